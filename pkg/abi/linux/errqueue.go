@@ -0,0 +1,74 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linux
+
+// Socket extended error origins, from uapi/linux/errqueue.h.
+const (
+	SO_EE_ORIGIN_NONE         = 0
+	SO_EE_ORIGIN_LOCAL        = 1
+	SO_EE_ORIGIN_ICMP         = 2
+	SO_EE_ORIGIN_ICMP6        = 3
+	SO_EE_ORIGIN_TXSTATUS     = 4
+	SO_EE_ORIGIN_ZEROCOPY     = 5
+	SO_EE_ORIGIN_TXTIME       = 6
+	SO_EE_ORIGIN_TIMESTAMPING = SO_EE_ORIGIN_TXSTATUS
+)
+
+// SO_EE_CODE_ZEROCOPY_COPIED is set in the code of SO_EE_ORIGIN_ZEROCOPY
+// notifications when the data of the send was copied, from
+// uapi/linux/errqueue.h.
+const SO_EE_CODE_ZEROCOPY_COPIED = 1
+
+// Codes of SO_EE_ORIGIN_TXTIME notifications, from uapi/linux/errqueue.h.
+const (
+	SO_EE_CODE_TXTIME_INVALID_PARAM = 1
+	SO_EE_CODE_TXTIME_MISSED        = 2
+)
+
+// SockExtendedErr is struct sock_extended_err, from uapi/linux/errqueue.h.
+type SockExtendedErr struct {
+	Errno  uint32
+	Origin uint8
+	Type   uint8
+	Code   uint8
+	Pad    uint8
+	Info   uint32
+	Data   uint32
+}
+
+// SizeOfSockExtendedErr is the size of a SockExtendedErr struct.
+const SizeOfSockExtendedErr = 16
+
+// SockErrCMsgIPv4 is the IP_RECVERR control message returned by
+// recvmsg(MSG_ERRQUEUE) on IPv4 sockets. It is equivalent to struct errhdr in
+// net/ipv4/ip_sockglue.c:ip_recv_error().
+type SockErrCMsgIPv4 struct {
+	SockExtendedErr
+	Offender SockAddrInet
+}
+
+// SizeOfSockErrCMsgIPv4 is the size of a SockErrCMsgIPv4 struct.
+const SizeOfSockErrCMsgIPv4 = SizeOfSockExtendedErr + 16
+
+// SockErrCMsgIPv6 is the IPV6_RECVERR control message returned by
+// recvmsg(MSG_ERRQUEUE) on IPv6 sockets. It is equivalent to struct errhdr in
+// net/ipv6/datagram.c:ipv6_recv_error().
+type SockErrCMsgIPv6 struct {
+	SockExtendedErr
+	Offender SockAddrInet6
+}
+
+// SizeOfSockErrCMsgIPv6 is the size of a SockErrCMsgIPv6 struct.
+const SizeOfSockErrCMsgIPv6 = SizeOfSockExtendedErr + 28
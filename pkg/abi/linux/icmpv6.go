@@ -0,0 +1,40 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linux
+
+// Socket options for SOL_ICMPV6, from uapi/linux/icmpv6.h.
+const (
+	ICMPV6_FILTER = 1
+)
+
+// ICMPv6 filter actions, from uapi/linux/icmpv6.h.
+const (
+	ICMPV6_FILTER_BLOCK       = 1
+	ICMPV6_FILTER_PASS        = 2
+	ICMPV6_FILTER_BLOCKOTHERS = 3
+	ICMPV6_FILTER_PASSONLY    = 4
+)
+
+// ICMP6Filter is the filter of the ICMPv6 messages delivered to a socket, as
+// per struct icmp6_filter in uapi/linux/icmpv6.h: the messages of type t are
+// blocked if bit t%32 of Data[t/32] is set.
+//
+// +marshal
+type ICMP6Filter struct {
+	Data [8]uint32
+}
+
+// SizeOfICMP6Filter is the binary size of an ICMP6Filter struct.
+const SizeOfICMP6Filter = 32
@@ -41,6 +41,7 @@ const (
 	IPPROTO_UDPLITE = 136
 	IPPROTO_MPLS    = 137
 	IPPROTO_RAW     = 255
+	IPPROTO_MPTCP   = 262
 )
 
 // Socket options from uapi/linux/in.h
@@ -159,3 +160,51 @@ const (
 	IPV6_RECVFRAGSIZE     = 77
 	IPV6_FREEBIND         = 78
 )
+
+// IPV6_MTU_DISCOVER values from uapi/linux/in6.h
+const (
+	IPV6_PMTUDISC_DONT      = 0
+	IPV6_PMTUDISC_WANT      = 1
+	IPV6_PMTUDISC_DO        = 2
+	IPV6_PMTUDISC_PROBE     = 3
+	IPV6_PMTUDISC_INTERFACE = 4
+	IPV6_PMTUDISC_OMIT      = 5
+)
+
+// IPv6 flow label manager actions, flags and share modes, from
+// uapi/linux/in6.h.
+const (
+	IPV6_FL_A_GET   = 0
+	IPV6_FL_A_PUT   = 1
+	IPV6_FL_A_RENEW = 2
+
+	IPV6_FL_F_CREATE  = 1
+	IPV6_FL_F_EXCL    = 2
+	IPV6_FL_F_REFLECT = 4
+	IPV6_FL_F_REMOTE  = 8
+
+	IPV6_FL_S_NONE    = 0
+	IPV6_FL_S_EXCL    = 1
+	IPV6_FL_S_PROCESS = 2
+	IPV6_FL_S_USER    = 3
+	IPV6_FL_S_ANY     = 255
+)
+
+// IPV6_FLOWINFO_FLOWLABEL is the mask of the flow label in the flow
+// information of an IPv6 socket address, in host byte order.
+const IPV6_FLOWINFO_FLOWLABEL = 0x000fffff
+
+// In6FlowLabelReq is struct in6_flowlabel_req, from uapi/linux/in6.h.
+type In6FlowLabelReq struct {
+	Dst     [16]byte
+	Label   uint32 // Network byte order.
+	Action  uint8
+	Share   uint8
+	Flags   uint16
+	Expires uint16
+	Linger  uint16
+	_       uint32
+}
+
+// SizeOfIn6FlowLabelReq is the size of an In6FlowLabelReq.
+const SizeOfIn6FlowLabelReq = 32
@@ -0,0 +1,91 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linux
+
+// Socket options for IPv4 multicast routing, from uapi/linux/mroute.h. They
+// are set on a raw IGMP socket at the SOL_IP level.
+const (
+	MRT_BASE          = 200
+	MRT_INIT          = MRT_BASE
+	MRT_DONE          = MRT_BASE + 1
+	MRT_ADD_VIF       = MRT_BASE + 2
+	MRT_DEL_VIF       = MRT_BASE + 3
+	MRT_ADD_MFC       = MRT_BASE + 4
+	MRT_DEL_MFC       = MRT_BASE + 5
+	MRT_VERSION       = MRT_BASE + 6
+	MRT_ASSERT        = MRT_BASE + 7
+	MRT_PIM           = MRT_BASE + 8
+	MRT_TABLE         = MRT_BASE + 9
+	MRT_ADD_MFC_PROXY = MRT_BASE + 10
+	MRT_DEL_MFC_PROXY = MRT_BASE + 11
+	MRT_FLUSH         = MRT_BASE + 12
+)
+
+// MRT_VERSION_NUMBER is the multicast routing API version reported by the
+// MRT_VERSION socket option.
+const MRT_VERSION_NUMBER = 0x0305
+
+// MAXVIFS is the maximum number of virtual interfaces.
+const MAXVIFS = 32
+
+// Flags for VifCtl.Flags.
+const (
+	VIFF_TUNNEL      = 0x1
+	VIFF_SRCRT       = 0x2
+	VIFF_REGISTER    = 0x4
+	VIFF_USE_IFINDEX = 0x8
+)
+
+// Multicast routing upcall message types, from uapi/linux/mroute.h.
+const (
+	IGMPMSG_NOCACHE  = 1
+	IGMPMSG_WRONGVIF = 2
+	IGMPMSG_WHOLEPKT = 3
+)
+
+// VifCtl is struct vifctl, from uapi/linux/mroute.h. It is used to add a
+// virtual interface with MRT_ADD_VIF.
+type VifCtl struct {
+	Vifi      uint16
+	Flags     uint8
+	Threshold uint8
+	RateLimit uint32
+	// LclAddr holds the local address of the interface, or its index in host
+	// byte order if VIFF_USE_IFINDEX is set in Flags.
+	LclAddr InetAddr
+	RmtAddr InetAddr
+}
+
+// SizeOfVifCtl is the size of a VifCtl.
+const SizeOfVifCtl = 16
+
+// MfcCtl is struct mfcctl, from uapi/linux/mroute.h. It is used to add and
+// remove multicast forwarding cache entries with MRT_ADD_MFC and MRT_DEL_MFC.
+type MfcCtl struct {
+	Origin   InetAddr
+	McastGrp InetAddr
+	Parent   uint16
+	// TTLs holds the TTL thresholds of the outgoing virtual interfaces. A
+	// virtual interface is not used if its threshold is 0 or 255.
+	TTLs    [MAXVIFS]uint8
+	_       uint16
+	PktCnt  uint32
+	ByteCnt uint32
+	WrongIf uint32
+	Expire  int32
+}
+
+// SizeOfMfcCtl is the size of a MfcCtl.
+const SizeOfMfcCtl = 60
@@ -0,0 +1,82 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linux
+
+// Socket options for IPv6 multicast routing, from uapi/linux/mroute6.h. They
+// are set on a raw ICMPv6 socket at the SOL_IPV6 level.
+const (
+	MRT6_BASE          = 200
+	MRT6_INIT          = MRT6_BASE
+	MRT6_DONE          = MRT6_BASE + 1
+	MRT6_ADD_MIF       = MRT6_BASE + 2
+	MRT6_DEL_MIF       = MRT6_BASE + 3
+	MRT6_ADD_MFC       = MRT6_BASE + 4
+	MRT6_DEL_MFC       = MRT6_BASE + 5
+	MRT6_VERSION       = MRT6_BASE + 6
+	MRT6_ASSERT        = MRT6_BASE + 7
+	MRT6_PIM           = MRT6_BASE + 8
+	MRT6_TABLE         = MRT6_BASE + 9
+	MRT6_ADD_MFC_PROXY = MRT6_BASE + 10
+	MRT6_DEL_MFC_PROXY = MRT6_BASE + 11
+	MRT6_FLUSH         = MRT6_BASE + 12
+)
+
+// MRT6_VERSION_NUMBER is the multicast routing API version reported by the
+// MRT6_VERSION socket option.
+const MRT6_VERSION_NUMBER = 0x0305
+
+// MAXMIFS is the maximum number of multicast interfaces.
+const MAXMIFS = 32
+
+// Flags for Mif6Ctl.Flags.
+const (
+	MIFF_REGISTER = 0x1
+)
+
+// Multicast routing upcall message types, from uapi/linux/mroute6.h.
+const (
+	MRT6MSG_NOCACHE  = 1
+	MRT6MSG_WRONGMIF = 2
+	MRT6MSG_WHOLEPKT = 3
+)
+
+// Mif6Ctl is struct mif6ctl, from uapi/linux/mroute6.h. It is used to add a
+// multicast interface with MRT6_ADD_MIF.
+type Mif6Ctl struct {
+	Mifi      uint16
+	Flags     uint8
+	Threshold uint8
+	Pifi      uint16
+	_         uint16
+	RateLimit uint32
+}
+
+// SizeOfMif6Ctl is the size of a Mif6Ctl.
+const SizeOfMif6Ctl = 12
+
+// Mf6cCtl is struct mf6cctl, from uapi/linux/mroute6.h. It is used to add and
+// remove multicast forwarding cache entries with MRT6_ADD_MFC and
+// MRT6_DEL_MFC.
+type Mf6cCtl struct {
+	Origin   SockAddrInet6
+	McastGrp SockAddrInet6
+	Parent   uint16
+	_        uint16
+	// IfSet is a bitmap of the outgoing multicast interfaces.
+	IfSet [8]uint32
+}
+
+// SizeOfMf6cCtl is the size of a Mf6cCtl.
+const SizeOfMf6cCtl = 92
@@ -699,3 +699,311 @@ const (
 	// packets do not have an associated socket.
 	XT_OWNER_SOCKET = 1 << 2
 )
+
+// XTConnlimitInfo holds data for limiting the number of connections of
+// hosts. It corresponds to struct xt_connlimit_info in
+// include/uapi/linux/netfilter/xt_connlimit.h.
+type XTConnlimitInfo struct {
+	// Mask masks the addresses connections are grouped by. Only the first
+	// 4 bytes are used for IPv4.
+	Mask [16]byte
+
+	// Limit is the number of connections above which packets match.
+	Limit uint32
+
+	// Flags holds the XT_CONNLIMIT_* flags below.
+	Flags uint32
+
+	// Data is used internally by the kernel.
+	Data uint64
+}
+
+// SizeOfXTConnlimitInfo is the size of an XTConnlimitInfo.
+const SizeOfXTConnlimitInfo = 32
+
+// Flags in XTConnlimitInfo.Flags. Corresponding constants are in
+// include/uapi/linux/netfilter/xt_connlimit.h.
+const (
+	// Invert the meaning of Limit.
+	XT_CONNLIMIT_INVERT = 1 << 0
+	// Group connections by destination rather than source address.
+	XT_CONNLIMIT_DADDR = 1 << 1
+)
+
+// XTHashlimitMtinfo1 holds data for limiting the rate of packets of flows.
+// It corresponds to struct xt_hashlimit_mtinfo1 in
+// include/uapi/linux/netfilter/xt_hashlimit.h.
+type XTHashlimitMtinfo1 struct {
+	// Name is the name of the hash table holding the state of flows.
+	Name [IFNAMSIZ]byte
+
+	// Mode holds the XT_HASHLIMIT_* flags below.
+	Mode uint32
+
+	// Avg is the average time between packets, in units of
+	// 1/XT_HASHLIMIT_SCALE seconds.
+	Avg uint32
+
+	// Burst is the number of packets flows may send at once.
+	Burst uint32
+
+	// Size is the number of buckets of the hash table.
+	Size uint32
+
+	// Max is the maximum number of flows of the hash table.
+	Max uint32
+
+	// GCInterval is the interval between garbage collections of the hash
+	// table, in milliseconds.
+	GCInterval uint32
+
+	// Expire is the time after which idle flows are forgotten, in
+	// milliseconds.
+	Expire uint32
+
+	// SrcMask is the length of the prefix of source addresses flows are
+	// identified by.
+	SrcMask uint8
+
+	// DstMask is the length of the prefix of destination addresses flows
+	// are identified by.
+	DstMask uint8
+
+	_ [2]byte
+
+	// HInfo is used internally by the kernel.
+	HInfo uint64
+}
+
+// SizeOfXTHashlimitMtinfo1 is the size of an XTHashlimitMtinfo1.
+const SizeOfXTHashlimitMtinfo1 = 56
+
+// Flags in XTHashlimitMtinfo1.Mode. Corresponding constants are in
+// include/uapi/linux/netfilter/xt_hashlimit.h.
+const (
+	// Identify flows by destination address.
+	XT_HASHLIMIT_HASH_DIP = 1 << 0
+	// Identify flows by destination port.
+	XT_HASHLIMIT_HASH_DPT = 1 << 1
+	// Identify flows by source address.
+	XT_HASHLIMIT_HASH_SIP = 1 << 2
+	// Identify flows by source port.
+	XT_HASHLIMIT_HASH_SPT = 1 << 3
+	// Match packets above the rate rather than within.
+	XT_HASHLIMIT_INVERT = 1 << 4
+)
+
+// XT_HASHLIMIT_SCALE is the number of units of XTHashlimitMtinfo1.Avg in a
+// second.
+const XT_HASHLIMIT_SCALE = 10000
+
+// XT_RECENT_NAME_LEN is the size of the name of recent lists.
+const XT_RECENT_NAME_LEN = 200
+
+// XTRecentMtinfoV1 holds data for matching the addresses of recent lists. It
+// corresponds to struct xt_recent_mtinfo_v1 in
+// include/uapi/linux/netfilter/xt_recent.h.
+type XTRecentMtinfoV1 struct {
+	// Seconds restricts the check to the times addresses were seen within
+	// as many seconds, if not zero.
+	Seconds uint32
+
+	// HitCount is the number of times addresses must have been seen to
+	// match, if not zero.
+	HitCount uint32
+
+	// CheckSet holds the XT_RECENT_* flags below.
+	CheckSet uint8
+
+	// Invert flips the meaning of the match.
+	Invert uint8
+
+	// Name is the name of the list.
+	Name [XT_RECENT_NAME_LEN]byte
+
+	// Side is XT_RECENT_SOURCE or XT_RECENT_DEST.
+	Side uint8
+
+	_ uint8
+
+	// Mask masks the addresses of the list. Only the first 4 bytes are
+	// used for IPv4.
+	Mask [16]byte
+}
+
+// SizeOfXTRecentMtinfoV1 is the size of an XTRecentMtinfoV1.
+const SizeOfXTRecentMtinfoV1 = 228
+
+// Flags in XTRecentMtinfoV1.CheckSet. Corresponding constants are in
+// include/uapi/linux/netfilter/xt_recent.h.
+const (
+	// Match addresses which are in the list.
+	XT_RECENT_CHECK = 1 << 0
+	// Add addresses to the list.
+	XT_RECENT_SET = 1 << 1
+	// Match addresses which are in the list, and update them.
+	XT_RECENT_UPDATE = 1 << 2
+	// Remove addresses from the list.
+	XT_RECENT_REMOVE = 1 << 3
+	// Require the TTL of packets to be the one addresses were last seen
+	// with.
+	XT_RECENT_TTL = 1 << 4
+	// Remove addresses which weren't seen within Seconds.
+	XT_RECENT_REAP = 1 << 5
+
+	XT_RECENT_MODIFIERS   = XT_RECENT_TTL | XT_RECENT_REAP
+	XT_RECENT_VALID_FLAGS = XT_RECENT_CHECK | XT_RECENT_SET | XT_RECENT_UPDATE | XT_RECENT_REMOVE | XT_RECENT_TTL | XT_RECENT_REAP
+)
+
+// Values of XTRecentMtinfoV1.Side. Corresponding constants are in
+// include/uapi/linux/netfilter/xt_recent.h.
+const (
+	XT_RECENT_SOURCE = 0
+	XT_RECENT_DEST   = 1
+)
+
+// XTLogInfo holds data for the LOG target. It corresponds to struct
+// xt_log_info in include/uapi/linux/netfilter/xt_LOG.h.
+type XTLogInfo struct {
+	// Level is the syslog level packets are logged at.
+	Level uint8
+
+	// LogFlags holds the XT_LOG_* flags below.
+	LogFlags uint8
+
+	// Prefix is prepended to the logs.
+	Prefix [30]byte
+}
+
+// SizeOfXTLogInfo is the size of an XTLogInfo.
+const SizeOfXTLogInfo = 32
+
+// Flags in XTLogInfo.LogFlags. Corresponding constants are in
+// include/uapi/linux/netfilter/xt_LOG.h.
+const (
+	// Log the sequence numbers of TCP segments.
+	XT_LOG_TCPSEQ = 0x01
+	// Log the options of TCP segments.
+	XT_LOG_TCPOPT = 0x02
+	// Log the options of IP packets.
+	XT_LOG_IPOPT = 0x04
+	// Log the owner of locally generated packets.
+	XT_LOG_UID = 0x08
+	// Unsupported, don't use.
+	XT_LOG_NFLOG = 0x10
+	// Decode the link headers of packets.
+	XT_LOG_MACDECODE = 0x20
+
+	XT_LOG_MASK = 0x2f
+)
+
+// XTNFLogInfo holds data for the NFLOG target. It corresponds to struct
+// xt_nflog_info in include/uapi/linux/netfilter/xt_NFLOG.h.
+type XTNFLogInfo struct {
+	// Len is the number of bytes of packets to log, if XT_NFLOG_F_COPY_LEN
+	// is set.
+	Len uint32
+
+	// Group is the nfnetlink_log group packets are logged to.
+	Group uint16
+
+	// Threshold is the number of packets to queue before sending them.
+	Threshold uint16
+
+	// Flags holds the XT_NFLOG_F_* flags below.
+	Flags uint16
+
+	_ uint16
+
+	// Prefix is sent along with packets.
+	Prefix [64]byte
+}
+
+// SizeOfXTNFLogInfo is the size of an XTNFLogInfo.
+const SizeOfXTNFLogInfo = 76
+
+// Flags in XTNFLogInfo.Flags. Corresponding constants are in
+// include/uapi/linux/netfilter/xt_NFLOG.h.
+const (
+	// Truncate packets to Len bytes.
+	XT_NFLOG_F_COPY_LEN = 0x1
+
+	XT_NFLOG_MASK = 0x1
+)
+
+// XTMarkTginfo2 holds data for the MARK target. It corresponds to struct
+// xt_mark_tginfo2 in include/uapi/linux/netfilter/xt_mark.h.
+type XTMarkTginfo2 struct {
+	// Mark is XORed with the mark of packets, once the bits of Mask are
+	// cleared.
+	Mark uint32
+
+	// Mask holds the bits of the mark of packets to clear.
+	Mask uint32
+}
+
+// SizeOfXTMarkTginfo2 is the size of an XTMarkTginfo2.
+const SizeOfXTMarkTginfo2 = 8
+
+// XTMarkMtinfo1 holds data for matching the mark of packets. It corresponds to
+// struct xt_mark_mtinfo1 in include/uapi/linux/netfilter/xt_mark.h.
+type XTMarkMtinfo1 struct {
+	// Mark is the mark of matching packets, once masked with Mask.
+	Mark uint32
+	Mask uint32
+
+	// Invert inverts the match if it is not 0.
+	Invert uint8
+
+	_ [3]byte
+}
+
+// SizeOfXTMarkMtinfo1 is the size of an XTMarkMtinfo1.
+const SizeOfXTMarkMtinfo1 = 12
+
+// XTConnmarkTginfo1 holds data for the CONNMARK target. It corresponds to
+// struct xt_connmark_tginfo1 in include/uapi/linux/netfilter/xt_connmark.h.
+type XTConnmarkTginfo1 struct {
+	// CTMark, CTMask and NFMask are the mark and the masks of the
+	// operation, as described by the XT_CONNMARK_* modes below.
+	CTMark uint32
+	CTMask uint32
+	NFMask uint32
+
+	// Mode is one of the XT_CONNMARK_* modes below.
+	Mode uint8
+
+	_ [3]byte
+}
+
+// SizeOfXTConnmarkTginfo1 is the size of an XTConnmarkTginfo1.
+const SizeOfXTConnmarkTginfo1 = 16
+
+// Modes of XTConnmarkTginfo1.Mode. Corresponding constants are in
+// include/uapi/linux/netfilter/xt_connmark.h.
+const (
+	// Set the mark of the connection to (ctmark & ~CTMask) ^ CTMark.
+	XT_CONNMARK_SET = 0
+	// Set the mark of the connection to
+	// (ctmark & ~CTMask) ^ (nfmark & NFMask).
+	XT_CONNMARK_SAVE = 1
+	// Set the mark of the packet to (nfmark & ~NFMask) ^ (ctmark & CTMask).
+	XT_CONNMARK_RESTORE = 2
+)
+
+// XTConnmarkMtinfo1 holds data for matching the mark of the connections of
+// packets. It corresponds to struct xt_connmark_mtinfo1 in
+// include/uapi/linux/netfilter/xt_connmark.h.
+type XTConnmarkMtinfo1 struct {
+	// Mark is the mark of matching connections, once masked with Mask.
+	Mark uint32
+	Mask uint32
+
+	// Invert inverts the match if it is not 0.
+	Invert uint8
+
+	_ [3]byte
+}
+
+// SizeOfXTConnmarkMtinfo1 is the size of an XTConnmarkMtinfo1.
+const SizeOfXTConnmarkMtinfo1 = 12
@@ -31,10 +31,19 @@ func TestSizes(t *testing.T) {
 		{IPTIP{}, SizeOfIPTIP},
 		{IPTOwnerInfo{}, SizeOfIPTOwnerInfo},
 		{IPTReplace{}, SizeOfIPTReplace},
+		{XTConnlimitInfo{}, SizeOfXTConnlimitInfo},
+		{XTConnmarkMtinfo1{}, SizeOfXTConnmarkMtinfo1},
+		{XTConnmarkTginfo1{}, SizeOfXTConnmarkTginfo1},
 		{XTCounters{}, SizeOfXTCounters},
 		{XTEntryMatch{}, SizeOfXTEntryMatch},
 		{XTEntryTarget{}, SizeOfXTEntryTarget},
 		{XTErrorTarget{}, SizeOfXTErrorTarget},
+		{XTHashlimitMtinfo1{}, SizeOfXTHashlimitMtinfo1},
+		{XTLogInfo{}, SizeOfXTLogInfo},
+		{XTMarkMtinfo1{}, SizeOfXTMarkMtinfo1},
+		{XTMarkTginfo2{}, SizeOfXTMarkTginfo2},
+		{XTNFLogInfo{}, SizeOfXTNFLogInfo},
+		{XTRecentMtinfoV1{}, SizeOfXTRecentMtinfoV1},
 		{XTStandardTarget{}, SizeOfXTStandardTarget},
 		{IP6TReplace{}, SizeOfIP6TReplace},
 		{IP6TEntry{}, SizeOfIP6TEntry},
@@ -0,0 +1,158 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linux
+
+// NetfilterGenMessage is struct nfgenmsg, from uapi/linux/netfilter/nfnetlink.h.
+// It follows the header of the messages of NETLINK_NETFILTER sockets.
+type NetfilterGenMessage struct {
+	Family  uint8
+	Version uint8
+
+	// ResID is in network byte order.
+	ResID uint16
+}
+
+// NetfilterGenMessageSize is the size of NetfilterGenMessage.
+const NetfilterGenMessageSize = 4
+
+// NFNETLINK_V0 is the version of NetfilterGenMessage, from
+// uapi/linux/netfilter/nfnetlink.h.
+const NFNETLINK_V0 = 0
+
+// Subsystems of NETLINK_NETFILTER sockets, from
+// uapi/linux/netfilter/nfnetlink.h. The subsystem of a message is the high
+// byte of its type.
+const (
+	NFNL_SUBSYS_NONE              = 0
+	NFNL_SUBSYS_CTNETLINK         = 1
+	NFNL_SUBSYS_CTNETLINK_EXP     = 2
+	NFNL_SUBSYS_QUEUE             = 3
+	NFNL_SUBSYS_ULOG              = 4
+	NFNL_SUBSYS_OSF               = 5
+	NFNL_SUBSYS_IPSET             = 6
+	NFNL_SUBSYS_ACCT              = 7
+	NFNL_SUBSYS_CTNETLINK_TIMEOUT = 8
+	NFNL_SUBSYS_CTHELPER          = 9
+	NFNL_SUBSYS_NFTABLES          = 10
+	NFNL_SUBSYS_NFT_COMPAT        = 11
+)
+
+// Message types of the NFNL_SUBSYS_ULOG subsystem, from
+// uapi/linux/netfilter/nfnetlink_log.h.
+const (
+	NFULNL_MSG_PACKET = 0
+	NFULNL_MSG_CONFIG = 1
+)
+
+// NfulnlMsgPacketHdr is struct nfulnl_msg_packet_hdr, from
+// uapi/linux/netfilter/nfnetlink_log.h.
+type NfulnlMsgPacketHdr struct {
+	// HWProtocol is the ethertype of the packet, in network byte order.
+	HWProtocol uint16
+	Hook       uint8
+	_          uint8
+}
+
+// NfulnlMsgPacketHdrSize is the size of NfulnlMsgPacketHdr.
+const NfulnlMsgPacketHdrSize = 4
+
+// NfulnlMsgPacketTimestamp is struct nfulnl_msg_packet_timestamp, from
+// uapi/linux/netfilter/nfnetlink_log.h. Its fields are in network byte order.
+type NfulnlMsgPacketTimestamp struct {
+	Sec  uint64
+	Usec uint64
+}
+
+// NfulnlMsgPacketTimestampSize is the size of NfulnlMsgPacketTimestamp.
+const NfulnlMsgPacketTimestampSize = 16
+
+// Attributes of NFULNL_MSG_PACKET messages, from
+// uapi/linux/netfilter/nfnetlink_log.h.
+const (
+	NFULA_UNSPEC             = 0
+	NFULA_PACKET_HDR         = 1
+	NFULA_MARK               = 2
+	NFULA_TIMESTAMP          = 3
+	NFULA_IFINDEX_INDEV      = 4
+	NFULA_IFINDEX_OUTDEV     = 5
+	NFULA_IFINDEX_PHYSINDEV  = 6
+	NFULA_IFINDEX_PHYSOUTDEV = 7
+	NFULA_HWADDR             = 8
+	NFULA_PAYLOAD            = 9
+	NFULA_PREFIX             = 10
+	NFULA_UID                = 11
+	NFULA_SEQ                = 12
+	NFULA_SEQ_GLOBAL         = 13
+	NFULA_GID                = 14
+	NFULA_HWTYPE             = 15
+	NFULA_HWHEADER           = 16
+	NFULA_HWLEN              = 17
+	NFULA_CT                 = 18
+	NFULA_CT_INFO            = 19
+)
+
+// Commands of the NFULA_CFG_CMD attribute, from
+// uapi/linux/netfilter/nfnetlink_log.h.
+const (
+	NFULNL_CFG_CMD_NONE      = 0
+	NFULNL_CFG_CMD_BIND      = 1
+	NFULNL_CFG_CMD_UNBIND    = 2
+	NFULNL_CFG_CMD_PF_BIND   = 3
+	NFULNL_CFG_CMD_PF_UNBIND = 4
+)
+
+// NfulnlMsgConfigMode is struct nfulnl_msg_config_mode, from
+// uapi/linux/netfilter/nfnetlink_log.h.
+type NfulnlMsgConfigMode struct {
+	// CopyRange is in network byte order.
+	CopyRange uint32
+	CopyMode  uint8
+	_         uint8
+}
+
+// NfulnlMsgConfigModeSize is the size of NfulnlMsgConfigMode.
+const NfulnlMsgConfigModeSize = 6
+
+// Attributes of NFULNL_MSG_CONFIG messages, from
+// uapi/linux/netfilter/nfnetlink_log.h.
+const (
+	NFULA_CFG_UNSPEC   = 0
+	NFULA_CFG_CMD      = 1
+	NFULA_CFG_MODE     = 2
+	NFULA_CFG_NLBUFSIZ = 3
+	NFULA_CFG_TIMEOUT  = 4
+	NFULA_CFG_QTHRESH  = 5
+	NFULA_CFG_FLAGS    = 6
+)
+
+// Copy modes of NfulnlMsgConfigMode, from
+// uapi/linux/netfilter/nfnetlink_log.h.
+const (
+	NFULNL_COPY_NONE   = 0x00
+	NFULNL_COPY_META   = 0x01
+	NFULNL_COPY_PACKET = 0x02
+)
+
+// Flags of the NFULA_CFG_FLAGS attribute, from
+// uapi/linux/netfilter/nfnetlink_log.h.
+const (
+	NFULNL_CFG_F_SEQ        = 0x0001
+	NFULNL_CFG_F_SEQ_GLOBAL = 0x0002
+	NFULNL_CFG_F_CONNTRACK  = 0x0004
+)
+
+// NFULNL_COPY_RANGE_MAX is the maximum number of bytes of packets sent to
+// userspace, from net/netfilter/nfnetlink_log.c.
+const NFULNL_COPY_RANGE_MAX = 0xffff - NetlinkAttrHeaderSize
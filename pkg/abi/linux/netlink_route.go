@@ -163,6 +163,68 @@ const (
 	IFLA_GSO_MAX_SIZE    = 41
 )
 
+// Link info attributes, the nested attributes of IFLA_LINKINFO, from
+// uapi/linux/if_link.h.
+const (
+	IFLA_INFO_UNSPEC     = 0
+	IFLA_INFO_KIND       = 1
+	IFLA_INFO_DATA       = 2
+	IFLA_INFO_XSTATS     = 3
+	IFLA_INFO_SLAVE_KIND = 4
+	IFLA_INFO_SLAVE_DATA = 5
+)
+
+// VLAN link info attributes, the nested attributes of IFLA_INFO_DATA for
+// "vlan" links, from uapi/linux/if_link.h.
+const (
+	IFLA_VLAN_UNSPEC      = 0
+	IFLA_VLAN_ID          = 1
+	IFLA_VLAN_FLAGS       = 2
+	IFLA_VLAN_EGRESS_QOS  = 3
+	IFLA_VLAN_INGRESS_QOS = 4
+	IFLA_VLAN_PROTOCOL    = 5
+)
+
+// GRE link info attributes, the nested attributes of IFLA_INFO_DATA for "gre"
+// and "ip6gre" links, from uapi/linux/if_tunnel.h.
+const (
+	IFLA_GRE_UNSPEC           = 0
+	IFLA_GRE_LINK             = 1
+	IFLA_GRE_IFLAGS           = 2
+	IFLA_GRE_OFLAGS           = 3
+	IFLA_GRE_IKEY             = 4
+	IFLA_GRE_OKEY             = 5
+	IFLA_GRE_LOCAL            = 6
+	IFLA_GRE_REMOTE           = 7
+	IFLA_GRE_TTL              = 8
+	IFLA_GRE_TOS              = 9
+	IFLA_GRE_PMTUDISC         = 10
+	IFLA_GRE_ENCAP_LIMIT      = 11
+	IFLA_GRE_FLOWINFO         = 12
+	IFLA_GRE_FLAGS            = 13
+	IFLA_GRE_ENCAP_TYPE       = 14
+	IFLA_GRE_ENCAP_FLAGS      = 15
+	IFLA_GRE_ENCAP_SPORT      = 16
+	IFLA_GRE_ENCAP_DPORT      = 17
+	IFLA_GRE_COLLECT_METADATA = 18
+	IFLA_GRE_IGNORE_DF        = 19
+	IFLA_GRE_FWMARK           = 20
+)
+
+// GRE flags of IFLA_GRE_IFLAGS and IFLA_GRE_OFLAGS, in network byte order,
+// from uapi/linux/if_tunnel.h.
+const (
+	GRE_CSUM = 0x8000
+	GRE_KEY  = 0x2000
+	GRE_SEQ  = 0x1000
+)
+
+// IPv6 tunnel flags of IFLA_GRE_FLAGS, from uapi/linux/ip6_tunnel.h.
+const (
+	IP6_TNL_F_IGN_ENCAP_LIMIT = 0x1
+	IP6_TNL_F_USE_ORIG_TCLASS = 0x2
+)
+
 // InterfaceAddrMessage is struct ifaddrmsg, from uapi/linux/if_addr.h.
 type InterfaceAddrMessage struct {
 	Family    uint8
@@ -189,7 +251,11 @@ const (
 const (
 	ARPHRD_NONE     = 65534
 	ARPHRD_ETHER    = 1
+	ARPHRD_TUNNEL   = 768
 	ARPHRD_LOOPBACK = 772
+	ARPHRD_SIT      = 776
+	ARPHRD_IPGRE    = 778
+	ARPHRD_IP6GRE   = 823
 )
 
 // RouteMessage is struct rtmsg, from uapi/linux/rtnetlink.h.
@@ -346,3 +412,118 @@ type RtAttr struct {
 
 // SizeOfRtAttr is the size of RtAttr.
 const SizeOfRtAttr = 4
+
+// NeighborMessage is struct ndmsg, from uapi/linux/neighbour.h.
+type NeighborMessage struct {
+	Family uint8
+	_      uint8
+	_      uint16
+	Index  int32
+	State  uint16
+	Flags  uint8
+	Type   uint8
+}
+
+// SizeOfNeighborMessage is the size of NeighborMessage.
+const SizeOfNeighborMessage = 12
+
+// Neighbor attributes, from uapi/linux/neighbour.h.
+const (
+	NDA_UNSPEC       = 0
+	NDA_DST          = 1
+	NDA_LLADDR       = 2
+	NDA_CACHEINFO    = 3
+	NDA_PROBES       = 4
+	NDA_VLAN         = 5
+	NDA_PORT         = 6
+	NDA_VNI          = 7
+	NDA_IFINDEX      = 8
+	NDA_MASTER       = 9
+	NDA_LINK_NETNSID = 10
+	NDA_SRC_VNI      = 11
+	NDA_PROTOCOL     = 12
+)
+
+// NeighborCacheInfo is struct nda_cacheinfo, from uapi/linux/neighbour.h.
+//
+// The times are in clock ticks, relative to the time of the message.
+type NeighborCacheInfo struct {
+	Confirmed uint32
+	Used      uint32
+	Updated   uint32
+	RefCount  uint32
+}
+
+// Neighbor states, from uapi/linux/neighbour.h.
+const (
+	NUD_NONE       = 0x00
+	NUD_INCOMPLETE = 0x01
+	NUD_REACHABLE  = 0x02
+	NUD_STALE      = 0x04
+	NUD_DELAY      = 0x08
+	NUD_PROBE      = 0x10
+	NUD_FAILED     = 0x20
+	NUD_NOARP      = 0x40
+	NUD_PERMANENT  = 0x80
+)
+
+// Neighbor flags, from uapi/linux/neighbour.h.
+const (
+	NTF_USE    = 0x01
+	NTF_SELF   = 0x02
+	NTF_MASTER = 0x04
+	NTF_PROXY  = 0x08
+	NTF_ROUTER = 0x80
+)
+
+// FibRuleHeader is struct fib_rule_hdr, from uapi/linux/fib_rules.h.
+type FibRuleHeader struct {
+	Family uint8
+	DstLen uint8
+	SrcLen uint8
+	TOS    uint8
+	Table  uint8
+	_      uint8
+	_      uint8
+	Action uint8
+	Flags  uint32
+}
+
+// SizeOfFibRuleHeader is the size of FibRuleHeader.
+const SizeOfFibRuleHeader = 12
+
+// Rule attributes, from uapi/linux/fib_rules.h.
+const (
+	FRA_UNSPEC             = 0
+	FRA_DST                = 1
+	FRA_SRC                = 2
+	FRA_IIFNAME            = 3
+	FRA_GOTO               = 4
+	FRA_PRIORITY           = 6
+	FRA_FWMARK             = 10
+	FRA_FLOW               = 11
+	FRA_TUN_ID             = 12
+	FRA_SUPPRESS_IFGROUP   = 13
+	FRA_SUPPRESS_PREFIXLEN = 14
+	FRA_TABLE              = 15
+	FRA_FWMASK             = 16
+	FRA_OIFNAME            = 17
+	FRA_PAD                = 18
+	FRA_L3MDEV             = 19
+	FRA_UID_RANGE          = 20
+	FRA_PROTOCOL           = 21
+	FRA_IP_PROTO           = 22
+	FRA_SPORT_RANGE        = 23
+	FRA_DPORT_RANGE        = 24
+)
+
+// Rule actions, from uapi/linux/fib_rules.h.
+const (
+	FR_ACT_UNSPEC      = 0
+	FR_ACT_TO_TBL      = 1
+	FR_ACT_GOTO        = 2
+	FR_ACT_NOP         = 3
+	FR_ACT_BLACKHOLE   = 6
+	FR_ACT_UNREACHABLE = 7
+	FR_ACT_PROHIBIT    = 8
+)
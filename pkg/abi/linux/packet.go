@@ -0,0 +1,172 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linux
+
+// Socket options for SOL_PACKET, from uapi/linux/if_packet.h.
+const (
+	PACKET_RX_RING    = 5
+	PACKET_STATISTICS = 6
+	PACKET_VERSION    = 10
+	PACKET_HDRLEN     = 11
+	PACKET_RESERVE    = 12
+	PACKET_TX_RING    = 13
+	PACKET_LOSS       = 14
+	PACKET_FANOUT     = 18
+)
+
+// Fanout types and flags of PACKET_FANOUT, from uapi/linux/if_packet.h. The
+// option value holds the group ID in its low 16 bits, and the type and flags
+// in its high 16 bits.
+const (
+	PACKET_FANOUT_HASH     = 0
+	PACKET_FANOUT_LB       = 1
+	PACKET_FANOUT_CPU      = 2
+	PACKET_FANOUT_ROLLOVER = 3
+	PACKET_FANOUT_RND      = 4
+	PACKET_FANOUT_QM       = 5
+	PACKET_FANOUT_CBPF     = 6
+	PACKET_FANOUT_EBPF     = 7
+
+	PACKET_FANOUT_FLAG_ROLLOVER = 0x1000
+	PACKET_FANOUT_FLAG_UNIQUEID = 0x2000
+	PACKET_FANOUT_FLAG_DEFRAG   = 0x8000
+)
+
+// Versions of the memory-mapped rings of packet sockets, from
+// uapi/linux/if_packet.h (enum tpacket_versions).
+const (
+	TPACKET_V1 = 0
+	TPACKET_V2 = 1
+	TPACKET_V3 = 2
+)
+
+// Status of the frames of receive rings, from uapi/linux/if_packet.h.
+const (
+	TP_STATUS_KERNEL       = 0
+	TP_STATUS_USER         = 1 << 0
+	TP_STATUS_COPY         = 1 << 1
+	TP_STATUS_LOSING       = 1 << 2
+	TP_STATUS_CSUMNOTREADY = 1 << 3
+	TP_STATUS_VLAN_VALID   = 1 << 4
+	TP_STATUS_BLK_TMO      = 1 << 5
+	TP_STATUS_TS_SOFTWARE  = 1 << 29
+)
+
+// Status of the frames of transmit rings, from uapi/linux/if_packet.h.
+const (
+	TP_STATUS_AVAILABLE    = 0
+	TP_STATUS_SEND_REQUEST = 1 << 0
+	TP_STATUS_SENDING      = 1 << 1
+	TP_STATUS_WRONG_FORMAT = 1 << 2
+)
+
+// TPACKET_ALIGNMENT is the alignment of the frames of memory-mapped rings,
+// from uapi/linux/if_packet.h.
+const TPACKET_ALIGNMENT = 16
+
+// Header lengths returned by PACKET_HDRLEN, from uapi/linux/if_packet.h: the
+// size of the frame headers, aligned to TPACKET_ALIGNMENT, plus the size of
+// struct sockaddr_ll.
+const (
+	TPACKET1_HDRLEN = 52
+	TPACKET2_HDRLEN = 52
+	TPACKET3_HDRLEN = 68
+)
+
+// TpacketReq3 is the geometry of a memory-mapped ring, as per struct
+// tpacket_req3 in uapi/linux/if_packet.h. The first four fields form the
+// struct tpacket_req of TPACKET_V1 and TPACKET_V2 rings.
+//
+// +marshal
+// +stateify savable
+type TpacketReq3 struct {
+	BlockSize      uint32
+	BlockNr        uint32
+	FrameSize      uint32
+	FrameNr        uint32
+	RetireBlkTov   uint32
+	SizeofPriv     uint32
+	FeatureReqWord uint32
+}
+
+// SizeOfTpacketReq is the binary size of a struct tpacket_req.
+const SizeOfTpacketReq = 16
+
+// SizeOfTpacketReq3 is the binary size of a TpacketReq3 struct.
+const SizeOfTpacketReq3 = 28
+
+// TpacketBlockDesc is the header of a block of a TPACKET_V3 receive ring, as
+// per struct tpacket_block_desc in uapi/linux/if_packet.h, with the
+// struct tpacket_hdr_v1 header inlined.
+//
+// +marshal
+type TpacketBlockDesc struct {
+	Version          uint32
+	OffsetToPriv     uint32
+	BlockStatus      uint32
+	NumPkts          uint32
+	OffsetToFirstPkt uint32
+	BlkLen           uint32
+	SeqNum           uint64
+	TsFirstPktSec    uint32
+	TsFirstPktNsec   uint32
+	TsLastPktSec     uint32
+	TsLastPktNsec    uint32
+}
+
+// SizeOfTpacketBlockDesc is the binary size of a TpacketBlockDesc struct.
+const SizeOfTpacketBlockDesc = 48
+
+// Tpacket3Hdr is the header of a packet of a TPACKET_V3 ring, as per struct
+// tpacket3_hdr in uapi/linux/if_packet.h.
+//
+// +marshal
+type Tpacket3Hdr struct {
+	NextOffset uint32
+	Sec        uint32
+	Nsec       uint32
+	Snaplen    uint32
+	Len        uint32
+	Status     uint32
+	Mac        uint16
+	Net        uint16
+	RxHash     uint32
+	VlanTCI    uint32
+	VlanTPID   uint16
+	_          uint16
+	_          [8]uint8
+}
+
+// SizeOfTpacket3Hdr is the binary size of a Tpacket3Hdr struct.
+const SizeOfTpacket3Hdr = 48
+
+// TpacketStatsV3 is struct tpacket_stats_v3 from uapi/linux/if_packet.h, as
+// returned by PACKET_STATISTICS for TPACKET_V3 rings. The first two fields
+// form the struct tpacket_stats of the other versions.
+//
+// +marshal
+type TpacketStatsV3 struct {
+	Packets    uint32
+	Drops      uint32
+	FreezeQCnt uint32
+}
+
+// TpacketStats is struct tpacket_stats from uapi/linux/if_packet.h.
+//
+// +marshal
+type TpacketStats struct {
+	Packets uint32
+	Drops   uint32
+}
@@ -0,0 +1,211 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linux
+
+// Socket options from uapi/linux/sctp.h.
+const (
+	SCTP_RTOINFO                = 0
+	SCTP_ASSOCINFO              = 1
+	SCTP_INITMSG                = 2
+	SCTP_NODELAY                = 3
+	SCTP_AUTOCLOSE              = 4
+	SCTP_SET_PEER_PRIMARY_ADDR  = 5
+	SCTP_PRIMARY_ADDR           = 6
+	SCTP_ADAPTATION_LAYER       = 7
+	SCTP_DISABLE_FRAGMENTS      = 8
+	SCTP_PEER_ADDR_PARAMS       = 9
+	SCTP_DEFAULT_SEND_PARAM     = 10
+	SCTP_EVENTS                 = 11
+	SCTP_I_WANT_MAPPED_V4_ADDR  = 12
+	SCTP_MAXSEG                 = 13
+	SCTP_STATUS                 = 14
+	SCTP_GET_PEER_ADDR_INFO     = 15
+	SCTP_DELAYED_ACK_TIME       = 16
+	SCTP_CONTEXT                = 17
+	SCTP_FRAGMENT_INTERLEAVE    = 18
+	SCTP_PARTIAL_DELIVERY_POINT = 19
+	SCTP_MAX_BURST              = 20
+	SCTP_AUTH_CHUNK             = 21
+	SCTP_HMAC_IDENT             = 22
+	SCTP_AUTH_KEY               = 23
+	SCTP_AUTH_ACTIVE_KEY        = 24
+	SCTP_AUTH_DELETE_KEY        = 25
+	SCTP_PEER_AUTH_CHUNKS       = 26
+	SCTP_LOCAL_AUTH_CHUNKS      = 27
+	SCTP_GET_ASSOC_NUMBER       = 28
+	SCTP_GET_ASSOC_ID_LIST      = 29
+	SCTP_AUTO_ASCONF            = 30
+	SCTP_PEER_ADDR_THLDS        = 31
+	SCTP_RECVRCVINFO            = 32
+	SCTP_RECVNXTINFO            = 33
+	SCTP_DEFAULT_SNDINFO        = 34
+)
+
+// Control message types from uapi/linux/sctp.h.
+const (
+	SCTP_INIT    = 0
+	SCTP_SNDRCV  = 1
+	SCTP_SNDINFO = 2
+	SCTP_RCVINFO = 3
+	SCTP_NXTINFO = 4
+)
+
+// Flags of SCTPSndInfo, SCTPRcvInfo and SCTPSndRcvInfo, from
+// uapi/linux/sctp.h.
+const (
+	SCTP_UNORDERED        = 1 << 0
+	SCTP_ADDR_OVER        = 1 << 1
+	SCTP_ABORT            = 1 << 2
+	SCTP_SACK_IMMEDIATELY = 1 << 3
+	SCTP_SENDALL          = 1 << 6
+	SCTP_EOF              = MSG_FIN
+)
+
+// States of SCTPStatus, enum sctp_sstat_state from uapi/linux/sctp.h.
+const (
+	SCTP_EMPTY = iota
+	SCTP_CLOSED
+	SCTP_COOKIE_WAIT
+	SCTP_COOKIE_ECHOED
+	SCTP_ESTABLISHED
+	SCTP_SHUTDOWN_PENDING
+	SCTP_SHUTDOWN_SENT
+	SCTP_SHUTDOWN_RECEIVED
+	SCTP_SHUTDOWN_ACK_SENT
+)
+
+// States of SCTPPaddrInfo, enum sctp_spinfo_state from uapi/linux/sctp.h.
+const (
+	SCTP_INACTIVE    = 0
+	SCTP_PF          = 1
+	SCTP_ACTIVE      = 2
+	SCTP_UNCONFIRMED = 3
+	SCTP_UNKNOWN     = 0xffff
+)
+
+// SCTPRTOInfo is struct sctp_rtoinfo, the SCTP_RTOINFO option value, from
+// uapi/linux/sctp.h. Timeouts are in milliseconds.
+//
+// +marshal
+type SCTPRTOInfo struct {
+	AssocID int32
+	Initial uint32
+	Max     uint32
+	Min     uint32
+}
+
+// SizeOfSCTPRTOInfo is the binary size of an SCTPRTOInfo struct.
+const SizeOfSCTPRTOInfo = 16
+
+// SCTPInitMsg is struct sctp_initmsg, the SCTP_INITMSG option value, from
+// uapi/linux/sctp.h. MaxInitTimeout is in milliseconds.
+//
+// +marshal
+type SCTPInitMsg struct {
+	NumOutStreams  uint16
+	MaxInStreams   uint16
+	MaxAttempts    uint16
+	MaxInitTimeout uint16
+}
+
+// SizeOfSCTPInitMsg is the binary size of an SCTPInitMsg struct.
+const SizeOfSCTPInitMsg = 8
+
+// SCTPSndInfo is struct sctp_sndinfo, the SCTP_SNDINFO control message and
+// SCTP_DEFAULT_SNDINFO option value, from uapi/linux/sctp.h.
+//
+// +marshal
+type SCTPSndInfo struct {
+	SID     uint16
+	Flags   uint16
+	PPID    uint32
+	Context uint32
+	AssocID int32
+}
+
+// SizeOfSCTPSndInfo is the binary size of an SCTPSndInfo struct.
+const SizeOfSCTPSndInfo = 16
+
+// SCTPRcvInfo is struct sctp_rcvinfo, the SCTP_RCVINFO control message, from
+// uapi/linux/sctp.h.
+//
+// +marshal
+type SCTPRcvInfo struct {
+	SID     uint16
+	SSN     uint16
+	Flags   uint16
+	_       uint16
+	PPID    uint32
+	TSN     uint32
+	CumTSN  uint32
+	Context uint32
+	AssocID int32
+}
+
+// SizeOfSCTPRcvInfo is the binary size of an SCTPRcvInfo struct.
+const SizeOfSCTPRcvInfo = 28
+
+// SCTPSndRcvInfo is struct sctp_sndrcvinfo, the SCTP_SNDRCV control message,
+// from uapi/linux/sctp.h.
+//
+// +marshal
+type SCTPSndRcvInfo struct {
+	Stream     uint16
+	SSN        uint16
+	Flags      uint16
+	_          uint16
+	PPID       uint32
+	Context    uint32
+	TimeToLive uint32
+	TSN        uint32
+	CumTSN     uint32
+	AssocID    int32
+}
+
+// SizeOfSCTPSndRcvInfo is the binary size of an SCTPSndRcvInfo struct.
+const SizeOfSCTPSndRcvInfo = 32
+
+// SCTPPaddrInfo is struct sctp_paddrinfo, from uapi/linux/sctp.h. SRTT and
+// RTO are in milliseconds.
+//
+// +marshal
+type SCTPPaddrInfo struct {
+	AssocID int32
+	Address [SockAddrMax]byte
+	State   int32
+	Cwnd    uint32
+	SRTT    uint32
+	RTO     uint32
+	MTU     uint32
+}
+
+// SCTPStatus is struct sctp_status, the SCTP_STATUS option value, from
+// uapi/linux/sctp.h.
+//
+// +marshal
+type SCTPStatus struct {
+	AssocID            int32
+	State              int32
+	Rwnd               uint32
+	UnackedData        uint16
+	PendingData        uint16
+	InStreams          uint16
+	OutStreams         uint16
+	FragmentationPoint uint32
+	Primary            SCTPPaddrInfo
+}
+
+// SizeOfSCTPStatus is the binary size of an SCTPStatus struct.
+const SizeOfSCTPStatus = 176
@@ -99,6 +99,8 @@ const (
 	SOL_UDP     = 17
 	SOL_IPV6    = 41
 	SOL_ICMPV6  = 58
+	SOL_SCTP    = 132
+	SOL_UDPLITE = 136
 	SOL_RAW     = 255
 	SOL_PACKET  = 263
 	SOL_NETLINK = 270
@@ -204,6 +206,7 @@ const (
 	SO_PEERGROUPS            = 59
 	SO_ZEROCOPY              = 60
 	SO_TXTIME                = 61
+	SO_DETACH_REUSEPORT_BPF  = 68
 )
 
 // enum socket_state, from uapi/linux/net.h.
@@ -262,6 +265,21 @@ type InetMulticastRequestWithNIC struct {
 	InterfaceIndex int32
 }
 
+// InetMulticastSourceRequest is struct ip_mreq_source, from uapi/linux/in.h.
+type InetMulticastSourceRequest struct {
+	MulticastAddr InetAddr
+	InterfaceAddr InetAddr
+	SourceAddr    InetAddr
+}
+
+// GroupSourceRequest is struct group_source_req, from uapi/linux/in.h.
+type GroupSourceRequest struct {
+	Interface uint32
+	_         uint32 // pad to the alignment of struct sockaddr_storage.
+	Group     [SockAddrMax]byte
+	Source    [SockAddrMax]byte
+}
+
 // Inet6Addr is struct in6_addr, from uapi/linux/in6.h.
 //
 // +marshal
@@ -332,6 +350,74 @@ type Linger struct {
 // SizeOfLinger is the binary size of a Linger struct.
 const SizeOfLinger = 8
 
+// SockTxtime is struct sock_txtime, the SO_TXTIME option value, from
+// uapi/linux/net_tstamp.h.
+//
+// +marshal
+type SockTxtime struct {
+	ClockID int32
+	Flags   uint32
+}
+
+// SizeOfSockTxtime is the binary size of a SockTxtime struct.
+const SizeOfSockTxtime = 8
+
+// Flags of SockTxtime, from uapi/linux/net_tstamp.h.
+const (
+	SOF_TXTIME_DEADLINE_MODE = 1 << 0
+	SOF_TXTIME_REPORT_ERRORS = 1 << 1
+
+	SOF_TXTIME_FLAGS_MASK = SOF_TXTIME_DEADLINE_MODE | SOF_TXTIME_REPORT_ERRORS
+)
+
+// Flags of SO_TIMESTAMPING, from uapi/linux/net_tstamp.h.
+const (
+	SOF_TIMESTAMPING_TX_HARDWARE  = 1 << 0
+	SOF_TIMESTAMPING_TX_SOFTWARE  = 1 << 1
+	SOF_TIMESTAMPING_RX_HARDWARE  = 1 << 2
+	SOF_TIMESTAMPING_RX_SOFTWARE  = 1 << 3
+	SOF_TIMESTAMPING_SOFTWARE     = 1 << 4
+	SOF_TIMESTAMPING_SYS_HARDWARE = 1 << 5
+	SOF_TIMESTAMPING_RAW_HARDWARE = 1 << 6
+	SOF_TIMESTAMPING_OPT_ID       = 1 << 7
+	SOF_TIMESTAMPING_TX_SCHED     = 1 << 8
+	SOF_TIMESTAMPING_TX_ACK       = 1 << 9
+	SOF_TIMESTAMPING_OPT_CMSG     = 1 << 10
+	SOF_TIMESTAMPING_OPT_TSONLY   = 1 << 11
+	SOF_TIMESTAMPING_OPT_STATS    = 1 << 12
+	SOF_TIMESTAMPING_OPT_PKTINFO  = 1 << 13
+	SOF_TIMESTAMPING_OPT_TX_SWHW  = 1 << 14
+
+	SOF_TIMESTAMPING_LAST = SOF_TIMESTAMPING_OPT_TX_SWHW
+	SOF_TIMESTAMPING_MASK = SOF_TIMESTAMPING_LAST<<1 - 1
+)
+
+// Types of transmit timestamps, from uapi/linux/errqueue.h.
+const (
+	SCM_TSTAMP_SND   = 0
+	SCM_TSTAMP_SCHED = 1
+	SCM_TSTAMP_ACK   = 2
+)
+
+// ScmTimestamping is struct scm_timestamping, from
+// uapi/linux/errqueue.h. Its array of timestamps is represented by the fields
+// below, as go_marshal doesn't support arrays of structs.
+//
+// +marshal
+type ScmTimestamping struct {
+	// Software is ts[0], the software timestamp.
+	Software Timespec
+
+	// Deprecated is ts[1], which is unused.
+	Deprecated Timespec
+
+	// Hardware is ts[2], the hardware timestamp.
+	Hardware Timespec
+}
+
+// SizeOfScmTimestamping is the binary size of a ScmTimestamping struct.
+const SizeOfScmTimestamping = 48
+
 // TCPInfo is a collection of TCP statistics.
 //
 // From uapi/linux/tcp.h. Newer versions of Linux continue to add new fields to
@@ -410,6 +496,27 @@ type TCPInfo struct {
 	RwndLimited uint64
 	// SndBufLimited is the time in microseconds limited by send buffer.
 	SndBufLimited uint64
+
+	// Delivered is the number of packets delivered, including retransmits.
+	Delivered uint32
+	// DeliveredCE is the number of delivered packets that were ECE marked.
+	DeliveredCE uint32
+
+	// BytesSent is RFC4898 tcpEStatsPerfHCDataOctetsOut.
+	BytesSent uint64
+	// BytesRetrans is RFC4898 tcpEStatsPerfOctetsRetrans.
+	BytesRetrans uint64
+	// DSACKDups is RFC4898 tcpEStatsStackDSACKDups.
+	DSACKDups uint32
+	// ReordSeen is the number of reordering events seen.
+	ReordSeen uint32
+
+	// RcvOOOPack is the number of out-of-order packets received.
+	RcvOOOPack uint32
+
+	// SndWnd is the peer's advertised receive window after scaling, in
+	// bytes.
+	SndWnd uint32
 }
 
 // SizeOfTCPInfo is the binary size of a TCPInfo struct.
@@ -417,8 +524,10 @@ var SizeOfTCPInfo = int(binary.Size(TCPInfo{}))
 
 // Control message types, from linux/socket.h.
 const (
-	SCM_CREDENTIALS = 0x2
-	SCM_RIGHTS      = 0x1
+	SCM_CREDENTIALS  = 0x2
+	SCM_RIGHTS       = 0x1
+	SCM_TXTIME       = SO_TXTIME
+	SCM_TIMESTAMPING = SO_TIMESTAMPING
 )
 
 // A ControlMessageHeader is the header for a socket control message.
@@ -474,6 +583,13 @@ const SizeOfControlMessageTOS = 1
 // SizeOfControlMessageTClass is the size of an IPV6_TCLASS control message.
 const SizeOfControlMessageTClass = 4
 
+// SizeOfControlMessageTxTime is the size of an SCM_TXTIME control message.
+const SizeOfControlMessageTxTime = 8
+
+// SizeOfControlMessageFlowInfo is the size of an IPV6_FLOWINFO control
+// message.
+const SizeOfControlMessageFlowInfo = 4
+
 // SizeOfControlMessageIPPacketInfo is the size of an IP_PKTINFO
 // control message.
 const SizeOfControlMessageIPPacketInfo = 12
@@ -51,8 +51,149 @@ const (
 	TCP_FASTOPEN_NO_COOKIE   = 34
 	TCP_ZEROCOPY_RECEIVE     = 35
 	TCP_INQ                  = 36
+	TCP_AO_ADD_KEY           = 38
+	TCP_AO_DEL_KEY           = 39
+	TCP_AO_INFO              = 40
+	TCP_AO_GET_KEYS          = 41
+	TCP_AO_REPAIR            = 42
 )
 
+// Flags of TCPInfo.Options, from uapi/linux/tcp.h.
+const (
+	TCPI_OPT_TIMESTAMPS = 1
+	TCPI_OPT_SACK       = 2
+	TCPI_OPT_WSCALE     = 4
+	TCPI_OPT_ECN        = 8
+	TCPI_OPT_ECN_SEEN   = 16
+	TCPI_OPT_SYN_DATA   = 32
+)
+
+// Values of TCPInfo.CaState, from uapi/linux/tcp.h.
+const (
+	TCP_CA_Open     = 0
+	TCP_CA_Disorder = 1
+	TCP_CA_CWR      = 2
+	TCP_CA_Recovery = 3
+	TCP_CA_Loss     = 4
+)
+
+// Values of TCP_REPAIR, from uapi/linux/tcp.h.
+const (
+	TCP_REPAIR_ON        = 1
+	TCP_REPAIR_OFF       = 0
+	TCP_REPAIR_OFF_NO_WP = -1
+)
+
+// Values of TCP_REPAIR_QUEUE, from uapi/linux/tcp.h.
+const (
+	TCP_NO_QUEUE   = 0
+	TCP_RECV_QUEUE = 1
+	TCP_SEND_QUEUE = 2
+)
+
+// TCP option kinds restored by TCP_REPAIR_OPTIONS, from include/net/tcp.h.
+const (
+	TCPOPT_MSS       = 2
+	TCPOPT_WINDOW    = 3
+	TCPOPT_SACK_PERM = 4
+	TCPOPT_TIMESTAMP = 8
+)
+
+// TCPRepairOpt is struct tcp_repair_opt, from uapi/linux/tcp.h.
+type TCPRepairOpt struct {
+	OptCode uint32
+	OptVal  uint32
+}
+
+// TCPRepairWindow is struct tcp_repair_window, from uapi/linux/tcp.h.
+//
+// +marshal
+type TCPRepairWindow struct {
+	SndWl1    uint32
+	SndWnd    uint32
+	MaxWindow uint32
+	RcvWnd    uint32
+	RcvWup    uint32
+}
+
+// TCP_MD5SIG_MAXKEYLEN is the maximum length of a TCP MD5 signature key, from
+// uapi/linux/tcp.h.
+const TCP_MD5SIG_MAXKEYLEN = 80
+
+// Flags of TCPMD5Sig, used by TCP_MD5SIG_EXT.
+const (
+	TCP_MD5SIG_FLAG_PREFIX  = 0x1
+	TCP_MD5SIG_FLAG_IFINDEX = 0x2
+)
+
+// TCPMD5Sig is struct tcp_md5sig, from uapi/linux/tcp.h.
+type TCPMD5Sig struct {
+	Addr      [SockAddrMax]byte
+	Flags     uint8
+	PrefixLen uint8
+	KeyLen    uint16
+	IfIndex   int32
+	Key       [TCP_MD5SIG_MAXKEYLEN]byte
+}
+
+// TCP_AO_MAXKEYLEN is the maximum length of a TCP-AO master key, from
+// uapi/linux/tcp.h.
+const TCP_AO_MAXKEYLEN = 80
+
+// Flags of TCPAOAdd, TCPAODel and TCPAOInfoOpt. Linux defines them as
+// bitfields.
+const (
+	TCP_AO_SET_CURRENT  = 0x1
+	TCP_AO_SET_RNEXT    = 0x2
+	TCP_AO_REQUIRED     = 0x4
+	TCP_AO_SET_COUNTERS = 0x8
+)
+
+// TCPAOAdd is struct tcp_ao_add, from uapi/linux/tcp.h.
+type TCPAOAdd struct {
+	Addr      [SockAddrMax]byte
+	AlgName   [64]byte
+	IfIndex   int32
+	Flags     uint32
+	Reserved2 uint16
+	Prefix    uint8
+	SndID     uint8
+	RcvID     uint8
+	MACLen    uint8
+	KeyFlags  uint8
+	KeyLen    uint8
+	Key       [TCP_AO_MAXKEYLEN]byte
+}
+
+// TCPAODel is struct tcp_ao_del, from uapi/linux/tcp.h.
+type TCPAODel struct {
+	Addr       [SockAddrMax]byte
+	IfIndex    int32
+	Flags      uint32
+	Reserved2  uint16
+	Prefix     uint8
+	SndID      uint8
+	RcvID      uint8
+	CurrentKey uint8
+	RNext      uint8
+	KeyFlags   uint8
+}
+
+// TCPAOInfoOpt is struct tcp_ao_info_opt, from uapi/linux/tcp.h.
+//
+// +marshal
+type TCPAOInfoOpt struct {
+	Flags          uint32
+	Reserved2      uint16
+	CurrentKey     uint8
+	RNext          uint8
+	PktGood        uint64
+	PktBad         uint64
+	PktKeyNotFound uint64
+	PktAORequired  uint64
+	PktDroppedICMP uint64
+}
+
 // Socket constants from include/net/tcp.h.
 const (
 	MAX_TCP_KEEPIDLE  = 32767
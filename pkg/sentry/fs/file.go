@@ -15,6 +15,7 @@
 package fs
 
 import (
+	"io"
 	"math"
 	"sync/atomic"
 
@@ -511,6 +512,30 @@ func (r *lockedReader) ReadAt(buf []byte, offset int64) (int, error) {
 	return int(n), err
 }
 
+// SpliceSource is implemented by the readers passed to FileOperations.ReadFrom
+// by Splice. It gives the destination direct access to the source file, e.g.
+// to reference its pages rather than copy them.
+type SpliceSource interface {
+	io.Reader
+
+	// SpliceFile returns the source file and the offset of the next Read.
+	SpliceFile() (*File, int64)
+
+	// Skip advances the offset of the next Read by n bytes, which were
+	// consumed without reading them.
+	Skip(n int64)
+}
+
+// SpliceFile implements SpliceSource.SpliceFile.
+func (r *lockedReader) SpliceFile() (*File, int64) {
+	return r.File, r.Offset
+}
+
+// Skip implements SpliceSource.Skip.
+func (r *lockedReader) Skip(n int64) {
+	r.Offset += n
+}
+
 // lockedWriter implements io.Writer and io.WriterAt.
 //
 // The same constraints as lockedReader apply; see above.
@@ -53,3 +53,12 @@ func (ipf *ipForwarding) afterLoad() {
 		}
 	}
 }
+
+// afterLoad is invoked by stateify.
+func (nlb *ipNonLocalBind) afterLoad() {
+	if nlb.enabled != nil {
+		if err := nlb.stack.SetNonLocalBind(ipv4.ProtocolNumber, *nlb.enabled); err != nil {
+			panic(fmt.Sprintf("failed to set IPv4 non-local bind [%v]: %v", *nlb.enabled, err))
+		}
+	}
+}
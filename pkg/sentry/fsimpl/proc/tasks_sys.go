@@ -66,11 +66,12 @@ func (fs *filesystem) newSysNetDir(ctx context.Context, root *auth.Credentials,
 	if stack := k.RootNetworkNamespace().Stack(); stack != nil {
 		contents = map[string]kernfs.Inode{
 			"ipv4": fs.newStaticDir(ctx, root, map[string]kernfs.Inode{
-				"tcp_recovery": fs.newInode(ctx, root, 0644, &tcpRecoveryData{stack: stack}),
-				"tcp_rmem":     fs.newInode(ctx, root, 0644, &tcpMemData{stack: stack, dir: tcpRMem}),
-				"tcp_sack":     fs.newInode(ctx, root, 0644, &tcpSackData{stack: stack}),
-				"tcp_wmem":     fs.newInode(ctx, root, 0644, &tcpMemData{stack: stack, dir: tcpWMem}),
-				"ip_forward":   fs.newInode(ctx, root, 0444, &ipForwarding{stack: stack}),
+				"tcp_recovery":     fs.newInode(ctx, root, 0644, &tcpRecoveryData{stack: stack}),
+				"tcp_rmem":         fs.newInode(ctx, root, 0644, &tcpMemData{stack: stack, dir: tcpRMem}),
+				"tcp_sack":         fs.newInode(ctx, root, 0644, &tcpSackData{stack: stack}),
+				"tcp_wmem":         fs.newInode(ctx, root, 0644, &tcpMemData{stack: stack, dir: tcpWMem}),
+				"ip_forward":       fs.newInode(ctx, root, 0444, &ipForwarding{stack: stack}),
+				"ip_nonlocal_bind": fs.newInode(ctx, root, 0644, &ipNonLocalBind{stack: stack}),
 
 				// The following files are simple stubs until they are implemented in
 				// netstack, most of these files are configuration related. We use the
@@ -79,7 +80,6 @@ func (fs *filesystem) newSysNetDir(ctx context.Context, root *auth.Credentials,
 				"ip_local_port_range":     fs.newInode(ctx, root, 0444, newStaticFile("16000   65535")),
 				"ip_local_reserved_ports": fs.newInode(ctx, root, 0444, newStaticFile("")),
 				"ipfrag_time":             fs.newInode(ctx, root, 0444, newStaticFile("30")),
-				"ip_nonlocal_bind":        fs.newInode(ctx, root, 0444, newStaticFile("0")),
 				"ip_no_pmtu_disc":         fs.newInode(ctx, root, 0444, newStaticFile("1")),
 
 				// tcp_allowed_congestion_control tell the user what they are able to
@@ -418,3 +418,59 @@ func (ipf *ipForwarding) Write(ctx context.Context, src usermem.IOSequence, offs
 	}
 	return n, nil
 }
+
+// ipNonLocalBind implements vfs.WritableDynamicBytesSource for
+// /proc/sys/net/ipv4/ip_nonlocal_bind.
+//
+// +stateify savable
+type ipNonLocalBind struct {
+	kernfs.DynamicBytesFile
+
+	stack   inet.Stack `state:"wait"`
+	enabled *bool
+}
+
+var _ vfs.WritableDynamicBytesSource = (*ipNonLocalBind)(nil)
+
+// Generate implements vfs.DynamicBytesSource.Generate.
+func (d *ipNonLocalBind) Generate(ctx context.Context, buf *bytes.Buffer) error {
+	if d.enabled == nil {
+		enabled := d.stack.NonLocalBind(ipv4.ProtocolNumber)
+		d.enabled = &enabled
+	}
+
+	val := "0\n"
+	if *d.enabled {
+		val = "1\n"
+	}
+	buf.WriteString(val)
+	return nil
+}
+
+// Write implements vfs.WritableDynamicBytesSource.Write.
+func (d *ipNonLocalBind) Write(ctx context.Context, src usermem.IOSequence, offset int64) (int64, error) {
+	if offset != 0 {
+		// No need to handle partial writes thus far.
+		return 0, syserror.EINVAL
+	}
+	if src.NumBytes() == 0 {
+		return 0, nil
+	}
+
+	// Limit input size so as not to impact performance if input size is large.
+	src = src.TakeFirst(usermem.PageSize - 1)
+
+	var v int32
+	n, err := usermem.CopyInt32StringInVec(ctx, src.IO, src.Addrs, &v, src.Opts)
+	if err != nil {
+		return 0, err
+	}
+	if d.enabled == nil {
+		d.enabled = new(bool)
+	}
+	*d.enabled = v != 0
+	if err := d.stack.SetNonLocalBind(ipv4.ProtocolNumber, *d.enabled); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -147,3 +147,54 @@ func TestConfigureIPForwarding(t *testing.T) {
 		})
 	}
 }
+
+// TestConfigureIPNonLocalBind tests the implementation of
+// /proc/sys/net/ipv4/ip_nonlocal_bind.
+func TestConfigureIPNonLocalBind(t *testing.T) {
+	ctx := context.Background()
+	s := inet.NewTestStack()
+
+	var cases = []struct {
+		comment string
+		initial bool
+		str     string
+		final   bool
+	}{
+		{
+			comment: `Non-local bind is disabled; write 1 and enable it`,
+			initial: false,
+			str:     "1",
+			final:   true,
+		},
+		{
+			comment: `Non-local bind is enabled; write 0 and disable it`,
+			initial: true,
+			str:     "0",
+			final:   false,
+		},
+		{
+			comment: `Non-local bind is disabled; write 2 and enable it`,
+			initial: false,
+			str:     "2",
+			final:   true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.comment, func(t *testing.T) {
+			s.IPNonLocalBind = c.initial
+
+			file := &ipNonLocalBind{stack: s}
+
+			// Write the values.
+			src := usermem.BytesIOSequence([]byte(c.str))
+			if n, err := file.Write(ctx, src, 0); n != int64(len(c.str)) || err != nil {
+				t.Errorf("file.Write(ctx, nil, %q, 0) = (%d, %v); want (%d, nil)", c.str, n, err, len(c.str))
+			}
+
+			// Read the values from the stack and check them.
+			if got, want := s.IPNonLocalBind, c.final; got != want {
+				t.Errorf("s.IPNonLocalBind incorrect; got: %v, want: %v", got, want)
+			}
+		})
+	}
+}
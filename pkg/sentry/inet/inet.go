@@ -16,6 +16,8 @@
 package inet
 
 import (
+	"time"
+
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
@@ -39,6 +41,19 @@ type Stack interface {
 	// identified by idx.
 	RemoveInterfaceAddr(idx int32, addr InterfaceAddr) error
 
+	// AddVLANInterface creates a network interface named name for the VLAN
+	// id of the network interface identified by parent, and returns the
+	// index of the new interface. protocol is the TPID of the VLAN tag in
+	// host byte order, 0x8100 for 802.1Q or 0x88a8 for 802.1ad.
+	AddVLANInterface(parent int32, name string, id uint16, protocol uint16) (int32, error)
+
+	// AddGREInterface creates a GRE tunnel interface named name, and returns
+	// the index of the new interface.
+	AddGREInterface(name string, tun GRETunnel) (int32, error)
+
+	// RemoveInterface removes the network interface identified by idx.
+	RemoveInterface(idx int32) error
+
 	// SupportsIPv6 returns true if the stack supports IPv6 connectivity.
 	SupportsIPv6() bool
 
@@ -75,6 +90,26 @@ type Stack interface {
 	// RouteTable returns the network stack's route table.
 	RouteTable() []Route
 
+	// AddRoute adds a route, placed among the routes with the same
+	// destination, table and priority as opts requires.
+	AddRoute(route Route, opts AddRouteOptions) error
+
+	// RemoveRoute removes the first route of route.Family to the destination
+	// of route which matches route. The table, priority, gateway and output
+	// interface of route match any value if they are zero.
+	RemoveRoute(route Route) error
+
+	// RouteRules returns the routing policy rules of the stack, in the
+	// order they are evaluated.
+	RouteRules() []RouteRule
+
+	// AddRouteRule adds a routing policy rule.
+	AddRouteRule(rule RouteRule) error
+
+	// RemoveRouteRule removes the first routing policy rule of rule.Family
+	// matching rule. The fields of rule which are zero match any value.
+	RemoveRouteRule(rule RouteRule) error
+
 	// Resume restarts the network stack after restore.
 	Resume()
 
@@ -93,6 +128,26 @@ type Stack interface {
 
 	// SetForwarding enables or disables packet forwarding between NICs.
 	SetForwarding(protocol tcpip.NetworkProtocolNumber, enable bool) error
+
+	// NonLocalBind returns if sockets may bind to addresses which aren't
+	// assigned to any interface.
+	NonLocalBind(protocol tcpip.NetworkProtocolNumber) bool
+
+	// SetNonLocalBind enables or disables binding sockets to addresses which
+	// aren't assigned to any interface.
+	SetNonLocalBind(protocol tcpip.NetworkProtocolNumber, enable bool) error
+
+	// Neighbors returns the neighbor entries of all interfaces, keyed by
+	// interface index.
+	Neighbors() map[int32][]Neighbor
+
+	// AddNeighbor adds a permanent neighbor entry to the interface with
+	// index idx.
+	AddNeighbor(idx int32, neigh Neighbor) error
+
+	// RemoveNeighbor removes the neighbor entry for neigh.Addr from the
+	// interface with index idx.
+	RemoveNeighbor(idx int32, neigh Neighbor) error
 }
 
 // Interface contains information about a network interface.
@@ -112,6 +167,44 @@ type Interface struct {
 
 	// MTU is the maximum transmission unit.
 	MTU uint32
+
+	// Link is the index of the network interface the interface sends and
+	// receives packets through, such as the parent of a VLAN interface, or 0
+	// if there is none.
+	Link int32
+}
+
+// GRETunnel contains the configuration of a GRE tunnel, like Linux's gre and
+// ip6gre devices. The tunnel is an ip6gre tunnel if Remote is an IPv6 address.
+type GRETunnel struct {
+	// Local is the local address of the tunnel, or empty if any local
+	// address may be used.
+	Local []byte
+
+	// Remote is the address of the remote end of the tunnel.
+	Remote []byte
+
+	// TTL is the TTL (or Hop Limit) of encapsulated packets, or 0 if it is
+	// inherited from the encapsulated packet.
+	TTL uint8
+
+	// TOS is the TOS (or Traffic Class) of encapsulated packets, unless
+	// InheritTOS is set.
+	TOS        uint8
+	InheritTOS bool
+
+	// InputKey is the key received packets must carry, if HasInputKey is
+	// set. OutputKey is the key carried by sent packets, if HasOutputKey is
+	// set.
+	InputKey     uint32
+	HasInputKey  bool
+	OutputKey    uint32
+	HasOutputKey bool
+
+	// InputChecksum and OutputChecksum are set if received and sent packets
+	// must carry a checksum.
+	InputChecksum  bool
+	OutputChecksum bool
 }
 
 // InterfaceAddr contains information about a network interface address.
@@ -129,6 +222,24 @@ type InterfaceAddr struct {
 	Addr []byte
 }
 
+// Neighbor contains information about a neighbor cache entry.
+type Neighbor struct {
+	// Family is the address family, a Linux AF_* constant.
+	Family uint8
+
+	// State is the entry state, a Linux NUD_* constant.
+	State uint16
+
+	// Addr is the neighbor's network address.
+	Addr []byte
+
+	// LinkAddr is the neighbor's link address.
+	LinkAddr []byte
+
+	// Age is the time elapsed since the entry was last updated.
+	Age time.Duration
+}
+
 // TCPBufferSize contains settings controlling TCP buffer sizing.
 //
 // +stateify savable
@@ -162,7 +273,12 @@ type Route struct {
 	TOS uint8
 
 	// Table is the routing table ID.
-	Table uint8
+	Table uint32
+
+	// Priority is the priority of the route (RTA_PRIORITY), the lowest
+	// being preferred among the routes to destinations of the same prefix
+	// length.
+	Priority uint32
 
 	// Protocol is the route origin, a Linux RTPROT_* constant.
 	Protocol uint8
@@ -189,6 +305,69 @@ type Route struct {
 	GatewayAddr []byte
 }
 
+// AddRouteOptions holds the options of Stack.AddRoute, which match the
+// NLM_F_* flags of RTM_NEWROUTE requests.
+type AddRouteOptions struct {
+	// Create allows the route to be added when there is no route with the
+	// same destination, table and priority (NLM_F_CREATE).
+	Create bool
+
+	// Exclusive makes the request fail if there is a route with the same
+	// destination, table and priority (NLM_F_EXCL).
+	Exclusive bool
+
+	// Replace makes the route replace the first route with the same
+	// destination, table and priority (NLM_F_REPLACE).
+	Replace bool
+
+	// Append makes the route be added after the routes with the same
+	// destination, table and priority rather than before them (NLM_F_APPEND).
+	Append bool
+}
+
+// RouteRule contains information about a routing policy rule.
+type RouteRule struct {
+	// Family is the address family, a Linux AF_* constant.
+	Family uint8
+
+	// Priority orders the rules, the rules with the lowest priority are
+	// evaluated first (FRA_PRIORITY).
+	Priority uint32
+
+	// SrcLen is the length of the source prefix.
+	SrcLen uint8
+
+	// SrcAddr is the source prefix the rule matches (FRA_SRC).
+	SrcAddr []byte
+
+	// DstLen is the length of the destination prefix.
+	DstLen uint8
+
+	// DstAddr is the destination prefix the rule matches (FRA_DST).
+	DstAddr []byte
+
+	// TOS is the Type of Service the rule matches.
+	TOS uint8
+
+	// Mark is the mark the rule matches after masking with MarkMask
+	// (FRA_FWMARK).
+	Mark uint32
+
+	// MarkMask is the mask applied to the mark of packets (FRA_FWMASK).
+	MarkMask uint32
+
+	// InputInterface is the index of the input interface the rule matches
+	// (FRA_IIFNAME).
+	InputInterface int32
+
+	// OutputInterface is the index of the output interface the rule
+	// matches (FRA_OIFNAME).
+	OutputInterface int32
+
+	// Table is the routing table ID looked up (FRA_TABLE).
+	Table uint32
+}
+
 // Below SNMP metrics are from Linux/usr/include/linux/snmp.h.
 
 // StatSNMPIP describes Ip line of /proc/net/snmp.
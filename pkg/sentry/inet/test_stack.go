@@ -18,6 +18,8 @@ import (
 	"bytes"
 	"fmt"
 
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/syserror"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
@@ -26,13 +28,16 @@ import (
 type TestStack struct {
 	InterfacesMap     map[int32]Interface
 	InterfaceAddrsMap map[int32][]InterfaceAddr
+	NeighborsMap      map[int32][]Neighbor
 	RouteList         []Route
+	RouteRuleList     []RouteRule
 	SupportsIPv6Flag  bool
 	TCPRecvBufSize    TCPBufferSize
 	TCPSendBufSize    TCPBufferSize
 	TCPSACKFlag       bool
 	Recovery          TCPLossRecovery
 	IPForwarding      bool
+	IPNonLocalBind    bool
 }
 
 // NewTestStack returns a TestStack with no network interfaces. The value of
@@ -42,6 +47,7 @@ func NewTestStack() *TestStack {
 	return &TestStack{
 		InterfacesMap:     make(map[int32]Interface),
 		InterfaceAddrsMap: make(map[int32][]InterfaceAddr),
+		NeighborsMap:      make(map[int32][]Neighbor),
 	}
 }
 
@@ -79,6 +85,60 @@ func (s *TestStack) RemoveInterfaceAddr(idx int32, addr InterfaceAddr) error {
 	return nil
 }
 
+// AddVLANInterface implements Stack.AddVLANInterface.
+func (s *TestStack) AddVLANInterface(parent int32, name string, id uint16, protocol uint16) (int32, error) {
+	p, ok := s.InterfacesMap[parent]
+	if !ok {
+		return 0, syserror.ENODEV
+	}
+	idx := int32(1)
+	for i := range s.InterfacesMap {
+		if i >= idx {
+			idx = i + 1
+		}
+	}
+	s.InterfacesMap[idx] = Interface{
+		DeviceType: p.DeviceType,
+		Flags:      p.Flags,
+		Name:       name,
+		Addr:       p.Addr,
+		MTU:        p.MTU,
+		Link:       parent,
+	}
+	return idx, nil
+}
+
+// AddGREInterface implements Stack.AddGREInterface.
+func (s *TestStack) AddGREInterface(name string, tun GRETunnel) (int32, error) {
+	idx := int32(1)
+	for i := range s.InterfacesMap {
+		if i >= idx {
+			idx = i + 1
+		}
+	}
+	deviceType := uint16(linux.ARPHRD_IPGRE)
+	if len(tun.Remote) == 16 {
+		deviceType = linux.ARPHRD_IP6GRE
+	}
+	s.InterfacesMap[idx] = Interface{
+		DeviceType: deviceType,
+		Name:       name,
+		Addr:       tun.Local,
+	}
+	return idx, nil
+}
+
+// RemoveInterface implements Stack.RemoveInterface.
+func (s *TestStack) RemoveInterface(idx int32) error {
+	if _, ok := s.InterfacesMap[idx]; !ok {
+		return syserror.ENODEV
+	}
+	delete(s.InterfacesMap, idx)
+	delete(s.InterfaceAddrsMap, idx)
+	delete(s.NeighborsMap, idx)
+	return nil
+}
+
 // SupportsIPv6 implements Stack.SupportsIPv6.
 func (s *TestStack) SupportsIPv6() bool {
 	return s.SupportsIPv6Flag
@@ -138,6 +198,45 @@ func (s *TestStack) RouteTable() []Route {
 	return s.RouteList
 }
 
+// AddRoute implements Stack.AddRoute.
+func (s *TestStack) AddRoute(route Route, opts AddRouteOptions) error {
+	s.RouteList = append(s.RouteList, route)
+	return nil
+}
+
+// RemoveRoute implements Stack.RemoveRoute.
+func (s *TestStack) RemoveRoute(route Route) error {
+	for i, r := range s.RouteList {
+		if r.Family == route.Family && r.DstLen == route.DstLen && bytes.Equal(r.DstAddr, route.DstAddr) {
+			s.RouteList = append(s.RouteList[:i], s.RouteList[i+1:]...)
+			return nil
+		}
+	}
+	return syserror.ESRCH
+}
+
+// RouteRules implements Stack.RouteRules.
+func (s *TestStack) RouteRules() []RouteRule {
+	return s.RouteRuleList
+}
+
+// AddRouteRule implements Stack.AddRouteRule.
+func (s *TestStack) AddRouteRule(rule RouteRule) error {
+	s.RouteRuleList = append(s.RouteRuleList, rule)
+	return nil
+}
+
+// RemoveRouteRule implements Stack.RemoveRouteRule.
+func (s *TestStack) RemoveRouteRule(rule RouteRule) error {
+	for i, r := range s.RouteRuleList {
+		if r.Family == rule.Family && (rule.Priority == 0 || r.Priority == rule.Priority) && (rule.Table == 0 || r.Table == rule.Table) {
+			s.RouteRuleList = append(s.RouteRuleList[:i], s.RouteRuleList[i+1:]...)
+			return nil
+		}
+	}
+	return syserror.ENOENT
+}
+
 // Resume implements Stack.Resume.
 func (s *TestStack) Resume() {}
 
@@ -164,3 +263,37 @@ func (s *TestStack) SetForwarding(protocol tcpip.NetworkProtocolNumber, enable b
 	s.IPForwarding = enable
 	return nil
 }
+
+// NonLocalBind implements inet.Stack.NonLocalBind.
+func (s *TestStack) NonLocalBind(protocol tcpip.NetworkProtocolNumber) bool {
+	return s.IPNonLocalBind
+}
+
+// SetNonLocalBind implements inet.Stack.SetNonLocalBind.
+func (s *TestStack) SetNonLocalBind(protocol tcpip.NetworkProtocolNumber, enable bool) error {
+	s.IPNonLocalBind = enable
+	return nil
+}
+
+// Neighbors implements inet.Stack.Neighbors.
+func (s *TestStack) Neighbors() map[int32][]Neighbor {
+	return s.NeighborsMap
+}
+
+// AddNeighbor implements inet.Stack.AddNeighbor.
+func (s *TestStack) AddNeighbor(idx int32, neigh Neighbor) error {
+	s.NeighborsMap[idx] = append(s.NeighborsMap[idx], neigh)
+	return nil
+}
+
+// RemoveNeighbor implements inet.Stack.RemoveNeighbor.
+func (s *TestStack) RemoveNeighbor(idx int32, neigh Neighbor) error {
+	neighs := s.NeighborsMap[idx]
+	for i, n := range neighs {
+		if bytes.Equal(n.Addr, neigh.Addr) {
+			s.NeighborsMap[idx] = append(neighs[:i], neighs[i+1:]...)
+			return nil
+		}
+	}
+	return syserror.ENOENT
+}
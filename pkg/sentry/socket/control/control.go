@@ -25,8 +25,10 @@ import (
 	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
 	"gvisor.dev/gvisor/pkg/sentry/socket"
 	"gvisor.dev/gvisor/pkg/sentry/socket/unix/transport"
+	"gvisor.dev/gvisor/pkg/syserr"
 	"gvisor.dev/gvisor/pkg/syserror"
 	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
 	"gvisor.dev/gvisor/pkg/usermem"
 )
 
@@ -310,6 +312,20 @@ func PackTimestamp(t *kernel.Task, timestamp int64, buf []byte) []byte {
 	)
 }
 
+// PackTimestamping packs a SCM_TIMESTAMPING socket control message holding the
+// software timestamp timestamp.
+func PackTimestamping(t *kernel.Task, timestamp int64, buf []byte) []byte {
+	var ts linux.ScmTimestamping
+	ts.Software = linux.NsecToTimespec(timestamp)
+	return putCmsgStruct(
+		buf,
+		linux.SOL_SOCKET,
+		linux.SCM_TIMESTAMPING,
+		t.Arch().Width(),
+		ts,
+	)
+}
+
 // PackInq packs a TCP_INQ socket control message.
 func PackInq(t *kernel.Task, inq int32, buf []byte) []byte {
 	return putCmsgStruct(
@@ -343,6 +359,20 @@ func PackTClass(t *kernel.Task, tClass uint32, buf []byte) []byte {
 	)
 }
 
+// PackFlowInfo packs an IPV6_FLOWINFO socket control message.
+func PackFlowInfo(t *kernel.Task, flowInfo uint32, buf []byte) []byte {
+	// The flow information is in network byte order.
+	var b [linux.SizeOfControlMessageFlowInfo]byte
+	binary.BigEndian.PutUint32(b[:], flowInfo)
+	return putCmsgStruct(
+		buf,
+		linux.SOL_IPV6,
+		linux.IPV6_FLOWINFO,
+		t.Arch().Width(),
+		b,
+	)
+}
+
 // PackIPPacketInfo packs an IP_PKTINFO socket control message.
 func PackIPPacketInfo(t *kernel.Task, packetInfo tcpip.IPPacketInfo, buf []byte) []byte {
 	var p linux.ControlMessageIPPacketInfo
@@ -359,6 +389,109 @@ func PackIPPacketInfo(t *kernel.Task, packetInfo tcpip.IPPacketInfo, buf []byte)
 	)
 }
 
+// PackSCTPRcvInfo packs an SCTP_RCVINFO socket control message.
+func PackSCTPRcvInfo(t *kernel.Task, info tcpip.SCTPRcvInfo, buf []byte) []byte {
+	p := linux.SCTPRcvInfo{
+		SID:    info.Stream,
+		SSN:    info.SSN,
+		PPID:   info.PPID,
+		TSN:    info.TSN,
+		CumTSN: info.CumTSN,
+	}
+	if info.Unordered {
+		p.Flags |= linux.SCTP_UNORDERED
+	}
+	return putCmsgStruct(
+		buf,
+		linux.SOL_SCTP,
+		linux.SCTP_RCVINFO,
+		t.Arch().Width(),
+		p,
+	)
+}
+
+func errOriginToLinux(origin tcpip.SockErrOrigin) uint8 {
+	switch origin {
+	case tcpip.SockExtErrorOriginLocal:
+		return linux.SO_EE_ORIGIN_LOCAL
+	case tcpip.SockExtErrorOriginICMP:
+		return linux.SO_EE_ORIGIN_ICMP
+	case tcpip.SockExtErrorOriginICMP6:
+		return linux.SO_EE_ORIGIN_ICMP6
+	case tcpip.SockExtErrorOriginZeroCopy:
+		return linux.SO_EE_ORIGIN_ZEROCOPY
+	case tcpip.SockExtErrorOriginTxTime:
+		return linux.SO_EE_ORIGIN_TXTIME
+	case tcpip.SockExtErrorOriginTimestamping:
+		return linux.SO_EE_ORIGIN_TIMESTAMPING
+	default:
+		return linux.SO_EE_ORIGIN_NONE
+	}
+}
+
+// newSockExtendedErr returns the struct sock_extended_err describing sockErr.
+func newSockExtendedErr(sockErr *tcpip.SockError) linux.SockExtendedErr {
+	ee := linux.SockExtendedErr{
+		Origin: errOriginToLinux(sockErr.ErrOrigin),
+		Type:   sockErr.ErrType,
+		Code:   sockErr.ErrCode,
+		Info:   sockErr.ErrInfo,
+		Data:   sockErr.ErrData,
+	}
+	// Zerocopy completion notifications carry no error, and transmit
+	// timestamps carry ENOMSG.
+	switch {
+	case sockErr.ErrOrigin == tcpip.SockExtErrorOriginTimestamping:
+		ee.Errno = uint32(linux.ENOMSG.Number())
+	case sockErr.Err != nil:
+		ee.Errno = uint32(syserr.TranslateNetstackError(sockErr.Err).ToLinux().Number())
+	}
+	return ee
+}
+
+// PackSockExtendedErr packs an IP*_RECVERR socket control message.
+func PackSockExtendedErr(t *kernel.Task, sockErr *tcpip.SockError, buf []byte) []byte {
+	switch sockErr.NetProto {
+	case header.IPv4ProtocolNumber:
+		errMsg := linux.SockErrCMsgIPv4{SockExtendedErr: newSockExtendedErr(sockErr)}
+		if sockErr.ErrOrigin.IsICMPErr() {
+			errMsg.Offender.Family = linux.AF_INET
+			copy(errMsg.Offender.Addr[:], sockErr.Offender.Addr)
+		}
+		return putCmsgStruct(
+			buf,
+			linux.SOL_IP,
+			linux.IP_RECVERR,
+			t.Arch().Width(),
+			errMsg,
+		)
+
+	case header.IPv6ProtocolNumber:
+		errMsg := linux.SockErrCMsgIPv6{SockExtendedErr: newSockExtendedErr(sockErr)}
+		if sockErr.ErrOrigin.IsICMPErr() {
+			errMsg.Offender.Family = linux.AF_INET6
+			if len(sockErr.Offender.Addr) == header.IPv4AddressSize {
+				// Copy address in v4-mapped format.
+				copy(errMsg.Offender.Addr[12:], sockErr.Offender.Addr)
+				errMsg.Offender.Addr[10] = 0xff
+				errMsg.Offender.Addr[11] = 0xff
+			} else {
+				copy(errMsg.Offender.Addr[:], sockErr.Offender.Addr)
+			}
+		}
+		return putCmsgStruct(
+			buf,
+			linux.SOL_IPV6,
+			linux.IPV6_RECVERR,
+			t.Arch().Width(),
+			errMsg,
+		)
+
+	default:
+		return buf
+	}
+}
+
 // PackControlMessages packs control messages into the given buffer.
 //
 // We skip control messages specific to Unix domain sockets.
@@ -370,6 +503,10 @@ func PackControlMessages(t *kernel.Task, cmsgs socket.ControlMessages, buf []byt
 		buf = PackTimestamp(t, cmsgs.IP.Timestamp, buf)
 	}
 
+	if cmsgs.IP.HasTimestamping {
+		buf = PackTimestamping(t, cmsgs.IP.Timestamping, buf)
+	}
+
 	if cmsgs.IP.HasInq {
 		// In Linux, TCP_CM_INQ is added after SO_TIMESTAMP.
 		buf = PackInq(t, cmsgs.IP.Inq, buf)
@@ -383,10 +520,22 @@ func PackControlMessages(t *kernel.Task, cmsgs socket.ControlMessages, buf []byt
 		buf = PackTClass(t, cmsgs.IP.TClass, buf)
 	}
 
+	if cmsgs.IP.HasFlowInfo {
+		buf = PackFlowInfo(t, cmsgs.IP.FlowInfo, buf)
+	}
+
 	if cmsgs.IP.HasIPPacketInfo {
 		buf = PackIPPacketInfo(t, cmsgs.IP.PacketInfo, buf)
 	}
 
+	if cmsgs.IP.SockErr != nil {
+		buf = PackSockExtendedErr(t, cmsgs.IP.SockErr, buf)
+	}
+
+	if cmsgs.IP.HasSCTPRcvInfo {
+		buf = PackSCTPRcvInfo(t, cmsgs.IP.SCTPRcvInfo, buf)
+	}
+
 	return buf
 }
 
@@ -404,6 +553,10 @@ func CmsgsSpace(t *kernel.Task, cmsgs socket.ControlMessages) int {
 		space += cmsgSpace(t, linux.SizeOfTimeval)
 	}
 
+	if cmsgs.IP.HasTimestamping {
+		space += cmsgSpace(t, linux.SizeOfScmTimestamping)
+	}
+
 	if cmsgs.IP.HasInq {
 		space += cmsgSpace(t, linux.SizeOfControlMessageInq)
 	}
@@ -416,6 +569,23 @@ func CmsgsSpace(t *kernel.Task, cmsgs socket.ControlMessages) int {
 		space += cmsgSpace(t, linux.SizeOfControlMessageTClass)
 	}
 
+	if cmsgs.IP.HasFlowInfo {
+		space += cmsgSpace(t, linux.SizeOfControlMessageFlowInfo)
+	}
+
+	if sockErr := cmsgs.IP.SockErr; sockErr != nil {
+		switch sockErr.NetProto {
+		case header.IPv4ProtocolNumber:
+			space += cmsgSpace(t, linux.SizeOfSockErrCMsgIPv4)
+		case header.IPv6ProtocolNumber:
+			space += cmsgSpace(t, linux.SizeOfSockErrCMsgIPv6)
+		}
+	}
+
+	if cmsgs.IP.HasSCTPRcvInfo {
+		space += cmsgSpace(t, linux.SizeOfSCTPRcvInfo)
+	}
+
 	return space
 }
 
@@ -489,6 +659,14 @@ func Parse(t *kernel.Task, socketOrEndpoint interface{}, buf []byte) (socket.Con
 				cmsgs.Unix.Credentials = scmCreds
 				i += binary.AlignUp(length, width)
 
+			case linux.SCM_TXTIME:
+				if length < linux.SizeOfControlMessageTxTime {
+					return socket.ControlMessages{}, syserror.EINVAL
+				}
+				cmsgs.IP.HasTxTime = true
+				cmsgs.IP.TxTime = usermem.ByteOrder.Uint64(buf[i : i+linux.SizeOfControlMessageTxTime])
+				i += binary.AlignUp(length, width)
+
 			default:
 				// Unknown message type.
 				return socket.ControlMessages{}, syserror.EINVAL
@@ -528,6 +706,39 @@ func Parse(t *kernel.Task, socketOrEndpoint interface{}, buf []byte) (socket.Con
 				binary.Unmarshal(buf[i:i+linux.SizeOfControlMessageTClass], usermem.ByteOrder, &cmsgs.IP.TClass)
 				i += binary.AlignUp(length, width)
 
+			default:
+				return socket.ControlMessages{}, syserror.EINVAL
+			}
+		case linux.SOL_SCTP:
+			switch h.Type {
+			case linux.SCTP_SNDINFO:
+				if length < linux.SizeOfSCTPSndInfo {
+					return socket.ControlMessages{}, syserror.EINVAL
+				}
+				var info linux.SCTPSndInfo
+				binary.Unmarshal(buf[i:i+linux.SizeOfSCTPSndInfo], usermem.ByteOrder, &info)
+				cmsgs.IP.HasSCTPSndInfo = true
+				cmsgs.IP.SCTPSndInfo = tcpip.SCTPSndInfo{
+					Stream:    info.SID,
+					Unordered: info.Flags&linux.SCTP_UNORDERED != 0,
+					PPID:      info.PPID,
+				}
+				i += binary.AlignUp(length, width)
+
+			case linux.SCTP_SNDRCV:
+				if length < linux.SizeOfSCTPSndRcvInfo {
+					return socket.ControlMessages{}, syserror.EINVAL
+				}
+				var info linux.SCTPSndRcvInfo
+				binary.Unmarshal(buf[i:i+linux.SizeOfSCTPSndRcvInfo], usermem.ByteOrder, &info)
+				cmsgs.IP.HasSCTPSndInfo = true
+				cmsgs.IP.SCTPSndInfo = tcpip.SCTPSndInfo{
+					Stream:    info.Stream,
+					Unordered: info.Flags&linux.SCTP_UNORDERED != 0,
+					PPID:      info.PPID,
+				}
+				i += binary.AlignUp(length, width)
+
 			default:
 				return socket.ControlMessages{}, syserror.EINVAL
 			}
@@ -418,11 +418,15 @@ func (s *socketOpsCommon) SetSockOpt(t *kernel.Task, level int, name int, opt []
 
 // RecvMsg implements socket.Socket.RecvMsg.
 func (s *socketOpsCommon) RecvMsg(t *kernel.Task, dst usermem.IOSequence, flags int, haveDeadline bool, deadline ktime.Time, senderRequested bool, controlLen uint64) (int, int, linux.SockAddr, uint32, socket.ControlMessages, *syserr.Error) {
-	// Only allow known and safe flags.
-	//
 	// FIXME(jamieliu): We can't support MSG_ERRQUEUE because it uses ancillary
 	// messages that gvisor/pkg/tcpip/transport/unix doesn't understand. Kill the
-	// Socket interface's dependence on netstack.
+	// Socket interface's dependence on netstack. Pretend we have an empty error
+	// queue.
+	if flags&syscall.MSG_ERRQUEUE != 0 {
+		return 0, 0, nil, 0, socket.ControlMessages{}, syserr.ErrTryAgain
+	}
+
+	// Only allow known and safe flags.
 	if flags&^(syscall.MSG_DONTWAIT|syscall.MSG_PEEK|syscall.MSG_TRUNC) != 0 {
 		return 0, 0, nil, 0, socket.ControlMessages{}, syserr.ErrInvalidArgument
 	}
@@ -540,6 +544,10 @@ func (s *socketOpsCommon) RecvMsg(t *kernel.Task, dst usermem.IOSequence, flags
 
 // SendMsg implements socket.Socket.SendMsg.
 func (s *socketOpsCommon) SendMsg(t *kernel.Task, src usermem.IOSequence, to []byte, flags int, haveDeadline bool, deadline ktime.Time, controlMessages socket.ControlMessages) (int, *syserr.Error) {
+	// SO_ZEROCOPY can't be enabled on host sockets, so MSG_ZEROCOPY is
+	// ignored like Linux does for such sockets.
+	flags &^= linux.MSG_ZEROCOPY
+
 	// Only allow known and safe flags.
 	if flags&^(syscall.MSG_DONTWAIT|syscall.MSG_EOR|syscall.MSG_FASTOPEN|syscall.MSG_MORE|syscall.MSG_NOSIGNAL) != 0 {
 		return 0, syserr.ErrInvalidArgument
@@ -638,12 +646,17 @@ func (s *socketOpsCommon) State() uint32 {
 		// For non-TCP sockets, silently ignore the failure.
 		return 0
 	}
-	if len(buf) != linux.SizeOfTCPInfo {
+	if len(buf) == 0 || len(buf) > linux.SizeOfTCPInfo {
 		// Unmarshal below will panic if getsockopt returns a buffer of
 		// unexpected size.
 		log.Warningf("Failed to get TCP socket info from %+v: getsockopt(2) returned %d bytes, expecting %d bytes.", s, len(buf), linux.SizeOfTCPInfo)
 		return 0
 	}
+	// Older kernels return a shorter structure; the missing trailing
+	// fields are zero.
+	if len(buf) < linux.SizeOfTCPInfo {
+		buf = append(buf, make([]byte, linux.SizeOfTCPInfo-len(buf))...)
+	}
 
 	binary.Unmarshal(buf, usermem.ByteOrder, &info)
 	return uint32(info.State)
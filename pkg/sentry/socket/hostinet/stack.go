@@ -64,6 +64,9 @@ type Stack struct {
 	netSNMPFile    *os.File
 	ipv4Forwarding bool
 	ipv6Forwarding bool
+
+	ipv4NonLocalBind bool
+	ipv6NonLocalBind bool
 }
 
 // NewStack returns an empty Stack containing no configuration.
@@ -130,6 +133,18 @@ func (s *Stack) Configure() error {
 		log.Warningf("Failed to read if ipv6 forwarding is enabled, setting to false")
 	}
 
+	// The host sysctls can't be read once the sandbox is running.
+	if nonLocalBind, err := ioutil.ReadFile("/proc/sys/net/ipv4/ip_nonlocal_bind"); err == nil {
+		s.ipv4NonLocalBind = strings.TrimSpace(string(nonLocalBind)) != "0"
+	} else {
+		log.Warningf("Failed to read if ipv4 non-local bind is enabled, setting to false")
+	}
+	if nonLocalBind, err := ioutil.ReadFile("/proc/sys/net/ipv6/ip_nonlocal_bind"); err == nil {
+		s.ipv6NonLocalBind = strings.TrimSpace(string(nonLocalBind)) != "0"
+	} else {
+		log.Warningf("Failed to read if ipv6 non-local bind is enabled, setting to false")
+	}
+
 	return nil
 }
 
@@ -214,7 +229,7 @@ func ExtractHostRoutes(routeMsgs []syscall.NetlinkMessage) ([]inet.Route, error)
 			DstLen:   ifRoute.Dst_len,
 			SrcLen:   ifRoute.Src_len,
 			TOS:      ifRoute.Tos,
-			Table:    ifRoute.Table,
+			Table:    uint32(ifRoute.Table),
 			Protocol: ifRoute.Protocol,
 			Scope:    ifRoute.Scope,
 			Type:     ifRoute.Type,
@@ -243,6 +258,12 @@ func ExtractHostRoutes(routeMsgs []syscall.NetlinkMessage) ([]inet.Route, error)
 					return nil, fmt.Errorf("RTM_GETROUTE returned RTM_NEWROUTE message with invalid attribute data length (%d bytes, expected %d bytes)", len(attr.Value), expected)
 				}
 				binary.Unmarshal(attr.Value, usermem.ByteOrder, &inetRoute.OutputInterface)
+			case syscall.RTA_PRIORITY:
+				expected := int(binary.Size(inetRoute.Priority))
+				if len(attr.Value) != expected {
+					return nil, fmt.Errorf("RTM_GETROUTE returned RTM_NEWROUTE message with invalid attribute data length (%d bytes, expected %d bytes)", len(attr.Value), expected)
+				}
+				binary.Unmarshal(attr.Value, usermem.ByteOrder, &inetRoute.Priority)
 			}
 		}
 
@@ -333,6 +354,21 @@ func (s *Stack) RemoveInterfaceAddr(int32, inet.InterfaceAddr) error {
 	return syserror.EACCES
 }
 
+// AddVLANInterface implements inet.Stack.AddVLANInterface.
+func (s *Stack) AddVLANInterface(int32, string, uint16, uint16) (int32, error) {
+	return 0, syserror.EACCES
+}
+
+// AddGREInterface implements inet.Stack.AddGREInterface.
+func (s *Stack) AddGREInterface(string, inet.GRETunnel) (int32, error) {
+	return 0, syserror.EACCES
+}
+
+// RemoveInterface implements inet.Stack.RemoveInterface.
+func (s *Stack) RemoveInterface(int32) error {
+	return syserror.EACCES
+}
+
 // SupportsIPv6 implements inet.Stack.SupportsIPv6.
 func (s *Stack) SupportsIPv6() bool {
 	return s.supportsIPv6
@@ -474,6 +510,31 @@ func (s *Stack) RouteTable() []inet.Route {
 	return append([]inet.Route(nil), s.routes...)
 }
 
+// AddRoute implements inet.Stack.AddRoute.
+func (s *Stack) AddRoute(inet.Route, inet.AddRouteOptions) error {
+	return syserror.EACCES
+}
+
+// RemoveRoute implements inet.Stack.RemoveRoute.
+func (s *Stack) RemoveRoute(inet.Route) error {
+	return syserror.EACCES
+}
+
+// RouteRules implements inet.Stack.RouteRules.
+func (s *Stack) RouteRules() []inet.RouteRule {
+	return nil
+}
+
+// AddRouteRule implements inet.Stack.AddRouteRule.
+func (s *Stack) AddRouteRule(inet.RouteRule) error {
+	return syserror.EACCES
+}
+
+// RemoveRouteRule implements inet.Stack.RemoveRouteRule.
+func (s *Stack) RemoveRouteRule(inet.RouteRule) error {
+	return syserror.EACCES
+}
+
 // Resume implements inet.Stack.Resume.
 func (s *Stack) Resume() {}
 
@@ -503,3 +564,36 @@ func (s *Stack) Forwarding(protocol tcpip.NetworkProtocolNumber) bool {
 func (s *Stack) SetForwarding(tcpip.NetworkProtocolNumber, bool) error {
 	return syserror.EACCES
 }
+
+// NonLocalBind implements inet.Stack.NonLocalBind.
+func (s *Stack) NonLocalBind(protocol tcpip.NetworkProtocolNumber) bool {
+	switch protocol {
+	case ipv4.ProtocolNumber:
+		return s.ipv4NonLocalBind
+	case ipv6.ProtocolNumber:
+		return s.ipv6NonLocalBind
+	default:
+		log.Warningf("NonLocalBind(%v) failed: unsupported protocol", protocol)
+		return false
+	}
+}
+
+// SetNonLocalBind implements inet.Stack.SetNonLocalBind.
+func (s *Stack) SetNonLocalBind(tcpip.NetworkProtocolNumber, bool) error {
+	return syserror.EACCES
+}
+
+// Neighbors implements inet.Stack.Neighbors.
+func (s *Stack) Neighbors() map[int32][]inet.Neighbor {
+	return nil
+}
+
+// AddNeighbor implements inet.Stack.AddNeighbor.
+func (s *Stack) AddNeighbor(int32, inet.Neighbor) error {
+	return syserror.EACCES
+}
+
+// RemoveNeighbor implements inet.Stack.RemoveNeighbor.
+func (s *Stack) RemoveNeighbor(int32, inet.Neighbor) error {
+	return syserror.EACCES
+}
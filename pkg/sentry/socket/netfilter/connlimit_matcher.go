@@ -0,0 +1,87 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netfilter
+
+import (
+	"fmt"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/binary"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+func init() {
+	registerMatchMaker(connlimitMarshaler{})
+}
+
+// connlimitMarshaler implements matchMaker for connlimit matching.
+type connlimitMarshaler struct{}
+
+// name implements matchMaker.name.
+func (connlimitMarshaler) name() string {
+	return stack.ConnLimitMatcherName
+}
+
+// revision implements matchMaker.revision.
+func (connlimitMarshaler) revision() uint8 {
+	return 1
+}
+
+// marshal implements matchMaker.marshal.
+func (connlimitMarshaler) marshal(mr stack.Matcher) []byte {
+	opts := mr.(*stack.ConnLimitMatcher).Options()
+	info := linux.XTConnlimitInfo{
+		Limit: opts.Limit,
+	}
+	copy(info.Mask[:], opts.Mask)
+	if opts.Invert {
+		info.Flags |= linux.XT_CONNLIMIT_INVERT
+	}
+	if opts.Destination {
+		info.Flags |= linux.XT_CONNLIMIT_DADDR
+	}
+	buf := make([]byte, 0, linux.SizeOfXTConnlimitInfo)
+	return marshalEntryMatch(stack.ConnLimitMatcherName, binary.Marshal(buf, usermem.ByteOrder, info))
+}
+
+// unmarshal implements matchMaker.unmarshal.
+func (connlimitMarshaler) unmarshal(stk *stack.Stack, buf []byte, filter stack.IPHeaderFilter) (stack.Matcher, error) {
+	if len(buf) < linux.SizeOfXTConnlimitInfo {
+		return nil, fmt.Errorf("buf has insufficient size for connlimit match: %d", len(buf))
+	}
+
+	// For alignment reasons, the match's total size may
+	// exceed what's strictly necessary to hold matchData.
+	var matchData linux.XTConnlimitInfo
+	binary.Unmarshal(buf[:linux.SizeOfXTConnlimitInfo], usermem.ByteOrder, &matchData)
+	nflog("parseMatchers: parsed XTConnlimitInfo: %+v", matchData)
+
+	if matchData.Flags&^(linux.XT_CONNLIMIT_INVERT|linux.XT_CONNLIMIT_DADDR) != 0 {
+		return nil, fmt.Errorf("unsupported connlimit matcher flags set")
+	}
+
+	matcher, err := stack.NewConnLimitMatcher(stk, stack.ConnLimitOptions{
+		Limit:       matchData.Limit,
+		Mask:        tcpip.Address(matchData.Mask[:]),
+		Destination: matchData.Flags&linux.XT_CONNLIMIT_DADDR != 0,
+		Invert:      matchData.Flags&linux.XT_CONNLIMIT_INVERT != 0,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connlimit matcher: %s", err)
+	}
+	return matcher, nil
+}
@@ -39,12 +39,15 @@ type matchMaker interface {
 	// name is the matcher name as stored in the xt_entry_match struct.
 	name() string
 
+	// revision is the version of the matcher.
+	revision() uint8
+
 	// marshal converts from a stack.Matcher to an ABI struct.
 	marshal(matcher stack.Matcher) []byte
 
 	// unmarshal converts from the ABI matcher struct to an
-	// stack.Matcher.
-	unmarshal(buf []byte, filter stack.IPHeaderFilter) (stack.Matcher, error)
+	// stack.Matcher of stk.
+	unmarshal(stk *stack.Stack, buf []byte, filter stack.IPHeaderFilter) (stack.Matcher, error)
 }
 
 // matchMakers maps the name of supported matchers to the matchMaker that
@@ -78,6 +81,7 @@ func marshalEntryMatch(name string, data []byte) []byte {
 	matcher := linux.KernelXTEntryMatch{
 		XTEntryMatch: linux.XTEntryMatch{
 			MatchSize: uint16(size),
+			Revision:  matchMakers[name].revision(),
 		},
 		Data: data,
 	}
@@ -88,12 +92,36 @@ func marshalEntryMatch(name string, data []byte) []byte {
 	return append(buf, make([]byte, size-len(buf))...)
 }
 
-func unmarshalMatcher(match linux.XTEntryMatch, filter stack.IPHeaderFilter, buf []byte) (stack.Matcher, error) {
+// cString returns the string held by the NUL-terminated buf, and whether it
+// is terminated.
+func cString(buf []byte) (string, bool) {
+	for i, c := range buf {
+		if c == 0 {
+			return string(buf[:i]), true
+		}
+	}
+	return "", false
+}
+
+func unmarshalMatcher(stk *stack.Stack, match linux.XTEntryMatch, filter stack.IPHeaderFilter, buf []byte) (stack.Matcher, error) {
 	matchMaker, ok := matchMakers[match.Name.String()]
 	if !ok {
 		return nil, fmt.Errorf("unsupported matcher with name %q", match.Name.String())
 	}
-	return matchMaker.unmarshal(buf, filter)
+	if match.Revision != matchMaker.revision() {
+		return nil, fmt.Errorf("unsupported revision %d of matcher with name %q", match.Revision, match.Name.String())
+	}
+	return matchMaker.unmarshal(stk, buf, filter)
+}
+
+// matchRevision returns the revision of the matcher named name if it is
+// supported in revision rev.
+func matchRevision(name string, rev uint8) (uint8, bool) {
+	matchMaker, ok := matchMakers[name]
+	if !ok || rev > matchMaker.revision() {
+		return 0, false
+	}
+	return matchMaker.revision(), true
 }
 
 // targetMaker knows how to (un)marshal a target. Once registered,
@@ -106,7 +134,7 @@ type targetMaker interface {
 	marshal(target target) []byte
 
 	// unmarshal converts from the ABI matcher struct to a target.
-	unmarshal(buf []byte, filter stack.IPHeaderFilter) (target, *syserr.Error)
+	unmarshal(stk *stack.Stack, buf []byte, filter stack.IPHeaderFilter) (target, *syserr.Error)
 }
 
 // A targetID uniquely identifies a target.
@@ -171,7 +199,7 @@ func marshalTarget(tgt stack.Target) []byte {
 	return targetMaker.marshal(target)
 }
 
-func unmarshalTarget(target linux.XTEntryTarget, filter stack.IPHeaderFilter, buf []byte) (target, *syserr.Error) {
+func unmarshalTarget(stk *stack.Stack, target linux.XTEntryTarget, filter stack.IPHeaderFilter, buf []byte) (target, *syserr.Error) {
 	tid := targetID{
 		name:            target.Name.String(),
 		networkProtocol: filter.NetworkProtocol(),
@@ -182,5 +210,5 @@ func unmarshalTarget(target linux.XTEntryTarget, filter stack.IPHeaderFilter, bu
 		nflog("unsupported target with name %q", target.Name.String())
 		return nil, syserr.ErrInvalidArgument
 	}
-	return targetMaker.unmarshal(buf, filter)
+	return targetMaker.unmarshal(stk, buf, filter)
 }
@@ -0,0 +1,124 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netfilter
+
+import (
+	"fmt"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/binary"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// hashlimitUnit is the unit of linux.XTHashlimitMtinfo1.Avg.
+const hashlimitUnit = time.Second / linux.XT_HASHLIMIT_SCALE
+
+func init() {
+	registerMatchMaker(hashlimitMarshaler{})
+}
+
+// hashlimitMarshaler implements matchMaker for hashlimit matching.
+type hashlimitMarshaler struct{}
+
+// name implements matchMaker.name.
+func (hashlimitMarshaler) name() string {
+	return stack.HashLimitMatcherName
+}
+
+// revision implements matchMaker.revision.
+func (hashlimitMarshaler) revision() uint8 {
+	return 1
+}
+
+// marshal implements matchMaker.marshal.
+func (hashlimitMarshaler) marshal(mr stack.Matcher) []byte {
+	opts := mr.(*stack.HashLimitMatcher).Options()
+	info := linux.XTHashlimitMtinfo1{
+		Avg:        uint32(opts.Interval / hashlimitUnit),
+		Burst:      opts.Burst,
+		Max:        opts.MaxFlows,
+		GCInterval: uint32(opts.GCInterval / time.Millisecond),
+		Expire:     uint32(opts.Expire / time.Millisecond),
+		SrcMask:    opts.SrcPrefixLen,
+		DstMask:    opts.DstPrefixLen,
+	}
+	copy(info.Name[:], opts.Name)
+	if opts.SrcAddr {
+		info.Mode |= linux.XT_HASHLIMIT_HASH_SIP
+	}
+	if opts.DstAddr {
+		info.Mode |= linux.XT_HASHLIMIT_HASH_DIP
+	}
+	if opts.SrcPort {
+		info.Mode |= linux.XT_HASHLIMIT_HASH_SPT
+	}
+	if opts.DstPort {
+		info.Mode |= linux.XT_HASHLIMIT_HASH_DPT
+	}
+	if opts.Above {
+		info.Mode |= linux.XT_HASHLIMIT_INVERT
+	}
+	buf := make([]byte, 0, linux.SizeOfXTHashlimitMtinfo1)
+	return marshalEntryMatch(stack.HashLimitMatcherName, binary.Marshal(buf, usermem.ByteOrder, info))
+}
+
+// unmarshal implements matchMaker.unmarshal.
+func (hashlimitMarshaler) unmarshal(stk *stack.Stack, buf []byte, filter stack.IPHeaderFilter) (stack.Matcher, error) {
+	if len(buf) < linux.SizeOfXTHashlimitMtinfo1 {
+		return nil, fmt.Errorf("buf has insufficient size for hashlimit match: %d", len(buf))
+	}
+
+	// For alignment reasons, the match's total size may
+	// exceed what's strictly necessary to hold matchData.
+	var matchData linux.XTHashlimitMtinfo1
+	binary.Unmarshal(buf[:linux.SizeOfXTHashlimitMtinfo1], usermem.ByteOrder, &matchData)
+	nflog("parseMatchers: parsed XTHashlimitMtinfo1: %+v", matchData)
+
+	const supportedModes = linux.XT_HASHLIMIT_HASH_DIP | linux.XT_HASHLIMIT_HASH_DPT | linux.XT_HASHLIMIT_HASH_SIP | linux.XT_HASHLIMIT_HASH_SPT | linux.XT_HASHLIMIT_INVERT
+	if matchData.Mode&^supportedModes != 0 {
+		return nil, fmt.Errorf("unsupported hashlimit matcher mode set")
+	}
+	name, ok := cString(matchData.Name[:])
+	if !ok {
+		return nil, fmt.Errorf("hashlimit matcher name isn't terminated")
+	}
+	// As in Linux, the table must be garbage collected.
+	if matchData.GCInterval == 0 || matchData.Expire == 0 {
+		return nil, fmt.Errorf("hashlimit matcher has no expiration")
+	}
+
+	matcher, err := stack.NewHashLimitMatcher(stk, stack.HashLimitOptions{
+		Name:            name,
+		NetworkProtocol: filter.NetworkProtocol(),
+		SrcAddr:         matchData.Mode&linux.XT_HASHLIMIT_HASH_SIP != 0,
+		DstAddr:         matchData.Mode&linux.XT_HASHLIMIT_HASH_DIP != 0,
+		SrcPort:         matchData.Mode&linux.XT_HASHLIMIT_HASH_SPT != 0,
+		DstPort:         matchData.Mode&linux.XT_HASHLIMIT_HASH_DPT != 0,
+		SrcPrefixLen:    matchData.SrcMask,
+		DstPrefixLen:    matchData.DstMask,
+		Interval:        time.Duration(matchData.Avg) * hashlimitUnit,
+		Burst:           matchData.Burst,
+		Above:           matchData.Mode&linux.XT_HASHLIMIT_INVERT != 0,
+		MaxFlows:        matchData.Max,
+		Expire:          time.Duration(matchData.Expire) * time.Millisecond,
+		GCInterval:      time.Duration(matchData.GCInterval) * time.Millisecond,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create hashlimit matcher: %s", err)
+	}
+	return matcher, nil
+}
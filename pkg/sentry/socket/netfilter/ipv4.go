@@ -168,7 +168,7 @@ func modifyEntries4(stk *stack.Stack, optVal []byte, replace *linux.IPTReplace,
 			nflog("entry doesn't have enough room for its matchers (only %d bytes remain)", len(optVal))
 			return nil, syserr.ErrInvalidArgument
 		}
-		matchers, err := parseMatchers(filter, optVal[:matchersSize])
+		matchers, err := parseMatchers(stk, filter, optVal[:matchersSize])
 		if err != nil {
 			nflog("failed to parse matchers: %v", err)
 			return nil, syserr.ErrInvalidArgument
@@ -188,7 +188,7 @@ func modifyEntries4(stk *stack.Stack, optVal []byte, replace *linux.IPTReplace,
 		}
 
 		{
-			target, err := parseTarget(filter, optVal[:targetSize], false /* ipv6 */)
+			target, err := parseTarget(stk, filter, optVal[:targetSize], false /* ipv6 */)
 			if err != nil {
 				nflog("failed to parse target: %v", err)
 				return nil, err
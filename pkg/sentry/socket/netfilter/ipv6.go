@@ -171,7 +171,7 @@ func modifyEntries6(stk *stack.Stack, optVal []byte, replace *linux.IPTReplace,
 			nflog("entry doesn't have enough room for its matchers (only %d bytes remain)", len(optVal))
 			return nil, syserr.ErrInvalidArgument
 		}
-		matchers, err := parseMatchers(filter, optVal[:matchersSize])
+		matchers, err := parseMatchers(stk, filter, optVal[:matchersSize])
 		if err != nil {
 			nflog("failed to parse matchers: %v", err)
 			return nil, syserr.ErrInvalidArgument
@@ -191,7 +191,7 @@ func modifyEntries6(stk *stack.Stack, optVal []byte, replace *linux.IPTReplace,
 		}
 
 		{
-			target, err := parseTarget(filter, optVal[:targetSize], true /* ipv6 */)
+			target, err := parseTarget(stk, filter, optVal[:targetSize], true /* ipv6 */)
 			if err != nil {
 				nflog("failed to parse target: %v", err)
 				return nil, err
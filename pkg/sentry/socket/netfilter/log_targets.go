@@ -0,0 +1,214 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netfilter
+
+import (
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/binary"
+	"gvisor.dev/gvisor/pkg/syserr"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+func init() {
+	for _, netProto := range []tcpip.NetworkProtocolNumber{header.IPv4ProtocolNumber, header.IPv6ProtocolNumber} {
+		registerTargetMaker(&logTargetMaker{
+			NetworkProtocol: netProto,
+		})
+		registerTargetMaker(&nflogTargetMaker{
+			NetworkProtocol: netProto,
+		})
+	}
+}
+
+// xtLogTarget is the ABI of LOG targets.
+type xtLogTarget struct {
+	Target linux.XTEntryTarget
+	Info   linux.XTLogInfo
+}
+
+const xtLogTargetSize = linux.SizeOfXTEntryTarget + linux.SizeOfXTLogInfo
+
+// xtNFLogTarget is the ABI of NFLOG targets. It is padded to be 8 byte
+// aligned.
+type xtNFLogTarget struct {
+	Target linux.XTEntryTarget
+	Info   linux.XTNFLogInfo
+	_      [4]byte
+}
+
+const xtNFLogTargetSize = linux.SizeOfXTEntryTarget + linux.SizeOfXTNFLogInfo + 4
+
+type logTarget struct {
+	*stack.LogTarget
+}
+
+func (lt *logTarget) id() targetID {
+	return targetID{
+		name:            stack.LogTargetName,
+		networkProtocol: lt.NetworkProtocol,
+	}
+}
+
+type logTargetMaker struct {
+	NetworkProtocol tcpip.NetworkProtocolNumber
+}
+
+func (lm *logTargetMaker) id() targetID {
+	return targetID{
+		name:            stack.LogTargetName,
+		networkProtocol: lm.NetworkProtocol,
+	}
+}
+
+func (*logTargetMaker) marshal(target target) []byte {
+	opts := target.(*logTarget).Options()
+	xt := xtLogTarget{
+		Target: linux.XTEntryTarget{
+			TargetSize: xtLogTargetSize,
+		},
+		Info: linux.XTLogInfo{
+			Level: opts.Level,
+		},
+	}
+	copy(xt.Target.Name[:], stack.LogTargetName)
+	copy(xt.Info.Prefix[:], opts.Prefix)
+	for _, flag := range []struct {
+		set  bool
+		flag uint8
+	}{
+		{opts.TCPSequence, linux.XT_LOG_TCPSEQ},
+		{opts.TCPOptions, linux.XT_LOG_TCPOPT},
+		{opts.IPOptions, linux.XT_LOG_IPOPT},
+		{opts.UID, linux.XT_LOG_UID},
+		{opts.MACDecode, linux.XT_LOG_MACDECODE},
+	} {
+		if flag.set {
+			xt.Info.LogFlags |= flag.flag
+		}
+	}
+
+	ret := make([]byte, 0, xtLogTargetSize)
+	return binary.Marshal(ret, usermem.ByteOrder, xt)
+}
+
+func (*logTargetMaker) unmarshal(stk *stack.Stack, buf []byte, filter stack.IPHeaderFilter) (target, *syserr.Error) {
+	if len(buf) < xtLogTargetSize {
+		nflog("logTargetMaker: buf has insufficient size for LOG target %d", len(buf))
+		return nil, syserr.ErrInvalidArgument
+	}
+	var xt xtLogTarget
+	binary.Unmarshal(buf[:xtLogTargetSize], usermem.ByteOrder, &xt)
+
+	prefix, ok := cString(xt.Info.Prefix[:])
+	if !ok {
+		nflog("logTargetMaker: prefix isn't NUL-terminated")
+		return nil, syserr.ErrInvalidArgument
+	}
+	flags := xt.Info.LogFlags
+	lt, err := stack.NewLogTarget(stk, filter.NetworkProtocol(), stack.LogOptions{
+		Level:       xt.Info.Level,
+		Prefix:      prefix,
+		TCPSequence: flags&linux.XT_LOG_TCPSEQ != 0,
+		TCPOptions:  flags&linux.XT_LOG_TCPOPT != 0,
+		IPOptions:   flags&linux.XT_LOG_IPOPT != 0,
+		UID:         flags&linux.XT_LOG_UID != 0,
+		MACDecode:   flags&linux.XT_LOG_MACDECODE != 0,
+	})
+	if err != nil {
+		nflog("logTargetMaker: failed to create LOG target: %s", err)
+		return nil, syserr.TranslateNetstackError(err)
+	}
+	return &logTarget{lt}, nil
+}
+
+type nflogTarget struct {
+	*stack.NFLogTarget
+}
+
+func (nt *nflogTarget) id() targetID {
+	return targetID{
+		name:            stack.NFLogTargetName,
+		networkProtocol: nt.NetworkProtocol,
+	}
+}
+
+type nflogTargetMaker struct {
+	NetworkProtocol tcpip.NetworkProtocolNumber
+}
+
+func (nm *nflogTargetMaker) id() targetID {
+	return targetID{
+		name:            stack.NFLogTargetName,
+		networkProtocol: nm.NetworkProtocol,
+	}
+}
+
+func (*nflogTargetMaker) marshal(target target) []byte {
+	opts := target.(*nflogTarget).Options()
+	xt := xtNFLogTarget{
+		Target: linux.XTEntryTarget{
+			TargetSize: xtNFLogTargetSize,
+		},
+		Info: linux.XTNFLogInfo{
+			Len:       opts.CopyLen,
+			Group:     opts.Group,
+			Threshold: opts.Threshold,
+		},
+	}
+	copy(xt.Target.Name[:], stack.NFLogTargetName)
+	copy(xt.Info.Prefix[:], opts.Prefix)
+	if opts.HasCopyLen {
+		xt.Info.Flags |= linux.XT_NFLOG_F_COPY_LEN
+	}
+
+	ret := make([]byte, 0, xtNFLogTargetSize)
+	return binary.Marshal(ret, usermem.ByteOrder, xt)
+}
+
+func (*nflogTargetMaker) unmarshal(stk *stack.Stack, buf []byte, filter stack.IPHeaderFilter) (target, *syserr.Error) {
+	// Linux only requires the fields of struct xt_nflog_info, without the
+	// padding.
+	if size := linux.SizeOfXTEntryTarget + linux.SizeOfXTNFLogInfo; len(buf) < size {
+		nflog("nflogTargetMaker: buf has insufficient size for NFLOG target %d", len(buf))
+		return nil, syserr.ErrInvalidArgument
+	}
+	var info linux.XTNFLogInfo
+	binary.Unmarshal(buf[linux.SizeOfXTEntryTarget:][:linux.SizeOfXTNFLogInfo], usermem.ByteOrder, &info)
+
+	if info.Flags&^linux.XT_NFLOG_MASK != 0 {
+		nflog("nflogTargetMaker: unknown flags %#x", info.Flags)
+		return nil, syserr.ErrInvalidArgument
+	}
+	prefix, ok := cString(info.Prefix[:])
+	if !ok {
+		nflog("nflogTargetMaker: prefix isn't NUL-terminated")
+		return nil, syserr.ErrInvalidArgument
+	}
+	nt, err := stack.NewNFLogTarget(stk, filter.NetworkProtocol(), stack.NFLogOptions{
+		Group:      info.Group,
+		Prefix:     prefix,
+		HasCopyLen: info.Flags&linux.XT_NFLOG_F_COPY_LEN != 0,
+		CopyLen:    info.Len,
+		Threshold:  info.Threshold,
+	})
+	if err != nil {
+		nflog("nflogTargetMaker: failed to create NFLOG target: %s", err)
+		return nil, syserr.TranslateNetstackError(err)
+	}
+	return &nflogTarget{nt}, nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netfilter
+
+import (
+	"fmt"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/binary"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+func init() {
+	registerMatchMaker(markMarshaler{})
+	registerMatchMaker(connmarkMarshaler{})
+}
+
+// markMarshaler implements matchMaker for mark matching.
+type markMarshaler struct{}
+
+// name implements matchMaker.name.
+func (markMarshaler) name() string {
+	return stack.MarkMatcherName
+}
+
+// revision implements matchMaker.revision.
+func (markMarshaler) revision() uint8 {
+	return 1
+}
+
+// marshal implements matchMaker.marshal.
+func (markMarshaler) marshal(mr stack.Matcher) []byte {
+	matcher := mr.(*stack.MarkMatcher)
+	info := linux.XTMarkMtinfo1{
+		Mark: matcher.Mark,
+		Mask: matcher.Mask,
+	}
+	if matcher.Invert {
+		info.Invert = 1
+	}
+	buf := make([]byte, 0, linux.SizeOfXTMarkMtinfo1)
+	return marshalEntryMatch(stack.MarkMatcherName, binary.Marshal(buf, usermem.ByteOrder, info))
+}
+
+// unmarshal implements matchMaker.unmarshal.
+func (markMarshaler) unmarshal(_ *stack.Stack, buf []byte, filter stack.IPHeaderFilter) (stack.Matcher, error) {
+	if len(buf) < linux.SizeOfXTMarkMtinfo1 {
+		return nil, fmt.Errorf("buf has insufficient size for mark match: %d", len(buf))
+	}
+
+	// For alignment reasons, the match's total size may
+	// exceed what's strictly necessary to hold matchData.
+	var matchData linux.XTMarkMtinfo1
+	binary.Unmarshal(buf[:linux.SizeOfXTMarkMtinfo1], usermem.ByteOrder, &matchData)
+	nflog("parseMatchers: parsed XTMarkMtinfo1: %+v", matchData)
+
+	return &stack.MarkMatcher{
+		Mark:   matchData.Mark,
+		Mask:   matchData.Mask,
+		Invert: matchData.Invert != 0,
+	}, nil
+}
+
+// connmarkMarshaler implements matchMaker for connmark matching.
+type connmarkMarshaler struct{}
+
+// name implements matchMaker.name.
+func (connmarkMarshaler) name() string {
+	return stack.ConnMarkMatcherName
+}
+
+// revision implements matchMaker.revision.
+func (connmarkMarshaler) revision() uint8 {
+	return 1
+}
+
+// marshal implements matchMaker.marshal.
+func (connmarkMarshaler) marshal(mr stack.Matcher) []byte {
+	opts := mr.(*stack.ConnMarkMatcher).Options()
+	info := linux.XTConnmarkMtinfo1{
+		Mark: opts.Mark,
+		Mask: opts.Mask,
+	}
+	if opts.Invert {
+		info.Invert = 1
+	}
+	buf := make([]byte, 0, linux.SizeOfXTConnmarkMtinfo1)
+	return marshalEntryMatch(stack.ConnMarkMatcherName, binary.Marshal(buf, usermem.ByteOrder, info))
+}
+
+// unmarshal implements matchMaker.unmarshal.
+func (connmarkMarshaler) unmarshal(stk *stack.Stack, buf []byte, filter stack.IPHeaderFilter) (stack.Matcher, error) {
+	if len(buf) < linux.SizeOfXTConnmarkMtinfo1 {
+		return nil, fmt.Errorf("buf has insufficient size for connmark match: %d", len(buf))
+	}
+
+	// For alignment reasons, the match's total size may
+	// exceed what's strictly necessary to hold matchData.
+	var matchData linux.XTConnmarkMtinfo1
+	binary.Unmarshal(buf[:linux.SizeOfXTConnmarkMtinfo1], usermem.ByteOrder, &matchData)
+	nflog("parseMatchers: parsed XTConnmarkMtinfo1: %+v", matchData)
+
+	return stack.NewConnMarkMatcher(stk, stack.ConnMarkOptions{
+		Mark:   matchData.Mark,
+		Mask:   matchData.Mask,
+		Invert: matchData.Invert != 0,
+	}), nil
+}
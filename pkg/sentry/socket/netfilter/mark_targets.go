@@ -0,0 +1,197 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netfilter
+
+import (
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/binary"
+	"gvisor.dev/gvisor/pkg/syserr"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// Revisions of the MARK and CONNMARK targets. Earlier revisions of MARK only
+// exist for IPv4 and are obsolete.
+const (
+	markTargetRevision     = 2
+	connMarkTargetRevision = 1
+)
+
+func init() {
+	for _, netProto := range []tcpip.NetworkProtocolNumber{header.IPv4ProtocolNumber, header.IPv6ProtocolNumber} {
+		registerTargetMaker(&markTargetMaker{
+			NetworkProtocol: netProto,
+		})
+		registerTargetMaker(&connMarkTargetMaker{
+			NetworkProtocol: netProto,
+		})
+	}
+}
+
+// xtMarkTarget is the ABI of MARK targets.
+type xtMarkTarget struct {
+	Target linux.XTEntryTarget
+	Info   linux.XTMarkTginfo2
+}
+
+const xtMarkTargetSize = linux.SizeOfXTEntryTarget + linux.SizeOfXTMarkTginfo2
+
+// xtConnMarkTarget is the ABI of CONNMARK targets.
+type xtConnMarkTarget struct {
+	Target linux.XTEntryTarget
+	Info   linux.XTConnmarkTginfo1
+}
+
+const xtConnMarkTargetSize = linux.SizeOfXTEntryTarget + linux.SizeOfXTConnmarkTginfo1
+
+type markTarget struct {
+	stack.MarkTarget
+}
+
+func (mt *markTarget) id() targetID {
+	return targetID{
+		name:            stack.MarkTargetName,
+		networkProtocol: mt.NetworkProtocol,
+		revision:        markTargetRevision,
+	}
+}
+
+type markTargetMaker struct {
+	NetworkProtocol tcpip.NetworkProtocolNumber
+}
+
+func (mm *markTargetMaker) id() targetID {
+	return targetID{
+		name:            stack.MarkTargetName,
+		networkProtocol: mm.NetworkProtocol,
+		revision:        markTargetRevision,
+	}
+}
+
+func (*markTargetMaker) marshal(target target) []byte {
+	mt := target.(*markTarget)
+	xt := xtMarkTarget{
+		Target: linux.XTEntryTarget{
+			TargetSize: xtMarkTargetSize,
+			Revision:   markTargetRevision,
+		},
+		Info: linux.XTMarkTginfo2{
+			Mark: mt.Mark,
+			Mask: mt.Mask,
+		},
+	}
+	copy(xt.Target.Name[:], stack.MarkTargetName)
+
+	ret := make([]byte, 0, xtMarkTargetSize)
+	return binary.Marshal(ret, usermem.ByteOrder, xt)
+}
+
+func (*markTargetMaker) unmarshal(_ *stack.Stack, buf []byte, filter stack.IPHeaderFilter) (target, *syserr.Error) {
+	if len(buf) < xtMarkTargetSize {
+		nflog("markTargetMaker: buf has insufficient size for MARK target %d", len(buf))
+		return nil, syserr.ErrInvalidArgument
+	}
+	var xt xtMarkTarget
+	binary.Unmarshal(buf[:xtMarkTargetSize], usermem.ByteOrder, &xt)
+
+	return &markTarget{stack.MarkTarget{
+		Mark:            xt.Info.Mark,
+		Mask:            xt.Info.Mask,
+		NetworkProtocol: filter.NetworkProtocol(),
+	}}, nil
+}
+
+type connMarkTarget struct {
+	stack.ConnMarkTarget
+}
+
+func (ct *connMarkTarget) id() targetID {
+	return targetID{
+		name:            stack.ConnMarkTargetName,
+		networkProtocol: ct.NetworkProtocol,
+		revision:        connMarkTargetRevision,
+	}
+}
+
+type connMarkTargetMaker struct {
+	NetworkProtocol tcpip.NetworkProtocolNumber
+}
+
+func (cm *connMarkTargetMaker) id() targetID {
+	return targetID{
+		name:            stack.ConnMarkTargetName,
+		networkProtocol: cm.NetworkProtocol,
+		revision:        connMarkTargetRevision,
+	}
+}
+
+func (*connMarkTargetMaker) marshal(target target) []byte {
+	ct := target.(*connMarkTarget)
+	xt := xtConnMarkTarget{
+		Target: linux.XTEntryTarget{
+			TargetSize: xtConnMarkTargetSize,
+			Revision:   connMarkTargetRevision,
+		},
+		Info: linux.XTConnmarkTginfo1{
+			CTMark: ct.CTMark,
+			CTMask: ct.CTMask,
+			NFMask: ct.NFMask,
+		},
+	}
+	copy(xt.Target.Name[:], stack.ConnMarkTargetName)
+	switch ct.Mode {
+	case stack.ConnMarkSet:
+		xt.Info.Mode = linux.XT_CONNMARK_SET
+	case stack.ConnMarkSave:
+		xt.Info.Mode = linux.XT_CONNMARK_SAVE
+	case stack.ConnMarkRestore:
+		xt.Info.Mode = linux.XT_CONNMARK_RESTORE
+	}
+
+	ret := make([]byte, 0, xtConnMarkTargetSize)
+	return binary.Marshal(ret, usermem.ByteOrder, xt)
+}
+
+func (*connMarkTargetMaker) unmarshal(_ *stack.Stack, buf []byte, filter stack.IPHeaderFilter) (target, *syserr.Error) {
+	if len(buf) < xtConnMarkTargetSize {
+		nflog("connMarkTargetMaker: buf has insufficient size for CONNMARK target %d", len(buf))
+		return nil, syserr.ErrInvalidArgument
+	}
+	var xt xtConnMarkTarget
+	binary.Unmarshal(buf[:xtConnMarkTargetSize], usermem.ByteOrder, &xt)
+
+	var mode stack.ConnMarkMode
+	switch xt.Info.Mode {
+	case linux.XT_CONNMARK_SET:
+		mode = stack.ConnMarkSet
+	case linux.XT_CONNMARK_SAVE:
+		mode = stack.ConnMarkSave
+	case linux.XT_CONNMARK_RESTORE:
+		mode = stack.ConnMarkRestore
+	default:
+		nflog("connMarkTargetMaker: unknown mode %d", xt.Info.Mode)
+		return nil, syserr.ErrInvalidArgument
+	}
+
+	return &connMarkTarget{stack.ConnMarkTarget{
+		Mode:            mode,
+		CTMark:          xt.Info.CTMark,
+		CTMask:          xt.Info.CTMask,
+		NFMask:          xt.Info.NFMask,
+		NetworkProtocol: filter.NetworkProtocol(),
+	}}, nil
+}
@@ -37,6 +37,11 @@ func (ownerMarshaler) name() string {
 	return matcherNameOwner
 }
 
+// revision implements matchMaker.revision.
+func (ownerMarshaler) revision() uint8 {
+	return 0
+}
+
 // marshal implements matchMaker.marshal.
 func (ownerMarshaler) marshal(mr stack.Matcher) []byte {
 	matcher := mr.(*OwnerMatcher)
@@ -64,7 +69,7 @@ func (ownerMarshaler) marshal(mr stack.Matcher) []byte {
 }
 
 // unmarshal implements matchMaker.unmarshal.
-func (ownerMarshaler) unmarshal(buf []byte, filter stack.IPHeaderFilter) (stack.Matcher, error) {
+func (ownerMarshaler) unmarshal(_ *stack.Stack, buf []byte, filter stack.IPHeaderFilter) (stack.Matcher, error) {
 	if len(buf) < linux.SizeOfIPTOwnerInfo {
 		return nil, fmt.Errorf("buf has insufficient size for owner match: %d", len(buf))
 	}
@@ -0,0 +1,227 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netfilter
+
+import (
+	"fmt"
+	"strings"
+
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// PacketLogger implements stack.PacketLogger by writing the packets logged by
+// LOG rules to the sentry log, in the format of Linux's nf_log_syslog.
+type PacketLogger struct{}
+
+var _ stack.PacketLogger = PacketLogger{}
+
+// LogPacket implements stack.PacketLogger.LogPacket.
+func (PacketLogger) LogPacket(opts stack.LogOptions, pl *stack.PacketLog) {
+	msg := FormatPacketLog(opts, pl)
+	switch {
+	case opts.Level <= 4:
+		log.Warningf("%s", msg)
+	case opts.Level <= 6:
+		log.Infof("%s", msg)
+	default:
+		log.Debugf("%s", msg)
+	}
+}
+
+// FormatPacketLog returns the line Linux logs for a packet logged by a LOG
+// rule configured with opts.
+func FormatPacketLog(opts stack.LogOptions, pl *stack.PacketLog) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%sIN=%s OUT=%s ", opts.Prefix, pl.InputInterface, pl.OutputInterface)
+	if pl.InputInterface != "" {
+		formatLinkHeader(&b, opts, pl.LinkHeader)
+	}
+	switch pl.NetworkProtocol {
+	case header.IPv4ProtocolNumber:
+		formatIPv4(&b, opts, pl.Packet)
+	case header.IPv6ProtocolNumber:
+		formatIPv6(&b, opts, pl.Packet)
+	}
+	if opts.UID && pl.Owner != nil {
+		fmt.Fprintf(&b, "UID=%d GID=%d ", pl.Owner.UID(), pl.Owner.GID())
+	}
+	if pl.Mark != 0 {
+		fmt.Fprintf(&b, "MARK=0x%x ", pl.Mark)
+	}
+	return b.String()
+}
+
+// formatLinkHeader formats the link header of a received packet. See
+// net/netfilter/nf_log_syslog.c:dump_mac_header.
+func formatLinkHeader(b *strings.Builder, opts stack.LogOptions, hdr buffer.View) {
+	if opts.MACDecode && len(hdr) == header.EthernetMinimumSize {
+		eth := header.Ethernet(hdr)
+		fmt.Fprintf(b, "MACSRC=%s MACDST=%s MACPROTO=%04x ", eth.SourceAddress(), eth.DestinationAddress(), uint16(eth.Type()))
+		return
+	}
+	b.WriteString("MAC=")
+	for i, c := range hdr {
+		if i != 0 {
+			b.WriteByte(':')
+		}
+		fmt.Fprintf(b, "%02x", c)
+	}
+	b.WriteByte(' ')
+}
+
+// formatOptions formats the options of a header, if any.
+func formatOptions(b *strings.Builder, opts []byte) {
+	if len(opts) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "OPT (%X) ", opts)
+}
+
+// formatIPv4 formats an IPv4 packet. See
+// net/netfilter/nf_log_syslog.c:dump_ipv4_packet.
+func formatIPv4(b *strings.Builder, opts stack.LogOptions, pkt buffer.View) {
+	if len(pkt) < header.IPv4MinimumSize {
+		b.WriteString("TRUNCATED")
+		return
+	}
+	h := header.IPv4(pkt)
+	tos, _ := h.TOS()
+	fmt.Fprintf(b, "SRC=%s DST=%s LEN=%d TOS=0x%02X PREC=0x%02X TTL=%d ID=%d ", h.SourceAddress(), h.DestinationAddress(), h.TotalLength(), tos&0x1e, tos&0xe0, h.TTL(), h.ID())
+
+	// The highest flag is reserved, Linux logs it as CE.
+	flags := h.Flags()
+	if flags&0x4 != 0 {
+		b.WriteString("CE ")
+	}
+	if flags&header.IPv4FlagDontFragment != 0 {
+		b.WriteString("DF ")
+	}
+	if flags&header.IPv4FlagMoreFragments != 0 {
+		b.WriteString("MF ")
+	}
+	offset := h.FragmentOffset()
+	if offset != 0 {
+		fmt.Fprintf(b, "FRAG:%d ", offset/8)
+	}
+
+	hdrLen := int(h.HeaderLength())
+	if hdrLen < header.IPv4MinimumSize || hdrLen > len(pkt) {
+		return
+	}
+	if opts.IPOptions {
+		formatOptions(b, pkt[header.IPv4MinimumSize:hdrLen])
+	}
+
+	// Only the first fragments have transport headers.
+	if offset != 0 {
+		return
+	}
+	formatTransport(b, opts, h.TransportProtocol(), pkt[hdrLen:])
+}
+
+// formatIPv6 formats an IPv6 packet. Unlike Linux, extension headers aren't
+// parsed. See net/netfilter/nf_log_syslog.c:dump_ipv6_packet.
+func formatIPv6(b *strings.Builder, opts stack.LogOptions, pkt buffer.View) {
+	if len(pkt) < header.IPv6MinimumSize {
+		b.WriteString("TRUNCATED")
+		return
+	}
+	h := header.IPv6(pkt)
+	tc, flowLabel := h.TOS()
+	fmt.Fprintf(b, "SRC=%s DST=%s LEN=%d TC=%d HOPLIMIT=%d FLOWLBL=%d ", h.SourceAddress(), h.DestinationAddress(), int(h.PayloadLength())+header.IPv6MinimumSize, tc, h.HopLimit(), flowLabel)
+	formatTransport(b, opts, tcpip.TransportProtocolNumber(h.NextHeader()), pkt[header.IPv6MinimumSize:])
+}
+
+// formatTransport formats the transport header of a packet.
+func formatTransport(b *strings.Builder, opts stack.LogOptions, proto tcpip.TransportProtocolNumber, payload buffer.View) {
+	switch proto {
+	case header.TCPProtocolNumber:
+		b.WriteString("PROTO=TCP ")
+		if len(payload) < header.TCPMinimumSize {
+			fmt.Fprintf(b, "INCOMPLETE [%d bytes] ", len(payload))
+			return
+		}
+		tcp := header.TCP(payload)
+		fmt.Fprintf(b, "SPT=%d DPT=%d ", tcp.SourcePort(), tcp.DestinationPort())
+		if opts.TCPSequence {
+			fmt.Fprintf(b, "SEQ=%d ACK=%d ", tcp.SequenceNumber(), tcp.AckNumber())
+		}
+		// The reserved bits are the low bits of the data offset byte.
+		fmt.Fprintf(b, "WINDOW=%d RES=0x%02x ", tcp.WindowSize(), (payload[header.TCPDataOffset]&0x0f)<<2)
+		for _, flag := range []struct {
+			flag uint8
+			name string
+		}{
+			{header.TCPFlagCwr, "CWR"},
+			{header.TCPFlagEce, "ECE"},
+			{header.TCPFlagUrg, "URG"},
+			{header.TCPFlagAck, "ACK"},
+			{header.TCPFlagPsh, "PSH"},
+			{header.TCPFlagRst, "RST"},
+			{header.TCPFlagSyn, "SYN"},
+			{header.TCPFlagFin, "FIN"},
+		} {
+			if tcp.Flags()&flag.flag != 0 {
+				b.WriteString(flag.name + " ")
+			}
+		}
+		fmt.Fprintf(b, "URGP=%d ", tcp.UrgentPointer())
+		if offset := int(tcp.DataOffset()); opts.TCPOptions && offset > header.TCPMinimumSize && offset <= len(payload) {
+			formatOptions(b, payload[header.TCPMinimumSize:offset])
+		}
+
+	case header.UDPProtocolNumber:
+		b.WriteString("PROTO=UDP ")
+		if len(payload) < header.UDPMinimumSize {
+			fmt.Fprintf(b, "INCOMPLETE [%d bytes] ", len(payload))
+			return
+		}
+		udp := header.UDP(payload)
+		fmt.Fprintf(b, "SPT=%d DPT=%d LEN=%d ", udp.SourcePort(), udp.DestinationPort(), udp.Length())
+
+	case header.ICMPv4ProtocolNumber:
+		b.WriteString("PROTO=ICMP ")
+		if len(payload) < header.ICMPv4MinimumSize {
+			fmt.Fprintf(b, "INCOMPLETE [%d bytes] ", len(payload))
+			return
+		}
+		icmp := header.ICMPv4(payload)
+		fmt.Fprintf(b, "TYPE=%d CODE=%d ", icmp.Type(), icmp.Code())
+		switch icmp.Type() {
+		case header.ICMPv4Echo, header.ICMPv4EchoReply:
+			fmt.Fprintf(b, "ID=%d SEQ=%d ", icmp.Ident(), icmp.Sequence())
+		}
+
+	case header.ICMPv6ProtocolNumber:
+		b.WriteString("PROTO=ICMPv6 ")
+		if len(payload) < header.ICMPv6MinimumSize {
+			fmt.Fprintf(b, "INCOMPLETE [%d bytes] ", len(payload))
+			return
+		}
+		icmp := header.ICMPv6(payload)
+		fmt.Fprintf(b, "TYPE=%d CODE=%d ", icmp.Type(), icmp.Code())
+		switch icmp.Type() {
+		case header.ICMPv6EchoRequest, header.ICMPv6EchoReply:
+			fmt.Fprintf(b, "ID=%d SEQ=%d ", icmp.Ident(), icmp.Sequence())
+		}
+
+	default:
+		fmt.Fprintf(b, "PROTO=%d ", proto)
+	}
+}
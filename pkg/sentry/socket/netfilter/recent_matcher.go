@@ -0,0 +1,125 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netfilter
+
+import (
+	"fmt"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/binary"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+func init() {
+	registerMatchMaker(recentMarshaler{})
+}
+
+// recentCommands maps the flags of the commands of recent matchers to the
+// commands.
+var recentCommands = map[uint8]stack.RecentCommand{
+	linux.XT_RECENT_CHECK:  stack.RecentCheck,
+	linux.XT_RECENT_SET:    stack.RecentSet,
+	linux.XT_RECENT_UPDATE: stack.RecentUpdate,
+	linux.XT_RECENT_REMOVE: stack.RecentRemove,
+}
+
+// recentMarshaler implements matchMaker for recent matching.
+type recentMarshaler struct{}
+
+// name implements matchMaker.name.
+func (recentMarshaler) name() string {
+	return stack.RecentMatcherName
+}
+
+// revision implements matchMaker.revision.
+func (recentMarshaler) revision() uint8 {
+	return 1
+}
+
+// marshal implements matchMaker.marshal.
+func (recentMarshaler) marshal(mr stack.Matcher) []byte {
+	opts := mr.(*stack.RecentMatcher).Options()
+	info := linux.XTRecentMtinfoV1{
+		Seconds:  uint32(opts.Within / time.Second),
+		HitCount: opts.HitCount,
+		Side:     linux.XT_RECENT_SOURCE,
+	}
+	copy(info.Name[:], opts.Name)
+	copy(info.Mask[:], opts.Mask)
+	for flag, command := range recentCommands {
+		if command == opts.Command {
+			info.CheckSet = flag
+		}
+	}
+	if opts.TTL {
+		info.CheckSet |= linux.XT_RECENT_TTL
+	}
+	if opts.Reap {
+		info.CheckSet |= linux.XT_RECENT_REAP
+	}
+	if opts.Destination {
+		info.Side = linux.XT_RECENT_DEST
+	}
+	if opts.Invert {
+		info.Invert = 1
+	}
+	buf := make([]byte, 0, linux.SizeOfXTRecentMtinfoV1)
+	return marshalEntryMatch(stack.RecentMatcherName, binary.Marshal(buf, usermem.ByteOrder, info))
+}
+
+// unmarshal implements matchMaker.unmarshal.
+func (recentMarshaler) unmarshal(stk *stack.Stack, buf []byte, filter stack.IPHeaderFilter) (stack.Matcher, error) {
+	if len(buf) < linux.SizeOfXTRecentMtinfoV1 {
+		return nil, fmt.Errorf("buf has insufficient size for recent match: %d", len(buf))
+	}
+
+	// For alignment reasons, the match's total size may
+	// exceed what's strictly necessary to hold matchData.
+	var matchData linux.XTRecentMtinfoV1
+	binary.Unmarshal(buf[:linux.SizeOfXTRecentMtinfoV1], usermem.ByteOrder, &matchData)
+	nflog("parseMatchers: parsed XTRecentMtinfoV1: %+v", matchData)
+
+	if matchData.CheckSet&^linux.XT_RECENT_VALID_FLAGS != 0 {
+		return nil, fmt.Errorf("unsupported recent matcher flags set")
+	}
+	// Exactly one command must be set.
+	command, ok := recentCommands[matchData.CheckSet&^linux.XT_RECENT_MODIFIERS]
+	if !ok {
+		return nil, fmt.Errorf("recent matcher has no single command")
+	}
+	name, ok := cString(matchData.Name[:])
+	if !ok {
+		return nil, fmt.Errorf("recent matcher name isn't terminated")
+	}
+
+	matcher, err := stack.NewRecentMatcher(stk, stack.RecentOptions{
+		Name:        name,
+		Command:     command,
+		Within:      time.Duration(matchData.Seconds) * time.Second,
+		HitCount:    matchData.HitCount,
+		Reap:        matchData.CheckSet&linux.XT_RECENT_REAP != 0,
+		TTL:         matchData.CheckSet&linux.XT_RECENT_TTL != 0,
+		Destination: matchData.Side == linux.XT_RECENT_DEST,
+		Mask:        tcpip.Address(matchData.Mask[:]),
+		Invert:      matchData.Invert != 0,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recent matcher: %s", err)
+	}
+	return matcher, nil
+}
@@ -0,0 +1,358 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nfnetlink provides a NETLINK_NETFILTER socket protocol.
+//
+// Only the nfnetlink_log subsystem is supported, through which sockets bind
+// to NFLOG groups and receive the packets logged to them by NFLOG rules.
+package nfnetlink
+
+import (
+	"sync/atomic"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/binary"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/socket/netlink"
+	"gvisor.dev/gvisor/pkg/sentry/socket/netstack"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/syserr"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// globalSeq is the sequence number of the packets sent to the instances with
+// the NFULNL_CFG_F_SEQ_GLOBAL flag.
+var globalSeq uint32
+
+// instance is the configuration of an NFLOG group a socket is bound to, like
+// Linux's nfulnl_instance.
+type instance struct {
+	copyMode  uint8
+	copyRange uint32
+	flags     uint16
+
+	// seq is the sequence number of the packets sent to the instance.
+	seq uint32
+}
+
+// Protocol implements netlink.Protocol and stack.NFLogListener.
+//
+// +stateify savable
+type Protocol struct {
+	k     *kernel.Kernel
+	stack *netstack.Stack
+
+	// sender sends the logged packets to the socket.
+	sender netlink.Sender
+
+	mu sync.Mutex `state:"nosave"`
+
+	// instances maps the groups the socket is bound to to their
+	// configuration. It is protected by mu.
+	//
+	// TODO(gvisor.dev/issue/170): Restore the bindings of the groups.
+	instances map[uint16]*instance `state:"nosave"`
+}
+
+var _ netlink.AsyncProtocol = (*Protocol)(nil)
+var _ stack.NFLogListener = (*Protocol)(nil)
+
+// NewProtocol creates a NETLINK_NETFILTER netlink.Protocol.
+func NewProtocol(t *kernel.Task) (netlink.Protocol, *syserr.Error) {
+	// Only netstack has iptables.
+	stk, ok := t.NetworkContext().(*netstack.Stack)
+	if !ok {
+		return nil, syserr.ErrProtocolNotSupported
+	}
+	return &Protocol{
+		k:     t.Kernel(),
+		stack: stk,
+	}, nil
+}
+
+// Protocol implements netlink.Protocol.Protocol.
+func (p *Protocol) Protocol() int {
+	return linux.NETLINK_NETFILTER
+}
+
+// CanSend implements netlink.Protocol.CanSend.
+func (p *Protocol) CanSend() bool {
+	return true
+}
+
+// Attach implements netlink.AsyncProtocol.Attach.
+func (p *Protocol) Attach(s netlink.Sender) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sender = s
+}
+
+// Release implements netlink.AsyncProtocol.Release.
+func (p *Protocol) Release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for group := range p.instances {
+		p.stack.Stack.UnbindNFLogGroup(group, p)
+	}
+	p.instances = nil
+	p.sender = nil
+}
+
+// ProcessMessage implements netlink.Protocol.ProcessMessage.
+func (p *Protocol) ProcessMessage(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	// All messages require CAP_NET_ADMIN. See
+	// net/netfilter/nfnetlink.c:nfnetlink_rcv.
+	creds := auth.CredentialsFromContext(ctx)
+	if !creds.HasCapability(linux.CAP_NET_ADMIN) {
+		return syserr.ErrPermissionDenied
+	}
+
+	var genMsg linux.NetfilterGenMessage
+	attrs, ok := msg.GetData(&genMsg)
+	if !ok {
+		return syserr.ErrInvalidArgument
+	}
+
+	typ := msg.Header().Type
+	if typ>>8 != linux.NFNL_SUBSYS_ULOG {
+		return syserr.ErrInvalidArgument
+	}
+	switch typ & 0xff {
+	case linux.NFULNL_MSG_CONFIG:
+		return p.configure(ntohs(genMsg.ResID), attrs)
+	case linux.NFULNL_MSG_PACKET:
+		return syserr.ErrNotSupported
+	default:
+		return syserr.ErrInvalidArgument
+	}
+}
+
+// configure handles NFULNL_MSG_CONFIG messages, which bind the socket to
+// group, unbind it or configure how packets are sent. See
+// net/netfilter/nfnetlink_log.c:nfulnl_recv_config.
+func (p *Protocol) configure(group uint16, attrs netlink.AttrsView) *syserr.Error {
+	var (
+		cmd   *uint8
+		mode  *linux.NfulnlMsgConfigMode
+		flags *uint16
+	)
+	for !attrs.Empty() {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
+			return syserr.ErrInvalidArgument
+		}
+		attrs = rest
+
+		switch ahdr.Type {
+		case linux.NFULA_CFG_CMD:
+			if len(value) < 1 {
+				return syserr.ErrInvalidArgument
+			}
+			cmd = &value[0]
+		case linux.NFULA_CFG_MODE:
+			if len(value) < linux.NfulnlMsgConfigModeSize {
+				return syserr.ErrInvalidArgument
+			}
+			mode = &linux.NfulnlMsgConfigMode{}
+			binary.Unmarshal(value[:linux.NfulnlMsgConfigModeSize], usermem.ByteOrder, mode)
+		case linux.NFULA_CFG_FLAGS:
+			if len(value) < 2 {
+				return syserr.ErrInvalidArgument
+			}
+			f := binary.BigEndian.Uint16(value)
+			flags = &f
+		case linux.NFULA_CFG_NLBUFSIZ, linux.NFULA_CFG_TIMEOUT, linux.NFULA_CFG_QTHRESH:
+			// Packets are sent right away rather than batched, so
+			// these are no-ops.
+			if len(value) < 4 {
+				return syserr.ErrInvalidArgument
+			}
+		}
+	}
+
+	if cmd != nil {
+		switch *cmd {
+		case linux.NFULNL_CFG_CMD_PF_BIND, linux.NFULNL_CFG_CMD_PF_UNBIND:
+			// These are obsolete and ignored, as in Linux.
+			return nil
+		}
+	}
+	if flags != nil && *flags&linux.NFULNL_CFG_F_CONNTRACK != 0 {
+		return syserr.ErrNotSupported
+	}
+	if mode != nil {
+		switch mode.CopyMode {
+		case linux.NFULNL_COPY_NONE, linux.NFULNL_COPY_META, linux.NFULNL_COPY_PACKET:
+		default:
+			return syserr.ErrInvalidArgument
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	inst, ok := p.instances[group]
+	if cmd != nil {
+		switch *cmd {
+		case linux.NFULNL_CFG_CMD_BIND:
+			if ok {
+				return syserr.ErrBusy
+			}
+			// Groups may only be bound to one socket.
+			if err := p.stack.Stack.BindNFLogGroup(group, p); err != nil {
+				return syserr.ErrPermissionDenied
+			}
+			inst = &instance{
+				copyMode:  linux.NFULNL_COPY_PACKET,
+				copyRange: linux.NFULNL_COPY_RANGE_MAX,
+			}
+			if p.instances == nil {
+				p.instances = make(map[uint16]*instance)
+			}
+			p.instances[group] = inst
+		case linux.NFULNL_CFG_CMD_UNBIND:
+			if !ok {
+				return syserr.ErrNoDevice
+			}
+			p.stack.Stack.UnbindNFLogGroup(group, p)
+			delete(p.instances, group)
+			return nil
+		default:
+			return syserr.ErrNotSupported
+		}
+	} else if !ok {
+		return syserr.ErrNoDevice
+	}
+
+	if mode != nil {
+		inst.copyMode = mode.CopyMode
+		switch inst.copyMode {
+		case linux.NFULNL_COPY_PACKET:
+			inst.copyRange = ntohl(mode.CopyRange)
+			if inst.copyRange == 0 || inst.copyRange > linux.NFULNL_COPY_RANGE_MAX {
+				inst.copyRange = linux.NFULNL_COPY_RANGE_MAX
+			}
+		default:
+			inst.copyRange = 0
+		}
+	}
+	if flags != nil {
+		inst.flags = *flags
+	}
+	return nil
+}
+
+// NFLogPacket implements stack.NFLogListener.NFLogPacket. It sends the packet
+// to the socket in an NFULNL_MSG_PACKET message. See
+// net/netfilter/nfnetlink_log.c:__build_packet_message.
+func (p *Protocol) NFLogPacket(opts stack.NFLogOptions, log *stack.PacketLog) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	inst, ok := p.instances[opts.Group]
+	if !ok || p.sender == nil {
+		return
+	}
+
+	family := uint8(linux.AF_INET)
+	if log.NetworkProtocol == header.IPv6ProtocolNumber {
+		family = linux.AF_INET6
+	}
+
+	ms := netlink.NewMessageSet(0 /* portID */, 0 /* seq */)
+	m := ms.AddMessage(linux.NetlinkMessageHeader{
+		Type: linux.NFNL_SUBSYS_ULOG<<8 | linux.NFULNL_MSG_PACKET,
+	})
+	m.Put(linux.NetfilterGenMessage{
+		Family:  family,
+		Version: linux.NFNETLINK_V0,
+		ResID:   htons(opts.Group),
+	})
+	m.PutAttr(linux.NFULA_PACKET_HDR, linux.NfulnlMsgPacketHdr{
+		HWProtocol: htons(uint16(log.NetworkProtocol)),
+		Hook:       uint8(log.Hook),
+	})
+	m.PutAttrString(linux.NFULA_PREFIX, opts.Prefix)
+	if log.InputNICID != 0 {
+		m.PutAttr(linux.NFULA_IFINDEX_INDEV, htonl(uint32(log.InputNICID)))
+	}
+	if log.OutputNICID != 0 {
+		m.PutAttr(linux.NFULA_IFINDEX_OUTDEV, htonl(uint32(log.OutputNICID)))
+	}
+	if log.Mark != 0 {
+		m.PutAttr(linux.NFULA_MARK, htonl(log.Mark))
+	}
+	m.PutAttr(linux.NFULA_TIMESTAMP, linux.NfulnlMsgPacketTimestamp{
+		Sec:  htonll(uint64(log.Time.Unix())),
+		Usec: htonll(uint64(log.Time.Nanosecond() / 1000)),
+	})
+	if log.Owner != nil {
+		m.PutAttr(linux.NFULA_UID, htonl(log.Owner.UID()))
+		m.PutAttr(linux.NFULA_GID, htonl(log.Owner.GID()))
+	}
+	if inst.flags&linux.NFULNL_CFG_F_SEQ != 0 {
+		m.PutAttr(linux.NFULA_SEQ, htonl(inst.seq))
+		inst.seq++
+	}
+	if inst.flags&linux.NFULNL_CFG_F_SEQ_GLOBAL != 0 {
+		m.PutAttr(linux.NFULA_SEQ_GLOBAL, htonl(atomic.AddUint32(&globalSeq, 1)-1))
+	}
+	if inst.copyMode == linux.NFULNL_COPY_PACKET {
+		payload := log.Packet
+		if uint32(len(payload)) > inst.copyRange {
+			payload = payload[:inst.copyRange]
+		}
+		m.PutAttr(linux.NFULA_PAYLOAD, []byte(payload))
+	}
+
+	// Like Linux, drop the packet if the receive buffer of the socket is
+	// full.
+	_ = p.sender.Send(p.k.SupervisorContext(), ms)
+}
+
+// htons, htonl and htonll convert values to network byte order, for the
+// fields which are marshalled in host byte order.
+func htons(v uint16) uint16 {
+	buf := make([]byte, 2)
+	binary.BigEndian.PutUint16(buf, v)
+	return usermem.ByteOrder.Uint16(buf)
+}
+
+func htonl(v uint32) uint32 {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, v)
+	return usermem.ByteOrder.Uint32(buf)
+}
+
+func htonll(v uint64) uint64 {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, v)
+	return usermem.ByteOrder.Uint64(buf)
+}
+
+// ntohs and ntohl convert values from network byte order.
+func ntohs(v uint16) uint16 {
+	return htons(v)
+}
+
+func ntohl(v uint32) uint32 {
+	return htonl(v)
+}
+
+// init registers the NETLINK_NETFILTER provider.
+func init() {
+	netlink.RegisterProvider(linux.NETLINK_NETFILTER, NewProtocol)
+}
@@ -45,6 +45,28 @@ type Protocol interface {
 	ProcessMessage(ctx context.Context, msg *Message, ms *MessageSet) *syserr.Error
 }
 
+// AsyncProtocol is a Protocol which sends messages to userspace on its own,
+// outside of the processing of the messages from userspace, like
+// notifications.
+type AsyncProtocol interface {
+	Protocol
+
+	// Attach is called once the socket of the protocol is created, with the
+	// Sender of the socket.
+	Attach(s Sender)
+
+	// Release is called when the socket of the protocol is released. The
+	// protocol must not use its Sender once Release returns.
+	Release()
+}
+
+// Sender sends messages to the userspace end of a netlink socket.
+type Sender interface {
+	// Send sends the messages of ms to userspace. Like in Linux, messages
+	// are dropped if the receive buffer of the socket is full.
+	Send(ctx context.Context, ms *MessageSet) *syserr.Error
+}
+
 // Provider is a function that creates a new Protocol for a specific netlink
 // protocol.
 //
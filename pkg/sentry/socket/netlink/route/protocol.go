@@ -17,6 +17,7 @@ package route
 
 import (
 	"bytes"
+	"encoding/binary"
 	"syscall"
 
 	"gvisor.dev/gvisor/pkg/abi/linux"
@@ -26,6 +27,7 @@ import (
 	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
 	"gvisor.dev/gvisor/pkg/sentry/socket/netlink"
 	"gvisor.dev/gvisor/pkg/syserr"
+	"gvisor.dev/gvisor/pkg/usermem"
 )
 
 // commandKind describes the operational class of a message type.
@@ -185,10 +187,325 @@ func addNewLinkMessage(ms *netlink.MessageSet, idx int32, i inet.Interface) {
 	}
 	m.PutAttr(linux.IFLA_ADDRESS, mac)
 	m.PutAttr(linux.IFLA_BROADCAST, brd)
+	if i.Link != 0 {
+		m.PutAttr(linux.IFLA_LINK, i.Link)
+	}
 
 	// TODO(gvisor.dev/issue/578): There are many more attributes.
 }
 
+// parseVLANInfo parses the IFLA_INFO_DATA attribute of "vlan" links, and
+// returns the VLAN identifier and the TPID of the VLAN tag.
+func parseVLANInfo(attrs netlink.AttrsView) (uint16, uint16, *syserr.Error) {
+	var id uint16
+	var hasID bool
+	protocol := uint16(0x8100) // ETH_P_8021Q.
+	for !attrs.Empty() {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
+			return 0, 0, syserr.ErrInvalidArgument
+		}
+		attrs = rest
+
+		switch ahdr.Type {
+		case linux.IFLA_VLAN_ID:
+			if len(value) < 2 {
+				return 0, 0, syserr.ErrInvalidArgument
+			}
+			id = usermem.ByteOrder.Uint16(value)
+			hasID = true
+		case linux.IFLA_VLAN_PROTOCOL:
+			if len(value) < 2 {
+				return 0, 0, syserr.ErrInvalidArgument
+			}
+			// The protocol is in network byte order.
+			protocol = binary.BigEndian.Uint16(value)
+		case linux.IFLA_VLAN_FLAGS, linux.IFLA_VLAN_EGRESS_QOS, linux.IFLA_VLAN_INGRESS_QOS:
+			// TODO(gvisor.dev/issue/578): Support VLAN flags and priority
+			// mappings.
+		default:
+			return 0, 0, syserr.ErrNotSupported
+		}
+	}
+	if !hasID {
+		return 0, 0, syserr.ErrInvalidArgument
+	}
+	return id, protocol, nil
+}
+
+// parseGREInfo parses the IFLA_INFO_DATA attribute of "gre" and "ip6gre"
+// links, whose addresses are addrLen bytes long.
+func parseGREInfo(attrs netlink.AttrsView, addrLen int) (inet.GRETunnel, *syserr.Error) {
+	var tun inet.GRETunnel
+	for !attrs.Empty() {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
+			return inet.GRETunnel{}, syserr.ErrInvalidArgument
+		}
+		attrs = rest
+
+		switch ahdr.Type {
+		case linux.IFLA_GRE_IFLAGS, linux.IFLA_GRE_OFLAGS:
+			if len(value) < 2 {
+				return inet.GRETunnel{}, syserr.ErrInvalidArgument
+			}
+			// The flags are in network byte order.
+			flags := binary.BigEndian.Uint16(value)
+			if flags&^(linux.GRE_CSUM|linux.GRE_KEY) != 0 {
+				// TODO(gvisor.dev/issue/578): Support sequence numbers.
+				return inet.GRETunnel{}, syserr.ErrNotSupported
+			}
+			if ahdr.Type == linux.IFLA_GRE_IFLAGS {
+				tun.InputChecksum = flags&linux.GRE_CSUM != 0
+				tun.HasInputKey = flags&linux.GRE_KEY != 0
+			} else {
+				tun.OutputChecksum = flags&linux.GRE_CSUM != 0
+				tun.HasOutputKey = flags&linux.GRE_KEY != 0
+			}
+		case linux.IFLA_GRE_IKEY, linux.IFLA_GRE_OKEY:
+			if len(value) < 4 {
+				return inet.GRETunnel{}, syserr.ErrInvalidArgument
+			}
+			// The keys are in network byte order.
+			if ahdr.Type == linux.IFLA_GRE_IKEY {
+				tun.InputKey = binary.BigEndian.Uint32(value)
+			} else {
+				tun.OutputKey = binary.BigEndian.Uint32(value)
+			}
+		case linux.IFLA_GRE_LOCAL, linux.IFLA_GRE_REMOTE:
+			if len(value) < addrLen {
+				return inet.GRETunnel{}, syserr.ErrInvalidArgument
+			}
+			// The unspecified address stands for no address.
+			var addr []byte
+			if !isZero(value[:addrLen]) {
+				addr = append(addr, value[:addrLen]...)
+			}
+			if ahdr.Type == linux.IFLA_GRE_LOCAL {
+				tun.Local = addr
+			} else {
+				tun.Remote = addr
+			}
+		case linux.IFLA_GRE_TTL:
+			if len(value) < 1 {
+				return inet.GRETunnel{}, syserr.ErrInvalidArgument
+			}
+			tun.TTL = value[0]
+		case linux.IFLA_GRE_TOS:
+			if len(value) < 1 {
+				return inet.GRETunnel{}, syserr.ErrInvalidArgument
+			}
+			// As in Linux, the lowest bit of the TOS, which is not part of
+			// the DSCP, asks for the TOS to be inherited.
+			tun.TOS = value[0] &^ 1
+			tun.InheritTOS = value[0]&1 != 0
+		case linux.IFLA_GRE_FLOWINFO:
+			if len(value) < 4 {
+				return inet.GRETunnel{}, syserr.ErrInvalidArgument
+			}
+			// The Traffic Class of ip6gre tunnels is carried in the flow
+			// information, which is in network byte order.
+			//
+			// TODO(gvisor.dev/issue/578): Support flow labels.
+			tun.TOS = uint8(binary.BigEndian.Uint32(value) >> 20)
+		case linux.IFLA_GRE_FLAGS:
+			if len(value) < 4 {
+				return inet.GRETunnel{}, syserr.ErrInvalidArgument
+			}
+			// Encapsulation limit options are never added, as if
+			// IP6_TNL_F_IGN_ENCAP_LIMIT was always set.
+			tun.InheritTOS = usermem.ByteOrder.Uint32(value)&linux.IP6_TNL_F_USE_ORIG_TCLASS != 0
+		case linux.IFLA_GRE_PMTUDISC, linux.IFLA_GRE_ENCAP_LIMIT, linux.IFLA_GRE_ENCAP_FLAGS, linux.IFLA_GRE_ENCAP_SPORT, linux.IFLA_GRE_ENCAP_DPORT:
+			// TODO(gvisor.dev/issue/578): Support path MTU discovery
+			// settings and encapsulation limits. The encapsulation
+			// flags and ports only apply to the unsupported
+			// IFLA_GRE_ENCAP_TYPE.
+		case linux.IFLA_GRE_LINK, linux.IFLA_GRE_ENCAP_TYPE, linux.IFLA_GRE_IGNORE_DF, linux.IFLA_GRE_FWMARK:
+			// TODO(gvisor.dev/issue/578): Support binding tunnels to an
+			// interface, UDP encapsulation, ignoring DF and firewall
+			// marks.
+			if !isZero(value) {
+				return inet.GRETunnel{}, syserr.ErrNotSupported
+			}
+		default:
+			return inet.GRETunnel{}, syserr.ErrNotSupported
+		}
+	}
+	if tun.Remote == nil {
+		// TODO(gvisor.dev/issue/578): Support tunnels which receive from
+		// any remote address.
+		return inet.GRETunnel{}, syserr.ErrNotSupported
+	}
+	return tun, nil
+}
+
+// isZero returns true if all the bytes of b are zero.
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// newLink handles RTM_NEWLINK requests.
+//
+// Only the creation of VLAN and GRE links is supported.
+func (p *Protocol) newLink(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack.
+		return syserr.ErrProtocolNotSupported
+	}
+
+	var ifi linux.InterfaceInfoMessage
+	attrs, ok := msg.GetData(&ifi)
+	if !ok {
+		return syserr.ErrInvalidArgument
+	}
+
+	var (
+		name     string
+		link     int32
+		kind     string
+		infoData netlink.AttrsView
+	)
+	for !attrs.Empty() {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
+			return syserr.ErrInvalidArgument
+		}
+		attrs = rest
+
+		switch ahdr.Type {
+		case linux.IFLA_IFNAME:
+			if len(value) < 1 {
+				return syserr.ErrInvalidArgument
+			}
+			name = string(value[:len(value)-1])
+		case linux.IFLA_LINK:
+			if len(value) < 4 {
+				return syserr.ErrInvalidArgument
+			}
+			link = int32(usermem.ByteOrder.Uint32(value))
+		case linux.IFLA_LINKINFO:
+			info := netlink.AttrsView(value)
+			for !info.Empty() {
+				ihdr, ivalue, irest, ok := info.ParseFirst()
+				if !ok {
+					return syserr.ErrInvalidArgument
+				}
+				info = irest
+
+				switch ihdr.Type {
+				case linux.IFLA_INFO_KIND:
+					kind = string(bytes.TrimRight(ivalue, "\x00"))
+				case linux.IFLA_INFO_DATA:
+					infoData = netlink.AttrsView(ivalue)
+				}
+			}
+		}
+	}
+
+	flags := msg.Header().Flags
+	if ifi.Index != 0 || name != "" {
+		for idx, i := range stack.Interfaces() {
+			if idx == ifi.Index || (name != "" && i.Name == name) {
+				if flags&linux.NLM_F_EXCL != 0 {
+					return syserr.ErrExists
+				}
+				// TODO(gvisor.dev/issue/578): Support changing links.
+				return syserr.ErrNotSupported
+			}
+		}
+	}
+	if flags&linux.NLM_F_CREATE == 0 {
+		return syserr.ErrNoDevice
+	}
+
+	switch kind {
+	case "vlan":
+		if link == 0 {
+			return syserr.ErrInvalidArgument
+		}
+		id, protocol, err := parseVLANInfo(infoData)
+		if err != nil {
+			return err
+		}
+		if _, err := stack.AddVLANInterface(link, name, id, protocol); err != nil {
+			return syserr.FromError(err)
+		}
+		return nil
+	case "gre", "ip6gre":
+		addrLen := len(linux.InetAddr{})
+		if kind == "ip6gre" {
+			addrLen = len(linux.Inet6Addr{})
+		}
+		tun, err := parseGREInfo(infoData, addrLen)
+		if err != nil {
+			return err
+		}
+		if _, err := stack.AddGREInterface(name, tun); err != nil {
+			return syserr.FromError(err)
+		}
+		return nil
+	default:
+		return syserr.ErrNotSupported
+	}
+}
+
+// delLink handles RTM_DELLINK requests.
+func (p *Protocol) delLink(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack.
+		return syserr.ErrProtocolNotSupported
+	}
+
+	var ifi linux.InterfaceInfoMessage
+	attrs, ok := msg.GetData(&ifi)
+	if !ok {
+		return syserr.ErrInvalidArgument
+	}
+
+	idx := ifi.Index
+	if idx == 0 {
+		var name string
+		for !attrs.Empty() {
+			ahdr, value, rest, ok := attrs.ParseFirst()
+			if !ok {
+				return syserr.ErrInvalidArgument
+			}
+			attrs = rest
+
+			if ahdr.Type == linux.IFLA_IFNAME {
+				if len(value) < 1 {
+					return syserr.ErrInvalidArgument
+				}
+				name = string(value[:len(value)-1])
+			}
+		}
+		if name == "" {
+			return syserr.ErrInvalidArgument
+		}
+		for i, iface := range stack.Interfaces() {
+			if iface.Name == name {
+				idx = i
+				break
+			}
+		}
+		if idx == 0 {
+			return syserr.ErrNoDevice
+		}
+	}
+
+	if err := stack.RemoveInterface(idx); err != nil {
+		return syserr.FromError(err)
+	}
+	return nil
+}
+
 // dumpAddrs handles RTM_GETADDR dump requests.
 func (p *Protocol) dumpAddrs(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
 	// RTM_GETADDR dump requests need not contain anything more than the
@@ -366,15 +683,17 @@ func (p *Protocol) dumpRoutes(ctx context.Context, msg *netlink.Message, ms *net
 			Type: linux.RTM_NEWROUTE,
 		})
 
+		table := rt.Table
+		if table == linux.RT_TABLE_UNSPEC {
+			table = linux.RT_TABLE_MAIN
+		}
 		m.Put(linux.RouteMessage{
 			Family: rt.Family,
 			DstLen: rt.DstLen,
 			SrcLen: rt.SrcLen,
 			TOS:    rt.TOS,
 
-			// Always return the main table since we don't have multiple
-			// routing tables.
-			Table:    linux.RT_TABLE_MAIN,
+			Table:    tableOfMessage(table),
 			Protocol: rt.Protocol,
 			Scope:    rt.Scope,
 			Type:     rt.Type,
@@ -383,6 +702,7 @@ func (p *Protocol) dumpRoutes(ctx context.Context, msg *netlink.Message, ms *net
 		})
 
 		m.PutAttr(254, []byte{123})
+		m.PutAttr(linux.RTA_TABLE, table)
 		if rt.DstLen > 0 {
 			m.PutAttr(linux.RTA_DST, rt.DstAddr)
 		}
@@ -395,6 +715,9 @@ func (p *Protocol) dumpRoutes(ctx context.Context, msg *netlink.Message, ms *net
 		if len(rt.GatewayAddr) > 0 {
 			m.PutAttr(linux.RTA_GATEWAY, rt.GatewayAddr)
 		}
+		if rt.Priority != 0 {
+			m.PutAttr(linux.RTA_PRIORITY, rt.Priority)
+		}
 
 		// TODO(gvisor.dev/issue/578): There are many more attributes.
 	}
@@ -402,6 +725,331 @@ func (p *Protocol) dumpRoutes(ctx context.Context, msg *netlink.Message, ms *net
 	return nil
 }
 
+// tableOfMessage returns the value of the 8-bit table field of the messages
+// describing a route or a rule of table, whose identifier is carried by an
+// attribute if it doesn't fit.
+func tableOfMessage(table uint32) uint8 {
+	if table > 0xff {
+		return linux.RT_TABLE_COMPAT
+	}
+	return uint8(table)
+}
+
+// parseRoute parses the RouteMessage and attributes of RTM_NEWROUTE and
+// RTM_DELROUTE requests.
+func parseRoute(msg *netlink.Message) (inet.Route, *syserr.Error) {
+	var rtMsg linux.RouteMessage
+	attrs, ok := msg.GetData(&rtMsg)
+	if !ok {
+		return inet.Route{}, syserr.ErrInvalidArgument
+	}
+
+	route := inet.Route{
+		Family:   rtMsg.Family,
+		DstLen:   rtMsg.DstLen,
+		SrcLen:   rtMsg.SrcLen,
+		TOS:      rtMsg.TOS,
+		Table:    uint32(rtMsg.Table),
+		Protocol: rtMsg.Protocol,
+		Scope:    rtMsg.Scope,
+		Type:     rtMsg.Type,
+		Flags:    rtMsg.Flags,
+	}
+	for !attrs.Empty() {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
+			return inet.Route{}, syserr.ErrInvalidArgument
+		}
+		attrs = rest
+
+		switch ahdr.Type {
+		case linux.RTA_DST:
+			route.DstAddr = value
+		case linux.RTA_SRC:
+			route.SrcAddr = value
+		case linux.RTA_GATEWAY:
+			route.GatewayAddr = value
+		case linux.RTA_OIF, linux.RTA_PRIORITY, linux.RTA_TABLE:
+			if len(value) < 4 {
+				return inet.Route{}, syserr.ErrInvalidArgument
+			}
+			v := usermem.ByteOrder.Uint32(value)
+			switch ahdr.Type {
+			case linux.RTA_OIF:
+				route.OutputInterface = int32(v)
+			case linux.RTA_PRIORITY:
+				route.Priority = v
+			case linux.RTA_TABLE:
+				if v != linux.RT_TABLE_UNSPEC {
+					route.Table = v
+				}
+			}
+		default:
+			return inet.Route{}, syserr.ErrNotSupported
+		}
+	}
+	if route.DstLen > 0 && route.DstAddr == nil {
+		return inet.Route{}, syserr.ErrInvalidArgument
+	}
+	if route.Table == linux.RT_TABLE_UNSPEC {
+		route.Table = linux.RT_TABLE_MAIN
+	}
+	return route, nil
+}
+
+// newRoute handles RTM_NEWROUTE requests.
+//
+// As in Linux, the NLM_F_CREATE, NLM_F_EXCL, NLM_F_REPLACE and NLM_F_APPEND
+// flags of the request select how the route is added among the routes with
+// the same destination, table and priority.
+func (p *Protocol) newRoute(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack.
+		return syserr.ErrProtocolNotSupported
+	}
+
+	route, err := parseRoute(msg)
+	if err != nil {
+		return err
+	}
+	flags := msg.Header().Flags
+	if err := stack.AddRoute(route, inet.AddRouteOptions{
+		Create:    flags&linux.NLM_F_CREATE != 0,
+		Exclusive: flags&linux.NLM_F_EXCL != 0,
+		Replace:   flags&linux.NLM_F_REPLACE != 0,
+		Append:    flags&linux.NLM_F_APPEND != 0,
+	}); err != nil {
+		return syserr.FromError(err)
+	}
+	return nil
+}
+
+// delRoute handles RTM_DELROUTE requests.
+func (p *Protocol) delRoute(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack.
+		return syserr.ErrProtocolNotSupported
+	}
+
+	route, err := parseRoute(msg)
+	if err != nil {
+		return err
+	}
+	if err := stack.RemoveRoute(route); err != nil {
+		return syserr.FromError(err)
+	}
+	return nil
+}
+
+// dumpRules handles RTM_GETRULE dump requests.
+func (p *Protocol) dumpRules(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	// RTM_GETRULE dump requests need not contain anything more than the
+	// netlink header and 1 byte protocol family common to all
+	// NETLINK_ROUTE requests.
+	var family uint8
+	if _, ok := msg.GetData(&family); !ok {
+		return syserr.ErrInvalidArgument
+	}
+
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack.
+		return nil
+	}
+
+	// We always send back an NLMSG_DONE.
+	ms.Multi = true
+
+	ifaces := stack.Interfaces()
+	for _, rule := range stack.RouteRules() {
+		if family != linux.AF_UNSPEC && family != rule.Family {
+			continue
+		}
+
+		m := ms.AddMessage(linux.NetlinkMessageHeader{
+			Type: linux.RTM_NEWRULE,
+		})
+		m.Put(linux.FibRuleHeader{
+			Family: rule.Family,
+			DstLen: rule.DstLen,
+			SrcLen: rule.SrcLen,
+			TOS:    rule.TOS,
+			Table:  tableOfMessage(rule.Table),
+			Action: linux.FR_ACT_TO_TBL,
+		})
+
+		m.PutAttr(linux.FRA_TABLE, rule.Table)
+		if rule.Priority != 0 {
+			m.PutAttr(linux.FRA_PRIORITY, rule.Priority)
+		}
+		if rule.SrcLen > 0 {
+			m.PutAttr(linux.FRA_SRC, rule.SrcAddr)
+		}
+		if rule.DstLen > 0 {
+			m.PutAttr(linux.FRA_DST, rule.DstAddr)
+		}
+		if i, ok := ifaces[rule.InputInterface]; ok {
+			m.PutAttrString(linux.FRA_IIFNAME, i.Name)
+		}
+		if i, ok := ifaces[rule.OutputInterface]; ok {
+			m.PutAttrString(linux.FRA_OIFNAME, i.Name)
+		}
+		if rule.Mark != 0 || rule.MarkMask != 0 {
+			m.PutAttr(linux.FRA_FWMARK, rule.Mark)
+			m.PutAttr(linux.FRA_FWMASK, rule.MarkMask)
+		}
+	}
+
+	return nil
+}
+
+// parseRule parses the FibRuleHeader and attributes of RTM_NEWRULE and
+// RTM_DELRULE requests. The interfaces are looked up by name in ifaces.
+func parseRule(msg *netlink.Message, ifaces map[int32]inet.Interface) (linux.FibRuleHeader, inet.RouteRule, *syserr.Error) {
+	var frh linux.FibRuleHeader
+	attrs, ok := msg.GetData(&frh)
+	if !ok {
+		return frh, inet.RouteRule{}, syserr.ErrInvalidArgument
+	}
+
+	rule := inet.RouteRule{
+		Family: frh.Family,
+		SrcLen: frh.SrcLen,
+		DstLen: frh.DstLen,
+		TOS:    frh.TOS,
+		Table:  uint32(frh.Table),
+	}
+	hasMask := false
+	for !attrs.Empty() {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
+			return frh, inet.RouteRule{}, syserr.ErrInvalidArgument
+		}
+		attrs = rest
+
+		switch ahdr.Type {
+		case linux.FRA_SRC:
+			rule.SrcAddr = value
+		case linux.FRA_DST:
+			rule.DstAddr = value
+		case linux.FRA_IIFNAME, linux.FRA_OIFNAME:
+			idx, ok := interfaceIndex(ifaces, string(bytes.TrimRight(value, "\x00")))
+			if !ok {
+				return frh, inet.RouteRule{}, syserr.ErrNoDevice
+			}
+			if ahdr.Type == linux.FRA_IIFNAME {
+				rule.InputInterface = idx
+			} else {
+				rule.OutputInterface = idx
+			}
+		case linux.FRA_PRIORITY, linux.FRA_TABLE, linux.FRA_FWMARK, linux.FRA_FWMASK:
+			if len(value) < 4 {
+				return frh, inet.RouteRule{}, syserr.ErrInvalidArgument
+			}
+			v := usermem.ByteOrder.Uint32(value)
+			switch ahdr.Type {
+			case linux.FRA_PRIORITY:
+				rule.Priority = v
+			case linux.FRA_TABLE:
+				if v != linux.RT_TABLE_UNSPEC {
+					rule.Table = v
+				}
+			case linux.FRA_FWMARK:
+				rule.Mark = v
+			case linux.FRA_FWMASK:
+				rule.MarkMask = v
+				hasMask = true
+			}
+		default:
+			return frh, inet.RouteRule{}, syserr.ErrNotSupported
+		}
+	}
+	// As in Linux, a mark without a mask matches the whole mark.
+	if rule.Mark != 0 && !hasMask {
+		rule.MarkMask = 0xffffffff
+	}
+	if (rule.SrcLen > 0 && rule.SrcAddr == nil) || (rule.DstLen > 0 && rule.DstAddr == nil) {
+		return frh, inet.RouteRule{}, syserr.ErrInvalidArgument
+	}
+	return frh, rule, nil
+}
+
+// interfaceIndex returns the index of the interface of ifaces named name.
+func interfaceIndex(ifaces map[int32]inet.Interface, name string) (int32, bool) {
+	for idx, i := range ifaces {
+		if i.Name == name {
+			return idx, true
+		}
+	}
+	return 0, false
+}
+
+// newRule handles RTM_NEWRULE requests.
+//
+// Only the rules looking up a routing table are supported.
+func (p *Protocol) newRule(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack.
+		return syserr.ErrProtocolNotSupported
+	}
+
+	frh, rule, err := parseRule(msg, stack.Interfaces())
+	if err != nil {
+		return err
+	}
+	if frh.Action != linux.FR_ACT_TO_TBL {
+		return syserr.ErrNotSupported
+	}
+	if rule.Table == linux.RT_TABLE_UNSPEC {
+		return syserr.ErrInvalidArgument
+	}
+
+	if rule.Priority == 0 {
+		// As in Linux, rules without a priority are evaluated before the
+		// existing rules, save for the rule of the local table which
+		// netstack doesn't need. A zero priority is handled as no
+		// priority.
+		for _, r := range stack.RouteRules() {
+			if r.Family == rule.Family {
+				if r.Priority > 0 {
+					rule.Priority = r.Priority - 1
+				}
+				break
+			}
+		}
+	}
+
+	if err := stack.AddRouteRule(rule); err != nil {
+		return syserr.FromError(err)
+	}
+	return nil
+}
+
+// delRule handles RTM_DELRULE requests.
+func (p *Protocol) delRule(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack.
+		return syserr.ErrProtocolNotSupported
+	}
+
+	frh, rule, err := parseRule(msg, stack.Interfaces())
+	if err != nil {
+		return err
+	}
+	if frh.Action != linux.FR_ACT_UNSPEC && frh.Action != linux.FR_ACT_TO_TBL {
+		return syserr.ErrNoFileOrDir
+	}
+
+	if err := stack.RemoveRouteRule(rule); err != nil {
+		return syserr.FromError(err)
+	}
+	return nil
+}
+
 // newAddr handles RTM_NEWADDR requests.
 func (p *Protocol) newAddr(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
 	stack := inet.StackFromContext(ctx)
@@ -498,6 +1146,209 @@ func (p *Protocol) delAddr(ctx context.Context, msg *netlink.Message, ms *netlin
 	return nil
 }
 
+// dumpNeighbors handles RTM_GETNEIGH dump requests.
+func (p *Protocol) dumpNeighbors(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	// RTM_GETNEIGH dump requests need not contain anything more than the
+	// netlink header and 1 byte protocol family common to all
+	// NETLINK_ROUTE requests. Those which contain a NeighborMessage may be
+	// restricted to an interface with NDA_IFINDEX.
+	var (
+		family uint8
+		index  int32
+	)
+	var ndm linux.NeighborMessage
+	if attrs, ok := msg.GetData(&ndm); ok {
+		family = ndm.Family
+		for !attrs.Empty() {
+			ahdr, value, rest, ok := attrs.ParseFirst()
+			if !ok {
+				return syserr.ErrInvalidArgument
+			}
+			attrs = rest
+
+			if ahdr.Type == linux.NDA_IFINDEX {
+				if len(value) < 4 {
+					return syserr.ErrInvalidArgument
+				}
+				index = int32(usermem.ByteOrder.Uint32(value))
+			}
+		}
+	} else if _, ok := msg.GetData(&family); !ok {
+		return syserr.ErrInvalidArgument
+	}
+
+	// The RTM_GETNEIGH dump response is a set of RTM_NEWNEIGH messages each
+	// containing a NeighborMessage followed by a set of netlink attributes.
+
+	// We always send back an NLMSG_DONE.
+	ms.Multi = true
+
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network devices.
+		return nil
+	}
+
+	for id, neighs := range stack.Neighbors() {
+		if index != 0 && index != id {
+			continue
+		}
+		for _, n := range neighs {
+			if family != linux.AF_UNSPEC && family != n.Family {
+				continue
+			}
+			addNewNeighborMessage(ms, id, n)
+		}
+	}
+
+	return nil
+}
+
+// getNeighbor handles RTM_GETNEIGH requests, which return the neighbor entry
+// of NDA_DST on the interface with index ndm.Index.
+func (p *Protocol) getNeighbor(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network devices.
+		return syserr.ErrNoDevice
+	}
+
+	ndm, neigh, err := parseNeighbor(msg)
+	if err != nil {
+		return err
+	}
+	if ndm.Index == 0 {
+		return syserr.ErrInvalidArgument
+	}
+	if _, ok := stack.Interfaces()[ndm.Index]; !ok {
+		return syserr.ErrNoDevice
+	}
+
+	for _, n := range stack.Neighbors()[ndm.Index] {
+		if n.Family == neigh.Family && bytes.Equal(n.Addr, neigh.Addr) {
+			addNewNeighborMessage(ms, ndm.Index, n)
+			return nil
+		}
+	}
+	return syserr.ErrNoFileOrDir
+}
+
+// addNewNeighborMessage appends a RTM_NEWNEIGH message for the given neighbor
+// entry of the interface with index idx into the message set.
+func addNewNeighborMessage(ms *netlink.MessageSet, idx int32, n inet.Neighbor) {
+	m := ms.AddMessage(linux.NetlinkMessageHeader{
+		Type: linux.RTM_NEWNEIGH,
+	})
+
+	m.Put(linux.NeighborMessage{
+		Family: n.Family,
+		Index:  idx,
+		State:  n.State,
+		Type:   linux.RTN_UNICAST,
+	})
+
+	m.PutAttr(linux.NDA_DST, n.Addr)
+	if len(n.LinkAddr) != 0 {
+		m.PutAttr(linux.NDA_LLADDR, n.LinkAddr)
+	}
+
+	// The entries aren't reference counted, and the times they were last
+	// confirmed and used aren't tracked separately from the time they were
+	// last updated.
+	age := uint32(linux.ClockTFromDuration(n.Age))
+	m.PutAttr(linux.NDA_CACHEINFO, linux.NeighborCacheInfo{
+		Confirmed: age,
+		Used:      age,
+		Updated:   age,
+	})
+}
+
+// parseNeighbor parses the NeighborMessage and attributes of RTM_NEWNEIGH and
+// RTM_DELNEIGH requests.
+func parseNeighbor(msg *netlink.Message) (linux.NeighborMessage, inet.Neighbor, *syserr.Error) {
+	var ndm linux.NeighborMessage
+	attrs, ok := msg.GetData(&ndm)
+	if !ok {
+		return ndm, inet.Neighbor{}, syserr.ErrInvalidArgument
+	}
+
+	neigh := inet.Neighbor{
+		Family: ndm.Family,
+		State:  ndm.State,
+	}
+	for !attrs.Empty() {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
+			return ndm, inet.Neighbor{}, syserr.ErrInvalidArgument
+		}
+		attrs = rest
+
+		switch ahdr.Type {
+		case linux.NDA_DST:
+			neigh.Addr = value
+		case linux.NDA_LLADDR:
+			neigh.LinkAddr = value
+		case linux.NDA_CACHEINFO, linux.NDA_PROBES:
+			// These are ignored, like in Linux, so that the entries
+			// returned by RTM_GETNEIGH may be passed back as is, which is
+			// how ip neigh flush removes them.
+		default:
+			return ndm, inet.Neighbor{}, syserr.ErrNotSupported
+		}
+	}
+	if len(neigh.Addr) == 0 {
+		return ndm, inet.Neighbor{}, syserr.ErrInvalidArgument
+	}
+	return ndm, neigh, nil
+}
+
+// newNeighbor handles RTM_NEWNEIGH requests.
+func (p *Protocol) newNeighbor(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack.
+		return syserr.ErrProtocolNotSupported
+	}
+
+	ndm, neigh, err := parseNeighbor(msg)
+	if err != nil {
+		return err
+	}
+
+	// Only permanent entries may be added; other entries are maintained by
+	// the stack's address resolution.
+	if ndm.State&(linux.NUD_PERMANENT|linux.NUD_NOARP) == 0 {
+		return syserr.ErrNotSupported
+	}
+	if len(neigh.LinkAddr) == 0 {
+		return syserr.ErrInvalidArgument
+	}
+
+	if err := stack.AddNeighbor(ndm.Index, neigh); err != nil {
+		return syserr.FromError(err)
+	}
+	return nil
+}
+
+// delNeighbor handles RTM_DELNEIGH requests.
+func (p *Protocol) delNeighbor(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack.
+		return syserr.ErrProtocolNotSupported
+	}
+
+	ndm, neigh, err := parseNeighbor(msg)
+	if err != nil {
+		return err
+	}
+
+	if err := stack.RemoveNeighbor(ndm.Index, neigh); err != nil {
+		return syserr.FromError(err)
+	}
+	return nil
+}
+
 // ProcessMessage implements netlink.Protocol.ProcessMessage.
 func (p *Protocol) ProcessMessage(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
 	hdr := msg.Header()
@@ -528,6 +1379,10 @@ func (p *Protocol) ProcessMessage(ctx context.Context, msg *netlink.Message, ms
 			return p.dumpAddrs(ctx, msg, ms)
 		case linux.RTM_GETROUTE:
 			return p.dumpRoutes(ctx, msg, ms)
+		case linux.RTM_GETNEIGH:
+			return p.dumpNeighbors(ctx, msg, ms)
+		case linux.RTM_GETRULE:
+			return p.dumpRules(ctx, msg, ms)
 		default:
 			return syserr.ErrNotSupported
 		}
@@ -537,10 +1392,28 @@ func (p *Protocol) ProcessMessage(ctx context.Context, msg *netlink.Message, ms
 			return p.getLink(ctx, msg, ms)
 		case linux.RTM_GETROUTE:
 			return p.dumpRoutes(ctx, msg, ms)
+		case linux.RTM_GETNEIGH:
+			return p.getNeighbor(ctx, msg, ms)
+		case linux.RTM_NEWLINK:
+			return p.newLink(ctx, msg, ms)
+		case linux.RTM_DELLINK:
+			return p.delLink(ctx, msg, ms)
 		case linux.RTM_NEWADDR:
 			return p.newAddr(ctx, msg, ms)
 		case linux.RTM_DELADDR:
 			return p.delAddr(ctx, msg, ms)
+		case linux.RTM_NEWNEIGH:
+			return p.newNeighbor(ctx, msg, ms)
+		case linux.RTM_DELNEIGH:
+			return p.delNeighbor(ctx, msg, ms)
+		case linux.RTM_NEWROUTE:
+			return p.newRoute(ctx, msg, ms)
+		case linux.RTM_DELROUTE:
+			return p.delRoute(ctx, msg, ms)
+		case linux.RTM_NEWRULE:
+			return p.newRule(ctx, msg, ms)
+		case linux.RTM_DELRULE:
+			return p.delRule(ctx, msg, ms)
 		default:
 			return syserr.ErrNotSupported
 		}
@@ -149,7 +149,7 @@ func NewSocket(t *kernel.Task, skType linux.SockType, protocol Protocol) (*Socke
 		return nil, err
 	}
 
-	return &Socket{
+	s := &Socket{
 		socketOpsCommon: socketOpsCommon{
 			ports:          t.Kernel().NetlinkPorts(),
 			protocol:       protocol,
@@ -158,11 +158,36 @@ func NewSocket(t *kernel.Task, skType linux.SockType, protocol Protocol) (*Socke
 			connection:     connection,
 			sendBufferSize: defaultSendBufferSize,
 		},
-	}, nil
+	}
+	s.attachProtocol()
+	return s, nil
+}
+
+// socketSender implements Sender for a socket.
+//
+// +stateify savable
+type socketSender struct {
+	s *socketOpsCommon
+}
+
+// Send implements Sender.Send.
+func (ss socketSender) Send(ctx context.Context, ms *MessageSet) *syserr.Error {
+	return ss.s.sendResponse(ctx, ms)
+}
+
+// attachProtocol gives the protocol of s the Sender of s, if it sends messages
+// on its own.
+func (s *socketOpsCommon) attachProtocol() {
+	if p, ok := s.protocol.(AsyncProtocol); ok {
+		p.Attach(socketSender{s})
+	}
 }
 
 // Release implements fs.FileOperations.Release.
 func (s *socketOpsCommon) Release(ctx context.Context) {
+	if p, ok := s.protocol.(AsyncProtocol); ok {
+		p.Release()
+	}
 	s.connection.Release(ctx)
 	s.ep.Close(ctx)
 
@@ -499,6 +524,11 @@ func (s *socketOpsCommon) GetPeerName(t *kernel.Task) (linux.SockAddr, uint32, *
 
 // RecvMsg implements socket.Socket.RecvMsg.
 func (s *socketOpsCommon) RecvMsg(t *kernel.Task, dst usermem.IOSequence, flags int, haveDeadline bool, deadline ktime.Time, senderRequested bool, controlDataLen uint64) (int, int, linux.SockAddr, uint32, socket.ControlMessages, *syserr.Error) {
+	// Netlink sockets have no error queue.
+	if flags&linux.MSG_ERRQUEUE != 0 {
+		return 0, 0, nil, 0, socket.ControlMessages{}, syserr.ErrTryAgain
+	}
+
 	from := &linux.SockAddrNetlink{
 		Family: linux.AF_NETLINK,
 		PortID: 0,
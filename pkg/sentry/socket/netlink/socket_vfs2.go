@@ -81,6 +81,7 @@ func NewVFS2(t *kernel.Task, skType linux.SockType, protocol Protocol) (*SocketV
 		},
 	}
 	fd.LockFD.Init(&vfs.FileLocks{})
+	fd.attachProtocol()
 	return fd, nil
 }
 
@@ -30,6 +30,7 @@ import (
 	"io"
 	"math"
 	"reflect"
+	"runtime"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -50,6 +51,7 @@ import (
 	"gvisor.dev/gvisor/pkg/sentry/inet"
 	"gvisor.dev/gvisor/pkg/sentry/kernel"
 	ktime "gvisor.dev/gvisor/pkg/sentry/kernel/time"
+	"gvisor.dev/gvisor/pkg/sentry/memmap"
 	"gvisor.dev/gvisor/pkg/sentry/socket"
 	"gvisor.dev/gvisor/pkg/sentry/socket/netfilter"
 	"gvisor.dev/gvisor/pkg/sentry/unimpl"
@@ -60,6 +62,7 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/sctp"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
 	"gvisor.dev/gvisor/pkg/usermem"
@@ -159,6 +162,7 @@ var Metrics = tcpip.Stats{
 				MembershipQuery:    mustCreateMetric("/netstack/igmp/packets_sent/membership_query", "Total number of IGMP Membership Query messages sent by netstack."),
 				V1MembershipReport: mustCreateMetric("/netstack/igmp/packets_sent/v1_membership_report", "Total number of IGMPv1 Membership Report messages sent by netstack."),
 				V2MembershipReport: mustCreateMetric("/netstack/igmp/packets_sent/v2_membership_report", "Total number of IGMPv2 Membership Report messages sent by netstack."),
+				V3MembershipReport: mustCreateMetric("/netstack/igmp/packets_sent/v3_membership_report", "Total number of IGMPv3 Membership Report messages sent by netstack."),
 				LeaveGroup:         mustCreateMetric("/netstack/igmp/packets_sent/leave_group", "Total number of IGMP Leave Group messages sent by netstack."),
 			},
 			Dropped: mustCreateMetric("/netstack/igmp/packets_sent/dropped", "Total number of IGMP packets dropped by netstack due to link layer errors."),
@@ -168,6 +172,7 @@ var Metrics = tcpip.Stats{
 				MembershipQuery:    mustCreateMetric("/netstack/igmp/packets_received/membership_query", "Total number of IGMP Membership Query messages received by netstack."),
 				V1MembershipReport: mustCreateMetric("/netstack/igmp/packets_received/v1_membership_report", "Total number of IGMPv1 Membership Report messages received by netstack."),
 				V2MembershipReport: mustCreateMetric("/netstack/igmp/packets_received/v2_membership_report", "Total number of IGMPv2 Membership Report messages received by netstack."),
+				V3MembershipReport: mustCreateMetric("/netstack/igmp/packets_received/v3_membership_report", "Total number of IGMPv3 Membership Report messages received by netstack."),
 				LeaveGroup:         mustCreateMetric("/netstack/igmp/packets_received/leave_group", "Total number of IGMP Leave Group messages received by netstack."),
 			},
 			Invalid:        mustCreateMetric("/netstack/igmp/packets_received/invalid", "Total number of IGMP packets received by netstack that could not be parsed."),
@@ -215,6 +220,20 @@ var Metrics = tcpip.Stats{
 		FastRetransmit:                     mustCreateMetric("/netstack/tcp/fast_retransmit", "Number of TCP segments which were fast retransmitted."),
 		Timeouts:                           mustCreateMetric("/netstack/tcp/timeouts", "Number of times RTO expired."),
 		ChecksumErrors:                     mustCreateMetric("/netstack/tcp/checksum_errors", "Number of segments dropped due to bad checksums."),
+		MD5NotFound:                        mustCreateMetric("/netstack/tcp/md5_not_found", "Number of segments dropped because they lacked an expected MD5 signature."),
+		MD5Unexpected:                      mustCreateMetric("/netstack/tcp/md5_unexpected", "Number of segments dropped because they carried an unexpected MD5 signature."),
+		MD5Failure:                         mustCreateMetric("/netstack/tcp/md5_failure", "Number of segments dropped because of an invalid MD5 signature."),
+		AOGood:                             mustCreateMetric("/netstack/tcp/ao_good", "Number of segments with a valid TCP-AO MAC."),
+		AOBad:                              mustCreateMetric("/netstack/tcp/ao_bad", "Number of segments dropped because of an invalid TCP-AO MAC."),
+		AOKeyNotFound:                      mustCreateMetric("/netstack/tcp/ao_key_not_found", "Number of segments dropped because no TCP-AO key matched their KeyID."),
+		AORequired:                         mustCreateMetric("/netstack/tcp/ao_required", "Number of segments dropped because they lacked a TCP-AO option."),
+		ECNCongestionResponses:             mustCreateMetric("/netstack/tcp/ecn_congestion_responses", "Number of times the congestion window was reduced in response to ECN feedback."),
+		AutoCorking:                        mustCreateMetric("/netstack/tcp/auto_corking", "Number of times a small segment was held back by auto-corking."),
+		SmallQueueThrottled:                mustCreateMetric("/netstack/tcp/small_queue_throttled", "Number of times sending was stopped because too much data was queued below the endpoint."),
+		DeferAcceptDrop:                    mustCreateMetric("/netstack/tcp/defer_accept_drop", "Number of bare ACKs completing a handshake dropped because of TCP_DEFER_ACCEPT."),
+		OutOfOrderQueued:                   mustCreateMetric("/netstack/tcp/out_of_order_queued", "Number of out-of-order segments queued."),
+		OutOfOrderDropped:                  mustCreateMetric("/netstack/tcp/out_of_order_dropped", "Number of out-of-order segments dropped because the out-of-order queue was full."),
+		OutOfOrderPruned:                   mustCreateMetric("/netstack/tcp/out_of_order_pruned", "Number of out-of-order segments pruned from a full out-of-order queue."),
 	},
 	UDP: tcpip.UDPStats{
 		PacketsReceived:          mustCreateMetric("/netstack/udp/packets_received", "Number of UDP datagrams received via HandlePacket."),
@@ -225,13 +244,50 @@ var Metrics = tcpip.Stats{
 		PacketSendErrors:         mustCreateMetric("/netstack/udp/packet_send_errors", "Number of UDP datagrams failed to be sent."),
 		ChecksumErrors:           mustCreateMetric("/netstack/udp/checksum_errors", "Number of UDP datagrams dropped due to bad checksums."),
 	},
+	UDPLite: tcpip.UDPStats{
+		PacketsReceived:          mustCreateMetric("/netstack/udplite/packets_received", "Number of UDP-Lite datagrams received via HandlePacket."),
+		UnknownPortErrors:        mustCreateMetric("/netstack/udplite/unknown_port_errors", "Number of incoming UDP-Lite datagrams dropped because they did not have a known destination port."),
+		ReceiveBufferErrors:      mustCreateMetric("/netstack/udplite/receive_buffer_errors", "Number of incoming UDP-Lite datagrams dropped due to the receiving buffer being in an invalid state."),
+		MalformedPacketsReceived: mustCreateMetric("/netstack/udplite/malformed_packets_received", "Number of incoming UDP-Lite datagrams dropped due to the UDP-Lite header being in a malformed state."),
+		PacketsSent:              mustCreateMetric("/netstack/udplite/packets_sent", "Number of UDP-Lite datagrams sent."),
+		PacketSendErrors:         mustCreateMetric("/netstack/udplite/packet_send_errors", "Number of UDP-Lite datagrams failed to be sent."),
+		ChecksumErrors:           mustCreateMetric("/netstack/udplite/checksum_errors", "Number of UDP-Lite datagrams dropped due to bad checksums."),
+	},
+	MPTCP: tcpip.MPTCPStats{
+		ActiveOpenings:  mustCreateMetric("/netstack/mptcp/active_openings", "Number of connections opened with MPTCP."),
+		PassiveOpenings: mustCreateMetric("/netstack/mptcp/passive_openings", "Number of connections accepted with MPTCP."),
+		Fallbacks:       mustCreateMetric("/netstack/mptcp/fallbacks", "Number of MPTCP connections which fell back to regular TCP."),
+		JoinsAccepted:   mustCreateMetric("/netstack/mptcp/joins_accepted", "Number of subflows which joined an MPTCP connection."),
+		JoinsFailed:     mustCreateMetric("/netstack/mptcp/joins_failed", "Number of MP_JOIN handshakes which failed."),
+		Reinjections:    mustCreateMetric("/netstack/mptcp/reinjections", "Number of times the data of a failed subflow was retransmitted on the other subflows."),
+	},
+	SCTP: tcpip.SCTPStats{
+		CurrentEstablished:     mustCreateGauge("/netstack/sctp/current_established", "Number of SCTP associations in the ESTABLISHED or SHUTDOWN-PENDING states."),
+		ActiveEstablishments:   mustCreateMetric("/netstack/sctp/active_establishments", "Number of SCTP associations initiated which were established."),
+		PassiveEstablishments:  mustCreateMetric("/netstack/sctp/passive_establishments", "Number of SCTP associations accepted which were established."),
+		Aborts:                 mustCreateMetric("/netstack/sctp/aborts", "Number of SCTP associations aborted."),
+		Shutdowns:              mustCreateMetric("/netstack/sctp/shutdowns", "Number of SCTP associations shut down gracefully."),
+		OutOfTheBlue:           mustCreateMetric("/netstack/sctp/out_of_the_blue", "Number of SCTP packets received which belonged to no association."),
+		ChecksumErrors:         mustCreateMetric("/netstack/sctp/checksum_errors", "Number of SCTP packets dropped due to bad checksums."),
+		InvalidPacketsReceived: mustCreateMetric("/netstack/sctp/invalid_packets_received", "Number of SCTP packets dropped because they were malformed or carried the wrong verification tag."),
+		PacketsSent:            mustCreateMetric("/netstack/sctp/packets_sent", "Number of SCTP packets sent."),
+		PacketsReceived:        mustCreateMetric("/netstack/sctp/packets_received", "Number of SCTP packets received."),
+		DataChunksSent:         mustCreateMetric("/netstack/sctp/data_chunks_sent", "Number of SCTP DATA chunks sent, including retransmissions."),
+		DataChunksReceived:     mustCreateMetric("/netstack/sctp/data_chunks_received", "Number of SCTP DATA chunks received, including duplicates."),
+		Retransmissions:        mustCreateMetric("/netstack/sctp/retransmissions", "Number of SCTP DATA chunks retransmitted after a retransmission timeout."),
+		FastRetransmissions:    mustCreateMetric("/netstack/sctp/fast_retransmissions", "Number of SCTP DATA chunks fast retransmitted."),
+		PathFailovers:          mustCreateMetric("/netstack/sctp/path_failovers", "Number of times an SCTP association switched its primary path."),
+	},
 }
 
 // DefaultTTL is linux's default TTL. All network protocols in all stacks used
 // with this package must have this value set as their default TTL.
 const DefaultTTL = 64
 
-const sizeOfInt32 int = 4
+const (
+	sizeOfUint16 int = 2
+	sizeOfInt32  int = 4
+)
 
 var errStackType = syserr.New("expected but did not receive a netstack.Stack", linux.EINVAL)
 
@@ -290,7 +346,6 @@ type SocketOperations struct {
 	fsutil.FileNotDirReaddir        `state:"nosave"`
 	fsutil.FileNoopFlush            `state:"nosave"`
 	fsutil.FileNoFsync              `state:"nosave"`
-	fsutil.FileNoMMap               `state:"nosave"`
 	fsutil.FileUseInodeUnstableAttr `state:"nosave"`
 
 	socketOpsCommon
@@ -339,6 +394,10 @@ type socketOpsCommon struct {
 	// sockOptInq corresponds to TCP_INQ. It is implemented at this level
 	// because it takes into account data from readView.
 	sockOptInq bool
+
+	// rings are the memory-mapped rings of packet sockets, and nil for the
+	// other sockets.
+	rings *packetRings
 }
 
 // New creates a new endpoint socket.
@@ -356,6 +415,7 @@ func New(t *kernel.Task, family int, skType linux.SockType, protocol int, queue
 			Endpoint: endpoint,
 			skType:   skType,
 			protocol: protocol,
+			rings:    newPacketRings(t, family, skType, queue, endpoint),
 		},
 	}), nil
 }
@@ -377,6 +437,10 @@ func (s *socketOpsCommon) isPacketBased() bool {
 	return s.skType == linux.SOCK_DGRAM || s.skType == linux.SOCK_SEQPACKET || s.skType == linux.SOCK_RDM || s.skType == linux.SOCK_RAW
 }
 
+func (s *socketOpsCommon) isSCTP() bool {
+	return isSCTPSocket(s.skType, s.protocol)
+}
+
 // fetchReadView updates the readView field of the socket if it's currently
 // empty. It assumes that the socket is locked.
 //
@@ -421,6 +485,9 @@ func (s *socketOpsCommon) Release(ctx context.Context) {
 	defer s.EventUnregister(&e)
 
 	s.Endpoint.Close()
+	if s.rings != nil {
+		s.rings.release(ctx)
+	}
 
 	// SO_LINGER option is valid only for TCP. For other socket types
 	// return after endpoint close.
@@ -603,7 +670,16 @@ func (r *readerPayload) Payload(size int) ([]byte, *tcpip.Error) {
 // ReadFrom implements fs.FileOperations.ReadFrom.
 func (s *SocketOperations) ReadFrom(ctx context.Context, _ *fs.File, r io.Reader, count int64) (int64, error) {
 	f := &readerPayload{ctx: ctx, r: r, count: count}
-	n, resCh, err := s.Endpoint.Write(f, tcpip.WriteOptions{
+	var p tcpip.Payloader = f
+	// Splices from regular files let the endpoint borrow the file's pages
+	// rather than copy them.
+	if src, ok := r.(fs.SpliceSource); ok {
+		if fp, ok := newFilePayload(ctx, src, f); ok {
+			defer fp.zc.DecRef()
+			p = fp
+		}
+	}
+	n, resCh, err := s.Endpoint.Write(p, tcpip.WriteOptions{
 		// Reads may be destructive but should be very fast,
 		// so we can't release the lock while copying data.
 		Atomic: true,
@@ -616,7 +692,7 @@ func (s *SocketOperations) ReadFrom(ctx context.Context, _ *fs.File, r io.Reader
 		if err := amutex.Block(ctx, resCh); err != nil {
 			return 0, err
 		}
-		n, _, err = s.Endpoint.Write(f, tcpip.WriteOptions{
+		n, _, err = s.Endpoint.Write(p, tcpip.WriteOptions{
 			Atomic: true, // See above.
 		})
 	}
@@ -632,6 +708,9 @@ func (s *SocketOperations) ReadFrom(ctx context.Context, _ *fs.File, r io.Reader
 // Readiness returns a mask of ready events for socket s.
 func (s *socketOpsCommon) Readiness(mask waiter.EventMask) waiter.EventMask {
 	r := s.Endpoint.Readiness(mask)
+	if s.rings != nil {
+		r = s.rings.readiness(mask, r)
+	}
 
 	// Check our cached value iff the caller asked for readability and the
 	// endpoint itself is currently not readable.
@@ -917,6 +996,9 @@ func (s *SocketOperations) GetSockOpt(t *kernel.Task, level, name int, outPtr us
 		}
 		return &val, nil
 	}
+	if level == linux.SOL_PACKET && s.rings != nil {
+		return s.rings.getSockOpt(t, name, outPtr, outLen)
+	}
 
 	return GetSockOpt(t, s, s.Endpoint, s.family, s.skType, level, name, outPtr, outLen)
 }
@@ -937,8 +1019,16 @@ func GetSockOpt(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, family in
 	case linux.SOL_IP:
 		return getSockOptIP(t, s, ep, name, outPtr, outLen, family)
 
+	case linux.SOL_SCTP:
+		return getSockOptSCTP(t, s, ep, family, name, outLen)
+
+	case linux.SOL_ICMPV6:
+		return getSockOptICMPv6(t, s, ep, name, outLen)
+
+	case linux.SOL_UDPLITE:
+		return getSockOptUDPLite(t, s, ep, name, outLen)
+
 	case linux.SOL_UDP,
-		linux.SOL_ICMPV6,
 		linux.SOL_RAW,
 		linux.SOL_PACKET:
 
@@ -1104,6 +1194,21 @@ func getSockOptSocket(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, fam
 		linger.Linger = int32(v.Timeout.Seconds())
 		return &linger, nil
 
+	case linux.SO_TXTIME:
+		if outLen < linux.SizeOfSockTxtime {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := ep.SocketOptions().GetTxTime()
+		txtime := linux.SockTxtime{ClockID: v.ClockID}
+		if v.DeadlineMode {
+			txtime.Flags |= linux.SOF_TXTIME_DEADLINE_MODE
+		}
+		if v.ReportErrors {
+			txtime.Flags |= linux.SOF_TXTIME_REPORT_ERRORS
+		}
+		return &txtime, nil
+
 	case linux.SO_SNDTIMEO:
 		// TODO(igudger): Linux allows shorter lengths for partial results.
 		if outLen < linux.SizeOfTimeval {
@@ -1156,12 +1261,61 @@ func getSockOptSocket(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, fam
 		vP := primitive.Int32(boolToInt32(v))
 		return &vP, nil
 
+	case linux.SO_ZEROCOPY:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetZeroCopy()))
+		return &v, nil
+
+	case linux.SO_BUSY_POLL:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(ep.SocketOptions().GetBusyPoll() / time.Microsecond)
+		return &v, nil
+
+	case linux.SO_MARK:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(ep.SocketOptions().GetMark())
+		return &v, nil
+
+	case linux.SO_TIMESTAMPING:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(ep.SocketOptions().GetTimestamping())
+		return &v, nil
+
 	default:
 		socket.GetSockOptEmitUnimplementedEvent(t, name)
 	}
 	return nil, syserr.ErrProtocolNotAvailable
 }
 
+// tcpCaState translates a netstack congestion control state to its Linux
+// TCP_CA_* value.
+func tcpCaState(state tcpip.TCPCongestionControlState) uint8 {
+	switch state {
+	case tcpip.TCPStateDisorder:
+		return linux.TCP_CA_Disorder
+	case tcpip.TCPStateRecovery:
+		return linux.TCP_CA_Recovery
+	case tcpip.TCPStateLoss:
+		return linux.TCP_CA_Loss
+	case tcpip.TCPStateCWR:
+		return linux.TCP_CA_CWR
+	default:
+		return linux.TCP_CA_Open
+	}
+}
+
 // getSockOptTCP implements GetSockOpt when level is SOL_TCP.
 func getSockOptTCP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name, outLen int) (marshal.Marshallable, *syserr.Error) {
 	if _, skType, skProto := s.Type(); !isTCPSocket(skType, skProto) {
@@ -1260,9 +1414,108 @@ func getSockOptTCP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name,
 			return nil, syserr.TranslateNetstackError(err)
 		}
 
-		// TODO(b/64800844): Translate fields once they are added to
-		// tcpip.TCPInfoOption.
-		info := linux.TCPInfo{}
+		info := linux.TCPInfo{
+			State:        uint8(s.State()),
+			CaState:      tcpCaState(v.CcState),
+			Retransmits:  v.Retransmits,
+			Probes:       v.Probes,
+			Backoff:      v.Retransmits,
+			WindowScale:  v.SndWndScale | v.RcvWndScale<<4,
+			RTO:          uint32(v.RTO.Microseconds()),
+			SndMss:       v.SndMSS,
+			RcvMss:       v.RcvMSS,
+			Unacked:      v.Unacked,
+			Sacked:       v.Sacked,
+			Lost:         v.Lost,
+			Retrans:      v.Retrans,
+			LastDataSent: uint32(v.LastDataSent.Milliseconds()),
+			LastDataRecv: uint32(v.LastDataRecv.Milliseconds()),
+			LastAckRecv:  uint32(v.LastAckRecv.Milliseconds()),
+			PMTU:         v.PMTU,
+			RcvSsthresh:  v.RcvWnd,
+			RTT:          uint32(v.RTT.Microseconds()),
+			RTTVar:       uint32(v.RTTVar.Microseconds()),
+			SndSsthresh:  v.SndSsthresh,
+			SndCwnd:      v.SndCwnd,
+			Advmss:       v.RcvMSS,
+			Reordering:   v.Reordering,
+			RcvRTT:       uint32(v.RcvRTT.Microseconds()),
+			RcvSpace:     v.RcvSpace,
+			TotalRetrans: v.TotalRetrans,
+			PacingRate:   v.PacingRate,
+			// Pacing is not capped with SO_MAX_PACING_RATE.
+			MaxPacingRate: ^uint64(0),
+			BytesAcked:    v.BytesAcked,
+			BytesReceived: v.BytesReceived,
+			SegsOut:       v.SegsOut,
+			SegsIn:        v.SegsIn,
+			NotSentBytes:  v.NotSentBytes,
+			MinRTT:        uint32(v.MinRTT.Microseconds()),
+			DataSegsIn:    v.DataSegsIn,
+			DataSegsOut:   v.DataSegsOut,
+			DeliveryRate:  v.DeliveryRate,
+			BusyTime:      uint64(v.BusyTime.Microseconds()),
+			RwndLimited:   uint64(v.RwndLimited.Microseconds()),
+			SndBufLimited: uint64(v.SndBufLimited.Microseconds()),
+			Delivered:     v.Delivered,
+			DeliveredCE:   v.DeliveredCE,
+			BytesSent:     v.BytesSent,
+			BytesRetrans:  v.BytesRetrans,
+			DSACKDups:     v.DSACKDups,
+			ReordSeen:     v.ReordSeen,
+			RcvOOOPack:    v.RcvOOOPack,
+			SndWnd:        v.SndWnd,
+		}
+		if v.Timestamps {
+			info.Options |= linux.TCPI_OPT_TIMESTAMPS
+		}
+		if v.SACK {
+			info.Options |= linux.TCPI_OPT_SACK
+		}
+		if v.WindowScale {
+			info.Options |= linux.TCPI_OPT_WSCALE
+		}
+		if v.ECN {
+			info.Options |= linux.TCPI_OPT_ECN
+		}
+		if v.ECNSeen {
+			info.Options |= linux.TCPI_OPT_ECN_SEEN
+		}
+		if v.DeliveryRateAppLimited {
+			info.DeliveryRateAppLimited = 1
+		}
+
+		// Linux truncates the output binary to outLen.
+		buf := t.CopyScratchBuffer(info.SizeBytes())
+		info.MarshalUnsafe(buf)
+		if len(buf) > outLen {
+			buf = buf[:outLen]
+		}
+		bufP := primitive.ByteSlice(buf)
+		return &bufP, nil
+
+	case linux.TCP_AO_INFO:
+		var v tcpip.TCPAOInfoOption
+		if err := ep.GetSockOpt(&v); err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+		info := linux.TCPAOInfoOpt{
+			CurrentKey:     v.Current,
+			RNext:          v.RNext,
+			PktGood:        v.Good,
+			PktBad:         v.Bad,
+			PktKeyNotFound: v.KeyNotFound,
+			PktAORequired:  v.AORequired,
+		}
+		if v.SetCurrent {
+			info.Flags |= linux.TCP_AO_SET_CURRENT
+		}
+		if v.SetRNext {
+			info.Flags |= linux.TCP_AO_SET_RNEXT
+		}
+		if v.Required {
+			info.Flags |= linux.TCP_AO_REQUIRED
+		}
 
 		// Linux truncates the output binary to outLen.
 		buf := t.CopyScratchBuffer(info.SizeBytes())
@@ -1274,7 +1527,6 @@ func getSockOptTCP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name,
 		return &bufP, nil
 
 	case linux.TCP_CC_INFO,
-		linux.TCP_NOTSENT_LOWAT,
 		linux.TCP_ZEROCOPY_RECEIVE:
 
 		t.Kernel().EmitUnimplementedEvent(t)
@@ -1322,6 +1574,19 @@ func getSockOptTCP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name,
 		}
 		return &lingerTimeout, nil
 
+	case linux.TCP_NOTSENT_LOWAT:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		var v tcpip.TCPNotSentLowatOption
+		if err := ep.GetSockOpt(&v); err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+
+		lowat := primitive.Int32(v)
+		return &lowat, nil
+
 	case linux.TCP_DEFER_ACCEPT:
 		if outLen < sizeOfInt32 {
 			return nil, syserr.ErrInvalidArgument
@@ -1358,6 +1623,60 @@ func getSockOptTCP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name,
 		}
 		vP := primitive.Int32(v)
 		return &vP, nil
+
+	case linux.TCP_REPAIR:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v, err := ep.GetSockOptInt(tcpip.TCPRepairOption)
+		if err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+		vP := primitive.Int32(v)
+		return &vP, nil
+
+	case linux.TCP_REPAIR_QUEUE:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v, err := ep.GetSockOptInt(tcpip.TCPRepairQueueOption)
+		if err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+		vP := primitive.Int32(v)
+		return &vP, nil
+
+	case linux.TCP_QUEUE_SEQ:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v, err := ep.GetSockOptInt(tcpip.TCPQueueSeqOption)
+		if err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+		vP := primitive.Int32(v)
+		return &vP, nil
+
+	case linux.TCP_REPAIR_WINDOW:
+		if outLen != tcpRepairWindowSize {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		var v tcpip.TCPRepairWindowOption
+		if err := ep.GetSockOpt(&v); err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+		return &linux.TCPRepairWindow{
+			SndWl1:    v.SndWl1,
+			SndWnd:    v.SndWnd,
+			MaxWindow: v.MaxWindow,
+			RcvWnd:    v.RcvWnd,
+			RcvWup:    v.RcvWup,
+		}, nil
+
 	default:
 		emitUnimplementedEventTCP(t, name)
 	}
@@ -1388,6 +1707,65 @@ func getSockOptIPv6(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name
 	case linux.IPV6_PATHMTU:
 		t.Kernel().EmitUnimplementedEvent(t)
 
+	case linux.IPV6_MTU:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v, err := ep.GetSockOptInt(tcpip.PathMTUOption)
+		if err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+
+		vP := primitive.Int32(v)
+		return &vP, nil
+
+	case linux.MRT6_VERSION:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(linux.MRT6_VERSION_NUMBER)
+		return &v, nil
+
+	case linux.MRT6_ASSERT, linux.MRT6_PIM:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		// Neither PIM nor assert upcalls are supported.
+		var v primitive.Int32
+		return &v, nil
+
+	case linux.IPV6_CHECKSUM:
+		if skType != linux.SOCK_RAW {
+			return nil, syserr.ErrProtocolNotAvailable
+		}
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v, err := ep.GetSockOptInt(tcpip.IPv6ChecksumOption)
+		if err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+
+		vP := primitive.Int32(v)
+		return &vP, nil
+
+	case linux.IPV6_MTU_DISCOVER:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v, err := ep.GetSockOptInt(tcpip.MTUDiscoverOption)
+		if err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+
+		vP := primitive.Int32(fromPMTUDiscoverySetting(v))
+		return &vP, nil
+
 	case linux.IPV6_TCLASS:
 		// Length handling for parity with Linux.
 		if outLen == 0 {
@@ -1418,6 +1796,38 @@ func getSockOptIPv6(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name
 		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetReceiveTClass()))
 		return &v, nil
 
+	case linux.IPV6_FREEBIND:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetFreeBind()))
+		return &v, nil
+
+	case linux.IPV6_RECVERR:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetRecvError()))
+		return &v, nil
+
+	case linux.IPV6_FLOWINFO:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetReceiveFlowInfo()))
+		return &v, nil
+
+	case linux.IPV6_FLOWINFO_SEND:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetSendFlowInfo()))
+		return &v, nil
+
 	case linux.IP6T_ORIGINAL_DST:
 		if outLen < int(binary.Size(linux.SockAddrInet6{})) {
 			return nil, syserr.ErrInvalidArgument
@@ -1471,6 +1881,26 @@ func getSockOptIPv6(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name
 		}
 		return &entries, nil
 
+	case linux.IP6T_SO_GET_REVISION_MATCH:
+		if outLen < linux.SizeOfXTGetRevision {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		// Only valid for raw IPv6 sockets.
+		if skType != linux.SOCK_RAW {
+			return nil, syserr.ErrProtocolNotAvailable
+		}
+
+		stack := inet.StackFromContext(t)
+		if stack == nil {
+			return nil, syserr.ErrNoDevice
+		}
+		ret, err := netfilter.MatchRevision(t, outPtr)
+		if err != nil {
+			return nil, err
+		}
+		return &ret, nil
+
 	case linux.IP6T_SO_GET_REVISION_TARGET:
 		if outLen < linux.SizeOfXTGetRevision {
 			return nil, syserr.ErrInvalidArgument
@@ -1523,6 +1953,19 @@ func getSockOptIP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name in
 
 		return &vP, nil
 
+	case linux.IP_MTU:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v, err := ep.GetSockOptInt(tcpip.PathMTUOption)
+		if err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+
+		vP := primitive.Int32(v)
+		return &vP, nil
+
 	case linux.IP_MULTICAST_TTL:
 		if outLen < sizeOfInt32 {
 			return nil, syserr.ErrInvalidArgument
@@ -1558,6 +2001,23 @@ func getSockOptIP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name in
 		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetMulticastLoop()))
 		return &v, nil
 
+	case linux.IP_MTU_DISCOVER:
+		// Length handling for parity with Linux.
+		if outLen == 0 {
+			var b primitive.ByteSlice
+			return &b, nil
+		}
+		v, err := ep.GetSockOptInt(tcpip.MTUDiscoverOption)
+		if err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+		if outLen < sizeOfInt32 {
+			vP := primitive.Uint8(fromPMTUDiscoverySetting(v))
+			return &vP, nil
+		}
+		vP := primitive.Int32(fromPMTUDiscoverySetting(v))
+		return &vP, nil
+
 	case linux.IP_TOS:
 		// Length handling for parity with Linux.
 		if outLen == 0 {
@@ -1583,6 +2043,22 @@ func getSockOptIP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name in
 		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetReceiveTOS()))
 		return &v, nil
 
+	case linux.IP_FREEBIND:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetFreeBind()))
+		return &v, nil
+
+	case linux.IP_RECVERR:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetRecvError()))
+		return &v, nil
+
 	case linux.IP_PKTINFO:
 		if outLen < sizeOfInt32 {
 			return nil, syserr.ErrInvalidArgument
@@ -1652,7 +2128,7 @@ func getSockOptIP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name in
 		}
 		return &entries, nil
 
-	case linux.IPT_SO_GET_REVISION_TARGET:
+	case linux.IPT_SO_GET_REVISION_MATCH:
 		if outLen < linux.SizeOfXTGetRevision {
 			return nil, syserr.ErrInvalidArgument
 		}
@@ -1666,24 +2142,277 @@ func getSockOptIP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name in
 		if stack == nil {
 			return nil, syserr.ErrNoDevice
 		}
-		ret, err := netfilter.TargetRevision(t, outPtr, header.IPv4ProtocolNumber)
+		ret, err := netfilter.MatchRevision(t, outPtr)
 		if err != nil {
 			return nil, err
 		}
 		return &ret, nil
 
-	default:
-		emitUnimplementedEventIP(t, name)
-	}
-	return nil, syserr.ErrProtocolNotAvailable
-}
+	case linux.IPT_SO_GET_REVISION_TARGET:
+		if outLen < linux.SizeOfXTGetRevision {
+			return nil, syserr.ErrInvalidArgument
+		}
 
-// SetSockOpt implements the linux syscall setsockopt(2) for sockets backed by
-// tcpip.Endpoint.
-func (s *SocketOperations) SetSockOpt(t *kernel.Task, level int, name int, optVal []byte) *syserr.Error {
-	// TODO(b/78348848): Unlike other socket options, SO_TIMESTAMP is
-	// implemented specifically for netstack.SocketOperations rather than
-	// commonEndpoint. commonEndpoint should be extended to support socket
+		// Only valid for raw IPv4 sockets.
+		if family, skType, _ := s.Type(); family != linux.AF_INET || skType != linux.SOCK_RAW {
+			return nil, syserr.ErrProtocolNotAvailable
+		}
+
+		stack := inet.StackFromContext(t)
+		if stack == nil {
+			return nil, syserr.ErrNoDevice
+		}
+		ret, err := netfilter.TargetRevision(t, outPtr, header.IPv4ProtocolNumber)
+		if err != nil {
+			return nil, err
+		}
+		return &ret, nil
+
+	case linux.MRT_VERSION:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(linux.MRT_VERSION_NUMBER)
+		return &v, nil
+
+	case linux.MRT_ASSERT, linux.MRT_PIM:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		// Neither PIM nor assert upcalls are supported.
+		var v primitive.Int32
+		return &v, nil
+
+	default:
+		emitUnimplementedEventIP(t, name)
+	}
+	return nil, syserr.ErrProtocolNotAvailable
+}
+
+// getSockOptICMPv6 implements GetSockOpt when level is SOL_ICMPV6.
+func getSockOptICMPv6(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name, outLen int) (marshal.Marshallable, *syserr.Error) {
+	if family, skType, skProto := s.Type(); family != linux.AF_INET6 || !isICMPSocket(skType, skProto) {
+		log.Warningf("SOL_ICMPV6 options are only supported on ICMPv6 sockets: skType, skProto = %v, %d", skType, skProto)
+		return nil, syserr.ErrUnknownProtocolOption
+	}
+
+	switch name {
+	case linux.ICMPV6_FILTER:
+		if outLen < linux.SizeOfICMP6Filter {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		var v tcpip.ICMPv6FilterOption
+		if err := ep.GetSockOpt(&v); err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+		return &linux.ICMP6Filter{Data: v.DenyType}, nil
+
+	default:
+		t.Kernel().EmitUnimplementedEvent(t)
+	}
+	return nil, syserr.ErrProtocolNotAvailable
+}
+
+// getSockOptUDPLite implements GetSockOpt when level is SOL_UDPLITE.
+func getSockOptUDPLite(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name, outLen int) (marshal.Marshallable, *syserr.Error) {
+	if _, skType, skProto := s.Type(); !isUDPSocket(skType, skProto) {
+		log.Warningf("SOL_UDPLITE options are only supported on UDP sockets: skType, skProto = %v, %d", skType, skProto)
+		return nil, syserr.ErrUnknownProtocolOption
+	}
+
+	switch name {
+	case linux.UDPLITE_SEND_CSCOV, linux.UDPLITE_RECV_CSCOV:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		opt := tcpip.UDPLiteSendCsCovOption
+		if name == linux.UDPLITE_RECV_CSCOV {
+			opt = tcpip.UDPLiteRecvCsCovOption
+		}
+		v, err := ep.GetSockOptInt(opt)
+		if err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+		vP := primitive.Int32(v)
+		return &vP, nil
+
+	default:
+		t.Kernel().EmitUnimplementedEvent(t)
+	}
+	return nil, syserr.ErrProtocolNotAvailable
+}
+
+// getSockOptSCTP implements GetSockOpt when level is SOL_SCTP.
+func getSockOptSCTP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, family, name, outLen int) (marshal.Marshallable, *syserr.Error) {
+	if _, skType, skProto := s.Type(); !isSCTPSocket(skType, skProto) {
+		log.Warningf("SOL_SCTP options are only supported on SCTP sockets: skType, skProto = %v, %d", skType, skProto)
+		return nil, syserr.ErrUnknownProtocolOption
+	}
+
+	switch name {
+	case linux.SCTP_NODELAY:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(boolToInt32(!ep.SocketOptions().GetDelayOption()))
+		return &v, nil
+
+	case linux.SCTP_MAXSEG:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v, err := ep.GetSockOptInt(tcpip.MaxSegOption)
+		if err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+		vP := primitive.Int32(v)
+		return &vP, nil
+
+	case linux.SCTP_RECVRCVINFO:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v, err := ep.GetSockOptInt(tcpip.SCTPRecvRcvInfoOption)
+		if err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+		vP := primitive.Int32(v)
+		return &vP, nil
+
+	case linux.SCTP_RTOINFO:
+		if outLen < linux.SizeOfSCTPRTOInfo {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		var v tcpip.SCTPRTOInfoOption
+		if err := ep.GetSockOpt(&v); err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+		return &linux.SCTPRTOInfo{
+			Initial: uint32(v.Initial.Milliseconds()),
+			Max:     uint32(v.Max.Milliseconds()),
+			Min:     uint32(v.Min.Milliseconds()),
+		}, nil
+
+	case linux.SCTP_INITMSG:
+		if outLen < linux.SizeOfSCTPInitMsg {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		var v tcpip.SCTPInitMsgOption
+		if err := ep.GetSockOpt(&v); err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+		return &linux.SCTPInitMsg{
+			NumOutStreams:  v.OutboundStreams,
+			MaxInStreams:   v.MaxInboundStreams,
+			MaxAttempts:    v.MaxAttempts,
+			MaxInitTimeout: uint16(v.MaxInitTimeout.Milliseconds()),
+		}, nil
+
+	case linux.SCTP_DEFAULT_SNDINFO:
+		if outLen < linux.SizeOfSCTPSndInfo {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		var v tcpip.SCTPDefaultSndInfoOption
+		if err := ep.GetSockOpt(&v); err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+		info := linux.SCTPSndInfo{
+			SID:  v.Stream,
+			PPID: v.PPID,
+		}
+		if v.Unordered {
+			info.Flags |= linux.SCTP_UNORDERED
+		}
+		return &info, nil
+
+	case linux.SCTP_STATUS:
+		if outLen < linux.SizeOfSCTPStatus {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		var v tcpip.SCTPStatusOption
+		if err := ep.GetSockOpt(&v); err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+		return &linux.SCTPStatus{
+			State:              sctpStateToLinux(sctp.EndpointState(v.State)),
+			Rwnd:               v.ReceiveWindow,
+			UnackedData:        v.UnackedData,
+			PendingData:        v.PendingData,
+			InStreams:          v.InboundStreams,
+			OutStreams:         v.OutboundStreams,
+			FragmentationPoint: v.FragmentationPoint,
+			Primary:            sctpPaddrInfo(family, v.Primary),
+		}, nil
+
+	default:
+		t.Kernel().EmitUnimplementedEvent(t)
+	}
+	return nil, syserr.ErrProtocolNotAvailable
+}
+
+// sctpStateToLinux returns the state of an SCTP association as reported by
+// SCTP_STATUS.
+func sctpStateToLinux(state sctp.EndpointState) int32 {
+	switch state {
+	case sctp.StateCookieWait:
+		return linux.SCTP_COOKIE_WAIT
+	case sctp.StateCookieEchoed:
+		return linux.SCTP_COOKIE_ECHOED
+	case sctp.StateEstablished:
+		return linux.SCTP_ESTABLISHED
+	case sctp.StateShutdownPending:
+		return linux.SCTP_SHUTDOWN_PENDING
+	case sctp.StateShutdownSent:
+		return linux.SCTP_SHUTDOWN_SENT
+	case sctp.StateShutdownReceived:
+		return linux.SCTP_SHUTDOWN_RECEIVED
+	case sctp.StateShutdownAckSent:
+		return linux.SCTP_SHUTDOWN_ACK_SENT
+	case sctp.StateClosed:
+		return linux.SCTP_CLOSED
+	default:
+		return linux.SCTP_EMPTY
+	}
+}
+
+// sctpPaddrInfo converts the description of a path of an SCTP association to
+// a struct sctp_paddrinfo.
+func sctpPaddrInfo(family int, p tcpip.SCTPPathInfo) linux.SCTPPaddrInfo {
+	info := linux.SCTPPaddrInfo{
+		State: linux.SCTP_INACTIVE,
+		Cwnd:  p.Cwnd,
+		SRTT:  uint32(p.SRTT.Milliseconds()),
+		RTO:   uint32(p.RTO.Milliseconds()),
+		MTU:   p.MTU,
+	}
+	switch {
+	case !p.Confirmed:
+		info.State = linux.SCTP_UNCONFIRMED
+	case p.Active:
+		info.State = linux.SCTP_ACTIVE
+	}
+	a, l := socket.ConvertAddress(family, p.Address)
+	a.MarshalBytes(info.Address[:l])
+	return info
+}
+
+// SetSockOpt implements the linux syscall setsockopt(2) for sockets backed by
+// tcpip.Endpoint.
+func (s *SocketOperations) SetSockOpt(t *kernel.Task, level int, name int, optVal []byte) *syserr.Error {
+	// TODO(b/78348848): Unlike other socket options, SO_TIMESTAMP is
+	// implemented specifically for netstack.SocketOperations rather than
+	// commonEndpoint. commonEndpoint should be extended to support socket
 	// options where the implementation is not shared, as unix sockets need
 	// their own support for SO_TIMESTAMP.
 	if level == linux.SOL_SOCKET && name == linux.SO_TIMESTAMP {
@@ -1704,6 +2433,9 @@ func (s *SocketOperations) SetSockOpt(t *kernel.Task, level int, name int, optVa
 		s.sockOptInq = usermem.ByteOrder.Uint32(optVal) != 0
 		return nil
 	}
+	if level == linux.SOL_PACKET && s.rings != nil {
+		return s.rings.setSockOpt(t, name, optVal)
+	}
 
 	return SetSockOpt(t, s, s.Endpoint, level, name, optVal)
 }
@@ -1724,6 +2456,15 @@ func SetSockOpt(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, level int
 	case linux.SOL_IP:
 		return setSockOptIP(t, s, ep, name, optVal)
 
+	case linux.SOL_SCTP:
+		return setSockOptSCTP(t, s, ep, name, optVal)
+
+	case linux.SOL_ICMPV6:
+		return setSockOptICMPv6(t, s, ep, name, optVal)
+
+	case linux.SOL_UDPLITE:
+		return setSockOptUDPLite(t, s, ep, name, optVal)
+
 	case linux.SOL_PACKET:
 		// gVisor doesn't support any SOL_PACKET options just return not
 		// supported. Returning nil here will result in tcpdump thinking AF_PACKET
@@ -1732,7 +2473,6 @@ func SetSockOpt(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, level int
 		return syserr.ErrProtocolNotAvailable
 
 	case linux.SOL_UDP,
-		linux.SOL_ICMPV6,
 		linux.SOL_RAW:
 
 		t.Kernel().EmitUnimplementedEvent(t)
@@ -1893,11 +2633,138 @@ func setSockOptSocket(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, nam
 				Enabled: v.OnOff != 0,
 				Timeout: time.Second * time.Duration(v.Linger)}))
 
+	case linux.SO_TXTIME:
+		if len(optVal) != linux.SizeOfSockTxtime {
+			return syserr.ErrInvalidArgument
+		}
+
+		var v linux.SockTxtime
+		binary.Unmarshal(optVal, usermem.ByteOrder, &v)
+		if v.ClockID != linux.CLOCK_MONOTONIC && !t.HasCapability(linux.CAP_NET_ADMIN) {
+			return syserr.ErrNotPermitted
+		}
+		switch v.ClockID {
+		case linux.CLOCK_MONOTONIC, linux.CLOCK_REALTIME, linux.CLOCK_TAI:
+		default:
+			return syserr.ErrInvalidArgument
+		}
+		if v.Flags&^linux.SOF_TXTIME_FLAGS_MASK != 0 {
+			return syserr.ErrInvalidArgument
+		}
+
+		ep.SocketOptions().SetTxTime(tcpip.TxTimeOption{
+			Enabled:      true,
+			ClockID:      v.ClockID,
+			DeadlineMode: v.Flags&linux.SOF_TXTIME_DEADLINE_MODE != 0,
+			ReportErrors: v.Flags&linux.SOF_TXTIME_REPORT_ERRORS != 0,
+		})
+		return nil
+
 	case linux.SO_DETACH_FILTER:
 		// optval is ignored.
 		var v tcpip.SocketDetachFilterOption
 		return syserr.TranslateNetstackError(ep.SetSockOpt(&v))
 
+	case linux.SO_ZEROCOPY:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+
+		// Like Linux, only TCP and UDP sockets support MSG_ZEROCOPY.
+		family, skType, skProto := s.Type()
+		if family != linux.AF_INET && family != linux.AF_INET6 {
+			return syserr.ErrNotSupported
+		}
+		if !(isUDPSocket(skType, skProto) && skProto != linux.IPPROTO_UDPLITE) && !(isTCPSocket(skType, skProto) && skProto != linux.IPPROTO_MPTCP) {
+			return syserr.ErrNotSupported
+		}
+
+		v := usermem.ByteOrder.Uint32(optVal)
+		if v > 1 {
+			return syserr.ErrInvalidArgument
+		}
+		ep.SocketOptions().SetZeroCopy(v != 0)
+		return nil
+
+	case linux.SO_BUSY_POLL:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+
+		v := int32(usermem.ByteOrder.Uint32(optVal))
+		if v < 0 {
+			return syserr.ErrInvalidArgument
+		}
+		// Like Linux, only CAP_NET_ADMIN allows raising the budget.
+		budget := time.Duration(v) * time.Microsecond
+		if budget > ep.SocketOptions().GetBusyPoll() && !t.HasCapability(linux.CAP_NET_ADMIN) {
+			return syserr.ErrNotPermitted
+		}
+		ep.SocketOptions().SetBusyPoll(budget)
+		return nil
+
+	case linux.SO_MARK:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+
+		// Like Linux, only CAP_NET_ADMIN allows changing the routing of the
+		// packets of the socket with its mark.
+		if !t.HasCapability(linux.CAP_NET_ADMIN) {
+			return syserr.ErrNotPermitted
+		}
+		ep.SocketOptions().SetMark(usermem.ByteOrder.Uint32(optVal))
+		return nil
+
+	case linux.SO_TIMESTAMPING:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+
+		v := tcpip.TimestampingFlags(usermem.ByteOrder.Uint32(optVal))
+		if v&^tcpip.TimestampingMask != 0 {
+			return syserr.ErrInvalidArgument
+		}
+		if v&tcpip.TimestampingOptStats != 0 && v&tcpip.TimestampingOptTsonly == 0 {
+			return syserr.ErrInvalidArgument
+		}
+		if v&tcpip.TimestampingOptID != 0 && ep.SocketOptions().GetTimestamping()&tcpip.TimestampingOptID == 0 {
+			// Like Linux, TCP sockets must be connected to correlate
+			// their timestamps with the bytes they send.
+			if _, skType, skProto := s.Type(); isTCPSocket(skType, skProto) {
+				switch tcp.EndpointState(ep.State()) {
+				case tcp.StateInitial, tcp.StateBound, tcp.StateClose, tcp.StateListen:
+					return syserr.ErrInvalidArgument
+				}
+			}
+		}
+		ep.SocketOptions().SetTimestamping(v)
+		return nil
+
+	case linux.SO_ATTACH_REUSEPORT_CBPF:
+		family, skType, skProto := s.Type()
+		if family != linux.AF_INET && family != linux.AF_INET6 {
+			return syserr.ErrNotSupported
+		}
+		if !isUDPSocket(skType, skProto) && !(isTCPSocket(skType, skProto) && skProto != linux.IPPROTO_MPTCP) {
+			return syserr.ErrNotSupported
+		}
+
+		f, err := copyInReusePortFilter(t, optVal)
+		if err != nil {
+			return err
+		}
+		ep.SocketOptions().SetReusePortFilter(f)
+		return nil
+
+	case linux.SO_DETACH_REUSEPORT_BPF:
+		// optval is ignored.
+		if ep.SocketOptions().GetReusePortFilter() == nil {
+			return syserr.ErrNoFileOrDir
+		}
+		ep.SocketOptions().SetReusePortFilter(nil)
+		return nil
+
 	default:
 		socket.SetSockOptEmitUnimplementedEvent(t, name)
 	}
@@ -2011,6 +2878,14 @@ func setSockOptTCP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name i
 		opt := tcpip.TCPLingerTimeoutOption(time.Second * time.Duration(v))
 		return syserr.TranslateNetstackError(ep.SetSockOpt(&opt))
 
+	case linux.TCP_NOTSENT_LOWAT:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+
+		opt := tcpip.TCPNotSentLowatOption(usermem.ByteOrder.Uint32(optVal))
+		return syserr.TranslateNetstackError(ep.SetSockOpt(&opt))
+
 	case linux.TCP_DEFER_ACCEPT:
 		if len(optVal) < sizeOfInt32 {
 			return syserr.ErrInvalidArgument
@@ -2038,22 +2913,237 @@ func setSockOptTCP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name i
 
 		return syserr.TranslateNetstackError(ep.SetSockOptInt(tcpip.TCPWindowClampOption, int(v)))
 
-	case linux.TCP_REPAIR_OPTIONS:
-		t.Kernel().EmitUnimplementedEvent(t)
+	case linux.TCP_MD5SIG, linux.TCP_MD5SIG_EXT:
+		opt, err := copyInTCPMD5Sig(optVal, name == linux.TCP_MD5SIG_EXT)
+		if err != nil {
+			return err
+		}
+		return syserr.TranslateNetstackError(ep.SetSockOpt(&opt))
 
-	default:
-		emitUnimplementedEventTCP(t, name)
-	}
+	case linux.TCP_AO_ADD_KEY:
+		opt, err := copyInTCPAOAdd(optVal)
+		if err != nil {
+			return err
+		}
+		return syserr.TranslateNetstackError(ep.SetSockOpt(&opt))
 
-	return nil
-}
+	case linux.TCP_AO_DEL_KEY:
+		opt, err := copyInTCPAODel(optVal)
+		if err != nil {
+			return err
+		}
+		return syserr.TranslateNetstackError(ep.SetSockOpt(&opt))
 
-// setSockOptIPv6 implements SetSockOpt when level is SOL_IPV6.
-func setSockOptIPv6(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name int, optVal []byte) *syserr.Error {
-	if _, ok := ep.(tcpip.Endpoint); !ok {
-		log.Warningf("SOL_IPV6 options not supported on endpoints other than tcpip.Endpoint: option = %d", name)
-		return syserr.ErrUnknownProtocolOption
-	}
+	case linux.TCP_AO_INFO:
+		if len(optVal) < tcpAOInfoOptSize {
+			return syserr.ErrInvalidArgument
+		}
+		var info linux.TCPAOInfoOpt
+		binary.Unmarshal(optVal[:tcpAOInfoOptSize], usermem.ByteOrder, &info)
+		opt := tcpip.TCPAOInfoOption{
+			SetCurrent:  info.Flags&linux.TCP_AO_SET_CURRENT != 0,
+			Current:     info.CurrentKey,
+			SetRNext:    info.Flags&linux.TCP_AO_SET_RNEXT != 0,
+			RNext:       info.RNext,
+			Required:    info.Flags&linux.TCP_AO_REQUIRED != 0,
+			SetCounters: info.Flags&linux.TCP_AO_SET_COUNTERS != 0,
+			Good:        info.PktGood,
+			Bad:         info.PktBad,
+			KeyNotFound: info.PktKeyNotFound,
+			AORequired:  info.PktAORequired,
+		}
+		return syserr.TranslateNetstackError(ep.SetSockOpt(&opt))
+
+	case linux.TCP_REPAIR:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+		if !t.HasCapability(linux.CAP_NET_ADMIN) {
+			return syserr.ErrNotPermitted
+		}
+		v := int32(usermem.ByteOrder.Uint32(optVal))
+		return syserr.TranslateNetstackError(ep.SetSockOptInt(tcpip.TCPRepairOption, int(v)))
+
+	case linux.TCP_REPAIR_QUEUE:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+		v := usermem.ByteOrder.Uint32(optVal)
+		return syserr.TranslateNetstackError(ep.SetSockOptInt(tcpip.TCPRepairQueueOption, int(v)))
+
+	case linux.TCP_QUEUE_SEQ:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+		v := usermem.ByteOrder.Uint32(optVal)
+		return syserr.TranslateNetstackError(ep.SetSockOptInt(tcpip.TCPQueueSeqOption, int(v)))
+
+	case linux.TCP_REPAIR_OPTIONS:
+		opt, err := copyInTCPRepairOptions(optVal)
+		if err != nil {
+			return err
+		}
+		return syserr.TranslateNetstackError(ep.SetSockOpt(&opt))
+
+	case linux.TCP_REPAIR_WINDOW:
+		if len(optVal) != tcpRepairWindowSize {
+			return syserr.ErrInvalidArgument
+		}
+		var w linux.TCPRepairWindow
+		binary.Unmarshal(optVal, usermem.ByteOrder, &w)
+		opt := tcpip.TCPRepairWindowOption{
+			SndWl1:    w.SndWl1,
+			SndWnd:    w.SndWnd,
+			MaxWindow: w.MaxWindow,
+			RcvWnd:    w.RcvWnd,
+			RcvWup:    w.RcvWup,
+		}
+		return syserr.TranslateNetstackError(ep.SetSockOpt(&opt))
+
+	default:
+		emitUnimplementedEventTCP(t, name)
+	}
+
+	return nil
+}
+
+// setSockOptICMPv6 implements SetSockOpt when level is SOL_ICMPV6.
+func setSockOptICMPv6(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name int, optVal []byte) *syserr.Error {
+	if family, skType, skProto := s.Type(); family != linux.AF_INET6 || !isICMPSocket(skType, skProto) {
+		log.Warningf("SOL_ICMPV6 options are only supported on ICMPv6 sockets: skType, skProto = %v, %d", skType, skProto)
+		return syserr.ErrUnknownProtocolOption
+	}
+
+	switch name {
+	case linux.ICMPV6_FILTER:
+		if len(optVal) < linux.SizeOfICMP6Filter {
+			return syserr.ErrInvalidArgument
+		}
+
+		var v linux.ICMP6Filter
+		binary.Unmarshal(optVal[:linux.SizeOfICMP6Filter], usermem.ByteOrder, &v)
+		return syserr.TranslateNetstackError(ep.SetSockOpt(&tcpip.ICMPv6FilterOption{DenyType: v.Data}))
+
+	default:
+		t.Kernel().EmitUnimplementedEvent(t)
+	}
+
+	return nil
+}
+
+// setSockOptUDPLite implements SetSockOpt when level is SOL_UDPLITE.
+func setSockOptUDPLite(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name int, optVal []byte) *syserr.Error {
+	if _, skType, skProto := s.Type(); !isUDPSocket(skType, skProto) {
+		log.Warningf("SOL_UDPLITE options are only supported on UDP sockets: skType, skProto = %v, %d", skType, skProto)
+		return syserr.ErrUnknownProtocolOption
+	}
+
+	switch name {
+	case linux.UDPLITE_SEND_CSCOV, linux.UDPLITE_RECV_CSCOV:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+
+		opt := tcpip.UDPLiteSendCsCovOption
+		if name == linux.UDPLITE_RECV_CSCOV {
+			opt = tcpip.UDPLiteRecvCsCovOption
+		}
+		v := int32(usermem.ByteOrder.Uint32(optVal))
+		return syserr.TranslateNetstackError(ep.SetSockOptInt(opt, int(v)))
+
+	default:
+		t.Kernel().EmitUnimplementedEvent(t)
+	}
+
+	return syserr.ErrProtocolNotAvailable
+}
+
+// setSockOptSCTP implements SetSockOpt when level is SOL_SCTP.
+func setSockOptSCTP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name int, optVal []byte) *syserr.Error {
+	if _, skType, skProto := s.Type(); !isSCTPSocket(skType, skProto) {
+		log.Warningf("SOL_SCTP options are only supported on SCTP sockets: skType, skProto = %v, %d", skType, skProto)
+		return syserr.ErrUnknownProtocolOption
+	}
+
+	switch name {
+	case linux.SCTP_NODELAY:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+
+		v := usermem.ByteOrder.Uint32(optVal)
+		ep.SocketOptions().SetDelayOption(v == 0)
+		return nil
+
+	case linux.SCTP_MAXSEG:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+
+		v := usermem.ByteOrder.Uint32(optVal)
+		return syserr.TranslateNetstackError(ep.SetSockOptInt(tcpip.MaxSegOption, int(v)))
+
+	case linux.SCTP_RECVRCVINFO:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+
+		v := usermem.ByteOrder.Uint32(optVal)
+		return syserr.TranslateNetstackError(ep.SetSockOptInt(tcpip.SCTPRecvRcvInfoOption, int(v)))
+
+	case linux.SCTP_RTOINFO:
+		if len(optVal) < linux.SizeOfSCTPRTOInfo {
+			return syserr.ErrInvalidArgument
+		}
+
+		var v linux.SCTPRTOInfo
+		binary.Unmarshal(optVal[:linux.SizeOfSCTPRTOInfo], usermem.ByteOrder, &v)
+		return syserr.TranslateNetstackError(ep.SetSockOpt(&tcpip.SCTPRTOInfoOption{
+			Initial: time.Duration(v.Initial) * time.Millisecond,
+			Min:     time.Duration(v.Min) * time.Millisecond,
+			Max:     time.Duration(v.Max) * time.Millisecond,
+		}))
+
+	case linux.SCTP_INITMSG:
+		if len(optVal) < linux.SizeOfSCTPInitMsg {
+			return syserr.ErrInvalidArgument
+		}
+
+		var v linux.SCTPInitMsg
+		binary.Unmarshal(optVal[:linux.SizeOfSCTPInitMsg], usermem.ByteOrder, &v)
+		return syserr.TranslateNetstackError(ep.SetSockOpt(&tcpip.SCTPInitMsgOption{
+			OutboundStreams:   v.NumOutStreams,
+			MaxInboundStreams: v.MaxInStreams,
+			MaxAttempts:       v.MaxAttempts,
+			MaxInitTimeout:    time.Duration(v.MaxInitTimeout) * time.Millisecond,
+		}))
+
+	case linux.SCTP_DEFAULT_SNDINFO:
+		if len(optVal) < linux.SizeOfSCTPSndInfo {
+			return syserr.ErrInvalidArgument
+		}
+
+		var v linux.SCTPSndInfo
+		binary.Unmarshal(optVal[:linux.SizeOfSCTPSndInfo], usermem.ByteOrder, &v)
+		return syserr.TranslateNetstackError(ep.SetSockOpt(&tcpip.SCTPDefaultSndInfoOption{
+			Stream:    v.SID,
+			Unordered: v.Flags&linux.SCTP_UNORDERED != 0,
+			PPID:      v.PPID,
+		}))
+
+	default:
+		t.Kernel().EmitUnimplementedEvent(t)
+	}
+
+	return nil
+}
+
+// setSockOptIPv6 implements SetSockOpt when level is SOL_IPV6.
+func setSockOptIPv6(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name int, optVal []byte) *syserr.Error {
+	if _, ok := ep.(tcpip.Endpoint); !ok {
+		log.Warningf("SOL_IPV6 options not supported on endpoints other than tcpip.Endpoint: option = %d", name)
+		return syserr.ErrUnknownProtocolOption
+	}
 
 	family, skType, skProto := s.Type()
 	if family != linux.AF_INET6 {
@@ -2094,6 +3184,26 @@ func setSockOptIPv6(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name
 
 		t.Kernel().EmitUnimplementedEvent(t)
 
+	case linux.IPV6_CHECKSUM:
+		if skType != linux.SOCK_RAW {
+			return syserr.ErrProtocolNotAvailable
+		}
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+		v := int32(usermem.ByteOrder.Uint32(optVal))
+		return syserr.TranslateNetstackError(ep.SetSockOptInt(tcpip.IPv6ChecksumOption, int(v)))
+
+	case linux.IPV6_MTU_DISCOVER:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+		v, ok := toPMTUDiscoverySetting(int32(usermem.ByteOrder.Uint32(optVal)))
+		if !ok {
+			return syserr.ErrInvalidArgument
+		}
+		return syserr.TranslateNetstackError(ep.SetSockOptInt(tcpip.MTUDiscoverOption, v))
+
 	case linux.IPV6_TCLASS:
 		if len(optVal) < sizeOfInt32 {
 			return syserr.ErrInvalidArgument
@@ -2116,6 +3226,48 @@ func setSockOptIPv6(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name
 		ep.SocketOptions().SetReceiveTClass(v != 0)
 		return nil
 
+	case linux.IPV6_FREEBIND:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+
+		v := usermem.ByteOrder.Uint32(optVal)
+		ep.SocketOptions().SetFreeBind(v != 0)
+		return nil
+
+	case linux.IPV6_RECVERR:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+		v := usermem.ByteOrder.Uint32(optVal)
+		ep.SocketOptions().SetRecvError(v != 0)
+		return nil
+
+	case linux.IPV6_FLOWINFO:
+		v, err := parseIntOrChar(optVal)
+		if err != nil {
+			return err
+		}
+
+		ep.SocketOptions().SetReceiveFlowInfo(v != 0)
+		return nil
+
+	case linux.IPV6_FLOWINFO_SEND:
+		v, err := parseIntOrChar(optVal)
+		if err != nil {
+			return err
+		}
+
+		ep.SocketOptions().SetSendFlowInfo(v != 0)
+		return nil
+
+	case linux.IPV6_FLOWLABEL_MGR:
+		opt, err := parseFlowLabelRequest(t, optVal)
+		if err != nil {
+			return err
+		}
+		return syserr.TranslateNetstackError(ep.SetSockOpt(&opt))
+
 	case linux.IP6T_SO_SET_REPLACE:
 		if len(optVal) < linux.SizeOfIP6TReplace {
 			return syserr.ErrInvalidArgument
@@ -2137,6 +3289,79 @@ func setSockOptIPv6(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name
 		// TODO(gvisor.dev/issue/170): Counter support.
 		return nil
 
+	case linux.MRT6_INIT:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+		return translateMulticastRoutingError(ep.SetSockOptInt(tcpip.MulticastRouterOption, 1))
+
+	case linux.MRT6_DONE:
+		return translateMulticastRoutingError(ep.SetSockOptInt(tcpip.MulticastRouterOption, 0))
+
+	case linux.MRT6_ADD_MIF:
+		if len(optVal) < linux.SizeOfMif6Ctl {
+			return syserr.ErrInvalidArgument
+		}
+		var ctl linux.Mif6Ctl
+		binary.Unmarshal(optVal[:linux.SizeOfMif6Ctl], usermem.ByteOrder, &ctl)
+		if ctl.Mifi >= linux.MAXMIFS {
+			return syserr.ErrFileTableOverflow
+		}
+		if ctl.Flags&linux.MIFF_REGISTER != 0 {
+			// PIM register interfaces are not supported.
+			return syserr.ErrNotSupported
+		}
+		return translateMulticastRoutingError(ep.SetSockOpt(&tcpip.AddMulticastRoutingInterfaceOption{
+			Index:     ctl.Mifi,
+			NIC:       tcpip.NICID(ctl.Pifi),
+			Threshold: ctl.Threshold,
+		}))
+
+	case linux.MRT6_DEL_MIF:
+		if len(optVal) < sizeOfUint16 {
+			return syserr.ErrInvalidArgument
+		}
+		return translateMulticastRoutingError(ep.SetSockOpt(&tcpip.RemoveMulticastRoutingInterfaceOption{
+			Index: usermem.ByteOrder.Uint16(optVal),
+		}))
+
+	case linux.MRT6_ADD_MFC, linux.MRT6_DEL_MFC:
+		if len(optVal) < linux.SizeOfMf6cCtl {
+			return syserr.ErrInvalidArgument
+		}
+		var ctl linux.Mf6cCtl
+		binary.Unmarshal(optVal[:linux.SizeOfMf6cCtl], usermem.ByteOrder, &ctl)
+		if ctl.Parent >= linux.MAXMIFS {
+			return syserr.ErrFileTableOverflow
+		}
+		source, group := tcpip.Address(ctl.Origin.Addr[:]), tcpip.Address(ctl.McastGrp.Addr[:])
+		if name == linux.MRT6_DEL_MFC {
+			return translateMulticastRoutingError(ep.SetSockOpt(&tcpip.RemoveMulticastRouteOption{
+				Source: source,
+				Group:  group,
+			}))
+		}
+		opt := tcpip.AddMulticastRouteOption{
+			Source:         source,
+			Group:          group,
+			InputInterface: ctl.Parent,
+		}
+		for i := uint16(0); i < linux.MAXMIFS; i++ {
+			if ctl.IfSet[i/32]&(1<<(i%32)) != 0 {
+				opt.OutputInterfaces = append(opt.OutputInterfaces, i)
+			}
+		}
+		return translateMulticastRoutingError(ep.SetSockOpt(&opt))
+
+	case linux.MRT6_ASSERT,
+		linux.MRT6_PIM,
+		linux.MRT6_TABLE,
+		linux.MRT6_ADD_MFC_PROXY,
+		linux.MRT6_DEL_MFC_PROXY,
+		linux.MRT6_FLUSH:
+
+		t.Kernel().EmitUnimplementedEvent(t)
+
 	default:
 		emitUnimplementedEventIPv6(t, name)
 	}
@@ -2144,11 +3369,249 @@ func setSockOptIPv6(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name
 	return nil
 }
 
+// translateMulticastRoutingError translates an error returned by a multicast
+// routing socket option to the error Linux returns for it.
+func translateMulticastRoutingError(err *tcpip.Error) *syserr.Error {
+	switch err {
+	case tcpip.ErrNotPermitted:
+		// The socket is not the multicast router.
+		return syserr.ErrPermissionDenied
+	case tcpip.ErrNoRoute:
+		// The multicast forwarding cache entry does not exist.
+		return syserr.ErrNoFileOrDir
+	default:
+		return syserr.TranslateNetstackError(err)
+	}
+}
+
 var (
 	inetMulticastRequestSize        = int(binary.Size(linux.InetMulticastRequest{}))
 	inetMulticastRequestWithNICSize = int(binary.Size(linux.InetMulticastRequestWithNIC{}))
+	inetMulticastSourceRequestSize  = int(binary.Size(linux.InetMulticastSourceRequest{}))
+	groupSourceRequestSize          = int(binary.Size(linux.GroupSourceRequest{}))
 )
 
+var tcpMD5SigSize = int(binary.Size(linux.TCPMD5Sig{}))
+
+// copyInTCPMD5Sig copies in a struct tcp_md5sig, as used by TCP_MD5SIG and
+// TCP_MD5SIG_EXT. The prefix and interface index are only honored with
+// TCP_MD5SIG_EXT, as in Linux.
+func copyInTCPMD5Sig(optVal []byte, ext bool) (tcpip.TCPMD5SigOption, *syserr.Error) {
+	if len(optVal) < tcpMD5SigSize {
+		return tcpip.TCPMD5SigOption{}, syserr.ErrInvalidArgument
+	}
+
+	var sig linux.TCPMD5Sig
+	binary.Unmarshal(optVal[:tcpMD5SigSize], usermem.ByteOrder, &sig)
+	if sig.KeyLen > linux.TCP_MD5SIG_MAXKEYLEN {
+		return tcpip.TCPMD5SigOption{}, syserr.ErrInvalidArgument
+	}
+	addr, family, err := socket.AddressAndFamily(sig.Addr[:])
+	if err != nil {
+		return tcpip.TCPMD5SigOption{}, err
+	}
+	if family != linux.AF_INET && family != linux.AF_INET6 {
+		return tcpip.TCPMD5SigOption{}, syserr.ErrInvalidArgument
+	}
+
+	opt := tcpip.TCPMD5SigOption{
+		Addr:      addr.Addr,
+		PrefixLen: len(addr.Addr) * 8,
+		Key:       sig.Key[:sig.KeyLen],
+	}
+	if ext && sig.Flags&linux.TCP_MD5SIG_FLAG_PREFIX != 0 {
+		if int(sig.PrefixLen) > opt.PrefixLen {
+			return tcpip.TCPMD5SigOption{}, syserr.ErrInvalidArgument
+		}
+		opt.PrefixLen = int(sig.PrefixLen)
+	}
+	if ext && sig.Flags&linux.TCP_MD5SIG_FLAG_IFINDEX != 0 {
+		opt.NIC = tcpip.NICID(sig.IfIndex)
+	}
+	// Peers of dual-stack sockets with IPv4-mapped IPv6 addresses use
+	// IPv4 addresses.
+	if header.IsV4MappedAddress(opt.Addr) {
+		opt.Addr = opt.Addr[header.IPv6AddressSize-header.IPv4AddressSize:]
+		if opt.PrefixLen -= 96; opt.PrefixLen < 0 {
+			opt.PrefixLen = 0
+		}
+	}
+	return opt, nil
+}
+
+var (
+	tcpAOAddSize        = int(binary.Size(linux.TCPAOAdd{}))
+	tcpAODelSize        = int(binary.Size(linux.TCPAODel{}))
+	tcpAOInfoOptSize    = int(binary.Size(linux.TCPAOInfoOpt{}))
+	tcpRepairOptSize    = int(binary.Size(linux.TCPRepairOpt{}))
+	tcpRepairWindowSize = int(binary.Size(linux.TCPRepairWindow{}))
+)
+
+// copyInTCPRepairOptions converts the array of struct tcp_repair_opt passed to
+// TCP_REPAIR_OPTIONS to a tcpip.TCPRepairOptionsOption.
+func copyInTCPRepairOptions(optVal []byte) (tcpip.TCPRepairOptionsOption, *syserr.Error) {
+	var opt tcpip.TCPRepairOptionsOption
+	for ; len(optVal) >= tcpRepairOptSize; optVal = optVal[tcpRepairOptSize:] {
+		var ro linux.TCPRepairOpt
+		binary.Unmarshal(optVal[:tcpRepairOptSize], usermem.ByteOrder, &ro)
+		switch ro.OptCode {
+		case linux.TCPOPT_MSS:
+			if ro.OptVal == 0 || ro.OptVal > math.MaxUint16 {
+				return opt, syserr.ErrInvalidArgument
+			}
+			opt.MSS = uint16(ro.OptVal)
+		case linux.TCPOPT_WINDOW:
+			snd, rcv := ro.OptVal&0xffff, ro.OptVal>>16
+			if snd > header.MaxWndScale || rcv > header.MaxWndScale {
+				return opt, syserr.ErrFileTooBig
+			}
+			opt.WindowScale = true
+			opt.SndWndScale = uint8(snd)
+			opt.RcvWndScale = uint8(rcv)
+		case linux.TCPOPT_SACK_PERM:
+			if ro.OptVal != 0 {
+				return opt, syserr.ErrInvalidArgument
+			}
+			opt.SACKPermitted = true
+		case linux.TCPOPT_TIMESTAMP:
+			if ro.OptVal != 0 {
+				return opt, syserr.ErrInvalidArgument
+			}
+			opt.Timestamp = true
+		default:
+			return opt, syserr.ErrInvalidArgument
+		}
+	}
+	return opt, nil
+}
+
+// copyInTCPAOPeer returns the peer address and prefix length of a TCP-AO key,
+// as used by TCP_AO_ADD_KEY and TCP_AO_DEL_KEY.
+func copyInTCPAOPeer(sockAddr []byte, prefix uint8) (tcpip.Address, int, *syserr.Error) {
+	addr, family, err := socket.AddressAndFamily(sockAddr)
+	if err != nil {
+		return "", 0, err
+	}
+	if family != linux.AF_INET && family != linux.AF_INET6 {
+		return "", 0, syserr.ErrInvalidArgument
+	}
+	prefixLen := int(prefix)
+	if prefixLen > len(addr.Addr)*8 {
+		return "", 0, syserr.ErrInvalidArgument
+	}
+	if header.IsV4MappedAddress(addr.Addr) {
+		addr.Addr = addr.Addr[header.IPv6AddressSize-header.IPv4AddressSize:]
+		if prefixLen -= 96; prefixLen < 0 {
+			prefixLen = 0
+		}
+	}
+	return addr.Addr, prefixLen, nil
+}
+
+// copyInTCPAOAdd copies in a struct tcp_ao_add, as used by TCP_AO_ADD_KEY.
+func copyInTCPAOAdd(optVal []byte) (tcpip.TCPAOAddKeyOption, *syserr.Error) {
+	if len(optVal) < tcpAOAddSize {
+		return tcpip.TCPAOAddKeyOption{}, syserr.ErrInvalidArgument
+	}
+
+	var add linux.TCPAOAdd
+	binary.Unmarshal(optVal[:tcpAOAddSize], usermem.ByteOrder, &add)
+	if add.KeyLen > linux.TCP_AO_MAXKEYLEN {
+		return tcpip.TCPAOAddKeyOption{}, syserr.ErrInvalidArgument
+	}
+	addr, prefixLen, err := copyInTCPAOPeer(add.Addr[:], add.Prefix)
+	if err != nil {
+		return tcpip.TCPAOAddKeyOption{}, err
+	}
+	alg := add.AlgName[:]
+	if i := bytes.IndexByte(alg, 0); i >= 0 {
+		alg = alg[:i]
+	}
+	return tcpip.TCPAOAddKeyOption{
+		Addr:       addr,
+		PrefixLen:  prefixLen,
+		NIC:        tcpip.NICID(add.IfIndex),
+		Algorithm:  string(alg),
+		SendID:     add.SndID,
+		RecvID:     add.RcvID,
+		MACLength:  int(add.MACLen),
+		Key:        add.Key[:add.KeyLen],
+		SetCurrent: add.Flags&linux.TCP_AO_SET_CURRENT != 0,
+		SetRNext:   add.Flags&linux.TCP_AO_SET_RNEXT != 0,
+	}, nil
+}
+
+// copyInTCPAODel copies in a struct tcp_ao_del, as used by TCP_AO_DEL_KEY.
+func copyInTCPAODel(optVal []byte) (tcpip.TCPAODelKeyOption, *syserr.Error) {
+	if len(optVal) < tcpAODelSize {
+		return tcpip.TCPAODelKeyOption{}, syserr.ErrInvalidArgument
+	}
+
+	var del linux.TCPAODel
+	binary.Unmarshal(optVal[:tcpAODelSize], usermem.ByteOrder, &del)
+	addr, prefixLen, err := copyInTCPAOPeer(del.Addr[:], del.Prefix)
+	if err != nil {
+		return tcpip.TCPAODelKeyOption{}, err
+	}
+	return tcpip.TCPAODelKeyOption{
+		Addr:       addr,
+		PrefixLen:  prefixLen,
+		NIC:        tcpip.NICID(del.IfIndex),
+		SendID:     del.SndID,
+		RecvID:     del.RcvID,
+		SetCurrent: del.Flags&linux.TCP_AO_SET_CURRENT != 0,
+		Current:    del.CurrentKey,
+		SetRNext:   del.Flags&linux.TCP_AO_SET_RNEXT != 0,
+		RNext:      del.RNext,
+	}, nil
+}
+
+// copyInGroupSourceRequest copies in a struct group_source_req, as used by
+// MCAST_JOIN_SOURCE_GROUP and MCAST_LEAVE_SOURCE_GROUP.
+func copyInGroupSourceRequest(optVal []byte) (tcpip.SourceMembershipOption, *syserr.Error) {
+	if len(optVal) < groupSourceRequestSize {
+		return tcpip.SourceMembershipOption{}, syserr.ErrInvalidArgument
+	}
+
+	var req linux.GroupSourceRequest
+	binary.Unmarshal(optVal[:groupSourceRequestSize], usermem.ByteOrder, &req)
+
+	group, groupFamily, err := socket.AddressAndFamily(req.Group[:])
+	if err != nil {
+		return tcpip.SourceMembershipOption{}, err
+	}
+	source, sourceFamily, err := socket.AddressAndFamily(req.Source[:])
+	if err != nil {
+		return tcpip.SourceMembershipOption{}, err
+	}
+	if groupFamily != linux.AF_INET || sourceFamily != linux.AF_INET {
+		return tcpip.SourceMembershipOption{}, syserr.ErrInvalidArgument
+	}
+
+	return tcpip.SourceMembershipOption{
+		NIC:           tcpip.NICID(req.Interface),
+		InterfaceAddr: header.IPv4Any,
+		MulticastAddr: group.Addr,
+		SourceAddr:    source.Addr,
+	}, nil
+}
+
+// copyInMulticastSourceRequest copies in a struct ip_mreq_source, as used by
+// IP_ADD_SOURCE_MEMBERSHIP and IP_DROP_SOURCE_MEMBERSHIP.
+func copyInMulticastSourceRequest(optVal []byte) (tcpip.SourceMembershipOption, *syserr.Error) {
+	if len(optVal) < inetMulticastSourceRequestSize {
+		return tcpip.SourceMembershipOption{}, syserr.ErrInvalidArgument
+	}
+
+	var req linux.InetMulticastSourceRequest
+	binary.Unmarshal(optVal[:inetMulticastSourceRequestSize], usermem.ByteOrder, &req)
+	return tcpip.SourceMembershipOption{
+		InterfaceAddr: tcpip.Address(req.InterfaceAddr[:]),
+		MulticastAddr: tcpip.Address(req.MulticastAddr[:]),
+		SourceAddr:    tcpip.Address(req.SourceAddr[:]),
+	}, nil
+}
+
 // copyInMulticastRequest copies in a variable-size multicast request. The
 // kernel determines which structure was passed by its length. IP_MULTICAST_IF
 // supports ip_mreqn, ip_mreq and in_addr, while IP_ADD_MEMBERSHIP and
@@ -2180,6 +3643,46 @@ func copyInMulticastRequest(optVal []byte, allowAddr bool) (linux.InetMulticastR
 	return req, nil
 }
 
+// toPMTUDiscoverySetting converts an IP_MTU_DISCOVER or IPV6_MTU_DISCOVER value
+// to the equivalent tcpip.PMTUDiscovery* setting.
+func toPMTUDiscoverySetting(v int32) (int, bool) {
+	switch v {
+	case linux.IP_PMTUDISC_DONT:
+		return tcpip.PMTUDiscoveryDont, true
+	case linux.IP_PMTUDISC_WANT:
+		return tcpip.PMTUDiscoveryWant, true
+	case linux.IP_PMTUDISC_DO:
+		return tcpip.PMTUDiscoveryDo, true
+	case linux.IP_PMTUDISC_PROBE:
+		return tcpip.PMTUDiscoveryProbe, true
+	case linux.IP_PMTUDISC_INTERFACE:
+		return tcpip.PMTUDiscoveryInterface, true
+	case linux.IP_PMTUDISC_OMIT:
+		return tcpip.PMTUDiscoveryOmit, true
+	default:
+		return 0, false
+	}
+}
+
+// fromPMTUDiscoverySetting converts a tcpip.PMTUDiscovery* setting to the
+// equivalent IP_MTU_DISCOVER or IPV6_MTU_DISCOVER value.
+func fromPMTUDiscoverySetting(v int) int32 {
+	switch v {
+	case tcpip.PMTUDiscoveryWant:
+		return linux.IP_PMTUDISC_WANT
+	case tcpip.PMTUDiscoveryDo:
+		return linux.IP_PMTUDISC_DO
+	case tcpip.PMTUDiscoveryProbe:
+		return linux.IP_PMTUDISC_PROBE
+	case tcpip.PMTUDiscoveryInterface:
+		return linux.IP_PMTUDISC_INTERFACE
+	case tcpip.PMTUDiscoveryOmit:
+		return linux.IP_PMTUDISC_OMIT
+	default:
+		return linux.IP_PMTUDISC_DONT
+	}
+}
+
 // parseIntOrChar copies either a 32-bit int or an 8-bit uint out of buf.
 //
 // net/ipv4/ip_sockglue.c:do_ip_setsockopt does this for its socket options.
@@ -2271,6 +3774,38 @@ func setSockOptIP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name in
 		t.Kernel().EmitUnimplementedEvent(t)
 		return syserr.ErrInvalidArgument
 
+	case linux.IP_ADD_SOURCE_MEMBERSHIP:
+		req, err := copyInMulticastSourceRequest(optVal)
+		if err != nil {
+			return err
+		}
+		opt := tcpip.AddSourceMembershipOption(req)
+		return syserr.TranslateNetstackError(ep.SetSockOpt(&opt))
+
+	case linux.IP_DROP_SOURCE_MEMBERSHIP:
+		req, err := copyInMulticastSourceRequest(optVal)
+		if err != nil {
+			return err
+		}
+		opt := tcpip.RemoveSourceMembershipOption(req)
+		return syserr.TranslateNetstackError(ep.SetSockOpt(&opt))
+
+	case linux.MCAST_JOIN_SOURCE_GROUP:
+		req, err := copyInGroupSourceRequest(optVal)
+		if err != nil {
+			return err
+		}
+		opt := tcpip.AddSourceMembershipOption(req)
+		return syserr.TranslateNetstackError(ep.SetSockOpt(&opt))
+
+	case linux.MCAST_LEAVE_SOURCE_GROUP:
+		req, err := copyInGroupSourceRequest(optVal)
+		if err != nil {
+			return err
+		}
+		opt := tcpip.RemoveSourceMembershipOption(req)
+		return syserr.TranslateNetstackError(ep.SetSockOpt(&opt))
+
 	case linux.IP_TTL:
 		v, err := parseIntOrChar(optVal)
 		if err != nil {
@@ -2283,7 +3818,18 @@ func setSockOptIP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name in
 		} else if v < 1 || v > 255 {
 			return syserr.ErrInvalidArgument
 		}
-		return syserr.TranslateNetstackError(ep.SetSockOptInt(tcpip.TTLOption, int(v)))
+		return syserr.TranslateNetstackError(ep.SetSockOptInt(tcpip.TTLOption, int(v)))
+
+	case linux.IP_MTU_DISCOVER:
+		v, err := parseIntOrChar(optVal)
+		if err != nil {
+			return err
+		}
+		setting, ok := toPMTUDiscoverySetting(v)
+		if !ok {
+			return syserr.ErrInvalidArgument
+		}
+		return syserr.TranslateNetstackError(ep.SetSockOptInt(tcpip.MTUDiscoverOption, setting))
 
 	case linux.IP_TOS:
 		if len(optVal) == 0 {
@@ -2303,6 +3849,22 @@ func setSockOptIP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name in
 		ep.SocketOptions().SetReceiveTOS(v != 0)
 		return nil
 
+	case linux.IP_FREEBIND:
+		v, err := parseIntOrChar(optVal)
+		if err != nil {
+			return err
+		}
+		ep.SocketOptions().SetFreeBind(v != 0)
+		return nil
+
+	case linux.IP_RECVERR:
+		v, err := parseIntOrChar(optVal)
+		if err != nil {
+			return err
+		}
+		ep.SocketOptions().SetRecvError(v != 0)
+		return nil
+
 	case linux.IP_PKTINFO:
 		if len(optVal) == 0 {
 			return nil
@@ -2346,21 +3908,97 @@ func setSockOptIP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name in
 		// TODO(gvisor.dev/issue/170): Counter support.
 		return nil
 
-	case linux.IP_ADD_SOURCE_MEMBERSHIP,
-		linux.IP_BIND_ADDRESS_NO_PORT,
+	case linux.MRT_INIT:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+		return translateMulticastRoutingError(ep.SetSockOptInt(tcpip.MulticastRouterOption, 1))
+
+	case linux.MRT_DONE:
+		return translateMulticastRoutingError(ep.SetSockOptInt(tcpip.MulticastRouterOption, 0))
+
+	case linux.MRT_ADD_VIF, linux.MRT_DEL_VIF:
+		if len(optVal) < linux.SizeOfVifCtl {
+			return syserr.ErrInvalidArgument
+		}
+		var ctl linux.VifCtl
+		binary.Unmarshal(optVal[:linux.SizeOfVifCtl], usermem.ByteOrder, &ctl)
+		if ctl.Vifi >= linux.MAXVIFS {
+			return syserr.ErrFileTableOverflow
+		}
+		if name == linux.MRT_DEL_VIF {
+			return translateMulticastRoutingError(ep.SetSockOpt(&tcpip.RemoveMulticastRoutingInterfaceOption{
+				Index: ctl.Vifi,
+			}))
+		}
+		if ctl.Flags&(linux.VIFF_TUNNEL|linux.VIFF_REGISTER) != 0 {
+			// Neither tunnel nor PIM register interfaces are supported.
+			return syserr.ErrNotSupported
+		}
+		opt := tcpip.AddMulticastRoutingInterfaceOption{
+			Index:     ctl.Vifi,
+			Threshold: ctl.Threshold,
+		}
+		if ctl.Flags&linux.VIFF_USE_IFINDEX != 0 {
+			ifIndex := int32(usermem.ByteOrder.Uint32(ctl.LclAddr[:]))
+			if ifIndex <= 0 {
+				return syserr.ErrNoDevice
+			}
+			opt.NIC = tcpip.NICID(ifIndex)
+		} else {
+			opt.InterfaceAddr = tcpip.Address(ctl.LclAddr[:])
+		}
+		return translateMulticastRoutingError(ep.SetSockOpt(&opt))
+
+	case linux.MRT_ADD_MFC, linux.MRT_DEL_MFC:
+		if len(optVal) < linux.SizeOfMfcCtl {
+			return syserr.ErrInvalidArgument
+		}
+		var ctl linux.MfcCtl
+		binary.Unmarshal(optVal[:linux.SizeOfMfcCtl], usermem.ByteOrder, &ctl)
+		if ctl.Parent >= linux.MAXVIFS {
+			return syserr.ErrFileTableOverflow
+		}
+		source, group := tcpip.Address(ctl.Origin[:]), tcpip.Address(ctl.McastGrp[:])
+		if name == linux.MRT_DEL_MFC {
+			return translateMulticastRoutingError(ep.SetSockOpt(&tcpip.RemoveMulticastRouteOption{
+				Source: source,
+				Group:  group,
+			}))
+		}
+		opt := tcpip.AddMulticastRouteOption{
+			Source:         source,
+			Group:          group,
+			InputInterface: ctl.Parent,
+		}
+		// The virtual interfaces' thresholds are used instead of the
+		// per-entry TTLs, which only select the outgoing interfaces.
+		for i, ttl := range ctl.TTLs {
+			if ttl != 0 && ttl != 255 {
+				opt.OutputInterfaces = append(opt.OutputInterfaces, uint16(i))
+			}
+		}
+		return translateMulticastRoutingError(ep.SetSockOpt(&opt))
+
+	case linux.MRT_ASSERT,
+		linux.MRT_PIM,
+		linux.MRT_TABLE,
+		linux.MRT_ADD_MFC_PROXY,
+		linux.MRT_DEL_MFC_PROXY,
+		linux.MRT_FLUSH:
+
+		t.Kernel().EmitUnimplementedEvent(t)
+
+	case linux.IP_BIND_ADDRESS_NO_PORT,
 		linux.IP_BLOCK_SOURCE,
 		linux.IP_CHECKSUM,
-		linux.IP_DROP_SOURCE_MEMBERSHIP,
-		linux.IP_FREEBIND,
 		linux.IP_IPSEC_POLICY,
 		linux.IP_MINTTL,
 		linux.IP_MSFILTER,
-		linux.IP_MTU_DISCOVER,
 		linux.IP_MULTICAST_ALL,
 		linux.IP_NODEFRAG,
 		linux.IP_OPTIONS,
 		linux.IP_PASSSEC,
-		linux.IP_RECVERR,
 		linux.IP_RECVFRAGSIZE,
 		linux.IP_RECVOPTS,
 		linux.IP_RECVORIGDSTADDR,
@@ -2371,9 +4009,7 @@ func setSockOptIP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name in
 		linux.IP_UNICAST_IF,
 		linux.IP_XFRM_POLICY,
 		linux.MCAST_BLOCK_SOURCE,
-		linux.MCAST_JOIN_SOURCE_GROUP,
 		linux.MCAST_LEAVE_GROUP,
-		linux.MCAST_LEAVE_SOURCE_GROUP,
 		linux.MCAST_MSFILTER,
 		linux.MCAST_UNBLOCK_SOURCE:
 
@@ -2394,10 +4030,6 @@ func emitUnimplementedEventTCP(t *kernel.Task, name int) {
 		linux.TCP_FASTOPEN_CONNECT,
 		linux.TCP_FASTOPEN_KEY,
 		linux.TCP_FASTOPEN_NO_COOKIE,
-		linux.TCP_QUEUE_SEQ,
-		linux.TCP_REPAIR,
-		linux.TCP_REPAIR_QUEUE,
-		linux.TCP_REPAIR_WINDOW,
 		linux.TCP_SAVED_SYN,
 		linux.TCP_SAVE_SYN,
 		linux.TCP_THIN_DUPACK,
@@ -2437,7 +4069,6 @@ func emitUnimplementedEventIPv6(t *kernel.Task, name int) {
 		linux.IPV6_MULTICAST_IF,
 		linux.IPV6_MULTICAST_LOOP,
 		linux.IPV6_RECVDSTOPTS,
-		linux.IPV6_RECVERR,
 		linux.IPV6_RECVFRAGSIZE,
 		linux.IPV6_RECVHOPLIMIT,
 		linux.IPV6_RECVHOPOPTS,
@@ -2458,6 +4089,50 @@ func emitUnimplementedEventIPv6(t *kernel.Task, name int) {
 	}
 }
 
+// parseFlowLabelRequest parses an in6_flowlabel_req for IPV6_FLOWLABEL_MGR.
+//
+// Labels are never allocated by the stack, so a label must be specified.
+// Reflection of the peer's flow label is not supported.
+func parseFlowLabelRequest(t *kernel.Task, optVal []byte) (tcpip.FlowLabelManagerOption, *syserr.Error) {
+	if len(optVal) < linux.SizeOfIn6FlowLabelReq {
+		return tcpip.FlowLabelManagerOption{}, syserr.ErrInvalidArgument
+	}
+	var req linux.In6FlowLabelReq
+	binary.Unmarshal(optVal[:linux.SizeOfIn6FlowLabelReq], usermem.ByteOrder, &req)
+	if req.Flags&(linux.IPV6_FL_F_REFLECT|linux.IPV6_FL_F_REMOTE) != 0 {
+		return tcpip.FlowLabelManagerOption{}, syserr.ErrInvalidArgument
+	}
+
+	opt := tcpip.FlowLabelManagerOption{
+		Label:     socket.Ntohl(req.Label),
+		Create:    req.Flags&linux.IPV6_FL_F_CREATE != 0,
+		Exclusive: req.Flags&linux.IPV6_FL_F_EXCL != 0,
+	}
+	switch req.Action {
+	case linux.IPV6_FL_A_GET:
+		opt.Action = tcpip.FlowLabelLease
+	case linux.IPV6_FL_A_PUT:
+		opt.Action = tcpip.FlowLabelRelease
+	case linux.IPV6_FL_A_RENEW:
+		opt.Action = tcpip.FlowLabelRenew
+	default:
+		return tcpip.FlowLabelManagerOption{}, syserr.ErrInvalidArgument
+	}
+	switch req.Share {
+	case linux.IPV6_FL_S_EXCL:
+		opt.Share = tcpip.FlowLabelShareExclusive
+	case linux.IPV6_FL_S_PROCESS:
+		opt.Share = tcpip.FlowLabelShareProcess
+		opt.Owner = uint64(t.ThreadGroup().ID())
+	case linux.IPV6_FL_S_USER:
+		opt.Share = tcpip.FlowLabelShareUser
+		opt.Owner = uint64(t.Credentials().EffectiveKUID)
+	case linux.IPV6_FL_S_ANY:
+		opt.Share = tcpip.FlowLabelShareAny
+	}
+	return opt, nil
+}
+
 // emitUnimplementedEventIP emits unimplemented event if name is valid. It
 // contains names that are common between Get and SetSockOpt when level is
 // SOL_IP.
@@ -2472,7 +4147,6 @@ func emitUnimplementedEventIP(t *kernel.Task, name int) {
 		linux.IP_PKTINFO,
 		linux.IP_PKTOPTIONS,
 		linux.IP_MTU_DISCOVER,
-		linux.IP_RECVERR,
 		linux.IP_RECVTTL,
 		linux.IP_RECVTOS,
 		linux.IP_MTU,
@@ -2626,6 +4300,35 @@ func toLinuxPacketType(pktType tcpip.PacketType) uint8 {
 	}
 }
 
+// repairPeek peeks the queue q of a TCP connection being repaired. The data
+// already read from the endpoint comes first in the receive queue.
+//
+// Precondition: s.readMu must be held.
+func (s *socketOpsCommon) repairPeek(ctx context.Context, dst usermem.IOSequence, q int) (int, *syserr.Error) {
+	var n int
+	if q == tcpip.TCPRecvQueue && len(s.readView) > 0 {
+		var err error
+		n, err = dst.CopyOut(ctx, s.readView)
+		if err != nil {
+			return n, syserr.FromError(err)
+		}
+		dst = dst.DropFirst(n)
+	}
+	num, err := dst.CopyOutFrom(ctx, safemem.FromVecReaderFunc{func(dsts [][]byte) (int64, error) {
+		n, _, err := s.Endpoint.Peek(dsts)
+		if err != nil {
+			return int64(n), syserr.TranslateNetstackError(err).ToError()
+		}
+		return int64(n), nil
+	}})
+	n += int(num)
+	if err == syserror.ErrWouldBlock && n > 0 {
+		// We got some data, so no need to return an error.
+		err = nil
+	}
+	return n, syserr.FromError(err)
+}
+
 // nonBlockingRead issues a non-blocking read.
 //
 // TODO(b/78348848): Support timestamps for stream sockets.
@@ -2633,8 +4336,9 @@ func (s *socketOpsCommon) nonBlockingRead(ctx context.Context, dst usermem.IOSeq
 	isPacket := s.isPacketBased()
 
 	// Fast path for regular reads from stream (e.g., TCP) endpoints. Note
-	// that senderRequested is ignored for stream sockets.
-	if !peek && !isPacket {
+	// that senderRequested is ignored for stream sockets. SCTP sockets
+	// preserve message boundaries and read one message at a time.
+	if !peek && !isPacket && !s.isSCTP() {
 		// TCP sockets discard the data if MSG_TRUNC is set.
 		//
 		// This behavior is documented in man 7 tcp:
@@ -2653,6 +4357,14 @@ func (s *socketOpsCommon) nonBlockingRead(ctx context.Context, dst usermem.IOSeq
 	s.readMu.Lock()
 	defer s.readMu.Unlock()
 
+	// The queues of a TCP connection being repaired may only be peeked.
+	if !isPacket && peek {
+		if q, err := s.Endpoint.GetSockOptInt(tcpip.TCPRepairQueueOption); err == nil {
+			n, err := s.repairPeek(ctx, dst, q)
+			return n, 0, nil, 0, s.controlMessages(), err
+		}
+	}
+
 	if err := s.fetchReadView(); err != nil {
 		return 0, 0, nil, 0, socket.ControlMessages{}, err
 	}
@@ -2680,12 +4392,7 @@ func (s *socketOpsCommon) nonBlockingRead(ctx context.Context, dst usermem.IOSeq
 	var addr linux.SockAddr
 	var addrLen uint32
 	if isPacket && senderRequested {
-		addr, addrLen = socket.ConvertAddress(s.family, s.sender)
-		switch v := addr.(type) {
-		case *linux.SockAddrLink:
-			v.Protocol = socket.Htons(uint16(s.linkPacketInfo.Protocol))
-			v.PacketType = toLinuxPacketType(s.linkPacketInfo.PktType)
-		}
+		addr, addrLen = s.senderAddress()
 	}
 
 	if peek {
@@ -2733,6 +4440,10 @@ func (s *socketOpsCommon) nonBlockingRead(ctx context.Context, dst usermem.IOSeq
 	if msgLen > int(n) {
 		flags |= linux.MSG_TRUNC
 	}
+	if s.isSCTP() && len(s.readView) == 0 {
+		// The whole message was read.
+		flags |= linux.MSG_EOR
+	}
 
 	if trunc {
 		n = msgLen
@@ -2743,17 +4454,121 @@ func (s *socketOpsCommon) nonBlockingRead(ctx context.Context, dst usermem.IOSeq
 	return n, flags, addr, addrLen, cmsg, syserr.FromError(err)
 }
 
+// senderAddress returns the address of the sender of the datagram in readView.
+//
+// Precondition: s.readMu must be held.
+func (s *socketOpsCommon) senderAddress() (linux.SockAddr, uint32) {
+	addr, addrLen := socket.ConvertAddress(s.family, s.sender)
+	switch v := addr.(type) {
+	case *linux.SockAddrLink:
+		v.Protocol = socket.Htons(uint16(s.linkPacketInfo.Protocol))
+		v.PacketType = toLinuxPacketType(s.linkPacketInfo.PktType)
+	}
+	return addr, addrLen
+}
+
+// nonBlockingReadBatch issues a non-blocking read of datagrams into the
+// elements of dsts following those of msgs, and returns msgs with the
+// datagrams read appended. The error is only returned if no datagram was
+// read.
+//
+// Precondition: s.Endpoint must implement tcpip.BatchEndpoint.
+func (s *socketOpsCommon) nonBlockingReadBatch(ctx context.Context, dsts []usermem.IOSequence, trunc, senderRequested bool, msgs []socket.ReceivedMessage) ([]socket.ReceivedMessage, *syserr.Error) {
+	s.readMu.Lock()
+	defer s.readMu.Unlock()
+
+	read := len(msgs)
+	// A datagram left in readView by a peek is received first.
+	if len(s.readView) > 0 {
+		msg, err := s.recvReadView(ctx, dsts[len(msgs)], trunc, senderRequested)
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+		if len(msgs) == len(dsts) {
+			return msgs, nil
+		}
+	}
+
+	batch := make([]tcpip.ReadMessage, len(dsts)-len(msgs))
+	n, err := s.Endpoint.(tcpip.BatchEndpoint).ReadBatch(batch)
+	if err != nil {
+		if len(msgs) > read {
+			return msgs, nil
+		}
+		return msgs, syserr.TranslateNetstackError(err)
+	}
+	for _, m := range batch[:n] {
+		s.readView = m.Data
+		s.sender = m.Addr
+		s.linkPacketInfo = m.Info
+		s.readCM = m.Control
+		msg, err := s.recvReadView(ctx, dsts[len(msgs)], trunc, senderRequested)
+		if err != nil {
+			// As in Linux, the datagram which couldn't be copied out is
+			// dropped, and so are the following datagrams of the batch
+			// which were already dequeued from the endpoint.
+			if len(msgs) > read {
+				return msgs, nil
+			}
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// recvReadView receives the datagram in readView into dst.
+//
+// Precondition: s.readMu must be held.
+func (s *socketOpsCommon) recvReadView(ctx context.Context, dst usermem.IOSequence, trunc, senderRequested bool) (socket.ReceivedMessage, *syserr.Error) {
+	n, err := dst.CopyOut(ctx, s.readView)
+	msgLen := len(s.readView)
+	s.readView = nil
+	atomic.StoreUint32(&s.readViewHasData, 0)
+	if err != nil {
+		return socket.ReceivedMessage{}, syserr.FromError(err)
+	}
+	s.updateTimestamp()
+
+	msg := socket.ReceivedMessage{
+		N:               n,
+		ControlMessages: s.controlMessages(),
+	}
+	s.fillCmsgInq(&msg.ControlMessages)
+	if msgLen > n {
+		msg.Flags |= linux.MSG_TRUNC
+	}
+	if trunc {
+		msg.N = msgLen
+	}
+	if senderRequested {
+		msg.SenderAddr, msg.SenderAddrLen = s.senderAddress()
+	}
+	return msg, nil
+}
+
+// rxTimestampingFlags are the SO_TIMESTAMPING flags with which received
+// messages carry SCM_TIMESTAMPING control messages.
+const rxTimestampingFlags = tcpip.TimestampingRxSoftware | tcpip.TimestampingSoftware
+
 func (s *socketOpsCommon) controlMessages() socket.ControlMessages {
 	return socket.ControlMessages{
 		IP: tcpip.ControlMessages{
 			HasTimestamp:    s.readCM.HasTimestamp && s.sockOptTimestamp,
 			Timestamp:       s.readCM.Timestamp,
+			HasTimestamping: s.readCM.HasTimestamp && s.Endpoint.SocketOptions().GetTimestamping()&rxTimestampingFlags == rxTimestampingFlags,
+			Timestamping:    s.readCM.Timestamp,
 			HasTOS:          s.readCM.HasTOS,
 			TOS:             s.readCM.TOS,
 			HasTClass:       s.readCM.HasTClass,
 			TClass:          s.readCM.TClass,
+			HasFlowInfo:     s.readCM.HasFlowInfo,
+			FlowInfo:        s.readCM.FlowInfo,
 			HasIPPacketInfo: s.readCM.HasIPPacketInfo,
 			PacketInfo:      s.readCM.PacketInfo,
+			HasSCTPRcvInfo:  s.readCM.HasSCTPRcvInfo,
+			SCTPRcvInfo:     s.readCM.SCTPRcvInfo,
 		},
 	}
 }
@@ -2770,9 +4585,56 @@ func (s *socketOpsCommon) updateTimestamp() {
 	}
 }
 
+// recvErr handles MSG_ERRQUEUE for recvmsg(2). It dequeues the error at the
+// front of the endpoint's error queue and returns the errant packet's payload
+// with the error as an IP_RECVERR or IPV6_RECVERR control message.
+//
+// This is analogous to net/ipv4/ip_sockglue.c:ip_recv_error(). Like Linux, it
+// never blocks.
+func (s *socketOpsCommon) recvErr(t *kernel.Task, dst usermem.IOSequence) (int, int, linux.SockAddr, uint32, socket.ControlMessages, *syserr.Error) {
+	sockErr := s.Endpoint.SocketOptions().DequeueErr()
+	if sockErr == nil {
+		return 0, 0, nil, 0, socket.ControlMessages{}, syserr.ErrTryAgain
+	}
+	if s.family == linux.AF_INET6 {
+		// IPv6 sockets report all errors, including those for packets sent to
+		// IPv4-mapped addresses, with IPV6_RECVERR control messages.
+		sockErr.NetProto = header.IPv6ProtocolNumber
+	}
+
+	// The payload is truncated if it does not fit in dst.
+	msgFlags := linux.MSG_ERRQUEUE
+	if int(dst.NumBytes()) < len(sockErr.Payload) {
+		msgFlags |= linux.MSG_TRUNC
+	}
+	n, err := dst.CopyOut(t, sockErr.Payload)
+
+	var addr linux.SockAddr
+	var addrLen uint32
+	cms := socket.ControlMessages{IP: tcpip.ControlMessages{SockErr: sockErr}}
+	switch sockErr.ErrOrigin {
+	case tcpip.SockExtErrorOriginZeroCopy:
+		// Zerocopy completion notifications have no address.
+	case tcpip.SockExtErrorOriginTimestamping:
+		// Transmit timestamps have no address either, and their time is
+		// only reported with TimestampingSoftware.
+		if s.Endpoint.SocketOptions().GetTimestamping()&tcpip.TimestampingSoftware != 0 {
+			cms.IP.HasTimestamping = true
+			cms.IP.Timestamping = sockErr.Timestamp
+		}
+	default:
+		addr, addrLen = socket.ConvertAddress(s.family, sockErr.Dst)
+	}
+	return n, msgFlags, addr, addrLen, cms, syserr.FromError(err)
+}
+
 // RecvMsg implements the linux syscall recvmsg(2) for sockets backed by
 // tcpip.Endpoint.
 func (s *socketOpsCommon) RecvMsg(t *kernel.Task, dst usermem.IOSequence, flags int, haveDeadline bool, deadline ktime.Time, senderRequested bool, controlDataLen uint64) (n int, msgFlags int, senderAddr linux.SockAddr, senderAddrLen uint32, controlMessages socket.ControlMessages, err *syserr.Error) {
+	if flags&linux.MSG_ERRQUEUE != 0 {
+		return s.recvErr(t, dst)
+	}
+
 	trunc := flags&linux.MSG_TRUNC != 0
 	peek := flags&linux.MSG_PEEK != 0
 	dontWait := flags&linux.MSG_DONTWAIT != 0
@@ -2826,6 +4688,9 @@ func (s *socketOpsCommon) RecvMsg(t *kernel.Task, dst usermem.IOSequence, flags
 		}
 		dst = dst.DropFirst(rn)
 
+		if s.busyPoll(t, ch) {
+			continue
+		}
 		if err := t.BlockWithDeadline(ch, haveDeadline, deadline); err != nil {
 			if n > 0 {
 				return n, msgFlags, senderAddr, senderAddrLen, controlMessages, nil
@@ -2841,38 +4706,38 @@ func (s *socketOpsCommon) RecvMsg(t *kernel.Task, dst usermem.IOSequence, flags
 // SendMsg implements the linux syscall sendmsg(2) for sockets backed by
 // tcpip.Endpoint.
 func (s *socketOpsCommon) SendMsg(t *kernel.Task, src usermem.IOSequence, to []byte, flags int, haveDeadline bool, deadline ktime.Time, controlMessages socket.ControlMessages) (int, *syserr.Error) {
-	// Reject Unix control messages.
-	if !controlMessages.Unix.Empty() {
-		return 0, syserr.ErrInvalidArgument
+	opts, optsErr := s.writeOptions(t, to, flags, controlMessages)
+	if optsErr != nil {
+		return 0, optsErr
 	}
 
-	var addr *tcpip.FullAddress
-	if len(to) > 0 {
-		addrBuf, family, err := socket.AddressAndFamily(to)
-		if err != nil {
-			return 0, err
-		}
-		if err := s.checkFamily(family, false /* exact */); err != nil {
-			return 0, err
-		}
-		addrBuf = s.mapFamily(addrBuf, family)
-
-		addr = &addrBuf
+	if s.rings != nil && s.rings.hasRing(true /* tx */) {
+		// The packets of the transmit ring are sent instead.
+		return s.rings.send(opts.To)
 	}
 
-	opts := tcpip.WriteOptions{
-		To:          addr,
-		More:        flags&linux.MSG_MORE != 0,
-		EndOfRecord: flags&linux.MSG_EOR != 0,
+	v := &ioSequencePayload{t, src}
+	var p tcpip.Payloader = v
+	if flags&linux.MSG_ZEROCOPY != 0 && s.Endpoint.SocketOptions().GetZeroCopy() {
+		netProto := header.IPv4ProtocolNumber
+		if s.family == linux.AF_INET6 {
+			netProto = header.IPv6ProtocolNumber
+		}
+		zp := newZeroCopyPayload(t, v, s.Endpoint.SocketOptions(), netProto)
+		defer func() {
+			if zp.zc.DecRef() {
+				s.Notify(waiter.EventErr)
+			}
+		}()
+		p = zp
 	}
 
-	v := &ioSequencePayload{t, src}
-	n, resCh, err := s.Endpoint.Write(v, opts)
+	n, resCh, err := s.Endpoint.Write(p, opts)
 	if resCh != nil {
 		if err := t.Block(resCh); err != nil {
 			return 0, syserr.FromError(err)
 		}
-		n, _, err = s.Endpoint.Write(v, opts)
+		n, _, err = s.Endpoint.Write(p, opts)
 	}
 	dontWait := flags&linux.MSG_DONTWAIT != 0
 	if err == nil && (n >= v.src.NumBytes() || dontWait) {
@@ -2892,7 +4757,7 @@ func (s *socketOpsCommon) SendMsg(t *kernel.Task, src usermem.IOSequence, to []b
 	v.DropFirst(int(n))
 	total := n
 	for {
-		n, _, err = s.Endpoint.Write(v, opts)
+		n, _, err = s.Endpoint.Write(p, opts)
 		v.DropFirst(int(n))
 		total += n
 
@@ -2914,6 +4779,252 @@ func (s *socketOpsCommon) SendMsg(t *kernel.Task, src usermem.IOSequence, to []b
 	}
 }
 
+// writeOptions returns the options of a write to the address to with flags and
+// controlMessages.
+func (s *socketOpsCommon) writeOptions(t *kernel.Task, to []byte, flags int, controlMessages socket.ControlMessages) (tcpip.WriteOptions, *syserr.Error) {
+	// Reject Unix control messages.
+	if !controlMessages.Unix.Empty() {
+		return tcpip.WriteOptions{}, syserr.ErrInvalidArgument
+	}
+
+	var addr *tcpip.FullAddress
+	if len(to) > 0 {
+		addrBuf, family, err := socket.AddressAndFamily(to)
+		if err != nil {
+			return tcpip.WriteOptions{}, err
+		}
+		if err := s.checkFamily(family, false /* exact */); err != nil {
+			return tcpip.WriteOptions{}, err
+		}
+		addrBuf = s.mapFamily(addrBuf, family)
+
+		addr = &addrBuf
+	}
+
+	opts := tcpip.WriteOptions{
+		To:          addr,
+		More:        flags&linux.MSG_MORE != 0,
+		EndOfRecord: flags&linux.MSG_EOR != 0,
+	}
+	if controlMessages.IP.HasTxTime {
+		txTime, err := s.txTime(t, controlMessages.IP.TxTime)
+		if err != nil {
+			return tcpip.WriteOptions{}, err
+		}
+		opts.TxTime = txTime
+		opts.TxTimeReported = controlMessages.IP.TxTime
+	}
+	if controlMessages.IP.HasSCTPSndInfo {
+		opts.SCTPSndInfo = &controlMessages.IP.SCTPSndInfo
+	}
+	return opts, nil
+}
+
+// Batchable implements socket.BatchSocket.Batchable. The datagrams of
+// endpoints implementing tcpip.BatchEndpoint are batched, unless they are
+// peeked, read from the error queue, sent with MSG_ZEROCOPY or sent from a
+// transmit ring.
+func (s *socketOpsCommon) Batchable(flags int) bool {
+	if !s.isPacketBased() || s.isSCTP() {
+		return false
+	}
+	if _, ok := s.Endpoint.(tcpip.BatchEndpoint); !ok {
+		return false
+	}
+	if flags&(linux.MSG_PEEK|linux.MSG_ERRQUEUE|linux.MSG_ZEROCOPY) != 0 {
+		return false
+	}
+	return s.rings == nil || !s.rings.hasRing(true /* tx */)
+}
+
+// RecvMsgBatch implements socket.BatchSocket.RecvMsgBatch.
+func (s *socketOpsCommon) RecvMsgBatch(t *kernel.Task, dsts []usermem.IOSequence, flags int, haveDeadline bool, deadline ktime.Time, senderRequested bool) ([]socket.ReceivedMessage, *syserr.Error) {
+	trunc := flags&linux.MSG_TRUNC != 0
+	dontWait := flags&linux.MSG_DONTWAIT != 0
+	msgs, err := s.nonBlockingReadBatch(t, dsts, trunc, senderRequested, nil)
+
+	if err == syserr.ErrClosedForReceive && dontWait && len(msgs) == 0 {
+		// In this situation we should return EAGAIN, as in RecvMsg.
+		return nil, syserr.ErrTryAgain
+	}
+
+	if err != nil && (err != syserr.ErrWouldBlock || dontWait) {
+		// Read failed and we should not retry.
+		if len(msgs) > 0 {
+			return msgs, nil
+		}
+		return nil, err
+	}
+
+	if err == nil && (dontWait || len(msgs) == len(dsts)) {
+		// We got all the messages we need.
+		return msgs, nil
+	}
+
+	// We'll have to block. Register for notifications and keep trying to
+	// receive the remaining messages.
+	e, ch := waiter.NewChannelEntry(nil)
+	s.EventRegister(&e, waiter.EventIn)
+	defer s.EventUnregister(&e)
+
+	for len(msgs) < len(dsts) {
+		msgs, err = s.nonBlockingReadBatch(t, dsts, trunc, senderRequested, msgs)
+		if err == nil {
+			continue
+		}
+		if err != syserr.ErrWouldBlock {
+			// Always stop on errors other than would block. Eat the
+			// error if we got any message.
+			if len(msgs) > 0 {
+				return msgs, nil
+			}
+			return nil, err
+		}
+
+		if s.busyPoll(t, ch) {
+			continue
+		}
+		if err := t.BlockWithDeadline(ch, haveDeadline, deadline); err != nil {
+			if len(msgs) > 0 {
+				return msgs, nil
+			}
+			if err == syserror.ETIMEDOUT {
+				return nil, syserr.ErrTryAgain
+			}
+			return nil, syserr.FromError(err)
+		}
+	}
+	return msgs, nil
+}
+
+// SendMsgBatch implements socket.BatchSocket.SendMsgBatch.
+//
+// The writes of batch endpoints never block, other than for address
+// resolution, so the deadline is unused.
+func (s *socketOpsCommon) SendMsgBatch(t *kernel.Task, srcs []usermem.IOSequence, tos [][]byte, flags int, haveDeadline bool, deadline ktime.Time, cms []socket.ControlMessages) (int, *syserr.Error) {
+	var err *syserr.Error
+	msgs := make([]tcpip.WriteMessage, 0, len(srcs))
+	for i, src := range srcs {
+		opts, optsErr := s.writeOptions(t, tos[i], flags, cms[i])
+		if optsErr != nil {
+			err = optsErr
+			break
+		}
+		msgs = append(msgs, tcpip.WriteMessage{
+			Payload: &ioSequencePayload{t, src},
+			Options: opts,
+		})
+	}
+
+	ep := s.Endpoint.(tcpip.BatchEndpoint)
+	sent := 0
+	resolved := false
+	for sent < len(msgs) {
+		n, resCh, writeErr := ep.WriteBatch(msgs[sent:])
+		sent += n
+		if writeErr == nil {
+			break
+		}
+		// As in SendMsg, a datagram waiting for address resolution is
+		// written again once, after the resolution completes.
+		if resCh == nil || (n == 0 && resolved) {
+			err = syserr.TranslateNetstackError(writeErr)
+			break
+		}
+		if err := t.Block(resCh); err != nil {
+			if sent > 0 {
+				return sent, nil
+			}
+			return 0, syserr.FromError(err)
+		}
+		resolved = true
+	}
+	if sent > 0 {
+		return sent, nil
+	}
+	return 0, err
+}
+
+// busyPoll implements SO_BUSY_POLL: a read about to block on ch spins instead,
+// polling the NICs the socket receives from for inbound packets, until ch is
+// notified or the busy-poll budget of the socket runs out. It returns true if
+// ch was notified, in which case the read must be retried rather than block.
+func (s *socketOpsCommon) busyPoll(t *kernel.Task, ch <-chan struct{}) bool {
+	budget := s.Endpoint.SocketOptions().GetBusyPoll()
+	if budget == 0 {
+		return false
+	}
+	stk, ok := t.NetworkContext().(*Stack)
+	if !ok {
+		return false
+	}
+	var nicID tcpip.BindToDeviceOption
+	if err := s.Endpoint.GetSockOpt(&nicID); err != nil {
+		nicID = 0
+	}
+
+	clock := stk.Stack.Clock()
+	end := clock.NowMonotonic() + budget.Nanoseconds()
+	for {
+		select {
+		case <-ch:
+			return true
+		default:
+		}
+		if t.Interrupted() || clock.NowMonotonic() >= end {
+			return false
+		}
+		if !stk.Stack.BusyPoll(tcpip.NICID(nicID)) {
+			runtime.Gosched()
+		}
+	}
+}
+
+// txTime converts the SCM_TXTIME transmit time txtime, in the SO_TXTIME clock
+// of the socket, to a transmit time in the monotonic clock of the stack.
+func (s *socketOpsCommon) txTime(t *kernel.Task, txtime uint64) (int64, *syserr.Error) {
+	v := s.Endpoint.SocketOptions().GetTxTime()
+	if !v.Enabled {
+		return 0, syserr.ErrInvalidArgument
+	}
+	stk, ok := t.NetworkContext().(*Stack)
+	if !ok {
+		return 0, syserr.ErrInvalidArgument
+	}
+
+	// The clocks of the task and of the stack differ, so only the delay
+	// until the transmit time is carried over.
+	var now ktime.Time
+	if v.ClockID == linux.CLOCK_MONOTONIC {
+		now = t.Kernel().MonotonicClock().Now()
+	} else {
+		// The TAI offset isn't tracked, and is assumed to be zero as in
+		// Linux until it is set.
+		now = t.Kernel().RealtimeClock().Now()
+	}
+	delay := int64(txtime) - now.Nanoseconds()
+	txTime := stk.Stack.Clock().NowMonotonic() + delay
+	if txTime <= 0 {
+		// A zero transmit time means that the packet isn't scheduled.
+		txTime = 1
+	}
+	return txTime, nil
+}
+
+// ConfigureMMap implements fs.FileOperations.ConfigureMMap.
+func (s *SocketOperations) ConfigureMMap(ctx context.Context, file *fs.File, opts *memmap.MMapOpts) error {
+	return s.socketOpsCommon.configureMMap(opts)
+}
+
+// configureMMap maps the rings of packet sockets. The other sockets can't be
+// mapped.
+func (s *socketOpsCommon) configureMMap(opts *memmap.MMapOpts) error {
+	if s.rings == nil {
+		return syserror.ENODEV
+	}
+	return s.rings.configureMMap(opts)
+}
+
 // Ioctl implements fs.FileOperations.Ioctl.
 func (s *SocketOperations) Ioctl(ctx context.Context, _ *fs.File, io usermem.IO, args arch.SyscallArguments) (uintptr, error) {
 	return s.socketOpsCommon.ioctl(ctx, io, args)
@@ -3253,7 +5364,10 @@ func interfaceStatusFlags(stack inet.Stack, name string) (uint32, *syserr.Error)
 func nicStateFlagsToLinux(f stack.NICStateFlags) uint32 {
 	var rv uint32
 	if f.Up {
-		rv |= linux.IFF_UP | linux.IFF_LOWER_UP
+		rv |= linux.IFF_UP
+	}
+	if f.LowerUp {
+		rv |= linux.IFF_LOWER_UP
 	}
 	if f.Running {
 		rv |= linux.IFF_RUNNING
@@ -3268,11 +5382,15 @@ func nicStateFlagsToLinux(f stack.NICStateFlags) uint32 {
 }
 
 func isTCPSocket(skType linux.SockType, skProto int) bool {
-	return skType == linux.SOCK_STREAM && (skProto == 0 || skProto == syscall.IPPROTO_TCP)
+	return skType == linux.SOCK_STREAM && (skProto == 0 || skProto == syscall.IPPROTO_TCP || skProto == linux.IPPROTO_MPTCP)
+}
+
+func isSCTPSocket(skType linux.SockType, skProto int) bool {
+	return skType == linux.SOCK_STREAM && skProto == linux.IPPROTO_SCTP
 }
 
 func isUDPSocket(skType linux.SockType, skProto int) bool {
-	return skType == linux.SOCK_DGRAM && (skProto == 0 || skProto == syscall.IPPROTO_UDP)
+	return skType == linux.SOCK_DGRAM && (skProto == 0 || skProto == syscall.IPPROTO_UDP || skProto == linux.IPPROTO_UDPLITE)
 }
 
 func isICMPSocket(skType linux.SockType, skProto int) bool {
@@ -24,6 +24,7 @@ import (
 	fslock "gvisor.dev/gvisor/pkg/sentry/fs/lock"
 	"gvisor.dev/gvisor/pkg/sentry/fsimpl/sockfs"
 	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/memmap"
 	"gvisor.dev/gvisor/pkg/sentry/socket"
 	"gvisor.dev/gvisor/pkg/sentry/vfs"
 	"gvisor.dev/gvisor/pkg/syserr"
@@ -65,6 +66,7 @@ func NewVFS2(t *kernel.Task, family int, skType linux.SockType, protocol int, qu
 			Endpoint: endpoint,
 			skType:   skType,
 			protocol: protocol,
+			rings:    newPacketRings(t, family, skType, queue, endpoint),
 		},
 	}
 	s.LockFD.Init(&vfs.FileLocks{})
@@ -238,6 +240,9 @@ func (s *SocketVFS2) GetSockOpt(t *kernel.Task, level, name int, outPtr usermem.
 		}
 		return &val, nil
 	}
+	if level == linux.SOL_PACKET && s.rings != nil {
+		return s.rings.getSockOpt(t, name, outPtr, outLen)
+	}
 
 	return GetSockOpt(t, s, s.Endpoint, s.family, s.skType, level, name, outPtr, outLen)
 }
@@ -268,10 +273,18 @@ func (s *SocketVFS2) SetSockOpt(t *kernel.Task, level int, name int, optVal []by
 		s.sockOptInq = usermem.ByteOrder.Uint32(optVal) != 0
 		return nil
 	}
+	if level == linux.SOL_PACKET && s.rings != nil {
+		return s.rings.setSockOpt(t, name, optVal)
+	}
 
 	return SetSockOpt(t, s, s.Endpoint, level, name, optVal)
 }
 
+// ConfigureMMap implements vfs.FileDescriptionImpl.ConfigureMMap.
+func (s *SocketVFS2) ConfigureMMap(ctx context.Context, opts *memmap.MMapOpts) error {
+	return s.socketOpsCommon.configureMMap(opts)
+}
+
 // LockPOSIX implements vfs.FileDescriptionImpl.LockPOSIX.
 func (s *SocketVFS2) LockPOSIX(ctx context.Context, uid fslock.UniqueID, t fslock.LockType, start, length uint64, whence int16, block fslock.Blocker) error {
 	return s.Locks().LockPOSIX(ctx, &s.vfsfd, uid, t, start, length, whence, block)
@@ -0,0 +1,837 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netstack
+
+import (
+	"time"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/binary"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/marshal"
+	"gvisor.dev/gvisor/pkg/marshal/primitive"
+	"gvisor.dev/gvisor/pkg/safemem"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	ktime "gvisor.dev/gvisor/pkg/sentry/kernel/time"
+	"gvisor.dev/gvisor/pkg/sentry/memmap"
+	"gvisor.dev/gvisor/pkg/sentry/mm"
+	"gvisor.dev/gvisor/pkg/sentry/pgalloc"
+	"gvisor.dev/gvisor/pkg/sentry/socket"
+	"gvisor.dev/gvisor/pkg/sentry/usage"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/syserr"
+	"gvisor.dev/gvisor/pkg/syserror"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/usermem"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	// defaultRetireBlkTov is the timeout after which the current block of
+	// a receive ring is passed to the application, if the application
+	// doesn't set one. Linux computes it from the link speed, and this is
+	// its value for 1Gb/s links.
+	defaultRetireBlkTov = 8 * time.Millisecond
+
+	// blockStatusOffset and frameStatusOffset are the offsets of the status
+	// in the block headers of receive rings, and in the frame headers of
+	// transmit rings.
+	blockStatusOffset = 8
+	frameStatusOffset = 20
+
+	// minMacLen is the minimum length reserved for the link header of the
+	// packets of receive rings, as per net/packet/af_packet.c:tpacket_rcv.
+	minMacLen = 16
+)
+
+// packetRings implements the memory-mapped rings of a packet socket, set with
+// PACKET_RX_RING and PACKET_TX_RING, as per
+// Documentation/networking/packet_mmap.rst. Only TPACKET_V3 rings are
+// supported.
+//
+// The receive ring is made of blocks holding as many packets as fit. A block
+// is passed to the application once full, or once the retire timer expires
+// while packets are in the block, and the application passes it back by
+// setting its status to TP_STATUS_KERNEL. The packets received while the next
+// block is owned by the application are dropped.
+//
+// The transmit ring is made of frames, each holding a packet which the
+// application requests to send by setting the frame status to
+// TP_STATUS_SEND_REQUEST before calling send(2).
+//
+// Lock order:
+//   packetRings.txMu
+//     ktime.Timer.mu
+//       packetRings.mu
+//
+// +stateify savable
+type packetRings struct {
+	// The following fields are initialized at creation time and are
+	// immutable.
+	mfp    pgalloc.MemoryFileProvider
+	clock  ktime.Clock
+	queue  *waiter.Queue
+	ep     tcpip.Endpoint
+	cooked bool
+
+	// txMu serializes the changes to the rings and the sending of the
+	// frames of the transmit ring, which can't hold mu while the packets
+	// are written as they may be looped back to the socket.
+	txMu sync.Mutex `state:"nosave"`
+
+	// mu protects the following fields.
+	mu sync.Mutex `state:"nosave"`
+
+	// version is the TPACKET version set with PACKET_VERSION, reserve the
+	// headroom before the packets set with PACKET_RESERVE, and loss the
+	// value of PACKET_LOSS.
+	version uint32
+	reserve uint32
+	loss    bool
+
+	// rx and tx are the geometry of the receive and transmit rings. The
+	// memory of both rings is held by mappable, the receive ring first.
+	rx       linux.TpacketReq3
+	tx       linux.TpacketReq3
+	mappable *mm.SpecialMappable
+
+	// timer retires the current block of the receive ring.
+	timer *ktime.Timer
+
+	// The following fields are the state of the current block of the
+	// receive ring, of index cur. open is true once a packet was written to
+	// it, and seq is its sequence number. tickSeq is the sequence number of
+	// the block open when timer last expired.
+	cur     uint32
+	open    bool
+	seq     uint64
+	tickSeq uint64
+	numPkts uint32
+	firstNS int64
+	lastNS  int64
+	// next is the offset of the next packet in the block, and last the
+	// offset of the last packet written.
+	next uint32
+	last uint32
+	// frozen is true if the packets are dropped because the current block
+	// is owned by the application.
+	frozen bool
+
+	// txHead is the index of the next frame of the transmit ring to send.
+	txHead uint32
+
+	// packets, drops and freezes are the counters returned by
+	// PACKET_STATISTICS, which are reset when read.
+	packets uint32
+	drops   uint32
+	freezes uint32
+}
+
+// newPacketRings returns the rings of a packet socket of type skType, or nil
+// if family is not AF_PACKET.
+func newPacketRings(t *kernel.Task, family int, skType linux.SockType, queue *waiter.Queue, ep tcpip.Endpoint) *packetRings {
+	if family != linux.AF_PACKET {
+		return nil
+	}
+	return &packetRings{
+		mfp:    t.Kernel(),
+		clock:  t.Kernel().MonotonicClock(),
+		queue:  queue,
+		ep:     ep,
+		cooked: skType == linux.SOCK_DGRAM,
+	}
+}
+
+// ringSize returns the size of a ring of geometry req.
+func ringSize(req *linux.TpacketReq3) uint64 {
+	return uint64(req.BlockSize) * uint64(req.BlockNr)
+}
+
+// firstPacketOffsetLocked returns the offset of the first packet in the blocks of
+// the receive ring.
+//
+// Precondition: r.mu must be held.
+func (r *packetRings) firstPacketOffsetLocked() uint32 {
+	return linux.SizeOfTpacketBlockDesc + uint32(binary.AlignUp(int(r.rx.SizeofPriv), 8))
+}
+
+// mapLocked returns the memory of the rings at offset off, of length n.
+//
+// Precondition: r.mu must be held, and r.mappable must not be nil.
+func (r *packetRings) mapLocked(off, n uint64) (safemem.BlockSeq, error) {
+	fr := r.mappable.FileRange()
+	return r.mfp.MemoryFile().MapInternal(memmap.FileRange{fr.Start + off, fr.Start + off + n}, usermem.ReadWrite)
+}
+
+// writeLocked copies b to the rings at offset off.
+//
+// Precondition: r.mu must be held, and r.mappable must not be nil.
+func (r *packetRings) writeLocked(off uint64, b []byte) error {
+	dsts, err := r.mapLocked(off, uint64(len(b)))
+	if err != nil {
+		return err
+	}
+	_, err = safemem.CopySeq(dsts, safemem.BlockSeqOf(safemem.BlockFromSafeSlice(b)))
+	return err
+}
+
+// readLocked copies the rings at offset off to b.
+//
+// Precondition: r.mu must be held, and r.mappable must not be nil.
+func (r *packetRings) readLocked(off uint64, b []byte) error {
+	srcs, err := r.mapLocked(off, uint64(len(b)))
+	if err != nil {
+		return err
+	}
+	_, err = safemem.CopySeq(safemem.BlockSeqOf(safemem.BlockFromSafeSlice(b)), srcs)
+	return err
+}
+
+// statusLocked returns the status at offset off, shared with the application.
+//
+// Precondition: r.mu must be held, and r.mappable must not be nil.
+func (r *packetRings) statusLocked(off uint64) (uint32, error) {
+	bs, err := r.mapLocked(off, 4)
+	if err != nil {
+		return 0, err
+	}
+	return safemem.LoadUint32(bs.Head())
+}
+
+// setStatusLocked sets the status at offset off, shared with the application,
+// once the block or frame it describes is written.
+//
+// Precondition: r.mu must be held, and r.mappable must not be nil.
+func (r *packetRings) setStatusLocked(off uint64, status uint32) error {
+	bs, err := r.mapLocked(off, 4)
+	if err != nil {
+		return err
+	}
+	_, err = safemem.SwapUint32(bs.Head(), status)
+	return err
+}
+
+// Deliver implements tcpip.PacketRing.Deliver.
+func (r *packetRings) Deliver(data buffer.VectorisedView, addr tcpip.FullAddress, info tcpip.LinkPacketInfo, timestampNS int64) bool {
+	r.mu.Lock()
+	delivered, retired := r.deliverLocked(data, addr, info, timestampNS)
+	if delivered {
+		r.packets++
+	} else {
+		r.drops++
+	}
+	r.mu.Unlock()
+
+	if retired {
+		r.queue.Notify(waiter.EventIn)
+	}
+	return delivered
+}
+
+// deliverLocked writes a packet to the current block of the receive ring, as
+// per net/packet/af_packet.c:tpacket_rcv. It returns whether the packet was
+// written, and whether a block was passed to the application.
+//
+// Precondition: r.mu must be held.
+func (r *packetRings) deliverLocked(data buffer.VectorisedView, addr tcpip.FullAddress, info tcpip.LinkPacketInfo, timestampNS int64) (bool, bool) {
+	if r.rx.BlockNr == 0 {
+		// The ring was released while the packet was delivered.
+		return false, false
+	}
+
+	// The network header is aligned, leaving room for the frame header,
+	// the address of the sender and the link header of raw sockets.
+	netOff := uint32(binary.AlignUp(linux.TPACKET3_HDRLEN+minMacLen, linux.TPACKET_ALIGNMENT)) + r.reserve
+	macOff := netOff
+	if !r.cooked {
+		macOff -= header.EthernetMinimumSize
+	}
+	maxLen := r.rx.BlockSize - r.firstPacketOffsetLocked()
+	if macOff > maxLen {
+		return false, false
+	}
+	snaplen := uint32(data.Size())
+	if snaplen > maxLen-macOff {
+		snaplen = maxLen - macOff
+	}
+	pktLen := uint32(binary.AlignUp(int(macOff+snaplen), 8))
+
+	retired := false
+	if r.open && r.next+pktLen > r.rx.BlockSize {
+		r.retireBlockLocked(0)
+		retired = true
+	}
+	if !r.open && !r.openBlockLocked(timestampNS) {
+		return false, retired
+	}
+
+	off := uint64(r.cur)*uint64(r.rx.BlockSize) + uint64(r.next)
+	pkt := make([]byte, macOff+snaplen)
+	binary.Marshal(pkt[:0], usermem.ByteOrder, &linux.Tpacket3Hdr{
+		NextOffset: pktLen,
+		Sec:        uint32(timestampNS / 1e9),
+		Nsec:       uint32(timestampNS % 1e9),
+		Snaplen:    snaplen,
+		Len:        uint32(data.Size()),
+		Status:     linux.TP_STATUS_USER | linux.TP_STATUS_TS_SOFTWARE,
+		Mac:        uint16(macOff),
+		Net:        uint16(netOff),
+	})
+	sa := linux.SockAddrLink{
+		Family:          linux.AF_PACKET,
+		Protocol:        socket.Htons(uint16(info.Protocol)),
+		InterfaceIndex:  int32(addr.NIC),
+		ARPHardwareType: linux.ARPHRD_ETHER,
+		PacketType:      toLinuxPacketType(info.PktType),
+		HardwareAddrLen: header.EthernetAddressSize,
+	}
+	copy(sa.HardwareAddr[:], addr.Addr)
+	binary.Marshal(pkt[linux.SizeOfTpacket3Hdr:linux.SizeOfTpacket3Hdr], usermem.ByteOrder, &sa)
+	b := pkt[macOff:]
+	for _, v := range data.Views() {
+		b = b[copy(b, v):]
+		if len(b) == 0 {
+			break
+		}
+	}
+	if err := r.writeLocked(off, pkt); err != nil {
+		return false, retired
+	}
+
+	r.numPkts++
+	r.last = r.next
+	r.next += pktLen
+	r.lastNS = timestampNS
+	return true, retired
+}
+
+// openBlockLocked opens the current block of the receive ring, unless it is
+// owned by the application, in which case the ring is frozen.
+//
+// Precondition: r.mu must be held.
+func (r *packetRings) openBlockLocked(timestampNS int64) bool {
+	status, err := r.statusLocked(uint64(r.cur)*uint64(r.rx.BlockSize) + blockStatusOffset)
+	if err != nil || status != linux.TP_STATUS_KERNEL {
+		if !r.frozen {
+			r.frozen = true
+			r.freezes++
+		}
+		return false
+	}
+	r.frozen = false
+	r.open = true
+	r.seq++
+	r.numPkts = 0
+	r.next = r.firstPacketOffsetLocked()
+	r.firstNS = timestampNS
+	return true
+}
+
+// retireBlockLocked passes the current block of the receive ring to the
+// application, as per net/packet/af_packet.c:prb_close_block.
+//
+// Precondition: r.mu must be held, and the current block must be open.
+func (r *packetRings) retireBlockLocked(status uint32) {
+	off := uint64(r.cur) * uint64(r.rx.BlockSize)
+
+	// The last packet of the block is not followed by any other.
+	var zero [4]byte
+	r.writeLocked(off+uint64(r.last), zero[:])
+
+	var desc [linux.SizeOfTpacketBlockDesc]byte
+	binary.Marshal(desc[:0], usermem.ByteOrder, &linux.TpacketBlockDesc{
+		Version:          linux.TPACKET_V3,
+		OffsetToPriv:     linux.SizeOfTpacketBlockDesc,
+		BlockStatus:      linux.TP_STATUS_KERNEL,
+		NumPkts:          r.numPkts,
+		OffsetToFirstPkt: r.firstPacketOffsetLocked(),
+		BlkLen:           r.next,
+		SeqNum:           r.seq,
+		TsFirstPktSec:    uint32(r.firstNS / 1e9),
+		TsFirstPktNsec:   uint32(r.firstNS % 1e9),
+		TsLastPktSec:     uint32(r.lastNS / 1e9),
+		TsLastPktNsec:    uint32(r.lastNS % 1e9),
+	})
+	r.writeLocked(off, desc[:])
+
+	status |= linux.TP_STATUS_USER
+	if r.drops != 0 {
+		status |= linux.TP_STATUS_LOSING
+	}
+	r.setStatusLocked(off+blockStatusOffset, status)
+
+	r.open = false
+	r.cur = (r.cur + 1) % r.rx.BlockNr
+}
+
+// Notify implements ktime.TimerListener.Notify. The current block is retired
+// if it was already open when the timer last expired.
+func (r *packetRings) Notify(exp uint64, setting ktime.Setting) (ktime.Setting, bool) {
+	r.mu.Lock()
+	retired := false
+	if r.open {
+		if r.tickSeq == r.seq {
+			r.retireBlockLocked(linux.TP_STATUS_BLK_TMO)
+			retired = true
+		}
+		r.tickSeq = r.seq
+	}
+	r.mu.Unlock()
+
+	if retired {
+		r.queue.Notify(waiter.EventIn)
+	}
+	return ktime.Setting{}, false
+}
+
+// Destroy implements ktime.TimerListener.Destroy.
+func (*packetRings) Destroy() {}
+
+// readiness returns the events of mask ready, adjusted to the rings: the
+// receive ring is readable once a block was passed to the application, and the
+// transmit ring is writable while its next frame is available.
+func (r *packetRings) readiness(mask, ready waiter.EventMask) waiter.EventMask {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if mask&waiter.EventIn != 0 && r.rx.BlockNr != 0 {
+		prev := (r.cur + r.rx.BlockNr - 1) % r.rx.BlockNr
+		if status, err := r.statusLocked(uint64(prev)*uint64(r.rx.BlockSize) + blockStatusOffset); err == nil && status != linux.TP_STATUS_KERNEL {
+			ready |= waiter.EventIn
+		}
+	}
+	if mask&waiter.EventOut != 0 && r.tx.BlockNr != 0 {
+		if status, err := r.statusLocked(r.frameOffsetLocked(r.txHead) + frameStatusOffset); err != nil || status != linux.TP_STATUS_AVAILABLE {
+			ready &^= waiter.EventOut
+		}
+	}
+	return ready
+}
+
+// hasRing returns whether the receive ring, or the transmit ring if tx is
+// true, is set.
+func (r *packetRings) hasRing(tx bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if tx {
+		return r.tx.BlockNr != 0
+	}
+	return r.rx.BlockNr != 0
+}
+
+// frameOffsetLocked returns the offset of frame i of the transmit ring.
+//
+// Precondition: r.mu must be held.
+func (r *packetRings) frameOffsetLocked(i uint32) uint64 {
+	perBlock := r.tx.BlockSize / r.tx.FrameSize
+	return ringSize(&r.rx) + uint64(i/perBlock)*uint64(r.tx.BlockSize) + uint64(i%perBlock)*uint64(r.tx.FrameSize)
+}
+
+// send sends the frames of the transmit ring the application requested to
+// send, as per net/packet/af_packet.c:tpacket_snd. It returns the number of
+// bytes sent.
+func (r *packetRings) send(to *tcpip.FullAddress) (int, *syserr.Error) {
+	r.txMu.Lock()
+	defer r.txMu.Unlock()
+
+	total := 0
+	for {
+		r.mu.Lock()
+		if r.tx.BlockNr == 0 {
+			r.mu.Unlock()
+			return total, nil
+		}
+		off := r.frameOffsetLocked(r.txHead)
+		if status, err := r.statusLocked(off + frameStatusOffset); err != nil || status != linux.TP_STATUS_SEND_REQUEST {
+			r.mu.Unlock()
+			return total, nil
+		}
+
+		var hdrBuf [linux.SizeOfTpacket3Hdr]byte
+		var hdr linux.Tpacket3Hdr
+		var data []byte
+		var err *syserr.Error
+		if r.readLocked(off, hdrBuf[:]) != nil {
+			err = syserr.ErrInvalidArgument
+		} else {
+			binary.Unmarshal(hdrBuf[:], usermem.ByteOrder, &hdr)
+			// The packet data follows the frame header, and variable
+			// sized frames are not supported.
+			switch maxLen := r.tx.FrameSize - linux.SizeOfTpacket3Hdr; {
+			case hdr.NextOffset != 0:
+				err = syserr.ErrInvalidArgument
+			case hdr.Len > maxLen:
+				err = syserr.ErrMessageTooLong
+			default:
+				data = make([]byte, hdr.Len)
+				if r.readLocked(off+linux.SizeOfTpacket3Hdr, data) != nil {
+					err = syserr.ErrInvalidArgument
+				}
+			}
+		}
+		if err != nil {
+			if r.loss {
+				// Malformed frames are skipped.
+				r.setStatusLocked(off+frameStatusOffset, linux.TP_STATUS_AVAILABLE)
+				r.txHead = (r.txHead + 1) % r.tx.FrameNr
+				r.mu.Unlock()
+				continue
+			}
+			r.setStatusLocked(off+frameStatusOffset, linux.TP_STATUS_WRONG_FORMAT)
+			r.mu.Unlock()
+			return total, err
+		}
+		r.setStatusLocked(off+frameStatusOffset, linux.TP_STATUS_SENDING)
+		r.mu.Unlock()
+
+		_, _, werr := r.ep.Write(tcpip.SlicePayload(data), tcpip.WriteOptions{To: to})
+
+		r.mu.Lock()
+		if werr != nil {
+			r.setStatusLocked(off+frameStatusOffset, linux.TP_STATUS_SEND_REQUEST)
+			r.mu.Unlock()
+			return total, syserr.TranslateNetstackError(werr)
+		}
+		r.setStatusLocked(off+frameStatusOffset, linux.TP_STATUS_AVAILABLE)
+		r.txHead = (r.txHead + 1) % r.tx.FrameNr
+		r.mu.Unlock()
+		total += len(data)
+	}
+}
+
+// setRing sets the receive ring, or the transmit ring if tx is true, to the
+// geometry of req, or releases it if req has no blocks, as per
+// net/packet/af_packet.c:packet_set_ring.
+func (r *packetRings) setRing(ctx context.Context, tx bool, req linux.TpacketReq3) *syserr.Error {
+	r.txMu.Lock()
+	defer r.txMu.Unlock()
+
+	r.mu.Lock()
+	if r.mappable != nil && r.mappable.ReadRefs() > 1 {
+		// The rings are mapped.
+		r.mu.Unlock()
+		return syserr.ErrBusy
+	}
+	rx, txReq := r.rx, r.tx
+	cur := &rx
+	if tx {
+		cur = &txReq
+	}
+	if req.BlockNr != 0 {
+		if cur.BlockNr != 0 {
+			r.mu.Unlock()
+			return syserr.ErrBusy
+		}
+		if err := r.validateLocked(tx, &req); err != nil {
+			r.mu.Unlock()
+			return err
+		}
+	} else if req.FrameNr != 0 {
+		r.mu.Unlock()
+		return syserr.ErrInvalidArgument
+	}
+	*cur = req
+
+	// The memory of both rings is reallocated, keeping the contents of the
+	// ring which is not changed.
+	var mappable *mm.SpecialMappable
+	if size := ringSize(&rx) + ringSize(&txReq); size != 0 {
+		fr, err := r.mfp.MemoryFile().Allocate(size, usage.Anonymous)
+		if err != nil {
+			r.mu.Unlock()
+			return syserr.ErrNoMemory
+		}
+		mappable = mm.NewSpecialMappable("[packet_mmap]", r.mfp, fr)
+		if r.mappable != nil {
+			src, dst, n := uint64(0), uint64(0), ringSize(&rx)
+			if !tx {
+				src, dst, n = ringSize(&r.rx), ringSize(&rx), ringSize(&txReq)
+			}
+			if n != 0 {
+				old := r.mappable.FileRange()
+				srcs, serr := r.mfp.MemoryFile().MapInternal(memmap.FileRange{old.Start + src, old.Start + src + n}, usermem.Read)
+				dsts, derr := r.mfp.MemoryFile().MapInternal(memmap.FileRange{fr.Start + dst, fr.Start + dst + n}, usermem.Write)
+				if serr == nil && derr == nil {
+					safemem.CopySeq(dsts, srcs)
+				}
+			}
+		}
+	}
+	if r.mappable != nil {
+		r.mappable.DecRef(ctx)
+	}
+	r.mappable = mappable
+	r.rx, r.tx = rx, txReq
+
+	var setting ktime.Setting
+	var ring tcpip.PacketRing
+	if tx {
+		r.txHead = 0
+	} else {
+		r.cur = 0
+		r.open = false
+		r.frozen = false
+		if r.rx.BlockNr != 0 {
+			ring = r
+			tov := time.Duration(r.rx.RetireBlkTov) * time.Millisecond
+			if tov == 0 {
+				tov = defaultRetireBlkTov
+			}
+			setting = ktime.Setting{Enabled: true, Next: r.clock.Now().Add(tov), Period: tov}
+			if r.timer == nil {
+				r.timer = ktime.NewTimer(r.clock, r)
+			}
+		}
+	}
+	timer := r.timer
+	r.mu.Unlock()
+
+	if !tx {
+		if timer != nil {
+			timer.Swap(setting)
+		}
+		r.ep.SetSockOpt(&tcpip.PacketRingOption{Ring: ring})
+	}
+	return nil
+}
+
+// validateLocked validates the geometry of a ring.
+//
+// Precondition: r.mu must be held.
+func (r *packetRings) validateLocked(tx bool, req *linux.TpacketReq3) *syserr.Error {
+	if r.version != linux.TPACKET_V3 {
+		return syserr.ErrInvalidArgument
+	}
+	if req.BlockSize == 0 || req.BlockSize%usermem.PageSize != 0 {
+		return syserr.ErrInvalidArgument
+	}
+	if !tx && uint64(req.BlockSize) < linux.SizeOfTpacketBlockDesc+uint64(binary.AlignUp(int(req.SizeofPriv), 8)) {
+		return syserr.ErrInvalidArgument
+	}
+	if req.FrameSize < linux.TPACKET3_HDRLEN+r.reserve || req.FrameSize%linux.TPACKET_ALIGNMENT != 0 {
+		return syserr.ErrInvalidArgument
+	}
+	perBlock := req.BlockSize / req.FrameSize
+	if perBlock == 0 || uint64(perBlock)*uint64(req.BlockNr) != uint64(req.FrameNr) {
+		return syserr.ErrInvalidArgument
+	}
+	return nil
+}
+
+// configureMMap configures a mapping of the rings. The mapping must cover both
+// rings.
+func (r *packetRings) configureMMap(opts *memmap.MMapOpts) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.mappable == nil || opts.Offset != 0 || opts.Length != r.mappable.Length() {
+		return syserror.EINVAL
+	}
+	r.mappable.IncRef()
+	opts.MappingIdentity = r.mappable
+	opts.Mappable = r.mappable
+	return nil
+}
+
+// release releases the rings once the socket is closed.
+func (r *packetRings) release(ctx context.Context) {
+	r.txMu.Lock()
+	defer r.txMu.Unlock()
+
+	r.mu.Lock()
+	timer := r.timer
+	r.timer = nil
+	if r.mappable != nil {
+		r.mappable.DecRef(ctx)
+		r.mappable = nil
+	}
+	r.rx = linux.TpacketReq3{}
+	r.tx = linux.TpacketReq3{}
+	r.mu.Unlock()
+
+	if timer != nil {
+		timer.Destroy()
+	}
+}
+
+// getSockOpt implements GetSockOpt when level is SOL_PACKET.
+func (r *packetRings) getSockOpt(t *kernel.Task, name int, outPtr usermem.Addr, outLen int) (marshal.Marshallable, *syserr.Error) {
+	switch name {
+	case linux.PACKET_VERSION, linux.PACKET_RESERVE, linux.PACKET_LOSS:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		var v primitive.Int32
+		switch name {
+		case linux.PACKET_VERSION:
+			v = primitive.Int32(r.version)
+		case linux.PACKET_RESERVE:
+			v = primitive.Int32(r.reserve)
+		default:
+			v = primitive.Int32(boolToInt32(r.loss))
+		}
+		return &v, nil
+
+	case linux.PACKET_HDRLEN:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		// The version whose header length is returned is read from the
+		// option value.
+		var v primitive.Int32
+		if _, err := v.CopyIn(t, outPtr); err != nil {
+			return nil, syserr.FromError(err)
+		}
+		switch v {
+		case linux.TPACKET_V1:
+			v = linux.TPACKET1_HDRLEN
+		case linux.TPACKET_V2:
+			v = linux.TPACKET2_HDRLEN
+		case linux.TPACKET_V3:
+			v = linux.TPACKET3_HDRLEN
+		default:
+			return nil, syserr.ErrInvalidArgument
+		}
+		return &v, nil
+
+	case linux.PACKET_STATISTICS:
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		// As in Linux, the packets dropped are counted as received.
+		stats := linux.TpacketStatsV3{
+			Packets:    r.packets + r.drops,
+			Drops:      r.drops,
+			FreezeQCnt: r.freezes,
+		}
+		r.packets, r.drops, r.freezes = 0, 0, 0
+		if r.version != linux.TPACKET_V3 {
+			return &linux.TpacketStats{Packets: stats.Packets, Drops: stats.Drops}, nil
+		}
+		return &stats, nil
+
+	case linux.PACKET_FANOUT:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		// The option is 0 if the socket hasn't joined a group, as the
+		// zero PacketFanoutOption is.
+		var opt tcpip.PacketFanoutOption
+		if err := r.ep.GetSockOpt(&opt); err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+		typ := linux.PACKET_FANOUT_HASH
+		switch opt.Mode {
+		case tcpip.PacketFanoutLB:
+			typ = linux.PACKET_FANOUT_LB
+		case tcpip.PacketFanoutCPU:
+			typ = linux.PACKET_FANOUT_CPU
+		}
+		v := primitive.Int32(int32(opt.ID) | int32(typ)<<16)
+		return &v, nil
+
+	default:
+		t.Kernel().EmitUnimplementedEvent(t)
+	}
+	return nil, syserr.ErrProtocolNotAvailable
+}
+
+// setSockOpt implements SetSockOpt when level is SOL_PACKET.
+func (r *packetRings) setSockOpt(t *kernel.Task, name int, optVal []byte) *syserr.Error {
+	switch name {
+	case linux.PACKET_RX_RING, linux.PACKET_TX_RING:
+		r.mu.Lock()
+		size := linux.SizeOfTpacketReq
+		if r.version == linux.TPACKET_V3 {
+			size = linux.SizeOfTpacketReq3
+		}
+		r.mu.Unlock()
+		if len(optVal) < size {
+			return syserr.ErrInvalidArgument
+		}
+
+		var req linux.TpacketReq3
+		binary.Unmarshal(optVal[:size], usermem.ByteOrder, &req)
+		return r.setRing(t, name == linux.PACKET_TX_RING, req)
+
+	case linux.PACKET_VERSION, linux.PACKET_RESERVE:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+
+		v := usermem.ByteOrder.Uint32(optVal)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.rx.BlockNr != 0 || r.tx.BlockNr != 0 {
+			return syserr.ErrBusy
+		}
+		if name == linux.PACKET_RESERVE {
+			r.reserve = v
+			return nil
+		}
+		switch v {
+		case linux.TPACKET_V1, linux.TPACKET_V2, linux.TPACKET_V3:
+			r.version = v
+			return nil
+		default:
+			return syserr.ErrInvalidArgument
+		}
+
+	case linux.PACKET_LOSS:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.rx.BlockNr != 0 || r.tx.BlockNr != 0 {
+			return syserr.ErrBusy
+		}
+		r.loss = usermem.ByteOrder.Uint32(optVal) != 0
+		return nil
+
+	case linux.PACKET_FANOUT:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+
+		v := usermem.ByteOrder.Uint32(optVal)
+		opt := tcpip.PacketFanoutOption{ID: uint16(v)}
+		typ, flags := (v>>16)&0xff, (v>>16)&^0xff
+		if flags != 0 {
+			return syserr.ErrInvalidArgument
+		}
+		switch typ {
+		case linux.PACKET_FANOUT_HASH:
+			opt.Mode = tcpip.PacketFanoutHash
+		case linux.PACKET_FANOUT_LB:
+			opt.Mode = tcpip.PacketFanoutLB
+		case linux.PACKET_FANOUT_CPU:
+			opt.Mode = tcpip.PacketFanoutCPU
+		default:
+			return syserr.ErrInvalidArgument
+		}
+		return syserr.TranslateNetstackError(r.ep.SetSockOpt(&opt))
+
+	default:
+		t.Kernel().EmitUnimplementedEvent(t)
+	}
+	return syserr.ErrProtocolNotAvailable
+}
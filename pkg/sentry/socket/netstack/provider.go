@@ -28,6 +28,8 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/mptcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/sctp"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
 	"gvisor.dev/gvisor/pkg/waiter"
@@ -48,15 +50,22 @@ type provider struct {
 func getTransportProtocol(ctx context.Context, stype linux.SockType, protocol int) (tcpip.TransportProtocolNumber, bool, *syserr.Error) {
 	switch stype {
 	case linux.SOCK_STREAM:
-		if protocol != 0 && protocol != syscall.IPPROTO_TCP {
-			return 0, true, syserr.ErrInvalidArgument
+		switch protocol {
+		case 0, syscall.IPPROTO_TCP:
+			return tcp.ProtocolNumber, true, nil
+		case linux.IPPROTO_MPTCP:
+			return mptcp.ProtocolNumber, true, nil
+		case linux.IPPROTO_SCTP:
+			return sctp.ProtocolNumber, true, nil
 		}
-		return tcp.ProtocolNumber, true, nil
+		return 0, true, syserr.ErrInvalidArgument
 
 	case linux.SOCK_DGRAM:
 		switch protocol {
 		case 0, syscall.IPPROTO_UDP:
 			return udp.ProtocolNumber, true, nil
+		case linux.IPPROTO_UDPLITE:
+			return udp.LiteProtocolNumber, true, nil
 		case syscall.IPPROTO_ICMP:
 			return header.ICMPv4ProtocolNumber, true, nil
 		case syscall.IPPROTO_ICMPV6:
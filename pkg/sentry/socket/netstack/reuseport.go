@@ -0,0 +1,76 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netstack
+
+import (
+	"encoding/binary"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/bpf"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/syserr"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// sizeOfSockFprog is the size of struct sock_fprog on 64-bit architectures.
+const sizeOfSockFprog = 16
+
+// reusePortFilter implements tcpip.ReusePortFilter for classic BPF programs
+// attached with SO_ATTACH_REUSEPORT_CBPF.
+//
+// +stateify savable
+type reusePortFilter struct {
+	prog bpf.Program
+}
+
+var _ tcpip.ReusePortFilter = (*reusePortFilter)(nil)
+
+// Select implements tcpip.ReusePortFilter.Select.
+//
+// Like Linux, the program runs on the transport payload of the packet, and
+// its return value is the index of the selected socket.
+func (f *reusePortFilter) Select(payload buffer.VectorisedView) (uint32, bool) {
+	idx, err := bpf.Exec(f.prog, bpf.InputBytes{
+		Data:  payload.ToView(),
+		Order: binary.BigEndian,
+	})
+	return idx, err == nil
+}
+
+// copyInReusePortFilter copies in and compiles the program referenced by the
+// struct sock_fprog in optVal.
+func copyInReusePortFilter(t *kernel.Task, optVal []byte) (*reusePortFilter, *syserr.Error) {
+	if len(optVal) < sizeOfSockFprog {
+		return nil, syserr.ErrInvalidArgument
+	}
+	n := usermem.ByteOrder.Uint16(optVal)
+	addr := usermem.Addr(usermem.ByteOrder.Uint64(optVal[8:]))
+	if n == 0 || n > bpf.MaxInstructions {
+		return nil, syserr.ErrInvalidArgument
+	}
+
+	insns := make([]linux.BPFInstruction, n)
+	if _, err := linux.CopyBPFInstructionSliceIn(t, addr, insns); err != nil {
+		return nil, syserr.FromError(err)
+	}
+	prog, err := bpf.Compile(insns)
+	if err != nil {
+		t.Debugf("Invalid SO_ATTACH_REUSEPORT_CBPF program: %v", err)
+		return nil, syserr.ErrInvalidArgument
+	}
+	return &reusePortFilter{prog: prog}, nil
+}
@@ -0,0 +1,177 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netstack
+
+import (
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/sentry/fs"
+	"gvisor.dev/gvisor/pkg/sentry/memmap"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// filePayload implements tcpip.ZeroCopyPayloader for splices from a regular
+// file into a socket, so that the endpoint can reference the file's pages
+// rather than copy them.
+//
+// Borrowed pages are held until the endpoint no longer references them. As in
+// Linux, changes to the file before its data is transmitted may be visible to
+// the peer.
+type filePayload struct {
+	*readerPayload
+
+	src      fs.SpliceSource
+	mappable memmap.Mappable
+	zc       *tcpip.ZeroCopySend
+
+	// size is the size of the file when the splice started.
+	size int64
+
+	// mu protects borrowed.
+	mu sync.Mutex
+
+	// borrowed are the file pages referenced by borrowed payloads.
+	borrowed []borrowedRange
+}
+
+// borrowedRange is a range of pages referenced by a borrowed payload.
+type borrowedRange struct {
+	file memmap.File
+	fr   memmap.FileRange
+}
+
+// newFilePayload returns a filePayload for the splice from src, using r to copy
+// data that can't be borrowed. It returns false if src is not a mappable
+// regular file.
+func newFilePayload(ctx context.Context, src fs.SpliceSource, r *readerPayload) (*filePayload, bool) {
+	f, _ := src.SpliceFile()
+	inode := f.Dirent.Inode
+	if !fs.IsRegular(inode.StableAttr) {
+		return nil, false
+	}
+	m := inode.Mappable()
+	if m == nil {
+		return nil, false
+	}
+	uattr, err := inode.UnstableAttr(ctx)
+	if err != nil {
+		return nil, false
+	}
+	p := &filePayload{
+		readerPayload: r,
+		src:           src,
+		mappable:      m,
+		size:          uattr.Size,
+	}
+	p.zc = tcpip.NewBorrowedSend(p.release)
+	return p, true
+}
+
+// ZeroCopySend implements tcpip.ZeroCopyPayloader.ZeroCopySend.
+func (p *filePayload) ZeroCopySend() *tcpip.ZeroCopySend {
+	return p.zc
+}
+
+// BorrowFullPayload implements tcpip.ZeroCopyPayloader.BorrowFullPayload.
+func (p *filePayload) BorrowFullPayload() ([]byte, *tcpip.Error) {
+	return p.BorrowPayload(int(p.count))
+}
+
+// BorrowPayload implements tcpip.ZeroCopyPayloader.BorrowPayload.
+//
+// Only payloads within a single translation of the file, backed by memory that
+// can be mapped internally, are borrowed; other payloads are copied. A
+// borrowed payload may be shorter than size.
+func (p *filePayload) BorrowPayload(size int) ([]byte, *tcpip.Error) {
+	if size > int(p.count) {
+		size = int(p.count)
+	}
+	if v, ok := p.borrow(size); ok {
+		return v, nil
+	}
+	p.zc.MarkCopied()
+	return p.Payload(size)
+}
+
+func (p *filePayload) borrow(size int) ([]byte, bool) {
+	_, off := p.src.SpliceFile()
+	if size == 0 || off >= p.size {
+		return nil, false
+	}
+	if rem := p.size - off; int64(size) > rem {
+		size = int(rem)
+	}
+	start := uint64(off)
+	end := start + uint64(size)
+	pgEnd, ok := usermem.Addr(end).RoundUp()
+	if !ok {
+		return nil, false
+	}
+	mr := memmap.MappableRange{uint64(usermem.Addr(start).RoundDown()), uint64(pgEnd)}
+
+	// Translations are only valid while mapped, so map the range for the
+	// duration of the translation. The pages remain valid afterwards since
+	// a reference is taken on them.
+	ar := usermem.AddrRange{0, usermem.Addr(mr.Length())}
+	if err := p.mappable.AddMapping(p.ctx, p, ar, mr.Start, false /* writable */); err != nil {
+		return nil, false
+	}
+	defer p.mappable.RemoveMapping(p.ctx, p, ar, mr.Start, false /* writable */)
+	ts, _ := p.mappable.Translate(p.ctx, mr, mr, usermem.Read)
+	if len(ts) == 0 {
+		return nil, false
+	}
+	t := ts[0]
+	if !t.Perms.Read || t.Source.Start != mr.Start || t.Source.End <= start {
+		return nil, false
+	}
+	if end > t.Source.End {
+		end = t.Source.End
+	}
+	usedEnd, _ := usermem.Addr(end).RoundUp()
+	fr := memmap.FileRange{t.Offset, t.Offset + uint64(usedEnd) - t.Source.Start}
+
+	t.File.IncRef(fr)
+	bs, err := t.File.MapInternal(memmap.FileRange{fr.Start + start - mr.Start, fr.Start + end - mr.Start}, usermem.Read)
+	if err != nil || bs.NumBlocks() != 1 || bs.Head().NeedSafecopy() {
+		t.File.DecRef(fr)
+		return nil, false
+	}
+
+	p.mu.Lock()
+	p.borrowed = append(p.borrowed, borrowedRange{t.File, fr})
+	p.mu.Unlock()
+
+	n := int64(end - start)
+	p.src.Skip(n)
+	p.count -= n
+	return bs.Head().ToSlice(), true
+}
+
+// Invalidate implements memmap.MappingSpace.Invalidate. Borrowed pages are
+// referenced directly, so they are unaffected.
+func (*filePayload) Invalidate(usermem.AddrRange, memmap.InvalidateOpts) {}
+
+// release drops the references on the pages referenced by borrowed payloads.
+func (p *filePayload) release() {
+	p.mu.Lock()
+	borrowed := p.borrowed
+	p.borrowed = nil
+	p.mu.Unlock()
+	for _, b := range borrowed {
+		b.file.DecRef(b.fr)
+	}
+}
@@ -16,6 +16,7 @@ package netstack
 
 import (
 	"fmt"
+	"time"
 
 	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/log"
@@ -24,6 +25,7 @@ import (
 	"gvisor.dev/gvisor/pkg/syserror"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/tunnel"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
@@ -51,6 +53,14 @@ func toLinuxARPHardwareType(t header.ARPHardwareType) uint16 {
 		return linux.ARPHRD_LOOPBACK
 	case header.ARPHardwareEther:
 		return linux.ARPHRD_ETHER
+	case header.ARPHardwareSIT:
+		return linux.ARPHRD_SIT
+	case header.ARPHardwareIPGRE:
+		return linux.ARPHRD_IPGRE
+	case header.ARPHardwareIP6GRE:
+		return linux.ARPHRD_IP6GRE
+	case header.ARPHardwareTunnel:
+		return linux.ARPHRD_TUNNEL
 	default:
 		panic(fmt.Sprintf("unknown ARPHRD type: %d", t))
 	}
@@ -60,13 +70,17 @@ func toLinuxARPHardwareType(t header.ARPHardwareType) uint16 {
 func (s *Stack) Interfaces() map[int32]inet.Interface {
 	is := make(map[int32]inet.Interface)
 	for id, ni := range s.Stack.NICInfo() {
-		is[int32(id)] = inet.Interface{
+		i := inet.Interface{
 			Name:       ni.Name,
 			Addr:       []byte(ni.LinkAddress),
 			Flags:      uint32(nicStateFlagsToLinux(ni.Flags)),
 			DeviceType: toLinuxARPHardwareType(ni.ARPHardwareType),
 			MTU:        ni.MTU,
 		}
+		if vlan, err := s.Stack.GetVLANInfo(id); err == nil {
+			i.Link = int32(vlan.Parent)
+		}
+		is[int32(id)] = i
 	}
 	return is
 }
@@ -198,6 +212,64 @@ func (s *Stack) RemoveInterfaceAddr(idx int32, addr inet.InterfaceAddr) error {
 	return nil
 }
 
+// nextNICID returns the ID of a new interface. As in Linux, the new interface
+// takes the lowest index above the ones in use.
+func (s *Stack) nextNICID() tcpip.NICID {
+	var nicID tcpip.NICID
+	for i := range s.Stack.NICInfo() {
+		if i > nicID {
+			nicID = i
+		}
+	}
+	return nicID + 1
+}
+
+// AddVLANInterface implements inet.Stack.AddVLANInterface.
+func (s *Stack) AddVLANInterface(parent int32, name string, id uint16, protocol uint16) (int32, error) {
+	nicID := s.nextNICID()
+	opts := stack.VLANOptions{
+		UpperNICOptions: stack.UpperNICOptions{Name: name},
+		Protocol:        tcpip.NetworkProtocolNumber(protocol),
+	}
+	if err := s.Stack.CreateVLAN(nicID, tcpip.NICID(parent), id, opts); err != nil {
+		return 0, syserr.TranslateNetstackError(err).ToError()
+	}
+	return int32(nicID), nil
+}
+
+// AddGREInterface implements inet.Stack.AddGREInterface.
+func (s *Stack) AddGREInterface(name string, tun inet.GRETunnel) (int32, error) {
+	opts := tunnel.Options{
+		Local:      tcpip.Address(tun.Local),
+		Remote:     tcpip.Address(tun.Remote),
+		TTL:        tun.TTL,
+		TOS:        tun.TOS,
+		InheritTOS: tun.InheritTOS,
+	}
+	greOpts := tunnel.GREOptions{
+		InputKey:       tun.InputKey,
+		HasInputKey:    tun.HasInputKey,
+		OutputKey:      tun.OutputKey,
+		HasOutputKey:   tun.HasOutputKey,
+		InputChecksum:  tun.InputChecksum,
+		OutputChecksum: tun.OutputChecksum,
+	}
+	ep, err := tunnel.NewGRE(s.Stack, opts, greOpts)
+	if err != nil {
+		return 0, syserr.TranslateNetstackError(err).ToError()
+	}
+	nicID := s.nextNICID()
+	if err := s.Stack.CreateNICWithOptions(nicID, ep, stack.NICOptions{Name: name}); err != nil {
+		return 0, syserr.TranslateNetstackError(err).ToError()
+	}
+	return int32(nicID), nil
+}
+
+// RemoveInterface implements inet.Stack.RemoveInterface.
+func (s *Stack) RemoveInterface(idx int32) error {
+	return syserr.TranslateNetstackError(s.Stack.RemoveNIC(tcpip.NICID(idx))).ToError()
+}
+
 // TCPReceiveBufferSize implements inet.Stack.TCPReceiveBufferSize.
 func (s *Stack) TCPReceiveBufferSize() (inet.TCPBufferSize, error) {
 	var rs tcpip.TCPReceiveBufferSizeRangeOption
@@ -389,6 +461,19 @@ func (s *Stack) Statistics(stat interface{}, arg string) error {
 			udp.ChecksumErrors.Value(),      // Udp/InCsumErrors.
 			0,                               // Udp/IgnoredMulti.
 		}
+	case *inet.StatSNMPUDPLite:
+		udp := Metrics.UDPLite
+		// TODO(gvisor.dev/issue/969) Support stubbed stats.
+		*stats = inet.StatSNMPUDPLite{
+			udp.PacketsReceived.Value(),     // InDatagrams.
+			udp.UnknownPortErrors.Value(),   // NoPorts.
+			0,                               // UdpLite/InErrors.
+			udp.PacketsSent.Value(),         // OutDatagrams.
+			udp.ReceiveBufferErrors.Value(), // RcvbufErrors.
+			0,                               // UdpLite/SndbufErrors.
+			udp.ChecksumErrors.Value(),      // UdpLite/InCsumErrors.
+			0,                               // UdpLite/IgnoredMulti.
+		}
 	default:
 		return syserr.ErrEndpointOperation.ToError()
 	}
@@ -424,6 +509,9 @@ func (s *Stack) RouteTable() []inet.Route {
 			Scope: linux.RT_SCOPE_LINK,
 			Type:  linux.RTN_UNICAST,
 
+			Table:    rt.TableID(),
+			Priority: rt.Metric,
+
 			DstAddr:         []byte(rt.Destination.ID()),
 			OutputInterface: int32(rt.NIC),
 			GatewayAddr:     []byte(rt.Gateway),
@@ -433,6 +521,259 @@ func (s *Stack) RouteTable() []inet.Route {
 	return routeTable
 }
 
+// convertRoute converts an inet.Route to a tcpip.Route. The output interface
+// is found by looking up the gateway if it isn't specified.
+func (s *Stack) convertRoute(route inet.Route) (tcpip.Route, error) {
+	var netProto tcpip.NetworkProtocolNumber
+	var addrLen int
+	switch route.Family {
+	case linux.AF_INET:
+		netProto, addrLen = ipv4.ProtocolNumber, header.IPv4AddressSize
+	case linux.AF_INET6:
+		netProto, addrLen = ipv6.ProtocolNumber, header.IPv6AddressSize
+	default:
+		return tcpip.Route{}, syserr.ErrAddressFamilyNotSupported.ToError()
+	}
+	// Only unicast routes to a destination are supported.
+	if route.Type != linux.RTN_UNICAST || route.SrcLen != 0 || route.TOS != 0 {
+		return tcpip.Route{}, syserror.EOPNOTSUPP
+	}
+	if int(route.DstLen) > addrLen*8 || (len(route.DstAddr) != addrLen && (route.DstLen != 0 || len(route.DstAddr) != 0)) {
+		return tcpip.Route{}, syserror.EINVAL
+	}
+	if len(route.GatewayAddr) != 0 && len(route.GatewayAddr) != addrLen {
+		return tcpip.Route{}, syserror.EINVAL
+	}
+
+	dst := tcpip.Address(route.DstAddr)
+	if len(dst) == 0 {
+		dst = tcpip.Address(make([]byte, addrLen))
+	}
+	destination := tcpip.AddressWithPrefix{Address: dst, PrefixLen: int(route.DstLen)}.Subnet()
+	if destination.ID() != dst {
+		// As in Linux, the bits of the destination beyond its prefix must
+		// be zero.
+		return tcpip.Route{}, syserror.EINVAL
+	}
+	r := tcpip.Route{
+		Destination: destination,
+		Gateway:     tcpip.Address(route.GatewayAddr),
+		NIC:         tcpip.NICID(route.OutputInterface),
+		Metric:      route.Priority,
+	}
+	if route.Table != tcpip.MainRouteTable {
+		r.Table = route.Table
+	}
+	if r.NIC == 0 {
+		if len(r.Gateway) == 0 {
+			return tcpip.Route{}, syserr.ErrNoDevice.ToError()
+		}
+		gwRoute, err := s.Stack.FindRoute(0, "", r.Gateway, netProto, false /* multicastLoop */)
+		if err != nil {
+			return tcpip.Route{}, syserr.TranslateNetstackError(err).ToError()
+		}
+		r.NIC = gwRoute.NICID()
+		gwRoute.Release()
+	} else if !s.Stack.HasNIC(r.NIC) {
+		return tcpip.Route{}, syserr.ErrNoDevice.ToError()
+	}
+	return r, nil
+}
+
+// AddRoute implements inet.Stack.AddRoute.
+func (s *Stack) AddRoute(route inet.Route, opts inet.AddRouteOptions) error {
+	r, err := s.convertRoute(route)
+	if err != nil {
+		return err
+	}
+	if !opts.Create {
+		// As in Linux, routes may only be replaced or added to the existing
+		// routes with the same destination and priority without
+		// NLM_F_CREATE.
+		exists := false
+		for _, rt := range s.Stack.GetRouteTable() {
+			if rt.TableID() == r.TableID() && rt.Destination == r.Destination && rt.Metric == r.Metric {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			return syserror.ENOENT
+		}
+	}
+	return syserr.TranslateNetstackError(s.Stack.AddRouteWithOptions(r, stack.AddRouteOptions{
+		Exclusive: opts.Exclusive,
+		Replace:   opts.Replace,
+		Append:    opts.Append,
+	})).ToError()
+}
+
+// RemoveRoute implements inet.Stack.RemoveRoute.
+func (s *Stack) RemoveRoute(route inet.Route) error {
+	var addrLen int
+	switch route.Family {
+	case linux.AF_INET:
+		addrLen = header.IPv4AddressSize
+	case linux.AF_INET6:
+		addrLen = header.IPv6AddressSize
+	default:
+		return syserr.ErrAddressFamilyNotSupported.ToError()
+	}
+	if int(route.DstLen) > addrLen*8 || (len(route.DstAddr) != 0 && len(route.DstAddr) != addrLen) {
+		return syserror.EINVAL
+	}
+	dst := tcpip.Address(route.DstAddr)
+	if len(dst) == 0 {
+		dst = tcpip.Address(make([]byte, addrLen))
+	}
+	destination := tcpip.AddressWithPrefix{Address: dst, PrefixLen: int(route.DstLen)}.Subnet()
+	gateway := tcpip.Address(route.GatewayAddr)
+	nicID := tcpip.NICID(route.OutputInterface)
+
+	// As in Linux, the first matching route is removed, along with all its
+	// next hops if it is a multipath route. The table, priority, gateway and
+	// output interface match any value if unspecified.
+	var first *tcpip.Route
+	s.Stack.RemoveRoutes(func(rt tcpip.Route) bool {
+		if rt.Destination != destination ||
+			(route.Table != 0 && rt.TableID() != route.Table) ||
+			(route.Priority != 0 && rt.Metric != route.Priority) ||
+			(len(gateway) != 0 && rt.Gateway != gateway) ||
+			(nicID != 0 && rt.NIC != nicID) {
+			return false
+		}
+		if first == nil {
+			first = &rt
+			return true
+		}
+		return first.Weight != 0 && rt.Weight != 0 && rt.TableID() == first.TableID() && rt.Metric == first.Metric
+	})
+	if first == nil {
+		return syserror.ESRCH
+	}
+	return nil
+}
+
+// RouteRules implements inet.Stack.RouteRules.
+func (s *Stack) RouteRules() []inet.RouteRule {
+	var rules []inet.RouteRule
+	for _, p := range []struct {
+		family   uint8
+		netProto tcpip.NetworkProtocolNumber
+	}{
+		{linux.AF_INET, ipv4.ProtocolNumber},
+		{linux.AF_INET6, ipv6.ProtocolNumber},
+	} {
+		for _, r := range s.Stack.RouteRules(p.netProto) {
+			rule := inet.RouteRule{
+				Family:          p.family,
+				Priority:        r.Priority,
+				TOS:             r.TOS,
+				Mark:            r.Mark,
+				MarkMask:        r.MarkMask,
+				InputInterface:  int32(r.InputNIC),
+				OutputInterface: int32(r.OutputNIC),
+				Table:           r.TableID(),
+			}
+			if r.Source != (tcpip.Subnet{}) {
+				rule.SrcLen = uint8(r.Source.Prefix())
+				rule.SrcAddr = []byte(r.Source.ID())
+			}
+			if r.Destination != (tcpip.Subnet{}) {
+				rule.DstLen = uint8(r.Destination.Prefix())
+				rule.DstAddr = []byte(r.Destination.ID())
+			}
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// convertRulePrefix returns the subnet of the prefix of length prefixLen of
+// addr, a source or destination prefix of a rule of the address family, or
+// the zero subnet if prefixLen is zero.
+func convertRulePrefix(family uint8, addr []byte, prefixLen uint8) (tcpip.Subnet, error) {
+	if prefixLen == 0 {
+		return tcpip.Subnet{}, nil
+	}
+	if len(addr)*8 < int(prefixLen) {
+		return tcpip.Subnet{}, syserror.EINVAL
+	}
+	if (family == linux.AF_INET && len(addr) != header.IPv4AddressSize) || (family == linux.AF_INET6 && len(addr) != header.IPv6AddressSize) {
+		return tcpip.Subnet{}, syserror.EINVAL
+	}
+	return tcpip.AddressWithPrefix{
+		Address:   tcpip.Address(addr),
+		PrefixLen: int(prefixLen),
+	}.Subnet(), nil
+}
+
+// convertRouteRule converts an inet.RouteRule to a tcpip.RouteRule.
+func convertRouteRule(rule inet.RouteRule) (tcpip.RouteRule, error) {
+	var netProto tcpip.NetworkProtocolNumber
+	switch rule.Family {
+	case linux.AF_INET:
+		netProto = ipv4.ProtocolNumber
+	case linux.AF_INET6:
+		netProto = ipv6.ProtocolNumber
+	default:
+		return tcpip.RouteRule{}, syserr.ErrAddressFamilyNotSupported.ToError()
+	}
+	source, err := convertRulePrefix(rule.Family, rule.SrcAddr, rule.SrcLen)
+	if err != nil {
+		return tcpip.RouteRule{}, err
+	}
+	destination, err := convertRulePrefix(rule.Family, rule.DstAddr, rule.DstLen)
+	if err != nil {
+		return tcpip.RouteRule{}, err
+	}
+	return tcpip.RouteRule{
+		Priority:    rule.Priority,
+		NetProto:    netProto,
+		Source:      source,
+		Destination: destination,
+		Mark:        rule.Mark,
+		MarkMask:    rule.MarkMask,
+		InputNIC:    tcpip.NICID(rule.InputInterface),
+		OutputNIC:   tcpip.NICID(rule.OutputInterface),
+		TOS:         rule.TOS,
+		Table:       rule.Table,
+	}, nil
+}
+
+// AddRouteRule implements inet.Stack.AddRouteRule.
+func (s *Stack) AddRouteRule(rule inet.RouteRule) error {
+	r, err := convertRouteRule(rule)
+	if err != nil {
+		return err
+	}
+	return syserr.TranslateNetstackError(s.Stack.AddRouteRule(r)).ToError()
+}
+
+// RemoveRouteRule implements inet.Stack.RemoveRouteRule.
+func (s *Stack) RemoveRouteRule(rule inet.RouteRule) error {
+	want, err := convertRouteRule(rule)
+	if err != nil {
+		return err
+	}
+	// As in Linux, the fields of the rule which are unspecified match any
+	// value.
+	if !s.Stack.RemoveRouteRule(want.NetProto, func(r tcpip.RouteRule) bool {
+		return (want.Priority == 0 || r.Priority == want.Priority) &&
+			(want.Source == (tcpip.Subnet{}) || r.Source == want.Source) &&
+			(want.Destination == (tcpip.Subnet{}) || r.Destination == want.Destination) &&
+			(want.Mark == 0 || r.Mark == want.Mark) &&
+			(want.MarkMask == 0 || r.MarkMask == want.MarkMask) &&
+			(want.InputNIC == 0 || r.InputNIC == want.InputNIC) &&
+			(want.OutputNIC == 0 || r.OutputNIC == want.OutputNIC) &&
+			(want.TOS == 0 || r.TOS == want.TOS) &&
+			(want.Table == 0 || r.TableID() == want.Table)
+	}) {
+		return syserror.ENOENT
+	}
+	return nil
+}
+
 // IPTables returns the stack's iptables.
 func (s *Stack) IPTables() (*stack.IPTables, error) {
 	return s.Stack.IPTables(), nil
@@ -478,3 +819,124 @@ func (s *Stack) SetForwarding(protocol tcpip.NetworkProtocolNumber, enable bool)
 	}
 	return nil
 }
+
+// NonLocalBind implements inet.Stack.NonLocalBind.
+func (s *Stack) NonLocalBind(protocol tcpip.NetworkProtocolNumber) bool {
+	var opt tcpip.NonLocalBindOption
+	if err := s.Stack.NetworkProtocolOption(protocol, &opt); err != nil {
+		return false
+	}
+	return bool(opt)
+}
+
+// SetNonLocalBind implements inet.Stack.SetNonLocalBind.
+func (s *Stack) SetNonLocalBind(protocol tcpip.NetworkProtocolNumber, enable bool) error {
+	opt := tcpip.NonLocalBindOption(enable)
+	return syserr.TranslateNetstackError(s.Stack.SetNetworkProtocolOption(protocol, &opt)).ToError()
+}
+
+// Neighbors implements inet.Stack.Neighbors.
+func (s *Stack) Neighbors() map[int32][]inet.Neighbor {
+	nicNeighbors := make(map[int32][]inet.Neighbor)
+	now := s.Stack.Clock().NowNanoseconds()
+	for id := range s.Stack.NICInfo() {
+		entries, err := s.Stack.Neighbors(id)
+		if err != nil {
+			continue
+		}
+
+		var neighbors []inet.Neighbor
+		for _, e := range entries {
+			var family uint8
+			switch len(e.Addr) {
+			case header.IPv4AddressSize:
+				family = linux.AF_INET
+			case header.IPv6AddressSize:
+				family = linux.AF_INET6
+			default:
+				log.Warningf("Unknown network protocol in neighbor %+v", e)
+				continue
+			}
+
+			var age time.Duration
+			if e.UpdatedAtNanos != 0 {
+				age = time.Duration(now - e.UpdatedAtNanos)
+			}
+			neighbors = append(neighbors, inet.Neighbor{
+				Family:   family,
+				State:    convertNeighborState(e.State),
+				Addr:     []byte(e.Addr),
+				LinkAddr: []byte(e.LinkAddr),
+				Age:      age,
+			})
+		}
+		nicNeighbors[int32(id)] = neighbors
+	}
+	return nicNeighbors
+}
+
+// convertNeighborState converts a stack.NeighborState to a Linux NUD_*
+// constant.
+func convertNeighborState(state stack.NeighborState) uint16 {
+	switch state {
+	case stack.Incomplete:
+		return linux.NUD_INCOMPLETE
+	case stack.Reachable:
+		return linux.NUD_REACHABLE
+	case stack.Stale:
+		return linux.NUD_STALE
+	case stack.Delay:
+		return linux.NUD_DELAY
+	case stack.Probe:
+		return linux.NUD_PROBE
+	case stack.Failed:
+		return linux.NUD_FAILED
+	case stack.Static:
+		return linux.NUD_PERMANENT
+	default:
+		return linux.NUD_NONE
+	}
+}
+
+// convertNeighborAddr returns the network address of an inet.Neighbor.
+func convertNeighborAddr(neigh inet.Neighbor) (tcpip.Address, error) {
+	switch neigh.Family {
+	case linux.AF_INET:
+		if len(neigh.Addr) != header.IPv4AddressSize {
+			return "", syserror.EINVAL
+		}
+	case linux.AF_INET6:
+		if len(neigh.Addr) != header.IPv6AddressSize {
+			return "", syserror.EINVAL
+		}
+	default:
+		return "", syserror.EINVAL
+	}
+	return tcpip.Address(neigh.Addr), nil
+}
+
+// AddNeighbor implements inet.Stack.AddNeighbor.
+func (s *Stack) AddNeighbor(idx int32, neigh inet.Neighbor) error {
+	addr, err := convertNeighborAddr(neigh)
+	if err != nil {
+		return err
+	}
+	return syserr.TranslateNetstackError(s.Stack.AddStaticNeighbor(tcpip.NICID(idx), addr, tcpip.LinkAddress(neigh.LinkAddr))).ToError()
+}
+
+// RemoveNeighbor implements inet.Stack.RemoveNeighbor.
+func (s *Stack) RemoveNeighbor(idx int32, neigh inet.Neighbor) error {
+	addr, err := convertNeighborAddr(neigh)
+	if err != nil {
+		return err
+	}
+	switch err := s.Stack.RemoveNeighbor(tcpip.NICID(idx), addr); err {
+	case nil:
+		return nil
+	case tcpip.ErrBadAddress:
+		// There is no entry for the address.
+		return syserror.ENOENT
+	default:
+		return syserr.TranslateNetstackError(err).ToError()
+	}
+}
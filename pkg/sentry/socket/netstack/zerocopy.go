@@ -0,0 +1,120 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netstack
+
+import (
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/mm"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// zeroCopyPayload implements tcpip.ZeroCopyPayloader for sends with
+// MSG_ZEROCOPY.
+//
+// Borrowed payloads reference the application's memory through internal
+// mappings of the pages backing it, which are pinned until the send completes.
+type zeroCopyPayload struct {
+	*ioSequencePayload
+
+	t  *kernel.Task
+	zc *tcpip.ZeroCopySend
+
+	// mu protects pinned.
+	mu sync.Mutex
+
+	// pinned are the pages referenced by borrowed payloads.
+	pinned []mm.PinnedRange
+}
+
+// newZeroCopyPayload returns a zeroCopyPayload for the send of p on the socket
+// owning so.
+func newZeroCopyPayload(t *kernel.Task, p *ioSequencePayload, so *tcpip.SocketOptions, netProto tcpip.NetworkProtocolNumber) *zeroCopyPayload {
+	z := &zeroCopyPayload{
+		ioSequencePayload: p,
+		t:                 t,
+	}
+	z.zc = so.NewZeroCopySend(netProto, z.unpin)
+	return z
+}
+
+// ZeroCopySend implements tcpip.ZeroCopyPayloader.ZeroCopySend.
+func (z *zeroCopyPayload) ZeroCopySend() *tcpip.ZeroCopySend {
+	return z.zc
+}
+
+// BorrowFullPayload implements tcpip.ZeroCopyPayloader.BorrowFullPayload.
+func (z *zeroCopyPayload) BorrowFullPayload() ([]byte, *tcpip.Error) {
+	return z.BorrowPayload(int(z.src.NumBytes()))
+}
+
+// BorrowPayload implements tcpip.ZeroCopyPayloader.BorrowPayload.
+//
+// Only payloads contained in the first iovec and backed by memory that can be
+// mapped internally are borrowed; other payloads are copied.
+func (z *zeroCopyPayload) BorrowPayload(size int) ([]byte, *tcpip.Error) {
+	if max := int(z.src.NumBytes()); size > max {
+		size = max
+	}
+	if v, ok := z.borrow(size); ok {
+		return v, nil
+	}
+	z.zc.MarkCopied()
+	return z.Payload(size)
+}
+
+func (z *zeroCopyPayload) borrow(size int) ([]byte, bool) {
+	head := z.src.Addrs.Head()
+	if size == 0 || int(head.Length()) < size {
+		return nil, false
+	}
+	ar, ok := head.Start.ToRange(uint64(size))
+	if !ok {
+		return nil, false
+	}
+	end, ok := ar.End.RoundUp()
+	if !ok {
+		return nil, false
+	}
+	par := usermem.AddrRange{Start: ar.Start.RoundDown(), End: end}
+
+	prs, err := z.t.MemoryManager().Pin(z.t, par, usermem.Read, z.src.Opts.IgnorePermissions)
+	if err != nil || len(prs) != 1 {
+		mm.Unpin(prs)
+		return nil, false
+	}
+	bs, err := prs[0].File.MapInternal(prs[0].FileRange(), usermem.Read)
+	if err != nil || bs.NumBlocks() != 1 || bs.Head().NeedSafecopy() {
+		mm.Unpin(prs)
+		return nil, false
+	}
+
+	z.mu.Lock()
+	z.pinned = append(z.pinned, prs...)
+	z.mu.Unlock()
+
+	off := int(ar.Start - par.Start)
+	return bs.Head().ToSlice()[off : off+size], true
+}
+
+// unpin releases the pages referenced by borrowed payloads.
+func (z *zeroCopyPayload) unpin() {
+	z.mu.Lock()
+	prs := z.pinned
+	z.pinned = nil
+	z.mu.Unlock()
+	mm.Unpin(prs)
+}
@@ -149,6 +149,42 @@ type SocketOps interface {
 	Type() (family int, skType linux.SockType, protocol int)
 }
 
+// ReceivedMessage is a message received by BatchSocket.RecvMsgBatch. Its
+// fields are the results of SocketOps.RecvMsg for a single message.
+type ReceivedMessage struct {
+	N               int
+	Flags           int
+	SenderAddr      linux.SockAddr
+	SenderAddrLen   uint32
+	ControlMessages ControlMessages
+}
+
+// BatchSocket is implemented by sockets which receive and send the messages
+// of the recvmmsg(2) and sendmmsg(2) linux syscalls with a single operation,
+// rather than with a call to SocketOps.RecvMsg or SocketOps.SendMsg for each
+// message.
+type BatchSocket interface {
+	// Batchable returns true if messages received or sent with flags may
+	// be batched.
+	Batchable(flags int) bool
+
+	// RecvMsgBatch receives up to len(dsts) messages into dsts, as
+	// SocketOps.RecvMsg does with flags for each of them. It blocks until
+	// len(dsts) messages are received, unless flags has MSG_DONTWAIT or
+	// the deadline is reached after a message is received.
+	//
+	// If err != nil, no message was received.
+	RecvMsgBatch(t *kernel.Task, dsts []usermem.IOSequence, flags int, haveDeadline bool, deadline ktime.Time, senderRequested bool) (msgs []ReceivedMessage, err *syserr.Error)
+
+	// SendMsgBatch sends the messages of srcs to the addresses of tos with
+	// the control messages of cms, as SocketOps.SendMsg does with flags
+	// for each of them, and returns the number of messages sent.
+	// SendMsgBatch does not take ownership of the control messages.
+	//
+	// If err != nil, no message was sent.
+	SendMsgBatch(t *kernel.Task, srcs []usermem.IOSequence, tos [][]byte, flags int, haveDeadline bool, deadline ktime.Time, cms []ControlMessages) (n int, err *syserr.Error)
+}
+
 // Provider is the interface implemented by providers of sockets for specific
 // address families (e.g., AF_INET).
 type Provider interface {
@@ -378,7 +414,6 @@ func SetSockOptEmitUnimplementedEvent(t *kernel.Task, name int) {
 	switch name {
 	case linux.SO_ATTACH_BPF,
 		linux.SO_ATTACH_FILTER,
-		linux.SO_ATTACH_REUSEPORT_CBPF,
 		linux.SO_ATTACH_REUSEPORT_EBPF,
 		linux.SO_CNX_ADVICE,
 		linux.SO_DETACH_FILTER,
@@ -479,6 +514,12 @@ func Htons(v uint16) uint16 {
 	return Ntohs(v)
 }
 
+// Ntohl converts a 32-bit number from network byte order to host byte order. It
+// assumes that the host is little endian.
+func Ntohl(v uint32) uint32 {
+	return v<<24 | (v<<8)&0xff0000 | (v>>8)&0xff00 | v>>24
+}
+
 // isLinkLocal determines if the given IPv6 address is link-local. This is the
 // case when it has the fe80::/10 prefix. This check is used to determine when
 // the NICID is relevant for a given IPv6 address.
@@ -604,8 +645,9 @@ func AddressAndFamily(addr []byte) (tcpip.FullAddress, uint16, *syserr.Error) {
 		binary.Unmarshal(addr[:sockAddrInet6Size], usermem.ByteOrder, &a)
 
 		out := tcpip.FullAddress{
-			Addr: BytesToIPAddress(a.Addr[:]),
-			Port: Ntohs(a.Port),
+			Addr:      BytesToIPAddress(a.Addr[:]),
+			Port:      Ntohs(a.Port),
+			FlowLabel: Ntohl(a.Flowinfo) & linux.IPV6_FLOWINFO_FLOWLABEL,
 		}
 		if isLinkLocal(out.Addr) {
 			out.NIC = tcpip.NICID(a.Scope_id)
@@ -469,6 +469,7 @@ var sockOptLevels = abi.ValueSet{
 	linux.SOL_UDP:     "SOL_UDP",
 	linux.SOL_IPV6:    "SOL_IPV6",
 	linux.SOL_ICMPV6:  "SOL_ICMPV6",
+	linux.SOL_UDPLITE: "SOL_UDPLITE",
 	linux.SOL_RAW:     "SOL_RAW",
 	linux.SOL_PACKET:  "SOL_PACKET",
 	linux.SOL_NETLINK: "SOL_NETLINK",
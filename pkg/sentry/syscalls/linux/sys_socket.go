@@ -703,6 +703,11 @@ func RecvMMsg(t *kernel.Task, args arch.SyscallArguments) (uintptr, *kernel.Sysc
 		}
 	}
 
+	if bs, ok := s.(socket.BatchSocket); ok && bs.Batchable(int(flags)) {
+		n, err := recvMMsgBatch(t, s, bs, msgPtr, vlen, flags, haveDeadline, deadline)
+		return n, nil, err
+	}
+
 	var count uint32
 	var err error
 	for i := uint64(0); i < uint64(vlen); i++ {
@@ -732,34 +737,125 @@ func RecvMMsg(t *kernel.Task, args arch.SyscallArguments) (uintptr, *kernel.Sysc
 	return uintptr(count), nil, nil
 }
 
+// recvMMsgBatch receives the messages of recvmmsg(2) with a single call to
+// bs.RecvMsgBatch.
+func recvMMsgBatch(t *kernel.Task, s socket.Socket, bs socket.BatchSocket, msgPtr usermem.Addr, vlen uint32, flags int32, haveDeadline bool, deadline ktime.Time) (uintptr, error) {
+	// Like Linux, the number of messages is capped.
+	if vlen > linux.UIO_MAXIOV {
+		vlen = linux.UIO_MAXIOV
+	}
+
+	msgs := make([]MessageHeader64, 0, vlen)
+	dsts := make([]usermem.IOSequence, 0, vlen)
+	senderRequested := false
+	var err error
+	for i := uint64(0); i < uint64(vlen); i++ {
+		mp, ok := msgPtr.AddLength(i * multipleMessageHeader64Len)
+		if !ok {
+			return 0, syserror.EFAULT
+		}
+		var msg MessageHeader64
+		var dst usermem.IOSequence
+		if msg, dst, err = captureRecvMsg(t, mp); err != nil {
+			// The messages preceding the invalid one are still received.
+			break
+		}
+		msgs = append(msgs, msg)
+		dsts = append(dsts, dst)
+		senderRequested = senderRequested || msg.NameLen != 0
+	}
+	if len(msgs) == 0 {
+		return 0, err
+	}
+
+	received, e := bs.RecvMsgBatch(t, dsts, int(flags), haveDeadline, deadline, senderRequested)
+	if e != nil {
+		return 0, syserror.ConvertIntr(e.ToError(), syserror.ERESTARTSYS)
+	}
+
+	var count uint32
+	for i, r := range received {
+		mp, _ := msgPtr.AddLength(uint64(i) * multipleMessageHeader64Len)
+		if err = copyOutRecvMsg(t, s, mp, &msgs[i], r, flags); err != nil {
+			for _, m := range received[i+1:] {
+				m.ControlMessages.Release(t)
+			}
+			break
+		}
+
+		// Copy the received length to the caller.
+		lp, ok := mp.AddLength(messageHeader64Len)
+		if !ok {
+			return 0, syserror.EFAULT
+		}
+		if _, err = primitive.CopyUint32Out(t, lp, uint32(r.N)); err != nil {
+			break
+		}
+		count++
+	}
+
+	if count == 0 {
+		return 0, err
+	}
+	return uintptr(count), nil
+}
+
 func recvSingleMsg(t *kernel.Task, s socket.Socket, msgPtr usermem.Addr, flags int32, haveDeadline bool, deadline ktime.Time) (uintptr, error) {
-	// Capture the message header and io vectors.
+	msg, dst, err := captureRecvMsg(t, msgPtr)
+	if err != nil {
+		return 0, err
+	}
+
+	n, mflags, sender, senderLen, cms, e := s.RecvMsg(t, dst, int(flags), haveDeadline, deadline, msg.NameLen != 0, msg.ControlLen)
+	if e != nil {
+		return 0, syserror.ConvertIntr(e.ToError(), syserror.ERESTARTSYS)
+	}
+	r := socket.ReceivedMessage{
+		N:               n,
+		Flags:           mflags,
+		SenderAddr:      sender,
+		SenderAddrLen:   senderLen,
+		ControlMessages: cms,
+	}
+	if err := copyOutRecvMsg(t, s, msgPtr, &msg, r, flags); err != nil {
+		return 0, err
+	}
+	return uintptr(n), nil
+}
+
+// captureRecvMsg captures the message header at msgPtr and the io vectors of
+// a message to be received.
+func captureRecvMsg(t *kernel.Task, msgPtr usermem.Addr) (MessageHeader64, usermem.IOSequence, error) {
 	var msg MessageHeader64
 	if _, err := msg.CopyIn(t, msgPtr); err != nil {
-		return 0, err
+		return MessageHeader64{}, usermem.IOSequence{}, err
 	}
 
 	if msg.IovLen > linux.UIO_MAXIOV {
-		return 0, syserror.EMSGSIZE
+		return MessageHeader64{}, usermem.IOSequence{}, syserror.EMSGSIZE
 	}
 	dst, err := t.IovecsIOSequence(usermem.Addr(msg.Iov), int(msg.IovLen), usermem.IOOpts{
 		AddressSpaceActive: true,
 	})
 	if err != nil {
-		return 0, err
+		return MessageHeader64{}, usermem.IOSequence{}, err
 	}
 
-	// FIXME(b/63594852): Pretend we have an empty error queue.
-	if flags&linux.MSG_ERRQUEUE != 0 {
-		return 0, syserror.EAGAIN
+	if msg.ControlLen > maxControlLen {
+		return MessageHeader64{}, usermem.IOSequence{}, syserror.ENOBUFS
 	}
+	return msg, dst, nil
+}
+
+// copyOutRecvMsg copies out the sender address, control messages and flags of
+// the message r, received with the message header msg at msgPtr. The control
+// messages of r are released.
+func copyOutRecvMsg(t *kernel.Task, s socket.Socket, msgPtr usermem.Addr, msg *MessageHeader64, r socket.ReceivedMessage, flags int32) error {
+	mflags := r.Flags
+	cms := r.ControlMessages
 
 	// Fast path when no control message nor name buffers are provided.
 	if msg.ControlLen == 0 && msg.NameLen == 0 {
-		n, mflags, _, _, cms, err := s.RecvMsg(t, dst, int(flags), haveDeadline, deadline, false, 0)
-		if err != nil {
-			return 0, syserror.ConvertIntr(err.ToError(), syserror.ERESTARTSYS)
-		}
 		if !cms.Unix.Empty() {
 			mflags |= linux.MSG_CTRUNC
 			cms.Release(t)
@@ -768,19 +864,11 @@ func recvSingleMsg(t *kernel.Task, s socket.Socket, msgPtr usermem.Addr, flags i
 		if int(msg.Flags) != mflags {
 			// Copy out the flags to the caller.
 			if _, err := primitive.CopyInt32Out(t, msgPtr+flagsOffset, int32(mflags)); err != nil {
-				return 0, err
+				return err
 			}
 		}
 
-		return uintptr(n), nil
-	}
-
-	if msg.ControlLen > maxControlLen {
-		return 0, syserror.ENOBUFS
-	}
-	n, mflags, sender, senderLen, cms, e := s.RecvMsg(t, dst, int(flags), haveDeadline, deadline, msg.NameLen != 0, msg.ControlLen)
-	if e != nil {
-		return 0, syserror.ConvertIntr(e.ToError(), syserror.ERESTARTSYS)
+		return nil
 	}
 	defer cms.Release(t)
 
@@ -798,27 +886,27 @@ func recvSingleMsg(t *kernel.Task, s socket.Socket, msgPtr usermem.Addr, flags i
 
 	// Copy the address to the caller.
 	if msg.NameLen != 0 {
-		if err := writeAddress(t, sender, senderLen, usermem.Addr(msg.Name), usermem.Addr(msgPtr+nameLenOffset)); err != nil {
-			return 0, err
+		if err := writeAddress(t, r.SenderAddr, r.SenderAddrLen, usermem.Addr(msg.Name), usermem.Addr(msgPtr+nameLenOffset)); err != nil {
+			return err
 		}
 	}
 
 	// Copy the control data to the caller.
 	if _, err := primitive.CopyUint64Out(t, msgPtr+controlLenOffset, uint64(len(controlData))); err != nil {
-		return 0, err
+		return err
 	}
 	if len(controlData) > 0 {
 		if _, err := t.CopyOutBytes(usermem.Addr(msg.Control), controlData); err != nil {
-			return 0, err
+			return err
 		}
 	}
 
 	// Copy out the flags to the caller.
 	if _, err := primitive.CopyInt32Out(t, msgPtr+flagsOffset, int32(mflags)); err != nil {
-		return 0, err
+		return err
 	}
 
-	return uintptr(n), nil
+	return nil
 }
 
 // recvFrom is the implementation of the recvfrom syscall. It is called by
@@ -920,7 +1008,7 @@ func SendMsg(t *kernel.Task, args arch.SyscallArguments) (uintptr, *kernel.Sysca
 	}
 
 	// Reject flags that we don't handle yet.
-	if flags & ^(linux.MSG_DONTWAIT|linux.MSG_EOR|linux.MSG_MORE|linux.MSG_NOSIGNAL) != 0 {
+	if flags & ^(linux.MSG_DONTWAIT|linux.MSG_EOR|linux.MSG_MORE|linux.MSG_NOSIGNAL|linux.MSG_ZEROCOPY) != 0 {
 		return 0, nil, syserror.EINVAL
 	}
 
@@ -958,7 +1046,7 @@ func SendMMsg(t *kernel.Task, args arch.SyscallArguments) (uintptr, *kernel.Sysc
 	}
 
 	// Reject flags that we don't handle yet.
-	if flags & ^(linux.MSG_DONTWAIT|linux.MSG_EOR|linux.MSG_MORE|linux.MSG_NOSIGNAL) != 0 {
+	if flags & ^(linux.MSG_DONTWAIT|linux.MSG_EOR|linux.MSG_MORE|linux.MSG_NOSIGNAL|linux.MSG_ZEROCOPY) != 0 {
 		return 0, nil, syserror.EINVAL
 	}
 
@@ -966,6 +1054,11 @@ func SendMMsg(t *kernel.Task, args arch.SyscallArguments) (uintptr, *kernel.Sysc
 		flags |= linux.MSG_DONTWAIT
 	}
 
+	if bs, ok := s.(socket.BatchSocket); ok && bs.Batchable(int(flags)) {
+		n, err := sendMMsgBatch(t, s, bs, file, msgPtr, vlen, flags)
+		return n, nil, err
+	}
+
 	var count uint32
 	var err error
 	for i := uint64(0); i < uint64(vlen); i++ {
@@ -995,22 +1088,123 @@ func SendMMsg(t *kernel.Task, args arch.SyscallArguments) (uintptr, *kernel.Sysc
 	return uintptr(count), nil, nil
 }
 
+// sendMMsgBatch sends the messages of sendmmsg(2) with a single call to
+// bs.SendMsgBatch.
+func sendMMsgBatch(t *kernel.Task, s socket.Socket, bs socket.BatchSocket, file *fs.File, msgPtr usermem.Addr, vlen uint32, flags int32) (uintptr, error) {
+	// Like Linux, the number of messages is capped.
+	if vlen > linux.UIO_MAXIOV {
+		vlen = linux.UIO_MAXIOV
+	}
+
+	srcs := make([]usermem.IOSequence, 0, vlen)
+	tos := make([][]byte, 0, vlen)
+	cms := make([]socket.ControlMessages, 0, vlen)
+	var err error
+	for i := uint64(0); i < uint64(vlen); i++ {
+		mp, ok := msgPtr.AddLength(i * multipleMessageHeader64Len)
+		if !ok {
+			return 0, syserror.EFAULT
+		}
+		var src usermem.IOSequence
+		var to []byte
+		var cm socket.ControlMessages
+		if src, to, cm, err = captureSendMsg(t, s, mp); err != nil {
+			// The messages preceding the invalid one are still sent.
+			break
+		}
+		srcs = append(srcs, src)
+		tos = append(tos, to)
+		cms = append(cms, cm)
+	}
+	if len(srcs) == 0 {
+		return 0, err
+	}
+
+	var haveDeadline bool
+	var deadline ktime.Time
+	if dl := s.SendTimeout(); dl > 0 {
+		deadline = t.Kernel().MonotonicClock().Now().Add(time.Duration(dl) * time.Nanosecond)
+		haveDeadline = true
+	} else if dl < 0 {
+		flags |= linux.MSG_DONTWAIT
+	}
+
+	// Call the syscall implementation.
+	n, e := bs.SendMsgBatch(t, srcs, tos, int(flags), haveDeadline, deadline, cms)
+	err = handleIOError(t, n != 0, e.ToError(), syserror.ERESTARTSYS, "sendmmsg", file)
+	// Control messages should be released for the messages which weren't sent
+	// as well as for zero-length messages, which are discarded by the receiver.
+	for i := range cms {
+		if i >= n || srcs[i].NumBytes() == 0 {
+			cms[i].Release(t)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < n; i++ {
+		// Copy the sent length to the caller.
+		mp, _ := msgPtr.AddLength(uint64(i) * multipleMessageHeader64Len)
+		lp, ok := mp.AddLength(messageHeader64Len)
+		if !ok {
+			return 0, syserror.EFAULT
+		}
+		if _, err := primitive.CopyUint32Out(t, lp, uint32(srcs[i].NumBytes())); err != nil {
+			if i == 0 {
+				return 0, err
+			}
+			return uintptr(i), nil
+		}
+	}
+	return uintptr(n), nil
+}
+
 func sendSingleMsg(t *kernel.Task, s socket.Socket, file *fs.File, msgPtr usermem.Addr, flags int32) (uintptr, error) {
+	src, to, controlMessages, err := captureSendMsg(t, s, msgPtr)
+	if err != nil {
+		return 0, err
+	}
+
+	var haveDeadline bool
+	var deadline ktime.Time
+	if dl := s.SendTimeout(); dl > 0 {
+		deadline = t.Kernel().MonotonicClock().Now().Add(time.Duration(dl) * time.Nanosecond)
+		haveDeadline = true
+	} else if dl < 0 {
+		flags |= linux.MSG_DONTWAIT
+	}
+
+	// Call the syscall implementation.
+	n, e := s.SendMsg(t, src, to, int(flags), haveDeadline, deadline, controlMessages)
+	err = handleIOError(t, n != 0, e.ToError(), syserror.ERESTARTSYS, "sendmsg", file)
+	// Control messages should be released on error as well as for zero-length
+	// messages, which are discarded by the receiver.
+	if n == 0 || err != nil {
+		controlMessages.Release(t)
+	}
+	return uintptr(n), err
+}
+
+// captureSendMsg captures the message header at msgPtr, and returns the io
+// vectors, destination address and control messages of the message to be
+// sent.
+func captureSendMsg(t *kernel.Task, s socket.Socket, msgPtr usermem.Addr) (usermem.IOSequence, []byte, socket.ControlMessages, error) {
 	// Capture the message header.
 	var msg MessageHeader64
 	if _, err := msg.CopyIn(t, msgPtr); err != nil {
-		return 0, err
+		return usermem.IOSequence{}, nil, socket.ControlMessages{}, err
 	}
 
 	var controlData []byte
 	if msg.ControlLen > 0 {
 		// Put an upper bound to prevent large allocations.
 		if msg.ControlLen > maxControlLen {
-			return 0, syserror.ENOBUFS
+			return usermem.IOSequence{}, nil, socket.ControlMessages{}, syserror.ENOBUFS
 		}
 		controlData = make([]byte, msg.ControlLen)
 		if _, err := t.CopyInBytes(usermem.Addr(msg.Control), controlData); err != nil {
-			return 0, err
+			return usermem.IOSequence{}, nil, socket.ControlMessages{}, err
 		}
 	}
 
@@ -1020,44 +1214,26 @@ func sendSingleMsg(t *kernel.Task, s socket.Socket, file *fs.File, msgPtr userme
 		var err error
 		to, err = CaptureAddress(t, usermem.Addr(msg.Name), msg.NameLen)
 		if err != nil {
-			return 0, err
+			return usermem.IOSequence{}, nil, socket.ControlMessages{}, err
 		}
 	}
 
-	// Read data then call the sendmsg implementation.
+	// Read data.
 	if msg.IovLen > linux.UIO_MAXIOV {
-		return 0, syserror.EMSGSIZE
+		return usermem.IOSequence{}, nil, socket.ControlMessages{}, syserror.EMSGSIZE
 	}
 	src, err := t.IovecsIOSequence(usermem.Addr(msg.Iov), int(msg.IovLen), usermem.IOOpts{
 		AddressSpaceActive: true,
 	})
 	if err != nil {
-		return 0, err
+		return usermem.IOSequence{}, nil, socket.ControlMessages{}, err
 	}
 
 	controlMessages, err := control.Parse(t, s, controlData)
 	if err != nil {
-		return 0, err
-	}
-
-	var haveDeadline bool
-	var deadline ktime.Time
-	if dl := s.SendTimeout(); dl > 0 {
-		deadline = t.Kernel().MonotonicClock().Now().Add(time.Duration(dl) * time.Nanosecond)
-		haveDeadline = true
-	} else if dl < 0 {
-		flags |= linux.MSG_DONTWAIT
+		return usermem.IOSequence{}, nil, socket.ControlMessages{}, err
 	}
-
-	// Call the syscall implementation.
-	n, e := s.SendMsg(t, src, to, int(flags), haveDeadline, deadline, controlMessages)
-	err = handleIOError(t, n != 0, e.ToError(), syserror.ERESTARTSYS, "sendmsg", file)
-	// Control messages should be released on error as well as for zero-length
-	// messages, which are discarded by the receiver.
-	if n == 0 || err != nil {
-		controlMessages.Release(t)
-	}
-	return uintptr(n), err
+	return src, to, controlMessages, nil
 }
 
 // sendTo is the implementation of the sendto syscall. It is called by sendto
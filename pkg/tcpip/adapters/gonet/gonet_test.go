@@ -135,7 +135,7 @@ func TestCloseReader(t *testing.T) {
 		s.Wait()
 	}()
 
-	addr := tcpip.FullAddress{NICID, tcpip.Address(net.IPv4(169, 254, 10, 1).To4()), 11211}
+	addr := tcpip.FullAddress{NIC: NICID, Addr: tcpip.Address(net.IPv4(169, 254, 10, 1).To4()), Port: 11211}
 
 	s.AddAddress(NICID, ipv4.ProtocolNumber, addr.Addr)
 
@@ -189,7 +189,7 @@ func TestCloseReaderWithForwarder(t *testing.T) {
 		s.Wait()
 	}()
 
-	addr := tcpip.FullAddress{NICID, tcpip.Address(net.IPv4(169, 254, 10, 1).To4()), 11211}
+	addr := tcpip.FullAddress{NIC: NICID, Addr: tcpip.Address(net.IPv4(169, 254, 10, 1).To4()), Port: 11211}
 	s.AddAddress(NICID, ipv4.ProtocolNumber, addr.Addr)
 
 	done := make(chan struct{})
@@ -243,7 +243,7 @@ func TestCloseRead(t *testing.T) {
 		s.Wait()
 	}()
 
-	addr := tcpip.FullAddress{NICID, tcpip.Address(net.IPv4(169, 254, 10, 1).To4()), 11211}
+	addr := tcpip.FullAddress{NIC: NICID, Addr: tcpip.Address(net.IPv4(169, 254, 10, 1).To4()), Port: 11211}
 	s.AddAddress(NICID, ipv4.ProtocolNumber, addr.Addr)
 
 	fwd := tcp.NewForwarder(s, 30000, 10, func(r *tcp.ForwarderRequest) {
@@ -287,7 +287,7 @@ func TestCloseWrite(t *testing.T) {
 		s.Wait()
 	}()
 
-	addr := tcpip.FullAddress{NICID, tcpip.Address(net.IPv4(169, 254, 10, 1).To4()), 11211}
+	addr := tcpip.FullAddress{NIC: NICID, Addr: tcpip.Address(net.IPv4(169, 254, 10, 1).To4()), Port: 11211}
 	s.AddAddress(NICID, ipv4.ProtocolNumber, addr.Addr)
 
 	fwd := tcp.NewForwarder(s, 30000, 10, func(r *tcp.ForwarderRequest) {
@@ -348,10 +348,10 @@ func TestUDPForwarder(t *testing.T) {
 	}()
 
 	ip1 := tcpip.Address(net.IPv4(169, 254, 10, 1).To4())
-	addr1 := tcpip.FullAddress{NICID, ip1, 11211}
+	addr1 := tcpip.FullAddress{NIC: NICID, Addr: ip1, Port: 11211}
 	s.AddAddress(NICID, ipv4.ProtocolNumber, ip1)
 	ip2 := tcpip.Address(net.IPv4(169, 254, 10, 2).To4())
-	addr2 := tcpip.FullAddress{NICID, ip2, 11311}
+	addr2 := tcpip.FullAddress{NIC: NICID, Addr: ip2, Port: 11311}
 	s.AddAddress(NICID, ipv4.ProtocolNumber, ip2)
 
 	done := make(chan struct{})
@@ -408,7 +408,7 @@ func TestDeadlineChange(t *testing.T) {
 		s.Wait()
 	}()
 
-	addr := tcpip.FullAddress{NICID, tcpip.Address(net.IPv4(169, 254, 10, 1).To4()), 11211}
+	addr := tcpip.FullAddress{NIC: NICID, Addr: tcpip.Address(net.IPv4(169, 254, 10, 1).To4()), Port: 11211}
 
 	s.AddAddress(NICID, ipv4.ProtocolNumber, addr.Addr)
 
@@ -464,10 +464,10 @@ func TestPacketConnTransfer(t *testing.T) {
 	}()
 
 	ip1 := tcpip.Address(net.IPv4(169, 254, 10, 1).To4())
-	addr1 := tcpip.FullAddress{NICID, ip1, 11211}
+	addr1 := tcpip.FullAddress{NIC: NICID, Addr: ip1, Port: 11211}
 	s.AddAddress(NICID, ipv4.ProtocolNumber, ip1)
 	ip2 := tcpip.Address(net.IPv4(169, 254, 10, 2).To4())
-	addr2 := tcpip.FullAddress{NICID, ip2, 11311}
+	addr2 := tcpip.FullAddress{NIC: NICID, Addr: ip2, Port: 11311}
 	s.AddAddress(NICID, ipv4.ProtocolNumber, ip2)
 
 	c1, err := DialUDP(s, &addr1, nil, ipv4.ProtocolNumber)
@@ -520,7 +520,7 @@ func TestConnectedPacketConnTransfer(t *testing.T) {
 	}()
 
 	ip := tcpip.Address(net.IPv4(169, 254, 10, 1).To4())
-	addr := tcpip.FullAddress{NICID, ip, 11211}
+	addr := tcpip.FullAddress{NIC: NICID, Addr: ip, Port: 11211}
 	s.AddAddress(NICID, ipv4.ProtocolNumber, ip)
 
 	c1, err := DialUDP(s, &addr, nil, ipv4.ProtocolNumber)
@@ -564,7 +564,7 @@ func makePipe() (c1, c2 net.Conn, stop func(), err error) {
 	}
 
 	ip := tcpip.Address(net.IPv4(169, 254, 10, 1).To4())
-	addr := tcpip.FullAddress{NICID, ip, 11211}
+	addr := tcpip.FullAddress{NIC: NICID, Addr: ip, Port: 11211}
 	s.AddAddress(NICID, ipv4.ProtocolNumber, ip)
 
 	l, err := ListenTCP(s, addr, ipv4.ProtocolNumber)
@@ -658,7 +658,7 @@ func TestTCPDialError(t *testing.T) {
 	}()
 
 	ip := tcpip.Address(net.IPv4(169, 254, 10, 1).To4())
-	addr := tcpip.FullAddress{NICID, ip, 11211}
+	addr := tcpip.FullAddress{NIC: NICID, Addr: ip, Port: 11211}
 
 	_, err := DialTCP(s, addr, ipv4.ProtocolNumber)
 	got, ok := err.(*net.OpError)
@@ -678,7 +678,7 @@ func TestDialContextTCPCanceled(t *testing.T) {
 		s.Wait()
 	}()
 
-	addr := tcpip.FullAddress{NICID, tcpip.Address(net.IPv4(169, 254, 10, 1).To4()), 11211}
+	addr := tcpip.FullAddress{NIC: NICID, Addr: tcpip.Address(net.IPv4(169, 254, 10, 1).To4()), Port: 11211}
 	s.AddAddress(NICID, ipv4.ProtocolNumber, addr.Addr)
 
 	ctx := context.Background()
@@ -700,7 +700,7 @@ func TestDialContextTCPTimeout(t *testing.T) {
 		s.Wait()
 	}()
 
-	addr := tcpip.FullAddress{NICID, tcpip.Address(net.IPv4(169, 254, 10, 1).To4()), 11211}
+	addr := tcpip.FullAddress{NIC: NICID, Addr: tcpip.Address(net.IPv4(169, 254, 10, 1).To4()), Port: 11211}
 	s.AddAddress(NICID, ipv4.ProtocolNumber, addr.Addr)
 
 	fwd := tcp.NewForwarder(s, 30000, 10, func(r *tcp.ForwarderRequest) {
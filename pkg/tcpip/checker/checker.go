@@ -296,6 +296,19 @@ func ReceiveTClass(want uint32) ControlMessagesChecker {
 	}
 }
 
+// ReceiveFlowInfo creates a checker that checks the FlowInfo field in
+// ControlMessages.
+func ReceiveFlowInfo(want uint32) ControlMessagesChecker {
+	return func(t *testing.T, cm tcpip.ControlMessages) {
+		t.Helper()
+		if !cm.HasFlowInfo {
+			t.Errorf("got cm.HasFlowInfo = %t, want = true", cm.HasFlowInfo)
+		} else if got := cm.FlowInfo; got != want {
+			t.Errorf("got cm.FlowInfo = %#x, want %#x", got, want)
+		}
+	}
+}
+
 // ReceiveTOS creates a checker that checks the TOS field in ControlMessages.
 func ReceiveTOS(want uint8) ControlMessagesChecker {
 	return func(t *testing.T, cm tcpip.ControlMessages) {
@@ -1225,6 +1238,42 @@ func NDPNASolicitedFlag(want bool) TransportChecker {
 	}
 }
 
+// NDPNAOverrideFlag creates a checker that checks the Override field of
+// a header.NDPNeighborAdvert.
+//
+// The returned TransportChecker assumes that a valid ICMPv6 is passed to it
+// containing a valid NDPNA message as far as the size is concerned.
+func NDPNAOverrideFlag(want bool) TransportChecker {
+	return func(t *testing.T, h header.Transport) {
+		t.Helper()
+
+		icmp := h.(header.ICMPv6)
+		na := header.NDPNeighborAdvert(icmp.MessageBody())
+
+		if got := na.OverrideFlag(); got != want {
+			t.Errorf("got %T.OverrideFlag = %t, want = %t", na, got, want)
+		}
+	}
+}
+
+// NDPNARouterFlag creates a checker that checks the Router field of
+// a header.NDPNeighborAdvert.
+//
+// The returned TransportChecker assumes that a valid ICMPv6 is passed to it
+// containing a valid NDPNA message as far as the size is concerned.
+func NDPNARouterFlag(want bool) TransportChecker {
+	return func(t *testing.T, h header.Transport) {
+		t.Helper()
+
+		icmp := h.(header.ICMPv6)
+		na := header.NDPNeighborAdvert(icmp.MessageBody())
+
+		if got := na.RouterFlag(); got != want {
+			t.Errorf("got %T.RouterFlag = %t, want = %t", na, got, want)
+		}
+	}
+}
+
 // ndpOptions checks that optsBuf only contains opts.
 func ndpOptions(t *testing.T, optsBuf header.NDPOptions, opts []header.NDPOption) {
 	t.Helper()
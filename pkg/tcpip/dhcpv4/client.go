@@ -0,0 +1,850 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dhcpv4 implements a DHCP client, as per RFC 2131.
+//
+// The client obtains a lease for an IPv4 address, assigns it to its NIC and
+// maintains it through the INIT, SELECTING, REQUESTING, BOUND, RENEWING and
+// REBINDING states. Routes and other configuration carried by the lease are
+// reported to the integrator, who is responsible for installing them.
+package dhcpv4
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	// ClientPort is the UDP port clients listen for messages on.
+	ClientPort = 68
+
+	// ServerPort is the UDP port servers and relay agents listen for messages
+	// on.
+	ServerPort = 67
+
+	// DefaultMaxInitialDelay is the maximum delay before the first DHCPDISCOVER
+	// message is sent, as recommended by RFC 2131 section 4.4.1.
+	DefaultMaxInitialDelay = 10 * time.Second
+)
+
+const (
+	// initialRetransmitTime and maxRetransmitTime bound the delay before a
+	// message is retransmitted, as per RFC 2131 section 4.1.
+	initialRetransmitTime = 4 * time.Second
+	maxRetransmitTime     = 64 * time.Second
+
+	// requestRetransmitCount is the number of times a DHCPREQUEST message is
+	// sent in the REQUESTING state before the client reverts to the INIT state.
+	requestRetransmitCount = 4
+
+	// minRenewRetransmitTime is the minimum delay before a DHCPREQUEST message
+	// is retransmitted in the RENEWING and REBINDING states, as per RFC 2131
+	// section 4.4.5.
+	minRenewRetransmitTime = 60 * time.Second
+
+	// declineBackoff is the minimum delay before the client restarts the
+	// configuration process after declining an address, as per RFC 2131
+	// section 3.1.
+	declineBackoff = 10 * time.Second
+
+	// infiniteDuration is the duration of an infinite lease or timer.
+	infiniteDuration = InfiniteLeaseTime * time.Second
+)
+
+var errInvalidLease = errors.New("dhcpv4: invalid lease")
+
+// parameterRequestList holds the options the client requests from servers.
+var parameterRequestList = []byte{
+	byte(OptionSubnetMask),
+	byte(OptionRouter),
+	byte(OptionDNSServers),
+	byte(OptionDomainName),
+	byte(OptionInterfaceMTU),
+	byte(OptionNTPServers),
+	byte(OptionRenewalTime),
+	byte(OptionRebindingTime),
+	byte(OptionDomainSearch),
+	byte(OptionClasslessStaticRoute),
+}
+
+// Config holds the configuration for a Client.
+type Config struct {
+	// ClientID is the client identifier sent to servers in the Client
+	// Identifier option.
+	//
+	// If empty, the hardware type followed by the NIC's link address is used,
+	// as per RFC 2132 section 9.14.
+	ClientID []byte
+
+	// Hostname is sent to servers in the Host Name option. It is not sent if
+	// empty.
+	Hostname string
+
+	// RequestedAddress is the address the client asks servers for when it
+	// starts acquiring a lease, e.g. the address it leased in a previous run.
+	// No particular address is requested if empty.
+	RequestedAddress tcpip.Address
+
+	// MaxInitialDelay is the maximum random delay before the first
+	// DHCPDISCOVER message of an acquisition is sent. Zero disables the delay.
+	//
+	// RFC 2131 recommends DefaultMaxInitialDelay.
+	MaxInitialDelay time.Duration
+
+	// OnLease is called whenever the client's lease changes, including when it
+	// is lost (with an empty Lease).
+	//
+	// OnLease is called from the goroutine running Client.Run and may call into
+	// the stack but not into the Client.
+	OnLease func(Lease)
+}
+
+// Lease holds the configuration obtained from a DHCP server.
+type Lease struct {
+	// Address is the leased address and the prefix of its subnet.
+	Address tcpip.AddressWithPrefix
+
+	// ServerID is the identifier of the server the lease was obtained from.
+	ServerID tcpip.Address
+
+	// Duration is the duration of the lease.
+	Duration time.Duration
+
+	// T1 and T2 are the durations after which the client contacts the server
+	// it obtained the lease from (RENEWING) or any server (REBINDING) to extend
+	// the lease.
+	T1 time.Duration
+	T2 time.Duration
+
+	// Routers are the routers on the client's subnet, in order of preference.
+	Routers []tcpip.Address
+
+	// ClasslessStaticRoutes are the routes advertised through the Classless
+	// Static Route option.
+	ClasslessStaticRoutes []ClasslessStaticRoute
+
+	// DNSServers are the DNS servers advertised by the server.
+	DNSServers []tcpip.Address
+
+	// DomainName is the domain name the client should use when resolving
+	// hostnames.
+	DomainName string
+
+	// DomainSearchList is the DNS search list advertised by the server.
+	DomainSearchList []string
+
+	// NTPServers are the NTP servers advertised by the server.
+	NTPServers []tcpip.Address
+
+	// MTU is the MTU to use on the NIC. Zero if not advertised.
+	MTU uint16
+}
+
+// Routes returns the routes described by the lease, to be installed on the
+// specified NIC.
+//
+// As per RFC 3442, the Router option is ignored when the lease holds classless
+// static routes.
+func (l *Lease) Routes(nicID tcpip.NICID) []tcpip.Route {
+	if l.Address.Address == "" {
+		return nil
+	}
+
+	routes := []tcpip.Route{{
+		Destination: l.Address.Subnet(),
+		NIC:         nicID,
+	}}
+	if len(l.ClasslessStaticRoutes) != 0 {
+		for _, r := range l.ClasslessStaticRoutes {
+			route := tcpip.Route{
+				Destination: r.Destination,
+				NIC:         nicID,
+			}
+			// A router of 0.0.0.0 means that the destination is on-link.
+			if r.Router != header.IPv4Any {
+				route.Gateway = r.Router
+			}
+			routes = append(routes, route)
+		}
+		return routes
+	}
+	if len(l.Routers) != 0 {
+		routes = append(routes, tcpip.Route{
+			Destination: header.IPv4EmptySubnet,
+			Gateway:     l.Routers[0],
+			NIC:         nicID,
+		})
+	}
+	return routes
+}
+
+// Client is a DHCP client for a single NIC.
+type Client struct {
+	stack           *stack.Stack
+	nicID           tcpip.NICID
+	linkAddr        tcpip.LinkAddress
+	clientID        []byte
+	hostname        string
+	requested       tcpip.Address
+	maxInitialDelay time.Duration
+	onLease         func(Lease)
+
+	mu struct {
+		sync.Mutex
+
+		lease Lease
+	}
+}
+
+// NewClient creates a DHCP client for the specified NIC.
+//
+// Returns tcpip.ErrNotSupported if the NIC does not have an Ethernet link
+// address.
+func NewClient(s *stack.Stack, nicID tcpip.NICID, config Config) (*Client, *tcpip.Error) {
+	info, ok := s.NICInfo()[nicID]
+	if !ok {
+		return nil, tcpip.ErrUnknownNICID
+	}
+	if len(info.LinkAddress) != header.EthernetAddressSize {
+		return nil, tcpip.ErrNotSupported
+	}
+
+	clientID := config.ClientID
+	if len(clientID) == 0 {
+		clientID = append([]byte{byte(header.ARPHardwareEther)}, info.LinkAddress...)
+	}
+
+	return &Client{
+		stack:           s,
+		nicID:           nicID,
+		linkAddr:        info.LinkAddress,
+		clientID:        clientID,
+		hostname:        config.Hostname,
+		requested:       config.RequestedAddress,
+		maxInitialDelay: config.MaxInitialDelay,
+		onLease:         config.OnLease,
+	}, nil
+}
+
+// Lease returns the client's current lease.
+func (c *Client) Lease() Lease {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mu.lease
+}
+
+func (c *Client) setLease(l Lease) {
+	c.mu.Lock()
+	c.mu.lease = l
+	c.mu.Unlock()
+
+	if c.onLease != nil {
+		c.onLease(l)
+	}
+}
+
+// Run runs the client until ctx is cancelled.
+//
+// While it runs, the client assigns the unspecified address (0.0.0.0) to the
+// NIC as a non-primary address to send messages before it holds a lease. When
+// Run returns, the client releases its lease and removes the leased address
+// from the NIC. Run must not be called concurrently.
+func (c *Client) Run(ctx context.Context) *tcpip.Error {
+	if err := c.stack.AddProtocolAddressWithOptions(c.nicID, tcpip.ProtocolAddress{
+		Protocol:          header.IPv4ProtocolNumber,
+		AddressWithPrefix: header.IPv4Any.WithPrefix(),
+	}, stack.NeverPrimaryEndpoint); err != nil {
+		return err
+	}
+	defer func() {
+		_ = c.stack.RemoveAddress(c.nicID, header.IPv4Any)
+	}()
+
+	cn, err := newConn(c.stack, c.nicID)
+	if err != nil {
+		return err
+	}
+	defer cn.close()
+
+	requested := c.requested
+	for ctx.Err() == nil {
+		lease, ok := c.acquire(ctx, cn, requested)
+		if !ok {
+			requested = ""
+			continue
+		}
+		if !c.assign(ctx, cn, lease) {
+			requested = ""
+			continue
+		}
+		requested = c.keep(ctx, cn, lease)
+	}
+
+	return nil
+}
+
+// acquire obtains a lease through a DHCPDISCOVER/DHCPOFFER/DHCPREQUEST/DHCPACK
+// exchange, as per RFC 2131 section 4.4.1.
+func (c *Client) acquire(ctx context.Context, cn *conn, requested tcpip.Address) (Lease, bool) {
+	if !c.initialDelay(ctx) {
+		return Lease{}, false
+	}
+
+	xid := c.stack.Rand().Uint32()
+	start := c.stack.Clock().NowMonotonic()
+	discover := c.newMessage(MessageTypeDiscover, xid)
+	discover.Broadcast = true
+	if len(requested) == header.IPv4AddressSize {
+		discover.Options = append(discover.Options, Option{Code: OptionRequestedAddress, Data: MarshalAddresses(requested)})
+	}
+	discover.Options = append(discover.Options, Option{Code: OptionParameterRequestList, Data: parameterRequestList})
+	offer, ok := c.exchange(ctx, cn, &discover, start, header.IPv4Broadcast, true /* unspecifiedSource */, c.selectingBackoff, nil /* stop */, func(m *Message) bool {
+		if m.Options.MessageType() != MessageTypeOffer {
+			return false
+		}
+		_, err := c.leaseFromReply(m, false /* requireLeaseTime */)
+		return err == nil
+	})
+	if !ok {
+		return Lease{}, false
+	}
+
+	// As per RFC 2131 section 4.4.1, the DHCPREQUEST message uses the same xid
+	// as the DHCPOFFER message.
+	offered, _ := c.leaseFromReply(&offer, false /* requireLeaseTime */)
+	request := c.newMessage(MessageTypeRequest, xid)
+	request.Broadcast = true
+	request.Options = append(request.Options,
+		Option{Code: OptionRequestedAddress, Data: MarshalAddresses(offered.Address.Address)},
+		Option{Code: OptionServerID, Data: MarshalAddresses(offered.ServerID)},
+		Option{Code: OptionParameterRequestList, Data: parameterRequestList},
+	)
+	reply, ok := c.exchange(ctx, cn, &request, start, header.IPv4Broadcast, true /* unspecifiedSource */, c.requestingBackoff, nil /* stop */, func(m *Message) bool {
+		return c.acceptAckOrNak(m, offered.ServerID)
+	})
+	if !ok {
+		return Lease{}, false
+	}
+
+	lease, err := c.leaseFromReply(&reply, true /* requireLeaseTime */)
+	if reply.Options.MessageType() != MessageTypeAck || err != nil {
+		// Avoid flooding the link if the server keeps refusing our requests.
+		c.wait(ctx, initialRetransmitTime)
+		return Lease{}, false
+	}
+	return lease, true
+}
+
+// assign adds the leased address to the NIC and waits for address conflict
+// detection to complete.
+//
+// If the address is found to be in use, the client declines it, as per RFC
+// 2131 section 3.1.
+func (c *Client) assign(ctx context.Context, cn *conn, lease Lease) bool {
+	protocolAddr := tcpip.ProtocolAddress{
+		Protocol:          header.IPv4ProtocolNumber,
+		AddressWithPrefix: lease.Address,
+	}
+	ch := make(chan stack.DADResult, 1)
+	switch err := c.stack.AddProtocolAddressWithDADHandler(c.nicID, protocolAddr, stack.CanBePrimaryEndpoint, func(r stack.DADResult) {
+		ch <- r
+	}); err {
+	case nil:
+	case tcpip.ErrNotSupported:
+		// The NIC cannot detect address conflicts.
+		if err := c.stack.AddProtocolAddressWithOptions(c.nicID, protocolAddr, stack.CanBePrimaryEndpoint); err != nil {
+			return false
+		}
+		ch <- &stack.DADSucceeded{}
+	default:
+		return false
+	}
+
+	select {
+	case <-ctx.Done():
+		_ = c.stack.RemoveAddress(c.nicID, lease.Address.Address)
+		return false
+	case r := <-ch:
+		switch r.(type) {
+		case *stack.DADSucceeded:
+			return true
+		case *stack.DADDupAddrDetected:
+			// The stack removes addresses that fail DAD.
+			decline := c.newMessage(MessageTypeDecline, c.stack.Rand().Uint32())
+			decline.Options = append(decline.Options,
+				Option{Code: OptionRequestedAddress, Data: MarshalAddresses(lease.Address.Address)},
+				Option{Code: OptionServerID, Data: MarshalAddresses(lease.ServerID)},
+			)
+			_ = cn.send(&decline, header.IPv4Broadcast, true /* unspecifiedSource */)
+			c.wait(ctx, declineBackoff)
+		}
+		return false
+	}
+}
+
+// keep maintains a lease through the RENEWING and REBINDING states, as per RFC
+// 2131 section 4.4.5, until the lease is lost or ctx is cancelled.
+//
+// Returns the address the client should request when it acquires a new lease.
+func (c *Client) keep(ctx context.Context, cn *conn, lease Lease) tcpip.Address {
+	for {
+		clock := c.stack.Clock()
+		start := clock.NowMonotonic()
+		t1C, stopT1 := tcpip.ChanAfter(c.stack.Clock(), lease.T1)
+		t2C, stopT2 := tcpip.ChanAfter(c.stack.Clock(), lease.T2)
+		expC, stopExp := tcpip.ChanAfter(c.stack.Clock(), lease.Duration)
+		stopAll := func() {
+			stopT1()
+			stopT2()
+			stopExp()
+		}
+
+		// The timers are armed before the lease is published so that time
+		// observed by the integrator is accounted for.
+		c.setLease(lease)
+
+		select {
+		case <-ctx.Done():
+			stopAll()
+			c.release(cn, lease)
+			c.lose(lease)
+			return ""
+		case <-expC:
+			stopAll()
+			c.lose(lease)
+			return ""
+		case <-t1C:
+		}
+
+		// As per RFC 2131 section 4.4.5, the client waits one-half of the
+		// remaining time until T2 (RENEWING) or the expiration of the lease
+		// (REBINDING), down to a minimum of 60 seconds, before retransmitting.
+		halfRemaining := func(d time.Duration) func(int) (time.Duration, bool) {
+			deadline := start + int64(d)
+			return func(int) (time.Duration, bool) {
+				rt := time.Duration(deadline-clock.NowMonotonic()) / 2
+				if rt < minRenewRetransmitTime {
+					rt = minRenewRetransmitTime
+				}
+				return rt, true
+			}
+		}
+
+		renewStart := clock.NowMonotonic()
+		request := c.newMessage(MessageTypeRequest, c.stack.Rand().Uint32())
+		request.ClientAddress = lease.Address.Address
+		request.Options = append(request.Options, Option{Code: OptionParameterRequestList, Data: parameterRequestList})
+		reply, ok := c.exchange(ctx, cn, &request, renewStart, lease.ServerID, false /* unspecifiedSource */, halfRemaining(lease.T2), t2C, func(m *Message) bool {
+			return c.acceptAckOrNak(m, lease.ServerID)
+		})
+		if !ok && ctx.Err() == nil {
+			request.TransactionID = c.stack.Rand().Uint32()
+			reply, ok = c.exchange(ctx, cn, &request, renewStart, header.IPv4Broadcast, false /* unspecifiedSource */, halfRemaining(lease.Duration), expC, func(m *Message) bool {
+				return c.acceptAckOrNak(m, "")
+			})
+		}
+		stopAll()
+
+		if ctx.Err() != nil {
+			c.release(cn, lease)
+			c.lose(lease)
+			return ""
+		}
+		if !ok {
+			c.lose(lease)
+			return ""
+		}
+
+		newLease, err := c.leaseFromReply(&reply, true /* requireLeaseTime */)
+		if reply.Options.MessageType() != MessageTypeAck || err != nil {
+			c.lose(lease)
+			return ""
+		}
+		if newLease.Address != lease.Address {
+			_ = c.stack.RemoveAddress(c.nicID, lease.Address.Address)
+			if !c.assign(ctx, cn, newLease) {
+				c.setLease(Lease{})
+				return ""
+			}
+		}
+		lease = newLease
+	}
+}
+
+// release informs the server that the client no longer uses the leased
+// address, as per RFC 2131 section 4.4.6.
+func (c *Client) release(cn *conn, lease Lease) {
+	m := c.newMessage(MessageTypeRelease, c.stack.Rand().Uint32())
+	m.ClientAddress = lease.Address.Address
+	m.Options = append(m.Options, Option{Code: OptionServerID, Data: MarshalAddresses(lease.ServerID)})
+	_ = cn.send(&m, lease.ServerID, false /* unspecifiedSource */)
+}
+
+// lose removes the leased address from the NIC and clears the lease.
+func (c *Client) lose(lease Lease) {
+	_ = c.stack.RemoveAddress(c.nicID, lease.Address.Address)
+	c.setLease(Lease{})
+}
+
+// initialDelay waits for a random duration bounded by the configured maximum
+// initial delay.
+//
+// Returns false if ctx was cancelled.
+func (c *Client) initialDelay(ctx context.Context) bool {
+	if c.maxInitialDelay <= 0 {
+		return ctx.Err() == nil
+	}
+	return c.wait(ctx, time.Duration(c.stack.Rand().Int63n(int64(c.maxInitialDelay))))
+}
+
+// wait waits for d to elapse on the stack's clock.
+//
+// Returns false if ctx was cancelled.
+func (c *Client) wait(ctx context.Context, d time.Duration) bool {
+	ch, stop := tcpip.ChanAfter(c.stack.Clock(), d)
+	defer stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-ch:
+		return true
+	}
+}
+
+// selectingBackoff returns the delay before the DHCPDISCOVER message is
+// retransmitted, as per RFC 2131 section 4.1.
+func (c *Client) selectingBackoff(count int) (time.Duration, bool) {
+	rt := maxRetransmitTime
+	if count < 4 {
+		rt = initialRetransmitTime << uint(count)
+	}
+	// Randomize the delay by plus or minus one second.
+	return rt + time.Duration(c.stack.Rand().Int63n(int64(2*time.Second))) - time.Second, true
+}
+
+// requestingBackoff is like selectingBackoff, but gives up after
+// requestRetransmitCount transmissions.
+func (c *Client) requestingBackoff(count int) (time.Duration, bool) {
+	if count >= requestRetransmitCount {
+		return 0, false
+	}
+	return c.selectingBackoff(count)
+}
+
+// exchange sends a message and retransmits it until a reply accepted by accept
+// is received.
+//
+// backoff returns the delay before the message is retransmitted for the
+// specified transmission count, or false if the exchange should be abandoned.
+// The exchange is also abandoned when stop is closed or ctx is cancelled.
+func (c *Client) exchange(ctx context.Context, cn *conn, m *Message, start int64, to tcpip.Address, unspecifiedSource bool, backoff func(int) (time.Duration, bool), stop <-chan struct{}, accept func(*Message) bool) (Message, bool) {
+	clock := c.stack.Clock()
+	for count := 0; ; count++ {
+		select {
+		case <-stop:
+			return Message{}, false
+		default:
+		}
+
+		secs := time.Duration(clock.NowMonotonic()-start) / time.Second
+		if secs > 0xffff {
+			secs = 0xffff
+		}
+		m.Secs = uint16(secs)
+		// Failing to send a message is handled like a lost message.
+		_ = cn.send(m, to, unspecifiedSource)
+
+		rt, ok := backoff(count)
+		if !ok {
+			return Message{}, false
+		}
+		timeoutC, stopTimeout := tcpip.ChanAfter(c.stack.Clock(), rt)
+		reply, ok := cn.receive(ctx, timeoutC, stop, func(r *Message) bool {
+			return r.Op == OpReply && r.TransactionID == m.TransactionID && r.ClientHardwareAddress == c.linkAddr && accept(r)
+		})
+		stopTimeout()
+		if ok {
+			return reply, true
+		}
+		if ctx.Err() != nil {
+			return Message{}, false
+		}
+	}
+}
+
+// newMessage returns a client message of the specified type holding the
+// client's identification.
+func (c *Client) newMessage(typ MessageType, xid uint32) Message {
+	m := Message{
+		Op:                    OpRequest,
+		TransactionID:         xid,
+		ClientHardwareAddress: c.linkAddr,
+		Options: Options{
+			{Code: OptionMessageType, Data: []byte{byte(typ)}},
+			{Code: OptionClientID, Data: c.clientID},
+		},
+	}
+	// As per RFC 2131 section 4.4.6, the DHCPRELEASE and DHCPDECLINE messages
+	// only identify the client and the lease.
+	if len(c.hostname) != 0 && typ != MessageTypeRelease && typ != MessageTypeDecline {
+		m.Options = append(m.Options, Option{Code: OptionHostName, Data: []byte(c.hostname)})
+	}
+	return m
+}
+
+// acceptAckOrNak returns true if m is a DHCPACK or DHCPNAK message, sent by
+// serverID if it is not empty.
+func (*Client) acceptAckOrNak(m *Message, serverID tcpip.Address) bool {
+	if typ := m.Options.MessageType(); typ != MessageTypeAck && typ != MessageTypeNak {
+		return false
+	}
+	if len(serverID) == 0 {
+		return true
+	}
+	b, ok := m.Options.Get(OptionServerID)
+	return ok && tcpip.Address(b) == serverID
+}
+
+// leaseFromReply returns the lease held in a DHCPOFFER or DHCPACK message.
+func (*Client) leaseFromReply(m *Message, requireLeaseTime bool) (Lease, error) {
+	addr := m.YourAddress
+	if addr == header.IPv4Any || addr == header.IPv4Broadcast || header.IsV4MulticastAddress(addr) || header.IsV4LoopbackAddress(addr) {
+		return Lease{}, errInvalidLease
+	}
+	b, ok := m.Options.Get(OptionServerID)
+	if !ok {
+		return Lease{}, errInvalidLease
+	}
+	serverID, err := ParseAddress(b)
+	if err != nil {
+		return Lease{}, errInvalidLease
+	}
+
+	lease := Lease{
+		Address: tcpip.AddressWithPrefix{
+			Address:   addr,
+			PrefixLen: classfulPrefixLen(addr),
+		},
+		ServerID: serverID,
+	}
+	if b, ok := m.Options.Get(OptionSubnetMask); ok {
+		if mask, err := ParseAddress(b); err == nil {
+			lease.Address.PrefixLen = tcpip.AddressMask(mask).Prefix()
+		}
+	}
+
+	leaseTime := uint32(InfiniteLeaseTime)
+	if b, ok := m.Options.Get(OptionLeaseTime); ok {
+		if leaseTime, err = ParseUint32(b); err != nil {
+			return Lease{}, errInvalidLease
+		}
+	} else if requireLeaseTime {
+		return Lease{}, errInvalidLease
+	}
+
+	if leaseTime == InfiniteLeaseTime {
+		lease.Duration = infiniteDuration
+		lease.T1 = infiniteDuration
+		lease.T2 = infiniteDuration
+	} else {
+		lease.Duration = time.Duration(leaseTime) * time.Second
+		// As per RFC 2131 section 4.4.5, T1 defaults to 0.5 * duration_of_lease
+		// and T2 defaults to 0.875 * duration_of_lease.
+		lease.T1 = lease.Duration / 2
+		lease.T2 = lease.Duration * 7 / 8
+		t1, t2 := lease.T1, lease.T2
+		if b, ok := m.Options.Get(OptionRenewalTime); ok {
+			if v, err := ParseUint32(b); err == nil {
+				t1 = time.Duration(v) * time.Second
+			}
+		}
+		if b, ok := m.Options.Get(OptionRebindingTime); ok {
+			if v, err := ParseUint32(b); err == nil {
+				t2 = time.Duration(v) * time.Second
+			}
+		}
+		if t1 <= t2 && t2 <= lease.Duration {
+			lease.T1 = t1
+			lease.T2 = t2
+		}
+	}
+
+	parseConfiguration(&lease, m.Options)
+	return lease, nil
+}
+
+// parseConfiguration populates the other configurations of lease from opts.
+func parseConfiguration(lease *Lease, opts Options) {
+	if b, ok := opts.Get(OptionRouter); ok {
+		if addrs, err := ParseAddresses(b); err == nil {
+			lease.Routers = addrs
+		}
+	}
+	if b, ok := opts.Get(OptionClasslessStaticRoute); ok {
+		if routes, err := ParseClasslessStaticRoutes(b); err == nil {
+			lease.ClasslessStaticRoutes = routes
+		}
+	}
+	if b, ok := opts.Get(OptionDNSServers); ok {
+		if addrs, err := ParseAddresses(b); err == nil {
+			lease.DNSServers = addrs
+		}
+	}
+	if b, ok := opts.Get(OptionDomainName); ok {
+		lease.DomainName = string(b)
+	}
+	if b, ok := opts.Get(OptionDomainSearch); ok {
+		if domains, err := ParseDomainSearch(b); err == nil {
+			lease.DomainSearchList = domains
+		}
+	}
+	if b, ok := opts.Get(OptionNTPServers); ok {
+		if addrs, err := ParseAddresses(b); err == nil {
+			lease.NTPServers = addrs
+		}
+	}
+	if b, ok := opts.Get(OptionInterfaceMTU); ok {
+		// As per RFC 2132 section 5.1, the minimum legal value for the MTU is 68.
+		if mtu, err := ParseUint16(b); err == nil && mtu >= 68 {
+			lease.MTU = mtu
+		}
+	}
+}
+
+// classfulPrefixLen returns the prefix length of the network class of addr,
+// used when the server does not provide a subnet mask.
+func classfulPrefixLen(addr tcpip.Address) int {
+	switch {
+	case addr[0] < 128:
+		return 8
+	case addr[0] < 192:
+		return 16
+	default:
+		return 24
+	}
+}
+
+// conn holds the client's UDP endpoints.
+type conn struct {
+	nicID tcpip.NICID
+
+	// ep receives messages, and sends messages from the NIC's primary address.
+	ep tcpip.Endpoint
+	wq *waiter.Queue
+
+	// unspecifiedEP sends messages from the unspecified address, before the
+	// client holds a lease.
+	unspecifiedEP tcpip.Endpoint
+}
+
+func newConn(s *stack.Stack, nicID tcpip.NICID) (*conn, *tcpip.Error) {
+	var wq waiter.Queue
+	ep, err := s.NewEndpoint(udp.ProtocolNumber, header.IPv4ProtocolNumber, &wq)
+	if err != nil {
+		return nil, err
+	}
+	// Both endpoints are bound to the client port.
+	ep.SocketOptions().SetReuseAddress(true)
+	ep.SocketOptions().SetBroadcast(true)
+	if err := ep.Bind(tcpip.FullAddress{NIC: nicID, Port: ClientPort}); err != nil {
+		ep.Close()
+		return nil, err
+	}
+
+	var unspecifiedWQ waiter.Queue
+	unspecifiedEP, err := s.NewEndpoint(udp.ProtocolNumber, header.IPv4ProtocolNumber, &unspecifiedWQ)
+	if err != nil {
+		ep.Close()
+		return nil, err
+	}
+	unspecifiedEP.SocketOptions().SetReuseAddress(true)
+	unspecifiedEP.SocketOptions().SetBroadcast(true)
+	if err := unspecifiedEP.Bind(tcpip.FullAddress{NIC: nicID, Addr: header.IPv4Any, Port: ClientPort}); err != nil {
+		ep.Close()
+		unspecifiedEP.Close()
+		return nil, err
+	}
+
+	return &conn{
+		nicID:         nicID,
+		ep:            ep,
+		wq:            &wq,
+		unspecifiedEP: unspecifiedEP,
+	}, nil
+}
+
+func (cn *conn) close() {
+	cn.ep.Close()
+	cn.unspecifiedEP.Close()
+}
+
+// send sends a message to a server or, if to is the broadcast address, all
+// servers on the link.
+func (cn *conn) send(m *Message, to tcpip.Address, unspecifiedSource bool) *tcpip.Error {
+	ep := cn.ep
+	if unspecifiedSource {
+		ep = cn.unspecifiedEP
+	}
+	v := buffer.View(m.Marshal())
+	_, _, err := ep.Write(tcpip.SlicePayload(v), tcpip.WriteOptions{
+		To: &tcpip.FullAddress{NIC: cn.nicID, Addr: to, Port: ServerPort},
+	})
+	return err
+}
+
+// receive waits for a message accepted by accept.
+//
+// Returns false if timeoutC or stop is closed or ctx is cancelled before such
+// a message is received.
+func (cn *conn) receive(ctx context.Context, timeoutC, stop <-chan struct{}, accept func(*Message) bool) (Message, bool) {
+	waitEntry, notifyCh := waiter.NewChannelEntry(nil)
+	cn.wq.EventRegister(&waitEntry, waiter.EventIn)
+	defer cn.wq.EventUnregister(&waitEntry)
+
+	for {
+		v, _, err := cn.ep.Read(nil)
+		if err == tcpip.ErrWouldBlock {
+			select {
+			case <-ctx.Done():
+				return Message{}, false
+			case <-timeoutC:
+				return Message{}, false
+			case <-stop:
+				return Message{}, false
+			case <-notifyCh:
+				continue
+			}
+		}
+		if err != nil {
+			return Message{}, false
+		}
+
+		m, parseErr := ParseMessage(v)
+		if parseErr != nil {
+			continue
+		}
+		if accept(&m) {
+			return m, true
+		}
+	}
+}
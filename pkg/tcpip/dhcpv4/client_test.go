@@ -0,0 +1,367 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcpv4
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/pipe"
+	"gvisor.dev/gvisor/pkg/tcpip/network/arp"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	nicID = 1
+
+	clientLinkAddr = testLinkAddr
+	serverLinkAddr = tcpip.LinkAddress("\x02\x02\x03\x04\x05\x07")
+
+	testLeaseTime = 100
+
+	testTimeout = 5 * time.Second
+)
+
+var (
+	testDNSServers = []tcpip.Address{testAddr1}
+	testSubnet     = tcpip.AddressWithPrefix{Address: testAddr1, PrefixLen: 24}
+)
+
+// received is a message received by the test server.
+type received struct {
+	typ    MessageType
+	ciaddr tcpip.Address
+}
+
+// testServer is a minimal DHCP server that hands out a single address.
+type testServer struct {
+	ep       tcpip.Endpoint
+	wq       *waiter.Queue
+	received chan received
+
+	// ignoreRequests is the number of DHCPREQUEST messages from bound clients
+	// to ignore.
+	ignoreRequests int
+
+	// maxOffers is the number of DHCPDISCOVER messages to answer. Zero means
+	// no limit.
+	maxOffers int
+	offers    int
+}
+
+func (s *testServer) serve() {
+	waitEntry, notifyCh := waiter.NewChannelEntry(nil)
+	s.wq.EventRegister(&waitEntry, waiter.EventIn)
+	defer s.wq.EventUnregister(&waitEntry)
+
+	for {
+		v, _, err := s.ep.Read(nil)
+		if err == tcpip.ErrWouldBlock {
+			<-notifyCh
+			continue
+		}
+		if err != nil {
+			return
+		}
+		m, parseErr := ParseMessage(v)
+		if parseErr != nil || m.Op != OpRequest {
+			continue
+		}
+		typ := m.Options.MessageType()
+		s.received <- received{typ: typ, ciaddr: m.ClientAddress}
+
+		reply := Message{
+			Op:                    OpReply,
+			TransactionID:         m.TransactionID,
+			Broadcast:             m.Broadcast,
+			ClientAddress:         m.ClientAddress,
+			YourAddress:           testAddr2,
+			ClientHardwareAddress: m.ClientHardwareAddress,
+			Options: Options{
+				{Code: OptionServerID, Data: MarshalAddresses(testAddr1)},
+				{Code: OptionLeaseTime, Data: MarshalUint32(testLeaseTime)},
+				{Code: OptionSubnetMask, Data: MarshalAddresses("\xff\xff\xff\x00")},
+				{Code: OptionRouter, Data: MarshalAddresses(testAddr1)},
+				{Code: OptionDNSServers, Data: MarshalAddresses(testDNSServers...)},
+				{Code: OptionDomainSearch, Data: MarshalDomainSearch([]string{"example.com"})},
+			},
+		}
+		switch typ {
+		case MessageTypeDiscover:
+			if s.maxOffers != 0 && s.offers == s.maxOffers {
+				continue
+			}
+			s.offers++
+			reply.Options = append(reply.Options, Option{Code: OptionMessageType, Data: []byte{byte(MessageTypeOffer)}})
+		case MessageTypeRequest:
+			if m.ClientAddress != header.IPv4Any && s.ignoreRequests > 0 {
+				s.ignoreRequests--
+				continue
+			}
+			reply.Options = append(reply.Options, Option{Code: OptionMessageType, Data: []byte{byte(MessageTypeAck)}})
+		default:
+			continue
+		}
+
+		to := header.IPv4Broadcast
+		if !m.Broadcast && m.ClientAddress != header.IPv4Any {
+			to = m.ClientAddress
+		}
+		if _, _, err := s.ep.Write(tcpip.SlicePayload(buffer.View(reply.Marshal())), tcpip.WriteOptions{
+			To: &tcpip.FullAddress{NIC: nicID, Addr: to, Port: ClientPort},
+		}); err != nil {
+			return
+		}
+	}
+}
+
+func (s *testServer) expect(t *testing.T, want MessageType, wantCIAddr tcpip.Address) {
+	t.Helper()
+
+	select {
+	case got := <-s.received:
+		if got.typ != want {
+			t.Fatalf("got server received message type = %s, want = %s", got.typ, want)
+		}
+		if got.ciaddr != wantCIAddr {
+			t.Errorf("got %s ciaddr = %s, want = %s", got.typ, got.ciaddr, wantCIAddr)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("timed out waiting for %s message", want)
+	}
+}
+
+func newServer(t *testing.T, ep stack.LinkEndpoint) *testServer {
+	t.Helper()
+
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+	})
+	if err := s.CreateNIC(nicID, ep); err != nil {
+		t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+	}
+	protocolAddr := tcpip.ProtocolAddress{Protocol: ipv4.ProtocolNumber, AddressWithPrefix: testSubnet}
+	if err := s.AddProtocolAddress(nicID, protocolAddr); err != nil {
+		t.Fatalf("s.AddProtocolAddress(%d, %#v): %s", nicID, protocolAddr, err)
+	}
+	s.SetRouteTable([]tcpip.Route{{Destination: testSubnet.Subnet(), NIC: nicID}})
+
+	var wq waiter.Queue
+	sep, err := s.NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+	if err != nil {
+		t.Fatalf("s.NewEndpoint(%d, %d, _): %s", udp.ProtocolNumber, ipv4.ProtocolNumber, err)
+	}
+	t.Cleanup(sep.Close)
+	sep.SocketOptions().SetBroadcast(true)
+	if err := sep.Bind(tcpip.FullAddress{NIC: nicID, Port: ServerPort}); err != nil {
+		t.Fatalf("sep.Bind(_): %s", err)
+	}
+
+	srv := &testServer{
+		ep:       sep,
+		wq:       &wq,
+		received: make(chan received, 10),
+	}
+	return srv
+}
+
+type testContext struct {
+	clock   *faketime.ManualClock
+	stack   *stack.Stack
+	srv     *testServer
+	leaseCh chan Lease
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func newTestContext(t *testing.T, configure func(*testServer)) *testContext {
+	t.Helper()
+
+	clock := faketime.NewManualClock()
+	clientEP, serverEP := pipe.New(clientLinkAddr, serverLinkAddr)
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{arp.NewProtocol, ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+		Clock:              clock,
+	})
+	if err := s.CreateNIC(nicID, clientEP); err != nil {
+		t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+	}
+	srv := newServer(t, serverEP)
+	if configure != nil {
+		configure(srv)
+	}
+	go srv.serve()
+
+	leaseCh := make(chan Lease, 1)
+	c, err := NewClient(s, nicID, Config{
+		Hostname: "host",
+		OnLease: func(l Lease) {
+			// Install the routes of the lease as integrators would.
+			s.SetRouteTable(l.Routes(nicID))
+			leaseCh <- l
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewClient(_, %d, _): %s", nicID, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := c.Run(ctx); err != nil {
+			t.Errorf("c.Run(_): %s", err)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		for {
+			select {
+			case <-done:
+				return
+			case <-leaseCh:
+			}
+		}
+	})
+
+	return &testContext{
+		clock:   clock,
+		stack:   s,
+		srv:     srv,
+		leaseCh: leaseCh,
+		cancel:  cancel,
+		done:    done,
+	}
+}
+
+func (c *testContext) expectLease(t *testing.T, want Lease) {
+	t.Helper()
+
+	select {
+	case got := <-c.leaseCh:
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("lease mismatch (-want +got):\n%s", diff)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for lease")
+	}
+
+	wantNIC := tcpip.NICID(nicID)
+	if want.Address.Address == "" {
+		wantNIC = 0
+	}
+	if got := c.stack.CheckLocalAddress(nicID, ipv4.ProtocolNumber, testAddr2); got != wantNIC {
+		t.Errorf("got c.stack.CheckLocalAddress(%d, %d, %s) = %d, want = %d", nicID, ipv4.ProtocolNumber, testAddr2, got, wantNIC)
+	}
+}
+
+var wantLease = Lease{
+	Address:          tcpip.AddressWithPrefix{Address: testAddr2, PrefixLen: 24},
+	ServerID:         testAddr1,
+	Duration:         testLeaseTime * time.Second,
+	T1:               testLeaseTime * time.Second / 2,
+	T2:               testLeaseTime * time.Second * 7 / 8,
+	Routers:          []tcpip.Address{testAddr1},
+	DNSServers:       testDNSServers,
+	DomainSearchList: []string{"example.com"},
+}
+
+func TestClientRenew(t *testing.T) {
+	c := newTestContext(t, nil)
+
+	c.srv.expect(t, MessageTypeDiscover, header.IPv4Any)
+	c.srv.expect(t, MessageTypeRequest, header.IPv4Any)
+	c.expectLease(t, wantLease)
+
+	// The lease should be renewed with the server at T1.
+	c.clock.Advance(wantLease.T1)
+	c.srv.expect(t, MessageTypeRequest, testAddr2)
+	c.expectLease(t, wantLease)
+
+	// The lease should be released when the client stops.
+	c.cancel()
+	c.srv.expect(t, MessageTypeRelease, testAddr2)
+	c.expectLease(t, Lease{})
+	<-c.done
+	if got := c.stack.CheckLocalAddress(nicID, ipv4.ProtocolNumber, header.IPv4Any); got != 0 {
+		t.Errorf("got c.stack.CheckLocalAddress(%d, %d, %s) = %d, want = 0", nicID, ipv4.ProtocolNumber, header.IPv4Any, got)
+	}
+}
+
+func TestClientRebind(t *testing.T) {
+	c := newTestContext(t, func(s *testServer) { s.ignoreRequests = 1 })
+
+	c.srv.expect(t, MessageTypeDiscover, header.IPv4Any)
+	c.srv.expect(t, MessageTypeRequest, header.IPv4Any)
+	c.expectLease(t, wantLease)
+
+	// The server ignores the client while it is renewing, so the client should
+	// contact any server at T2.
+	c.clock.Advance(wantLease.T1)
+	c.srv.expect(t, MessageTypeRequest, testAddr2)
+	c.clock.Advance(wantLease.T2 - wantLease.T1)
+	c.srv.expect(t, MessageTypeRequest, testAddr2)
+	c.expectLease(t, wantLease)
+}
+
+func TestClientLeaseExpiry(t *testing.T) {
+	c := newTestContext(t, func(s *testServer) {
+		s.ignoreRequests = 2
+		s.maxOffers = 1
+	})
+
+	c.srv.expect(t, MessageTypeDiscover, header.IPv4Any)
+	c.srv.expect(t, MessageTypeRequest, header.IPv4Any)
+	c.expectLease(t, wantLease)
+
+	c.clock.Advance(wantLease.T1)
+	c.srv.expect(t, MessageTypeRequest, testAddr2)
+	c.clock.Advance(wantLease.T2 - wantLease.T1)
+	c.srv.expect(t, MessageTypeRequest, testAddr2)
+
+	// The address should be removed when the lease expires, and the client
+	// should start over. The server does not make another offer.
+	c.clock.Advance(wantLease.Duration - wantLease.T2)
+	c.expectLease(t, Lease{})
+	c.srv.expect(t, MessageTypeDiscover, header.IPv4Any)
+}
+
+func TestNewClientErrors(t *testing.T) {
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+	})
+	if _, err := NewClient(s, nicID, Config{}); err != tcpip.ErrUnknownNICID {
+		t.Errorf("got NewClient(_, %d, _) = (_, %v), want = (_, %s)", nicID, err, tcpip.ErrUnknownNICID)
+	}
+
+	ep, _ := pipe.New("", "")
+	if err := s.CreateNIC(nicID, ep); err != nil {
+		t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+	}
+	if _, err := NewClient(s, nicID, Config{}); err != tcpip.ErrNotSupported {
+		t.Errorf("got NewClient(_, %d, _) = (_, %v), want = (_, %s)", nicID, err, tcpip.ErrNotSupported)
+	}
+}
@@ -0,0 +1,496 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcpv4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// Op is the BOOTP message op code, as per RFC 2131 section 2.
+type Op uint8
+
+// BOOTP message op codes.
+const (
+	OpRequest Op = 1
+	OpReply   Op = 2
+)
+
+// MessageType is a DHCP message type, as per RFC 2132 section 9.6.
+type MessageType uint8
+
+// DHCP message types.
+const (
+	MessageTypeDiscover MessageType = 1
+	MessageTypeOffer    MessageType = 2
+	MessageTypeRequest  MessageType = 3
+	MessageTypeDecline  MessageType = 4
+	MessageTypeAck      MessageType = 5
+	MessageTypeNak      MessageType = 6
+	MessageTypeRelease  MessageType = 7
+	MessageTypeInform   MessageType = 8
+)
+
+// String implements fmt.Stringer.
+func (t MessageType) String() string {
+	switch t {
+	case MessageTypeDiscover:
+		return "DHCPDISCOVER"
+	case MessageTypeOffer:
+		return "DHCPOFFER"
+	case MessageTypeRequest:
+		return "DHCPREQUEST"
+	case MessageTypeDecline:
+		return "DHCPDECLINE"
+	case MessageTypeAck:
+		return "DHCPACK"
+	case MessageTypeNak:
+		return "DHCPNAK"
+	case MessageTypeRelease:
+		return "DHCPRELEASE"
+	case MessageTypeInform:
+		return "DHCPINFORM"
+	default:
+		return fmt.Sprintf("MessageType(%d)", t)
+	}
+}
+
+// OptionCode is a DHCP option code, as per RFC 2132.
+type OptionCode uint8
+
+// DHCP option codes.
+const (
+	OptionPad                  OptionCode = 0
+	OptionSubnetMask           OptionCode = 1
+	OptionRouter               OptionCode = 3
+	OptionDNSServers           OptionCode = 6
+	OptionHostName             OptionCode = 12
+	OptionDomainName           OptionCode = 15
+	OptionInterfaceMTU         OptionCode = 26
+	OptionBroadcastAddress     OptionCode = 28
+	OptionNTPServers           OptionCode = 42
+	OptionRequestedAddress     OptionCode = 50
+	OptionLeaseTime            OptionCode = 51
+	OptionMessageType          OptionCode = 53
+	OptionServerID             OptionCode = 54
+	OptionParameterRequestList OptionCode = 55
+	OptionMessage              OptionCode = 56
+	OptionMaxMessageSize       OptionCode = 57
+	OptionRenewalTime          OptionCode = 58
+	OptionRebindingTime        OptionCode = 59
+	OptionClientID             OptionCode = 61
+	OptionDomainSearch         OptionCode = 119
+	OptionClasslessStaticRoute OptionCode = 121
+	OptionEnd                  OptionCode = 255
+)
+
+// InfiniteLeaseTime is the value of the IP Address Lease Time option for leases
+// that never expire, as per RFC 2131 section 3.3.
+const InfiniteLeaseTime = 0xffffffff
+
+// MinimumMessageSize is the minimum size of a DHCP message every DHCP
+// participant must accept, as per RFC 2131 section 2.
+const MinimumMessageSize = 576 - header.IPv4MinimumSize - header.UDPMinimumSize
+
+const (
+	// messageHeaderSize is the size of the fixed-format BOOTP header, as per RFC
+	// 2131 section 2, followed by the magic cookie.
+	messageHeaderSize = 240
+	optionHeaderSize  = 2
+
+	chaddrOffset = 28
+	chaddrSize   = 16
+	cookieOffset = 236
+
+	// flagBroadcast is the BROADCAST bit of the flags field, as per RFC 2131
+	// section 2.
+	flagBroadcast = 1 << 15
+)
+
+// magicCookie identifies the start of DHCP options, as per RFC 2131 section 3.
+var magicCookie = [4]byte{99, 130, 83, 99}
+
+var (
+	errMessageTruncated = errors.New("dhcpv4: message truncated")
+	errOptionTruncated  = errors.New("dhcpv4: option truncated")
+	errBadMagicCookie   = errors.New("dhcpv4: bad magic cookie")
+	errBadHardwareLen   = errors.New("dhcpv4: bad hardware address length")
+)
+
+// Option is a DHCP option.
+type Option struct {
+	Code OptionCode
+	Data []byte
+}
+
+// Options is a list of DHCP options.
+type Options []Option
+
+// Get returns the data of the first option with the specified code.
+func (o Options) Get(code OptionCode) ([]byte, bool) {
+	for _, opt := range o {
+		if opt.Code == code {
+			return opt.Data, true
+		}
+	}
+	return nil, false
+}
+
+// MessageType returns the type of the message holding the options.
+//
+// Returns 0 if the options do not hold a valid DHCP Message Type option.
+func (o Options) MessageType() MessageType {
+	b, ok := o.Get(OptionMessageType)
+	if !ok || len(b) != 1 {
+		return 0
+	}
+	return MessageType(b[0])
+}
+
+func (o Options) size() int {
+	l := 1 // End option.
+	for _, opt := range o {
+		// Options longer than 255 bytes are split in multiple options, as per
+		// RFC 3396.
+		chunks := (len(opt.Data) + 254) / 255
+		if chunks == 0 {
+			chunks = 1
+		}
+		l += chunks*optionHeaderSize + len(opt.Data)
+	}
+	return l
+}
+
+func (o Options) marshal(b []byte) int {
+	i := 0
+	for _, opt := range o {
+		data := opt.Data
+		for first := true; first || len(data) != 0; first = false {
+			n := len(data)
+			if n > 255 {
+				n = 255
+			}
+			b[i] = byte(opt.Code)
+			b[i+1] = byte(n)
+			i += optionHeaderSize
+			i += copy(b[i:], data[:n])
+			data = data[n:]
+		}
+	}
+	b[i] = byte(OptionEnd)
+	return i + 1
+}
+
+// ParseOptions parses a sequence of DHCP options terminated by the End option.
+//
+// Options with the same code are concatenated, as per RFC 3396.
+func ParseOptions(b []byte) (Options, error) {
+	var opts Options
+	index := make(map[OptionCode]int)
+	for len(b) != 0 {
+		code := OptionCode(b[0])
+		switch code {
+		case OptionPad:
+			b = b[1:]
+			continue
+		case OptionEnd:
+			return opts, nil
+		}
+		if len(b) < optionHeaderSize {
+			return nil, errOptionTruncated
+		}
+		l := int(b[1])
+		b = b[optionHeaderSize:]
+		if len(b) < l {
+			return nil, errOptionTruncated
+		}
+		if i, ok := index[code]; ok {
+			opts[i].Data = append(opts[i].Data, b[:l]...)
+		} else {
+			index[code] = len(opts)
+			opts = append(opts, Option{Code: code, Data: b[:l:l]})
+		}
+		b = b[l:]
+	}
+	// Be lenient with senders that omit the End option.
+	return opts, nil
+}
+
+// Message is a DHCP message, as per RFC 2131 section 2.
+//
+// The sname and file fields are not used and option overloading is not
+// supported.
+type Message struct {
+	Op Op
+
+	// TransactionID is the xid field of the message.
+	TransactionID uint32
+
+	// Secs is the number of seconds elapsed since the client began address
+	// acquisition or renewal.
+	Secs uint16
+
+	// Broadcast is the BROADCAST flag, set by clients that cannot receive
+	// unicast IP datagrams until their address is configured.
+	Broadcast bool
+
+	ClientAddress  tcpip.Address // ciaddr
+	YourAddress    tcpip.Address // yiaddr
+	ServerAddress  tcpip.Address // siaddr
+	GatewayAddress tcpip.Address // giaddr
+
+	// ClientHardwareAddress is the chaddr field of the message. Only Ethernet
+	// addresses are supported.
+	ClientHardwareAddress tcpip.LinkAddress
+
+	Options Options
+}
+
+// Marshal returns the wire representation of the message.
+func (m *Message) Marshal() []byte {
+	b := make([]byte, messageHeaderSize+m.Options.size())
+	b[0] = byte(m.Op)
+	b[1] = byte(header.ARPHardwareEther)
+	b[2] = byte(len(m.ClientHardwareAddress))
+	binary.BigEndian.PutUint32(b[4:], m.TransactionID)
+	binary.BigEndian.PutUint16(b[8:], m.Secs)
+	if m.Broadcast {
+		binary.BigEndian.PutUint16(b[10:], flagBroadcast)
+	}
+	putAddress(b[12:], m.ClientAddress)
+	putAddress(b[16:], m.YourAddress)
+	putAddress(b[20:], m.ServerAddress)
+	putAddress(b[24:], m.GatewayAddress)
+	copy(b[chaddrOffset:chaddrOffset+chaddrSize], m.ClientHardwareAddress)
+	copy(b[cookieOffset:], magicCookie[:])
+	m.Options.marshal(b[messageHeaderSize:])
+	return b
+}
+
+func putAddress(b []byte, addr tcpip.Address) {
+	if len(addr) == header.IPv4AddressSize {
+		copy(b, addr)
+	}
+}
+
+// ParseMessage parses a DHCP message.
+func ParseMessage(b []byte) (Message, error) {
+	if len(b) < messageHeaderSize {
+		return Message{}, errMessageTruncated
+	}
+	if [4]byte{b[cookieOffset], b[cookieOffset+1], b[cookieOffset+2], b[cookieOffset+3]} != magicCookie {
+		return Message{}, errBadMagicCookie
+	}
+	hlen := int(b[2])
+	if hlen > chaddrSize {
+		return Message{}, errBadHardwareLen
+	}
+	opts, err := ParseOptions(b[messageHeaderSize:])
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{
+		Op:                    Op(b[0]),
+		TransactionID:         binary.BigEndian.Uint32(b[4:]),
+		Secs:                  binary.BigEndian.Uint16(b[8:]),
+		Broadcast:             binary.BigEndian.Uint16(b[10:])&flagBroadcast != 0,
+		ClientAddress:         tcpip.Address(b[12:16]),
+		YourAddress:           tcpip.Address(b[16:20]),
+		ServerAddress:         tcpip.Address(b[20:24]),
+		GatewayAddress:        tcpip.Address(b[24:28]),
+		ClientHardwareAddress: tcpip.LinkAddress(b[chaddrOffset : chaddrOffset+hlen]),
+		Options:               opts,
+	}, nil
+}
+
+// MarshalAddresses returns the option data of an option holding a list of IPv4
+// addresses, such as the Router option.
+func MarshalAddresses(addrs ...tcpip.Address) []byte {
+	b := make([]byte, 0, len(addrs)*header.IPv4AddressSize)
+	for _, a := range addrs {
+		b = append(b, a...)
+	}
+	return b
+}
+
+// ParseAddresses parses the option data of an option holding a list of IPv4
+// addresses.
+func ParseAddresses(b []byte) ([]tcpip.Address, error) {
+	if len(b) == 0 || len(b)%header.IPv4AddressSize != 0 {
+		return nil, errOptionTruncated
+	}
+	addrs := make([]tcpip.Address, 0, len(b)/header.IPv4AddressSize)
+	for ; len(b) != 0; b = b[header.IPv4AddressSize:] {
+		addrs = append(addrs, tcpip.Address(b[:header.IPv4AddressSize]))
+	}
+	return addrs, nil
+}
+
+// ParseAddress parses the option data of an option holding a single IPv4
+// address.
+func ParseAddress(b []byte) (tcpip.Address, error) {
+	if len(b) != header.IPv4AddressSize {
+		return "", errOptionTruncated
+	}
+	return tcpip.Address(b), nil
+}
+
+// MarshalUint32 returns the option data of an option holding a 32-bit value,
+// such as the IP Address Lease Time option.
+func MarshalUint32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+// ParseUint32 parses the option data of an option holding a 32-bit value.
+func ParseUint32(b []byte) (uint32, error) {
+	if len(b) != 4 {
+		return 0, errOptionTruncated
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+// MarshalUint16 returns the option data of an option holding a 16-bit value,
+// such as the Interface MTU option.
+func MarshalUint16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+// ParseUint16 parses the option data of an option holding a 16-bit value.
+func ParseUint16(b []byte) (uint16, error) {
+	if len(b) != 2 {
+		return 0, errOptionTruncated
+	}
+	return binary.BigEndian.Uint16(b), nil
+}
+
+// ClasslessStaticRoute is a route held in a Classless Static Route option, as
+// per RFC 3442.
+type ClasslessStaticRoute struct {
+	Destination tcpip.Subnet
+	Router      tcpip.Address
+}
+
+// MarshalClasslessStaticRoutes returns the option data of a Classless Static
+// Route option.
+func MarshalClasslessStaticRoutes(routes []ClasslessStaticRoute) []byte {
+	var b []byte
+	for _, r := range routes {
+		width := r.Destination.Prefix()
+		b = append(b, byte(width))
+		b = append(b, r.Destination.ID()[:(width+7)/8]...)
+		b = append(b, r.Router...)
+	}
+	return b
+}
+
+// ParseClasslessStaticRoutes parses the option data of a Classless Static
+// Route option.
+func ParseClasslessStaticRoutes(b []byte) ([]ClasslessStaticRoute, error) {
+	var routes []ClasslessStaticRoute
+	for len(b) != 0 {
+		width := int(b[0])
+		if width > 32 {
+			return nil, errOptionTruncated
+		}
+		significant := (width + 7) / 8
+		b = b[1:]
+		if len(b) < significant+header.IPv4AddressSize {
+			return nil, errOptionTruncated
+		}
+		var dest [header.IPv4AddressSize]byte
+		copy(dest[:], b[:significant])
+		subnet := tcpip.AddressWithPrefix{
+			Address:   tcpip.Address(dest[:]),
+			PrefixLen: width,
+		}.Subnet()
+		b = b[significant:]
+		routes = append(routes, ClasslessStaticRoute{
+			Destination: subnet,
+			Router:      tcpip.Address(b[:header.IPv4AddressSize]),
+		})
+		b = b[header.IPv4AddressSize:]
+	}
+	return routes, nil
+}
+
+// MarshalDomainSearch returns the option data of a Domain Search option,
+// encoding the domains as uncompressed DNS names (RFC 1035 section 3.1).
+func MarshalDomainSearch(domains []string) []byte {
+	var b []byte
+	for _, d := range domains {
+		for _, label := range strings.Split(strings.TrimSuffix(d, "."), ".") {
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+		b = append(b, 0)
+	}
+	return b
+}
+
+// ParseDomainSearch parses the option data of a Domain Search option, as per
+// RFC 3397, following DNS compression pointers (RFC 1035 section 4.1.4).
+func ParseDomainSearch(b []byte) ([]string, error) {
+	var domains []string
+	for i := 0; i < len(b); {
+		var labels []string
+		next := -1
+		// Each jump must go backwards, which guarantees termination.
+		for pos, limit := i, len(b); ; {
+			if pos >= limit {
+				return nil, errOptionTruncated
+			}
+			l := int(b[pos])
+			if l == 0 {
+				pos++
+				if next < 0 {
+					next = pos
+				}
+				break
+			}
+			if l&0xc0 == 0xc0 {
+				if pos+1 >= len(b) {
+					return nil, errOptionTruncated
+				}
+				if next < 0 {
+					next = pos + 2
+				}
+				ptr := int(binary.BigEndian.Uint16(b[pos:]) & 0x3fff)
+				if ptr >= pos {
+					return nil, errOptionTruncated
+				}
+				limit = pos
+				pos = ptr
+				continue
+			}
+			if pos+1+l > len(b) {
+				return nil, errOptionTruncated
+			}
+			labels = append(labels, string(b[pos+1:pos+1+l]))
+			pos += 1 + l
+		}
+		domains = append(domains, strings.Join(labels, "."))
+		i = next
+	}
+	return domains, nil
+}
@@ -0,0 +1,207 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcpv4
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+const (
+	testAddr1 = tcpip.Address("\xc0\xa8\x00\x01")
+	testAddr2 = tcpip.Address("\xc0\xa8\x00\x0a")
+
+	testLinkAddr = tcpip.LinkAddress("\x02\x02\x03\x04\x05\x06")
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	msg := Message{
+		Op:                    OpReply,
+		TransactionID:         0xdeadbeef,
+		Secs:                  7,
+		Broadcast:             true,
+		ClientAddress:         header.IPv4Any,
+		YourAddress:           testAddr2,
+		ServerAddress:         testAddr1,
+		GatewayAddress:        header.IPv4Any,
+		ClientHardwareAddress: testLinkAddr,
+		Options: Options{
+			{Code: OptionMessageType, Data: []byte{byte(MessageTypeAck)}},
+			{Code: OptionServerID, Data: MarshalAddresses(testAddr1)},
+			{Code: OptionLeaseTime, Data: MarshalUint32(3600)},
+			{Code: OptionInterfaceMTU, Data: MarshalUint16(1400)},
+			// Options longer than 255 bytes are split and concatenated back.
+			{Code: OptionHostName, Data: bytes.Repeat([]byte("a"), 300)},
+		},
+	}
+
+	got, err := ParseMessage(msg.Marshal())
+	if err != nil {
+		t.Fatalf("ParseMessage(_): %s", err)
+	}
+	if diff := cmp.Diff(msg, got); diff != "" {
+		t.Errorf("message mismatch (-want +got):\n%s", diff)
+	}
+	if typ := got.Options.MessageType(); typ != MessageTypeAck {
+		t.Errorf("got got.Options.MessageType() = %s, want = %s", typ, MessageTypeAck)
+	}
+}
+
+func TestParseMessageErrors(t *testing.T) {
+	valid := (&Message{Op: OpRequest, ClientHardwareAddress: testLinkAddr}).Marshal()
+
+	badCookie := append([]byte(nil), valid...)
+	badCookie[cookieOffset] = 0
+
+	truncatedOption := append([]byte(nil), valid[:messageHeaderSize]...)
+	truncatedOption = append(truncatedOption, byte(OptionRouter), 8, 1, 2, 3, 4)
+
+	for _, test := range []struct {
+		name string
+		b    []byte
+	}{
+		{name: "Truncated header", b: valid[:messageHeaderSize-1]},
+		{name: "Bad magic cookie", b: badCookie},
+		{name: "Truncated option", b: truncatedOption},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ParseMessage(test.b); err == nil {
+				t.Error("got ParseMessage(_) = nil error, want error")
+			}
+		})
+	}
+}
+
+func TestClasslessStaticRoutes(t *testing.T) {
+	routes := []ClasslessStaticRoute{
+		{
+			Destination: tcpip.AddressWithPrefix{Address: "\x0a\x00\x00\x00", PrefixLen: 8}.Subnet(),
+			Router:      testAddr1,
+		},
+		{
+			Destination: tcpip.AddressWithPrefix{Address: "\xc0\xa8\x40\x00", PrefixLen: 18}.Subnet(),
+			Router:      header.IPv4Any,
+		},
+		{
+			Destination: header.IPv4EmptySubnet,
+			Router:      testAddr1,
+		},
+	}
+
+	b := MarshalClasslessStaticRoutes(routes)
+	want := []byte{
+		8, 10, 192, 168, 0, 1,
+		18, 192, 168, 64, 0, 0, 0, 0,
+		0, 192, 168, 0, 1,
+	}
+	if !bytes.Equal(b, want) {
+		t.Errorf("got MarshalClasslessStaticRoutes(_) = %v, want = %v", b, want)
+	}
+
+	got, err := ParseClasslessStaticRoutes(b)
+	if err != nil {
+		t.Fatalf("ParseClasslessStaticRoutes(_): %s", err)
+	}
+	if diff := cmp.Diff(routes, got, cmp.AllowUnexported(tcpip.Subnet{})); diff != "" {
+		t.Errorf("routes mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := ParseClasslessStaticRoutes([]byte{33, 1, 2, 3, 4, 5, 1, 2, 3, 4}); err == nil {
+		t.Error("got ParseClasslessStaticRoutes(_) = nil error for width 33, want error")
+	}
+	if _, err := ParseClasslessStaticRoutes(b[:len(b)-1]); err == nil {
+		t.Error("got ParseClasslessStaticRoutes(_) = nil error for truncated option, want error")
+	}
+}
+
+func TestParseDomainSearch(t *testing.T) {
+	// The example from RFC 3397 section 2, which uses compression pointers.
+	b := []byte{
+		3, 'e', 'n', 'g', 5, 'a', 'p', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+		9, 'm', 'a', 'r', 'k', 'e', 't', 'i', 'n', 'g', 0xc0, 0x04,
+	}
+	got, err := ParseDomainSearch(b)
+	if err != nil {
+		t.Fatalf("ParseDomainSearch(_): %s", err)
+	}
+	want := []string{"eng.apple.com", "marketing.apple.com"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("domains mismatch (-want +got):\n%s", diff)
+	}
+
+	got, err = ParseDomainSearch(MarshalDomainSearch(want))
+	if err != nil {
+		t.Fatalf("ParseDomainSearch(MarshalDomainSearch(_)): %s", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("domains mismatch after round trip (-want +got):\n%s", diff)
+	}
+
+	// Pointers must point backwards.
+	if _, err := ParseDomainSearch([]byte{0xc0, 0x00}); err == nil {
+		t.Error("got ParseDomainSearch(_) = nil error for looping pointer, want error")
+	}
+}
+
+func TestLeaseRoutes(t *testing.T) {
+	const nicID = 1
+
+	subnet := tcpip.AddressWithPrefix{Address: testAddr2, PrefixLen: 24}
+	staticDest := tcpip.AddressWithPrefix{Address: "\x0a\x00\x00\x00", PrefixLen: 8}.Subnet()
+	for _, test := range []struct {
+		name  string
+		lease Lease
+		want  []tcpip.Route
+	}{
+		{
+			name:  "No lease",
+			lease: Lease{},
+		},
+		{
+			name:  "Router",
+			lease: Lease{Address: subnet, Routers: []tcpip.Address{testAddr1}},
+			want: []tcpip.Route{
+				{Destination: subnet.Subnet(), NIC: nicID},
+				{Destination: header.IPv4EmptySubnet, Gateway: testAddr1, NIC: nicID},
+			},
+		},
+		{
+			name: "Classless static routes override router",
+			lease: Lease{
+				Address: subnet,
+				Routers: []tcpip.Address{testAddr1},
+				ClasslessStaticRoutes: []ClasslessStaticRoute{
+					{Destination: staticDest, Router: testAddr1},
+					{Destination: header.IPv4EmptySubnet, Router: header.IPv4Any},
+				},
+			},
+			want: []tcpip.Route{
+				{Destination: subnet.Subnet(), NIC: nicID},
+				{Destination: staticDest, Gateway: testAddr1, NIC: nicID},
+				{Destination: header.IPv4EmptySubnet, NIC: nicID},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := cmp.Diff(test.want, test.lease.Routes(nicID), cmp.AllowUnexported(tcpip.Subnet{})); diff != "" {
+				t.Errorf("routes mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,584 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcpv4
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	// DefaultLeaseTime is the default duration of the leases granted by a
+	// Server.
+	DefaultLeaseTime = 12 * time.Hour
+
+	// offerHoldTime is the duration an offered address is reserved for the
+	// client it was offered to.
+	offerHoldTime = time.Minute
+)
+
+// Reservation binds an address to a client.
+type Reservation struct {
+	// ClientHardwareAddress is the link address of the client.
+	ClientHardwareAddress tcpip.LinkAddress
+
+	// Address is the address always offered to the client. It does not have to
+	// be in the server's pool.
+	Address tcpip.Address
+}
+
+// ServerLease is a lease granted by a Server.
+type ServerLease struct {
+	// ClientID is the identifier the client sent in the Client Identifier
+	// option, if any.
+	ClientID []byte
+
+	// ClientHardwareAddress is the link address of the client.
+	ClientHardwareAddress tcpip.LinkAddress
+
+	// Address is the leased address.
+	Address tcpip.Address
+
+	// Expiry is the time at which the lease expires, as reported by the stack's
+	// clock.
+	Expiry time.Time
+}
+
+// ServerConfig holds the configuration for a Server.
+type ServerConfig struct {
+	// Address is the server's address and the subnet addresses are leased from.
+	// It must be assigned to the server's NIC.
+	Address tcpip.AddressWithPrefix
+
+	// PoolStart and PoolEnd delimit the range of addresses leased to clients.
+	//
+	// If empty, all the addresses of the subnet except the network, broadcast
+	// and server addresses are leased.
+	PoolStart tcpip.Address
+	PoolEnd   tcpip.Address
+
+	// Reservations are the addresses reserved for specific clients.
+	Reservations []Reservation
+
+	// LeaseTime is the duration of granted leases. If zero, DefaultLeaseTime
+	// is used.
+	LeaseTime time.Duration
+
+	// Routers, DNSServers, DomainName, DomainSearchList, NTPServers and MTU are
+	// advertised to clients when not empty.
+	Routers          []tcpip.Address
+	DNSServers       []tcpip.Address
+	DomainName       string
+	DomainSearchList []string
+	NTPServers       []tcpip.Address
+	MTU              uint16
+
+	// ClasslessStaticRoutes are advertised to clients through the Classless
+	// Static Route option when not empty.
+	ClasslessStaticRoutes []ClasslessStaticRoute
+
+	// Leases are the leases granted by a previous instance of the server, e.g.
+	// loaded from persistent storage. Expired leases are ignored.
+	Leases []ServerLease
+
+	// OnLease is called whenever a lease is granted or extended, and with a
+	// zero Expiry when a lease is released or declined. Integrators may use it
+	// to persist leases.
+	//
+	// OnLease is called from the goroutine running Server.Run and may call into
+	// the stack but not into the Server.
+	OnLease func(ServerLease)
+}
+
+// binding is the state the server holds for an address.
+type binding struct {
+	lease ServerLease
+
+	// offered is true if the address was offered to the client but not yet
+	// requested.
+	offered bool
+
+	// declined is true if a client reported the address to be in use.
+	declined bool
+}
+
+// Server is a DHCP server for a single NIC, as per RFC 2131 section 4.3.
+//
+// Relay agents are supported; clients on other subnets are leased addresses
+// from the server's subnet.
+type Server struct {
+	stack     *stack.Stack
+	nicID     tcpip.NICID
+	config    ServerConfig
+	poolStart uint32
+	poolEnd   uint32
+	leaseTime time.Duration
+	onLease   func(ServerLease)
+
+	mu struct {
+		sync.Mutex
+
+		// bindings holds the server's bindings, keyed by address.
+		bindings map[tcpip.Address]*binding
+	}
+}
+
+// NewServer creates a DHCP server for the specified NIC.
+func NewServer(s *stack.Stack, nicID tcpip.NICID, config ServerConfig) (*Server, *tcpip.Error) {
+	if _, ok := s.NICInfo()[nicID]; !ok {
+		return nil, tcpip.ErrUnknownNICID
+	}
+	if len(config.Address.Address) != header.IPv4AddressSize || config.Address.PrefixLen > 30 {
+		return nil, tcpip.ErrBadAddress
+	}
+
+	subnet := config.Address.Subnet()
+	poolStart := addrToUint32(subnet.ID()) + 1
+	poolEnd := addrToUint32(subnet.Broadcast()) - 1
+	if len(config.PoolStart) != 0 || len(config.PoolEnd) != 0 {
+		if !subnet.Contains(config.PoolStart) || !subnet.Contains(config.PoolEnd) {
+			return nil, tcpip.ErrBadAddress
+		}
+		poolStart = addrToUint32(config.PoolStart)
+		poolEnd = addrToUint32(config.PoolEnd)
+		if poolStart > poolEnd {
+			return nil, tcpip.ErrBadAddress
+		}
+	}
+
+	leaseTime := config.LeaseTime
+	if leaseTime <= 0 {
+		leaseTime = DefaultLeaseTime
+	}
+
+	srv := &Server{
+		stack:     s,
+		nicID:     nicID,
+		config:    config,
+		poolStart: poolStart,
+		poolEnd:   poolEnd,
+		leaseTime: leaseTime,
+		onLease:   config.OnLease,
+	}
+	srv.mu.bindings = make(map[tcpip.Address]*binding)
+	now := srv.now()
+	for _, l := range config.Leases {
+		if l.Expiry.After(now) {
+			srv.mu.bindings[l.Address] = &binding{lease: l}
+		}
+	}
+	return srv, nil
+}
+
+// Leases returns the active leases granted by the server.
+func (srv *Server) Leases() []ServerLease {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	now := srv.now()
+	var leases []ServerLease
+	for _, b := range srv.mu.bindings {
+		if !b.offered && !b.declined && b.lease.Expiry.After(now) {
+			leases = append(leases, b.lease)
+		}
+	}
+	return leases
+}
+
+// Run runs the server until ctx is cancelled.
+//
+// Run must not be called concurrently.
+func (srv *Server) Run(ctx context.Context) *tcpip.Error {
+	var wq waiter.Queue
+	ep, err := srv.stack.NewEndpoint(udp.ProtocolNumber, header.IPv4ProtocolNumber, &wq)
+	if err != nil {
+		return err
+	}
+	defer ep.Close()
+
+	ep.SocketOptions().SetBroadcast(true)
+	if err := ep.Bind(tcpip.FullAddress{NIC: srv.nicID, Port: ServerPort}); err != nil {
+		return err
+	}
+
+	waitEntry, notifyCh := waiter.NewChannelEntry(nil)
+	wq.EventRegister(&waitEntry, waiter.EventIn)
+	defer wq.EventUnregister(&waitEntry)
+
+	for {
+		v, _, err := ep.Read(nil)
+		if err == tcpip.ErrWouldBlock {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-notifyCh:
+				continue
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		m, parseErr := ParseMessage(v)
+		if parseErr != nil {
+			continue
+		}
+		reply, to, changed, ok := srv.handle(&m)
+		if changed != nil && srv.onLease != nil {
+			srv.onLease(*changed)
+		}
+		if !ok {
+			continue
+		}
+
+		port := uint16(ClientPort)
+		if to == m.GatewayAddress {
+			port = ServerPort
+		}
+		// Failing to send a reply is handled like a lost message; the client
+		// retransmits its message.
+		_, _, _ = ep.Write(tcpip.SlicePayload(buffer.View(reply.Marshal())), tcpip.WriteOptions{
+			To: &tcpip.FullAddress{NIC: srv.nicID, Addr: to, Port: port},
+		})
+	}
+}
+
+// handle processes a client message.
+//
+// Returns the reply and its destination if a reply should be sent, and the
+// lease that changed, if any.
+func (srv *Server) handle(m *Message) (Message, tcpip.Address, *ServerLease, bool) {
+	if m.Op != OpRequest || len(m.ClientHardwareAddress) != header.EthernetAddressSize {
+		return Message{}, "", nil, false
+	}
+	if b, ok := m.Options.Get(OptionServerID); ok && tcpip.Address(b) != srv.config.Address.Address {
+		// The client selected another server, as per RFC 2131 section 4.3.2.
+		srv.mu.Lock()
+		srv.releaseOfferLocked(m)
+		srv.mu.Unlock()
+		return Message{}, "", nil, false
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	switch m.Options.MessageType() {
+	case MessageTypeDiscover:
+		addr, ok := srv.selectAddressLocked(m)
+		if !ok {
+			return Message{}, "", nil, false
+		}
+		srv.mu.bindings[addr] = &binding{
+			lease:   srv.newLease(m, addr, offerHoldTime),
+			offered: true,
+		}
+		reply := srv.newReply(m, MessageTypeOffer, addr)
+		return reply, srv.replyDestination(m, &reply), nil, true
+
+	case MessageTypeRequest:
+		return srv.handleRequestLocked(m)
+
+	case MessageTypeDecline:
+		// As per RFC 2131 section 4.3.3, the server marks the address as not
+		// available.
+		b, ok := m.Options.Get(OptionRequestedAddress)
+		if !ok {
+			return Message{}, "", nil, false
+		}
+		addr := tcpip.Address(b)
+		bd, ok := srv.mu.bindings[addr]
+		if !ok || !srv.isClientLocked(bd, m) {
+			return Message{}, "", nil, false
+		}
+		released := bd.lease
+		released.Expiry = time.Time{}
+		srv.mu.bindings[addr] = &binding{
+			lease:    ServerLease{Address: addr, Expiry: srv.now().Add(srv.leaseTime)},
+			declined: true,
+		}
+		return Message{}, "", &released, false
+
+	case MessageTypeRelease:
+		// As per RFC 2131 section 4.3.4, the server marks the address as not
+		// allocated.
+		bd, ok := srv.mu.bindings[m.ClientAddress]
+		if !ok || bd.offered || bd.declined || !srv.isClientLocked(bd, m) {
+			return Message{}, "", nil, false
+		}
+		delete(srv.mu.bindings, m.ClientAddress)
+		released := bd.lease
+		released.Expiry = time.Time{}
+		return Message{}, "", &released, false
+
+	case MessageTypeInform:
+		// As per RFC 2131 section 4.3.5, the server sends the local
+		// configuration parameters without allocating an address.
+		if m.ClientAddress == header.IPv4Any {
+			return Message{}, "", nil, false
+		}
+		reply := srv.newReply(m, MessageTypeAck, "")
+		return reply, srv.replyDestination(m, &reply), nil, true
+
+	default:
+		return Message{}, "", nil, false
+	}
+}
+
+// handleRequestLocked processes a DHCPREQUEST message, as per RFC 2131 section
+// 4.3.2.
+//
+// Precondition: srv.mu must be locked.
+func (srv *Server) handleRequestLocked(m *Message) (Message, tcpip.Address, *ServerLease, bool) {
+	var addr tcpip.Address
+	_, selecting := m.Options.Get(OptionServerID)
+	if b, ok := m.Options.Get(OptionRequestedAddress); ok && m.ClientAddress == header.IPv4Any {
+		addr = tcpip.Address(b)
+	} else {
+		// The client is RENEWING or REBINDING.
+		addr = m.ClientAddress
+	}
+
+	subnet := srv.config.Address.Subnet()
+	bd, ok := srv.mu.bindings[addr]
+	switch {
+	case ok && !bd.declined && srv.isClientLocked(bd, m) && (!bd.offered || selecting):
+		// The client is requesting its offer or extending its lease.
+	case selecting:
+		// The client requested an address that was not offered to it.
+		return srv.nakLocked(m)
+	case !subnet.Contains(addr) && srv.reservedAddress(m.ClientHardwareAddress) != addr:
+		// The client moved to another subnet.
+		return srv.nakLocked(m)
+	case ok && !bd.offered && bd.lease.Expiry.After(srv.now()):
+		// The address is leased to another client.
+		return srv.nakLocked(m)
+	default:
+		// As per RFC 2131 section 4.3.2, the server remains silent if it has no
+		// record of the client.
+		return Message{}, "", nil, false
+	}
+
+	lease := srv.newLease(m, addr, srv.leaseTime)
+	srv.mu.bindings[addr] = &binding{lease: lease}
+	reply := srv.newReply(m, MessageTypeAck, addr)
+	return reply, srv.replyDestination(m, &reply), &lease, true
+}
+
+// nakLocked returns a DHCPNAK reply to m.
+//
+// Precondition: srv.mu must be locked.
+func (srv *Server) nakLocked(m *Message) (Message, tcpip.Address, *ServerLease, bool) {
+	reply := Message{
+		Op:                    OpReply,
+		TransactionID:         m.TransactionID,
+		GatewayAddress:        m.GatewayAddress,
+		ClientHardwareAddress: m.ClientHardwareAddress,
+		Options: Options{
+			{Code: OptionMessageType, Data: []byte{byte(MessageTypeNak)}},
+			{Code: OptionServerID, Data: MarshalAddresses(srv.config.Address.Address)},
+		},
+	}
+	// As per RFC 2131 section 4.1, DHCPNAK messages are broadcast unless they
+	// are sent through a relay agent.
+	to := header.IPv4Broadcast
+	if m.GatewayAddress != header.IPv4Any && len(m.GatewayAddress) != 0 {
+		reply.Broadcast = true
+		to = m.GatewayAddress
+	}
+	return reply, to, nil, true
+}
+
+// releaseOfferLocked releases the address offered to the client that sent m.
+//
+// Precondition: srv.mu must be locked.
+func (srv *Server) releaseOfferLocked(m *Message) {
+	for addr, bd := range srv.mu.bindings {
+		if bd.offered && srv.isClientLocked(bd, m) {
+			delete(srv.mu.bindings, addr)
+		}
+	}
+}
+
+// selectAddressLocked selects the address to offer to the client that sent a
+// DHCPDISCOVER message, as per RFC 2131 section 4.3.1.
+//
+// Precondition: srv.mu must be locked.
+func (srv *Server) selectAddressLocked(m *Message) (tcpip.Address, bool) {
+	if addr := srv.reservedAddress(m.ClientHardwareAddress); len(addr) != 0 {
+		return addr, true
+	}
+
+	// Prefer the client's current or previous address.
+	for addr, bd := range srv.mu.bindings {
+		if !bd.declined && srv.isClientLocked(bd, m) {
+			return addr, true
+		}
+	}
+
+	now := srv.now()
+	if b, ok := m.Options.Get(OptionRequestedAddress); ok && srv.isAvailableLocked(tcpip.Address(b), now) {
+		return tcpip.Address(b), true
+	}
+
+	for i := srv.poolStart; i <= srv.poolEnd; i++ {
+		if addr := uint32ToAddr(i); srv.isAvailableLocked(addr, now) {
+			return addr, true
+		}
+	}
+	return "", false
+}
+
+// isAvailableLocked returns true if addr can be leased to a new client.
+//
+// Precondition: srv.mu must be locked.
+func (srv *Server) isAvailableLocked(addr tcpip.Address, now time.Time) bool {
+	if len(addr) != header.IPv4AddressSize || addr == srv.config.Address.Address {
+		return false
+	}
+	if i := addrToUint32(addr); i < srv.poolStart || i > srv.poolEnd {
+		return false
+	}
+	for _, r := range srv.config.Reservations {
+		if r.Address == addr {
+			return false
+		}
+	}
+	bd, ok := srv.mu.bindings[addr]
+	return !ok || !bd.lease.Expiry.After(now)
+}
+
+// isClientLocked returns true if bd is bound to the client that sent m.
+//
+// Clients are identified by their Client Identifier option if they send one,
+// and by their hardware address otherwise, as per RFC 2131 section 4.2.
+//
+// Precondition: srv.mu must be locked.
+func (*Server) isClientLocked(bd *binding, m *Message) bool {
+	if clientID, ok := m.Options.Get(OptionClientID); ok {
+		return bytes.Equal(clientID, bd.lease.ClientID)
+	}
+	return len(bd.lease.ClientID) == 0 && m.ClientHardwareAddress == bd.lease.ClientHardwareAddress
+}
+
+// reservedAddress returns the address reserved for the client with the
+// specified hardware address, if any.
+func (srv *Server) reservedAddress(linkAddr tcpip.LinkAddress) tcpip.Address {
+	for _, r := range srv.config.Reservations {
+		if r.ClientHardwareAddress == linkAddr {
+			return r.Address
+		}
+	}
+	return ""
+}
+
+func (srv *Server) newLease(m *Message, addr tcpip.Address, d time.Duration) ServerLease {
+	clientID, _ := m.Options.Get(OptionClientID)
+	return ServerLease{
+		ClientID:              append([]byte(nil), clientID...),
+		ClientHardwareAddress: m.ClientHardwareAddress,
+		Address:               addr,
+		Expiry:                srv.now().Add(d),
+	}
+}
+
+// newReply returns a DHCPOFFER or DHCPACK reply to m.
+//
+// addr is the address leased to the client, or empty when replying to a
+// DHCPINFORM message.
+func (srv *Server) newReply(m *Message, typ MessageType, addr tcpip.Address) Message {
+	c := &srv.config
+	subnet := c.Address.Subnet()
+	reply := Message{
+		Op:                    OpReply,
+		TransactionID:         m.TransactionID,
+		Broadcast:             m.Broadcast,
+		ClientAddress:         m.ClientAddress,
+		YourAddress:           addr,
+		GatewayAddress:        m.GatewayAddress,
+		ClientHardwareAddress: m.ClientHardwareAddress,
+		Options: Options{
+			{Code: OptionMessageType, Data: []byte{byte(typ)}},
+			{Code: OptionServerID, Data: MarshalAddresses(c.Address.Address)},
+			{Code: OptionSubnetMask, Data: MarshalAddresses(tcpip.Address(subnet.Mask()))},
+		},
+	}
+	if len(addr) != 0 {
+		reply.Options = append(reply.Options, Option{Code: OptionLeaseTime, Data: MarshalUint32(uint32(srv.leaseTime / time.Second))})
+	}
+	if len(c.Routers) != 0 {
+		reply.Options = append(reply.Options, Option{Code: OptionRouter, Data: MarshalAddresses(c.Routers...)})
+	}
+	if len(c.ClasslessStaticRoutes) != 0 {
+		reply.Options = append(reply.Options, Option{Code: OptionClasslessStaticRoute, Data: MarshalClasslessStaticRoutes(c.ClasslessStaticRoutes)})
+	}
+	if len(c.DNSServers) != 0 {
+		reply.Options = append(reply.Options, Option{Code: OptionDNSServers, Data: MarshalAddresses(c.DNSServers...)})
+	}
+	if len(c.DomainName) != 0 {
+		reply.Options = append(reply.Options, Option{Code: OptionDomainName, Data: []byte(c.DomainName)})
+	}
+	if len(c.DomainSearchList) != 0 {
+		reply.Options = append(reply.Options, Option{Code: OptionDomainSearch, Data: MarshalDomainSearch(c.DomainSearchList)})
+	}
+	if len(c.NTPServers) != 0 {
+		reply.Options = append(reply.Options, Option{Code: OptionNTPServers, Data: MarshalAddresses(c.NTPServers...)})
+	}
+	if c.MTU != 0 {
+		reply.Options = append(reply.Options, Option{Code: OptionInterfaceMTU, Data: MarshalUint16(c.MTU)})
+	}
+	return reply
+}
+
+// replyDestination returns the destination of a reply to m, as per RFC 2131
+// section 4.1.
+//
+// The server does not unicast replies to clients without an address, as that
+// would require resolving the client's address to its hardware address before
+// the client owns it; such replies are broadcast.
+func (*Server) replyDestination(m *Message, reply *Message) tcpip.Address {
+	switch {
+	case m.GatewayAddress != header.IPv4Any && len(m.GatewayAddress) != 0:
+		return m.GatewayAddress
+	case m.ClientAddress != header.IPv4Any && len(m.ClientAddress) != 0:
+		return m.ClientAddress
+	default:
+		reply.Broadcast = true
+		return header.IPv4Broadcast
+	}
+}
+
+func (srv *Server) now() time.Time {
+	return time.Unix(0, srv.stack.Clock().NowNanoseconds())
+}
+
+func addrToUint32(addr tcpip.Address) uint32 {
+	return binary.BigEndian.Uint32([]byte(addr))
+}
+
+func uint32ToAddr(v uint32) tcpip.Address {
+	b := make([]byte, header.IPv4AddressSize)
+	binary.BigEndian.PutUint32(b, v)
+	return tcpip.Address(b)
+}
@@ -0,0 +1,369 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcpv4
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/link/pipe"
+	"gvisor.dev/gvisor/pkg/tcpip/network/arp"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+const (
+	otherLinkAddr = tcpip.LinkAddress("\x02\x02\x03\x04\x05\x08")
+
+	poolStart = tcpip.Address("\xc0\xa8\x00\x0a")
+	poolNext  = tcpip.Address("\xc0\xa8\x00\x0b")
+	poolEnd   = tcpip.Address("\xc0\xa8\x00\x0c")
+
+	reservedAddr = tcpip.Address("\xc0\xa8\x00\x64")
+	otherServer  = tcpip.Address("\xc0\xa8\x00\x02")
+)
+
+func newTestServer(t *testing.T, clock tcpip.Clock, configure func(*ServerConfig)) *Server {
+	t.Helper()
+
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		Clock:            clock,
+	})
+	if err := s.CreateNIC(nicID, channel.New(1, 1500, serverLinkAddr)); err != nil {
+		t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+	}
+	config := ServerConfig{
+		Address:    testSubnet,
+		PoolStart:  poolStart,
+		PoolEnd:    poolEnd,
+		LeaseTime:  testLeaseTime * time.Second,
+		Routers:    []tcpip.Address{testAddr1},
+		DNSServers: testDNSServers,
+	}
+	if configure != nil {
+		configure(&config)
+	}
+	srv, err := NewServer(s, nicID, config)
+	if err != nil {
+		t.Fatalf("NewServer(_, %d, _): %s", nicID, err)
+	}
+	return srv
+}
+
+func clientMessage(linkAddr tcpip.LinkAddress, typ MessageType, opts ...Option) *Message {
+	return &Message{
+		Op:                    OpRequest,
+		TransactionID:         1,
+		ClientAddress:         header.IPv4Any,
+		YourAddress:           header.IPv4Any,
+		ServerAddress:         header.IPv4Any,
+		GatewayAddress:        header.IPv4Any,
+		ClientHardwareAddress: linkAddr,
+		Options:               append(Options{{Code: OptionMessageType, Data: []byte{byte(typ)}}}, opts...),
+	}
+}
+
+func serverIDOption(addr tcpip.Address) Option {
+	return Option{Code: OptionServerID, Data: MarshalAddresses(addr)}
+}
+
+func requestedAddressOption(addr tcpip.Address) Option {
+	return Option{Code: OptionRequestedAddress, Data: MarshalAddresses(addr)}
+}
+
+// expectReply checks that the server replies to m with a message of the
+// specified type and your address.
+func expectReply(t *testing.T, srv *Server, m *Message, wantType MessageType, wantAddr tcpip.Address) Message {
+	t.Helper()
+
+	reply, to, _, ok := srv.handle(m)
+	if !ok {
+		t.Fatalf("expected %s reply to %s", wantType, m.Options.MessageType())
+	}
+	if got := reply.Options.MessageType(); got != wantType {
+		t.Fatalf("got reply type = %s, want = %s", got, wantType)
+	}
+	if reply.YourAddress != wantAddr {
+		t.Errorf("got reply.YourAddress = %s, want = %s", reply.YourAddress, wantAddr)
+	}
+	if to != header.IPv4Broadcast {
+		t.Errorf("got reply destination = %s, want = %s", to, header.IPv4Broadcast)
+	}
+	return reply
+}
+
+func expectNoReply(t *testing.T, srv *Server, m *Message) {
+	t.Helper()
+
+	if reply, _, _, ok := srv.handle(m); ok {
+		t.Fatalf("got %s reply to %s, want no reply", reply.Options.MessageType(), m.Options.MessageType())
+	}
+}
+
+// lease acquires a lease for the client with the specified link address.
+func lease(t *testing.T, srv *Server, linkAddr tcpip.LinkAddress, wantAddr tcpip.Address) {
+	t.Helper()
+
+	expectReply(t, srv, clientMessage(linkAddr, MessageTypeDiscover), MessageTypeOffer, wantAddr)
+	expectReply(t, srv, clientMessage(linkAddr, MessageTypeRequest, serverIDOption(testAddr1), requestedAddressOption(wantAddr)), MessageTypeAck, wantAddr)
+}
+
+func TestServerAllocation(t *testing.T) {
+	clock := faketime.NewManualClock()
+	srv := newTestServer(t, clock, nil)
+
+	offer := expectReply(t, srv, clientMessage(clientLinkAddr, MessageTypeDiscover), MessageTypeOffer, poolStart)
+	wantOpts := Options{
+		{Code: OptionMessageType, Data: []byte{byte(MessageTypeOffer)}},
+		serverIDOption(testAddr1),
+		{Code: OptionSubnetMask, Data: MarshalAddresses("\xff\xff\xff\x00")},
+		{Code: OptionLeaseTime, Data: MarshalUint32(testLeaseTime)},
+		{Code: OptionRouter, Data: MarshalAddresses(testAddr1)},
+		{Code: OptionDNSServers, Data: MarshalAddresses(testDNSServers...)},
+	}
+	if diff := cmp.Diff(wantOpts, offer.Options); diff != "" {
+		t.Errorf("offer options mismatch (-want +got):\n%s", diff)
+	}
+
+	// The offered address is held for the client.
+	expectReply(t, srv, clientMessage(otherLinkAddr, MessageTypeDiscover), MessageTypeOffer, poolNext)
+
+	_, _, changed, ok := srv.handle(clientMessage(clientLinkAddr, MessageTypeRequest, serverIDOption(testAddr1), requestedAddressOption(poolStart)))
+	if !ok {
+		t.Fatal("expected DHCPACK reply to DHCPREQUEST")
+	}
+	want := ServerLease{
+		ClientHardwareAddress: clientLinkAddr,
+		Address:               poolStart,
+		Expiry:                time.Unix(0, clock.NowNanoseconds()).Add(testLeaseTime * time.Second),
+	}
+	if diff := cmp.Diff(&want, changed); diff != "" {
+		t.Errorf("changed lease mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]ServerLease{want}, srv.Leases()); diff != "" {
+		t.Errorf("leases mismatch (-want +got):\n%s", diff)
+	}
+
+	// The other client selected another server, so its offer is released.
+	expectNoReply(t, srv, clientMessage(otherLinkAddr, MessageTypeRequest, serverIDOption(otherServer), requestedAddressOption(poolNext)))
+	lease(t, srv, "\x02\x02\x03\x04\x05\x09", poolNext)
+
+	// Renewing extends the lease.
+	clock.Advance(testLeaseTime * time.Second / 2)
+	renew := clientMessage(clientLinkAddr, MessageTypeRequest)
+	renew.ClientAddress = poolStart
+	reply, to, _, ok := srv.handle(renew)
+	if !ok || reply.Options.MessageType() != MessageTypeAck {
+		t.Fatal("expected DHCPACK reply to renewing DHCPREQUEST")
+	}
+	if to != poolStart {
+		t.Errorf("got renew reply destination = %s, want = %s", to, poolStart)
+	}
+
+	// Released addresses are available to other clients.
+	release := clientMessage(clientLinkAddr, MessageTypeRelease, serverIDOption(testAddr1))
+	release.ClientAddress = poolStart
+	expectNoReply(t, srv, release)
+	lease(t, srv, otherLinkAddr, poolStart)
+}
+
+func TestServerExhaustionAndExpiry(t *testing.T) {
+	clock := faketime.NewManualClock()
+	srv := newTestServer(t, clock, func(c *ServerConfig) { c.PoolEnd = poolStart })
+
+	lease(t, srv, clientLinkAddr, poolStart)
+	expectNoReply(t, srv, clientMessage(otherLinkAddr, MessageTypeDiscover))
+
+	clock.Advance(testLeaseTime * time.Second)
+	lease(t, srv, otherLinkAddr, poolStart)
+}
+
+func TestServerRequests(t *testing.T) {
+	srv := newTestServer(t, faketime.NewManualClock(), nil)
+	lease(t, srv, clientLinkAddr, poolStart)
+
+	// A client that was not offered the address is refused.
+	expectReply(t, srv, clientMessage(otherLinkAddr, MessageTypeRequest, serverIDOption(testAddr1), requestedAddressOption(poolStart)), MessageTypeNak, "")
+
+	// INIT-REBOOT for an address of another subnet is refused.
+	expectReply(t, srv, clientMessage(otherLinkAddr, MessageTypeRequest, requestedAddressOption("\x0a\x00\x00\x01")), MessageTypeNak, "")
+
+	// INIT-REBOOT for an address leased to another client is refused.
+	expectReply(t, srv, clientMessage(otherLinkAddr, MessageTypeRequest, requestedAddressOption(poolStart)), MessageTypeNak, "")
+
+	// INIT-REBOOT from an unknown client is ignored.
+	expectNoReply(t, srv, clientMessage(otherLinkAddr, MessageTypeRequest, requestedAddressOption(poolEnd)))
+
+	// INIT-REBOOT from the client holding the lease is acknowledged.
+	expectReply(t, srv, clientMessage(clientLinkAddr, MessageTypeRequest, requestedAddressOption(poolStart)), MessageTypeAck, poolStart)
+
+	// Clients are identified by their client identifier when they send one.
+	clientID := Option{Code: OptionClientID, Data: []byte("id")}
+	expectReply(t, srv, clientMessage(otherLinkAddr, MessageTypeDiscover, clientID), MessageTypeOffer, poolNext)
+	expectReply(t, srv, clientMessage(clientLinkAddr, MessageTypeRequest, clientID, serverIDOption(testAddr1), requestedAddressOption(poolNext)), MessageTypeAck, poolNext)
+
+	// DHCPINFORM is answered without allocating an address.
+	inform := clientMessage(otherLinkAddr, MessageTypeInform)
+	inform.ClientAddress = poolEnd
+	reply, to, _, ok := srv.handle(inform)
+	if !ok || reply.Options.MessageType() != MessageTypeAck {
+		t.Fatal("expected DHCPACK reply to DHCPINFORM")
+	}
+	if _, ok := reply.Options.Get(OptionLeaseTime); ok {
+		t.Error("got lease time option in reply to DHCPINFORM")
+	}
+	if to != poolEnd {
+		t.Errorf("got DHCPINFORM reply destination = %s, want = %s", to, poolEnd)
+	}
+}
+
+func TestServerDecline(t *testing.T) {
+	srv := newTestServer(t, faketime.NewManualClock(), nil)
+	lease(t, srv, clientLinkAddr, poolStart)
+
+	_, _, changed, _ := srv.handle(clientMessage(clientLinkAddr, MessageTypeDecline, serverIDOption(testAddr1), requestedAddressOption(poolStart)))
+	if changed == nil || !changed.Expiry.IsZero() {
+		t.Errorf("got changed lease = %#v, want lease with zero expiry", changed)
+	}
+	if leases := srv.Leases(); len(leases) != 0 {
+		t.Errorf("got srv.Leases() = %#v, want = []", leases)
+	}
+
+	// The declined address is not offered again.
+	expectReply(t, srv, clientMessage(clientLinkAddr, MessageTypeDiscover), MessageTypeOffer, poolNext)
+}
+
+func TestServerReservationsAndPersistence(t *testing.T) {
+	clock := faketime.NewManualClock()
+	restored := ServerLease{
+		ClientHardwareAddress: otherLinkAddr,
+		Address:               poolStart,
+		Expiry:                time.Unix(0, clock.NowNanoseconds()).Add(time.Hour),
+	}
+	expired := ServerLease{
+		ClientHardwareAddress: "\x02\x02\x03\x04\x05\x09",
+		Address:               poolNext,
+		Expiry:                time.Unix(0, clock.NowNanoseconds()),
+	}
+	srv := newTestServer(t, clock, func(c *ServerConfig) {
+		c.Reservations = []Reservation{{ClientHardwareAddress: clientLinkAddr, Address: reservedAddr}}
+		c.Leases = []ServerLease{restored, expired}
+	})
+
+	if diff := cmp.Diff([]ServerLease{restored}, srv.Leases()); diff != "" {
+		t.Errorf("leases mismatch (-want +got):\n%s", diff)
+	}
+
+	lease(t, srv, clientLinkAddr, reservedAddr)
+	lease(t, srv, otherLinkAddr, poolStart)
+	lease(t, srv, "\x02\x02\x03\x04\x05\x0a", poolNext)
+}
+
+func TestServerWithClient(t *testing.T) {
+	clientEP, serverEP := pipe.New(clientLinkAddr, serverLinkAddr)
+
+	serverStack := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+	})
+	if err := serverStack.CreateNIC(nicID, serverEP); err != nil {
+		t.Fatalf("serverStack.CreateNIC(%d, _): %s", nicID, err)
+	}
+	protocolAddr := tcpip.ProtocolAddress{Protocol: ipv4.ProtocolNumber, AddressWithPrefix: testSubnet}
+	if err := serverStack.AddProtocolAddress(nicID, protocolAddr); err != nil {
+		t.Fatalf("serverStack.AddProtocolAddress(%d, %#v): %s", nicID, protocolAddr, err)
+	}
+	serverStack.SetRouteTable([]tcpip.Route{{Destination: testSubnet.Subnet(), NIC: nicID}})
+
+	serverLeaseCh := make(chan ServerLease, 2)
+	srv, err := NewServer(serverStack, nicID, ServerConfig{
+		Address:   testSubnet,
+		PoolStart: poolStart,
+		PoolEnd:   poolEnd,
+		Routers:   []tcpip.Address{testAddr1},
+		OnLease:   func(l ServerLease) { serverLeaseCh <- l },
+	})
+	if err != nil {
+		t.Fatalf("NewServer(_, %d, _): %s", nicID, err)
+	}
+
+	clientStack := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{arp.NewProtocol, ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+	})
+	if err := clientStack.CreateNIC(nicID, clientEP); err != nil {
+		t.Fatalf("clientStack.CreateNIC(%d, _): %s", nicID, err)
+	}
+	leaseCh := make(chan Lease, 2)
+	c, err := NewClient(clientStack, nicID, Config{
+		OnLease: func(l Lease) {
+			clientStack.SetRouteTable(l.Routes(nicID))
+			leaseCh <- l
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewClient(_, %d, _): %s", nicID, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serverDone := make(chan struct{})
+	go func() {
+		defer close(serverDone)
+		if err := srv.Run(ctx); err != nil {
+			t.Errorf("srv.Run(_): %s", err)
+		}
+	}()
+	clientDone := make(chan struct{})
+	go func() {
+		defer close(clientDone)
+		if err := c.Run(ctx); err != nil {
+			t.Errorf("c.Run(_): %s", err)
+		}
+	}()
+	defer func() {
+		cancel()
+		<-clientDone
+		<-serverDone
+	}()
+
+	select {
+	case l := <-leaseCh:
+		want := tcpip.AddressWithPrefix{Address: poolStart, PrefixLen: testSubnet.PrefixLen}
+		if l.Address != want {
+			t.Errorf("got lease address = %s, want = %s", l.Address, want)
+		}
+		if l.Duration != DefaultLeaseTime {
+			t.Errorf("got lease duration = %s, want = %s", l.Duration, DefaultLeaseTime)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for client lease")
+	}
+	select {
+	case l := <-serverLeaseCh:
+		if l.Address != poolStart || l.ClientHardwareAddress != clientLinkAddr {
+			t.Errorf("got server lease = %#v, want lease of %s to %s", l, poolStart, clientLinkAddr)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for server lease")
+	}
+}
@@ -0,0 +1,775 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dhcpv6 implements a DHCPv6 client, as per RFC 8415.
+//
+// The client supports stateful address configuration through an Identity
+// Association for Non-temporary Addresses (IA_NA) and stateless configuration
+// through Information-request messages. Which mode the client runs in is
+// driven by the M and O flags of NDP Router Advertisements, as reported to the
+// integrator through ipv6.NDPDispatcher.OnDHCPv6Configuration.
+package dhcpv6
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	// ClientPort is the UDP port clients listen for messages on.
+	ClientPort = 546
+
+	// ServerPort is the UDP port servers and relay agents listen for messages
+	// on.
+	ServerPort = 547
+
+	// AllDHCPRelayAgentsAndServers is the link-scoped multicast address used by
+	// clients to communicate with neighboring relay agents and servers, as per
+	// RFC 8415 section 7.1.
+	AllDHCPRelayAgentsAndServers tcpip.Address = "\xff\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00\x02"
+
+	// DefaultMaxInitialDelay is the maximum delay before the first Solicit or
+	// Information-request message is sent, as recommended by RFC 8415 section
+	// 7.6 (SOL_MAX_DELAY and INF_MAX_DELAY).
+	DefaultMaxInitialDelay = time.Second
+)
+
+// Transmission and retransmission parameters, as per RFC 8415 section 7.6.
+var (
+	solicitParams = retransmitParams{irt: time.Second, mrt: 3600 * time.Second}
+	requestParams = retransmitParams{irt: time.Second, mrt: 30 * time.Second, mrc: 10}
+	renewParams   = retransmitParams{irt: 10 * time.Second, mrt: 600 * time.Second}
+	rebindParams  = retransmitParams{irt: 10 * time.Second, mrt: 600 * time.Second}
+	declineParams = retransmitParams{irt: time.Second, mrc: 4}
+	infoParams    = retransmitParams{irt: time.Second, mrt: 3600 * time.Second}
+)
+
+const (
+	// defaultInformationRefreshTime and minInformationRefreshTime are the
+	// default and minimum intervals between Information-request exchanges, as
+	// per RFC 8415 section 21.23 (IRT_DEFAULT and IRT_MINIMUM).
+	defaultInformationRefreshTime = 86400 * time.Second
+	minInformationRefreshTime     = 600 * time.Second
+
+	// infiniteDuration is the duration of an infinite lifetime or timer.
+	infiniteDuration = InfiniteLifetime * time.Second
+)
+
+var (
+	errNoAddress = errors.New("dhcpv6: no address assigned")
+	errFailed    = errors.New("dhcpv6: server reported failure")
+)
+
+// retransmitParams holds the parameters for retransmitting a message, as per
+// RFC 8415 section 15.
+type retransmitParams struct {
+	// irt is the initial retransmission time.
+	irt time.Duration
+
+	// mrt is the maximum retransmission time. Zero means no maximum.
+	mrt time.Duration
+
+	// mrc is the maximum retransmission count. Zero means no maximum.
+	mrc int
+}
+
+// Config holds the configuration for a Client.
+type Config struct {
+	// DUID is the client's DHCP Unique Identifier.
+	//
+	// If empty, a DUID-LL based on the NIC's link address is used.
+	DUID DUID
+
+	// IAID is the identifier of the client's IA_NA.
+	//
+	// If zero, the NIC's ID is used.
+	IAID uint32
+
+	// MaxInitialDelay is the maximum random delay before the first message of a
+	// Solicit or Information-request exchange is sent. Zero disables the delay.
+	//
+	// RFC 8415 recommends DefaultMaxInitialDelay.
+	MaxInitialDelay time.Duration
+
+	// OnLease is called whenever the client's lease changes, including when it
+	// is lost (with an empty Lease).
+	//
+	// OnLease is called from the goroutine running Client.Run and may call into
+	// the stack but not into the Client.
+	OnLease func(Lease)
+}
+
+// Lease holds the configuration obtained from a DHCPv6 server.
+type Lease struct {
+	// Address is the leased address. It is empty if the configuration was
+	// obtained through stateless DHCPv6.
+	Address tcpip.Address
+
+	// PreferredLifetime and ValidLifetime are the lifetimes of Address.
+	PreferredLifetime time.Duration
+	ValidLifetime     time.Duration
+
+	// T1 and T2 are the durations after which the client contacts the server
+	// it obtained the lease from (Renew) or any server (Rebind) to extend the
+	// lifetimes of Address.
+	T1 time.Duration
+	T2 time.Duration
+
+	// ServerID is the DUID of the server the lease was obtained from.
+	ServerID DUID
+
+	// DNSServers are the recursive DNS servers advertised by the server.
+	DNSServers []tcpip.Address
+
+	// DomainSearchList is the DNS search list advertised by the server.
+	DomainSearchList []string
+}
+
+// Client is a DHCPv6 client for a single NIC.
+type Client struct {
+	stack           *stack.Stack
+	nicID           tcpip.NICID
+	duid            DUID
+	iaid            uint32
+	maxInitialDelay time.Duration
+	onLease         func(Lease)
+
+	// configCh holds the latest DHCPv6 configuration reported by NDP that has
+	// not yet been handled.
+	configCh chan ipv6.DHCPv6ConfigurationFromNDPRA
+
+	mu struct {
+		sync.Mutex
+
+		lease Lease
+	}
+}
+
+// NewClient creates a DHCPv6 client for the specified NIC.
+func NewClient(s *stack.Stack, nicID tcpip.NICID, config Config) (*Client, *tcpip.Error) {
+	info, ok := s.NICInfo()[nicID]
+	if !ok {
+		return nil, tcpip.ErrUnknownNICID
+	}
+
+	duid := config.DUID
+	if len(duid) == 0 {
+		if len(info.LinkAddress) == 0 {
+			return nil, tcpip.ErrNotSupported
+		}
+		duid = NewDUIDLL(info.LinkAddress)
+	}
+
+	iaid := config.IAID
+	if iaid == 0 {
+		iaid = uint32(nicID)
+	}
+
+	return &Client{
+		stack:           s,
+		nicID:           nicID,
+		duid:            duid,
+		iaid:            iaid,
+		maxInitialDelay: config.MaxInitialDelay,
+		onLease:         config.OnLease,
+		configCh:        make(chan ipv6.DHCPv6ConfigurationFromNDPRA, 1),
+	}, nil
+}
+
+// HandleNDPConfiguration informs the client of the DHCPv6 configuration
+// available on the link, as learned from NDP Router Advertisements.
+//
+// HandleNDPConfiguration does not block and does not call into the stack, so
+// it may be called from ipv6.NDPDispatcher.OnDHCPv6Configuration.
+//
+// Once addresses are available (ipv6.DHCPv6ManagedAddress), the client keeps
+// maintaining its lease until Run returns.
+func (c *Client) HandleNDPConfiguration(config ipv6.DHCPv6ConfigurationFromNDPRA) {
+	for {
+		select {
+		case c.configCh <- config:
+			return
+		default:
+		}
+
+		// Replace the configuration that has not been handled yet.
+		select {
+		case <-c.configCh:
+		default:
+		}
+	}
+}
+
+// Lease returns the client's current lease.
+func (c *Client) Lease() Lease {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mu.lease
+}
+
+func (c *Client) setLease(l Lease) {
+	c.mu.Lock()
+	c.mu.lease = l
+	c.mu.Unlock()
+
+	if c.onLease != nil {
+		c.onLease(l)
+	}
+}
+
+// Run runs the client until ctx is cancelled.
+//
+// When Run returns, the client releases its lease and removes the leased
+// address from the NIC. Run must not be called concurrently.
+func (c *Client) Run(ctx context.Context) *tcpip.Error {
+	var wq waiter.Queue
+	ep, err := c.stack.NewEndpoint(udp.ProtocolNumber, ipv6.ProtocolNumber, &wq)
+	if err != nil {
+		return err
+	}
+	defer ep.Close()
+
+	// DHCPv6 is only used over IPv6; don't let the wildcard bind claim the
+	// IPv4 port.
+	ep.SocketOptions().SetV6Only(true)
+	if err := ep.Bind(tcpip.FullAddress{NIC: c.nicID, Port: ClientPort}); err != nil {
+		return err
+	}
+
+	cn := conn{ep: ep, wq: &wq}
+	config := ipv6.DHCPv6NoConfiguration
+	for ctx.Err() == nil {
+		switch config {
+		case ipv6.DHCPv6ManagedAddress:
+			c.runStateful(ctx, &cn)
+		case ipv6.DHCPv6OtherConfigurations:
+			config = c.runStateless(ctx, &cn)
+		default:
+			select {
+			case <-ctx.Done():
+			case config = <-c.configCh:
+			}
+		}
+	}
+
+	return nil
+}
+
+// runStateful obtains and maintains a lease for an address until ctx is
+// cancelled.
+func (c *Client) runStateful(ctx context.Context, cn *conn) {
+	for ctx.Err() == nil {
+		lease, ok := c.acquire(ctx, cn)
+		if !ok {
+			continue
+		}
+		if !c.assign(ctx, cn, lease) {
+			continue
+		}
+		c.keep(ctx, cn, lease)
+	}
+}
+
+// runStateless obtains configuration information through Information-request
+// exchanges until ctx is cancelled or the configuration available on the link
+// changes.
+//
+// Returns the new configuration.
+func (c *Client) runStateless(ctx context.Context, cn *conn) ipv6.DHCPv6ConfigurationFromNDPRA {
+	for {
+		if !c.initialDelay(ctx) {
+			return ipv6.DHCPv6NoConfiguration
+		}
+
+		reply, ok := c.exchange(ctx, cn, MessageTypeInformationRequest, Options{
+			c.clientIDOption(),
+			c.oroOption(OptionInformationRefreshTime),
+		}, infoParams, nil /* stop */, c.acceptReply)
+		if !ok {
+			return ipv6.DHCPv6NoConfiguration
+		}
+
+		var lease Lease
+		parseConfiguration(&lease, reply.Options)
+		c.setLease(lease)
+
+		refresh := defaultInformationRefreshTime
+		if b, ok := reply.Options.Get(OptionInformationRefreshTime); ok && len(b) == 4 {
+			refresh = time.Duration(binary.BigEndian.Uint32(b)) * time.Second
+			if refresh < minInformationRefreshTime {
+				refresh = minInformationRefreshTime
+			}
+		}
+
+		refreshC, stop := tcpip.ChanAfter(c.stack.Clock(), refresh)
+		select {
+		case <-ctx.Done():
+			stop()
+			return ipv6.DHCPv6NoConfiguration
+		case config := <-c.configCh:
+			stop()
+			if config != ipv6.DHCPv6OtherConfigurations {
+				return config
+			}
+		case <-refreshC:
+		}
+	}
+}
+
+// acquire obtains a lease through a Solicit/Advertise/Request/Reply exchange,
+// as per RFC 8415 section 18.2.1.
+func (c *Client) acquire(ctx context.Context, cn *conn) (Lease, bool) {
+	if !c.initialDelay(ctx) {
+		return Lease{}, false
+	}
+
+	adv, ok := c.exchange(ctx, cn, MessageTypeSolicit, Options{
+		c.clientIDOption(),
+		c.ianaOption(""),
+		c.oroOption(),
+	}, solicitParams, nil /* stop */, func(m *Message) bool {
+		if m.Type != MessageTypeAdvertise || !c.isForUs(m) {
+			return false
+		}
+		// As per RFC 8415 section 18.2.9, the client MUST ignore any Advertise
+		// message that contains no addresses.
+		_, err := c.leaseFromReply(m)
+		return err == nil
+	})
+	if !ok {
+		return Lease{}, false
+	}
+
+	offer, _ := c.leaseFromReply(&adv)
+	reply, ok := c.exchange(ctx, cn, MessageTypeRequest, Options{
+		c.clientIDOption(),
+		{Code: OptionServerID, Data: offer.ServerID},
+		c.ianaOption(offer.Address),
+		c.oroOption(),
+	}, requestParams, nil /* stop */, c.acceptReply)
+	if !ok {
+		return Lease{}, false
+	}
+
+	lease, err := c.leaseFromReply(&reply)
+	if err != nil {
+		// Avoid flooding the link if the server keeps refusing our requests.
+		waitC, stop := tcpip.ChanAfter(c.stack.Clock(), solicitParams.irt)
+		defer stop()
+		select {
+		case <-ctx.Done():
+		case <-waitC:
+		}
+		return Lease{}, false
+	}
+	return lease, true
+}
+
+// assign adds the leased address to the NIC and waits for duplicate address
+// detection to complete.
+//
+// If the address is found to be a duplicate, the client declines it, as per
+// RFC 8415 section 18.2.8.
+func (c *Client) assign(ctx context.Context, cn *conn, lease Lease) bool {
+	ch := make(chan stack.DADResult, 1)
+	if err := c.stack.AddProtocolAddressWithDADHandler(c.nicID, c.protocolAddress(lease.Address), stack.CanBePrimaryEndpoint, func(r stack.DADResult) {
+		ch <- r
+	}); err != nil {
+		return false
+	}
+
+	select {
+	case <-ctx.Done():
+		_ = c.stack.RemoveAddress(c.nicID, lease.Address)
+		return false
+	case r := <-ch:
+		switch r.(type) {
+		case *stack.DADSucceeded:
+			return true
+		case *stack.DADDupAddrDetected:
+			// The stack removes addresses that fail DAD.
+			_, _ = c.exchange(ctx, cn, MessageTypeDecline, Options{
+				c.clientIDOption(),
+				{Code: OptionServerID, Data: lease.ServerID},
+				c.ianaOption(lease.Address),
+			}, declineParams, nil /* stop */, c.acceptReply)
+		}
+		return false
+	}
+}
+
+// keep maintains a lease through Renew and Rebind exchanges, as per RFC 8415
+// section 18.2.4 and 18.2.5, until the lease is lost or ctx is cancelled.
+func (c *Client) keep(ctx context.Context, cn *conn, lease Lease) {
+	for {
+		t1C, stopT1 := tcpip.ChanAfter(c.stack.Clock(), lease.T1)
+		t2C, stopT2 := tcpip.ChanAfter(c.stack.Clock(), lease.T2)
+		validC, stopValid := tcpip.ChanAfter(c.stack.Clock(), lease.ValidLifetime)
+		stopAll := func() {
+			stopT1()
+			stopT2()
+			stopValid()
+		}
+
+		// The timers are armed before the lease is published so that time
+		// observed by the integrator is accounted for.
+		c.setLease(lease)
+
+		select {
+		case <-ctx.Done():
+			stopAll()
+			c.release(cn, lease)
+			c.lose(lease)
+			return
+		case <-validC:
+			stopAll()
+			c.lose(lease)
+			return
+		case <-t1C:
+		}
+
+		reply, ok := c.exchange(ctx, cn, MessageTypeRenew, Options{
+			c.clientIDOption(),
+			{Code: OptionServerID, Data: lease.ServerID},
+			c.ianaOption(lease.Address),
+			c.oroOption(),
+		}, renewParams, t2C, c.acceptReply)
+		if !ok && ctx.Err() == nil {
+			reply, ok = c.exchange(ctx, cn, MessageTypeRebind, Options{
+				c.clientIDOption(),
+				c.ianaOption(lease.Address),
+				c.oroOption(),
+			}, rebindParams, validC, c.acceptReply)
+		}
+		stopAll()
+
+		if ctx.Err() != nil {
+			c.release(cn, lease)
+			c.lose(lease)
+			return
+		}
+		if !ok {
+			c.lose(lease)
+			return
+		}
+
+		newLease, err := c.leaseFromReply(&reply)
+		if err != nil {
+			c.lose(lease)
+			return
+		}
+		if newLease.Address != lease.Address {
+			_ = c.stack.RemoveAddress(c.nicID, lease.Address)
+			if !c.assign(ctx, cn, newLease) {
+				c.setLease(Lease{})
+				return
+			}
+		}
+		lease = newLease
+	}
+}
+
+// release informs the server that the client no longer uses the leased
+// address.
+//
+// As the client is shutting down, the Release message is sent only once.
+func (c *Client) release(cn *conn, lease Lease) {
+	_ = cn.send(&Message{
+		Type:          MessageTypeRelease,
+		TransactionID: c.stack.Rand().Uint32(),
+		Options: Options{
+			c.clientIDOption(),
+			{Code: OptionServerID, Data: lease.ServerID},
+			c.ianaOption(lease.Address),
+			elapsedTimeOption(0),
+		},
+	})
+}
+
+// lose removes the leased address from the NIC and clears the lease.
+func (c *Client) lose(lease Lease) {
+	_ = c.stack.RemoveAddress(c.nicID, lease.Address)
+	c.setLease(Lease{})
+}
+
+// initialDelay waits for a random duration bounded by the configured maximum
+// initial delay.
+//
+// Returns false if ctx was cancelled.
+func (c *Client) initialDelay(ctx context.Context) bool {
+	if c.maxInitialDelay <= 0 {
+		return ctx.Err() == nil
+	}
+
+	delayC, stop := tcpip.ChanAfter(c.stack.Clock(), time.Duration(c.stack.Rand().Int63n(int64(c.maxInitialDelay))))
+	defer stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-delayC:
+		return true
+	}
+}
+
+// exchange sends a message and retransmits it as per RFC 8415 section 15 until
+// a reply accepted by accept is received.
+//
+// The exchange is abandoned when the retransmission count is exhausted, stop
+// is closed or ctx is cancelled.
+func (c *Client) exchange(ctx context.Context, cn *conn, typ MessageType, opts Options, params retransmitParams, stop <-chan struct{}, accept func(*Message) bool) (Message, bool) {
+	clock := c.stack.Clock()
+	start := clock.NowMonotonic()
+	txID := c.stack.Rand().Uint32() & 0xffffff
+
+	var rt time.Duration
+	for count := 0; params.mrc == 0 || count < params.mrc; count++ {
+		select {
+		case <-stop:
+			return Message{}, false
+		default:
+		}
+
+		elapsed := time.Duration(clock.NowMonotonic() - start)
+		msg := Message{
+			Type:          typ,
+			TransactionID: txID,
+			Options:       append(Options{elapsedTimeOption(elapsed)}, opts...),
+		}
+		// Failing to send a message is handled like a lost message.
+		_ = cn.send(&msg)
+
+		rt = c.nextRetransmitTime(rt, params, count == 0 && typ == MessageTypeSolicit)
+		timeoutC, stopTimeout := tcpip.ChanAfter(c.stack.Clock(), rt)
+		reply, ok := cn.receive(ctx, timeoutC, stop, func(m *Message) bool {
+			return m.TransactionID == txID && accept(m)
+		})
+		stopTimeout()
+		if ok {
+			return reply, true
+		}
+		if ctx.Err() != nil {
+			return Message{}, false
+		}
+	}
+
+	return Message{}, false
+}
+
+// nextRetransmitTime returns the retransmission timeout following prev, as per
+// RFC 8415 section 15.
+//
+// If positive is true, the randomization factor is forced to be positive as
+// required for the first Solicit message.
+func (c *Client) nextRetransmitTime(prev time.Duration, params retransmitParams, positive bool) time.Duration {
+	rand := c.stack.Rand().Float64()*0.2 - 0.1
+	if positive {
+		rand = math.Abs(rand)
+	}
+
+	var rt time.Duration
+	if prev == 0 {
+		rt = params.irt + time.Duration(rand*float64(params.irt))
+	} else {
+		rt = 2*prev + time.Duration(rand*float64(prev))
+	}
+	if params.mrt != 0 && rt > params.mrt {
+		rt = params.mrt + time.Duration(rand*float64(params.mrt))
+	}
+	return rt
+}
+
+// isForUs returns true if m is a server message addressed to the client.
+func (c *Client) isForUs(m *Message) bool {
+	if _, ok := m.Options.Get(OptionServerID); !ok {
+		return false
+	}
+	clientID, ok := m.Options.Get(OptionClientID)
+	return ok && bytes.Equal(clientID, c.duid)
+}
+
+func (c *Client) acceptReply(m *Message) bool {
+	return m.Type == MessageTypeReply && c.isForUs(m)
+}
+
+// leaseFromReply returns the lease held in a Reply or Advertise message.
+func (c *Client) leaseFromReply(m *Message) (Lease, error) {
+	if statusOf(m.Options) != StatusSuccess {
+		return Lease{}, errFailed
+	}
+	serverID, _ := m.Options.Get(OptionServerID)
+
+	for _, b := range m.Options.GetAll(OptionIANA) {
+		ia, err := ParseIANA(b)
+		if err != nil || ia.IAID != c.iaid || statusOf(ia.Options) != StatusSuccess {
+			continue
+		}
+
+		for _, b := range ia.Options.GetAll(OptionIAAddr) {
+			addr, err := ParseIAAddress(b)
+			if err != nil || addr.ValidLifetime == 0 || addr.PreferredLifetime > addr.ValidLifetime || !header.IsV6UnicastAddress(addr.Address) {
+				continue
+			}
+
+			lease := Lease{
+				Address:           addr.Address,
+				PreferredLifetime: time.Duration(addr.PreferredLifetime) * time.Second,
+				ValidLifetime:     time.Duration(addr.ValidLifetime) * time.Second,
+				T1:                time.Duration(ia.T1) * time.Second,
+				T2:                time.Duration(ia.T2) * time.Second,
+				ServerID:          DUID(serverID),
+			}
+			// As per RFC 8415 section 18.2.4, the client chooses T1 and T2 if the
+			// server left them to the client's discretion or they are invalid.
+			if ia.T1 == 0 || ia.T2 == 0 || ia.T1 > ia.T2 {
+				lease.T1 = lease.PreferredLifetime / 2
+				lease.T2 = lease.PreferredLifetime * 4 / 5
+				if addr.PreferredLifetime == InfiniteLifetime {
+					lease.T1 = infiniteDuration
+					lease.T2 = infiniteDuration
+				}
+			}
+			parseConfiguration(&lease, m.Options)
+			return lease, nil
+		}
+	}
+
+	return Lease{}, errNoAddress
+}
+
+// parseConfiguration populates the other configurations of lease from opts.
+func parseConfiguration(lease *Lease, opts Options) {
+	if b, ok := opts.Get(OptionDNSServers); ok {
+		if addrs, err := ParseAddresses(b); err == nil {
+			lease.DNSServers = addrs
+		}
+	}
+	if b, ok := opts.Get(OptionDomainList); ok {
+		if domains, err := ParseDomainList(b); err == nil {
+			lease.DomainSearchList = domains
+		}
+	}
+}
+
+func (c *Client) protocolAddress(addr tcpip.Address) tcpip.ProtocolAddress {
+	return tcpip.ProtocolAddress{
+		Protocol: ipv6.ProtocolNumber,
+		AddressWithPrefix: tcpip.AddressWithPrefix{
+			Address:   addr,
+			PrefixLen: 8 * header.IPv6AddressSize,
+		},
+	}
+}
+
+func (c *Client) clientIDOption() Option {
+	return Option{Code: OptionClientID, Data: c.duid}
+}
+
+// ianaOption returns an IA_NA option holding addr, or no address if addr is
+// empty.
+func (c *Client) ianaOption(addr tcpip.Address) Option {
+	ia := IANA{IAID: c.iaid}
+	if len(addr) != 0 {
+		iaAddr := IAAddress{Address: addr}
+		ia.Options = Options{{Code: OptionIAAddr, Data: iaAddr.Marshal()}}
+	}
+	return Option{Code: OptionIANA, Data: ia.Marshal()}
+}
+
+// oroOption returns an Option Request option for the other configurations
+// supported by the client and the extra codes.
+func (*Client) oroOption(extra ...OptionCode) Option {
+	codes := append([]OptionCode{OptionDNSServers, OptionDomainList}, extra...)
+	b := make([]byte, 2*len(codes))
+	for i, code := range codes {
+		binary.BigEndian.PutUint16(b[2*i:], uint16(code))
+	}
+	return Option{Code: OptionORO, Data: b}
+}
+
+// elapsedTimeOption returns an Elapsed Time option, expressed in hundredths of
+// a second as per RFC 8415 section 21.9.
+func elapsedTimeOption(elapsed time.Duration) Option {
+	v := elapsed / (10 * time.Millisecond)
+	if v > math.MaxUint16 {
+		v = math.MaxUint16
+	}
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, uint16(v))
+	return Option{Code: OptionElapsedTime, Data: b}
+}
+
+// conn is the client's UDP endpoint.
+type conn struct {
+	ep tcpip.Endpoint
+	wq *waiter.Queue
+}
+
+// send sends a message to all DHCPv6 relay agents and servers on the link.
+func (cn *conn) send(m *Message) *tcpip.Error {
+	v := buffer.View(m.Marshal())
+	_, _, err := cn.ep.Write(tcpip.SlicePayload(v), tcpip.WriteOptions{
+		To: &tcpip.FullAddress{Addr: AllDHCPRelayAgentsAndServers, Port: ServerPort},
+	})
+	return err
+}
+
+// receive waits for a message accepted by accept.
+//
+// Returns false if timeoutC or stop is closed or ctx is cancelled before such
+// a message is received.
+func (cn *conn) receive(ctx context.Context, timeoutC, stop <-chan struct{}, accept func(*Message) bool) (Message, bool) {
+	waitEntry, notifyCh := waiter.NewChannelEntry(nil)
+	cn.wq.EventRegister(&waitEntry, waiter.EventIn)
+	defer cn.wq.EventUnregister(&waitEntry)
+
+	for {
+		v, _, err := cn.ep.Read(nil)
+		if err == tcpip.ErrWouldBlock {
+			select {
+			case <-ctx.Done():
+				return Message{}, false
+			case <-timeoutC:
+				return Message{}, false
+			case <-stop:
+				return Message{}, false
+			case <-notifyCh:
+				continue
+			}
+		}
+		if err != nil {
+			return Message{}, false
+		}
+
+		m, parseErr := ParseMessage(v)
+		if parseErr != nil {
+			continue
+		}
+		if accept(&m) {
+			return m, true
+		}
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcpv6
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/pipe"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+const (
+	nicID = 1
+
+	clientLinkAddr = tcpip.LinkAddress("\x02\x02\x03\x04\x05\x06")
+	serverLinkAddr = tcpip.LinkAddress("\x02\x02\x03\x04\x05\x07")
+
+	testTimeout = 5 * time.Second
+)
+
+var testDNSServers = []tcpip.Address{testAddr2}
+
+// testServer is a minimal DHCPv6 server that hands out a single address.
+type testServer struct {
+	conn     *gonet.UDPConn
+	duid     DUID
+	addr     tcpip.Address
+	t1, t2   uint32
+	received chan MessageType
+}
+
+func (s *testServer) serve() {
+	buf := make([]byte, header.IPv6MinimumMTU)
+	for {
+		n, from, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		m, err := ParseMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		s.received <- m.Type
+
+		clientID, _ := m.Options.Get(OptionClientID)
+		reply := Message{
+			Type:          MessageTypeReply,
+			TransactionID: m.TransactionID,
+			Options: Options{
+				{Code: OptionClientID, Data: clientID},
+				{Code: OptionServerID, Data: s.duid},
+				{Code: OptionDNSServers, Data: MarshalAddresses(testDNSServers)},
+			},
+		}
+		switch m.Type {
+		case MessageTypeSolicit:
+			reply.Type = MessageTypeAdvertise
+			fallthrough
+		case MessageTypeRequest, MessageTypeRenew, MessageTypeRebind:
+			b, _ := m.Options.Get(OptionIANA)
+			reqIA, err := ParseIANA(b)
+			if err != nil {
+				continue
+			}
+			iaAddr := IAAddress{
+				Address:           s.addr,
+				PreferredLifetime: 2 * s.t2,
+				ValidLifetime:     3 * s.t2,
+			}
+			ia := IANA{
+				IAID:    reqIA.IAID,
+				T1:      s.t1,
+				T2:      s.t2,
+				Options: Options{{Code: OptionIAAddr, Data: iaAddr.Marshal()}},
+			}
+			reply.Options = append(reply.Options, Option{Code: OptionIANA, Data: ia.Marshal()})
+		case MessageTypeInformationRequest:
+		default:
+			// Release and Decline only need to be acknowledged.
+		}
+
+		if _, err := s.conn.WriteTo(reply.Marshal(), from); err != nil {
+			return
+		}
+	}
+}
+
+func (s *testServer) expect(t *testing.T, want MessageType) {
+	t.Helper()
+
+	select {
+	case got := <-s.received:
+		if got != want {
+			t.Fatalf("got server received message type = %s, want = %s", got, want)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("timed out waiting for %s message", want)
+	}
+}
+
+func newStack(t *testing.T, clock tcpip.Clock, ep stack.LinkEndpoint, linkAddr tcpip.LinkAddress) *stack.Stack {
+	t.Helper()
+
+	ndpConfigs := ipv6.DefaultNDPConfigurations()
+	ndpConfigs.DupAddrDetectTransmits = 0
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv6.NewProtocolWithOptions(ipv6.Options{
+			NDPConfigs: ndpConfigs,
+		}), ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+		Clock:              clock,
+	})
+	if err := s.CreateNIC(nicID, ep); err != nil {
+		t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+	}
+	llAddr := header.LinkLocalAddr(linkAddr)
+	if err := s.AddAddress(nicID, ipv6.ProtocolNumber, llAddr); err != nil {
+		t.Fatalf("s.AddAddress(%d, %d, %s): %s", nicID, ipv6.ProtocolNumber, llAddr, err)
+	}
+	return s
+}
+
+func newTestServer(t *testing.T, s *stack.Stack) *testServer {
+	t.Helper()
+
+	if err := s.JoinGroup(ipv6.ProtocolNumber, nicID, AllDHCPRelayAgentsAndServers); err != nil {
+		t.Fatalf("s.JoinGroup(%d, %d, %s): %s", ipv6.ProtocolNumber, nicID, AllDHCPRelayAgentsAndServers, err)
+	}
+	conn, err := gonet.DialUDP(s, &tcpip.FullAddress{NIC: nicID, Port: ServerPort}, nil, ipv6.ProtocolNumber)
+	if err != nil {
+		t.Fatalf("gonet.DialUDP(_, _, nil, %d): %s", ipv6.ProtocolNumber, err)
+	}
+	srv := &testServer{
+		conn:     conn,
+		duid:     NewDUIDLL(serverLinkAddr),
+		addr:     testAddr1,
+		t1:       100,
+		t2:       160,
+		received: make(chan MessageType, 10),
+	}
+	go srv.serve()
+	t.Cleanup(func() { conn.Close() })
+	return srv
+}
+
+func expectLease(t *testing.T, ch <-chan Lease, want Lease) {
+	t.Helper()
+
+	select {
+	case got := <-ch:
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("lease mismatch (-want +got):\n%s", diff)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for lease")
+	}
+}
+
+func TestClientStateful(t *testing.T) {
+	clock := faketime.NewManualClock()
+	clientEP, serverEP := pipe.New(clientLinkAddr, serverLinkAddr)
+	clientStack := newStack(t, clock, clientEP, clientLinkAddr)
+	serverStack := newStack(t, nil, serverEP, serverLinkAddr)
+	srv := newTestServer(t, serverStack)
+
+	leaseCh := make(chan Lease, 1)
+	c, err := NewClient(clientStack, nicID, Config{
+		OnLease: func(l Lease) { leaseCh <- l },
+	})
+	if err != nil {
+		t.Fatalf("NewClient(_, %d, _): %s", nicID, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := c.Run(ctx); err != nil {
+			t.Errorf("c.Run(_): %s", err)
+		}
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	c.HandleNDPConfiguration(ipv6.DHCPv6ManagedAddress)
+	srv.expect(t, MessageTypeSolicit)
+	srv.expect(t, MessageTypeRequest)
+
+	wantLease := Lease{
+		Address:           testAddr1,
+		PreferredLifetime: 320 * time.Second,
+		ValidLifetime:     480 * time.Second,
+		T1:                100 * time.Second,
+		T2:                160 * time.Second,
+		ServerID:          srv.duid,
+		DNSServers:        testDNSServers,
+	}
+	expectLease(t, leaseCh, wantLease)
+	if got := clientStack.CheckLocalAddress(nicID, ipv6.ProtocolNumber, testAddr1); got != nicID {
+		t.Errorf("got clientStack.CheckLocalAddress(%d, %d, %s) = %d, want = %d", nicID, ipv6.ProtocolNumber, testAddr1, got, nicID)
+	}
+
+	// The lease should be renewed with the server at T1.
+	clock.Advance(wantLease.T1)
+	srv.expect(t, MessageTypeRenew)
+	expectLease(t, leaseCh, wantLease)
+
+	// The lease should be released when the client stops.
+	cancel()
+	srv.expect(t, MessageTypeRelease)
+	expectLease(t, leaseCh, Lease{})
+	<-done
+	if got := clientStack.CheckLocalAddress(nicID, ipv6.ProtocolNumber, testAddr1); got != 0 {
+		t.Errorf("got clientStack.CheckLocalAddress(%d, %d, %s) = %d, want = 0", nicID, ipv6.ProtocolNumber, testAddr1, got)
+	}
+}
+
+func TestClientStateless(t *testing.T) {
+	clientEP, serverEP := pipe.New(clientLinkAddr, serverLinkAddr)
+	clientStack := newStack(t, faketime.NewManualClock(), clientEP, clientLinkAddr)
+	serverStack := newStack(t, nil, serverEP, serverLinkAddr)
+	srv := newTestServer(t, serverStack)
+
+	leaseCh := make(chan Lease, 1)
+	c, err := NewClient(clientStack, nicID, Config{
+		OnLease: func(l Lease) { leaseCh <- l },
+	})
+	if err != nil {
+		t.Fatalf("NewClient(_, %d, _): %s", nicID, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := c.Run(ctx); err != nil {
+			t.Errorf("c.Run(_): %s", err)
+		}
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	c.HandleNDPConfiguration(ipv6.DHCPv6OtherConfigurations)
+	srv.expect(t, MessageTypeInformationRequest)
+	expectLease(t, leaseCh, Lease{DNSServers: testDNSServers})
+}
+
+func TestNewClientUnknownNIC(t *testing.T) {
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv6.NewProtocol},
+	})
+	if _, err := NewClient(s, nicID, Config{}); err != tcpip.ErrUnknownNICID {
+		t.Errorf("got NewClient(_, %d, _) = (_, %v), want = (_, %s)", nicID, err, tcpip.ErrUnknownNICID)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcpv6
+
+import (
+	"encoding/binary"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// DUIDType is the type of a DHCP Unique Identifier, as per RFC 8415 section
+// 11.1.
+type DUIDType uint16
+
+// DUID types.
+const (
+	DUIDTypeLLT  DUIDType = 1
+	DUIDTypeEN   DUIDType = 2
+	DUIDTypeLL   DUIDType = 3
+	DUIDTypeUUID DUIDType = 4
+)
+
+// duidLLTEpoch is the base time for the time field of a DUID-LLT, as per RFC
+// 8415 section 11.2.
+var duidLLTEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// DUID is a DHCP Unique Identifier, as per RFC 8415 section 11.
+//
+// A client's DUID is expected to be stable across restarts; integrators that
+// want a DUID-LLT or DUID-UUID should persist it and provide it to the client.
+type DUID []byte
+
+// Type returns the type of the DUID.
+//
+// Returns 0 if the DUID is too short to hold a type.
+func (d DUID) Type() DUIDType {
+	if len(d) < 2 {
+		return 0
+	}
+	return DUIDType(binary.BigEndian.Uint16(d))
+}
+
+// NewDUIDLL returns a DUID based on a link-layer address (DUID-LL), as per RFC
+// 8415 section 11.4.
+func NewDUIDLL(linkAddr tcpip.LinkAddress) DUID {
+	d := make(DUID, 4+len(linkAddr))
+	binary.BigEndian.PutUint16(d, uint16(DUIDTypeLL))
+	binary.BigEndian.PutUint16(d[2:], uint16(header.ARPHardwareEther))
+	copy(d[4:], linkAddr)
+	return d
+}
+
+// NewDUIDLLT returns a DUID based on a link-layer address plus time
+// (DUID-LLT), as per RFC 8415 section 11.2.
+func NewDUIDLLT(linkAddr tcpip.LinkAddress, t time.Time) DUID {
+	d := make(DUID, 8+len(linkAddr))
+	binary.BigEndian.PutUint16(d, uint16(DUIDTypeLLT))
+	binary.BigEndian.PutUint16(d[2:], uint16(header.ARPHardwareEther))
+	binary.BigEndian.PutUint32(d[4:], uint32(t.Sub(duidLLTEpoch)/time.Second))
+	copy(d[8:], linkAddr)
+	return d
+}
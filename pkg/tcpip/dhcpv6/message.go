@@ -0,0 +1,396 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcpv6
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// MessageType is a DHCPv6 message type, as per RFC 8415 section 7.3.
+type MessageType uint8
+
+// DHCPv6 message types.
+const (
+	MessageTypeSolicit            MessageType = 1
+	MessageTypeAdvertise          MessageType = 2
+	MessageTypeRequest            MessageType = 3
+	MessageTypeConfirm            MessageType = 4
+	MessageTypeRenew              MessageType = 5
+	MessageTypeRebind             MessageType = 6
+	MessageTypeReply              MessageType = 7
+	MessageTypeRelease            MessageType = 8
+	MessageTypeDecline            MessageType = 9
+	MessageTypeInformationRequest MessageType = 11
+)
+
+// String implements fmt.Stringer.
+func (t MessageType) String() string {
+	switch t {
+	case MessageTypeSolicit:
+		return "Solicit"
+	case MessageTypeAdvertise:
+		return "Advertise"
+	case MessageTypeRequest:
+		return "Request"
+	case MessageTypeConfirm:
+		return "Confirm"
+	case MessageTypeRenew:
+		return "Renew"
+	case MessageTypeRebind:
+		return "Rebind"
+	case MessageTypeReply:
+		return "Reply"
+	case MessageTypeRelease:
+		return "Release"
+	case MessageTypeDecline:
+		return "Decline"
+	case MessageTypeInformationRequest:
+		return "Information-request"
+	default:
+		return fmt.Sprintf("MessageType(%d)", t)
+	}
+}
+
+// OptionCode is a DHCPv6 option code, as per RFC 8415 section 21.
+type OptionCode uint16
+
+// DHCPv6 option codes.
+const (
+	OptionClientID               OptionCode = 1
+	OptionServerID               OptionCode = 2
+	OptionIANA                   OptionCode = 3
+	OptionIAAddr                 OptionCode = 5
+	OptionORO                    OptionCode = 6
+	OptionElapsedTime            OptionCode = 8
+	OptionStatusCode             OptionCode = 13
+	OptionRapidCommit            OptionCode = 14
+	OptionDNSServers             OptionCode = 23
+	OptionDomainList             OptionCode = 24
+	OptionInformationRefreshTime OptionCode = 32
+)
+
+// StatusCode is a DHCPv6 status code, as per RFC 8415 section 21.13.
+type StatusCode uint16
+
+// DHCPv6 status codes.
+const (
+	StatusSuccess      StatusCode = 0
+	StatusUnspecFail   StatusCode = 1
+	StatusNoAddrsAvail StatusCode = 2
+	StatusNoBinding    StatusCode = 3
+	StatusNotOnLink    StatusCode = 4
+	StatusUseMulticast StatusCode = 5
+)
+
+// InfiniteLifetime is the value used to represent an infinite lifetime or
+// timer in DHCPv6 options, as per RFC 8415 section 7.7.
+const InfiniteLifetime = 0xffffffff
+
+const (
+	messageHeaderSize = 4
+	optionHeaderSize  = 4
+	ianaHeaderSize    = 12
+	iaAddrHeaderSize  = header.IPv6AddressSize + 8
+	statusCodeMinSize = 2
+)
+
+var (
+	errMessageTruncated = errors.New("dhcpv6: message truncated")
+	errOptionTruncated  = errors.New("dhcpv6: option truncated")
+)
+
+// Option is a DHCPv6 option.
+type Option struct {
+	Code OptionCode
+	Data []byte
+}
+
+// Options is a list of DHCPv6 options.
+type Options []Option
+
+// Get returns the data of the first option with the specified code.
+func (o Options) Get(code OptionCode) ([]byte, bool) {
+	for _, opt := range o {
+		if opt.Code == code {
+			return opt.Data, true
+		}
+	}
+	return nil, false
+}
+
+// GetAll returns the data of all options with the specified code.
+func (o Options) GetAll(code OptionCode) [][]byte {
+	var ret [][]byte
+	for _, opt := range o {
+		if opt.Code == code {
+			ret = append(ret, opt.Data)
+		}
+	}
+	return ret
+}
+
+func (o Options) size() int {
+	l := 0
+	for _, opt := range o {
+		l += optionHeaderSize + len(opt.Data)
+	}
+	return l
+}
+
+func (o Options) marshal(b []byte) int {
+	i := 0
+	for _, opt := range o {
+		binary.BigEndian.PutUint16(b[i:], uint16(opt.Code))
+		binary.BigEndian.PutUint16(b[i+2:], uint16(len(opt.Data)))
+		i += optionHeaderSize
+		i += copy(b[i:], opt.Data)
+	}
+	return i
+}
+
+// Marshal returns the wire representation of the options.
+func (o Options) Marshal() []byte {
+	b := make([]byte, o.size())
+	o.marshal(b)
+	return b
+}
+
+// ParseOptions parses a sequence of DHCPv6 options.
+func ParseOptions(b []byte) (Options, error) {
+	var opts Options
+	for len(b) != 0 {
+		if len(b) < optionHeaderSize {
+			return nil, errOptionTruncated
+		}
+		code := OptionCode(binary.BigEndian.Uint16(b))
+		l := int(binary.BigEndian.Uint16(b[2:]))
+		b = b[optionHeaderSize:]
+		if len(b) < l {
+			return nil, errOptionTruncated
+		}
+		opts = append(opts, Option{Code: code, Data: b[:l:l]})
+		b = b[l:]
+	}
+	return opts, nil
+}
+
+// Message is a DHCPv6 client/server message, as per RFC 8415 section 8.
+type Message struct {
+	Type MessageType
+
+	// TransactionID is the 24-bit transaction ID of the message.
+	TransactionID uint32
+
+	Options Options
+}
+
+// Marshal returns the wire representation of the message.
+func (m *Message) Marshal() []byte {
+	b := make([]byte, messageHeaderSize+m.Options.size())
+	binary.BigEndian.PutUint32(b, m.TransactionID&0xffffff)
+	b[0] = byte(m.Type)
+	m.Options.marshal(b[messageHeaderSize:])
+	return b
+}
+
+// ParseMessage parses a DHCPv6 client/server message.
+func ParseMessage(b []byte) (Message, error) {
+	if len(b) < messageHeaderSize {
+		return Message{}, errMessageTruncated
+	}
+	opts, err := ParseOptions(b[messageHeaderSize:])
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{
+		Type:          MessageType(b[0]),
+		TransactionID: binary.BigEndian.Uint32(b) & 0xffffff,
+		Options:       opts,
+	}, nil
+}
+
+// IANA is an Identity Association for Non-temporary Addresses option, as per
+// RFC 8415 section 21.4.
+type IANA struct {
+	IAID uint32
+
+	// T1 and T2 are in seconds.
+	T1 uint32
+	T2 uint32
+
+	Options Options
+}
+
+// Marshal returns the option data of the IA_NA option.
+func (ia *IANA) Marshal() []byte {
+	b := make([]byte, ianaHeaderSize+ia.Options.size())
+	binary.BigEndian.PutUint32(b, ia.IAID)
+	binary.BigEndian.PutUint32(b[4:], ia.T1)
+	binary.BigEndian.PutUint32(b[8:], ia.T2)
+	ia.Options.marshal(b[ianaHeaderSize:])
+	return b
+}
+
+// ParseIANA parses the option data of an IA_NA option.
+func ParseIANA(b []byte) (IANA, error) {
+	if len(b) < ianaHeaderSize {
+		return IANA{}, errOptionTruncated
+	}
+	opts, err := ParseOptions(b[ianaHeaderSize:])
+	if err != nil {
+		return IANA{}, err
+	}
+	return IANA{
+		IAID:    binary.BigEndian.Uint32(b),
+		T1:      binary.BigEndian.Uint32(b[4:]),
+		T2:      binary.BigEndian.Uint32(b[8:]),
+		Options: opts,
+	}, nil
+}
+
+// IAAddress is an IA Address option, as per RFC 8415 section 21.6.
+type IAAddress struct {
+	Address tcpip.Address
+
+	// PreferredLifetime and ValidLifetime are in seconds.
+	PreferredLifetime uint32
+	ValidLifetime     uint32
+
+	Options Options
+}
+
+// Marshal returns the option data of the IA Address option.
+func (a *IAAddress) Marshal() []byte {
+	b := make([]byte, iaAddrHeaderSize+a.Options.size())
+	copy(b, a.Address)
+	binary.BigEndian.PutUint32(b[header.IPv6AddressSize:], a.PreferredLifetime)
+	binary.BigEndian.PutUint32(b[header.IPv6AddressSize+4:], a.ValidLifetime)
+	a.Options.marshal(b[iaAddrHeaderSize:])
+	return b
+}
+
+// ParseIAAddress parses the option data of an IA Address option.
+func ParseIAAddress(b []byte) (IAAddress, error) {
+	if len(b) < iaAddrHeaderSize {
+		return IAAddress{}, errOptionTruncated
+	}
+	opts, err := ParseOptions(b[iaAddrHeaderSize:])
+	if err != nil {
+		return IAAddress{}, err
+	}
+	return IAAddress{
+		Address:           tcpip.Address(b[:header.IPv6AddressSize]),
+		PreferredLifetime: binary.BigEndian.Uint32(b[header.IPv6AddressSize:]),
+		ValidLifetime:     binary.BigEndian.Uint32(b[header.IPv6AddressSize+4:]),
+		Options:           opts,
+	}, nil
+}
+
+// MarshalStatusCode returns the option data of a Status Code option.
+func MarshalStatusCode(code StatusCode, msg string) []byte {
+	b := make([]byte, statusCodeMinSize+len(msg))
+	binary.BigEndian.PutUint16(b, uint16(code))
+	copy(b[statusCodeMinSize:], msg)
+	return b
+}
+
+// ParseStatusCode parses the option data of a Status Code option.
+func ParseStatusCode(b []byte) (StatusCode, string, error) {
+	if len(b) < statusCodeMinSize {
+		return 0, "", errOptionTruncated
+	}
+	return StatusCode(binary.BigEndian.Uint16(b)), string(b[statusCodeMinSize:]), nil
+}
+
+// statusOf returns the status code carried in opts. As per RFC 8415 section
+// 21.13, the absence of a Status Code option implies success.
+func statusOf(opts Options) StatusCode {
+	b, ok := opts.Get(OptionStatusCode)
+	if !ok {
+		return StatusSuccess
+	}
+	code, _, err := ParseStatusCode(b)
+	if err != nil {
+		return StatusUnspecFail
+	}
+	return code
+}
+
+// MarshalAddresses returns the option data of an option holding a list of IPv6
+// addresses, such as the DNS Recursive Name Server option.
+func MarshalAddresses(addrs []tcpip.Address) []byte {
+	b := make([]byte, 0, len(addrs)*header.IPv6AddressSize)
+	for _, a := range addrs {
+		b = append(b, a...)
+	}
+	return b
+}
+
+// ParseAddresses parses the option data of an option holding a list of IPv6
+// addresses.
+func ParseAddresses(b []byte) ([]tcpip.Address, error) {
+	if len(b)%header.IPv6AddressSize != 0 {
+		return nil, errOptionTruncated
+	}
+	addrs := make([]tcpip.Address, 0, len(b)/header.IPv6AddressSize)
+	for ; len(b) != 0; b = b[header.IPv6AddressSize:] {
+		addrs = append(addrs, tcpip.Address(b[:header.IPv6AddressSize]))
+	}
+	return addrs, nil
+}
+
+// MarshalDomainList returns the option data of a Domain Search List option,
+// encoding the domains as uncompressed DNS names (RFC 1035 section 3.1).
+func MarshalDomainList(domains []string) []byte {
+	var b []byte
+	for _, d := range domains {
+		for _, label := range strings.Split(strings.TrimSuffix(d, "."), ".") {
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+		b = append(b, 0)
+	}
+	return b
+}
+
+// ParseDomainList parses the option data of a Domain Search List option.
+func ParseDomainList(b []byte) ([]string, error) {
+	var domains []string
+	var labels []string
+	for len(b) != 0 {
+		l := int(b[0])
+		b = b[1:]
+		if l == 0 {
+			domains = append(domains, strings.Join(labels, "."))
+			labels = nil
+			continue
+		}
+		if len(b) < l {
+			return nil, errOptionTruncated
+		}
+		labels = append(labels, string(b[:l]))
+		b = b[l:]
+	}
+	if len(labels) != 0 {
+		return nil, errOptionTruncated
+	}
+	return domains, nil
+}
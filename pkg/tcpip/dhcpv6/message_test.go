@@ -0,0 +1,145 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcpv6
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+const (
+	testAddr1 = tcpip.Address("\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01")
+	testAddr2 = tcpip.Address("\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02")
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	iaAddr := IAAddress{
+		Address:           testAddr1,
+		PreferredLifetime: 100,
+		ValidLifetime:     200,
+	}
+	ia := IANA{
+		IAID: 7,
+		T1:   50,
+		T2:   80,
+		Options: Options{
+			{Code: OptionIAAddr, Data: iaAddr.Marshal()},
+			{Code: OptionStatusCode, Data: MarshalStatusCode(StatusSuccess, "ok")},
+		},
+	}
+	msg := Message{
+		Type:          MessageTypeReply,
+		TransactionID: 0xabcdef,
+		Options: Options{
+			{Code: OptionClientID, Data: NewDUIDLL("\x02\x03\x04\x05\x06\x07")},
+			{Code: OptionIANA, Data: ia.Marshal()},
+			{Code: OptionDNSServers, Data: MarshalAddresses([]tcpip.Address{testAddr1, testAddr2})},
+			{Code: OptionDomainList, Data: MarshalDomainList([]string{"example.com", "corp.example.org."})},
+		},
+	}
+
+	got, err := ParseMessage(msg.Marshal())
+	if err != nil {
+		t.Fatalf("ParseMessage(_): %s", err)
+	}
+	if got.Type != msg.Type || got.TransactionID != msg.TransactionID {
+		t.Errorf("got (Type, TransactionID) = (%s, %x), want = (%s, %x)", got.Type, got.TransactionID, msg.Type, msg.TransactionID)
+	}
+
+	b, ok := got.Options.Get(OptionIANA)
+	if !ok {
+		t.Fatal("expected IA_NA option")
+	}
+	gotIA, err := ParseIANA(b)
+	if err != nil {
+		t.Fatalf("ParseIANA(_): %s", err)
+	}
+	if gotIA.IAID != ia.IAID || gotIA.T1 != ia.T1 || gotIA.T2 != ia.T2 {
+		t.Errorf("got IA_NA = %+v, want = %+v", gotIA, ia)
+	}
+	if got := statusOf(gotIA.Options); got != StatusSuccess {
+		t.Errorf("got statusOf(_) = %d, want = %d", got, StatusSuccess)
+	}
+	b, ok = gotIA.Options.Get(OptionIAAddr)
+	if !ok {
+		t.Fatal("expected IA Address option")
+	}
+	gotIAAddr, err := ParseIAAddress(b)
+	if err != nil {
+		t.Fatalf("ParseIAAddress(_): %s", err)
+	}
+	if diff := cmp.Diff(iaAddr, gotIAAddr); diff != "" {
+		t.Errorf("IA Address mismatch (-want +got):\n%s", diff)
+	}
+
+	b, _ = got.Options.Get(OptionDNSServers)
+	addrs, err := ParseAddresses(b)
+	if err != nil {
+		t.Fatalf("ParseAddresses(_): %s", err)
+	}
+	if diff := cmp.Diff([]tcpip.Address{testAddr1, testAddr2}, addrs); diff != "" {
+		t.Errorf("DNS servers mismatch (-want +got):\n%s", diff)
+	}
+
+	b, _ = got.Options.Get(OptionDomainList)
+	domains, err := ParseDomainList(b)
+	if err != nil {
+		t.Fatalf("ParseDomainList(_): %s", err)
+	}
+	if diff := cmp.Diff([]string{"example.com", "corp.example.org"}, domains); diff != "" {
+		t.Errorf("domain list mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseMessageTruncated(t *testing.T) {
+	msg := Message{
+		Type:          MessageTypeSolicit,
+		TransactionID: 1,
+		Options:       Options{{Code: OptionClientID, Data: []byte{1, 2, 3, 4}}},
+	}
+	b := msg.Marshal()
+	for i := 0; i < len(b); i++ {
+		if i == messageHeaderSize {
+			// A message without options is valid.
+			continue
+		}
+		if _, err := ParseMessage(b[:i]); err == nil {
+			t.Errorf("got ParseMessage(b[:%d]) = (_, nil), want error", i)
+		}
+	}
+}
+
+func TestDUID(t *testing.T) {
+	const linkAddr = tcpip.LinkAddress("\x02\x03\x04\x05\x06\x07")
+
+	ll := NewDUIDLL(linkAddr)
+	if got := ll.Type(); got != DUIDTypeLL {
+		t.Errorf("got NewDUIDLL(_).Type() = %d, want = %d", got, DUIDTypeLL)
+	}
+	if diff := cmp.Diff(DUID("\x00\x03\x00\x01"+string(linkAddr)), ll); diff != "" {
+		t.Errorf("DUID-LL mismatch (-want +got):\n%s", diff)
+	}
+
+	llt := NewDUIDLLT(linkAddr, duidLLTEpoch.Add(0x01020304*time.Second))
+	if got := llt.Type(); got != DUIDTypeLLT {
+		t.Errorf("got NewDUIDLLT(_, _).Type() = %d, want = %d", got, DUIDTypeLLT)
+	}
+	if diff := cmp.Diff(DUID("\x00\x01\x00\x01\x01\x02\x03\x04"+string(linkAddr)), llt); diff != "" {
+		t.Errorf("DUID-LLT mismatch (-want +got):\n%s", diff)
+	}
+}
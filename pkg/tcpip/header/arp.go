@@ -41,6 +41,10 @@ const (
 	// https://www.iana.org/assignments/arp-parameters/arp-parameters.xhtml#arp-parameters-2
 	ARPHardwareEther    ARPHardwareType = 1
 	ARPHardwareLoopback ARPHardwareType = 2
+	ARPHardwareSIT      ARPHardwareType = 3
+	ARPHardwareIPGRE    ARPHardwareType = 4
+	ARPHardwareIP6GRE   ARPHardwareType = 5
+	ARPHardwareTunnel   ARPHardwareType = 6
 )
 
 // ARPOp is an ARP opcode.
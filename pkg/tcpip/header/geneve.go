@@ -0,0 +1,127 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header
+
+import (
+	"encoding/binary"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+const (
+	geneveVersionOptLen = 0
+	geneveFlags         = 1
+	geneveProtocolType  = 2
+	geneveVNI           = 4
+
+	geneveVersionShift = 6
+	geneveOptLenMask   = 0x3f
+	geneveVNIShift     = 8
+)
+
+// GENEVE header flags, as per RFC 8926 section 3.4.
+const (
+	GENEVEFlagOAM      = 1 << 7
+	GENEVEFlagCritical = 1 << 6
+)
+
+const (
+	// GENEVEMinimumSize is the size of a GENEVE header without options.
+	GENEVEMinimumSize = 8
+
+	// GENEVEPort is the UDP port assigned to GENEVE by IANA.
+	GENEVEPort = 6081
+
+	// GENEVEMaximumVNI is the largest GENEVE virtual network identifier.
+	GENEVEMaximumVNI = 1<<24 - 1
+
+	// TransparentEthernetBridgingProtocolNumber is the EtherType of
+	// encapsulated ethernet frames.
+	TransparentEthernetBridgingProtocolNumber tcpip.NetworkProtocolNumber = 0x6558
+)
+
+// GENEVEFields contains the fields of a GENEVE header without options. It is
+// used to describe the fields of a packet that needs to be encoded.
+type GENEVEFields struct {
+	// OAM indicates that the packet carries a control message.
+	OAM bool
+
+	// Critical indicates that the options of the header include critical
+	// options.
+	Critical bool
+
+	// Protocol is the "protocol type" field of the GENEVE header, which holds
+	// the EtherType of the encapsulated packet.
+	Protocol tcpip.NetworkProtocolNumber
+
+	// VNI is the "virtual network identifier" field of the GENEVE header.
+	VNI uint32
+}
+
+// GENEVE represents a Generic Network Virtualization Encapsulation header
+// stored in a byte array, as per RFC 8926.
+type GENEVE []byte
+
+// Version returns the version of the GENEVE header.
+func (b GENEVE) Version() uint8 {
+	return b[geneveVersionOptLen] >> geneveVersionShift
+}
+
+// HeaderLength returns the length of the GENEVE header, including its
+// options.
+func (b GENEVE) HeaderLength() int {
+	return GENEVEMinimumSize + 4*int(b[geneveVersionOptLen]&geneveOptLenMask)
+}
+
+// Flags returns the flags of the GENEVE header.
+func (b GENEVE) Flags() uint8 {
+	return b[geneveFlags]
+}
+
+// Protocol returns the "protocol type" field of the GENEVE header.
+func (b GENEVE) Protocol() tcpip.NetworkProtocolNumber {
+	return tcpip.NetworkProtocolNumber(binary.BigEndian.Uint16(b[geneveProtocolType:]))
+}
+
+// VNI returns the "virtual network identifier" field of the GENEVE header.
+func (b GENEVE) VNI() uint32 {
+	return binary.BigEndian.Uint32(b[geneveVNI:]) >> geneveVNIShift
+}
+
+// Options returns the options of the GENEVE header.
+func (b GENEVE) Options() []byte {
+	return b[GENEVEMinimumSize:b.HeaderLength()]
+}
+
+// IsValid returns true if b holds a complete GENEVE version 0 header,
+// including its options.
+func (b GENEVE) IsValid() bool {
+	return len(b) >= GENEVEMinimumSize && b.Version() == 0 && len(b) >= b.HeaderLength()
+}
+
+// Encode encodes all the fields of a GENEVE header without options.
+func (b GENEVE) Encode(f *GENEVEFields) {
+	var flags uint8
+	if f.OAM {
+		flags |= GENEVEFlagOAM
+	}
+	if f.Critical {
+		flags |= GENEVEFlagCritical
+	}
+	b[geneveVersionOptLen] = 0
+	b[geneveFlags] = flags
+	binary.BigEndian.PutUint16(b[geneveProtocolType:], uint16(f.Protocol))
+	binary.BigEndian.PutUint32(b[geneveVNI:], f.VNI<<geneveVNIShift)
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header_test
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func TestGENEVEEncode(t *testing.T) {
+	fields := header.GENEVEFields{
+		Critical: true,
+		Protocol: header.TransparentEthernetBridgingProtocolNumber,
+		VNI:      0x123456,
+	}
+	b := header.GENEVE(make([]byte, header.GENEVEMinimumSize))
+	b.Encode(&fields)
+	if want := []byte{0x00, 0x40, 0x65, 0x58, 0x12, 0x34, 0x56, 0x00}; string(b) != string(want) {
+		t.Fatalf("got encoded GENEVE header = %x, want = %x", []byte(b), want)
+	}
+
+	if !b.IsValid() {
+		t.Fatal("got b.IsValid() = false, want = true")
+	}
+	if got := b.HeaderLength(); got != header.GENEVEMinimumSize {
+		t.Errorf("got b.HeaderLength() = %d, want = %d", got, header.GENEVEMinimumSize)
+	}
+	if got, want := b.Flags(), uint8(header.GENEVEFlagCritical); got != want {
+		t.Errorf("got b.Flags() = %#x, want = %#x", got, want)
+	}
+	if got := b.Protocol(); got != fields.Protocol {
+		t.Errorf("got b.Protocol() = %#x, want = %#x", got, fields.Protocol)
+	}
+	if got := b.VNI(); got != fields.VNI {
+		t.Errorf("got b.VNI() = %#x, want = %#x", got, fields.VNI)
+	}
+}
+
+func TestGENEVEOptions(t *testing.T) {
+	b := header.GENEVE([]byte{
+		0x01, 0x00, 0x65, 0x58, 0x00, 0x00, 0x01, 0x00,
+		0x01, 0x02, 0x03, 0x04,
+		0xff,
+	})
+	if !b.IsValid() {
+		t.Fatal("got b.IsValid() = false, want = true")
+	}
+	if got, want := b.HeaderLength(), header.GENEVEMinimumSize+4; got != want {
+		t.Errorf("got b.HeaderLength() = %d, want = %d", got, want)
+	}
+	if got, want := b.Options(), []byte{0x01, 0x02, 0x03, 0x04}; string(got) != string(want) {
+		t.Errorf("got b.Options() = %x, want = %x", got, want)
+	}
+}
+
+func TestGENEVEIsValid(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		want bool
+	}{
+		{name: "empty", b: nil, want: false},
+		{name: "valid", b: []byte{0x00, 0x00, 0x65, 0x58, 0x00, 0x00, 0x01, 0x00}, want: true},
+		{name: "version 1", b: []byte{0x40, 0x00, 0x65, 0x58, 0x00, 0x00, 0x01, 0x00}, want: false},
+		{name: "truncated options", b: []byte{0x01, 0x00, 0x65, 0x58, 0x00, 0x00, 0x01, 0x00, 0x01}, want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := header.GENEVE(test.b).IsValid(); got != test.want {
+				t.Errorf("got IsValid() = %t, want = %t", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header
+
+import (
+	"encoding/binary"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+const (
+	greFlagsVersion = 0
+	greProtocolType = 2
+	greOptional     = 4
+)
+
+// GRE header flags, as per RFC 2784 section 2 and RFC 2890 section 2.
+const (
+	GREFlagChecksum = 1 << 15
+	GREFlagRouting  = 1 << 14
+	GREFlagKey      = 1 << 13
+	GREFlagSequence = 1 << 12
+
+	greVersionMask = 0x7
+)
+
+const (
+	// GREMinimumSize is the minimum size of a valid GRE header.
+	GREMinimumSize = 4
+
+	// GREMaximumSize is the maximum size of a GRE header with the checksum,
+	// key and sequence number fields present.
+	GREMaximumSize = 16
+
+	// GREProtocolNumber is GRE's IP protocol number.
+	GREProtocolNumber tcpip.TransportProtocolNumber = 47
+)
+
+// GREFields contains the fields of a GRE header. It is used to describe the
+// fields of a packet that needs to be encoded.
+type GREFields struct {
+	// Checksum indicates that the checksum field is present. Its value is
+	// set separately with SetChecksum.
+	Checksum bool
+
+	// Key is the "key" field of the GRE header, if KeyPresent is true.
+	Key uint32
+
+	// KeyPresent indicates that the key field is present.
+	KeyPresent bool
+
+	// Sequence is the "sequence number" field of the GRE header, if
+	// SequencePresent is true.
+	Sequence uint32
+
+	// SequencePresent indicates that the sequence number field is present.
+	SequencePresent bool
+
+	// Protocol is the "protocol type" field of the GRE header, which holds the
+	// EtherType of the encapsulated packet.
+	Protocol tcpip.NetworkProtocolNumber
+}
+
+// GRE represents a Generic Routing Encapsulation header stored in a byte
+// array, as per RFC 2784 and RFC 2890.
+type GRE []byte
+
+// GRESize returns the size of a GRE header with the fields described by f.
+func GRESize(f *GREFields) int {
+	size := GREMinimumSize
+	if f.Checksum {
+		size += 4
+	}
+	if f.KeyPresent {
+		size += 4
+	}
+	if f.SequencePresent {
+		size += 4
+	}
+	return size
+}
+
+// Flags returns the flags of the GRE header.
+func (b GRE) Flags() uint16 {
+	return binary.BigEndian.Uint16(b[greFlagsVersion:]) &^ greVersionMask
+}
+
+// Version returns the version of the GRE header.
+func (b GRE) Version() uint8 {
+	return uint8(binary.BigEndian.Uint16(b[greFlagsVersion:]) & greVersionMask)
+}
+
+// Protocol returns the "protocol type" field of the GRE header.
+func (b GRE) Protocol() tcpip.NetworkProtocolNumber {
+	return tcpip.NetworkProtocolNumber(binary.BigEndian.Uint16(b[greProtocolType:]))
+}
+
+// HeaderLength returns the length of the GRE header, including its optional
+// fields.
+func (b GRE) HeaderLength() int {
+	flags := b.Flags()
+	return GRESize(&GREFields{
+		Checksum:        flags&GREFlagChecksum != 0,
+		KeyPresent:      flags&GREFlagKey != 0,
+		SequencePresent: flags&GREFlagSequence != 0,
+	})
+}
+
+// IsValid returns true if b holds a complete GRE version 0 header without
+// the deprecated routing field.
+func (b GRE) IsValid() bool {
+	if len(b) < GREMinimumSize {
+		return false
+	}
+	return b.Version() == 0 && b.Flags()&GREFlagRouting == 0 && len(b) >= b.HeaderLength()
+}
+
+// Checksum returns the "checksum" field of the GRE header.
+//
+// Precondition: the checksum field must be present.
+func (b GRE) Checksum() uint16 {
+	return binary.BigEndian.Uint16(b[greOptional:])
+}
+
+// SetChecksum sets the "checksum" field of the GRE header.
+//
+// Precondition: the checksum field must be present.
+func (b GRE) SetChecksum(checksum uint16) {
+	binary.BigEndian.PutUint16(b[greOptional:], checksum)
+}
+
+// Key returns the "key" field of the GRE header.
+//
+// Precondition: the key field must be present.
+func (b GRE) Key() uint32 {
+	return binary.BigEndian.Uint32(b[b.keyOffset():])
+}
+
+// Sequence returns the "sequence number" field of the GRE header.
+//
+// Precondition: the sequence number field must be present.
+func (b GRE) Sequence() uint32 {
+	off := b.keyOffset()
+	if b.Flags()&GREFlagKey != 0 {
+		off += 4
+	}
+	return binary.BigEndian.Uint32(b[off:])
+}
+
+func (b GRE) keyOffset() int {
+	if b.Flags()&GREFlagChecksum != 0 {
+		return greOptional + 4
+	}
+	return greOptional
+}
+
+// Encode encodes all the fields of the GRE header. The checksum field, if
+// present, is set to zero.
+func (b GRE) Encode(f *GREFields) {
+	var flags uint16
+	off := greOptional
+	if f.Checksum {
+		flags |= GREFlagChecksum
+		binary.BigEndian.PutUint32(b[off:], 0)
+		off += 4
+	}
+	if f.KeyPresent {
+		flags |= GREFlagKey
+		binary.BigEndian.PutUint32(b[off:], f.Key)
+		off += 4
+	}
+	if f.SequencePresent {
+		flags |= GREFlagSequence
+		binary.BigEndian.PutUint32(b[off:], f.Sequence)
+	}
+	binary.BigEndian.PutUint16(b[greFlagsVersion:], flags)
+	binary.BigEndian.PutUint16(b[greProtocolType:], uint16(f.Protocol))
+}
@@ -0,0 +1,117 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header_test
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func TestGREEncode(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields header.GREFields
+		want   []byte
+	}{
+		{
+			name:   "no optional fields",
+			fields: header.GREFields{Protocol: header.IPv4ProtocolNumber},
+			want:   []byte{0x00, 0x00, 0x08, 0x00},
+		},
+		{
+			name: "key",
+			fields: header.GREFields{
+				KeyPresent: true,
+				Key:        0x01020304,
+				Protocol:   header.IPv6ProtocolNumber,
+			},
+			want: []byte{0x20, 0x00, 0x86, 0xdd, 0x01, 0x02, 0x03, 0x04},
+		},
+		{
+			name: "all optional fields",
+			fields: header.GREFields{
+				Checksum:        true,
+				KeyPresent:      true,
+				Key:             0x01020304,
+				SequencePresent: true,
+				Sequence:        0x05060708,
+				Protocol:        header.IPv4ProtocolNumber,
+			},
+			want: []byte{
+				0xb0, 0x00, 0x08, 0x00,
+				0x00, 0x00, 0x00, 0x00,
+				0x01, 0x02, 0x03, 0x04,
+				0x05, 0x06, 0x07, 0x08,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			size := header.GRESize(&test.fields)
+			if size != len(test.want) {
+				t.Fatalf("got header.GRESize(_) = %d, want = %d", size, len(test.want))
+			}
+			b := header.GRE(make([]byte, size))
+			b.Encode(&test.fields)
+			if string(b) != string(test.want) {
+				t.Fatalf("got encoded GRE header = %x, want = %x", []byte(b), test.want)
+			}
+
+			if !b.IsValid() {
+				t.Fatal("got b.IsValid() = false, want = true")
+			}
+			if got := b.HeaderLength(); got != size {
+				t.Errorf("got b.HeaderLength() = %d, want = %d", got, size)
+			}
+			if got := b.Protocol(); got != test.fields.Protocol {
+				t.Errorf("got b.Protocol() = %d, want = %d", got, test.fields.Protocol)
+			}
+			if test.fields.KeyPresent {
+				if got := b.Key(); got != test.fields.Key {
+					t.Errorf("got b.Key() = %#x, want = %#x", got, test.fields.Key)
+				}
+			}
+			if test.fields.SequencePresent {
+				if got := b.Sequence(); got != test.fields.Sequence {
+					t.Errorf("got b.Sequence() = %#x, want = %#x", got, test.fields.Sequence)
+				}
+			}
+		})
+	}
+}
+
+func TestGREIsValid(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		want bool
+	}{
+		{name: "empty", b: nil, want: false},
+		{name: "valid", b: []byte{0x00, 0x00, 0x08, 0x00}, want: true},
+		{name: "routing present", b: []byte{0x40, 0x00, 0x08, 0x00}, want: false},
+		{name: "version 1", b: []byte{0x00, 0x01, 0x88, 0x0b}, want: false},
+		{name: "truncated key", b: []byte{0x20, 0x00, 0x08, 0x00, 0x01}, want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := header.GRE(test.b).IsValid(); got != test.want {
+				t.Errorf("got IsValid() = %t, want = %t", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+const (
+	// IGMPv3QueryMinimumSize is the minimum size of a valid IGMPv3 Membership
+	// Query message in bytes, as per RFC 3376 section 4.1.
+	IGMPv3QueryMinimumSize = 12
+
+	// IGMPv3ReportMinimumSize is the minimum size of a valid IGMPv3 Membership
+	// Report message in bytes, as per RFC 3376 section 4.2.
+	IGMPv3ReportMinimumSize = 8
+
+	// IGMPv3ReportGroupAddressRecordMinimumSize is the minimum size of a Group
+	// Record in an IGMPv3 Membership Report, as per RFC 3376 section 4.2.
+	IGMPv3ReportGroupAddressRecordMinimumSize = 8
+
+	igmpv3QueryResvSQRVOffset     = 8
+	igmpv3QueryQQICOffset         = 9
+	igmpv3QueryNumberOfSourcesOff = 10
+	igmpv3QuerySourcesOffset      = 12
+
+	igmpv3ReportNumberOfRecordsOffset = 6
+	igmpv3ReportRecordsOffset         = 8
+
+	igmpv3QuerySFlagMask = 1 << 3
+	igmpv3QueryQRVMask   = 0x7
+)
+
+// IGMPv3RoutersAddress is the destination address of IGMPv3 Membership
+// Reports, as per RFC 3376 section 4.2.14.
+const IGMPv3RoutersAddress tcpip.Address = "\xe0\x00\x00\x16"
+
+// IGMPv3Query is an IGMPv3 Membership Query message, as per RFC 3376 section
+// 4.1.
+//
+// The query must be at least IGMPv3QueryMinimumSize bytes long.
+type IGMPv3Query IGMP
+
+// MaximumResponseCode returns the Max Resp Code field.
+func (b IGMPv3Query) MaximumResponseCode() uint8 {
+	return b[igmpMaxRespTimeOffset]
+}
+
+// MaximumResponseTime returns the maximum allowed time before sending a
+// responding report, decoded from the Max Resp Code field.
+func (b IGMPv3Query) MaximumResponseTime() time.Duration {
+	return IGMPv3MaximumResponseDelay(b.MaximumResponseCode())
+}
+
+// GroupAddress returns the Group Address field.
+func (b IGMPv3Query) GroupAddress() tcpip.Address {
+	return IGMP(b).GroupAddress()
+}
+
+// SuppressRouterProcessing returns the S Flag field.
+func (b IGMPv3Query) SuppressRouterProcessing() bool {
+	return b[igmpv3QueryResvSQRVOffset]&igmpv3QuerySFlagMask != 0
+}
+
+// QuerierRobustnessVariable returns the QRV field.
+func (b IGMPv3Query) QuerierRobustnessVariable() uint8 {
+	return b[igmpv3QueryResvSQRVOffset] & igmpv3QueryQRVMask
+}
+
+// QuerierQueryInterval returns the querier's query interval, decoded from the
+// QQIC field.
+func (b IGMPv3Query) QuerierQueryInterval() time.Duration {
+	return time.Duration(decodeIGMPv3FloatingPoint(b[igmpv3QueryQQICOffset])) * time.Second
+}
+
+// NumberOfSources returns the Number of Sources field.
+func (b IGMPv3Query) NumberOfSources() uint16 {
+	return binary.BigEndian.Uint16(b[igmpv3QueryNumberOfSourcesOff:])
+}
+
+// Sources returns the Source Address fields.
+//
+// Returns false if the message is too short to hold the number of sources it
+// claims to hold.
+func (b IGMPv3Query) Sources() ([]tcpip.Address, bool) {
+	n := int(b.NumberOfSources())
+	buf := b[igmpv3QuerySourcesOffset:]
+	if len(buf) < n*IPv4AddressSize {
+		return nil, false
+	}
+	sources := make([]tcpip.Address, 0, n)
+	for i := 0; i < n; i++ {
+		sources = append(sources, tcpip.Address(buf[:IPv4AddressSize]))
+		buf = buf[IPv4AddressSize:]
+	}
+	return sources, true
+}
+
+// IGMPv3MaximumResponseDelay decodes the Max Resp Code field of an IGMPv3
+// query, as per RFC 3376 section 4.1.1.
+func IGMPv3MaximumResponseDelay(code uint8) time.Duration {
+	return time.Duration(decodeIGMPv3FloatingPoint(code)) * time.Second / 10
+}
+
+// decodeIGMPv3FloatingPoint decodes the 8-bit floating point representation
+// used by the Max Resp Code and QQIC fields.
+func decodeIGMPv3FloatingPoint(code uint8) uint16 {
+	// As per RFC 3376 sections 4.1.1 and 4.1.7,
+	//
+	//   If Max Resp Code >= 128, Max Resp Code represents a floating-point
+	//   value as follows:
+	//
+	//       0 1 2 3 4 5 6 7
+	//      +-+-+-+-+-+-+-+-+
+	//      |1| exp | mant  |
+	//      +-+-+-+-+-+-+-+-+
+	//
+	//   Max Resp Time = (mant | 0x10) << (exp + 3)
+	if code < 128 {
+		return uint16(code)
+	}
+	exp := (code >> 4) & 0x7
+	mant := code & 0xf
+	return uint16(mant|0x10) << (exp + 3)
+}
+
+// IGMPv3ReportRecordType is the type of a Group Record in an IGMPv3
+// Membership Report, as per RFC 3376 section 4.2.12.
+type IGMPv3ReportRecordType uint8
+
+// Group Record types, as per RFC 3376 section 4.2.12.
+const (
+	// IGMPv3ReportRecordModeIsInclude is a Current-State Record indicating the
+	// interface has an INCLUDE filter mode for the group.
+	IGMPv3ReportRecordModeIsInclude IGMPv3ReportRecordType = 1
+
+	// IGMPv3ReportRecordModeIsExclude is a Current-State Record indicating the
+	// interface has an EXCLUDE filter mode for the group.
+	IGMPv3ReportRecordModeIsExclude IGMPv3ReportRecordType = 2
+
+	// IGMPv3ReportRecordChangeToIncludeMode is a Filter-Mode-Change Record
+	// indicating the filter mode for the group changed to INCLUDE.
+	IGMPv3ReportRecordChangeToIncludeMode IGMPv3ReportRecordType = 3
+
+	// IGMPv3ReportRecordChangeToExcludeMode is a Filter-Mode-Change Record
+	// indicating the filter mode for the group changed to EXCLUDE.
+	IGMPv3ReportRecordChangeToExcludeMode IGMPv3ReportRecordType = 4
+
+	// IGMPv3ReportRecordAllowNewSources is a Source-List-Change Record
+	// indicating the sources that should now be received.
+	IGMPv3ReportRecordAllowNewSources IGMPv3ReportRecordType = 5
+
+	// IGMPv3ReportRecordBlockOldSources is a Source-List-Change Record
+	// indicating the sources that should no longer be received.
+	IGMPv3ReportRecordBlockOldSources IGMPv3ReportRecordType = 6
+)
+
+// IGMPv3ReportGroupAddressRecordSerializer is an IGMPv3 Multicast Address
+// Record serializer.
+type IGMPv3ReportGroupAddressRecordSerializer struct {
+	RecordType   IGMPv3ReportRecordType
+	GroupAddress tcpip.Address
+	Sources      []tcpip.Address
+}
+
+// Length returns the number of bytes this serializer would occupy.
+func (s *IGMPv3ReportGroupAddressRecordSerializer) Length() int {
+	return IGMPv3ReportGroupAddressRecordMinimumSize + len(s.Sources)*IPv4AddressSize
+}
+
+func copyIPv4Address(dst []byte, src tcpip.Address) {
+	if n := copy(dst, src); n != IPv4AddressSize {
+		panic(fmt.Sprintf("got copy(...) = %d, want = %d", n, IPv4AddressSize))
+	}
+}
+
+// SerializeInto serializes the record into the buffer.
+//
+// Precondition: b must have enough space to fit the record.
+func (s *IGMPv3ReportGroupAddressRecordSerializer) SerializeInto(b []byte) {
+	b[0] = byte(s.RecordType)
+	// Auxiliary data is not used.
+	b[1] = 0
+	binary.BigEndian.PutUint16(b[2:], uint16(len(s.Sources)))
+	copyIPv4Address(b[4:], s.GroupAddress)
+	b = b[IGMPv3ReportGroupAddressRecordMinimumSize:]
+	for _, source := range s.Sources {
+		copyIPv4Address(b, source)
+		b = b[IPv4AddressSize:]
+	}
+}
+
+// IGMPv3ReportSerializer is an IGMPv3 Membership Report serializer.
+type IGMPv3ReportSerializer struct {
+	Records []IGMPv3ReportGroupAddressRecordSerializer
+}
+
+// Length returns the number of bytes this serializer would occupy.
+func (s *IGMPv3ReportSerializer) Length() int {
+	ret := IGMPv3ReportMinimumSize
+	for i := range s.Records {
+		ret += s.Records[i].Length()
+	}
+	return ret
+}
+
+// SerializeInto serializes the report into the buffer, leaving the checksum
+// field zeroed.
+//
+// Precondition: b must have enough space to fit the report.
+func (s *IGMPv3ReportSerializer) SerializeInto(b []byte) {
+	b[igmpTypeOffset] = byte(IGMPv3MembershipReport)
+	b[igmpMaxRespTimeOffset] = 0
+	binary.BigEndian.PutUint16(b[igmpChecksumOffset:], 0)
+	binary.BigEndian.PutUint16(b[igmpGroupAddressOffset:], 0)
+	binary.BigEndian.PutUint16(b[igmpv3ReportNumberOfRecordsOffset:], uint16(len(s.Records)))
+	b = b[igmpv3ReportRecordsOffset:]
+	for i := range s.Records {
+		s.Records[i].SerializeInto(b)
+		b = b[s.Records[i].Length():]
+	}
+}
+
+// IGMPv3Report is an IGMPv3 Membership Report message, as per RFC 3376
+// section 4.2.
+//
+// The report must be at least IGMPv3ReportMinimumSize bytes long.
+type IGMPv3Report IGMP
+
+// NumberOfGroupRecords returns the Number of Group Records field.
+func (b IGMPv3Report) NumberOfGroupRecords() uint16 {
+	return binary.BigEndian.Uint16(b[igmpv3ReportNumberOfRecordsOffset:])
+}
+
+// GroupRecords returns the Group Records in the report.
+//
+// Returns false if the report is malformed.
+func (b IGMPv3Report) GroupRecords() ([]IGMPv3ReportGroupAddressRecordSerializer, bool) {
+	n := int(b.NumberOfGroupRecords())
+	buf := b[igmpv3ReportRecordsOffset:]
+	records := make([]IGMPv3ReportGroupAddressRecordSerializer, 0, n)
+	for i := 0; i < n; i++ {
+		if len(buf) < IGMPv3ReportGroupAddressRecordMinimumSize {
+			return nil, false
+		}
+		auxLen := int(buf[1]) * 4
+		numSources := int(binary.BigEndian.Uint16(buf[2:]))
+		record := IGMPv3ReportGroupAddressRecordSerializer{
+			RecordType:   IGMPv3ReportRecordType(buf[0]),
+			GroupAddress: tcpip.Address(buf[4:][:IPv4AddressSize]),
+		}
+		buf = buf[IGMPv3ReportGroupAddressRecordMinimumSize:]
+		if len(buf) < numSources*IPv4AddressSize+auxLen {
+			return nil, false
+		}
+		for j := 0; j < numSources; j++ {
+			record.Sources = append(record.Sources, tcpip.Address(buf[:IPv4AddressSize]))
+			buf = buf[IPv4AddressSize:]
+		}
+		buf = buf[auxLen:]
+		records = append(records, record)
+	}
+	return records, true
+}
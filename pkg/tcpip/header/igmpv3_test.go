@@ -0,0 +1,131 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func TestIGMPv3Query(t *testing.T) {
+	b := []byte{
+		0x11,       // Type, Membership Query
+		0x8a,       // Max Resp Code (exp = 0, mant = 0xa)
+		0x00, 0x00, // Checksum
+		0xe0, 0x01, 0x02, 0x03, // Group Address
+		0x0a,       // Resv, S = 1, QRV = 2
+		0x7d,       // QQIC
+		0x00, 0x02, // Number of Sources
+		0x01, 0x02, 0x03, 0x04, // Source 1
+		0x05, 0x06, 0x07, 0x08, // Source 2
+	}
+	q := header.IGMPv3Query(b)
+
+	if got, want := q.MaximumResponseTime(), time.Duration(0x1a<<3)*time.Second/10; got != want {
+		t.Errorf("got q.MaximumResponseTime() = %s, want = %s", got, want)
+	}
+	if got, want := q.GroupAddress(), tcpip.Address("\xe0\x01\x02\x03"); got != want {
+		t.Errorf("got q.GroupAddress() = %s, want = %s", got, want)
+	}
+	if !q.SuppressRouterProcessing() {
+		t.Error("got q.SuppressRouterProcessing() = false, want = true")
+	}
+	if got, want := q.QuerierRobustnessVariable(), uint8(2); got != want {
+		t.Errorf("got q.QuerierRobustnessVariable() = %d, want = %d", got, want)
+	}
+	if got, want := q.QuerierQueryInterval(), 125*time.Second; got != want {
+		t.Errorf("got q.QuerierQueryInterval() = %s, want = %s", got, want)
+	}
+	sources, ok := q.Sources()
+	if !ok {
+		t.Fatal("got q.Sources() = (_, false), want = (_, true)")
+	}
+	if diff := cmp.Diff([]tcpip.Address{"\x01\x02\x03\x04", "\x05\x06\x07\x08"}, sources); diff != "" {
+		t.Errorf("q.Sources() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, ok := header.IGMPv3Query(b[:len(b)-1]).Sources(); ok {
+		t.Error("got Sources() on truncated query = (_, true), want = (_, false)")
+	}
+}
+
+func TestIGMPv3MaximumResponseDelay(t *testing.T) {
+	tests := []struct {
+		code uint8
+		want time.Duration
+	}{
+		{code: 0, want: 0},
+		{code: 100, want: 10 * time.Second},
+		{code: 127, want: 12700 * time.Millisecond},
+		{code: 128, want: 12800 * time.Millisecond},
+		{code: 0xff, want: time.Duration(0x1f<<10) * time.Second / 10},
+	}
+
+	for _, test := range tests {
+		if got := header.IGMPv3MaximumResponseDelay(test.code); got != test.want {
+			t.Errorf("got IGMPv3MaximumResponseDelay(%d) = %s, want = %s", test.code, got, test.want)
+		}
+	}
+}
+
+func TestIGMPv3ReportSerializer(t *testing.T) {
+	records := []header.IGMPv3ReportGroupAddressRecordSerializer{
+		{
+			RecordType:   header.IGMPv3ReportRecordModeIsExclude,
+			GroupAddress: "\xe0\x01\x02\x03",
+		},
+		{
+			RecordType:   header.IGMPv3ReportRecordAllowNewSources,
+			GroupAddress: "\xe8\x01\x02\x03",
+			Sources:      []tcpip.Address{"\x01\x02\x03\x04", "\x05\x06\x07\x08"},
+		},
+	}
+	s := header.IGMPv3ReportSerializer{Records: records}
+	b := make([]byte, s.Length())
+	s.SerializeInto(b)
+
+	want := []byte{
+		0x22,       // Type
+		0x00,       // Reserved
+		0x00, 0x00, // Checksum
+		0x00, 0x00, // Reserved
+		0x00, 0x02, // Number of Group Records
+		0x02, 0x00, 0x00, 0x00, // Record type, Aux Data Len, Number of Sources
+		0xe0, 0x01, 0x02, 0x03, // Multicast Address
+		0x05, 0x00, 0x00, 0x02, // Record type, Aux Data Len, Number of Sources
+		0xe8, 0x01, 0x02, 0x03, // Multicast Address
+		0x01, 0x02, 0x03, 0x04, // Source 1
+		0x05, 0x06, 0x07, 0x08, // Source 2
+	}
+	if diff := cmp.Diff(want, b); diff != "" {
+		t.Fatalf("serialized report mismatch (-want +got):\n%s", diff)
+	}
+
+	report := header.IGMPv3Report(b)
+	got, ok := report.GroupRecords()
+	if !ok {
+		t.Fatal("got report.GroupRecords() = (_, false), want = (_, true)")
+	}
+	if diff := cmp.Diff(records, got); diff != "" {
+		t.Errorf("report.GroupRecords() mismatch (-want +got):\n%s", diff)
+	}
+	if _, ok := header.IGMPv3Report(b[:len(b)-1]).GroupRecords(); ok {
+		t.Error("got GroupRecords() on truncated report = (_, true), want = (_, false)")
+	}
+}
@@ -179,6 +179,18 @@ const (
 	IPv4FlagDontFragment
 )
 
+// ECN codepoints, carried in the two least significant bits of the IPv4 "type
+// of service" and IPv6 "traffic class" fields, as per RFC 3168 section 5.
+const (
+	IPECNNotECT = 0
+	IPECNECT1   = 1
+	IPECNECT0   = 2
+	IPECNCE     = 3
+
+	// IPECNMask is the mask of the ECN field.
+	IPECNMask = 3
+)
+
 // IPv4EmptySubnet is the empty IPv4 subnet.
 var IPv4EmptySubnet = func() tcpip.Subnet {
 	subnet, err := tcpip.NewSubnet(IPv4Any, tcpip.AddressMask(IPv4Any))
@@ -434,6 +446,16 @@ func IsV4MulticastAddress(addr tcpip.Address) bool {
 	return (addr[0] & 0xf0) == 0xe0
 }
 
+// IsV4LinkLocalMulticastAddress determines if the provided address is an IPv4
+// link-local multicast address (belongs to 224.0.0.0/24 subnet). As per RFC
+// 5771 section 4, packets destined to such addresses must not be forwarded.
+func IsV4LinkLocalMulticastAddress(addr tcpip.Address) bool {
+	if len(addr) != IPv4AddressSize {
+		return false
+	}
+	return addr[0] == 224 && addr[1] == 0 && addr[2] == 0
+}
+
 // IsV4LoopbackAddress determines if the provided address is an IPv4 loopback
 // address (belongs to 127.0.0.0/8 subnet). See RFC 1122 section 3.2.1.3.
 func IsV4LoopbackAddress(addr tcpip.Address) bool {
@@ -443,6 +465,15 @@ func IsV4LoopbackAddress(addr tcpip.Address) bool {
 	return addr[0] == 0x7f
 }
 
+// IsV4LinkLocalAddress determines if the provided address is an IPv4
+// link-local address (belongs to 169.254.0.0/16 subnet). See RFC 3927.
+func IsV4LinkLocalAddress(addr tcpip.Address) bool {
+	if len(addr) != IPv4AddressSize {
+		return false
+	}
+	return addr[0] == 169 && addr[1] == 254
+}
+
 // ========================= Options ==========================
 
 // An IPv4OptionType can hold the valuse for the Type in an IPv4 option.
@@ -107,6 +107,10 @@ const (
 	//   or greater.  This is known as the IPv6 minimum link MTU.
 	IPv6MinimumMTU = 1280
 
+	// IPv6FlowLabelMask is the mask of the 20-bit flow label of an IPv6
+	// packet, as per RFC 8200 section 6.
+	IPv6FlowLabelMask = 0xfffff
+
 	// IPv6Loopback is the IPv6 Loopback address.
 	IPv6Loopback tcpip.Address = "\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01"
 
@@ -211,12 +215,12 @@ func (IPv6) Checksum() uint16 {
 // TOS returns the "traffic class" and "flow label" fields of the ipv6 header.
 func (b IPv6) TOS() (uint8, uint32) {
 	v := binary.BigEndian.Uint32(b[versTCFL:])
-	return uint8(v >> 20), v & 0xfffff
+	return uint8(v >> 20), v & IPv6FlowLabelMask
 }
 
 // SetTOS sets the "traffic class" and "flow label" fields of the ipv6 header.
 func (b IPv6) SetTOS(t uint8, l uint32) {
-	vtf := (6 << 28) | (uint32(t) << 20) | (l & 0xfffff)
+	vtf := (6 << 28) | (uint32(t) << 20) | (l & IPv6FlowLabelMask)
 	binary.BigEndian.PutUint32(b[versTCFL:], vtf)
 }
 
@@ -392,6 +396,13 @@ func IsV6LinkLocalMulticastAddress(addr tcpip.Address) bool {
 	return IsV6MulticastAddress(addr) && addr[ipv6MulticastAddressScopeByteIdx]&ipv6MulticastAddressScopeMask == ipv6LinkLocalMulticastScope
 }
 
+// IsV6RoutableMulticastAddress determines if the provided address is an IPv6
+// multicast address with a scope wider than link-local. As per RFC 4291
+// section 2.7, routers must not forward packets destined to narrower scopes.
+func IsV6RoutableMulticastAddress(addr tcpip.Address) bool {
+	return IsV6MulticastAddress(addr) && addr[ipv6MulticastAddressScopeByteIdx]&ipv6MulticastAddressScopeMask > ipv6LinkLocalMulticastScope
+}
+
 // IsV6UniqueLocalAddress determines if the provided address is an IPv6
 // unique-local address (within the prefix FC00::/7).
 func IsV6UniqueLocalAddress(addr tcpip.Address) bool {
@@ -497,18 +508,21 @@ func InitialTempIID(initialTempIIDHistory []byte, seed []byte, nicID tcpip.NICID
 	}
 }
 
-// GenerateTempIPv6SLAACAddr generates a temporary SLAAC IPv6 address for an
-// associated stable/permanent SLAAC address.
+// GenerateTempIPv6SLAACAddr generates a temporary SLAAC IPv6 address for a
+// SLAAC prefix.
+//
+// As per RFC 8981 section 3.3.1, the temporary IID is randomized and is not
+// derived from the IID of any stable address generated for the prefix.
 //
 // GenerateTempIPv6SLAACAddr will update the temporary IID history value to be
 // used when generating a new temporary IID.
 //
 // Panics if tempIIDHistory is not at least IIDSize bytes.
-func GenerateTempIPv6SLAACAddr(tempIIDHistory []byte, stableAddr tcpip.Address) tcpip.AddressWithPrefix {
-	addrBytes := []byte(stableAddr)
+func GenerateTempIPv6SLAACAddr(tempIIDHistory []byte, prefix tcpip.Subnet) tcpip.AddressWithPrefix {
+	addrBytes := []byte(prefix.ID())
 	h := sha256.New()
 	h.Write(tempIIDHistory)
-	h.Write(addrBytes[IIDOffsetInIPv6Address:])
+	h.Write(addrBytes[:IIDOffsetInIPv6Address])
 	var sumBuf [sha256.Size]byte
 	sum := h.Sum(sumBuf[:0])
 
@@ -18,6 +18,7 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 
@@ -143,6 +144,12 @@ func (b ipv6OptionsExtHdr) Iter() IPv6OptionsExtHdrOptionsIterator {
 	return it
 }
 
+// Length returns the length of the extension header in bytes, including the
+// Next Header and Hdr Ext Len fields.
+func (b ipv6OptionsExtHdr) Length() int {
+	return len(b) + ipv6ExtHdrLenBytesPerUnit - ipv6ExtHdrLenBytesExcluded
+}
+
 // IPv6OptionsExtHdrOptionsIterator is an iterator over IPv6 extension header
 // options.
 //
@@ -217,8 +224,54 @@ const (
 	// ipv6PadBExtHdrOptionIdentifier is the identifier for a padding option that
 	// provides variable length byte padding, as outlined in RFC 8200 section 4.2.
 	ipv6PadNExtHdrOptionIdentifier IPv6ExtHdrOptionIndentifier = 1
+
+	// ipv6RouterAlertExtHdrOptionIdentifier is the identifier for the Router
+	// Alert option, as outlined in RFC 2711 section 2.1.
+	ipv6RouterAlertExtHdrOptionIdentifier IPv6ExtHdrOptionIndentifier = 5
+
+	// ipv6RouterAlertPayloadLength is the length of the Router Alert option's
+	// data.
+	ipv6RouterAlertPayloadLength = 2
 )
 
+// ErrMalformedIPv6ExtHdrOption indicates that an IPv6 extension header option
+// is malformed.
+var ErrMalformedIPv6ExtHdrOption = errors.New("malformed IPv6 extension header option")
+
+// IPv6RouterAlertValue is the value of a Router Alert option, as per RFC 2711
+// section 2.1.
+type IPv6RouterAlertValue uint16
+
+// IPv6 Router Alert values, as registered by IANA.
+//
+// https://www.iana.org/assignments/ipv6-routeralert-values/ipv6-routeralert-values.xhtml
+const (
+	// IPv6RouterAlertMLD indicates that the datagram contains a Multicast
+	// Listener Discovery message, as per RFC 2711 section 2.1.
+	IPv6RouterAlertMLD IPv6RouterAlertValue = 0
+
+	// IPv6RouterAlertRSVP indicates that the datagram contains an RSVP message,
+	// as per RFC 2711 section 2.1.
+	IPv6RouterAlertRSVP IPv6RouterAlertValue = 1
+
+	// IPv6RouterAlertActiveNetworks indicates that the datagram contains an
+	// Active Networks message, as per RFC 2711 section 2.1.
+	IPv6RouterAlertActiveNetworks IPv6RouterAlertValue = 2
+)
+
+// IPv6RouterAlertOption is the Router Alert option, as outlined in RFC 2711.
+type IPv6RouterAlertOption struct {
+	Value IPv6RouterAlertValue
+}
+
+// UnknownAction implements IPv6OptionUnknownAction.UnknownAction.
+func (*IPv6RouterAlertOption) UnknownAction() IPv6OptionUnknownAction {
+	return IPv6OptionUnknownAction((ipv6RouterAlertExtHdrOptionIdentifier & ipv6UnknownExtHdrOptionActionMask) >> ipv6UnknownExtHdrOptionActionShift)
+}
+
+// isIPv6ExtHdrOption implements IPv6ExtHdrOption.isIPv6ExtHdrOption.
+func (*IPv6RouterAlertOption) isIPv6ExtHdrOption() {}
+
 // IPv6UnknownExtHdrOption holds the identifier and data for an IPv6 extension
 // header option that is unknown by the parsing utilities.
 type IPv6UnknownExtHdrOption struct {
@@ -236,8 +289,10 @@ func (*IPv6UnknownExtHdrOption) isIPv6ExtHdrOption() {}
 
 // Next returns the next option in the options data.
 //
-// If the next item is not a known extension header option,
-// IPv6UnknownExtHdrOption will be returned with the option identifier and data.
+// If the next item is a Router Alert option, IPv6RouterAlertOption will be
+// returned with the option's value. If the next item is not a known extension
+// header option, IPv6UnknownExtHdrOption will be returned with the option
+// identifier and data.
 //
 // The return is of the format (option, done, error). done will be true when
 // Next is unable to return anything because the iterator has reached the end of
@@ -294,6 +349,18 @@ func (i *IPv6OptionsExtHdrOptionsIterator) Next() (IPv6ExtHdrOption, bool, error
 				panic(fmt.Sprintf("error when skipping PadN (N = %d) option's data bytes: %s", length, err))
 			}
 			continue
+		case ipv6RouterAlertExtHdrOptionIdentifier:
+			if length != ipv6RouterAlertPayloadLength {
+				// Consume the option's data so that the iterator is consistently
+				// exhausted on error.
+				i.reader.Reset(nil)
+				return nil, true, fmt.Errorf("got Router Alert option data length = %d, want = %d: %w", length, ipv6RouterAlertPayloadLength, ErrMalformedIPv6ExtHdrOption)
+			}
+			var value [ipv6RouterAlertPayloadLength]byte
+			if _, err := io.ReadFull(&i.reader, value[:]); err != nil {
+				panic(fmt.Sprintf("error when reading Router Alert option's data bytes: %s", err))
+			}
+			return &IPv6RouterAlertOption{Value: IPv6RouterAlertValue(binary.BigEndian.Uint16(value[:]))}, false, nil
 		default:
 			bytes := make([]byte, length)
 			if n, err := io.ReadFull(&i.reader, bytes); err != nil {
@@ -211,6 +211,25 @@ func TestIPv6OptionsExtHdrIterErr(t *testing.T) {
 			bytes: []byte{1, 3},
 			err:   io.ErrUnexpectedEOF,
 		},
+		{
+			name:  "Router Alert",
+			bytes: []byte{5, 2, 0, 0},
+		},
+		{
+			name:  "Router Alert too short",
+			bytes: []byte{5, 1, 0},
+			err:   ErrMalformedIPv6ExtHdrOption,
+		},
+		{
+			name:  "Router Alert too long",
+			bytes: []byte{5, 3, 0, 0, 0},
+			err:   ErrMalformedIPv6ExtHdrOption,
+		},
+		{
+			name:  "Router Alert missing data",
+			bytes: []byte{5, 2, 0},
+			err:   io.ErrUnexpectedEOF,
+		},
 	}
 
 	check := func(t *testing.T, it IPv6OptionsExtHdrOptionsIterator, expectedErr error) {
@@ -337,6 +356,30 @@ func TestIPv6OptionsExtHdrIter(t *testing.T) {
 				&IPv6UnknownExtHdrOption{Identifier: 253, Data: []byte{2, 3, 4, 5}},
 			},
 		},
+		{
+			name:  "Router Alert MLD",
+			bytes: []byte{5, 2, 0, 0},
+			expected: []IPv6ExtHdrOption{
+				&IPv6RouterAlertOption{Value: IPv6RouterAlertMLD},
+			},
+		},
+		{
+			name: "Router Alert with other options",
+			bytes: []byte{
+				// Unknown
+				255, 0,
+
+				// Router Alert (RSVP)
+				5, 2, 0, 1,
+
+				// Pad2
+				1, 0,
+			},
+			expected: []IPv6ExtHdrOption{
+				&IPv6UnknownExtHdrOption{Identifier: 255, Data: []byte{}},
+				&IPv6RouterAlertOption{Value: IPv6RouterAlertRSVP},
+			},
+		},
 	}
 
 	checkIter := func(t *testing.T, it IPv6OptionsExtHdrOptionsIterator, expected []IPv6ExtHdrOption) {
@@ -384,6 +427,15 @@ func TestIPv6OptionsExtHdrIter(t *testing.T) {
 	}
 }
 
+func TestIPv6OptionsExtHdrLength(t *testing.T) {
+	for _, l := range []int{6, 14, 22} {
+		extHdr := IPv6HopByHopOptionsExtHdr{ipv6OptionsExtHdr: make([]byte, l)}
+		if got, want := extHdr.Length(), l+2; got != want {
+			t.Errorf("got Length() = %d, want = %d", got, want)
+		}
+	}
+}
+
 func TestIPv6RoutingExtHdr(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -294,6 +294,48 @@ func TestIsV6LinkLocalMulticastAddress(t *testing.T) {
 	}
 }
 
+func TestIsV6RoutableMulticastAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		addr     tcpip.Address
+		expected bool
+	}{
+		{
+			name:     "Interface Local Multicast",
+			addr:     "\xff\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01",
+			expected: false,
+		},
+		{
+			name:     "Link Local Multicast",
+			addr:     linkLocalMulticastAddr,
+			expected: false,
+		},
+		{
+			name:     "Site Local Multicast",
+			addr:     "\xff\x05\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01",
+			expected: true,
+		},
+		{
+			name:     "Global Multicast with flags",
+			addr:     "\xff\x3e\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01",
+			expected: true,
+		},
+		{
+			name:     "Global Unicast",
+			addr:     globalAddr,
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := header.IsV6RoutableMulticastAddress(test.addr); got != test.expected {
+				t.Errorf("got header.IsV6RoutableMulticastAddress(%s) = %t, want = %t", test.addr, got, test.expected)
+			}
+		})
+	}
+}
+
 func TestIsV6LinkLocalAddress(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -0,0 +1,701 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// MPTCPSubtype is the subtype of a Multipath TCP option, as per RFC 8684
+// section 3.
+type MPTCPSubtype uint8
+
+// MPTCP option subtypes.
+const (
+	MPTCPSubtypeMPCapable   MPTCPSubtype = 0
+	MPTCPSubtypeMPJoin      MPTCPSubtype = 1
+	MPTCPSubtypeDSS         MPTCPSubtype = 2
+	MPTCPSubtypeAddAddr     MPTCPSubtype = 3
+	MPTCPSubtypeRemoveAddr  MPTCPSubtype = 4
+	MPTCPSubtypeMPPrio      MPTCPSubtype = 5
+	MPTCPSubtypeMPFail      MPTCPSubtype = 6
+	MPTCPSubtypeMPFastclose MPTCPSubtype = 7
+)
+
+// MPTCPVersion is the version of MPTCP specified by RFC 8684.
+const MPTCPVersion = 1
+
+// MP_CAPABLE flags, as per RFC 8684 section 3.1.
+const (
+	MPCapableFlagChecksum      = 0x80
+	MPCapableFlagExtensibility = 0x40
+	MPCapableFlagNoJoinSource  = 0x20
+	MPCapableFlagHMACSHA256    = 0x01
+)
+
+// MPTCP option lengths.
+const (
+	MPCapableSynLength          = 4
+	MPCapableSynAckLength       = 12
+	MPCapableAckLength          = 20
+	MPCapableDataLength         = 22
+	MPCapableDataChecksumLength = 24
+
+	MPJoinSynLength    = 12
+	MPJoinSynAckLength = 16
+	MPJoinAckLength    = 24
+
+	// MPJoinHMACLength is the length of the HMAC carried in the third ACK
+	// of an MP_JOIN handshake.
+	MPJoinHMACLength = 20
+
+	MPPrioLength      = 3
+	MPFastcloseLength = 12
+
+	// MPTCPMaxDSSLength is the length of a DSS option with 8 octet data
+	// ACK and data sequence number, and a checksum.
+	MPTCPMaxDSSLength = 28
+)
+
+// DSS flags, as per RFC 8684 section 3.3.
+const (
+	dssFlagDataAck  = 0x01
+	dssFlagDataAck8 = 0x02
+	dssFlagMapping  = 0x04
+	dssFlagDSN8     = 0x08
+	dssFlagDataFIN  = 0x10
+)
+
+// Flags of the other MPTCP options, carried in the low bits of the subtype
+// octet.
+const (
+	addAddrFlagEcho  = 0x01
+	mpJoinFlagBackup = 0x01
+	mpPrioFlagBackup = 0x01
+)
+
+const (
+	mptcpSubtypeShift  = 4
+	mptcpMinimumLength = 3
+
+	addAddrBaseLength = 4
+	addAddrPortLength = 2
+	addAddrHMACLength = 8
+)
+
+// MPCapableOption is the MP_CAPABLE option, as per RFC 8684 section 3.1.
+type MPCapableOption struct {
+	// Version is the MPTCP version.
+	Version uint8
+
+	// Flags holds the MPCapableFlag* flags.
+	Flags uint8
+
+	// NumKeys is the number of keys carried by the option: 0 on a SYN, 1
+	// on a SYN-ACK and 2 on the ACK and first data segment.
+	NumKeys int
+
+	// SenderKey is the key of the sender of the option.
+	SenderKey uint64
+
+	// ReceiverKey is the key of the receiver of the option.
+	ReceiverKey uint64
+
+	// HasDataLen is true if the option carries a data-level length, which
+	// maps the segment's payload to the start of the data sequence space.
+	HasDataLen bool
+
+	// DataLen is the data-level length.
+	DataLen uint16
+}
+
+// MPJoinOption is the MP_JOIN option, as per RFC 8684 section 3.2. The fields
+// which are set depend on the segment it is carried by.
+type MPJoinOption struct {
+	// Backup is true if the subflow should only be used as a backup.
+	Backup bool
+
+	// AddrID is the address ID of the source address of the subflow.
+	AddrID uint8
+
+	// Token is the token of the receiver of a SYN.
+	Token uint32
+
+	// Nonce is the random number of the sender of a SYN or SYN-ACK.
+	Nonce uint32
+
+	// TruncatedHMAC is the truncated HMAC of the sender of a SYN-ACK.
+	TruncatedHMAC uint64
+
+	// HMAC is the HMAC of the sender of the third ACK.
+	HMAC [MPJoinHMACLength]byte
+}
+
+// DSSOption is the Data Sequence Signal option, as per RFC 8684 section 3.3.
+type DSSOption struct {
+	// HasDataAck is true if the option carries a data ACK.
+	HasDataAck bool
+
+	// DataAck is the data-level cumulative acknowledgement.
+	DataAck uint64
+
+	// HasMapping is true if the option carries a data sequence mapping.
+	HasMapping bool
+
+	// DSN is the data sequence number of the first byte of the mapping.
+	DSN uint64
+
+	// SSN is the subflow sequence number, relative to the subflow's
+	// initial sequence number, of the first byte of the mapping.
+	SSN uint32
+
+	// DataLen is the length of the mapping. It includes the DATA_FIN, if
+	// set.
+	DataLen uint16
+
+	// DataFIN is true if the mapping ends with a DATA_FIN.
+	DataFIN bool
+
+	// HasChecksum is true if the mapping carries a DSS checksum.
+	HasChecksum bool
+
+	// Checksum is the DSS checksum.
+	Checksum uint16
+
+	// dataAck32 and dsn32 are true if the data ACK and DSN were carried
+	// as 4 octet values.
+	dataAck32 bool
+	dsn32     bool
+}
+
+// AddAddrOption is the ADD_ADDR option, as per RFC 8684 section 3.4.1.
+type AddAddrOption struct {
+	// Echo is true if the option echoes a received ADD_ADDR.
+	Echo bool
+
+	// AddrID is the address ID of the advertised address.
+	AddrID uint8
+
+	// Addr is the advertised address.
+	Addr tcpip.Address
+
+	// Port is the advertised port, or zero.
+	Port uint16
+
+	// HMAC is the truncated HMAC of the option. It's only present if Echo
+	// is false.
+	HMAC uint64
+}
+
+// MPTCPOptions holds the MPTCP options carried by a TCP segment.
+type MPTCPOptions struct {
+	HasCapable bool
+	Capable    MPCapableOption
+
+	HasJoin bool
+	Join    MPJoinOption
+
+	HasDSS bool
+	DSS    DSSOption
+
+	HasAddAddr bool
+	AddAddr    AddAddrOption
+
+	// RemoveAddrIDs holds the address IDs of a REMOVE_ADDR option.
+	RemoveAddrIDs []uint8
+
+	HasPrio bool
+	// PrioBackup is the backup flag of an MP_PRIO option.
+	PrioBackup bool
+
+	HasFastclose bool
+	// FastcloseKey is the receiver's key carried by an MP_FASTCLOSE option.
+	FastcloseKey uint64
+}
+
+// ParseMPTCPOptions parses the MPTCP options carried by the TCP options opts.
+// Malformed MPTCP options are ignored, as per RFC 8684 section 3.
+func ParseMPTCPOptions(opts []byte) MPTCPOptions {
+	var m MPTCPOptions
+	for i := 0; i < len(opts); {
+		switch opts[i] {
+		case TCPOptionEOL:
+			return m
+		case TCPOptionNOP:
+			i++
+			continue
+		}
+		if i+2 > len(opts) {
+			return m
+		}
+		l := int(opts[i+1])
+		if l < 2 || i+l > len(opts) {
+			return m
+		}
+		if opts[i] == TCPOptionMPTCP && l >= mptcpMinimumLength {
+			m.parseOption(opts[i : i+l])
+		}
+		i += l
+	}
+	return m
+}
+
+// parseOption parses the MPTCP option o, including its kind and length.
+func (m *MPTCPOptions) parseOption(o []byte) {
+	subtype := MPTCPSubtype(o[2] >> mptcpSubtypeShift)
+	switch subtype {
+	case MPTCPSubtypeMPCapable:
+		c := MPCapableOption{Version: o[2] & 0xf}
+		if len(o) < MPCapableSynLength {
+			return
+		}
+		c.Flags = o[3]
+		switch len(o) {
+		case MPCapableSynLength:
+		case MPCapableSynAckLength:
+			c.NumKeys = 1
+			c.SenderKey = binary.BigEndian.Uint64(o[4:])
+		case MPCapableAckLength, MPCapableDataLength, MPCapableDataChecksumLength:
+			c.NumKeys = 2
+			c.SenderKey = binary.BigEndian.Uint64(o[4:])
+			c.ReceiverKey = binary.BigEndian.Uint64(o[12:])
+			if len(o) >= MPCapableDataLength {
+				c.HasDataLen = true
+				c.DataLen = binary.BigEndian.Uint16(o[20:])
+			}
+		default:
+			return
+		}
+		m.HasCapable = true
+		m.Capable = c
+
+	case MPTCPSubtypeMPJoin:
+		var j MPJoinOption
+		switch len(o) {
+		case MPJoinSynLength:
+			j.Backup = o[2]&mpJoinFlagBackup != 0
+			j.AddrID = o[3]
+			j.Token = binary.BigEndian.Uint32(o[4:])
+			j.Nonce = binary.BigEndian.Uint32(o[8:])
+		case MPJoinSynAckLength:
+			j.Backup = o[2]&mpJoinFlagBackup != 0
+			j.AddrID = o[3]
+			j.TruncatedHMAC = binary.BigEndian.Uint64(o[4:])
+			j.Nonce = binary.BigEndian.Uint32(o[12:])
+		case MPJoinAckLength:
+			copy(j.HMAC[:], o[4:])
+		default:
+			return
+		}
+		m.HasJoin = true
+		m.Join = j
+
+	case MPTCPSubtypeDSS:
+		var d DSSOption
+		flags := o[3]
+		want := 4
+		if flags&dssFlagDataAck != 0 {
+			want += 4
+			if flags&dssFlagDataAck8 != 0 {
+				want += 4
+			}
+		}
+		if flags&dssFlagMapping != 0 {
+			want += 4 + 4 + 2
+			if flags&dssFlagDSN8 != 0 {
+				want += 4
+			}
+		}
+		if len(o) != want && !(flags&dssFlagMapping != 0 && len(o) == want+2) {
+			return
+		}
+		off := 4
+		if flags&dssFlagDataAck != 0 {
+			d.HasDataAck = true
+			if flags&dssFlagDataAck8 != 0 {
+				d.DataAck = binary.BigEndian.Uint64(o[off:])
+				off += 8
+			} else {
+				d.DataAck = uint64(binary.BigEndian.Uint32(o[off:]))
+				d.dataAck32 = true
+				off += 4
+			}
+		}
+		if flags&dssFlagMapping != 0 {
+			d.HasMapping = true
+			if flags&dssFlagDSN8 != 0 {
+				d.DSN = binary.BigEndian.Uint64(o[off:])
+				off += 8
+			} else {
+				d.DSN = uint64(binary.BigEndian.Uint32(o[off:]))
+				d.dsn32 = true
+				off += 4
+			}
+			d.SSN = binary.BigEndian.Uint32(o[off:])
+			d.DataLen = binary.BigEndian.Uint16(o[off+4:])
+			off += 6
+			if len(o) == off+2 {
+				d.HasChecksum = true
+				d.Checksum = binary.BigEndian.Uint16(o[off:])
+			}
+			d.DataFIN = flags&dssFlagDataFIN != 0
+		}
+		m.HasDSS = true
+		m.DSS = d
+
+	case MPTCPSubtypeAddAddr:
+		a := AddAddrOption{
+			Echo:   o[2]&addAddrFlagEcho != 0,
+			AddrID: o[3],
+		}
+		n := len(o) - addAddrBaseLength
+		if !a.Echo {
+			n -= addAddrHMACLength
+		}
+		var addrLen int
+		switch n {
+		case IPv4AddressSize, IPv4AddressSize + addAddrPortLength:
+			addrLen = IPv4AddressSize
+		case IPv6AddressSize, IPv6AddressSize + addAddrPortLength:
+			addrLen = IPv6AddressSize
+		default:
+			return
+		}
+		off := addAddrBaseLength
+		a.Addr = tcpip.Address(o[off : off+addrLen])
+		off += addrLen
+		if n > addrLen {
+			a.Port = binary.BigEndian.Uint16(o[off:])
+			off += addAddrPortLength
+		}
+		if !a.Echo {
+			a.HMAC = binary.BigEndian.Uint64(o[off:])
+		}
+		m.HasAddAddr = true
+		m.AddAddr = a
+
+	case MPTCPSubtypeRemoveAddr:
+		m.RemoveAddrIDs = append([]uint8(nil), o[3:]...)
+
+	case MPTCPSubtypeMPPrio:
+		m.HasPrio = true
+		m.PrioBackup = o[2]&mpPrioFlagBackup != 0
+
+	case MPTCPSubtypeMPFastclose:
+		if len(o) != MPFastcloseLength {
+			return
+		}
+		m.HasFastclose = true
+		m.FastcloseKey = binary.BigEndian.Uint64(o[4:])
+	}
+}
+
+// ExpandDataSeq expands a data sequence number or data ACK which may have been
+// carried as a 4 octet value to 64 bits, choosing the value closest to ref.
+func ExpandDataSeq(v uint64, is32 bool, ref uint64) uint64 {
+	if !is32 {
+		return v
+	}
+	full := ref&^0xffffffff | v
+	// Pick the candidate closest to ref, accounting for wraparound of
+	// the lower 32 bits.
+	if d := int32(uint32(v) - uint32(ref)); d >= 0 {
+		if full < ref {
+			full += 1 << 32
+		}
+	} else if full > ref {
+		full -= 1 << 32
+	}
+	return full
+}
+
+// ExpandedDataAck returns the data ACK of d expanded to 64 bits relative to
+// ref.
+func (d *DSSOption) ExpandedDataAck(ref uint64) uint64 {
+	return ExpandDataSeq(d.DataAck, d.dataAck32, ref)
+}
+
+// ExpandedDSN returns the data sequence number of d expanded to 64 bits
+// relative to ref.
+func (d *DSSOption) ExpandedDSN(ref uint64) uint64 {
+	return ExpandDataSeq(d.DSN, d.dsn32, ref)
+}
+
+// encodeMPTCPHeader encodes the kind, length and subtype of an MPTCP option
+// into b.
+func encodeMPTCPHeader(subtype MPTCPSubtype, length int, lowBits uint8, b []byte) {
+	b[0] = TCPOptionMPTCP
+	b[1] = uint8(length)
+	b[2] = uint8(subtype)<<mptcpSubtypeShift | lowBits&0xf
+}
+
+// EncodeMPCapableOption encodes c into b. The form of the option is selected
+// by c.NumKeys and c.HasDataLen. It returns the number of bytes written, or 0
+// if b is too small.
+func EncodeMPCapableOption(c MPCapableOption, b []byte) int {
+	var l int
+	switch {
+	case c.NumKeys == 0:
+		l = MPCapableSynLength
+	case c.NumKeys == 1:
+		l = MPCapableSynAckLength
+	case c.HasDataLen:
+		l = MPCapableDataLength
+	default:
+		l = MPCapableAckLength
+	}
+	if len(b) < l {
+		return 0
+	}
+	encodeMPTCPHeader(MPTCPSubtypeMPCapable, l, c.Version, b)
+	b[3] = c.Flags
+	if c.NumKeys >= 1 {
+		binary.BigEndian.PutUint64(b[4:], c.SenderKey)
+	}
+	if c.NumKeys >= 2 {
+		binary.BigEndian.PutUint64(b[12:], c.ReceiverKey)
+	}
+	if c.HasDataLen {
+		binary.BigEndian.PutUint16(b[20:], c.DataLen)
+	}
+	return l
+}
+
+// EncodeMPJoinSynOption encodes the MP_JOIN option of a SYN into b. It returns
+// the number of bytes written, or 0 if b is too small.
+func EncodeMPJoinSynOption(j MPJoinOption, b []byte) int {
+	if len(b) < MPJoinSynLength {
+		return 0
+	}
+	var flags uint8
+	if j.Backup {
+		flags = mpJoinFlagBackup
+	}
+	encodeMPTCPHeader(MPTCPSubtypeMPJoin, MPJoinSynLength, flags, b)
+	b[3] = j.AddrID
+	binary.BigEndian.PutUint32(b[4:], j.Token)
+	binary.BigEndian.PutUint32(b[8:], j.Nonce)
+	return MPJoinSynLength
+}
+
+// EncodeMPJoinSynAckOption encodes the MP_JOIN option of a SYN-ACK into b. It
+// returns the number of bytes written, or 0 if b is too small.
+func EncodeMPJoinSynAckOption(j MPJoinOption, b []byte) int {
+	if len(b) < MPJoinSynAckLength {
+		return 0
+	}
+	var flags uint8
+	if j.Backup {
+		flags = mpJoinFlagBackup
+	}
+	encodeMPTCPHeader(MPTCPSubtypeMPJoin, MPJoinSynAckLength, flags, b)
+	b[3] = j.AddrID
+	binary.BigEndian.PutUint64(b[4:], j.TruncatedHMAC)
+	binary.BigEndian.PutUint32(b[12:], j.Nonce)
+	return MPJoinSynAckLength
+}
+
+// EncodeMPJoinAckOption encodes the MP_JOIN option of the third ACK into b. It
+// returns the number of bytes written, or 0 if b is too small.
+func EncodeMPJoinAckOption(j MPJoinOption, b []byte) int {
+	if len(b) < MPJoinAckLength {
+		return 0
+	}
+	encodeMPTCPHeader(MPTCPSubtypeMPJoin, MPJoinAckLength, 0, b)
+	b[3] = 0
+	copy(b[4:], j.HMAC[:])
+	return MPJoinAckLength
+}
+
+// DSSOptionLength returns the length of the encoding of d.
+func DSSOptionLength(d DSSOption) int {
+	l := 4
+	if d.HasDataAck {
+		l += 8
+	}
+	if d.HasMapping {
+		l += 8 + 4 + 2
+		if d.HasChecksum {
+			l += 2
+		}
+	}
+	return l
+}
+
+// EncodeDSSOption encodes d into b, using 8 octet data ACK and data sequence
+// numbers. It returns the number of bytes written, or 0 if b is too small.
+func EncodeDSSOption(d DSSOption, b []byte) int {
+	l := DSSOptionLength(d)
+	if len(b) < l {
+		return 0
+	}
+	encodeMPTCPHeader(MPTCPSubtypeDSS, l, 0, b)
+	var flags uint8
+	off := 4
+	if d.HasDataAck {
+		flags |= dssFlagDataAck | dssFlagDataAck8
+		binary.BigEndian.PutUint64(b[off:], d.DataAck)
+		off += 8
+	}
+	if d.HasMapping {
+		flags |= dssFlagMapping | dssFlagDSN8
+		if d.DataFIN {
+			flags |= dssFlagDataFIN
+		}
+		binary.BigEndian.PutUint64(b[off:], d.DSN)
+		binary.BigEndian.PutUint32(b[off+8:], d.SSN)
+		binary.BigEndian.PutUint16(b[off+12:], d.DataLen)
+		off += 14
+		if d.HasChecksum {
+			binary.BigEndian.PutUint16(b[off:], d.Checksum)
+		}
+	}
+	b[3] = flags
+	return l
+}
+
+// AddAddrOptionLength returns the length of the encoding of a.
+func AddAddrOptionLength(a AddAddrOption) int {
+	l := addAddrBaseLength + len(a.Addr)
+	if a.Port != 0 {
+		l += addAddrPortLength
+	}
+	if !a.Echo {
+		l += addAddrHMACLength
+	}
+	return l
+}
+
+// EncodeAddAddrOption encodes a into b. It returns the number of bytes
+// written, or 0 if b is too small.
+func EncodeAddAddrOption(a AddAddrOption, b []byte) int {
+	l := AddAddrOptionLength(a)
+	if len(b) < l {
+		return 0
+	}
+	var flags uint8
+	if a.Echo {
+		flags = addAddrFlagEcho
+	}
+	encodeMPTCPHeader(MPTCPSubtypeAddAddr, l, flags, b)
+	b[3] = a.AddrID
+	off := addAddrBaseLength + copy(b[addAddrBaseLength:], a.Addr)
+	if a.Port != 0 {
+		binary.BigEndian.PutUint16(b[off:], a.Port)
+		off += addAddrPortLength
+	}
+	if !a.Echo {
+		binary.BigEndian.PutUint64(b[off:], a.HMAC)
+	}
+	return l
+}
+
+// EncodeRemoveAddrOption encodes a REMOVE_ADDR option for ids into b. It
+// returns the number of bytes written, or 0 if b is too small.
+func EncodeRemoveAddrOption(ids []uint8, b []byte) int {
+	l := mptcpMinimumLength + len(ids)
+	if len(ids) == 0 || len(b) < l {
+		return 0
+	}
+	encodeMPTCPHeader(MPTCPSubtypeRemoveAddr, l, 0, b)
+	copy(b[mptcpMinimumLength:], ids)
+	return l
+}
+
+// EncodeMPPrioOption encodes an MP_PRIO option into b. It returns the number
+// of bytes written, or 0 if b is too small.
+func EncodeMPPrioOption(backup bool, b []byte) int {
+	if len(b) < MPPrioLength {
+		return 0
+	}
+	var flags uint8
+	if backup {
+		flags = mpPrioFlagBackup
+	}
+	encodeMPTCPHeader(MPTCPSubtypeMPPrio, MPPrioLength, flags, b)
+	return MPPrioLength
+}
+
+// EncodeMPFastcloseOption encodes an MP_FASTCLOSE option carrying the
+// receiver's key into b. It returns the number of bytes written, or 0 if b is
+// too small.
+func EncodeMPFastcloseOption(key uint64, b []byte) int {
+	if len(b) < MPFastcloseLength {
+		return 0
+	}
+	encodeMPTCPHeader(MPTCPSubtypeMPFastclose, MPFastcloseLength, 0, b)
+	b[3] = 0
+	binary.BigEndian.PutUint64(b[4:], key)
+	return MPFastcloseLength
+}
+
+// MPTCPToken returns the token identifying a connection to the holder of
+// key, the most significant 32 bits of the SHA-256 hash of the key, as per
+// RFC 8684 section 3.1.
+func MPTCPToken(key uint64) uint32 {
+	h := mptcpKeyHash(key)
+	return binary.BigEndian.Uint32(h[:])
+}
+
+// MPTCPIDSN returns the initial data sequence number of the holder of key,
+// the least significant 64 bits of the SHA-256 hash of the key, as per RFC
+// 8684 section 3.1.
+func MPTCPIDSN(key uint64) uint64 {
+	h := mptcpKeyHash(key)
+	return binary.BigEndian.Uint64(h[sha256.Size-8:])
+}
+
+func mptcpKeyHash(key uint64) [sha256.Size]byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], key)
+	return sha256.Sum256(b[:])
+}
+
+// mptcpHMAC returns HMAC-SHA256 of msg keyed with the concatenation of key1
+// and key2.
+func mptcpHMAC(key1, key2 uint64, msg []byte) []byte {
+	var k [16]byte
+	binary.BigEndian.PutUint64(k[:], key1)
+	binary.BigEndian.PutUint64(k[8:], key2)
+	h := hmac.New(sha256.New, k[:])
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+// MPTCPJoinHMAC returns the HMAC authenticating an MP_JOIN handshake sent by
+// the holder of localKey and localNonce, as per RFC 8684 section 3.2.
+// The SYN-ACK carries its leftmost 64 bits and the third ACK its leftmost
+// MPJoinHMACLength bytes.
+func MPTCPJoinHMAC(localKey, remoteKey uint64, localNonce, remoteNonce uint32) []byte {
+	var msg [8]byte
+	binary.BigEndian.PutUint32(msg[:], localNonce)
+	binary.BigEndian.PutUint32(msg[4:], remoteNonce)
+	return mptcpHMAC(localKey, remoteKey, msg[:])
+}
+
+// MPTCPAddAddrHMAC returns the truncated HMAC of an ADD_ADDR option sent by
+// the holder of localKey, as per RFC 8684 section 3.4.1.
+func MPTCPAddAddrHMAC(localKey, remoteKey uint64, id uint8, addr tcpip.Address, port uint16) uint64 {
+	msg := make([]byte, 0, 1+len(addr)+addAddrPortLength)
+	msg = append(msg, id)
+	msg = append(msg, addr...)
+	msg = append(msg, byte(port>>8), byte(port))
+	h := mptcpHMAC(localKey, remoteKey, msg)
+	return binary.BigEndian.Uint64(h[len(h)-8:])
+}
@@ -0,0 +1,248 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func TestMPCapableOption(t *testing.T) {
+	tests := []struct {
+		name    string
+		opt     header.MPCapableOption
+		wantLen int
+	}{
+		{
+			name: "SYN",
+			opt: header.MPCapableOption{
+				Version: header.MPTCPVersion,
+				Flags:   header.MPCapableFlagHMACSHA256,
+			},
+			wantLen: header.MPCapableSynLength,
+		},
+		{
+			name: "SYN-ACK",
+			opt: header.MPCapableOption{
+				Version:   header.MPTCPVersion,
+				Flags:     header.MPCapableFlagHMACSHA256,
+				NumKeys:   1,
+				SenderKey: 0x0102030405060708,
+			},
+			wantLen: header.MPCapableSynAckLength,
+		},
+		{
+			name: "ACK",
+			opt: header.MPCapableOption{
+				Version:     header.MPTCPVersion,
+				Flags:       header.MPCapableFlagHMACSHA256,
+				NumKeys:     2,
+				SenderKey:   0x0102030405060708,
+				ReceiverKey: 0x1112131415161718,
+			},
+			wantLen: header.MPCapableAckLength,
+		},
+		{
+			name: "data",
+			opt: header.MPCapableOption{
+				Version:     header.MPTCPVersion,
+				Flags:       header.MPCapableFlagHMACSHA256,
+				NumKeys:     2,
+				SenderKey:   0x0102030405060708,
+				ReceiverKey: 0x1112131415161718,
+				HasDataLen:  true,
+				DataLen:     1000,
+			},
+			wantLen: header.MPCapableDataLength,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := make([]byte, 40)
+			n := header.EncodeMPCapableOption(test.opt, b)
+			if n != test.wantLen {
+				t.Fatalf("got header.EncodeMPCapableOption(%+v, _) = %d, want = %d", test.opt, n, test.wantLen)
+			}
+			got := header.ParseMPTCPOptions(b[:n])
+			if !got.HasCapable {
+				t.Fatalf("got header.ParseMPTCPOptions(%x).HasCapable = false, want = true", b[:n])
+			}
+			if diff := cmp.Diff(test.opt, got.Capable); diff != "" {
+				t.Errorf("MP_CAPABLE mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMPJoinOption(t *testing.T) {
+	b := make([]byte, 40)
+
+	syn := header.MPJoinOption{Backup: true, AddrID: 3, Token: 0xdeadbeef, Nonce: 0x12345678}
+	n := header.EncodeMPJoinSynOption(syn, b)
+	if got := header.ParseMPTCPOptions(b[:n]); !got.HasJoin || got.Join != syn {
+		t.Errorf("got SYN MP_JOIN = %+v, want = %+v", got.Join, syn)
+	}
+
+	synAck := header.MPJoinOption{AddrID: 1, TruncatedHMAC: 0x0102030405060708, Nonce: 0x87654321}
+	n = header.EncodeMPJoinSynAckOption(synAck, b)
+	if got := header.ParseMPTCPOptions(b[:n]); !got.HasJoin || got.Join != synAck {
+		t.Errorf("got SYN-ACK MP_JOIN = %+v, want = %+v", got.Join, synAck)
+	}
+
+	var ack header.MPJoinOption
+	copy(ack.HMAC[:], header.MPTCPJoinHMAC(1, 2, 3, 4))
+	n = header.EncodeMPJoinAckOption(ack, b)
+	if got := header.ParseMPTCPOptions(b[:n]); !got.HasJoin || got.Join != ack {
+		t.Errorf("got ACK MP_JOIN = %+v, want = %+v", got.Join, ack)
+	}
+}
+
+func TestDSSOption(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  header.DSSOption
+	}{
+		{
+			name: "data ACK",
+			opt:  header.DSSOption{HasDataAck: true, DataAck: 1 << 40},
+		},
+		{
+			name: "mapping",
+			opt:  header.DSSOption{HasMapping: true, DSN: 1<<40 + 5, SSN: 1, DataLen: 1460},
+		},
+		{
+			name: "data ACK and DATA_FIN",
+			opt:  header.DSSOption{HasDataAck: true, DataAck: 7, HasMapping: true, DSN: 100, SSN: 0, DataLen: 1, DataFIN: true},
+		},
+		{
+			name: "checksum",
+			opt:  header.DSSOption{HasDataAck: true, DataAck: 7, HasMapping: true, DSN: 100, SSN: 1, DataLen: 10, HasChecksum: true, Checksum: 0xabcd},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := make([]byte, 40)
+			n := header.EncodeDSSOption(test.opt, b)
+			if want := header.DSSOptionLength(test.opt); n != want {
+				t.Fatalf("got header.EncodeDSSOption(%+v, _) = %d, want = %d", test.opt, n, want)
+			}
+			got := header.ParseMPTCPOptions(b[:n])
+			if !got.HasDSS {
+				t.Fatalf("got header.ParseMPTCPOptions(%x).HasDSS = false, want = true", b[:n])
+			}
+			if diff := cmp.Diff(test.opt, got.DSS, cmp.AllowUnexported(header.DSSOption{})); diff != "" {
+				t.Errorf("DSS mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDSSOptionShortForms(t *testing.T) {
+	// DSS with a 4 octet data ACK and a 4 octet DSN.
+	b := []byte{
+		header.TCPOptionMPTCP, 18, uint8(header.MPTCPSubtypeDSS) << 4, 0x05,
+		0x00, 0x00, 0x00, 0x10,
+		0x00, 0x00, 0x00, 0x20,
+		0x00, 0x00, 0x00, 0x01,
+		0x00, 0x0a,
+	}
+	got := header.ParseMPTCPOptions(b)
+	if !got.HasDSS || !got.DSS.HasDataAck || !got.DSS.HasMapping {
+		t.Fatalf("got header.ParseMPTCPOptions(%x) = %+v, want DSS with data ACK and mapping", b, got)
+	}
+	const ref = 5<<32 + 0x8
+	if got, want := got.DSS.ExpandedDataAck(ref), uint64(5<<32+0x10); got != want {
+		t.Errorf("got ExpandedDataAck(%#x) = %#x, want = %#x", ref, got, want)
+	}
+	if got, want := got.DSS.ExpandedDSN(ref), uint64(5<<32+0x20); got != want {
+		t.Errorf("got ExpandedDSN(%#x) = %#x, want = %#x", ref, got, want)
+	}
+}
+
+func TestExpandDataSeq(t *testing.T) {
+	tests := []struct {
+		v    uint64
+		ref  uint64
+		want uint64
+	}{
+		{v: 10, ref: 5, want: 10},
+		{v: 0xfffffff0, ref: 1<<32 + 5, want: 0xfffffff0},
+		{v: 5, ref: 1<<32 - 10, want: 1<<32 + 5},
+		{v: 0x80, ref: 7<<32 + 0x100, want: 7<<32 + 0x80},
+	}
+	for _, test := range tests {
+		if got := header.ExpandDataSeq(test.v, true, test.ref); got != test.want {
+			t.Errorf("got header.ExpandDataSeq(%#x, true, %#x) = %#x, want = %#x", test.v, test.ref, got, test.want)
+		}
+	}
+}
+
+func TestAddAddrOption(t *testing.T) {
+	tests := []header.AddAddrOption{
+		{AddrID: 1, Addr: tcpip.Address("\x0a\x00\x00\x01"), HMAC: 0x1122334455667788},
+		{AddrID: 2, Addr: tcpip.Address("\x0a\x00\x00\x02"), Port: 80, HMAC: 1},
+		{Echo: true, AddrID: 3, Addr: tcpip.Address(bytes.Repeat([]byte{0xfe}, header.IPv6AddressSize))},
+	}
+	for _, opt := range tests {
+		b := make([]byte, 40)
+		n := header.EncodeAddAddrOption(opt, b)
+		if want := header.AddAddrOptionLength(opt); n != want {
+			t.Fatalf("got header.EncodeAddAddrOption(%+v, _) = %d, want = %d", opt, n, want)
+		}
+		if got := header.ParseMPTCPOptions(b[:n]); !got.HasAddAddr || got.AddAddr != opt {
+			t.Errorf("got ADD_ADDR = %+v, want = %+v", got.AddAddr, opt)
+		}
+	}
+}
+
+func TestParseMPTCPOptionsSkipsOtherOptions(t *testing.T) {
+	b := make([]byte, 40)
+	n := header.EncodeNOP(b)
+	n += header.EncodeNOP(b[n:])
+	n += header.EncodeTSOption(1, 2, b[n:])
+	n += header.EncodeRemoveAddrOption([]uint8{4, 5}, b[n:])
+	n += header.EncodeMPPrioOption(true, b[n:])
+	got := header.ParseMPTCPOptions(b[:n])
+	if diff := cmp.Diff([]uint8{4, 5}, got.RemoveAddrIDs); diff != "" {
+		t.Errorf("REMOVE_ADDR mismatch (-want +got):\n%s", diff)
+	}
+	if !got.HasPrio || !got.PrioBackup {
+		t.Errorf("got MP_PRIO = (%t, %t), want = (true, true)", got.HasPrio, got.PrioBackup)
+	}
+}
+
+func TestMPTCPKeyDerivation(t *testing.T) {
+	const key = 0x0123456789abcdef
+	if header.MPTCPToken(key) == header.MPTCPToken(key+1) {
+		t.Errorf("tokens of different keys collide")
+	}
+	if header.MPTCPIDSN(key) == header.MPTCPIDSN(key+1) {
+		t.Errorf("IDSNs of different keys collide")
+	}
+
+	// Both ends of a join must compute each other's HMAC.
+	a := header.MPTCPJoinHMAC(1, 2, 3, 4)
+	b := header.MPTCPJoinHMAC(2, 1, 4, 3)
+	if bytes.Equal(a, b) {
+		t.Errorf("got equal HMACs for both directions of a join")
+	}
+	if !bytes.Equal(a, header.MPTCPJoinHMAC(1, 2, 3, 4)) {
+		t.Errorf("MPTCPJoinHMAC is not deterministic")
+	}
+}
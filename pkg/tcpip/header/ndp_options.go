@@ -42,6 +42,10 @@ const (
 	// option, as per RFC 4861 section 4.6.2.
 	NDPPrefixInformationType NDPOptionIdentifier = 3
 
+	// NDPRedirectedHeaderOptionType is the type of the Redirected Header
+	// option, as per RFC 4861 section 4.6.3.
+	NDPRedirectedHeaderOptionType NDPOptionIdentifier = 4
+
 	// NDPRecursiveDNSServerOptionType is the type of the Recursive DNS
 	// Server option, as per RFC 8106 section 5.1.
 	NDPRecursiveDNSServerOptionType NDPOptionIdentifier = 25
@@ -106,6 +110,11 @@ const (
 	// within an NDPPrefixInformation.
 	ndpPrefixInformationPrefixOffset = 14
 
+	// ndpRedirectedHeaderReservedLength is the length, in bytes, of the
+	// Reserved field preceding the redirected packet within an NDP Redirected
+	// Header option, as per RFC 4861 section 4.6.3.
+	ndpRedirectedHeaderReservedLength = 6
+
 	// ndpRecursiveDNSServerLifetimeOffset is the start of the 4-byte
 	// Lifetime field within an NDPRecursiveDNSServer.
 	ndpRecursiveDNSServerLifetimeOffset = 2
@@ -241,6 +250,11 @@ func (i *NDPOptionIterator) Next() (NDPOption, bool, error) {
 
 			return NDPPrefixInformation(body), false, nil
 
+		case NDPRedirectedHeaderOptionType:
+			// The Length field is non-zero so the body always holds at least the
+			// Reserved field.
+			return NDPRedirectedHeader(body[ndpRedirectedHeaderReservedLength:]), false, nil
+
 		case NDPRecursiveDNSServerOptionType:
 			opt := NDPRecursiveDNSServer(body)
 			if err := opt.checkAddresses(); err != nil {
@@ -496,6 +510,39 @@ func (o NDPTargetLinkLayerAddressOption) EthernetAddress() tcpip.LinkAddress {
 	return tcpip.LinkAddress([]byte(nil))
 }
 
+// NDPRedirectedHeader is the NDP Redirected Header option as defined by RFC
+// 4861 section 4.6.3.
+//
+// It holds the IP header and data of the packet that triggered the Redirect,
+// truncated to fit in the Redirect message. When parsed, it may also include
+// trailing padding bytes.
+type NDPRedirectedHeader []byte
+
+// Type implements NDPOption.Type.
+func (o NDPRedirectedHeader) Type() NDPOptionIdentifier {
+	return NDPRedirectedHeaderOptionType
+}
+
+// Length implements NDPOption.Length.
+func (o NDPRedirectedHeader) Length() int {
+	return ndpRedirectedHeaderReservedLength + len(o)
+}
+
+// serializeInto implements NDPOption.serializeInto.
+func (o NDPRedirectedHeader) serializeInto(b []byte) int {
+	// Zero out the Reserved field.
+	for i := 0; i < ndpRedirectedHeaderReservedLength; i++ {
+		b[i] = 0
+	}
+
+	return ndpRedirectedHeaderReservedLength + copy(b[ndpRedirectedHeaderReservedLength:], o)
+}
+
+// String implements fmt.Stringer.String.
+func (o NDPRedirectedHeader) String() string {
+	return fmt.Sprintf("%T(%d bytes)", o, len(o))
+}
+
 // NDPPrefixInformation is the NDP Prefix Information option as defined by
 // RFC 4861 section 4.6.2.
 //
@@ -56,6 +56,53 @@ func TestNDPNeighborSolicit(t *testing.T) {
 	}
 }
 
+// TestNDPRedirect tests the functions of NDPRedirect.
+func TestNDPRedirect(t *testing.T) {
+	b := []byte{
+		0, 0, 0, 0,
+		1, 2, 3, 4,
+		5, 6, 7, 8,
+		9, 10, 11, 12,
+		13, 14, 15, 16,
+		17, 18, 19, 20,
+		21, 22, 23, 24,
+		25, 26, 27, 28,
+		29, 30, 31, 32,
+	}
+
+	rd := NDPRedirect(b)
+	target := tcpip.Address("\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10")
+	if got := rd.TargetAddress(); got != target {
+		t.Errorf("got rd.TargetAddress = %s, want %s", got, target)
+	}
+	dst := tcpip.Address("\x11\x12\x13\x14\x15\x16\x17\x18\x19\x1a\x1b\x1c\x1d\x1e\x1f\x20")
+	if got := rd.DestinationAddress(); got != dst {
+		t.Errorf("got rd.DestinationAddress = %s, want %s", got, dst)
+	}
+
+	// Test updating the addresses.
+	target2 := tcpip.Address("\x21\x22\x23\x24\x25\x26\x27\x28\x29\x2a\x2b\x2c\x2d\x2e\x2f\x30")
+	rd.SetTargetAddress(target2)
+	if got := rd.TargetAddress(); got != target2 {
+		t.Errorf("got rd.TargetAddress = %s, want %s", got, target2)
+	}
+	dst2 := tcpip.Address("\x31\x32\x33\x34\x35\x36\x37\x38\x39\x3a\x3b\x3c\x3d\x3e\x3f\x40")
+	rd.SetDestinationAddress(dst2)
+	if got := rd.DestinationAddress(); got != dst2 {
+		t.Errorf("got rd.DestinationAddress = %s, want %s", got, dst2)
+	}
+	// Make sure the addresses got updated in the backing buffer.
+	if got := tcpip.Address(b[ndpRedirectTargetAddressOffset:][:IPv6AddressSize]); got != target2 {
+		t.Errorf("got target address buffer = %s, want %s", got, target2)
+	}
+	if got := tcpip.Address(b[ndpRedirectDestinationAddressOffset:][:IPv6AddressSize]); got != dst2 {
+		t.Errorf("got destination address buffer = %s, want %s", got, dst2)
+	}
+	if got := len(rd.Options()); got != 0 {
+		t.Errorf("got len(rd.Options()) = %d, want = 0", got)
+	}
+}
+
 // TestNDPNeighborAdvert tests the functions of NDPNeighborAdvert.
 func TestNDPNeighborAdvert(t *testing.T) {
 	b := []byte{
@@ -492,6 +539,70 @@ func TestNDPPrefixInformationOption(t *testing.T) {
 	}
 }
 
+// TestNDPRedirectedHeaderOption tests the serialization and parsing of an
+// NDPRedirectedHeader.
+func TestNDPRedirectedHeaderOption(t *testing.T) {
+	b := []byte{
+		1, 2, 3, 4,
+		5, 6, 7, 8,
+		9, 10, 11, 12,
+	}
+
+	targetBuf := []byte{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}
+	opts := NDPOptions(targetBuf)
+	serializer := NDPOptionsSerializer{
+		NDPRedirectedHeader(b),
+	}
+	if got, want := opts.Serialize(serializer), len(targetBuf); got != want {
+		t.Errorf("got Serialize = %d, want = %d", got, want)
+	}
+	expectedBuf := []byte{
+		4, 3, 0, 0,
+		0, 0, 0, 0,
+		1, 2, 3, 4,
+		5, 6, 7, 8,
+		9, 10, 11, 12,
+		0, 0, 0, 0,
+	}
+	if !bytes.Equal(targetBuf, expectedBuf) {
+		t.Fatalf("got targetBuf = %x, want = %x", targetBuf, expectedBuf)
+	}
+
+	it, err := opts.Iter(true)
+	if err != nil {
+		t.Fatalf("got Iter = (_, %s), want = (_, nil)", err)
+	}
+
+	next, done, err := it.Next()
+	if err != nil {
+		t.Fatalf("got Next = (_, _, %s), want = (_, _, nil)", err)
+	}
+	if done {
+		t.Fatal("got Next = (_, true, _), want = (_, false, _)")
+	}
+	if got := next.Type(); got != NDPRedirectedHeaderOptionType {
+		t.Errorf("got Type = %d, want = %d", got, NDPRedirectedHeaderOptionType)
+	}
+
+	// The parsed option includes the padding bytes.
+	rh := next.(NDPRedirectedHeader)
+	if want := append(b, 0, 0, 0, 0); !bytes.Equal(rh, want) {
+		t.Errorf("got redirected header = %x, want = %x", []byte(rh), want)
+	}
+
+	// Iterator should not return anything else.
+	next, done, err = it.Next()
+	if err != nil {
+		t.Errorf("got Next = (_, _, %s), want = (_, _, nil)", err)
+	}
+	if !done {
+		t.Error("got Next = (_, false, _), want = (_, true, _)")
+	}
+	if next != nil {
+		t.Errorf("got Next = (%x, _, _), want = (nil, _, _)", next)
+	}
+}
+
 func TestNDPRecursiveDNSServerOptionSerialize(t *testing.T) {
 	b := []byte{
 		9, 8,
@@ -25,21 +25,22 @@ func _() {
 	_ = x[NDPSourceLinkLayerAddressOptionType-1]
 	_ = x[NDPTargetLinkLayerAddressOptionType-2]
 	_ = x[NDPPrefixInformationType-3]
+	_ = x[NDPRedirectedHeaderOptionType-4]
 	_ = x[NDPRecursiveDNSServerOptionType-25]
 }
 
 const (
-	_NDPOptionIdentifier_name_0 = "NDPSourceLinkLayerAddressOptionTypeNDPTargetLinkLayerAddressOptionTypeNDPPrefixInformationType"
+	_NDPOptionIdentifier_name_0 = "NDPSourceLinkLayerAddressOptionTypeNDPTargetLinkLayerAddressOptionTypeNDPPrefixInformationTypeNDPRedirectedHeaderOptionType"
 	_NDPOptionIdentifier_name_1 = "NDPRecursiveDNSServerOptionType"
 )
 
 var (
-	_NDPOptionIdentifier_index_0 = [...]uint8{0, 35, 70, 94}
+	_NDPOptionIdentifier_index_0 = [...]uint8{0, 35, 70, 94, 123}
 )
 
 func (i NDPOptionIdentifier) String() string {
 	switch {
-	case 1 <= i && i <= 3:
+	case 1 <= i && i <= 4:
 		i -= 1
 		return _NDPOptionIdentifier_name_0[_NDPOptionIdentifier_index_0[i]:_NDPOptionIdentifier_index_0[i+1]]
 	case i == 25:
@@ -146,6 +146,16 @@ func UDP(pkt *stack.PacketBuffer) bool {
 	return ok
 }
 
+// UDPLite parses a UDP-Lite packet found in pkt.Data and populates pkt's
+// transport header with the UDP-Lite header.
+//
+// Returns true if the header was successfully parsed.
+func UDPLite(pkt *stack.PacketBuffer) bool {
+	_, ok := pkt.TransportHeader().Consume(header.UDPMinimumSize)
+	pkt.TransportProtocolNumber = header.UDPLiteProtocolNumber
+	return ok
+}
+
 // TCP parses a TCP packet found in pkt.Data and populates pkt's transport
 // header with the TCP header.
 //
@@ -169,3 +179,13 @@ func TCP(pkt *stack.PacketBuffer) bool {
 	pkt.TransportProtocolNumber = header.TCPProtocolNumber
 	return ok
 }
+
+// SCTP parses an SCTP packet found in pkt.Data and populates pkt's transport
+// header with the SCTP common header. The chunks are left in pkt.Data.
+//
+// Returns true if the header was successfully parsed.
+func SCTP(pkt *stack.PacketBuffer) bool {
+	_, ok := pkt.TransportHeader().Consume(header.SCTPMinimumSize)
+	pkt.TransportProtocolNumber = header.SCTPProtocolNumber
+	return ok
+}
@@ -0,0 +1,741 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+const (
+	sctpSrcPort         = 0
+	sctpDstPort         = 2
+	sctpVerificationTag = 4
+	sctpChecksum        = 8
+)
+
+const (
+	// SCTPProtocolNumber is SCTP's transport protocol number.
+	SCTPProtocolNumber tcpip.TransportProtocolNumber = 132
+
+	// SCTPMinimumSize is the size of the SCTP common header.
+	SCTPMinimumSize = 12
+
+	// SCTPChunkHeaderLength is the length of the header of a chunk.
+	SCTPChunkHeaderLength = 4
+
+	// SCTPParameterHeaderLength is the length of the header of a parameter
+	// or of an error cause.
+	SCTPParameterHeaderLength = 4
+
+	// SCTPDataChunkHeaderLength is the length of a DATA chunk without its
+	// user data.
+	SCTPDataChunkHeaderLength = 16
+
+	// SCTPInitChunkMinimumLength is the length of an INIT or INIT ACK chunk
+	// without parameters.
+	SCTPInitChunkMinimumLength = 20
+
+	// SCTPSackChunkMinimumLength is the length of a SACK chunk without gap
+	// ack blocks and duplicate TSNs.
+	SCTPSackChunkMinimumLength = 16
+
+	// SCTPShutdownChunkLength is the length of a SHUTDOWN chunk.
+	SCTPShutdownChunkLength = 8
+
+	// SCTPMaxStreams is the maximum number of streams in each direction of
+	// an association.
+	SCTPMaxStreams = 65535
+)
+
+// SCTPChunkType is the type of an SCTP chunk, as per RFC 4960 section 3.2.
+type SCTPChunkType uint8
+
+// SCTP chunk types.
+const (
+	SCTPChunkData             SCTPChunkType = 0
+	SCTPChunkInit             SCTPChunkType = 1
+	SCTPChunkInitAck          SCTPChunkType = 2
+	SCTPChunkSack             SCTPChunkType = 3
+	SCTPChunkHeartbeat        SCTPChunkType = 4
+	SCTPChunkHeartbeatAck     SCTPChunkType = 5
+	SCTPChunkAbort            SCTPChunkType = 6
+	SCTPChunkShutdown         SCTPChunkType = 7
+	SCTPChunkShutdownAck      SCTPChunkType = 8
+	SCTPChunkError            SCTPChunkType = 9
+	SCTPChunkCookieEcho       SCTPChunkType = 10
+	SCTPChunkCookieAck        SCTPChunkType = 11
+	SCTPChunkShutdownComplete SCTPChunkType = 14
+)
+
+// Flags of DATA chunks, as per RFC 4960 section 3.3.1 and RFC 7053.
+const (
+	SCTPDataFlagEnd       = 1 << 0
+	SCTPDataFlagBeginning = 1 << 1
+	SCTPDataFlagUnordered = 1 << 2
+	SCTPDataFlagImmediate = 1 << 3
+)
+
+// SCTPFlagT is the flag of ABORT and SHUTDOWN COMPLETE chunks which indicates
+// that the verification tag of their packet is reflected from the packet they
+// respond to.
+const SCTPFlagT = 1 << 0
+
+// SCTPParameterType is the type of a parameter of an INIT or INIT ACK chunk,
+// or of a HEARTBEAT chunk.
+type SCTPParameterType uint16
+
+// SCTP parameter types.
+const (
+	SCTPParameterHeartbeatInfo         SCTPParameterType = 1
+	SCTPParameterIPv4Address           SCTPParameterType = 5
+	SCTPParameterIPv6Address           SCTPParameterType = 6
+	SCTPParameterStateCookie           SCTPParameterType = 7
+	SCTPParameterUnrecognized          SCTPParameterType = 8
+	SCTPParameterCookiePreservative    SCTPParameterType = 9
+	SCTPParameterHostName              SCTPParameterType = 11
+	SCTPParameterSupportedAddressTypes SCTPParameterType = 12
+)
+
+// SCTPErrorCauseCode is the code of an error cause carried by ABORT and ERROR
+// chunks, as per RFC 4960 section 3.3.10.
+type SCTPErrorCauseCode uint16
+
+// SCTP error cause codes.
+const (
+	SCTPCauseInvalidStream          SCTPErrorCauseCode = 1
+	SCTPCauseMissingParameter       SCTPErrorCauseCode = 2
+	SCTPCauseStaleCookie            SCTPErrorCauseCode = 3
+	SCTPCauseOutOfResource          SCTPErrorCauseCode = 4
+	SCTPCauseUnresolvableAddress    SCTPErrorCauseCode = 5
+	SCTPCauseUnrecognizedChunk      SCTPErrorCauseCode = 6
+	SCTPCauseInvalidParameter       SCTPErrorCauseCode = 7
+	SCTPCauseUnrecognizedParameters SCTPErrorCauseCode = 8
+	SCTPCauseNoUserData             SCTPErrorCauseCode = 9
+	SCTPCauseCookieWhileShutdown    SCTPErrorCauseCode = 10
+	SCTPCauseRestartWithNewAddress  SCTPErrorCauseCode = 11
+	SCTPCauseUserInitiatedAbort     SCTPErrorCauseCode = 12
+	SCTPCauseProtocolViolation      SCTPErrorCauseCode = 13
+)
+
+// SCTPFields contains the fields of an SCTP common header. It is used to
+// describe the fields of a packet that needs to be encoded.
+type SCTPFields struct {
+	// SrcPort is the "source port" field of an SCTP packet.
+	SrcPort uint16
+
+	// DstPort is the "destination port" field of an SCTP packet.
+	DstPort uint16
+
+	// VerificationTag is the "verification tag" field of an SCTP packet.
+	VerificationTag uint32
+}
+
+// SCTP represents an SCTP packet stored in a byte array: the common header
+// followed by the chunks.
+type SCTP []byte
+
+// SourcePort returns the "source port" field of the SCTP packet.
+func (b SCTP) SourcePort() uint16 {
+	return binary.BigEndian.Uint16(b[sctpSrcPort:])
+}
+
+// DestinationPort returns the "destination port" field of the SCTP packet.
+func (b SCTP) DestinationPort() uint16 {
+	return binary.BigEndian.Uint16(b[sctpDstPort:])
+}
+
+// VerificationTag returns the "verification tag" field of the SCTP packet.
+func (b SCTP) VerificationTag() uint32 {
+	return binary.BigEndian.Uint32(b[sctpVerificationTag:])
+}
+
+// Checksum returns the "checksum" field of the SCTP packet.
+func (b SCTP) Checksum() uint32 {
+	// The CRC32c is transmitted in the reflected bit order it is computed
+	// in, as per RFC 4960 appendix B.
+	return binary.LittleEndian.Uint32(b[sctpChecksum:])
+}
+
+// SetChecksum sets the "checksum" field of the SCTP packet.
+func (b SCTP) SetChecksum(checksum uint32) {
+	binary.LittleEndian.PutUint32(b[sctpChecksum:], checksum)
+}
+
+// CalculateChecksum calculates the CRC32c checksum of the SCTP packet, as
+// per RFC 4960 section 6.8.
+func (b SCTP) CalculateChecksum() uint32 {
+	var zero [4]byte
+	crc := crc32.Update(0, crc32cTable, b[:sctpChecksum])
+	crc = crc32.Update(crc, crc32cTable, zero[:])
+	return crc32.Update(crc, crc32cTable, b[SCTPMinimumSize:])
+}
+
+// IsChecksumValid returns true if the checksum of the SCTP packet is valid.
+func (b SCTP) IsChecksumValid() bool {
+	return b.Checksum() == b.CalculateChecksum()
+}
+
+// Chunks returns the chunks of the SCTP packet.
+func (b SCTP) Chunks() []byte {
+	return b[SCTPMinimumSize:]
+}
+
+// Encode encodes all the fields of the SCTP common header but the checksum.
+func (b SCTP) Encode(f *SCTPFields) {
+	binary.BigEndian.PutUint16(b[sctpSrcPort:], f.SrcPort)
+	binary.BigEndian.PutUint16(b[sctpDstPort:], f.DstPort)
+	binary.BigEndian.PutUint32(b[sctpVerificationTag:], f.VerificationTag)
+	b.SetChecksum(0)
+}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// SCTPPaddedLength returns n rounded up to a multiple of 4 bytes, the
+// alignment of chunks and parameters.
+func SCTPPaddedLength(n int) int {
+	return (n + 3) &^ 3
+}
+
+// SCTPChunk is a single SCTP chunk, whose length is the value of its length
+// field, without padding.
+type SCTPChunk []byte
+
+// Type returns the type of the chunk.
+func (c SCTPChunk) Type() SCTPChunkType {
+	return SCTPChunkType(c[0])
+}
+
+// Flags returns the flags of the chunk.
+func (c SCTPChunk) Flags() uint8 {
+	return c[1]
+}
+
+// Length returns the length field of the chunk.
+func (c SCTPChunk) Length() uint16 {
+	return binary.BigEndian.Uint16(c[2:])
+}
+
+// Value returns the value of the chunk.
+func (c SCTPChunk) Value() []byte {
+	return c[SCTPChunkHeaderLength:]
+}
+
+// IsValid returns true if the chunk is long enough for its type.
+func (c SCTPChunk) IsValid() bool {
+	switch c.Type() {
+	case SCTPChunkData:
+		// DATA chunks without user data are invalid, as per RFC 4960
+		// section 6.2.
+		return len(c) > SCTPDataChunkHeaderLength
+	case SCTPChunkInit, SCTPChunkInitAck:
+		return len(c) >= SCTPInitChunkMinimumLength
+	case SCTPChunkSack:
+		if len(c) < SCTPSackChunkMinimumLength {
+			return false
+		}
+		s := SCTPSackChunk(c)
+		return len(c) >= SCTPSackChunkMinimumLength+4*(int(s.numGapAckBlocks())+int(s.numDuplicateTSNs()))
+	case SCTPChunkShutdown:
+		return len(c) >= SCTPShutdownChunkLength
+	case SCTPChunkHeartbeat, SCTPChunkHeartbeatAck:
+		_, ok := SCTPHeartbeatChunk(c).Info()
+		return ok
+	default:
+		return true
+	}
+}
+
+// ParseSCTPChunks returns the chunks of b, the chunks of an SCTP packet. It
+// returns false if the chunks are malformed.
+func ParseSCTPChunks(b []byte) ([]SCTPChunk, bool) {
+	var chunks []SCTPChunk
+	for len(b) > 0 {
+		if len(b) < SCTPChunkHeaderLength {
+			return nil, false
+		}
+		l := int(binary.BigEndian.Uint16(b[2:]))
+		if l < SCTPChunkHeaderLength || l > len(b) {
+			return nil, false
+		}
+		chunks = append(chunks, SCTPChunk(b[:l]))
+		if p := SCTPPaddedLength(l); p < len(b) {
+			b = b[p:]
+		} else {
+			b = nil
+		}
+	}
+	return chunks, true
+}
+
+// SCTPChunkSize returns the size of a chunk whose value is valueLen bytes
+// long, including its padding.
+func SCTPChunkSize(valueLen int) int {
+	return SCTPPaddedLength(SCTPChunkHeaderLength + valueLen)
+}
+
+// EncodeSCTPChunk encodes a chunk with the given value to b, which must be at
+// least SCTPChunkSize(len(value)) bytes long, and returns the number of bytes
+// written, including the padding.
+func EncodeSCTPChunk(b []byte, typ SCTPChunkType, flags uint8, value []byte) int {
+	n := encodeSCTPChunkHeader(b, typ, flags, SCTPChunkHeaderLength+len(value))
+	copy(b[SCTPChunkHeaderLength:], value)
+	return n
+}
+
+// encodeSCTPChunkHeader encodes the header of a chunk of the given length,
+// zeroes its padding, and returns its padded length.
+func encodeSCTPChunkHeader(b []byte, typ SCTPChunkType, flags uint8, length int) int {
+	b[0] = uint8(typ)
+	b[1] = flags
+	binary.BigEndian.PutUint16(b[2:], uint16(length))
+	p := SCTPPaddedLength(length)
+	for i := length; i < p; i++ {
+		b[i] = 0
+	}
+	return p
+}
+
+// SCTPDataFields contains the fields of a DATA chunk.
+type SCTPDataFields struct {
+	// Flags are the SCTPDataFlag* flags of the chunk.
+	Flags uint8
+
+	// TSN is the transmission sequence number of the chunk.
+	TSN uint32
+
+	// StreamID is the identifier of the stream of the chunk.
+	StreamID uint16
+
+	// StreamSequence is the stream sequence number of the message of the
+	// chunk, which is ignored for unordered messages.
+	StreamSequence uint16
+
+	// PayloadProtocol is the payload protocol identifier of the message of
+	// the chunk, which is opaque to SCTP.
+	PayloadProtocol uint32
+}
+
+// SCTPDataChunk is a DATA chunk.
+type SCTPDataChunk SCTPChunk
+
+// TSN returns the transmission sequence number of the chunk.
+func (c SCTPDataChunk) TSN() uint32 {
+	return binary.BigEndian.Uint32(c[4:])
+}
+
+// StreamID returns the stream identifier of the chunk.
+func (c SCTPDataChunk) StreamID() uint16 {
+	return binary.BigEndian.Uint16(c[8:])
+}
+
+// StreamSequence returns the stream sequence number of the chunk.
+func (c SCTPDataChunk) StreamSequence() uint16 {
+	return binary.BigEndian.Uint16(c[10:])
+}
+
+// PayloadProtocol returns the payload protocol identifier of the chunk.
+func (c SCTPDataChunk) PayloadProtocol() uint32 {
+	return binary.BigEndian.Uint32(c[12:])
+}
+
+// UserData returns the user data of the chunk.
+func (c SCTPDataChunk) UserData() []byte {
+	return c[SCTPDataChunkHeaderLength:]
+}
+
+// SCTPDataChunkSize returns the size of a DATA chunk carrying dataLen bytes
+// of user data, including its padding.
+func SCTPDataChunkSize(dataLen int) int {
+	return SCTPPaddedLength(SCTPDataChunkHeaderLength + dataLen)
+}
+
+// EncodeSCTPDataChunk encodes a DATA chunk to b, which must be at least
+// SCTPDataChunkSize(len(data)) bytes long, and returns its padded length.
+func EncodeSCTPDataChunk(b []byte, f SCTPDataFields, data []byte) int {
+	n := encodeSCTPChunkHeader(b, SCTPChunkData, f.Flags, SCTPDataChunkHeaderLength+len(data))
+	binary.BigEndian.PutUint32(b[4:], f.TSN)
+	binary.BigEndian.PutUint16(b[8:], f.StreamID)
+	binary.BigEndian.PutUint16(b[10:], f.StreamSequence)
+	binary.BigEndian.PutUint32(b[12:], f.PayloadProtocol)
+	copy(b[SCTPDataChunkHeaderLength:], data)
+	return n
+}
+
+// SCTPParameter is a parameter, whose length is the value of its length field,
+// without padding. Error causes have the same format.
+type SCTPParameter []byte
+
+// Type returns the type of the parameter.
+func (p SCTPParameter) Type() SCTPParameterType {
+	return SCTPParameterType(binary.BigEndian.Uint16(p))
+}
+
+// Value returns the value of the parameter.
+func (p SCTPParameter) Value() []byte {
+	return p[SCTPParameterHeaderLength:]
+}
+
+// ParseSCTPParameters returns the parameters of b. It returns false if the
+// parameters are malformed.
+func ParseSCTPParameters(b []byte) ([]SCTPParameter, bool) {
+	var params []SCTPParameter
+	for len(b) > 0 {
+		if len(b) < SCTPParameterHeaderLength {
+			return nil, false
+		}
+		l := int(binary.BigEndian.Uint16(b[2:]))
+		if l < SCTPParameterHeaderLength || l > len(b) {
+			return nil, false
+		}
+		params = append(params, SCTPParameter(b[:l]))
+		if p := SCTPPaddedLength(l); p < len(b) {
+			b = b[p:]
+		} else {
+			b = nil
+		}
+	}
+	return params, true
+}
+
+// SCTPParameterSize returns the size of a parameter whose value is valueLen
+// bytes long, including its padding.
+func SCTPParameterSize(valueLen int) int {
+	return SCTPPaddedLength(SCTPParameterHeaderLength + valueLen)
+}
+
+// EncodeSCTPParameter encodes a parameter to b, which must be at least
+// SCTPParameterSize(len(value)) bytes long, and returns its padded length.
+func EncodeSCTPParameter(b []byte, typ SCTPParameterType, value []byte) int {
+	l := SCTPParameterHeaderLength + len(value)
+	binary.BigEndian.PutUint16(b, uint16(typ))
+	binary.BigEndian.PutUint16(b[2:], uint16(l))
+	copy(b[SCTPParameterHeaderLength:], value)
+	p := SCTPPaddedLength(l)
+	for i := l; i < p; i++ {
+		b[i] = 0
+	}
+	return p
+}
+
+// SCTPInitFields contains the fields of an INIT or INIT ACK chunk.
+type SCTPInitFields struct {
+	// InitiateTag is the verification tag of the packets sent to the
+	// sender of the chunk.
+	InitiateTag uint32
+
+	// AdvertisedReceiverWindow is the receive window of the sender of the
+	// chunk, in bytes.
+	AdvertisedReceiverWindow uint32
+
+	// OutboundStreams is the number of streams the sender of the chunk
+	// wishes to send on.
+	OutboundStreams uint16
+
+	// InboundStreams is the maximum number of streams the sender of the
+	// chunk can receive on.
+	InboundStreams uint16
+
+	// InitialTSN is the first TSN the sender of the chunk will use.
+	InitialTSN uint32
+
+	// Addresses are the addresses of the sender of the chunk, besides the
+	// source address of its packet.
+	Addresses []tcpip.Address
+
+	// SupportedAddressTypes are the types of the addresses the sender of
+	// an INIT chunk supports, if not empty.
+	SupportedAddressTypes []SCTPParameterType
+
+	// StateCookie is the state cookie of an INIT ACK chunk.
+	StateCookie []byte
+}
+
+// SCTPInitChunk is an INIT or INIT ACK chunk.
+type SCTPInitChunk SCTPChunk
+
+// InitiateTag returns the initiate tag of the chunk.
+func (c SCTPInitChunk) InitiateTag() uint32 {
+	return binary.BigEndian.Uint32(c[4:])
+}
+
+// AdvertisedReceiverWindow returns the advertised receiver window credit of
+// the chunk.
+func (c SCTPInitChunk) AdvertisedReceiverWindow() uint32 {
+	return binary.BigEndian.Uint32(c[8:])
+}
+
+// OutboundStreams returns the number of outbound streams of the chunk.
+func (c SCTPInitChunk) OutboundStreams() uint16 {
+	return binary.BigEndian.Uint16(c[12:])
+}
+
+// InboundStreams returns the maximum number of inbound streams of the chunk.
+func (c SCTPInitChunk) InboundStreams() uint16 {
+	return binary.BigEndian.Uint16(c[14:])
+}
+
+// InitialTSN returns the initial TSN of the chunk.
+func (c SCTPInitChunk) InitialTSN() uint32 {
+	return binary.BigEndian.Uint32(c[16:])
+}
+
+// Parameters returns the variable-length parameters of the chunk.
+func (c SCTPInitChunk) Parameters() []byte {
+	return c[SCTPInitChunkMinimumLength:]
+}
+
+// Fields returns the fields of the chunk. It returns false if its parameters
+// are malformed. Unknown parameters are ignored.
+func (c SCTPInitChunk) Fields() (SCTPInitFields, bool) {
+	f := SCTPInitFields{
+		InitiateTag:              c.InitiateTag(),
+		AdvertisedReceiverWindow: c.AdvertisedReceiverWindow(),
+		OutboundStreams:          c.OutboundStreams(),
+		InboundStreams:           c.InboundStreams(),
+		InitialTSN:               c.InitialTSN(),
+	}
+	params, ok := ParseSCTPParameters(c.Parameters())
+	if !ok {
+		return SCTPInitFields{}, false
+	}
+	for _, p := range params {
+		v := p.Value()
+		switch p.Type() {
+		case SCTPParameterIPv4Address:
+			if len(v) != IPv4AddressSize {
+				return SCTPInitFields{}, false
+			}
+			f.Addresses = append(f.Addresses, tcpip.Address(v))
+		case SCTPParameterIPv6Address:
+			if len(v) != IPv6AddressSize {
+				return SCTPInitFields{}, false
+			}
+			f.Addresses = append(f.Addresses, tcpip.Address(v))
+		case SCTPParameterSupportedAddressTypes:
+			for i := 0; i+1 < len(v); i += 2 {
+				f.SupportedAddressTypes = append(f.SupportedAddressTypes, SCTPParameterType(binary.BigEndian.Uint16(v[i:])))
+			}
+		case SCTPParameterStateCookie:
+			f.StateCookie = v
+		}
+	}
+	return f, true
+}
+
+// SCTPInitChunkSize returns the size of an INIT or INIT ACK chunk with the
+// given fields.
+func SCTPInitChunkSize(f SCTPInitFields) int {
+	n := SCTPInitChunkMinimumLength
+	for _, addr := range f.Addresses {
+		n += SCTPParameterSize(len(addr))
+	}
+	if len(f.SupportedAddressTypes) != 0 {
+		n += SCTPParameterSize(2 * len(f.SupportedAddressTypes))
+	}
+	if f.StateCookie != nil {
+		n += SCTPParameterSize(len(f.StateCookie))
+	}
+	return n
+}
+
+// EncodeSCTPInitChunk encodes an INIT or INIT ACK chunk to b, which must be at
+// least SCTPInitChunkSize(f) bytes long, and returns its length.
+func EncodeSCTPInitChunk(b []byte, typ SCTPChunkType, f SCTPInitFields) int {
+	binary.BigEndian.PutUint32(b[4:], f.InitiateTag)
+	binary.BigEndian.PutUint32(b[8:], f.AdvertisedReceiverWindow)
+	binary.BigEndian.PutUint16(b[12:], f.OutboundStreams)
+	binary.BigEndian.PutUint16(b[14:], f.InboundStreams)
+	binary.BigEndian.PutUint32(b[16:], f.InitialTSN)
+	n := SCTPInitChunkMinimumLength
+	for _, addr := range f.Addresses {
+		typ := SCTPParameterIPv4Address
+		if len(addr) == IPv6AddressSize {
+			typ = SCTPParameterIPv6Address
+		}
+		n += EncodeSCTPParameter(b[n:], typ, []byte(addr))
+	}
+	if len(f.SupportedAddressTypes) != 0 {
+		v := make([]byte, 2*len(f.SupportedAddressTypes))
+		for i, t := range f.SupportedAddressTypes {
+			binary.BigEndian.PutUint16(v[2*i:], uint16(t))
+		}
+		n += EncodeSCTPParameter(b[n:], SCTPParameterSupportedAddressTypes, v)
+	}
+	if f.StateCookie != nil {
+		n += EncodeSCTPParameter(b[n:], SCTPParameterStateCookie, f.StateCookie)
+	}
+	// All the parameters are padded, so the length of the chunk is a
+	// multiple of 4 bytes.
+	encodeSCTPChunkHeader(b, typ, 0, n)
+	return n
+}
+
+// SCTPGapAckBlock is a gap ack block of a SACK chunk. Its bounds are offsets
+// from the cumulative TSN ack of the chunk.
+type SCTPGapAckBlock struct {
+	Start uint16
+	End   uint16
+}
+
+// SCTPSackFields contains the fields of a SACK chunk.
+type SCTPSackFields struct {
+	// CumulativeTSNAck is the TSN of the last DATA chunk received in
+	// sequence.
+	CumulativeTSNAck uint32
+
+	// AdvertisedReceiverWindow is the receive window of the sender of the
+	// chunk, in bytes.
+	AdvertisedReceiverWindow uint32
+
+	// GapAckBlocks are the blocks of DATA chunks received after a gap.
+	GapAckBlocks []SCTPGapAckBlock
+
+	// DuplicateTSNs are the TSNs of the duplicate DATA chunks received
+	// since the previous SACK.
+	DuplicateTSNs []uint32
+}
+
+// SCTPSackChunk is a SACK chunk.
+type SCTPSackChunk SCTPChunk
+
+// CumulativeTSNAck returns the cumulative TSN ack of the chunk.
+func (c SCTPSackChunk) CumulativeTSNAck() uint32 {
+	return binary.BigEndian.Uint32(c[4:])
+}
+
+// AdvertisedReceiverWindow returns the advertised receiver window credit of
+// the chunk.
+func (c SCTPSackChunk) AdvertisedReceiverWindow() uint32 {
+	return binary.BigEndian.Uint32(c[8:])
+}
+
+func (c SCTPSackChunk) numGapAckBlocks() uint16 {
+	return binary.BigEndian.Uint16(c[12:])
+}
+
+func (c SCTPSackChunk) numDuplicateTSNs() uint16 {
+	return binary.BigEndian.Uint16(c[14:])
+}
+
+// GapAckBlocks returns the gap ack blocks of the chunk.
+func (c SCTPSackChunk) GapAckBlocks() []SCTPGapAckBlock {
+	n := int(c.numGapAckBlocks())
+	blocks := make([]SCTPGapAckBlock, n)
+	for i := range blocks {
+		off := SCTPSackChunkMinimumLength + 4*i
+		blocks[i] = SCTPGapAckBlock{
+			Start: binary.BigEndian.Uint16(c[off:]),
+			End:   binary.BigEndian.Uint16(c[off+2:]),
+		}
+	}
+	return blocks
+}
+
+// DuplicateTSNs returns the duplicate TSNs of the chunk.
+func (c SCTPSackChunk) DuplicateTSNs() []uint32 {
+	n := int(c.numDuplicateTSNs())
+	tsns := make([]uint32, n)
+	off := SCTPSackChunkMinimumLength + 4*int(c.numGapAckBlocks())
+	for i := range tsns {
+		tsns[i] = binary.BigEndian.Uint32(c[off+4*i:])
+	}
+	return tsns
+}
+
+// SCTPSackChunkSize returns the size of a SACK chunk with the given fields.
+func SCTPSackChunkSize(f SCTPSackFields) int {
+	return SCTPSackChunkMinimumLength + 4*(len(f.GapAckBlocks)+len(f.DuplicateTSNs))
+}
+
+// EncodeSCTPSackChunk encodes a SACK chunk to b, which must be at least
+// SCTPSackChunkSize(f) bytes long, and returns its length.
+func EncodeSCTPSackChunk(b []byte, f SCTPSackFields) int {
+	n := SCTPSackChunkSize(f)
+	encodeSCTPChunkHeader(b, SCTPChunkSack, 0, n)
+	binary.BigEndian.PutUint32(b[4:], f.CumulativeTSNAck)
+	binary.BigEndian.PutUint32(b[8:], f.AdvertisedReceiverWindow)
+	binary.BigEndian.PutUint16(b[12:], uint16(len(f.GapAckBlocks)))
+	binary.BigEndian.PutUint16(b[14:], uint16(len(f.DuplicateTSNs)))
+	off := SCTPSackChunkMinimumLength
+	for _, blk := range f.GapAckBlocks {
+		binary.BigEndian.PutUint16(b[off:], blk.Start)
+		binary.BigEndian.PutUint16(b[off+2:], blk.End)
+		off += 4
+	}
+	for _, tsn := range f.DuplicateTSNs {
+		binary.BigEndian.PutUint32(b[off:], tsn)
+		off += 4
+	}
+	return n
+}
+
+// SCTPShutdownChunk is a SHUTDOWN chunk.
+type SCTPShutdownChunk SCTPChunk
+
+// CumulativeTSNAck returns the cumulative TSN ack of the chunk.
+func (c SCTPShutdownChunk) CumulativeTSNAck() uint32 {
+	return binary.BigEndian.Uint32(c[4:])
+}
+
+// EncodeSCTPShutdownChunk encodes a SHUTDOWN chunk to b, which must be at least
+// SCTPShutdownChunkLength bytes long, and returns its length.
+func EncodeSCTPShutdownChunk(b []byte, cumulativeTSNAck uint32) int {
+	encodeSCTPChunkHeader(b, SCTPChunkShutdown, 0, SCTPShutdownChunkLength)
+	binary.BigEndian.PutUint32(b[4:], cumulativeTSNAck)
+	return SCTPShutdownChunkLength
+}
+
+// SCTPHeartbeatChunk is a HEARTBEAT or HEARTBEAT ACK chunk.
+type SCTPHeartbeatChunk SCTPChunk
+
+// Info returns the heartbeat information of the chunk. It returns false if
+// the chunk doesn't carry a heartbeat information parameter.
+func (c SCTPHeartbeatChunk) Info() ([]byte, bool) {
+	params, ok := ParseSCTPParameters(SCTPChunk(c).Value())
+	if !ok || len(params) == 0 || params[0].Type() != SCTPParameterHeartbeatInfo {
+		return nil, false
+	}
+	return params[0].Value(), true
+}
+
+// SCTPHeartbeatChunkSize returns the size of a HEARTBEAT or HEARTBEAT ACK chunk
+// carrying infoLen bytes of heartbeat information.
+func SCTPHeartbeatChunkSize(infoLen int) int {
+	return SCTPChunkHeaderLength + SCTPParameterSize(infoLen)
+}
+
+// EncodeSCTPHeartbeatChunk encodes a HEARTBEAT or HEARTBEAT ACK chunk to b,
+// which must be at least SCTPHeartbeatChunkSize(len(info)) bytes long, and
+// returns its padded length.
+func EncodeSCTPHeartbeatChunk(b []byte, typ SCTPChunkType, info []byte) int {
+	encodeSCTPChunkHeader(b, typ, 0, SCTPChunkHeaderLength+SCTPParameterHeaderLength+len(info))
+	EncodeSCTPParameter(b[SCTPChunkHeaderLength:], SCTPParameterHeartbeatInfo, info)
+	return SCTPHeartbeatChunkSize(len(info))
+}
+
+// SCTPErrorCauses returns the error causes of an ABORT or ERROR chunk. It
+// returns false if they are malformed.
+func SCTPErrorCauses(c SCTPChunk) ([]SCTPParameter, bool) {
+	return ParseSCTPParameters(c.Value())
+}
+
+// EncodeSCTPErrorCause encodes an error cause to b, which must be at least
+// SCTPParameterSize(len(info)) bytes long, and returns its padded length.
+func EncodeSCTPErrorCause(b []byte, code SCTPErrorCauseCode, info []byte) int {
+	return EncodeSCTPParameter(b, SCTPParameterType(code), info)
+}
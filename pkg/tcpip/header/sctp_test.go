@@ -0,0 +1,226 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func TestSCTPChecksum(t *testing.T) {
+	// An SCTP packet with a single COOKIE ACK chunk.
+	b := header.SCTP(make([]byte, header.SCTPMinimumSize+header.SCTPChunkSize(0)))
+	b.Encode(&header.SCTPFields{
+		SrcPort:         1,
+		DstPort:         2,
+		VerificationTag: 0x01020304,
+	})
+	header.EncodeSCTPChunk(b.Chunks(), header.SCTPChunkCookieAck, 0, nil)
+	if b.IsChecksumValid() {
+		t.Fatalf("got IsChecksumValid() = true for a zero checksum")
+	}
+	b.SetChecksum(b.CalculateChecksum())
+	if !b.IsChecksumValid() {
+		t.Fatalf("got IsChecksumValid() = false after SetChecksum(CalculateChecksum())")
+	}
+	// Any change to the packet must invalidate the checksum.
+	b[header.SCTPMinimumSize+1] ^= 1
+	if b.IsChecksumValid() {
+		t.Errorf("got IsChecksumValid() = true after modifying the packet")
+	}
+}
+
+func TestSCTPCommonHeader(t *testing.T) {
+	b := header.SCTP(make([]byte, header.SCTPMinimumSize))
+	b.Encode(&header.SCTPFields{
+		SrcPort:         0x1234,
+		DstPort:         0x5678,
+		VerificationTag: 0x9abcdef0,
+	})
+	if got, want := b.SourcePort(), uint16(0x1234); got != want {
+		t.Errorf("got SourcePort() = %#x, want = %#x", got, want)
+	}
+	if got, want := b.DestinationPort(), uint16(0x5678); got != want {
+		t.Errorf("got DestinationPort() = %#x, want = %#x", got, want)
+	}
+	if got, want := b.VerificationTag(), uint32(0x9abcdef0); got != want {
+		t.Errorf("got VerificationTag() = %#x, want = %#x", got, want)
+	}
+}
+
+func TestSCTPParseChunks(t *testing.T) {
+	data := []byte("hello")
+	b := make([]byte, header.SCTPDataChunkSize(len(data))+header.SCTPShutdownChunkLength+header.SCTPChunkSize(0))
+	n := header.EncodeSCTPDataChunk(b, header.SCTPDataFields{
+		Flags:           header.SCTPDataFlagBeginning | header.SCTPDataFlagEnd,
+		TSN:             10,
+		StreamID:        2,
+		StreamSequence:  3,
+		PayloadProtocol: 4,
+	}, data)
+	if n != header.SCTPDataChunkSize(len(data)) || n%4 != 0 {
+		t.Fatalf("got EncodeSCTPDataChunk(...) = %d, want = %d", n, header.SCTPDataChunkSize(len(data)))
+	}
+	n += header.EncodeSCTPShutdownChunk(b[n:], 9)
+	n += header.EncodeSCTPChunk(b[n:], header.SCTPChunkShutdownAck, 0, nil)
+
+	chunks, ok := header.ParseSCTPChunks(b[:n])
+	if !ok {
+		t.Fatalf("ParseSCTPChunks(_) failed")
+	}
+	var types []header.SCTPChunkType
+	for _, c := range chunks {
+		if !c.IsValid() {
+			t.Errorf("chunk %d is invalid", c.Type())
+		}
+		types = append(types, c.Type())
+	}
+	if diff := cmp.Diff([]header.SCTPChunkType{header.SCTPChunkData, header.SCTPChunkShutdown, header.SCTPChunkShutdownAck}, types); diff != "" {
+		t.Fatalf("chunk types mismatch (-want +got):\n%s", diff)
+	}
+
+	d := header.SCTPDataChunk(chunks[0])
+	if got, want := chunks[0].Flags(), uint8(header.SCTPDataFlagBeginning|header.SCTPDataFlagEnd); got != want {
+		t.Errorf("got Flags() = %#x, want = %#x", got, want)
+	}
+	if d.TSN() != 10 || d.StreamID() != 2 || d.StreamSequence() != 3 || d.PayloadProtocol() != 4 {
+		t.Errorf("got DATA chunk (TSN, stream, SSN, PPID) = (%d, %d, %d, %d), want = (10, 2, 3, 4)", d.TSN(), d.StreamID(), d.StreamSequence(), d.PayloadProtocol())
+	}
+	if !bytes.Equal(d.UserData(), data) {
+		t.Errorf("got UserData() = %q, want = %q", d.UserData(), data)
+	}
+	if got := header.SCTPShutdownChunk(chunks[1]).CumulativeTSNAck(); got != 9 {
+		t.Errorf("got CumulativeTSNAck() = %d, want = 9", got)
+	}
+}
+
+func TestSCTPParseMalformedChunks(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{
+			name: "truncated header",
+			b:    []byte{1, 0, 0},
+		},
+		{
+			name: "length too small",
+			b:    []byte{1, 0, 0, 3},
+		},
+		{
+			name: "length too large",
+			b:    []byte{1, 0, 0, 8, 0, 0, 0},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, ok := header.ParseSCTPChunks(test.b); ok {
+				t.Errorf("got ParseSCTPChunks(%x) succeeded, want failure", test.b)
+			}
+		})
+	}
+
+	// A DATA chunk without user data is invalid.
+	b := make([]byte, header.SCTPDataChunkSize(0))
+	header.EncodeSCTPDataChunk(b, header.SCTPDataFields{}, nil)
+	if header.SCTPChunk(b).IsValid() {
+		t.Errorf("got IsValid() = true for a DATA chunk without user data")
+	}
+}
+
+func TestSCTPInitChunk(t *testing.T) {
+	want := header.SCTPInitFields{
+		InitiateTag:              0x01020304,
+		AdvertisedReceiverWindow: 65536,
+		OutboundStreams:          10,
+		InboundStreams:           20,
+		InitialTSN:               0x0a0b0c0d,
+		Addresses: []tcpip.Address{
+			"\x0a\x00\x00\x01",
+			"\xfe\x80\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01",
+		},
+		SupportedAddressTypes: []header.SCTPParameterType{header.SCTPParameterIPv4Address, header.SCTPParameterIPv6Address},
+		StateCookie:           []byte("cookie"),
+	}
+	b := make([]byte, header.SCTPInitChunkSize(want))
+	if n := header.EncodeSCTPInitChunk(b, header.SCTPChunkInitAck, want); n != len(b) {
+		t.Fatalf("got EncodeSCTPInitChunk(...) = %d, want = %d", n, len(b))
+	}
+	chunks, ok := header.ParseSCTPChunks(b)
+	if !ok || len(chunks) != 1 {
+		t.Fatalf("got ParseSCTPChunks(_) = (%d chunks, %t), want = (1 chunk, true)", len(chunks), ok)
+	}
+	if got := chunks[0].Type(); got != header.SCTPChunkInitAck {
+		t.Errorf("got Type() = %d, want = %d", got, header.SCTPChunkInitAck)
+	}
+	got, ok := header.SCTPInitChunk(chunks[0]).Fields()
+	if !ok {
+		t.Fatalf("Fields() failed")
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("INIT ACK fields mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSCTPSackChunk(t *testing.T) {
+	want := header.SCTPSackFields{
+		CumulativeTSNAck:         100,
+		AdvertisedReceiverWindow: 4096,
+		GapAckBlocks:             []header.SCTPGapAckBlock{{Start: 2, End: 3}, {Start: 5, End: 5}},
+		DuplicateTSNs:            []uint32{99},
+	}
+	b := make([]byte, header.SCTPSackChunkSize(want))
+	header.EncodeSCTPSackChunk(b, want)
+	c := header.SCTPChunk(b)
+	if !c.IsValid() {
+		t.Fatalf("got IsValid() = false")
+	}
+	s := header.SCTPSackChunk(c)
+	got := header.SCTPSackFields{
+		CumulativeTSNAck:         s.CumulativeTSNAck(),
+		AdvertisedReceiverWindow: s.AdvertisedReceiverWindow(),
+		GapAckBlocks:             s.GapAckBlocks(),
+		DuplicateTSNs:            s.DuplicateTSNs(),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("SACK fields mismatch (-want +got):\n%s", diff)
+	}
+
+	// A SACK chunk whose counts exceed its length is invalid.
+	if header.SCTPChunk(b[:len(b)-4]).IsValid() {
+		t.Errorf("got IsValid() = true for a truncated SACK chunk")
+	}
+}
+
+func TestSCTPHeartbeatChunk(t *testing.T) {
+	info := []byte("info!")
+	b := make([]byte, header.SCTPHeartbeatChunkSize(len(info)))
+	header.EncodeSCTPHeartbeatChunk(b, header.SCTPChunkHeartbeat, info)
+	chunks, ok := header.ParseSCTPChunks(b)
+	if !ok || len(chunks) != 1 {
+		t.Fatalf("got ParseSCTPChunks(_) = (%d chunks, %t), want = (1 chunk, true)", len(chunks), ok)
+	}
+	got, ok := header.SCTPHeartbeatChunk(chunks[0]).Info()
+	if !ok {
+		t.Fatalf("Info() failed")
+	}
+	if !bytes.Equal(got, info) {
+		t.Errorf("got Info() = %q, want = %q", got, info)
+	}
+}
@@ -15,10 +15,13 @@
 package header
 
 import (
+	"crypto/md5"
 	"encoding/binary"
+	"io"
 
 	"github.com/google/btree"
 	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip/seqnum"
 )
 
@@ -53,8 +56,14 @@ const (
 	TCPFlagPsh
 	TCPFlagAck
 	TCPFlagUrg
+	TCPFlagEce
+	TCPFlagCwr
 )
 
+// tcpFlagAE is the Accurate ECN flag, which is stored in the least significant
+// bit of the data offset byte rather than in the flags field.
+const tcpFlagAE = 1
+
 // Options that may be present in a TCP segment.
 const (
 	TCPOptionEOL           = 0
@@ -64,6 +73,8 @@ const (
 	TCPOptionTS            = 8
 	TCPOptionSACKPermitted = 4
 	TCPOptionSACK          = 5
+	TCPOptionMD5           = 19
+	TCPOptionMPTCP         = 30
 )
 
 // Option Lengths.
@@ -72,6 +83,7 @@ const (
 	TCPOptionTSLength            = 10
 	TCPOptionWSLength            = 3
 	TCPOptionSackPermittedLength = 2
+	TCPOptionMD5Length           = 18
 )
 
 // TCPFields contains the fields of a TCP packet. It is used to describe the
@@ -96,6 +108,9 @@ type TCPFields struct {
 	// Flags is the "flags" field of a TCP packet.
 	Flags uint8
 
+	// AE is the Accurate ECN flag of a TCP packet, as per RFC 9768.
+	AE bool
+
 	// WindowSize is the "window size" field of a TCP packet.
 	WindowSize uint16
 
@@ -238,6 +253,11 @@ func (b TCP) Flags() uint8 {
 	return b[TCPFlagsOffset]
 }
 
+// AE returns true if the Accurate ECN flag of the tcp header is set.
+func (b TCP) AE() bool {
+	return b[TCPDataOffset]&tcpFlagAE != 0
+}
+
 // WindowSize returns the "window size" field of the tcp header.
 func (b TCP) WindowSize() uint16 {
 	return binary.BigEndian.Uint16(b[TCPWinSizeOffset:])
@@ -332,6 +352,9 @@ func (b TCP) Encode(t *TCPFields) {
 	binary.BigEndian.PutUint16(b[TCPSrcPortOffset:], t.SrcPort)
 	binary.BigEndian.PutUint16(b[TCPDstPortOffset:], t.DstPort)
 	b[TCPDataOffset] = (t.DataOffset / 4) << 4
+	if t.AE {
+		b[TCPDataOffset] |= tcpFlagAE
+	}
 	binary.BigEndian.PutUint16(b[TCPChecksumOffset:], t.Checksum)
 	binary.BigEndian.PutUint16(b[TCPUrgentPtrOffset:], t.UrgentPointer)
 }
@@ -600,6 +623,104 @@ func AddTCPOptionPadding(options []byte, offset int) int {
 	return paddingToAdd
 }
 
+// EncodeMD5Option encodes a TCP MD5 signature option with a zero digest,
+// preceded by two NOPs, into b. It returns the number of bytes written, or 0
+// if b is too small. The digest is set with TCPMD5Digest once the segment is
+// built.
+func EncodeMD5Option(b []byte) int {
+	const size = 2 + TCPOptionMD5Length
+	if len(b) < size {
+		return 0
+	}
+	b[0] = TCPOptionNOP
+	b[1] = TCPOptionNOP
+	b[2] = TCPOptionMD5
+	b[3] = TCPOptionMD5Length
+	for i := 4; i < size; i++ {
+		b[i] = 0
+	}
+	return size
+}
+
+// TCPMD5OptionDigest returns the digest of the TCP MD5 signature option in the
+// options b, if any.
+func TCPMD5OptionDigest(b []byte) ([]byte, bool) {
+	i, l, ok := findTCPOption(b, TCPOptionMD5)
+	if !ok || l != TCPOptionMD5Length {
+		return nil, false
+	}
+	return b[i+2 : i+l], true
+}
+
+// findTCPOption returns the offset and the length of the first option of the
+// given kind in the options b, if any.
+func findTCPOption(b []byte, kind byte) (int, int, bool) {
+	for i := 0; i < len(b); {
+		switch b[i] {
+		case TCPOptionEOL:
+			return 0, 0, false
+		case TCPOptionNOP:
+			i++
+			continue
+		}
+		if i+1 >= len(b) {
+			return 0, 0, false
+		}
+		l := int(b[i+1])
+		if l < 2 || i+l > len(b) {
+			return 0, 0, false
+		}
+		if b[i] == kind {
+			return i, l, true
+		}
+		i += l
+	}
+	return 0, 0, false
+}
+
+// writeTCPPseudoHeader writes the pseudo-header of a TCP segment from src to
+// dst of the given length to w, as used by TCP signatures. The pseudo-header
+// is the one used by Linux for each IP version.
+func writeTCPPseudoHeader(w io.Writer, src, dst tcpip.Address, length uint32) {
+	w.Write([]byte(src))
+	w.Write([]byte(dst))
+	if len(src) == IPv4AddressSize {
+		var b [4]byte
+		b[1] = uint8(TCPProtocolNumber)
+		binary.BigEndian.PutUint16(b[2:], uint16(length))
+		w.Write(b[:])
+	} else {
+		var b [8]byte
+		binary.BigEndian.PutUint32(b[0:], length)
+		binary.BigEndian.PutUint32(b[4:], uint32(TCPProtocolNumber))
+		w.Write(b[:])
+	}
+}
+
+// TCPMD5Digest returns the TCP MD5 signature of a segment from src to dst
+// whose header is h and whose payload is payload, with the given key, as per
+// RFC 2385 section 2.0. The options of the segment are not signed.
+func TCPMD5Digest(key []byte, src, dst tcpip.Address, h TCP, payload buffer.VectorisedView) [md5.Size]byte {
+	d := md5.New()
+
+	writeTCPPseudoHeader(d, src, dst, uint32(int(h.DataOffset())+payload.Size()))
+
+	// The header is signed without its options, with a zero checksum.
+	var hdr [TCPMinimumSize]byte
+	copy(hdr[:], h)
+	binary.BigEndian.PutUint16(hdr[TCPChecksumOffset:], 0)
+	d.Write(hdr[:])
+
+	for _, v := range payload.Views() {
+		d.Write(v)
+	}
+	d.Write(key)
+
+	var sum [md5.Size]byte
+	d.Sum(sum[:0])
+	return sum
+}
+
 // Acceptable checks if a segment that starts at segSeq and has length segLen is
 // "acceptable" for arriving in a receive window that starts at rcvNxt and ends
 // before rcvAcc, according to the table on page 26 and 69 of RFC 793.
@@ -0,0 +1,316 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header
+
+import (
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/seqnum"
+)
+
+const (
+	// TCPOptionAO is the kind of the TCP Authentication Option, as per
+	// RFC 5925 section 2.2.
+	TCPOptionAO = 29
+
+	// TCPOptionAOMinimumLength is the length of a TCP Authentication
+	// Option without its MAC.
+	TCPOptionAOMinimumLength = 4
+
+	// TCPAOMaxMACLength is the maximum length of a TCP Authentication
+	// Option MAC that is sent. It leaves enough room for the options of a
+	// SYN segment.
+	TCPAOMaxMACLength = 16
+
+	// TCPAODefaultMACLength is the default length of a TCP Authentication
+	// Option MAC, which is 96 bits as per RFC 5926 section 3.2.
+	TCPAODefaultMACLength = 12
+
+	// tcpAOLabel is the label of the TCP-AO key derivation function, as
+	// per RFC 5926 section 3.1.1.
+	tcpAOLabel = "TCP-AO"
+)
+
+// TCPAOAlgorithm is a MAC algorithm of the TCP Authentication Option.
+type TCPAOAlgorithm int
+
+// TCP Authentication Option MAC algorithms. HMAC-SHA-1-96 and
+// AES-128-CMAC-96 are required by RFC 5926 section 2.1, HMAC-SHA-256 is
+// supported by Linux.
+const (
+	TCPAOHMACSHA1 TCPAOAlgorithm = iota
+	TCPAOCMACAES128
+	TCPAOHMACSHA256
+)
+
+// tcpAOAlgorithmNames are the names of the TCP-AO MAC algorithms, as used by
+// Linux.
+var tcpAOAlgorithmNames = map[TCPAOAlgorithm]string{
+	TCPAOHMACSHA1:   "hmac(sha1)",
+	TCPAOCMACAES128: "cmac(aes128)",
+	TCPAOHMACSHA256: "hmac(sha256)",
+}
+
+// TCPAOAlgorithmByName returns the TCP-AO MAC algorithm with the given name.
+func TCPAOAlgorithmByName(name string) (TCPAOAlgorithm, bool) {
+	for a, n := range tcpAOAlgorithmNames {
+		if n == name {
+			return a, true
+		}
+	}
+	return 0, false
+}
+
+// String implements fmt.Stringer.
+func (a TCPAOAlgorithm) String() string {
+	return tcpAOAlgorithmNames[a]
+}
+
+// MACSize returns the length of the untruncated MACs of the algorithm.
+func (a TCPAOAlgorithm) MACSize() int {
+	switch a {
+	case TCPAOCMACAES128:
+		return aes.BlockSize
+	case TCPAOHMACSHA256:
+		return sha256.Size
+	default:
+		return sha1.Size
+	}
+}
+
+// new returns a MAC of the algorithm keyed with key.
+func (a TCPAOAlgorithm) new(key []byte) hash.Hash {
+	switch a {
+	case TCPAOCMACAES128:
+		return newCMAC(key)
+	case TCPAOHMACSHA256:
+		return hmac.New(sha256.New, key)
+	default:
+		return hmac.New(sha1.New, key)
+	}
+}
+
+// TCPAOOption is a parsed TCP Authentication Option.
+type TCPAOOption struct {
+	// KeyID is the SendID of the key the segment is authenticated with.
+	KeyID uint8
+
+	// RNextKeyID is the RecvID of the key the sender wants to receive
+	// segments authenticated with.
+	RNextKeyID uint8
+
+	// MAC is the message authentication code of the segment.
+	MAC []byte
+}
+
+// ParseTCPAOOption returns the TCP Authentication Option in the options b, if
+// any.
+func ParseTCPAOOption(b []byte) (TCPAOOption, bool) {
+	i, l, ok := findTCPOption(b, TCPOptionAO)
+	if !ok || l < TCPOptionAOMinimumLength {
+		return TCPAOOption{}, false
+	}
+	return TCPAOOption{
+		KeyID:      b[i+2],
+		RNextKeyID: b[i+3],
+		MAC:        b[i+TCPOptionAOMinimumLength : i+l],
+	}, true
+}
+
+// EncodeTCPAOOption encodes a TCP Authentication Option with a zero MAC of
+// length macLen into b, followed by NOPs up to a 4 byte boundary. It returns
+// the number of bytes written, or 0 if b is too small. The MAC is set with
+// TCPAOMAC once the segment is built.
+func EncodeTCPAOOption(keyID, rnextKeyID uint8, macLen int, b []byte) int {
+	l := TCPOptionAOMinimumLength + macLen
+	size := (l + 3) &^ 3
+	if len(b) < size {
+		return 0
+	}
+	b[0] = TCPOptionAO
+	b[1] = uint8(l)
+	b[2] = keyID
+	b[3] = rnextKeyID
+	for i := TCPOptionAOMinimumLength; i < l; i++ {
+		b[i] = 0
+	}
+	for i := l; i < size; i++ {
+		b[i] = TCPOptionNOP
+	}
+	return size
+}
+
+// TCPAOTrafficKey derives the traffic key used with the segments from src to
+// dst of a connection from the master key, as per RFC 5926 section 3.1.
+// srcISN and dstISN are the initial sequence numbers of each side of the
+// connection; dstISN is zero for SYN segments.
+func TCPAOTrafficKey(alg TCPAOAlgorithm, masterKey []byte, src, dst tcpip.Address, srcPort, dstPort uint16, srcISN, dstISN seqnum.Value) []byte {
+	if alg == TCPAOCMACAES128 && len(masterKey) != aes.BlockSize {
+		// RFC 5926 section 3.1.1.2: master keys of another length are
+		// first turned into a 128 bit key.
+		d := newCMAC(make([]byte, aes.BlockSize))
+		d.Write(masterKey)
+		masterKey = d.Sum(nil)
+	}
+	d := alg.new(masterKey)
+
+	// The key is derived in a single iteration, as the output of the
+	// PRF is as long as the traffic key.
+	d.Write([]byte{1})
+	d.Write([]byte(tcpAOLabel))
+	d.Write([]byte(src))
+	d.Write([]byte(dst))
+	var b [14]byte
+	binary.BigEndian.PutUint16(b[0:], srcPort)
+	binary.BigEndian.PutUint16(b[2:], dstPort)
+	binary.BigEndian.PutUint32(b[4:], uint32(srcISN))
+	binary.BigEndian.PutUint32(b[8:], uint32(dstISN))
+	binary.BigEndian.PutUint16(b[12:], uint16(alg.MACSize()*8))
+	d.Write(b[:])
+	return d.Sum(nil)
+}
+
+// TCPAOMAC returns the MAC of a segment from src to dst whose header,
+// including options, is h and whose payload is payload, truncated to macLen
+// bytes, as per RFC 5925 section 5.1. sne is the sequence number extension of
+// the segment. The header must carry a TCP Authentication Option, whose MAC is
+// ignored.
+func TCPAOMAC(alg TCPAOAlgorithm, trafficKey []byte, sne uint32, src, dst tcpip.Address, h TCP, payload buffer.VectorisedView, macLen int) []byte {
+	d := alg.new(trafficKey)
+
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], sne)
+	d.Write(b[:])
+
+	hdrLen := int(h.DataOffset())
+	writeTCPPseudoHeader(d, src, dst, uint32(hdrLen+payload.Size()))
+
+	// The header is authenticated with a zero checksum and a zero MAC.
+	hdr := append([]byte(nil), h[:hdrLen]...)
+	binary.BigEndian.PutUint16(hdr[TCPChecksumOffset:], 0)
+	if i, l, ok := findTCPOption(hdr[TCPMinimumSize:], TCPOptionAO); ok {
+		i += TCPMinimumSize
+		for j := i + TCPOptionAOMinimumLength; j < i+l; j++ {
+			hdr[j] = 0
+		}
+	}
+	d.Write(hdr)
+
+	for _, v := range payload.Views() {
+		d.Write(v)
+	}
+	sum := d.Sum(nil)
+	if macLen < len(sum) {
+		sum = sum[:macLen]
+	}
+	return sum
+}
+
+// cmac is an AES-CMAC, as per RFC 4493.
+type cmac struct {
+	key []byte
+	msg []byte
+}
+
+var _ hash.Hash = (*cmac)(nil)
+
+func newCMAC(key []byte) *cmac {
+	return &cmac{key: key}
+}
+
+// Write implements hash.Hash.Write.
+func (c *cmac) Write(b []byte) (int, error) {
+	c.msg = append(c.msg, b...)
+	return len(b), nil
+}
+
+// Sum implements hash.Hash.Sum.
+func (c *cmac) Sum(b []byte) []byte {
+	block, err := aes.NewCipher(c.key)
+	if err != nil {
+		panic(err)
+	}
+
+	// Generate the subkeys.
+	var k1, k2 [aes.BlockSize]byte
+	block.Encrypt(k1[:], k1[:])
+	cmacDouble(&k1)
+	k2 = k1
+	cmacDouble(&k2)
+
+	n := (len(c.msg) + aes.BlockSize - 1) / aes.BlockSize
+	last := k1
+	if n == 0 || len(c.msg)%aes.BlockSize != 0 {
+		// The last block is incomplete and gets padded.
+		if n == 0 {
+			n = 1
+		}
+		last = k2
+		var pad [aes.BlockSize]byte
+		r := copy(pad[:], c.msg[(n-1)*aes.BlockSize:])
+		pad[r] = 0x80
+		for i := range last {
+			last[i] ^= pad[i]
+		}
+	} else {
+		for i := range last {
+			last[i] ^= c.msg[(n-1)*aes.BlockSize+i]
+		}
+	}
+
+	var x [aes.BlockSize]byte
+	for i := 0; i < n-1; i++ {
+		for j := range x {
+			x[j] ^= c.msg[i*aes.BlockSize+j]
+		}
+		block.Encrypt(x[:], x[:])
+	}
+	for j := range x {
+		x[j] ^= last[j]
+	}
+	block.Encrypt(x[:], x[:])
+	return append(b, x[:]...)
+}
+
+// cmacDouble multiplies k by x in GF(2^128), as per RFC 4493 section 2.3.
+func cmacDouble(k *[aes.BlockSize]byte) {
+	msb := k[0] >> 7
+	for i := 0; i < len(k)-1; i++ {
+		k[i] = k[i]<<1 | k[i+1]>>7
+	}
+	k[len(k)-1] = k[len(k)-1]<<1 ^ msb*0x87
+}
+
+// Reset implements hash.Hash.Reset.
+func (c *cmac) Reset() {
+	c.msg = c.msg[:0]
+}
+
+// Size implements hash.Hash.Size.
+func (c *cmac) Size() int {
+	return aes.BlockSize
+}
+
+// BlockSize implements hash.Hash.BlockSize.
+func (c *cmac) BlockSize() int {
+	return aes.BlockSize
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+)
+
+// TestCMAC checks the AES-CMAC implementation against the test vectors of
+// RFC 4493 section 4.
+func TestCMAC(t *testing.T) {
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	msg, _ := hex.DecodeString("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710")
+	testCases := []struct {
+		len  int
+		want string
+	}{
+		{len: 0, want: "bb1d6929e95937287fa37d129b756746"},
+		{len: 16, want: "070a16b46b4d4144f79bdd9dd04a287c"},
+		{len: 40, want: "dfa66747de9ae63030ca32611497c827"},
+		{len: 64, want: "51f0bebf7e3b9d92fc49741779363cfe"},
+	}
+	for _, tc := range testCases {
+		c := newCMAC(key)
+		c.Write(msg[:tc.len])
+		if got := hex.EncodeToString(c.Sum(nil)); got != tc.want {
+			t.Errorf("got AES-CMAC of %d bytes = %s, want = %s", tc.len, got, tc.want)
+		}
+	}
+}
+
+func TestTCPAOMAC(t *testing.T) {
+	const (
+		src = tcpip.Address("\x0a\x00\x00\x01")
+		dst = tcpip.Address("\x0a\x00\x00\x02")
+	)
+	for _, alg := range []TCPAOAlgorithm{TCPAOHMACSHA1, TCPAOCMACAES128, TCPAOHMACSHA256} {
+		t.Run(alg.String(), func(t *testing.T) {
+			b := make([]byte, TCPMinimumSize+TCPOptionAOMinimumLength+TCPAODefaultMACLength)
+			h := TCP(b)
+			h.Encode(&TCPFields{
+				SrcPort:    179,
+				DstPort:    40000,
+				SeqNum:     1,
+				AckNum:     2,
+				DataOffset: uint8(len(b)),
+				Flags:      TCPFlagAck,
+				WindowSize: 1000,
+			})
+			if got, want := EncodeTCPAOOption(1, 2, TCPAODefaultMACLength, h.Options()), len(b)-TCPMinimumSize; got != want {
+				t.Fatalf("got EncodeTCPAOOption(...) = %d, want = %d", got, want)
+			}
+			data := buffer.View("hello").ToVectorisedView()
+
+			key := TCPAOTrafficKey(alg, []byte("secret"), src, dst, 179, 40000, 1000, 2000)
+			if got, want := len(key), alg.MACSize(); got != want {
+				t.Fatalf("got len(TCPAOTrafficKey(...)) = %d, want = %d", got, want)
+			}
+			if other := TCPAOTrafficKey(alg, []byte("secret"), dst, src, 40000, 179, 2000, 1000); string(other) == string(key) {
+				t.Fatal("got the same traffic key for both directions")
+			}
+
+			mac := TCPAOMAC(alg, key, 0, src, dst, h, data, TCPAODefaultMACLength)
+			opt, ok := ParseTCPAOOption(h.Options())
+			if !ok {
+				t.Fatal("ParseTCPAOOption(_) didn't find the option")
+			}
+			if opt.KeyID != 1 || opt.RNextKeyID != 2 || len(opt.MAC) != TCPAODefaultMACLength {
+				t.Fatalf("got ParseTCPAOOption(_) = %+v, want KeyID = 1, RNextKeyID = 2 and a %d byte MAC", opt, TCPAODefaultMACLength)
+			}
+			copy(opt.MAC, mac)
+
+			// The MAC of the segment is ignored, but the other
+			// fields are authenticated.
+			if got := TCPAOMAC(alg, key, 0, src, dst, h, data, TCPAODefaultMACLength); string(got) != string(mac) {
+				t.Errorf("got TCPAOMAC(...) = %x after setting the MAC, want = %x", got, mac)
+			}
+			if got := TCPAOMAC(alg, key, 1, src, dst, h, data, TCPAODefaultMACLength); string(got) == string(mac) {
+				t.Error("got the same MAC with another sequence number extension")
+			}
+			h.Options()[3] = 3
+			if got := TCPAOMAC(alg, key, 0, src, dst, h, data, TCPAODefaultMACLength); string(got) == string(mac) {
+				t.Error("got the same MAC with another RNextKeyID")
+			}
+		})
+	}
+}
@@ -15,9 +15,12 @@
 package header_test
 
 import (
+	"encoding/hex"
 	"reflect"
 	"testing"
 
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 )
 
@@ -146,3 +149,65 @@ func TestTCPParseOptions(t *testing.T) {
 		}
 	}
 }
+
+func TestTCPMD5Digest(t *testing.T) {
+	const payload = "hello"
+	b := make([]byte, header.TCPMinimumSize+20)
+	h := header.TCP(b)
+	h.Encode(&header.TCPFields{
+		SrcPort:    179,
+		DstPort:    40000,
+		SeqNum:     1,
+		AckNum:     2,
+		DataOffset: uint8(len(b)),
+		Flags:      header.TCPFlagAck | header.TCPFlagPsh,
+		WindowSize: 1000,
+		Checksum:   0xabcd,
+	})
+	if got, want := header.EncodeMD5Option(h.Options()), 2+header.TCPOptionMD5Length; got != want {
+		t.Fatalf("got EncodeMD5Option(_) = %d, want = %d", got, want)
+	}
+	data := buffer.View(payload).ToVectorisedView()
+
+	testCases := []struct {
+		name     string
+		src, dst tcpip.Address
+		want     string
+	}{
+		{
+			name: "IPv4",
+			src:  "\x0a\x00\x00\x01",
+			dst:  "\x0a\x00\x00\x02",
+			want: "e5a8cbaa541b71771f6ed43808e4c5fd",
+		},
+		{
+			name: "IPv6",
+			src:  "\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f",
+			dst:  "\x10\x11\x12\x13\x14\x15\x16\x17\x18\x19\x1a\x1b\x1c\x1d\x1e\x1f",
+			want: "4e19d13ff0afcc6f358e7c5cf4d9c51e",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sum := header.TCPMD5Digest([]byte("secret"), tc.src, tc.dst, h, data)
+			if got := hex.EncodeToString(sum[:]); got != tc.want {
+				t.Errorf("got TCPMD5Digest(...) = %s, want = %s", got, tc.want)
+			}
+		})
+	}
+
+	digest, ok := header.TCPMD5OptionDigest(h.Options())
+	if !ok {
+		t.Fatal("TCPMD5OptionDigest(_) didn't find the option")
+	}
+	sum := header.TCPMD5Digest([]byte("secret"), testCases[0].src, testCases[0].dst, h, data)
+	copy(digest, sum[:])
+	// The digest doesn't cover the options, so setting it doesn't change
+	// the signature.
+	if got := header.TCPMD5Digest([]byte("secret"), testCases[0].src, testCases[0].dst, h, data); got != sum {
+		t.Errorf("got TCPMD5Digest(...) = %x after setting the digest, want = %x", got, sum)
+	}
+	if got, _ := header.TCPMD5OptionDigest(h.Options()); string(got) != string(sum[:]) {
+		t.Errorf("got TCPMD5OptionDigest(_) = %x, want = %x", got, sum)
+	}
+}
@@ -62,6 +62,11 @@ const (
 
 	// UDPProtocolNumber is UDP's transport protocol number.
 	UDPProtocolNumber tcpip.TransportProtocolNumber = 17
+
+	// UDPLiteProtocolNumber is UDP-Lite's transport protocol number.
+	// UDP-Lite packets have a UDP header whose "length" field holds the
+	// checksum coverage, as per RFC 3828 section 3.1.
+	UDPLiteProtocolNumber tcpip.TransportProtocolNumber = 136
 )
 
 // SourcePort returns the "source port" field of the udp header.
@@ -0,0 +1,103 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header
+
+import (
+	"encoding/binary"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+const (
+	vlanTCI  = 0
+	vlanType = 2
+
+	vlanPriorityShift = 13
+	vlanDEIFlag       = 1 << 12
+	vlanIDMask        = 0xfff
+)
+
+const (
+	// VLANMinimumSize is the size of an 802.1Q tag, following the TPID which
+	// takes the place of the EtherType of the ethernet header.
+	VLANMinimumSize = 4
+
+	// VLANMaximumID is the largest VLAN identifier which may be assigned. 0
+	// identifies priority tagged frames and 4095 is reserved.
+	VLANMaximumID = 4094
+
+	// VLANProtocol8021Q is the TPID of IEEE 802.1Q customer VLAN tags.
+	VLANProtocol8021Q tcpip.NetworkProtocolNumber = 0x8100
+
+	// VLANProtocol8021AD is the TPID of IEEE 802.1ad service VLAN tags, the
+	// outer tags of QinQ frames.
+	VLANProtocol8021AD tcpip.NetworkProtocolNumber = 0x88a8
+)
+
+// VLANFields contains the fields of an 802.1Q tag. It is used to describe the
+// fields of a tag that needs to be encoded.
+type VLANFields struct {
+	// Priority is the "priority code point" field of the tag.
+	Priority uint8
+
+	// DropEligible is the "drop eligible indicator" field of the tag.
+	DropEligible bool
+
+	// ID is the "VLAN identifier" field of the tag.
+	ID uint16
+
+	// Type is the EtherType of the payload of the frame.
+	Type tcpip.NetworkProtocolNumber
+}
+
+// VLAN represents an 802.1Q tag stored in a byte array: the tag control
+// information and the EtherType of the payload which follow the TPID of a
+// tagged ethernet frame.
+type VLAN []byte
+
+// IsVLANProtocol returns true if proto is the TPID of a VLAN tag.
+func IsVLANProtocol(proto tcpip.NetworkProtocolNumber) bool {
+	return proto == VLANProtocol8021Q || proto == VLANProtocol8021AD
+}
+
+// Priority returns the "priority code point" field of the tag.
+func (b VLAN) Priority() uint8 {
+	return uint8(binary.BigEndian.Uint16(b[vlanTCI:]) >> vlanPriorityShift)
+}
+
+// DropEligible returns the "drop eligible indicator" field of the tag.
+func (b VLAN) DropEligible() bool {
+	return binary.BigEndian.Uint16(b[vlanTCI:])&vlanDEIFlag != 0
+}
+
+// ID returns the "VLAN identifier" field of the tag.
+func (b VLAN) ID() uint16 {
+	return binary.BigEndian.Uint16(b[vlanTCI:]) & vlanIDMask
+}
+
+// Type returns the EtherType of the payload of the frame.
+func (b VLAN) Type() tcpip.NetworkProtocolNumber {
+	return tcpip.NetworkProtocolNumber(binary.BigEndian.Uint16(b[vlanType:]))
+}
+
+// Encode encodes all the fields of the tag.
+func (b VLAN) Encode(f *VLANFields) {
+	tci := uint16(f.Priority)<<vlanPriorityShift | f.ID&vlanIDMask
+	if f.DropEligible {
+		tci |= vlanDEIFlag
+	}
+	binary.BigEndian.PutUint16(b[vlanTCI:], tci)
+	binary.BigEndian.PutUint16(b[vlanType:], uint16(f.Type))
+}
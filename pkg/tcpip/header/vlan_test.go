@@ -0,0 +1,48 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header_test
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func TestVLANEncode(t *testing.T) {
+	fields := header.VLANFields{
+		Priority:     5,
+		DropEligible: true,
+		ID:           0x123,
+		Type:         header.IPv6ProtocolNumber,
+	}
+	b := header.VLAN(make([]byte, header.VLANMinimumSize))
+	b.Encode(&fields)
+	if want := []byte{0xb1, 0x23, 0x86, 0xdd}; string(b) != string(want) {
+		t.Fatalf("got encoded VLAN tag = %x, want = %x", []byte(b), want)
+	}
+
+	if got := b.Priority(); got != fields.Priority {
+		t.Errorf("got b.Priority() = %d, want = %d", got, fields.Priority)
+	}
+	if got := b.DropEligible(); got != fields.DropEligible {
+		t.Errorf("got b.DropEligible() = %t, want = %t", got, fields.DropEligible)
+	}
+	if got := b.ID(); got != fields.ID {
+		t.Errorf("got b.ID() = %d, want = %d", got, fields.ID)
+	}
+	if got := b.Type(); got != fields.Type {
+		t.Errorf("got b.Type() = %d, want = %d", got, fields.Type)
+	}
+}
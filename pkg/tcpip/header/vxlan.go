@@ -0,0 +1,65 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header
+
+import "encoding/binary"
+
+const (
+	vxlanFlags = 0
+	vxlanVNI   = 4
+
+	vxlanVNIShift = 8
+)
+
+const (
+	// VXLANSize is the size of a VXLAN header.
+	VXLANSize = 8
+
+	// VXLANPort is the UDP port assigned to VXLAN by IANA.
+	VXLANPort = 4789
+
+	// VXLANFlagVNI is the "I" flag of the VXLAN header, which must be set
+	// for the VNI to be valid.
+	VXLANFlagVNI = 0x08000000
+
+	// VXLANMaximumVNI is the largest VXLAN network identifier.
+	VXLANMaximumVNI = 1<<24 - 1
+)
+
+// VXLAN represents a Virtual eXtensible Local Area Network header stored in a
+// byte array, as per RFC 7348.
+type VXLAN []byte
+
+// Flags returns the flags of the VXLAN header, including its reserved bits.
+func (b VXLAN) Flags() uint32 {
+	return binary.BigEndian.Uint32(b[vxlanFlags:])
+}
+
+// VNI returns the "VXLAN network identifier" field of the VXLAN header.
+func (b VXLAN) VNI() uint32 {
+	return binary.BigEndian.Uint32(b[vxlanVNI:]) >> vxlanVNIShift
+}
+
+// IsValid returns true if b holds a complete VXLAN header with a valid VNI
+// and, like Linux requires, no reserved bit set.
+func (b VXLAN) IsValid() bool {
+	return len(b) >= VXLANSize && b.Flags() == VXLANFlagVNI && b[vxlanVNI+3] == 0
+}
+
+// Encode encodes a VXLAN header carrying the VXLAN network identifier vni.
+func (b VXLAN) Encode(vni uint32) {
+	binary.BigEndian.PutUint32(b[vxlanFlags:], VXLANFlagVNI)
+	binary.BigEndian.PutUint32(b[vxlanVNI:], vni<<vxlanVNIShift)
+}
@@ -0,0 +1,58 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header_test
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func TestVXLANEncode(t *testing.T) {
+	b := header.VXLAN(make([]byte, header.VXLANSize))
+	b.Encode(0x123456)
+	if want := []byte{0x08, 0x00, 0x00, 0x00, 0x12, 0x34, 0x56, 0x00}; string(b) != string(want) {
+		t.Fatalf("got encoded VXLAN header = %x, want = %x", []byte(b), want)
+	}
+	if !b.IsValid() {
+		t.Fatal("got b.IsValid() = false, want = true")
+	}
+	if got, want := b.VNI(), uint32(0x123456); got != want {
+		t.Errorf("got b.VNI() = %#x, want = %#x", got, want)
+	}
+}
+
+func TestVXLANIsValid(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		want bool
+	}{
+		{name: "empty", b: nil, want: false},
+		{name: "valid", b: []byte{0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00}, want: true},
+		{name: "truncated", b: []byte{0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}, want: false},
+		{name: "no VNI flag", b: []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00}, want: false},
+		{name: "reserved flag", b: []byte{0x0c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00}, want: false},
+		{name: "reserved byte", b: []byte{0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x01}, want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := header.VXLAN(test.b).IsValid(); got != test.want {
+				t.Errorf("got IsValid() = %t, want = %t", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,355 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ipv4ll implements dynamic configuration of IPv4 link-local addresses,
+// as per RFC 3927.
+//
+// A Client selects an address in 169.254/16, probes for it through the NIC's
+// ARP Address Conflict Detection, announces it once it is claimed and defends
+// it against conflicting hosts for as long as it runs. Integrators typically
+// run a Client when no other IPv4 configuration (e.g. DHCP) is available; the
+// Client does not install routes for the link-local subnet.
+package ipv4ll
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/network/arp"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// Protocol constants, as per RFC 3927 section 9.
+const (
+	// ProbeWait is the maximum initial random delay before probing for an
+	// address (PROBE_WAIT).
+	ProbeWait = time.Second
+
+	// ProbeNum is the number of probes sent for an address (PROBE_NUM).
+	ProbeNum = 3
+
+	// ProbeMin is the minimum delay between probes (PROBE_MIN).
+	ProbeMin = time.Second
+
+	// AnnounceNum is the number of announcements sent once an address is
+	// claimed (ANNOUNCE_NUM).
+	AnnounceNum = 2
+
+	// AnnounceInterval is the time between announcements (ANNOUNCE_INTERVAL).
+	AnnounceInterval = 2 * time.Second
+
+	// MaxConflicts is the number of conflicts after which the rate of probing
+	// for new addresses is limited (MAX_CONFLICTS).
+	MaxConflicts = 10
+
+	// RateLimitInterval is the delay between successive attempts once
+	// MaxConflicts is reached (RATE_LIMIT_INTERVAL).
+	RateLimitInterval = 60 * time.Second
+
+	// DefendInterval is the minimum interval between defensive ARPs
+	// (DEFEND_INTERVAL).
+	DefendInterval = 10 * time.Second
+)
+
+const (
+	// PrefixLen is the prefix length of IPv4 link-local addresses.
+	PrefixLen = 16
+
+	// firstHost and numHosts describe the range of addresses a host may
+	// select, 169.254.1.0 to 169.254.254.255, as per RFC 3927 section 2.1.
+	firstHost = 0x0100
+	numHosts  = 254 * 256
+)
+
+// Config holds the configuration for a Client.
+type Config struct {
+	// Address is the first address the client attempts to claim, e.g. the
+	// address it claimed in a previous run, as recommended by RFC 3927 section
+	// 2.1.
+	//
+	// If empty or not a valid link-local address, the first attempted address
+	// is derived from the NIC's link address so that a host usually selects
+	// the same address every time.
+	Address tcpip.Address
+
+	// OnAddress is called whenever the address claimed by the client changes,
+	// including when it is lost (with an empty address).
+	//
+	// OnAddress is called from the goroutine running Client.Run and may call
+	// into the stack but not into the Client.
+	OnAddress func(tcpip.AddressWithPrefix)
+}
+
+// Client configures an IPv4 link-local address on a single NIC.
+type Client struct {
+	stack     *stack.Stack
+	nicID     tcpip.NICID
+	monitor   arp.AddressConflictMonitor
+	preferred tcpip.Address
+	onAddress func(tcpip.AddressWithPrefix)
+
+	// rng selects candidate addresses.
+	//
+	// As per RFC 3927 section 2.1, it is seeded from the NIC's link address.
+	rng *rand.Rand
+
+	mu struct {
+		sync.Mutex
+
+		addr tcpip.AddressWithPrefix
+	}
+}
+
+// NewClient creates an IPv4 link-local address client for the specified NIC.
+//
+// Returns tcpip.ErrNotSupported if the NIC does not resolve IPv4 addresses
+// with ARP.
+func NewClient(s *stack.Stack, nicID tcpip.NICID, config Config) (*Client, *tcpip.Error) {
+	info, ok := s.NICInfo()[nicID]
+	if !ok {
+		return nil, tcpip.ErrUnknownNICID
+	}
+
+	ep, err := s.GetNetworkEndpoint(nicID, arp.ProtocolNumber)
+	if err != nil {
+		return nil, tcpip.ErrNotSupported
+	}
+	monitor, ok := ep.(arp.AddressConflictMonitor)
+	if !ok {
+		return nil, tcpip.ErrNotSupported
+	}
+
+	var seed int64
+	if len(info.LinkAddress) != 0 {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(info.LinkAddress))
+		seed = int64(h.Sum64())
+	} else {
+		seed = s.Rand().Int63()
+	}
+
+	preferred := config.Address
+	if !isSelectable(preferred) {
+		preferred = ""
+	}
+
+	return &Client{
+		stack:     s,
+		nicID:     nicID,
+		monitor:   monitor,
+		preferred: preferred,
+		onAddress: config.OnAddress,
+		rng:       rand.New(rand.NewSource(seed)),
+	}, nil
+}
+
+// isSelectable returns true if addr is a link-local address a host may select,
+// as per RFC 3927 section 2.1.
+func isSelectable(addr tcpip.Address) bool {
+	return header.IsV4LinkLocalAddress(addr) && addr[2] != 0 && addr[2] != 255
+}
+
+// Address returns the address currently claimed by the client.
+func (c *Client) Address() tcpip.AddressWithPrefix {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mu.addr
+}
+
+func (c *Client) setAddress(addr tcpip.AddressWithPrefix) {
+	c.mu.Lock()
+	c.mu.addr = addr
+	c.mu.Unlock()
+
+	if c.onAddress != nil {
+		c.onAddress(addr)
+	}
+}
+
+// candidate returns the next address to attempt to claim.
+func (c *Client) candidate() tcpip.Address {
+	if addr := c.preferred; addr != "" {
+		c.preferred = ""
+		return addr
+	}
+
+	n := firstHost + c.rng.Intn(numHosts)
+	return tcpip.Address([]byte{169, 254, byte(n >> 8), byte(n)})
+}
+
+// Run runs the client until ctx is cancelled.
+//
+// Probing is performed through the NIC's ARP Address Conflict Detection. If it
+// is disabled, Run enables it with ProbeNum probes sent ProbeMin apart.
+//
+// When Run returns, the claimed address is removed from the NIC. Run must not
+// be called concurrently.
+func (c *Client) Run(ctx context.Context) *tcpip.Error {
+	conflicts := 0
+	addr := c.candidate()
+	for {
+		// As per RFC 3927 section 2.2.1, wait for a random delay before probing
+		// to avoid synchronizing with other hosts that were powered on at the
+		// same time. Once MaxConflicts conflicts are detected, the rate at which
+		// new addresses are probed for must be limited.
+		delay := time.Duration(c.stack.Rand().Int63n(int64(ProbeWait)))
+		if conflicts >= MaxConflicts {
+			delay = RateLimitInterval
+		}
+		if !c.wait(ctx, delay) {
+			return nil
+		}
+
+		res, err := c.probe(ctx, addr)
+		if err != nil {
+			return err
+		}
+		switch res.(type) {
+		case nil:
+			return nil
+		case *stack.DADSucceeded:
+		case *stack.DADDupAddrDetected:
+			conflicts++
+			addr = c.candidate()
+			continue
+		default:
+			// Probing was aborted or failed, e.g. because the NIC is disabled;
+			// try again later.
+			continue
+		}
+
+		if err := c.stack.AddProtocolAddressWithOptions(c.nicID, tcpip.ProtocolAddress{
+			Protocol: header.IPv4ProtocolNumber,
+			AddressWithPrefix: tcpip.AddressWithPrefix{
+				Address:   addr,
+				PrefixLen: PrefixLen,
+			},
+		}, stack.CanBePrimaryEndpoint); err != nil {
+			return err
+		}
+		conflicts = 0
+
+		if !c.defend(ctx, addr) {
+			return nil
+		}
+		conflicts++
+		addr = c.candidate()
+	}
+}
+
+// probe checks if addr is in use by a neighbor.
+//
+// Returns a nil result if ctx was cancelled.
+func (c *Client) probe(ctx context.Context, addr tcpip.Address) (stack.DADResult, *tcpip.Error) {
+	ch := make(chan stack.DADResult, 1)
+	h := func(r stack.DADResult) { ch <- r }
+	res, err := c.stack.CheckDuplicateAddress(c.nicID, header.IPv4ProtocolNumber, addr, h)
+	if err != nil {
+		return nil, err
+	}
+	if res == stack.DADDisabled {
+		if err := c.stack.SetDADConfigurations(c.nicID, header.IPv4ProtocolNumber, stack.DADConfigurations{
+			DupAddrDetectTransmits: ProbeNum,
+			RetransmitTimer:        ProbeMin,
+		}); err != nil {
+			return nil, err
+		}
+		if _, err := c.stack.CheckDuplicateAddress(c.nicID, header.IPv4ProtocolNumber, addr, h); err != nil {
+			return nil, err
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, nil
+	case r := <-ch:
+		return r, nil
+	}
+}
+
+// defend announces the claimed address and defends it against conflicting
+// hosts, as per RFC 3927 section 2.4 and 2.5, until the address is lost or ctx
+// is cancelled.
+//
+// Returns false if ctx was cancelled.
+func (c *Client) defend(ctx context.Context, addr tcpip.Address) bool {
+	conflictCh := make(chan struct{}, 1)
+	stopMonitor := c.monitor.MonitorAddressConflicts(addr, func(tcpip.LinkAddress) {
+		select {
+		case conflictCh <- struct{}{}:
+		default:
+		}
+	})
+	defer stopMonitor()
+
+	c.setAddress(tcpip.AddressWithPrefix{Address: addr, PrefixLen: PrefixLen})
+	lose := func() {
+		_ = c.stack.RemoveAddress(c.nicID, addr)
+		c.setAddress(tcpip.AddressWithPrefix{})
+	}
+
+	_ = c.monitor.SendAnnouncement(addr)
+	announcements := AnnounceNum - 1
+	announceC, stopAnnounce := tcpip.ChanAfter(c.stack.Clock(), AnnounceInterval)
+	defer func() { stopAnnounce() }()
+
+	var lastDefense time.Duration
+	defended := false
+	for {
+		select {
+		case <-ctx.Done():
+			lose()
+			return false
+		case <-announceC:
+			_ = c.monitor.SendAnnouncement(addr)
+			announcements--
+			announceC, stopAnnounce = nil, func() {}
+			if announcements > 0 {
+				announceC, stopAnnounce = tcpip.ChanAfter(c.stack.Clock(), AnnounceInterval)
+			}
+		case <-conflictCh:
+			// As per RFC 3927 section 2.5, a host that receives a conflicting
+			// ARP packet may defend its address with a single announcement, but
+			// must give up the address if it has already defended it within
+			// DefendInterval.
+			now := time.Duration(c.stack.Clock().NowMonotonic())
+			if defended && now-lastDefense < DefendInterval {
+				lose()
+				return true
+			}
+			defended = true
+			lastDefense = now
+			_ = c.monitor.SendAnnouncement(addr)
+		}
+	}
+}
+
+// wait waits for d to elapse on the stack's clock.
+//
+// Returns false if ctx was cancelled.
+func (c *Client) wait(ctx context.Context, d time.Duration) bool {
+	ch, stop := tcpip.ChanAfter(c.stack.Clock(), d)
+	defer stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-ch:
+		return true
+	}
+}
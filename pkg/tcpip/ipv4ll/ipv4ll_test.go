@@ -0,0 +1,287 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv4ll
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/arp"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	nicID = 1
+
+	linkAddr       = tcpip.LinkAddress("\x02\x02\x03\x04\x05\x06")
+	remoteLinkAddr = tcpip.LinkAddress("\x02\x02\x03\x04\x05\x07")
+
+	preferredAddr = tcpip.Address("\xa9\xfe\x0a\x14")
+
+	channelSize = 16
+	mtu         = 1500
+
+	testTimeout = 5 * time.Second
+)
+
+type testContext struct {
+	clock  *faketime.ManualClock
+	stack  *stack.Stack
+	ep     *channel.Endpoint
+	client *Client
+	addrCh chan tcpip.AddressWithPrefix
+}
+
+func newTestContext(t *testing.T, config Config) *testContext {
+	t.Helper()
+
+	clock := faketime.NewManualClock()
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{arp.NewProtocol, ipv4.NewProtocol},
+		Clock:            clock,
+	})
+	ep := channel.New(channelSize, mtu, linkAddr)
+	if err := s.CreateNIC(nicID, ep); err != nil {
+		t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+	}
+
+	addrCh := make(chan tcpip.AddressWithPrefix, 1)
+	config.OnAddress = func(addr tcpip.AddressWithPrefix) { addrCh <- addr }
+	c, err := NewClient(s, nicID, config)
+	if err != nil {
+		t.Fatalf("NewClient(_, %d, _): %s", nicID, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := c.Run(ctx); err != nil {
+			t.Errorf("c.Run(_): %s", err)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return &testContext{
+		clock:  clock,
+		stack:  s,
+		ep:     ep,
+		client: c,
+		addrCh: addrCh,
+	}
+}
+
+// nextARP advances the clock until the client sends an ARP packet and returns
+// it.
+func (c *testContext) nextARP(t *testing.T) header.ARP {
+	t.Helper()
+
+	for deadline := time.Now().Add(testTimeout); time.Now().Before(deadline); {
+		if pkt, ok := c.ep.Read(); ok {
+			if pkt.Proto != arp.ProtocolNumber {
+				continue
+			}
+			h := header.ARP(stack.PayloadSince(pkt.Pkt.NetworkHeader()))
+			if !h.IsValid() {
+				t.Fatalf("invalid ARP packet: len = %d; packet = %x", len(h), h)
+			}
+			return h
+		}
+		c.clock.Advance(100 * time.Millisecond)
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("timed out waiting for ARP packet")
+	return nil
+}
+
+// expectProbes expects the client to probe for an address and returns the
+// probed address.
+func (c *testContext) expectProbes(t *testing.T, n int) tcpip.Address {
+	t.Helper()
+
+	var addr tcpip.Address
+	for i := 0; i < n; i++ {
+		h := c.nextARP(t)
+		if got := tcpip.Address(h.ProtocolAddressSender()); got != header.IPv4Any {
+			t.Fatalf("got probe #%d sender address = %s, want = %s", i+1, got, header.IPv4Any)
+		}
+		target := tcpip.Address(h.ProtocolAddressTarget())
+		if i == 0 {
+			addr = target
+		} else if target != addr {
+			t.Fatalf("got probe #%d target address = %s, want = %s", i+1, target, addr)
+		}
+	}
+	return addr
+}
+
+// expectAnnouncement expects the client to announce addr.
+func (c *testContext) expectAnnouncement(t *testing.T, addr tcpip.Address) {
+	t.Helper()
+
+	h := c.nextARP(t)
+	if got := h.Op(); got != header.ARPRequest {
+		t.Errorf("got announcement op = %d, want = %d", got, header.ARPRequest)
+	}
+	if got := tcpip.Address(h.ProtocolAddressSender()); got != addr {
+		t.Errorf("got announcement sender address = %s, want = %s", got, addr)
+	}
+	if got := tcpip.Address(h.ProtocolAddressTarget()); got != addr {
+		t.Errorf("got announcement target address = %s, want = %s", got, addr)
+	}
+}
+
+func (c *testContext) expectAddress(t *testing.T, want tcpip.AddressWithPrefix) {
+	t.Helper()
+
+	select {
+	case got := <-c.addrCh:
+		if got != want {
+			t.Fatalf("got address = %s, want = %s", got, want)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("timed out waiting for address %s", want)
+	}
+
+	wantNIC := tcpip.NICID(nicID)
+	addr := want.Address
+	if want.Address == "" {
+		wantNIC = 0
+		addr = preferredAddr
+	}
+	if got := c.stack.CheckLocalAddress(nicID, ipv4.ProtocolNumber, addr); got != wantNIC {
+		t.Errorf("got c.stack.CheckLocalAddress(%d, %d, %s) = %d, want = %d", nicID, ipv4.ProtocolNumber, addr, got, wantNIC)
+	}
+}
+
+func (c *testContext) injectARP(op header.ARPOp, senderAddr, targetAddr tcpip.Address) {
+	v := make(buffer.View, header.ARPSize)
+	h := header.ARP(v)
+	h.SetIPv4OverEthernet()
+	h.SetOp(op)
+	copy(h.HardwareAddressSender(), remoteLinkAddr)
+	copy(h.ProtocolAddressSender(), senderAddr)
+	copy(h.ProtocolAddressTarget(), targetAddr)
+	c.ep.InjectInbound(arp.ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: v.ToVectorisedView(),
+	}))
+}
+
+func TestClaimAddress(t *testing.T) {
+	c := newTestContext(t, Config{Address: preferredAddr})
+
+	if got := c.expectProbes(t, ProbeNum); got != preferredAddr {
+		t.Fatalf("got probed address = %s, want = %s", got, preferredAddr)
+	}
+	c.expectAnnouncement(t, preferredAddr)
+	want := tcpip.AddressWithPrefix{Address: preferredAddr, PrefixLen: PrefixLen}
+	c.expectAddress(t, want)
+	if got := c.client.Address(); got != want {
+		t.Errorf("got c.client.Address() = %s, want = %s", got, want)
+	}
+	c.expectAnnouncement(t, preferredAddr)
+}
+
+func TestProbeConflict(t *testing.T) {
+	c := newTestContext(t, Config{Address: preferredAddr})
+
+	if got := c.expectProbes(t, 1); got != preferredAddr {
+		t.Fatalf("got probed address = %s, want = %s", got, preferredAddr)
+	}
+	c.injectARP(header.ARPReply, preferredAddr, header.IPv4Any)
+
+	addr := c.expectProbes(t, ProbeNum)
+	if addr == preferredAddr {
+		t.Fatalf("got probed address = %s after conflict, want another address", addr)
+	}
+	if !isSelectable(addr) {
+		t.Errorf("got probed address = %s, want an address in 169.254.1.0 to 169.254.254.255", addr)
+	}
+	c.expectAnnouncement(t, addr)
+	c.expectAddress(t, tcpip.AddressWithPrefix{Address: addr, PrefixLen: PrefixLen})
+}
+
+func TestDefendAddress(t *testing.T) {
+	c := newTestContext(t, Config{Address: preferredAddr})
+
+	c.expectProbes(t, ProbeNum)
+	c.expectAnnouncement(t, preferredAddr)
+	c.expectAddress(t, tcpip.AddressWithPrefix{Address: preferredAddr, PrefixLen: PrefixLen})
+	c.expectAnnouncement(t, preferredAddr)
+
+	// The first conflict is defended with an announcement.
+	c.injectARP(header.ARPReply, preferredAddr, header.IPv4Any)
+	c.expectAnnouncement(t, preferredAddr)
+
+	// A second conflict within DefendInterval makes the client give up the
+	// address and select another one.
+	c.injectARP(header.ARPReply, preferredAddr, header.IPv4Any)
+	c.expectAddress(t, tcpip.AddressWithPrefix{})
+	if addr := c.expectProbes(t, ProbeNum); addr == preferredAddr {
+		t.Errorf("got probed address = %s after losing it, want another address", addr)
+	}
+}
+
+func TestCandidateFromLinkAddress(t *testing.T) {
+	newClient := func(linkAddr tcpip.LinkAddress) *Client {
+		t.Helper()
+
+		s := stack.New(stack.Options{
+			NetworkProtocols: []stack.NetworkProtocolFactory{arp.NewProtocol, ipv4.NewProtocol},
+		})
+		if err := s.CreateNIC(nicID, channel.New(channelSize, mtu, linkAddr)); err != nil {
+			t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+		}
+		c, err := NewClient(s, nicID, Config{})
+		if err != nil {
+			t.Fatalf("NewClient(_, %d, _): %s", nicID, err)
+		}
+		return c
+	}
+
+	// Hosts should usually select the same address every time.
+	got, want := newClient(linkAddr).candidate(), newClient(linkAddr).candidate()
+	if got != want {
+		t.Errorf("got candidate() = %s, want = %s", got, want)
+	}
+	if !isSelectable(got) {
+		t.Errorf("got candidate() = %s, want an address in 169.254.1.0 to 169.254.254.255", got)
+	}
+	if other := newClient(remoteLinkAddr).candidate(); other == got {
+		t.Errorf("got the same candidate %s for link addresses %s and %s", got, linkAddr, remoteLinkAddr)
+	}
+}
+
+func TestNewClientWithoutARP(t *testing.T) {
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+	})
+	if err := s.CreateNIC(nicID, channel.New(channelSize, mtu, linkAddr)); err != nil {
+		t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+	}
+	if _, err := NewClient(s, nicID, Config{}); err != tcpip.ErrNotSupported {
+		t.Errorf("got NewClient(_, %d, _) = (_, %v), want = (_, %s)", nicID, err, tcpip.ErrNotSupported)
+	}
+}
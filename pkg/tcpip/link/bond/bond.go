@@ -0,0 +1,644 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bond provides the implementation of a bonding link endpoint, which
+// aggregates several link endpoints, its slaves, into a single interface like
+// Linux's bonding devices, for redundancy or for bandwidth.
+//
+// In active-backup mode, frames are sent and received through a single slave,
+// and the bond fails over to another slave when the link of the active slave
+// goes down. In 802.3ad mode, the bond runs the Link Aggregation Control
+// Protocol (LACP) on its slaves, and distributes the frames it sends among the
+// slaves aggregated with the same partner.
+//
+// The state of the links of the slaves is reported by the owner of the bond,
+// or monitored by the bond: the MII monitor polls the link endpoints of the
+// slaves which report their carrier, and the ARP monitor sends ARP requests
+// through the slaves and checks that they receive frames.
+//
+// The slaves of a bond send and receive raw ethernet frames, as the link
+// endpoints wrapped by ethernet.Endpoint do. All the frames are sent with the
+// link address of the bond.
+package bond
+
+import (
+	"sync/atomic"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// defaultMTU is the MTU of bonds without slaves, as in Linux.
+const defaultMTU = 1500
+
+// Mode is the mode of a bond, which determines how it uses its slaves. Its
+// values are those of Linux's BOND_MODE_*.
+type Mode int
+
+// Bonding modes.
+const (
+	// ModeActiveBackup sends and receives frames through a single slave, the
+	// active slave, and fails over to another slave when the link of the
+	// active slave goes down.
+	ModeActiveBackup Mode = 1
+
+	// Mode8023AD aggregates the slaves connected to the same partner with
+	// LACP, as per IEEE 802.3ad, and distributes the frames sent by the bond
+	// among them.
+	Mode8023AD Mode = 4
+)
+
+// HashPolicy is the transmit hash policy of a bond in Mode8023AD, which
+// selects the slave each frame is sent through. Its values are those of
+// Linux's BOND_XMIT_POLICY_*.
+type HashPolicy int
+
+// Transmit hash policies.
+const (
+	// HashLayer2 hashes the link addresses and the network protocol of the
+	// frames, so that all the frames to a peer are sent through the same
+	// slave.
+	HashLayer2 HashPolicy = 0
+
+	// HashLayer34 hashes the network addresses and the ports of the TCP and
+	// UDP segments, so that the flows to a peer may be sent through different
+	// slaves. Non-IP frames are hashed as with HashLayer2.
+	HashLayer34 HashPolicy = 1
+)
+
+// Options specify the details of a bond endpoint.
+type Options struct {
+	// LinkAddress is the link address of the bond.
+	LinkAddress tcpip.LinkAddress
+
+	// Mode is the mode of the bond.
+	Mode Mode
+
+	// Clock is used by the link monitors and by LACP. If nil, the system
+	// clock is used.
+	Clock tcpip.Clock
+
+	// MIIMonInterval is the interval at which the MII monitor polls the
+	// carrier of the slaves whose link endpoints implement CarrierReporter.
+	// If 0, the MII monitor is disabled.
+	MIIMonInterval time.Duration
+
+	// ARPInterval is the interval at which the ARP monitor sends ARP requests
+	// for ARPTargets through the slaves. The link of a slave is considered
+	// down if it hasn't received any frame for twice this interval. If 0, the
+	// ARP monitor is disabled.
+	ARPInterval time.Duration
+
+	// ARPTargets are the IPv4 addresses probed by the ARP monitor.
+	ARPTargets []tcpip.Address
+
+	// ARPSource is the sender address of the ARP requests sent by the ARP
+	// monitor. If empty, the requests are ARP probes, with an unspecified
+	// sender address.
+	ARPSource tcpip.Address
+
+	// LACPFast makes the bond ask its LACP partners to send LACPDUs every
+	// second rather than every 30 seconds, so that partners which stop
+	// responding are detected faster.
+	LACPFast bool
+
+	// HashPolicy is the transmit hash policy of the bond in Mode8023AD.
+	HashPolicy HashPolicy
+}
+
+var _ stack.LinkEndpoint = (*Endpoint)(nil)
+
+// Endpoint is a bonding link endpoint.
+type Endpoint struct {
+	linkAddr    tcpip.LinkAddress
+	mode        Mode
+	clock       tcpip.Clock
+	hashPolicy  HashPolicy
+	lacpFast    bool
+	arpInterval time.Duration
+	arpTargets  []tcpip.Address
+	arpSource   tcpip.Address
+
+	// drops is the number of frames which were dropped because the bond had
+	// no usable slave.
+	//
+	// Must be accessed using atomic operations.
+	drops uint64
+
+	// mu protects the fields below, and the LACP state of the slaves.
+	mu         sync.RWMutex
+	dispatcher stack.NetworkDispatcher
+
+	// slaves is never modified in place, so that it may be iterated over
+	// without holding mu.
+	slaves []*Slave
+
+	// nextPort is the LACP port number of the next slave.
+	nextPort uint16
+
+	// active is the slave frames are sent and received through in
+	// ModeActiveBackup, or nil if no slave is usable.
+	active *Slave
+
+	// primary is the slave which is preferred as the active slave whenever
+	// its link is up, or nil.
+	primary *Slave
+
+	// distributing are the slaves frames are sent through in Mode8023AD. It
+	// is never modified in place.
+	distributing []*Slave
+
+	// timers are the timers of the link monitors and of LACP. They are
+	// replaced each time they fire, and set to nil when the bond is closed.
+	timers []tcpip.Timer
+	closed bool
+}
+
+// New returns a bond link endpoint without slaves.
+func New(opts Options) *Endpoint {
+	clock := opts.Clock
+	if clock == nil {
+		clock = &tcpip.StdClock{}
+	}
+	mode := opts.Mode
+	if mode != Mode8023AD {
+		mode = ModeActiveBackup
+	}
+	e := &Endpoint{
+		linkAddr:    opts.LinkAddress,
+		mode:        mode,
+		clock:       clock,
+		hashPolicy:  opts.HashPolicy,
+		lacpFast:    opts.LACPFast,
+		arpInterval: opts.ARPInterval,
+		arpTargets:  append([]tcpip.Address(nil), opts.ARPTargets...),
+		arpSource:   opts.ARPSource,
+		nextPort:    1,
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if opts.MIIMonInterval > 0 {
+		e.scheduleLocked(len(e.timers), opts.MIIMonInterval, e.miiMonitor)
+	}
+	if opts.ARPInterval > 0 && len(opts.ARPTargets) != 0 {
+		e.scheduleLocked(len(e.timers), opts.ARPInterval, e.arpMonitor)
+	}
+	if mode == Mode8023AD {
+		e.scheduleLocked(len(e.timers), fastPeriodicTime, e.lacpTick)
+	}
+	return e
+}
+
+// scheduleLocked schedules f to be called every d by the timer i of the bond.
+//
+// Precondition: e.mu must be locked.
+func (e *Endpoint) scheduleLocked(i int, d time.Duration, f func()) {
+	var timer tcpip.Timer
+	timer = e.clock.AfterFunc(d, func() {
+		e.mu.Lock()
+		if e.closed || e.timers[i] != timer {
+			e.mu.Unlock()
+			return
+		}
+		e.scheduleLocked(i, d, f)
+		e.mu.Unlock()
+
+		f()
+	})
+	if i == len(e.timers) {
+		e.timers = append(e.timers, timer)
+	} else {
+		e.timers[i] = timer
+	}
+}
+
+// Close stops the link monitors of the bond and LACP. The bond keeps sending
+// and receiving frames through its slaves, whose links are considered to
+// remain in their current state.
+func (e *Endpoint) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.closed = true
+	for _, t := range e.timers {
+		t.Stop()
+	}
+	e.timers = nil
+}
+
+var _ stack.NetworkDispatcher = (*Slave)(nil)
+var _ stack.CarrierDispatcher = (*Slave)(nil)
+
+// Slave is a slave of a bond.
+type Slave struct {
+	bond *Endpoint
+	ep   stack.LinkEndpoint
+
+	// port is the LACP port number of the slave.
+	port uint16
+
+	// linkUp is 1 if the link of the slave is up.
+	//
+	// Must be accessed using atomic operations.
+	linkUp uint32
+
+	// lastRx is the monotonic time at which the slave last received a frame.
+	//
+	// Must be accessed using atomic operations.
+	lastRx int64
+
+	// lacp is the LACP state of the slave, protected by the mu of the bond.
+	lacp lacpPort
+}
+
+// AddSlave adds a slave sending and receiving frames through ep to the bond.
+// The link of the slave is initially considered up.
+func (e *Endpoint) AddSlave(ep stack.LinkEndpoint) *Slave {
+	s := &Slave{
+		bond:   e,
+		ep:     ep,
+		linkUp: 1,
+		lastRx: e.clock.NowMonotonic(),
+	}
+
+	e.mu.Lock()
+	s.port = e.nextPort
+	e.nextPort++
+	s.lacp.ntt = true
+	e.slaves = append(e.slaves[:len(e.slaves):len(e.slaves)], s)
+	e.selectLocked()
+	pdus := e.pendingLACPDUsLocked(false /* periodic */)
+	e.mu.Unlock()
+
+	ep.Attach(s)
+	e.sendLACPDUs(pdus)
+	return s
+}
+
+// RemoveSlave removes the slave s from the bond. The link endpoint of the
+// slave remains attached to the bond, which drops the frames it receives.
+func (e *Endpoint) RemoveSlave(s *Slave) *tcpip.Error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i, slave := range e.slaves {
+		if slave != s {
+			continue
+		}
+		slaves := make([]*Slave, 0, len(e.slaves)-1)
+		slaves = append(slaves, e.slaves[:i]...)
+		e.slaves = append(slaves, e.slaves[i+1:]...)
+
+		if e.primary == s {
+			e.primary = nil
+		}
+		if e.active == s {
+			e.active = nil
+		}
+		s.lacp = lacpPort{}
+		e.selectLocked()
+		return nil
+	}
+	return tcpip.ErrUnknownDevice
+}
+
+// Slaves returns the slaves of the bond.
+func (e *Endpoint) Slaves() []*Slave {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return append([]*Slave(nil), e.slaves...)
+}
+
+// ActiveSlave returns the slave frames are sent and received through in
+// ModeActiveBackup, or nil if there is none.
+func (e *Endpoint) ActiveSlave() *Slave {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.active
+}
+
+// SetPrimary makes s, a slave of the bond, the active slave whenever its link
+// is up in ModeActiveBackup. If s is nil, no slave is preferred, and the bond
+// only fails over when the link of the active slave goes down.
+func (e *Endpoint) SetPrimary(s *Slave) *tcpip.Error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if s != nil && !e.isSlaveLocked(s) {
+		return tcpip.ErrUnknownDevice
+	}
+	e.primary = s
+	e.selectLocked()
+	return nil
+}
+
+// Drops returns the number of frames which were dropped because the bond had
+// no usable slave to send them through.
+func (e *Endpoint) Drops() uint64 {
+	return atomic.LoadUint64(&e.drops)
+}
+
+// isSlaveLocked returns true if s is a slave of the bond.
+//
+// Precondition: e.mu must be locked.
+func (e *Endpoint) isSlaveLocked(s *Slave) bool {
+	for _, slave := range e.slaves {
+		if slave == s {
+			return true
+		}
+	}
+	return false
+}
+
+// selectLocked selects the slaves frames are sent and received through after
+// the state of the slaves changed.
+//
+// Precondition: e.mu must be locked.
+func (e *Endpoint) selectLocked() {
+	switch e.mode {
+	case ModeActiveBackup:
+		e.selectActiveLocked()
+	case Mode8023AD:
+		e.selectAggregatorLocked()
+	}
+}
+
+// selectActiveLocked selects the active slave of the bond: the primary slave
+// if its link is up, or else the current active slave if its link is up, or
+// else the first slave whose link is up.
+//
+// Precondition: e.mu must be locked.
+func (e *Endpoint) selectActiveLocked() {
+	if p := e.primary; p != nil && p.LinkUp() {
+		e.active = p
+		return
+	}
+	if a := e.active; a != nil && a.LinkUp() {
+		return
+	}
+	e.active = nil
+	for _, s := range e.slaves {
+		if s.LinkUp() {
+			e.active = s
+			return
+		}
+	}
+}
+
+// collectingLocked returns true if the frames received by s are delivered to
+// the stack.
+//
+// Precondition: e.mu must be locked.
+func (e *Endpoint) collectingLocked(s *Slave) bool {
+	switch e.mode {
+	case ModeActiveBackup:
+		return s == e.active
+	case Mode8023AD:
+		return s.lacp.aggregated
+	default:
+		return false
+	}
+}
+
+// LinkEndpoint returns the link endpoint of s.
+func (s *Slave) LinkEndpoint() stack.LinkEndpoint {
+	return s.ep
+}
+
+// LinkUp returns true if the link of s is up.
+func (s *Slave) LinkUp() bool {
+	return atomic.LoadUint32(&s.linkUp) == 1
+}
+
+// SetLinkUp sets the state of the link of s, as reported by the owner of the
+// bond or by a link monitor. The bond stops using s while its link is down.
+func (s *Slave) SetLinkUp(up bool) {
+	var v uint32
+	if up {
+		v = 1
+	}
+	if atomic.SwapUint32(&s.linkUp, v) == v {
+		return
+	}
+
+	e := s.bond
+	e.mu.Lock()
+	if !e.isSlaveLocked(s) {
+		e.mu.Unlock()
+		return
+	}
+	if up {
+		// Send an LACPDU as soon as the link comes up, so that the
+		// partner doesn't have to wait for the next periodic one.
+		s.lacp.ntt = true
+	}
+	e.selectLocked()
+	pdus := e.pendingLACPDUsLocked(false /* periodic */)
+	e.mu.Unlock()
+
+	e.sendLACPDUs(pdus)
+}
+
+// DeliverCarrierChange implements stack.CarrierDispatcher.DeliverCarrierChange.
+// The link of s follows the carrier of its link endpoint.
+func (s *Slave) DeliverCarrierChange(up bool) {
+	s.SetLinkUp(up)
+}
+
+// DeliverNetworkPacket implements stack.NetworkDispatcher.
+func (s *Slave) DeliverNetworkPacket(_, _ tcpip.LinkAddress, _ tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	s.bond.receive(s, pkt)
+}
+
+// DeliverOutboundPacket implements stack.NetworkDispatcher.
+func (*Slave) DeliverOutboundPacket(tcpip.LinkAddress, tcpip.LinkAddress, tcpip.NetworkProtocolNumber, *stack.PacketBuffer) {
+}
+
+// write sends frame, an ethernet frame to dst, out of s.
+func (s *Slave) write(dst tcpip.LinkAddress, proto tcpip.NetworkProtocolNumber, frame buffer.VectorisedView) *tcpip.Error {
+	r := stack.Route{
+		LocalLinkAddress: s.bond.linkAddr,
+		NetProto:         proto,
+	}
+	r.ResolveWith(dst)
+	return s.ep.WritePacket(&r, nil /* gso */, proto, stack.NewPacketBuffer(stack.PacketBufferOptions{
+		ReserveHeaderBytes: int(s.ep.MaxHeaderLength()),
+		Data:               frame,
+	}))
+}
+
+// isGroupAddress returns true if addr is a broadcast or multicast address.
+func isGroupAddress(addr tcpip.LinkAddress) bool {
+	return addr == header.EthernetBroadcastAddress || header.IsMulticastEthernetAddress(addr)
+}
+
+// receive handles pkt, a frame received by the slave s.
+func (e *Endpoint) receive(s *Slave, pkt *stack.PacketBuffer) {
+	// Any frame shows that the link of the slave is up to the ARP monitor.
+	atomic.StoreInt64(&s.lastRx, e.clock.NowMonotonic())
+
+	hdr, ok := pkt.LinkHeader().Consume(header.EthernetMinimumSize)
+	if !ok {
+		return
+	}
+	eth := header.Ethernet(hdr)
+	src, dst, proto := eth.SourceAddress(), eth.DestinationAddress(), eth.Type()
+	if proto == slowProtocolsType {
+		if e.mode == Mode8023AD {
+			e.handleLACPDU(s, pkt.Data.ToView())
+		}
+		return
+	}
+	if dst != e.linkAddr && !isGroupAddress(dst) {
+		return
+	}
+
+	e.mu.RLock()
+	collecting := e.collectingLocked(s)
+	d := e.dispatcher
+	e.mu.RUnlock()
+	if collecting && d != nil {
+		d.DeliverNetworkPacket(src /* remote */, dst /* local */, proto, pkt)
+	}
+}
+
+// MTU implements stack.LinkEndpoint.MTU. It is the smallest MTU of the slaves
+// of the bond.
+func (e *Endpoint) MTU() uint32 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if len(e.slaves) == 0 {
+		return defaultMTU
+	}
+	mtu := e.slaves[0].ep.MTU()
+	for _, s := range e.slaves[1:] {
+		if m := s.ep.MTU(); m < mtu {
+			mtu = m
+		}
+	}
+	return mtu
+}
+
+// MaxHeaderLength implements stack.LinkEndpoint.MaxHeaderLength.
+func (e *Endpoint) MaxHeaderLength() uint16 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var max uint16
+	for _, s := range e.slaves {
+		if l := s.ep.MaxHeaderLength(); l > max {
+			max = l
+		}
+	}
+	return header.EthernetMinimumSize + max
+}
+
+// LinkAddress implements stack.LinkEndpoint.LinkAddress.
+func (e *Endpoint) LinkAddress() tcpip.LinkAddress {
+	return e.linkAddr
+}
+
+// Capabilities implements stack.LinkEndpoint.Capabilities.
+func (*Endpoint) Capabilities() stack.LinkEndpointCapabilities {
+	return stack.CapabilityResolutionRequired
+}
+
+// Attach implements stack.LinkEndpoint.Attach.
+func (e *Endpoint) Attach(dispatcher stack.NetworkDispatcher) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dispatcher = dispatcher
+}
+
+// IsAttached implements stack.LinkEndpoint.IsAttached.
+func (e *Endpoint) IsAttached() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.dispatcher != nil
+}
+
+// Wait implements stack.LinkEndpoint.Wait. It waits for the link endpoints of
+// all the slaves.
+func (e *Endpoint) Wait() {
+	for _, s := range e.Slaves() {
+		s.ep.Wait()
+	}
+}
+
+// ARPHardwareType implements stack.LinkEndpoint.ARPHardwareType.
+func (*Endpoint) ARPHardwareType() header.ARPHardwareType {
+	return header.ARPHardwareEther
+}
+
+// AddHeader implements stack.LinkEndpoint.AddHeader.
+func (*Endpoint) AddHeader(local, remote tcpip.LinkAddress, proto tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	eth := header.Ethernet(pkt.LinkHeader().Push(header.EthernetMinimumSize))
+	eth.Encode(&header.EthernetFields{
+		SrcAddr: local,
+		DstAddr: remote,
+		Type:    proto,
+	})
+}
+
+// txSlave returns the slave frame, an ethernet frame, is sent through, or nil
+// if there is none.
+func (e *Endpoint) txSlave(frame *buffer.VectorisedView) *Slave {
+	e.mu.RLock()
+	active, distributing := e.active, e.distributing
+	e.mu.RUnlock()
+
+	switch e.mode {
+	case ModeActiveBackup:
+		return active
+	case Mode8023AD:
+		if len(distributing) == 0 {
+			return nil
+		}
+		return distributing[e.hash(frame)%uint32(len(distributing))]
+	default:
+		return nil
+	}
+}
+
+// WritePacket implements stack.LinkEndpoint.WritePacket.
+func (e *Endpoint) WritePacket(r *stack.Route, _ *stack.GSO, proto tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) *tcpip.Error {
+	dst := r.RemoteLinkAddress()
+	e.AddHeader(e.linkAddr, dst, proto, pkt)
+	frame := buffer.NewVectorisedView(pkt.Size(), pkt.Views())
+	s := e.txSlave(&frame)
+	if s == nil {
+		// Like Linux, the frames which can't be sent are silently dropped.
+		atomic.AddUint64(&e.drops, 1)
+		return nil
+	}
+	return s.write(dst, proto, frame)
+}
+
+// WritePackets implements stack.LinkEndpoint.WritePackets.
+func (e *Endpoint) WritePackets(r *stack.Route, gso *stack.GSO, pkts stack.PacketBufferList, proto tcpip.NetworkProtocolNumber) (int, *tcpip.Error) {
+	n := 0
+	for pkt := pkts.Front(); pkt != nil; pkt = pkt.Next() {
+		if err := e.WritePacket(r, gso, proto, pkt); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,500 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bond
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/link/veth"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	bondAddr  = tcpip.LinkAddress("\x02\x00\x00\x00\x00\x01")
+	peerAddr  = tcpip.LinkAddress("\x02\x00\x00\x00\x00\x02")
+	host1Addr = tcpip.LinkAddress("\x02\x00\x00\x00\x01\x01")
+	host2Addr = tcpip.LinkAddress("\x02\x00\x00\x00\x01\x02")
+
+	testProto = tcpip.NetworkProtocolNumber(0x88b5)
+	interval  = time.Second
+)
+
+var (
+	payload   = []byte{1, 2, 3, 4}
+	arpTarget = tcpip.Address("\xc0\xa8\x00\x01")
+)
+
+// testDispatcher records the payloads of the packets delivered to the stack.
+type testDispatcher struct {
+	delivered [][]byte
+}
+
+// DeliverNetworkPacket implements stack.NetworkDispatcher.
+func (d *testDispatcher) DeliverNetworkPacket(_, _ tcpip.LinkAddress, _ tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	d.delivered = append(d.delivered, pkt.Data.ToView())
+}
+
+// DeliverOutboundPacket implements stack.NetworkDispatcher.
+func (*testDispatcher) DeliverOutboundPacket(tcpip.LinkAddress, tcpip.LinkAddress, tcpip.NetworkProtocolNumber, *stack.PacketBuffer) {
+}
+
+// carrierEndpoint is a channel endpoint which reports its carrier.
+type carrierEndpoint struct {
+	*channel.Endpoint
+
+	// carrier must be accessed using atomic operations.
+	carrier uint32
+}
+
+var _ CarrierReporter = (*carrierEndpoint)(nil)
+
+// Carrier implements CarrierReporter.Carrier.
+func (e *carrierEndpoint) Carrier() bool {
+	return atomic.LoadUint32(&e.carrier) == 1
+}
+
+func (e *carrierEndpoint) setCarrier(up bool) {
+	var v uint32
+	if up {
+		v = 1
+	}
+	atomic.StoreUint32(&e.carrier, v)
+}
+
+type testContext struct {
+	t          *testing.T
+	clock      *faketime.ManualClock
+	bond       *Endpoint
+	dispatcher testDispatcher
+	links      []*carrierEndpoint
+	slaves     []*Slave
+}
+
+// newTestContext returns a test context with a bond created with opts, whose
+// link address and clock are overridden.
+func newTestContext(t *testing.T, numSlaves int, opts Options) *testContext {
+	c := &testContext{
+		t:     t,
+		clock: faketime.NewManualClock(),
+	}
+	opts.LinkAddress = bondAddr
+	opts.Clock = c.clock
+	c.bond = New(opts)
+	t.Cleanup(c.bond.Close)
+	c.bond.Attach(&c.dispatcher)
+	for i := 0; i < numSlaves; i++ {
+		link := &carrierEndpoint{
+			Endpoint: channel.New(4, defaultMTU, ""),
+			carrier:  1,
+		}
+		c.links = append(c.links, link)
+		c.slaves = append(c.slaves, c.bond.AddSlave(link))
+	}
+	return c
+}
+
+func frame(src, dst tcpip.LinkAddress) buffer.View {
+	v := buffer.NewView(header.EthernetMinimumSize + len(payload))
+	header.Ethernet(v).Encode(&header.EthernetFields{
+		SrcAddr: src,
+		DstAddr: dst,
+		Type:    testProto,
+	})
+	copy(v[header.EthernetMinimumSize:], payload)
+	return v
+}
+
+// inject injects a frame from peerAddr to the bond on the slave i.
+func (c *testContext) inject(i int) {
+	c.links[i].InjectInbound(testProto, stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: frame(peerAddr, bondAddr).ToVectorisedView(),
+	}))
+}
+
+// checkDelivered checks whether a frame was delivered to the stack.
+func (c *testContext) checkDelivered(want bool) {
+	c.t.Helper()
+
+	delivered := c.dispatcher.delivered
+	c.dispatcher.delivered = nil
+	if got := len(delivered) != 0; got != want {
+		c.t.Fatalf("got delivered = %t, want = %t", got, want)
+	}
+	for _, d := range delivered {
+		if !bytes.Equal(d, payload) {
+			c.t.Errorf("got delivered = %x, want = %x", d, payload)
+		}
+	}
+}
+
+// write writes a frame to dst through the bond.
+func (c *testContext) write(dst tcpip.LinkAddress) {
+	c.t.Helper()
+
+	var r stack.Route
+	r.ResolveWith(dst)
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		ReserveHeaderBytes: int(c.bond.MaxHeaderLength()),
+		Data:               buffer.View(payload).ToVectorisedView(),
+	})
+	if err := c.bond.WritePacket(&r, nil /* gso */, testProto, pkt); err != nil {
+		c.t.Fatalf("WritePacket(_, nil, %d, _): %s", testProto, err)
+	}
+}
+
+// checkSent checks that the frame to dst was sent out of the slave want only,
+// or of none if want is negative.
+func (c *testContext) checkSent(dst tcpip.LinkAddress, want int) {
+	c.t.Helper()
+
+	f := frame(bondAddr, dst)
+	for i, link := range c.links {
+		p, ok := link.Read()
+		if ok != (i == want) {
+			c.t.Errorf("got frame sent out of slave %d = %t, want = %t", i, ok, i == want)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		vv := buffer.NewVectorisedView(p.Pkt.Size(), p.Pkt.Views())
+		if got := vv.ToView(); !bytes.Equal(got, f) {
+			c.t.Errorf("got frame sent out of slave %d = %x, want = %x", i, got, f)
+		}
+	}
+}
+
+func TestActiveBackup(t *testing.T) {
+	c := newTestContext(t, 2, Options{Mode: ModeActiveBackup})
+
+	// The first slave is the active slave.
+	if got := c.bond.ActiveSlave(); got != c.slaves[0] {
+		t.Fatalf("got ActiveSlave() = %p, want = %p", got, c.slaves[0])
+	}
+	c.write(host1Addr)
+	c.checkSent(host1Addr, 0)
+	c.inject(0)
+	c.checkDelivered(true)
+	c.inject(1)
+	c.checkDelivered(false)
+
+	// The bond fails over when the link of the active slave goes down.
+	c.slaves[0].SetLinkUp(false)
+	if got := c.bond.ActiveSlave(); got != c.slaves[1] {
+		t.Fatalf("got ActiveSlave() = %p, want = %p", got, c.slaves[1])
+	}
+	c.write(host1Addr)
+	c.checkSent(host1Addr, 1)
+	c.inject(0)
+	c.checkDelivered(false)
+	c.inject(1)
+	c.checkDelivered(true)
+
+	// It doesn't fail back without a primary slave.
+	c.slaves[0].SetLinkUp(true)
+	if got := c.bond.ActiveSlave(); got != c.slaves[1] {
+		t.Fatalf("got ActiveSlave() = %p, want = %p", got, c.slaves[1])
+	}
+
+	// The primary slave is active whenever its link is up.
+	if err := c.bond.SetPrimary(c.slaves[0]); err != nil {
+		t.Fatalf("SetPrimary(_): %s", err)
+	}
+	if got := c.bond.ActiveSlave(); got != c.slaves[0] {
+		t.Fatalf("got ActiveSlave() = %p, want = %p", got, c.slaves[0])
+	}
+
+	// Frames are dropped when no slave is usable.
+	c.slaves[0].SetLinkUp(false)
+	c.slaves[1].SetLinkUp(false)
+	if got := c.bond.ActiveSlave(); got != nil {
+		t.Fatalf("got ActiveSlave() = %p, want = nil", got)
+	}
+	c.write(host1Addr)
+	c.checkSent(host1Addr, -1)
+	if got := c.bond.Drops(); got != 1 {
+		t.Errorf("got Drops() = %d, want = 1", got)
+	}
+
+	// Removed slaves are no longer used.
+	c.slaves[1].SetLinkUp(true)
+	if err := c.bond.RemoveSlave(c.slaves[1]); err != nil {
+		t.Fatalf("RemoveSlave(_): %s", err)
+	}
+	if err := c.bond.RemoveSlave(c.slaves[1]); err != tcpip.ErrUnknownDevice {
+		t.Fatalf("got RemoveSlave(_) = %v, want = %s", err, tcpip.ErrUnknownDevice)
+	}
+	if got := c.bond.ActiveSlave(); got != nil {
+		t.Fatalf("got ActiveSlave() = %p, want = nil", got)
+	}
+	c.inject(1)
+	c.checkDelivered(false)
+}
+
+func TestMIIMonitor(t *testing.T) {
+	c := newTestContext(t, 2, Options{
+		Mode:           ModeActiveBackup,
+		MIIMonInterval: interval,
+	})
+
+	c.links[0].setCarrier(false)
+	c.clock.Advance(interval)
+	if c.slaves[0].LinkUp() {
+		t.Error("got slaves[0].LinkUp() = true, want = false")
+	}
+	if got := c.bond.ActiveSlave(); got != c.slaves[1] {
+		t.Fatalf("got ActiveSlave() = %p, want = %p", got, c.slaves[1])
+	}
+
+	c.links[0].setCarrier(true)
+	c.clock.Advance(interval)
+	if !c.slaves[0].LinkUp() {
+		t.Error("got slaves[0].LinkUp() = false, want = true")
+	}
+}
+
+func TestARPMonitor(t *testing.T) {
+	c := newTestContext(t, 2, Options{
+		Mode:        ModeActiveBackup,
+		ARPInterval: interval,
+		ARPTargets:  []tcpip.Address{arpTarget},
+	})
+
+	// checkARPRequests checks that an ARP request for arpTarget was sent
+	// out of every slave.
+	checkARPRequests := func() {
+		t.Helper()
+		for i, link := range c.links {
+			p, ok := link.Read()
+			if !ok {
+				t.Fatalf("got no ARP request sent out of slave %d", i)
+			}
+			vv := buffer.NewVectorisedView(p.Pkt.Size(), p.Pkt.Views())
+			v := vv.ToView()
+			eth := header.Ethernet(v)
+			if eth.Type() != header.ARPProtocolNumber || eth.DestinationAddress() != header.EthernetBroadcastAddress {
+				t.Fatalf("got frame sent out of slave %d = %x, want an ARP request", i, v)
+			}
+			h := header.ARP(v[header.EthernetMinimumSize:])
+			if !h.IsValid() || h.Op() != header.ARPRequest || tcpip.Address(h.ProtocolAddressTarget()) != arpTarget {
+				t.Errorf("got ARP request sent out of slave %d = %x, want a request for %s", i, h, arpTarget)
+			}
+		}
+	}
+
+	// The links of the slaves which keep receiving frames stay up.
+	for i := 0; i < 3; i++ {
+		c.clock.Advance(interval)
+		checkARPRequests()
+		c.inject(0)
+	}
+	c.checkDelivered(true)
+	if !c.slaves[0].LinkUp() {
+		t.Error("got slaves[0].LinkUp() = false, want = true")
+	}
+	if c.slaves[1].LinkUp() {
+		t.Error("got slaves[1].LinkUp() = true, want = false")
+	}
+
+	// The links come back up when the slaves receive frames again.
+	c.inject(1)
+	c.clock.Advance(interval)
+	checkARPRequests()
+	if !c.slaves[1].LinkUp() {
+		t.Error("got slaves[1].LinkUp() = false, want = true")
+	}
+}
+
+func TestLACP(t *testing.T) {
+	clock := faketime.NewManualClock()
+	newBond := func(addr tcpip.LinkAddress) (*Endpoint, *testDispatcher) {
+		b := New(Options{
+			LinkAddress: addr,
+			Mode:        Mode8023AD,
+			Clock:       clock,
+			LACPFast:    true,
+		})
+		t.Cleanup(b.Close)
+		var d testDispatcher
+		b.Attach(&d)
+		return b, &d
+	}
+	bond1, _ := newBond(bondAddr)
+	bond2, dispatcher2 := newBond(peerAddr)
+
+	// A third slave of bond1 is connected to a channel endpoint rather than
+	// to bond2, and isn't aggregated with the others.
+	var links []*veth.Endpoint
+	for i := 0; i < 2; i++ {
+		ep1, ep2 := veth.New(veth.Options{})
+		links = append(links, ep1)
+		bond1.AddSlave(ep1)
+		bond2.AddSlave(ep2)
+	}
+	link3 := channel.New(4, defaultMTU, "")
+	bond1.AddSlave(link3)
+
+	// The LACP exchange completes synchronously.
+	for _, b := range []*Endpoint{bond1, bond2} {
+		b.mu.RLock()
+		distributing := b.distributing
+		b.mu.RUnlock()
+		if len(distributing) != 2 {
+			t.Fatalf("got %d distributing slaves, want = 2", len(distributing))
+		}
+	}
+	if p, ok := link3.Read(); !ok {
+		t.Error("got no LACPDU sent out of slave3")
+	} else if got := header.Ethernet(p.Pkt.Data.ToView()).Type(); got != slowProtocolsType {
+		t.Errorf("got frame of type %d sent out of slave3, want = %d", got, slowProtocolsType)
+	}
+
+	// Frames to different peers are distributed among the aggregated slaves.
+	write := func(dst tcpip.LinkAddress) {
+		t.Helper()
+		var r stack.Route
+		r.ResolveWith(dst)
+		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+			ReserveHeaderBytes: int(bond1.MaxHeaderLength()),
+			Data:               buffer.View(payload).ToVectorisedView(),
+		})
+		if err := bond1.WritePacket(&r, nil /* gso */, testProto, pkt); err != nil {
+			t.Fatalf("WritePacket(_, nil, %d, _): %s", testProto, err)
+		}
+	}
+	// With HashLayer2, these destinations are hashed to different slaves.
+	drops := links[0].Drops() + links[1].Drops()
+	for _, dst := range []tcpip.LinkAddress{peerAddr, header.EthernetBroadcastAddress} {
+		write(dst)
+	}
+	if got := len(dispatcher2.delivered); got != 2 {
+		t.Fatalf("got %d frames delivered by bond2, want = 2", got)
+	}
+	if got := links[0].Drops() + links[1].Drops(); got != drops {
+		t.Errorf("got %d frames dropped by the links, want = %d", got, drops)
+	}
+	if link3.Drain() != 0 {
+		t.Error("got frames sent out of slave3, want none")
+	}
+
+	// Frames received by slaves outside of the aggregator are dropped.
+	dispatcher1 := &testDispatcher{}
+	bond1.Attach(dispatcher1)
+	link3.InjectInbound(testProto, stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: frame(peerAddr, bondAddr).ToVectorisedView(),
+	}))
+	if len(dispatcher1.delivered) != 0 {
+		t.Error("got frame received by slave3 delivered, want dropped")
+	}
+
+	// The partner expires when it stops sending LACPDUs, after which frames
+	// are dropped.
+	bond2.Close()
+	for _, link := range links {
+		link.Peer().Attach(nil)
+	}
+	clock.Advance(shortTimeout)
+	bond1.mu.RLock()
+	distributing := bond1.distributing
+	bond1.mu.RUnlock()
+	if len(distributing) != 0 {
+		t.Fatalf("got %d distributing slaves, want = 0", len(distributing))
+	}
+	write(peerAddr)
+	if got := bond1.Drops(); got != 1 {
+		t.Errorf("got Drops() = %d, want = 1", got)
+	}
+}
+
+func TestLACPDU(t *testing.T) {
+	c := newTestContext(t, 1, Options{Mode: Mode8023AD})
+
+	// The slave sent an LACPDU when it was added.
+	p, ok := c.links[0].Read()
+	if !ok {
+		t.Fatal("got no LACPDU sent")
+	}
+	vv := buffer.NewVectorisedView(p.Pkt.Size(), p.Pkt.Views())
+	v := vv.ToView()
+	eth := header.Ethernet(v)
+	if eth.DestinationAddress() != slowProtocolsAddress || eth.Type() != slowProtocolsType {
+		t.Fatalf("got frame = %x, want an LACPDU", v)
+	}
+	pdu := v[header.EthernetMinimumSize:]
+	if len(pdu) != lacpduSize || pdu[0] != lacpSubtype || pdu[1] != lacpVersion {
+		t.Fatalf("got LACPDU = %x, want subtype %d and version %d", pdu, lacpSubtype, lacpVersion)
+	}
+	actor := decodeLACPInfo(pdu[lacpActorOffset:])
+	want := lacpInfo{
+		systemPriority: lacpSystemPriority,
+		system:         bondAddr,
+		key:            lacpKey,
+		portPriority:   lacpPortPriority,
+		port:           1,
+		state:          lacpActivity | lacpAggregation | lacpDefaulted,
+	}
+	if actor != want {
+		t.Errorf("got actor = %+v, want = %+v", actor, want)
+	}
+
+	// Periodic LACPDUs are sent every 30 seconds to partners which didn't
+	// ask for short timeouts.
+	c.clock.Advance(slowPeriodicTime - fastPeriodicTime)
+	if c.links[0].Drain() != 0 {
+		t.Fatal("got LACPDU sent before the periodic time")
+	}
+	c.clock.Advance(fastPeriodicTime)
+	if c.links[0].Drain() != 1 {
+		t.Fatal("got no periodic LACPDU sent")
+	}
+}
+
+func TestLayer34Hash(t *testing.T) {
+	e := New(Options{HashPolicy: HashLayer34})
+	udp := func(srcPort uint16) uint32 {
+		v := buffer.NewView(header.EthernetMinimumSize + header.IPv4MinimumSize + header.UDPMinimumSize)
+		header.Ethernet(v).Encode(&header.EthernetFields{
+			SrcAddr: host1Addr,
+			DstAddr: host2Addr,
+			Type:    header.IPv4ProtocolNumber,
+		})
+		header.IPv4(v[header.EthernetMinimumSize:]).Encode(&header.IPv4Fields{
+			TotalLength: header.IPv4MinimumSize + header.UDPMinimumSize,
+			Protocol:    uint8(header.UDPProtocolNumber),
+			SrcAddr:     "\x0a\x00\x00\x01",
+			DstAddr:     "\x0a\x00\x00\x02",
+		})
+		header.UDP(v[header.EthernetMinimumSize+header.IPv4MinimumSize:]).Encode(&header.UDPFields{
+			SrcPort: srcPort,
+			DstPort: 80,
+		})
+		vv := v.ToVectorisedView()
+		return e.hash(&vv)
+	}
+	if udp(1000) == udp(1001) {
+		t.Error("got the same hash for different flows")
+	}
+	if udp(1000) != udp(1000) {
+		t.Error("got different hashes for the same flow")
+	}
+}
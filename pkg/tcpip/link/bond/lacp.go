@@ -0,0 +1,421 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bond
+
+import (
+	"encoding/binary"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+const (
+	// slowProtocolsType is the ethertype of the Slow Protocols, which include
+	// LACP, as per IEEE 802.3 Annex 57A.
+	slowProtocolsType tcpip.NetworkProtocolNumber = 0x8809
+
+	// slowProtocolsAddress is the multicast address LACPDUs are sent to.
+	slowProtocolsAddress = tcpip.LinkAddress("\x01\x80\xc2\x00\x00\x02")
+
+	// The layout of LACPDUs, as per IEEE 802.1AX section 6.4.2.2. They are
+	// padded with a terminator TLV and reserved bytes to lacpduSize.
+	lacpSubtype           = 1
+	lacpVersion           = 1
+	lacpActorTLV          = 1
+	lacpPartnerTLV        = 2
+	lacpCollectorTLV      = 3
+	lacpInfoLength        = 20
+	lacpCollectorLength   = 16
+	lacpActorOffset       = 2
+	lacpPartnerOffset     = lacpActorOffset + lacpInfoLength
+	lacpCollectorOffset   = lacpPartnerOffset + lacpInfoLength
+	lacpduSize            = 110
+	lacpSystemPriority    = 0xffff
+	lacpPortPriority      = 0xff
+	lacpKey               = 1
+	lacpCollectorMaxDelay = 0
+
+	// fastPeriodicTime and slowPeriodicTime are the intervals between the
+	// LACPDUs sent to the partners which asked for short or long timeouts.
+	fastPeriodicTime = time.Second
+	slowPeriodicTime = 30 * time.Second
+
+	// shortTimeout and longTimeout are the times after which the information
+	// of a partner which stopped sending LACPDUs expires.
+	shortTimeout = 3 * fastPeriodicTime
+	longTimeout  = 3 * slowPeriodicTime
+)
+
+// The bits of the state of LACP actors and partners, as per IEEE 802.1AX
+// section 6.4.2.3.
+const (
+	lacpActivity        = 1 << 0
+	lacpTimeout         = 1 << 1
+	lacpAggregation     = 1 << 2
+	lacpSynchronization = 1 << 3
+	lacpCollecting      = 1 << 4
+	lacpDistributing    = 1 << 5
+	lacpDefaulted       = 1 << 6
+	lacpExpired         = 1 << 7
+)
+
+// lacpInfo is the information about an actor or a partner carried by
+// LACPDUs.
+type lacpInfo struct {
+	systemPriority uint16
+	system         tcpip.LinkAddress
+	key            uint16
+	portPriority   uint16
+	port           uint16
+	state          uint8
+}
+
+// encode encodes i in b as a TLV of type typ.
+func (i *lacpInfo) encode(b []byte, typ uint8) {
+	b[0] = typ
+	b[1] = lacpInfoLength
+	binary.BigEndian.PutUint16(b[2:], i.systemPriority)
+	copy(b[4:4+header.EthernetAddressSize], i.system)
+	binary.BigEndian.PutUint16(b[10:], i.key)
+	binary.BigEndian.PutUint16(b[12:], i.portPriority)
+	binary.BigEndian.PutUint16(b[14:], i.port)
+	b[16] = i.state
+}
+
+// decodeLACPInfo decodes the TLV at the front of b, which must be at least
+// lacpInfoLength bytes long.
+func decodeLACPInfo(b []byte) lacpInfo {
+	return lacpInfo{
+		systemPriority: binary.BigEndian.Uint16(b[2:]),
+		system:         tcpip.LinkAddress(b[4 : 4+header.EthernetAddressSize]),
+		key:            binary.BigEndian.Uint16(b[10:]),
+		portPriority:   binary.BigEndian.Uint16(b[12:]),
+		port:           binary.BigEndian.Uint16(b[14:]),
+		state:          b[16],
+	}
+}
+
+// partnerID identifies the partners with which slaves may be aggregated:
+// slaves connected to the same partner system with the same key.
+type partnerID struct {
+	systemPriority uint16
+	system         tcpip.LinkAddress
+	key            uint16
+}
+
+// lacpPort is the LACP state of a slave.
+type lacpPort struct {
+	// partner is the information about the partner of the slave received in
+	// its last LACPDU, if hasPartner is set.
+	partner    lacpInfo
+	hasPartner bool
+
+	// partnerExpiry is the monotonic time at which partner expires.
+	partnerExpiry int64
+
+	// aggregated is set if the slave is part of the aggregator of the bond,
+	// in which case the frames it receives are delivered to the stack.
+	aggregated bool
+
+	// ntt is set if an LACPDU must be sent through the slave without
+	// waiting for the next periodic one.
+	ntt bool
+
+	// nextTx is the monotonic time at which the next periodic LACPDU is sent
+	// through the slave.
+	nextTx int64
+}
+
+// id returns the ID of the partner of p.
+func (p *lacpPort) id() partnerID {
+	return partnerID{
+		systemPriority: p.partner.systemPriority,
+		system:         p.partner.system,
+		key:            p.partner.key,
+	}
+}
+
+// actorLocked returns the information about the bond as the actor of s.
+//
+// Precondition: e.mu must be locked.
+func (e *Endpoint) actorLocked(s *Slave) lacpInfo {
+	state := uint8(lacpActivity | lacpAggregation)
+	if e.lacpFast {
+		state |= lacpTimeout
+	}
+	if !s.lacp.hasPartner {
+		state |= lacpDefaulted
+	}
+	if s.lacp.aggregated {
+		state |= lacpSynchronization | lacpCollecting
+		if s.lacp.partner.state&lacpSynchronization != 0 {
+			state |= lacpDistributing
+		}
+	}
+	return lacpInfo{
+		systemPriority: lacpSystemPriority,
+		system:         e.linkAddr,
+		key:            lacpKey,
+		portPriority:   lacpPortPriority,
+		port:           s.port,
+		state:          state,
+	}
+}
+
+// selectAggregatorLocked selects the slaves in the aggregator of the bond and
+// the ones frames are distributed among.
+//
+// Like Linux's ad_select=count, the aggregator is made of the slaves whose
+// links are up and which are connected to the partner with the most such
+// slaves. The frames are only distributed among the slaves whose partner is in
+// sync with the bond.
+//
+// Precondition: e.mu must be locked.
+func (e *Endpoint) selectAggregatorLocked() {
+	counts := make(map[partnerID]int)
+	var best partnerID
+	for _, s := range e.slaves {
+		if !s.LinkUp() || !s.lacp.hasPartner {
+			continue
+		}
+		id := s.lacp.id()
+		counts[id]++
+		if counts[id] > counts[best] {
+			best = id
+		}
+	}
+
+	var distributing []*Slave
+	for _, s := range e.slaves {
+		old := e.actorLocked(s).state
+		s.lacp.aggregated = s.LinkUp() && s.lacp.hasPartner && s.lacp.id() == best
+		if e.actorLocked(s).state != old {
+			s.lacp.ntt = true
+		}
+		if s.lacp.aggregated && s.lacp.partner.state&lacpSynchronization != 0 {
+			distributing = append(distributing, s)
+		}
+	}
+	e.distributing = distributing
+}
+
+// lacpdu is an LACPDU to be sent through a slave.
+type lacpdu struct {
+	slave *Slave
+	frame buffer.View
+}
+
+// lacpduLocked returns an ethernet frame with the LACPDU of the bond for s.
+//
+// Precondition: e.mu must be locked.
+func (e *Endpoint) lacpduLocked(s *Slave) buffer.View {
+	v := buffer.NewView(header.EthernetMinimumSize + lacpduSize)
+	header.Ethernet(v).Encode(&header.EthernetFields{
+		SrcAddr: e.linkAddr,
+		DstAddr: slowProtocolsAddress,
+		Type:    slowProtocolsType,
+	})
+	pdu := v[header.EthernetMinimumSize:]
+	pdu[0] = lacpSubtype
+	pdu[1] = lacpVersion
+	actor := e.actorLocked(s)
+	actor.encode(pdu[lacpActorOffset:], lacpActorTLV)
+	// The partner is all zeros until the slave receives an LACPDU.
+	s.lacp.partner.encode(pdu[lacpPartnerOffset:], lacpPartnerTLV)
+	pdu[lacpCollectorOffset] = lacpCollectorTLV
+	pdu[lacpCollectorOffset+1] = lacpCollectorLength
+	binary.BigEndian.PutUint16(pdu[lacpCollectorOffset+2:], lacpCollectorMaxDelay)
+	// The terminator TLV and the reserved bytes are zero.
+	return v
+}
+
+// pendingLACPDUsLocked returns the LACPDUs to send through the slaves whose
+// links are up: the ones which must be sent immediately, and the periodic ones
+// which are due if periodic is set.
+//
+// Precondition: e.mu must be locked.
+func (e *Endpoint) pendingLACPDUsLocked(periodic bool) []lacpdu {
+	if e.mode != Mode8023AD {
+		return nil
+	}
+	now := e.clock.NowMonotonic()
+	var pdus []lacpdu
+	for _, s := range e.slaves {
+		if !s.LinkUp() {
+			continue
+		}
+		if !s.lacp.ntt && (!periodic || now < s.lacp.nextTx) {
+			continue
+		}
+		period := slowPeriodicTime
+		if s.lacp.partner.state&lacpTimeout != 0 {
+			period = fastPeriodicTime
+		}
+		s.lacp.ntt = false
+		s.lacp.nextTx = now + int64(period)
+		pdus = append(pdus, lacpdu{slave: s, frame: e.lacpduLocked(s)})
+	}
+	return pdus
+}
+
+// sendLACPDUs sends pdus. They must be sent without holding the mu of the
+// bond, as the partner may respond synchronously.
+func (*Endpoint) sendLACPDUs(pdus []lacpdu) {
+	for _, p := range pdus {
+		_ = p.slave.write(slowProtocolsAddress, slowProtocolsType, p.frame.ToVectorisedView())
+	}
+}
+
+// lacpTick expires the partners which stopped sending LACPDUs, and sends the
+// periodic LACPDUs which are due.
+func (e *Endpoint) lacpTick() {
+	now := e.clock.NowMonotonic()
+
+	e.mu.Lock()
+	expired := false
+	for _, s := range e.slaves {
+		if s.lacp.hasPartner && now >= s.lacp.partnerExpiry {
+			s.lacp.partner = lacpInfo{}
+			s.lacp.hasPartner = false
+			expired = true
+		}
+	}
+	if expired {
+		e.selectAggregatorLocked()
+	}
+	pdus := e.pendingLACPDUsLocked(true /* periodic */)
+	e.mu.Unlock()
+
+	e.sendLACPDUs(pdus)
+}
+
+// handleLACPDU handles v, a Slow Protocols frame received by s.
+func (e *Endpoint) handleLACPDU(s *Slave, v buffer.View) {
+	if len(v) < lacpCollectorOffset || v[0] != lacpSubtype || v[1] < lacpVersion {
+		return
+	}
+	if v[lacpActorOffset] != lacpActorTLV || v[lacpActorOffset+1] != lacpInfoLength {
+		return
+	}
+	if v[lacpPartnerOffset] != lacpPartnerTLV || v[lacpPartnerOffset+1] != lacpInfoLength {
+		return
+	}
+	partner := decodeLACPInfo(v[lacpActorOffset:])
+	// The information about the bond known by the partner.
+	actor := decodeLACPInfo(v[lacpPartnerOffset:])
+
+	timeout := longTimeout
+	if e.lacpFast {
+		timeout = shortTimeout
+	}
+
+	e.mu.Lock()
+	if !e.isSlaveLocked(s) {
+		e.mu.Unlock()
+		return
+	}
+	s.lacp.partnerExpiry = e.clock.NowMonotonic() + int64(timeout)
+	if !s.lacp.hasPartner || s.lacp.partner != partner {
+		s.lacp.partner = partner
+		s.lacp.hasPartner = true
+		e.selectAggregatorLocked()
+	}
+	if actor != e.actorLocked(s) {
+		// The partner is out of date.
+		s.lacp.ntt = true
+	}
+	pdus := e.pendingLACPDUsLocked(false /* periodic */)
+	e.mu.Unlock()
+
+	e.sendLACPDUs(pdus)
+}
+
+// hash returns the hash of frame, an ethernet frame, used to select the slave
+// it is sent through in Mode8023AD. Like Linux's, the hashes are the exclusive
+// or of the fields selected by the hash policy.
+func (e *Endpoint) hash(frame *buffer.VectorisedView) uint32 {
+	v, ok := frame.PullUp(header.EthernetMinimumSize)
+	if !ok {
+		return 0
+	}
+	eth := header.Ethernet(v)
+	src, dst := eth.SourceAddress(), eth.DestinationAddress()
+	h := uint32(src[len(src)-1]^dst[len(dst)-1]) ^ uint32(eth.Type())
+	if e.hashPolicy != HashLayer34 {
+		return h
+	}
+	if l34, ok := layer34Hash(eth.Type(), frame); ok {
+		h = l34
+	}
+	h ^= h >> 16
+	h ^= h >> 8
+	return h
+}
+
+// layer34Hash returns the hash of the network addresses and transport ports
+// of the IP packet in frame, an ethernet frame. The ports of fragments and of
+// other transport protocols than TCP and UDP aren't hashed.
+func layer34Hash(proto tcpip.NetworkProtocolNumber, frame *buffer.VectorisedView) (uint32, bool) {
+	var h uint32
+	var transport tcpip.TransportProtocolNumber
+	var transportOffset int
+	switch proto {
+	case header.IPv4ProtocolNumber:
+		v, ok := frame.PullUp(header.EthernetMinimumSize + header.IPv4MinimumSize)
+		if !ok {
+			return 0, false
+		}
+		ip := header.IPv4(v[header.EthernetMinimumSize:])
+		h = foldAddress(ip.SourceAddress()) ^ foldAddress(ip.DestinationAddress())
+		if ip.More() || ip.FragmentOffset() != 0 {
+			return h, true
+		}
+		transport = ip.TransportProtocol()
+		transportOffset = header.EthernetMinimumSize + int(ip.HeaderLength())
+	case header.IPv6ProtocolNumber:
+		v, ok := frame.PullUp(header.EthernetMinimumSize + header.IPv6MinimumSize)
+		if !ok {
+			return 0, false
+		}
+		ip := header.IPv6(v[header.EthernetMinimumSize:])
+		h = foldAddress(ip.SourceAddress()) ^ foldAddress(ip.DestinationAddress())
+		transport = ip.TransportProtocol()
+		transportOffset = header.EthernetMinimumSize + header.IPv6MinimumSize
+	default:
+		return 0, false
+	}
+
+	if transport != header.TCPProtocolNumber && transport != header.UDPProtocolNumber {
+		return h, true
+	}
+	// The source and destination ports are the first 4 bytes of both TCP and
+	// UDP headers.
+	v, ok := frame.PullUp(transportOffset + 4)
+	if !ok {
+		return h, true
+	}
+	return h ^ binary.BigEndian.Uint32(v[transportOffset:]), true
+}
+
+// foldAddress returns the exclusive or of the 32-bit words of addr.
+func foldAddress(addr tcpip.Address) uint32 {
+	var h uint32
+	for i := 0; i+4 <= len(addr); i += 4 {
+		h ^= binary.BigEndian.Uint32([]byte(addr[i : i+4]))
+	}
+	return h
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bond
+
+import (
+	"sync/atomic"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// CarrierReporter is implemented by the link endpoints which report the state
+// of their link, so that the MII monitor of the bonds they are slaves of may
+// poll it. The slaves also follow the changes of the carrier the endpoints
+// report.
+type CarrierReporter = stack.CarrierReporter
+
+// miiMonitor updates the state of the links of the slaves whose link
+// endpoints report their carrier.
+func (e *Endpoint) miiMonitor() {
+	for _, s := range e.Slaves() {
+		if c, ok := s.ep.(CarrierReporter); ok {
+			s.SetLinkUp(c.Carrier())
+		}
+	}
+}
+
+// arpMonitor updates the state of the links of the slaves from the time at
+// which they last received a frame, and sends ARP requests for the ARP
+// targets through all of them, so that the replies show that their links are
+// up.
+//
+// Like Linux's ARP monitor in the load balancing modes, the requests are sent
+// through all the slaves, including the ones whose link is down so that they
+// are detected when it comes back up.
+func (e *Endpoint) arpMonitor() {
+	now := e.clock.NowMonotonic()
+	for _, s := range e.Slaves() {
+		s.SetLinkUp(now-atomic.LoadInt64(&s.lastRx) < int64(2*e.arpInterval))
+		for _, target := range e.arpTargets {
+			_ = s.write(header.EthernetBroadcastAddress, header.ARPProtocolNumber, e.arpRequest(target))
+		}
+	}
+}
+
+// arpRequest returns an ethernet frame with an ARP request for target, sent
+// by the bond.
+func (e *Endpoint) arpRequest(target tcpip.Address) buffer.VectorisedView {
+	v := buffer.NewView(header.EthernetMinimumSize + header.ARPSize)
+	header.Ethernet(v).Encode(&header.EthernetFields{
+		SrcAddr: e.linkAddr,
+		DstAddr: header.EthernetBroadcastAddress,
+		Type:    header.ARPProtocolNumber,
+	})
+	h := header.ARP(v[header.EthernetMinimumSize:])
+	h.SetIPv4OverEthernet()
+	h.SetOp(header.ARPRequest)
+	copy(h.HardwareAddressSender(), e.linkAddr)
+	// The sender address of ARP probes is left unspecified.
+	copy(h.ProtocolAddressSender(), e.arpSource)
+	copy(h.ProtocolAddressTarget(), target)
+	return v.ToVectorisedView()
+}
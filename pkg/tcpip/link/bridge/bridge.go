@@ -0,0 +1,470 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bridge provides the implementation of a bridge link endpoint, which
+// connects several link endpoints, its ports, into a single ethernet segment
+// like Linux's bridge devices. The bridge is the interface of the stack on the
+// segment.
+//
+// The bridge learns the link addresses reachable through each port from the
+// source addresses of the frames received by the port. Frames to a known
+// address are forwarded to its port only, and other frames are flooded to all
+// the ports.
+//
+// With multicast snooping, the bridge also learns the ports with listeners of
+// each multicast group from the IGMP and MLD messages they receive, and
+// forwards multicast frames to these ports only.
+//
+// The ports of a bridge send and receive raw ethernet frames, as the link
+// endpoints wrapped by ethernet.Endpoint do.
+package bridge
+
+import (
+	"sync/atomic"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// defaultMTU is the MTU of bridges without ports, as in Linux.
+const defaultMTU = 1500
+
+// PortState is the forwarding state of a port, as defined by IEEE 802.1D. Its
+// values are those of Linux's BR_STATE_*.
+type PortState uint32
+
+// Port states.
+const (
+	// PortDisabled drops all frames.
+	PortDisabled PortState = iota
+
+	// PortListening drops all frames.
+	PortListening
+
+	// PortLearning learns the source addresses of the frames it receives,
+	// but drops them.
+	PortLearning
+
+	// PortForwarding learns the source addresses of the frames it receives
+	// and forwards them.
+	PortForwarding
+
+	// PortBlocking drops all frames.
+	PortBlocking
+)
+
+// Options specify the details of a bridge endpoint.
+type Options struct {
+	// LinkAddress is the link address of the bridge.
+	LinkAddress tcpip.LinkAddress
+
+	// Clock is used to expire the entries of the forwarding database. If
+	// nil, the system clock is used.
+	Clock tcpip.Clock
+
+	// AgingTime is the time after which the entries learned by the bridge
+	// expire. If 0, DefaultAgingTime is used.
+	AgingTime time.Duration
+
+	// MulticastSnooping enables multicast snooping.
+	MulticastSnooping bool
+
+	// MulticastQuerier makes the bridge send IGMP and MLD general queries.
+	// See Endpoint.SetMulticastQuerier.
+	MulticastQuerier bool
+
+	// MembershipInterval is the time after which the multicast memberships
+	// learned by the bridge expire. If 0, DefaultMembershipInterval is used.
+	MembershipInterval time.Duration
+
+	// QueryInterval is the interval between the general queries sent by the
+	// bridge. If 0, DefaultQueryInterval is used.
+	QueryInterval time.Duration
+}
+
+var _ stack.LinkEndpoint = (*Endpoint)(nil)
+
+// Endpoint is a bridge link endpoint.
+type Endpoint struct {
+	linkAddr tcpip.LinkAddress
+	fdb      fdb
+	snooping snooping
+
+	// mu protects the fields below.
+	mu         sync.RWMutex
+	dispatcher stack.NetworkDispatcher
+
+	// ports is never modified in place, so that it may be iterated over
+	// without holding mu.
+	ports []*Port
+}
+
+// New returns a bridge link endpoint without ports.
+func New(opts Options) *Endpoint {
+	clock := opts.Clock
+	if clock == nil {
+		clock = &tcpip.StdClock{}
+	}
+	agingTime := opts.AgingTime
+	if agingTime == 0 {
+		agingTime = DefaultAgingTime
+	}
+	e := &Endpoint{linkAddr: opts.LinkAddress}
+	e.fdb.init(clock, agingTime)
+	membershipInterval := opts.MembershipInterval
+	if membershipInterval == 0 {
+		membershipInterval = DefaultMembershipInterval
+	}
+	queryInterval := opts.QueryInterval
+	if queryInterval == 0 {
+		queryInterval = DefaultQueryInterval
+	}
+	e.snooping.init(clock, opts.MulticastSnooping, membershipInterval, queryInterval)
+	if opts.MulticastQuerier {
+		e.SetMulticastQuerier(true)
+	}
+	return e
+}
+
+var _ stack.NetworkDispatcher = (*Port)(nil)
+
+// Port is a port of a bridge.
+type Port struct {
+	bridge *Endpoint
+	ep     stack.LinkEndpoint
+
+	// state is the PortState of the port. It must be accessed using atomic
+	// operations.
+	state uint32
+}
+
+// AddPort adds a port sending and receiving frames through ep to the bridge.
+// The port is in the forwarding state.
+func (e *Endpoint) AddPort(ep stack.LinkEndpoint) *Port {
+	p := &Port{
+		bridge: e,
+		ep:     ep,
+		state:  uint32(PortForwarding),
+	}
+
+	e.mu.Lock()
+	e.ports = append(e.ports[:len(e.ports):len(e.ports)], p)
+	e.mu.Unlock()
+
+	ep.Attach(p)
+	return p
+}
+
+// RemovePort removes the port p from the bridge, along with its entries in
+// the forwarding databases. The link endpoint of the port remains attached to
+// the bridge, which drops the frames it receives.
+func (e *Endpoint) RemovePort(p *Port) *tcpip.Error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i, port := range e.ports {
+		if port != p {
+			continue
+		}
+		ports := make([]*Port, 0, len(e.ports)-1)
+		ports = append(ports, e.ports[:i]...)
+		e.ports = append(ports, e.ports[i+1:]...)
+
+		atomic.StoreUint32(&p.state, uint32(PortDisabled))
+		e.fdb.flush(p, true /* static */)
+		return nil
+	}
+	return tcpip.ErrUnknownDevice
+}
+
+// Ports returns the ports of the bridge.
+func (e *Endpoint) Ports() []*Port {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return append([]*Port(nil), e.ports...)
+}
+
+// FDB returns the entries of the forwarding database of the bridge.
+func (e *Endpoint) FDB() []FDBEntry {
+	return e.fdb.dump()
+}
+
+// AddStaticEntry adds a static entry to the forwarding database of the bridge,
+// so that frames to addr are forwarded to p only.
+func (e *Endpoint) AddStaticEntry(addr tcpip.LinkAddress, p *Port) *tcpip.Error {
+	if !header.IsValidUnicastEthernetAddress(addr) {
+		return tcpip.ErrBadAddress
+	}
+	e.fdb.addStatic(addr, p)
+	return nil
+}
+
+// RemoveEntry removes the entry of addr from the forwarding database of the
+// bridge.
+func (e *Endpoint) RemoveEntry(addr tcpip.LinkAddress) *tcpip.Error {
+	if !e.fdb.remove(addr) {
+		return tcpip.ErrBadAddress
+	}
+	return nil
+}
+
+// FlushFDB removes the dynamic entries of the forwarding database of the
+// bridge.
+func (e *Endpoint) FlushFDB() {
+	e.fdb.flush(nil /* port */, false /* static */)
+}
+
+// LinkEndpoint returns the link endpoint of p.
+func (p *Port) LinkEndpoint() stack.LinkEndpoint {
+	return p.ep
+}
+
+// State returns the forwarding state of p.
+func (p *Port) State() PortState {
+	return PortState(atomic.LoadUint32(&p.state))
+}
+
+// SetState sets the forwarding state of p. The addresses and multicast
+// memberships learned through p are forgotten when it stops learning.
+func (p *Port) SetState(state PortState) {
+	old := PortState(atomic.SwapUint32(&p.state, uint32(state)))
+	if old.learning() && !state.learning() {
+		p.bridge.fdb.flush(p, false /* static */)
+		p.bridge.snooping.removePort(p)
+	}
+}
+
+// learning returns true if ports in state s learn addresses.
+func (s PortState) learning() bool {
+	return s == PortLearning || s == PortForwarding
+}
+
+// DeliverNetworkPacket implements stack.NetworkDispatcher.
+func (p *Port) DeliverNetworkPacket(_, _ tcpip.LinkAddress, _ tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	p.bridge.receive(p, pkt)
+}
+
+// DeliverOutboundPacket implements stack.NetworkDispatcher.
+func (*Port) DeliverOutboundPacket(tcpip.LinkAddress, tcpip.LinkAddress, tcpip.NetworkProtocolNumber, *stack.PacketBuffer) {
+}
+
+// write sends frame, an ethernet frame from src to dst, out of p.
+func (p *Port) write(src, dst tcpip.LinkAddress, proto tcpip.NetworkProtocolNumber, frame buffer.VectorisedView) *tcpip.Error {
+	r := stack.Route{
+		LocalLinkAddress: src,
+		NetProto:         proto,
+	}
+	r.ResolveWith(dst)
+	// Each port is given its own view of the frame, whose bytes are never
+	// written to.
+	views := append([]buffer.View(nil), frame.Views()...)
+	return p.ep.WritePacket(&r, nil /* gso */, proto, stack.NewPacketBuffer(stack.PacketBufferOptions{
+		ReserveHeaderBytes: int(p.ep.MaxHeaderLength()),
+		Data:               buffer.NewVectorisedView(frame.Size(), views),
+	}))
+}
+
+// isGroupAddress returns true if addr is a broadcast or multicast address.
+func isGroupAddress(addr tcpip.LinkAddress) bool {
+	return addr == header.EthernetBroadcastAddress || header.IsMulticastEthernetAddress(addr)
+}
+
+// receive handles pkt, a frame received by the port in.
+func (e *Endpoint) receive(in *Port, pkt *stack.PacketBuffer) {
+	state := in.State()
+	if !state.learning() {
+		return
+	}
+
+	hdr, ok := pkt.LinkHeader().Consume(header.EthernetMinimumSize)
+	if !ok {
+		return
+	}
+	eth := header.Ethernet(hdr)
+	src, dst, proto := eth.SourceAddress(), eth.DestinationAddress(), eth.Type()
+	if header.IsValidUnicastEthernetAddress(src) {
+		e.fdb.learn(src, in)
+	}
+	if state != PortForwarding {
+		return
+	}
+
+	report := false
+	if header.IsMulticastEthernetAddress(dst) {
+		report = e.snoop(in, proto, pkt.Data.ToView())
+	}
+
+	local := dst == e.linkAddr
+	if !local {
+		frame := buffer.NewVectorisedView(len(hdr), []buffer.View{hdr})
+		frame.Append(pkt.Data)
+		if report {
+			// Like Linux, membership reports are only forwarded to
+			// multicast routers, so that listeners don't suppress each
+			// other's reports.
+			e.flood(in, e.snooping.routerPorts(), src, dst, proto, frame)
+		} else {
+			_ = e.forward(in, src, dst, proto, frame)
+		}
+	}
+	if local || isGroupAddress(dst) {
+		e.mu.RLock()
+		d := e.dispatcher
+		e.mu.RUnlock()
+		if d != nil {
+			d.DeliverNetworkPacket(src /* remote */, dst /* local */, proto, pkt)
+		}
+	}
+}
+
+// forward sends frame, an ethernet frame from src to dst, out of the port dst
+// is reachable through if known, or floods it out of all the forwarding ports
+// otherwise. Multicast frames to snooped groups are only sent out of the ports
+// with listeners of the group and of multicast routers. The frame is never sent back out of in, the port it was received
+// by, if any.
+//
+// Errors are only returned for frames forwarded to a known port.
+func (e *Endpoint) forward(in *Port, src, dst tcpip.LinkAddress, proto tcpip.NetworkProtocolNumber, frame buffer.VectorisedView) *tcpip.Error {
+	if !isGroupAddress(dst) {
+		if p, ok := e.fdb.lookup(dst); ok {
+			if p == in || p.State() != PortForwarding {
+				return nil
+			}
+			return p.write(src, dst, proto, frame)
+		}
+	}
+
+	ports, ok := e.snoopingPorts(dst, proto, frame)
+	if !ok {
+		e.mu.RLock()
+		ports = e.ports
+		e.mu.RUnlock()
+	}
+	e.flood(in, ports, src, dst, proto, frame)
+	return nil
+}
+
+// flood sends frame, an ethernet frame from src to dst, out of the forwarding
+// ports in ports, except in. Like Linux, flooding doesn't fail when some ports
+// do.
+func (e *Endpoint) flood(in *Port, ports []*Port, src, dst tcpip.LinkAddress, proto tcpip.NetworkProtocolNumber, frame buffer.VectorisedView) {
+	for _, p := range ports {
+		if p == in || p.State() != PortForwarding {
+			continue
+		}
+		_ = p.write(src, dst, proto, frame)
+	}
+}
+
+// MTU implements stack.LinkEndpoint.MTU. It is the smallest MTU of the ports
+// of the bridge.
+func (e *Endpoint) MTU() uint32 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if len(e.ports) == 0 {
+		return defaultMTU
+	}
+	mtu := e.ports[0].ep.MTU()
+	for _, p := range e.ports[1:] {
+		if m := p.ep.MTU(); m < mtu {
+			mtu = m
+		}
+	}
+	return mtu
+}
+
+// MaxHeaderLength implements stack.LinkEndpoint.MaxHeaderLength.
+func (e *Endpoint) MaxHeaderLength() uint16 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var max uint16
+	for _, p := range e.ports {
+		if l := p.ep.MaxHeaderLength(); l > max {
+			max = l
+		}
+	}
+	return header.EthernetMinimumSize + max
+}
+
+// LinkAddress implements stack.LinkEndpoint.LinkAddress.
+func (e *Endpoint) LinkAddress() tcpip.LinkAddress {
+	return e.linkAddr
+}
+
+// Capabilities implements stack.LinkEndpoint.Capabilities.
+func (*Endpoint) Capabilities() stack.LinkEndpointCapabilities {
+	return stack.CapabilityResolutionRequired
+}
+
+// Attach implements stack.LinkEndpoint.Attach.
+func (e *Endpoint) Attach(dispatcher stack.NetworkDispatcher) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dispatcher = dispatcher
+}
+
+// IsAttached implements stack.LinkEndpoint.IsAttached.
+func (e *Endpoint) IsAttached() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.dispatcher != nil
+}
+
+// Wait implements stack.LinkEndpoint.Wait. It waits for the link endpoints of
+// all the ports.
+func (e *Endpoint) Wait() {
+	for _, p := range e.Ports() {
+		p.ep.Wait()
+	}
+}
+
+// ARPHardwareType implements stack.LinkEndpoint.ARPHardwareType.
+func (*Endpoint) ARPHardwareType() header.ARPHardwareType {
+	return header.ARPHardwareEther
+}
+
+// AddHeader implements stack.LinkEndpoint.AddHeader.
+func (*Endpoint) AddHeader(local, remote tcpip.LinkAddress, proto tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	eth := header.Ethernet(pkt.LinkHeader().Push(header.EthernetMinimumSize))
+	eth.Encode(&header.EthernetFields{
+		SrcAddr: local,
+		DstAddr: remote,
+		Type:    proto,
+	})
+}
+
+// WritePacket implements stack.LinkEndpoint.WritePacket.
+func (e *Endpoint) WritePacket(r *stack.Route, _ *stack.GSO, proto tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) *tcpip.Error {
+	dst := r.RemoteLinkAddress()
+	e.AddHeader(e.linkAddr, dst, proto, pkt)
+	return e.forward(nil /* in */, e.linkAddr, dst, proto, buffer.NewVectorisedView(pkt.Size(), pkt.Views()))
+}
+
+// WritePackets implements stack.LinkEndpoint.WritePackets.
+func (e *Endpoint) WritePackets(r *stack.Route, gso *stack.GSO, pkts stack.PacketBufferList, proto tcpip.NetworkProtocolNumber) (int, *tcpip.Error) {
+	n := 0
+	for pkt := pkts.Front(); pkt != nil; pkt = pkt.Next() {
+		if err := e.WritePacket(r, gso, proto, pkt); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
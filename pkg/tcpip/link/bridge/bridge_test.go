@@ -0,0 +1,473 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bridge
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	bridgeAddr = tcpip.LinkAddress("\x02\x00\x00\x00\x00\x01")
+	host1Addr  = tcpip.LinkAddress("\x02\x00\x00\x00\x01\x01")
+	host2Addr  = tcpip.LinkAddress("\x02\x00\x00\x00\x01\x02")
+	host3Addr  = tcpip.LinkAddress("\x02\x00\x00\x00\x01\x03")
+	groupAddr  = tcpip.LinkAddress("\x01\x00\x5e\x00\x00\x01")
+
+	testProto = tcpip.NetworkProtocolNumber(0x88b5)
+	agingTime = time.Minute
+)
+
+var payload = []byte{1, 2, 3, 4}
+
+// delivery is a packet delivered to the stack by the bridge.
+type delivery struct {
+	remote, local tcpip.LinkAddress
+	proto         tcpip.NetworkProtocolNumber
+	payload       []byte
+}
+
+// testDispatcher records the packets delivered to the stack.
+type testDispatcher struct {
+	delivered []delivery
+}
+
+// DeliverNetworkPacket implements stack.NetworkDispatcher.
+func (d *testDispatcher) DeliverNetworkPacket(remote, local tcpip.LinkAddress, proto tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	d.delivered = append(d.delivered, delivery{
+		remote:  remote,
+		local:   local,
+		proto:   proto,
+		payload: pkt.Data.ToView(),
+	})
+}
+
+// DeliverOutboundPacket implements stack.NetworkDispatcher.
+func (*testDispatcher) DeliverOutboundPacket(tcpip.LinkAddress, tcpip.LinkAddress, tcpip.NetworkProtocolNumber, *stack.PacketBuffer) {
+}
+
+type testContext struct {
+	t          *testing.T
+	clock      *faketime.ManualClock
+	bridge     *Endpoint
+	dispatcher testDispatcher
+	links      []*channel.Endpoint
+	ports      []*Port
+}
+
+func newTestContext(t *testing.T, numPorts int) *testContext {
+	return newTestContextWithOptions(t, numPorts, Options{})
+}
+
+// newTestContextWithOptions returns a test context with a bridge created with
+// opts, whose link address, clock and aging time are overridden.
+func newTestContextWithOptions(t *testing.T, numPorts int, opts Options) *testContext {
+	c := &testContext{
+		t:     t,
+		clock: faketime.NewManualClock(),
+	}
+	opts.LinkAddress = bridgeAddr
+	opts.Clock = c.clock
+	opts.AgingTime = agingTime
+	c.bridge = New(opts)
+	c.bridge.Attach(&c.dispatcher)
+	for i := 0; i < numPorts; i++ {
+		link := channel.New(4, defaultMTU, "")
+		c.links = append(c.links, link)
+		c.ports = append(c.ports, c.bridge.AddPort(link))
+	}
+	return c
+}
+
+func frame(src, dst tcpip.LinkAddress) buffer.View {
+	v := buffer.NewView(header.EthernetMinimumSize + len(payload))
+	header.Ethernet(v).Encode(&header.EthernetFields{
+		SrcAddr: src,
+		DstAddr: dst,
+		Type:    testProto,
+	})
+	copy(v[header.EthernetMinimumSize:], payload)
+	return v
+}
+
+// inject injects a frame from src to dst on the port i.
+func (c *testContext) inject(i int, src, dst tcpip.LinkAddress) {
+	c.injectFrame(i, frame(src, dst))
+}
+
+// injectFrame injects f on the port i.
+func (c *testContext) injectFrame(i int, f buffer.View) {
+	c.links[i].InjectInbound(header.Ethernet(f).Type(), stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: f.ToVectorisedView(),
+	}))
+}
+
+// checkSent checks that the frame from src to dst was sent out of the ports
+// in want only.
+func (c *testContext) checkSent(src, dst tcpip.LinkAddress, want ...int) {
+	c.t.Helper()
+	c.checkSentFrame(frame(src, dst), want...)
+}
+
+// checkSentFrame checks that f was sent out of the ports in want only.
+func (c *testContext) checkSentFrame(f buffer.View, want ...int) {
+	c.t.Helper()
+
+	sent := make(map[int]bool)
+	for _, i := range want {
+		sent[i] = true
+	}
+	for i, link := range c.links {
+		p, ok := link.Read()
+		if ok != sent[i] {
+			c.t.Errorf("got frame sent out of port %d = %t, want = %t", i, ok, sent[i])
+			continue
+		}
+		if !ok {
+			continue
+		}
+		vv := buffer.NewVectorisedView(p.Pkt.Size(), p.Pkt.Views())
+		if got := vv.ToView(); !bytes.Equal(got, f) {
+			c.t.Errorf("got frame sent out of port %d = %x, want = %x", i, got, f)
+		}
+	}
+}
+
+// checkDelivered checks whether the frame from src to dst was delivered to the
+// stack.
+func (c *testContext) checkDelivered(src, dst tcpip.LinkAddress, want bool) {
+	c.t.Helper()
+
+	delivered := c.dispatcher.delivered
+	c.dispatcher.delivered = nil
+	if got := len(delivered) != 0; got != want {
+		c.t.Fatalf("got delivered = %t, want = %t", got, want)
+	}
+	if !want {
+		return
+	}
+	if len(delivered) != 1 {
+		c.t.Fatalf("got %d delivered frames, want = 1", len(delivered))
+	}
+	d := delivered[0]
+	if d.remote != src || d.local != dst || d.proto != testProto || !bytes.Equal(d.payload, payload) {
+		c.t.Errorf("got delivered = %#v, want = {remote: %s, local: %s, proto: %d, payload: %x}", d, src, dst, testProto, payload)
+	}
+}
+
+func TestForwarding(t *testing.T) {
+	c := newTestContext(t, 3)
+
+	// Frames to unknown addresses are flooded.
+	c.inject(0, host1Addr, host2Addr)
+	c.checkSent(host1Addr, host2Addr, 1, 2)
+	c.checkDelivered(host1Addr, host2Addr, false)
+
+	// Frames to learned addresses are forwarded to their port only.
+	c.inject(1, host2Addr, host1Addr)
+	c.checkSent(host2Addr, host1Addr, 0)
+	c.inject(0, host1Addr, host2Addr)
+	c.checkSent(host1Addr, host2Addr, 1)
+
+	// Frames to an address on the port they were received by are dropped.
+	c.inject(1, host3Addr, host2Addr)
+	c.checkSent(host3Addr, host2Addr)
+
+	// Broadcast and multicast frames are flooded and delivered locally.
+	for _, dst := range []tcpip.LinkAddress{header.EthernetBroadcastAddress, groupAddr} {
+		c.inject(0, host1Addr, dst)
+		c.checkSent(host1Addr, dst, 1, 2)
+		c.checkDelivered(host1Addr, dst, true)
+	}
+
+	// Frames to the bridge are only delivered locally.
+	c.inject(0, host1Addr, bridgeAddr)
+	c.checkSent(host1Addr, bridgeAddr)
+	c.checkDelivered(host1Addr, bridgeAddr, true)
+}
+
+func TestAging(t *testing.T) {
+	c := newTestContext(t, 3)
+
+	c.inject(0, host1Addr, host2Addr)
+	c.checkSent(host1Addr, host2Addr, 1, 2)
+	if got := c.bridge.FDB(); len(got) != 1 || got[0] != (FDBEntry{Addr: host1Addr, Port: c.ports[0]}) {
+		t.Fatalf("got FDB() = %#v, want = [{Addr: %s, Port: %p}]", got, host1Addr, c.ports[0])
+	}
+
+	// Learned addresses expire.
+	c.clock.Advance(agingTime + time.Second)
+	if got := c.bridge.FDB(); len(got) != 0 {
+		t.Fatalf("got FDB() = %#v, want = []", got)
+	}
+	c.inject(1, host2Addr, host1Addr)
+	c.checkSent(host2Addr, host1Addr, 0, 2)
+
+	// Static entries don't expire, and aren't overridden by learning.
+	if err := c.bridge.AddStaticEntry(host3Addr, c.ports[2]); err != nil {
+		t.Fatalf("AddStaticEntry(%s, _): %s", host3Addr, err)
+	}
+	c.clock.Advance(agingTime + time.Second)
+	c.inject(0, host3Addr, host2Addr)
+	c.checkSent(host3Addr, host2Addr, 1, 2)
+	c.inject(1, host2Addr, host3Addr)
+	c.checkSent(host2Addr, host3Addr, 2)
+
+	// Flushing the forwarding database only forgets the learned addresses.
+	c.bridge.FlushFDB()
+	if got := c.bridge.FDB(); len(got) != 1 || got[0] != (FDBEntry{Addr: host3Addr, Port: c.ports[2], Static: true}) {
+		t.Fatalf("got FDB() = %#v, want = [{Addr: %s, Port: %p, Static: true}]", got, host3Addr, c.ports[2])
+	}
+}
+
+func TestPortState(t *testing.T) {
+	c := newTestContext(t, 3)
+
+	// Blocking ports neither learn nor forward.
+	c.ports[0].SetState(PortBlocking)
+	c.inject(0, host1Addr, header.EthernetBroadcastAddress)
+	c.checkSent(host1Addr, header.EthernetBroadcastAddress)
+	c.checkDelivered(host1Addr, header.EthernetBroadcastAddress, false)
+	c.inject(1, host2Addr, host1Addr)
+	c.checkSent(host2Addr, host1Addr, 2)
+
+	// Learning ports learn but don't forward.
+	c.ports[0].SetState(PortLearning)
+	c.inject(0, host1Addr, host2Addr)
+	c.checkSent(host1Addr, host2Addr)
+	c.inject(1, host2Addr, host1Addr)
+	c.checkSent(host2Addr, host1Addr)
+
+	// Frames are forwarded to ports in the forwarding state.
+	c.ports[0].SetState(PortForwarding)
+	c.inject(1, host2Addr, host1Addr)
+	c.checkSent(host2Addr, host1Addr, 0)
+
+	// Disabling a port forgets the addresses learned through it.
+	c.ports[0].SetState(PortDisabled)
+	if _, ok := c.bridge.fdb.lookup(host1Addr); ok {
+		t.Errorf("got fdb.lookup(%s) = (_, true) after disabling its port, want = (_, false)", host1Addr)
+	}
+
+	// Removed ports aren't flooded to.
+	if err := c.bridge.RemovePort(c.ports[2]); err != nil {
+		t.Fatalf("RemovePort(_): %s", err)
+	}
+	if err := c.bridge.RemovePort(c.ports[2]); err != tcpip.ErrUnknownDevice {
+		t.Fatalf("got RemovePort(_) = %v, want = %s", err, tcpip.ErrUnknownDevice)
+	}
+	c.ports[0].SetState(PortForwarding)
+	c.inject(0, host1Addr, host3Addr)
+	c.checkSent(host1Addr, host3Addr, 1)
+}
+
+func TestWritePacket(t *testing.T) {
+	c := newTestContext(t, 2)
+
+	write := func(dst tcpip.LinkAddress) {
+		t.Helper()
+
+		var r stack.Route
+		r.ResolveWith(dst)
+		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+			ReserveHeaderBytes: int(c.bridge.MaxHeaderLength()),
+			Data:               buffer.View(payload).ToVectorisedView(),
+		})
+		if err := c.bridge.WritePacket(&r, nil /* gso */, testProto, pkt); err != nil {
+			t.Fatalf("WritePacket(_, nil, %d, _): %s", testProto, err)
+		}
+	}
+
+	// Frames from the stack are flooded to unknown addresses, and never
+	// delivered back to the stack.
+	write(host2Addr)
+	c.checkSent(bridgeAddr, host2Addr, 0, 1)
+	c.checkDelivered(bridgeAddr, host2Addr, false)
+
+	c.inject(1, host2Addr, bridgeAddr)
+	c.checkDelivered(host2Addr, bridgeAddr, true)
+	write(host2Addr)
+	c.checkSent(bridgeAddr, host2Addr, 1)
+
+	if got, want := c.bridge.MTU(), uint32(defaultMTU); got != want {
+		t.Errorf("got MTU() = %d, want = %d", got, want)
+	}
+}
+
+const (
+	routerIPv4Addr = tcpip.Address("\x0a\x00\x00\x01")
+	hostIPv4Addr   = tcpip.Address("\x0a\x00\x00\x02")
+	groupIPv4Addr  = tcpip.Address("\xef\x01\x01\x01")
+	hostIPv6Addr   = tcpip.Address("\xfe\x80\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02")
+	groupIPv6Addr  = tcpip.Address("\xff\x0e\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01")
+
+	// mldv2RoutersAddr is the address MLDv2 reports are sent to, as per RFC
+	// 3810 section 5.2.14.
+	mldv2RoutersAddr = tcpip.Address("\xff\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x16")
+)
+
+// ipv4Frame returns an ethernet frame from src holding an IPv4 packet from
+// srcAddr to dstAddr, with the transport protocol proto and payload.
+func ipv4Frame(src tcpip.LinkAddress, srcAddr, dstAddr tcpip.Address, proto tcpip.TransportProtocolNumber, payload []byte) buffer.View {
+	v := buffer.NewView(header.EthernetMinimumSize + header.IPv4MinimumSize + len(payload))
+	header.Ethernet(v).Encode(&header.EthernetFields{
+		SrcAddr: src,
+		DstAddr: header.EthernetAddressFromMulticastIPv4Address(dstAddr),
+		Type:    header.IPv4ProtocolNumber,
+	})
+	ip := header.IPv4(v[header.EthernetMinimumSize:])
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(ip)),
+		TTL:         1,
+		Protocol:    uint8(proto),
+		SrcAddr:     srcAddr,
+		DstAddr:     dstAddr,
+	})
+	copy(ip[header.IPv4MinimumSize:], payload)
+	return v
+}
+
+// igmpFrame returns an ethernet frame from src holding an IGMP message of type
+// typ for group, from srcAddr.
+func igmpFrame(src tcpip.LinkAddress, srcAddr tcpip.Address, typ header.IGMPType, group tcpip.Address) buffer.View {
+	igmp := header.IGMP(buffer.NewView(header.IGMPMinimumSize))
+	igmp.SetType(typ)
+	igmp.SetGroupAddress(group)
+	dst := group
+	if typ == header.IGMPMembershipQuery {
+		dst = header.IPv4AllSystems
+	}
+	return ipv4Frame(src, srcAddr, dst, header.IGMPProtocolNumber, igmp)
+}
+
+// ipv6Frame returns an ethernet frame from src holding an IPv6 packet to
+// dstAddr, with the next header next and payload.
+func ipv6Frame(src tcpip.LinkAddress, dstAddr tcpip.Address, next tcpip.TransportProtocolNumber, payload []byte) buffer.View {
+	v := buffer.NewView(header.EthernetMinimumSize + header.IPv6MinimumSize + len(payload))
+	header.Ethernet(v).Encode(&header.EthernetFields{
+		SrcAddr: src,
+		DstAddr: header.EthernetAddressFromMulticastIPv6Address(dstAddr),
+		Type:    header.IPv6ProtocolNumber,
+	})
+	ip := header.IPv6(v[header.EthernetMinimumSize:])
+	ip.Encode(&header.IPv6Fields{
+		PayloadLength: uint16(len(payload)),
+		NextHeader:    uint8(next),
+		HopLimit:      1,
+		SrcAddr:       hostIPv6Addr,
+		DstAddr:       dstAddr,
+	})
+	copy(ip[header.IPv6MinimumSize:], payload)
+	return v
+}
+
+func TestMulticastSnooping(t *testing.T) {
+	c := newTestContextWithOptions(t, 3, Options{MulticastSnooping: true})
+
+	// Frames to groups without listeners are flooded.
+	data := ipv4Frame(host3Addr, hostIPv4Addr, groupIPv4Addr, header.UDPProtocolNumber, payload)
+	c.injectFrame(2, data)
+	c.checkSentFrame(data, 0, 1)
+
+	// Frames to groups with listeners are only sent out of their ports.
+	report := igmpFrame(host1Addr, hostIPv4Addr, header.IGMPv2MembershipReport, groupIPv4Addr)
+	c.injectFrame(0, report)
+	c.checkSentFrame(report)
+	if got, want := c.bridge.MDB(), []MDBEntry{{Group: groupIPv4Addr, Port: c.ports[0]}}; len(got) != 1 || got[0] != want[0] {
+		t.Fatalf("got MDB() = %#v, want = %#v", got, want)
+	}
+	c.injectFrame(2, data)
+	c.checkSentFrame(data, 0)
+
+	// Ports of multicast routers receive the frames to all the groups with
+	// listeners.
+	query := igmpFrame(host2Addr, routerIPv4Addr, header.IGMPMembershipQuery, "\x00\x00\x00\x00")
+	c.injectFrame(1, query)
+	c.checkSentFrame(query, 0, 2)
+	if got := c.bridge.RouterPorts(); len(got) != 1 || got[0] != c.ports[1] {
+		t.Fatalf("got RouterPorts() = %#v, want = [%p]", got, c.ports[1])
+	}
+	c.injectFrame(2, data)
+	c.checkSentFrame(data, 0, 1)
+
+	// Frames to groups whose listeners left are flooded again. Reports and
+	// leaves are only forwarded to multicast routers.
+	leave := igmpFrame(host1Addr, hostIPv4Addr, header.IGMPLeaveGroup, groupIPv4Addr)
+	c.injectFrame(0, leave)
+	c.checkSentFrame(leave, 1)
+	if got := c.bridge.MDB(); len(got) != 0 {
+		t.Fatalf("got MDB() = %#v, want = []", got)
+	}
+	c.injectFrame(2, data)
+	c.checkSentFrame(data, 0, 1)
+
+	// Memberships reported with MLDv2 are snooped.
+	mld := buffer.NewView(mldv2ReportMinimumSize + mldv2RecordMinimumSize)
+	header.ICMPv6(mld).SetType(mldv2ListenerReport)
+	mld[7] = 1 // Number of records.
+	mld[mldv2ReportMinimumSize] = byte(header.IGMPv3ReportRecordChangeToExcludeMode)
+	copy(mld[mldv2ReportMinimumSize+4:], groupIPv6Addr)
+	mldReport := ipv6Frame(host1Addr, mldv2RoutersAddr, header.ICMPv6ProtocolNumber, mld)
+	c.injectFrame(0, mldReport)
+	c.checkSentFrame(mldReport, 1)
+	data = ipv6Frame(host3Addr, groupIPv6Addr, header.UDPProtocolNumber, payload)
+	c.injectFrame(2, data)
+	c.checkSentFrame(data, 0, 1)
+
+	// Memberships expire.
+	c.clock.Advance(DefaultMembershipInterval + time.Second)
+	if got := c.bridge.MDB(); len(got) != 0 {
+		t.Fatalf("got MDB() = %#v, want = []", got)
+	}
+	c.injectFrame(2, data)
+	c.checkSentFrame(data, 0, 1)
+}
+
+func TestMulticastQuerier(t *testing.T) {
+	c := newTestContextWithOptions(t, 2, Options{
+		MulticastSnooping: true,
+		MulticastQuerier:  true,
+	})
+
+	c.clock.Advance(DefaultQueryInterval)
+	for i, link := range c.links {
+		for _, want := range []tcpip.NetworkProtocolNumber{header.IPv4ProtocolNumber, header.IPv6ProtocolNumber} {
+			p, ok := link.Read()
+			if !ok {
+				t.Fatalf("got no query sent out of port %d, want protocol %d query", i, want)
+			}
+			if got := p.Proto; got != want {
+				t.Errorf("got query sent out of port %d with protocol %d, want = %d", i, got, want)
+			}
+		}
+	}
+
+	// No query is sent once the bridge stops being a querier.
+	c.bridge.SetMulticastQuerier(false)
+	c.clock.Advance(DefaultQueryInterval)
+	for i, link := range c.links {
+		if _, ok := link.Read(); ok {
+			t.Errorf("got query sent out of port %d after disabling the querier", i)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bridge
+
+import (
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// DefaultAgingTime is the default time after which the dynamic entries of the
+// forwarding database expire, as in Linux.
+const DefaultAgingTime = 300 * time.Second
+
+// FDBEntry is an entry of the forwarding database of a bridge, which maps a
+// link address to the port it is reachable through.
+type FDBEntry struct {
+	// Addr is the link address of the entry.
+	Addr tcpip.LinkAddress
+
+	// Port is the port Addr is reachable through.
+	Port *Port
+
+	// Static is true if the entry was added with AddStaticEntry. Static
+	// entries don't expire.
+	Static bool
+}
+
+type fdbEntry struct {
+	port   *Port
+	static bool
+
+	// updated is the monotonic time at which the entry was last learned.
+	updated int64
+}
+
+// fdb is the forwarding database of a bridge.
+type fdb struct {
+	clock     tcpip.Clock
+	agingTime time.Duration
+
+	// mu protects the fields below.
+	mu      sync.RWMutex
+	entries map[tcpip.LinkAddress]fdbEntry
+
+	// lastSweep is the monotonic time at which the expired entries were last
+	// removed.
+	lastSweep int64
+}
+
+func (f *fdb) init(clock tcpip.Clock, agingTime time.Duration) {
+	f.clock = clock
+	f.agingTime = agingTime
+	f.entries = make(map[tcpip.LinkAddress]fdbEntry)
+	f.lastSweep = clock.NowMonotonic()
+}
+
+// expired returns true if e has expired at the monotonic time now.
+func (f *fdb) expired(e fdbEntry, now int64) bool {
+	return !e.static && time.Duration(now-e.updated) > f.agingTime
+}
+
+// learn records that addr is reachable through p, unless there is a static
+// entry for addr.
+func (f *fdb) learn(addr tcpip.LinkAddress, p *Port) {
+	now := f.clock.NowMonotonic()
+
+	f.mu.RLock()
+	e, ok := f.entries[addr]
+	f.mu.RUnlock()
+	// Refreshing entries is the common case, avoid taking the write lock
+	// for each received frame when it is unnecessary.
+	if ok && e.static {
+		return
+	}
+	if ok && e.port == p && time.Duration(now-e.updated) < time.Second {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if e, ok := f.entries[addr]; ok && e.static {
+		return
+	}
+	f.entries[addr] = fdbEntry{port: p, updated: now}
+	if time.Duration(now-f.lastSweep) > f.agingTime {
+		for addr, e := range f.entries {
+			if f.expired(e, now) {
+				delete(f.entries, addr)
+			}
+		}
+		f.lastSweep = now
+	}
+}
+
+// lookup returns the port addr is reachable through, if known.
+func (f *fdb) lookup(addr tcpip.LinkAddress) (*Port, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	e, ok := f.entries[addr]
+	if !ok || f.expired(e, f.clock.NowMonotonic()) {
+		return nil, false
+	}
+	return e.port, true
+}
+
+// addStatic adds a static entry mapping addr to p.
+func (f *fdb) addStatic(addr tcpip.LinkAddress, p *Port) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries[addr] = fdbEntry{port: p, static: true}
+}
+
+// remove removes the entry of addr, and returns true if there was one.
+func (f *fdb) remove(addr tcpip.LinkAddress) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.entries[addr]
+	delete(f.entries, addr)
+	return ok
+}
+
+// flush removes the dynamic entries of p, or of all the ports if p is nil. If
+// static is true, static entries are removed too.
+func (f *fdb) flush(p *Port, static bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for addr, e := range f.entries {
+		if (p == nil || e.port == p) && (static || !e.static) {
+			delete(f.entries, addr)
+		}
+	}
+}
+
+// dump returns the entries which haven't expired.
+func (f *fdb) dump() []FDBEntry {
+	now := f.clock.NowMonotonic()
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	entries := make([]FDBEntry, 0, len(f.entries))
+	for addr, e := range f.entries {
+		if !f.expired(e, now) {
+			entries = append(entries, FDBEntry{Addr: addr, Port: e.port, Static: e.static})
+		}
+	}
+	return entries
+}
@@ -0,0 +1,583 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bridge
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+const (
+	// DefaultMembershipInterval is the default time after which the
+	// memberships learned by a bridge expire, as in Linux.
+	DefaultMembershipInterval = 260 * time.Second
+
+	// DefaultQueryInterval is the default interval between the general
+	// queries sent by a bridge acting as querier, as in Linux.
+	DefaultQueryInterval = 125 * time.Second
+
+	// queryResponseInterval is the maximum response delay advertised in the
+	// general queries sent by a bridge, as in Linux.
+	queryResponseInterval = 10 * time.Second
+
+	// mldv2ListenerReport is the ICMPv6 type of MLDv2 Multicast Listener
+	// Reports, as per RFC 3810 section 5.2.
+	mldv2ListenerReport header.ICMPv6Type = 143
+
+	// mldv2ReportMinimumSize is the size of the header of an MLDv2 Multicast
+	// Listener Report, and mldv2RecordMinimumSize the size of the header of
+	// its Multicast Address Records, as per RFC 3810 section 5.2.
+	mldv2ReportMinimumSize = 8
+	mldv2RecordMinimumSize = 4 + header.IPv6AddressSize
+)
+
+// MDBEntry is an entry of the multicast forwarding database of a bridge, which
+// records that a port has listeners of a multicast group.
+type MDBEntry struct {
+	// Group is the IPv4 or IPv6 address of the multicast group.
+	Group tcpip.Address
+
+	// Port is the port with listeners of Group.
+	Port *Port
+}
+
+// snooping is the multicast snooping state of a bridge, learned from the IGMP
+// and MLD messages received by its ports.
+type snooping struct {
+	clock              tcpip.Clock
+	membershipInterval time.Duration
+	queryInterval      time.Duration
+
+	// enabled is 1 if multicast snooping is enabled. It must be accessed
+	// using atomic operations.
+	enabled uint32
+
+	// mu protects the fields below.
+	mu sync.Mutex
+
+	// groups maps multicast groups to the monotonic time at which the
+	// membership of each of their ports expires.
+	groups map[tcpip.Address]map[*Port]int64
+
+	// routers maps the ports multicast routers are reachable through to the
+	// monotonic time at which they expire.
+	routers map[*Port]int64
+
+	// querier is the timer sending general queries, or nil if the bridge
+	// isn't a querier.
+	querier tcpip.Timer
+}
+
+func (s *snooping) init(clock tcpip.Clock, enabled bool, membershipInterval, queryInterval time.Duration) {
+	s.clock = clock
+	s.membershipInterval = membershipInterval
+	s.queryInterval = queryInterval
+	if enabled {
+		s.enabled = 1
+	}
+	s.groups = make(map[tcpip.Address]map[*Port]int64)
+	s.routers = make(map[*Port]int64)
+}
+
+func (s *snooping) isEnabled() bool {
+	return atomic.LoadUint32(&s.enabled) == 1
+}
+
+// join records that p has listeners of group.
+func (s *snooping) join(group tcpip.Address, p *Port) {
+	expires := s.clock.NowMonotonic() + s.membershipInterval.Nanoseconds()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ports, ok := s.groups[group]
+	if !ok {
+		ports = make(map[*Port]int64)
+		s.groups[group] = ports
+	}
+	ports[p] = expires
+}
+
+// leave records that p no longer has listeners of group.
+//
+// Unlike Linux, the bridge doesn't query p for remaining listeners before
+// forgetting the membership, as with Linux's multicast_fast_leave.
+func (s *snooping) leave(group tcpip.Address, p *Port) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ports, ok := s.groups[group]
+	if !ok {
+		return
+	}
+	delete(ports, p)
+	if len(ports) == 0 {
+		delete(s.groups, group)
+	}
+}
+
+// addRouter records that a multicast router is reachable through p.
+func (s *snooping) addRouter(p *Port) {
+	expires := s.clock.NowMonotonic() + s.membershipInterval.Nanoseconds()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routers[p] = expires
+}
+
+// removePort forgets the memberships and routers of p.
+func (s *snooping) removePort(p *Port) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for group, ports := range s.groups {
+		delete(ports, p)
+		if len(ports) == 0 {
+			delete(s.groups, group)
+		}
+	}
+	delete(s.routers, p)
+}
+
+// flush forgets all the memberships and routers.
+func (s *snooping) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.groups = make(map[tcpip.Address]map[*Port]int64)
+	s.routers = make(map[*Port]int64)
+}
+
+// lookup returns the ports frames to group must be sent out of: the ports with
+// listeners of group and the ports of multicast routers. It returns false if
+// no port has listeners of group, in which case frames to group are flooded.
+func (s *snooping) lookup(group tcpip.Address) ([]*Port, bool) {
+	now := s.clock.NowMonotonic()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ports []*Port
+	for p, expires := range s.groups[group] {
+		if expires < now {
+			delete(s.groups[group], p)
+			continue
+		}
+		ports = append(ports, p)
+	}
+	if len(ports) == 0 {
+		delete(s.groups, group)
+		return nil, false
+	}
+	for p, expires := range s.routers {
+		if expires < now {
+			delete(s.routers, p)
+			continue
+		}
+		if _, ok := s.groups[group][p]; !ok {
+			ports = append(ports, p)
+		}
+	}
+	return ports, true
+}
+
+// dump returns the memberships which haven't expired.
+func (s *snooping) dump() []MDBEntry {
+	now := s.clock.NowMonotonic()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entries []MDBEntry
+	for group, ports := range s.groups {
+		for p, expires := range ports {
+			if expires >= now {
+				entries = append(entries, MDBEntry{Group: group, Port: p})
+			}
+		}
+	}
+	return entries
+}
+
+// routerPorts returns the ports of the multicast routers which haven't
+// expired.
+func (s *snooping) routerPorts() []*Port {
+	now := s.clock.NowMonotonic()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ports []*Port
+	for p, expires := range s.routers {
+		if expires >= now {
+			ports = append(ports, p)
+		}
+	}
+	return ports
+}
+
+// SetMulticastSnooping enables or disables multicast snooping. When disabled,
+// the multicast forwarding database is flushed and multicast frames are
+// flooded.
+func (e *Endpoint) SetMulticastSnooping(v bool) {
+	var enabled uint32
+	if v {
+		enabled = 1
+	}
+	if old := atomic.SwapUint32(&e.snooping.enabled, enabled); old == 1 && !v {
+		e.snooping.flush()
+	}
+}
+
+// SetMulticastQuerier sets whether the bridge acts as an IGMP and MLD querier,
+// sending general queries out of its ports every query interval so that the
+// listeners on the segment report their memberships even if there is no
+// multicast router.
+func (e *Endpoint) SetMulticastQuerier(v bool) {
+	s := &e.snooping
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !v {
+		if s.querier != nil {
+			s.querier.Stop()
+			s.querier = nil
+		}
+		return
+	}
+	if s.querier == nil {
+		e.scheduleQueryLocked()
+	}
+}
+
+// scheduleQueryLocked schedules the next general queries.
+//
+// Precondition: e.snooping.mu must be locked.
+func (e *Endpoint) scheduleQueryLocked() {
+	s := &e.snooping
+	var timer tcpip.Timer
+	timer = s.clock.AfterFunc(s.queryInterval, func() {
+		s.mu.Lock()
+		if s.querier != timer {
+			// The bridge stopped being a querier.
+			s.mu.Unlock()
+			return
+		}
+		e.scheduleQueryLocked()
+		s.mu.Unlock()
+
+		e.sendQueries()
+	})
+	s.querier = timer
+}
+
+// MDB returns the entries of the multicast forwarding database of the bridge.
+func (e *Endpoint) MDB() []MDBEntry {
+	return e.snooping.dump()
+}
+
+// RouterPorts returns the ports multicast routers are reachable through, which
+// are learned from the queries the ports receive. Multicast frames to groups
+// with listeners are also sent out of these ports.
+func (e *Endpoint) RouterPorts() []*Port {
+	return e.snooping.routerPorts()
+}
+
+// snoopedGroup returns the multicast group of the IP packet v, if frames to
+// the group are only forwarded to its listeners. Frames to the groups in
+// 224.0.0.0/24 and to ff02::1 are always flooded, as in Linux.
+func snoopedGroup(proto tcpip.NetworkProtocolNumber, v buffer.View) (tcpip.Address, bool) {
+	switch proto {
+	case header.IPv4ProtocolNumber:
+		if len(v) < header.IPv4MinimumSize {
+			return "", false
+		}
+		group := header.IPv4(v).DestinationAddress()
+		if !header.IsV4MulticastAddress(group) || isLinkLocalIPv4Group(group) {
+			return "", false
+		}
+		return group, true
+	case header.IPv6ProtocolNumber:
+		if len(v) < header.IPv6MinimumSize {
+			return "", false
+		}
+		group := header.IPv6(v).DestinationAddress()
+		if !header.IsV6MulticastAddress(group) || group == header.IPv6AllNodesMulticastAddress {
+			return "", false
+		}
+		return group, true
+	default:
+		return "", false
+	}
+}
+
+// isLinkLocalIPv4Group returns true if group is in 224.0.0.0/24, whose
+// memberships are never reported, as per RFC 4541 section 2.1.2.
+func isLinkLocalIPv4Group(group tcpip.Address) bool {
+	return group[0] == 224 && group[1] == 0 && group[2] == 0
+}
+
+// snoopingPorts returns the ports frame, an ethernet frame to the multicast
+// address dst, must be sent out of. It returns false if the frame must be
+// flooded.
+func (e *Endpoint) snoopingPorts(dst tcpip.LinkAddress, proto tcpip.NetworkProtocolNumber, frame buffer.VectorisedView) ([]*Port, bool) {
+	if dst == header.EthernetBroadcastAddress || !e.snooping.isEnabled() {
+		return nil, false
+	}
+	v := frame.ToView()
+	group, ok := snoopedGroup(proto, v[header.EthernetMinimumSize:])
+	if !ok {
+		return nil, false
+	}
+	return e.snooping.lookup(group)
+}
+
+// snoop learns the memberships and routers reachable through the port in from
+// the IGMP or MLD message in v, an IP packet received by in. It returns true if
+// v is a membership report or leave.
+func (e *Endpoint) snoop(in *Port, proto tcpip.NetworkProtocolNumber, v buffer.View) bool {
+	if !e.snooping.isEnabled() {
+		return false
+	}
+	switch proto {
+	case header.IPv4ProtocolNumber:
+		return e.snoopIGMP(in, v)
+	case header.IPv6ProtocolNumber:
+		return e.snoopMLD(in, v)
+	default:
+		return false
+	}
+}
+
+func (e *Endpoint) snoopIGMP(in *Port, v buffer.View) bool {
+	ip := header.IPv4(v)
+	if !ip.IsValid(len(v)) || ip.TransportProtocol() != header.IGMPProtocolNumber {
+		return false
+	}
+	igmp := header.IGMP(v[ip.HeaderLength():ip.TotalLength()])
+	if len(igmp) < header.IGMPMinimumSize {
+		return false
+	}
+
+	update := func(group tcpip.Address, join bool) {
+		if !header.IsV4MulticastAddress(group) || isLinkLocalIPv4Group(group) {
+			return
+		}
+		if join {
+			e.snooping.join(group, in)
+		} else {
+			e.snooping.leave(group, in)
+		}
+	}
+	switch igmp.Type() {
+	case header.IGMPMembershipQuery:
+		// Queries from 0.0.0.0 are sent by other snooping bridges, not
+		// routers.
+		if ip.SourceAddress() != header.IPv4Any {
+			e.snooping.addRouter(in)
+		}
+		return false
+	case header.IGMPv1MembershipReport, header.IGMPv2MembershipReport:
+		update(igmp.GroupAddress(), true /* join */)
+		return true
+	case header.IGMPLeaveGroup:
+		update(igmp.GroupAddress(), false /* join */)
+		return true
+	case header.IGMPv3MembershipReport:
+		if len(igmp) < header.IGMPv3ReportMinimumSize {
+			return false
+		}
+		records, ok := header.IGMPv3Report(igmp).GroupRecords()
+		if !ok {
+			return false
+		}
+		for _, r := range records {
+			update(r.GroupAddress, joins(r.RecordType, len(r.Sources)))
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// joins returns whether a Group Record of type t with n sources reports that
+// the sender listens to the group. Records excluding no sources are leaves.
+// MLDv2 records have the same types as IGMPv3 ones.
+func joins(t header.IGMPv3ReportRecordType, n int) bool {
+	switch t {
+	case header.IGMPv3ReportRecordModeIsInclude, header.IGMPv3ReportRecordChangeToIncludeMode:
+		return n != 0
+	default:
+		return true
+	}
+}
+
+func (e *Endpoint) snoopMLD(in *Port, v buffer.View) bool {
+	if len(v) < header.IPv6MinimumSize {
+		return false
+	}
+	ip := header.IPv6(v)
+	payload := ip.Payload()
+	if len(payload) > int(ip.PayloadLength()) {
+		payload = payload[:ip.PayloadLength()]
+	}
+	next := ip.NextHeader()
+	// MLD messages are sent with a Hop-by-Hop Options header holding the
+	// Router Alert option, as per RFC 2710 section 3.
+	if header.IPv6ExtensionHeaderIdentifier(next) == header.IPv6HopByHopOptionsExtHdrIdentifier {
+		if len(payload) < 2 {
+			return false
+		}
+		hdrLen := (int(payload[1]) + 1) * 8
+		if len(payload) < hdrLen {
+			return false
+		}
+		next = payload[0]
+		payload = payload[hdrLen:]
+	}
+	if tcpip.TransportProtocolNumber(next) != header.ICMPv6ProtocolNumber || len(payload) < header.ICMPv6HeaderSize {
+		return false
+	}
+
+	update := func(group tcpip.Address, join bool) {
+		// Like Linux, the listeners of ff02::1 aren't snooped.
+		if !header.IsV6MulticastAddress(group) || group == header.IPv6AllNodesMulticastAddress {
+			return
+		}
+		if join {
+			e.snooping.join(group, in)
+		} else {
+			e.snooping.leave(group, in)
+		}
+	}
+	icmp := header.ICMPv6(payload)
+	switch icmp.Type() {
+	case header.ICMPv6MulticastListenerQuery:
+		// Queries from :: are sent by other snooping bridges, not routers.
+		if ip.SourceAddress() != header.IPv6Any {
+			e.snooping.addRouter(in)
+		}
+		return false
+	case header.ICMPv6MulticastListenerReport, header.ICMPv6MulticastListenerDone:
+		if len(icmp.MessageBody()) < header.MLDMinimumSize {
+			return false
+		}
+		update(header.MLD(icmp.MessageBody()).MulticastAddress(), icmp.Type() == header.ICMPv6MulticastListenerReport)
+		return true
+	case mldv2ListenerReport:
+		if len(payload) < mldv2ReportMinimumSize {
+			return false
+		}
+		n := int(binary.BigEndian.Uint16(payload[6:]))
+		b := payload[mldv2ReportMinimumSize:]
+		for i := 0; i < n; i++ {
+			if len(b) < mldv2RecordMinimumSize {
+				return true
+			}
+			recordType := header.IGMPv3ReportRecordType(b[0])
+			sources := int(binary.BigEndian.Uint16(b[2:]))
+			recordLen := mldv2RecordMinimumSize + sources*header.IPv6AddressSize + int(b[1])*4
+			if len(b) < recordLen {
+				return true
+			}
+			update(tcpip.Address(b[4:][:header.IPv6AddressSize]), joins(recordType, sources))
+			b = b[recordLen:]
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// sendQueries floods IGMPv2 and MLDv1 general queries out of the ports of the
+// bridge.
+func (e *Endpoint) sendQueries() {
+	for _, q := range []struct {
+		dst   tcpip.LinkAddress
+		proto tcpip.NetworkProtocolNumber
+		frame buffer.View
+	}{
+		{
+			dst:   header.EthernetAddressFromMulticastIPv4Address(header.IPv4AllSystems),
+			proto: header.IPv4ProtocolNumber,
+			frame: e.igmpQuery(),
+		},
+		{
+			dst:   header.EthernetAddressFromMulticastIPv6Address(header.IPv6AllNodesMulticastAddress),
+			proto: header.IPv6ProtocolNumber,
+			frame: e.mldQuery(),
+		},
+	} {
+		header.Ethernet(q.frame).Encode(&header.EthernetFields{
+			SrcAddr: e.linkAddr,
+			DstAddr: q.dst,
+			Type:    q.proto,
+		})
+		_ = e.forward(nil /* in */, e.linkAddr, q.dst, q.proto, q.frame.ToVectorisedView())
+	}
+}
+
+// igmpQuery returns an ethernet frame with an IGMPv2 general query, leaving
+// the ethernet header zeroed.
+func (e *Endpoint) igmpQuery() buffer.View {
+	options := header.IPv4OptionsSerializer{
+		&header.IPv4SerializableRouterAlertOption{},
+	}
+	ipLen := header.IPv4MinimumSize + int(options.Length())
+	v := buffer.NewView(header.EthernetMinimumSize + ipLen + header.IGMPQueryMinimumSize)
+
+	ip := header.IPv4(v[header.EthernetMinimumSize:])
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(ip)),
+		TTL:         header.IGMPTTL,
+		Protocol:    uint8(header.IGMPProtocolNumber),
+		SrcAddr:     header.IPv4Any,
+		DstAddr:     header.IPv4AllSystems,
+		Options:     options,
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+
+	igmp := header.IGMP(ip[ipLen:])
+	igmp.SetType(header.IGMPMembershipQuery)
+	igmp.SetMaxRespTime(byte(queryResponseInterval / (100 * time.Millisecond)))
+	igmp.SetChecksum(header.IGMPCalculateChecksum(igmp))
+	return v
+}
+
+// mldQuery returns an ethernet frame with an MLDv1 general query, leaving the
+// ethernet header zeroed.
+func (e *Endpoint) mldQuery() buffer.View {
+	// The Hop-by-Hop Options header holds the Router Alert option for MLD
+	// messages, padded with a PadN option, as per RFC 2711.
+	hopByHop := []byte{uint8(header.ICMPv6ProtocolNumber), 0, 5, 2, 0, 0, 1, 0}
+	icmpLen := header.ICMPv6HeaderSize + header.MLDMinimumSize
+	v := buffer.NewView(header.EthernetMinimumSize + header.IPv6MinimumSize + len(hopByHop) + icmpLen)
+
+	src := header.LinkLocalAddr(e.linkAddr)
+	ip := header.IPv6(v[header.EthernetMinimumSize:])
+	ip.Encode(&header.IPv6Fields{
+		PayloadLength: uint16(len(hopByHop) + icmpLen),
+		NextHeader:    uint8(header.IPv6HopByHopOptionsExtHdrIdentifier),
+		HopLimit:      header.MLDHopLimit,
+		SrcAddr:       src,
+		DstAddr:       header.IPv6AllNodesMulticastAddress,
+	})
+	copy(ip[header.IPv6MinimumSize:], hopByHop)
+
+	icmp := header.ICMPv6(ip[header.IPv6MinimumSize+len(hopByHop):])
+	icmp.SetType(header.ICMPv6MulticastListenerQuery)
+	header.MLD(icmp.MessageBody()).SetMaximumResponseDelay(uint16(queryResponseInterval / time.Millisecond))
+	icmp.SetChecksum(header.ICMPv6Checksum(icmp, src, header.IPv6AllNodesMulticastAddress, buffer.VectorisedView{}))
+	return v
+}
@@ -19,6 +19,7 @@ package channel
 
 import (
 	"context"
+	"sync/atomic"
 
 	"gvisor.dev/gvisor/pkg/sync"
 	"gvisor.dev/gvisor/pkg/tcpip"
@@ -132,10 +133,17 @@ type Endpoint struct {
 	linkAddr           tcpip.LinkAddress
 	LinkEPCapabilities stack.LinkEndpointCapabilities
 
+	// noCarrier is 1 when the link of the endpoint is down.
+	//
+	// Must be accessed using atomic operations.
+	noCarrier uint32
+
 	// Outbound packet queue.
 	q *queue
 }
 
+var _ stack.CarrierReporter = (*Endpoint)(nil)
+
 // New creates a new channel endpoint.
 func New(size int, mtu uint32, linkAddr tcpip.LinkAddress) *Endpoint {
 	return &Endpoint{
@@ -207,6 +215,27 @@ func (e *Endpoint) MTU() uint32 {
 	return e.mtu
 }
 
+// Carrier implements stack.CarrierReporter.Carrier. The link of the endpoint
+// is up until SetCarrier(false) is called.
+func (e *Endpoint) Carrier() bool {
+	return atomic.LoadUint32(&e.noCarrier) == 0
+}
+
+// SetCarrier brings the link of the endpoint up or down, and reports the change
+// to the dispatcher.
+func (e *Endpoint) SetCarrier(up bool) {
+	var v uint32
+	if !up {
+		v = 1
+	}
+	if atomic.SwapUint32(&e.noCarrier, v) == v {
+		return
+	}
+	if d, ok := e.dispatcher.(stack.CarrierDispatcher); ok {
+		d.DeliverCarrierChange(up)
+	}
+}
+
 // Capabilities implements stack.LinkEndpoint.Capabilities.
 func (e *Endpoint) Capabilities() stack.LinkEndpointCapabilities {
 	return e.LinkEPCapabilities
@@ -0,0 +1,180 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package encaptest provides the stacks used by the tests of the link
+// endpoints that carry the packets of a device in the packets of an uplink,
+// like tunnels and overlay networks.
+package encaptest
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/arp"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+const (
+	// UplinkNICID is the ID of the uplink NIC of the stacks.
+	UplinkNICID = 1
+
+	// Timeout is how long CheckUDP waits for a datagram.
+	Timeout = 5 * time.Second
+)
+
+// Options are the options of a stack created by NewStack.
+type Options struct {
+	// Addrs are the addresses of the uplink NIC. The subnet of each address
+	// is routed through the uplink NIC.
+	Addrs []tcpip.AddressWithPrefix
+
+	// TransportProtocols are the transport protocols of the stack, in
+	// addition to UDP.
+	TransportProtocols []stack.TransportProtocolFactory
+
+	// ARP is true if the stack resolves IPv4 link addresses with ARP.
+	ARP bool
+}
+
+// NewStack returns an IPv4 and IPv6 stack with the uplink NIC UplinkNICID for
+// ep. Duplicate address detection and router solicitations are disabled, so
+// that the stack only sends the packets of the test.
+func NewStack(t *testing.T, ep stack.LinkEndpoint, opts Options) *stack.Stack {
+	t.Helper()
+
+	ndpConfigs := ipv6.DefaultNDPConfigurations()
+	ndpConfigs.DupAddrDetectTransmits = 0
+	ndpConfigs.MaxRtrSolicitations = 0
+	netProtos := []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocolWithOptions(ipv6.Options{
+		NDPConfigs: ndpConfigs,
+	})}
+	if opts.ARP {
+		netProtos = append(netProtos, arp.NewProtocol)
+	}
+	s := stack.New(stack.Options{
+		NetworkProtocols:   netProtos,
+		TransportProtocols: append([]stack.TransportProtocolFactory{udp.NewProtocol}, opts.TransportProtocols...),
+	})
+	if err := s.CreateNIC(UplinkNICID, ep); err != nil {
+		t.Fatalf("s.CreateNIC(%d, _): %s", UplinkNICID, err)
+	}
+	for _, addr := range opts.Addrs {
+		addAddress(t, s, UplinkNICID, addr)
+	}
+	return s
+}
+
+// AddAddresses adds addrs to the NIC nicID of s, with a route to their /24 or,
+// for IPv6 addresses, /64 subnet.
+func AddAddresses(t *testing.T, s *stack.Stack, nicID tcpip.NICID, addrs ...tcpip.Address) {
+	t.Helper()
+
+	for _, addr := range addrs {
+		prefixLen := 24
+		if len(addr) == header.IPv6AddressSize {
+			prefixLen = 64
+		}
+		addAddress(t, s, nicID, tcpip.AddressWithPrefix{Address: addr, PrefixLen: prefixLen})
+	}
+}
+
+// addAddress adds addr to the NIC nicID of s, with a route to its subnet.
+func addAddress(t *testing.T, s *stack.Stack, nicID tcpip.NICID, addr tcpip.AddressWithPrefix) {
+	t.Helper()
+
+	netProto := ipv4.ProtocolNumber
+	if len(addr.Address) == header.IPv6AddressSize {
+		netProto = ipv6.ProtocolNumber
+	}
+	if err := s.AddAddress(nicID, netProto, addr.Address); err != nil {
+		t.Fatalf("s.AddAddress(%d, %d, %s): %s", nicID, netProto, addr.Address, err)
+	}
+	s.AddRoute(tcpip.Route{Destination: addr.Subnet(), NIC: nicID})
+}
+
+// CheckUDP checks that a datagram sent from s1 to dst on s2 is received from
+// src.
+func CheckUDP(t *testing.T, s1, s2 *stack.Stack, netProto tcpip.NetworkProtocolNumber, src, dst tcpip.Address, data []byte) {
+	t.Helper()
+
+	const port = 1234
+	conn2, err := gonet.DialUDP(s2, &tcpip.FullAddress{Addr: dst, Port: port}, nil, netProto)
+	if err != nil {
+		t.Fatalf("gonet.DialUDP(_, _, nil, %d): %s", netProto, err)
+	}
+	defer conn2.Close()
+	conn1, err := gonet.DialUDP(s1, nil, &tcpip.FullAddress{Addr: dst, Port: port}, netProto)
+	if err != nil {
+		t.Fatalf("gonet.DialUDP(_, nil, _, %d): %s", netProto, err)
+	}
+	defer conn1.Close()
+	if err := conn2.SetDeadline(time.Now().Add(Timeout)); err != nil {
+		t.Fatalf("conn2.SetDeadline(_): %s", err)
+	}
+
+	if _, err := conn1.Write(data); err != nil {
+		t.Fatalf("conn1.Write(_): %s", err)
+	}
+	buf := make([]byte, 2*header.IPv6MinimumMTU)
+	n, from, err := conn2.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("conn2.ReadFrom(_): %s", err)
+	}
+	if got := buf[:n]; !bytes.Equal(got, data) {
+		t.Errorf("got conn2.ReadFrom(_) = %q, want = %q", got, data)
+	}
+	if got := tcpip.Address(from.(*net.UDPAddr).IP); got != src {
+		t.Errorf("got received from %s, want = %s", got, src)
+	}
+}
+
+// ReadUDP returns the next UDP datagram sent through ep, and its source as it
+// is seen by a stack that receives it on its uplink NIC. It doesn't block, so
+// that tests may hand datagrams to the devices of other stacks without
+// waiting for them. The other packets queued before the datagram are dropped.
+func ReadUDP(t *testing.T, ep *channel.Endpoint) (tcpip.FullAddress, buffer.View) {
+	t.Helper()
+
+	for {
+		p, ok := ep.Read()
+		if !ok {
+			t.Fatal("no UDP datagram was sent")
+		}
+		v := stack.PayloadSince(p.Pkt.NetworkHeader())
+		var h header.Network
+		switch p.Proto {
+		case ipv4.ProtocolNumber:
+			h = header.IPv4(v)
+		case ipv6.ProtocolNumber:
+			h = header.IPv6(v)
+		default:
+			continue
+		}
+		if h.TransportProtocol() != udp.ProtocolNumber {
+			continue
+		}
+		u := header.UDP(h.Payload())
+		return tcpip.FullAddress{NIC: UplinkNICID, Addr: h.SourceAddress(), Port: u.SourcePort()}, buffer.View(u.Payload())
+	}
+}
@@ -17,6 +17,7 @@
 package ethernet
 
 import (
+	"gvisor.dev/gvisor/pkg/sync"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 	"gvisor.dev/gvisor/pkg/tcpip/link/nested"
@@ -25,6 +26,7 @@ import (
 
 var _ stack.NetworkDispatcher = (*Endpoint)(nil)
 var _ stack.LinkEndpoint = (*Endpoint)(nil)
+var _ stack.LinkAddressFilter = (*Endpoint)(nil)
 
 // New returns an ethernet link endpoint that wraps an inner link endpoint.
 func New(ep stack.LinkEndpoint) *Endpoint {
@@ -40,6 +42,11 @@ func New(ep stack.LinkEndpoint) *Endpoint {
 // packet to the stack.
 type Endpoint struct {
 	nested.Endpoint
+
+	mu sync.RWMutex
+	// filter holds the link addresses other than the endpoint's for which
+	// unicast frames are received.
+	filter map[tcpip.LinkAddress]struct{}
 }
 
 // DeliverNetworkPacket implements stack.NetworkDispatcher.
@@ -50,11 +57,36 @@ func (e *Endpoint) DeliverNetworkPacket(_, _ tcpip.LinkAddress, _ tcpip.NetworkP
 	}
 
 	eth := header.Ethernet(hdr)
-	if dst := eth.DestinationAddress(); dst == e.Endpoint.LinkAddress() || dst == header.EthernetBroadcastAddress || header.IsMulticastEthernetAddress(dst) {
+	if dst := eth.DestinationAddress(); dst == e.Endpoint.LinkAddress() || dst == header.EthernetBroadcastAddress || header.IsMulticastEthernetAddress(dst) || e.filtered(dst) {
 		e.Endpoint.DeliverNetworkPacket(eth.SourceAddress() /* remote */, dst /* local */, eth.Type() /* protocol */, pkt)
 	}
 }
 
+// filtered returns true if addr was added to the filter of e.
+func (e *Endpoint) filtered(addr tcpip.LinkAddress) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	_, ok := e.filter[addr]
+	return ok
+}
+
+// AddFilterAddress implements stack.LinkAddressFilter.
+func (e *Endpoint) AddFilterAddress(addr tcpip.LinkAddress) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.filter == nil {
+		e.filter = make(map[tcpip.LinkAddress]struct{})
+	}
+	e.filter[addr] = struct{}{}
+}
+
+// RemoveFilterAddress implements stack.LinkAddressFilter.
+func (e *Endpoint) RemoveFilterAddress(addr tcpip.LinkAddress) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.filter, addr)
+}
+
 // Capabilities implements stack.LinkEndpoint.
 func (e *Endpoint) Capabilities() stack.LinkEndpointCapabilities {
 	return stack.CapabilityResolutionRequired | e.Endpoint.Capabilities()
@@ -62,13 +94,13 @@ func (e *Endpoint) Capabilities() stack.LinkEndpointCapabilities {
 
 // WritePacket implements stack.LinkEndpoint.
 func (e *Endpoint) WritePacket(r *stack.Route, gso *stack.GSO, proto tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) *tcpip.Error {
-	e.AddHeader(e.Endpoint.LinkAddress(), r.RemoteLinkAddress(), proto, pkt)
+	e.AddHeader(e.localLinkAddress(r), r.RemoteLinkAddress(), proto, pkt)
 	return e.Endpoint.WritePacket(r, gso, proto, pkt)
 }
 
 // WritePackets implements stack.LinkEndpoint.
 func (e *Endpoint) WritePackets(r *stack.Route, gso *stack.GSO, pkts stack.PacketBufferList, proto tcpip.NetworkProtocolNumber) (int, *tcpip.Error) {
-	linkAddr := e.Endpoint.LinkAddress()
+	linkAddr := e.localLinkAddress(r)
 
 	for pkt := pkts.Front(); pkt != nil; pkt = pkt.Next() {
 		e.AddHeader(linkAddr, r.RemoteLinkAddress(), proto, pkt)
@@ -77,6 +109,17 @@ func (e *Endpoint) WritePackets(r *stack.Route, gso *stack.GSO, pkts stack.Packe
 	return e.Endpoint.WritePackets(r, gso, pkts, proto)
 }
 
+// localLinkAddress returns the source address of the frames written for r.
+// As with fdbased endpoints, the local link address of the route is preserved
+// so that NICs with a link address of their own, like MACVLAN NICs, may send
+// frames through e.
+func (e *Endpoint) localLinkAddress(r *stack.Route) tcpip.LinkAddress {
+	if r.LocalLinkAddress != "" {
+		return r.LocalLinkAddress
+	}
+	return e.Endpoint.LinkAddress()
+}
+
 // MaxHeaderLength implements stack.LinkEndpoint.
 func (e *Endpoint) MaxHeaderLength() uint16 {
 	return header.EthernetMinimumSize + e.Endpoint.MaxHeaderLength()
@@ -33,6 +33,13 @@
 // packets on the descriptors are consistently 5 tuple hashed to one of the
 // descriptors to prevent TCP reordering.
 //
+// The queues of a multiqueue TUN or TAP device (see tun.OpenQueues) are
+// consistently hashed by the host kernel, and can be used as the FDs of a
+// single endpoint. If the queues were opened with IFF_VNET_HDR, setting
+// Options.VirtioNetHeader makes the endpoint exchange checksum and
+// segmentation offload hints with the host through the virtio-net header
+// of each packet.
+//
 // Since netstack today does not compute 5 tuple hashes for outgoing packets we
 // only use the first FD to write outbound packets. Once 5 tuple hashes for
 // all outbound packets are available we will make use of all underlying FD's to
@@ -82,6 +89,14 @@ const (
 	// primary use-case for this is runsc which uses an AF_PACKET FD to
 	// receive packets from the veth device.
 	PacketMMap
+	// IOUring enables use of io_uring to read inbound packets. Sockets are
+	// read by a multishot recv into buffers provided to the ring, and other
+	// FDs by fixed reads into registered buffers, so that the packets are
+	// read without any syscall per packet.
+	//
+	// NOTE: IOUring requires Linux 5.6, and Linux 6.0 to use multishot
+	// recvs.
+	IOUring
 )
 
 func (p PacketDispatchMode) String() string {
@@ -92,6 +107,8 @@ func (p PacketDispatchMode) String() string {
 		return "RecvMMsg"
 	case PacketMMap:
 		return "PacketMMap"
+	case IOUring:
+		return "IOUring"
 	default:
 		return fmt.Sprintf("unknown packet dispatch mode '%d'", p)
 	}
@@ -123,6 +140,13 @@ type endpoint struct {
 	inboundDispatchers []linkDispatcher
 	dispatcher         stack.NetworkDispatcher
 
+	// busyPollMu serializes busy-polls.
+	busyPollMu sync.Mutex
+
+	// busyPollDispatchers read the packets pending on the FDs without
+	// blocking, for busy-polls. FDs which can't be busy-polled have none.
+	busyPollDispatchers []linkDispatcher
+
 	// packetDispatchMode controls the packet dispatcher used by this
 	// endpoint.
 	packetDispatchMode PacketDispatchMode
@@ -131,6 +155,10 @@ type endpoint struct {
 	// disabled.
 	gsoMaxSize uint32
 
+	// vnetHdr is set if each packet read from or written to the FDs is
+	// preceded by a virtio-net header.
+	vnetHdr bool
+
 	// wg keeps track of running goroutines.
 	wg sync.WaitGroup
 }
@@ -181,6 +209,13 @@ type Options struct {
 	// RXChecksumOffload if true, indicates that this endpoints capability
 	// set should include CapabilityRXChecksumOffload.
 	RXChecksumOffload bool
+
+	// VirtioNetHeader if true, indicates that the FDs are queues of a TUN or
+	// TAP device opened with IFF_VNET_HDR, whose packets are preceded by a
+	// virtio-net header. The transport checksums of the inbound packets are
+	// then validated as indicated by the host, and GSO is done by the host
+	// if GSOMaxSize is set and SoftwareGSOEnabled isn't.
+	VirtioNetHeader bool
 }
 
 // fanoutID is used for AF_PACKET based endpoints to enable PACKET_FANOUT
@@ -197,7 +232,8 @@ var fanoutID = 1
 // open for the lifetime of the returned endpoint (until after the endpoint has
 // stopped being using and Wait returns).
 func New(opts *Options) (stack.LinkEndpoint, error) {
-	caps := stack.LinkEndpointCapabilities(0)
+	// Writes to the FDs are complete once WritePacket(s) returns.
+	caps := stack.CapabilityZeroCopyTX
 	if opts.RXChecksumOffload {
 		caps |= stack.CapabilityRXChecksumOffload
 	}
@@ -232,6 +268,7 @@ func New(opts *Options) (stack.LinkEndpoint, error) {
 		addr:               opts.Address,
 		hdrSize:            hdrSize,
 		packetDispatchMode: opts.PacketDispatchMode,
+		vnetHdr:            opts.VirtioNetHeader,
 	}
 
 	// Create per channel dispatchers.
@@ -245,12 +282,13 @@ func New(opts *Options) (stack.LinkEndpoint, error) {
 		if err != nil {
 			return nil, err
 		}
-		if isSocket {
+		if isSocket || opts.VirtioNetHeader {
 			if opts.GSOMaxSize != 0 {
 				if opts.SoftwareGSOEnabled {
 					e.caps |= stack.CapabilitySoftwareGSO
 				} else {
 					e.caps |= stack.CapabilityHardwareGSO
+					e.vnetHdr = true
 				}
 				e.gsoMaxSize = opts.GSOMaxSize
 			}
@@ -260,6 +298,14 @@ func New(opts *Options) (stack.LinkEndpoint, error) {
 			return nil, fmt.Errorf("createInboundDispatcher(...) = %v", err)
 		}
 		e.inboundDispatchers = append(e.inboundDispatchers, inboundDispatcher)
+
+		busyPollDispatcher, err := createBusyPollDispatcher(e, fd, isSocket)
+		if err != nil {
+			return nil, fmt.Errorf("createBusyPollDispatcher(...) = %v", err)
+		}
+		if busyPollDispatcher != nil {
+			e.busyPollDispatchers = append(e.busyPollDispatchers, busyPollDispatcher)
+		}
 	}
 
 	// Increment fanoutID to ensure that we don't re-use the same fanoutID for
@@ -270,6 +316,15 @@ func New(opts *Options) (stack.LinkEndpoint, error) {
 }
 
 func createInboundDispatcher(e *endpoint, fd int, isSocket bool) (linkDispatcher, error) {
+	if e.packetDispatchMode == IOUring {
+		// io_uring reads from all kinds of FDs.
+		d, err := newIOUringDispatcher(fd, e)
+		if err != nil {
+			return nil, fmt.Errorf("newIOUringDispatcher(%d, %+v) = %v", fd, e, err)
+		}
+		return d, nil
+	}
+
 	// By default use the readv() dispatcher as it works with all kinds of
 	// FDs (tap/tun/unix domain sockets and af_packet).
 	inboundDispatcher, err := newReadVDispatcher(fd, e)
@@ -312,6 +367,38 @@ func createInboundDispatcher(e *endpoint, fd int, isSocket bool) (linkDispatcher
 	return inboundDispatcher, nil
 }
 
+// createBusyPollDispatcher creates a dispatcher which reads the packets pending
+// on fd without blocking. It returns nil if fd can't be busy-polled.
+func createBusyPollDispatcher(e *endpoint, fd int, isSocket bool) (linkDispatcher, error) {
+	if e.packetDispatchMode == IOUring {
+		// The packets are read by the requests queued to the ring of
+		// the inbound dispatcher.
+		return nil, nil
+	}
+	if isSocket {
+		switch e.packetDispatchMode {
+		case PacketMMap:
+			// The packets are received in the ring of the inbound
+			// dispatcher, which only it reads.
+			return nil, nil
+		case RecvMMsg:
+			d, err := newRecvMMsgDispatcher(fd, e)
+			if err != nil {
+				return nil, fmt.Errorf("newRecvMMsgDispatcher(%d, %+v) = %v", fd, e, err)
+			}
+			d.(*recvMMsgDispatcher).nonBlocking = true
+			return d, nil
+		}
+	}
+
+	d, err := newReadVDispatcher(fd, e)
+	if err != nil {
+		return nil, fmt.Errorf("newReadVDispatcher(%d, %+v) = %v", fd, e, err)
+	}
+	d.(*readVDispatcher).nonBlocking = true
+	return d, nil
+}
+
 func isSocketFD(fd int) (bool, error) {
 	var stat syscall.Stat_t
 	if err := syscall.Fstat(fd, &stat); err != nil {
@@ -341,6 +428,26 @@ func (e *endpoint) IsAttached() bool {
 	return e.dispatcher != nil
 }
 
+// BusyPoll implements stack.BusyPoller.BusyPoll. The packets pending on the FDs
+// are read alongside the inbound dispatchers, each packet being read by only
+// one of them.
+func (e *endpoint) BusyPoll() bool {
+	if e.dispatcher == nil {
+		return false
+	}
+
+	e.busyPollMu.Lock()
+	defer e.busyPollMu.Unlock()
+
+	polled := false
+	for _, d := range e.busyPollDispatchers {
+		if ok, _ := d.dispatch(); ok {
+			polled = true
+		}
+	}
+	return polled
+}
+
 // MTU implements stack.LinkEndpoint.MTU. It returns the value initialized
 // during construction.
 func (e *endpoint) MTU() uint32 {
@@ -381,11 +488,20 @@ type virtioNetHdr struct {
 // These constants are declared in linux/virtio_net.h.
 const (
 	_VIRTIO_NET_HDR_F_NEEDS_CSUM = 1
+	_VIRTIO_NET_HDR_F_DATA_VALID = 2
 
 	_VIRTIO_NET_HDR_GSO_TCPV4 = 1
 	_VIRTIO_NET_HDR_GSO_TCPV6 = 4
 )
 
+// checksumValidated returns whether the virtio-net header hdr of an inbound
+// packet indicates that its transport checksum needn't be verified: either
+// the host validated it, or the packet never left the host and its checksum
+// is partial, as for a TCP segment coalesced by GRO or sent with TSO.
+func checksumValidated(hdr []byte) bool {
+	return hdr[0]&(_VIRTIO_NET_HDR_F_NEEDS_CSUM|_VIRTIO_NET_HDR_F_DATA_VALID) != 0
+}
+
 // AddHeader implements stack.LinkEndpoint.AddHeader.
 func (e *endpoint) AddHeader(local, remote tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
 	if e.hdrSize > 0 {
@@ -416,13 +532,13 @@ func (e *endpoint) WritePacket(r *stack.Route, gso *stack.GSO, protocol tcpip.Ne
 	var builder iovec.Builder
 
 	fd := e.fds[pkt.Hash%uint32(len(e.fds))]
-	if e.Capabilities()&stack.CapabilityHardwareGSO != 0 {
+	if e.vnetHdr {
 		vnetHdr := virtioNetHdr{}
 		if gso != nil {
 			vnetHdr.hdrLen = uint16(pkt.HeaderSize())
 			if gso.NeedsCsum {
 				vnetHdr.flags = _VIRTIO_NET_HDR_F_NEEDS_CSUM
-				vnetHdr.csumStart = header.EthernetMinimumSize + gso.L3HdrLen
+				vnetHdr.csumStart = uint16(e.hdrSize) + gso.L3HdrLen
 				vnetHdr.csumOffset = gso.CsumOffset
 			}
 			if gso.Type != stack.GSONone && uint16(pkt.Data.Size()) > gso.MSS {
@@ -457,13 +573,13 @@ func (e *endpoint) sendBatch(batchFD int, batch []*stack.PacketBuffer) (int, *tc
 		}
 
 		var vnetHdrBuf []byte
-		if e.Capabilities()&stack.CapabilityHardwareGSO != 0 {
+		if e.vnetHdr {
 			vnetHdr := virtioNetHdr{}
 			if pkt.GSOOptions != nil {
 				vnetHdr.hdrLen = uint16(pkt.HeaderSize())
 				if pkt.GSOOptions.NeedsCsum {
 					vnetHdr.flags = _VIRTIO_NET_HDR_F_NEEDS_CSUM
-					vnetHdr.csumStart = header.EthernetMinimumSize + pkt.GSOOptions.L3HdrLen
+					vnetHdr.csumStart = uint16(e.hdrSize) + pkt.GSOOptions.L3HdrLen
 					vnetHdr.csumOffset = pkt.GSOOptions.CsumOffset
 				}
 				if pkt.GSOOptions.Type != stack.GSONone && uint16(pkt.Data.Size()) > pkt.GSOOptions.MSS {
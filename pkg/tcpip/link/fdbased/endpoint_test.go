@@ -27,6 +27,7 @@ import (
 	"unsafe"
 
 	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/binary"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
@@ -236,7 +237,10 @@ func testWritePacket(t *testing.T, plen int, eth bool, gsoMaxSize uint32, hash u
 		if vnetHdr.flags&_VIRTIO_NET_HDR_F_NEEDS_CSUM == 0 {
 			t.Fatalf("virtioNetHdr.flags %v  doesn't contain %v", vnetHdr.flags, _VIRTIO_NET_HDR_F_NEEDS_CSUM)
 		}
-		csumStart := header.EthernetMinimumSize + gso.L3HdrLen
+		csumStart := gso.L3HdrLen
+		if eth {
+			csumStart += header.EthernetMinimumSize
+		}
 		if vnetHdr.csumStart != csumStart {
 			t.Fatalf("vnetHdr.csumStart = %v, want %v", vnetHdr.csumStart, csumStart)
 		}
@@ -411,6 +415,54 @@ func TestDeliverPacket(t *testing.T) {
 	}
 }
 
+func TestDeliverVirtioNetHeader(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		flags         uint8
+		wantValidated bool
+	}{
+		{name: "None", flags: 0, wantValidated: false},
+		{name: "NeedsCsum", flags: _VIRTIO_NET_HDR_F_NEEDS_CSUM, wantValidated: true},
+		{name: "DataValid", flags: _VIRTIO_NET_HDR_F_DATA_VALID, wantValidated: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := newContext(t, &Options{MTU: mtu, VirtioNetHeader: true})
+			defer c.cleanup()
+
+			// Build packet.
+			all := make([]byte, 100)
+			if _, err := rand.Read(all); err != nil {
+				t.Fatalf("rand.Read(all): %s", err)
+			}
+			// Make it look like an IPv4 packet.
+			all[0] = 0x40
+			vnetHdr := binary.Marshal(nil, binary.LittleEndian, virtioNetHdr{flags: test.flags})
+
+			// Write packet via the file descriptor.
+			if _, err := syscall.Write(c.readFDs[0], append(vnetHdr, all...)); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+
+			// Receive packet through the endpoint.
+			select {
+			case pi := <-c.ch:
+				want := packetInfo{
+					Proto: header.IPv4ProtocolNumber,
+					Contents: stack.NewPacketBuffer(stack.PacketBufferOptions{
+						Data: buffer.NewViewFromBytes(all).ToVectorisedView(),
+					}),
+				}
+				checkPacketInfoEqual(t, pi, want)
+				if got := pi.Contents.RXTransportChecksumValidated; got != test.wantValidated {
+					t.Errorf("got RXTransportChecksumValidated = %t, want = %t", got, test.wantValidated)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatalf("Timed out waiting for packet")
+			}
+		})
+	}
+}
+
 func TestBufConfigMaxLength(t *testing.T) {
 	got := 0
 	for _, i := range BufConfig {
@@ -556,6 +608,10 @@ func TestDispatchPacketFormat(t *testing.T) {
 			name:          "recvMMsgDispatcher",
 			newDispatcher: newRecvMMsgDispatcher,
 		},
+		{
+			name:          "ioUringDispatcher",
+			newDispatcher: newIOUringDispatcher,
+		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
 			// Create a socket pair to send/recv.
@@ -606,3 +662,119 @@ func TestDispatchPacketFormat(t *testing.T) {
 		})
 	}
 }
+
+func TestIOUringDispatcherFixedReads(t *testing.T) {
+	// Packets are read from pipes by fixed reads. Pipes in packet mode keep
+	// the packet boundaries.
+	var fds [2]int
+	if err := syscall.Pipe2(fds[:], syscall.O_DIRECT); err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[0])
+
+	sink := &fakeNetworkDispatcher{}
+	d, err := newIOUringDispatcher(fds[0], &endpoint{dispatcher: sink})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write more packets than there are buffers, so that the buffers are
+	// read again.
+	const count = 2*ioUringBufferCount + 1
+	go func() {
+		defer syscall.Close(fds[1])
+		for i := 0; i < count; i++ {
+			pkt := make([]byte, header.IPv4MinimumSize+i)
+			pkt[0] = header.IPv4Version << 4
+			if _, err := syscall.Write(fds[1], pkt); err != nil {
+				t.Errorf("syscall.Write(_, _): %s", err)
+				return
+			}
+		}
+	}()
+
+	for {
+		ok, err := d.dispatch()
+		if err != nil {
+			t.Fatalf("d.dispatch() = %t, %s", ok, err)
+		}
+		if !ok {
+			break
+		}
+	}
+	if got, want := len(sink.pkts), count; got != want {
+		t.Fatalf("got len(sink.pkts) = %d, want = %d", got, want)
+	}
+	for i, pkt := range sink.pkts {
+		if got, want := pkt.Data.Size(), header.IPv4MinimumSize+i; got != want {
+			t.Errorf("got sink.pkts[%d].Data.Size() = %d, want = %d", i, got, want)
+		}
+	}
+}
+
+func TestBusyPoll(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		mode PacketDispatchMode
+	}{
+		{
+			name: "Readv",
+			mode: Readv,
+		},
+		{
+			name: "RecvMMsg",
+			mode: RecvMMsg,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			// Create a socket pair to send/recv.
+			fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer syscall.Close(fds[0])
+			defer syscall.Close(fds[1])
+			if err := syscall.SetNonblock(fds[0], true); err != nil {
+				t.Fatal(err)
+			}
+
+			sink := &fakeNetworkDispatcher{}
+			e := &endpoint{
+				hdrSize:            header.EthernetMinimumSize,
+				dispatcher:         sink,
+				packetDispatchMode: test.mode,
+			}
+			d, err := createBusyPollDispatcher(e, fds[0], true /* isSocket */)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.busyPollDispatchers = []linkDispatcher{d}
+
+			// Busy-polls must not block when no packet is pending.
+			if e.BusyPoll() {
+				t.Fatal("got e.BusyPoll() = true with no pending packet, want = false")
+			}
+
+			data := []byte{
+				// Ethernet header.
+				1, 2, 3, 4, 5, 60,
+				1, 2, 3, 4, 5, 61,
+				8, 0,
+				// Mock network header.
+				40, 41, 42, 43,
+			}
+			if err := syscall.Sendmsg(fds[1], data, nil, nil, 0); err != nil {
+				t.Fatal(err)
+			}
+			if !e.BusyPoll() {
+				t.Fatal("got e.BusyPoll() = false with a pending packet, want = true")
+			}
+			if got, want := len(sink.pkts), 1; got != want {
+				t.Fatalf("len(sink.pkts) = %d, want %d", got, want)
+			}
+			if got, want := sink.pkts[0].Data.Size(), 4; got != want {
+				t.Errorf("pkt.Data.Size() = %d, want %d", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,574 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux,amd64 linux,arm64
+
+package fdbased
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/rawfile"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// These constants are declared in linux/io_uring.h.
+const (
+	_SYS_IO_URING_SETUP    = 425
+	_SYS_IO_URING_ENTER    = 426
+	_SYS_IO_URING_REGISTER = 427
+
+	_IORING_OFF_SQ_RING = 0
+	_IORING_OFF_CQ_RING = 0x8000000
+	_IORING_OFF_SQES    = 0x10000000
+
+	_IORING_ENTER_GETEVENTS = 1 << 0
+
+	_IORING_REGISTER_BUFFERS = 0
+
+	_IORING_OP_READ_FIXED      = 4
+	_IORING_OP_RECV            = 27
+	_IORING_OP_PROVIDE_BUFFERS = 31
+
+	_IOSQE_BUFFER_SELECT = 1 << 5
+
+	_IORING_RECV_MULTISHOT = 1 << 1
+
+	_IORING_CQE_F_BUFFER       = 1 << 0
+	_IORING_CQE_F_MORE         = 1 << 1
+	_IORING_CQE_BUFFER_SHIFT   = 16
+	_IORING_FEAT_SINGLE_MMAP   = 1 << 0
+	_IORING_FEAT_NODROP        = 1 << 1
+	_IORING_FEAT_SUBMIT_STABLE = 1 << 2
+)
+
+// Offsets within a submission queue entry, the io_uring_sqe structure.
+const (
+	sqeOpcode   = 0
+	sqeFlags    = 1
+	sqeIOPrio   = 2
+	sqeFD       = 4
+	sqeOff      = 8
+	sqeAddr     = 16
+	sqeLen      = 24
+	sqeUserData = 32
+	sqeBufIndex = 40
+
+	sqeSize = 64
+)
+
+// Offsets within a completion queue entry, the io_uring_cqe structure.
+const (
+	cqeUserData = 0
+	cqeRes      = 8
+	cqeFlags    = 12
+
+	cqeSize = 16
+)
+
+// ioUringParams is the io_uring_params structure.
+type ioUringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFD         uint32
+	_            [3]uint32
+	sqOff        ioSQRingOffsets
+	cqOff        ioCQRingOffsets
+}
+
+// ioSQRingOffsets is the io_sqring_offsets structure.
+type ioSQRingOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	flags       uint32
+	dropped     uint32
+	array       uint32
+	_           uint32
+	_           uint64
+}
+
+// ioCQRingOffsets is the io_cqring_offsets structure.
+type ioCQRingOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	overflow    uint32
+	cqes        uint32
+	flags       uint32
+	_           uint32
+	_           uint64
+}
+
+// ioUring is an io_uring instance: a submission queue of requests and a
+// completion queue of their results, both shared with the kernel.
+//
+// It is thread-compatible.
+type ioUring struct {
+	fd int
+
+	sqRing []byte
+	cqRing []byte
+	sqes   []byte
+
+	sqHead    *uint32
+	sqTail    *uint32
+	sqMask    uint32
+	sqEntries uint32
+	sqArray   []uint32
+
+	cqHead *uint32
+	cqTail *uint32
+	cqMask uint32
+	cqes   []byte
+
+	// toSubmit is the number of entries queued since the last call to
+	// enter.
+	toSubmit uint32
+}
+
+// newIOUring creates an io_uring instance with at least entries submission
+// queue entries.
+func newIOUring(entries uint32) (*ioUring, error) {
+	var params ioUringParams
+	fd, _, errno := syscall.Syscall(_SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&params)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("io_uring_setup(%d, _) failed: %v", entries, errno)
+	}
+	// The features are required to submit entries referring to memory
+	// which isn't kept alive, and not to lose completions.
+	if want := uint32(_IORING_FEAT_NODROP | _IORING_FEAT_SUBMIT_STABLE); params.features&want != want {
+		syscall.Close(int(fd))
+		return nil, fmt.Errorf("io_uring features %#x don't include %#x", params.features, want)
+	}
+
+	r := &ioUring{fd: int(fd)}
+	mmap := func(offset int64, size uint32) ([]byte, error) {
+		return syscall.Mmap(r.fd, offset, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	}
+	var err error
+	if r.sqRing, err = mmap(_IORING_OFF_SQ_RING, params.sqOff.array+params.sqEntries*4); err != nil {
+		r.close()
+		return nil, fmt.Errorf("mmap of the submission queue failed: %v", err)
+	}
+	if r.cqRing, err = mmap(_IORING_OFF_CQ_RING, params.cqOff.cqes+params.cqEntries*cqeSize); err != nil {
+		r.close()
+		return nil, fmt.Errorf("mmap of the completion queue failed: %v", err)
+	}
+	if r.sqes, err = mmap(_IORING_OFF_SQES, params.sqEntries*sqeSize); err != nil {
+		r.close()
+		return nil, fmt.Errorf("mmap of the submission queue entries failed: %v", err)
+	}
+
+	r.sqHead = (*uint32)(unsafe.Pointer(&r.sqRing[params.sqOff.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.sqRing[params.sqOff.tail]))
+	r.sqMask = *(*uint32)(unsafe.Pointer(&r.sqRing[params.sqOff.ringMask]))
+	r.sqEntries = params.sqEntries
+	r.sqArray = (*[1 << 20]uint32)(unsafe.Pointer(&r.sqRing[params.sqOff.array]))[:params.sqEntries:params.sqEntries]
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.cqRing[params.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.cqRing[params.cqOff.tail]))
+	r.cqMask = *(*uint32)(unsafe.Pointer(&r.cqRing[params.cqOff.ringMask]))
+	r.cqes = r.cqRing[params.cqOff.cqes:]
+	return r, nil
+}
+
+// close releases the resources of the ring.
+func (r *ioUring) close() {
+	for _, b := range [][]byte{r.sqRing, r.cqRing, r.sqes} {
+		if b != nil {
+			syscall.Munmap(b)
+		}
+	}
+	syscall.Close(r.fd)
+}
+
+// registerBuffers registers the buffers bufs, so that they can be used in
+// fixed reads.
+func (r *ioUring) registerBuffers(bufs [][]byte) error {
+	iovecs := make([]syscall.Iovec, len(bufs))
+	for i, b := range bufs {
+		iovecs[i].Base = &b[0]
+		iovecs[i].SetLen(len(b))
+	}
+	if _, _, errno := syscall.Syscall6(_SYS_IO_URING_REGISTER, uintptr(r.fd), _IORING_REGISTER_BUFFERS, uintptr(unsafe.Pointer(&iovecs[0])), uintptr(len(iovecs)), 0, 0); errno != 0 {
+		return fmt.Errorf("io_uring_register(_, IORING_REGISTER_BUFFERS, _, %d) failed: %v", len(iovecs), errno)
+	}
+	return nil
+}
+
+// getSQE returns the next submission queue entry, zeroed, or nil if the
+// submission queue is full. The entry is submitted by the next call to enter.
+func (r *ioUring) getSQE() []byte {
+	tail := *r.sqTail
+	if tail-atomic.LoadUint32(r.sqHead) == r.sqEntries {
+		return nil
+	}
+	i := tail & r.sqMask
+	sqe := r.sqes[i*sqeSize:][:sqeSize]
+	for j := range sqe {
+		sqe[j] = 0
+	}
+	r.sqArray[i] = i
+	atomic.StoreUint32(r.sqTail, tail+1)
+	r.toSubmit++
+	return sqe
+}
+
+// enter submits the queued entries and waits until at least minComplete
+// completions are available.
+func (r *ioUring) enter(minComplete uint32) syscall.Errno {
+	flags := uintptr(0)
+	if minComplete != 0 {
+		flags |= _IORING_ENTER_GETEVENTS
+	}
+	for {
+		n, _, errno := syscall.Syscall6(_SYS_IO_URING_ENTER, uintptr(r.fd), uintptr(r.toSubmit), uintptr(minComplete), flags, 0, 0)
+		switch errno {
+		case 0:
+			r.toSubmit -= uint32(n)
+			if minComplete == 0 || r.toSubmit == 0 {
+				return 0
+			}
+		case syscall.EINTR:
+		default:
+			return errno
+		}
+	}
+}
+
+// peekCQE returns the next completion queue entry, or nil if there is none.
+// The entry must be consumed by calling advanceCQ.
+func (r *ioUring) peekCQE() []byte {
+	head := *r.cqHead
+	if head == atomic.LoadUint32(r.cqTail) {
+		return nil
+	}
+	return r.cqes[(head&r.cqMask)*cqeSize:][:cqeSize]
+}
+
+// advanceCQ consumes the entry returned by peekCQE.
+func (r *ioUring) advanceCQ() {
+	atomic.StoreUint32(r.cqHead, *r.cqHead+1)
+}
+
+const (
+	// ioUringBufferCount is the number of buffers in which packets are
+	// received concurrently.
+	ioUringBufferCount = 32
+
+	// ioUringBufferSize is the size of each buffer, large enough for a GSO
+	// packet with its virtio-net and ethernet headers.
+	ioUringBufferSize = 65536 + 128
+
+	// ioUringBufferGroup is the group of the buffers provided for the
+	// multishot recv.
+	ioUringBufferGroup = 0
+
+	// ioUringRecvUserData is the user data of the multishot recv request.
+	// The user data of the other requests is the index of their buffer, or
+	// ioUringProvideUserData.
+	ioUringRecvUserData = ^uint64(0)
+
+	// ioUringProvideUserData is the user data of the requests providing
+	// buffers.
+	ioUringProvideUserData = ^uint64(0) - 1
+)
+
+// ioUringDispatcher uses io_uring to read inbound packets and dispatches them.
+//
+// Sockets are read by a single multishot recv which picks the buffers
+// provided to the ring, so that reading packets doesn't take any submission
+// once it is started. Other FDs, and sockets of hosts which don't support
+// multishot recvs, are read by one fixed read per registered buffer. In both
+// cases, the buffers are given back to the kernel when their packets have been
+// dispatched, and the submissions are batched with the wait for the next
+// completions, in a single system call.
+type ioUringDispatcher struct {
+	// fd is the file descriptor used to send and receive packets.
+	fd int
+
+	// e is the endpoint this dispatcher is attached to.
+	e *endpoint
+
+	// ring is the io_uring instance through which fd is read.
+	ring *ioUring
+
+	// buffers are the buffers registered with ring. They're mapped outside
+	// of the Go heap.
+	buffers [][]byte
+
+	// mem is the memory mapping holding buffers.
+	mem []byte
+
+	// multishot is set if fd is read by a multishot recv rather than fixed
+	// reads.
+	multishot bool
+
+	// recvPending is set if the multishot recv needs to be submitted again.
+	recvPending bool
+}
+
+func newIOUringDispatcher(fd int, e *endpoint) (linkDispatcher, error) {
+	isSocket, err := isSocketFD(fd)
+	if err != nil {
+		return nil, err
+	}
+	ring, err := newIOUring(2 * ioUringBufferCount)
+	if err != nil {
+		return nil, err
+	}
+	mem, err := syscall.Mmap(-1, 0, ioUringBufferCount*ioUringBufferSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS)
+	if err != nil {
+		ring.close()
+		return nil, fmt.Errorf("mmap of the buffers failed: %v", err)
+	}
+	d := &ioUringDispatcher{
+		fd:        fd,
+		e:         e,
+		ring:      ring,
+		mem:       mem,
+		multishot: isSocket,
+	}
+	for i := 0; i < ioUringBufferCount; i++ {
+		d.buffers = append(d.buffers, mem[i*ioUringBufferSize:][:ioUringBufferSize])
+	}
+	if err := ring.registerBuffers(d.buffers); err != nil {
+		d.close()
+		return nil, err
+	}
+
+	if d.multishot {
+		d.provideBuffers(0, ioUringBufferCount)
+		d.recvPending = true
+	} else {
+		for i := range d.buffers {
+			d.readFixed(i)
+		}
+	}
+	return d, nil
+}
+
+// close releases the resources of the dispatcher.
+func (d *ioUringDispatcher) close() {
+	d.ring.close()
+	syscall.Munmap(d.mem)
+}
+
+// queueSQE returns a submission queue entry for a request, submitting the
+// queued ones if the submission queue is full.
+func (d *ioUringDispatcher) queueSQE() []byte {
+	for {
+		if sqe := d.ring.getSQE(); sqe != nil {
+			return sqe
+		}
+		// There are never more requests in flight than the
+		// completion queue can hold, so submitting can't fail.
+		d.ring.enter(0)
+	}
+}
+
+// readFixed queues a fixed read of a packet into the i-th buffer.
+func (d *ioUringDispatcher) readFixed(i int) {
+	sqe := d.queueSQE()
+	sqe[sqeOpcode] = _IORING_OP_READ_FIXED
+	binary.LittleEndian.PutUint32(sqe[sqeFD:], uint32(d.fd))
+	binary.LittleEndian.PutUint64(sqe[sqeAddr:], uint64(uintptr(unsafe.Pointer(&d.buffers[i][0]))))
+	binary.LittleEndian.PutUint32(sqe[sqeLen:], ioUringBufferSize)
+	binary.LittleEndian.PutUint64(sqe[sqeUserData:], uint64(i))
+	binary.LittleEndian.PutUint16(sqe[sqeBufIndex:], uint16(i))
+}
+
+// provideBuffers queues the provision of count buffers starting at the i-th
+// one for the multishot recv.
+func (d *ioUringDispatcher) provideBuffers(i, count int) {
+	sqe := d.queueSQE()
+	sqe[sqeOpcode] = _IORING_OP_PROVIDE_BUFFERS
+	binary.LittleEndian.PutUint32(sqe[sqeFD:], uint32(count))
+	binary.LittleEndian.PutUint64(sqe[sqeOff:], uint64(i))
+	binary.LittleEndian.PutUint64(sqe[sqeAddr:], uint64(uintptr(unsafe.Pointer(&d.buffers[i][0]))))
+	binary.LittleEndian.PutUint32(sqe[sqeLen:], ioUringBufferSize)
+	binary.LittleEndian.PutUint64(sqe[sqeUserData:], ioUringProvideUserData)
+	binary.LittleEndian.PutUint16(sqe[sqeBufIndex:], ioUringBufferGroup)
+}
+
+// recv queues the multishot recv.
+func (d *ioUringDispatcher) recv() {
+	sqe := d.queueSQE()
+	sqe[sqeOpcode] = _IORING_OP_RECV
+	sqe[sqeFlags] = _IOSQE_BUFFER_SELECT
+	binary.LittleEndian.PutUint16(sqe[sqeIOPrio:], _IORING_RECV_MULTISHOT)
+	binary.LittleEndian.PutUint32(sqe[sqeFD:], uint32(d.fd))
+	binary.LittleEndian.PutUint64(sqe[sqeUserData:], ioUringRecvUserData)
+	binary.LittleEndian.PutUint16(sqe[sqeBufIndex:], ioUringBufferGroup)
+	d.recvPending = false
+}
+
+// waitReadable blocks until fd is readable, for the requests which failed
+// because fd is non-blocking.
+func (d *ioUringDispatcher) waitReadable() *tcpip.Error {
+	event := rawfile.PollEvent{
+		FD:     int32(d.fd),
+		Events: 1, // POLLIN
+	}
+	if _, e := rawfile.BlockingPoll(&event, 1, nil); e != 0 && e != syscall.EINTR {
+		return rawfile.TranslateErrno(e)
+	}
+	return nil
+}
+
+// dispatch submits the pending requests, then waits for packets and
+// dispatches them. The ring is released once the FD is closed or fails, as
+// the dispatcher isn't called again.
+func (d *ioUringDispatcher) dispatch() (bool, *tcpip.Error) {
+	cont, err := d.receive()
+	if !cont || err != nil {
+		d.close()
+	}
+	return cont, err
+}
+
+// receive waits for completions until at least one packet is dispatched.
+func (d *ioUringDispatcher) receive() (bool, *tcpip.Error) {
+	for {
+		if d.recvPending {
+			d.recv()
+		}
+		if errno := d.ring.enter(1); errno != 0 {
+			return false, rawfile.TranslateErrno(errno)
+		}
+
+		dispatched := false
+		for cqe := d.ring.peekCQE(); cqe != nil; cqe = d.ring.peekCQE() {
+			userData := binary.LittleEndian.Uint64(cqe[cqeUserData:])
+			res := int32(binary.LittleEndian.Uint32(cqe[cqeRes:]))
+			flags := binary.LittleEndian.Uint32(cqe[cqeFlags:])
+			d.ring.advanceCQ()
+
+			switch {
+			case userData == ioUringProvideUserData:
+				if res < 0 {
+					return false, rawfile.TranslateErrno(syscall.Errno(-res))
+				}
+			case userData == ioUringRecvUserData:
+				if flags&_IORING_CQE_F_MORE == 0 {
+					d.recvPending = true
+				}
+				switch {
+				case res > 0:
+					i := int(flags >> _IORING_CQE_BUFFER_SHIFT)
+					d.deliver(d.buffers[i][:res])
+					d.provideBuffers(i, 1)
+					dispatched = true
+				case res == 0:
+					return false, nil
+				case syscall.Errno(-res) == syscall.ENOBUFS:
+					// All the buffers are in use, and are
+					// provided again with the dispatched
+					// packets.
+				case syscall.Errno(-res) == syscall.EINVAL && flags&_IORING_CQE_F_BUFFER == 0:
+					// Multishot recvs aren't supported
+					// by the host, use fixed reads.
+					d.multishot = false
+					d.recvPending = false
+					for i := range d.buffers {
+						d.readFixed(i)
+					}
+				case syscall.Errno(-res) == syscall.EAGAIN || syscall.Errno(-res) == syscall.EINTR:
+					if err := d.waitReadable(); err != nil {
+						return false, err
+					}
+				default:
+					return false, rawfile.TranslateErrno(syscall.Errno(-res))
+				}
+			default:
+				i := int(userData)
+				switch {
+				case res > 0:
+					d.deliver(d.buffers[i][:res])
+					dispatched = true
+				case res == 0:
+					return false, nil
+				case syscall.Errno(-res) == syscall.EAGAIN || syscall.Errno(-res) == syscall.EINTR:
+					if err := d.waitReadable(); err != nil {
+						return false, err
+					}
+				default:
+					return false, rawfile.TranslateErrno(syscall.Errno(-res))
+				}
+				d.readFixed(i)
+			}
+		}
+		if dispatched {
+			return true, nil
+		}
+	}
+}
+
+// deliver dispatches the packet read in b, which is reused once it returns.
+func (d *ioUringDispatcher) deliver(b []byte) {
+	validated := false
+	if d.e.vnetHdr {
+		if len(b) <= virtioNetHdrSize {
+			return
+		}
+		validated = checksumValidated(b)
+		b = b[virtioNetHdrSize:]
+	}
+
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: buffer.NewViewFromBytes(b).ToVectorisedView(),
+	})
+	pkt.RXTransportChecksumValidated = validated
+
+	var (
+		p             tcpip.NetworkProtocolNumber
+		remote, local tcpip.LinkAddress
+	)
+	if d.e.hdrSize > 0 {
+		hdr, ok := pkt.LinkHeader().Consume(d.e.hdrSize)
+		if !ok {
+			return
+		}
+		eth := header.Ethernet(hdr)
+		p = eth.Type()
+		remote = eth.SourceAddress()
+		local = eth.DestinationAddress()
+	} else {
+		// We don't get any indication of what the packet is, so try to guess
+		// if it's an IPv4 or IPv6 packet.
+		switch header.IPVersion(b) {
+		case header.IPv4Version:
+			p = header.IPv4ProtocolNumber
+		case header.IPv6Version:
+			p = header.IPv6ProtocolNumber
+		default:
+			return
+		}
+	}
+
+	d.e.dispatcher.DeliverNetworkPacket(remote, local, p, pkt)
+}
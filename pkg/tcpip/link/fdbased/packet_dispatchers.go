@@ -42,18 +42,25 @@ type readVDispatcher struct {
 	views []buffer.View
 
 	// iovecs are initialized with base pointers/len of the corresponding
-	// entries in the views defined above, except when GSO is enabled then
-	// the first iovec points to a buffer for the vnet header which is
+	// entries in the views defined above, except when the endpoint uses
+	// virtio-net headers then the first iovec points to vnetHdr.
+	iovecs []syscall.Iovec
+
+	// vnetHdr holds the virtio-net header of the last packet read, which is
 	// stripped before the views are passed up the stack for further
 	// processing.
-	iovecs []syscall.Iovec
+	vnetHdr [virtioNetHdrSize]byte
+
+	// nonBlocking is set if dispatch returns tcpip.ErrWouldBlock instead of
+	// blocking when no packet is available, as for busy-polls.
+	nonBlocking bool
 }
 
 func newReadVDispatcher(fd int, e *endpoint) (linkDispatcher, error) {
 	d := &readVDispatcher{fd: fd, e: e}
 	d.views = make([]buffer.View, len(BufConfig))
 	iovLen := len(BufConfig)
-	if d.e.Capabilities()&stack.CapabilityHardwareGSO != 0 {
+	if d.e.vnetHdr {
 		iovLen++
 	}
 	d.iovecs = make([]syscall.Iovec, iovLen)
@@ -61,14 +68,12 @@ func newReadVDispatcher(fd int, e *endpoint) (linkDispatcher, error) {
 }
 
 func (d *readVDispatcher) allocateViews(bufConfig []int) {
-	var vnetHdr [virtioNetHdrSize]byte
 	vnetHdrOff := 0
-	if d.e.Capabilities()&stack.CapabilityHardwareGSO != 0 {
-		// The kernel adds virtioNetHdr before each packet, but
-		// we don't use it, so so we allocate a buffer for it,
-		// add it in iovecs but don't add it in a view.
+	if d.e.vnetHdr {
+		// The kernel adds virtioNetHdr before each packet, which
+		// we read in its own buffer rather than in a view.
 		d.iovecs[0] = syscall.Iovec{
-			Base: &vnetHdr[0],
+			Base: &d.vnetHdr[0],
 			Len:  uint64(virtioNetHdrSize),
 		}
 		vnetHdrOff++
@@ -102,20 +107,30 @@ func (d *readVDispatcher) capViews(n int, buffers []int) int {
 func (d *readVDispatcher) dispatch() (bool, *tcpip.Error) {
 	d.allocateViews(BufConfig)
 
-	n, err := rawfile.BlockingReadv(d.fd, d.iovecs)
+	readv := rawfile.BlockingReadv
+	if d.nonBlocking {
+		readv = rawfile.NonBlockingReadv
+	}
+	n, err := readv(d.fd, d.iovecs)
 	if n == 0 || err != nil {
 		return false, err
 	}
-	if d.e.Capabilities()&stack.CapabilityHardwareGSO != 0 {
+	if d.e.vnetHdr {
 		// Skip virtioNetHdr which is added before each packet, it
-		// isn't used and it isn't in a view.
+		// isn't in a view.
 		n -= virtioNetHdrSize
+		if n <= 0 {
+			return true, nil
+		}
 	}
 
 	used := d.capViews(n, BufConfig)
 	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
 		Data: buffer.NewVectorisedView(n, append([]buffer.View(nil), d.views[:used]...)),
 	})
+	if d.e.vnetHdr {
+		pkt.RXTransportChecksumValidated = checksumValidated(d.vnetHdr[:])
+	}
 
 	var (
 		p             tcpip.NetworkProtocolNumber
@@ -167,16 +182,24 @@ type recvMMsgDispatcher struct {
 
 	// iovecs is an array of array of iovec records where each iovec base
 	// pointer and length are initialzed to the corresponding view above,
-	// except when GSO is enabled then the first iovec in each array of
-	// iovecs points to a buffer for the vnet header which is stripped
-	// before the views are passed up the stack for further processing.
+	// except when the endpoint uses virtio-net headers then the first iovec
+	// in each array of iovecs points to the corresponding entry of vnetHdrs.
 	iovecs [][]syscall.Iovec
 
+	// vnetHdrs holds the virtio-net headers of the packets read, which are
+	// stripped before the views are passed up the stack for further
+	// processing.
+	vnetHdrs [][virtioNetHdrSize]byte
+
 	// msgHdrs is an array of MMsgHdr objects where each MMsghdr is used to
 	// reference an array of iovecs in the iovecs field defined above.  This
 	// array is passed as the parameter to recvmmsg call to retrieve
 	// potentially more than 1 packet per syscall.
 	msgHdrs []rawfile.MMsgHdr
+
+	// nonBlocking is set if dispatch returns tcpip.ErrWouldBlock instead of
+	// blocking when no packet is available, as for busy-polls.
+	nonBlocking bool
 }
 
 const (
@@ -196,9 +219,10 @@ func newRecvMMsgDispatcher(fd int, e *endpoint) (linkDispatcher, error) {
 	}
 	d.iovecs = make([][]syscall.Iovec, MaxMsgsPerRecv)
 	iovLen := len(BufConfig)
-	if d.e.Capabilities()&stack.CapabilityHardwareGSO != 0 {
+	if d.e.vnetHdr {
 		// virtioNetHdr is prepended before each packet.
 		iovLen++
+		d.vnetHdrs = make([][virtioNetHdrSize]byte, MaxMsgsPerRecv)
 	}
 	for i := range d.iovecs {
 		d.iovecs[i] = make([]syscall.Iovec, iovLen)
@@ -225,14 +249,12 @@ func (d *recvMMsgDispatcher) capViews(k, n int, buffers []int) int {
 
 func (d *recvMMsgDispatcher) allocateViews(bufConfig []int) {
 	for k := 0; k < len(d.views); k++ {
-		var vnetHdr [virtioNetHdrSize]byte
 		vnetHdrOff := 0
-		if d.e.Capabilities()&stack.CapabilityHardwareGSO != 0 {
-			// The kernel adds virtioNetHdr before each packet, but
-			// we don't use it, so so we allocate a buffer for it,
-			// add it in iovecs but don't add it in a view.
+		if d.e.vnetHdr {
+			// The kernel adds virtioNetHdr before each packet, which
+			// we read in its own buffer rather than in a view.
 			d.iovecs[k][0] = syscall.Iovec{
-				Base: &vnetHdr[0],
+				Base: &d.vnetHdrs[k][0],
 				Len:  uint64(virtioNetHdrSize),
 			}
 			vnetHdrOff++
@@ -256,21 +278,31 @@ func (d *recvMMsgDispatcher) allocateViews(bufConfig []int) {
 func (d *recvMMsgDispatcher) dispatch() (bool, *tcpip.Error) {
 	d.allocateViews(BufConfig)
 
-	nMsgs, err := rawfile.BlockingRecvMMsg(d.fd, d.msgHdrs)
+	recvMMsg := rawfile.BlockingRecvMMsg
+	if d.nonBlocking {
+		recvMMsg = rawfile.NonBlockingRecvMMsg
+	}
+	nMsgs, err := recvMMsg(d.fd, d.msgHdrs)
 	if err != nil {
 		return false, err
 	}
 	// Process each of received packets.
 	for k := 0; k < nMsgs; k++ {
 		n := int(d.msgHdrs[k].Len)
-		if d.e.Capabilities()&stack.CapabilityHardwareGSO != 0 {
+		if d.e.vnetHdr {
 			n -= virtioNetHdrSize
+			if n <= 0 {
+				continue
+			}
 		}
 
 		used := d.capViews(k, int(n), BufConfig)
 		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
 			Data: buffer.NewVectorisedView(int(n), append([]buffer.View(nil), d.views[k][:used]...)),
 		})
+		if d.e.vnetHdr {
+			pkt.RXTransportChecksumValidated = checksumValidated(d.vnetHdrs[k][:])
+		}
 
 		var (
 			p             tcpip.NetworkProtocolNumber
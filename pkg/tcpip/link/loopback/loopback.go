@@ -21,6 +21,9 @@
 package loopback
 
 import (
+	"math"
+	"sync/atomic"
+
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
@@ -29,6 +32,13 @@ import (
 
 type endpoint struct {
 	dispatcher stack.NetworkDispatcher
+
+	// inline is 1 while a packet is delivered with ProcessInline set, so
+	// the packets written while processing it are queued rather than
+	// processed recursively.
+	//
+	// Must be accessed using atomic operations.
+	inline uint32
 }
 
 // New creates a new loopback endpoint. This link-layer endpoint just turns
@@ -55,9 +65,16 @@ func (*endpoint) MTU() uint32 {
 }
 
 // Capabilities implements stack.LinkEndpoint.Capabilities. Loopback advertises
-// itself as supporting checksum offload, but in reality it's just omitted.
+// itself as supporting checksum and segmentation offload, but in reality
+// they're just omitted: packets are delivered as they are written.
 func (*endpoint) Capabilities() stack.LinkEndpointCapabilities {
-	return stack.CapabilityRXChecksumOffload | stack.CapabilityTXChecksumOffload | stack.CapabilitySaveRestore | stack.CapabilityLoopback
+	return stack.CapabilityRXChecksumOffload | stack.CapabilityTXChecksumOffload | stack.CapabilitySaveRestore | stack.CapabilityLoopback | stack.CapabilityHardwareGSO
+}
+
+// GSOMaxSize implements stack.GSOEndpoint. GSO packets are delivered without
+// being segmented, so they must fit in a single IP packet.
+func (*endpoint) GSOMaxSize() uint32 {
+	return math.MaxUint16 - header.IPv4MaximumHeaderSize
 }
 
 // MaxHeaderLength implements stack.LinkEndpoint.MaxHeaderLength. Given that the
@@ -75,8 +92,30 @@ func (*endpoint) LinkAddress() tcpip.LinkAddress {
 func (*endpoint) Wait() {}
 
 // WritePacket implements stack.LinkEndpoint.WritePacket. It delivers outbound
-// packets to the network-layer dispatcher.
+// packets to the network-layer dispatcher, on the goroutine of the writer.
 func (e *endpoint) WritePacket(_ *stack.Route, _ *stack.GSO, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) *tcpip.Error {
+	e.deliver(protocol, pkt)
+	return nil
+}
+
+// WritePackets implements stack.LinkEndpoint.WritePackets.
+func (e *endpoint) WritePackets(_ *stack.Route, _ *stack.GSO, pkts stack.PacketBufferList, protocol tcpip.NetworkProtocolNumber) (int, *tcpip.Error) {
+	n := 0
+	for pkt := pkts.Front(); pkt != nil; pkt = pkt.Next() {
+		e.deliver(protocol, pkt)
+		n++
+	}
+	return n, nil
+}
+
+// deliver turns the outbound packet pkt into an inbound one and delivers it
+// to the network-layer dispatcher.
+//
+// The transport protocols are allowed to process the packet before deliver
+// returns, unless the packet is written while processing another one. This
+// avoids handing each packet to another goroutine, like Linux processes the
+// packets looped back on the CPU of the writer.
+func (e *endpoint) deliver(protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
 	// Construct data as the unparsed portion for the loopback packet.
 	data := buffer.NewVectorisedView(pkt.Size(), pkt.Views())
 
@@ -86,14 +125,12 @@ func (e *endpoint) WritePacket(_ *stack.Route, _ *stack.GSO, protocol tcpip.Netw
 	newPkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
 		Data: data,
 	})
+	inline := atomic.CompareAndSwapUint32(&e.inline, 0, 1)
+	newPkt.ProcessInline = inline
 	e.dispatcher.DeliverNetworkPacket("" /* remote */, "" /* local */, protocol, newPkt)
-
-	return nil
-}
-
-// WritePackets implements stack.LinkEndpoint.WritePackets.
-func (e *endpoint) WritePackets(*stack.Route, *stack.GSO, stack.PacketBufferList, tcpip.NetworkProtocolNumber) (int, *tcpip.Error) {
-	panic("not implemented")
+	if inline {
+		atomic.StoreUint32(&e.inline, 0)
+	}
 }
 
 // ARPHardwareType implements stack.LinkEndpoint.ARPHardwareType.
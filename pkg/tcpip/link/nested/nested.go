@@ -37,6 +37,9 @@ type Endpoint struct {
 	dispatcher stack.NetworkDispatcher
 }
 
+var _ stack.BusyPoller = (*Endpoint)(nil)
+var _ stack.CarrierDispatcher = (*Endpoint)(nil)
+var _ stack.CarrierReporter = (*Endpoint)(nil)
 var _ stack.GSOEndpoint = (*Endpoint)(nil)
 var _ stack.LinkEndpoint = (*Endpoint)(nil)
 var _ stack.NetworkDispatcher = (*Endpoint)(nil)
@@ -70,6 +73,16 @@ func (e *Endpoint) DeliverOutboundPacket(remote, local tcpip.LinkAddress, protoc
 	}
 }
 
+// DeliverCarrierChange implements stack.CarrierDispatcher.DeliverCarrierChange.
+func (e *Endpoint) DeliverCarrierChange(up bool) {
+	e.mu.RLock()
+	d := e.dispatcher
+	e.mu.RUnlock()
+	if d, ok := d.(stack.CarrierDispatcher); ok {
+		d.DeliverCarrierChange(up)
+	}
+}
+
 // Attach implements stack.LinkEndpoint.
 func (e *Endpoint) Attach(dispatcher stack.NetworkDispatcher) {
 	e.mu.Lock()
@@ -135,6 +148,22 @@ func (e *Endpoint) GSOMaxSize() uint32 {
 	return 0
 }
 
+// BusyPoll implements stack.BusyPoller.
+func (e *Endpoint) BusyPoll() bool {
+	if e, ok := e.child.(stack.BusyPoller); ok {
+		return e.BusyPoll()
+	}
+	return false
+}
+
+// Carrier implements stack.CarrierReporter.Carrier.
+func (e *Endpoint) Carrier() bool {
+	if e, ok := e.child.(stack.CarrierReporter); ok {
+		return e.Carrier()
+	}
+	return true
+}
+
 // ARPHardwareType implements stack.LinkEndpoint.ARPHardwareType
 func (e *Endpoint) ARPHardwareType() header.ARPHardwareType {
 	return e.child.ARPHardwareType()
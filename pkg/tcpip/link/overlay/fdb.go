@@ -0,0 +1,205 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package overlay
+
+import (
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// DefaultAgingTime is the default time after which the learned entries of the
+// forwarding database of a VXLAN device expire, as in Linux.
+const DefaultAgingTime = 300 * time.Second
+
+// DefaultDestination is the link address of the entries of the forwarding
+// database which hold the remote endpoints frames are sent to when their
+// destination has no entry, like the all-zeros entries of Linux.
+const DefaultDestination = tcpip.LinkAddress("\x00\x00\x00\x00\x00\x00")
+
+// FDBEntry is an entry of the forwarding database of a VXLAN device, which
+// maps a link address to a remote endpoint it is reachable through.
+type FDBEntry struct {
+	// Addr is the link address of the entry, or DefaultDestination.
+	Addr tcpip.LinkAddress
+
+	// Remote is the address of the remote endpoint Addr is reachable
+	// through.
+	Remote tcpip.Address
+
+	// Static is true if the entry was added with AddFDBEntry. Static entries
+	// don't expire.
+	Static bool
+}
+
+type fdbEntry struct {
+	// remotes is never modified in place, so that it may be used without
+	// holding the mutex of the database.
+	remotes []tcpip.Address
+	static  bool
+
+	// updated is the monotonic time at which the entry was last learned.
+	updated int64
+}
+
+// fdb is the forwarding database of a VXLAN device.
+type fdb struct {
+	clock     tcpip.Clock
+	agingTime time.Duration
+
+	// mu protects the fields below.
+	mu      sync.RWMutex
+	entries map[tcpip.LinkAddress]fdbEntry
+
+	// lastSweep is the monotonic time at which the expired entries were last
+	// removed.
+	lastSweep int64
+}
+
+func (f *fdb) init(clock tcpip.Clock, agingTime time.Duration) {
+	f.clock = clock
+	f.agingTime = agingTime
+	f.entries = make(map[tcpip.LinkAddress]fdbEntry)
+	f.lastSweep = clock.NowMonotonic()
+}
+
+// expired returns true if e has expired at the monotonic time now.
+func (f *fdb) expired(e fdbEntry, now int64) bool {
+	return !e.static && time.Duration(now-e.updated) > f.agingTime
+}
+
+// learn records that addr is reachable through remote, unless there is a
+// static entry for addr.
+func (f *fdb) learn(addr tcpip.LinkAddress, remote tcpip.Address) {
+	now := f.clock.NowMonotonic()
+
+	f.mu.RLock()
+	e, ok := f.entries[addr]
+	f.mu.RUnlock()
+	// Refreshing entries is the common case, avoid taking the write lock
+	// for each received frame when it is unnecessary.
+	if ok && e.static {
+		return
+	}
+	if ok && e.remotes[0] == remote && time.Duration(now-e.updated) < time.Second {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if e, ok := f.entries[addr]; ok && e.static {
+		return
+	}
+	f.entries[addr] = fdbEntry{remotes: []tcpip.Address{remote}, updated: now}
+	if time.Duration(now-f.lastSweep) > f.agingTime {
+		for addr, e := range f.entries {
+			if f.expired(e, now) {
+				delete(f.entries, addr)
+			}
+		}
+		f.lastSweep = now
+	}
+}
+
+// lookup returns the remote endpoints addr is reachable through, if known.
+func (f *fdb) lookup(addr tcpip.LinkAddress) ([]tcpip.Address, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	e, ok := f.entries[addr]
+	if !ok || f.expired(e, f.clock.NowMonotonic()) {
+		return nil, false
+	}
+	return e.remotes, true
+}
+
+// add adds remote to the static entry of addr, which replaces the learned
+// entry of addr if any.
+func (f *fdb) add(addr tcpip.LinkAddress, remote tcpip.Address) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, ok := f.entries[addr]
+	if !ok || !e.static {
+		f.entries[addr] = fdbEntry{remotes: []tcpip.Address{remote}, static: true}
+		return
+	}
+	for _, r := range e.remotes {
+		if r == remote {
+			return
+		}
+	}
+	e.remotes = append(e.remotes[:len(e.remotes):len(e.remotes)], remote)
+	f.entries[addr] = e
+}
+
+// remove removes remote from the entry of addr, or the whole entry if remote
+// is empty, and returns true if there was one.
+func (f *fdb) remove(addr tcpip.LinkAddress, remote tcpip.Address) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, ok := f.entries[addr]
+	if !ok {
+		return false
+	}
+	if len(remote) == 0 {
+		delete(f.entries, addr)
+		return true
+	}
+	for i, r := range e.remotes {
+		if r != remote {
+			continue
+		}
+		if len(e.remotes) == 1 {
+			delete(f.entries, addr)
+			return true
+		}
+		remotes := make([]tcpip.Address, 0, len(e.remotes)-1)
+		remotes = append(remotes, e.remotes[:i]...)
+		e.remotes = append(remotes, e.remotes[i+1:]...)
+		f.entries[addr] = e
+		return true
+	}
+	return false
+}
+
+// flush removes the learned entries.
+func (f *fdb) flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for addr, e := range f.entries {
+		if !e.static {
+			delete(f.entries, addr)
+		}
+	}
+}
+
+// dump returns the entries which haven't expired, with one entry for each of
+// their remote endpoints.
+func (f *fdb) dump() []FDBEntry {
+	now := f.clock.NowMonotonic()
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	entries := make([]FDBEntry, 0, len(f.entries))
+	for addr, e := range f.entries {
+		if f.expired(e, now) {
+			continue
+		}
+		for _, remote := range e.remotes {
+			entries = append(entries, FDBEntry{Addr: addr, Remote: remote, Static: e.static})
+		}
+	}
+	return entries
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package overlay
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// NewGENEVE returns a GENEVE device that sends and receives datagrams through
+// the UDP endpoints of s, which must have the UDP protocol and IPv4 or IPv6
+// (or both) registered.
+//
+// Like Linux's geneve devices, GENEVE devices have no forwarding database:
+// all the frames are sent to opts.Remote.
+func NewGENEVE(s *stack.Stack, opts Options) (*Endpoint, *tcpip.Error) {
+	if opts.VNI > header.GENEVEMaximumVNI {
+		return nil, tcpip.ErrInvalidOptionValue
+	}
+	return newEndpoint(s, geneveEncapsulation{}, opts, nil /* fdb */, false /* learning */)
+}
+
+// geneveEncapsulation implements encapsulation for GENEVE devices, which
+// encapsulate ethernet frames without options.
+type geneveEncapsulation struct{}
+
+func (geneveEncapsulation) port() uint16 {
+	return header.GENEVEPort
+}
+
+func (geneveEncapsulation) headerLength() int {
+	return header.GENEVEMinimumSize
+}
+
+func (geneveEncapsulation) encapsulate(hdr buffer.View, vni uint32) {
+	header.GENEVE(hdr).Encode(&header.GENEVEFields{
+		Protocol: header.TransparentEthernetBridgingProtocolNumber,
+		VNI:      vni,
+	})
+}
+
+func (geneveEncapsulation) parseVNI(payload buffer.View) (uint32, bool) {
+	geneve := header.GENEVE(payload)
+	if !geneve.IsValid() {
+		return 0, false
+	}
+	return geneve.VNI(), true
+}
+
+// decapsulate implements encapsulation.
+//
+// As no option is understood, packets with critical options are dropped as
+// RFC 8926 section 3.5 requires, and the other options are ignored. Control
+// messages are dropped too.
+func (geneveEncapsulation) decapsulate(payload buffer.View) (buffer.View, bool) {
+	geneve := header.GENEVE(payload)
+	if geneve.Flags()&(header.GENEVEFlagOAM|header.GENEVEFlagCritical) != 0 {
+		return nil, false
+	}
+	if geneve.Protocol() != header.TransparentEthernetBridgingProtocolNumber {
+		return nil, false
+	}
+	return payload[geneve.HeaderLength():], true
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package overlay
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/encaptest"
+	"gvisor.dev/gvisor/pkg/tcpip/link/pipe"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+func addGENEVE(t *testing.T, s *stack.Stack, opts Options, innerAddrs ...tcpip.Address) *Endpoint {
+	t.Helper()
+
+	opts.MTU = header.IPv6MinimumMTU
+	dev, err := NewGENEVE(s, opts)
+	if err != nil {
+		t.Fatalf("NewGENEVE(_, %+v): %s", opts, err)
+	}
+	addDevice(t, s, deviceNICID, dev, innerAddrs...)
+	return dev
+}
+
+func TestGENEVE(t *testing.T) {
+	link1, link2 := pipe.New(linkAddr1, linkAddr2)
+	s1 := newTestStack(t, link1, outerAddr1)
+	s2 := newTestStack(t, link2, outerAddr2)
+	dev1 := addGENEVE(t, s1, Options{VNI: testVNI, LinkAddress: devLinkAddr1, Remote: outerAddr2}, innerAddr1, innerV6Addr1)
+	dev2 := addGENEVE(t, s2, Options{VNI: testVNI, LinkAddress: devLinkAddr2, Remote: outerAddr1}, innerAddr2, innerV6Addr2)
+
+	encaptest.CheckUDP(t, s1, s2, ipv4.ProtocolNumber, innerAddr1, innerAddr2, []byte("hello"))
+	encaptest.CheckUDP(t, s2, s1, ipv4.ProtocolNumber, innerAddr2, innerAddr1, []byte("world"))
+	encaptest.CheckUDP(t, s1, s2, ipv6.ProtocolNumber, innerV6Addr1, innerV6Addr2, []byte("hello v6"))
+	encaptest.CheckUDP(t, s2, s1, ipv6.ProtocolNumber, innerV6Addr2, innerV6Addr1, []byte("world v6"))
+
+	for i, dev := range []*Endpoint{dev1, dev2} {
+		if got := dev.Stats().DecapsulationErrors.Value(); got != 0 {
+			t.Errorf("got dev%d.Stats().DecapsulationErrors.Value() = %d, want = 0", i+1, got)
+		}
+		if got := dev.FDB(); got != nil {
+			t.Errorf("got dev%d.FDB() = %+v, want = nil", i+1, got)
+		}
+		if err := dev.AddFDBEntry(DefaultDestination, outerAddr1); err != tcpip.ErrNotSupported {
+			t.Errorf("got dev%d.AddFDBEntry(_, _) = %v, want = %s", i+1, err, tcpip.ErrNotSupported)
+		}
+	}
+}
+
+func TestGENEVEDecapsulation(t *testing.T) {
+	frame := make([]byte, header.EthernetMinimumSize)
+	header.Ethernet(frame).Encode(&header.EthernetFields{
+		SrcAddr: devLinkAddr1,
+		DstAddr: header.EthernetBroadcastAddress,
+		Type:    header.IPv4ProtocolNumber,
+	})
+	tests := []struct {
+		name       string
+		hdr        []byte
+		truncate   bool
+		wantErrors uint64
+	}{
+		{
+			name:       "valid",
+			hdr:        []byte{0x00, 0x00, 0x65, 0x58, 0x00, 0x00, testVNI, 0x00},
+			wantErrors: 0,
+		},
+		{
+			name:       "non-critical option",
+			hdr:        []byte{0x01, 0x00, 0x65, 0x58, 0x00, 0x00, testVNI, 0x00, 0x01, 0x02, 0x03, 0x00},
+			wantErrors: 0,
+		},
+		{
+			name:       "critical option",
+			hdr:        []byte{0x01, 0x40, 0x65, 0x58, 0x00, 0x00, testVNI, 0x00, 0x01, 0x02, 0x83, 0x00},
+			wantErrors: 1,
+		},
+		{
+			name:       "control message",
+			hdr:        []byte{0x00, 0x80, 0x65, 0x58, 0x00, 0x00, testVNI, 0x00},
+			wantErrors: 1,
+		},
+		{
+			name:       "IPv4 payload",
+			hdr:        []byte{0x00, 0x00, 0x08, 0x00, 0x00, 0x00, testVNI, 0x00},
+			wantErrors: 1,
+		},
+		{
+			name:       "truncated frame",
+			hdr:        []byte{0x00, 0x00, 0x65, 0x58, 0x00, 0x00, testVNI, 0x00},
+			truncate:   true,
+			wantErrors: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			link1, _ := pipe.New(linkAddr1, linkAddr2)
+			s := newTestStack(t, link1, outerAddr1)
+			dev := addGENEVE(t, s, Options{VNI: testVNI, LinkAddress: devLinkAddr2, Remote: outerAddr2})
+
+			msg := append(append([]byte(nil), test.hdr...), frame...)
+			if test.truncate {
+				msg = msg[:len(msg)-1]
+			}
+			dev.listener.handleDatagram(outerAddr2, msg)
+			if got := dev.Stats().DecapsulationErrors.Value(); got != test.wantErrors {
+				t.Errorf("got dev.Stats().DecapsulationErrors.Value() = %d, want = %d", got, test.wantErrors)
+			}
+		})
+	}
+}
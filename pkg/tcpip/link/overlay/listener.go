@@ -0,0 +1,280 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package overlay
+
+import (
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// listenerKey identifies the listener of a port of a stack.
+type listenerKey struct {
+	stack *stack.Stack
+	port  uint16
+}
+
+// listeners holds the listeners of the ports devices listen on, like the UDP
+// sockets of Linux's vxlan and geneve devices are shared by the devices of a
+// network namespace.
+var listeners struct {
+	mu sync.Mutex
+	m  map[listenerKey]*listener
+}
+
+// listener holds the UDP endpoints of a stack that receive the datagrams sent
+// to a port, and the devices listening on it.
+type listener struct {
+	key   listenerKey
+	encap encapsulation
+
+	// sockets is immutable.
+	sockets []*socket
+
+	// readers holds the goroutines reading the sockets.
+	readers sync.WaitGroup
+
+	mu sync.RWMutex
+
+	// devices maps the identifiers of virtual networks to their device.
+	devices map[uint32]*Endpoint
+
+	// groups counts the devices which joined each multicast group.
+	groups map[tcpip.MembershipOption]int
+}
+
+// socket is a UDP endpoint of the stack a listener sends and receives
+// datagrams through.
+type socket struct {
+	netProto tcpip.NetworkProtocolNumber
+	ep       tcpip.Endpoint
+	wq       waiter.Queue
+	done     chan struct{}
+}
+
+// listen makes e listen on port, along with the devices of s which already
+// listen on it with the same encapsulation.
+func listen(s *stack.Stack, port uint16, encap encapsulation, e *Endpoint) (*listener, *tcpip.Error) {
+	listeners.mu.Lock()
+	defer listeners.mu.Unlock()
+
+	key := listenerKey{stack: s, port: port}
+	l, ok := listeners.m[key]
+	if !ok {
+		var err *tcpip.Error
+		if l, err = newListener(key, encap); err != nil {
+			return nil, err
+		}
+		if listeners.m == nil {
+			listeners.m = make(map[listenerKey]*listener)
+		}
+		listeners.m[key] = l
+	} else if l.encap != encap {
+		return nil, tcpip.ErrPortInUse
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.devices[e.vni]; ok {
+		return nil, tcpip.ErrDuplicateAddress
+	}
+	l.devices[e.vni] = e
+	return l, nil
+}
+
+// newListener returns a listener with UDP endpoints of the stack bound to the
+// port of key, for each network protocol of the stack.
+func newListener(key listenerKey, encap encapsulation) (*listener, *tcpip.Error) {
+	var sockets []*socket
+	for _, netProto := range []tcpip.NetworkProtocolNumber{header.IPv4ProtocolNumber, header.IPv6ProtocolNumber} {
+		if key.stack.NetworkProtocolInstance(netProto) == nil {
+			continue
+		}
+		s := &socket{
+			netProto: netProto,
+			done:     make(chan struct{}),
+		}
+		ep, err := key.stack.NewEndpoint(header.UDPProtocolNumber, netProto, &s.wq)
+		if err != nil {
+			closeSockets(sockets)
+			return nil, err
+		}
+		s.ep = ep
+		if netProto == header.IPv6ProtocolNumber {
+			ep.SocketOptions().SetV6Only(true)
+		}
+		ep.SocketOptions().SetMulticastLoop(false)
+		if err := ep.Bind(tcpip.FullAddress{Port: key.port}); err != nil {
+			ep.Close()
+			closeSockets(sockets)
+			return nil, err
+		}
+		sockets = append(sockets, s)
+	}
+	if len(sockets) == 0 {
+		return nil, tcpip.ErrUnknownProtocol
+	}
+
+	l := &listener{
+		key:     key,
+		encap:   encap,
+		sockets: sockets,
+		devices: make(map[uint32]*Endpoint),
+		groups:  make(map[tcpip.MembershipOption]int),
+	}
+	for _, s := range sockets {
+		l.readers.Add(1)
+		go l.readLoop(s)
+	}
+	return l, nil
+}
+
+// release stops the device of the virtual network vni from listening, and
+// returns true if it was the last one, in which case the UDP endpoints of l
+// are closed.
+func (l *listener) release(vni uint32) bool {
+	listeners.mu.Lock()
+	defer listeners.mu.Unlock()
+
+	l.mu.Lock()
+	delete(l.devices, vni)
+	last := len(l.devices) == 0
+	l.mu.Unlock()
+	if last {
+		delete(listeners.m, l.key)
+		closeSockets(l.sockets)
+	}
+	return last
+}
+
+// closeSockets closes sockets and stops their readers.
+func closeSockets(sockets []*socket) {
+	for _, s := range sockets {
+		close(s.done)
+		s.ep.Close()
+	}
+}
+
+// socket returns the socket of l that sends datagrams to addr, or nil if the
+// stack doesn't have its network protocol.
+func (l *listener) socket(addr tcpip.Address) *socket {
+	netProto := header.IPv4ProtocolNumber
+	if len(addr) == header.IPv6AddressSize {
+		netProto = header.IPv6ProtocolNumber
+	}
+	for _, s := range l.sockets {
+		if s.netProto == netProto {
+			return s
+		}
+	}
+	return nil
+}
+
+// joinGroup makes the UDP endpoint of l receive the datagrams sent to the
+// multicast group addr on the NIC nicID, for a device.
+func (l *listener) joinGroup(nicID tcpip.NICID, addr tcpip.Address) *tcpip.Error {
+	s := l.socket(addr)
+	if s == nil {
+		return tcpip.ErrAddressFamilyNotSupported
+	}
+	key := tcpip.MembershipOption{NIC: nicID, MulticastAddr: addr}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.groups[key] == 0 {
+		opt := tcpip.AddMembershipOption(key)
+		if err := s.ep.SetSockOpt(&opt); err != nil {
+			return err
+		}
+	}
+	l.groups[key]++
+	return nil
+}
+
+// leaveGroup undoes joinGroup.
+func (l *listener) leaveGroup(nicID tcpip.NICID, addr tcpip.Address) {
+	key := tcpip.MembershipOption{NIC: nicID, MulticastAddr: addr}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.groups[key]--
+	if l.groups[key] == 0 {
+		delete(l.groups, key)
+		opt := tcpip.RemoveMembershipOption(key)
+		_ = l.socket(addr).ep.SetSockOpt(&opt)
+	}
+}
+
+// readLoop handles the datagrams received by s until it is closed.
+func (l *listener) readLoop(s *socket) {
+	defer l.readers.Done()
+	entry, ch := waiter.NewChannelEntry(nil)
+	s.wq.EventRegister(&entry, waiter.EventIn)
+	defer s.wq.EventUnregister(&entry)
+	for {
+		var src tcpip.FullAddress
+		payload, _, err := s.ep.Read(&src)
+		switch err {
+		case nil:
+			l.handleDatagram(src.Addr, payload)
+		case tcpip.ErrWouldBlock:
+			select {
+			case <-ch:
+			case <-s.done:
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// handleDatagram delivers a datagram received from src to the device of its
+// virtual network.
+func (l *listener) handleDatagram(src tcpip.Address, payload buffer.View) {
+	vni, ok := l.encap.parseVNI(payload)
+	if !ok {
+		return
+	}
+	l.mu.RLock()
+	e, ok := l.devices[vni]
+	l.mu.RUnlock()
+	if !ok {
+		return
+	}
+	e.deliver(src, payload)
+}
+
+// sendTo sends msg to dst through the UDP endpoint of its network protocol.
+func (l *listener) sendTo(dst tcpip.FullAddress, msg []byte) *tcpip.Error {
+	s := l.socket(dst.Addr)
+	if s == nil {
+		return tcpip.ErrAddressFamilyNotSupported
+	}
+	_, ch, err := s.ep.Write(tcpip.SlicePayload(msg), tcpip.WriteOptions{To: &dst})
+	if err == tcpip.ErrNoLinkAddress {
+		// Send the datagram once the link address of the next hop is
+		// resolved.
+		go func() {
+			<-ch
+			_, _, _ = s.ep.Write(tcpip.SlicePayload(msg), tcpip.WriteOptions{To: &dst})
+		}()
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,410 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package overlay provides link endpoints for ethernet overlay networks, whose
+// frames are encapsulated in UDP datagrams sent through the UDP endpoints of a
+// netstack stack: VXLAN devices (RFC 7348) and GENEVE devices (RFC 8926), like
+// Linux's vxlan and geneve devices.
+//
+// A device belongs to the virtual network identified by its VNI. The devices
+// of a stack which listen on the same port share its UDP endpoints, and the
+// datagrams they receive are delivered to the device of their VNI.
+//
+// Frames are sent to the remote endpoint their destination is reachable
+// through, as known from the forwarding database of VXLAN devices, or to the
+// default remote endpoint of the device otherwise. The default remote
+// endpoint may be a multicast group, which the device joins.
+//
+// Datagrams are sent from the port the device listens on, rather than from a
+// port derived from the flow of the encapsulated frame. The routes to the
+// remote endpoints must not go through the device.
+package overlay
+
+import (
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// defaultLinkMTU is the MTU of the link the datagrams of a device without a
+// default remote endpoint are assumed to be sent on, for its default MTU.
+const defaultLinkMTU = 1500
+
+// Options holds the configuration of a device.
+type Options struct {
+	// VNI is the identifier of the virtual network of the device.
+	VNI uint32
+
+	// LinkAddress is the link address of the device.
+	LinkAddress tcpip.LinkAddress
+
+	// Remote is the default remote endpoint of the device, to which frames
+	// are sent when their destination has no entry in the forwarding
+	// database. If it is a multicast address, the device joins the group.
+	//
+	// If empty, frames are only sent to the remote endpoints in the
+	// forwarding database.
+	Remote tcpip.Address
+
+	// NIC is the NIC datagrams are sent through and multicast groups are
+	// joined on. If zero, it is selected by the stack.
+	NIC tcpip.NICID
+
+	// Port is the UDP port the device listens on and sends datagrams to. If
+	// zero, the port assigned by IANA to the encapsulation is used.
+	Port uint16
+
+	// MTU is the MTU of the device.
+	//
+	// If zero, it is derived from the MTU of the route to Remote when the
+	// device is created, or from the MTU of an ethernet link if Remote is
+	// empty.
+	MTU uint32
+}
+
+// Stats holds the packet counters of a device.
+type Stats struct {
+	// DecapsulationErrors is the number of datagrams received for the device
+	// that were dropped because they don't hold a frame the device accepts.
+	DecapsulationErrors tcpip.StatCounter
+
+	// NoRemote is the number of frames that were dropped because no remote
+	// endpoint is known for their destination.
+	NoRemote tcpip.StatCounter
+}
+
+// encapsulation is the part of a device that is specific to the protocol used
+// to encapsulate frames. Its implementations are comparable, so that devices
+// sharing a port can be checked to use the same encapsulation.
+type encapsulation interface {
+	// port returns the UDP port assigned to the encapsulation by IANA.
+	port() uint16
+
+	// headerLength returns the size of the encapsulation header between the
+	// UDP header and the encapsulated frame.
+	headerLength() int
+
+	// encapsulate writes the encapsulation header of a frame of the virtual
+	// network vni in to hdr.
+	encapsulate(hdr buffer.View, vni uint32)
+
+	// parseVNI returns the identifier of the virtual network of a received
+	// datagram, or false if the datagram is malformed.
+	parseVNI(payload buffer.View) (uint32, bool)
+
+	// decapsulate returns the frame encapsulated in payload.
+	//
+	// Returns false if the frame must be dropped.
+	decapsulate(payload buffer.View) (buffer.View, bool)
+}
+
+// Endpoint is a VXLAN or GENEVE device link endpoint.
+type Endpoint struct {
+	stack    *stack.Stack
+	encap    encapsulation
+	vni      uint32
+	linkAddr tcpip.LinkAddress
+	remote   tcpip.Address
+	nicID    tcpip.NICID
+	port     uint16
+	mtu      uint32
+	listener *listener
+	stats    Stats
+
+	// fdb is the forwarding database of the device. It is nil for GENEVE
+	// devices, which send all the frames to their default remote endpoint.
+	fdb *fdb
+
+	// learning is true if the device learns the remote endpoints of the
+	// link addresses from the frames it receives.
+	learning bool
+
+	mu         sync.RWMutex
+	dispatcher stack.NetworkDispatcher
+	closed     bool
+
+	// stoppedListener is true if the listener of the device was stopped
+	// when it was closed, as the device was the last one listening on its
+	// port.
+	stoppedListener bool
+}
+
+var _ stack.LinkEndpoint = (*Endpoint)(nil)
+
+// newEndpoint returns a device that encapsulates frames with encap and sends
+// them through the UDP endpoints of s. If f is not nil, it is the forwarding
+// database of the device.
+func newEndpoint(s *stack.Stack, encap encapsulation, opts Options, f *fdb, learning bool) (*Endpoint, *tcpip.Error) {
+	if !header.IsValidUnicastEthernetAddress(opts.LinkAddress) {
+		return nil, tcpip.ErrBadAddress
+	}
+	var netProto tcpip.NetworkProtocolNumber
+	switch len(opts.Remote) {
+	case 0:
+	case header.IPv4AddressSize:
+		netProto = header.IPv4ProtocolNumber
+	case header.IPv6AddressSize:
+		netProto = header.IPv6ProtocolNumber
+	default:
+		return nil, tcpip.ErrBadAddress
+	}
+	if opts.Port == 0 {
+		opts.Port = encap.port()
+	}
+
+	if opts.MTU == 0 {
+		// The route's MTU already accounts for the outer IP header.
+		mtu := uint32(defaultLinkMTU - header.IPv4MinimumSize)
+		if len(opts.Remote) != 0 {
+			r, err := s.FindRoute(opts.NIC, "", opts.Remote, netProto, false /* multicastLoop */)
+			if err != nil {
+				return nil, err
+			}
+			mtu = r.MTU()
+			r.Release()
+		}
+		overhead := uint32(header.UDPMinimumSize + encap.headerLength() + header.EthernetMinimumSize)
+		if mtu <= overhead {
+			return nil, tcpip.ErrInvalidOptionValue
+		}
+		opts.MTU = mtu - overhead
+	}
+
+	e := &Endpoint{
+		stack:    s,
+		encap:    encap,
+		vni:      opts.VNI,
+		linkAddr: opts.LinkAddress,
+		remote:   opts.Remote,
+		nicID:    opts.NIC,
+		port:     opts.Port,
+		mtu:      opts.MTU,
+		fdb:      f,
+		learning: learning,
+	}
+	l, err := listen(s, opts.Port, encap, e)
+	if err != nil {
+		return nil, err
+	}
+	e.listener = l
+	if isMulticast(opts.Remote) {
+		if err := l.joinGroup(opts.NIC, opts.Remote); err != nil {
+			l.release(e.vni)
+			return nil, err
+		}
+	}
+	if f != nil && len(opts.Remote) != 0 {
+		// Like Linux, the default remote endpoint is the first default
+		// destination of the forwarding database.
+		f.add(DefaultDestination, opts.Remote)
+	}
+	return e, nil
+}
+
+// isMulticast returns true if addr is an IPv4 or IPv6 multicast address.
+func isMulticast(addr tcpip.Address) bool {
+	return header.IsV4MulticastAddress(addr) || header.IsV6MulticastAddress(addr)
+}
+
+// Stats returns the device's packet counters.
+func (e *Endpoint) Stats() *Stats {
+	return &e.stats
+}
+
+// VNI returns the identifier of the virtual network of the device.
+func (e *Endpoint) VNI() uint32 {
+	return e.vni
+}
+
+// Close leaves the multicast group of the device and stops listening on its
+// port, whose UDP endpoints are closed if no other device listens on it.
+func (e *Endpoint) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	e.closed = true
+	if isMulticast(e.remote) {
+		e.listener.leaveGroup(e.nicID, e.remote)
+	}
+	e.stoppedListener = e.listener.release(e.vni)
+}
+
+// Attach implements stack.LinkEndpoint.
+func (e *Endpoint) Attach(dispatcher stack.NetworkDispatcher) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dispatcher = dispatcher
+}
+
+// IsAttached implements stack.LinkEndpoint.
+func (e *Endpoint) IsAttached() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.dispatcher != nil
+}
+
+// MTU implements stack.LinkEndpoint.
+func (e *Endpoint) MTU() uint32 {
+	return e.mtu
+}
+
+// Capabilities implements stack.LinkEndpoint.
+func (*Endpoint) Capabilities() stack.LinkEndpointCapabilities {
+	return stack.CapabilityResolutionRequired
+}
+
+// MaxHeaderLength implements stack.LinkEndpoint.
+//
+// Frames are encapsulated in new buffers, so no space is reserved for the
+// encapsulation headers.
+func (*Endpoint) MaxHeaderLength() uint16 {
+	return header.EthernetMinimumSize
+}
+
+// LinkAddress implements stack.LinkEndpoint.
+func (e *Endpoint) LinkAddress() tcpip.LinkAddress {
+	return e.linkAddr
+}
+
+// Wait implements stack.LinkEndpoint.
+//
+// If the device was the last one listening on its port, it waits for the
+// readers of the UDP endpoints to stop after the device is closed.
+func (e *Endpoint) Wait() {
+	e.mu.RLock()
+	stopped := e.stoppedListener
+	e.mu.RUnlock()
+	if stopped {
+		e.listener.readers.Wait()
+	}
+}
+
+// ARPHardwareType implements stack.LinkEndpoint.
+func (*Endpoint) ARPHardwareType() header.ARPHardwareType {
+	return header.ARPHardwareEther
+}
+
+// AddHeader implements stack.LinkEndpoint.
+func (*Endpoint) AddHeader(local, remote tcpip.LinkAddress, proto tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	eth := header.Ethernet(pkt.LinkHeader().Push(header.EthernetMinimumSize))
+	eth.Encode(&header.EthernetFields{
+		SrcAddr: local,
+		DstAddr: remote,
+		Type:    proto,
+	})
+}
+
+// WritePacket implements stack.LinkEndpoint.
+//
+// The frame is sent to the remote endpoints its destination is reachable
+// through. Like flooding, replicating a frame to several remote endpoints
+// doesn't fail when some of them do.
+func (e *Endpoint) WritePacket(r *stack.Route, _ *stack.GSO, proto tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) *tcpip.Error {
+	src := r.LocalLinkAddress
+	if src == "" {
+		src = e.linkAddr
+	}
+	dst := r.RemoteLinkAddress()
+	e.AddHeader(src, dst, proto, pkt)
+
+	remotes := e.remotes(dst)
+	if len(remotes) == 0 {
+		e.stats.NoRemote.Increment()
+		return tcpip.ErrNoRoute
+	}
+
+	hdrLen := e.encap.headerLength()
+	msg := make([]byte, hdrLen, hdrLen+pkt.Size())
+	e.encap.encapsulate(msg, e.vni)
+	for _, v := range pkt.Views() {
+		msg = append(msg, v...)
+	}
+	for _, remote := range remotes {
+		err := e.listener.sendTo(tcpip.FullAddress{NIC: e.nicID, Addr: remote, Port: e.port}, msg)
+		if err != nil && len(remotes) == 1 {
+			return err
+		}
+	}
+	return nil
+}
+
+// WritePackets implements stack.LinkEndpoint.
+func (e *Endpoint) WritePackets(r *stack.Route, gso *stack.GSO, pkts stack.PacketBufferList, proto tcpip.NetworkProtocolNumber) (int, *tcpip.Error) {
+	n := 0
+	for pkt := pkts.Front(); pkt != nil; pkt = pkt.Next() {
+		if err := e.WritePacket(r, gso, proto, pkt); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// remotes returns the remote endpoints a frame to dst is sent to.
+func (e *Endpoint) remotes(dst tcpip.LinkAddress) []tcpip.Address {
+	if e.fdb == nil {
+		if len(e.remote) == 0 {
+			return nil
+		}
+		return []tcpip.Address{e.remote}
+	}
+	if header.IsValidUnicastEthernetAddress(dst) {
+		if remotes, ok := e.fdb.lookup(dst); ok {
+			return remotes
+		}
+	}
+	remotes, _ := e.fdb.lookup(DefaultDestination)
+	return remotes
+}
+
+// deliver decapsulates a datagram of the virtual network of the device
+// received from src, and delivers its frame to the device's dispatcher.
+func (e *Endpoint) deliver(src tcpip.Address, payload buffer.View) {
+	frame, ok := e.encap.decapsulate(payload)
+	if !ok || len(frame) < header.EthernetMinimumSize {
+		e.stats.DecapsulationErrors.Increment()
+		return
+	}
+	eth := header.Ethernet(frame)
+	srcAddr, dstAddr := eth.SourceAddress(), eth.DestinationAddress()
+	// Like Linux, frames looped back to the device are dropped, before the
+	// device learns its own address.
+	if srcAddr == e.linkAddr {
+		return
+	}
+	if e.learning && header.IsValidUnicastEthernetAddress(srcAddr) {
+		e.fdb.learn(srcAddr, src)
+	}
+	if dstAddr != e.linkAddr && dstAddr != header.EthernetBroadcastAddress && !header.IsMulticastEthernetAddress(dstAddr) {
+		return
+	}
+
+	e.mu.RLock()
+	d := e.dispatcher
+	e.mu.RUnlock()
+	if d == nil {
+		return
+	}
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: frame.ToVectorisedView(),
+	})
+	if _, ok := pkt.LinkHeader().Consume(header.EthernetMinimumSize); !ok {
+		return
+	}
+	d.DeliverNetworkPacket(srcAddr /* remote */, dstAddr /* local */, eth.Type(), pkt)
+}
@@ -0,0 +1,67 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package overlay
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/link/encaptest"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	deviceNICID = 2
+
+	linkAddr1    = tcpip.LinkAddress("\x02\x02\x03\x04\x05\x06")
+	linkAddr2    = tcpip.LinkAddress("\x02\x02\x03\x04\x05\x07")
+	devLinkAddr1 = tcpip.LinkAddress("\x02\x03\x03\x04\x05\x06")
+	devLinkAddr2 = tcpip.LinkAddress("\x02\x03\x03\x04\x05\x07")
+
+	outerAddr1 = tcpip.Address("\xc0\xa8\x00\x01")
+	outerAddr2 = tcpip.Address("\xc0\xa8\x00\x02")
+
+	innerAddr1   = tcpip.Address("\x0a\x00\x00\x01")
+	innerAddr2   = tcpip.Address("\x0a\x00\x00\x02")
+	innerV6Addr1 = tcpip.Address("\xfd\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01")
+	innerV6Addr2 = tcpip.Address("\xfd\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02")
+
+	testVNI = 42
+)
+
+// newTestStack returns a stack with an uplink NIC that has outerAddr.
+func newTestStack(t *testing.T, ep stack.LinkEndpoint, outerAddr tcpip.Address) *stack.Stack {
+	t.Helper()
+
+	return encaptest.NewStack(t, ep, encaptest.Options{
+		Addrs: []tcpip.AddressWithPrefix{{Address: outerAddr, PrefixLen: 24}},
+		ARP:   true,
+	})
+}
+
+// addDevice creates the NIC nicID for dev, with the inner addresses, and
+// closes dev when the test ends.
+func addDevice(t *testing.T, s *stack.Stack, nicID tcpip.NICID, dev *Endpoint, innerAddrs ...tcpip.Address) {
+	t.Helper()
+
+	t.Cleanup(func() {
+		dev.Close()
+		dev.Wait()
+	})
+	if err := s.CreateNIC(nicID, dev); err != nil {
+		t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+	}
+	encaptest.AddAddresses(t, s, nicID, innerAddrs...)
+}
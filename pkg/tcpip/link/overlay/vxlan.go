@@ -0,0 +1,132 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package overlay
+
+import (
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// VXLANOptions holds the VXLAN-specific configuration of a device.
+type VXLANOptions struct {
+	// Learning makes the device learn the remote endpoints the link
+	// addresses are reachable through from the frames it receives.
+	Learning bool
+
+	// AgingTime is the time after which the learned entries of the
+	// forwarding database expire. If zero, DefaultAgingTime is used.
+	AgingTime time.Duration
+}
+
+// NewVXLAN returns a VXLAN device that sends and receives datagrams through
+// the UDP endpoints of s, which must have the UDP protocol and IPv4 or IPv6
+// (or both) registered.
+//
+// Frames are sent to the remote endpoints of the entry of their destination
+// in the forwarding database of the device, or to the remote endpoints of the
+// DefaultDestination entries otherwise, the first of which is opts.Remote.
+func NewVXLAN(s *stack.Stack, opts Options, vxlanOpts VXLANOptions) (*Endpoint, *tcpip.Error) {
+	if opts.VNI > header.VXLANMaximumVNI {
+		return nil, tcpip.ErrInvalidOptionValue
+	}
+	agingTime := vxlanOpts.AgingTime
+	if agingTime == 0 {
+		agingTime = DefaultAgingTime
+	}
+	var f fdb
+	f.init(s.Clock(), agingTime)
+	return newEndpoint(s, vxlanEncapsulation{}, opts, &f, vxlanOpts.Learning)
+}
+
+// FDB returns the entries of the forwarding database of the device, or nil if
+// it isn't a VXLAN device.
+func (e *Endpoint) FDB() []FDBEntry {
+	if e.fdb == nil {
+		return nil
+	}
+	return e.fdb.dump()
+}
+
+// AddFDBEntry adds remote to the remote endpoints of the static entry of addr
+// in the forwarding database of the device, like bridge fdb append. The
+// static entry replaces the learned entry of addr, if any.
+//
+// If addr is DefaultDestination, frames whose destination has no entry are
+// also sent to remote.
+func (e *Endpoint) AddFDBEntry(addr tcpip.LinkAddress, remote tcpip.Address) *tcpip.Error {
+	if e.fdb == nil {
+		return tcpip.ErrNotSupported
+	}
+	if addr != DefaultDestination && !header.IsValidUnicastEthernetAddress(addr) {
+		return tcpip.ErrBadAddress
+	}
+	if len(remote) != header.IPv4AddressSize && len(remote) != header.IPv6AddressSize {
+		return tcpip.ErrBadAddress
+	}
+	e.fdb.add(addr, remote)
+	return nil
+}
+
+// RemoveFDBEntry removes remote from the remote endpoints of the entry of addr
+// in the forwarding database of the device, or the whole entry if remote is
+// empty.
+func (e *Endpoint) RemoveFDBEntry(addr tcpip.LinkAddress, remote tcpip.Address) *tcpip.Error {
+	if e.fdb == nil {
+		return tcpip.ErrNotSupported
+	}
+	if !e.fdb.remove(addr, remote) {
+		return tcpip.ErrBadAddress
+	}
+	return nil
+}
+
+// FlushFDB removes the learned entries of the forwarding database of the
+// device.
+func (e *Endpoint) FlushFDB() {
+	if e.fdb != nil {
+		e.fdb.flush()
+	}
+}
+
+// vxlanEncapsulation implements encapsulation for VXLAN devices.
+type vxlanEncapsulation struct{}
+
+func (vxlanEncapsulation) port() uint16 {
+	return header.VXLANPort
+}
+
+func (vxlanEncapsulation) headerLength() int {
+	return header.VXLANSize
+}
+
+func (vxlanEncapsulation) encapsulate(hdr buffer.View, vni uint32) {
+	header.VXLAN(hdr).Encode(vni)
+}
+
+func (vxlanEncapsulation) parseVNI(payload buffer.View) (uint32, bool) {
+	vxlan := header.VXLAN(payload)
+	if !vxlan.IsValid() {
+		return 0, false
+	}
+	return vxlan.VNI(), true
+}
+
+func (vxlanEncapsulation) decapsulate(payload buffer.View) (buffer.View, bool) {
+	return payload[header.VXLANSize:], true
+}
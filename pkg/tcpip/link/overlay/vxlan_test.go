@@ -0,0 +1,289 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package overlay
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/encaptest"
+	"gvisor.dev/gvisor/pkg/tcpip/link/pipe"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+func addVXLAN(t *testing.T, s *stack.Stack, nicID tcpip.NICID, opts Options, vxlanOpts VXLANOptions, innerAddrs ...tcpip.Address) *Endpoint {
+	t.Helper()
+
+	if opts.MTU == 0 {
+		opts.MTU = header.IPv6MinimumMTU
+	}
+	dev, err := NewVXLAN(s, opts, vxlanOpts)
+	if err != nil {
+		t.Fatalf("NewVXLAN(_, %+v, %+v): %s", opts, vxlanOpts, err)
+	}
+	addDevice(t, s, nicID, dev, innerAddrs...)
+	return dev
+}
+
+// hasFDBEntry returns true if the forwarding database of dev has want.
+func hasFDBEntry(dev *Endpoint, want FDBEntry) bool {
+	for _, e := range dev.FDB() {
+		if e == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestVXLAN(t *testing.T) {
+	link1, link2 := pipe.New(linkAddr1, linkAddr2)
+	s1 := newTestStack(t, link1, outerAddr1)
+	s2 := newTestStack(t, link2, outerAddr2)
+	dev1 := addVXLAN(t, s1, deviceNICID, Options{VNI: testVNI, LinkAddress: devLinkAddr1, Remote: outerAddr2}, VXLANOptions{}, innerAddr1, innerV6Addr1)
+	dev2 := addVXLAN(t, s2, deviceNICID, Options{VNI: testVNI, LinkAddress: devLinkAddr2, Remote: outerAddr1}, VXLANOptions{Learning: true}, innerAddr2, innerV6Addr2)
+
+	encaptest.CheckUDP(t, s1, s2, ipv4.ProtocolNumber, innerAddr1, innerAddr2, []byte("hello"))
+	encaptest.CheckUDP(t, s2, s1, ipv4.ProtocolNumber, innerAddr2, innerAddr1, []byte("world"))
+	encaptest.CheckUDP(t, s1, s2, ipv6.ProtocolNumber, innerV6Addr1, innerV6Addr2, []byte("hello v6"))
+	encaptest.CheckUDP(t, s2, s1, ipv6.ProtocolNumber, innerV6Addr2, innerV6Addr1, []byte("world v6"))
+
+	// Only the second device learns.
+	if want := (FDBEntry{Addr: devLinkAddr1, Remote: outerAddr1}); !hasFDBEntry(dev2, want) {
+		t.Errorf("got dev2.FDB() = %+v, want entry %+v", dev2.FDB(), want)
+	}
+	if want := (FDBEntry{Addr: DefaultDestination, Remote: outerAddr1, Static: true}); !hasFDBEntry(dev2, want) {
+		t.Errorf("got dev2.FDB() = %+v, want entry %+v", dev2.FDB(), want)
+	}
+	if got, want := len(dev1.FDB()), 1; got != want {
+		t.Errorf("got len(dev1.FDB()) = %d, want = %d", got, want)
+	}
+	dev2.FlushFDB()
+	if got, want := len(dev2.FDB()), 1; got != want {
+		t.Errorf("got len(dev2.FDB()) = %d after FlushFDB, want = %d", got, want)
+	}
+
+	for i, dev := range []*Endpoint{dev1, dev2} {
+		if got := dev.Stats().DecapsulationErrors.Value(); got != 0 {
+			t.Errorf("got dev%d.Stats().DecapsulationErrors.Value() = %d, want = 0", i+1, got)
+		}
+		if got := dev.Stats().NoRemote.Value(); got != 0 {
+			t.Errorf("got dev%d.Stats().NoRemote.Value() = %d, want = 0", i+1, got)
+		}
+	}
+}
+
+func TestVXLANDefaultMTU(t *testing.T) {
+	link1, _ := pipe.New(linkAddr1, linkAddr2)
+	s := newTestStack(t, link1, outerAddr1)
+
+	tests := []struct {
+		name    string
+		remote  tcpip.Address
+		wantMTU uint32
+	}{
+		{
+			name:    "remote",
+			remote:  outerAddr2,
+			wantMTU: header.IPv6MinimumMTU - header.IPv4MinimumSize - header.UDPMinimumSize - header.VXLANSize - header.EthernetMinimumSize,
+		},
+		{
+			name:    "no remote",
+			wantMTU: 1450,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dev, err := NewVXLAN(s, Options{VNI: testVNI, LinkAddress: devLinkAddr1, Remote: test.remote}, VXLANOptions{})
+			if err != nil {
+				t.Fatalf("NewVXLAN(_, _, _): %s", err)
+			}
+			defer dev.Wait()
+			defer dev.Close()
+			if got := dev.MTU(); got != test.wantMTU {
+				t.Errorf("got dev.MTU() = %d, want = %d", got, test.wantMTU)
+			}
+		})
+	}
+}
+
+func TestVXLANFDB(t *testing.T) {
+	link1, link2 := pipe.New(linkAddr1, linkAddr2)
+	s1 := newTestStack(t, link1, outerAddr1)
+	s2 := newTestStack(t, link2, outerAddr2)
+	dev1 := addVXLAN(t, s1, deviceNICID, Options{VNI: testVNI, LinkAddress: devLinkAddr1}, VXLANOptions{}, innerAddr1)
+	dev2 := addVXLAN(t, s2, deviceNICID, Options{VNI: testVNI, LinkAddress: devLinkAddr2}, VXLANOptions{}, innerAddr2)
+
+	// Without a default destination, the frames to multicast addresses, like
+	// ARP requests, are dropped.
+	var wq waiter.Queue
+	ep, err := s1.NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+	if err != nil {
+		t.Fatalf("s1.NewEndpoint(%d, %d, _): %s", udp.ProtocolNumber, ipv4.ProtocolNumber, err)
+	}
+	defer ep.Close()
+	to := tcpip.FullAddress{NIC: deviceNICID, Addr: header.IPv4AllSystems, Port: 1234}
+	if _, _, err := ep.Write(tcpip.SlicePayload("dropped"), tcpip.WriteOptions{To: &to}); err != tcpip.ErrNoRoute {
+		t.Fatalf("got ep.Write(_, _) = %v, want = %s", err, tcpip.ErrNoRoute)
+	}
+	if got := dev1.Stats().NoRemote.Value(); got != 1 {
+		t.Errorf("got dev1.Stats().NoRemote.Value() = %d, want = 1", got)
+	}
+
+	for _, test := range []struct {
+		addr   tcpip.LinkAddress
+		remote tcpip.Address
+	}{
+		{addr: header.EthernetBroadcastAddress, remote: outerAddr2},
+		{addr: devLinkAddr2, remote: "\x01"},
+	} {
+		if err := dev1.AddFDBEntry(test.addr, test.remote); err != tcpip.ErrBadAddress {
+			t.Errorf("got dev1.AddFDBEntry(%s, %s) = %v, want = %s", test.addr, test.remote, err, tcpip.ErrBadAddress)
+		}
+	}
+
+	// The first device only knows the second one, which replies to the
+	// first device through its default destination.
+	if err := dev1.AddFDBEntry(devLinkAddr2, outerAddr2); err != nil {
+		t.Fatalf("dev1.AddFDBEntry(%s, %s): %s", devLinkAddr2, outerAddr2, err)
+	}
+	if err := dev2.AddFDBEntry(DefaultDestination, outerAddr1); err != nil {
+		t.Fatalf("dev2.AddFDBEntry(%s, %s): %s", DefaultDestination, outerAddr1, err)
+	}
+	if err := s1.AddStaticNeighbor(deviceNICID, innerAddr2, devLinkAddr2); err != nil {
+		t.Fatalf("s1.AddStaticNeighbor(%d, %s, %s): %s", deviceNICID, innerAddr2, devLinkAddr2, err)
+	}
+	encaptest.CheckUDP(t, s1, s2, ipv4.ProtocolNumber, innerAddr1, innerAddr2, []byte("hello"))
+	encaptest.CheckUDP(t, s2, s1, ipv4.ProtocolNumber, innerAddr2, innerAddr1, []byte("world"))
+
+	if want := (FDBEntry{Addr: devLinkAddr2, Remote: outerAddr2, Static: true}); !hasFDBEntry(dev1, want) {
+		t.Errorf("got dev1.FDB() = %+v, want entry %+v", dev1.FDB(), want)
+	}
+	if err := dev1.RemoveFDBEntry(devLinkAddr2, outerAddr1); err != tcpip.ErrBadAddress {
+		t.Errorf("got dev1.RemoveFDBEntry(%s, %s) = %v, want = %s", devLinkAddr2, outerAddr1, err, tcpip.ErrBadAddress)
+	}
+	if err := dev1.RemoveFDBEntry(devLinkAddr2, outerAddr2); err != nil {
+		t.Errorf("dev1.RemoveFDBEntry(%s, %s): %s", devLinkAddr2, outerAddr2, err)
+	}
+	if got := dev1.FDB(); len(got) != 0 {
+		t.Errorf("got dev1.FDB() = %+v, want = []", got)
+	}
+}
+
+func TestVXLANFDBReplication(t *testing.T) {
+	link1, _ := pipe.New(linkAddr1, linkAddr2)
+	s := newTestStack(t, link1, outerAddr1)
+	dev, err := NewVXLAN(s, Options{VNI: testVNI, LinkAddress: devLinkAddr1, Remote: outerAddr2, MTU: header.IPv6MinimumMTU}, VXLANOptions{})
+	if err != nil {
+		t.Fatalf("NewVXLAN(_, _, _): %s", err)
+	}
+	defer dev.Wait()
+	defer dev.Close()
+
+	const outerAddr3 = tcpip.Address("\xc0\xa8\x00\x03")
+	for _, remote := range []tcpip.Address{outerAddr3, outerAddr2} {
+		if err := dev.AddFDBEntry(DefaultDestination, remote); err != nil {
+			t.Fatalf("dev.AddFDBEntry(%s, %s): %s", DefaultDestination, remote, err)
+		}
+	}
+	got, _ := dev.fdb.lookup(DefaultDestination)
+	if want := []tcpip.Address{outerAddr2, outerAddr3}; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got default destinations = %s, want = %s", got, want)
+	}
+	if got := dev.remotes(devLinkAddr2); len(got) != 2 {
+		t.Errorf("got dev.remotes(%s) = %s, want both default destinations", devLinkAddr2, got)
+	}
+
+	if err := dev.RemoveFDBEntry(DefaultDestination, ""); err != nil {
+		t.Fatalf("dev.RemoveFDBEntry(%s, \"\"): %s", DefaultDestination, err)
+	}
+	if got := dev.remotes(devLinkAddr2); len(got) != 0 {
+		t.Errorf("got dev.remotes(%s) = %s, want = []", devLinkAddr2, got)
+	}
+}
+
+func TestVXLANMulticast(t *testing.T) {
+	const group = tcpip.Address("\xef\x01\x02\x03")
+
+	link1, link2 := pipe.New(linkAddr1, linkAddr2)
+	s1 := newTestStack(t, link1, outerAddr1)
+	s2 := newTestStack(t, link2, outerAddr2)
+	opts := Options{VNI: testVNI, Remote: group, NIC: encaptest.UplinkNICID}
+	opts.LinkAddress = devLinkAddr1
+	dev1 := addVXLAN(t, s1, deviceNICID, opts, VXLANOptions{Learning: true}, innerAddr1)
+	opts.LinkAddress = devLinkAddr2
+	dev2 := addVXLAN(t, s2, deviceNICID, opts, VXLANOptions{Learning: true}, innerAddr2)
+
+	// The ARP requests are sent to the group, and the replies to the remote
+	// endpoint learned from the requests.
+	encaptest.CheckUDP(t, s1, s2, ipv4.ProtocolNumber, innerAddr1, innerAddr2, []byte("hello"))
+	encaptest.CheckUDP(t, s2, s1, ipv4.ProtocolNumber, innerAddr2, innerAddr1, []byte("world"))
+
+	if want := (FDBEntry{Addr: devLinkAddr1, Remote: outerAddr1}); !hasFDBEntry(dev2, want) {
+		t.Errorf("got dev2.FDB() = %+v, want entry %+v", dev2.FDB(), want)
+	}
+	if want := (FDBEntry{Addr: devLinkAddr2, Remote: outerAddr2}); !hasFDBEntry(dev1, want) {
+		t.Errorf("got dev1.FDB() = %+v, want entry %+v", dev1.FDB(), want)
+	}
+	if in, err := s2.IsInGroup(encaptest.UplinkNICID, group); err != nil || !in {
+		t.Errorf("got s2.IsInGroup(%d, %s) = (%t, %v), want = (true, nil)", encaptest.UplinkNICID, group, in, err)
+	}
+	dev2.Close()
+	if in, err := s2.IsInGroup(encaptest.UplinkNICID, group); err != nil || in {
+		t.Errorf("got s2.IsInGroup(%d, %s) = (%t, %v) after dev2.Close(), want = (false, nil)", encaptest.UplinkNICID, group, in, err)
+	}
+}
+
+func TestVXLANSharedPort(t *testing.T) {
+	const (
+		otherNICID = 3
+		otherVNI   = 43
+
+		otherInnerAddr1 = tcpip.Address("\x0a\x00\x01\x01")
+		otherInnerAddr2 = tcpip.Address("\x0a\x00\x01\x02")
+	)
+
+	link1, link2 := pipe.New(linkAddr1, linkAddr2)
+	s1 := newTestStack(t, link1, outerAddr1)
+	s2 := newTestStack(t, link2, outerAddr2)
+	addVXLAN(t, s1, deviceNICID, Options{VNI: testVNI, LinkAddress: devLinkAddr1, Remote: outerAddr2}, VXLANOptions{}, innerAddr1)
+	addVXLAN(t, s2, deviceNICID, Options{VNI: testVNI, LinkAddress: devLinkAddr2, Remote: outerAddr1}, VXLANOptions{}, innerAddr2)
+	other1 := addVXLAN(t, s1, otherNICID, Options{VNI: otherVNI, LinkAddress: devLinkAddr1, Remote: outerAddr2}, VXLANOptions{}, otherInnerAddr1)
+	addVXLAN(t, s2, otherNICID, Options{VNI: otherVNI, LinkAddress: devLinkAddr2, Remote: outerAddr1}, VXLANOptions{}, otherInnerAddr2)
+
+	encaptest.CheckUDP(t, s1, s2, ipv4.ProtocolNumber, innerAddr1, innerAddr2, []byte("hello"))
+	encaptest.CheckUDP(t, s1, s2, ipv4.ProtocolNumber, otherInnerAddr1, otherInnerAddr2, []byte("hello other"))
+
+	if _, err := NewVXLAN(s1, Options{VNI: otherVNI, LinkAddress: devLinkAddr1}, VXLANOptions{}); err != tcpip.ErrDuplicateAddress {
+		t.Errorf("got NewVXLAN(_, _, _) with a used VNI = %v, want = %s", err, tcpip.ErrDuplicateAddress)
+	}
+	if _, err := NewGENEVE(s1, Options{VNI: testVNI + 100, LinkAddress: devLinkAddr1, Port: header.VXLANPort}); err != tcpip.ErrPortInUse {
+		t.Errorf("got NewGENEVE(_, _) on the VXLAN port = %v, want = %s", err, tcpip.ErrPortInUse)
+	}
+
+	// The VNI of a closed device may be reused, and the other devices keep
+	// receiving.
+	other1.Close()
+	dev, err := NewVXLAN(s1, Options{VNI: otherVNI, LinkAddress: devLinkAddr1}, VXLANOptions{})
+	if err != nil {
+		t.Fatalf("NewVXLAN(_, _, _) after closing the device of its VNI: %s", err)
+	}
+	dev.Close()
+	encaptest.CheckUDP(t, s2, s1, ipv4.ProtocolNumber, innerAddr2, innerAddr1, []byte("world"))
+}
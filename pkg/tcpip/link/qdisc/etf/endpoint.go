@@ -0,0 +1,315 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package etf provides the implementation of data-link layer endpoints that
+// wrap another endpoint and hold outbound packets scheduled with SO_TXTIME
+// until their transmit time, like Linux's Earliest TxTime First queueing
+// discipline.
+//
+// Packets whose transmit time has passed are dropped. Unlike Linux's ETF,
+// packets without a transmit time are written to the lower endpoint
+// immediately, rather than dropped.
+package etf
+
+import (
+	"container/heap"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// endpoint represents a LinkEndpoint which holds outgoing packets scheduled
+// with SO_TXTIME in a queue sorted by transmit time.
+type endpoint struct {
+	dispatcher stack.NetworkDispatcher
+	lower      stack.LinkEndpoint
+	clock      tcpip.Clock
+
+	// delta is how early before their transmit time packets are written to
+	// the lower endpoint, to account for the latency of the lower layers.
+	delta time.Duration
+
+	// limit is the maximum number of packets held in the queue.
+	limit int
+
+	mu     sync.Mutex
+	q      packetHeap
+	nextID uint64
+	closed bool
+
+	// timer if not nil expires at timerAt to write the packets whose
+	// transmit time has come.
+	timer   tcpip.Timer
+	timerAt int64
+}
+
+// New creates a new ETF link endpoint which holds at most limit packets, and
+// writes them to lower delta before their transmit time according to clock,
+// which must be the clock of the stack.
+func New(lower stack.LinkEndpoint, clock tcpip.Clock, limit int, delta time.Duration) stack.LinkEndpoint {
+	return &endpoint{
+		lower: lower,
+		clock: clock,
+		delta: delta,
+		limit: limit,
+	}
+}
+
+// DeliverNetworkPacket implements stack.NetworkDispatcher.DeliverNetworkPacket.
+func (e *endpoint) DeliverNetworkPacket(remote, local tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	e.dispatcher.DeliverNetworkPacket(remote, local, protocol, pkt)
+}
+
+// DeliverOutboundPacket implements stack.NetworkDispatcher.DeliverOutboundPacket.
+func (e *endpoint) DeliverOutboundPacket(remote, local tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	e.dispatcher.DeliverOutboundPacket(remote, local, protocol, pkt)
+}
+
+// Attach implements stack.LinkEndpoint.Attach.
+func (e *endpoint) Attach(dispatcher stack.NetworkDispatcher) {
+	e.dispatcher = dispatcher
+	e.lower.Attach(e)
+}
+
+// IsAttached implements stack.LinkEndpoint.IsAttached.
+func (e *endpoint) IsAttached() bool {
+	return e.dispatcher != nil
+}
+
+// MTU implements stack.LinkEndpoint.MTU.
+func (e *endpoint) MTU() uint32 {
+	return e.lower.MTU()
+}
+
+// Capabilities implements stack.LinkEndpoint.Capabilities.
+func (e *endpoint) Capabilities() stack.LinkEndpointCapabilities {
+	// Scheduled packets are written to the lower endpoint asynchronously.
+	return e.lower.Capabilities() &^ stack.CapabilityZeroCopyTX
+}
+
+// MaxHeaderLength implements stack.LinkEndpoint.MaxHeaderLength.
+func (e *endpoint) MaxHeaderLength() uint16 {
+	return e.lower.MaxHeaderLength()
+}
+
+// LinkAddress implements stack.LinkEndpoint.LinkAddress.
+func (e *endpoint) LinkAddress() tcpip.LinkAddress {
+	return e.lower.LinkAddress()
+}
+
+// GSOMaxSize returns the maximum GSO packet size.
+func (e *endpoint) GSOMaxSize() uint32 {
+	if gso, ok := e.lower.(stack.GSOEndpoint); ok {
+		return gso.GSOMaxSize()
+	}
+	return 0
+}
+
+// WritePacket implements stack.LinkEndpoint.WritePacket.
+func (e *endpoint) WritePacket(r *stack.Route, gso *stack.GSO, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) *tcpip.Error {
+	if pkt.TxTime.Time == 0 {
+		return e.lower.WritePacket(r, gso, protocol, pkt)
+	}
+	if e.missed(pkt, e.clock.NowMonotonic()) {
+		return nil
+	}
+	if pkt.TxTime.Deadline {
+		// The packet may be transmitted before its deadline.
+		return e.lower.WritePacket(r, gso, protocol, pkt)
+	}
+
+	// WritePacket caller's do not set the following fields in PacketBuffer
+	// so we populate them here.
+	pkt.EgressRoute = r.Clone()
+	pkt.GSOOptions = gso
+	pkt.NetworkProtocolNumber = protocol
+	if !e.enqueue(pkt) {
+		pkt.EgressRoute.Release()
+		return tcpip.ErrNoBufferSpace
+	}
+	return nil
+}
+
+// WritePackets implements stack.LinkEndpoint.WritePackets.
+//
+// Being a batch API, each packet in pkts should have the following fields
+// populated:
+//   - pkt.EgressRoute
+//   - pkt.GSOOptions
+//   - pkt.NetworkProtocolNumber
+func (e *endpoint) WritePackets(_ *stack.Route, _ *stack.GSO, pkts stack.PacketBufferList, _ tcpip.NetworkProtocolNumber) (int, *tcpip.Error) {
+	n := 0
+	for pkt := pkts.Front(); pkt != nil; pkt = pkt.Next() {
+		if err := e.WritePacket(pkt.EgressRoute, pkt.GSOOptions, pkt.NetworkProtocolNumber, pkt); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// missed returns true if the transmit time of pkt has passed at now, in which
+// case pkt is dropped.
+func (e *endpoint) missed(pkt *stack.PacketBuffer, now int64) bool {
+	if pkt.TxTime.Time >= now {
+		return false
+	}
+	if pkt.TxTime.Missed != nil {
+		pkt.TxTime.Missed()
+	}
+	return true
+}
+
+// enqueue holds pkt until its transmit time. It returns false if the queue is
+// full.
+func (e *endpoint) enqueue(pkt *stack.PacketBuffer) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed || e.q.Len() >= e.limit {
+		return false
+	}
+	pkt.DeferTransmitDone()
+	heap.Push(&e.q, scheduledPacket{pkt: pkt, id: e.nextID})
+	e.nextID++
+	e.scheduleLocked()
+	return true
+}
+
+// scheduleLocked arms the timer to expire when the first packet of the queue
+// is to be written.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) scheduleLocked() {
+	if e.q.Len() == 0 {
+		return
+	}
+	at := e.q[0].pkt.TxTime.Time - e.delta.Nanoseconds()
+	if e.timer != nil {
+		if e.timerAt <= at {
+			return
+		}
+		if !e.timer.Stop() {
+			// The timer is expiring, and will schedule the next
+			// expiration.
+			return
+		}
+	}
+	e.timerAt = at
+	e.timer = e.clock.AfterFunc(time.Duration(at-e.clock.NowMonotonic()), e.dequeue)
+}
+
+// dequeue writes the packets whose transmit time has come to the lower
+// endpoint.
+func (e *endpoint) dequeue() {
+	e.mu.Lock()
+	e.timer = nil
+	now := e.clock.NowMonotonic()
+	var batch stack.PacketBufferList
+	for e.q.Len() > 0 && e.q[0].pkt.TxTime.Time-e.delta.Nanoseconds() <= now {
+		batch.PushBack(heap.Pop(&e.q).(scheduledPacket).pkt)
+	}
+	if !e.closed {
+		e.scheduleLocked()
+	}
+	e.mu.Unlock()
+
+	for pkt := batch.Front(); pkt != nil; {
+		nxt := pkt.Next()
+		batch.Remove(pkt)
+		if !e.missed(pkt, now) {
+			e.lower.WritePacket(pkt.EgressRoute, pkt.GSOOptions, pkt.NetworkProtocolNumber, pkt)
+		}
+		pkt.EgressRoute.Release()
+		pkt.TransmitDone()
+		pkt = nxt
+	}
+}
+
+// Wait implements stack.LinkEndpoint.Wait.
+func (e *endpoint) Wait() {
+	e.lower.Wait()
+
+	// The linkEP is gone. Drop the packets still scheduled.
+	e.mu.Lock()
+	e.closed = true
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+	q := e.q
+	e.q = nil
+	e.mu.Unlock()
+
+	for _, p := range q {
+		p.pkt.EgressRoute.Release()
+		p.pkt.TransmitDone()
+	}
+}
+
+// ARPHardwareType implements stack.LinkEndpoint.ARPHardwareType
+func (e *endpoint) ARPHardwareType() header.ARPHardwareType {
+	return e.lower.ARPHardwareType()
+}
+
+// AddHeader implements stack.LinkEndpoint.AddHeader.
+func (e *endpoint) AddHeader(local, remote tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	e.lower.AddHeader(local, remote, protocol, pkt)
+}
+
+// scheduledPacket is a packet held until its transmit time.
+type scheduledPacket struct {
+	pkt *stack.PacketBuffer
+
+	// id orders the packets with the same transmit time by arrival.
+	id uint64
+}
+
+// packetHeap is a min-heap of packets ordered by transmit time.
+type packetHeap []scheduledPacket
+
+// Len implements heap.Interface.Len.
+func (h packetHeap) Len() int {
+	return len(h)
+}
+
+// Less implements heap.Interface.Less.
+func (h packetHeap) Less(i, j int) bool {
+	if h[i].pkt.TxTime.Time != h[j].pkt.TxTime.Time {
+		return h[i].pkt.TxTime.Time < h[j].pkt.TxTime.Time
+	}
+	return h[i].id < h[j].id
+}
+
+// Swap implements heap.Interface.Swap.
+func (h packetHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+// Push implements heap.Interface.Push.
+func (h *packetHeap) Push(x interface{}) {
+	*h = append(*h, x.(scheduledPacket))
+}
+
+// Pop implements heap.Interface.Pop.
+func (h *packetHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = scheduledPacket{}
+	*h = old[:n-1]
+	return x
+}
@@ -0,0 +1,180 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etf
+
+import (
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	nicID      = 1
+	localAddr  = tcpip.Address("\x0a\x00\x00\x01")
+	remoteAddr = tcpip.Address("\x0a\x00\x00\x02")
+	delta      = time.Millisecond
+)
+
+type testContext struct {
+	clock *faketime.ManualClock
+	lower *channel.Endpoint
+	ep    stack.LinkEndpoint
+	route *stack.Route
+}
+
+func newTestContext(t *testing.T, limit int) *testContext {
+	t.Helper()
+	clock := faketime.NewManualClock()
+	lower := channel.New(limit+1, header.IPv4MinimumMTU, "")
+	ep := New(lower, clock, limit, delta)
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		Clock:            clock,
+	})
+	if err := s.CreateNIC(nicID, ep); err != nil {
+		t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+	}
+	if err := s.AddAddress(nicID, ipv4.ProtocolNumber, localAddr); err != nil {
+		t.Fatalf("s.AddAddress(%d, %d, %s): %s", nicID, ipv4.ProtocolNumber, localAddr, err)
+	}
+	s.SetRouteTable([]tcpip.Route{{Destination: header.IPv4EmptySubnet, NIC: nicID}})
+	r, err := s.FindRoute(nicID, localAddr, remoteAddr, ipv4.ProtocolNumber, false /* multicastLoop */)
+	if err != nil {
+		t.Fatalf("s.FindRoute(%d, %s, %s, %d, false): %s", nicID, localAddr, remoteAddr, ipv4.ProtocolNumber, err)
+	}
+	t.Cleanup(r.Release)
+	return &testContext{
+		clock: clock,
+		lower: lower,
+		ep:    ep,
+		route: r,
+	}
+}
+
+// write writes a packet with payload b scheduled at txTime.
+func (c *testContext) write(t *testing.T, b byte, txTime stack.TxTime) {
+	t.Helper()
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: buffer.View([]byte{b}).ToVectorisedView(),
+	})
+	pkt.TxTime = txTime
+	if err := c.ep.WritePacket(c.route, nil /* gso */, ipv4.ProtocolNumber, pkt); err != nil {
+		t.Fatalf("WritePacket(_, nil, %d, _): %s", ipv4.ProtocolNumber, err)
+	}
+}
+
+// read returns the payloads of the packets written to the lower endpoint.
+func (c *testContext) read() []byte {
+	var b []byte
+	for {
+		p, ok := c.lower.Read()
+		if !ok {
+			return b
+		}
+		b = append(b, p.Pkt.Data.ToView()[0])
+	}
+}
+
+func TestScheduledOrder(t *testing.T) {
+	c := newTestContext(t, 10)
+	now := c.clock.NowMonotonic()
+	c.write(t, 3, stack.TxTime{Time: now + int64(30*time.Millisecond)})
+	c.write(t, 1, stack.TxTime{Time: now + int64(10*time.Millisecond)})
+	c.write(t, 2, stack.TxTime{Time: now + int64(20*time.Millisecond)})
+	c.write(t, 4, stack.TxTime{Time: now + int64(30*time.Millisecond)})
+	c.write(t, 0, stack.TxTime{})
+
+	if got, want := string(c.read()), "\x00"; got != want {
+		t.Fatalf("got written packets = %q, want = %q", got, want)
+	}
+
+	c.clock.Advance(10*time.Millisecond - delta - 1)
+	if got := c.read(); len(got) != 0 {
+		t.Fatalf("got written packets = %q before their transmit time", got)
+	}
+	c.clock.Advance(1)
+	if got, want := string(c.read()), "\x01"; got != want {
+		t.Fatalf("got written packets = %q, want = %q", got, want)
+	}
+	c.clock.Advance(20 * time.Millisecond)
+	if got, want := string(c.read()), "\x02\x03\x04"; got != want {
+		t.Fatalf("got written packets = %q, want = %q", got, want)
+	}
+}
+
+func TestDeadlineMode(t *testing.T) {
+	c := newTestContext(t, 10)
+	now := c.clock.NowMonotonic()
+	c.write(t, 1, stack.TxTime{Time: now + int64(time.Second), Deadline: true})
+	if got, want := string(c.read()), "\x01"; got != want {
+		t.Fatalf("got written packets = %q, want = %q", got, want)
+	}
+}
+
+func TestMissedDeadline(t *testing.T) {
+	c := newTestContext(t, 10)
+	c.clock.Advance(time.Second)
+	missed := 0
+	c.write(t, 1, stack.TxTime{
+		Time: c.clock.NowMonotonic() - 1,
+		Missed: func() {
+			missed++
+		},
+	})
+	if got := c.read(); len(got) != 0 {
+		t.Errorf("got written packets = %q, want none", got)
+	}
+	if missed != 1 {
+		t.Errorf("got missed = %d, want = 1", missed)
+	}
+}
+
+func TestQueueLimit(t *testing.T) {
+	const limit = 2
+	c := newTestContext(t, limit)
+	txTime := stack.TxTime{Time: c.clock.NowMonotonic() + int64(10*time.Millisecond)}
+	for i := 0; i < limit; i++ {
+		c.write(t, byte(i), txTime)
+	}
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: buffer.View([]byte{limit}).ToVectorisedView(),
+	})
+	pkt.TxTime = txTime
+	if err := c.ep.WritePacket(c.route, nil /* gso */, ipv4.ProtocolNumber, pkt); err != tcpip.ErrNoBufferSpace {
+		t.Fatalf("got WritePacket(_, nil, %d, _) = %s, want = %s", ipv4.ProtocolNumber, err, tcpip.ErrNoBufferSpace)
+	}
+
+	c.clock.Advance(10 * time.Millisecond)
+	if got, want := string(c.read()), "\x00\x01"; got != want {
+		t.Fatalf("got written packets = %q, want = %q", got, want)
+	}
+}
+
+func TestWaitDropsScheduledPackets(t *testing.T) {
+	c := newTestContext(t, 10)
+	c.write(t, 1, stack.TxTime{Time: c.clock.NowMonotonic() + int64(10*time.Millisecond)})
+	c.ep.Wait()
+	c.clock.Advance(time.Second)
+	if got := c.read(); len(got) != 0 {
+		t.Fatalf("got written packets = %q after Wait, want none", got)
+	}
+}
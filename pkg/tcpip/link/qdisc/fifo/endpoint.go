@@ -92,9 +92,12 @@ func (q *queueDispatcher) dispatchLoop() {
 			// We pass a protocol of zero here because each packet carries its
 			// NetworkProtocol.
 			q.lower.WritePackets(nil /* route */, nil /* gso */, batch, 0 /* protocol */)
-			for pkt := batch.Front(); pkt != nil; pkt = pkt.Next() {
+			for pkt := batch.Front(); pkt != nil; {
+				nxt := pkt.Next()
 				pkt.EgressRoute.Release()
 				batch.Remove(pkt)
+				pkt.TransmitDone()
+				pkt = nxt
 			}
 			batch.Reset()
 		}
@@ -129,7 +132,8 @@ func (e *endpoint) MTU() uint32 {
 
 // Capabilities implements stack.LinkEndpoint.Capabilities.
 func (e *endpoint) Capabilities() stack.LinkEndpointCapabilities {
-	return e.lower.Capabilities()
+	// Queued packets are written to the lower endpoint asynchronously.
+	return e.lower.Capabilities() &^ stack.CapabilityZeroCopyTX
 }
 
 // MaxHeaderLength implements stack.LinkEndpoint.MaxHeaderLength.
@@ -159,7 +163,9 @@ func (e *endpoint) WritePacket(r *stack.Route, gso *stack.GSO, protocol tcpip.Ne
 	pkt.GSOOptions = gso
 	pkt.NetworkProtocolNumber = protocol
 	d := e.dispatchers[int(pkt.Hash)%len(e.dispatchers)]
+	pkt.DeferTransmitDone()
 	if !d.q.enqueue(pkt) {
+		pkt.TransmitDone()
 		return tcpip.ErrNoBufferSpace
 	}
 	d.newPacketWaker.Assert()
@@ -183,7 +189,9 @@ func (e *endpoint) WritePackets(_ *stack.Route, _ *stack.GSO, pkts stack.PacketB
 		// packet is still in our queue.
 		newRoute := pkt.EgressRoute.Clone()
 		pkt.EgressRoute = newRoute
+		pkt.DeferTransmitDone()
 		if !d.q.enqueue(pkt) {
+			pkt.TransmitDone()
 			if enqueued > 0 {
 				d.newPacketWaker.Assert()
 			}
@@ -0,0 +1,353 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package qdisc
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/rand"
+	"gvisor.dev/gvisor/pkg/tcpip/hash/jenkins"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// FQCoDelOptions are the options of an FQCoDel discipline. The zero values
+// select the defaults of Linux's fq_codel.
+type FQCoDelOptions struct {
+	// Flows is the number of flow queues. It defaults to 1024.
+	Flows int
+
+	// Limit is the maximum number of packets queued. It defaults to 10240.
+	Limit int
+
+	// Quantum is the number of bytes a flow queue can transmit before the
+	// next one's turn. It defaults to 1514.
+	Quantum int
+
+	// Target is the acceptable minimum queueing delay. It defaults to 5ms.
+	Target time.Duration
+
+	// Interval is the time over which the queueing delay must exceed
+	// Target before packets are dropped. It defaults to 100ms.
+	Interval time.Duration
+}
+
+// FQCoDel is a discipline hashing the packets into flow queues, scheduled by
+// deficit round robin and managed by CoDel, as per RFC 8290 and like Linux's
+// fq_codel.
+//
+// The packets are hashed by their transport layer hash, or their addresses,
+// transport protocol and ports if they don't have one. CoDel drops the packets
+// at the head of a flow queue whose packets stay queued for longer than
+// Target over Interval, at an increasing rate until they don't.
+type FQCoDel struct {
+	opts FQCoDelOptions
+	seed uint32
+
+	flows    []fqCoDelFlow
+	newFlows []*fqCoDelFlow
+	oldFlows []*fqCoDelFlow
+	len      int
+
+	// maxPacket is the size of the largest packet queued so far.
+	maxPacket int
+}
+
+var _ Discipline = (*FQCoDel)(nil)
+
+// fqCoDelList identifies the list of flow queues a flow queue is in.
+type fqCoDelList int
+
+const (
+	fqCoDelNone fqCoDelList = iota
+	fqCoDelNew
+	fqCoDelOld
+)
+
+// fqCoDelFlow is a flow queue of an FQCoDel discipline.
+type fqCoDelFlow struct {
+	q     []timedPacket
+	bytes int
+
+	// list is the list of flow queues the flow queue is in.
+	list    fqCoDelList
+	deficit int
+
+	// The state of CoDel for the flow queue, as per RFC 8289 section 5.
+	firstAboveTime int64
+	dropNext       int64
+	count          int
+	lastCount      int
+	dropping       bool
+}
+
+// timedPacket is a queued packet with the time at which it was queued.
+type timedPacket struct {
+	pkt        *stack.PacketBuffer
+	enqueuedAt int64
+}
+
+// NewFQCoDel returns a fair queueing controlled delay discipline.
+func NewFQCoDel(opts FQCoDelOptions) *FQCoDel {
+	if opts.Flows == 0 {
+		opts.Flows = 1024
+	}
+	if opts.Limit == 0 {
+		opts.Limit = 10240
+	}
+	if opts.Quantum == 0 {
+		opts.Quantum = 1514
+	}
+	if opts.Target == 0 {
+		opts.Target = 5 * time.Millisecond
+	}
+	if opts.Interval == 0 {
+		opts.Interval = 100 * time.Millisecond
+	}
+	var seed [4]byte
+	rand.Read(seed[:])
+	return &FQCoDel{
+		opts:  opts,
+		seed:  binary.LittleEndian.Uint32(seed[:]),
+		flows: make([]fqCoDelFlow, opts.Flows),
+	}
+}
+
+// Enqueue implements Discipline.Enqueue.
+func (q *FQCoDel) Enqueue(pkt *stack.PacketBuffer, now int64, dropped *stack.PacketBufferList) bool {
+	f := &q.flows[q.classify(pkt)]
+	size := pkt.Size()
+	f.q = append(f.q, timedPacket{pkt: pkt, enqueuedAt: now})
+	f.bytes += size
+	q.len++
+	if size > q.maxPacket {
+		q.maxPacket = size
+	}
+	if f.list == fqCoDelNone {
+		f.list = fqCoDelNew
+		f.deficit = q.opts.Quantum
+		q.newFlows = append(q.newFlows, f)
+	}
+
+	if q.len <= q.opts.Limit {
+		return true
+	}
+	// Drop the head packet of the flow queue with the largest backlog.
+	fattest := &q.flows[0]
+	for i := range q.flows {
+		if q.flows[i].bytes > fattest.bytes {
+			fattest = &q.flows[i]
+		}
+	}
+	head := q.pop(fattest)
+	if head == pkt {
+		return false
+	}
+	dropped.PushBack(head)
+	return true
+}
+
+// classify returns the index of the flow queue of pkt.
+func (q *FQCoDel) classify(pkt *stack.PacketBuffer) int {
+	h := pkt.Hash
+	if h == 0 {
+		h = q.flowHash(pkt)
+	}
+	return int(h % uint32(len(q.flows)))
+}
+
+// flowHash returns the hash of the flow of pkt, made of its addresses,
+// transport protocol and ports.
+func (q *FQCoDel) flowHash(pkt *stack.PacketBuffer) uint32 {
+	h := jenkins.Sum32(q.seed)
+	v := pkt.NetworkHeader().View()
+	switch pkt.NetworkProtocolNumber {
+	case header.IPv4ProtocolNumber:
+		if len(v) < header.IPv4MinimumSize {
+			return 0
+		}
+		ip := header.IPv4(v)
+		h.Write([]byte(ip.SourceAddress()))
+		h.Write([]byte(ip.DestinationAddress()))
+		h.Write([]byte{ip.Protocol()})
+	case header.IPv6ProtocolNumber:
+		if len(v) < header.IPv6MinimumSize {
+			return 0
+		}
+		ip := header.IPv6(v)
+		h.Write([]byte(ip.SourceAddress()))
+		h.Write([]byte(ip.DestinationAddress()))
+		h.Write([]byte{ip.NextHeader()})
+	default:
+		return 0
+	}
+	if t := pkt.TransportHeader().View(); len(t) >= 4 {
+		// The ports of TCP, UDP and SCTP.
+		h.Write(t[:4])
+	}
+	return h.Sum32()
+}
+
+// pop removes and returns the head packet of f, or nil if f is empty.
+func (q *FQCoDel) pop(f *fqCoDelFlow) *stack.PacketBuffer {
+	if len(f.q) == 0 {
+		return nil
+	}
+	pkt := f.q[0].pkt
+	f.q[0] = timedPacket{}
+	f.q = f.q[1:]
+	f.bytes -= pkt.Size()
+	q.len--
+	return pkt
+}
+
+// Dequeue implements Discipline.Dequeue.
+func (q *FQCoDel) Dequeue(now int64, dropped *stack.PacketBufferList) (*stack.PacketBuffer, int64) {
+	for {
+		var f *fqCoDelFlow
+		switch {
+		case len(q.newFlows) != 0:
+			f = q.newFlows[0]
+		case len(q.oldFlows) != 0:
+			f = q.oldFlows[0]
+		default:
+			return nil, 0
+		}
+
+		if f.deficit <= 0 {
+			f.deficit += q.opts.Quantum
+			q.removeHead(f)
+			f.list = fqCoDelOld
+			q.oldFlows = append(q.oldFlows, f)
+			continue
+		}
+
+		pkt := q.codelDequeue(f, now, dropped)
+		if pkt == nil {
+			// Empty new flow queues are moved to the old ones, so that
+			// they can't get priority by sending a packet at a time.
+			wasNew := f.list == fqCoDelNew
+			q.removeHead(f)
+			if wasNew && len(q.oldFlows) != 0 {
+				f.list = fqCoDelOld
+				q.oldFlows = append(q.oldFlows, f)
+			} else {
+				f.list = fqCoDelNone
+			}
+			continue
+		}
+		f.deficit -= pkt.Size()
+		return pkt, 0
+	}
+}
+
+// removeHead removes f from the head of its list of flow queues.
+func (q *FQCoDel) removeHead(f *fqCoDelFlow) {
+	switch f.list {
+	case fqCoDelNew:
+		q.newFlows[0] = nil
+		q.newFlows = q.newFlows[1:]
+	case fqCoDelOld:
+		q.oldFlows[0] = nil
+		q.oldFlows = q.oldFlows[1:]
+	}
+}
+
+// codelDequeue returns the next packet of f to transmit at now, as per RFC
+// 8289 section 5.5, or nil if f is empty. The packets dropped by CoDel are
+// appended to dropped.
+func (q *FQCoDel) codelDequeue(f *fqCoDelFlow, now int64, dropped *stack.PacketBufferList) *stack.PacketBuffer {
+	pkt, okToDrop := q.codelDoDequeue(f, now)
+	if pkt == nil {
+		f.dropping = false
+		return nil
+	}
+	interval := q.opts.Interval.Nanoseconds()
+	if f.dropping {
+		if !okToDrop {
+			f.dropping = false
+		}
+		for f.dropping && now >= f.dropNext {
+			dropped.PushBack(pkt)
+			f.count++
+			pkt, okToDrop = q.codelDoDequeue(f, now)
+			if !okToDrop {
+				f.dropping = false
+			} else {
+				f.dropNext = controlLaw(f.dropNext, interval, f.count)
+			}
+		}
+	} else if okToDrop {
+		dropped.PushBack(pkt)
+		pkt, _ = q.codelDoDequeue(f, now)
+		f.dropping = true
+		// If the flow queue was recently dropping, resume at the
+		// previous drop rate.
+		delta := f.count - f.lastCount
+		if delta > 1 && now-f.dropNext < 16*interval {
+			f.count = delta
+		} else {
+			f.count = 1
+		}
+		f.lastCount = f.count
+		f.dropNext = controlLaw(now, interval, f.count)
+	}
+	return pkt
+}
+
+// codelDoDequeue removes and returns the head packet of f, and whether its
+// queueing delay has exceeded the target for at least an interval.
+func (q *FQCoDel) codelDoDequeue(f *fqCoDelFlow, now int64) (*stack.PacketBuffer, bool) {
+	if len(f.q) == 0 {
+		f.firstAboveTime = 0
+		return nil, false
+	}
+	sojournTime := now - f.q[0].enqueuedAt
+	pkt := q.pop(f)
+	if sojournTime < q.opts.Target.Nanoseconds() || f.bytes <= q.maxPacket {
+		// The queue is below target, or holds less than a packet.
+		f.firstAboveTime = 0
+		return pkt, false
+	}
+	if f.firstAboveTime == 0 {
+		f.firstAboveTime = now + q.opts.Interval.Nanoseconds()
+		return pkt, false
+	}
+	return pkt, now >= f.firstAboveTime
+}
+
+// controlLaw returns the time of the next drop, count drops after t.
+func controlLaw(t, interval int64, count int) int64 {
+	return t + int64(float64(interval)/math.Sqrt(float64(count)))
+}
+
+// Len implements Discipline.Len.
+func (q *FQCoDel) Len() int {
+	return q.len
+}
+
+// Reset implements Discipline.Reset.
+func (q *FQCoDel) Reset(dropped *stack.PacketBufferList) {
+	for i := range q.flows {
+		f := &q.flows[i]
+		for pkt := q.pop(f); pkt != nil; pkt = q.pop(f) {
+			dropped.PushBack(pkt)
+		}
+		*f = fqCoDelFlow{}
+	}
+	q.newFlows = nil
+	q.oldFlows = nil
+}
@@ -0,0 +1,462 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package qdisc
+
+import (
+	"sort"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// htbPriorities is the number of priorities of HTB classes.
+const htbPriorities = 8
+
+// HTBOptions are the options of an HTB discipline.
+type HTBOptions struct {
+	// Classify returns the ID of the class of a packet. It may be nil, in
+	// which case all the packets are of DefaultClass.
+	Classify func(pkt *stack.PacketBuffer) uint32
+
+	// DefaultClass is the ID of the class of the packets which aren't
+	// classified in a leaf class. If it isn't a leaf class, the packets
+	// are transmitted without shaping, ahead of the shaped packets.
+	DefaultClass uint32
+
+	// DirectLimit is the maximum number of packets queued to be
+	// transmitted without shaping. It defaults to 1000.
+	DirectLimit int
+}
+
+// HTBClass is the configuration of a class of an HTB discipline.
+type HTBClass struct {
+	// Parent is the ID of the parent class, or 0 for the root classes.
+	Parent uint32
+
+	// Rate is the rate guaranteed to the class, in bytes per second.
+	Rate uint64
+
+	// Ceil is the maximum rate of the class, borrowing from its ancestors,
+	// in bytes per second. It defaults to Rate.
+	Ceil uint64
+
+	// Burst and CBurst are the number of bytes which can be transmitted at
+	// once at Rate and Ceil respectively. They default to a millisecond at
+	// the rate plus 1514 bytes.
+	Burst  uint32
+	CBurst uint32
+
+	// Priority is the priority of the class, from 0 to 7, lower priorities
+	// being served first. It only applies to leaf classes.
+	Priority int
+
+	// Quantum is the number of bytes a leaf class transmits before the
+	// next one of the same priority, when they borrow from the same
+	// ancestor. It defaults to a tenth of Rate, within [1000, 200000].
+	Quantum int
+
+	// Discipline is the discipline in which the packets of a leaf class
+	// are queued. It defaults to a PFIFO holding 1000 packets. Setting it
+	// again replaces the discipline of the class, dropping its packets.
+	Discipline Discipline
+}
+
+// HTB is a hierarchical token bucket discipline, which shapes the packets of
+// classes to a guaranteed rate each, and lets them borrow the unused rate of
+// their ancestors up to a maximum rate, like Linux's htb.
+//
+// Packets are queued in the leaf classes, with a discipline of their own. The
+// classes have a token bucket for each of their rates: a class can transmit
+// on its own when both buckets have tokens, and borrow from its ancestors when
+// only its ceil bucket has. Leaf classes transmitting on their own are served
+// first, then the ones borrowing from the nearest ancestor, and so on; by
+// priority and in deficit round robin at each level.
+//
+// The classes can be changed at any time.
+type HTB struct {
+	opts HTBOptions
+
+	mu      sync.Mutex
+	classes map[uint32]*htbClass
+
+	// leaves are the leaf classes, sorted by ID.
+	leaves []*htbClass
+
+	// maxDepth is the depth of the deepest class, root classes having a
+	// depth of 0.
+	maxDepth int
+
+	// rr is the index in leaves of the next leaf class to serve, for each
+	// priority.
+	rr [htbPriorities]int
+
+	// direct holds the packets transmitted without shaping.
+	direct    stack.PacketBufferList
+	directLen int
+
+	// purged holds the packets of the removed leaf classes, until they're
+	// dropped by the next call to Enqueue or Dequeue.
+	purged stack.PacketBufferList
+}
+
+var _ Discipline = (*HTB)(nil)
+
+// htbClass is a class of an HTB discipline.
+type htbClass struct {
+	id       uint32
+	config   HTBClass
+	parent   *htbClass
+	children int
+	depth    int
+
+	// q is the discipline of the class if it is a leaf, or nil.
+	q       Discipline
+	deficit int
+
+	// tokens and ctokens are the numbers of tokens in the buckets of Rate
+	// and Ceil at last, in nanoseconds of transmission. buffer and cbuffer
+	// are the sizes of the buckets.
+	tokens  int64
+	ctokens int64
+	buffer  int64
+	cbuffer int64
+	last    int64
+}
+
+// NewHTB returns a hierarchical token bucket discipline with no classes.
+func NewHTB(opts HTBOptions) *HTB {
+	if opts.DirectLimit == 0 {
+		opts.DirectLimit = 1000
+	}
+	return &HTB{
+		opts:    opts,
+		classes: make(map[uint32]*htbClass),
+	}
+}
+
+// SetClass creates the class id with config, or changes the configuration of
+// the existing class. The parent of an existing class can't be changed.
+//
+// A leaf class becoming the parent of a new class is no longer a leaf, and
+// its packets are dropped.
+func (h *HTB) SetClass(id uint32, config HTBClass) *tcpip.Error {
+	if id == 0 || config.Rate == 0 || config.Priority < 0 || config.Priority >= htbPriorities {
+		return tcpip.ErrInvalidOptionValue
+	}
+	if config.Ceil == 0 {
+		config.Ceil = config.Rate
+	}
+	if config.Ceil < config.Rate {
+		return tcpip.ErrInvalidOptionValue
+	}
+	if config.Burst == 0 {
+		config.Burst = uint32(config.Rate/1000) + 1514
+	}
+	if config.CBurst == 0 {
+		config.CBurst = uint32(config.Ceil/1000) + 1514
+	}
+	if config.Quantum == 0 {
+		config.Quantum = int(config.Rate / 10)
+		if config.Quantum < 1000 {
+			config.Quantum = 1000
+		} else if config.Quantum > 200000 {
+			config.Quantum = 200000
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c, ok := h.classes[id]
+	if ok {
+		if c.config.Parent != config.Parent {
+			return tcpip.ErrInvalidOptionValue
+		}
+	} else {
+		var parent *htbClass
+		if config.Parent != 0 {
+			parent, ok = h.classes[config.Parent]
+			if !ok {
+				return tcpip.ErrNoSuchFile
+			}
+		}
+		c = &htbClass{id: id, parent: parent}
+		if parent != nil {
+			c.depth = parent.depth + 1
+			if parent.q != nil {
+				parent.q.Reset(&h.purged)
+				parent.q = nil
+				parent.config.Discipline = nil
+			}
+			parent.children++
+		}
+		h.classes[id] = c
+	}
+
+	if config.Discipline != nil && config.Discipline != c.q && c.q != nil {
+		c.q.Reset(&h.purged)
+		c.q = nil
+	}
+	if c.q == nil && c.children == 0 {
+		c.q = config.Discipline
+		if c.q == nil {
+			c.q = NewPFIFO(1000)
+		}
+	}
+	config.Discipline = c.q
+	c.config = config
+	c.buffer = transmitTime(int(config.Burst), config.Rate)
+	c.cbuffer = transmitTime(int(config.CBurst), config.Ceil)
+	if !ok {
+		c.tokens = c.buffer
+		c.ctokens = c.cbuffer
+		c.deficit = config.Quantum
+	}
+	if c.tokens > c.buffer {
+		c.tokens = c.buffer
+	}
+	if c.ctokens > c.cbuffer {
+		c.ctokens = c.cbuffer
+	}
+	h.updateLocked()
+	return nil
+}
+
+// RemoveClass removes the class id, which must have no children, dropping its
+// packets. A parent class left without children becomes a leaf.
+func (h *HTB) RemoveClass(id uint32) *tcpip.Error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c, ok := h.classes[id]
+	if !ok {
+		return tcpip.ErrNoSuchFile
+	}
+	if c.children != 0 {
+		return tcpip.ErrInvalidOptionValue
+	}
+	c.q.Reset(&h.purged)
+	delete(h.classes, id)
+	if p := c.parent; p != nil {
+		p.children--
+		if p.children == 0 {
+			p.q = NewPFIFO(1000)
+			p.config.Discipline = p.q
+		}
+	}
+	h.updateLocked()
+	return nil
+}
+
+// Class returns the configuration of the class id.
+func (h *HTB) Class(id uint32) (HTBClass, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c, ok := h.classes[id]
+	if !ok {
+		return HTBClass{}, false
+	}
+	return c.config, true
+}
+
+// updateLocked updates the leaf classes and the maximum depth after the
+// classes changed.
+//
+// Precondition: h.mu must be held.
+func (h *HTB) updateLocked() {
+	h.leaves = h.leaves[:0]
+	h.maxDepth = 0
+	for _, c := range h.classes {
+		if c.q != nil {
+			h.leaves = append(h.leaves, c)
+		}
+		if c.depth > h.maxDepth {
+			h.maxDepth = c.depth
+		}
+	}
+	sort.Slice(h.leaves, func(i, j int) bool {
+		return h.leaves[i].id < h.leaves[j].id
+	})
+}
+
+// Enqueue implements Discipline.Enqueue.
+func (h *HTB) Enqueue(pkt *stack.PacketBuffer, now int64, dropped *stack.PacketBufferList) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	dropped.PushBackList(&h.purged)
+
+	var c *htbClass
+	if h.opts.Classify != nil {
+		c = h.classes[h.opts.Classify(pkt)]
+	}
+	if c == nil || c.q == nil {
+		c = h.classes[h.opts.DefaultClass]
+	}
+	if c == nil || c.q == nil {
+		if h.directLen >= h.opts.DirectLimit {
+			return false
+		}
+		h.direct.PushBack(pkt)
+		h.directLen++
+		return true
+	}
+	return c.q.Enqueue(pkt, now, dropped)
+}
+
+// Dequeue implements Discipline.Dequeue.
+func (h *HTB) Dequeue(now int64, dropped *stack.PacketBufferList) (*stack.PacketBuffer, int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	dropped.PushBackList(&h.purged)
+
+	if pkt := h.direct.Front(); pkt != nil {
+		h.direct.Remove(pkt)
+		h.directLen--
+		return pkt, 0
+	}
+
+	for _, c := range h.classes {
+		c.update(now)
+	}
+	var next int64
+	for level := 0; level <= h.maxDepth; level++ {
+		for prio := 0; prio < htbPriorities; prio++ {
+			pkt, at := h.dequeueLocked(level, prio, now, dropped)
+			if pkt != nil {
+				return pkt, 0
+			}
+			next = earliest(next, at)
+		}
+	}
+
+	// Wait until a class of a leaf class with packets gets tokens.
+	for _, l := range h.leaves {
+		if l.q.Len() == 0 {
+			continue
+		}
+		for c := l; c != nil; c = c.parent {
+			if c.tokens < 0 {
+				next = earliest(next, now-c.tokens)
+			}
+			if c.ctokens < 0 {
+				next = earliest(next, now-c.ctokens)
+			}
+		}
+	}
+	return nil, next
+}
+
+// dequeueLocked dequeues a packet of the leaf classes of priority prio which
+// can transmit by borrowing from their ancestor level levels up, or on their
+// own for level 0. If there is none, it returns the earliest time at which
+// one of their disciplines can transmit, or 0.
+//
+// Precondition: h.mu must be held.
+func (h *HTB) dequeueLocked(level, prio int, now int64, dropped *stack.PacketBufferList) (*stack.PacketBuffer, int64) {
+	var next int64
+	for i := range h.leaves {
+		idx := (h.rr[prio] + i) % len(h.leaves)
+		l := h.leaves[idx]
+		if l.config.Priority != prio || l.q.Len() == 0 || !l.canTransmit(level) {
+			continue
+		}
+		pkt, at := l.q.Dequeue(now, dropped)
+		if pkt == nil {
+			next = earliest(next, at)
+			continue
+		}
+
+		// Serve the leaf class until it exhausts its quantum.
+		size := pkt.Size()
+		l.deficit -= size
+		h.rr[prio] = idx
+		if l.deficit < 0 {
+			l.deficit += l.config.Quantum
+			h.rr[prio] = idx + 1
+		}
+
+		// The classes borrowing from the ancestor don't use their own
+		// tokens.
+		for d, c := 0, l; c != nil; d, c = d+1, c.parent {
+			if d >= level {
+				c.tokens -= transmitTime(size, c.config.Rate)
+			}
+			c.ctokens -= transmitTime(size, c.config.Ceil)
+		}
+		return pkt, 0
+	}
+	return nil, next
+}
+
+// update adds the tokens earned since last to the buckets of c.
+func (c *htbClass) update(now int64) {
+	diff := now - c.last
+	c.last = now
+	c.tokens += diff
+	if c.tokens > c.buffer {
+		c.tokens = c.buffer
+	}
+	c.ctokens += diff
+	if c.ctokens > c.cbuffer {
+		c.ctokens = c.cbuffer
+	}
+}
+
+// canTransmit returns whether the leaf class c can transmit by borrowing from
+// its ancestor level levels up, or on its own for level 0.
+func (c *htbClass) canTransmit(level int) bool {
+	for ; level > 0; level-- {
+		if c.ctokens < 0 {
+			return false
+		}
+		c = c.parent
+		if c == nil {
+			return false
+		}
+	}
+	return c.tokens >= 0 && c.ctokens >= 0
+}
+
+// earliest returns the earliest of the times a and b, which are ignored if
+// they're 0.
+func earliest(a, b int64) int64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// Len implements Discipline.Len.
+func (h *HTB) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := h.directLen
+	for _, l := range h.leaves {
+		n += l.q.Len()
+	}
+	return n
+}
+
+// Reset implements Discipline.Reset.
+func (h *HTB) Reset(dropped *stack.PacketBufferList) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	dropped.PushBackList(&h.purged)
+	dropped.PushBackList(&h.direct)
+	h.directLen = 0
+	for _, l := range h.leaves {
+		l.q.Reset(dropped)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qdisc
+
+import (
+	"container/heap"
+	"encoding/binary"
+	mathrand "math/rand"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/rand"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// NetemOptions are the options of a Netem discipline. The probabilities are
+// between 0 and 1.
+type NetemOptions struct {
+	// Delay is the time packets are held before being transmitted.
+	Delay time.Duration
+
+	// Jitter is the maximum random variation of the delay of each packet,
+	// uniformly distributed in [-Jitter, Jitter]. Packets are transmitted
+	// in the order of their delay, so a jitter may reorder them.
+	Jitter time.Duration
+
+	// Loss is the probability that a packet is dropped.
+	Loss float64
+
+	// Duplicate is the probability that a packet is transmitted twice. The
+	// duplicate is delayed independently of the original.
+	Duplicate float64
+
+	// Reorder is the probability that a packet is transmitted without delay,
+	// ahead of the delayed packets.
+	Reorder float64
+
+	// Limit is the maximum number of packets queued. It defaults to 1000.
+	Limit int
+
+	// Rand is the source of randomness of the discipline. If nil, a source
+	// with a random seed is used.
+	Rand *mathrand.Rand
+}
+
+// Netem is a discipline impairing the packets with delay, jitter, loss,
+// duplication and reordering to emulate bad networks, like Linux's netem.
+//
+// Lost packets are dropped silently: the senders aren't told about it, as
+// with losses on the network.
+type Netem struct {
+	opts NetemOptions
+
+	q      delayedPacketHeap
+	nextID uint64
+}
+
+var _ Discipline = (*Netem)(nil)
+
+// NewNetem returns a network emulation discipline.
+func NewNetem(opts NetemOptions) *Netem {
+	if opts.Limit == 0 {
+		opts.Limit = 1000
+	}
+	if opts.Rand == nil {
+		var seed [8]byte
+		rand.Read(seed[:])
+		opts.Rand = mathrand.New(mathrand.NewSource(int64(binary.LittleEndian.Uint64(seed[:]))))
+	}
+	return &Netem{opts: opts}
+}
+
+// Enqueue implements Discipline.Enqueue.
+func (q *Netem) Enqueue(pkt *stack.PacketBuffer, now int64, dropped *stack.PacketBufferList) bool {
+	if q.q.Len() >= q.opts.Limit {
+		return false
+	}
+	if q.chance(q.opts.Loss) {
+		dropped.PushBack(pkt)
+		return true
+	}
+	if q.chance(q.opts.Duplicate) && q.q.Len()+1 < q.opts.Limit {
+		dup := pkt.Clone()
+		if pkt.EgressRoute != nil {
+			dup.EgressRoute = pkt.EgressRoute.Clone()
+		}
+		q.push(dup, q.sendTime(now))
+	}
+	q.push(pkt, q.sendTime(now))
+	return true
+}
+
+// Dequeue implements Discipline.Dequeue.
+func (q *Netem) Dequeue(now int64, _ *stack.PacketBufferList) (*stack.PacketBuffer, int64) {
+	if q.q.Len() == 0 {
+		return nil, 0
+	}
+	if at := q.q[0].at; at > now {
+		return nil, at
+	}
+	return heap.Pop(&q.q).(delayedPacket).pkt, 0
+}
+
+// Len implements Discipline.Len.
+func (q *Netem) Len() int {
+	return q.q.Len()
+}
+
+// Reset implements Discipline.Reset.
+func (q *Netem) Reset(dropped *stack.PacketBufferList) {
+	for q.q.Len() > 0 {
+		dropped.PushBack(heap.Pop(&q.q).(delayedPacket).pkt)
+	}
+}
+
+// chance returns true with probability p.
+func (q *Netem) chance(p float64) bool {
+	return p > 0 && q.opts.Rand.Float64() < p
+}
+
+// sendTime returns the time at which a packet queued at now is transmitted.
+func (q *Netem) sendTime(now int64) int64 {
+	if q.chance(q.opts.Reorder) {
+		return now
+	}
+	delay := int64(q.opts.Delay)
+	if jitter := int64(q.opts.Jitter); jitter > 0 {
+		delay += q.opts.Rand.Int63n(2*jitter+1) - jitter
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return now + delay
+}
+
+// push queues pkt to be transmitted at time at.
+func (q *Netem) push(pkt *stack.PacketBuffer, at int64) {
+	heap.Push(&q.q, delayedPacket{pkt: pkt, at: at, id: q.nextID})
+	q.nextID++
+}
+
+// delayedPacket is a packet held until the time at which it is transmitted.
+type delayedPacket struct {
+	pkt *stack.PacketBuffer
+	at  int64
+
+	// id orders the packets with the same transmit time by arrival.
+	id uint64
+}
+
+// delayedPacketHeap is a min-heap of packets ordered by transmit time.
+type delayedPacketHeap []delayedPacket
+
+// Len implements heap.Interface.Len.
+func (h delayedPacketHeap) Len() int {
+	return len(h)
+}
+
+// Less implements heap.Interface.Less.
+func (h delayedPacketHeap) Less(i, j int) bool {
+	if h[i].at != h[j].at {
+		return h[i].at < h[j].at
+	}
+	return h[i].id < h[j].id
+}
+
+// Swap implements heap.Interface.Swap.
+func (h delayedPacketHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+// Push implements heap.Interface.Push.
+func (h *delayedPacketHeap) Push(x interface{}) {
+	*h = append(*h, x.(delayedPacket))
+}
+
+// Pop implements heap.Interface.Pop.
+func (h *delayedPacketHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = delayedPacket{}
+	*h = old[:n-1]
+	return x
+}
@@ -0,0 +1,64 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package qdisc
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// PFIFO is a discipline holding packets in a single bounded FIFO queue, like
+// Linux's pfifo.
+type PFIFO struct {
+	q     stack.PacketBufferList
+	len   int
+	limit int
+}
+
+var _ Discipline = (*PFIFO)(nil)
+
+// NewPFIFO returns a FIFO discipline holding at most limit packets.
+func NewPFIFO(limit int) *PFIFO {
+	return &PFIFO{limit: limit}
+}
+
+// Enqueue implements Discipline.Enqueue.
+func (q *PFIFO) Enqueue(pkt *stack.PacketBuffer, _ int64, _ *stack.PacketBufferList) bool {
+	if q.len >= q.limit {
+		return false
+	}
+	q.q.PushBack(pkt)
+	q.len++
+	return true
+}
+
+// Dequeue implements Discipline.Dequeue.
+func (q *PFIFO) Dequeue(int64, *stack.PacketBufferList) (*stack.PacketBuffer, int64) {
+	pkt := q.q.Front()
+	if pkt != nil {
+		q.q.Remove(pkt)
+		q.len--
+	}
+	return pkt, 0
+}
+
+// Len implements Discipline.Len.
+func (q *PFIFO) Len() int {
+	return q.len
+}
+
+// Reset implements Discipline.Reset.
+func (q *PFIFO) Reset(dropped *stack.PacketBufferList) {
+	dropped.PushBackList(&q.q)
+	q.len = 0
+}
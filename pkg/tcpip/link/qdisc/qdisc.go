@@ -0,0 +1,401 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package qdisc provides the implementation of data-link layer endpoints that
+// wrap another endpoint and queue outbound packets in a pluggable queueing
+// discipline, which decides when and in which order they are written to the
+// lower endpoint.
+//
+// The disciplines provided are PFIFO, FQCoDel, TBF, HTB and Netem, after their
+// Linux counterparts. The discipline of an endpoint can be replaced at runtime.
+package qdisc
+
+import (
+	"reflect"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sleep"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// Discipline is a queueing discipline, which holds outbound packets until
+// they're transmitted.
+//
+// Times are in nanoseconds of the stack's monotonic clock. Disciplines are
+// only called by one goroutine at a time.
+type Discipline interface {
+	// Enqueue queues pkt at time now. It returns false if pkt is dropped,
+	// in which case its ownership is retained by the caller. The packets
+	// dropped to make room for pkt are appended to dropped, as is pkt if
+	// the discipline drops it silently.
+	Enqueue(pkt *stack.PacketBuffer, now int64, dropped *stack.PacketBufferList) bool
+
+	// Dequeue removes and returns the next packet to transmit at time now.
+	// If no packet can be transmitted, it returns nil and the time at
+	// which one can, or 0 if no packet is queued. The packets dropped
+	// instead of being transmitted are appended to dropped.
+	Dequeue(now int64, dropped *stack.PacketBufferList) (*stack.PacketBuffer, int64)
+
+	// Len returns the number of queued packets.
+	Len() int
+
+	// Reset removes all the queued packets and appends them to dropped.
+	Reset(dropped *stack.PacketBufferList)
+}
+
+// Stats are the counters of an Endpoint.
+type Stats struct {
+	// Enqueued is the number of packets queued by the discipline.
+	Enqueued *tcpip.StatCounter
+
+	// Sent is the number of packets written to the lower endpoint.
+	Sent *tcpip.StatCounter
+
+	// Dropped is the number of packets dropped by the discipline, either
+	// when they were written or once queued.
+	Dropped *tcpip.StatCounter
+}
+
+// Endpoint is a LinkEndpoint which queues all the outgoing packets in a
+// discipline, and writes them to the lower endpoint asynchronously.
+type Endpoint struct {
+	dispatcher stack.NetworkDispatcher
+	lower      stack.LinkEndpoint
+	clock      tcpip.Clock
+	stats      Stats
+
+	newPacketWaker sleep.Waker
+	closeWaker     sleep.Waker
+	wg             sync.WaitGroup
+
+	mu         sync.Mutex
+	discipline Discipline
+	closed     bool
+
+	// timer if not nil expires at timerAt, when the discipline can
+	// transmit a packet.
+	timer   tcpip.Timer
+	timerAt int64
+}
+
+var _ stack.LinkEndpoint = (*Endpoint)(nil)
+var _ stack.GSOEndpoint = (*Endpoint)(nil)
+var _ stack.CarrierDispatcher = (*Endpoint)(nil)
+var _ stack.CarrierReporter = (*Endpoint)(nil)
+
+// New creates a new endpoint queueing the packets written to lower in d,
+// according to clock, which must be the clock of the stack.
+func New(lower stack.LinkEndpoint, clock tcpip.Clock, d Discipline) *Endpoint {
+	e := &Endpoint{
+		lower:      lower,
+		clock:      clock,
+		discipline: d,
+	}
+	tcpip.InitStatCounters(reflect.ValueOf(&e.stats).Elem())
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.dispatchLoop()
+	}()
+	return e
+}
+
+// Stats returns the counters of the endpoint.
+func (e *Endpoint) Stats() Stats {
+	return e.stats
+}
+
+// Discipline returns the discipline of the endpoint.
+func (e *Endpoint) Discipline() Discipline {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.discipline
+}
+
+// SetDiscipline replaces the discipline of the endpoint with d. The packets
+// queued in the previous discipline are queued in d, unless d drops them.
+func (e *Endpoint) SetDiscipline(d Discipline) {
+	var pkts, dropped stack.PacketBufferList
+	e.mu.Lock()
+	if !e.closed {
+		e.discipline.Reset(&pkts)
+	}
+	e.discipline = d
+	now := e.clock.NowMonotonic()
+	for pkt := pkts.Front(); pkt != nil; {
+		nxt := pkt.Next()
+		pkts.Remove(pkt)
+		if !d.Enqueue(pkt, now, &dropped) {
+			dropped.PushBack(pkt)
+		}
+		pkt = nxt
+	}
+	e.mu.Unlock()
+
+	e.drop(&dropped)
+	e.newPacketWaker.Assert()
+}
+
+func (e *Endpoint) dispatchLoop() {
+	const newPacketWakerID = 1
+	const closeWakerID = 2
+	s := sleep.Sleeper{}
+	s.AddWaker(&e.newPacketWaker, newPacketWakerID)
+	s.AddWaker(&e.closeWaker, closeWakerID)
+	defer s.Done()
+
+	const batchSize = 32
+	for {
+		id, ok := s.Fetch(true)
+		if ok && id == closeWakerID {
+			return
+		}
+		for {
+			var batch, dropped stack.PacketBufferList
+			e.mu.Lock()
+			now := e.clock.NowMonotonic()
+			for n := 0; n < batchSize; n++ {
+				pkt, next := e.discipline.Dequeue(now, &dropped)
+				if pkt == nil {
+					if next != 0 {
+						e.scheduleLocked(now, next)
+					}
+					break
+				}
+				batch.PushBack(pkt)
+			}
+			e.mu.Unlock()
+
+			e.drop(&dropped)
+			if batch.Empty() {
+				break
+			}
+			e.stats.Sent.IncrementBy(uint64(batch.Len()))
+			// We pass a protocol of zero here because each packet carries its
+			// NetworkProtocol.
+			e.lower.WritePackets(nil /* route */, nil /* gso */, batch, 0 /* protocol */)
+			for pkt := batch.Front(); pkt != nil; {
+				nxt := pkt.Next()
+				pkt.EgressRoute.Release()
+				batch.Remove(pkt)
+				pkt.TransmitDone()
+				pkt = nxt
+			}
+		}
+	}
+}
+
+// scheduleLocked arms the timer to wake up the dispatch loop at time at.
+//
+// Precondition: e.mu must be held.
+func (e *Endpoint) scheduleLocked(now, at int64) {
+	if e.closed {
+		return
+	}
+	if e.timer != nil {
+		if e.timerAt <= at {
+			return
+		}
+		e.timer.Stop()
+	}
+	var t tcpip.Timer
+	t = e.clock.AfterFunc(time.Duration(at-now), func() {
+		e.mu.Lock()
+		if e.timer == t {
+			e.timer = nil
+		}
+		e.mu.Unlock()
+		e.newPacketWaker.Assert()
+	})
+	e.timer = t
+	e.timerAt = at
+}
+
+// drop releases the packets dropped by the discipline.
+func (e *Endpoint) drop(dropped *stack.PacketBufferList) {
+	for pkt := dropped.Front(); pkt != nil; {
+		nxt := pkt.Next()
+		dropped.Remove(pkt)
+		e.stats.Dropped.Increment()
+		pkt.EgressRoute.Release()
+		pkt.TransmitDone()
+		pkt = nxt
+	}
+}
+
+// enqueue queues pkt in the discipline. It returns false if pkt is dropped,
+// in which case its ownership is retained by the caller.
+func (e *Endpoint) enqueue(pkt *stack.PacketBuffer) bool {
+	// Since qdisc can hold onto a packet for long we should Clone the route
+	// here to ensure it doesn't get released while the packet is still in
+	// our queue.
+	r := pkt.EgressRoute
+	pkt.EgressRoute = r.Clone()
+	pkt.DeferTransmitDone()
+
+	var dropped stack.PacketBufferList
+	e.mu.Lock()
+	ok := !e.closed && e.discipline.Enqueue(pkt, e.clock.NowMonotonic(), &dropped)
+	e.mu.Unlock()
+
+	e.drop(&dropped)
+	if !ok {
+		pkt.EgressRoute.Release()
+		pkt.EgressRoute = r
+		pkt.TransmitDone()
+		e.stats.Dropped.Increment()
+		return false
+	}
+	e.stats.Enqueued.Increment()
+	e.newPacketWaker.Assert()
+	return true
+}
+
+// DeliverNetworkPacket implements stack.NetworkDispatcher.DeliverNetworkPacket.
+func (e *Endpoint) DeliverNetworkPacket(remote, local tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	e.dispatcher.DeliverNetworkPacket(remote, local, protocol, pkt)
+}
+
+// DeliverOutboundPacket implements stack.NetworkDispatcher.DeliverOutboundPacket.
+func (e *Endpoint) DeliverOutboundPacket(remote, local tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	e.dispatcher.DeliverOutboundPacket(remote, local, protocol, pkt)
+}
+
+// DeliverCarrierChange implements stack.CarrierDispatcher.DeliverCarrierChange.
+func (e *Endpoint) DeliverCarrierChange(up bool) {
+	if d, ok := e.dispatcher.(stack.CarrierDispatcher); ok {
+		d.DeliverCarrierChange(up)
+	}
+}
+
+// Attach implements stack.LinkEndpoint.Attach.
+func (e *Endpoint) Attach(dispatcher stack.NetworkDispatcher) {
+	e.dispatcher = dispatcher
+	e.lower.Attach(e)
+}
+
+// IsAttached implements stack.LinkEndpoint.IsAttached.
+func (e *Endpoint) IsAttached() bool {
+	return e.dispatcher != nil
+}
+
+// MTU implements stack.LinkEndpoint.MTU.
+func (e *Endpoint) MTU() uint32 {
+	return e.lower.MTU()
+}
+
+// Capabilities implements stack.LinkEndpoint.Capabilities.
+func (e *Endpoint) Capabilities() stack.LinkEndpointCapabilities {
+	// Queued packets are written to the lower endpoint asynchronously.
+	return e.lower.Capabilities() &^ stack.CapabilityZeroCopyTX
+}
+
+// MaxHeaderLength implements stack.LinkEndpoint.MaxHeaderLength.
+func (e *Endpoint) MaxHeaderLength() uint16 {
+	return e.lower.MaxHeaderLength()
+}
+
+// LinkAddress implements stack.LinkEndpoint.LinkAddress.
+func (e *Endpoint) LinkAddress() tcpip.LinkAddress {
+	return e.lower.LinkAddress()
+}
+
+// Carrier implements stack.CarrierReporter.Carrier.
+func (e *Endpoint) Carrier() bool {
+	if c, ok := e.lower.(stack.CarrierReporter); ok {
+		return c.Carrier()
+	}
+	return true
+}
+
+// GSOMaxSize returns the maximum GSO packet size.
+func (e *Endpoint) GSOMaxSize() uint32 {
+	if gso, ok := e.lower.(stack.GSOEndpoint); ok {
+		return gso.GSOMaxSize()
+	}
+	return 0
+}
+
+// WritePacket implements stack.LinkEndpoint.WritePacket.
+func (e *Endpoint) WritePacket(r *stack.Route, gso *stack.GSO, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) *tcpip.Error {
+	// WritePacket caller's do not set the following fields in PacketBuffer
+	// so we populate them here.
+	pkt.EgressRoute = r
+	pkt.GSOOptions = gso
+	pkt.NetworkProtocolNumber = protocol
+	if !e.enqueue(pkt) {
+		return tcpip.ErrNoBufferSpace
+	}
+	return nil
+}
+
+// WritePackets implements stack.LinkEndpoint.WritePackets.
+//
+// Being a batch API, each packet in pkts should have the following fields
+// populated:
+//   - pkt.EgressRoute
+//   - pkt.GSOOptions
+//   - pkt.NetworkProtocolNumber
+func (e *Endpoint) WritePackets(_ *stack.Route, _ *stack.GSO, pkts stack.PacketBufferList, _ tcpip.NetworkProtocolNumber) (int, *tcpip.Error) {
+	enqueued := 0
+	for pkt := pkts.Front(); pkt != nil; {
+		nxt := pkt.Next()
+		if !e.enqueue(pkt) {
+			return enqueued, tcpip.ErrNoBufferSpace
+		}
+		pkt = nxt
+		enqueued++
+	}
+	return enqueued, nil
+}
+
+// Wait implements stack.LinkEndpoint.Wait.
+func (e *Endpoint) Wait() {
+	e.lower.Wait()
+
+	// The linkEP is gone. Teardown the outbound dispatcher goroutine and
+	// drop the packets still queued.
+	e.closeWaker.Assert()
+	e.wg.Wait()
+
+	var dropped stack.PacketBufferList
+	e.mu.Lock()
+	e.closed = true
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+	e.discipline.Reset(&dropped)
+	e.mu.Unlock()
+	e.drop(&dropped)
+}
+
+// ARPHardwareType implements stack.LinkEndpoint.ARPHardwareType
+func (e *Endpoint) ARPHardwareType() header.ARPHardwareType {
+	return e.lower.ARPHardwareType()
+}
+
+// AddHeader implements stack.LinkEndpoint.AddHeader.
+func (e *Endpoint) AddHeader(local, remote tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	e.lower.AddHeader(local, remote, protocol, pkt)
+}
+
+// transmitTime returns the time it takes to transmit size bytes at rate bytes
+// per second, in nanoseconds.
+func transmitTime(size int, rate uint64) int64 {
+	return int64(uint64(size) * uint64(time.Second) / rate)
+}
@@ -0,0 +1,437 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package qdisc
+
+import (
+	"context"
+	"encoding/binary"
+	mathrand "math/rand"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	nicID      = 1
+	localAddr  = tcpip.Address("\x0a\x00\x00\x01")
+	remoteAddr = tcpip.Address("\x0a\x00\x00\x02")
+)
+
+// newPacket returns a packet of size bytes, whose first byte is id.
+func newPacket(id byte, size int, hash uint32) *stack.PacketBuffer {
+	v := buffer.NewView(size)
+	v[0] = id
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: v.ToVectorisedView(),
+	})
+	pkt.Hash = hash
+	return pkt
+}
+
+// ids returns the IDs of the packets of l.
+func ids(l *stack.PacketBufferList) string {
+	var b []byte
+	for pkt := l.Front(); pkt != nil; pkt = pkt.Next() {
+		b = append(b, pkt.Data.ToView()[0])
+	}
+	return string(b)
+}
+
+// dequeueAll dequeues the packets d transmits at now, and returns their IDs.
+func dequeueAll(d Discipline, now int64, dropped *stack.PacketBufferList) string {
+	var l stack.PacketBufferList
+	for {
+		pkt, _ := d.Dequeue(now, dropped)
+		if pkt == nil {
+			return ids(&l)
+		}
+		l.PushBack(pkt)
+	}
+}
+
+func TestPFIFO(t *testing.T) {
+	q := NewPFIFO(2)
+	var dropped stack.PacketBufferList
+	for i, want := range []bool{true, true, false} {
+		if got := q.Enqueue(newPacket(byte(i), 10, 0), 0, &dropped); got != want {
+			t.Errorf("got q.Enqueue(packet %d) = %t, want = %t", i, got, want)
+		}
+	}
+	if got, want := q.Len(), 2; got != want {
+		t.Errorf("got q.Len() = %d, want = %d", got, want)
+	}
+	if got, want := dequeueAll(q, 0, &dropped), "\x00\x01"; got != want {
+		t.Errorf("got dequeued packets = %q, want = %q", got, want)
+	}
+	if !dropped.Empty() {
+		t.Errorf("got dropped packets = %q, want none", ids(&dropped))
+	}
+}
+
+func TestTBF(t *testing.T) {
+	// The bucket holds 200 bytes, filled at 1000 bytes per second.
+	q := NewTBF(TBFOptions{Rate: 1000, Burst: 200, Limit: 500})
+	var dropped stack.PacketBufferList
+	for i := 0; i < 6; i++ {
+		want := i < 5
+		if got := q.Enqueue(newPacket(byte(i), 100, 0), 0, &dropped); got != want {
+			t.Errorf("got q.Enqueue(packet %d) = %t, want = %t", i, got, want)
+		}
+	}
+
+	// The burst is transmitted at once, and the bucket runs into debt with
+	// the last packet.
+	now := int64(time.Hour)
+	if got, want := dequeueAll(q, now, &dropped), "\x00\x01\x02"; got != want {
+		t.Fatalf("got dequeued packets = %q, want = %q", got, want)
+	}
+	pkt, next := q.Dequeue(now, &dropped)
+	if pkt != nil || next != now+int64(100*time.Millisecond) {
+		t.Fatalf("got q.Dequeue(%d, _) = (%v, %d), want = (nil, %d)", now, pkt, next, now+int64(100*time.Millisecond))
+	}
+	if got, want := dequeueAll(q, next, &dropped), "\x03"; got != want {
+		t.Errorf("got dequeued packets = %q, want = %q", got, want)
+	}
+	if got, want := dequeueAll(q, next+int64(100*time.Millisecond), &dropped), "\x04"; got != want {
+		t.Errorf("got dequeued packets = %q, want = %q", got, want)
+	}
+}
+
+func TestFQCoDelFairness(t *testing.T) {
+	q := NewFQCoDel(FQCoDelOptions{Flows: 16})
+	var dropped stack.PacketBufferList
+	for i := 0; i < 4; i++ {
+		q.Enqueue(newPacket('a', 1000, 1), 0, &dropped)
+	}
+	q.Enqueue(newPacket('b', 1000, 2), 0, &dropped)
+
+	// Each flow queue transmits its quantum in turn.
+	if got, want := dequeueAll(q, 0, &dropped), "aabaa"; got != want {
+		t.Errorf("got dequeued packets = %q, want = %q", got, want)
+	}
+	if !dropped.Empty() {
+		t.Errorf("got dropped packets = %q, want none", ids(&dropped))
+	}
+}
+
+func TestFQCoDelLimit(t *testing.T) {
+	q := NewFQCoDel(FQCoDelOptions{Flows: 16, Limit: 3})
+	var dropped stack.PacketBufferList
+	q.Enqueue(newPacket(1, 100, 1), 0, &dropped)
+	q.Enqueue(newPacket(2, 100, 1), 0, &dropped)
+	q.Enqueue(newPacket(3, 100, 2), 0, &dropped)
+	// The head of the largest flow queue is dropped.
+	if !q.Enqueue(newPacket(4, 100, 2), 0, &dropped) {
+		t.Fatal("got q.Enqueue(packet 4) = false, want = true")
+	}
+	if got, want := ids(&dropped), "\x01"; got != want {
+		t.Errorf("got dropped packets = %q, want = %q", got, want)
+	}
+	if got, want := q.Len(), 3; got != want {
+		t.Errorf("got q.Len() = %d, want = %d", got, want)
+	}
+}
+
+func TestFQCoDelDrops(t *testing.T) {
+	q := NewFQCoDel(FQCoDelOptions{})
+	var dropped stack.PacketBufferList
+	for i := 0; i < 10; i++ {
+		q.Enqueue(newPacket(byte(i), 1000, 1), 0, &dropped)
+	}
+
+	// The queueing delay exceeds the target, which starts the interval.
+	now := int64(200 * time.Millisecond)
+	if pkt, _ := q.Dequeue(now, &dropped); pkt == nil || pkt.Data.ToView()[0] != 0 {
+		t.Fatalf("got q.Dequeue(%d, _) = %v, want packet 0", now, pkt)
+	}
+	// Once the interval has passed, the head packet is dropped.
+	now += int64(100 * time.Millisecond)
+	if pkt, _ := q.Dequeue(now, &dropped); pkt == nil || pkt.Data.ToView()[0] != 2 {
+		t.Fatalf("got q.Dequeue(%d, _) = %v, want packet 2", now, pkt)
+	}
+	if got, want := ids(&dropped), "\x01"; got != want {
+		t.Errorf("got dropped packets = %q, want = %q", got, want)
+	}
+	// The next drop happens an interval later.
+	if pkt, _ := q.Dequeue(now+1, &dropped); pkt == nil || pkt.Data.ToView()[0] != 3 {
+		t.Fatalf("got q.Dequeue(%d, _) = %v, want packet 3", now+1, pkt)
+	}
+	now += int64(100 * time.Millisecond)
+	if pkt, _ := q.Dequeue(now, &dropped); pkt == nil || pkt.Data.ToView()[0] != 5 {
+		t.Fatalf("got q.Dequeue(%d, _) = %v, want packet 5", now, pkt)
+	}
+	if got, want := ids(&dropped), "\x01\x04"; got != want {
+		t.Errorf("got dropped packets = %q, want = %q", got, want)
+	}
+}
+
+func TestHTB(t *testing.T) {
+	q := NewHTB(HTBOptions{
+		Classify: func(pkt *stack.PacketBuffer) uint32 {
+			return uint32(pkt.Data.ToView()[0])
+		},
+	})
+	for _, c := range []struct {
+		id     uint32
+		config HTBClass
+	}{
+		{id: 1, config: HTBClass{Rate: 1000, Burst: 100, CBurst: 100, Quantum: 100}},
+		{id: 2, config: HTBClass{Parent: 1, Rate: 200, Ceil: 1000, Burst: 100, CBurst: 100, Quantum: 100}},
+		{id: 3, config: HTBClass{Parent: 1, Rate: 200, Ceil: 400, Burst: 100, CBurst: 100, Quantum: 100}},
+	} {
+		if err := q.SetClass(c.id, c.config); err != nil {
+			t.Fatalf("q.SetClass(%d, %+v): %s", c.id, c.config, err)
+		}
+	}
+	if err := q.SetClass(4, HTBClass{Parent: 5, Rate: 1}); err != tcpip.ErrNoSuchFile {
+		t.Errorf("got q.SetClass(4, _) with an unknown parent = %v, want = %s", err, tcpip.ErrNoSuchFile)
+	}
+	if err := q.RemoveClass(1); err != tcpip.ErrInvalidOptionValue {
+		t.Errorf("got q.RemoveClass(1) with children = %v, want = %s", err, tcpip.ErrInvalidOptionValue)
+	}
+
+	var dropped stack.PacketBufferList
+	for i := 0; i < 100; i++ {
+		for _, id := range []byte{2, 3} {
+			if !q.Enqueue(newPacket(id, 100, 0), 0, &dropped) {
+				t.Fatalf("q.Enqueue(_) of class %d failed", id)
+			}
+		}
+	}
+	// Unclassified packets aren't shaped.
+	if !q.Enqueue(newPacket(0, 100, 0), 0, &dropped) {
+		t.Fatal("q.Enqueue(_) of an unclassified packet failed")
+	}
+
+	// Class 3 transmits up to its ceil, and class 2 borrows the rest of the
+	// rate of class 1.
+	bytes := make(map[byte]int)
+	start := int64(time.Hour)
+	end := start + int64(10*time.Second)
+	for now := start; now < end; {
+		pkt, next := q.Dequeue(now, &dropped)
+		if pkt == nil {
+			if next <= now {
+				t.Fatalf("got q.Dequeue(%d, _) = (nil, %d), want a later time", now, next)
+			}
+			now = next
+			continue
+		}
+		bytes[pkt.Data.ToView()[0]] += pkt.Size()
+	}
+	if got, want := bytes[0], 100; got != want {
+		t.Errorf("got %d bytes of unclassified packets, want = %d", got, want)
+	}
+	for _, c := range []struct {
+		id   byte
+		rate int
+	}{
+		{id: 2, rate: 600},
+		{id: 3, rate: 400},
+	} {
+		// Allow for the bursts.
+		if got, want := bytes[c.id], 10*c.rate; got < want-400 || got > want+400 {
+			t.Errorf("got %d bytes of class %d in 10s, want about %d", got, c.id, want)
+		}
+	}
+	if !dropped.Empty() {
+		t.Errorf("got %d dropped packets, want none", dropped.Len())
+	}
+
+	// Removing a class drops its packets.
+	if err := q.RemoveClass(3); err != nil {
+		t.Fatalf("q.RemoveClass(3): %s", err)
+	}
+	q.Dequeue(end, &dropped)
+	if got, want := dropped.Len(), 100-bytes[3]/100; got != want {
+		t.Errorf("got %d dropped packets after removing class 3, want = %d", got, want)
+	}
+}
+
+// batchEndpoint is a channel endpoint writing batches of packets carrying
+// their route, as qdisc endpoints do.
+type batchEndpoint struct {
+	*channel.Endpoint
+}
+
+// WritePackets implements stack.LinkEndpoint.WritePackets.
+func (e batchEndpoint) WritePackets(_ *stack.Route, _ *stack.GSO, pkts stack.PacketBufferList, _ tcpip.NetworkProtocolNumber) (int, *tcpip.Error) {
+	n := 0
+	for pkt := pkts.Front(); pkt != nil; pkt = pkt.Next() {
+		if err := e.WritePacket(pkt.EgressRoute, pkt.GSOOptions, pkt.NetworkProtocolNumber, pkt); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func TestNetemDelay(t *testing.T) {
+	const delay = int64(100 * time.Millisecond)
+	q := NewNetem(NetemOptions{Delay: time.Duration(delay), Limit: 2})
+	var dropped stack.PacketBufferList
+	for i, want := range []bool{true, true, false} {
+		if got := q.Enqueue(newPacket(byte(i), 10, 0), 0, &dropped); got != want {
+			t.Errorf("got q.Enqueue(packet %d) = %t, want = %t", i, got, want)
+		}
+	}
+	pkt, next := q.Dequeue(delay-1, &dropped)
+	if pkt != nil || next != delay {
+		t.Fatalf("got q.Dequeue(%d, _) = (%v, %d), want = (nil, %d)", delay-1, pkt, next, delay)
+	}
+	if got, want := dequeueAll(q, delay, &dropped), "\x00\x01"; got != want {
+		t.Errorf("got dequeued packets = %q, want = %q", got, want)
+	}
+	if !dropped.Empty() {
+		t.Errorf("got dropped packets = %q, want none", ids(&dropped))
+	}
+}
+
+func TestNetemImpairments(t *testing.T) {
+	const (
+		delay  = int64(100 * time.Millisecond)
+		jitter = int64(10 * time.Millisecond)
+		count  = 1000
+	)
+	q := NewNetem(NetemOptions{
+		Delay:     time.Duration(delay),
+		Jitter:    time.Duration(jitter),
+		Loss:      0.1,
+		Duplicate: 0.1,
+		Reorder:   0.1,
+		Limit:     2 * count,
+		Rand:      mathrand.New(mathrand.NewSource(1)),
+	})
+	var dropped stack.PacketBufferList
+	for i := 0; i < count; i++ {
+		// Packets are queued every millisecond, numbered in their payload.
+		v := buffer.NewView(10)
+		binary.BigEndian.PutUint16(v, uint16(i))
+		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Data: v.ToVectorisedView(),
+		})
+		if !q.Enqueue(pkt, int64(i)*int64(time.Millisecond), &dropped) {
+			t.Fatalf("got q.Enqueue(packet %d) = false, want = true", i)
+		}
+	}
+
+	sent, reordered, immediate := 0, 0, 0
+	last := -1
+	for now := int64(0); q.Len() > 0; now += int64(time.Millisecond) {
+		for {
+			pkt, _ := q.Dequeue(now, &dropped)
+			if pkt == nil {
+				break
+			}
+			sent++
+			id := int(binary.BigEndian.Uint16(pkt.Data.ToView()))
+			if id < last {
+				reordered++
+			} else {
+				last = id
+			}
+			switch d := now - int64(id)*int64(time.Millisecond); {
+			case d < delay-jitter:
+				immediate++
+			case d > delay+jitter+int64(time.Millisecond):
+				t.Errorf("got packet %d delayed by %s, want at most %s", id, time.Duration(d), time.Duration(delay+jitter))
+			}
+		}
+	}
+
+	// The impairments happen about as often as configured.
+	lost := dropped.Len()
+	if lost < count/20 || lost > count*3/20 {
+		t.Errorf("got %d lost packets, want about %d", lost, count/10)
+	}
+	if dups := sent + lost - count; dups < count/20 || dups > count*3/20 {
+		t.Errorf("got %d duplicated packets, want about %d", dups, count/10)
+	}
+	if reordered == 0 {
+		t.Error("got no reordered packets")
+	}
+	if immediate == 0 {
+		t.Error("got no packets transmitted without delay")
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	clock := faketime.NewManualClock()
+	lower := channel.New(10, header.IPv4MinimumMTU, "")
+	ep := New(batchEndpoint{lower}, clock, NewTBF(TBFOptions{Rate: 1000, Burst: 100, Limit: 1000}))
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		Clock:            clock,
+	})
+	if err := s.CreateNIC(nicID, ep); err != nil {
+		t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+	}
+	if err := s.AddAddress(nicID, ipv4.ProtocolNumber, localAddr); err != nil {
+		t.Fatalf("s.AddAddress(%d, %d, %s): %s", nicID, ipv4.ProtocolNumber, localAddr, err)
+	}
+	s.SetRouteTable([]tcpip.Route{{Destination: header.IPv4EmptySubnet, NIC: nicID}})
+	r, err := s.FindRoute(nicID, localAddr, remoteAddr, ipv4.ProtocolNumber, false /* multicastLoop */)
+	if err != nil {
+		t.Fatalf("s.FindRoute(%d, %s, %s, %d, false): %s", nicID, localAddr, remoteAddr, ipv4.ProtocolNumber, err)
+	}
+	defer r.Release()
+
+	read := func(want byte) {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		p, ok := lower.ReadContext(ctx)
+		if !ok {
+			t.Fatalf("timed out waiting for packet %d", want)
+		}
+		if got := p.Pkt.Data.ToView()[0]; got != want {
+			t.Fatalf("got packet %d, want packet %d", got, want)
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		if err := ep.WritePacket(r, nil /* gso */, ipv4.ProtocolNumber, newPacket(byte(i), 100, 0)); err != nil {
+			t.Fatalf("ep.WritePacket(_, nil, %d, packet %d): %s", ipv4.ProtocolNumber, i, err)
+		}
+	}
+
+	// The packets beyond the burst are written once the bucket has tokens
+	// again.
+	read(0)
+	read(1)
+	clock.Advance(100 * time.Millisecond)
+	read(2)
+
+	// The packets left are written as soon as the discipline is replaced.
+	ep.SetDiscipline(NewPFIFO(10))
+	read(3)
+
+	stats := ep.Stats()
+	if got, want := stats.Enqueued.Value(), uint64(4); got != want {
+		t.Errorf("got stats.Enqueued.Value() = %d, want = %d", got, want)
+	}
+	if got := stats.Dropped.Value(); got != 0 {
+		t.Errorf("got stats.Dropped.Value() = %d, want = 0", got)
+	}
+
+	lower.Close()
+	ep.Wait()
+}
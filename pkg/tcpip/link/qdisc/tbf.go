@@ -0,0 +1,112 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package qdisc
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// TBFOptions are the options of a TBF discipline.
+type TBFOptions struct {
+	// Rate is the rate at which packets are transmitted, in bytes per
+	// second.
+	Rate uint64
+
+	// Burst is the size of the token bucket, in bytes: the number of bytes
+	// which can be transmitted at once after the discipline was idle.
+	Burst uint32
+
+	// Limit is the maximum number of bytes queued.
+	Limit uint32
+}
+
+// TBF is a token bucket filter discipline, which shapes the packets to a rate
+// like Linux's tbf.
+//
+// The tokens are accounted in nanoseconds of transmission at the rate, and
+// packets are transmitted whenever the bucket isn't empty. The bucket may
+// become negative, so that packets larger than the burst, such as GSO
+// packets, don't stall the queue.
+type TBF struct {
+	opts TBFOptions
+
+	q     stack.PacketBufferList
+	len   int
+	bytes int
+
+	// buffer is the size of the bucket, in nanoseconds.
+	buffer int64
+
+	// tokens is the number of tokens in the bucket at last.
+	tokens int64
+	last   int64
+}
+
+var _ Discipline = (*TBF)(nil)
+
+// NewTBF returns a token bucket filter discipline. opts.Rate must not be 0.
+func NewTBF(opts TBFOptions) *TBF {
+	buffer := transmitTime(int(opts.Burst), opts.Rate)
+	return &TBF{
+		opts:   opts,
+		buffer: buffer,
+		tokens: buffer,
+	}
+}
+
+// Enqueue implements Discipline.Enqueue.
+func (q *TBF) Enqueue(pkt *stack.PacketBuffer, _ int64, _ *stack.PacketBufferList) bool {
+	size := pkt.Size()
+	if q.bytes+size > int(q.opts.Limit) {
+		return false
+	}
+	q.q.PushBack(pkt)
+	q.len++
+	q.bytes += size
+	return true
+}
+
+// Dequeue implements Discipline.Dequeue.
+func (q *TBF) Dequeue(now int64, _ *stack.PacketBufferList) (*stack.PacketBuffer, int64) {
+	pkt := q.q.Front()
+	if pkt == nil {
+		return nil, 0
+	}
+	tokens := q.tokens + now - q.last
+	if tokens > q.buffer {
+		tokens = q.buffer
+	}
+	if tokens < 0 {
+		return nil, now - tokens
+	}
+	q.q.Remove(pkt)
+	q.len--
+	size := pkt.Size()
+	q.bytes -= size
+	q.tokens = tokens - transmitTime(size, q.opts.Rate)
+	q.last = now
+	return pkt, 0
+}
+
+// Len implements Discipline.Len.
+func (q *TBF) Len() int {
+	return q.len
+}
+
+// Reset implements Discipline.Reset.
+func (q *TBF) Reset(dropped *stack.PacketBufferList) {
+	dropped.PushBackList(&q.q)
+	q.len = 0
+	q.bytes = 0
+}
@@ -87,6 +87,28 @@ func NonBlockingSendMMsg(fd int, msgHdrs []MMsgHdr) (int, *tcpip.Error) {
 	return int(n), nil
 }
 
+// NonBlockingReadv reads from a file descriptor that is set up as non-blocking
+// and stores the data in a list of iovecs buffers. It returns
+// tcpip.ErrWouldBlock if no data is available.
+func NonBlockingReadv(fd int, iovecs []syscall.Iovec) (int, *tcpip.Error) {
+	n, _, e := syscall.RawSyscall(syscall.SYS_READV, uintptr(fd), uintptr(unsafe.Pointer(&iovecs[0])), uintptr(len(iovecs)))
+	if e != 0 {
+		return 0, TranslateErrno(e)
+	}
+	return int(n), nil
+}
+
+// NonBlockingRecvMMsg reads from a file descriptor that is set up as
+// non-blocking and stores the received messages in a slice of MMsgHdr
+// structures. It returns tcpip.ErrWouldBlock if no data is available.
+func NonBlockingRecvMMsg(fd int, msgHdrs []MMsgHdr) (int, *tcpip.Error) {
+	n, _, e := syscall.RawSyscall6(syscall.SYS_RECVMMSG, uintptr(fd), uintptr(unsafe.Pointer(&msgHdrs[0])), uintptr(len(msgHdrs)), syscall.MSG_DONTWAIT, 0, 0)
+	if e != 0 {
+		return 0, TranslateErrno(e)
+	}
+	return int(n), nil
+}
+
 // PollEvent represents the pollfd structure passed to a poll() system call.
 type PollEvent struct {
 	FD      int32
@@ -35,7 +35,7 @@ func TestBasicTxQueue(t *testing.T) {
 	txp.Init(pb2)
 
 	var q Tx
-	q.Init(pb1, pb2)
+	q.Init(pb1, pb2, nil)
 
 	// Enqueue two buffers.
 	b := []TxBuffer{
@@ -203,7 +203,7 @@ func TestBadTxCompletion(t *testing.T) {
 	txp.Init(pb2)
 
 	var q Tx
-	q.Init(pb1, pb2)
+	q.Init(pb1, pb2, nil)
 
 	// Post a completion that is too short, and check that it is ignored.
 	if d := txp.Push(7); d == nil {
@@ -318,7 +318,7 @@ func TestFillTxPipe(t *testing.T) {
 	txp.Init(pb2)
 
 	var q Tx
-	q.Init(pb1, pb2)
+	q.Init(pb1, pb2, nil)
 
 	// Transmit twice, which should fill the tx pipe.
 	b := []TxBuffer{
@@ -386,7 +386,7 @@ func TestLotsOfTransmissions(t *testing.T) {
 	txp.Init(pb2)
 
 	var q Tx
-	q.Init(pb1, pb2)
+	q.Init(pb1, pb2, nil)
 
 	// Prepare packet with two buffers.
 	b := []TxBuffer{
@@ -515,3 +515,68 @@ func TestRxDisableNotification(t *testing.T) {
 		t.Fatalf("Bad value in shared state: got %v, want %v", state, eventFDDisabled)
 	}
 }
+
+func TestBatchedTxQueue(t *testing.T) {
+	// Check that batched packets are only visible once flushed.
+	pb1 := make([]byte, 200)
+	pb2 := make([]byte, 100)
+
+	var rxp pipe.Rx
+	rxp.Init(pb1)
+
+	var txp pipe.Tx
+	txp.Init(pb2)
+
+	var q Tx
+	q.Init(pb1, pb2, nil)
+
+	for id := uint64(1); id <= 2; id++ {
+		b := TxBuffer{nil, 100 * id, 60}
+		if !q.EnqueueBatched(id, 60, 1, &b) {
+			t.Fatalf("EnqueueBatched failed for packet %d", id)
+		}
+		if d := rxp.Pull(); d != nil {
+			t.Fatalf("Tx pipe isn't empty before Flush: got %v", d)
+		}
+	}
+
+	q.Flush()
+	for id := uint64(1); id <= 2; id++ {
+		d := rxp.Pull()
+		if d == nil {
+			t.Fatalf("Tx pipe is empty after Flush, want packet %d", id)
+		}
+		if got := DecodeTxPacketHeader(d).ID; got != id {
+			t.Fatalf("Bad packet id: got %v, want %v", got, id)
+		}
+		rxp.Flush()
+	}
+}
+
+func TestTxNotificationEnabled(t *testing.T) {
+	// Check that the peer is notified unless it disabled notifications.
+	pb1 := make([]byte, 100)
+	pb2 := make([]byte, 100)
+
+	var q Tx
+	q.Init(pb1, pb2, nil)
+	if !q.NotificationEnabled() {
+		t.Errorf("Notification disabled without shared state")
+	}
+
+	var state uint32
+	q.Init(pb1, pb2, &state)
+	for _, test := range []struct {
+		state uint32
+		want  bool
+	}{
+		{eventFDUninitialized, true},
+		{eventFDDisabled, false},
+		{eventFDEnabled, true},
+	} {
+		state = test.state
+		if got := q.NotificationEnabled(); got != test.want {
+			t.Errorf("Bad notification state for shared state %v: got %v, want %v", test.state, got, test.want)
+		}
+	}
+}
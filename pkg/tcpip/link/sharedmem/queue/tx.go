@@ -16,6 +16,7 @@ package queue
 
 import (
 	"encoding/binary"
+	"sync/atomic"
 
 	"gvisor.dev/gvisor/pkg/log"
 	"gvisor.dev/gvisor/pkg/tcpip/link/sharedmem/pipe"
@@ -49,19 +50,42 @@ type TxBuffer struct {
 //
 // This struct is thread-compatible.
 type Tx struct {
-	tx pipe.Tx
-	rx pipe.Rx
+	tx                 pipe.Tx
+	rx                 pipe.Rx
+	sharedEventFDState *uint32
 }
 
-// Init initializes the transmit queue with the given pipes.
-func (t *Tx) Init(tx, rx []byte) {
+// Init initializes the transmit queue with the given pipes, and shared state
+// pointer -- the latter is used by the peer to enable/disable eventfd
+// notifications. If it is nil, the peer is always notified.
+func (t *Tx) Init(tx, rx []byte, sharedEventFDState *uint32) {
+	t.sharedEventFDState = sharedEventFDState
 	t.tx.Init(tx)
 	t.rx.Init(rx)
 }
 
+// NotificationEnabled returns whether the peer wants the eventfd to be
+// notified when packets are queued. The peer disables notifications while it
+// is polling the queue.
+func (t *Tx) NotificationEnabled() bool {
+	return t.sharedEventFDState == nil || atomic.LoadUint32(t.sharedEventFDState) != eventFDDisabled
+}
+
 // Enqueue queues the given linked list of buffers for transmission as one
 // packet. While it is queued, the caller must not modify them.
 func (t *Tx) Enqueue(id uint64, totalDataLen, bufferCount uint32, buffer *TxBuffer) bool {
+	if !t.EnqueueBatched(id, totalDataLen, bufferCount, buffer) {
+		return false
+	}
+	t.tx.Flush()
+	return true
+}
+
+// EnqueueBatched queues the given linked list of buffers for transmission as
+// one packet, like Enqueue, except that the packet is only made visible to the
+// peer by the next call to Flush or Enqueue, so that a batch of packets can be
+// made visible at once.
+func (t *Tx) EnqueueBatched(id uint64, totalDataLen, bufferCount uint32, buffer *TxBuffer) bool {
 	// Reserve room in the tx pipe.
 	totalLen := sizeOfPacketHeader + uint64(bufferCount)*sizeOfBufferDescriptor
 
@@ -83,11 +107,14 @@ func (t *Tx) Enqueue(id uint64, totalDataLen, bufferCount uint32, buffer *TxBuff
 		buffer = buffer.Next
 	}
 
-	t.tx.Flush()
-
 	return true
 }
 
+// Flush makes the packets queued by EnqueueBatched visible to the peer.
+func (t *Tx) Flush() {
+	t.tx.Flush()
+}
+
 // CompletedPacket returns the id of the last completed transmission. The
 // returned id, if any, refers to a value passed on a previous call to
 // Enqueue().
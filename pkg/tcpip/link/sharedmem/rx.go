@@ -30,6 +30,15 @@ type rx struct {
 	sharedData []byte
 	q          queue.Rx
 	eventFD    int
+
+	// pending holds the buffers of the packets received which haven't been
+	// posted back yet.
+	pending []queue.RxBuffer
+
+	// postBatchSize is the number of pending buffers above which they are
+	// posted back while packets are being received. They are always posted
+	// back before waiting for packets.
+	postBatchSize int
 }
 
 // init initializes all state needed by the rx queue based on the information
@@ -110,25 +119,19 @@ func (r *rx) cleanup() {
 // postAndReceive posts the provided buffers (if any), and then tries to read
 // from the receive queue.
 //
+// The buffers are posted in batches of postBatchSize, which coalesces the
+// completions the peer has to process, or before waiting for packets. Posting
+// never blocks: buffers that can't be posted because the queue is full stay
+// pending, and there are never more of them than fit in the queue.
+//
 // Capacity permitting, it reuses the posted buffer slice to store the buffers
 // that were read as well.
 //
 // This function will block if there aren't any available packets.
 func (r *rx) postAndReceive(b []queue.RxBuffer, stopRequested *uint32) ([]queue.RxBuffer, uint32) {
-	// Post the buffers first. If we cannot post, sleep until we can. We
-	// never post more than will fit concurrently, so it's safe to wait
-	// until enough room is available.
-	if len(b) != 0 && !r.q.PostBuffers(b) {
-		r.q.EnableNotification()
-		for !r.q.PostBuffers(b) {
-			var tmp [8]byte
-			rawfile.BlockingRead(r.eventFD, tmp[:])
-			if atomic.LoadUint32(stopRequested) != 0 {
-				r.q.DisableNotification()
-				return nil, 0
-			}
-		}
-		r.q.DisableNotification()
+	r.pending = append(r.pending, b...)
+	if len(r.pending) >= r.postBatchSize {
+		r.post()
 	}
 
 	// Read the next set of descriptors.
@@ -137,9 +140,11 @@ func (r *rx) postAndReceive(b []queue.RxBuffer, stopRequested *uint32) ([]queue.
 		return b, n
 	}
 
-	// Data isn't immediately available. Enable eventfd notifications.
+	// Data isn't immediately available. Enable eventfd notifications,
+	// which also signal room to post the pending buffers.
 	r.q.EnableNotification()
 	for {
+		r.post()
 		b, n = r.q.Dequeue(b)
 		if len(b) != 0 {
 			break
@@ -157,3 +162,20 @@ func (r *rx) postAndReceive(b []queue.RxBuffer, stopRequested *uint32) ([]queue.
 
 	return b, n
 }
+
+// post posts as many of the pending buffers as the queue can take, in batches
+// of at most postBatchSize.
+func (r *rx) post() {
+	posted := 0
+	for posted < len(r.pending) {
+		batch := r.pending[posted:]
+		if len(batch) > r.postBatchSize {
+			batch = batch[:r.postBatchSize]
+		}
+		if !r.q.PostBuffers(batch) {
+			break
+		}
+		posted += len(batch)
+	}
+	r.pending = append(r.pending[:0], r.pending[posted:]...)
+}
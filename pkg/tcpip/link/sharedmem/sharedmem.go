@@ -20,6 +20,12 @@
 // Shared memory endpoints can be used in the networking stack by calling New()
 // to create a new endpoint, and then passing it as an argument to
 // Stack.CreateNIC().
+//
+// An endpoint can use several pairs of tx and rx queues, so that packets are
+// sent and received in parallel on many-core hosts. Outbound packets are
+// spread over the tx queues by their hash, and each rx queue is read by its
+// own goroutine, so the peer must send all the packets of a flow over the same
+// rx queue to prevent reordering.
 package sharedmem
 
 import (
@@ -62,6 +68,31 @@ type QueueConfig struct {
 	SharedDataFD int
 }
 
+// QueuePairConfig holds the configurations of a pair of tx and rx queues.
+type QueuePairConfig struct {
+	// TX is the transmit queue.
+	TX QueueConfig
+
+	// RX is the receive queue.
+	RX QueueConfig
+}
+
+// queuePair holds all state associated with a pair of tx and rx queues.
+type queuePair struct {
+	// txMu protects tx, which is used by all the writers.
+	txMu sync.Mutex
+	tx   tx
+
+	// rx is only used by the dispatch goroutine of the queue pair.
+	rx rx
+}
+
+// cleanup releases all resources allocated for the queue pair.
+func (q *queuePair) cleanup() {
+	q.tx.cleanup()
+	q.rx.cleanup()
+}
+
 type endpoint struct {
 	// mtu (maximum transmission unit) is the maximum size of a packet.
 	mtu uint32
@@ -72,8 +103,9 @@ type endpoint struct {
 	// addr is the local address of this endpoint.
 	addr tcpip.LinkAddress
 
-	// rx is the receive queue.
-	rx rx
+	// queues are the queue pairs of the endpoint. The first one holds the
+	// TX and RX queues of the options.
+	queues []*queuePair
 
 	// stopRequested is to be accessed atomically only, and determines if
 	// the worker goroutines should stop.
@@ -85,40 +117,98 @@ type endpoint struct {
 	// mu protects the following fields.
 	mu sync.Mutex
 
-	// tx is the transmit queue.
-	tx tx
-
-	// workerStarted specifies whether the worker goroutine was started.
+	// workerStarted specifies whether the worker goroutines were started.
 	workerStarted bool
+
+	// gsoMaxSize is the maximum size of the TCP segments sent unsegmented
+	// to the peer. It is zero if segmentation offload is disabled.
+	gsoMaxSize uint32
+}
+
+// Options specify the details of a shared-memory-based endpoint.
+type Options struct {
+	// MTU is the maximum size of a frame, including its ethernet header.
+	MTU uint32
+
+	// BufferSize is the size of each individual buffer.
+	BufferSize uint32
+
+	// LinkAddress is the link address of the endpoint.
+	LinkAddress tcpip.LinkAddress
+
+	// TX is the transmit queue.
+	TX QueueConfig
+
+	// RX is the receive queue.
+	RX QueueConfig
+
+	// AdditionalQueues are the queue pairs used in addition to TX and RX.
+	AdditionalQueues []QueuePairConfig
+
+	// GSOMaxSize is the maximum size of the TCP segments sent to the peer
+	// without being segmented, which is useful when the peer is on the
+	// same host. Such segments only carry the checksum of the TCP
+	// pseudo-header, so the peer must not verify their checksums. It is
+	// zero if segmentation offload is disabled.
+	GSOMaxSize uint32
 }
 
 // New creates a new shared-memory-based endpoint. Buffers will be broken up
 // into buffers of "bufferSize" bytes.
 func New(mtu, bufferSize uint32, addr tcpip.LinkAddress, tx, rx QueueConfig) (stack.LinkEndpoint, error) {
+	return NewWithOptions(Options{
+		MTU:         mtu,
+		BufferSize:  bufferSize,
+		LinkAddress: addr,
+		TX:          tx,
+		RX:          rx,
+	})
+}
+
+// NewWithOptions creates a new shared-memory-based endpoint with the given
+// options.
+func NewWithOptions(opts Options) (stack.LinkEndpoint, error) {
 	e := &endpoint{
-		mtu:        mtu,
-		bufferSize: bufferSize,
-		addr:       addr,
+		mtu:        opts.MTU,
+		bufferSize: opts.BufferSize,
+		addr:       opts.LinkAddress,
+		gsoMaxSize: opts.GSOMaxSize,
 	}
 
-	if err := e.tx.init(bufferSize, &tx); err != nil {
-		return nil, err
-	}
+	configs := append([]QueuePairConfig{{TX: opts.TX, RX: opts.RX}}, opts.AdditionalQueues...)
+	for i := range configs {
+		q := &queuePair{}
+		if err := q.tx.init(opts.BufferSize, &configs[i].TX); err != nil {
+			e.cleanup()
+			return nil, err
+		}
 
-	if err := e.rx.init(bufferSize, &rx); err != nil {
-		e.tx.cleanup()
-		return nil, err
+		if err := q.rx.init(opts.BufferSize, &configs[i].RX); err != nil {
+			q.tx.cleanup()
+			e.cleanup()
+			return nil, err
+		}
+		e.queues = append(e.queues, q)
 	}
 
 	return e, nil
 }
 
+// cleanup releases all resources allocated for the queue pairs.
+func (e *endpoint) cleanup() {
+	for _, q := range e.queues {
+		q.cleanup()
+	}
+}
+
 // Close frees all resources associated with the endpoint.
 func (e *endpoint) Close() {
-	// Tell dispatch goroutine to stop, then write to the eventfd so that
-	// it wakes up in case it's sleeping.
+	// Tell dispatch goroutines to stop, then write to the eventfds so that
+	// they wake up in case they're sleeping.
 	atomic.StoreUint32(&e.stopRequested, 1)
-	syscall.Write(e.rx.eventFD, []byte{1, 0, 0, 0, 0, 0, 0, 0})
+	for _, q := range e.queues {
+		syscall.Write(q.rx.eventFD, []byte{1, 0, 0, 0, 0, 0, 0, 0})
+	}
 
 	// Cleanup the queues inline if the worker hasn't started yet; we also
 	// know it won't start from now on because stopRequested is set to 1.
@@ -127,8 +217,7 @@ func (e *endpoint) Close() {
 	e.mu.Unlock()
 
 	if !workerPresent {
-		e.tx.cleanup()
-		e.rx.cleanup()
+		e.cleanup()
 	}
 }
 
@@ -138,17 +227,19 @@ func (e *endpoint) Wait() {
 	e.completed.Wait()
 }
 
-// Attach implements stack.LinkEndpoint.Attach. It launches the goroutine that
-// reads packets from the rx queue.
+// Attach implements stack.LinkEndpoint.Attach. It launches the goroutines that
+// read packets from the rx queues.
 func (e *endpoint) Attach(dispatcher stack.NetworkDispatcher) {
 	e.mu.Lock()
 	if !e.workerStarted && atomic.LoadUint32(&e.stopRequested) == 0 {
 		e.workerStarted = true
-		e.completed.Add(1)
+		e.completed.Add(len(e.queues))
 		// Link endpoints are not savable. When transportation endpoints
 		// are saved, they stop sending outgoing packets and all
 		// incoming packets are rejected.
-		go e.dispatchLoop(dispatcher) // S/R-SAFE: see above.
+		for _, q := range e.queues {
+			go e.dispatchLoop(dispatcher, q) // S/R-SAFE: see above.
+		}
 	}
 	e.mu.Unlock()
 }
@@ -167,10 +258,18 @@ func (e *endpoint) MTU() uint32 {
 }
 
 // Capabilities implements stack.LinkEndpoint.Capabilities.
-func (*endpoint) Capabilities() stack.LinkEndpointCapabilities {
+func (e *endpoint) Capabilities() stack.LinkEndpointCapabilities {
+	if e.gsoMaxSize != 0 {
+		return stack.CapabilityHardwareGSO
+	}
 	return 0
 }
 
+// GSOMaxSize implements stack.GSOEndpoint.
+func (e *endpoint) GSOMaxSize() uint32 {
+	return e.gsoMaxSize
+}
+
 // MaxHeaderLength implements stack.LinkEndpoint.MaxHeaderLength. It returns the
 // ethernet frame header size.
 func (*endpoint) MaxHeaderLength() uint16 {
@@ -201,6 +300,11 @@ func (e *endpoint) AddHeader(local, remote tcpip.LinkAddress, protocol tcpip.Net
 	eth.Encode(ethHdr)
 }
 
+// txQueue returns the queue pair over which pkt is sent.
+func (e *endpoint) txQueue(pkt *stack.PacketBuffer) *queuePair {
+	return e.queues[pkt.Hash%uint32(len(e.queues))]
+}
+
 // WritePacket writes outbound packets to the file descriptor. If it is not
 // currently writable, the packet is dropped.
 func (e *endpoint) WritePacket(r *stack.Route, _ *stack.GSO, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) *tcpip.Error {
@@ -208,9 +312,10 @@ func (e *endpoint) WritePacket(r *stack.Route, _ *stack.GSO, protocol tcpip.Netw
 
 	views := pkt.Views()
 	// Transmit the packet.
-	e.mu.Lock()
-	ok := e.tx.transmit(views...)
-	e.mu.Unlock()
+	q := e.txQueue(pkt)
+	q.txMu.Lock()
+	ok := q.tx.transmit(views...)
+	q.txMu.Unlock()
 
 	if !ok {
 		return tcpip.ErrWouldBlock
@@ -219,17 +324,53 @@ func (e *endpoint) WritePacket(r *stack.Route, _ *stack.GSO, protocol tcpip.Netw
 	return nil
 }
 
-// WritePackets implements stack.LinkEndpoint.WritePackets.
-func (e *endpoint) WritePackets(r *stack.Route, _ *stack.GSO, pkts stack.PacketBufferList, protocol tcpip.NetworkProtocolNumber) (int, *tcpip.Error) {
-	panic("not implemented")
+// WritePackets implements stack.LinkEndpoint.WritePackets. The consecutive
+// packets sent over the same tx queue are made visible to the peer at once,
+// with a single notification.
+//
+// Being a batch API, each packet in pkts should have the following
+// fields populated:
+//  - pkt.EgressRoute
+//  - pkt.NetworkProtocolNumber
+func (e *endpoint) WritePackets(_ *stack.Route, _ *stack.GSO, pkts stack.PacketBufferList, _ tcpip.NetworkProtocolNumber) (int, *tcpip.Error) {
+	var q *queuePair
+	sent, queued := 0, 0
+	var err *tcpip.Error
+	for pkt := pkts.Front(); pkt != nil; pkt = pkt.Next() {
+		if pktQueue := e.txQueue(pkt); pktQueue != q {
+			if q != nil {
+				q.tx.flush()
+				q.txMu.Unlock()
+				sent += queued
+				queued = 0
+			}
+			q = pktQueue
+			q.txMu.Lock()
+		}
+
+		e.AddHeader(pkt.EgressRoute.LocalLinkAddress, pkt.EgressRoute.RemoteLinkAddress(), pkt.NetworkProtocolNumber, pkt)
+		if !q.tx.enqueue(pkt.Views()...) {
+			err = tcpip.ErrWouldBlock
+			break
+		}
+		queued++
+	}
+	if q != nil {
+		if queued != 0 {
+			q.tx.flush()
+		}
+		q.txMu.Unlock()
+		sent += queued
+	}
+	return sent, err
 }
 
-// dispatchLoop reads packets from the rx queue in a loop and dispatches them
-// to the network stack.
-func (e *endpoint) dispatchLoop(d stack.NetworkDispatcher) {
+// dispatchLoop reads packets from the rx queue of q in a loop and dispatches
+// them to the network stack.
+func (e *endpoint) dispatchLoop(d stack.NetworkDispatcher, q *queuePair) {
 	// Post initial set of buffers.
-	limit := e.rx.q.PostedBuffersLimit()
-	if l := uint64(len(e.rx.data)) / uint64(e.bufferSize); limit > l {
+	limit := q.rx.q.PostedBuffersLimit()
+	if l := uint64(len(q.rx.data)) / uint64(e.bufferSize); limit > l {
 		limit = l
 	}
 	for i := uint64(0); i < limit; i++ {
@@ -238,23 +379,29 @@ func (e *endpoint) dispatchLoop(d stack.NetworkDispatcher) {
 			Size:   e.bufferSize,
 			ID:     i,
 		}
-		if !e.rx.q.PostBuffers([]queue.RxBuffer{b}) {
+		if !q.rx.q.PostBuffers([]queue.RxBuffer{b}) {
 			log.Warningf("Unable to post %v-th buffer", i)
 		}
 	}
 
+	// Post the buffers back in batches of a quarter of the buffers.
+	q.rx.postBatchSize = int(limit / 4)
+	if q.rx.postBatchSize == 0 {
+		q.rx.postBatchSize = 1
+	}
+
 	// Read in a loop until a stop is requested.
 	var rxb []queue.RxBuffer
 	for atomic.LoadUint32(&e.stopRequested) == 0 {
 		var n uint32
-		rxb, n = e.rx.postAndReceive(rxb, &e.stopRequested)
+		rxb, n = q.rx.postAndReceive(rxb, &e.stopRequested)
 
 		// Copy data from the shared area to its own buffer, then
 		// prepare to repost the buffer.
 		b := make([]byte, n)
 		offset := uint32(0)
 		for i := range rxb {
-			copy(b[offset:], e.rx.data[rxb[i].Offset:][:rxb[i].Size])
+			copy(b[offset:], q.rx.data[rxb[i].Offset:][:rxb[i].Size])
 			offset += rxb[i].Size
 
 			rxb[i].Size = e.bufferSize
@@ -275,8 +422,7 @@ func (e *endpoint) dispatchLoop(d stack.NetworkDispatcher) {
 	}
 
 	// Clean state.
-	e.tx.cleanup()
-	e.rx.cleanup()
+	q.cleanup()
 
 	e.completed.Done()
 }
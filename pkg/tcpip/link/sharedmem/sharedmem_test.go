@@ -18,6 +18,8 @@ package sharedmem
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
@@ -84,6 +86,13 @@ type packetInfo struct {
 	linkHeader buffer.View
 }
 
+// testQueuePair holds the peer side of an additional queue pair.
+type testQueuePair struct {
+	cfg QueuePairConfig
+	txq queueBuffers
+	rxq queueBuffers
+}
+
 type testContext struct {
 	t     *testing.T
 	ep    *endpoint
@@ -92,37 +101,58 @@ type testContext struct {
 	txq   queueBuffers
 	rxq   queueBuffers
 
+	additionalQueues []*testQueuePair
+
 	packetCh chan struct{}
 	mu       sync.Mutex
 	packets  []packetInfo
 }
 
 func newTestContext(t *testing.T, mtu, bufferSize uint32, addr tcpip.LinkAddress) *testContext {
+	return newMultiQueueTestContext(t, mtu, bufferSize, addr, 0)
+}
+
+func newMultiQueueTestContext(t *testing.T, mtu, bufferSize uint32, addr tcpip.LinkAddress, additionalQueues int) *testContext {
 	var err error
 	c := &testContext{
 		t:        t,
 		packetCh: make(chan struct{}, 1000000),
 	}
-	c.txCfg = createQueueFDs(t, queueSizes{
-		dataSize:       queueDataSize,
-		txPipeSize:     queuePipeSize,
-		rxPipeSize:     queuePipeSize,
-		sharedDataSize: 4096,
-	})
-
-	c.rxCfg = createQueueFDs(t, queueSizes{
+	sizes := queueSizes{
 		dataSize:       queueDataSize,
 		txPipeSize:     queuePipeSize,
 		rxPipeSize:     queuePipeSize,
 		sharedDataSize: 4096,
-	})
+	}
+	c.txCfg = createQueueFDs(t, sizes)
+	c.rxCfg = createQueueFDs(t, sizes)
 
 	initQueue(t, &c.txq, &c.txCfg)
 	initQueue(t, &c.rxq, &c.rxCfg)
 
-	ep, err := New(mtu, bufferSize, addr, c.txCfg, c.rxCfg)
+	opts := Options{
+		MTU:         mtu,
+		BufferSize:  bufferSize,
+		LinkAddress: addr,
+		TX:          c.txCfg,
+		RX:          c.rxCfg,
+	}
+	for i := 0; i < additionalQueues; i++ {
+		q := &testQueuePair{
+			cfg: QueuePairConfig{
+				TX: createQueueFDs(t, sizes),
+				RX: createQueueFDs(t, sizes),
+			},
+		}
+		initQueue(t, &q.txq, &q.cfg.TX)
+		initQueue(t, &q.rxq, &q.cfg.RX)
+		c.additionalQueues = append(c.additionalQueues, q)
+		opts.AdditionalQueues = append(opts.AdditionalQueues, q.cfg)
+	}
+
+	ep, err := NewWithOptions(opts)
 	if err != nil {
-		t.Fatalf("New failed: %v", err)
+		t.Fatalf("NewWithOptions failed: %v", err)
 	}
 
 	c.ep = ep.(*endpoint)
@@ -153,6 +183,12 @@ func (c *testContext) cleanup() {
 	closeFDs(&c.rxCfg)
 	c.txq.cleanup()
 	c.rxq.cleanup()
+	for _, q := range c.additionalQueues {
+		closeFDs(&q.cfg.TX)
+		closeFDs(&q.cfg.RX)
+		q.txq.cleanup()
+		q.rxq.cleanup()
+	}
 }
 
 func (c *testContext) waitForPackets(n int, to <-chan time.Time, errorStr string) {
@@ -623,7 +659,7 @@ func TestSimpleReceive(t *testing.T) {
 	defer c.cleanup()
 
 	// Check that buffers have been posted.
-	limit := c.ep.rx.q.PostedBuffersLimit()
+	limit := c.ep.queues[0].rx.q.PostedBuffersLimit()
 	for i := uint64(0); i < limit; i++ {
 		timeout := time.After(2 * time.Second)
 		bi := queue.DecodeRxBufferHeader(pollPull(t, &c.rxq.tx, timeout, "Timeout waiting for all buffers to be posted"))
@@ -701,7 +737,7 @@ func TestRxBuffersReposted(t *testing.T) {
 	defer c.cleanup()
 
 	// Receive all posted buffers.
-	limit := c.ep.rx.q.PostedBuffersLimit()
+	limit := c.ep.queues[0].rx.q.PostedBuffersLimit()
 	buffers := make([]queue.RxBuffer, 0, limit)
 	for i := limit; i > 0; i-- {
 		timeout := time.After(2 * time.Second)
@@ -746,7 +782,8 @@ func TestRxBuffersReposted(t *testing.T) {
 
 // TestReceivePostingIsFull checks that the endpoint will properly handle the
 // case when a received buffer cannot be immediately reposted because it hasn't
-// been pulled from the tx pipe yet.
+// been pulled from the tx pipe yet: packets keep being received, and the
+// buffers are reposted once there is room for them.
 func TestReceivePostingIsFull(t *testing.T) {
 	const bufferSize = 1500
 	c := newTestContext(t, 20000, bufferSize, localLinkAddr)
@@ -767,21 +804,27 @@ func TestReceivePostingIsFull(t *testing.T) {
 	c.rxq.rx.Flush()
 	syscall.Write(c.rxCfg.EventFD, []byte{1, 0, 0, 0, 0, 0, 0, 0})
 
-	// Check that no packet is received yet, as the worker is blocked trying
-	// to repost.
-	select {
-	case <-time.After(500 * time.Millisecond):
-	case <-c.packetCh:
-		t.Fatalf("Unexpected packet received")
-	}
+	// Check that the second packet is received even though the first
+	// buffer couldn't be reposted.
+	c.waitForPackets(1, time.After(time.Second), "Timeout waiting for second completed packet")
 
-	// Flush tx queue, which will allow the first buffer to be reposted,
-	// and the second completion to be pulled.
+	// Flush tx queue, which will allow both buffers to be reposted after
+	// the other buffers posted initially.
 	c.rxq.tx.Flush()
 	syscall.Write(c.rxCfg.EventFD, []byte{1, 0, 0, 0, 0, 0, 0, 0})
 
-	// Check that second packet completes.
-	c.waitForPackets(1, time.After(time.Second), "Timeout waiting for second completed packet")
+	limit := c.ep.queues[0].rx.q.PostedBuffersLimit()
+	for i := uint64(2); i < limit; i++ {
+		pollPull(t, &c.rxq.tx, time.After(time.Second), "Timeout waiting for all buffers to be posted")
+		c.rxq.tx.Flush()
+	}
+	for _, want := range []queue.RxBuffer{first, second} {
+		bi := queue.DecodeRxBufferHeader(pollPull(t, &c.rxq.tx, time.After(time.Second), "Timeout waiting for buffer to be reposted"))
+		if bi != want {
+			t.Fatalf("Different buffer posted: got %v, want %v", bi, want)
+		}
+		c.rxq.tx.Flush()
+	}
 }
 
 // TestCloseWhileWaitingToPost closes the endpoint while it is waiting to
@@ -810,3 +853,148 @@ func TestCloseWhileWaitingToPost(t *testing.T) {
 	cleaned = true
 	c.ep.Wait()
 }
+
+// TestSegmentationOffload tests that endpoints created with a GSO maximum size
+// advertise segmentation offload.
+func TestSegmentationOffload(t *testing.T) {
+	sizes := queueSizes{
+		dataSize:       queueDataSize,
+		txPipeSize:     queuePipeSize,
+		rxPipeSize:     queuePipeSize,
+		sharedDataSize: 4096,
+	}
+	for _, gsoMaxSize := range []uint32{0, stack.SoftwareGSOMaxSize} {
+		t.Run(fmt.Sprintf("GSOMaxSize=%d", gsoMaxSize), func(t *testing.T) {
+			txCfg := createQueueFDs(t, sizes)
+			defer closeFDs(&txCfg)
+			rxCfg := createQueueFDs(t, sizes)
+			defer closeFDs(&rxCfg)
+
+			ep, err := NewWithOptions(Options{
+				MTU:         1500,
+				BufferSize:  1500,
+				LinkAddress: localLinkAddr,
+				TX:          txCfg,
+				RX:          rxCfg,
+				GSOMaxSize:  gsoMaxSize,
+			})
+			if err != nil {
+				t.Fatalf("NewWithOptions failed: %v", err)
+			}
+			defer ep.(*endpoint).Close()
+
+			if got, want := ep.Capabilities()&stack.CapabilityHardwareGSO != 0, gsoMaxSize != 0; got != want {
+				t.Errorf("got hardware GSO capability = %t, want = %t", got, want)
+			}
+			if got := ep.(stack.GSOEndpoint).GSOMaxSize(); got != gsoMaxSize {
+				t.Errorf("got GSOMaxSize() = %d, want = %d", got, gsoMaxSize)
+			}
+		})
+	}
+}
+
+// TestMultipleQueues checks that packets are sent over the tx queue selected by
+// their hash, and received from all the rx queues.
+func TestMultipleQueues(t *testing.T) {
+	const bufferSize = 1500
+	c := newMultiQueueTestContext(t, 20000, bufferSize, localLinkAddr, 1)
+	defer c.cleanup()
+
+	var r stack.Route
+	r.ResolveWith(remoteLinkAddr)
+
+	txqs := []*queueBuffers{&c.txq, &c.additionalQueues[0].txq}
+	for hash := range txqs {
+		data := buffer.NewView(100)
+		randomFill(data)
+		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+			ReserveHeaderBytes: int(c.ep.MaxHeaderLength()),
+			Data:               data.ToVectorisedView(),
+		})
+		pkt.Hash = uint32(hash)
+		if err := c.ep.WritePacket(&r, nil /* gso */, header.IPv4ProtocolNumber, pkt); err != nil {
+			t.Fatalf("WritePacket failed: %v", err)
+		}
+
+		for i, q := range txqs {
+			desc := q.tx.Pull()
+			if got, want := desc != nil, i == hash; got != want {
+				t.Fatalf("Got packet on tx queue %d = %t, want = %t", i, got, want)
+			}
+			if desc != nil {
+				q.tx.Flush()
+			}
+		}
+	}
+
+	rxqs := []struct {
+		q   *queueBuffers
+		cfg *QueueConfig
+	}{
+		{&c.rxq, &c.rxCfg},
+		{&c.additionalQueues[0].rxq, &c.additionalQueues[0].cfg.RX},
+	}
+	for i, rxq := range rxqs {
+		bi := queue.DecodeRxBufferHeader(pollPull(t, &rxq.q.tx, time.After(time.Second), "Timeout waiting for buffer to be posted"))
+		contents := make([]byte, 100)
+		randomFill(contents)
+		copy(rxq.q.data[bi.Offset:], contents)
+
+		b := rxq.q.rx.Push(queue.RxCompletionSize(1))
+		queue.EncodeRxCompletion(b, uint32(len(contents)), 0)
+		queue.EncodeRxCompletionBuffer(b, 0, bi)
+		rxq.q.rx.Flush()
+		syscall.Write(rxq.cfg.EventFD, []byte{1, 0, 0, 0, 0, 0, 0, 0})
+
+		c.waitForPackets(1, time.After(5*time.Second), fmt.Sprintf("Timeout waiting for packet on rx queue %d", i))
+		c.mu.Lock()
+		rcvd := []byte(c.packets[0].vv.ToView())
+		c.packets = c.packets[:0]
+		c.mu.Unlock()
+		if want := contents[header.EthernetMinimumSize:]; !bytes.Equal(rcvd, want) {
+			t.Fatalf("Unexpected buffer contents on rx queue %d: got %x, want %x", i, rcvd, want)
+		}
+	}
+}
+
+// TestWritePacketsNotifiesOnce checks that a batch of packets is made visible
+// to the peer at once, with a single notification.
+func TestWritePacketsNotifiesOnce(t *testing.T) {
+	c := newTestContext(t, 20000, 1500, localLinkAddr)
+	defer c.cleanup()
+
+	var r stack.Route
+	r.ResolveWith(remoteLinkAddr)
+
+	const count = 10
+	var pkts stack.PacketBufferList
+	for i := 0; i < count; i++ {
+		data := buffer.NewView(100)
+		randomFill(data)
+		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+			ReserveHeaderBytes: int(c.ep.MaxHeaderLength()),
+			Data:               data.ToVectorisedView(),
+		})
+		pkt.EgressRoute = &r
+		pkt.NetworkProtocolNumber = header.IPv4ProtocolNumber
+		pkts.PushBack(pkt)
+	}
+	if n, err := c.ep.WritePackets(&r, nil /* gso */, pkts, header.IPv4ProtocolNumber); n != count || err != nil {
+		t.Fatalf("WritePackets returned (%d, %v), want (%d, nil)", n, err, count)
+	}
+
+	for i := 0; i < count; i++ {
+		if c.txq.tx.Pull() == nil {
+			t.Fatalf("Tx pipe is empty, want packet %d", i)
+		}
+		c.txq.tx.Flush()
+	}
+
+	var b [8]byte
+	if _, err := syscall.Read(c.txCfg.EventFD, b[:]); err != nil {
+		t.Fatalf("Read of the tx eventfd failed: %v", err)
+	}
+	if got := binary.LittleEndian.Uint64(b[:]); got != 1 {
+		t.Errorf("Got %d notifications, want 1", got)
+	}
+}
@@ -28,10 +28,12 @@ const (
 
 // tx holds all state associated with a tx queue.
 type tx struct {
-	data []byte
-	q    queue.Tx
-	ids  idManager
-	bufs bufferManager
+	data       []byte
+	sharedData []byte
+	q          queue.Tx
+	ids        idManager
+	bufs       bufferManager
+	eventFD    int
 }
 
 // init initializes all state needed by the tx queue based on the information
@@ -59,11 +61,32 @@ func (t *tx) init(mtu uint32, c *QueueConfig) error {
 		return err
 	}
 
+	sharedData, err := getBuffer(c.SharedDataFD)
+	if err != nil {
+		syscall.Munmap(txPipe)
+		syscall.Munmap(rxPipe)
+		syscall.Munmap(data)
+		return err
+	}
+
+	// Duplicate the eventFD so that caller can close it but we can still
+	// use it.
+	efd, err := syscall.Dup(c.EventFD)
+	if err != nil {
+		syscall.Munmap(txPipe)
+		syscall.Munmap(rxPipe)
+		syscall.Munmap(data)
+		syscall.Munmap(sharedData)
+		return err
+	}
+
 	// Initialize state based on buffers.
-	t.q.Init(txPipe, rxPipe)
+	t.q.Init(txPipe, rxPipe, sharedDataPointer(sharedData))
 	t.ids.init()
 	t.bufs.init(0, len(data), int(mtu))
 	t.data = data
+	t.sharedData = sharedData
+	t.eventFD = efd
 
 	return nil
 }
@@ -75,11 +98,34 @@ func (t *tx) cleanup() {
 	syscall.Munmap(a)
 	syscall.Munmap(b)
 	syscall.Munmap(t.data)
+	syscall.Munmap(t.sharedData)
+	syscall.Close(t.eventFD)
 }
 
 // transmit sends a packet made of bufs. Returns a boolean that specifies
 // whether the packet was successfully transmitted.
 func (t *tx) transmit(bufs ...buffer.View) bool {
+	if !t.enqueue(bufs...) {
+		return false
+	}
+	t.flush()
+	return true
+}
+
+// flush makes the packets enqueued since the last flush visible to the peer,
+// and rings its doorbell once for all of them, unless the peer is polling the
+// queue.
+func (t *tx) flush() {
+	t.q.Flush()
+	if t.q.NotificationEnabled() {
+		syscall.Write(t.eventFD, []byte{1, 0, 0, 0, 0, 0, 0, 0})
+	}
+}
+
+// enqueue queues a packet made of bufs without making it visible to the peer,
+// which is done by the next call to flush. Returns a boolean that specifies
+// whether the packet was successfully queued.
+func (t *tx) enqueue(bufs ...buffer.View) bool {
 	// Pull completions from the tx queue and add their buffers back to the
 	// pool so that we can reuse them.
 	for {
@@ -133,7 +179,7 @@ func (t *tx) transmit(bufs ...buffer.View) bool {
 
 	// Get an id for this packet and send it out.
 	id := t.ids.add(buf)
-	if !t.q.Enqueue(id, total, bufCount, buf) {
+	if !t.q.EnqueueBatched(id, total, bufCount, buf) {
 		t.ids.remove(id)
 		t.bufs.free(buf)
 		return false
@@ -0,0 +1,231 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sniffer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/bpf"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// DefaultSnapLen is the default maximum number of bytes of a packet saved by
+// a capture, as in tcpdump.
+const DefaultSnapLen = 262144
+
+// pcapHeaderLen is the size of the header at the start of pcap files.
+const pcapHeaderLen = 24
+
+// CaptureFile is a file a capture writes to.
+type CaptureFile interface {
+	io.Writer
+
+	// Truncate changes the size of the file.
+	Truncate(size int64) error
+
+	// Seek sets the offset of the next write to the file.
+	Seek(offset int64, whence int) (int64, error)
+}
+
+// CaptureOptions are the options of a capture.
+type CaptureOptions struct {
+	// Filter is a classic BPF program selecting the packets to save, as
+	// compiled by tcpdump -ddd for the RAW link type. It is run on the
+	// packets starting at their network header, and returns the number of
+	// bytes of the packet to save, or 0 to skip it. If nil, all the packets
+	// are saved.
+	Filter []linux.BPFInstruction
+
+	// SnapLen is the maximum number of bytes of a packet saved. It
+	// defaults to DefaultSnapLen.
+	SnapLen uint32
+
+	// Direction is the direction of the packets to save. It defaults to
+	// stack.CaptureBoth.
+	Direction stack.CaptureDirection
+
+	// Files are the files to write the packets to, in the pcap format.
+	Files []CaptureFile
+
+	// MaxFileSize is the size beyond which a file is rotated, in bytes.
+	// Once the last file is full, the first one is truncated and
+	// reused. If 0, a single file is written to without limit.
+	MaxFileSize int64
+}
+
+// Capture saves the packets going through a NIC to pcap files.
+type Capture struct {
+	stack *stack.Stack
+	nicID tcpip.NICID
+	opts  CaptureOptions
+
+	// filter is valid if opts.Filter is set.
+	filter bpf.Program
+
+	mu sync.Mutex
+
+	// file is the index of the file being written to, size is its size,
+	// and used is the number of files written to so far.
+	file int
+	size int64
+	used int
+
+	// packets is the number of packets saved.
+	packets uint64
+
+	// err is the error which stopped the writes to the files, if any.
+	err error
+}
+
+var _ stack.PacketCapture = (*Capture)(nil)
+
+// StartCapture starts saving the packets going through the NIC nicID of s.
+func StartCapture(s *stack.Stack, nicID tcpip.NICID, opts CaptureOptions) (*Capture, error) {
+	if len(opts.Files) == 0 {
+		return nil, fmt.Errorf("no capture file")
+	}
+	if opts.SnapLen == 0 {
+		opts.SnapLen = DefaultSnapLen
+	}
+	if opts.Direction == 0 {
+		opts.Direction = stack.CaptureBoth
+	}
+	c := &Capture{
+		stack: s,
+		nicID: nicID,
+		opts:  opts,
+	}
+	if opts.Filter != nil {
+		filter, err := bpf.Compile(opts.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter: %w", err)
+		}
+		c.filter = filter
+	}
+	if err := c.startFile(0); err != nil {
+		return nil, err
+	}
+	if err := s.AddPacketCapture(nicID, c); err != nil {
+		return nil, fmt.Errorf("AddPacketCapture(%d, _): %s", nicID, err)
+	}
+	return c, nil
+}
+
+// Stop stops the capture. It returns the error which stopped the writes to
+// the files, if any.
+func (c *Capture) Stop() error {
+	if err := c.stack.RemovePacketCapture(c.nicID, c); err != nil && err != tcpip.ErrUnknownNICID {
+		return fmt.Errorf("RemovePacketCapture(%d, _): %s", c.nicID, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Packets returns the number of packets saved.
+func (c *Capture) Packets() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.packets
+}
+
+// Capture implements stack.PacketCapture.Capture.
+func (c *Capture) Capture(_ tcpip.NICID, dir stack.CaptureDirection, _ tcpip.NetworkProtocolNumber, packet buffer.VectorisedView) {
+	if c.opts.Direction&dir == 0 {
+		return
+	}
+	length := uint32(packet.Size())
+	if length > c.opts.SnapLen {
+		length = c.opts.SnapLen
+	}
+	if c.opts.Filter != nil {
+		ret, err := bpf.Exec(c.filter, bpf.InputBytes{Data: packet.ToView(), Order: binary.BigEndian})
+		if err != nil || ret == 0 {
+			return
+		}
+		if length > ret {
+			length = ret
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	if c.err = c.write(packet, length); c.err == nil {
+		c.packets++
+	}
+}
+
+// write saves the first length bytes of packet.
+//
+// Precondition: c.mu must be held.
+func (c *Capture) write(packet buffer.VectorisedView, length uint32) error {
+	recordLen := int64(pcapPacketHeaderLen + length)
+	if max := c.opts.MaxFileSize; max > 0 && c.size > pcapHeaderLen && c.size+recordLen > max {
+		if err := c.startFile((c.file + 1) % len(c.opts.Files)); err != nil {
+			return err
+		}
+	}
+
+	w := c.opts.Files[c.file]
+	if err := binary.Write(w, binary.BigEndian, newPCAPPacketHeader(length, uint32(packet.Size()))); err != nil {
+		return err
+	}
+	remaining := int(length)
+	for _, v := range packet.Views() {
+		if remaining == 0 {
+			break
+		}
+		if len(v) > remaining {
+			v = v[:remaining]
+		}
+		if _, err := w.Write(v); err != nil {
+			return err
+		}
+		remaining -= len(v)
+	}
+	c.size += recordLen
+	return nil
+}
+
+// startFile starts writing to the file i, truncating it if it was written to
+// before.
+func (c *Capture) startFile(i int) error {
+	f := c.opts.Files[i]
+	if i < c.used {
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	} else {
+		c.used = i + 1
+	}
+	if err := writePCAPHeader(f, c.opts.SnapLen); err != nil {
+		return err
+	}
+	c.file = i
+	c.size = pcapHeaderLen
+	return nil
+}
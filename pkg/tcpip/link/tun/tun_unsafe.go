@@ -34,6 +34,84 @@ func OpenTAP(name string) (int, error) {
 	return open(name, syscall.IFF_TAP|syscall.IFF_NO_PI)
 }
 
+// These constants are declared in linux/if_tun.h.
+const (
+	_IFF_MULTI_QUEUE = 0x0100
+
+	_TUN_F_CSUM = 0x01
+	_TUN_F_TSO4 = 0x02
+	_TUN_F_TSO6 = 0x04
+)
+
+// virtioNetHdrSize is the size of the virtio_net_hdr structure declared in
+// linux/virtio_net.h.
+const virtioNetHdrSize = 10
+
+// QueueOptions specify how the queues of a multiqueue device are opened.
+type QueueOptions struct {
+	// Queues is the number of queues to open.
+	Queues int
+
+	// TAP if true, indicates that the device is a TAP device instead of a
+	// TUN device.
+	TAP bool
+
+	// VirtioNetHeader if true, indicates that each packet read from or
+	// written to the queues is preceded by a virtio-net header, and enables
+	// the checksum and TCP segmentation offloads of the device.
+	VirtioNetHeader bool
+}
+
+// OpenQueues opens opts.Queues queues of the specified multiqueue TUN or TAP
+// device, sets them to non-blocking mode, and returns their file descriptors.
+//
+// The host kernel steers the packets of each flow to a single queue, so the
+// file descriptors can be passed to fdbased.New to read them in parallel.
+func OpenQueues(name string, opts QueueOptions) ([]int, error) {
+	if opts.Queues <= 0 {
+		return nil, syscall.EINVAL
+	}
+	flags := uint16(syscall.IFF_TUN | syscall.IFF_NO_PI | _IFF_MULTI_QUEUE)
+	if opts.TAP {
+		flags = syscall.IFF_TAP | syscall.IFF_NO_PI | _IFF_MULTI_QUEUE
+	}
+	if opts.VirtioNetHeader {
+		flags |= syscall.IFF_VNET_HDR
+	}
+
+	fds := make([]int, 0, opts.Queues)
+	for i := 0; i < opts.Queues; i++ {
+		fd, err := open(name, flags)
+		if err == nil && opts.VirtioNetHeader {
+			err = setVirtioNetHeader(fd)
+		}
+		if err != nil {
+			if fd >= 0 {
+				syscall.Close(fd)
+			}
+			for _, fd := range fds {
+				syscall.Close(fd)
+			}
+			return nil, err
+		}
+		fds = append(fds, fd)
+	}
+	return fds, nil
+}
+
+// setVirtioNetHeader sets the size of the virtio-net header of the queue fd,
+// and negotiates the offloads which the header allows.
+func setVirtioNetHeader(fd int) error {
+	hdrSize := int32(virtioNetHdrSize)
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TUNSETVNETHDRSZ, uintptr(unsafe.Pointer(&hdrSize))); errno != 0 {
+		return errno
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TUNSETOFFLOAD, _TUN_F_CSUM|_TUN_F_TSO4|_TUN_F_TSO6); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
 func open(name string, flags uint16) (int, error) {
 	fd, err := syscall.Open("/dev/net/tun", syscall.O_RDWR, 0)
 	if err != nil {
@@ -0,0 +1,145 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tunnel
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// GREOptions holds the GRE-specific configuration of a tunnel, as per RFC 2784
+// and RFC 2890.
+type GREOptions struct {
+	// InputKey is the key received packets must carry, if HasInputKey is
+	// true. Otherwise, received packets must not carry a key.
+	InputKey    uint32
+	HasInputKey bool
+
+	// OutputKey is the key carried by sent packets, if HasOutputKey is true.
+	OutputKey    uint32
+	HasOutputKey bool
+
+	// InputChecksum indicates that received packets must carry a checksum.
+	// Checksums are always verified when present.
+	InputChecksum bool
+
+	// OutputChecksum indicates that sent packets carry a checksum.
+	OutputChecksum bool
+}
+
+// NewGREProtocol returns a transport protocol that delivers GRE packets to GRE
+// tunnels.
+func NewGREProtocol(s *stack.Stack) stack.TransportProtocol {
+	return newProtocol(s, header.GREProtocolNumber, parseGREID)
+}
+
+// NewGRE returns a tunnel that encapsulates IPv4 and IPv6 packets in GRE. The
+// outer network protocol is that of opts.Remote, like Linux's gre and ip6gre
+// devices.
+//
+// The stack must have been created with NewGREProtocol.
+func NewGRE(s *stack.Stack, opts Options, greOpts GREOptions) (*Endpoint, *tcpip.Error) {
+	return newEndpoint(s, header.GREProtocolNumber, &greEncapsulation{opts: greOpts}, opts)
+}
+
+// parseGREID returns the key carried by a GRE packet.
+func parseGREID(payload buffer.View) (tunnelID, bool) {
+	gre := header.GRE(payload)
+	if !gre.IsValid() {
+		return tunnelID{}, false
+	}
+	if gre.Flags()&header.GREFlagKey == 0 {
+		return tunnelID{}, true
+	}
+	return tunnelID{value: gre.Key(), present: true}, true
+}
+
+// greEncapsulation implements encapsulation for GRE tunnels.
+type greEncapsulation struct {
+	opts GREOptions
+}
+
+func (*greEncapsulation) supports(proto tcpip.NetworkProtocolNumber) bool {
+	return proto == header.IPv4ProtocolNumber || proto == header.IPv6ProtocolNumber
+}
+
+func (*greEncapsulation) hardwareType(outerProto tcpip.NetworkProtocolNumber) header.ARPHardwareType {
+	if outerProto == header.IPv6ProtocolNumber {
+		return header.ARPHardwareIP6GRE
+	}
+	return header.ARPHardwareIPGRE
+}
+
+func (g *greEncapsulation) fields(proto tcpip.NetworkProtocolNumber) header.GREFields {
+	return header.GREFields{
+		Checksum:   g.opts.OutputChecksum,
+		Key:        g.opts.OutputKey,
+		KeyPresent: g.opts.HasOutputKey,
+		Protocol:   proto,
+	}
+}
+
+func (g *greEncapsulation) headerLength() int {
+	f := g.fields(0)
+	return header.GRESize(&f)
+}
+
+func (g *greEncapsulation) encapsulate(hdr buffer.View, proto tcpip.NetworkProtocolNumber, payload buffer.VectorisedView) {
+	f := g.fields(proto)
+	gre := header.GRE(hdr)
+	gre.Encode(&f)
+	if f.Checksum {
+		gre.SetChecksum(^header.ChecksumVV(payload, header.Checksum(hdr, 0)))
+	}
+}
+
+func (g *greEncapsulation) inputID() tunnelID {
+	return tunnelID{value: g.opts.InputKey, present: g.opts.HasInputKey}
+}
+
+// decapsulate implements encapsulation.
+//
+// The key is checked by the protocol's demultiplexing.
+func (g *greEncapsulation) decapsulate(_ tcpip.Address, payload buffer.View) (tcpip.NetworkProtocolNumber, buffer.View, bool) {
+	gre := header.GRE(payload)
+	if !gre.IsValid() {
+		return 0, nil, false
+	}
+	if gre.Flags()&header.GREFlagChecksum != 0 {
+		if header.Checksum(payload, 0) != 0xffff {
+			return 0, nil, false
+		}
+	} else if g.opts.InputChecksum {
+		return 0, nil, false
+	}
+
+	inner := payload[gre.HeaderLength():]
+	switch proto := gre.Protocol(); proto {
+	case header.IPv4ProtocolNumber:
+		if len(inner) < header.IPv4MinimumSize || !header.IPv4(inner).IsValid(len(inner)) {
+			return 0, nil, false
+		}
+		return proto, inner[:header.IPv4(inner).TotalLength()], true
+	case header.IPv6ProtocolNumber:
+		if len(inner) < header.IPv6MinimumSize || !header.IPv6(inner).IsValid(len(inner)) {
+			return 0, nil, false
+		}
+		return proto, inner[:header.IPv6MinimumSize+int(header.IPv6(inner).PayloadLength())], true
+	default:
+		return 0, nil, false
+	}
+}
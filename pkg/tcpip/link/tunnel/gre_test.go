@@ -0,0 +1,181 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tunnel
+
+import (
+	"bytes"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/encaptest"
+	"gvisor.dev/gvisor/pkg/tcpip/link/pipe"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	innerV4Addr1 = tcpip.Address("\xc0\xa8\x00\x01")
+	innerV4Addr2 = tcpip.Address("\xc0\xa8\x00\x02")
+)
+
+func addGRE(t *testing.T, s *stack.Stack, local, remote, innerAddr, innerV4Addr tcpip.Address, greOpts GREOptions) *Endpoint {
+	t.Helper()
+
+	ep, err := NewGRE(s, Options{Local: local, Remote: remote, MTU: header.IPv6MinimumMTU}, greOpts)
+	if err != nil {
+		t.Fatalf("NewGRE(_, _, %+v): %s", greOpts, err)
+	}
+	if err := s.CreateNIC(tunnelNICID, ep); err != nil {
+		t.Fatalf("s.CreateNIC(%d, _): %s", tunnelNICID, err)
+	}
+	if err := s.AddAddress(tunnelNICID, ipv6.ProtocolNumber, innerAddr); err != nil {
+		t.Fatalf("s.AddAddress(%d, %d, %s): %s", tunnelNICID, ipv6.ProtocolNumber, innerAddr, err)
+	}
+	if err := s.AddAddress(tunnelNICID, ipv4.ProtocolNumber, innerV4Addr); err != nil {
+		t.Fatalf("s.AddAddress(%d, %d, %s): %s", tunnelNICID, ipv4.ProtocolNumber, innerV4Addr, err)
+	}
+	// The inner IPv4 route must take precedence over the uplink's default
+	// route.
+	s.SetRouteTable(append([]tcpip.Route{{
+		Destination: tcpip.AddressWithPrefix{Address: innerV4Addr, PrefixLen: 24}.Subnet(),
+		NIC:         tunnelNICID,
+	}}, s.GetRouteTable()...))
+	s.AddRoute(tcpip.Route{Destination: header.IPv6EmptySubnet, NIC: tunnelNICID})
+	return ep
+}
+
+func TestGRE(t *testing.T) {
+	tests := []struct {
+		name          string
+		local, remote tcpip.Address
+		greOpts1      GREOptions
+		greOpts2      GREOptions
+		wantHWType    header.ARPHardwareType
+	}{
+		{
+			name:       "IPv4 outer",
+			local:      outerAddr1,
+			remote:     outerAddr2,
+			wantHWType: header.ARPHardwareIPGRE,
+		},
+		{
+			name:       "IPv6 outer",
+			local:      outerV6Addr1,
+			remote:     outerV6Addr2,
+			wantHWType: header.ARPHardwareIP6GRE,
+		},
+		{
+			name:       "Keys",
+			local:      outerAddr1,
+			remote:     outerAddr2,
+			greOpts1:   GREOptions{InputKey: 2, HasInputKey: true, OutputKey: 1, HasOutputKey: true},
+			greOpts2:   GREOptions{InputKey: 1, HasInputKey: true, OutputKey: 2, HasOutputKey: true},
+			wantHWType: header.ARPHardwareIPGRE,
+		},
+		{
+			name:       "Checksums",
+			local:      outerV6Addr1,
+			remote:     outerV6Addr2,
+			greOpts1:   GREOptions{InputChecksum: true, OutputChecksum: true},
+			greOpts2:   GREOptions{InputChecksum: true, OutputChecksum: true},
+			wantHWType: header.ARPHardwareIP6GRE,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ep1, ep2 := pipe.New(linkAddr1, linkAddr2)
+			s1 := newTestStack(t, ep1, outerAddr1, outerV6Addr1)
+			s2 := newTestStack(t, ep2, outerAddr2, outerV6Addr2)
+			gre1 := addGRE(t, s1, test.local, test.remote, innerAddr1, innerV4Addr1, test.greOpts1)
+			gre2 := addGRE(t, s2, test.remote, test.local, innerAddr2, innerV4Addr2, test.greOpts2)
+
+			if got := gre1.ARPHardwareType(); got != test.wantHWType {
+				t.Errorf("got gre1.ARPHardwareType() = %d, want = %d", got, test.wantHWType)
+			}
+
+			// Send more than fits in a single outer packet.
+			data := bytes.Repeat([]byte("hello"), 250)
+			encaptest.CheckUDP(t, s1, s2, ipv6.ProtocolNumber, innerAddr1, innerAddr2, data)
+			encaptest.CheckUDP(t, s2, s1, ipv6.ProtocolNumber, innerAddr2, innerAddr1, data)
+			encaptest.CheckUDP(t, s1, s2, ipv4.ProtocolNumber, innerV4Addr1, innerV4Addr2, data)
+
+			if got := gre2.Stats().DecapsulationErrors.Value(); got != 0 {
+				t.Errorf("got gre2.Stats().DecapsulationErrors.Value() = %d, want = 0", got)
+			}
+		})
+	}
+}
+
+func TestGREDrops(t *testing.T) {
+	tests := []struct {
+		name                    string
+		greOpts1                GREOptions
+		greOpts2                GREOptions
+		wantDecapsulationErrors uint64
+		wantUnknownProtocol     uint64
+	}{
+		{
+			name:                "Key mismatch",
+			greOpts1:            GREOptions{OutputKey: 1, HasOutputKey: true},
+			greOpts2:            GREOptions{InputKey: 2, HasInputKey: true},
+			wantUnknownProtocol: 1,
+		},
+		{
+			name:                "Missing key",
+			greOpts2:            GREOptions{InputKey: 2, HasInputKey: true},
+			wantUnknownProtocol: 1,
+		},
+		{
+			name:                    "Missing checksum",
+			greOpts2:                GREOptions{InputChecksum: true},
+			wantDecapsulationErrors: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ep1, ep2 := pipe.New(linkAddr1, linkAddr2)
+			s1 := newTestStack(t, ep1, outerAddr1, "")
+			s2 := newTestStack(t, ep2, outerAddr2, "")
+			addGRE(t, s1, outerAddr1, outerAddr2, innerAddr1, innerV4Addr1, test.greOpts1)
+			gre2 := addGRE(t, s2, outerAddr2, outerAddr1, innerAddr2, innerV4Addr2, test.greOpts2)
+
+			conn1, err := gonet.DialUDP(s1, nil, &tcpip.FullAddress{Addr: innerAddr2, Port: 1234}, ipv6.ProtocolNumber)
+			if err != nil {
+				t.Fatalf("gonet.DialUDP(_, nil, _, %d): %s", ipv6.ProtocolNumber, err)
+			}
+			defer conn1.Close()
+			if _, err := conn1.Write([]byte("hello")); err != nil {
+				t.Fatalf("conn1.Write(_): %s", err)
+			}
+
+			if got := s2.Stats().UDP.PacketsReceived.Value(); got != 0 {
+				t.Errorf("got s2.Stats().UDP.PacketsReceived.Value() = %d, want = 0", got)
+			}
+			if got := gre2.Stats().DecapsulationErrors.Value(); got != test.wantDecapsulationErrors {
+				t.Errorf("got gre2.Stats().DecapsulationErrors.Value() = %d, want = %d", got, test.wantDecapsulationErrors)
+			}
+			// Packets that do not match a tunnel are answered with an ICMP
+			// protocol unreachable, like Linux.
+			if got := s1.Stats().ICMP.V4.PacketsReceived.DstUnreachable.Value(); got != test.wantUnknownProtocol {
+				t.Errorf("got s1.Stats().ICMP.V4.PacketsReceived.DstUnreachable.Value() = %d, want = %d", got, test.wantUnknownProtocol)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tunnel
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// IPIPProtocolNumber is the IPv4 protocol number of IPv4 packets encapsulated
+// in IPv4, as per RFC 2003 section 3.1.
+const IPIPProtocolNumber tcpip.TransportProtocolNumber = 4
+
+// NewIPIPProtocol returns a transport protocol that delivers IPv4 packets
+// encapsulated in IPv4 to IPIP tunnels.
+func NewIPIPProtocol(s *stack.Stack) stack.TransportProtocol {
+	return newProtocol(s, IPIPProtocolNumber, nil /* parseID */)
+}
+
+// NewIPIP returns a tunnel that encapsulates IPv4 packets in IPv4, as per RFC
+// 2003, like Linux's ipip devices. opts.Remote must be an IPv4 address.
+//
+// The stack must have been created with NewIPIPProtocol.
+func NewIPIP(s *stack.Stack, opts Options) (*Endpoint, *tcpip.Error) {
+	if len(opts.Remote) != header.IPv4AddressSize {
+		return nil, tcpip.ErrBadAddress
+	}
+	return newEndpoint(s, IPIPProtocolNumber, ipipEncapsulation{}, opts)
+}
+
+// ipipEncapsulation implements encapsulation for IPIP tunnels.
+type ipipEncapsulation struct{}
+
+func (ipipEncapsulation) supports(proto tcpip.NetworkProtocolNumber) bool {
+	return proto == header.IPv4ProtocolNumber
+}
+
+func (ipipEncapsulation) hardwareType(tcpip.NetworkProtocolNumber) header.ARPHardwareType {
+	return header.ARPHardwareTunnel
+}
+
+func (ipipEncapsulation) headerLength() int {
+	return 0
+}
+
+func (ipipEncapsulation) encapsulate(buffer.View, tcpip.NetworkProtocolNumber, buffer.VectorisedView) {
+	// The outer IP header is the only header.
+}
+
+func (ipipEncapsulation) inputID() tunnelID {
+	return tunnelID{}
+}
+
+// decapsulate implements encapsulation.
+//
+// The outer source is checked against the tunnel's remote address by the
+// protocol's demultiplexing. Like the martian sources Linux drops, packets
+// from loopback, multicast and broadcast sources are dropped so that the
+// tunnel can't be used to spoof them.
+func (ipipEncapsulation) decapsulate(_ tcpip.Address, payload buffer.View) (tcpip.NetworkProtocolNumber, buffer.View, bool) {
+	if len(payload) < header.IPv4MinimumSize {
+		return 0, nil, false
+	}
+	ip := header.IPv4(payload)
+	if !ip.IsValid(len(payload)) {
+		return 0, nil, false
+	}
+
+	innerSrc := ip.SourceAddress()
+	if header.IsV4LoopbackAddress(innerSrc) || header.IsV4MulticastAddress(innerSrc) || innerSrc == header.IPv4Broadcast {
+		return 0, nil, false
+	}
+	return header.IPv4ProtocolNumber, payload[:ip.TotalLength()], true
+}
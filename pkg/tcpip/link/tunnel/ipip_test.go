@@ -0,0 +1,221 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tunnel
+
+import (
+	"bytes"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/link/encaptest"
+	"gvisor.dev/gvisor/pkg/tcpip/link/pipe"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+func addIPIP(t *testing.T, s *stack.Stack, opts Options, innerAddr tcpip.Address) *Endpoint {
+	t.Helper()
+
+	opts.MTU = header.IPv6MinimumMTU
+	ep, err := NewIPIP(s, opts)
+	if err != nil {
+		t.Fatalf("NewIPIP(_, %+v): %s", opts, err)
+	}
+	if err := s.CreateNIC(tunnelNICID, ep); err != nil {
+		t.Fatalf("s.CreateNIC(%d, _): %s", tunnelNICID, err)
+	}
+	if err := s.AddAddress(tunnelNICID, ipv4.ProtocolNumber, innerAddr); err != nil {
+		t.Fatalf("s.AddAddress(%d, %d, %s): %s", tunnelNICID, ipv4.ProtocolNumber, innerAddr, err)
+	}
+	// The inner route must take precedence over the uplink's default route.
+	s.SetRouteTable(append([]tcpip.Route{{
+		Destination: tcpip.AddressWithPrefix{Address: innerAddr, PrefixLen: 24}.Subnet(),
+		NIC:         tunnelNICID,
+	}}, s.GetRouteTable()...))
+	return ep
+}
+
+func TestIPIP(t *testing.T) {
+	ep1, ep2 := pipe.New(linkAddr1, linkAddr2)
+	s1 := newTestStack(t, ep1, outerAddr1, "")
+	s2 := newTestStack(t, ep2, outerAddr2, "")
+	ipip1 := addIPIP(t, s1, Options{Local: outerAddr1, Remote: outerAddr2}, innerV4Addr1)
+	ipip2 := addIPIP(t, s2, Options{Local: outerAddr2, Remote: outerAddr1}, innerV4Addr2)
+
+	if got, want := ipip1.ARPHardwareType(), header.ARPHardwareTunnel; got != want {
+		t.Errorf("got ipip1.ARPHardwareType() = %d, want = %d", got, want)
+	}
+
+	// Send more than fits in a single outer packet.
+	data := bytes.Repeat([]byte("hello"), 250)
+	encaptest.CheckUDP(t, s1, s2, ipv4.ProtocolNumber, innerV4Addr1, innerV4Addr2, data)
+	encaptest.CheckUDP(t, s2, s1, ipv4.ProtocolNumber, innerV4Addr2, innerV4Addr1, data)
+
+	for i, ep := range []*Endpoint{ipip1, ipip2} {
+		if got := ep.Stats().DecapsulationErrors.Value(); got != 0 {
+			t.Errorf("got ipip%d.Stats().DecapsulationErrors.Value() = %d, want = 0", i+1, got)
+		}
+	}
+}
+
+func TestNewIPIP(t *testing.T) {
+	ep1, _ := pipe.New(linkAddr1, linkAddr2)
+	s := newTestStack(t, ep1, outerAddr1, "")
+	if _, err := NewIPIP(s, Options{Remote: outerV6Addr2}); err != tcpip.ErrBadAddress {
+		t.Errorf("got NewIPIP(_, _) with IPv6 remote = %v, want = %s", err, tcpip.ErrBadAddress)
+	}
+	ep, err := NewIPIP(s, Options{Remote: outerAddr3})
+	if err != nil {
+		t.Fatalf("NewIPIP(_, _): %s", err)
+	}
+	if got, want := ep.MTU(), uint32(header.IPv6MinimumMTU-header.IPv4MinimumSize); got != want {
+		t.Errorf("got ep.MTU() = %d, want = %d", got, want)
+	}
+}
+
+func TestIPIPTTLAndTOS(t *testing.T) {
+	const (
+		innerTTL = 7
+		innerTOS = 0x20
+	)
+
+	tests := []struct {
+		name    string
+		opts    Options
+		wantTTL uint8
+		wantTOS uint8
+	}{
+		{
+			name:    "inherited",
+			opts:    Options{InheritTOS: true},
+			wantTTL: innerTTL,
+			wantTOS: innerTOS,
+		},
+		{
+			name:    "fixed",
+			opts:    Options{TTL: 64, TOS: 0x10},
+			wantTTL: 64,
+			wantTOS: 0x10,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			uplink := channel.New(1, header.IPv6MinimumMTU, linkAddr1)
+			s := newTestStack(t, uplink, outerAddr1, "")
+			opts := test.opts
+			opts.Local, opts.Remote = outerAddr1, outerAddr2
+			addIPIP(t, s, opts, innerV4Addr1)
+
+			var wq waiter.Queue
+			ep, err := s.NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+			if err != nil {
+				t.Fatalf("s.NewEndpoint(%d, %d, _): %s", udp.ProtocolNumber, ipv4.ProtocolNumber, err)
+			}
+			defer ep.Close()
+			if err := ep.SetSockOptInt(tcpip.TTLOption, innerTTL); err != nil {
+				t.Fatalf("ep.SetSockOptInt(%d, %d): %s", tcpip.TTLOption, innerTTL, err)
+			}
+			if err := ep.SetSockOptInt(tcpip.IPv4TOSOption, innerTOS); err != nil {
+				t.Fatalf("ep.SetSockOptInt(%d, %d): %s", tcpip.IPv4TOSOption, innerTOS, err)
+			}
+			to := tcpip.FullAddress{Addr: innerV4Addr2, Port: 1234}
+			if _, _, err := ep.Write(tcpip.SlicePayload("hello"), tcpip.WriteOptions{To: &to}); err != nil {
+				t.Fatalf("ep.Write(_, _): %s", err)
+			}
+
+			p, ok := uplink.Read()
+			if !ok {
+				t.Fatal("no packet sent on the uplink")
+			}
+			vv := buffer.NewVectorisedView(p.Pkt.Size(), p.Pkt.Views())
+			outer := header.IPv4(vv.ToView())
+			if got := outer.Protocol(); got != uint8(IPIPProtocolNumber) {
+				t.Errorf("got outer.Protocol() = %d, want = %d", got, IPIPProtocolNumber)
+			}
+			if got := outer.TTL(); got != test.wantTTL {
+				t.Errorf("got outer.TTL() = %d, want = %d", got, test.wantTTL)
+			}
+			if got, _ := outer.TOS(); got != test.wantTOS {
+				t.Errorf("got outer.TOS() = %#x, want = %#x", got, test.wantTOS)
+			}
+			if got := header.IPv4(outer.Payload()).SourceAddress(); got != innerV4Addr1 {
+				t.Errorf("got inner source = %s, want = %s", got, innerV4Addr1)
+			}
+		})
+	}
+}
+
+func TestIPIPDecapsulate(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    tcpip.Address
+		wantOK bool
+	}{
+		{
+			name:   "unicast",
+			src:    innerV4Addr1,
+			wantOK: true,
+		},
+		{
+			name:   "loopback",
+			src:    "\x7f\x00\x00\x01",
+			wantOK: false,
+		},
+		{
+			name:   "multicast",
+			src:    header.IPv4AllSystems,
+			wantOK: false,
+		},
+		{
+			name:   "broadcast",
+			src:    header.IPv4Broadcast,
+			wantOK: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v := make(buffer.View, header.IPv4MinimumSize)
+			header.IPv4(v).Encode(&header.IPv4Fields{
+				TotalLength: header.IPv4MinimumSize,
+				Protocol:    uint8(header.UDPProtocolNumber),
+				TTL:         64,
+				SrcAddr:     test.src,
+				DstAddr:     innerV4Addr2,
+			})
+			// Trailing bytes are not part of the encapsulated packet.
+			v = append(v, 0, 0)
+			proto, inner, ok := ipipEncapsulation{}.decapsulate(outerAddr2, v)
+			if ok != test.wantOK {
+				t.Fatalf("got decapsulate(%s, _) = (_, _, %t), want = (_, _, %t)", outerAddr2, ok, test.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if proto != header.IPv4ProtocolNumber {
+				t.Errorf("got proto = %d, want = %d", proto, header.IPv4ProtocolNumber)
+			}
+			if len(inner) != header.IPv4MinimumSize {
+				t.Errorf("got len(inner) = %d, want = %d", len(inner), header.IPv4MinimumSize)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tunnel
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// SITProtocolNumber is the IPv4 protocol number of IPv6 packets encapsulated
+// in IPv4, as per RFC 4213 section 3.5.
+const SITProtocolNumber tcpip.TransportProtocolNumber = 41
+
+// NewSITProtocol returns a transport protocol that delivers IPv6 packets
+// encapsulated in IPv4 to SIT tunnels.
+func NewSITProtocol(s *stack.Stack) stack.TransportProtocol {
+	return newProtocol(s, SITProtocolNumber, nil /* parseID */)
+}
+
+// NewSIT returns a configured tunnel that encapsulates IPv6 packets in IPv4,
+// as per RFC 4213 section 3. opts.Remote must be an IPv4 address.
+//
+// The stack must have been created with NewSITProtocol.
+func NewSIT(s *stack.Stack, opts Options) (*Endpoint, *tcpip.Error) {
+	if len(opts.Remote) != header.IPv4AddressSize {
+		return nil, tcpip.ErrBadAddress
+	}
+	return newEndpoint(s, SITProtocolNumber, sitEncapsulation{}, opts)
+}
+
+// sixToFourPrefix is the 6to4 prefix (2002::/16), as per RFC 3056.
+const sixToFourPrefix = "\x20\x02"
+
+// sitEncapsulation implements encapsulation for SIT tunnels.
+type sitEncapsulation struct{}
+
+func (sitEncapsulation) supports(proto tcpip.NetworkProtocolNumber) bool {
+	return proto == header.IPv6ProtocolNumber
+}
+
+func (sitEncapsulation) hardwareType(tcpip.NetworkProtocolNumber) header.ARPHardwareType {
+	return header.ARPHardwareSIT
+}
+
+func (sitEncapsulation) headerLength() int {
+	return 0
+}
+
+func (sitEncapsulation) encapsulate(buffer.View, tcpip.NetworkProtocolNumber, buffer.VectorisedView) {
+	// The outer IP header is the only header.
+}
+
+func (sitEncapsulation) inputID() tunnelID {
+	return tunnelID{}
+}
+
+// decapsulate implements encapsulation.
+//
+// As per RFC 4213 section 3.6, the outer source is checked against the
+// tunnel's remote address by the protocol's demultiplexing. The IPv6 source
+// is checked so that the tunnel can't be used to spoof addresses that an
+// IPv4 host can only own if its IPv4 address is the one embedded.
+func (sitEncapsulation) decapsulate(src tcpip.Address, payload buffer.View) (tcpip.NetworkProtocolNumber, buffer.View, bool) {
+	if len(payload) < header.IPv6MinimumSize {
+		return 0, nil, false
+	}
+	ip := header.IPv6(payload)
+	if !ip.IsValid(len(payload)) {
+		return 0, nil, false
+	}
+
+	innerSrc := ip.SourceAddress()
+	switch {
+	case header.IsV6LoopbackAddress(innerSrc), header.IsV6MulticastAddress(innerSrc):
+		return 0, nil, false
+	case header.IsV4MappedAddress(innerSrc):
+		return 0, nil, false
+	case innerSrc[:header.IPv6AddressSize-header.IPv4AddressSize] == header.IPv6Any[:header.IPv6AddressSize-header.IPv4AddressSize] && innerSrc != header.IPv6Any:
+		// IPv4-compatible addresses are deprecated, as per RFC 4291 section
+		// 2.5.5.1.
+		return 0, nil, false
+	case innerSrc[:len(sixToFourPrefix)] == sixToFourPrefix && innerSrc[len(sixToFourPrefix):][:header.IPv4AddressSize] != src:
+		return 0, nil, false
+	}
+	return header.IPv6ProtocolNumber, payload[:header.IPv6MinimumSize+int(ip.PayloadLength())], true
+}
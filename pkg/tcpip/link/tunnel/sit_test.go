@@ -0,0 +1,186 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tunnel
+
+import (
+	"bytes"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/encaptest"
+	"gvisor.dev/gvisor/pkg/tcpip/link/pipe"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+func addSIT(t *testing.T, s *stack.Stack, local, remote, innerAddr tcpip.Address) *Endpoint {
+	t.Helper()
+
+	// The uplink's MTU is the IPv6 minimum MTU, so encapsulated packets may
+	// need to be fragmented.
+	ep, err := NewSIT(s, Options{Local: local, Remote: remote, MTU: header.IPv6MinimumMTU})
+	if err != nil {
+		t.Fatalf("NewSIT(_, _): %s", err)
+	}
+	if err := s.CreateNIC(tunnelNICID, ep); err != nil {
+		t.Fatalf("s.CreateNIC(%d, _): %s", tunnelNICID, err)
+	}
+	if err := s.AddAddress(tunnelNICID, ipv6.ProtocolNumber, innerAddr); err != nil {
+		t.Fatalf("s.AddAddress(%d, %d, %s): %s", tunnelNICID, ipv6.ProtocolNumber, innerAddr, err)
+	}
+	s.AddRoute(tcpip.Route{Destination: header.IPv6EmptySubnet, NIC: tunnelNICID})
+	return ep
+}
+
+func TestSIT(t *testing.T) {
+	ep1, ep2 := pipe.New(linkAddr1, linkAddr2)
+	s1 := newTestStack(t, ep1, outerAddr1, "")
+	s2 := newTestStack(t, ep2, outerAddr2, "")
+	addSIT(t, s1, outerAddr1, outerAddr2, innerAddr1)
+	addSIT(t, s2, outerAddr2, outerAddr1, innerAddr2)
+
+	// Send more than fits in a single outer packet.
+	encaptest.CheckUDP(t, s1, s2, ipv6.ProtocolNumber, innerAddr1, innerAddr2, bytes.Repeat([]byte("hello"), 250))
+}
+
+func TestSITIgnoresOtherRemotes(t *testing.T) {
+	ep1, ep2 := pipe.New(linkAddr1, linkAddr2)
+	s1 := newTestStack(t, ep1, outerAddr1, "")
+	s2 := newTestStack(t, ep2, outerAddr2, "")
+	addSIT(t, s1, outerAddr1, outerAddr2, innerAddr1)
+	// s2's tunnel expects packets from a different remote.
+	sit2 := addSIT(t, s2, outerAddr2, outerAddr3, innerAddr2)
+
+	conn1, err := gonet.DialUDP(s1, nil, &tcpip.FullAddress{Addr: innerAddr2, Port: 1234}, ipv6.ProtocolNumber)
+	if err != nil {
+		t.Fatalf("gonet.DialUDP(_, nil, _, %d): %s", ipv6.ProtocolNumber, err)
+	}
+	defer conn1.Close()
+	if _, err := conn1.Write([]byte("hello")); err != nil {
+		t.Fatalf("conn1.Write(_): %s", err)
+	}
+
+	if got := s2.Stats().UDP.PacketsReceived.Value(); got != 0 {
+		t.Errorf("got s2.Stats().UDP.PacketsReceived.Value() = %d, want = 0", got)
+	}
+	if got := s2.Stats().ICMP.V4.PacketsSent.DstUnreachable.Value(); got != 1 {
+		t.Errorf("got s2.Stats().ICMP.V4.PacketsSent.DstUnreachable.Value() = %d, want = 1", got)
+	}
+	if got := sit2.Stats().DecapsulationErrors.Value(); got != 0 {
+		t.Errorf("got sit2.Stats().DecapsulationErrors.Value() = %d, want = 0", got)
+	}
+}
+
+func TestNewSIT(t *testing.T) {
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+	})
+	if _, err := NewSIT(s, Options{Remote: outerAddr2, MTU: 1280}); err != tcpip.ErrUnknownProtocol {
+		t.Errorf("got NewSIT(_, _) without protocol = %v, want = %s", err, tcpip.ErrUnknownProtocol)
+	}
+
+	ep1, _ := pipe.New(linkAddr1, linkAddr2)
+	s = newTestStack(t, ep1, outerAddr1, "")
+	if _, err := NewSIT(s, Options{Remote: innerAddr2}); err != tcpip.ErrBadAddress {
+		t.Errorf("got NewSIT(_, _) with IPv6 remote = %v, want = %s", err, tcpip.ErrBadAddress)
+	}
+	ep, err := NewSIT(s, Options{Remote: outerAddr3})
+	if err != nil {
+		t.Fatalf("NewSIT(_, _): %s", err)
+	}
+	if got, want := ep.MTU(), uint32(header.IPv6MinimumMTU-header.IPv4MinimumSize); got != want {
+		t.Errorf("got ep.MTU() = %d, want = %d", got, want)
+	}
+
+	addSIT(t, s, outerAddr1, outerAddr2, innerAddr1)
+	if _, err := NewSIT(s, Options{Local: outerAddr1, Remote: outerAddr2}); err != tcpip.ErrDuplicateAddress {
+		t.Errorf("got NewSIT(_, _) for an existing tunnel = %v, want = %s", err, tcpip.ErrDuplicateAddress)
+	}
+}
+
+func TestSITDecapsulate(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    tcpip.Address
+		wantOK bool
+	}{
+		{
+			name:   "global",
+			src:    innerAddr1,
+			wantOK: true,
+		},
+		{
+			name:   "6to4 of outer source",
+			src:    "\x20\x02\x0a\x00\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01",
+			wantOK: true,
+		},
+		{
+			name:   "6to4 of other address",
+			src:    "\x20\x02\x0a\x00\x00\x03\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01",
+			wantOK: false,
+		},
+		{
+			name:   "IPv4-mapped",
+			src:    "\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\xff\xff\x0a\x00\x00\x02",
+			wantOK: false,
+		},
+		{
+			name:   "IPv4-compatible",
+			src:    "\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x0a\x00\x00\x02",
+			wantOK: false,
+		},
+		{
+			name:   "loopback",
+			src:    header.IPv6Loopback,
+			wantOK: false,
+		},
+		{
+			name:   "multicast",
+			src:    header.IPv6AllNodesMulticastAddress,
+			wantOK: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v := make(buffer.View, header.IPv6MinimumSize)
+			header.IPv6(v).Encode(&header.IPv6Fields{
+				NextHeader: uint8(header.UDPProtocolNumber),
+				HopLimit:   64,
+				SrcAddr:    test.src,
+				DstAddr:    innerAddr2,
+			})
+			// Trailing bytes are not part of the encapsulated packet.
+			v = append(v, 0, 0)
+			proto, inner, ok := sitEncapsulation{}.decapsulate(outerAddr2, v)
+			if ok != test.wantOK {
+				t.Fatalf("got decapsulate(%s, _) = (_, _, %t), want = (_, _, %t)", outerAddr2, ok, test.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if proto != header.IPv6ProtocolNumber {
+				t.Errorf("got proto = %d, want = %d", proto, header.IPv6ProtocolNumber)
+			}
+			if len(inner) != header.IPv6MinimumSize {
+				t.Errorf("got len(inner) = %d, want = %d", len(inner), header.IPv6MinimumSize)
+			}
+		})
+	}
+}
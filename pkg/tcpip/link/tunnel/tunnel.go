@@ -0,0 +1,457 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tunnel provides link endpoints that encapsulate packets in IP
+// packets sent to a remote tunnel endpoint, and decapsulate the packets
+// received from it.
+//
+// A tunnel needs the transport protocol of its encapsulation to be
+// registered with the stack (e.g. NewSITProtocol) so that encapsulated
+// packets received by the stack are delivered to it. Tunnel endpoints can
+// then be created (e.g. NewSIT) and passed to Stack.CreateNIC.
+package tunnel
+
+import (
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// Options holds the configuration of a tunnel.
+type Options struct {
+	// Local is the local address of the tunnel, used as the source of
+	// encapsulated packets.
+	//
+	// If empty, the source is selected by the stack and packets received on
+	// any local address are accepted.
+	Local tcpip.Address
+
+	// Remote is the address of the remote tunnel endpoint.
+	Remote tcpip.Address
+
+	// MTU is the MTU of the tunnel.
+	//
+	// If zero, it is derived from the MTU of the route to Remote when the
+	// tunnel is created.
+	MTU uint32
+
+	// TTL is the TTL (or Hop Limit) of encapsulated packets.
+	//
+	// If zero, it is inherited from the encapsulated packet.
+	TTL uint8
+
+	// TOS is the TOS (or Traffic Class) of encapsulated packets, unless
+	// InheritTOS is set.
+	TOS uint8
+
+	// InheritTOS indicates that the TOS of encapsulated packets is inherited
+	// from the encapsulated packet.
+	InheritTOS bool
+}
+
+// encapsulation is the part of a tunnel that is specific to the protocol
+// used to encapsulate packets.
+type encapsulation interface {
+	// supports returns true if packets of the network protocol can be
+	// encapsulated.
+	supports(proto tcpip.NetworkProtocolNumber) bool
+
+	// hardwareType returns the hardware type of a tunnel whose packets are
+	// sent with the outer network protocol.
+	hardwareType(outerProto tcpip.NetworkProtocolNumber) header.ARPHardwareType
+
+	// headerLength returns the size of the encapsulation header between the
+	// outer IP header and the encapsulated packet.
+	headerLength() int
+
+	// encapsulate writes the encapsulation header of a packet of the network
+	// protocol in to hdr.
+	encapsulate(hdr buffer.View, proto tcpip.NetworkProtocolNumber, payload buffer.VectorisedView)
+
+	// inputID returns the identifier received packets must carry to be
+	// delivered to the tunnel.
+	inputID() tunnelID
+
+	// decapsulate returns the network protocol and contents of the packet
+	// encapsulated in payload, which was received from src.
+	//
+	// Returns false if the packet must be dropped.
+	decapsulate(src tcpip.Address, payload buffer.View) (tcpip.NetworkProtocolNumber, buffer.View, bool)
+}
+
+// tunnelID distinguishes tunnels that share local and remote addresses, such
+// as GRE tunnels with different keys.
+type tunnelID struct {
+	value   uint32
+	present bool
+}
+
+// tunnelKey identifies the tunnel a received packet is destined to.
+type tunnelKey struct {
+	local  tcpip.Address
+	remote tcpip.Address
+	id     tunnelID
+}
+
+// protocol is a transport protocol that delivers encapsulated packets to the
+// tunnel they are destined to.
+type protocol struct {
+	stack  *stack.Stack
+	number tcpip.TransportProtocolNumber
+
+	// parseID returns the tunnel identifier carried by a received packet, or
+	// false if the packet is malformed. It is nil if the protocol does not
+	// carry identifiers.
+	parseID func(payload buffer.View) (tunnelID, bool)
+
+	mu      sync.RWMutex
+	tunnels map[tunnelKey]*Endpoint
+}
+
+var _ stack.TransportProtocol = (*protocol)(nil)
+
+func newProtocol(s *stack.Stack, number tcpip.TransportProtocolNumber, parseID func(buffer.View) (tunnelID, bool)) *protocol {
+	return &protocol{
+		stack:   s,
+		number:  number,
+		parseID: parseID,
+		tunnels: make(map[tunnelKey]*Endpoint),
+	}
+}
+
+// Number implements stack.TransportProtocol.
+func (p *protocol) Number() tcpip.TransportProtocolNumber {
+	return p.number
+}
+
+// NewEndpoint implements stack.TransportProtocol.
+func (*protocol) NewEndpoint(tcpip.NetworkProtocolNumber, *waiter.Queue) (tcpip.Endpoint, *tcpip.Error) {
+	return nil, tcpip.ErrNotSupported
+}
+
+// NewRawEndpoint implements stack.TransportProtocol.
+func (*protocol) NewRawEndpoint(tcpip.NetworkProtocolNumber, *waiter.Queue) (tcpip.Endpoint, *tcpip.Error) {
+	return nil, tcpip.ErrNotSupported
+}
+
+// MinimumPacketSize implements stack.TransportProtocol.
+func (*protocol) MinimumPacketSize() int {
+	return 0
+}
+
+// ParsePorts implements stack.TransportProtocol.
+//
+// Encapsulation protocols have no ports; tunnels are identified by address.
+func (*protocol) ParsePorts(buffer.View) (src, dst uint16, err *tcpip.Error) {
+	return 0, 0, nil
+}
+
+// HandleUnknownDestinationPacket implements stack.TransportProtocol.
+//
+// Encapsulated packets are never delivered to transport endpoints, so they
+// are all handled here.
+func (p *protocol) HandleUnknownDestinationPacket(id stack.TransportEndpointID, pkt *stack.PacketBuffer) stack.UnknownDestinationPacketDisposition {
+	payload := pkt.Data.ToView()
+	var tid tunnelID
+	if p.parseID != nil {
+		var ok bool
+		if tid, ok = p.parseID(payload); !ok {
+			return stack.UnknownDestinationPacketMalformed
+		}
+	}
+
+	p.mu.RLock()
+	e, ok := p.tunnels[tunnelKey{local: id.LocalAddress, remote: id.RemoteAddress, id: tid}]
+	if !ok {
+		e, ok = p.tunnels[tunnelKey{remote: id.RemoteAddress, id: tid}]
+	}
+	p.mu.RUnlock()
+	if !ok {
+		return stack.UnknownDestinationPacketUnhandled
+	}
+	e.deliver(id.RemoteAddress, payload)
+	return stack.UnknownDestinationPacketHandled
+}
+
+// SetOption implements stack.TransportProtocol.
+func (*protocol) SetOption(tcpip.SettableTransportProtocolOption) *tcpip.Error {
+	return tcpip.ErrUnknownProtocolOption
+}
+
+// Option implements stack.TransportProtocol.
+func (*protocol) Option(tcpip.GettableTransportProtocolOption) *tcpip.Error {
+	return tcpip.ErrUnknownProtocolOption
+}
+
+// Close implements stack.TransportProtocol.
+func (*protocol) Close() {}
+
+// Wait implements stack.TransportProtocol.
+func (*protocol) Wait() {}
+
+// Parse implements stack.TransportProtocol.
+//
+// The encapsulated packet is left in the packet's data.
+func (*protocol) Parse(*stack.PacketBuffer) bool {
+	return true
+}
+
+// Endpoint is a tunnel link endpoint.
+type Endpoint struct {
+	stack      *stack.Stack
+	protocol   *protocol
+	encap      encapsulation
+	outerProto tcpip.NetworkProtocolNumber
+	opts       Options
+
+	stats Stats
+
+	mu         sync.RWMutex
+	dispatcher stack.NetworkDispatcher
+
+	// nicID is the ID of the NIC the tunnel is attached to, if known.
+	nicID tcpip.NICID
+}
+
+var _ stack.LinkEndpoint = (*Endpoint)(nil)
+
+// Stats holds the packet counters of a tunnel.
+type Stats struct {
+	// DecapsulationErrors is the number of received packets that were
+	// dropped because they failed the tunnel's decapsulation checks.
+	DecapsulationErrors tcpip.StatCounter
+}
+
+// Stats returns the tunnel's packet counters.
+func (e *Endpoint) Stats() *Stats {
+	return &e.stats
+}
+
+// newEndpoint returns a tunnel that encapsulates packets with encap in the
+// transport protocol number of s.
+func newEndpoint(s *stack.Stack, number tcpip.TransportProtocolNumber, encap encapsulation, opts Options) (*Endpoint, *tcpip.Error) {
+	p, ok := s.TransportProtocolInstance(number).(*protocol)
+	if !ok {
+		return nil, tcpip.ErrUnknownProtocol
+	}
+
+	var outerProto tcpip.NetworkProtocolNumber
+	switch len(opts.Remote) {
+	case header.IPv4AddressSize:
+		outerProto = header.IPv4ProtocolNumber
+	case header.IPv6AddressSize:
+		outerProto = header.IPv6ProtocolNumber
+	default:
+		return nil, tcpip.ErrBadAddress
+	}
+	if len(opts.Local) != 0 && len(opts.Local) != len(opts.Remote) {
+		return nil, tcpip.ErrBadAddress
+	}
+
+	if opts.MTU == 0 {
+		// The route's MTU already accounts for the outer IP header.
+		r, err := s.FindRoute(0, opts.Local, opts.Remote, outerProto, false /* multicastLoop */)
+		if err != nil {
+			return nil, err
+		}
+		mtu := r.MTU()
+		r.Release()
+		overhead := uint32(encap.headerLength())
+		if mtu <= overhead {
+			return nil, tcpip.ErrInvalidOptionValue
+		}
+		opts.MTU = mtu - overhead
+	}
+
+	p.mu.RLock()
+	_, ok = p.tunnels[tunnelKey{local: opts.Local, remote: opts.Remote, id: encap.inputID()}]
+	p.mu.RUnlock()
+	if ok {
+		return nil, tcpip.ErrDuplicateAddress
+	}
+
+	return &Endpoint{
+		stack:      s,
+		protocol:   p,
+		encap:      encap,
+		outerProto: outerProto,
+		opts:       opts,
+	}, nil
+}
+
+// Attach implements stack.LinkEndpoint.
+//
+// The tunnel receives packets while it is attached.
+func (e *Endpoint) Attach(dispatcher stack.NetworkDispatcher) {
+	key := tunnelKey{local: e.opts.Local, remote: e.opts.Remote, id: e.encap.inputID()}
+	e.protocol.mu.Lock()
+	if dispatcher != nil {
+		e.protocol.tunnels[key] = e
+	} else if e.protocol.tunnels[key] == e {
+		delete(e.protocol.tunnels, key)
+	}
+	e.protocol.mu.Unlock()
+
+	e.mu.Lock()
+	e.dispatcher = dispatcher
+	e.nicID = 0
+	if nic, ok := dispatcher.(interface{ ID() tcpip.NICID }); ok {
+		e.nicID = nic.ID()
+	}
+	e.mu.Unlock()
+}
+
+// IsAttached implements stack.LinkEndpoint.
+func (e *Endpoint) IsAttached() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.dispatcher != nil
+}
+
+// MTU implements stack.LinkEndpoint.
+func (e *Endpoint) MTU() uint32 {
+	return e.opts.MTU
+}
+
+// Capabilities implements stack.LinkEndpoint.
+func (*Endpoint) Capabilities() stack.LinkEndpointCapabilities {
+	return 0
+}
+
+// MaxHeaderLength implements stack.LinkEndpoint.
+//
+// Encapsulation headers are added by the stack when the encapsulated packet
+// is sent, so no space is reserved for them.
+func (*Endpoint) MaxHeaderLength() uint16 {
+	return 0
+}
+
+// LinkAddress implements stack.LinkEndpoint.
+func (*Endpoint) LinkAddress() tcpip.LinkAddress {
+	return ""
+}
+
+// Wait implements stack.LinkEndpoint.
+func (*Endpoint) Wait() {}
+
+// ARPHardwareType implements stack.LinkEndpoint.
+func (e *Endpoint) ARPHardwareType() header.ARPHardwareType {
+	return e.encap.hardwareType(e.outerProto)
+}
+
+// AddHeader implements stack.LinkEndpoint.
+func (*Endpoint) AddHeader(local, remote tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+}
+
+// WritePacket implements stack.LinkEndpoint.
+func (e *Endpoint) WritePacket(_ *stack.Route, _ *stack.GSO, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) *tcpip.Error {
+	if !e.encap.supports(protocol) {
+		return tcpip.ErrNotSupported
+	}
+
+	r, err := e.stack.FindRoute(0, e.opts.Local, e.opts.Remote, e.outerProto, false /* multicastLoop */)
+	if err != nil {
+		return err
+	}
+	defer r.Release()
+
+	// Don't send encapsulated packets through the tunnel itself.
+	e.mu.RLock()
+	nicID := e.nicID
+	e.mu.RUnlock()
+	if nicID != 0 && r.NICID() == nicID {
+		return tcpip.ErrNoRoute
+	}
+
+	ttl, tos := e.opts.TTL, e.opts.TOS
+	if ttl == 0 || e.opts.InheritTOS {
+		innerTTL, innerTOS := innerTTLAndTOS(protocol, pkt)
+		if ttl == 0 {
+			ttl = innerTTL
+		}
+		if e.opts.InheritTOS {
+			tos = innerTOS
+		}
+	}
+
+	hdrLen := e.encap.headerLength()
+	outer := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		ReserveHeaderBytes: int(r.MaxHeaderLength()) + hdrLen,
+		Data:               buffer.NewVectorisedView(pkt.Size(), pkt.Views()),
+	})
+	if hdrLen != 0 {
+		e.encap.encapsulate(outer.TransportHeader().Push(hdrLen), protocol, outer.Data)
+	}
+	return r.WritePacket(nil /* gso */, stack.NetworkHeaderParams{
+		Protocol: e.protocol.number,
+		TTL:      ttl,
+		TOS:      tos,
+	}, outer)
+}
+
+// WritePackets implements stack.LinkEndpoint.
+func (e *Endpoint) WritePackets(r *stack.Route, gso *stack.GSO, pkts stack.PacketBufferList, protocol tcpip.NetworkProtocolNumber) (int, *tcpip.Error) {
+	n := 0
+	for pkt := pkts.Front(); pkt != nil; pkt = pkt.Next() {
+		if err := e.WritePacket(r, gso, protocol, pkt); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// deliver decapsulates a packet received from src and delivers it to the
+// tunnel's dispatcher.
+func (e *Endpoint) deliver(src tcpip.Address, payload buffer.View) {
+	proto, inner, ok := e.encap.decapsulate(src, payload)
+	if !ok {
+		e.stats.DecapsulationErrors.Increment()
+		return
+	}
+
+	e.mu.RLock()
+	d := e.dispatcher
+	e.mu.RUnlock()
+	if d == nil {
+		return
+	}
+	d.DeliverNetworkPacket("" /* remote */, "" /* local */, proto, stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: inner.ToVectorisedView(),
+	}))
+}
+
+// innerTTLAndTOS returns the TTL and TOS of the packet that is being
+// encapsulated.
+func innerTTLAndTOS(protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) (uint8, uint8) {
+	h := pkt.NetworkHeader().View()
+	switch protocol {
+	case header.IPv4ProtocolNumber:
+		if len(h) >= header.IPv4MinimumSize {
+			ip := header.IPv4(h)
+			tos, _ := ip.TOS()
+			return ip.TTL(), tos
+		}
+	case header.IPv6ProtocolNumber:
+		if len(h) >= header.IPv6MinimumSize {
+			ip := header.IPv6(h)
+			tc, _ := ip.TOS()
+			return ip.HopLimit(), tc
+		}
+	}
+	return 0, 0
+}
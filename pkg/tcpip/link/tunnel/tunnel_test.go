@@ -0,0 +1,56 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tunnel
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/link/encaptest"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	tunnelNICID = 2
+
+	linkAddr1 = tcpip.LinkAddress("\x02\x02\x03\x04\x05\x06")
+	linkAddr2 = tcpip.LinkAddress("\x02\x02\x03\x04\x05\x07")
+
+	outerAddr1 = tcpip.Address("\x0a\x00\x00\x01")
+	outerAddr2 = tcpip.Address("\x0a\x00\x00\x02")
+	outerAddr3 = tcpip.Address("\x0a\x00\x00\x03")
+
+	outerV6Addr1 = tcpip.Address("\xfd\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01")
+	outerV6Addr2 = tcpip.Address("\xfd\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02")
+
+	innerAddr1 = tcpip.Address("\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01")
+	innerAddr2 = tcpip.Address("\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02")
+)
+
+// newTestStack returns a stack with an uplink NIC that has the given IPv4 and,
+// if not empty, IPv6 addresses. All IPv4 destinations are routed through the
+// uplink.
+func newTestStack(t *testing.T, ep stack.LinkEndpoint, outerAddr, outerV6Addr tcpip.Address) *stack.Stack {
+	t.Helper()
+
+	addrs := []tcpip.AddressWithPrefix{{Address: outerAddr, PrefixLen: 0}}
+	if len(outerV6Addr) != 0 {
+		addrs = append(addrs, tcpip.AddressWithPrefix{Address: outerV6Addr, PrefixLen: 64})
+	}
+	return encaptest.NewStack(t, ep, encaptest.Options{
+		Addrs:              addrs,
+		TransportProtocols: []stack.TransportProtocolFactory{NewSITProtocol, NewGREProtocol, NewIPIPProtocol},
+	})
+}
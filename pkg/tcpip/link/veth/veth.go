@@ -0,0 +1,200 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package veth provides the implementation of virtual ethernet pairs: two
+// link endpoints connected to each other, like Linux's veth devices, so that
+// the frames sent through one end are received by the other. The ends may be
+// attached to NICs of different stacks.
+//
+// The ends of a pair send and receive raw ethernet frames. They are meant to
+// be wrapped by ethernet.Endpoint, or to be used as the ports of a bridge.
+//
+// Frames are delivered to the other end synchronously, as loopback endpoints
+// do, and are dropped if the other end isn't attached to a NIC.
+package veth
+
+import (
+	"sync/atomic"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// DefaultMTU is the MTU of the ends of a pair if none is specified, as in
+// Linux.
+const DefaultMTU = 1500
+
+var _ stack.LinkEndpoint = (*Endpoint)(nil)
+
+// Options specify the details of a veth pair.
+type Options struct {
+	// MTU is the MTU of both ends of the pair. If 0, DefaultMTU is used.
+	MTU uint32
+
+	// LinkAddress is the link address of the first end of the pair.
+	LinkAddress tcpip.LinkAddress
+
+	// PeerLinkAddress is the link address of the second end of the pair.
+	PeerLinkAddress tcpip.LinkAddress
+
+	// ChecksumOffload skips the computation and the verification of the
+	// transport checksums of the packets sent through the pair, as their
+	// content can't be altered between the ends. It must only be set if both
+	// ends are attached to netstack NICs.
+	ChecksumOffload bool
+}
+
+// Endpoint is one end of a veth pair.
+type Endpoint struct {
+	peer     *Endpoint
+	linkAddr tcpip.LinkAddress
+	mtu      uint32
+	caps     stack.LinkEndpointCapabilities
+
+	mu         sync.RWMutex
+	dispatcher stack.NetworkDispatcher
+
+	// drops is the number of frames which were dropped because the end they
+	// were sent to wasn't attached.
+	//
+	// Must be accessed using atomic operations.
+	drops uint64
+}
+
+// New returns both ends of a new veth pair.
+func New(opts Options) (*Endpoint, *Endpoint) {
+	mtu := opts.MTU
+	if mtu == 0 {
+		mtu = DefaultMTU
+	}
+	var caps stack.LinkEndpointCapabilities
+	if opts.ChecksumOffload {
+		caps |= stack.CapabilityTXChecksumOffload | stack.CapabilityRXChecksumOffload
+	}
+
+	ep1 := &Endpoint{
+		linkAddr: opts.LinkAddress,
+		mtu:      mtu,
+		caps:     caps,
+	}
+	ep2 := &Endpoint{
+		linkAddr: opts.PeerLinkAddress,
+		mtu:      mtu,
+		caps:     caps,
+	}
+	ep1.peer = ep2
+	ep2.peer = ep1
+	return ep1, ep2
+}
+
+// Peer returns the other end of the pair of e.
+func (e *Endpoint) Peer() *Endpoint {
+	return e.peer
+}
+
+// Drops returns the number of frames sent through e which were dropped
+// because the other end of the pair wasn't attached.
+func (e *Endpoint) Drops() uint64 {
+	return atomic.LoadUint64(&e.drops)
+}
+
+// deliver delivers pkt, a frame sent by the peer of e, to the NIC of e.
+func (e *Endpoint) deliver(protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) bool {
+	e.mu.RLock()
+	d := e.dispatcher
+	e.mu.RUnlock()
+	if d == nil {
+		return false
+	}
+
+	// The frame is delivered in a new packet, as the sender retains the
+	// ownership of pkt.
+	d.DeliverNetworkPacket("" /* remote */, "" /* local */, protocol, stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: buffer.NewVectorisedView(pkt.Size(), pkt.Views()),
+	}))
+	return true
+}
+
+// WritePacket implements stack.LinkEndpoint.WritePacket.
+func (e *Endpoint) WritePacket(_ *stack.Route, _ *stack.GSO, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) *tcpip.Error {
+	if !e.peer.deliver(protocol, pkt) {
+		// As in Linux, the frames sent to a detached end are silently
+		// dropped.
+		atomic.AddUint64(&e.drops, 1)
+	}
+	return nil
+}
+
+// WritePackets implements stack.LinkEndpoint.WritePackets.
+func (e *Endpoint) WritePackets(r *stack.Route, gso *stack.GSO, pkts stack.PacketBufferList, protocol tcpip.NetworkProtocolNumber) (int, *tcpip.Error) {
+	n := 0
+	for pkt := pkts.Front(); pkt != nil; pkt = pkt.Next() {
+		if err := e.WritePacket(r, gso, protocol, pkt); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Attach implements stack.LinkEndpoint.Attach.
+func (e *Endpoint) Attach(dispatcher stack.NetworkDispatcher) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dispatcher = dispatcher
+}
+
+// IsAttached implements stack.LinkEndpoint.IsAttached.
+func (e *Endpoint) IsAttached() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.dispatcher != nil
+}
+
+// Wait implements stack.LinkEndpoint.Wait.
+func (*Endpoint) Wait() {}
+
+// MTU implements stack.LinkEndpoint.MTU.
+func (e *Endpoint) MTU() uint32 {
+	return e.mtu
+}
+
+// Capabilities implements stack.LinkEndpoint.Capabilities.
+func (e *Endpoint) Capabilities() stack.LinkEndpointCapabilities {
+	return e.caps
+}
+
+// MaxHeaderLength implements stack.LinkEndpoint.MaxHeaderLength.
+func (*Endpoint) MaxHeaderLength() uint16 {
+	return 0
+}
+
+// LinkAddress implements stack.LinkEndpoint.LinkAddress.
+func (e *Endpoint) LinkAddress() tcpip.LinkAddress {
+	return e.linkAddr
+}
+
+// ARPHardwareType implements stack.LinkEndpoint.ARPHardwareType. Like other
+// endpoints which send raw frames, the ends of a pair rely on ethernet.Endpoint
+// to report ARPHardwareEther.
+func (*Endpoint) ARPHardwareType() header.ARPHardwareType {
+	return header.ARPHardwareNone
+}
+
+// AddHeader implements stack.LinkEndpoint.AddHeader.
+func (*Endpoint) AddHeader(_, _ tcpip.LinkAddress, _ tcpip.NetworkProtocolNumber, _ *stack.PacketBuffer) {
+}
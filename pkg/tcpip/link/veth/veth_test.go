@@ -0,0 +1,149 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package veth_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/link/ethernet"
+	"gvisor.dev/gvisor/pkg/tcpip/link/veth"
+	"gvisor.dev/gvisor/pkg/tcpip/network/arp"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	nicID = 1
+	port  = 1234
+
+	linkAddr1 = tcpip.LinkAddress("\x02\x03\x03\x04\x05\x06")
+	linkAddr2 = tcpip.LinkAddress("\x02\x03\x03\x04\x05\x07")
+)
+
+var (
+	addr1 = tcpip.Address(net.ParseIP("192.168.0.1").To4())
+	addr2 = tcpip.Address(net.ParseIP("192.168.0.2").To4())
+)
+
+// newStack returns a stack with the NIC nicID for ep, which has the address
+// addr and reaches the address remote at the link address remoteLinkAddr.
+func newStack(t *testing.T, ep *veth.Endpoint, addr, remote tcpip.Address, remoteLinkAddr tcpip.LinkAddress) *stack.Stack {
+	t.Helper()
+
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{arp.NewProtocol, ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+	})
+	if err := s.CreateNIC(nicID, ethernet.New(ep)); err != nil {
+		t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+	}
+	protocolAddr := tcpip.ProtocolAddress{
+		Protocol:          ipv4.ProtocolNumber,
+		AddressWithPrefix: tcpip.AddressWithPrefix{Address: addr, PrefixLen: 24},
+	}
+	if err := s.AddProtocolAddress(nicID, protocolAddr); err != nil {
+		t.Fatalf("AddProtocolAddress(%d, %+v): %s", nicID, protocolAddr, err)
+	}
+	s.SetRouteTable([]tcpip.Route{{Destination: protocolAddr.AddressWithPrefix.Subnet(), NIC: nicID}})
+	if err := s.AddStaticNeighbor(nicID, remote, remoteLinkAddr); err != nil {
+		t.Fatalf("AddStaticNeighbor(%d, %s, %s): %s", nicID, remote, remoteLinkAddr, err)
+	}
+	return s
+}
+
+// newUDPEndpoint returns a UDP endpoint of s bound to addr.
+func newUDPEndpoint(t *testing.T, s *stack.Stack, addr tcpip.Address) tcpip.Endpoint {
+	t.Helper()
+
+	var wq waiter.Queue
+	ep, err := s.NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+	if err != nil {
+		t.Fatalf("NewEndpoint(%d, %d, _): %s", udp.ProtocolNumber, ipv4.ProtocolNumber, err)
+	}
+	t.Cleanup(ep.Close)
+	bindAddr := tcpip.FullAddress{Addr: addr, Port: port}
+	if err := ep.Bind(bindAddr); err != nil {
+		t.Fatalf("Bind(%#v): %s", bindAddr, err)
+	}
+	return ep
+}
+
+func TestPair(t *testing.T) {
+	for _, checksumOffload := range []bool{false, true} {
+		t.Run("", func(t *testing.T) {
+			ep1, ep2 := veth.New(veth.Options{
+				LinkAddress:     linkAddr1,
+				PeerLinkAddress: linkAddr2,
+				ChecksumOffload: checksumOffload,
+			})
+			if ep1.Peer() != ep2 || ep2.Peer() != ep1 {
+				t.Fatal("got the ends of the pair not to be each other's peer")
+			}
+			for _, ep := range []*veth.Endpoint{ep1, ep2} {
+				if got := ep.MTU(); got != veth.DefaultMTU {
+					t.Errorf("got MTU() = %d, want = %d", got, veth.DefaultMTU)
+				}
+			}
+
+			s1 := newStack(t, ep1, addr1, addr2, linkAddr2)
+			s2 := newStack(t, ep2, addr2, addr1, linkAddr1)
+			udp1 := newUDPEndpoint(t, s1, addr1)
+			udp2 := newUDPEndpoint(t, s2, addr2)
+
+			// The packets sent by each stack are received by the other.
+			payload := []byte{1, 2, 3, 4}
+			for _, c := range []struct {
+				src, dst         tcpip.Endpoint
+				srcAddr, dstAddr tcpip.Address
+			}{
+				{src: udp1, dst: udp2, srcAddr: addr1, dstAddr: addr2},
+				{src: udp2, dst: udp1, srcAddr: addr2, dstAddr: addr1},
+			} {
+				wOpts := tcpip.WriteOptions{To: &tcpip.FullAddress{Addr: c.dstAddr, Port: port}}
+				if _, _, err := c.src.Write(tcpip.SlicePayload(payload), wOpts); err != nil {
+					t.Fatalf("Write(_, %#v): %s", wOpts, err)
+				}
+				var addr tcpip.FullAddress
+				v, _, err := c.dst.Read(&addr)
+				if err != nil {
+					t.Fatalf("Read(_): %s", err)
+				}
+				if !bytes.Equal(v, payload) {
+					t.Errorf("got Read(_) = %x, want = %x", v, payload)
+				}
+				if addr.Addr != c.srcAddr {
+					t.Errorf("got Read(_) from %s, want = %s", addr.Addr, c.srcAddr)
+				}
+			}
+
+			// The frames sent to a detached end are dropped.
+			if err := s2.RemoveNIC(nicID); err != nil {
+				t.Fatalf("RemoveNIC(%d): %s", nicID, err)
+			}
+			wOpts := tcpip.WriteOptions{To: &tcpip.FullAddress{Addr: addr2, Port: port}}
+			if _, _, err := udp1.Write(tcpip.SlicePayload(payload), wOpts); err != nil {
+				t.Fatalf("Write(_, %#v): %s", wOpts, err)
+			}
+			if got := ep1.Drops(); got != 1 {
+				t.Errorf("got ep1.Drops() = %d, want = 1", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wireguard
+
+import (
+	"sort"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// allowedIPs maps the allowed IPs of the peers of a device to the peers, for
+// cryptokey routing.
+//
+// Each subnet belongs to at most one peer.
+type allowedIPs struct {
+	// entries is sorted by decreasing prefix length, so that the first entry
+	// containing an address is its longest match.
+	entries []allowedIP
+}
+
+type allowedIP struct {
+	subnet tcpip.Subnet
+	peer   *Peer
+}
+
+// insert makes subnet an allowed IP of p, removing it from the peer it
+// belonged to, if any.
+func (a *allowedIPs) insert(subnet tcpip.Subnet, p *Peer) {
+	for i := range a.entries {
+		if a.entries[i].subnet == subnet {
+			a.entries[i].peer = p
+			return
+		}
+	}
+	prefix := subnet.Prefix()
+	i := sort.Search(len(a.entries), func(i int) bool {
+		return a.entries[i].subnet.Prefix() < prefix
+	})
+	a.entries = append(a.entries, allowedIP{})
+	copy(a.entries[i+1:], a.entries[i:])
+	a.entries[i] = allowedIP{subnet: subnet, peer: p}
+}
+
+// removePeer removes all the allowed IPs of p.
+func (a *allowedIPs) removePeer(p *Peer) {
+	entries := a.entries[:0]
+	for _, e := range a.entries {
+		if e.peer != p {
+			entries = append(entries, e)
+		}
+	}
+	for i := len(entries); i < len(a.entries); i++ {
+		a.entries[i] = allowedIP{}
+	}
+	a.entries = entries
+}
+
+// lookup returns the peer whose allowed IPs best match addr, or nil if none
+// matches.
+func (a *allowedIPs) lookup(addr tcpip.Address) *Peer {
+	for i := range a.entries {
+		if a.entries[i].subnet.Contains(addr) {
+			return a.entries[i].peer
+		}
+	}
+	return nil
+}
+
+// subnets returns the allowed IPs of p.
+func (a *allowedIPs) subnets(p *Peer) []tcpip.Subnet {
+	var subnets []tcpip.Subnet
+	for _, e := range a.entries {
+		if e.peer == p {
+			subnets = append(subnets, e.subnet)
+		}
+	}
+	return subnets
+}
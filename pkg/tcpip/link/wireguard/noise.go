@@ -0,0 +1,487 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wireguard
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"hash"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"gvisor.dev/gvisor/pkg/rand"
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+const (
+	noiseConstruction = "Noise_IKpsk2_25519_ChaChaPoly_BLAKE2s"
+	wgIdentifier      = "WireGuard v1 zx2c4 Jason@zx2c4.com"
+	wgLabelMAC1       = "mac1----"
+)
+
+// Message types.
+const (
+	messageInitiationType  = 1
+	messageResponseType    = 2
+	messageCookieReplyType = 3
+	messageTransportType   = 4
+)
+
+// Offsets of the fields of handshake initiations.
+const (
+	initiationSender      = 4
+	initiationEphemeral   = 8
+	initiationStatic      = 40
+	initiationTimestamp   = 88
+	initiationMAC1        = 116
+	messageInitiationSize = 148
+)
+
+// Offsets of the fields of handshake responses.
+const (
+	responseSender      = 4
+	responseReceiver    = 8
+	responseEphemeral   = 12
+	responseEmpty       = 44
+	responseMAC1        = 60
+	messageResponseSize = 92
+)
+
+// Offsets of the fields of transport data messages.
+const (
+	transportReceiver           = 4
+	transportCounter            = 8
+	messageTransportHeaderSize  = 16
+	messageTransportMinimumSize = messageTransportHeaderSize + chacha20poly1305.Overhead
+)
+
+// tai64nSize is the size of TAI64N timestamps.
+const tai64nSize = 12
+
+// KeySize is the size of Curve25519 keys.
+const KeySize = 32
+
+// Key is a Curve25519 private or public key.
+type Key [KeySize]byte
+
+// GeneratePrivateKey returns a new random private key.
+func GeneratePrivateKey() Key {
+	var k Key
+	randomBytes(k[:])
+	k[0] &= 248
+	k[31] = (k[31] & 127) | 64
+	return k
+}
+
+// PublicKey returns the public key of the private key k.
+func (k Key) PublicKey() Key {
+	var pub Key
+	s, err := curve25519.X25519(k[:], curve25519.Basepoint)
+	if err != nil {
+		panic("X25519 with the base point failed: " + err.Error())
+	}
+	copy(pub[:], s)
+	return pub
+}
+
+// IsZero returns true if k is the zero key, which stands for no key.
+func (k Key) IsZero() bool {
+	var zero Key
+	return subtle.ConstantTimeCompare(k[:], zero[:]) == 1
+}
+
+// String returns the base64 encoding of k, as used by the wg tool.
+func (k Key) String() string {
+	return base64.StdEncoding.EncodeToString(k[:])
+}
+
+// ParseKey parses the base64 encoding of a key.
+func ParseKey(s string) (Key, *tcpip.Error) {
+	var k Key
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(b) != KeySize {
+		return k, tcpip.ErrInvalidOptionValue
+	}
+	copy(k[:], b)
+	return k, nil
+}
+
+// randomBytes fills b with cryptographic random bytes.
+func randomBytes(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic("unable to get random numbers: " + err.Error())
+	}
+}
+
+// digest is a BLAKE2s hash, which is also the size of the chaining keys and
+// the symmetric keys of the handshake.
+type digest [blake2s.Size]byte
+
+var (
+	// initialChainKey is Hash(Construction).
+	initialChainKey = digest(blake2s.Sum256([]byte(noiseConstruction)))
+
+	// initialHash is Hash(initialChainKey || Identifier).
+	initialHash = func() digest {
+		var h digest
+		mixHash(&h, &initialChainKey, []byte(wgIdentifier))
+		return h
+	}()
+
+	zeroNonce [chacha20poly1305.NonceSize]byte
+)
+
+func newBLAKE2s() hash.Hash {
+	h, err := blake2s.New256(nil)
+	if err != nil {
+		panic("unkeyed BLAKE2s failed: " + err.Error())
+	}
+	return h
+}
+
+// mixHash sets dst to Hash(h || data).
+func mixHash(dst, h *digest, data []byte) {
+	b := newBLAKE2s()
+	b.Write(h[:])
+	b.Write(data)
+	b.Sum(dst[:0])
+}
+
+// hmacBLAKE2s sets dst to HMAC-BLAKE2s(key, the concatenation of data).
+func hmacBLAKE2s(dst *digest, key []byte, data ...[]byte) {
+	m := hmac.New(newBLAKE2s, key)
+	for _, d := range data {
+		m.Write(d)
+	}
+	m.Sum(dst[:0])
+}
+
+// kdf derives len(outs) keys from the chaining key ck and input with HKDF,
+// and stores them in outs. ck may be one of outs.
+func kdf(ck *digest, input []byte, outs ...*digest) {
+	var prk digest
+	hmacBLAKE2s(&prk, ck[:], input)
+	var prev []byte
+	for i, out := range outs {
+		hmacBLAKE2s(out, prk[:], prev, []byte{byte(i + 1)})
+		prev = out[:]
+	}
+}
+
+// sharedSecret returns the result of the Diffie-Hellman exchange between priv
+// and pub.
+//
+// Returns false if pub is a low order point, which yields an all zero secret.
+func sharedSecret(priv, pub *Key) ([]byte, bool) {
+	s, err := curve25519.X25519(priv[:], pub[:])
+	if err != nil {
+		return nil, false
+	}
+	return s, true
+}
+
+// newAEAD returns the ChaCha20-Poly1305 AEAD for key.
+func newAEAD(key *digest) cipher.AEAD {
+	a, err := chacha20poly1305.New(key[:])
+	if err != nil {
+		panic("ChaCha20-Poly1305 with a 32 bytes key failed: " + err.Error())
+	}
+	return a
+}
+
+// mac1Key returns the key of the MAC1 of the handshake messages sent to the
+// owner of pub, Hash(LabelMAC1 || pub).
+func mac1Key(pub *Key) digest {
+	b := newBLAKE2s()
+	b.Write([]byte(wgLabelMAC1))
+	b.Write(pub[:])
+	var k digest
+	b.Sum(k[:0])
+	return k
+}
+
+// mac1 returns the MAC1 of the handshake message msg with key.
+func mac1(msg []byte, key *digest) [blake2s.Size128]byte {
+	m, err := blake2s.New128(key[:])
+	if err != nil {
+		panic("keyed BLAKE2s failed: " + err.Error())
+	}
+	// MAC1 covers the message up to itself, and is followed by MAC2.
+	m.Write(msg[:len(msg)-2*blake2s.Size128])
+	var sum [blake2s.Size128]byte
+	m.Sum(sum[:0])
+	return sum
+}
+
+// setMAC1 sets the MAC1 of the handshake message msg with key.
+//
+// MAC2 is left zero, as the device never sends cookie replies and so never
+// has cookies for its peers.
+func setMAC1(msg []byte, key *digest) {
+	sum := mac1(msg, key)
+	copy(msg[len(msg)-2*blake2s.Size128:], sum[:])
+}
+
+// checkMAC1 returns true if the MAC1 of the handshake message msg is valid
+// for key.
+func checkMAC1(msg []byte, key *digest) bool {
+	sum := mac1(msg, key)
+	off := len(msg) - 2*blake2s.Size128
+	return hmac.Equal(sum[:], msg[off:off+blake2s.Size128])
+}
+
+// tai64n returns the TAI64N timestamp of the time t, in nanoseconds since the
+// Unix epoch.
+func tai64n(t int64) [tai64nSize]byte {
+	var ts [tai64nSize]byte
+	binary.BigEndian.PutUint64(ts[:], 0x400000000000000a+uint64(t/1e9))
+	binary.BigEndian.PutUint32(ts[8:], uint32(t%1e9))
+	return ts
+}
+
+// handshake is the state of a handshake initiated with a peer.
+type handshake struct {
+	// inProgress is true if an initiation was sent and its response wasn't
+	// received yet.
+	inProgress bool
+
+	// started is the monotonic time at which the first initiation of the
+	// handshake was sent.
+	started int64
+
+	// localIndex is the sender index of the last initiation.
+	localIndex uint32
+
+	chainKey  digest
+	hash      digest
+	ephemeral Key
+}
+
+// createInitiationLocked starts a new handshake with p and returns its
+// initiation message.
+//
+// Returns false if the device has no private key, or no handshake is possible
+// with p.
+//
+// Precondition: e.mu must be read locked, p.mu must be locked.
+func (e *Endpoint) createInitiationLocked(p *Peer) ([]byte, bool) {
+	if e.privateKey.IsZero() {
+		return nil, false
+	}
+	static, ok := sharedSecret(&e.privateKey, &p.publicKey)
+	if !ok {
+		return nil, false
+	}
+	hs := &p.handshake
+	hs.ephemeral = GeneratePrivateKey()
+	ephemeral := hs.ephemeral.PublicKey()
+	ephemeralShared, ok := sharedSecret(&hs.ephemeral, &p.publicKey)
+	if !ok {
+		return nil, false
+	}
+
+	msg := make([]byte, messageInitiationSize)
+	binary.LittleEndian.PutUint32(msg, messageInitiationType)
+	copy(msg[initiationEphemeral:], ephemeral[:])
+
+	var key digest
+	hs.chainKey = initialChainKey
+	mixHash(&hs.hash, &initialHash, p.publicKey[:])
+	kdf(&hs.chainKey, ephemeral[:], &hs.chainKey)
+	mixHash(&hs.hash, &hs.hash, ephemeral[:])
+
+	kdf(&hs.chainKey, ephemeralShared, &hs.chainKey, &key)
+	newAEAD(&key).Seal(msg[initiationStatic:initiationStatic], zeroNonce[:], e.publicKey[:], hs.hash[:])
+	mixHash(&hs.hash, &hs.hash, msg[initiationStatic:initiationTimestamp])
+
+	kdf(&hs.chainKey, static, &hs.chainKey, &key)
+	ts := tai64n(e.clock.NowNanoseconds())
+	newAEAD(&key).Seal(msg[initiationTimestamp:initiationTimestamp], zeroNonce[:], ts[:], hs.hash[:])
+	mixHash(&hs.hash, &hs.hash, msg[initiationTimestamp:initiationMAC1])
+
+	if hs.inProgress {
+		e.removeIndex(hs.localIndex)
+	}
+	hs.localIndex = e.addIndex(p, nil /* keypair */)
+	binary.LittleEndian.PutUint32(msg[initiationSender:], hs.localIndex)
+	setMAC1(msg, &p.mac1Key)
+	hs.inProgress = true
+	return msg, true
+}
+
+// consumeInitiationLocked processes a handshake initiation, whose MAC1 was
+// checked, and returns the response to send to its sender and the peer that
+// sent it.
+//
+// Returns false if the initiation is invalid or replayed.
+//
+// Precondition: e.mu must be read locked.
+func (e *Endpoint) consumeInitiationLocked(msg []byte) ([]byte, *Peer, bool) {
+	if e.privateKey.IsZero() {
+		return nil, nil, false
+	}
+	var ephemeral Key
+	copy(ephemeral[:], msg[initiationEphemeral:initiationStatic])
+	ephemeralShared, ok := sharedSecret(&e.privateKey, &ephemeral)
+	if !ok {
+		return nil, nil, false
+	}
+
+	var ck, h, key digest
+	ck = initialChainKey
+	mixHash(&h, &initialHash, e.publicKey[:])
+	kdf(&ck, ephemeral[:], &ck)
+	mixHash(&h, &h, ephemeral[:])
+
+	kdf(&ck, ephemeralShared, &ck, &key)
+	var peerKey Key
+	if _, err := newAEAD(&key).Open(peerKey[:0], zeroNonce[:], msg[initiationStatic:initiationTimestamp], h[:]); err != nil {
+		return nil, nil, false
+	}
+	mixHash(&h, &h, msg[initiationStatic:initiationTimestamp])
+	p, ok := e.peers[peerKey]
+	if !ok {
+		return nil, nil, false
+	}
+	static, ok := sharedSecret(&e.privateKey, &peerKey)
+	if !ok {
+		return nil, nil, false
+	}
+
+	kdf(&ck, static, &ck, &key)
+	var ts [tai64nSize]byte
+	if _, err := newAEAD(&key).Open(ts[:0], zeroNonce[:], msg[initiationTimestamp:initiationMAC1], h[:]); err != nil {
+		return nil, nil, false
+	}
+	mixHash(&h, &h, msg[initiationTimestamp:initiationMAC1])
+
+	// The response uses a new ephemeral key.
+	responderPrivate := GeneratePrivateKey()
+	responderEphemeral := responderPrivate.PublicKey()
+	ee, ok := sharedSecret(&responderPrivate, &ephemeral)
+	if !ok {
+		return nil, nil, false
+	}
+	se, ok := sharedSecret(&responderPrivate, &peerKey)
+	if !ok {
+		return nil, nil, false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.removed {
+		return nil, nil, false
+	}
+	// Initiations must carry increasing timestamps, so that replayed ones are
+	// ignored.
+	if bytes.Compare(ts[:], p.lastTimestamp[:]) <= 0 {
+		return nil, nil, false
+	}
+	p.lastTimestamp = ts
+
+	resp := make([]byte, messageResponseSize)
+	binary.LittleEndian.PutUint32(resp, messageResponseType)
+	copy(resp[responseReceiver:], msg[initiationSender:initiationEphemeral])
+	copy(resp[responseEphemeral:], responderEphemeral[:])
+
+	var tau digest
+	kdf(&ck, responderEphemeral[:], &ck)
+	mixHash(&h, &h, responderEphemeral[:])
+	kdf(&ck, ee, &ck)
+	kdf(&ck, se, &ck)
+	kdf(&ck, p.presharedKey[:], &ck, &tau, &key)
+	mixHash(&h, &h, tau[:])
+	newAEAD(&key).Seal(resp[responseEmpty:responseEmpty], zeroNonce[:], nil, h[:])
+
+	// The initiator sends with the first key.
+	var send, recv digest
+	kdf(&ck, nil, &recv, &send)
+	kp := e.newKeypair(&send, &recv, binary.LittleEndian.Uint32(msg[initiationSender:]), false /* initiator */)
+	kp.localIndex = e.addIndex(p, kp)
+	binary.LittleEndian.PutUint32(resp[responseSender:], kp.localIndex)
+	setMAC1(resp, &p.mac1Key)
+
+	// The keypair is only used to send once the initiator has confirmed it by
+	// sending data with it.
+	if p.next != nil {
+		e.removeIndex(p.next.localIndex)
+	}
+	p.next = kp
+	p.lastHandshake = e.clock.NowNanoseconds()
+	return resp, p, true
+}
+
+// consumeResponseLocked processes a handshake response, whose MAC1 was
+// checked, and returns the peer whose handshake it completes.
+//
+// Returns false if the response doesn't complete a handshake in progress.
+//
+// Precondition: e.mu must be read locked. On success, the peer's mu is
+// returned locked.
+func (e *Endpoint) consumeResponseLocked(msg []byte) (*Peer, bool) {
+	index := binary.LittleEndian.Uint32(msg[responseReceiver:])
+	p, kp, ok := e.lookupIndex(index)
+	if !ok || kp != nil {
+		return nil, false
+	}
+
+	var ephemeral Key
+	copy(ephemeral[:], msg[responseEphemeral:responseEmpty])
+	se, ok := sharedSecret(&e.privateKey, &ephemeral)
+	if !ok {
+		return nil, false
+	}
+
+	p.mu.Lock()
+	hs := &p.handshake
+	if p.removed || !hs.inProgress || hs.localIndex != index {
+		p.mu.Unlock()
+		return nil, false
+	}
+	ee, ok := sharedSecret(&hs.ephemeral, &ephemeral)
+	if !ok {
+		p.mu.Unlock()
+		return nil, false
+	}
+
+	var key, tau digest
+	ck, h := hs.chainKey, hs.hash
+	kdf(&ck, ephemeral[:], &ck)
+	mixHash(&h, &h, ephemeral[:])
+	kdf(&ck, ee, &ck)
+	kdf(&ck, se, &ck)
+	kdf(&ck, p.presharedKey[:], &ck, &tau, &key)
+	mixHash(&h, &h, tau[:])
+	if _, err := newAEAD(&key).Open(nil, zeroNonce[:], msg[responseEmpty:responseMAC1], h[:]); err != nil {
+		p.mu.Unlock()
+		return nil, false
+	}
+
+	var send, recv digest
+	kdf(&ck, nil, &send, &recv)
+	// The responder sends to the index of the initiation.
+	kp = e.newKeypair(&send, &recv, binary.LittleEndian.Uint32(msg[responseSender:]), true /* initiator */)
+	kp.localIndex = index
+	e.setIndex(index, p, kp)
+	*hs = handshake{}
+	p.rotateLocked(kp)
+	p.lastHandshake = e.clock.NowNanoseconds()
+	return p, true
+}
@@ -0,0 +1,479 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wireguard
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"math"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+)
+
+// Protocol limits, from the "Timers" section of the WireGuard paper.
+const (
+	rekeyAfterMessages  = 1 << 60
+	rejectAfterMessages = math.MaxUint64 - (1 << 13)
+	rekeyAfterTime      = 120 * time.Second
+	rejectAfterTime     = 180 * time.Second
+	rekeyAttemptTime    = 90 * time.Second
+	rekeyTimeout        = 5 * time.Second
+	keepaliveTimeout    = 10 * time.Second
+)
+
+// maxStagedPackets is the maximum number of packets queued for a peer while a
+// handshake with it is in progress.
+const maxStagedPackets = 128
+
+// PeerConfig is the configuration of a peer of a device.
+type PeerConfig struct {
+	// PublicKey is the public key of the peer, which identifies it.
+	PublicKey Key
+
+	// PresharedKey is an optional symmetric key mixed into the handshakes
+	// with the peer.
+	PresharedKey Key
+
+	// Endpoint is the address and port the peer is reached at. It is updated
+	// to the source of the authenticated messages received from the peer.
+	//
+	// If empty, packets can only be sent to the peer once it has sent a
+	// message.
+	Endpoint tcpip.FullAddress
+
+	// AllowedIPs are the subnets of the addresses that are routed to the peer,
+	// and that the packets received from it are accepted from.
+	AllowedIPs []tcpip.Subnet
+
+	// PersistentKeepalive is the interval at which keepalives are sent to the
+	// peer to keep stateful firewalls and NATs on the path open. Zero
+	// disables them.
+	PersistentKeepalive time.Duration
+}
+
+// PeerStatus is the configuration and state of a peer of a device.
+type PeerStatus struct {
+	PeerConfig
+
+	// LastHandshake is the time of the last completed handshake with the
+	// peer, in nanoseconds since the Unix epoch, or zero if there was none.
+	LastHandshake int64
+
+	// RxBytes and TxBytes are the numbers of bytes of the transport data
+	// messages received from and sent to the peer.
+	RxBytes uint64
+	TxBytes uint64
+}
+
+// Peer is a peer of a device.
+type Peer struct {
+	device    *Endpoint
+	publicKey Key
+
+	// mac1Key is the key of the MAC1 of the handshake messages sent to the
+	// peer.
+	mac1Key digest
+
+	mu                  sync.Mutex
+	removed             bool
+	presharedKey        Key
+	endpoint            tcpip.FullAddress
+	persistentKeepalive time.Duration
+	handshake           handshake
+
+	// lastTimestamp is the greatest timestamp of the initiations received
+	// from the peer.
+	lastTimestamp [tai64nSize]byte
+
+	// current is the keypair data is sent with. previous is kept to receive
+	// the data sent before a new handshake, and next was created by a
+	// handshake the peer initiated and is used once the peer sends data with
+	// it.
+	current  *keypair
+	previous *keypair
+	next     *keypair
+
+	// staged holds the packets to send once a handshake completes.
+	staged []buffer.View
+
+	lastHandshake int64
+	rxBytes       uint64
+	txBytes       uint64
+
+	// lastSent is the monotonic time at which the last transport data message
+	// was sent to the peer.
+	lastSent int64
+
+	retransmitTimer  tcpip.Timer
+	keepaliveTimer   tcpip.Timer
+	persistentTimer  tcpip.Timer
+	keepalivePending bool
+}
+
+// keypair holds the symmetric keys derived from a handshake.
+type keypair struct {
+	send        cipher.AEAD
+	recv        cipher.AEAD
+	localIndex  uint32
+	remoteIndex uint32
+	initiator   bool
+
+	// created is the monotonic time at which the handshake completed.
+	created int64
+
+	// sendCounter and replay are protected by the peer's mu.
+	sendCounter uint64
+	replay      replayFilter
+}
+
+// newKeypair returns a keypair that sends with the key send and receives with
+// the key recv. Its local index is left to the caller.
+func (e *Endpoint) newKeypair(send, recv *digest, remoteIndex uint32, initiator bool) *keypair {
+	return &keypair{
+		send:        newAEAD(send),
+		recv:        newAEAD(recv),
+		remoteIndex: remoteIndex,
+		initiator:   initiator,
+		created:     e.clock.NowMonotonic(),
+	}
+}
+
+// nonce returns the AEAD nonce of a transport data message with counter.
+func nonce(counter uint64) []byte {
+	var n [chacha20poly1305.NonceSize]byte
+	binary.LittleEndian.PutUint64(n[4:], counter)
+	return n[:]
+}
+
+// expired returns true if kp may no longer be used at time now.
+func (kp *keypair) expired(now int64) bool {
+	return now-kp.created >= int64(rejectAfterTime)
+}
+
+// rotateLocked makes kp, which was confirmed by a handshake, the current
+// keypair of p.
+//
+// Precondition: p.mu must be locked.
+func (p *Peer) rotateLocked(kp *keypair) {
+	if p.previous != nil {
+		p.device.removeIndex(p.previous.localIndex)
+	}
+	if p.next != nil && p.next != kp {
+		p.device.removeIndex(p.next.localIndex)
+	}
+	p.previous = p.current
+	p.current = kp
+	p.next = nil
+}
+
+// clearSessionsLocked forgets the handshake and keypairs of p.
+//
+// Precondition: p.mu must be locked.
+func (p *Peer) clearSessionsLocked() {
+	e := p.device
+	if p.handshake.inProgress {
+		e.removeIndex(p.handshake.localIndex)
+	}
+	p.handshake = handshake{}
+	for _, kp := range []*keypair{p.current, p.previous, p.next} {
+		if kp != nil {
+			e.removeIndex(kp.localIndex)
+		}
+	}
+	p.current, p.previous, p.next = nil, nil, nil
+	p.stopTimerLocked(&p.retransmitTimer)
+}
+
+// stopTimerLocked stops the timer *t, if any.
+//
+// Precondition: p.mu must be locked.
+func (p *Peer) stopTimerLocked(t *tcpip.Timer) {
+	if *t != nil {
+		(*t).Stop()
+		*t = nil
+	}
+}
+
+// scheduleLocked replaces the timer *t with one that calls f after d.
+//
+// Precondition: p.mu must be locked.
+func (p *Peer) scheduleLocked(t *tcpip.Timer, d time.Duration, f func()) {
+	p.stopTimerLocked(t)
+	*t = p.device.clock.AfterFunc(d, f)
+}
+
+// encryptLocked returns a transport data message carrying packet, sent with
+// the current keypair of p.
+//
+// Returns false if p has no usable keypair.
+//
+// Precondition: p.mu must be locked.
+func (p *Peer) encryptLocked(packet buffer.View) ([]byte, bool) {
+	kp := p.current
+	now := p.device.clock.NowMonotonic()
+	if kp == nil || kp.expired(now) || kp.sendCounter >= rejectAfterMessages {
+		return nil, false
+	}
+
+	// Packets are padded to a multiple of 16 bytes, without exceeding the
+	// MTU, to hide their exact size.
+	padded := (len(packet) + 15) &^ 15
+	if mtu := int(p.device.mtu); padded > mtu {
+		padded = mtu
+	}
+	if padded < len(packet) {
+		padded = len(packet)
+	}
+	msg := make([]byte, messageTransportHeaderSize+padded+chacha20poly1305.Overhead)
+	binary.LittleEndian.PutUint32(msg, messageTransportType)
+	binary.LittleEndian.PutUint32(msg[transportReceiver:], kp.remoteIndex)
+	binary.LittleEndian.PutUint64(msg[transportCounter:], kp.sendCounter)
+	copy(msg[messageTransportHeaderSize:], packet)
+	plaintext := msg[messageTransportHeaderSize:][:padded]
+	kp.send.Seal(plaintext[:0], nonce(kp.sendCounter), plaintext, nil)
+	kp.sendCounter++
+
+	p.txBytes += uint64(len(msg))
+	p.lastSent = now
+	return msg, true
+}
+
+// needsRekeyLocked returns true if a new handshake must be initiated to
+// replace the current keypair of p.
+//
+// Precondition: p.mu must be locked.
+func (p *Peer) needsRekeyLocked() bool {
+	kp := p.current
+	if kp == nil {
+		return true
+	}
+	if kp.sendCounter >= rekeyAfterMessages {
+		return true
+	}
+	return kp.initiator && p.device.clock.NowMonotonic()-kp.created >= int64(rekeyAfterTime)
+}
+
+// stageLocked queues packet until a handshake with p completes, dropping the
+// oldest staged packet if the queue is full.
+//
+// Precondition: p.mu must be locked.
+func (p *Peer) stageLocked(packet buffer.View) {
+	if len(p.staged) == maxStagedPackets {
+		copy(p.staged, p.staged[1:])
+		p.staged = p.staged[:len(p.staged)-1]
+	}
+	p.staged = append(p.staged, packet)
+}
+
+// flushStagedLocked returns the transport data messages carrying the staged
+// packets of p, once it has a usable keypair.
+//
+// Precondition: p.mu must be locked.
+func (p *Peer) flushStagedLocked() [][]byte {
+	var msgs [][]byte
+	for len(p.staged) != 0 {
+		msg, ok := p.encryptLocked(p.staged[0])
+		if !ok {
+			break
+		}
+		msgs = append(msgs, msg)
+		p.staged[0] = nil
+		p.staged = p.staged[1:]
+	}
+	if len(p.staged) == 0 {
+		p.staged = nil
+	}
+	return msgs
+}
+
+// send sends packet to p, or stages it until a handshake completes. An empty
+// packet is a keepalive.
+func (p *Peer) send(packet buffer.View) *tcpip.Error {
+	p.mu.Lock()
+	dst := p.endpoint
+	if len(dst.Addr) == 0 {
+		p.mu.Unlock()
+		return tcpip.ErrDestinationRequired
+	}
+	msg, ok := p.encryptLocked(packet)
+	if !ok {
+		p.stageLocked(packet)
+	}
+	rekey := p.needsRekeyLocked()
+	p.mu.Unlock()
+
+	if rekey {
+		p.initiateHandshake(false /* retry */)
+	}
+	if !ok {
+		return nil
+	}
+	return p.device.sendTo(dst, msg)
+}
+
+// sendKeepalive sends a keepalive to p.
+func (p *Peer) sendKeepalive() {
+	_ = p.send(nil)
+}
+
+// initiateHandshake sends a handshake initiation to p, unless a handshake is
+// already in progress. retry is true when the initiation of the handshake in
+// progress is retransmitted because it wasn't answered.
+func (p *Peer) initiateHandshake(retry bool) {
+	e := p.device
+	e.mu.RLock()
+	p.mu.Lock()
+	hs := &p.handshake
+	now := e.clock.NowMonotonic()
+	if p.removed || hs.inProgress != retry || len(p.endpoint.Addr) == 0 {
+		p.mu.Unlock()
+		e.mu.RUnlock()
+		return
+	}
+	if !retry {
+		hs.started = now
+	} else if now-hs.started >= int64(rekeyAttemptTime) {
+		// Give up, and drop the packets waiting for the handshake.
+		e.removeIndex(hs.localIndex)
+		*hs = handshake{}
+		p.staged = nil
+		p.mu.Unlock()
+		e.mu.RUnlock()
+		return
+	}
+	msg, ok := e.createInitiationLocked(p)
+	if ok {
+		p.scheduleLocked(&p.retransmitTimer, rekeyTimeout, func() {
+			p.initiateHandshake(true /* retry */)
+		})
+	} else if hs.inProgress {
+		e.removeIndex(hs.localIndex)
+		*hs = handshake{}
+	}
+	dst := p.endpoint
+	p.mu.Unlock()
+	e.mu.RUnlock()
+
+	if ok {
+		_ = e.sendTo(dst, msg)
+	}
+}
+
+// receivedDataLocked schedules a keepalive, unless data is sent to p before
+// KeepaliveTimeout elapses, after data was received from p.
+//
+// Precondition: p.mu must be locked.
+func (p *Peer) receivedDataLocked() {
+	if p.keepalivePending {
+		return
+	}
+	p.keepalivePending = true
+	received := p.device.clock.NowMonotonic()
+	p.scheduleLocked(&p.keepaliveTimer, keepaliveTimeout, func() {
+		p.mu.Lock()
+		p.keepalivePending = false
+		send := !p.removed && p.lastSent < received
+		p.mu.Unlock()
+		if send {
+			p.sendKeepalive()
+		}
+	})
+}
+
+// schedulePersistentKeepaliveLocked schedules the next persistent keepalive
+// of p.
+//
+// Precondition: p.mu must be locked.
+func (p *Peer) schedulePersistentKeepaliveLocked() {
+	if p.persistentKeepalive == 0 {
+		p.stopTimerLocked(&p.persistentTimer)
+		return
+	}
+	p.scheduleLocked(&p.persistentTimer, p.persistentKeepalive, func() {
+		p.mu.Lock()
+		if p.removed || p.persistentKeepalive == 0 {
+			p.mu.Unlock()
+			return
+		}
+		idle := p.device.clock.NowMonotonic()-p.lastSent >= int64(p.persistentKeepalive)
+		p.schedulePersistentKeepaliveLocked()
+		p.mu.Unlock()
+		if idle {
+			p.sendKeepalive()
+		}
+	})
+}
+
+// status returns the configuration and state of p.
+func (p *Peer) status() PeerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PeerStatus{
+		PeerConfig: PeerConfig{
+			PublicKey:           p.publicKey,
+			PresharedKey:        p.presharedKey,
+			Endpoint:            p.endpoint,
+			PersistentKeepalive: p.persistentKeepalive,
+		},
+		LastHandshake: p.lastHandshake,
+		RxBytes:       p.rxBytes,
+		TxBytes:       p.txBytes,
+	}
+}
+
+// Replay filter parameters. The window holds the counters of the last
+// (replayBlocks-1)*64 messages; one block is kept free to slide it.
+const (
+	replayBlocks     = 128
+	replayBlockBits  = 64
+	replayWindowSize = (replayBlocks - 1) * replayBlockBits
+)
+
+// replayFilter rejects the transport data messages whose counter was already
+// received, or is too old to tell, as described in RFC 6479.
+type replayFilter struct {
+	last uint64
+	ring [replayBlocks]uint64
+}
+
+// accept returns true if counter wasn't received yet, and records it.
+func (f *replayFilter) accept(counter uint64) bool {
+	block := counter / replayBlockBits
+	if counter > f.last {
+		// Slide the window, clearing the blocks it now covers.
+		current := f.last / replayBlockBits
+		n := block - current
+		if n > replayBlocks {
+			n = replayBlocks
+		}
+		for i := current + 1; i <= current+n; i++ {
+			f.ring[i%replayBlocks] = 0
+		}
+		f.last = counter
+	} else if f.last-counter > replayWindowSize {
+		return false
+	}
+	bit := uint64(1) << (counter % replayBlockBits)
+	b := &f.ring[block%replayBlocks]
+	if *b&bit != 0 {
+		return false
+	}
+	*b |= bit
+	return true
+}
@@ -0,0 +1,249 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wireguard
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// IpcGet returns the configuration and state of the device as the reply of
+// the "get=1" operation of the WireGuard userspace API, without the final
+// errno line.
+func (e *Endpoint) IpcGet() string {
+	e.mu.RLock()
+	privateKey := e.privateKey
+	listenPort := e.listenPort
+	e.mu.RUnlock()
+
+	var b strings.Builder
+	if !privateKey.IsZero() {
+		fmt.Fprintf(&b, "private_key=%s\n", hex.EncodeToString(privateKey[:]))
+	}
+	fmt.Fprintf(&b, "listen_port=%d\n", listenPort)
+	for _, p := range e.Peers() {
+		fmt.Fprintf(&b, "public_key=%s\n", hex.EncodeToString(p.PublicKey[:]))
+		fmt.Fprintf(&b, "preshared_key=%s\n", hex.EncodeToString(p.PresharedKey[:]))
+		b.WriteString("protocol_version=1\n")
+		if len(p.Endpoint.Addr) != 0 {
+			fmt.Fprintf(&b, "endpoint=%s\n", net.JoinHostPort(net.IP(p.Endpoint.Addr).String(), strconv.Itoa(int(p.Endpoint.Port))))
+		}
+		fmt.Fprintf(&b, "last_handshake_time_sec=%d\n", p.LastHandshake/int64(time.Second))
+		fmt.Fprintf(&b, "last_handshake_time_nsec=%d\n", p.LastHandshake%int64(time.Second))
+		fmt.Fprintf(&b, "tx_bytes=%d\n", p.TxBytes)
+		fmt.Fprintf(&b, "rx_bytes=%d\n", p.RxBytes)
+		fmt.Fprintf(&b, "persistent_keepalive_interval=%d\n", p.PersistentKeepalive/time.Second)
+		for _, subnet := range p.AllowedIPs {
+			fmt.Fprintf(&b, "allowed_ip=%s/%d\n", net.IP(subnet.ID()), subnet.Prefix())
+		}
+	}
+	return b.String()
+}
+
+// IpcSet applies config, the body of a "set=1" operation of the WireGuard
+// userspace API, to the device.
+//
+// The whole configuration is parsed before any of it is applied, so that a
+// malformed configuration changes nothing.
+func (e *Endpoint) IpcSet(config string) *tcpip.Error {
+	var (
+		privateKey   *Key
+		listenPort   *uint16
+		replacePeers bool
+		updates      []*peerUpdate
+	)
+	for _, line := range strings.Split(config, "\n") {
+		if line == "" {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return tcpip.ErrInvalidOptionValue
+		}
+		key, value := kv[0], kv[1]
+
+		// The device keys come before the peers, and the peer keys apply to
+		// the last public_key.
+		var u *peerUpdate
+		if len(updates) != 0 {
+			u = updates[len(updates)-1]
+		}
+		switch key {
+		case "private_key", "listen_port", "fwmark", "replace_peers":
+			if u != nil {
+				return tcpip.ErrInvalidOptionValue
+			}
+		case "public_key":
+			k, err := parseHexKey(value)
+			if err != nil {
+				return err
+			}
+			updates = append(updates, &peerUpdate{publicKey: k})
+			continue
+		default:
+			if u == nil {
+				return tcpip.ErrInvalidOptionValue
+			}
+		}
+
+		switch key {
+		case "private_key":
+			k, err := parseHexKey(value)
+			if err != nil {
+				return err
+			}
+			privateKey = &k
+		case "listen_port":
+			port, err := strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				return tcpip.ErrInvalidOptionValue
+			}
+			p := uint16(port)
+			listenPort = &p
+		case "fwmark":
+			// Packets can't be marked.
+			if value != "0" && value != "" {
+				return tcpip.ErrNotSupported
+			}
+		case "replace_peers":
+			if value != "true" {
+				return tcpip.ErrInvalidOptionValue
+			}
+			replacePeers = true
+		case "remove":
+			if value != "true" {
+				return tcpip.ErrInvalidOptionValue
+			}
+			u.remove = true
+		case "update_only":
+			if value != "true" {
+				return tcpip.ErrInvalidOptionValue
+			}
+			u.updateOnly = true
+		case "preshared_key":
+			k, err := parseHexKey(value)
+			if err != nil {
+				return err
+			}
+			u.presharedKey = &k
+		case "endpoint":
+			addr, err := parseEndpoint(value)
+			if err != nil {
+				return err
+			}
+			u.endpoint = &addr
+		case "persistent_keepalive_interval":
+			secs, err := strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				return tcpip.ErrInvalidOptionValue
+			}
+			d := time.Duration(secs) * time.Second
+			u.persistentKeepalive = &d
+		case "replace_allowed_ips":
+			if value != "true" {
+				return tcpip.ErrInvalidOptionValue
+			}
+			u.replaceAllowedIPs = true
+		case "allowed_ip":
+			subnet, err := parseAllowedIP(value)
+			if err != nil {
+				return err
+			}
+			u.allowedIPs = append(u.allowedIPs, subnet)
+		case "protocol_version":
+			if value != "1" {
+				return tcpip.ErrNotSupported
+			}
+		default:
+			return tcpip.ErrInvalidOptionValue
+		}
+	}
+
+	e.mu.Lock()
+	if privateKey != nil {
+		e.setPrivateKeyLocked(*privateKey)
+	}
+	if listenPort != nil && (*listenPort == 0 || *listenPort != e.listenPort) && !e.closed {
+		if err := e.bindLocked(*listenPort); err != nil {
+			e.mu.Unlock()
+			return err
+		}
+	}
+	if replacePeers {
+		for _, p := range e.peers {
+			e.removePeerLocked(p)
+		}
+	}
+	e.mu.Unlock()
+
+	for _, u := range updates {
+		if err := e.updatePeer(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseHexKey parses the hexadecimal encoding of a key.
+func parseHexKey(s string) (Key, *tcpip.Error) {
+	var k Key
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != KeySize {
+		return k, tcpip.ErrInvalidOptionValue
+	}
+	copy(k[:], b)
+	return k, nil
+}
+
+// parseEndpoint parses an endpoint formatted as "address:port", with IPv6
+// addresses in brackets.
+func parseEndpoint(s string) (tcpip.FullAddress, *tcpip.Error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return tcpip.FullAddress{}, tcpip.ErrInvalidOptionValue
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return tcpip.FullAddress{}, tcpip.ErrBadAddress
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return tcpip.FullAddress{}, tcpip.ErrInvalidOptionValue
+	}
+	return tcpip.FullAddress{Addr: tcpip.Address(ip), Port: uint16(p)}, nil
+}
+
+// parseAllowedIP parses an allowed IP in CIDR notation. The bits of the
+// address outside of the prefix are ignored.
+func parseAllowedIP(s string) (tcpip.Subnet, *tcpip.Error) {
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return tcpip.Subnet{}, tcpip.ErrInvalidOptionValue
+	}
+	subnet, err := tcpip.NewSubnet(tcpip.Address(ipNet.IP), tcpip.AddressMask(ipNet.Mask))
+	if err != nil {
+		return tcpip.Subnet{}, tcpip.ErrInvalidOptionValue
+	}
+	return subnet, nil
+}
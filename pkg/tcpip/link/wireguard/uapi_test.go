@@ -0,0 +1,127 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wireguard
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+)
+
+func TestIpc(t *testing.T) {
+	link := channel.New(1, 1500, linkAddr1)
+	_, dev := newTestStack(t, link, outerAddr1, Options{})
+
+	privateKey := GeneratePrivateKey()
+	peer1, peer2 := GeneratePrivateKey().PublicKey(), GeneratePrivateKey().PublicKey()
+	psk := GeneratePrivateKey()
+	hexKey := func(k Key) string { return hex.EncodeToString(k[:]) }
+
+	// Peers are listed by public key.
+	if hexKey(peer1) > hexKey(peer2) {
+		peer1, peer2 = peer2, peer1
+	}
+	if err := dev.IpcSet("private_key=" + hexKey(privateKey) + "\n" +
+		"listen_port=51820\n" +
+		"replace_peers=true\n" +
+		"public_key=" + hexKey(peer1) + "\n" +
+		"preshared_key=" + hexKey(psk) + "\n" +
+		"endpoint=[fd00::1]:51821\n" +
+		"replace_allowed_ips=true\n" +
+		"allowed_ip=10.0.0.1/24\n" +
+		"allowed_ip=fd00::/64\n" +
+		"public_key=" + hexKey(peer2) + "\n" +
+		"protocol_version=1\n" +
+		"endpoint=192.168.0.2:51822\n" +
+		"allowed_ip=10.1.0.0/16\n" +
+		"\n"); err != nil {
+		t.Fatalf("dev.IpcSet(_): %s", err)
+	}
+	if got, want := dev.PublicKey(), privateKey.PublicKey(); got != want {
+		t.Errorf("got dev.PublicKey() = %s, want = %s", got, want)
+	}
+	if got, want := dev.ListenPort(), uint16(51820); got != want {
+		t.Errorf("got dev.ListenPort() = %d, want = %d", got, want)
+	}
+	want := "private_key=" + hexKey(privateKey) + "\n" +
+		"listen_port=51820\n" +
+		"public_key=" + hexKey(peer1) + "\n" +
+		"preshared_key=" + hexKey(psk) + "\n" +
+		"protocol_version=1\n" +
+		"endpoint=[fd00::1]:51821\n" +
+		"last_handshake_time_sec=0\n" +
+		"last_handshake_time_nsec=0\n" +
+		"tx_bytes=0\n" +
+		"rx_bytes=0\n" +
+		"persistent_keepalive_interval=0\n" +
+		"allowed_ip=fd00::/64\n" +
+		"allowed_ip=10.0.0.0/24\n" +
+		"public_key=" + hexKey(peer2) + "\n" +
+		"preshared_key=" + hexKey(Key{}) + "\n" +
+		"protocol_version=1\n" +
+		"endpoint=192.168.0.2:51822\n" +
+		"last_handshake_time_sec=0\n" +
+		"last_handshake_time_nsec=0\n" +
+		"tx_bytes=0\n" +
+		"rx_bytes=0\n" +
+		"persistent_keepalive_interval=0\n" +
+		"allowed_ip=10.1.0.0/16\n"
+	if got := dev.IpcGet(); got != want {
+		t.Errorf("got dev.IpcGet() = %q, want = %q", got, want)
+	}
+
+	peers := dev.Peers()
+	if len(peers) != 2 {
+		t.Fatalf("got len(dev.Peers()) = %d, want = 2", len(peers))
+	}
+	if got, want := peers[0].Endpoint, (tcpip.FullAddress{Addr: "\xfd\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01", Port: 51821}); got != want {
+		t.Errorf("got peers[0].Endpoint = %+v, want = %+v", got, want)
+	}
+
+	// A malformed configuration changes nothing.
+	for _, config := range []string{
+		"listen_port=65536\n",
+		"public_key=" + hexKey(peer1) + "\nprivate_key=" + hexKey(privateKey) + "\n",
+		"public_key=" + hexKey(peer1) + "\nremove=true\nallowed_ip=10.0.0.0\n",
+		"public_key=" + hexKey(peer1) + "\nendpoint=10.0.0.1\n",
+		"public_key=00\n",
+		"endpoint=10.0.0.1:1\n",
+		"unknown=1\n",
+	} {
+		if err := dev.IpcSet(config); err == nil {
+			t.Errorf("got dev.IpcSet(%q) = nil, want an error", config)
+		}
+	}
+	if got := dev.IpcGet(); got != want {
+		t.Errorf("got dev.IpcGet() = %q after malformed configurations, want = %q", got, want)
+	}
+
+	// Peers are updated and removed.
+	if err := dev.IpcSet("public_key=" + hexKey(peer1) + "\nremove=true\n" +
+		"public_key=" + hexKey(peer2) + "\nupdate_only=true\npersistent_keepalive_interval=25\nreplace_allowed_ips=true\n" +
+		"public_key=" + hexKey(GeneratePrivateKey().PublicKey()) + "\nupdate_only=true\n"); err != nil {
+		t.Fatalf("dev.IpcSet(_): %s", err)
+	}
+	peers = dev.Peers()
+	if len(peers) != 1 {
+		t.Fatalf("got len(dev.Peers()) = %d, want = 1", len(peers))
+	}
+	if got := peers[0]; got.PublicKey != peer2 || got.PersistentKeepalive != 25*time.Second || len(got.AllowedIPs) != 0 {
+		t.Errorf("got peer %s with PersistentKeepalive = %s and AllowedIPs = %v, want peer %s with 25s and none", got.PublicKey, got.PersistentKeepalive, got.AllowedIPs, peer2)
+	}
+}
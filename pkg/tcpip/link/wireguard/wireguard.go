@@ -0,0 +1,811 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wireguard provides a link endpoint that implements a WireGuard
+// device on top of the UDP sockets of a netstack stack.
+//
+// A device holds a Curve25519 private key, listens on a UDP port and has
+// peers identified by their public keys. Packets written to the device are
+// sent to the peer whose allowed IPs best match their destination, encrypted
+// with the keys of a Noise IKpsk2 handshake; packets received from a peer are
+// decrypted and delivered if their source is one of its allowed IPs.
+//
+// Devices are configured with the Go API (SetPrivateKey, SetPeer, ...) or
+// with the key=value text of the cross-platform userspace API used by the wg
+// tool (IpcSet and IpcGet).
+//
+// Devices never send cookie replies, so the MAC2 of handshake messages is
+// neither checked nor set, and handshakes aren't rate limited under load.
+// The routes to the endpoints of the peers must not go through the device.
+package wireguard
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// DefaultMTU is the default MTU of devices, which leaves room for the IPv6,
+// UDP and WireGuard headers in a 1500 bytes MTU.
+const DefaultMTU = 1420
+
+// Options holds the configuration of a device.
+type Options struct {
+	// PrivateKey is the private key of the device. If zero, the device
+	// can't handshake until it is set with SetPrivateKey.
+	PrivateKey Key
+
+	// ListenPort is the UDP port the device listens on. If zero, a port is
+	// picked by the stack.
+	ListenPort uint16
+
+	// MTU is the MTU of the device. If zero, DefaultMTU is used.
+	MTU uint32
+}
+
+// Stats holds the packet counters of a device.
+type Stats struct {
+	// InvalidMessages is the number of received messages that were dropped
+	// because they were malformed, failed authentication or were replayed.
+	InvalidMessages tcpip.StatCounter
+
+	// ForbiddenSources is the number of decrypted packets that were dropped
+	// because their source isn't an allowed IP of the peer that sent them.
+	ForbiddenSources tcpip.StatCounter
+}
+
+// Endpoint is a WireGuard device link endpoint.
+type Endpoint struct {
+	stack *stack.Stack
+	clock tcpip.Clock
+	mtu   uint32
+	stats Stats
+
+	// readers holds the goroutines reading the sockets.
+	readers sync.WaitGroup
+
+	mu         sync.RWMutex
+	dispatcher stack.NetworkDispatcher
+	privateKey Key
+	publicKey  Key
+
+	// mac1Key is the key of the MAC1 of the handshake messages sent to the
+	// device.
+	mac1Key    digest
+	listenPort uint16
+	sockets    []*socket
+	peers      map[Key]*Peer
+	allowedIPs allowedIPs
+	closed     bool
+
+	// indexMu protects indices. It may be locked while holding mu and the
+	// peers' mu.
+	indexMu sync.RWMutex
+
+	// indices maps the local indices of the handshakes in progress and of the
+	// keypairs to their peer.
+	indices map[uint32]index
+}
+
+var _ stack.LinkEndpoint = (*Endpoint)(nil)
+
+// index is the handshake or keypair a local index stands for.
+type index struct {
+	peer *Peer
+
+	// keypair is nil for the index of a handshake.
+	keypair *keypair
+}
+
+// socket is a UDP endpoint of the stack the device sends and receives
+// messages through.
+type socket struct {
+	netProto tcpip.NetworkProtocolNumber
+	ep       tcpip.Endpoint
+	wq       waiter.Queue
+	done     chan struct{}
+}
+
+// New returns a device that sends and receives messages through the UDP
+// endpoints of s, which must have the UDP protocol and IPv4 or IPv6 (or both)
+// registered.
+func New(s *stack.Stack, opts Options) (*Endpoint, *tcpip.Error) {
+	if opts.MTU == 0 {
+		opts.MTU = DefaultMTU
+	}
+	e := &Endpoint{
+		stack:   s,
+		clock:   s.Clock(),
+		mtu:     opts.MTU,
+		peers:   make(map[Key]*Peer),
+		indices: make(map[uint32]index),
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.setPrivateKeyLocked(opts.PrivateKey)
+	if err := e.bindLocked(opts.ListenPort); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Stats returns the device's packet counters.
+func (e *Endpoint) Stats() *Stats {
+	return &e.stats
+}
+
+// Close closes the sockets of the device and stops its timers.
+func (e *Endpoint) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	e.closed = true
+	closeSockets(e.sockets)
+	e.sockets = nil
+	for _, p := range e.peers {
+		p.mu.Lock()
+		p.stopLocked()
+		p.mu.Unlock()
+	}
+}
+
+// PublicKey returns the public key of the device, or the zero key if it has
+// no private key.
+func (e *Endpoint) PublicKey() Key {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.publicKey
+}
+
+// SetPrivateKey sets the private key of the device.
+//
+// The sessions with the peers are reset, and a peer whose public key is the
+// public key of the device is removed.
+func (e *Endpoint) SetPrivateKey(k Key) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.setPrivateKeyLocked(k)
+}
+
+// Precondition: e.mu must be locked.
+func (e *Endpoint) setPrivateKeyLocked(k Key) {
+	if k == e.privateKey {
+		return
+	}
+	e.privateKey = k
+	e.publicKey = Key{}
+	if !k.IsZero() {
+		e.publicKey = k.PublicKey()
+		if p, ok := e.peers[e.publicKey]; ok {
+			e.removePeerLocked(p)
+		}
+	}
+	e.mac1Key = mac1Key(&e.publicKey)
+	for _, p := range e.peers {
+		p.mu.Lock()
+		p.clearSessionsLocked()
+		p.mu.Unlock()
+	}
+}
+
+// ListenPort returns the UDP port the device listens on.
+func (e *Endpoint) ListenPort() uint16 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.listenPort
+}
+
+// SetListenPort makes the device listen on port, or on a port picked by the
+// stack if port is zero.
+func (e *Endpoint) SetListenPort(port uint16) *tcpip.Error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return tcpip.ErrClosedForReceive
+	}
+	if port != 0 && port == e.listenPort {
+		return nil
+	}
+	return e.bindLocked(port)
+}
+
+// SetPeer adds the peer identified by cfg.PublicKey to the device, or
+// replaces its configuration if it already is a peer. The sessions with an
+// existing peer are kept.
+func (e *Endpoint) SetPeer(cfg PeerConfig) *tcpip.Error {
+	endpoint := cfg.Endpoint
+	keepalive := cfg.PersistentKeepalive
+	return e.updatePeer(&peerUpdate{
+		publicKey:           cfg.PublicKey,
+		presharedKey:        &cfg.PresharedKey,
+		endpoint:            &endpoint,
+		persistentKeepalive: &keepalive,
+		replaceAllowedIPs:   true,
+		allowedIPs:          cfg.AllowedIPs,
+	})
+}
+
+// RemovePeer removes the peer identified by publicKey from the device, if it
+// is one of its peers.
+func (e *Endpoint) RemovePeer(publicKey Key) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if p, ok := e.peers[publicKey]; ok {
+		e.removePeerLocked(p)
+	}
+}
+
+// Peers returns the configuration and state of the peers of the device,
+// sorted by public key.
+func (e *Endpoint) Peers() []PeerStatus {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	peers := make([]PeerStatus, 0, len(e.peers))
+	for _, p := range e.peers {
+		s := p.status()
+		s.AllowedIPs = e.allowedIPs.subnets(p)
+		peers = append(peers, s)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return bytes.Compare(peers[i].PublicKey[:], peers[j].PublicKey[:]) < 0
+	})
+	return peers
+}
+
+// peerUpdate is a change to the configuration of a peer. Nil fields are left
+// unchanged.
+type peerUpdate struct {
+	publicKey Key
+
+	// remove removes the peer.
+	remove bool
+
+	// updateOnly ignores the update if the peer doesn't exist.
+	updateOnly bool
+
+	presharedKey        *Key
+	endpoint            *tcpip.FullAddress
+	persistentKeepalive *time.Duration
+
+	// replaceAllowedIPs removes the allowed IPs of the peer before adding
+	// allowedIPs.
+	replaceAllowedIPs bool
+	allowedIPs        []tcpip.Subnet
+}
+
+// updatePeer applies u to the configuration of the device.
+func (e *Endpoint) updatePeer(u *peerUpdate) *tcpip.Error {
+	if u.endpoint != nil {
+		switch len(u.endpoint.Addr) {
+		case 0, header.IPv4AddressSize, header.IPv6AddressSize:
+		default:
+			return tcpip.ErrBadAddress
+		}
+	}
+
+	e.mu.Lock()
+	p, ok := e.peers[u.publicKey]
+	if u.remove {
+		if ok {
+			e.removePeerLocked(p)
+		}
+		e.mu.Unlock()
+		return nil
+	}
+	if !ok {
+		// Like Linux, a peer with the public key of the device is ignored.
+		if u.updateOnly || (!e.publicKey.IsZero() && u.publicKey == e.publicKey) {
+			e.mu.Unlock()
+			return nil
+		}
+		p = &Peer{
+			device:    e,
+			publicKey: u.publicKey,
+			mac1Key:   mac1Key(&u.publicKey),
+		}
+		e.peers[u.publicKey] = p
+	}
+	if u.replaceAllowedIPs {
+		e.allowedIPs.removePeer(p)
+	}
+	for _, subnet := range u.allowedIPs {
+		e.allowedIPs.insert(subnet, p)
+	}
+
+	p.mu.Lock()
+	if u.presharedKey != nil {
+		p.presharedKey = *u.presharedKey
+	}
+	if u.endpoint != nil {
+		p.endpoint = *u.endpoint
+	}
+	// Like Linux, a keepalive is sent when persistent keepalives are enabled
+	// so that the peer can reach the device right away.
+	keepalive := false
+	if u.persistentKeepalive != nil {
+		keepalive = p.persistentKeepalive == 0 && *u.persistentKeepalive != 0 && !e.closed
+		p.persistentKeepalive = *u.persistentKeepalive
+		if !e.closed {
+			p.schedulePersistentKeepaliveLocked()
+		}
+	}
+	p.mu.Unlock()
+	e.mu.Unlock()
+
+	if keepalive {
+		p.sendKeepalive()
+	}
+	return nil
+}
+
+// removePeerLocked removes p from the device.
+//
+// Precondition: e.mu must be locked.
+func (e *Endpoint) removePeerLocked(p *Peer) {
+	delete(e.peers, p.publicKey)
+	e.allowedIPs.removePeer(p)
+	p.mu.Lock()
+	p.stopLocked()
+	p.mu.Unlock()
+}
+
+// stopLocked stops p once it was removed or its device closed.
+//
+// Precondition: p.mu must be locked.
+func (p *Peer) stopLocked() {
+	p.removed = true
+	p.clearSessionsLocked()
+	p.stopTimerLocked(&p.keepaliveTimer)
+	p.stopTimerLocked(&p.persistentTimer)
+	p.staged = nil
+}
+
+// addIndex returns a new random local index for the handshake or keypair kp
+// of p.
+func (e *Endpoint) addIndex(p *Peer, kp *keypair) uint32 {
+	e.indexMu.Lock()
+	defer e.indexMu.Unlock()
+	for {
+		var b [4]byte
+		randomBytes(b[:])
+		i := binary.LittleEndian.Uint32(b[:])
+		if _, ok := e.indices[i]; !ok {
+			e.indices[i] = index{peer: p, keypair: kp}
+			return i
+		}
+	}
+}
+
+// setIndex makes the local index i stand for the keypair kp of p.
+func (e *Endpoint) setIndex(i uint32, p *Peer, kp *keypair) {
+	e.indexMu.Lock()
+	defer e.indexMu.Unlock()
+	e.indices[i] = index{peer: p, keypair: kp}
+}
+
+// removeIndex frees the local index i.
+func (e *Endpoint) removeIndex(i uint32) {
+	e.indexMu.Lock()
+	defer e.indexMu.Unlock()
+	delete(e.indices, i)
+}
+
+// lookupIndex returns the peer and keypair the local index i stands for.
+func (e *Endpoint) lookupIndex(i uint32) (*Peer, *keypair, bool) {
+	e.indexMu.RLock()
+	defer e.indexMu.RUnlock()
+	idx, ok := e.indices[i]
+	return idx.peer, idx.keypair, ok
+}
+
+// bindLocked replaces the sockets of the device with sockets bound to port.
+//
+// Precondition: e.mu must be locked.
+func (e *Endpoint) bindLocked(port uint16) *tcpip.Error {
+	var sockets []*socket
+	for _, netProto := range []tcpip.NetworkProtocolNumber{header.IPv4ProtocolNumber, header.IPv6ProtocolNumber} {
+		if e.stack.NetworkProtocolInstance(netProto) == nil {
+			continue
+		}
+		s := &socket{
+			netProto: netProto,
+			done:     make(chan struct{}),
+		}
+		ep, err := e.stack.NewEndpoint(header.UDPProtocolNumber, netProto, &s.wq)
+		if err != nil {
+			closeSockets(sockets)
+			return err
+		}
+		s.ep = ep
+		if netProto == header.IPv6ProtocolNumber {
+			ep.SocketOptions().SetV6Only(true)
+		}
+		if err := ep.Bind(tcpip.FullAddress{Port: port}); err != nil {
+			ep.Close()
+			closeSockets(sockets)
+			return err
+		}
+		if port == 0 {
+			// The sockets of the other protocols use the port picked for
+			// the first one.
+			addr, err := ep.GetLocalAddress()
+			if err != nil {
+				ep.Close()
+				closeSockets(sockets)
+				return err
+			}
+			port = addr.Port
+		}
+		sockets = append(sockets, s)
+	}
+	if len(sockets) == 0 {
+		return tcpip.ErrUnknownProtocol
+	}
+
+	closeSockets(e.sockets)
+	e.sockets = sockets
+	e.listenPort = port
+	for _, s := range sockets {
+		e.readers.Add(1)
+		go e.readLoop(s)
+	}
+	return nil
+}
+
+// closeSockets closes sockets and stops their readers.
+func closeSockets(sockets []*socket) {
+	for _, s := range sockets {
+		close(s.done)
+		s.ep.Close()
+	}
+}
+
+// readLoop handles the messages received by s until it is closed.
+func (e *Endpoint) readLoop(s *socket) {
+	defer e.readers.Done()
+	entry, ch := waiter.NewChannelEntry(nil)
+	s.wq.EventRegister(&entry, waiter.EventIn)
+	defer s.wq.EventUnregister(&entry)
+	for {
+		var src tcpip.FullAddress
+		msg, _, err := s.ep.Read(&src)
+		switch err {
+		case nil:
+			e.handleMessage(src, msg)
+		case tcpip.ErrWouldBlock:
+			select {
+			case <-ch:
+			case <-s.done:
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// sendTo sends msgs to dst through the socket of its network protocol.
+func (e *Endpoint) sendTo(dst tcpip.FullAddress, msgs ...[]byte) *tcpip.Error {
+	netProto := header.IPv4ProtocolNumber
+	if len(dst.Addr) == header.IPv6AddressSize {
+		netProto = header.IPv6ProtocolNumber
+	}
+	var s *socket
+	e.mu.RLock()
+	for _, sock := range e.sockets {
+		if sock.netProto == netProto {
+			s = sock
+		}
+	}
+	e.mu.RUnlock()
+	if s == nil {
+		return tcpip.ErrAddressFamilyNotSupported
+	}
+
+	for _, msg := range msgs {
+		_, ch, err := s.ep.Write(tcpip.SlicePayload(msg), tcpip.WriteOptions{To: &dst})
+		if err == tcpip.ErrNoLinkAddress {
+			// Send the message once the link address of the next hop is
+			// resolved.
+			go func(msg []byte) {
+				<-ch
+				_, _, _ = s.ep.Write(tcpip.SlicePayload(msg), tcpip.WriteOptions{To: &dst})
+			}(msg)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleMessage handles a message received from src.
+func (e *Endpoint) handleMessage(src tcpip.FullAddress, msg buffer.View) {
+	ok := false
+	if len(msg) >= 4 {
+		switch binary.LittleEndian.Uint32(msg) {
+		case messageInitiationType:
+			ok = e.handleInitiation(src, msg)
+		case messageResponseType:
+			ok = e.handleResponse(src, msg)
+		case messageTransportType:
+			ok = e.handleTransport(src, msg)
+		}
+		// Cookie replies are unexpected, as MAC2 is never set.
+	}
+	if !ok {
+		e.stats.InvalidMessages.Increment()
+	}
+}
+
+// handleInitiation handles a handshake initiation received from src, and
+// returns false if it is invalid.
+func (e *Endpoint) handleInitiation(src tcpip.FullAddress, msg buffer.View) bool {
+	if len(msg) != messageInitiationSize {
+		return false
+	}
+	e.mu.RLock()
+	if !checkMAC1(msg, &e.mac1Key) {
+		e.mu.RUnlock()
+		return false
+	}
+	resp, p, ok := e.consumeInitiationLocked(msg)
+	e.mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	p.mu.Lock()
+	p.endpoint = src
+	p.mu.Unlock()
+	_ = e.sendTo(src, resp)
+	return true
+}
+
+// handleResponse handles a handshake response received from src, and returns
+// false if it is invalid.
+func (e *Endpoint) handleResponse(src tcpip.FullAddress, msg buffer.View) bool {
+	if len(msg) != messageResponseSize {
+		return false
+	}
+	e.mu.RLock()
+	if !checkMAC1(msg, &e.mac1Key) {
+		e.mu.RUnlock()
+		return false
+	}
+	p, ok := e.consumeResponseLocked(msg)
+	e.mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	p.endpoint = src
+	p.stopTimerLocked(&p.retransmitTimer)
+	msgs := p.flushStagedLocked()
+	if len(msgs) == 0 {
+		// Confirm the handshake, so that the peer can send data.
+		if keepalive, ok := p.encryptLocked(nil); ok {
+			msgs = append(msgs, keepalive)
+		}
+	}
+	p.mu.Unlock()
+	_ = e.sendTo(src, msgs...)
+	return true
+}
+
+// handleTransport handles a transport data message received from src, and
+// returns false if it is invalid.
+func (e *Endpoint) handleTransport(src tcpip.FullAddress, msg buffer.View) bool {
+	if len(msg) < messageTransportMinimumSize {
+		return false
+	}
+	p, kp, ok := e.lookupIndex(binary.LittleEndian.Uint32(msg[transportReceiver:]))
+	if !ok || kp == nil {
+		return false
+	}
+	counter := binary.LittleEndian.Uint64(msg[transportCounter:])
+	if counter >= rejectAfterMessages || kp.expired(e.clock.NowMonotonic()) {
+		return false
+	}
+	ciphertext := msg[messageTransportHeaderSize:]
+	packet, err := kp.recv.Open(ciphertext[:0], nonce(counter), ciphertext, nil)
+	if err != nil {
+		return false
+	}
+
+	p.mu.Lock()
+	if kp != p.current && kp != p.previous && kp != p.next {
+		p.mu.Unlock()
+		return false
+	}
+	if !kp.replay.accept(counter) {
+		p.mu.Unlock()
+		return false
+	}
+	p.endpoint = src
+	p.rxBytes += uint64(len(msg))
+	var msgs [][]byte
+	if kp == p.next {
+		// The peer confirmed the handshake it initiated.
+		p.rotateLocked(kp)
+		msgs = p.flushStagedLocked()
+	}
+	if len(packet) != 0 {
+		p.receivedDataLocked()
+	}
+	p.mu.Unlock()
+	_ = e.sendTo(src, msgs...)
+
+	if len(packet) != 0 {
+		e.deliver(p, packet)
+	}
+	return true
+}
+
+// deliver delivers a packet received from p to the device's dispatcher.
+func (e *Endpoint) deliver(p *Peer, packet buffer.View) {
+	var (
+		proto  tcpip.NetworkProtocolNumber
+		src    tcpip.Address
+		length int
+	)
+	switch header.IPVersion(packet) {
+	case header.IPv4Version:
+		if len(packet) < header.IPv4MinimumSize {
+			e.stats.InvalidMessages.Increment()
+			return
+		}
+		h := header.IPv4(packet)
+		proto, src, length = header.IPv4ProtocolNumber, h.SourceAddress(), int(h.TotalLength())
+	case header.IPv6Version:
+		if len(packet) < header.IPv6MinimumSize {
+			e.stats.InvalidMessages.Increment()
+			return
+		}
+		h := header.IPv6(packet)
+		proto, src, length = header.IPv6ProtocolNumber, h.SourceAddress(), header.IPv6MinimumSize+int(h.PayloadLength())
+	default:
+		e.stats.InvalidMessages.Increment()
+		return
+	}
+	// Drop the padding.
+	if length > len(packet) {
+		e.stats.InvalidMessages.Increment()
+		return
+	}
+	packet = packet[:length]
+
+	e.mu.RLock()
+	owner := e.allowedIPs.lookup(src)
+	d := e.dispatcher
+	e.mu.RUnlock()
+	if owner != p {
+		e.stats.ForbiddenSources.Increment()
+		return
+	}
+	if d == nil {
+		return
+	}
+	d.DeliverNetworkPacket("" /* remote */, "" /* local */, proto, stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: packet.ToVectorisedView(),
+	}))
+}
+
+// Attach implements stack.LinkEndpoint.
+func (e *Endpoint) Attach(dispatcher stack.NetworkDispatcher) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dispatcher = dispatcher
+}
+
+// IsAttached implements stack.LinkEndpoint.
+func (e *Endpoint) IsAttached() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.dispatcher != nil
+}
+
+// MTU implements stack.LinkEndpoint.
+func (e *Endpoint) MTU() uint32 {
+	return e.mtu
+}
+
+// Capabilities implements stack.LinkEndpoint.
+func (*Endpoint) Capabilities() stack.LinkEndpointCapabilities {
+	return 0
+}
+
+// MaxHeaderLength implements stack.LinkEndpoint.
+//
+// Packets are encrypted in new buffers, so no space is reserved for the
+// encapsulation headers.
+func (*Endpoint) MaxHeaderLength() uint16 {
+	return 0
+}
+
+// LinkAddress implements stack.LinkEndpoint.
+func (*Endpoint) LinkAddress() tcpip.LinkAddress {
+	return ""
+}
+
+// Wait implements stack.LinkEndpoint.
+//
+// It waits for the readers of the sockets to stop after the device is closed.
+func (e *Endpoint) Wait() {
+	e.readers.Wait()
+}
+
+// ARPHardwareType implements stack.LinkEndpoint.
+func (*Endpoint) ARPHardwareType() header.ARPHardwareType {
+	return header.ARPHardwareNone
+}
+
+// AddHeader implements stack.LinkEndpoint.
+func (*Endpoint) AddHeader(local, remote tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+}
+
+// WritePacket implements stack.LinkEndpoint.
+//
+// The packet is sent to the peer whose allowed IPs best match its
+// destination.
+func (e *Endpoint) WritePacket(_ *stack.Route, _ *stack.GSO, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) *tcpip.Error {
+	var dst tcpip.Address
+	h := pkt.NetworkHeader().View()
+	switch protocol {
+	case header.IPv4ProtocolNumber:
+		if len(h) < header.IPv4MinimumSize {
+			return tcpip.ErrMalformedHeader
+		}
+		dst = header.IPv4(h).DestinationAddress()
+	case header.IPv6ProtocolNumber:
+		if len(h) < header.IPv6MinimumSize {
+			return tcpip.ErrMalformedHeader
+		}
+		dst = header.IPv6(h).DestinationAddress()
+	default:
+		return tcpip.ErrNotSupported
+	}
+
+	e.mu.RLock()
+	p := e.allowedIPs.lookup(dst)
+	e.mu.RUnlock()
+	if p == nil {
+		return tcpip.ErrNoRoute
+	}
+	vv := buffer.NewVectorisedView(pkt.Size(), pkt.Views())
+	return p.send(vv.ToView())
+}
+
+// WritePackets implements stack.LinkEndpoint.
+func (e *Endpoint) WritePackets(r *stack.Route, gso *stack.GSO, pkts stack.PacketBufferList, protocol tcpip.NetworkProtocolNumber) (int, *tcpip.Error) {
+	n := 0
+	for pkt := pkts.Front(); pkt != nil; pkt = pkt.Next() {
+		if err := e.WritePacket(r, gso, protocol, pkt); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,277 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wireguard
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/link/encaptest"
+	"gvisor.dev/gvisor/pkg/tcpip/link/pipe"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	deviceNICID = 2
+
+	linkAddr1 = tcpip.LinkAddress("\x02\x02\x03\x04\x05\x06")
+	linkAddr2 = tcpip.LinkAddress("\x02\x02\x03\x04\x05\x07")
+
+	outerAddr1 = tcpip.Address("\xc0\xa8\x00\x01")
+	outerAddr2 = tcpip.Address("\xc0\xa8\x00\x02")
+
+	innerAddr1   = tcpip.Address("\x0a\x00\x00\x01")
+	innerAddr2   = tcpip.Address("\x0a\x00\x00\x02")
+	innerV6Addr1 = tcpip.Address("\xfd\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01")
+	innerV6Addr2 = tcpip.Address("\xfd\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02")
+
+	listenPort1 = 51820
+	listenPort2 = 51821
+
+	// uplinkQueueSize and uplinkMTU are the size of the queue and the MTU of
+	// the channel uplinks, which hold the messages until they are relayed.
+	uplinkQueueSize = 16
+	uplinkMTU       = 1500
+)
+
+// newTestStack returns a stack with an uplink NIC that has outerAddr, and a
+// WireGuard device NIC that has the inner addresses.
+func newTestStack(t *testing.T, ep stack.LinkEndpoint, outerAddr tcpip.Address, opts Options, innerAddrs ...tcpip.Address) (*stack.Stack, *Endpoint) {
+	t.Helper()
+
+	s := encaptest.NewStack(t, ep, encaptest.Options{
+		Addrs: []tcpip.AddressWithPrefix{{Address: outerAddr, PrefixLen: 24}},
+	})
+	dev, err := New(s, opts)
+	if err != nil {
+		t.Fatalf("New(_, %+v): %s", opts, err)
+	}
+	t.Cleanup(func() {
+		dev.Close()
+		dev.Wait()
+	})
+	if err := s.CreateNIC(deviceNICID, dev); err != nil {
+		t.Fatalf("s.CreateNIC(%d, _): %s", deviceNICID, err)
+	}
+	encaptest.AddAddresses(t, s, deviceNICID, innerAddrs...)
+	return s, dev
+}
+
+// hostSubnet returns the subnet that only holds addr.
+func hostSubnet(addr tcpip.Address) tcpip.Subnet {
+	return tcpip.AddressWithPrefix{Address: addr, PrefixLen: len(addr) * 8}.Subnet()
+}
+
+// relay hands the next message sent through the uplink from to dev, as if dev
+// received it.
+func relay(t *testing.T, from *channel.Endpoint, dev *Endpoint) {
+	t.Helper()
+
+	src, msg := encaptest.ReadUDP(t, from)
+	dev.handleMessage(src, msg)
+}
+
+func TestTunnel(t *testing.T) {
+	key1, key2 := GeneratePrivateKey(), GeneratePrivateKey()
+	link1, link2 := pipe.New(linkAddr1, linkAddr2)
+	s1, dev1 := newTestStack(t, link1, outerAddr1, Options{PrivateKey: key1, ListenPort: listenPort1}, innerAddr1, innerV6Addr1)
+	s2, dev2 := newTestStack(t, link2, outerAddr2, Options{PrivateKey: key2, ListenPort: listenPort2}, innerAddr2, innerV6Addr2)
+
+	if got, want := dev1.MTU(), uint32(DefaultMTU); got != want {
+		t.Errorf("got dev1.MTU() = %d, want = %d", got, want)
+	}
+	if got, want := dev1.PublicKey(), key1.PublicKey(); got != want {
+		t.Errorf("got dev1.PublicKey() = %s, want = %s", got, want)
+	}
+
+	// Only the first device knows the endpoint of its peer; the second one
+	// learns it from the handshake.
+	if err := dev1.SetPeer(PeerConfig{
+		PublicKey:  key2.PublicKey(),
+		Endpoint:   tcpip.FullAddress{Addr: outerAddr2, Port: listenPort2},
+		AllowedIPs: []tcpip.Subnet{hostSubnet(innerAddr2), hostSubnet(innerV6Addr2)},
+	}); err != nil {
+		t.Fatalf("dev1.SetPeer(_): %s", err)
+	}
+	if err := dev2.SetPeer(PeerConfig{
+		PublicKey:  key1.PublicKey(),
+		AllowedIPs: []tcpip.Subnet{hostSubnet(innerAddr1), hostSubnet(innerV6Addr1)},
+	}); err != nil {
+		t.Fatalf("dev2.SetPeer(_): %s", err)
+	}
+
+	encaptest.CheckUDP(t, s1, s2, ipv4.ProtocolNumber, innerAddr1, innerAddr2, []byte("hello"))
+	encaptest.CheckUDP(t, s2, s1, ipv4.ProtocolNumber, innerAddr2, innerAddr1, []byte("world"))
+	encaptest.CheckUDP(t, s1, s2, ipv6.ProtocolNumber, innerV6Addr1, innerV6Addr2, []byte("hello v6"))
+	encaptest.CheckUDP(t, s2, s1, ipv6.ProtocolNumber, innerV6Addr2, innerV6Addr1, []byte("world v6"))
+
+	peers := dev2.Peers()
+	if len(peers) != 1 {
+		t.Fatalf("got len(dev2.Peers()) = %d, want = 1", len(peers))
+	}
+	if got, want := peers[0].Endpoint, (tcpip.FullAddress{NIC: encaptest.UplinkNICID, Addr: outerAddr1, Port: listenPort1}); got != want {
+		t.Errorf("got peer endpoint = %+v, want = %+v", got, want)
+	}
+	if peers[0].LastHandshake == 0 {
+		t.Error("got no handshake with the peer")
+	}
+	if peers[0].RxBytes == 0 || peers[0].TxBytes == 0 {
+		t.Errorf("got RxBytes = %d, TxBytes = %d, want both non zero", peers[0].RxBytes, peers[0].TxBytes)
+	}
+	for i, dev := range []*Endpoint{dev1, dev2} {
+		if got := dev.Stats().InvalidMessages.Value(); got != 0 {
+			t.Errorf("got dev%d.Stats().InvalidMessages.Value() = %d, want = 0", i+1, got)
+		}
+	}
+}
+
+func TestCryptokeyRouting(t *testing.T) {
+	key1, key2 := GeneratePrivateKey(), GeneratePrivateKey()
+	link1, link2 := channel.New(uplinkQueueSize, uplinkMTU, linkAddr1), channel.New(uplinkQueueSize, uplinkMTU, linkAddr2)
+	s1, dev1 := newTestStack(t, link1, outerAddr1, Options{PrivateKey: key1, ListenPort: listenPort1}, innerAddr1)
+	_, dev2 := newTestStack(t, link2, outerAddr2, Options{PrivateKey: key2, ListenPort: listenPort2}, innerAddr2)
+
+	if err := dev1.SetPeer(PeerConfig{
+		PublicKey:  key2.PublicKey(),
+		Endpoint:   tcpip.FullAddress{Addr: outerAddr2, Port: listenPort2},
+		AllowedIPs: []tcpip.Subnet{header.IPv4EmptySubnet},
+	}); err != nil {
+		t.Fatalf("dev1.SetPeer(_): %s", err)
+	}
+	// The packets of the first device don't come from its allowed IPs.
+	if err := dev2.SetPeer(PeerConfig{
+		PublicKey:  key1.PublicKey(),
+		AllowedIPs: []tcpip.Subnet{hostSubnet(innerAddr2)},
+	}); err != nil {
+		t.Fatalf("dev2.SetPeer(_): %s", err)
+	}
+
+	conn, err := gonet.DialUDP(s1, nil, &tcpip.FullAddress{Addr: innerAddr2, Port: 1234}, ipv4.ProtocolNumber)
+	if err != nil {
+		t.Fatalf("gonet.DialUDP(_, nil, _, %d): %s", ipv4.ProtocolNumber, err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("conn.Write(_): %s", err)
+	}
+	// The initiation, the response, and the packet the first device sends
+	// once the handshake completes.
+	relay(t, link1, dev2)
+	relay(t, link2, dev1)
+	relay(t, link1, dev2)
+	if got := dev2.Stats().ForbiddenSources.Value(); got != 1 {
+		t.Errorf("got dev2.Stats().ForbiddenSources.Value() = %d, want = 1", got)
+	}
+
+	// Packets to addresses that aren't allowed IPs of any peer have no route.
+	dev1.RemovePeer(key2.PublicKey())
+	if _, err := conn.Write([]byte("hello")); err == nil {
+		t.Error("got conn.Write(_) = nil, want an error")
+	}
+}
+
+func TestUnknownPeer(t *testing.T) {
+	key1, key2 := GeneratePrivateKey(), GeneratePrivateKey()
+	link1, link2 := channel.New(uplinkQueueSize, uplinkMTU, linkAddr1), channel.New(uplinkQueueSize, uplinkMTU, linkAddr2)
+	s1, dev1 := newTestStack(t, link1, outerAddr1, Options{PrivateKey: key1, ListenPort: listenPort1}, innerAddr1)
+	_, dev2 := newTestStack(t, link2, outerAddr2, Options{PrivateKey: key2, ListenPort: listenPort2}, innerAddr2)
+
+	if err := dev1.SetPeer(PeerConfig{
+		PublicKey:  key2.PublicKey(),
+		Endpoint:   tcpip.FullAddress{Addr: outerAddr2, Port: listenPort2},
+		AllowedIPs: []tcpip.Subnet{hostSubnet(innerAddr2)},
+	}); err != nil {
+		t.Fatalf("dev1.SetPeer(_): %s", err)
+	}
+
+	conn, err := gonet.DialUDP(s1, nil, &tcpip.FullAddress{Addr: innerAddr2, Port: 1234}, ipv4.ProtocolNumber)
+	if err != nil {
+		t.Fatalf("gonet.DialUDP(_, nil, _, %d): %s", ipv4.ProtocolNumber, err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("conn.Write(_): %s", err)
+	}
+	relay(t, link1, dev2)
+	if got := dev2.Stats().InvalidMessages.Value(); got != 1 {
+		t.Errorf("got dev2.Stats().InvalidMessages.Value() = %d, want = 1", got)
+	}
+	if peers := dev1.Peers(); peers[0].LastHandshake != 0 {
+		t.Errorf("got a handshake at %d with a device that doesn't know the peer", peers[0].LastHandshake)
+	}
+}
+
+func TestAllowedIPs(t *testing.T) {
+	p1, p2 := &Peer{}, &Peer{}
+	var a allowedIPs
+	a.insert(header.IPv4EmptySubnet, p1)
+	a.insert(tcpip.AddressWithPrefix{Address: "\x0a\x00\x00\x00", PrefixLen: 8}.Subnet(), p2)
+	a.insert(tcpip.AddressWithPrefix{Address: "\x0a\x01\x00\x00", PrefixLen: 16}.Subnet(), p1)
+
+	for _, test := range []struct {
+		addr tcpip.Address
+		want *Peer
+	}{
+		{addr: "\xc0\xa8\x00\x01", want: p1},
+		{addr: "\x0a\x00\x00\x01", want: p2},
+		{addr: "\x0a\x01\x00\x01", want: p1},
+		{addr: innerV6Addr1, want: nil},
+	} {
+		if got := a.lookup(test.addr); got != test.want {
+			t.Errorf("got a.lookup(%s) = %p, want = %p", test.addr, got, test.want)
+		}
+	}
+
+	// Subnets move to the last peer they are inserted for.
+	a.insert(header.IPv4EmptySubnet, p2)
+	a.removePeer(p2)
+	if got := a.lookup("\xc0\xa8\x00\x01"); got != nil {
+		t.Errorf("got a.lookup(192.168.0.1) = %p after removing its peer, want = nil", got)
+	}
+	if got, want := a.subnets(p1), []tcpip.Subnet{tcpip.AddressWithPrefix{Address: "\x0a\x01\x00\x00", PrefixLen: 16}.Subnet()}; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("got a.subnets(p1) = %v, want = %v", got, want)
+	}
+}
+
+func TestReplayFilter(t *testing.T) {
+	var f replayFilter
+	for _, test := range []struct {
+		counter uint64
+		want    bool
+	}{
+		{counter: 0, want: true},
+		{counter: 0, want: false},
+		{counter: 2, want: true},
+		{counter: 1, want: true},
+		{counter: 2, want: false},
+		{counter: replayWindowSize + 2, want: true},
+		// Counter 1 fell out of the window.
+		{counter: 1, want: false},
+		{counter: 3, want: true},
+		{counter: 10 * replayWindowSize, want: true},
+		{counter: 9*replayWindowSize - 1, want: false},
+		{counter: 10*replayWindowSize - 1, want: true},
+		{counter: 10*replayWindowSize - 1, want: false},
+	} {
+		if got := f.accept(test.counter); got != test.want {
+			t.Errorf("got f.accept(%d) = %t, want = %t", test.counter, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,519 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nat64 implements IPv6/IPv4 translation: stateless (SIIT) and
+// stateful NAT64 as per RFC 7915 and RFC 6146, and the customer-side
+// translator (CLAT) of 464XLAT as per RFC 6877.
+//
+// A translator is an Endpoint, a virtual link endpoint that is added to the
+// stack as a NIC. Packets that the stack routes to the NIC are translated and
+// sent by the stack in the other address family. Packets of the other family
+// that are destined to the translator are intercepted by wrapping the NIC
+// that receives them with WrapUplink, and delivered to the Endpoint's NIC
+// once translated.
+//
+// For example, a CLAT lets IPv4-only applications run on an IPv6-only
+// network:
+//
+//	ep, err := nat64.New(s, nat64.Options{
+//		Mode:            nat64.ModeCLAT,
+//		Prefix:          pref64, // As learned from PREF64 or DNS64.
+//		MTU:             uplinkMTU - 20,
+//		CLATIPv4Address: "\xc0\x00\x00\x01", // 192.0.0.1
+//		CLATIPv6Address: clatAddr,
+//	})
+//	s.CreateNIC(uplinkID, ep.WrapUplink(uplink))
+//	s.AddAddress(uplinkID, ipv6.ProtocolNumber, clatAddr)
+//	s.CreateNIC(clatID, ep)
+//	s.AddAddress(clatID, ipv4.ProtocolNumber, "\xc0\x00\x00\x01")
+//	// Route 0.0.0.0/0 to clatID and ::/0 to uplinkID.
+package nat64
+
+import (
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/nested"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// Mode is the kind of translation an Endpoint performs.
+type Mode int
+
+const (
+	// ModeCLAT translates IPv4 packets of the translating host to IPv6, as
+	// per RFC 6877. The Endpoint carries IPv4 packets.
+	//
+	// IPv4 destinations are embedded in the prefix and the host's IPv4
+	// address is replaced by a dedicated IPv6 address.
+	ModeCLAT Mode = iota
+
+	// ModeStateless translates IPv6 packets to IPv4 without keeping state, as
+	// per RFC 7915. The Endpoint carries IPv6 packets.
+	//
+	// Both the source and destination IPv6 addresses must be IPv4-embedded
+	// addresses within the prefix.
+	ModeStateless
+
+	// ModeStateful translates IPv6 packets to IPv4 by sharing the IPv4
+	// addresses of a pool between IPv6 hosts, as per RFC 6146. The Endpoint
+	// carries IPv6 packets.
+	//
+	// IPv4 destinations are embedded in the prefix. Sessions are created by
+	// packets from IPv6 hosts; packets from IPv4 hosts that do not match a
+	// session are dropped.
+	ModeStateful
+)
+
+// rfc7600DummyAddress is the IPv4 address used as the source of translated
+// ICMP errors whose IPv6 source cannot be translated, as per RFC 7600.
+const rfc7600DummyAddress tcpip.Address = "\xc0\x00\x00\x08"
+
+// Options holds the configuration of an Endpoint.
+type Options struct {
+	// Mode is the kind of translation to perform.
+	Mode Mode
+
+	// Prefix is the prefix IPv4 addresses are embedded in, as per RFC 6052.
+	//
+	// If zero, WellKnownPrefix is used. Network-specific prefixes are usually
+	// learned from the PREF64 NDP option (RFC 8781) or from DNS64 (RFC 7050).
+	Prefix tcpip.Subnet
+
+	// MTU is the MTU of the Endpoint. It should be the MTU of the network
+	// packets are translated to, adjusted for the difference in header sizes.
+	MTU uint32
+
+	// CLATIPv4Address is the IPv4 address of the translating host, in
+	// ModeCLAT.
+	CLATIPv4Address tcpip.Address
+
+	// CLATIPv6Address is the IPv6 address IPv4 packets are translated from
+	// and to, in ModeCLAT. It must be assigned to the uplink NIC so that it
+	// is resolvable, and should not be used for native IPv6 traffic.
+	CLATIPv6Address tcpip.Address
+
+	// Pool holds the IPv4 addresses that represent IPv6 hosts, in
+	// ModeStateless and ModeStateful. They must be assigned to the uplink NIC
+	// so that they are resolvable.
+	Pool []tcpip.Address
+}
+
+// Stats holds the packet counters of an Endpoint.
+type Stats struct {
+	// Outbound is the number of packets written to the Endpoint that were
+	// translated and sent.
+	Outbound tcpip.StatCounter
+
+	// Inbound is the number of packets intercepted on the uplink that were
+	// translated and delivered to the Endpoint.
+	Inbound tcpip.StatCounter
+
+	// Dropped is the number of packets that could not be translated or
+	// sent.
+	Dropped tcpip.StatCounter
+}
+
+// Endpoint is a link endpoint that translates packets between IPv4 and IPv6.
+type Endpoint struct {
+	stack *stack.Stack
+	opts  Options
+
+	// insideProto is the protocol of the packets carried by the Endpoint and
+	// outsideProto is the protocol they are translated to.
+	insideProto  tcpip.NetworkProtocolNumber
+	outsideProto tcpip.NetworkProtocolNumber
+
+	// out and in map the flows of outbound and inbound packets.
+	out mapper
+	in  mapper
+
+	// sessions is the session table, in ModeStateful.
+	sessions *sessionTable
+
+	stats Stats
+
+	mu         sync.RWMutex
+	dispatcher stack.NetworkDispatcher
+}
+
+var _ stack.LinkEndpoint = (*Endpoint)(nil)
+
+// New returns a new translator that sends translated packets through s.
+func New(s *stack.Stack, opts Options) (*Endpoint, *tcpip.Error) {
+	if opts.Prefix == (tcpip.Subnet{}) {
+		opts.Prefix = WellKnownPrefix
+	}
+	if !IsValidPrefix(opts.Prefix) {
+		return nil, tcpip.ErrInvalidOptionValue
+	}
+
+	e := &Endpoint{
+		stack: s,
+		opts:  opts,
+	}
+	switch opts.Mode {
+	case ModeCLAT:
+		if len(opts.CLATIPv4Address) != header.IPv4AddressSize || len(opts.CLATIPv6Address) != header.IPv6AddressSize {
+			return nil, tcpip.ErrBadAddress
+		}
+		e.insideProto, e.outsideProto = header.IPv4ProtocolNumber, header.IPv6ProtocolNumber
+		e.out = clatOutbound{e}
+		e.in = clatInbound{e}
+	case ModeStateless, ModeStateful:
+		if len(opts.Pool) == 0 {
+			return nil, tcpip.ErrBadAddress
+		}
+		for _, addr := range opts.Pool {
+			if len(addr) != header.IPv4AddressSize {
+				return nil, tcpip.ErrBadAddress
+			}
+		}
+		e.insideProto, e.outsideProto = header.IPv6ProtocolNumber, header.IPv4ProtocolNumber
+		if opts.Mode == ModeStateless {
+			e.out = statelessMapper{e}
+			e.in = statelessMapper{e}
+		} else {
+			e.sessions = newSessionTable(s.Clock(), s.Rand(), opts.Pool)
+			e.out = statefulOutbound{e}
+			e.in = statefulInbound{e}
+		}
+	default:
+		return nil, tcpip.ErrInvalidOptionValue
+	}
+	return e, nil
+}
+
+// Stats returns the Endpoint's packet counters.
+func (e *Endpoint) Stats() *Stats {
+	return &e.stats
+}
+
+// Attach implements stack.LinkEndpoint.
+func (e *Endpoint) Attach(dispatcher stack.NetworkDispatcher) {
+	e.mu.Lock()
+	e.dispatcher = dispatcher
+	e.mu.Unlock()
+}
+
+// IsAttached implements stack.LinkEndpoint.
+func (e *Endpoint) IsAttached() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.dispatcher != nil
+}
+
+// MTU implements stack.LinkEndpoint.
+func (e *Endpoint) MTU() uint32 {
+	return e.opts.MTU
+}
+
+// Capabilities implements stack.LinkEndpoint.
+func (*Endpoint) Capabilities() stack.LinkEndpointCapabilities {
+	return 0
+}
+
+// MaxHeaderLength implements stack.LinkEndpoint.
+func (*Endpoint) MaxHeaderLength() uint16 {
+	return 0
+}
+
+// LinkAddress implements stack.LinkEndpoint.
+func (*Endpoint) LinkAddress() tcpip.LinkAddress {
+	return ""
+}
+
+// Wait implements stack.LinkEndpoint.
+func (*Endpoint) Wait() {}
+
+// ARPHardwareType implements stack.LinkEndpoint.
+func (*Endpoint) ARPHardwareType() header.ARPHardwareType {
+	return header.ARPHardwareNone
+}
+
+// AddHeader implements stack.LinkEndpoint.
+func (*Endpoint) AddHeader(local, remote tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+}
+
+// WritePacket implements stack.LinkEndpoint.
+//
+// Packets that cannot be translated are dropped.
+func (e *Endpoint) WritePacket(_ *stack.Route, _ *stack.GSO, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) *tcpip.Error {
+	if protocol != e.insideProto {
+		e.stats.Dropped.Increment()
+		return nil
+	}
+	vv := buffer.NewVectorisedView(pkt.Size(), pkt.Views())
+	v := vv.ToView()
+	var ok bool
+	if e.insideProto == header.IPv4ProtocolNumber {
+		v, ok = translate4to6(v, e.out)
+	} else {
+		v, ok = translate6to4(v, e.out)
+	}
+	if !ok {
+		e.stats.Dropped.Increment()
+		return nil
+	}
+	return e.send(v)
+}
+
+// WritePackets implements stack.LinkEndpoint.
+func (e *Endpoint) WritePackets(r *stack.Route, gso *stack.GSO, pkts stack.PacketBufferList, protocol tcpip.NetworkProtocolNumber) (int, *tcpip.Error) {
+	n := 0
+	for pkt := pkts.Front(); pkt != nil; pkt = pkt.Next() {
+		if err := e.WritePacket(r, gso, protocol, pkt); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// send sends a translated packet through the stack.
+func (e *Endpoint) send(v buffer.View) *tcpip.Error {
+	var dst tcpip.Address
+	if e.outsideProto == header.IPv4ProtocolNumber {
+		dst = header.IPv4(v).DestinationAddress()
+	} else {
+		dst = header.IPv6(v).DestinationAddress()
+	}
+	r, err := e.stack.FindRoute(0, "", dst, e.outsideProto, false /* multicastLoop */)
+	if err != nil {
+		e.stats.Dropped.Increment()
+		return err
+	}
+	defer r.Release()
+
+	if err := r.WriteHeaderIncludedPacket(stack.NewPacketBuffer(stack.PacketBufferOptions{
+		ReserveHeaderBytes: int(r.MaxHeaderLength()),
+		Data:               v.ToVectorisedView(),
+	})); err != nil {
+		e.stats.Dropped.Increment()
+		return err
+	}
+	e.stats.Outbound.Increment()
+	return nil
+}
+
+// WrapUplink returns a link endpoint that wraps lower and intercepts the
+// packets destined to the translator, which are translated and delivered
+// through e instead of lower.
+//
+// Neighbor Discovery messages are never intercepted so that the stack can
+// keep resolving the translator's IPv6 address.
+func (e *Endpoint) WrapUplink(lower stack.LinkEndpoint) stack.LinkEndpoint {
+	u := &uplinkEndpoint{ep: e}
+	u.Endpoint.Init(lower, u)
+	return u
+}
+
+// intercepts returns true if the packet received on the uplink is destined to
+// the translator.
+func (e *Endpoint) intercepts(protocol tcpip.NetworkProtocolNumber, v buffer.View) bool {
+	if protocol != e.outsideProto {
+		return false
+	}
+	switch protocol {
+	case header.IPv4ProtocolNumber:
+		if len(v) < header.IPv4MinimumSize {
+			return false
+		}
+		dst := header.IPv4(v).DestinationAddress()
+		for _, addr := range e.opts.Pool {
+			if dst == addr {
+				return true
+			}
+		}
+		return false
+	case header.IPv6ProtocolNumber:
+		if len(v) < header.IPv6MinimumSize {
+			return false
+		}
+		h := header.IPv6(v)
+		if h.DestinationAddress() != e.opts.CLATIPv6Address {
+			return false
+		}
+		if tcpip.TransportProtocolNumber(h.NextHeader()) == header.ICMPv6ProtocolNumber && len(v) > header.IPv6MinimumSize {
+			switch header.ICMPv6Type(v[header.IPv6MinimumSize]) {
+			case header.ICMPv6RouterSolicit, header.ICMPv6RouterAdvert, header.ICMPv6NeighborSolicit, header.ICMPv6NeighborAdvert, header.ICMPv6RedirectMsg:
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// deliver translates a packet intercepted on the uplink and delivers it
+// through e.
+func (e *Endpoint) deliver(v buffer.View) {
+	var ok bool
+	if e.outsideProto == header.IPv4ProtocolNumber {
+		v, ok = translate4to6(v, e.in)
+	} else {
+		v, ok = translate6to4(v, e.in)
+	}
+	if !ok {
+		e.stats.Dropped.Increment()
+		return
+	}
+
+	e.mu.RLock()
+	d := e.dispatcher
+	e.mu.RUnlock()
+	if d == nil {
+		e.stats.Dropped.Increment()
+		return
+	}
+	e.stats.Inbound.Increment()
+	d.DeliverNetworkPacket("" /* remote */, "" /* local */, e.insideProto, stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: v.ToVectorisedView(),
+	}))
+}
+
+// uplinkEndpoint is the link endpoint returned by Endpoint.WrapUplink.
+type uplinkEndpoint struct {
+	nested.Endpoint
+	ep *Endpoint
+}
+
+// DeliverNetworkPacket implements stack.NetworkDispatcher.
+func (u *uplinkEndpoint) DeliverNetworkPacket(remote, local tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	if protocol == u.ep.outsideProto {
+		v := pkt.Data.ToView()
+		if u.ep.intercepts(protocol, v) {
+			u.ep.deliver(v)
+			return
+		}
+	}
+	u.Endpoint.DeliverNetworkPacket(remote, local, protocol, pkt)
+}
+
+// clatOutbound maps the flows of IPv4 packets sent by the translating host in
+// ModeCLAT.
+type clatOutbound struct{ e *Endpoint }
+
+func (m clatOutbound) mapFlow(f flow) (flow, bool) {
+	if f.src != m.e.opts.CLATIPv4Address {
+		return flow{}, false
+	}
+	f.src = m.e.opts.CLATIPv6Address
+	f.dst = EmbedIPv4(m.e.opts.Prefix, f.dst)
+	return f, true
+}
+
+func (m clatOutbound) mapErrorSource(addr tcpip.Address) (tcpip.Address, bool) {
+	if addr == m.e.opts.CLATIPv4Address {
+		return m.e.opts.CLATIPv6Address, true
+	}
+	return EmbedIPv4(m.e.opts.Prefix, addr), true
+}
+
+// clatInbound maps the flows of IPv6 packets received by the translating host
+// in ModeCLAT.
+type clatInbound struct{ e *Endpoint }
+
+func (m clatInbound) mapFlow(f flow) (flow, bool) {
+	src, ok := ExtractIPv4(m.e.opts.Prefix, f.src)
+	if !ok || f.dst != m.e.opts.CLATIPv6Address {
+		return flow{}, false
+	}
+	f.src = src
+	f.dst = m.e.opts.CLATIPv4Address
+	return f, true
+}
+
+func (m clatInbound) mapErrorSource(addr tcpip.Address) (tcpip.Address, bool) {
+	if src, ok := ExtractIPv4(m.e.opts.Prefix, addr); ok {
+		return src, true
+	}
+	// Routers on the IPv6 network are not IPv4-translatable.
+	return rfc7600DummyAddress, true
+}
+
+// statelessMapper maps flows in both directions in ModeStateless.
+type statelessMapper struct{ e *Endpoint }
+
+func (m statelessMapper) mapFlow(f flow) (flow, bool) {
+	var ok bool
+	if f.src, ok = m.mapAddress(f.src); !ok {
+		return flow{}, false
+	}
+	if f.dst, ok = m.mapAddress(f.dst); !ok {
+		return flow{}, false
+	}
+	return f, true
+}
+
+func (m statelessMapper) mapErrorSource(addr tcpip.Address) (tcpip.Address, bool) {
+	return m.mapAddress(addr)
+}
+
+func (m statelessMapper) mapAddress(addr tcpip.Address) (tcpip.Address, bool) {
+	if len(addr) == header.IPv4AddressSize {
+		return EmbedIPv4(m.e.opts.Prefix, addr), true
+	}
+	return ExtractIPv4(m.e.opts.Prefix, addr)
+}
+
+// statefulOutbound maps the flows of IPv6 packets sent by IPv6 hosts in
+// ModeStateful.
+type statefulOutbound struct{ e *Endpoint }
+
+func (m statefulOutbound) mapFlow(f flow) (flow, bool) {
+	dst, ok := ExtractIPv4(m.e.opts.Prefix, f.dst)
+	if !ok {
+		return flow{}, false
+	}
+	s, ok := m.e.sessions.outbound(f)
+	if !ok {
+		return flow{}, false
+	}
+	f.src, f.srcPort = s.outside.addr, s.outside.port
+	f.dst = dst
+	if s.inside.proto == header.ICMPv4ProtocolNumber {
+		f.dstPort = f.srcPort
+	}
+	return f, true
+}
+
+func (m statefulOutbound) mapErrorSource(tcpip.Address) (tcpip.Address, bool) {
+	// Only errors generated by IPv6 hosts about their own sessions are
+	// translated.
+	return "", false
+}
+
+// statefulInbound maps the flows of IPv4 packets sent to the pool in
+// ModeStateful.
+type statefulInbound struct{ e *Endpoint }
+
+func (m statefulInbound) mapFlow(f flow) (flow, bool) {
+	s, ok := m.e.sessions.inbound(f)
+	if !ok {
+		return flow{}, false
+	}
+	f.src = EmbedIPv4(m.e.opts.Prefix, f.src)
+	f.dst, f.dstPort = s.inside.addr, s.inside.port
+	if s.inside.proto == header.ICMPv4ProtocolNumber {
+		f.srcPort = f.dstPort
+	}
+	return f, true
+}
+
+func (m statefulInbound) mapErrorSource(addr tcpip.Address) (tcpip.Address, bool) {
+	return EmbedIPv4(m.e.opts.Prefix, addr), true
+}
@@ -0,0 +1,170 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nat64
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/pipe"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+const (
+	uplinkNICID = 1
+	clatNICID   = 2
+
+	hostLinkAddr   = tcpip.LinkAddress("\x02\x02\x03\x04\x05\x06")
+	serverLinkAddr = tcpip.LinkAddress("\x02\x02\x03\x04\x05\x07")
+
+	clatIPv4Addr = tcpip.Address("\xc0\x00\x00\x01") // 192.0.0.1
+	clatIPv6Addr = tcpip.Address("\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\xc1\xa7")
+
+	testTimeout = 5 * time.Second
+)
+
+func newTestStack() *stack.Stack {
+	ndpConfigs := ipv6.DefaultNDPConfigurations()
+	ndpConfigs.DupAddrDetectTransmits = 0
+	return stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv6.NewProtocolWithOptions(ipv6.Options{
+			NDPConfigs: ndpConfigs,
+		}), ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+	})
+}
+
+func createUplinkNIC(t *testing.T, s *stack.Stack, ep stack.LinkEndpoint, linkAddr tcpip.LinkAddress) {
+	t.Helper()
+
+	if err := s.CreateNIC(uplinkNICID, ep); err != nil {
+		t.Fatalf("s.CreateNIC(%d, _): %s", uplinkNICID, err)
+	}
+	llAddr := header.LinkLocalAddr(linkAddr)
+	if err := s.AddAddress(uplinkNICID, ipv6.ProtocolNumber, llAddr); err != nil {
+		t.Fatalf("s.AddAddress(%d, %d, %s): %s", uplinkNICID, ipv6.ProtocolNumber, llAddr, err)
+	}
+}
+
+func newCLAT(t *testing.T, s *stack.Stack) *Endpoint {
+	t.Helper()
+
+	e, err := New(s, Options{
+		Mode:            ModeCLAT,
+		MTU:             header.IPv6MinimumMTU - headerSizeDelta,
+		CLATIPv4Address: clatIPv4Addr,
+		CLATIPv6Address: clatIPv6Addr,
+	})
+	if err != nil {
+		t.Fatalf("New(_, _): %s", err)
+	}
+	return e
+}
+
+// TestCLAT tests that an IPv4 application on a host with a CLAT can exchange
+// packets with an IPv4 destination reached through an IPv6-only network.
+func TestCLAT(t *testing.T) {
+	hostEP, serverEP := pipe.New(hostLinkAddr, serverLinkAddr)
+
+	// The host only has IPv6 connectivity; IPv4 is provided by the CLAT.
+	hostStack := newTestStack()
+	clat := newCLAT(t, hostStack)
+	createUplinkNIC(t, hostStack, clat.WrapUplink(hostEP), hostLinkAddr)
+	if err := hostStack.AddAddress(uplinkNICID, ipv6.ProtocolNumber, clatIPv6Addr); err != nil {
+		t.Fatalf("hostStack.AddAddress(%d, %d, %s): %s", uplinkNICID, ipv6.ProtocolNumber, clatIPv6Addr, err)
+	}
+	if err := hostStack.CreateNIC(clatNICID, clat); err != nil {
+		t.Fatalf("hostStack.CreateNIC(%d, _): %s", clatNICID, err)
+	}
+	if err := hostStack.AddAddress(clatNICID, ipv4.ProtocolNumber, clatIPv4Addr); err != nil {
+		t.Fatalf("hostStack.AddAddress(%d, %d, %s): %s", clatNICID, ipv4.ProtocolNumber, clatIPv4Addr, err)
+	}
+	hostStack.SetRouteTable([]tcpip.Route{
+		{Destination: header.IPv4EmptySubnet, NIC: clatNICID},
+		{Destination: header.IPv6EmptySubnet, NIC: uplinkNICID},
+	})
+
+	// The server is an IPv6 host that the NAT64 on the path would translate
+	// v4Addr2 to.
+	serverStack := newTestStack()
+	createUplinkNIC(t, serverStack, serverEP, serverLinkAddr)
+	if err := serverStack.AddAddress(uplinkNICID, ipv6.ProtocolNumber, v6Addr2); err != nil {
+		t.Fatalf("serverStack.AddAddress(%d, %d, %s): %s", uplinkNICID, ipv6.ProtocolNumber, v6Addr2, err)
+	}
+	serverStack.SetRouteTable([]tcpip.Route{{Destination: header.IPv6EmptySubnet, NIC: uplinkNICID}})
+
+	const serverPort = 53
+	serverConn, err := gonet.DialUDP(serverStack, &tcpip.FullAddress{NIC: uplinkNICID, Addr: v6Addr2, Port: serverPort}, nil, ipv6.ProtocolNumber)
+	if err != nil {
+		t.Fatalf("gonet.DialUDP(_, _, nil, %d): %s", ipv6.ProtocolNumber, err)
+	}
+	defer serverConn.Close()
+	hostConn, err := gonet.DialUDP(hostStack, nil, &tcpip.FullAddress{Addr: v4Addr2, Port: serverPort}, ipv4.ProtocolNumber)
+	if err != nil {
+		t.Fatalf("gonet.DialUDP(_, nil, _, %d): %s", ipv4.ProtocolNumber, err)
+	}
+	defer hostConn.Close()
+
+	deadline := time.Now().Add(testTimeout)
+	if err := serverConn.SetDeadline(deadline); err != nil {
+		t.Fatalf("serverConn.SetDeadline(_): %s", err)
+	}
+	if err := hostConn.SetDeadline(deadline); err != nil {
+		t.Fatalf("hostConn.SetDeadline(_): %s", err)
+	}
+
+	query := []byte("query")
+	if _, err := hostConn.Write(query); err != nil {
+		t.Fatalf("hostConn.Write(_): %s", err)
+	}
+	buf := make([]byte, header.IPv6MinimumMTU)
+	n, from, err := serverConn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("serverConn.ReadFrom(_): %s", err)
+	}
+	if got := buf[:n]; !bytes.Equal(got, query) {
+		t.Errorf("got server received = %q, want = %q", got, query)
+	}
+	if got, want := tcpip.Address(from.(*net.UDPAddr).IP), clatIPv6Addr; got != want {
+		t.Errorf("got server received from %s, want = %s", got, want)
+	}
+
+	answer := []byte("answer")
+	if _, err := serverConn.WriteTo(answer, from); err != nil {
+		t.Fatalf("serverConn.WriteTo(_, %s): %s", from, err)
+	}
+	n, err = hostConn.Read(buf)
+	if err != nil {
+		t.Fatalf("hostConn.Read(_): %s", err)
+	}
+	if got := buf[:n]; !bytes.Equal(got, answer) {
+		t.Errorf("got host received = %q, want = %q", got, answer)
+	}
+
+	if got := clat.Stats().Outbound.Value(); got != 1 {
+		t.Errorf("got clat.Stats().Outbound.Value() = %d, want = 1", got)
+	}
+	if got := clat.Stats().Inbound.Value(); got != 1 {
+		t.Errorf("got clat.Stats().Inbound.Value() = %d, want = 1", got)
+	}
+}
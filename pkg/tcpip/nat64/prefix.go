@@ -0,0 +1,92 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nat64
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// WellKnownPrefix is the Well-Known Prefix (64:ff9b::/96) reserved for
+// IPv4-embedded IPv6 addresses, as per RFC 6052 section 2.1.
+var WellKnownPrefix = func() tcpip.Subnet {
+	s, err := tcpip.NewSubnet(
+		"\x00\x64\xff\x9b\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00",
+		"\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\x00\x00\x00\x00",
+	)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}()
+
+// uOctet is the index of the octet of an IPv4-embedded IPv6 address that must
+// be zero, as per RFC 6052 section 2.2.
+const uOctet = 8
+
+// IsValidPrefix returns true if prefix can be used to embed IPv4 addresses in
+// IPv6 addresses, as per RFC 6052 section 2.2.
+func IsValidPrefix(prefix tcpip.Subnet) bool {
+	if len(prefix.ID()) != header.IPv6AddressSize {
+		return false
+	}
+	switch prefix.Prefix() {
+	case 32, 40, 48, 56, 64, 96:
+		return true
+	default:
+		return false
+	}
+}
+
+// EmbedIPv4 returns the IPv4-embedded IPv6 address for addr within prefix, as
+// per RFC 6052 section 2.2.
+//
+// Precondition: IsValidPrefix(prefix) must be true and addr must be an IPv4
+// address.
+func EmbedIPv4(prefix tcpip.Subnet, addr tcpip.Address) tcpip.Address {
+	b := []byte(prefix.ID())
+	i := prefix.Prefix() / 8
+	for _, c := range []byte(addr) {
+		if i == uOctet {
+			i++
+		}
+		b[i] = c
+		i++
+	}
+	return tcpip.Address(b)
+}
+
+// ExtractIPv4 returns the IPv4 address embedded in addr, as per RFC 6052
+// section 2.2.
+//
+// Returns false if addr is not within prefix.
+//
+// Precondition: IsValidPrefix(prefix) must be true.
+func ExtractIPv4(prefix tcpip.Subnet, addr tcpip.Address) (tcpip.Address, bool) {
+	if len(addr) != header.IPv6AddressSize || !prefix.Contains(addr) {
+		return "", false
+	}
+
+	b := make([]byte, header.IPv4AddressSize)
+	i := prefix.Prefix() / 8
+	for j := range b {
+		if i == uOctet {
+			i++
+		}
+		b[j] = addr[i]
+		i++
+	}
+	return tcpip.Address(b), true
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nat64
+
+import (
+	"net"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func mustParseSubnet(t *testing.T, s string) tcpip.Subnet {
+	t.Helper()
+
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatalf("net.ParseCIDR(%q): %s", s, err)
+	}
+	subnet, err := tcpip.NewSubnet(tcpip.Address(ipNet.IP), tcpip.AddressMask(ipNet.Mask))
+	if err != nil {
+		t.Fatalf("tcpip.NewSubnet(%s, %s): %s", ipNet.IP, ipNet.Mask, err)
+	}
+	return subnet
+}
+
+// TestEmbedExtractIPv4 tests the examples of RFC 6052 section 2.4.
+func TestEmbedExtractIPv4(t *testing.T) {
+	ipv4Addr := tcpip.Address("\xc0\x00\x02\x21") // 192.0.2.33
+
+	tests := []struct {
+		prefix string
+		want   tcpip.Address
+	}{
+		{
+			prefix: "2001:db8::/32",
+			want:   "\x20\x01\x0d\xb8\xc0\x00\x02\x21\x00\x00\x00\x00\x00\x00\x00\x00",
+		},
+		{
+			prefix: "2001:db8:100::/40",
+			want:   "\x20\x01\x0d\xb8\x01\xc0\x00\x02\x00\x21\x00\x00\x00\x00\x00\x00",
+		},
+		{
+			prefix: "2001:db8:122::/48",
+			want:   "\x20\x01\x0d\xb8\x01\x22\xc0\x00\x00\x02\x21\x00\x00\x00\x00\x00",
+		},
+		{
+			prefix: "2001:db8:122:300::/56",
+			want:   "\x20\x01\x0d\xb8\x01\x22\x03\xc0\x00\x00\x02\x21\x00\x00\x00\x00",
+		},
+		{
+			prefix: "2001:db8:122:344::/64",
+			want:   "\x20\x01\x0d\xb8\x01\x22\x03\x44\x00\xc0\x00\x02\x21\x00\x00\x00",
+		},
+		{
+			prefix: "2001:db8:122:344::/96",
+			want:   "\x20\x01\x0d\xb8\x01\x22\x03\x44\x00\x00\x00\x00\xc0\x00\x02\x21",
+		},
+		{
+			prefix: "64:ff9b::/96",
+			want:   "\x00\x64\xff\x9b\x00\x00\x00\x00\x00\x00\x00\x00\xc0\x00\x02\x21",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.prefix, func(t *testing.T) {
+			prefix := mustParseSubnet(t, test.prefix)
+			if !IsValidPrefix(prefix) {
+				t.Fatalf("got IsValidPrefix(%s) = false, want = true", prefix)
+			}
+
+			if got := EmbedIPv4(prefix, ipv4Addr); got != test.want {
+				t.Errorf("got EmbedIPv4(%s, %s) = %s, want = %s", prefix, ipv4Addr, got, test.want)
+			}
+			if got, ok := ExtractIPv4(prefix, test.want); !ok || got != ipv4Addr {
+				t.Errorf("got ExtractIPv4(%s, %s) = (%s, %t), want = (%s, true)", prefix, test.want, got, ok, ipv4Addr)
+			}
+		})
+	}
+}
+
+func TestExtractIPv4OutsidePrefix(t *testing.T) {
+	addr := tcpip.Address("\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\xc0\x00\x02\x21")
+	if got, ok := ExtractIPv4(WellKnownPrefix, addr); ok {
+		t.Errorf("got ExtractIPv4(%s, %s) = (%s, true), want = (_, false)", WellKnownPrefix, addr, got)
+	}
+}
+
+func TestIsValidPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   bool
+	}{
+		{prefix: "64:ff9b::/96", want: true},
+		{prefix: "2001:db8::/32", want: true},
+		{prefix: "2001:db8::/33", want: false},
+		{prefix: "2001:db8::/128", want: false},
+		{prefix: "192.0.2.0/24", want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.prefix, func(t *testing.T) {
+			if got := IsValidPrefix(mustParseSubnet(t, test.prefix)); got != test.want {
+				t.Errorf("got IsValidPrefix(%s) = %t, want = %t", test.prefix, got, test.want)
+			}
+		})
+	}
+	if len(WellKnownPrefix.ID()) != header.IPv6AddressSize || WellKnownPrefix.Prefix() != 96 {
+		t.Errorf("got WellKnownPrefix = %s, want = 64:ff9b::/96", WellKnownPrefix)
+	}
+}
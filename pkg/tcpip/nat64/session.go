@@ -0,0 +1,264 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nat64
+
+import (
+	"math/rand"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcpconntrack"
+)
+
+// Session lifetimes, as per RFC 6146 section 4.
+const (
+	// UDPSessionTimeout is the lifetime of an idle UDP session.
+	UDPSessionTimeout = 5 * time.Minute
+
+	// TCPEstablishedSessionTimeout is the lifetime of an idle TCP session in
+	// the established state.
+	TCPEstablishedSessionTimeout = 2*time.Hour + 4*time.Minute
+
+	// TCPTransitorySessionTimeout is the lifetime of an idle TCP session that
+	// is being opened or closed.
+	TCPTransitorySessionTimeout = 4 * time.Minute
+
+	// ICMPSessionTimeout is the lifetime of an idle ICMP query session.
+	ICMPSessionTimeout = 60 * time.Second
+)
+
+const (
+	// minPoolPort is the smallest port allocated from the pool. Well-known
+	// ports are left for use by the translating host itself.
+	minPoolPort = 1024
+
+	// maxPortAllocationAttempts is the number of random ports tried on each
+	// pool address before moving on to the next one.
+	maxPortAllocationAttempts = 64
+)
+
+// transportAddress is an address and port (or ICMP query identifier) of a
+// transport protocol.
+//
+// ICMP query sessions always use header.ICMPv4ProtocolNumber so that the IPv4
+// and IPv6 sides of a session share a protocol.
+type transportAddress struct {
+	proto tcpip.TransportProtocolNumber
+	addr  tcpip.Address
+	port  uint16
+}
+
+// session is an entry of the Binding Information Base, as per RFC 6146
+// section 3.1.
+//
+// Mappings and filtering are endpoint-independent: a session binds an IPv6
+// transport address to an IPv4 transport address regardless of the remote
+// address.
+type session struct {
+	// inside is the transport address of the IPv6 host.
+	inside transportAddress
+
+	// outside is the IPv4 transport address allocated from the pool.
+	outside transportAddress
+
+	// tcb tracks the state of TCP sessions.
+	tcb tcpconntrack.TCB
+
+	// expires is the monotonic time at which the session expires.
+	expires int64
+}
+
+// refresh extends the session's lifetime.
+func (s *session) refresh(now int64) {
+	var d time.Duration
+	switch s.inside.proto {
+	case header.TCPProtocolNumber:
+		d = TCPTransitorySessionTimeout
+		if s.tcb.State() == tcpconntrack.ResultAlive {
+			d = TCPEstablishedSessionTimeout
+		}
+	case header.UDPProtocolNumber:
+		d = UDPSessionTimeout
+	default:
+		d = ICMPSessionTimeout
+	}
+	s.expires = now + d.Nanoseconds()
+}
+
+// sessionTable is the state of a stateful NAT64, as per RFC 6146.
+type sessionTable struct {
+	clock tcpip.Clock
+	rand  *rand.Rand
+	pool  []tcpip.Address
+
+	// mu protects the fields below.
+	mu        sync.Mutex
+	inside    map[transportAddress]*session
+	outside   map[transportAddress]*session
+	lastSweep int64
+}
+
+func newSessionTable(clock tcpip.Clock, rng *rand.Rand, pool []tcpip.Address) *sessionTable {
+	return &sessionTable{
+		clock:     clock,
+		rand:      rng,
+		pool:      pool,
+		inside:    make(map[transportAddress]*session),
+		outside:   make(map[transportAddress]*session),
+		lastSweep: clock.NowMonotonic(),
+	}
+}
+
+// sessionProtocol returns the protocol used to key sessions for proto.
+func sessionProtocol(proto tcpip.TransportProtocolNumber) tcpip.TransportProtocolNumber {
+	if proto == header.ICMPv6ProtocolNumber {
+		return header.ICMPv4ProtocolNumber
+	}
+	return proto
+}
+
+// outbound returns the session for a packet sent by an IPv6 host with the
+// given flow, creating it if required and permitted.
+//
+// TCP sessions are only created by SYN segments, as per RFC 6146 section
+// 3.5.2.2.
+func (t *sessionTable) outbound(f flow) (*session, bool) {
+	key := transportAddress{proto: sessionProtocol(f.proto), addr: f.src, port: f.srcPort}
+	now := t.clock.NowMonotonic()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.lookupLocked(t.inside, key, now)
+	if s == nil {
+		if f.embedded {
+			return nil, false
+		}
+		if key.proto == header.TCPProtocolNumber && (f.tcp == nil || f.tcp.Flags()&header.TCPFlagSyn == 0) {
+			return nil, false
+		}
+		outside, ok := t.allocateLocked(key, now)
+		if !ok {
+			return nil, false
+		}
+		s = &session{inside: key, outside: outside}
+		if key.proto == header.TCPProtocolNumber {
+			s.tcb.Init(f.tcp)
+		}
+		t.inside[key] = s
+		t.outside[outside] = s
+	} else if f.tcp != nil {
+		s.tcb.UpdateStateOutbound(f.tcp)
+	}
+	if !f.embedded {
+		s.refresh(now)
+	}
+	return s, true
+}
+
+// inbound returns the session for a packet sent to an IPv4 pool address with
+// the given flow.
+//
+// Packets that do not match an existing session are not translated.
+func (t *sessionTable) inbound(f flow) (*session, bool) {
+	key := transportAddress{proto: sessionProtocol(f.proto), addr: f.dst, port: f.dstPort}
+	now := t.clock.NowMonotonic()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.lookupLocked(t.outside, key, now)
+	if s == nil {
+		return nil, false
+	}
+	if f.tcp != nil {
+		s.tcb.UpdateStateInbound(f.tcp)
+	}
+	if !f.embedded {
+		s.refresh(now)
+	}
+	return s, true
+}
+
+// lookupLocked returns the unexpired session in m for key.
+//
+// Precondition: t.mu must be locked.
+func (t *sessionTable) lookupLocked(m map[transportAddress]*session, key transportAddress, now int64) *session {
+	s, ok := m[key]
+	if !ok {
+		return nil
+	}
+	if s.expires <= now {
+		t.removeLocked(s)
+		return nil
+	}
+	return s
+}
+
+// allocateLocked allocates an IPv4 transport address from the pool for the
+// IPv6 transport address inside.
+//
+// Precondition: t.mu must be locked.
+func (t *sessionTable) allocateLocked(inside transportAddress, now int64) (transportAddress, bool) {
+	if now-t.lastSweep >= ICMPSessionTimeout.Nanoseconds() {
+		t.sweepLocked(now)
+	}
+
+	for _, addr := range t.pool {
+		for i := 0; i < maxPortAllocationAttempts; i++ {
+			port := inside.port
+			// Try to preserve the port first, as many applications expect
+			// it.
+			if i != 0 || port < minPoolPort {
+				port = uint16(minPoolPort + t.rand.Intn(1<<16-minPoolPort))
+			}
+			outside := transportAddress{proto: inside.proto, addr: addr, port: port}
+			if t.lookupLocked(t.outside, outside, now) == nil {
+				return outside, true
+			}
+		}
+	}
+	return transportAddress{}, false
+}
+
+// sweepLocked removes all expired sessions.
+//
+// Precondition: t.mu must be locked.
+func (t *sessionTable) sweepLocked(now int64) {
+	for _, s := range t.inside {
+		if s.expires <= now {
+			t.removeLocked(s)
+		}
+	}
+	t.lastSweep = now
+}
+
+// removeLocked removes s from the table.
+//
+// Precondition: t.mu must be locked.
+func (t *sessionTable) removeLocked(s *session) {
+	delete(t.inside, s.inside)
+	delete(t.outside, s.outside)
+}
+
+// len returns the number of sessions in the table, including expired
+// sessions that have not been removed yet.
+func (t *sessionTable) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.inside)
+}
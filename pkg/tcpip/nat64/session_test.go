@@ -0,0 +1,174 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nat64
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	poolAddr = v4Addr3
+	hostAddr = tcpip.Address("\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01")
+)
+
+func newStatefulEndpoint(t *testing.T) (*Endpoint, *faketime.ManualClock) {
+	t.Helper()
+
+	clock := faketime.NewManualClock()
+	s := stack.New(stack.Options{Clock: clock})
+	e, err := New(s, Options{
+		Mode: ModeStateful,
+		MTU:  header.IPv6MinimumMTU,
+		Pool: []tcpip.Address{poolAddr},
+	})
+	if err != nil {
+		t.Fatalf("New(_, _): %s", err)
+	}
+	return e, clock
+}
+
+func tcpSegment(src, dst tcpip.Address, srcPort, dstPort uint16, flags uint8) []byte {
+	b := make([]byte, header.TCPMinimumSize)
+	tcp := header.TCP(b)
+	tcp.Encode(&header.TCPFields{
+		SrcPort:    srcPort,
+		DstPort:    dstPort,
+		SeqNum:     1,
+		DataOffset: header.TCPMinimumSize,
+		Flags:      flags,
+		WindowSize: 1024,
+	})
+	tcp.SetChecksum(^header.Checksum(b, header.PseudoHeaderChecksum(header.TCPProtocolNumber, src, dst, uint16(len(b)))))
+	return b
+}
+
+func TestStatefulUDPSession(t *testing.T) {
+	e, clock := newStatefulEndpoint(t)
+	const hostPort, serverPort = 5000, 53
+
+	out := ipv6Packet(hostAddr, v6Addr2, header.UDPProtocolNumber, udpSegment(hostAddr, v6Addr2, hostPort, serverPort, []byte("query")))
+	v4, ok := translate6to4(out, e.out)
+	if !ok {
+		t.Fatal("translate6to4 failed")
+	}
+	ip4 := header.IPv4(v4)
+	if got := ip4.SourceAddress(); got != poolAddr {
+		t.Errorf("got ip4.SourceAddress() = %s, want = %s", got, poolAddr)
+	}
+	if got, want := ip4.DestinationAddress(), v4Addr2; got != want {
+		t.Errorf("got ip4.DestinationAddress() = %s, want = %s", got, want)
+	}
+	udp := header.UDP(ip4.Payload())
+	// The host's port is preserved when it is available.
+	if got := udp.SourcePort(); got != hostPort {
+		t.Errorf("got udp.SourcePort() = %d, want = %d", got, hostPort)
+	}
+	if got := e.sessions.len(); got != 1 {
+		t.Errorf("got e.sessions.len() = %d, want = 1", got)
+	}
+
+	in := ipv4Packet(v4Addr2, poolAddr, header.UDPProtocolNumber, udpSegment(v4Addr2, poolAddr, serverPort, udp.SourcePort(), []byte("answer")))
+	v6, ok := translate4to6(in, e.in)
+	if !ok {
+		t.Fatal("translate4to6 failed")
+	}
+	ip6 := header.IPv6(v6)
+	if got := ip6.DestinationAddress(); got != hostAddr {
+		t.Errorf("got ip6.DestinationAddress() = %s, want = %s", got, hostAddr)
+	}
+	if got, want := ip6.SourceAddress(), v6Addr2; got != want {
+		t.Errorf("got ip6.SourceAddress() = %s, want = %s", got, want)
+	}
+	if got := header.UDP(ip6.Payload()).DestinationPort(); got != hostPort {
+		t.Errorf("got DestinationPort() = %d, want = %d", got, hostPort)
+	}
+
+	// Packets to other ports of the pool do not match the session.
+	other := ipv4Packet(v4Addr2, poolAddr, header.UDPProtocolNumber, udpSegment(v4Addr2, poolAddr, serverPort, udp.SourcePort()+1, nil))
+	if _, ok := translate4to6(other, e.in); ok {
+		t.Error("translated an inbound packet without a session")
+	}
+
+	// The session expires once idle for long enough.
+	clock.Advance(UDPSessionTimeout)
+	if _, ok := translate4to6(in, e.in); ok {
+		t.Error("translated an inbound packet after the session expired")
+	}
+}
+
+func TestStatefulTCPSession(t *testing.T) {
+	e, _ := newStatefulEndpoint(t)
+	const hostPort, serverPort = 5000, 80
+
+	ack := ipv6Packet(hostAddr, v6Addr2, header.TCPProtocolNumber, tcpSegment(hostAddr, v6Addr2, hostPort, serverPort, header.TCPFlagAck))
+	if _, ok := translate6to4(ack, e.out); ok {
+		t.Fatal("created a TCP session from a non-SYN segment")
+	}
+
+	syn := ipv6Packet(hostAddr, v6Addr2, header.TCPProtocolNumber, tcpSegment(hostAddr, v6Addr2, hostPort, serverPort, header.TCPFlagSyn))
+	v4, ok := translate6to4(syn, e.out)
+	if !ok {
+		t.Fatal("translate6to4 failed for SYN")
+	}
+	ip4 := header.IPv4(v4)
+	if !validTransportChecksum(header.TCPProtocolNumber, ip4.SourceAddress(), ip4.DestinationAddress(), ip4.Payload()) {
+		t.Error("got invalid TCP checksum")
+	}
+	port := header.TCP(ip4.Payload()).SourcePort()
+
+	synAck := ipv4Packet(v4Addr2, poolAddr, header.TCPProtocolNumber, tcpSegment(v4Addr2, poolAddr, serverPort, port, header.TCPFlagSyn|header.TCPFlagAck))
+	if _, ok := translate4to6(synAck, e.in); !ok {
+		t.Fatal("translate4to6 failed for SYN-ACK")
+	}
+}
+
+func TestStatefulICMPErrorFromIPv4Router(t *testing.T) {
+	e, _ := newStatefulEndpoint(t)
+	const hostPort, serverPort = 5000, 53
+
+	out := ipv6Packet(hostAddr, v6Addr2, header.UDPProtocolNumber, udpSegment(hostAddr, v6Addr2, hostPort, serverPort, []byte("query")))
+	v4, ok := translate6to4(out, e.out)
+	if !ok {
+		t.Fatal("translate6to4 failed")
+	}
+
+	// A router on the IPv4 network reports that the translated packet's TTL
+	// was exceeded.
+	const routerAddr = tcpip.Address("\xc6\x33\x64\xfe")
+	icmp := icmpv4Message(header.ICMPv4TimeExceeded, header.ICMPv4TTLExceeded, 0, v4)
+	v6, ok := translate4to6(ipv4Packet(routerAddr, poolAddr, header.ICMPv4ProtocolNumber, icmp), e.in)
+	if !ok {
+		t.Fatal("translate4to6 failed for ICMP error")
+	}
+	ip6 := header.IPv6(v6)
+	if got, want := ip6.SourceAddress(), EmbedIPv4(WellKnownPrefix, routerAddr); got != want {
+		t.Errorf("got ip6.SourceAddress() = %s, want = %s", got, want)
+	}
+	if got := ip6.DestinationAddress(); got != hostAddr {
+		t.Errorf("got ip6.DestinationAddress() = %s, want = %s", got, hostAddr)
+	}
+	inner := header.IPv6(header.ICMPv6(ip6.Payload()).Payload())
+	if got := inner.SourceAddress(); got != hostAddr {
+		t.Errorf("got inner.SourceAddress() = %s, want = %s", got, hostAddr)
+	}
+	if got := header.UDP(inner.Payload()).SourcePort(); got != hostPort {
+		t.Errorf("got inner source port = %d, want = %d", got, hostPort)
+	}
+}
@@ -0,0 +1,653 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nat64
+
+import (
+	"encoding/binary"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+const (
+	// maxICMPv4ErrorSize is the maximum size of a translated ICMPv4 error
+	// message, including the IPv4 header, as per RFC 1812 section 4.3.2.3.
+	maxICMPv4ErrorSize = 576
+
+	// maxICMPv6ErrorSize is the maximum size of a translated ICMPv6 error
+	// message, including the IPv6 header, as per RFC 4443 section 2.4.
+	maxICMPv6ErrorSize = header.IPv6MinimumMTU
+
+	// headerSizeDelta is the difference between the sizes of the IPv6
+	// and IPv4 minimum headers, used to adjust MTU values carried in ICMP
+	// errors as per RFC 7915 sections 4.2 and 5.2.
+	headerSizeDelta = header.IPv6MinimumSize - header.IPv4MinimumSize
+)
+
+// flow identifies the addresses and ports of a translated packet.
+//
+// For ICMP query messages, both ports hold the query identifier.
+type flow struct {
+	proto   tcpip.TransportProtocolNumber
+	src     tcpip.Address
+	dst     tcpip.Address
+	srcPort uint16
+	dstPort uint16
+
+	// tcp is the TCP header of the packet, if the flow was taken from a
+	// complete TCP segment.
+	tcp header.TCP
+
+	// embedded is true if the flow was taken from the packet embedded in an
+	// ICMP error message. Mappers must not create state for such flows.
+	embedded bool
+}
+
+// reverse returns the flow in the opposite direction.
+func (f flow) reverse() flow {
+	return flow{
+		proto:   f.proto,
+		src:     f.dst,
+		dst:     f.src,
+		srcPort: f.dstPort,
+		dstPort: f.srcPort,
+		// tcp is not reversed as it is only meaningful in the direction
+		// the segment was sent in.
+		embedded: f.embedded,
+	}
+}
+
+// mapper maps flows from one address family to the other.
+type mapper interface {
+	// mapFlow returns the translated form of f.
+	//
+	// The returned flow's protocol is ignored. For ICMP query messages, the
+	// returned ports must be equal.
+	mapFlow(f flow) (flow, bool)
+
+	// mapErrorSource returns the translated form of the source address of an
+	// ICMP error message, which is usually a router that is not a party to
+	// the flow the error is about.
+	//
+	// Errors generated by the destination of the packet they are about are
+	// translated using the flow of that packet instead.
+	mapErrorSource(addr tcpip.Address) (tcpip.Address, bool)
+}
+
+// translate4to6 translates an IPv4 packet to IPv6 as per RFC 7915 section 4.
+//
+// Fragmented packets and packets carrying protocols other than TCP, UDP and
+// ICMP are not translated.
+func translate4to6(pkt buffer.View, m mapper) (buffer.View, bool) {
+	h := header.IPv4(pkt)
+	if !h.IsValid(len(pkt)) {
+		return nil, false
+	}
+	if h.More() || h.FragmentOffset() != 0 {
+		return nil, false
+	}
+	if header.IPv4(pkt[:h.HeaderLength()]).CalculateChecksum() != 0xffff {
+		return nil, false
+	}
+	payload := pkt[h.HeaderLength():h.TotalLength()]
+	src, dst := h.SourceAddress(), h.DestinationAddress()
+
+	switch proto := h.TransportProtocol(); proto {
+	case header.TCPProtocolNumber, header.UDPProtocolNumber:
+		if !validTransportChecksum(proto, src, dst, payload) {
+			return nil, false
+		}
+		f, ok := transportFlow(proto, src, dst, payload)
+		if !ok {
+			return nil, false
+		}
+		nf, ok := m.mapFlow(f)
+		if !ok {
+			return nil, false
+		}
+		v := make(buffer.View, header.IPv6MinimumSize+len(payload))
+		encodeIPv6(v, h, nf.src, nf.dst, proto)
+		copy(v[header.IPv6MinimumSize:], payload)
+		setTransport(v[header.IPv6MinimumSize:], proto, nf)
+		return v, true
+
+	case header.ICMPv4ProtocolNumber:
+		if len(payload) < header.ICMPv4MinimumSize || header.Checksum(payload, 0) != 0xffff {
+			return nil, false
+		}
+		icmp := header.ICMPv4(payload)
+		v := make(buffer.View, header.IPv6MinimumSize+len(payload)+headerSizeDelta)
+		out := header.ICMPv6(v[header.IPv6MinimumSize:])
+
+		var nf flow
+		switch icmp.Type() {
+		case header.ICMPv4Echo, header.ICMPv4EchoReply:
+			var ok bool
+			nf, ok = m.mapFlow(flow{
+				proto:   header.ICMPv4ProtocolNumber,
+				src:     src,
+				dst:     dst,
+				srcPort: icmp.Ident(),
+				dstPort: icmp.Ident(),
+			})
+			if !ok {
+				return nil, false
+			}
+			copy(out, icmp)
+			out.SetType(header.ICMPv6EchoRequest)
+			if icmp.Type() == header.ICMPv4EchoReply {
+				out.SetType(header.ICMPv6EchoReply)
+			}
+			out.SetIdent(nf.srcPort)
+			v = v[:header.IPv6MinimumSize+len(payload)]
+			out = header.ICMPv6(v[header.IPv6MinimumSize:])
+
+		default:
+			if !translateICMPv4Error(icmp, out) {
+				return nil, false
+			}
+			inner, ok := translateInner4to6(icmp.Payload(), m)
+			if !ok {
+				return nil, false
+			}
+			// An error generated by the destination of the embedded packet is
+			// translated as coming from that destination.
+			nsrc := header.IPv6(inner).DestinationAddress()
+			if src != header.IPv4(icmp.Payload()).DestinationAddress() {
+				if nsrc, ok = m.mapErrorSource(src); !ok {
+					return nil, false
+				}
+			}
+			nf = flow{src: nsrc, dst: header.IPv6(inner).SourceAddress()}
+			n := copy(out[header.ICMPv6MinimumSize:], inner)
+			v = v[:header.IPv6MinimumSize+header.ICMPv6MinimumSize+n]
+			if len(v) > maxICMPv6ErrorSize {
+				v = v[:maxICMPv6ErrorSize]
+			}
+			out = header.ICMPv6(v[header.IPv6MinimumSize:])
+		}
+
+		encodeIPv6(v, h, nf.src, nf.dst, header.ICMPv6ProtocolNumber)
+		out.SetChecksum(0)
+		out.SetChecksum(^header.Checksum(out, header.PseudoHeaderChecksum(header.ICMPv6ProtocolNumber, nf.src, nf.dst, uint16(len(out)))))
+		return v, true
+
+	default:
+		return nil, false
+	}
+}
+
+// translate6to4 translates an IPv6 packet to IPv4 as per RFC 7915 section 5.
+//
+// Packets carrying extension headers and packets carrying protocols other
+// than TCP, UDP and ICMPv6 are not translated.
+func translate6to4(pkt buffer.View, m mapper) (buffer.View, bool) {
+	h := header.IPv6(pkt)
+	if !h.IsValid(len(pkt)) {
+		return nil, false
+	}
+	payload := pkt[header.IPv6MinimumSize:][:h.PayloadLength()]
+	src, dst := h.SourceAddress(), h.DestinationAddress()
+
+	switch proto := tcpip.TransportProtocolNumber(h.NextHeader()); proto {
+	case header.TCPProtocolNumber, header.UDPProtocolNumber:
+		if !validTransportChecksum(proto, src, dst, payload) {
+			return nil, false
+		}
+		f, ok := transportFlow(proto, src, dst, payload)
+		if !ok {
+			return nil, false
+		}
+		nf, ok := m.mapFlow(f)
+		if !ok {
+			return nil, false
+		}
+		v := make(buffer.View, header.IPv4MinimumSize+len(payload))
+		encodeIPv4(v, h, nf.src, nf.dst, proto)
+		copy(v[header.IPv4MinimumSize:], payload)
+		setTransport(v[header.IPv4MinimumSize:], proto, nf)
+		return v, true
+
+	case header.ICMPv6ProtocolNumber:
+		if len(payload) < header.ICMPv6MinimumSize {
+			return nil, false
+		}
+		if header.Checksum(payload, header.PseudoHeaderChecksum(proto, src, dst, uint16(len(payload)))) != 0xffff {
+			return nil, false
+		}
+		icmp := header.ICMPv6(payload)
+		v := make(buffer.View, header.IPv4MinimumSize+len(payload))
+		out := header.ICMPv4(v[header.IPv4MinimumSize:])
+
+		var nf flow
+		switch icmp.Type() {
+		case header.ICMPv6EchoRequest, header.ICMPv6EchoReply:
+			var ok bool
+			nf, ok = m.mapFlow(flow{
+				proto:   header.ICMPv6ProtocolNumber,
+				src:     src,
+				dst:     dst,
+				srcPort: icmp.Ident(),
+				dstPort: icmp.Ident(),
+			})
+			if !ok {
+				return nil, false
+			}
+			copy(out, icmp)
+			out.SetType(header.ICMPv4Echo)
+			if icmp.Type() == header.ICMPv6EchoReply {
+				out.SetType(header.ICMPv4EchoReply)
+			}
+			out.SetIdent(nf.srcPort)
+
+		default:
+			if !translateICMPv6Error(icmp, out) {
+				return nil, false
+			}
+			inner, ok := translateInner6to4(icmp.Payload(), m)
+			if !ok {
+				return nil, false
+			}
+			// An error generated by the destination of the embedded packet is
+			// translated as coming from that destination.
+			nsrc := header.IPv4(inner).DestinationAddress()
+			if src != header.IPv6(icmp.Payload()).DestinationAddress() {
+				if nsrc, ok = m.mapErrorSource(src); !ok {
+					return nil, false
+				}
+			}
+			nf = flow{src: nsrc, dst: header.IPv4(inner).SourceAddress()}
+			n := copy(out[header.ICMPv4MinimumSize:], inner)
+			v = v[:header.IPv4MinimumSize+header.ICMPv4MinimumSize+n]
+			if len(v) > maxICMPv4ErrorSize {
+				v = v[:maxICMPv4ErrorSize]
+			}
+			out = header.ICMPv4(v[header.IPv4MinimumSize:])
+		}
+
+		encodeIPv4(v, h, nf.src, nf.dst, header.ICMPv4ProtocolNumber)
+		out.SetChecksum(0)
+		out.SetChecksum(^header.Checksum(out, 0))
+		return v, true
+
+	default:
+		return nil, false
+	}
+}
+
+// translateInner4to6 translates the IPv4 packet embedded in an ICMPv4 error
+// message, which may be truncated.
+//
+// The embedded packet was sent in the opposite direction of the error
+// message, so its flow is reversed before it is mapped.
+func translateInner4to6(pkt buffer.View, m mapper) (buffer.View, bool) {
+	if len(pkt) < header.IPv4MinimumSize {
+		return nil, false
+	}
+	h := header.IPv4(pkt)
+	hdrLen := int(h.HeaderLength())
+	if hdrLen < header.IPv4MinimumSize || hdrLen > len(pkt) || int(h.TotalLength()) < hdrLen {
+		return nil, false
+	}
+	payload := pkt[hdrLen:]
+	proto := h.TransportProtocol()
+	nproto := proto
+	f := flow{proto: proto, src: h.SourceAddress(), dst: h.DestinationAddress(), embedded: true}
+	switch proto {
+	case header.TCPProtocolNumber, header.UDPProtocolNumber:
+		if len(payload) < 4 {
+			return nil, false
+		}
+		f.srcPort = binary.BigEndian.Uint16(payload)
+		f.dstPort = binary.BigEndian.Uint16(payload[2:])
+	case header.ICMPv4ProtocolNumber:
+		if len(payload) < header.ICMPv4MinimumSize {
+			return nil, false
+		}
+		icmp := header.ICMPv4(payload)
+		if t := icmp.Type(); t != header.ICMPv4Echo && t != header.ICMPv4EchoReply {
+			return nil, false
+		}
+		f.srcPort, f.dstPort = icmp.Ident(), icmp.Ident()
+		nproto = header.ICMPv6ProtocolNumber
+	default:
+		return nil, false
+	}
+	nf, ok := m.mapFlow(f.reverse())
+	if !ok {
+		return nil, false
+	}
+	nf = nf.reverse()
+
+	v := make(buffer.View, header.IPv6MinimumSize+len(payload))
+	encodeIPv6(v, h, nf.src, nf.dst, nproto)
+	header.IPv6(v).SetPayloadLength(h.TotalLength() - uint16(hdrLen))
+	copy(v[header.IPv6MinimumSize:], payload)
+	if nproto == header.ICMPv6ProtocolNumber {
+		icmp := header.ICMPv6(v[header.IPv6MinimumSize:])
+		icmp.SetType(header.ICMPv6EchoRequest)
+		if header.ICMPv4(payload).Type() == header.ICMPv4EchoReply {
+			icmp.SetType(header.ICMPv6EchoReply)
+		}
+		icmp.SetIdent(nf.srcPort)
+	} else {
+		setPorts(v[header.IPv6MinimumSize:], nf)
+	}
+	return v, true
+}
+
+// translateInner6to4 translates the IPv6 packet embedded in an ICMPv6 error
+// message, which may be truncated.
+//
+// The embedded packet was sent in the opposite direction of the error
+// message, so its flow is reversed before it is mapped.
+func translateInner6to4(pkt buffer.View, m mapper) (buffer.View, bool) {
+	if len(pkt) < header.IPv6MinimumSize {
+		return nil, false
+	}
+	h := header.IPv6(pkt)
+	payload := pkt[header.IPv6MinimumSize:]
+	proto := tcpip.TransportProtocolNumber(h.NextHeader())
+	nproto := proto
+	f := flow{proto: proto, src: h.SourceAddress(), dst: h.DestinationAddress(), embedded: true}
+	switch proto {
+	case header.TCPProtocolNumber, header.UDPProtocolNumber:
+		if len(payload) < 4 {
+			return nil, false
+		}
+		f.srcPort = binary.BigEndian.Uint16(payload)
+		f.dstPort = binary.BigEndian.Uint16(payload[2:])
+	case header.ICMPv6ProtocolNumber:
+		if len(payload) < header.ICMPv6MinimumSize {
+			return nil, false
+		}
+		icmp := header.ICMPv6(payload)
+		if t := icmp.Type(); t != header.ICMPv6EchoRequest && t != header.ICMPv6EchoReply {
+			return nil, false
+		}
+		f.srcPort, f.dstPort = icmp.Ident(), icmp.Ident()
+		nproto = header.ICMPv4ProtocolNumber
+	default:
+		return nil, false
+	}
+	nf, ok := m.mapFlow(f.reverse())
+	if !ok {
+		return nil, false
+	}
+	nf = nf.reverse()
+
+	v := make(buffer.View, header.IPv4MinimumSize+len(payload))
+	encodeIPv4(v, h, nf.src, nf.dst, nproto)
+	ip := header.IPv4(v)
+	ip.SetTotalLength(h.PayloadLength() + header.IPv4MinimumSize)
+	ip.SetChecksum(0)
+	ip.SetChecksum(^ip.CalculateChecksum())
+	copy(v[header.IPv4MinimumSize:], payload)
+	if nproto == header.ICMPv4ProtocolNumber {
+		icmp := header.ICMPv4(v[header.IPv4MinimumSize:])
+		icmp.SetType(header.ICMPv4Echo)
+		if header.ICMPv6(payload).Type() == header.ICMPv6EchoReply {
+			icmp.SetType(header.ICMPv4EchoReply)
+		}
+		icmp.SetIdent(nf.srcPort)
+	} else {
+		setPorts(v[header.IPv4MinimumSize:], nf)
+	}
+	return v, true
+}
+
+// icmpv4ParamProblemPointers maps the pointer of an ICMPv4 Parameter Problem
+// message to the pointer of an ICMPv6 Parameter Problem message, as per RFC
+// 7915 section 4.2 (Figure 3). Fields that have no IPv6 counterpart are
+// absent.
+var icmpv4ParamProblemPointers = map[byte]uint32{
+	0: 0, 1: 1, 2: 4, 3: 4, 8: 7, 9: 6,
+	12: 8, 13: 8, 14: 8, 15: 8,
+	16: 24, 17: 24, 18: 24, 19: 24,
+}
+
+// icmpv6ParamProblemPointer maps the pointer of an ICMPv6 Parameter Problem
+// message to the pointer of an ICMPv4 Parameter Problem message, as per RFC
+// 7915 section 5.2 (Figure 6).
+func icmpv6ParamProblemPointer(p uint32) (byte, bool) {
+	switch {
+	case p == 0, p == 1:
+		return byte(p), true
+	case p == 4, p == 5:
+		return 2, true
+	case p == 6:
+		return 9, true
+	case p == 7:
+		return 8, true
+	case p >= 8 && p <= 23:
+		return 12, true
+	case p >= 24 && p <= 39:
+		return 16, true
+	default:
+		return 0, false
+	}
+}
+
+// translateICMPv4Error writes the ICMPv6 type, code and type-specific fields
+// corresponding to the ICMPv4 error message in to out, as per RFC 7915
+// section 4.2.
+func translateICMPv4Error(in header.ICMPv4, out header.ICMPv6) bool {
+	out.SetTypeSpecific(0)
+	switch in.Type() {
+	case header.ICMPv4DstUnreachable:
+		switch code := in.Code(); code {
+		case header.ICMPv4NetUnreachable, header.ICMPv4HostUnreachable, 5, 6, 7, 8, 11, 12:
+			out.SetType(header.ICMPv6DstUnreachable)
+			out.SetCode(header.ICMPv6NetworkUnreachable)
+		case 9, 10, 13, 15:
+			out.SetType(header.ICMPv6DstUnreachable)
+			out.SetCode(header.ICMPv6Prohibited)
+		case header.ICMPv4ProtoUnreachable:
+			out.SetType(header.ICMPv6ParamProblem)
+			out.SetCode(header.ICMPv6UnknownHeader)
+			// Point to the Next Header field.
+			out.SetTypeSpecific(6)
+		case header.ICMPv4PortUnreachable:
+			out.SetType(header.ICMPv6DstUnreachable)
+			out.SetCode(header.ICMPv6PortUnreachable)
+		case header.ICMPv4FragmentationNeeded:
+			out.SetType(header.ICMPv6PacketTooBig)
+			out.SetCode(0)
+			mtu := uint32(in.MTU()) + headerSizeDelta
+			if mtu < header.IPv6MinimumMTU {
+				mtu = header.IPv6MinimumMTU
+			}
+			out.SetMTU(mtu)
+		default:
+			return false
+		}
+	case header.ICMPv4TimeExceeded:
+		out.SetType(header.ICMPv6TimeExceeded)
+		out.SetCode(header.ICMPv6Code(in.Code()))
+	case header.ICMPv4ParamProblem:
+		if in.Code() != 0 {
+			return false
+		}
+		p, ok := icmpv4ParamProblemPointers[in.Pointer()]
+		if !ok {
+			return false
+		}
+		out.SetType(header.ICMPv6ParamProblem)
+		out.SetCode(header.ICMPv6ErroneousHeader)
+		out.SetTypeSpecific(p)
+	default:
+		return false
+	}
+	return true
+}
+
+// translateICMPv6Error writes the ICMPv4 type, code and rest-of-header fields
+// corresponding to the ICMPv6 error message in to out, as per RFC 7915
+// section 5.2.
+func translateICMPv6Error(in header.ICMPv6, out header.ICMPv4) bool {
+	binary.BigEndian.PutUint32(out[4:], 0)
+	switch in.Type() {
+	case header.ICMPv6DstUnreachable:
+		out.SetType(header.ICMPv4DstUnreachable)
+		switch in.Code() {
+		case header.ICMPv6NetworkUnreachable, header.ICMPv6BeyondScope, header.ICMPv6AddressUnreachable:
+			out.SetCode(header.ICMPv4HostUnreachable)
+		case header.ICMPv6Prohibited:
+			// Communication with Destination Host Administratively
+			// Prohibited.
+			out.SetCode(10)
+		case header.ICMPv6PortUnreachable:
+			out.SetCode(header.ICMPv4PortUnreachable)
+		default:
+			return false
+		}
+	case header.ICMPv6PacketTooBig:
+		out.SetType(header.ICMPv4DstUnreachable)
+		out.SetCode(header.ICMPv4FragmentationNeeded)
+		mtu := in.MTU() - headerSizeDelta
+		if in.MTU() < headerSizeDelta || mtu > 0xffff {
+			mtu = 0xffff
+		}
+		out.SetMTU(uint16(mtu))
+	case header.ICMPv6TimeExceeded:
+		out.SetType(header.ICMPv4TimeExceeded)
+		out.SetCode(header.ICMPv4Code(in.Code()))
+	case header.ICMPv6ParamProblem:
+		switch in.Code() {
+		case header.ICMPv6ErroneousHeader:
+			p, ok := icmpv6ParamProblemPointer(in.TypeSpecific())
+			if !ok {
+				return false
+			}
+			out.SetType(header.ICMPv4ParamProblem)
+			out.SetCode(0)
+			out.SetPointer(p)
+		case header.ICMPv6UnknownHeader:
+			out.SetType(header.ICMPv4DstUnreachable)
+			out.SetCode(header.ICMPv4ProtoUnreachable)
+		default:
+			return false
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// encodeIPv6 writes an IPv6 header translated from the IPv4 header h in to
+// the start of v, as per RFC 7915 section 4.1.
+//
+// The payload length is set from the size of v.
+func encodeIPv6(v buffer.View, h header.IPv4, src, dst tcpip.Address, proto tcpip.TransportProtocolNumber) {
+	tos, _ := h.TOS()
+	header.IPv6(v).Encode(&header.IPv6Fields{
+		TrafficClass:  tos,
+		PayloadLength: uint16(len(v) - header.IPv6MinimumSize),
+		NextHeader:    uint8(proto),
+		HopLimit:      h.TTL(),
+		SrcAddr:       src,
+		DstAddr:       dst,
+	})
+}
+
+// encodeIPv4 writes an IPv4 header translated from the IPv6 header h in to
+// the start of v, as per RFC 7915 section 5.1.
+//
+// The total length is set from the size of v. The Don't Fragment flag is only
+// set on packets larger than 1260 bytes so that IPv4 routers may fragment
+// packets that will not need IPv6 fragmentation on the way back.
+func encodeIPv4(v buffer.View, h header.IPv6, src, dst tcpip.Address, proto tcpip.TransportProtocolNumber) {
+	tc, _ := h.TOS()
+	var flags uint8
+	if len(v) > header.IPv6MinimumMTU-headerSizeDelta {
+		flags = header.IPv4FlagDontFragment
+	}
+	ip := header.IPv4(v)
+	ip.Encode(&header.IPv4Fields{
+		TOS:         tc,
+		TotalLength: uint16(len(v)),
+		Flags:       flags,
+		TTL:         h.HopLimit(),
+		Protocol:    uint8(proto),
+		SrcAddr:     src,
+		DstAddr:     dst,
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+}
+
+// transportFlow returns the flow of a TCP or UDP segment.
+func transportFlow(proto tcpip.TransportProtocolNumber, src, dst tcpip.Address, payload []byte) (flow, bool) {
+	switch proto {
+	case header.TCPProtocolNumber:
+		if len(payload) < header.TCPMinimumSize {
+			return flow{}, false
+		}
+	case header.UDPProtocolNumber:
+		if len(payload) < header.UDPMinimumSize {
+			return flow{}, false
+		}
+	}
+	f := flow{
+		proto:   proto,
+		src:     src,
+		dst:     dst,
+		srcPort: binary.BigEndian.Uint16(payload),
+		dstPort: binary.BigEndian.Uint16(payload[2:]),
+	}
+	if proto == header.TCPProtocolNumber {
+		f.tcp = header.TCP(payload)
+	}
+	return f, true
+}
+
+// validTransportChecksum returns true if the checksum of the TCP or UDP
+// segment is valid.
+//
+// UDP over IPv4 may omit its checksum; such segments are considered valid.
+func validTransportChecksum(proto tcpip.TransportProtocolNumber, src, dst tcpip.Address, payload []byte) bool {
+	if proto == header.UDPProtocolNumber && len(payload) >= header.UDPMinimumSize && header.UDP(payload).Checksum() == 0 {
+		return len(src) == header.IPv4AddressSize
+	}
+	return header.Checksum(payload, header.PseudoHeaderChecksum(proto, src, dst, uint16(len(payload)))) == 0xffff
+}
+
+// setPorts writes the ports of f in to the TCP or UDP segment.
+func setPorts(payload []byte, f flow) {
+	binary.BigEndian.PutUint16(payload, f.srcPort)
+	binary.BigEndian.PutUint16(payload[2:], f.dstPort)
+}
+
+// setTransport writes the ports of f in to the TCP or UDP segment and
+// recalculates its checksum for the translated addresses.
+func setTransport(payload []byte, proto tcpip.TransportProtocolNumber, f flow) {
+	setPorts(payload, f)
+	xsum := header.PseudoHeaderChecksum(proto, f.src, f.dst, uint16(len(payload)))
+	switch proto {
+	case header.TCPProtocolNumber:
+		tcp := header.TCP(payload)
+		tcp.SetChecksum(0)
+		tcp.SetChecksum(^header.Checksum(payload, xsum))
+	case header.UDPProtocolNumber:
+		udp := header.UDP(payload)
+		udp.SetChecksum(0)
+		c := ^header.Checksum(payload, xsum)
+		// A zero checksum means that no checksum was computed, as per RFC 768.
+		if c == 0 {
+			c = 0xffff
+		}
+		udp.SetChecksum(c)
+	}
+}
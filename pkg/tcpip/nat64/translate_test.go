@@ -0,0 +1,388 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nat64
+
+import (
+	"bytes"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+const (
+	v4Addr1 = tcpip.Address("\xc0\x00\x02\x01") // 192.0.2.1
+	v4Addr2 = tcpip.Address("\xc6\x33\x64\x01") // 198.51.100.1
+	v4Addr3 = tcpip.Address("\xcb\x00\x71\x01") // 203.0.113.1
+	ttl     = 64
+	tos     = 0x10
+)
+
+var (
+	v6Addr1 = EmbedIPv4(WellKnownPrefix, v4Addr1)
+	v6Addr2 = EmbedIPv4(WellKnownPrefix, v4Addr2)
+	v6Addr3 = EmbedIPv4(WellKnownPrefix, v4Addr3)
+)
+
+func ipv4Packet(src, dst tcpip.Address, proto tcpip.TransportProtocolNumber, payload []byte) buffer.View {
+	v := make(buffer.View, header.IPv4MinimumSize+len(payload))
+	ip := header.IPv4(v)
+	ip.Encode(&header.IPv4Fields{
+		TOS:         tos,
+		TotalLength: uint16(len(v)),
+		TTL:         ttl,
+		Protocol:    uint8(proto),
+		SrcAddr:     src,
+		DstAddr:     dst,
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+	copy(v[header.IPv4MinimumSize:], payload)
+	return v
+}
+
+func ipv6Packet(src, dst tcpip.Address, proto tcpip.TransportProtocolNumber, payload []byte) buffer.View {
+	v := make(buffer.View, header.IPv6MinimumSize+len(payload))
+	header.IPv6(v).Encode(&header.IPv6Fields{
+		TrafficClass:  tos,
+		PayloadLength: uint16(len(payload)),
+		NextHeader:    uint8(proto),
+		HopLimit:      ttl,
+		SrcAddr:       src,
+		DstAddr:       dst,
+	})
+	copy(v[header.IPv6MinimumSize:], payload)
+	return v
+}
+
+func udpSegment(src, dst tcpip.Address, srcPort, dstPort uint16, data []byte) []byte {
+	b := make([]byte, header.UDPMinimumSize+len(data))
+	udp := header.UDP(b)
+	udp.Encode(&header.UDPFields{
+		SrcPort: srcPort,
+		DstPort: dstPort,
+		Length:  uint16(len(b)),
+	})
+	copy(b[header.UDPMinimumSize:], data)
+	udp.SetChecksum(^header.Checksum(b, header.PseudoHeaderChecksum(header.UDPProtocolNumber, src, dst, uint16(len(b)))))
+	return b
+}
+
+func icmpv4Message(typ header.ICMPv4Type, code header.ICMPv4Code, rest uint32, payload []byte) []byte {
+	b := make([]byte, header.ICMPv4MinimumSize+len(payload))
+	icmp := header.ICMPv4(b)
+	icmp.SetType(typ)
+	icmp.SetCode(code)
+	b[4], b[5], b[6], b[7] = byte(rest>>24), byte(rest>>16), byte(rest>>8), byte(rest)
+	copy(icmp.Payload(), payload)
+	icmp.SetChecksum(^header.Checksum(b, 0))
+	return b
+}
+
+func icmpv6Message(src, dst tcpip.Address, typ header.ICMPv6Type, code header.ICMPv6Code, rest uint32, payload []byte) []byte {
+	b := make([]byte, header.ICMPv6MinimumSize+len(payload))
+	icmp := header.ICMPv6(b)
+	icmp.SetType(typ)
+	icmp.SetCode(code)
+	icmp.SetTypeSpecific(rest)
+	copy(icmp.Payload(), payload)
+	icmp.SetChecksum(^header.Checksum(b, header.PseudoHeaderChecksum(header.ICMPv6ProtocolNumber, src, dst, uint16(len(b)))))
+	return b
+}
+
+func newStatelessMapper() statelessMapper {
+	return statelessMapper{&Endpoint{opts: Options{Prefix: WellKnownPrefix}}}
+}
+
+func TestTranslateUDPRoundTrip(t *testing.T) {
+	m := newStatelessMapper()
+	data := []byte("hello")
+	pkt := ipv4Packet(v4Addr1, v4Addr2, header.UDPProtocolNumber, udpSegment(v4Addr1, v4Addr2, 1000, 53, data))
+
+	v6, ok := translate4to6(pkt, m)
+	if !ok {
+		t.Fatal("translate4to6 failed")
+	}
+	ip6 := header.IPv6(v6)
+	if !ip6.IsValid(len(v6)) {
+		t.Fatalf("got invalid IPv6 packet = %x", []byte(v6))
+	}
+	if got, want := ip6.SourceAddress(), v6Addr1; got != want {
+		t.Errorf("got ip6.SourceAddress() = %s, want = %s", got, want)
+	}
+	if got, want := ip6.DestinationAddress(), v6Addr2; got != want {
+		t.Errorf("got ip6.DestinationAddress() = %s, want = %s", got, want)
+	}
+	if got, want := ip6.HopLimit(), uint8(ttl); got != want {
+		t.Errorf("got ip6.HopLimit() = %d, want = %d", got, want)
+	}
+	if got, _ := ip6.TOS(); got != tos {
+		t.Errorf("got traffic class = %#x, want = %#x", got, tos)
+	}
+	if got, want := tcpip.TransportProtocolNumber(ip6.NextHeader()), header.UDPProtocolNumber; got != want {
+		t.Errorf("got ip6.NextHeader() = %d, want = %d", got, want)
+	}
+	payload := v6[header.IPv6MinimumSize:]
+	if !validTransportChecksum(header.UDPProtocolNumber, v6Addr1, v6Addr2, payload) {
+		t.Errorf("got invalid UDP checksum in %x", []byte(payload))
+	}
+
+	v4, ok := translate6to4(v6, m)
+	if !ok {
+		t.Fatal("translate6to4 failed")
+	}
+	ip4 := header.IPv4(v4)
+	if !ip4.IsValid(len(v4)) {
+		t.Fatalf("got invalid IPv4 packet = %x", []byte(v4))
+	}
+	if got, want := ip4.SourceAddress(), v4Addr1; got != want {
+		t.Errorf("got ip4.SourceAddress() = %s, want = %s", got, want)
+	}
+	if got, want := ip4.DestinationAddress(), v4Addr2; got != want {
+		t.Errorf("got ip4.DestinationAddress() = %s, want = %s", got, want)
+	}
+	if ip4.Flags()&header.IPv4FlagDontFragment != 0 {
+		t.Error("got DF set on a small translated packet")
+	}
+	if got, want := v4[header.IPv4MinimumSize:], pkt[header.IPv4MinimumSize:]; !bytes.Equal(got, want) {
+		t.Errorf("got UDP segment = %x, want = %x", got, want)
+	}
+}
+
+func TestTranslateDropsInvalidPackets(t *testing.T) {
+	m := newStatelessMapper()
+
+	udp := udpSegment(v4Addr1, v4Addr2, 1000, 53, []byte("hello"))
+	udp[header.UDPMinimumSize] ^= 0xff
+	if _, ok := translate4to6(ipv4Packet(v4Addr1, v4Addr2, header.UDPProtocolNumber, udp), m); ok {
+		t.Error("translated a UDP segment with an invalid checksum")
+	}
+
+	frag := ipv4Packet(v4Addr1, v4Addr2, header.UDPProtocolNumber, udpSegment(v4Addr1, v4Addr2, 1000, 53, nil))
+	ip := header.IPv4(frag)
+	ip.SetFlagsFragmentOffset(header.IPv4FlagMoreFragments, 0)
+	ip.SetChecksum(0)
+	ip.SetChecksum(^ip.CalculateChecksum())
+	if _, ok := translate4to6(frag, m); ok {
+		t.Error("translated a fragment")
+	}
+
+	// Destinations outside the prefix cannot be translated.
+	dst := tcpip.Address("\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01")
+	pkt := ipv6Packet(v6Addr1, dst, header.UDPProtocolNumber, udpSegment(v6Addr1, dst, 1000, 53, nil))
+	if _, ok := translate6to4(pkt, m); ok {
+		t.Error("translated a packet to a destination outside the prefix")
+	}
+}
+
+func TestTranslateICMPEcho(t *testing.T) {
+	m := newStatelessMapper()
+	const ident, seq = 1234, 5
+	echo := icmpv6Message(v6Addr1, v6Addr2, header.ICMPv6EchoRequest, 0, ident<<16|seq, []byte("ping"))
+
+	v4, ok := translate6to4(ipv6Packet(v6Addr1, v6Addr2, header.ICMPv6ProtocolNumber, echo), m)
+	if !ok {
+		t.Fatal("translate6to4 failed")
+	}
+	ip4 := header.IPv4(v4)
+	if got, want := ip4.TransportProtocol(), header.ICMPv4ProtocolNumber; got != want {
+		t.Fatalf("got ip4.TransportProtocol() = %d, want = %d", got, want)
+	}
+	icmp := header.ICMPv4(ip4.Payload())
+	if got, want := icmp.Type(), header.ICMPv4Echo; got != want {
+		t.Errorf("got icmp.Type() = %d, want = %d", got, want)
+	}
+	if got := icmp.Ident(); got != ident {
+		t.Errorf("got icmp.Ident() = %d, want = %d", got, ident)
+	}
+	if got := icmp.Sequence(); got != seq {
+		t.Errorf("got icmp.Sequence() = %d, want = %d", got, seq)
+	}
+	if got := header.Checksum(icmp, 0); got != 0xffff {
+		t.Errorf("got ICMPv4 checksum sum = %#x, want = 0xffff", got)
+	}
+
+	v6, ok := translate4to6(v4, m)
+	if !ok {
+		t.Fatal("translate4to6 failed")
+	}
+	if got, want := v6[header.IPv6MinimumSize:], buffer.View(echo); !bytes.Equal(got, want) {
+		t.Errorf("got round-tripped ICMPv6 message = %x, want = %x", got, want)
+	}
+}
+
+func TestTranslateICMPv4FragmentationNeeded(t *testing.T) {
+	m := newStatelessMapper()
+
+	// v4Addr1 sent a UDP packet to v4Addr2 which a router, v4Addr3, could not
+	// forward without fragmentation.
+	inner := ipv4Packet(v4Addr1, v4Addr2, header.UDPProtocolNumber, udpSegment(v4Addr1, v4Addr2, 1000, 53, []byte("hello")))
+	icmp := icmpv4Message(header.ICMPv4DstUnreachable, header.ICMPv4FragmentationNeeded, 1400, inner)
+	v6, ok := translate4to6(ipv4Packet(v4Addr3, v4Addr1, header.ICMPv4ProtocolNumber, icmp), m)
+	if !ok {
+		t.Fatal("translate4to6 failed")
+	}
+
+	ip6 := header.IPv6(v6)
+	if got, want := ip6.SourceAddress(), v6Addr3; got != want {
+		t.Errorf("got ip6.SourceAddress() = %s, want = %s", got, want)
+	}
+	if got, want := ip6.DestinationAddress(), v6Addr1; got != want {
+		t.Errorf("got ip6.DestinationAddress() = %s, want = %s", got, want)
+	}
+	out := header.ICMPv6(v6[header.IPv6MinimumSize:])
+	if got, want := out.Type(), header.ICMPv6PacketTooBig; got != want {
+		t.Errorf("got out.Type() = %d, want = %d", got, want)
+	}
+	if got, want := out.MTU(), uint32(1420); got != want {
+		t.Errorf("got out.MTU() = %d, want = %d", got, want)
+	}
+	if got := header.Checksum(out, header.PseudoHeaderChecksum(header.ICMPv6ProtocolNumber, v6Addr3, v6Addr1, uint16(len(out)))); got != 0xffff {
+		t.Errorf("got ICMPv6 checksum sum = %#x, want = 0xffff", got)
+	}
+
+	innerOut := header.IPv6(out.Payload())
+	if got, want := innerOut.SourceAddress(), v6Addr1; got != want {
+		t.Errorf("got innerOut.SourceAddress() = %s, want = %s", got, want)
+	}
+	if got, want := innerOut.DestinationAddress(), v6Addr2; got != want {
+		t.Errorf("got innerOut.DestinationAddress() = %s, want = %s", got, want)
+	}
+	if got, want := int(innerOut.PayloadLength()), len(inner)-header.IPv4MinimumSize; got != want {
+		t.Errorf("got innerOut.PayloadLength() = %d, want = %d", got, want)
+	}
+}
+
+func TestTranslateICMPv6Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		typ      header.ICMPv6Type
+		code     header.ICMPv6Code
+		rest     uint32
+		wantOK   bool
+		wantType header.ICMPv4Type
+		wantCode header.ICMPv4Code
+		wantRest []byte
+	}{
+		{
+			name:     "address unreachable",
+			typ:      header.ICMPv6DstUnreachable,
+			code:     header.ICMPv6AddressUnreachable,
+			wantOK:   true,
+			wantType: header.ICMPv4DstUnreachable,
+			wantCode: header.ICMPv4HostUnreachable,
+			wantRest: []byte{0, 0, 0, 0},
+		},
+		{
+			name:     "port unreachable",
+			typ:      header.ICMPv6DstUnreachable,
+			code:     header.ICMPv6PortUnreachable,
+			wantOK:   true,
+			wantType: header.ICMPv4DstUnreachable,
+			wantCode: header.ICMPv4PortUnreachable,
+			wantRest: []byte{0, 0, 0, 0},
+		},
+		{
+			name:     "packet too big",
+			typ:      header.ICMPv6PacketTooBig,
+			rest:     1280,
+			wantOK:   true,
+			wantType: header.ICMPv4DstUnreachable,
+			wantCode: header.ICMPv4FragmentationNeeded,
+			wantRest: []byte{0, 0, 0x04, 0xec},
+		},
+		{
+			name:     "hop limit exceeded",
+			typ:      header.ICMPv6TimeExceeded,
+			code:     header.ICMPv6HopLimitExceeded,
+			wantOK:   true,
+			wantType: header.ICMPv4TimeExceeded,
+			wantCode: header.ICMPv4TTLExceeded,
+			wantRest: []byte{0, 0, 0, 0},
+		},
+		{
+			name:     "erroneous hop limit",
+			typ:      header.ICMPv6ParamProblem,
+			code:     header.ICMPv6ErroneousHeader,
+			rest:     7,
+			wantOK:   true,
+			wantType: header.ICMPv4ParamProblem,
+			wantRest: []byte{8, 0, 0, 0},
+		},
+		{
+			name:     "erroneous destination",
+			typ:      header.ICMPv6ParamProblem,
+			code:     header.ICMPv6ErroneousHeader,
+			rest:     30,
+			wantOK:   true,
+			wantType: header.ICMPv4ParamProblem,
+			wantRest: []byte{16, 0, 0, 0},
+		},
+		{
+			name:   "erroneous flow label",
+			typ:    header.ICMPv6ParamProblem,
+			code:   header.ICMPv6ErroneousHeader,
+			rest:   2,
+			wantOK: false,
+		},
+		{
+			name:     "unknown next header",
+			typ:      header.ICMPv6ParamProblem,
+			code:     header.ICMPv6UnknownHeader,
+			rest:     6,
+			wantOK:   true,
+			wantType: header.ICMPv4DstUnreachable,
+			wantCode: header.ICMPv4ProtoUnreachable,
+			wantRest: []byte{0, 0, 0, 0},
+		},
+		{
+			name:   "unknown option",
+			typ:    header.ICMPv6ParamProblem,
+			code:   header.ICMPv6UnknownOption,
+			wantOK: false,
+		},
+		{
+			name:   "redirect",
+			typ:    header.ICMPv6RedirectMsg,
+			wantOK: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			in := header.ICMPv6(make([]byte, header.ICMPv6MinimumSize))
+			in.SetType(test.typ)
+			in.SetCode(test.code)
+			in.SetTypeSpecific(test.rest)
+			out := header.ICMPv4(make([]byte, header.ICMPv4MinimumSize))
+
+			if got := translateICMPv6Error(in, out); got != test.wantOK {
+				t.Fatalf("got translateICMPv6Error(_, _) = %t, want = %t", got, test.wantOK)
+			}
+			if !test.wantOK {
+				return
+			}
+			if got := out.Type(); got != test.wantType {
+				t.Errorf("got out.Type() = %d, want = %d", got, test.wantType)
+			}
+			if got := out.Code(); got != test.wantCode {
+				t.Errorf("got out.Code() = %d, want = %d", got, test.wantCode)
+			}
+			if got := []byte(out[4:]); !bytes.Equal(got, test.wantRest) {
+				t.Errorf("got rest of header = %x, want = %x", got, test.wantRest)
+			}
+		})
+	}
+}
@@ -21,10 +21,12 @@ import (
 	"fmt"
 	"sync/atomic"
 
+	"gvisor.dev/gvisor/pkg/sync"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 	"gvisor.dev/gvisor/pkg/tcpip/header/parse"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ip"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
 
@@ -38,6 +40,35 @@ const (
 // facility provided by the stack to deliver packets to a layer above
 // the link-layer is via stack.NetworkEndpoint.HandlePacket.
 var _ stack.NetworkEndpoint = (*endpoint)(nil)
+var _ stack.DuplicateAddressDetector = (*endpoint)(nil)
+var _ stack.AddressAnnouncer = (*endpoint)(nil)
+var _ AddressConflictMonitor = (*endpoint)(nil)
+var _ ip.DADProtocol = (*endpoint)(nil)
+
+// AddressConflictHandler is called with the link address of a neighbor that
+// claims an address being monitored for conflicts.
+//
+// The handler is not permitted to block or to call into the stack.
+type AddressConflictHandler func(holder tcpip.LinkAddress)
+
+// AddressConflictMonitor is implemented by ARP endpoints to let integrators
+// detect and defend against conflicts for addresses that are already in use,
+// as per RFC 5227 section 2.4.
+type AddressConflictMonitor interface {
+	// MonitorAddressConflicts calls h whenever an ARP packet sent by another
+	// node with the sender IP address set to addr is received, until the
+	// returned function is called.
+	MonitorAddressConflicts(addr tcpip.Address, h AddressConflictHandler) (stop func())
+
+	// SendAnnouncement sends an ARP Announcement for addr, regardless of
+	// Options.SendGratuitousARP.
+	SendAnnouncement(addr tcpip.Address) *tcpip.Error
+}
+
+// conflictMonitor is a registration made through MonitorAddressConflicts.
+type conflictMonitor struct {
+	handler AddressConflictHandler
+}
 
 type endpoint struct {
 	protocol *protocol
@@ -50,6 +81,160 @@ type endpoint struct {
 	nic           stack.NetworkInterface
 	linkAddrCache stack.LinkAddressCache
 	nud           stack.NUDHandler
+
+	mu struct {
+		sync.Mutex
+
+		dad ip.DAD
+
+		// conflictMonitors holds the registered conflict monitors, keyed by the
+		// monitored address.
+		conflictMonitors map[tcpip.Address]map[*conflictMonitor]struct{}
+
+		policies Policies
+	}
+}
+
+// MonitorAddressConflicts implements AddressConflictMonitor.
+func (e *endpoint) MonitorAddressConflicts(addr tcpip.Address, h AddressConflictHandler) func() {
+	m := &conflictMonitor{handler: h}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	monitors, ok := e.mu.conflictMonitors[addr]
+	if !ok {
+		monitors = make(map[*conflictMonitor]struct{})
+		e.mu.conflictMonitors[addr] = monitors
+	}
+	monitors[m] = struct{}{}
+
+	return func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		delete(monitors, m)
+		if len(e.mu.conflictMonitors[addr]) == 0 {
+			delete(e.mu.conflictMonitors, addr)
+		}
+	}
+}
+
+// SendAnnouncement implements AddressConflictMonitor.
+func (e *endpoint) SendAnnouncement(addr tcpip.Address) *tcpip.Error {
+	// Only unicast addresses are resolved with ARP.
+	if len(addr) != header.IPv4AddressSize || addr == header.IPv4Any || addr == header.IPv4Broadcast || header.IsV4MulticastAddress(addr) {
+		return tcpip.ErrBadAddress
+	}
+
+	if !e.Enabled() {
+		return tcpip.ErrNotPermitted
+	}
+
+	return e.sendARPRequest(addr, addr, header.EthernetBroadcastAddress)
+}
+
+// CheckDuplicateAddress implements stack.DuplicateAddressDetector.
+func (e *endpoint) CheckDuplicateAddress(addr tcpip.Address, h stack.DADCompletionHandler) stack.DADCheckAddressDisposition {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.mu.dad.CheckDuplicateAddressLocked(addr, h)
+}
+
+// SetDADConfigurations implements stack.DuplicateAddressDetector.
+func (e *endpoint) SetDADConfigurations(c stack.DADConfigurations) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.mu.dad.SetConfigsLocked(c)
+}
+
+// DuplicateAddressProtocol implements stack.DuplicateAddressDetector.
+func (*endpoint) DuplicateAddressProtocol() tcpip.NetworkProtocolNumber {
+	return header.IPv4ProtocolNumber
+}
+
+// SendDADMessage implements ip.DADProtocol.
+//
+// As per RFC 5227 section 2.1.1, an ARP Probe is an ARP Request with the
+// sender IP address set to all zeroes and the target IP address set to the
+// address being probed.
+func (e *endpoint) SendDADMessage(addr tcpip.Address) *tcpip.Error {
+	return e.sendARPRequest(header.IPv4Any, addr, header.EthernetBroadcastAddress)
+}
+
+// AnnounceAddress implements stack.AddressAnnouncer.
+//
+// As per RFC 5227 section 2.3, an ARP Announcement is an ARP Request with both
+// the sender and target IP addresses set to the announced address. Receivers
+// update any cached entry for the address with our link address.
+//
+// Does nothing if the protocol is not configured to send gratuitous ARP.
+func (e *endpoint) AnnounceAddress(addr tcpip.Address) *tcpip.Error {
+	if !e.protocol.options.SendGratuitousARP {
+		return nil
+	}
+
+	return e.SendAnnouncement(addr)
+}
+
+// AnnouncedAddressProtocol implements stack.AddressAnnouncer.
+func (*endpoint) AnnouncedAddressProtocol() tcpip.NetworkProtocolNumber {
+	return header.IPv4ProtocolNumber
+}
+
+// handleConflictingPacket checks if the ARP packet conflicts with an address
+// that is currently being probed or monitored for conflicts.
+//
+// As per RFC 5227 section 2.1.1,
+//
+//   If during this period, from the beginning of the probing process until
+//   ANNOUNCE_WAIT seconds after the last probe packet is sent, the host
+//   receives any ARP packet (Request *or* Reply) on the interface where the
+//   probe is being performed, where the packet's 'sender IP address' is the
+//   address being probed for, then the host MUST treat this address as being
+//   in use by some other host, and should indicate to the configuring agent
+//   (human operator, DHCP server, etc.) that the proposed address is
+//   unacceptable.
+//
+//   In addition, if during this period the host receives any ARP Probe where
+//   the packet's 'target IP address' is the address being probed for, and the
+//   packet's 'sender hardware address' is not the hardware address of any of
+//   the host's interfaces, then the host SHOULD similarly treat this as an
+//   address conflict and signal an error to the configuring agent as above.
+//
+// Once an address is in use, a conflict is reported to the address' conflict
+// monitors, as per RFC 5227 section 2.4.
+func (e *endpoint) handleConflictingPacket(h header.ARP) {
+	senderAddr := tcpip.Address(h.ProtocolAddressSender())
+	senderLinkAddr := tcpip.LinkAddress(h.HardwareAddressSender())
+
+	if senderAddr != header.IPv4Any {
+		var handlers []AddressConflictHandler
+		e.mu.Lock()
+		if e.mu.dad.IsRunningLocked(senderAddr) {
+			e.mu.dad.StopLocked(senderAddr, &stack.DADDupAddrDetected{HolderLinkAddress: senderLinkAddr})
+		}
+		if senderLinkAddr != e.nic.LinkAddress() {
+			for m := range e.mu.conflictMonitors[senderAddr] {
+				handlers = append(handlers, m.handler)
+			}
+		}
+		e.mu.Unlock()
+
+		for _, h := range handlers {
+			h(senderLinkAddr)
+		}
+		return
+	}
+
+	if h.Op() != header.ARPRequest || senderLinkAddr == e.nic.LinkAddress() {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	targetAddr := tcpip.Address(h.ProtocolAddressTarget())
+	if e.mu.dad.IsRunningLocked(targetAddr) {
+		e.mu.dad.StopLocked(targetAddr, &stack.DADDupAddrDetected{HolderLinkAddress: senderLinkAddr})
+	}
 }
 
 func (e *endpoint) Enable() *tcpip.Error {
@@ -128,23 +313,19 @@ func (e *endpoint) HandlePacket(pkt *stack.PacketBuffer) {
 		return
 	}
 
+	e.handleConflictingPacket(h)
+
 	switch h.Op() {
 	case header.ARPRequest:
-		localAddr := tcpip.Address(h.ProtocolAddressTarget())
+		if !e.shouldReply(h) {
+			return // we have no useful answer, ignore the request
+		}
 
 		if e.nud == nil {
-			if e.linkAddrCache.CheckLocalAddress(e.nic.ID(), header.IPv4ProtocolNumber, localAddr) == 0 {
-				return // we have no useful answer, ignore the request
-			}
-
 			addr := tcpip.Address(h.ProtocolAddressSender())
 			linkAddr := tcpip.LinkAddress(h.HardwareAddressSender())
 			e.linkAddrCache.AddLinkAddress(e.nic.ID(), addr, linkAddr)
 		} else {
-			if e.protocol.stack.CheckLocalAddress(e.nic.ID(), header.IPv4ProtocolNumber, localAddr) == 0 {
-				return // we have no useful answer, ignore the request
-			}
-
 			remoteAddr := tcpip.Address(h.ProtocolAddressSender())
 			remoteLinkAddr := tcpip.LinkAddress(h.HardwareAddressSender())
 			e.nud.HandleProbe(remoteAddr, ProtocolNumber, remoteLinkAddr, e.protocol)
@@ -205,7 +386,8 @@ func (e *endpoint) HandlePacket(pkt *stack.PacketBuffer) {
 
 // protocol implements stack.NetworkProtocol and stack.LinkAddressResolver.
 type protocol struct {
-	stack *stack.Stack
+	stack   *stack.Stack
+	options Options
 }
 
 func (p *protocol) Number() tcpip.NetworkProtocolNumber { return ProtocolNumber }
@@ -223,6 +405,17 @@ func (p *protocol) NewEndpoint(nic stack.NetworkInterface, linkAddrCache stack.L
 		linkAddrCache: linkAddrCache,
 		nud:           nud,
 	}
+
+	e.mu.Lock()
+	e.mu.conflictMonitors = make(map[tcpip.Address]map[*conflictMonitor]struct{})
+	e.mu.policies = p.options.Policies
+	e.mu.dad.Init(&e.mu, p.options.DADConfigs, ip.DADOptions{
+		Clock:    p.stack.Clock(),
+		Protocol: e,
+		NICID:    nic.ID(),
+	})
+	e.mu.Unlock()
+
 	return e
 }
 
@@ -238,6 +431,10 @@ func (p *protocol) LinkAddressRequest(targetAddr, localAddr tcpip.Address, remot
 	}
 
 	nicID := nic.ID()
+	ep, _ := p.stack.GetNetworkEndpoint(nicID, ProtocolNumber)
+	if e, ok := ep.(*endpoint); ok {
+		localAddr = e.requestSourceAddress(targetAddr, localAddr)
+	}
 	if len(localAddr) == 0 {
 		addr, err := p.stack.GetMainNICAddress(nicID, header.IPv4ProtocolNumber)
 		if err != nil {
@@ -253,6 +450,14 @@ func (p *protocol) LinkAddressRequest(targetAddr, localAddr tcpip.Address, remot
 		return tcpip.ErrBadLocalAddress
 	}
 
+	return sendARPRequest(nic, localAddr, targetAddr, remoteLinkAddr)
+}
+
+func (e *endpoint) sendARPRequest(localAddr, targetAddr tcpip.Address, remoteLinkAddr tcpip.LinkAddress) *tcpip.Error {
+	return sendARPRequest(e.nic, localAddr, targetAddr, remoteLinkAddr)
+}
+
+func sendARPRequest(nic stack.NetworkInterface, localAddr, targetAddr tcpip.Address, remoteLinkAddr tcpip.LinkAddress) *tcpip.Error {
 	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
 		ReserveHeaderBytes: int(nic.MaxHeaderLength()) + header.ARPSize,
 	})
@@ -304,7 +509,36 @@ func (*protocol) Parse(pkt *stack.PacketBuffer) (proto tcpip.TransportProtocolNu
 	return 0, false, parse.ARP(pkt)
 }
 
+// Options holds options to configure a protocol.
+type Options struct {
+	// DADConfigs is the default DAD configurations used by ARP endpoints.
+	//
+	// Note, DAD (Address Conflict Detection as described by RFC 5227) is
+	// disabled by default.
+	DADConfigs stack.DADConfigurations
+
+	// SendGratuitousARP determines whether or not an ARP Announcement is sent
+	// when an IPv4 address is assigned to an enabled NIC, and for all assigned
+	// IPv4 addresses when a NIC is enabled. This lets neighbors quickly learn a
+	// new link address for our addresses (e.g. after the node migrates).
+	SendGratuitousARP bool
+
+	// Policies is the default policies of ARP endpoints.
+	Policies Policies
+}
+
+// NewProtocolWithOptions returns an ARP network protocol factory that
+// will return an ARP network protocol with the provided options.
+func NewProtocolWithOptions(opts Options) stack.NetworkProtocolFactory {
+	return func(s *stack.Stack) stack.NetworkProtocol {
+		return &protocol{
+			stack:   s,
+			options: opts,
+		}
+	}
+}
+
 // NewProtocol returns an ARP network protocol.
 func NewProtocol(s *stack.Stack) stack.NetworkProtocol {
-	return &protocol{stack: s}
+	return NewProtocolWithOptions(Options{})(s)
 }
@@ -25,6 +25,7 @@ import (
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
 	"gvisor.dev/gvisor/pkg/tcpip/link/sniffer"
@@ -577,3 +578,720 @@ func TestLinkAddressRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestDADARPProbe(t *testing.T) {
+	const dadTransmits = 3
+
+	dadConfigs := stack.DADConfigurations{
+		DupAddrDetectTransmits: dadTransmits,
+		RetransmitTimer:        time.Second,
+	}
+
+	checkProbe := func(t *testing.T, pkt channel.PacketInfo) {
+		t.Helper()
+
+		if pkt.Proto != arp.ProtocolNumber {
+			t.Fatalf("got pkt.Proto = %d, want = %d", pkt.Proto, arp.ProtocolNumber)
+		}
+		if got := pkt.Route.RemoteLinkAddress(); got != header.EthernetBroadcastAddress {
+			t.Errorf("got pkt.Route.RemoteLinkAddress() = %s, want = %s", got, header.EthernetBroadcastAddress)
+		}
+		req := header.ARP(stack.PayloadSince(pkt.Pkt.NetworkHeader()))
+		if !req.IsValid() {
+			t.Fatalf("invalid ARP packet: len = %d; packet = %x", len(req), req)
+		}
+		if got := req.Op(); got != header.ARPRequest {
+			t.Errorf("got req.Op() = %d, want = %d", got, header.ARPRequest)
+		}
+		if got := tcpip.LinkAddress(req.HardwareAddressSender()); got != stackLinkAddr {
+			t.Errorf("got req.HardwareAddressSender() = %s, want = %s", got, stackLinkAddr)
+		}
+		if got := tcpip.Address(req.ProtocolAddressSender()); got != header.IPv4Any {
+			t.Errorf("got req.ProtocolAddressSender() = %s, want = %s", got, header.IPv4Any)
+		}
+		if got := tcpip.Address(req.ProtocolAddressTarget()); got != remoteAddr {
+			t.Errorf("got req.ProtocolAddressTarget() = %s, want = %s", got, remoteAddr)
+		}
+	}
+
+	injectARP := func(e *channel.Endpoint, op header.ARPOp, senderLinkAddr tcpip.LinkAddress, senderAddr, targetAddr tcpip.Address) {
+		v := make(buffer.View, header.ARPSize)
+		h := header.ARP(v)
+		h.SetIPv4OverEthernet()
+		h.SetOp(op)
+		copy(h.HardwareAddressSender(), senderLinkAddr)
+		copy(h.ProtocolAddressSender(), senderAddr)
+		copy(h.ProtocolAddressTarget(), targetAddr)
+		e.InjectInbound(arp.ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Data: v.ToVectorisedView(),
+		}))
+	}
+
+	tests := []struct {
+		name          string
+		injectAfter   int
+		inject        func(*channel.Endpoint)
+		expectedProbe int
+		expectedRes   stack.DADResult
+	}{
+		{
+			name:          "No conflict",
+			expectedProbe: dadTransmits,
+			expectedRes:   &stack.DADSucceeded{},
+		},
+		{
+			name:        "Reply from holder",
+			injectAfter: 1,
+			inject: func(e *channel.Endpoint) {
+				injectARP(e, header.ARPReply, remoteLinkAddr, remoteAddr, header.IPv4Any)
+			},
+			expectedProbe: 1,
+			expectedRes:   &stack.DADDupAddrDetected{HolderLinkAddress: remoteLinkAddr},
+		},
+		{
+			name:        "Request from holder",
+			injectAfter: 2,
+			inject: func(e *channel.Endpoint) {
+				injectARP(e, header.ARPRequest, remoteLinkAddr, remoteAddr, unknownAddr)
+			},
+			expectedProbe: 2,
+			expectedRes:   &stack.DADDupAddrDetected{HolderLinkAddress: remoteLinkAddr},
+		},
+		{
+			name:        "Probe from another host",
+			injectAfter: 1,
+			inject: func(e *channel.Endpoint) {
+				injectARP(e, header.ARPRequest, remoteLinkAddr, header.IPv4Any, remoteAddr)
+			},
+			expectedProbe: 1,
+			expectedRes:   &stack.DADDupAddrDetected{HolderLinkAddress: remoteLinkAddr},
+		},
+		{
+			name:        "Looped back probe",
+			injectAfter: 1,
+			inject: func(e *channel.Endpoint) {
+				injectARP(e, header.ARPRequest, stackLinkAddr, header.IPv4Any, remoteAddr)
+			},
+			expectedProbe: dadTransmits,
+			expectedRes:   &stack.DADSucceeded{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := faketime.NewManualClock()
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{arp.NewProtocolWithOptions(arp.Options{
+					DADConfigs: dadConfigs,
+				}), ipv4.NewProtocol},
+				Clock: clock,
+			})
+			e := channel.New(dadTransmits, defaultMTU, stackLinkAddr)
+			if err := s.CreateNIC(nicID, e); err != nil {
+				t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+			}
+
+			ch := make(chan stack.DADResult, 1)
+			if res, err := s.CheckDuplicateAddress(nicID, header.IPv4ProtocolNumber, remoteAddr, func(r stack.DADResult) {
+				ch <- r
+			}); err != nil {
+				t.Fatalf("s.CheckDuplicateAddress(%d, %d, %s, _): %s", nicID, header.IPv4ProtocolNumber, remoteAddr, err)
+			} else if res != stack.DADStarting {
+				t.Fatalf("got s.CheckDuplicateAddress(%d, %d, %s, _) = %d, want = %d", nicID, header.IPv4ProtocolNumber, remoteAddr, res, stack.DADStarting)
+			}
+
+			for i := 0; i < dadTransmits; i++ {
+				if test.inject != nil && i == test.injectAfter {
+					test.inject(e)
+				}
+				if i != 0 {
+					clock.Advance(dadConfigs.RetransmitTimer)
+				} else {
+					clock.Advance(0)
+				}
+			}
+			clock.Advance(dadConfigs.RetransmitTimer)
+
+			for i := 0; i < test.expectedProbe; i++ {
+				pkt, ok := e.Read()
+				if !ok {
+					t.Fatalf("expected probe #%d to be sent", i+1)
+				}
+				checkProbe(t, pkt)
+			}
+			if pkt, ok := e.Read(); ok {
+				t.Errorf("unexpected packet sent, Proto=%d", pkt.Proto)
+			}
+
+			select {
+			case r := <-ch:
+				if diff := cmp.Diff(test.expectedRes, r); diff != "" {
+					t.Errorf("DAD result mismatch (-want +got):\n%s", diff)
+				}
+			default:
+				t.Fatal("expected DAD result")
+			}
+		})
+	}
+}
+
+func TestGratuitousARP(t *testing.T) {
+	checkAnnouncement := func(t *testing.T, e *channel.Endpoint) {
+		t.Helper()
+
+		pkt, ok := e.Read()
+		if !ok {
+			t.Fatal("expected ARP Announcement to be sent")
+		}
+		if pkt.Proto != arp.ProtocolNumber {
+			t.Fatalf("got pkt.Proto = %d, want = %d", pkt.Proto, arp.ProtocolNumber)
+		}
+		if got := pkt.Route.RemoteLinkAddress(); got != header.EthernetBroadcastAddress {
+			t.Errorf("got pkt.Route.RemoteLinkAddress() = %s, want = %s", got, header.EthernetBroadcastAddress)
+		}
+		req := header.ARP(stack.PayloadSince(pkt.Pkt.NetworkHeader()))
+		if !req.IsValid() {
+			t.Fatalf("invalid ARP packet: len = %d; packet = %x", len(req), req)
+		}
+		if got := req.Op(); got != header.ARPRequest {
+			t.Errorf("got req.Op() = %d, want = %d", got, header.ARPRequest)
+		}
+		if got := tcpip.LinkAddress(req.HardwareAddressSender()); got != stackLinkAddr {
+			t.Errorf("got req.HardwareAddressSender() = %s, want = %s", got, stackLinkAddr)
+		}
+		if got := tcpip.Address(req.ProtocolAddressSender()); got != stackAddr {
+			t.Errorf("got req.ProtocolAddressSender() = %s, want = %s", got, stackAddr)
+		}
+		if got := tcpip.Address(req.ProtocolAddressTarget()); got != stackAddr {
+			t.Errorf("got req.ProtocolAddressTarget() = %s, want = %s", got, stackAddr)
+		}
+	}
+
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprintf("SendGratuitousARP=%t", enabled), func(t *testing.T) {
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{arp.NewProtocolWithOptions(arp.Options{
+					SendGratuitousARP: enabled,
+				}), ipv4.NewProtocol},
+			})
+			e := channel.New(defaultChannelSize, defaultMTU, stackLinkAddr)
+			if err := s.CreateNIC(nicID, e); err != nil {
+				t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+			}
+
+			if err := s.AddAddress(nicID, ipv4.ProtocolNumber, stackAddr); err != nil {
+				t.Fatalf("s.AddAddress(%d, %d, %s): %s", nicID, ipv4.ProtocolNumber, stackAddr, err)
+			}
+			if enabled {
+				checkAnnouncement(t, e)
+			}
+			if pkt, ok := e.Read(); ok {
+				t.Errorf("unexpected packet sent after adding address, Proto=%d", pkt.Proto)
+			}
+
+			// Addresses should be announced again when the NIC comes back up.
+			if err := s.DisableNIC(nicID); err != nil {
+				t.Fatalf("s.DisableNIC(%d): %s", nicID, err)
+			}
+			if err := s.EnableNIC(nicID); err != nil {
+				t.Fatalf("s.EnableNIC(%d): %s", nicID, err)
+			}
+			if enabled {
+				checkAnnouncement(t, e)
+			}
+			if pkt, ok := e.Read(); ok {
+				t.Errorf("unexpected packet sent after enabling NIC, Proto=%d", pkt.Proto)
+			}
+		})
+	}
+}
+
+func TestAddressConflictMonitor(t *testing.T) {
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{arp.NewProtocol, ipv4.NewProtocol},
+	})
+	e := channel.New(defaultChannelSize, defaultMTU, stackLinkAddr)
+	if err := s.CreateNIC(nicID, e); err != nil {
+		t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+	}
+	if err := s.AddAddress(nicID, ipv4.ProtocolNumber, stackAddr); err != nil {
+		t.Fatalf("s.AddAddress(%d, %d, %s): %s", nicID, ipv4.ProtocolNumber, stackAddr, err)
+	}
+
+	netEP, err := s.GetNetworkEndpoint(nicID, arp.ProtocolNumber)
+	if err != nil {
+		t.Fatalf("s.GetNetworkEndpoint(%d, %d): %s", nicID, arp.ProtocolNumber, err)
+	}
+	monitor, ok := netEP.(arp.AddressConflictMonitor)
+	if !ok {
+		t.Fatalf("expected %T to implement arp.AddressConflictMonitor", netEP)
+	}
+
+	injectARP := func(op header.ARPOp, senderLinkAddr tcpip.LinkAddress, senderAddr, targetAddr tcpip.Address) {
+		v := make(buffer.View, header.ARPSize)
+		h := header.ARP(v)
+		h.SetIPv4OverEthernet()
+		h.SetOp(op)
+		copy(h.HardwareAddressSender(), senderLinkAddr)
+		copy(h.ProtocolAddressSender(), senderAddr)
+		copy(h.ProtocolAddressTarget(), targetAddr)
+		e.InjectInbound(arp.ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Data: v.ToVectorisedView(),
+		}))
+	}
+
+	ch := make(chan tcpip.LinkAddress, 1)
+	stop := monitor.MonitorAddressConflicts(stackAddr, func(holder tcpip.LinkAddress) {
+		ch <- holder
+	})
+
+	expectConflict := func(want tcpip.LinkAddress) {
+		t.Helper()
+
+		select {
+		case got := <-ch:
+			if got != want {
+				t.Errorf("got conflict holder = %s, want = %s", got, want)
+			}
+		default:
+			if want != "" {
+				t.Errorf("expected conflict with %s", want)
+			}
+		}
+	}
+
+	// Probes and our own packets are not conflicts for addresses in use.
+	injectARP(header.ARPRequest, remoteLinkAddr, header.IPv4Any, stackAddr)
+	expectConflict("")
+	injectARP(header.ARPRequest, stackLinkAddr, stackAddr, stackAddr)
+	expectConflict("")
+
+	injectARP(header.ARPReply, remoteLinkAddr, stackAddr, remoteAddr)
+	expectConflict(remoteLinkAddr)
+	injectARP(header.ARPRequest, remoteLinkAddr, stackAddr, stackAddr)
+	expectConflict(remoteLinkAddr)
+
+	stop()
+	injectARP(header.ARPReply, remoteLinkAddr, stackAddr, remoteAddr)
+	expectConflict("")
+
+	// Announcements are sent on demand even if gratuitous ARP is disabled.
+	e.Drain()
+	if err := monitor.SendAnnouncement(stackAddr); err != nil {
+		t.Fatalf("monitor.SendAnnouncement(%s): %s", stackAddr, err)
+	}
+	pkt, ok := e.Read()
+	if !ok {
+		t.Fatal("expected ARP Announcement to be sent")
+	}
+	req := header.ARP(stack.PayloadSince(pkt.Pkt.NetworkHeader()))
+	if !req.IsValid() {
+		t.Fatalf("invalid ARP packet: len = %d; packet = %x", len(req), req)
+	}
+	if got := tcpip.Address(req.ProtocolAddressSender()); got != stackAddr {
+		t.Errorf("got req.ProtocolAddressSender() = %s, want = %s", got, stackAddr)
+	}
+	if got := tcpip.Address(req.ProtocolAddressTarget()); got != stackAddr {
+		t.Errorf("got req.ProtocolAddressTarget() = %s, want = %s", got, stackAddr)
+	}
+}
+
+var _ stack.DADDispatcher = (*dadDispatcher)(nil)
+
+type dadDispatcher struct {
+	ch chan stack.DADResult
+}
+
+// OnDuplicateAddressDetectionResult implements stack.DADDispatcher.
+func (d *dadDispatcher) OnDuplicateAddressDetectionResult(_ tcpip.NICID, _ tcpip.Address, r stack.DADResult) {
+	d.ch <- r
+}
+
+func TestAddAddressWithDAD(t *testing.T) {
+	const dadTransmits = 2
+
+	dadConfigs := stack.DADConfigurations{
+		DupAddrDetectTransmits: dadTransmits,
+		RetransmitTimer:        time.Second,
+	}
+
+	tests := []struct {
+		name         string
+		conflict     bool
+		expectedRes  stack.DADResult
+		expectedAddr bool
+	}{
+		{
+			name:         "No conflict",
+			expectedRes:  &stack.DADSucceeded{},
+			expectedAddr: true,
+		},
+		{
+			name:         "Conflict",
+			conflict:     true,
+			expectedRes:  &stack.DADDupAddrDetected{HolderLinkAddress: remoteLinkAddr},
+			expectedAddr: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := faketime.NewManualClock()
+			disp := dadDispatcher{ch: make(chan stack.DADResult, 1)}
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{arp.NewProtocolWithOptions(arp.Options{
+					DADConfigs: dadConfigs,
+				}), ipv4.NewProtocol},
+				Clock:   clock,
+				DADDisp: &disp,
+			})
+			e := channel.New(dadTransmits, defaultMTU, stackLinkAddr)
+			if err := s.CreateNIC(nicID, e); err != nil {
+				t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+			}
+
+			protocolAddr := tcpip.ProtocolAddress{
+				Protocol:          ipv4.ProtocolNumber,
+				AddressWithPrefix: stackAddr.WithPrefix(),
+			}
+			if err := s.AddProtocolAddressWithDAD(nicID, protocolAddr, stack.CanBePrimaryEndpoint); err != nil {
+				t.Fatalf("s.AddProtocolAddressWithDAD(%d, %+v, %d): %s", nicID, protocolAddr, stack.CanBePrimaryEndpoint, err)
+			}
+			clock.Advance(0)
+
+			// The address should not be usable while DAD is being performed.
+			if got := s.CheckLocalAddress(nicID, ipv4.ProtocolNumber, stackAddr); got != 0 {
+				t.Errorf("got s.CheckLocalAddress(%d, %d, %s) = %d, want = 0", nicID, ipv4.ProtocolNumber, stackAddr, got)
+			}
+
+			if test.conflict {
+				v := make(buffer.View, header.ARPSize)
+				h := header.ARP(v)
+				h.SetIPv4OverEthernet()
+				h.SetOp(header.ARPReply)
+				copy(h.HardwareAddressSender(), remoteLinkAddr)
+				copy(h.ProtocolAddressSender(), stackAddr)
+				copy(h.ProtocolAddressTarget(), header.IPv4Any)
+				e.InjectInbound(arp.ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+					Data: v.ToVectorisedView(),
+				}))
+			} else {
+				clock.Advance(dadConfigs.RetransmitTimer * dadTransmits)
+			}
+
+			select {
+			case r := <-disp.ch:
+				if diff := cmp.Diff(test.expectedRes, r); diff != "" {
+					t.Errorf("DAD result mismatch (-want +got):\n%s", diff)
+				}
+			default:
+				t.Fatal("expected DAD result")
+			}
+
+			want := tcpip.NICID(0)
+			if test.expectedAddr {
+				want = nicID
+			}
+			if got := s.CheckLocalAddress(nicID, ipv4.ProtocolNumber, stackAddr); got != want {
+				t.Errorf("got s.CheckLocalAddress(%d, %d, %s) = %d, want = %d", nicID, ipv4.ProtocolNumber, stackAddr, got, want)
+			}
+			// The address should only remain on the NIC if DAD succeeded.
+			found := false
+			for _, a := range s.AllAddresses()[nicID] {
+				if a == protocolAddr {
+					found = true
+				}
+			}
+			if found != test.expectedAddr {
+				t.Errorf("got address %+v found = %t, want = %t", protocolAddr, found, test.expectedAddr)
+			}
+		})
+	}
+}
+
+func TestAddAddressWithDADHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		dadTransmits uint8
+	}{
+		{
+			name:         "DAD disabled",
+			dadTransmits: 0,
+		},
+		{
+			name:         "DAD enabled",
+			dadTransmits: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dadConfigs := stack.DADConfigurations{
+				DupAddrDetectTransmits: test.dadTransmits,
+				RetransmitTimer:        time.Second,
+			}
+			clock := faketime.NewManualClock()
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{arp.NewProtocolWithOptions(arp.Options{
+					DADConfigs: dadConfigs,
+				}), ipv4.NewProtocol},
+				Clock: clock,
+			})
+			e := channel.New(defaultChannelSize, defaultMTU, stackLinkAddr)
+			if err := s.CreateNIC(nicID, e); err != nil {
+				t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+			}
+
+			ch := make(chan stack.DADResult, 1)
+			protocolAddr := tcpip.ProtocolAddress{
+				Protocol:          ipv4.ProtocolNumber,
+				AddressWithPrefix: stackAddr.WithPrefix(),
+			}
+			if err := s.AddProtocolAddressWithDADHandler(nicID, protocolAddr, stack.CanBePrimaryEndpoint, func(r stack.DADResult) {
+				ch <- r
+			}); err != nil {
+				t.Fatalf("s.AddProtocolAddressWithDADHandler(%d, %+v, %d, _): %s", nicID, protocolAddr, stack.CanBePrimaryEndpoint, err)
+			}
+
+			if test.dadTransmits != 0 {
+				clock.Advance(0)
+				select {
+				case r := <-ch:
+					t.Fatalf("unexpected DAD result before DAD completed = %#v", r)
+				default:
+				}
+				clock.Advance(dadConfigs.RetransmitTimer * time.Duration(test.dadTransmits))
+			}
+
+			// The address must be usable as soon as the handler is called.
+			select {
+			case r := <-ch:
+				if diff := cmp.Diff(&stack.DADSucceeded{}, r); diff != "" {
+					t.Errorf("DAD result mismatch (-want +got):\n%s", diff)
+				}
+			default:
+				t.Fatal("expected DAD result")
+			}
+			if got := s.CheckLocalAddress(nicID, ipv4.ProtocolNumber, stackAddr); got != nicID {
+				t.Errorf("got s.CheckLocalAddress(%d, %d, %s) = %d, want = %d", nicID, ipv4.ProtocolNumber, stackAddr, got, nicID)
+			}
+		})
+	}
+}
+
+func TestIgnorePolicy(t *testing.T) {
+	const nic2ID = 2
+	nic2Addr := tcpip.Address("\xc0\xa8\x01\x01")
+	otherSubnetAddr := tcpip.Address("\xc0\xa8\x05\x05")
+
+	tests := []struct {
+		name       string
+		senderAddr tcpip.Address
+		targetAddr tcpip.Address
+		// wantReply holds whether a reply is expected, indexed by policy.
+		wantReply map[arp.IgnorePolicy]bool
+	}{
+		{
+			name:       "Interface address",
+			senderAddr: remoteAddr,
+			targetAddr: stackAddr,
+			wantReply: map[arp.IgnorePolicy]bool{
+				arp.ReplyForInterfaceAddress: true,
+				arp.ReplyForAnyLocalAddress:  true,
+				arp.ReplyForInterfaceSubnet:  true,
+			},
+		},
+		{
+			name:       "Interface address from another subnet",
+			senderAddr: otherSubnetAddr,
+			targetAddr: stackAddr,
+			wantReply: map[arp.IgnorePolicy]bool{
+				arp.ReplyForInterfaceAddress: true,
+				arp.ReplyForAnyLocalAddress:  true,
+			},
+		},
+		{
+			name:       "Probe for interface address",
+			senderAddr: header.IPv4Any,
+			targetAddr: stackAddr,
+			wantReply: map[arp.IgnorePolicy]bool{
+				arp.ReplyForInterfaceAddress: true,
+				arp.ReplyForAnyLocalAddress:  true,
+				arp.ReplyForInterfaceSubnet:  true,
+			},
+		},
+		{
+			name:       "Address of another interface",
+			senderAddr: remoteAddr,
+			targetAddr: nic2Addr,
+			wantReply: map[arp.IgnorePolicy]bool{
+				arp.ReplyForAnyLocalAddress: true,
+			},
+		},
+		{
+			name:       "Unknown address",
+			senderAddr: remoteAddr,
+			targetAddr: unknownAddr,
+			wantReply:  map[arp.IgnorePolicy]bool{},
+		},
+	}
+
+	for _, test := range tests {
+		for _, policy := range []arp.IgnorePolicy{arp.ReplyForInterfaceAddress, arp.ReplyForAnyLocalAddress, arp.ReplyForInterfaceSubnet, arp.ReplyNever} {
+			t.Run(fmt.Sprintf("%s/Policy=%d", test.name, policy), func(t *testing.T) {
+				s := stack.New(stack.Options{
+					NetworkProtocols: []stack.NetworkProtocolFactory{arp.NewProtocolWithOptions(arp.Options{
+						Policies: arp.Policies{Ignore: policy},
+					}), ipv4.NewProtocol},
+				})
+				e := channel.New(defaultChannelSize, defaultMTU, stackLinkAddr)
+				if err := s.CreateNIC(nicID, e); err != nil {
+					t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+				}
+				if err := s.CreateNIC(nic2ID, channel.New(defaultChannelSize, defaultMTU, "")); err != nil {
+					t.Fatalf("s.CreateNIC(%d, _): %s", nic2ID, err)
+				}
+				for _, protocolAddr := range []struct {
+					nicID tcpip.NICID
+					addr  tcpip.ProtocolAddress
+				}{
+					{nicID: nicID, addr: tcpip.ProtocolAddress{Protocol: ipv4.ProtocolNumber, AddressWithPrefix: tcpip.AddressWithPrefix{Address: stackAddr, PrefixLen: 24}}},
+					{nicID: nic2ID, addr: tcpip.ProtocolAddress{Protocol: ipv4.ProtocolNumber, AddressWithPrefix: tcpip.AddressWithPrefix{Address: nic2Addr, PrefixLen: 24}}},
+				} {
+					if err := s.AddProtocolAddress(protocolAddr.nicID, protocolAddr.addr); err != nil {
+						t.Fatalf("s.AddProtocolAddress(%d, %#v): %s", protocolAddr.nicID, protocolAddr.addr, err)
+					}
+				}
+
+				v := make(buffer.View, header.ARPSize)
+				h := header.ARP(v)
+				h.SetIPv4OverEthernet()
+				h.SetOp(header.ARPRequest)
+				copy(h.HardwareAddressSender(), remoteLinkAddr)
+				copy(h.ProtocolAddressSender(), test.senderAddr)
+				copy(h.ProtocolAddressTarget(), test.targetAddr)
+				e.InjectInbound(arp.ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+					Data: v.ToVectorisedView(),
+				}))
+
+				pkt, ok := e.Read()
+				if want := test.wantReply[policy]; ok != want {
+					t.Fatalf("got reply sent = %t, want = %t", ok, want)
+				}
+				if !ok {
+					return
+				}
+				rep := header.ARP(stack.PayloadSince(pkt.Pkt.NetworkHeader()))
+				if got := rep.Op(); got != header.ARPReply {
+					t.Errorf("got rep.Op() = %d, want = %d", got, header.ARPReply)
+				}
+				if got := tcpip.Address(rep.ProtocolAddressSender()); got != test.targetAddr {
+					t.Errorf("got rep.ProtocolAddressSender() = %s, want = %s", got, test.targetAddr)
+				}
+			})
+		}
+	}
+}
+
+func TestAnnouncePolicy(t *testing.T) {
+	subnetAddr := tcpip.Address("\xc0\xa8\x01\x01")
+	subnetTargetAddr := tcpip.Address("\xc0\xa8\x01\x02")
+	offLinkAddr := tcpip.Address("\xac\x10\x00\x01")
+
+	tests := []struct {
+		name       string
+		targetAddr tcpip.Address
+		localAddr  tcpip.Address
+		// wantLocalAddr holds the expected sender address, indexed by policy.
+		wantLocalAddr map[arp.AnnouncePolicy]tcpip.Address
+	}{
+		{
+			name:       "Source from another subnet",
+			targetAddr: subnetTargetAddr,
+			localAddr:  stackAddr,
+			wantLocalAddr: map[arp.AnnouncePolicy]tcpip.Address{
+				arp.AnnounceAnyLocalAddress:    stackAddr,
+				arp.AnnounceSubnetLocalAddress: subnetAddr,
+				arp.AnnounceBestLocalAddress:   subnetAddr,
+			},
+		},
+		{
+			name:       "Source from target subnet",
+			targetAddr: subnetTargetAddr,
+			localAddr:  subnetAddr,
+			wantLocalAddr: map[arp.AnnouncePolicy]tcpip.Address{
+				arp.AnnounceAnyLocalAddress:    subnetAddr,
+				arp.AnnounceSubnetLocalAddress: subnetAddr,
+				arp.AnnounceBestLocalAddress:   subnetAddr,
+			},
+		},
+		{
+			name:       "Target outside all subnets",
+			targetAddr: offLinkAddr,
+			localAddr:  subnetAddr,
+			wantLocalAddr: map[arp.AnnouncePolicy]tcpip.Address{
+				arp.AnnounceAnyLocalAddress:    subnetAddr,
+				arp.AnnounceSubnetLocalAddress: stackAddr,
+				arp.AnnounceBestLocalAddress:   stackAddr,
+			},
+		},
+		{
+			name:       "Unspecified source",
+			targetAddr: subnetTargetAddr,
+			wantLocalAddr: map[arp.AnnouncePolicy]tcpip.Address{
+				arp.AnnounceAnyLocalAddress:    stackAddr,
+				arp.AnnounceSubnetLocalAddress: subnetAddr,
+				arp.AnnounceBestLocalAddress:   subnetAddr,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		for policy, wantLocalAddr := range test.wantLocalAddr {
+			t.Run(fmt.Sprintf("%s/Policy=%d", test.name, policy), func(t *testing.T) {
+				s := stack.New(stack.Options{
+					NetworkProtocols: []stack.NetworkProtocolFactory{arp.NewProtocol, ipv4.NewProtocol},
+				})
+				linkEP := channel.New(defaultChannelSize, defaultMTU, stackLinkAddr)
+				if err := s.CreateNIC(nicID, linkEP); err != nil {
+					t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+				}
+				for _, addr := range []tcpip.Address{stackAddr, subnetAddr} {
+					protocolAddr := tcpip.ProtocolAddress{
+						Protocol:          ipv4.ProtocolNumber,
+						AddressWithPrefix: tcpip.AddressWithPrefix{Address: addr, PrefixLen: 24},
+					}
+					if err := s.AddProtocolAddress(nicID, protocolAddr); err != nil {
+						t.Fatalf("s.AddProtocolAddress(%d, %#v): %s", nicID, protocolAddr, err)
+					}
+				}
+
+				netEP, err := s.GetNetworkEndpoint(nicID, arp.ProtocolNumber)
+				if err != nil {
+					t.Fatalf("s.GetNetworkEndpoint(%d, %d): %s", nicID, arp.ProtocolNumber, err)
+				}
+				policyEP, ok := netEP.(arp.PolicyEndpoint)
+				if !ok {
+					t.Fatalf("expected %T to implement arp.PolicyEndpoint", netEP)
+				}
+				policyEP.SetPolicies(arp.Policies{Announce: policy})
+				if got, want := policyEP.Policies(), (arp.Policies{Announce: policy}); got != want {
+					t.Errorf("got policyEP.Policies() = %#v, want = %#v", got, want)
+				}
+
+				linkRes := s.NetworkProtocolInstance(arp.ProtocolNumber).(stack.LinkAddressResolver)
+				if err := linkRes.LinkAddressRequest(test.targetAddr, test.localAddr, "", &testInterface{LinkEndpoint: linkEP, nicID: nicID}); err != nil {
+					t.Fatalf("linkRes.LinkAddressRequest(%s, %s, '', _): %s", test.targetAddr, test.localAddr, err)
+				}
+				pkt, ok := linkEP.Read()
+				if !ok {
+					t.Fatal("expected to send a link address request")
+				}
+				req := header.ARP(stack.PayloadSince(pkt.Pkt.NetworkHeader()))
+				if got := tcpip.Address(req.ProtocolAddressSender()); got != wantLocalAddr {
+					t.Errorf("got req.ProtocolAddressSender() = %s, want = %s", got, wantLocalAddr)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package arp
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// AnnouncePolicy determines the sender IP address of the ARP requests an
+// endpoint sends to resolve link addresses, similar to Linux's arp_announce.
+type AnnouncePolicy int
+
+const (
+	// AnnounceAnyLocalAddress uses the source address of the packet that
+	// triggered resolution, equivalent to arp_announce=0. This is the default.
+	AnnounceAnyLocalAddress AnnouncePolicy = iota
+
+	// AnnounceSubnetLocalAddress uses the source address of the packet that
+	// triggered resolution if it belongs to a subnet of the NIC that holds the
+	// target address, and behaves like AnnounceBestLocalAddress otherwise,
+	// equivalent to arp_announce=1.
+	AnnounceSubnetLocalAddress
+
+	// AnnounceBestLocalAddress uses a primary address of the NIC whose subnet
+	// holds the target address, or the NIC's main address if there is none,
+	// equivalent to arp_announce=2.
+	AnnounceBestLocalAddress
+)
+
+// IgnorePolicy determines which ARP requests an endpoint answers, similar to
+// Linux's arp_ignore.
+type IgnorePolicy int
+
+const (
+	// ReplyForInterfaceAddress answers requests for addresses assigned to the
+	// receiving NIC, equivalent to arp_ignore=1. This is the default.
+	ReplyForInterfaceAddress IgnorePolicy = iota
+
+	// ReplyForAnyLocalAddress answers requests for addresses assigned to any
+	// NIC, equivalent to arp_ignore=0.
+	ReplyForAnyLocalAddress
+
+	// ReplyForInterfaceSubnet answers requests for addresses assigned to the
+	// receiving NIC when the sender address is part of the target address'
+	// subnet, equivalent to arp_ignore=2. ARP Probes are always answered so
+	// that address conflicts can be detected.
+	ReplyForInterfaceSubnet
+
+	// ReplyNever does not answer any request, equivalent to arp_ignore=8.
+	ReplyNever
+)
+
+// Policies are the policies an ARP endpoint applies to the requests it sends
+// and receives.
+type Policies struct {
+	// Announce determines the sender IP address of requests sent to resolve
+	// link addresses.
+	Announce AnnouncePolicy
+
+	// Ignore determines which received requests are answered.
+	Ignore IgnorePolicy
+}
+
+// PolicyEndpoint is an ARP endpoint that applies Policies.
+type PolicyEndpoint interface {
+	// SetPolicies sets the endpoint's policies.
+	SetPolicies(Policies)
+
+	// Policies returns the endpoint's policies.
+	Policies() Policies
+}
+
+var _ PolicyEndpoint = (*endpoint)(nil)
+
+// SetPolicies implements PolicyEndpoint.
+func (e *endpoint) SetPolicies(p Policies) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.mu.policies = p
+}
+
+// Policies implements PolicyEndpoint.
+func (e *endpoint) Policies() Policies {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.mu.policies
+}
+
+// shouldReply returns true if the ARP request h should be answered, as per the
+// endpoint's IgnorePolicy.
+func (e *endpoint) shouldReply(h header.ARP) bool {
+	targetAddr := tcpip.Address(h.ProtocolAddressTarget())
+	nicID := e.nic.ID()
+
+	switch e.Policies().Ignore {
+	case ReplyForAnyLocalAddress:
+		return e.protocol.stack.CheckLocalAddress(0 /* nicID */, header.IPv4ProtocolNumber, targetAddr) != 0
+	case ReplyForInterfaceSubnet:
+		if e.protocol.stack.CheckLocalAddress(nicID, header.IPv4ProtocolNumber, targetAddr) == 0 {
+			return false
+		}
+		senderAddr := tcpip.Address(h.ProtocolAddressSender())
+		if senderAddr == header.IPv4Any {
+			return true
+		}
+		for _, addr := range e.addresses(false /* primaryOnly */) {
+			if addr.Address != targetAddr {
+				continue
+			}
+			subnet := addr.Subnet()
+			return subnet.Contains(senderAddr)
+		}
+		return false
+	case ReplyNever:
+		return false
+	default:
+		return e.protocol.stack.CheckLocalAddress(nicID, header.IPv4ProtocolNumber, targetAddr) != 0
+	}
+}
+
+// requestSourceAddress returns the sender IP address for a request resolving
+// targetAddr, as per the endpoint's AnnouncePolicy.
+//
+// localAddr is the source address of the packet that triggered resolution, if
+// any. An empty address is returned if the NIC's main address should be used.
+func (e *endpoint) requestSourceAddress(targetAddr, localAddr tcpip.Address) tcpip.Address {
+	policy := e.Policies().Announce
+	if policy == AnnounceAnyLocalAddress {
+		return localAddr
+	}
+
+	addrs := e.addresses(true /* primaryOnly */)
+	if policy == AnnounceSubnetLocalAddress {
+		for _, addr := range addrs {
+			subnet := addr.Subnet()
+			if subnet.Contains(targetAddr) && subnet.Contains(localAddr) {
+				return localAddr
+			}
+		}
+	}
+	for _, addr := range addrs {
+		subnet := addr.Subnet()
+		if subnet.Contains(targetAddr) {
+			return addr.Address
+		}
+	}
+	return ""
+}
+
+// addresses returns the IPv4 addresses assigned to the endpoint's NIC.
+//
+// Only addresses that may be used as source addresses are returned if
+// primaryOnly is true.
+func (e *endpoint) addresses(primaryOnly bool) []tcpip.AddressWithPrefix {
+	ep, err := e.protocol.stack.GetNetworkEndpoint(e.nic.ID(), header.IPv4ProtocolNumber)
+	if err != nil {
+		return nil
+	}
+	addressableEP, ok := ep.(stack.AddressableEndpoint)
+	if !ok {
+		return nil
+	}
+	if primaryOnly {
+		return addressableEP.PrimaryAddresses()
+	}
+	return addressableEP.PermanentAddresses()
+}
@@ -53,8 +53,67 @@ var (
 	// ErrFragmentOverlap indicates that, during reassembly, a fragment overlaps
 	// with another one.
 	ErrFragmentOverlap = errors.New("overlapping fragments")
+
+	// ErrSourceLimitReached indicates that a fragment was dropped as too many
+	// packets from its source are already being reassembled.
+	ErrSourceLimitReached = errors.New("too many packets being reassembled from source")
 )
 
+// Limits are the resource limits of a Fragmentation.
+type Limits struct {
+	// HighMemoryLimit is the limit on the memory consumed by the fragments
+	// being reassembled, equivalent to Linux's net.ipv4.ipfrag_high_thresh.
+	// The oldest reassemblies are discarded when the limit is exceeded.
+	HighMemoryLimit int
+
+	// LowMemoryLimit is the memory consumption reassemblies are discarded down
+	// to once HighMemoryLimit is exceeded, equivalent to Linux's
+	// net.ipv4.ipfrag_low_thresh.
+	LowMemoryLimit int
+
+	// Timeout is the maximum time allowed to reassemble a packet, equivalent
+	// to Linux's net.ipv4.ipfrag_time.
+	Timeout time.Duration
+
+	// MaxReassembliesPerSource is the maximum number of packets from a single
+	// source address that may be reassembled concurrently. Fragments that
+	// would start a new reassembly past the limit are dropped.
+	//
+	// A limit of 0 is not enforced.
+	MaxReassembliesPerSource int
+}
+
+// Stats holds statistics about the reassembly of fragments.
+type Stats struct {
+	// FragmentsReceived is the number of fragments processed.
+	FragmentsReceived tcpip.StatCounter
+
+	// PacketsReassembled is the number of packets successfully reassembled.
+	PacketsReassembled tcpip.StatCounter
+
+	// ReassemblyErrors is the number of reassemblies abandoned because of an
+	// invalid or overlapping fragment.
+	ReassemblyErrors tcpip.StatCounter
+
+	// ReassemblyTimeouts is the number of reassemblies that timed out.
+	ReassemblyTimeouts tcpip.StatCounter
+
+	// MemoryLimitDrops is the number of reassemblies discarded to bring the
+	// memory consumed by fragments under the memory limits.
+	MemoryLimitDrops tcpip.StatCounter
+
+	// SourceLimitDrops is the number of fragments dropped because too many
+	// packets from their source were being reassembled.
+	SourceLimitDrops tcpip.StatCounter
+}
+
+// ReassemblyStatsEndpoint is a network endpoint that keeps statistics about
+// the reassembly of the fragments it receives.
+type ReassemblyStatsEndpoint interface {
+	// ReassemblyStats returns the endpoint's reassembly statistics.
+	ReassemblyStats() *Stats
+}
+
 // FragmentID is the identifier for a fragment.
 type FragmentID struct {
 	// Source is the source address of the fragment.
@@ -78,6 +137,7 @@ type Fragmentation struct {
 	mu             sync.Mutex
 	highLimit      int
 	lowLimit       int
+	maxPerSource   int
 	reassemblers   map[FragmentID]*reassembler
 	rList          reassemblerList
 	size           int
@@ -86,6 +146,9 @@ type Fragmentation struct {
 	clock          tcpip.Clock
 	releaseJob     *tcpip.Job
 	timeoutHandler TimeoutHandler
+
+	// sources holds the number of reassemblers of each source address.
+	sources map[tcpip.Address]int
 }
 
 // TimeoutHandler is consulted if a packet reassembly has timed out.
@@ -111,32 +174,77 @@ type TimeoutHandler interface {
 // Fragments are lazily evicted only when a new a packet with an
 // already existing fragmentation-id arrives after the timeout.
 func NewFragmentation(blockSize uint16, highMemoryLimit, lowMemoryLimit int, reassemblingTimeout time.Duration, clock tcpip.Clock, timeoutHandler TimeoutHandler) *Fragmentation {
-	if lowMemoryLimit >= highMemoryLimit {
-		lowMemoryLimit = highMemoryLimit
-	}
-
-	if lowMemoryLimit < 0 {
-		lowMemoryLimit = 0
-	}
-
 	if blockSize < minBlockSize {
 		blockSize = minBlockSize
 	}
 
 	f := &Fragmentation{
 		reassemblers:   make(map[FragmentID]*reassembler),
-		highLimit:      highMemoryLimit,
-		lowLimit:       lowMemoryLimit,
-		timeout:        reassemblingTimeout,
+		sources:        make(map[tcpip.Address]int),
 		blockSize:      blockSize,
 		clock:          clock,
 		timeoutHandler: timeoutHandler,
 	}
+	f.setLimitsLocked(Limits{
+		HighMemoryLimit: highMemoryLimit,
+		LowMemoryLimit:  lowMemoryLimit,
+		Timeout:         reassemblingTimeout,
+	})
 	f.releaseJob = tcpip.NewJob(f.clock, &f.mu, f.releaseReassemblersLocked)
 
 	return f
 }
 
+// SetLimits replaces the resource limits of f.
+//
+// Reassemblies are discarded immediately if they exceed the new limits.
+func (f *Fragmentation) SetLimits(limits Limits) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.setLimitsLocked(limits)
+	f.evictLocked()
+	if !f.rList.Empty() {
+		// The oldest reassembler may have expired with the new timeout.
+		f.releaseJob.Cancel()
+		f.releaseReassemblersLocked()
+	}
+}
+
+// setLimitsLocked replaces the resource limits of f.
+//
+// Precondition: f.mu must be locked if f is in use.
+func (f *Fragmentation) setLimitsLocked(limits Limits) {
+	if limits.LowMemoryLimit >= limits.HighMemoryLimit {
+		limits.LowMemoryLimit = limits.HighMemoryLimit
+	}
+
+	if limits.LowMemoryLimit < 0 {
+		limits.LowMemoryLimit = 0
+	}
+
+	if limits.MaxReassembliesPerSource < 0 {
+		limits.MaxReassembliesPerSource = 0
+	}
+
+	f.highLimit = limits.HighMemoryLimit
+	f.lowLimit = limits.LowMemoryLimit
+	f.timeout = limits.Timeout
+	f.maxPerSource = limits.MaxReassembliesPerSource
+}
+
+// Limits returns the resource limits of f.
+func (f *Fragmentation) Limits() Limits {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return Limits{
+		HighMemoryLimit:          f.highLimit,
+		LowMemoryLimit:           f.lowLimit,
+		Timeout:                  f.timeout,
+		MaxReassembliesPerSource: f.maxPerSource,
+	}
+}
+
 // Process processes an incoming fragment belonging to an ID and returns a
 // complete packet and its protocol number when all the packets belonging to
 // that ID have been received.
@@ -150,9 +258,15 @@ func NewFragmentation(blockSize uint16, highMemoryLimit, lowMemoryLimit int, rea
 // proto is the protocol number marked in the fragment being processed. It has
 // to be given here outside of the FragmentID struct because IPv6 should not use
 // the protocol to identify a fragment.
+//
+// stats holds the statistics of the interface the fragment was received on.
+// Events affecting the reassembly of the packet are accounted to the interface
+// its first received fragment arrived on.
 func (f *Fragmentation) Process(
-	id FragmentID, first, last uint16, more bool, proto uint8, pkt *stack.PacketBuffer) (
+	id FragmentID, first, last uint16, more bool, proto uint8, pkt *stack.PacketBuffer, stats *Stats) (
 	buffer.VectorisedView, uint8, bool, error) {
+	stats.FragmentsReceived.Increment()
+
 	if first > last {
 		return buffer.VectorisedView{}, 0, false, fmt.Errorf("first=%d is greater than last=%d: %w", first, last, ErrInvalidArgs)
 	}
@@ -173,8 +287,15 @@ func (f *Fragmentation) Process(
 	f.mu.Lock()
 	r, ok := f.reassemblers[id]
 	if !ok {
+		if f.maxPerSource > 0 && f.sources[id.Source] >= f.maxPerSource {
+			f.mu.Unlock()
+			stats.SourceLimitDrops.Increment()
+			return buffer.VectorisedView{}, 0, false, ErrSourceLimitReached
+		}
 		r = newReassembler(id, f.clock)
+		r.stats = stats
 		f.reassemblers[id] = r
+		f.sources[id.Source]++
 		wasEmpty := f.rList.Empty()
 		f.rList.PushFront(r)
 		if wasEmpty {
@@ -191,7 +312,9 @@ func (f *Fragmentation) Process(
 		// We probably got an invalid sequence of fragments. Just
 		// discard the reassembler and move on.
 		f.mu.Lock()
-		f.release(r, false /* timedOut */)
+		if f.release(r, false /* timedOut */) {
+			r.stats.ReassemblyErrors.Increment()
+		}
 		f.mu.Unlock()
 		return buffer.VectorisedView{}, 0, false, fmt.Errorf("fragmentation processing error: %w", err)
 	}
@@ -199,30 +322,46 @@ func (f *Fragmentation) Process(
 	f.size += consumed
 	if done {
 		f.release(r, false /* timedOut */)
+		r.stats.PacketsReassembled.Increment()
 	}
-	// Evict reassemblers if we are consuming more memory than highLimit until
-	// we reach lowLimit.
-	if f.size > f.highLimit {
-		for f.size > f.lowLimit {
-			tail := f.rList.Back()
-			if tail == nil {
-				break
-			}
-			f.release(tail, false /* timedOut */)
-		}
-	}
+	f.evictLocked()
 	f.mu.Unlock()
 	return res, firstFragmentProto, done, nil
 }
 
-func (f *Fragmentation) release(r *reassembler, timedOut bool) {
+// evictLocked evicts reassemblers if we are consuming more memory than
+// highLimit until we reach lowLimit.
+//
+// Precondition: f.mu must be locked.
+func (f *Fragmentation) evictLocked() {
+	if f.size <= f.highLimit {
+		return
+	}
+	for f.size > f.lowLimit {
+		tail := f.rList.Back()
+		if tail == nil {
+			break
+		}
+		if f.release(tail, false /* timedOut */) {
+			tail.stats.MemoryLimitDrops.Increment()
+		}
+	}
+}
+
+// release releases r, returning false if r was already released.
+func (f *Fragmentation) release(r *reassembler, timedOut bool) bool {
 	// Before releasing a fragment we need to check if r is already marked as done.
 	// Otherwise, we would delete it twice.
 	if r.checkDoneOrMark() {
-		return
+		return false
 	}
 
 	delete(f.reassemblers, r.id)
+	if n := f.sources[r.id.Source] - 1; n > 0 {
+		f.sources[r.id.Source] = n
+	} else {
+		delete(f.sources, r.id.Source)
+	}
 	f.rList.Remove(r)
 	f.size -= r.size
 	if f.size < 0 {
@@ -230,9 +369,13 @@ func (f *Fragmentation) release(r *reassembler, timedOut bool) {
 		f.size = 0
 	}
 
-	if h := f.timeoutHandler; timedOut && h != nil {
-		h.OnReassemblyTimeout(r.pkt)
+	if timedOut {
+		r.stats.ReassemblyTimeouts.Increment()
+		if h := f.timeoutHandler; h != nil {
+			h.OnReassemblyTimeout(r.pkt)
+		}
 	}
+	return true
 }
 
 // releaseReassemblersLocked releases already-expired reassemblers, then
@@ -21,6 +21,7 @@ import (
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip/faketime"
 	"gvisor.dev/gvisor/pkg/tcpip/network/testutil"
@@ -112,7 +113,7 @@ func TestFragmentationProcess(t *testing.T) {
 			f := NewFragmentation(minBlockSize, 1024, 512, reassembleTimeout, &faketime.NullClock{}, nil)
 			firstFragmentProto := c.in[0].proto
 			for i, in := range c.in {
-				vv, proto, done, err := f.Process(in.id, in.first, in.last, in.more, in.proto, in.pkt)
+				vv, proto, done, err := f.Process(in.id, in.first, in.last, in.more, in.proto, in.pkt, &Stats{})
 				if err != nil {
 					t.Fatalf("f.Process(%+v, %d, %d, %t, %d, %#v) failed: %s",
 						in.id, in.first, in.last, in.more, in.proto, in.pkt, err)
@@ -247,7 +248,7 @@ func TestReassemblingTimeout(t *testing.T) {
 			for _, event := range test.events {
 				clock.Advance(event.clockAdvance)
 				if frag := event.fragment; frag != nil {
-					_, _, done, err := f.Process(FragmentID{}, frag.first, frag.last, frag.more, protocol, pkt(len(frag.data), frag.data))
+					_, _, done, err := f.Process(FragmentID{}, frag.first, frag.last, frag.more, protocol, pkt(len(frag.data), frag.data), &Stats{})
 					if err != nil {
 						t.Fatalf("%s: f.Process failed: %s", event.name, err)
 					}
@@ -266,15 +267,15 @@ func TestReassemblingTimeout(t *testing.T) {
 func TestMemoryLimits(t *testing.T) {
 	f := NewFragmentation(minBlockSize, 3, 1, reassembleTimeout, &faketime.NullClock{}, nil)
 	// Send first fragment with id = 0.
-	f.Process(FragmentID{ID: 0}, 0, 0, true, 0xFF, pkt(1, "0"))
+	f.Process(FragmentID{ID: 0}, 0, 0, true, 0xFF, pkt(1, "0"), &Stats{})
 	// Send first fragment with id = 1.
-	f.Process(FragmentID{ID: 1}, 0, 0, true, 0xFF, pkt(1, "1"))
+	f.Process(FragmentID{ID: 1}, 0, 0, true, 0xFF, pkt(1, "1"), &Stats{})
 	// Send first fragment with id = 2.
-	f.Process(FragmentID{ID: 2}, 0, 0, true, 0xFF, pkt(1, "2"))
+	f.Process(FragmentID{ID: 2}, 0, 0, true, 0xFF, pkt(1, "2"), &Stats{})
 
 	// Send first fragment with id = 3. This should caused id = 0 and id = 1 to be
 	// evicted.
-	f.Process(FragmentID{ID: 3}, 0, 0, true, 0xFF, pkt(1, "3"))
+	f.Process(FragmentID{ID: 3}, 0, 0, true, 0xFF, pkt(1, "3"), &Stats{})
 
 	if _, ok := f.reassemblers[FragmentID{ID: 0}]; ok {
 		t.Errorf("Memory limits are not respected: id=0 has not been evicted.")
@@ -290,9 +291,9 @@ func TestMemoryLimits(t *testing.T) {
 func TestMemoryLimitsIgnoresDuplicates(t *testing.T) {
 	f := NewFragmentation(minBlockSize, 1, 0, reassembleTimeout, &faketime.NullClock{}, nil)
 	// Send first fragment with id = 0.
-	f.Process(FragmentID{}, 0, 0, true, 0xFF, pkt(1, "0"))
+	f.Process(FragmentID{}, 0, 0, true, 0xFF, pkt(1, "0"), &Stats{})
 	// Send the same packet again.
-	f.Process(FragmentID{}, 0, 0, true, 0xFF, pkt(1, "0"))
+	f.Process(FragmentID{}, 0, 0, true, 0xFF, pkt(1, "0"), &Stats{})
 
 	got := f.size
 	want := 1
@@ -301,6 +302,100 @@ func TestMemoryLimitsIgnoresDuplicates(t *testing.T) {
 	}
 }
 
+func TestSourceLimits(t *testing.T) {
+	const (
+		src1 = tcpip.Address("\x0a\x00\x00\x01")
+		src2 = tcpip.Address("\x0a\x00\x00\x02")
+	)
+
+	f := NewFragmentation(minBlockSize, HighFragThreshold, LowFragThreshold, reassembleTimeout, &faketime.NullClock{}, nil)
+	f.SetLimits(Limits{
+		HighMemoryLimit:          HighFragThreshold,
+		LowMemoryLimit:           LowFragThreshold,
+		Timeout:                  reassembleTimeout,
+		MaxReassembliesPerSource: 2,
+	})
+	var stats Stats
+
+	for id := uint32(0); id < 2; id++ {
+		if _, _, _, err := f.Process(FragmentID{Source: src1, ID: id}, 0, 0, true, 0xFF, pkt(1, "0"), &stats); err != nil {
+			t.Fatalf("f.Process(id=%d) from %s: %s", id, src1, err)
+		}
+	}
+	// A third reassembly from the same source is rejected, but fragments of
+	// ongoing reassemblies and reassemblies from other sources are accepted.
+	if _, _, _, err := f.Process(FragmentID{Source: src1, ID: 2}, 0, 0, true, 0xFF, pkt(1, "0"), &stats); !errors.Is(err, ErrSourceLimitReached) {
+		t.Errorf("got f.Process(id=2) from %s = %v, want = %s", src1, err, ErrSourceLimitReached)
+	}
+	if _, _, done, err := f.Process(FragmentID{Source: src1, ID: 0}, 1, 1, false, 0xFF, pkt(1, "1"), &stats); err != nil || !done {
+		t.Errorf("got f.Process(id=0) from %s = (_, _, %t, %v), want = (_, _, true, nil)", src1, done, err)
+	}
+	if _, _, _, err := f.Process(FragmentID{Source: src2, ID: 0}, 0, 0, true, 0xFF, pkt(1, "0"), &stats); err != nil {
+		t.Errorf("f.Process(id=0) from %s: %s", src2, err)
+	}
+
+	// The completed reassembly no longer counts towards the limit.
+	if _, _, _, err := f.Process(FragmentID{Source: src1, ID: 2}, 0, 0, true, 0xFF, pkt(1, "0"), &stats); err != nil {
+		t.Errorf("f.Process(id=2) from %s: %s", src1, err)
+	}
+
+	if got := stats.SourceLimitDrops.Value(); got != 1 {
+		t.Errorf("got stats.SourceLimitDrops.Value() = %d, want = 1", got)
+	}
+	if got := stats.PacketsReassembled.Value(); got != 1 {
+		t.Errorf("got stats.PacketsReassembled.Value() = %d, want = 1", got)
+	}
+	if got := stats.FragmentsReceived.Value(); got != 6 {
+		t.Errorf("got stats.FragmentsReceived.Value() = %d, want = 6", got)
+	}
+}
+
+func TestSetLimits(t *testing.T) {
+	const timeout = time.Second
+
+	clock := faketime.NewManualClock()
+	f := NewFragmentation(minBlockSize, HighFragThreshold, LowFragThreshold, 2*timeout, clock, nil)
+	var stats1, stats2 Stats
+
+	f.Process(FragmentID{ID: 0}, 0, 0, true, 0xFF, pkt(1, "0"), &stats1)
+	f.Process(FragmentID{ID: 1}, 0, 1, true, 0xFF, pkt(2, "01"), &stats2)
+	f.Process(FragmentID{ID: 2}, 0, 1, true, 0xFF, pkt(2, "01"), &stats2)
+
+	// Lowering the memory limits evicts the oldest reassemblies.
+	f.SetLimits(Limits{
+		HighMemoryLimit: 4,
+		LowMemoryLimit:  2,
+		Timeout:         timeout,
+	})
+	if got := f.size; got != 2 {
+		t.Errorf("got f.size = %d, want = 2", got)
+	}
+	if got := stats1.MemoryLimitDrops.Value(); got != 1 {
+		t.Errorf("got stats1.MemoryLimitDrops.Value() = %d, want = 1", got)
+	}
+	if got := stats2.MemoryLimitDrops.Value(); got != 1 {
+		t.Errorf("got stats2.MemoryLimitDrops.Value() = %d, want = 1", got)
+	}
+
+	// The remaining reassembly times out with the new timeout.
+	clock.Advance(timeout)
+	if got := len(f.reassemblers); got != 0 {
+		t.Errorf("got len(f.reassemblers) = %d, want = 0", got)
+	}
+	if got := stats2.ReassemblyTimeouts.Value(); got != 1 {
+		t.Errorf("got stats2.ReassemblyTimeouts.Value() = %d, want = 1", got)
+	}
+
+	want := Limits{
+		HighMemoryLimit: 4,
+		LowMemoryLimit:  2,
+		Timeout:         timeout,
+	}
+	if got := f.Limits(); got != want {
+		t.Errorf("got f.Limits() = %+v, want = %+v", got, want)
+	}
+}
+
 func TestErrors(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -385,7 +480,7 @@ func TestErrors(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			f := NewFragmentation(test.blockSize, HighFragThreshold, LowFragThreshold, reassembleTimeout, &faketime.NullClock{}, nil)
-			_, _, done, err := f.Process(FragmentID{}, test.first, test.last, test.more, 0, pkt(len(test.data), test.data))
+			_, _, done, err := f.Process(FragmentID{}, test.first, test.last, test.more, 0, pkt(len(test.data), test.data), &Stats{})
 			if !errors.Is(err, test.err) {
 				t.Errorf("got Process(_, %d, %d, %t, _, %q) = (_, _, _, %v), want = (_, _, _, %v)", test.first, test.last, test.more, test.data, err, test.err)
 			}
@@ -604,7 +699,7 @@ func TestTimeoutHandler(t *testing.T) {
 			f := NewFragmentation(minBlockSize, HighFragThreshold, LowFragThreshold, reassembleTimeout, &faketime.NullClock{}, handler)
 
 			for _, p := range test.params {
-				if _, _, _, err := f.Process(id, p.first, p.last, p.more, proto, p.pkt); err != nil && !test.wantError {
+				if _, _, _, err := f.Process(id, p.first, p.last, p.more, proto, p.pkt, &Stats{}); err != nil && !test.wantError {
 					t.Errorf("f.Process error = %s", err)
 				}
 			}
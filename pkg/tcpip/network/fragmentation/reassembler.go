@@ -43,6 +43,10 @@ type reassembler struct {
 	done         bool
 	creationTime int64
 	pkt          *stack.PacketBuffer
+
+	// stats holds the statistics of the interface the first fragment of the
+	// packet was received on.
+	stats *Stats
 }
 
 func newReassembler(id FragmentID, clock tcpip.Clock) *reassembler {
@@ -0,0 +1,190 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"fmt"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+type dadState struct {
+	job  *tcpip.Job
+	done *bool
+
+	completionHandlers []stack.DADCompletionHandler
+}
+
+// DADProtocol is a protocol whose core state machine can be represented by DAD.
+type DADProtocol interface {
+	// SendDADMessage attempts to send a DAD probe message.
+	SendDADMessage(tcpip.Address) *tcpip.Error
+}
+
+// DADOptions holds options for DAD.
+type DADOptions struct {
+	Clock    tcpip.Clock
+	Protocol DADProtocol
+	NICID    tcpip.NICID
+}
+
+// DAD performs duplicate address detection for addresses.
+//
+// DAD is protocol agnostic; the protocol specific work of sending probes and
+// detecting conflicts from received messages is performed by the protocol that
+// uses DAD.
+type DAD struct {
+	opts    DADOptions
+	configs stack.DADConfigurations
+
+	protocolMU sync.Locker
+	addresses  map[tcpip.Address]dadState
+}
+
+// Init initializes the DAD state.
+//
+// Must only be called once for the lifetime of d; Init will panic if it is
+// called twice.
+//
+// The lock will only be taken when timers fire.
+func (d *DAD) Init(protocolMU sync.Locker, configs stack.DADConfigurations, opts DADOptions) {
+	if d.addresses != nil {
+		panic("attempted to initialize DAD state twice")
+	}
+
+	configs.Validate()
+
+	*d = DAD{
+		opts:       opts,
+		configs:    configs,
+		protocolMU: protocolMU,
+		addresses:  make(map[tcpip.Address]dadState),
+	}
+}
+
+// CheckDuplicateAddressLocked performs DAD for an address, calling the
+// completion handler once DAD resolves.
+//
+// If DAD is already performing for the provided address, h will be called when
+// the currently running process completes.
+//
+// Precondition: d.protocolMU must be locked.
+func (d *DAD) CheckDuplicateAddressLocked(addr tcpip.Address, h stack.DADCompletionHandler) stack.DADCheckAddressDisposition {
+	if d.configs.DupAddrDetectTransmits == 0 {
+		return stack.DADDisabled
+	}
+
+	ret := stack.DADAlreadyRunning
+	s, ok := d.addresses[addr]
+	if !ok {
+		ret = stack.DADStarting
+
+		remaining := d.configs.DupAddrDetectTransmits
+
+		// Protected by d.protocolMU.
+		done := false
+
+		s = dadState{
+			done: &done,
+			job: tcpip.NewJob(d.opts.Clock, d.protocolMU, func() {
+				if done {
+					return
+				}
+
+				s, ok := d.addresses[addr]
+				if !ok {
+					panic(fmt.Sprintf("DAD timer fired but missing state for %s on NIC(%d)", addr, d.opts.NICID))
+				}
+
+				dadDone := remaining == 0
+
+				var err *tcpip.Error
+				if !dadDone {
+					err = d.opts.Protocol.SendDADMessage(addr)
+				}
+
+				if !dadDone && err == nil {
+					remaining--
+					s.job.Schedule(d.configs.RetransmitTimer)
+					return
+				}
+
+				// At this point we know that either DAD has resolved or we hit an error
+				// sending the last DAD message. Either way, clear the DAD state.
+				done = true
+				delete(d.addresses, addr)
+
+				var res stack.DADResult = &stack.DADSucceeded{}
+				if err != nil {
+					res = &stack.DADError{Err: err}
+				}
+				for _, h := range s.completionHandlers {
+					h(res)
+				}
+			}),
+		}
+
+		// We initially start a timer to fire immediately because some of the DAD
+		// work cannot be done while holding the protocol's lock. This is
+		// effectively the same as starting a goroutine but we use a timer that
+		// fires immediately so we can reset it for the next DAD iteration.
+		s.job.Schedule(0)
+	}
+
+	s.completionHandlers = append(s.completionHandlers, h)
+	d.addresses[addr] = s
+	return ret
+}
+
+// IsRunningLocked returns true if DAD is being performed for addr.
+//
+// Precondition: d.protocolMU must be locked.
+func (d *DAD) IsRunningLocked(addr tcpip.Address) bool {
+	_, ok := d.addresses[addr]
+	return ok
+}
+
+// StopLocked stops a currently running DAD process, calling the completion
+// handlers registered for addr with the provided result.
+//
+// Precondition: d.protocolMU must be locked.
+func (d *DAD) StopLocked(addr tcpip.Address, res stack.DADResult) {
+	s, ok := d.addresses[addr]
+	if !ok {
+		return
+	}
+
+	*s.done = true
+	s.job.Cancel()
+	delete(d.addresses, addr)
+
+	if res == nil {
+		panic(fmt.Sprintf("DAD for %s on NIC(%d) stopped without a result", addr, d.opts.NICID))
+	}
+
+	for _, h := range s.completionHandlers {
+		h(res)
+	}
+}
+
+// SetConfigsLocked sets the DAD configurations.
+//
+// Precondition: d.protocolMU must be locked.
+func (d *DAD) SetConfigsLocked(c stack.DADConfigurations) {
+	c.Validate()
+	d.configs = c
+}
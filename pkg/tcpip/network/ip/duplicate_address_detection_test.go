@@ -0,0 +1,203 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+var _ ip.DADProtocol = (*mockDADProtocol)(nil)
+
+type mockDADProtocol struct {
+	mu struct {
+		sync.Mutex
+
+		dad       ip.DAD
+		sendCount map[tcpip.Address]int
+	}
+}
+
+func (m *mockDADProtocol) init(c stack.DADConfigurations, opts ip.DADOptions) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	opts.Protocol = m
+	m.mu.dad.Init(&m.mu, c, opts)
+	m.initLocked()
+}
+
+func (m *mockDADProtocol) initLocked() {
+	m.mu.sendCount = make(map[tcpip.Address]int)
+}
+
+// SendDADMessage implements ip.DADProtocol.
+//
+// Precondition: m.mu must be locked.
+func (m *mockDADProtocol) SendDADMessage(addr tcpip.Address) *tcpip.Error {
+	m.mu.sendCount[addr]++
+	return nil
+}
+
+func (m *mockDADProtocol) check(addrs []tcpip.Address) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sendCount := make(map[tcpip.Address]int)
+	for _, a := range addrs {
+		sendCount[a]++
+	}
+
+	diff := cmp.Diff(sendCount, m.mu.sendCount)
+	m.initLocked()
+	return diff
+}
+
+func (m *mockDADProtocol) checkDuplicateAddress(addr tcpip.Address, h stack.DADCompletionHandler) stack.DADCheckAddressDisposition {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mu.dad.CheckDuplicateAddressLocked(addr, h)
+}
+
+func (m *mockDADProtocol) stop(addr tcpip.Address, res stack.DADResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mu.dad.StopLocked(addr, res)
+}
+
+func (m *mockDADProtocol) setConfigs(c stack.DADConfigurations) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mu.dad.SetConfigsLocked(c)
+}
+
+const (
+	dadAddr1 = tcpip.Address("\x01")
+	dadAddr2 = tcpip.Address("\x02")
+)
+
+type dadResult struct {
+	Addr tcpip.Address
+	R    stack.DADResult
+}
+
+func handler(ch chan<- dadResult, a tcpip.Address) func(stack.DADResult) {
+	return func(r stack.DADResult) {
+		ch <- dadResult{Addr: a, R: r}
+	}
+}
+
+func TestDADCheckDuplicateAddress(t *testing.T) {
+	var dad mockDADProtocol
+	clock := faketime.NewManualClock()
+	dad.init(stack.DADConfigurations{}, ip.DADOptions{
+		Clock: clock,
+	})
+
+	ch := make(chan dadResult, 2)
+
+	// DAD should initially be disabled.
+	if res := dad.checkDuplicateAddress(dadAddr1, handler(nil, "")); res != stack.DADDisabled {
+		t.Errorf("got dad.checkDuplicateAddress(%s, _) = %d, want = %d", dadAddr1, res, stack.DADDisabled)
+	}
+	// Wait for any initially fired timers to complete.
+	clock.Advance(0)
+	if diff := dad.check(nil); diff != "" {
+		t.Errorf("dad check mismatch (-want +got):\n%s", diff)
+	}
+
+	// Enable and request DAD.
+	dadConfigs1 := stack.DADConfigurations{
+		DupAddrDetectTransmits: 1,
+		RetransmitTimer:        time.Second,
+	}
+	dad.setConfigs(dadConfigs1)
+	if res := dad.checkDuplicateAddress(dadAddr1, handler(ch, dadAddr1)); res != stack.DADStarting {
+		t.Errorf("got dad.checkDuplicateAddress(%s, _) = %d, want = %d", dadAddr1, res, stack.DADStarting)
+	}
+	clock.Advance(0)
+	if diff := dad.check([]tcpip.Address{dadAddr1}); diff != "" {
+		t.Errorf("dad check mismatch (-want +got):\n%s", diff)
+	}
+	// The second request for an address we are already performing DAD on should
+	// not send a new message.
+	if res := dad.checkDuplicateAddress(dadAddr1, handler(ch, dadAddr1)); res != stack.DADAlreadyRunning {
+		t.Errorf("got dad.checkDuplicateAddress(%s, _) = %d, want = %d", dadAddr1, res, stack.DADAlreadyRunning)
+	}
+	clock.Advance(0)
+	if diff := dad.check(nil); diff != "" {
+		t.Errorf("dad check mismatch (-want +got):\n%s", diff)
+	}
+
+	// Update the configurations and perform DAD on a new address. DAD for the
+	// first address should continue with the old configurations.
+	dadConfigs2 := stack.DADConfigurations{
+		DupAddrDetectTransmits: 2,
+		RetransmitTimer:        2 * time.Second,
+	}
+	dad.setConfigs(dadConfigs2)
+	if res := dad.checkDuplicateAddress(dadAddr2, handler(ch, dadAddr2)); res != stack.DADStarting {
+		t.Errorf("got dad.checkDuplicateAddress(%s, _) = %d, want = %d", dadAddr2, res, stack.DADStarting)
+	}
+	clock.Advance(0)
+	if diff := dad.check([]tcpip.Address{dadAddr2}); diff != "" {
+		t.Errorf("dad check mismatch (-want +got):\n%s", diff)
+	}
+
+	clock.Advance(dadConfigs1.RetransmitTimer)
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-ch:
+			if diff := cmp.Diff(dadResult{Addr: dadAddr1, R: &stack.DADSucceeded{}}, r); diff != "" {
+				t.Errorf("result mismatch (-want +got):\n%s", diff)
+			}
+		default:
+			t.Fatalf("expected DAD result for %s", dadAddr1)
+		}
+	}
+	// DAD for the second address should not have sent another message since it
+	// uses a longer retransmit timer.
+	if diff := dad.check(nil); diff != "" {
+		t.Errorf("dad check mismatch (-want +got):\n%s", diff)
+	}
+
+	// Stop DAD for the second address before it resolves.
+	dad.stop(dadAddr2, &stack.DADAborted{})
+	select {
+	case r := <-ch:
+		if diff := cmp.Diff(dadResult{Addr: dadAddr2, R: &stack.DADAborted{}}, r); diff != "" {
+			t.Errorf("result mismatch (-want +got):\n%s", diff)
+		}
+	default:
+		t.Fatalf("expected DAD result for %s", dadAddr2)
+	}
+
+	clock.Advance(dadConfigs2.RetransmitTimer * time.Duration(dadConfigs2.DupAddrDetectTransmits))
+	select {
+	case r := <-ch:
+		t.Fatalf("unexpected DAD result = %#v", r)
+	default:
+	}
+	if diff := dad.check(nil); diff != "" {
+		t.Errorf("dad check mismatch (-want +got):\n%s", diff)
+	}
+}
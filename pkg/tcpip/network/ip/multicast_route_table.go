@@ -0,0 +1,305 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"sync/atomic"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	// DefaultMaxPendingQueueSize is the default maximum number of packets
+	// queued for a pending route.
+	DefaultMaxPendingQueueSize = 3
+
+	// DefaultPendingRouteExpiration is the default time after which a pending
+	// route and its queued packets are discarded if no route is installed.
+	DefaultPendingRouteExpiration = 10 * time.Second
+
+	// DefaultCleanupInterval is the default interval at which expired pending
+	// routes are discarded.
+	DefaultCleanupInterval = 10 * time.Second
+)
+
+// MulticastRouteTableConfig holds the configuration of a MulticastRouteTable.
+type MulticastRouteTableConfig struct {
+	// MaxPendingQueueSize is the maximum number of packets queued for a
+	// pending route. Must be greater than zero.
+	MaxPendingQueueSize int
+
+	// Clock is the clock used to expire pending routes and to record when
+	// installed routes are used.
+	Clock tcpip.Clock
+}
+
+// DefaultMulticastRouteTableConfig returns the default configuration of a
+// MulticastRouteTable that uses clock.
+func DefaultMulticastRouteTableConfig(clock tcpip.Clock) MulticastRouteTableConfig {
+	return MulticastRouteTableConfig{
+		MaxPendingQueueSize: DefaultMaxPendingQueueSize,
+		Clock:               clock,
+	}
+}
+
+// InstalledRoute is a multicast route that was installed in a
+// MulticastRouteTable.
+type InstalledRoute struct {
+	stack.MulticastRoute
+
+	// lastUsedTimestamp is the monotonic time, in nanoseconds, at which the
+	// route was last used.
+	//
+	// Must be accessed using atomic operations.
+	lastUsedTimestamp int64
+}
+
+// LastUsedTimestamp returns the monotonic time, in nanoseconds, at which the
+// route was last used.
+func (r *InstalledRoute) LastUsedTimestamp() int64 {
+	return atomic.LoadInt64(&r.lastUsedTimestamp)
+}
+
+// SetLastUsedTimestamp records that the route was used at the monotonic time
+// t.
+//
+// The timestamp is only updated if t is later than the current timestamp.
+func (r *InstalledRoute) SetLastUsedTimestamp(t int64) {
+	for {
+		prev := atomic.LoadInt64(&r.lastUsedTimestamp)
+		if t <= prev || atomic.CompareAndSwapInt64(&r.lastUsedTimestamp, prev, t) {
+			return
+		}
+	}
+}
+
+// pendingRoute holds the packets received for a (source, group) pair while
+// waiting for a route to be installed.
+type pendingRoute struct {
+	packets []*stack.PacketBuffer
+
+	// expiresAt is the monotonic time, in nanoseconds, at which the pending
+	// route is discarded.
+	expiresAt int64
+}
+
+// GetRouteResultState is the state of a GetRouteResult.
+type GetRouteResultState int
+
+const (
+	// InstalledRouteFound indicates that an installed route was found.
+	InstalledRouteFound GetRouteResultState = iota
+
+	// PacketQueuedInPendingRoute indicates that the packet was queued in an
+	// existing pending route.
+	PacketQueuedInPendingRoute
+
+	// NoRouteFoundAndPendingInserted indicates that no route was found and
+	// that a pending route was created for the packet. Integrators should be
+	// notified so that they may install a route.
+	NoRouteFoundAndPendingInserted
+
+	// PendingQueueFull indicates that no route was found and that the packet
+	// was dropped as the pending route's queue is full.
+	PendingQueueFull
+)
+
+// GetRouteResult is the result of a MulticastRouteTable.GetRouteOrInsertPending
+// lookup.
+type GetRouteResult struct {
+	// State is the state of the lookup.
+	State GetRouteResultState
+
+	// InstalledRoute is the route that was found. It is only set if State is
+	// InstalledRouteFound.
+	InstalledRoute *InstalledRoute
+}
+
+// MulticastRouteTable is a multicast routing table.
+//
+// Packets that do not match an installed route are queued in a pending route
+// until a route is installed or the pending route expires. The table is
+// protocol agnostic; the protocol specific work of forwarding packets is
+// performed by the protocol that uses the table.
+type MulticastRouteTable struct {
+	config MulticastRouteTableConfig
+
+	mu sync.RWMutex
+
+	// The following fields are protected by mu.
+	installedRoutes map[stack.UnicastSourceAndMulticastDestination]*InstalledRoute
+	pendingRoutes   map[stack.UnicastSourceAndMulticastDestination]pendingRoute
+
+	// cleanupTimer discards expired pending routes. It is nil when there are
+	// no pending routes.
+	cleanupTimer tcpip.Timer
+}
+
+// Init initializes the table with the provided config.
+//
+// Must only be called once for the lifetime of the table.
+func (r *MulticastRouteTable) Init(config MulticastRouteTableConfig) *tcpip.Error {
+	if config.MaxPendingQueueSize <= 0 || config.Clock == nil {
+		return tcpip.ErrInvalidOptionValue
+	}
+
+	r.config = config
+	r.installedRoutes = make(map[stack.UnicastSourceAndMulticastDestination]*InstalledRoute)
+	r.pendingRoutes = make(map[stack.UnicastSourceAndMulticastDestination]pendingRoute)
+	return nil
+}
+
+// Close removes all installed and pending routes and stops the table's
+// timers.
+func (r *MulticastRouteTable) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.installedRoutes = make(map[stack.UnicastSourceAndMulticastDestination]*InstalledRoute)
+	r.pendingRoutes = make(map[stack.UnicastSourceAndMulticastDestination]pendingRoute)
+	if r.cleanupTimer != nil {
+		r.cleanupTimer.Stop()
+		r.cleanupTimer = nil
+	}
+}
+
+// NewInstalledRoute returns an InstalledRoute for route that can be added to
+// the table.
+func (r *MulticastRouteTable) NewInstalledRoute(route stack.MulticastRoute) *InstalledRoute {
+	return &InstalledRoute{
+		MulticastRoute:    route,
+		lastUsedTimestamp: r.config.Clock.NowMonotonic(),
+	}
+}
+
+// GetRoute returns the installed route that matches key, if any.
+func (r *MulticastRouteTable) GetRoute(key stack.UnicastSourceAndMulticastDestination) (*InstalledRoute, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	route, ok := r.installedRoutes[key]
+	return route, ok
+}
+
+// GetRouteOrInsertPending returns the installed route that matches key or,
+// if there is none, queues pkt in the pending route for key.
+//
+// The caller must not modify pkt once it is queued.
+func (r *MulticastRouteTable) GetRouteOrInsertPending(key stack.UnicastSourceAndMulticastDestination, pkt *stack.PacketBuffer) GetRouteResult {
+	// Fast path for packets that match an installed route.
+	if route, ok := r.GetRoute(key); ok {
+		return GetRouteResult{State: InstalledRouteFound, InstalledRoute: route}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if route, ok := r.installedRoutes[key]; ok {
+		return GetRouteResult{State: InstalledRouteFound, InstalledRoute: route}
+	}
+
+	pending, ok := r.pendingRoutes[key]
+	if !ok {
+		pending.expiresAt = r.config.Clock.NowMonotonic() + DefaultPendingRouteExpiration.Nanoseconds()
+	} else if len(pending.packets) >= r.config.MaxPendingQueueSize {
+		return GetRouteResult{State: PendingQueueFull}
+	}
+	pending.packets = append(pending.packets, pkt)
+	r.pendingRoutes[key] = pending
+
+	if r.cleanupTimer == nil {
+		r.cleanupTimer = r.config.Clock.AfterFunc(DefaultCleanupInterval, r.cleanupPendingRoutes)
+	}
+
+	if ok {
+		return GetRouteResult{State: PacketQueuedInPendingRoute}
+	}
+	return GetRouteResult{State: NoRouteFoundAndPendingInserted}
+}
+
+// cleanupPendingRoutes discards expired pending routes.
+func (r *MulticastRouteTable) cleanupPendingRoutes() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cleanupTimer == nil {
+		// The table was closed.
+		return
+	}
+
+	now := r.config.Clock.NowMonotonic()
+	for key, pending := range r.pendingRoutes {
+		if now >= pending.expiresAt {
+			delete(r.pendingRoutes, key)
+		}
+	}
+
+	if len(r.pendingRoutes) == 0 {
+		r.cleanupTimer = nil
+		return
+	}
+	r.cleanupTimer.Reset(DefaultCleanupInterval)
+}
+
+// AddInstalledRoute adds route to the table for key, replacing any route that
+// was previously installed for key.
+//
+// Returns the packets that were queued in the pending route for key; the
+// caller is responsible for forwarding them.
+func (r *MulticastRouteTable) AddInstalledRoute(key stack.UnicastSourceAndMulticastDestination, route *InstalledRoute) []*stack.PacketBuffer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.installedRoutes[key] = route
+
+	pending, ok := r.pendingRoutes[key]
+	if !ok {
+		return nil
+	}
+	delete(r.pendingRoutes, key)
+	if len(r.pendingRoutes) == 0 && r.cleanupTimer != nil {
+		r.cleanupTimer.Stop()
+		r.cleanupTimer = nil
+	}
+	return pending.packets
+}
+
+// RemoveInstalledRoute removes the route installed for key.
+//
+// Returns false if no route was installed for key.
+func (r *MulticastRouteTable) RemoveInstalledRoute(key stack.UnicastSourceAndMulticastDestination) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.installedRoutes[key]; !ok {
+		return false
+	}
+	delete(r.installedRoutes, key)
+	return true
+}
+
+// GetLastUsedTimestamp returns the monotonic time, in nanoseconds, at which
+// the route installed for key was last used.
+//
+// Returns false if no route was installed for key.
+func (r *MulticastRouteTable) GetLastUsedTimestamp(key stack.UnicastSourceAndMulticastDestination) (int64, bool) {
+	route, ok := r.GetRoute(key)
+	if !ok {
+		return 0, false
+	}
+	return route.LastUsedTimestamp(), true
+}
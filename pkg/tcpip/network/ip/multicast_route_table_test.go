@@ -0,0 +1,167 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip_test
+
+import (
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+var (
+	mcastKey1 = stack.UnicastSourceAndMulticastDestination{
+		Source:      "\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01",
+		Destination: "\xff\x0e\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01",
+	}
+	mcastKey2 = stack.UnicastSourceAndMulticastDestination{
+		Source:      "\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02",
+		Destination: "\xff\x0e\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01",
+	}
+	mcastRoute = stack.MulticastRoute{
+		ExpectedInputInterface: 1,
+		OutgoingInterfaces:     []stack.MulticastRouteOutgoingInterface{{ID: 2, MinTTL: 1}},
+	}
+)
+
+func newMulticastRouteTable(t *testing.T, clock tcpip.Clock) *ip.MulticastRouteTable {
+	t.Helper()
+
+	var table ip.MulticastRouteTable
+	if err := table.Init(ip.DefaultMulticastRouteTableConfig(clock)); err != nil {
+		t.Fatalf("table.Init(_) = %s", err)
+	}
+	return &table
+}
+
+func TestMulticastRouteTableInit(t *testing.T) {
+	clock := faketime.NewManualClock()
+	tests := []struct {
+		name   string
+		config ip.MulticastRouteTableConfig
+		want   *tcpip.Error
+	}{
+		{
+			name:   "Default",
+			config: ip.DefaultMulticastRouteTableConfig(clock),
+		},
+		{
+			name:   "Zero queue size",
+			config: ip.MulticastRouteTableConfig{Clock: clock},
+			want:   tcpip.ErrInvalidOptionValue,
+		},
+		{
+			name:   "No clock",
+			config: ip.MulticastRouteTableConfig{MaxPendingQueueSize: 1},
+			want:   tcpip.ErrInvalidOptionValue,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var table ip.MulticastRouteTable
+			if err := table.Init(test.config); err != test.want {
+				t.Errorf("got table.Init(%#v) = %v, want = %v", test.config, err, test.want)
+			}
+		})
+	}
+}
+
+func TestMulticastRouteTablePendingRoutes(t *testing.T) {
+	clock := faketime.NewManualClock()
+	table := newMulticastRouteTable(t, clock)
+	defer table.Close()
+
+	getRoute := func(key stack.UnicastSourceAndMulticastDestination, pkt *stack.PacketBuffer, want ip.GetRouteResultState) {
+		t.Helper()
+
+		if got := table.GetRouteOrInsertPending(key, pkt); got.State != want {
+			t.Errorf("got table.GetRouteOrInsertPending(%#v, _).State = %d, want = %d", key, got.State, want)
+		}
+	}
+
+	var pkts []*stack.PacketBuffer
+	for i := 0; i < ip.DefaultMaxPendingQueueSize+1; i++ {
+		pkts = append(pkts, stack.NewPacketBuffer(stack.PacketBufferOptions{}))
+	}
+
+	getRoute(mcastKey1, pkts[0], ip.NoRouteFoundAndPendingInserted)
+	for _, pkt := range pkts[1:ip.DefaultMaxPendingQueueSize] {
+		getRoute(mcastKey1, pkt, ip.PacketQueuedInPendingRoute)
+	}
+	getRoute(mcastKey1, pkts[ip.DefaultMaxPendingQueueSize], ip.PendingQueueFull)
+
+	// Installing the route releases the queued packets.
+	queued := table.AddInstalledRoute(mcastKey1, table.NewInstalledRoute(mcastRoute))
+	if got, want := len(queued), ip.DefaultMaxPendingQueueSize; got != want {
+		t.Fatalf("got len(table.AddInstalledRoute(...)) = %d, want = %d", got, want)
+	}
+	for i, pkt := range queued {
+		if pkt != pkts[i] {
+			t.Errorf("got queued packet #%d = %p, want = %p", i, pkt, pkts[i])
+		}
+	}
+	if got := table.GetRouteOrInsertPending(mcastKey1, pkts[0]); got.State != ip.InstalledRouteFound || got.InstalledRoute == nil {
+		t.Fatalf("got table.GetRouteOrInsertPending(%#v, _) = %#v, want installed route", mcastKey1, got)
+	}
+
+	// Pending routes expire.
+	getRoute(mcastKey2, pkts[0], ip.NoRouteFoundAndPendingInserted)
+	clock.Advance(ip.DefaultPendingRouteExpiration)
+	getRoute(mcastKey2, pkts[0], ip.NoRouteFoundAndPendingInserted)
+	clock.Advance(ip.DefaultPendingRouteExpiration)
+	if queued := table.AddInstalledRoute(mcastKey2, table.NewInstalledRoute(mcastRoute)); len(queued) != 0 {
+		t.Errorf("got len(table.AddInstalledRoute(...)) = %d, want = 0", len(queued))
+	}
+}
+
+func TestMulticastRouteTableInstalledRoutes(t *testing.T) {
+	clock := faketime.NewManualClock()
+	table := newMulticastRouteTable(t, clock)
+	defer table.Close()
+
+	if _, ok := table.GetLastUsedTimestamp(mcastKey1); ok {
+		t.Errorf("got table.GetLastUsedTimestamp(%#v) = (_, true), want = (_, false)", mcastKey1)
+	}
+	if table.RemoveInstalledRoute(mcastKey1) {
+		t.Errorf("got table.RemoveInstalledRoute(%#v) = true, want = false", mcastKey1)
+	}
+
+	start := clock.NowMonotonic()
+	route := table.NewInstalledRoute(mcastRoute)
+	table.AddInstalledRoute(mcastKey1, route)
+	if got, ok := table.GetLastUsedTimestamp(mcastKey1); !ok || got != start {
+		t.Errorf("got table.GetLastUsedTimestamp(%#v) = (%d, %t), want = (%d, true)", mcastKey1, got, ok, start)
+	}
+
+	// The timestamp only moves forward.
+	clock.Advance(time.Second)
+	used := clock.NowMonotonic()
+	route.SetLastUsedTimestamp(used)
+	route.SetLastUsedTimestamp(start)
+	if got, ok := table.GetLastUsedTimestamp(mcastKey1); !ok || got != used {
+		t.Errorf("got table.GetLastUsedTimestamp(%#v) = (%d, %t), want = (%d, true)", mcastKey1, got, ok, used)
+	}
+
+	if !table.RemoveInstalledRoute(mcastKey1) {
+		t.Errorf("got table.RemoveInstalledRoute(%#v) = false, want = true", mcastKey1)
+	}
+	if _, ok := table.GetRoute(mcastKey1); ok {
+		t.Errorf("got table.GetRoute(%#v) = (_, true), want = (_, false)", mcastKey1)
+	}
+}
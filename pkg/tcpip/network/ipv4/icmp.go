@@ -55,6 +55,11 @@ func (e *endpoint) handleControl(typ stack.ControlType, extra uint32, pkt *stack
 		return
 	}
 
+	if typ == stack.ControlPacketTooBig && extra != 0 {
+		// extra is the network-layer payload MTU.
+		e.protocol.stack.UpdatePathMTU(ProtocolNumber, hdr.DestinationAddress(), extra+header.IPv4MinimumSize)
+	}
+
 	// Skip the ip header, then deliver control message.
 	pkt.Data.TrimFront(hlen)
 	p := hdr.TransportProtocol()
@@ -497,6 +502,11 @@ func (p *protocol) returnError(reason icmpReason, pkt *stack.PacketBuffer) *tcpi
 	default:
 		panic(fmt.Sprintf("unsupported ICMP type %T", reason))
 	}
+	ep, _ := p.stack.GetNetworkEndpoint(route.NICID(), ProtocolNumber)
+	if e, ok := ep.(*endpoint); ok && !e.icmpRateLimits.allowError(icmpHdr.Type()) {
+		sent.RateLimited.Increment()
+		return nil
+	}
 	icmpHdr.SetChecksum(header.ICMPv4Checksum(icmpHdr, icmpPkt.Data))
 
 	if err := route.WritePacket(
@@ -0,0 +1,182 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv4
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// ICMPv4TypeSet is a set of ICMPv4 message types.
+type ICMPv4TypeSet [4]uint64
+
+// Add adds t to the set.
+func (s *ICMPv4TypeSet) Add(t header.ICMPv4Type) {
+	s[t/64] |= 1 << (t % 64)
+}
+
+// Contains returns true if t is in the set.
+func (s ICMPv4TypeSet) Contains(t header.ICMPv4Type) bool {
+	return s[t/64]&(1<<(t%64)) != 0
+}
+
+// ICMPRateLimits are the limits an endpoint applies to the rate at which it
+// generates ICMPv4 errors, on top of the stack-wide ICMP rate limit.
+//
+// Limits set to 0 are not enforced.
+type ICMPRateLimits struct {
+	// ErrorLimit is the maximum number of ICMPv4 error messages the endpoint
+	// generates per second, equivalent to the inverse of Linux's
+	// net.ipv4.icmp_ratelimit.
+	ErrorLimit rate.Limit
+
+	// ErrorBurst is the maximum number of ICMPv4 error messages the endpoint
+	// generates in a single burst. A burst less than 1 is treated as 1.
+	ErrorBurst int
+
+	// ErrorExemptTypes holds the ICMPv4 error types that ErrorLimit does not
+	// apply to, the complement of Linux's net.ipv4.icmp_ratemask.
+	ErrorExemptTypes ICMPv4TypeSet
+}
+
+// ICMPRateLimitStats holds the number of ICMPv4 error messages an endpoint did
+// not send because of its ICMPRateLimits, by type.
+type ICMPRateLimitStats struct {
+	// DstUnreachableRateLimited is the number of Destination Unreachable
+	// messages not sent.
+	DstUnreachableRateLimited tcpip.StatCounter
+
+	// TimeExceededRateLimited is the number of Time Exceeded messages not
+	// sent.
+	TimeExceededRateLimited tcpip.StatCounter
+
+	// ParamProblemRateLimited is the number of Parameter Problem messages not
+	// sent.
+	ParamProblemRateLimited tcpip.StatCounter
+}
+
+// counter returns the counter of suppressed errors of type typ, or nil if
+// errors of type typ are not counted.
+func (s *ICMPRateLimitStats) counter(typ header.ICMPv4Type) *tcpip.StatCounter {
+	switch typ {
+	case header.ICMPv4DstUnreachable:
+		return &s.DstUnreachableRateLimited
+	case header.ICMPv4TimeExceeded:
+		return &s.TimeExceededRateLimited
+	case header.ICMPv4ParamProblem:
+		return &s.ParamProblemRateLimited
+	default:
+		return nil
+	}
+}
+
+// ICMPRateLimitEndpoint is a network endpoint that limits the rate at which
+// it generates ICMPv4 errors.
+type ICMPRateLimitEndpoint interface {
+	// SetICMPRateLimits sets the endpoint's ICMPv4 rate limits.
+	SetICMPRateLimits(ICMPRateLimits)
+
+	// ICMPRateLimits returns the endpoint's ICMPv4 rate limits.
+	ICMPRateLimits() ICMPRateLimits
+
+	// ICMPRateLimitStats returns the endpoint's rate limiting statistics.
+	ICMPRateLimitStats() *ICMPRateLimitStats
+}
+
+var _ ICMPRateLimitEndpoint = (*endpoint)(nil)
+
+// SetICMPRateLimits implements ICMPRateLimitEndpoint.
+func (e *endpoint) SetICMPRateLimits(limits ICMPRateLimits) {
+	e.icmpRateLimits.setLimits(limits)
+}
+
+// ICMPRateLimits implements ICMPRateLimitEndpoint.
+func (e *endpoint) ICMPRateLimits() ICMPRateLimits {
+	e.icmpRateLimits.mu.Lock()
+	defer e.icmpRateLimits.mu.Unlock()
+	return e.icmpRateLimits.mu.limits
+}
+
+// ICMPRateLimitStats implements ICMPRateLimitEndpoint.
+func (e *endpoint) ICMPRateLimitStats() *ICMPRateLimitStats {
+	return &e.icmpRateLimits.stats
+}
+
+// icmpRateLimitState is the per-interface state used to limit the rate at
+// which ICMPv4 errors are generated.
+//
+// icmpRateLimitState is protected by its own lock instead of the endpoint's
+// lock as ICMPv4 errors are generated while the endpoint's lock may be held.
+//
+// icmpRateLimitState.init MUST be called to initialize the state.
+type icmpRateLimitState struct {
+	clock tcpip.Clock
+
+	stats ICMPRateLimitStats
+
+	mu struct {
+		sync.Mutex
+
+		limits ICMPRateLimits
+
+		// errors is nil when the error limit is not enforced.
+		errors *rate.Limiter
+	}
+}
+
+// init sets up an icmpRateLimitState struct, and is required to be called
+// before using a new icmpRateLimitState.
+func (s *icmpRateLimitState) init(clock tcpip.Clock, limits ICMPRateLimits) {
+	s.clock = clock
+	s.setLimits(limits)
+}
+
+// setLimits replaces the limits, refilling the token bucket.
+func (s *icmpRateLimitState) setLimits(limits ICMPRateLimits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mu.limits = limits
+	s.mu.errors = nil
+	if limits.ErrorLimit > 0 {
+		burst := limits.ErrorBurst
+		if burst < 1 {
+			burst = 1
+		}
+		s.mu.errors = rate.NewLimiter(limits.ErrorLimit, burst)
+	}
+}
+
+// allowError returns true if an ICMPv4 error of type typ may be sent.
+func (s *icmpRateLimitState) allowError(typ header.ICMPv4Type) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.mu.errors == nil || s.mu.limits.ErrorExemptTypes.Contains(typ) {
+		return true
+	}
+	// Follow the stack's notion of time rather than the wall clock.
+	if s.mu.errors.AllowN(time.Unix(0, s.clock.NowMonotonic()), 1) {
+		return true
+	}
+	if counter := s.stats.counter(typ); counter != nil {
+		counter.Increment()
+	}
+	return false
+}
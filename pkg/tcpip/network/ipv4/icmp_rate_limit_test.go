@@ -0,0 +1,177 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv4_test
+
+import (
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+// drainICMPPackets reads all the packets sent through e and returns how many
+// of them were ICMPv4 messages of type typ.
+func drainICMPPackets(e *channel.Endpoint, typ header.ICMPv4Type) int {
+	n := 0
+	for {
+		p, ok := e.Read()
+		if !ok {
+			return n
+		}
+		ip := header.IPv4(stack.PayloadSince(p.Pkt.NetworkHeader()))
+		if ip.TransportProtocol() == header.ICMPv4ProtocolNumber && header.ICMPv4(ip.Payload()).Type() == typ {
+			n++
+		}
+	}
+}
+
+func TestICMPErrorRateLimit(t *testing.T) {
+	const (
+		nicID     = 1
+		localPort = 1234
+	)
+	localAddr := tcpip.Address("\x0a\x00\x00\x01")
+	remoteAddr := tcpip.Address("\x0a\x00\x00\x02")
+
+	tests := []struct {
+		name        string
+		limits      ipv4.ICMPRateLimits
+		wantSent    int
+		wantLimited uint64
+	}{
+		{
+			name:     "Not enforced",
+			wantSent: 3,
+		},
+		{
+			name:        "Limited",
+			limits:      ipv4.ICMPRateLimits{ErrorLimit: 1, ErrorBurst: 2},
+			wantSent:    2,
+			wantLimited: 1,
+		},
+		{
+			name:        "Zero burst",
+			limits:      ipv4.ICMPRateLimits{ErrorLimit: 1},
+			wantSent:    1,
+			wantLimited: 2,
+		},
+		{
+			name: "Exempt type",
+			limits: func() ipv4.ICMPRateLimits {
+				limits := ipv4.ICMPRateLimits{ErrorLimit: 1, ErrorBurst: 1}
+				limits.ErrorExemptTypes.Add(header.ICMPv4DstUnreachable)
+				return limits
+			}(),
+			wantSent: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := faketime.NewManualClock()
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocolWithOptions(ipv4.Options{
+					ICMPRateLimits: test.limits,
+				})},
+				TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+				Clock:              clock,
+			})
+			e := channel.New(10, 1280, "")
+			if err := s.CreateNIC(nicID, e); err != nil {
+				t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+			}
+			if err := s.AddAddress(nicID, ipv4.ProtocolNumber, localAddr); err != nil {
+				t.Fatalf("AddAddress(%d, %d, %s) = %s", nicID, ipv4.ProtocolNumber, localAddr, err)
+			}
+			s.SetRouteTable([]tcpip.Route{{Destination: header.IPv4EmptySubnet, NIC: nicID}})
+			// Ignore the IGMP reports sent when the NIC was enabled.
+			e.Drain()
+
+			injectUDP := func() {
+				const totalLen = header.IPv4MinimumSize + header.UDPMinimumSize
+				hdr := buffer.NewPrependable(totalLen)
+				u := header.UDP(hdr.Prepend(header.UDPMinimumSize))
+				u.Encode(&header.UDPFields{
+					SrcPort: localPort + 1,
+					DstPort: localPort,
+					Length:  header.UDPMinimumSize,
+				})
+				sum := header.PseudoHeaderChecksum(udp.ProtocolNumber, remoteAddr, localAddr, header.UDPMinimumSize)
+				u.SetChecksum(^u.CalculateChecksum(sum))
+				ip := header.IPv4(hdr.Prepend(header.IPv4MinimumSize))
+				ip.Encode(&header.IPv4Fields{
+					TotalLength: totalLen,
+					Protocol:    uint8(udp.ProtocolNumber),
+					TTL:         64,
+					SrcAddr:     remoteAddr,
+					DstAddr:     localAddr,
+				})
+				ip.SetChecksum(^ip.CalculateChecksum())
+				e.InjectInbound(ipv4.ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+					Data: hdr.View().ToVectorisedView(),
+				}))
+			}
+
+			for i := 0; i < 3; i++ {
+				injectUDP()
+			}
+			if got := drainICMPPackets(e, header.ICMPv4DstUnreachable); got != test.wantSent {
+				t.Errorf("got %d Destination Unreachable messages, want = %d", got, test.wantSent)
+			}
+
+			ep, err := s.GetNetworkEndpoint(nicID, ipv4.ProtocolNumber)
+			if err != nil {
+				t.Fatalf("s.GetNetworkEndpoint(%d, %d): %s", nicID, ipv4.ProtocolNumber, err)
+			}
+			rateLimitEP := ep.(ipv4.ICMPRateLimitEndpoint)
+			if got := rateLimitEP.ICMPRateLimits(); got != test.limits {
+				t.Errorf("got ICMPRateLimits() = %+v, want = %+v", got, test.limits)
+			}
+			stats := rateLimitEP.ICMPRateLimitStats()
+			if got := stats.DstUnreachableRateLimited.Value(); got != test.wantLimited {
+				t.Errorf("got DstUnreachableRateLimited = %d, want = %d", got, test.wantLimited)
+			}
+			if got := stats.TimeExceededRateLimited.Value(); got != 0 {
+				t.Errorf("got TimeExceededRateLimited = %d, want = 0", got)
+			}
+			if got := s.Stats().ICMP.V4.PacketsSent.RateLimited.Value(); got != test.wantLimited {
+				t.Errorf("got RateLimited = %d, want = %d", got, test.wantLimited)
+			}
+
+			// Tokens should be replenished over time.
+			clock.Advance(time.Second)
+			injectUDP()
+			if got := drainICMPPackets(e, header.ICMPv4DstUnreachable); got != 1 {
+				t.Errorf("got %d Destination Unreachable messages after replenishing tokens, want = 1", got)
+			}
+
+			// Removing the limits stops errors from being suppressed.
+			rateLimitEP.SetICMPRateLimits(ipv4.ICMPRateLimits{})
+			for i := 0; i < 3; i++ {
+				injectUDP()
+			}
+			if got := drainICMPPackets(e, header.ICMPv4DstUnreachable); got != 3 {
+				t.Errorf("got %d Destination Unreachable messages without limits, want = 3", got)
+			}
+		})
+	}
+}
@@ -16,6 +16,7 @@ package ipv4
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -37,6 +38,13 @@ const (
 	// See note on igmpState.igmpV1Present for more detail.
 	v1RouterPresentTimeout = 400 * time.Second
 
+	// v2RouterPresentTimeout is the Older Version Querier Present Timeout from
+	// RFC 3376 section 8.12, calculated with the default Robustness Variable,
+	// Query Interval and Query Response Interval (2 * 125s + 10s).
+	//
+	// See note on igmpState.igmpV2Present for more detail.
+	v2RouterPresentTimeout = 260 * time.Second
+
 	// v1MaxRespTime from RFC 2236 Section 4, Page 5. "The IGMPv1 router
 	// will send General Queries with the Max Response Time set to 0. This MUST
 	// be interpreted as a value of 100 (10 seconds)."
@@ -59,6 +67,14 @@ type IGMPOptions struct {
 	// joining and leaving multicast groups respectively, and handle incoming
 	// IGMP packets.
 	Enabled bool
+
+	// EnableV3 indicates whether IGMPv3, as defined by RFC 3376, will be
+	// performed when no IGMPv1 or IGMPv2 querier is present on the interface.
+	//
+	// When disabled, IGMPv2 is the newest version of IGMP used and IGMPv3
+	// queries are handled as IGMPv2 queries. Source-specific memberships are
+	// still tracked, but are advertised as regular group memberships.
+	EnableV3 bool
 }
 
 var _ ip.MulticastGroupProtocol = (*igmpState)(nil)
@@ -84,6 +100,27 @@ type igmpState struct {
 	// when false.
 	igmpV1Present uint32
 
+	// igmpV2Present is like igmpV1Present but for IGMPv2 queriers, from RFC
+	// 3376 section 7.2.1: "In order to switch gracefully between versions of
+	// IGMP, hosts keep both an IGMPv1 Querier Present timer and an IGMPv2
+	// Querier Present timer per interface". It is only set when IGMPv3 is
+	// enabled.
+	//
+	// Must be accessed with atomic operations. Holds a value of 1 when true, 0
+	// when false.
+	igmpV2Present uint32
+
+	// sourceFilters holds the interface-level source filter state of each
+	// joined group, as per RFC 3376 section 3.2.
+	//
+	// sourceFilters has its own lock as it is read when sending reports from
+	// the generic multicast protocol's jobs, which do not hold mu.
+	sourceFilters struct {
+		sync.Mutex
+
+		groups map[tcpip.Address]*igmpSourceFilter
+	}
+
 	mu struct {
 		sync.RWMutex
 
@@ -93,11 +130,234 @@ type igmpState struct {
 		// message, upon expiration the igmpV1Present flag is cleared.
 		// igmpV1Job may not be nil once igmpState is initialized.
 		igmpV1Job *tcpip.Job
+
+		// igmpV2Job is like igmpV1Job but clears the igmpV2Present flag.
+		igmpV2Job *tcpip.Job
+
+		// generalQueryJob is the IGMPv3 interface timer used to respond to
+		// general queries, as per RFC 3376 section 5.2.
+		//
+		// generalQueryJob may not be nil once igmpState is initialized.
+		generalQueryJob *tcpip.Job
+
+		// generalQueryPending is true when generalQueryJob is scheduled.
+		generalQueryPending bool
+
+		// groupQueries holds the pending responses to IGMPv3 group-specific and
+		// group-and-source-specific queries.
+		groupQueries map[tcpip.Address]*igmpPendingGroupQuery
+	}
+}
+
+// igmpSourceFilter is the interface-level source filter of a group.
+//
+// As per RFC 3376 section 3.2, the interface is in EXCLUDE mode for the group
+// if any of its memberships is in EXCLUDE mode. Memberships joined without a
+// source are in EXCLUDE mode with an empty source list, so the interface's
+// source list is then also empty. Otherwise, the interface is in INCLUDE mode
+// for the group and its source list is the union of all the joined sources.
+type igmpSourceFilter struct {
+	// anySourceJoins is the number of times the group was joined without a
+	// source.
+	anySourceJoins uint64
+
+	// sources holds the number of times the group was joined for each source.
+	sources map[tcpip.Address]uint64
+}
+
+// joins returns the total number of times the group was joined.
+func (f *igmpSourceFilter) joins() uint64 {
+	joins := f.anySourceJoins
+	for _, n := range f.sources {
+		joins += n
+	}
+	return joins
+}
+
+// add records a join for the source, or for any source if source is empty.
+func (f *igmpSourceFilter) add(source tcpip.Address) {
+	if len(source) == 0 {
+		f.anySourceJoins++
+		return
+	}
+	f.sources[source]++
+}
+
+// remove removes a join for the source, or for any source if source is empty.
+//
+// Returns false if no such join exists.
+func (f *igmpSourceFilter) remove(source tcpip.Address) bool {
+	if len(source) == 0 {
+		if f.anySourceJoins == 0 {
+			return false
+		}
+		f.anySourceJoins--
+		return true
+	}
+
+	n, ok := f.sources[source]
+	if !ok {
+		return false
+	}
+	if n == 1 {
+		delete(f.sources, source)
+	} else {
+		f.sources[source] = n - 1
+	}
+	return true
+}
+
+// state returns a snapshot of the filter state.
+func (f *igmpSourceFilter) state() igmpFilterState {
+	if f.anySourceJoins != 0 {
+		return igmpFilterState{exclude: true}
+	}
+	sources := make([]tcpip.Address, 0, len(f.sources))
+	for source := range f.sources {
+		sources = append(sources, source)
+	}
+	sortAddresses(sources)
+	return igmpFilterState{sources: sources}
+}
+
+// igmpFilterState is a snapshot of the interface-level filter state of a group.
+//
+// The zero value is INCLUDE {}, the state of a group that is not joined.
+type igmpFilterState struct {
+	exclude bool
+	sources []tcpip.Address
+}
+
+// igmpPendingGroupQuery is a pending response to an IGMPv3 group-specific or
+// group-and-source-specific query.
+type igmpPendingGroupQuery struct {
+	job *tcpip.Job
+
+	// sources holds the queried sources. A nil map indicates the response is
+	// for a group-specific query.
+	sources map[tcpip.Address]struct{}
+}
+
+func sortAddresses(addrs []tcpip.Address) {
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+}
+
+// stateChangeRecords returns the group records to send when the filter state
+// of a group changes.
+func stateChangeRecords(groupAddress tcpip.Address, oldState, newState igmpFilterState) []header.IGMPv3ReportGroupAddressRecordSerializer {
+	// As per RFC 3376 section 5.1,
+	//
+	//   Old State         New State         State-Change Record Sent
+	//   ---------         ---------         ------------------------
+	//
+	//   INCLUDE (A)       INCLUDE (B)       ALLOW (B-A), BLOCK (A-B)
+	//
+	//   EXCLUDE (A)       EXCLUDE (B)       ALLOW (A-B), BLOCK (B-A)
+	//
+	//   INCLUDE (A)       EXCLUDE (B)       TO_EX (B)
+	//
+	//   EXCLUDE (A)       INCLUDE (B)       TO_IN (B)
+	switch {
+	case oldState.exclude && newState.exclude:
+		// Our EXCLUDE source list is always empty.
+		return nil
+	case !oldState.exclude && newState.exclude:
+		return []header.IGMPv3ReportGroupAddressRecordSerializer{{
+			RecordType:   header.IGMPv3ReportRecordChangeToExcludeMode,
+			GroupAddress: groupAddress,
+		}}
+	case oldState.exclude && !newState.exclude:
+		return []header.IGMPv3ReportGroupAddressRecordSerializer{{
+			RecordType:   header.IGMPv3ReportRecordChangeToIncludeMode,
+			GroupAddress: groupAddress,
+			Sources:      newState.sources,
+		}}
+	}
+
+	var records []header.IGMPv3ReportGroupAddressRecordSerializer
+	if allow := addressDifference(newState.sources, oldState.sources); len(allow) != 0 {
+		records = append(records, header.IGMPv3ReportGroupAddressRecordSerializer{
+			RecordType:   header.IGMPv3ReportRecordAllowNewSources,
+			GroupAddress: groupAddress,
+			Sources:      allow,
+		})
 	}
+	if block := addressDifference(oldState.sources, newState.sources); len(block) != 0 {
+		records = append(records, header.IGMPv3ReportGroupAddressRecordSerializer{
+			RecordType:   header.IGMPv3ReportRecordBlockOldSources,
+			GroupAddress: groupAddress,
+			Sources:      block,
+		})
+	}
+	return records
+}
+
+// addressDifference returns the addresses in a that are not in b.
+func addressDifference(a, b []tcpip.Address) []tcpip.Address {
+	var ret []tcpip.Address
+	for _, addr := range a {
+		found := false
+		for _, other := range b {
+			if addr == other {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ret = append(ret, addr)
+		}
+	}
+	return ret
+}
+
+// currentStateRecord returns the Current-State Record for a group in response
+// to a query, as per RFC 3376 section 5.2.
+//
+// querySources holds the queried sources for a group-and-source-specific
+// query, or nil for a general or group-specific query.
+//
+// Returns false if no record should be sent.
+func currentStateRecord(groupAddress tcpip.Address, state igmpFilterState, querySources map[tcpip.Address]struct{}) (header.IGMPv3ReportGroupAddressRecordSerializer, bool) {
+	if querySources == nil {
+		recordType := header.IGMPv3ReportRecordModeIsInclude
+		if state.exclude {
+			recordType = header.IGMPv3ReportRecordModeIsExclude
+		}
+		return header.IGMPv3ReportGroupAddressRecordSerializer{
+			RecordType:   recordType,
+			GroupAddress: groupAddress,
+			Sources:      state.sources,
+		}, true
+	}
+
+	// As per RFC 3376 section 5.2, the response to a group-and-source-specific
+	// query is IS_IN(A*B) if the group is in INCLUDE (A) mode, or IS_IN(B-A) if
+	// the group is in EXCLUDE (A) mode, where B is the set of queried sources.
+	var sources []tcpip.Address
+	for source := range querySources {
+		if state.exclude || len(addressDifference([]tcpip.Address{source}, state.sources)) == 0 {
+			sources = append(sources, source)
+		}
+	}
+	if len(sources) == 0 {
+		return header.IGMPv3ReportGroupAddressRecordSerializer{}, false
+	}
+	sortAddresses(sources)
+	return header.IGMPv3ReportGroupAddressRecordSerializer{
+		RecordType:   header.IGMPv3ReportRecordModeIsInclude,
+		GroupAddress: groupAddress,
+		Sources:      sources,
+	}, true
 }
 
 // SendReport implements ip.MulticastGroupProtocol.
 func (igmp *igmpState) SendReport(groupAddress tcpip.Address) *tcpip.Error {
+	if igmp.v3Mode() {
+		// As per RFC 3376 section 5.1, joining a group is a change of the
+		// interface's filter state for the group from INCLUDE {}.
+		return igmp.writeV3Report(stateChangeRecords(groupAddress, igmpFilterState{}, igmp.filterState(groupAddress)))
+	}
+
 	igmpType := header.IGMPv2MembershipReport
 	if igmp.v1Present() {
 		igmpType = header.IGMPv1MembershipReport
@@ -114,6 +374,11 @@ func (igmp *igmpState) SendLeave(groupAddress tcpip.Address) *tcpip.Error {
 	if igmp.v1Present() {
 		return nil
 	}
+	if igmp.v3Mode() {
+		// As per RFC 3376 section 5.1, leaving a group is a change of the
+		// interface's filter state for the group to INCLUDE {}.
+		return igmp.writeV3Report(stateChangeRecords(groupAddress, igmp.filterState(groupAddress), igmpFilterState{}))
+	}
 	return igmp.writePacket(header.IPv4AllRoutersGroup, groupAddress, header.IGMPLeaveGroup)
 }
 
@@ -136,6 +401,18 @@ func (igmp *igmpState) init(ep *endpoint, opts IGMPOptions) {
 	igmp.mu.igmpV1Job = igmp.ep.protocol.stack.NewJob(&igmp.mu, func() {
 		igmp.setV1Present(false)
 	})
+	igmp.mu.igmpV2Job = igmp.ep.protocol.stack.NewJob(&igmp.mu, func() {
+		igmp.setV2Present(false)
+	})
+	igmp.mu.generalQueryJob = igmp.ep.protocol.stack.NewJob(&igmp.mu, func() {
+		igmp.mu.generalQueryPending = false
+		igmp.sendGeneralQueryResponse()
+	})
+	igmp.mu.groupQueries = make(map[tcpip.Address]*igmpPendingGroupQuery)
+
+	igmp.sourceFilters.Lock()
+	defer igmp.sourceFilters.Unlock()
+	igmp.sourceFilters.groups = make(map[tcpip.Address]*igmpSourceFilter)
 }
 
 func (igmp *igmpState) handleIGMP(pkt *stack.PacketBuffer) {
@@ -167,6 +444,22 @@ func (igmp *igmpState) handleIGMP(pkt *stack.PacketBuffer) {
 			received.Invalid.Increment()
 			return
 		}
+		// As per RFC 3376 section 7.1, the version of a query is determined by
+		// its length: IGMPv1 and IGMPv2 queries are 8 octets long while IGMPv3
+		// queries are at least 12 octets long. Queries of any other length
+		// MUST be silently ignored.
+		if igmp.opts.EnableV3 {
+			if size := pkt.Data.Size(); size >= header.IGMPv3QueryMinimumSize {
+				queryView, ok := pkt.Data.PullUp(size)
+				if !ok || !igmp.handleV3Query(header.IGMPv3Query(queryView)) {
+					received.Invalid.Increment()
+				}
+				return
+			} else if size != header.IGMPQueryMinimumSize {
+				received.Invalid.Increment()
+				return
+			}
+		}
 		igmp.handleMembershipQuery(h.GroupAddress(), h.MaxRespTime())
 	case header.IGMPv1MembershipReport:
 		received.V1MembershipReport.Increment()
@@ -186,6 +479,10 @@ func (igmp *igmpState) handleIGMP(pkt *stack.PacketBuffer) {
 		received.LeaveGroup.Increment()
 		// As per RFC 2236 Section 6, Page 7: "IGMP messages other than Query or
 		// Report, are ignored in all states"
+	case header.IGMPv3MembershipReport:
+		received.V3MembershipReport.Increment()
+		// As per RFC 3376 section 5, hosts do not perform report suppression in
+		// IGMPv3 so IGMPv3 reports are ignored.
 
 	default:
 		// As per RFC 2236 Section 2.1 Page 3: "Unrecognized message types should
@@ -207,6 +504,24 @@ func (igmp *igmpState) setV1Present(v bool) {
 	}
 }
 
+func (igmp *igmpState) v2Present() bool {
+	return atomic.LoadUint32(&igmp.igmpV2Present) == 1
+}
+
+func (igmp *igmpState) setV2Present(v bool) {
+	if v {
+		atomic.StoreUint32(&igmp.igmpV2Present, 1)
+	} else {
+		atomic.StoreUint32(&igmp.igmpV2Present, 0)
+	}
+}
+
+// v3Mode returns true if the interface is in IGMPv3 compatibility mode, as
+// per RFC 3376 section 7.2.1.
+func (igmp *igmpState) v3Mode() bool {
+	return igmp.opts.EnableV3 && !igmp.v1Present() && !igmp.v2Present()
+}
+
 func (igmp *igmpState) handleMembershipQuery(groupAddress tcpip.Address, maxRespTime time.Duration) {
 	igmp.mu.Lock()
 	defer igmp.mu.Unlock()
@@ -215,29 +530,252 @@ func (igmp *igmpState) handleMembershipQuery(groupAddress tcpip.Address, maxResp
 	// then change the state to note that an IGMPv1 router is present and
 	// schedule the query received Job.
 	if maxRespTime == 0 && igmp.opts.Enabled {
+		igmp.cancelV3QueryResponsesLocked()
 		igmp.mu.igmpV1Job.Cancel()
 		igmp.mu.igmpV1Job.Schedule(v1RouterPresentTimeout)
 		igmp.setV1Present(true)
 		maxRespTime = v1MaxRespTime
+	} else if igmp.opts.EnableV3 && igmp.opts.Enabled {
+		// As per RFC 3376 section 7.2.1, the IGMPv2 Querier Present timer is set
+		// when an IGMPv2 General Query is received.
+		igmp.cancelV3QueryResponsesLocked()
+		igmp.mu.igmpV2Job.Cancel()
+		igmp.mu.igmpV2Job.Schedule(v2RouterPresentTimeout)
+		igmp.setV2Present(true)
 	}
 
 	igmp.mu.genericMulticastProtocol.HandleQuery(groupAddress, maxRespTime)
 }
 
+// handleV3Query handles an IGMPv3 query.
+//
+// Returns false if the query is malformed.
+func (igmp *igmpState) handleV3Query(query header.IGMPv3Query) bool {
+	sources, ok := query.Sources()
+	if !ok {
+		return false
+	}
+	groupAddress := query.GroupAddress()
+	maxRespTime := query.MaximumResponseTime()
+
+	igmp.mu.Lock()
+	defer igmp.mu.Unlock()
+
+	if !igmp.v3Mode() {
+		// As per RFC 3376 section 7.2.1, a host in IGMPv1 or IGMPv2 compatibility
+		// mode responds to queries with reports of that version.
+		igmp.mu.genericMulticastProtocol.HandleQuery(groupAddress, maxRespTime)
+		return true
+	}
+
+	if !igmp.opts.Enabled {
+		return true
+	}
+
+	// As per RFC 3376 section 5.2,
+	//
+	//   1. If there is a pending response to a previous General Query
+	//      scheduled sooner than the selected delay, no additional response
+	//      needs to be scheduled.
+	//
+	//   2. If the received Query is a General Query, the interface timer is
+	//      used to schedule a response to the General Query after the
+	//      selected delay.  Any previously pending response to a General
+	//      Query is canceled.
+	//
+	// The delay of a pending response is not tracked so a pending response to
+	// a General Query is always kept.
+	if igmp.mu.generalQueryPending {
+		return true
+	}
+
+	delay := igmp.calculateDelay(maxRespTime)
+	if groupAddress.Unspecified() {
+		igmp.mu.generalQueryPending = true
+		igmp.mu.generalQueryJob.Schedule(delay)
+		return true
+	}
+
+	if !igmp.mu.genericMulticastProtocol.IsLocallyJoined(groupAddress) {
+		return true
+	}
+
+	//   3. If the received Query is a Group-Specific Query or a Group-and-
+	//      Source-Specific Query and there is no pending response to a
+	//      previous Query for this group, then the group timer is used to
+	//      schedule a report.  If the received Query is a Group-and-Source-
+	//      Specific Query, the list of queried sources is recorded to be used
+	//      when generating a response.
+	//
+	//   4. If there already is a pending response to a previous Query
+	//      scheduled for this group, and either the new Query is a Group-
+	//      Specific Query or the recorded source-list associated with the
+	//      group is empty, then the group source-list is cleared and a single
+	//      response is scheduled using the group timer.  The new response is
+	//      scheduled to be sent at the earliest of the remaining time for the
+	//      pending report and the selected delay.
+	//
+	//   5. If the received Query is a Group-and-Source-Specific Query and
+	//      there is a pending response for this group with a non-empty
+	//      source-list, then the group source list is augmented to contain the
+	//      list of sources in the new Query and a single response is scheduled
+	//      using the group timer.  The new response is scheduled to be sent at
+	//      the earliest of the remaining time for the pending report and the
+	//      selected delay.
+	pending, ok := igmp.mu.groupQueries[groupAddress]
+	if !ok {
+		pending = &igmpPendingGroupQuery{}
+		if len(sources) != 0 {
+			pending.sources = make(map[tcpip.Address]struct{})
+		}
+		pending.job = igmp.ep.protocol.stack.NewJob(&igmp.mu, func() {
+			igmp.sendGroupQueryResponseLocked(groupAddress)
+		})
+		pending.job.Schedule(delay)
+		igmp.mu.groupQueries[groupAddress] = pending
+	} else if len(sources) == 0 {
+		pending.sources = nil
+	}
+	if pending.sources != nil {
+		for _, source := range sources {
+			pending.sources[source] = struct{}{}
+		}
+	}
+	return true
+}
+
+// calculateDelay returns a random delay in the range [0, maxRespTime).
+func (igmp *igmpState) calculateDelay(maxRespTime time.Duration) time.Duration {
+	if maxRespTime == 0 {
+		return 0
+	}
+	return time.Duration(igmp.ep.protocol.stack.Rand().Int63n(int64(maxRespTime)))
+}
+
+// cancelV3QueryResponsesLocked cancels all pending responses to IGMPv3
+// queries.
+//
+// As per RFC 3376 section 7.2.1, "Whenever a host changes its compatibility
+// mode, it cancels all its pending response and retransmission timers."
+//
+// Precondition: igmp.mu must be locked.
+func (igmp *igmpState) cancelV3QueryResponsesLocked() {
+	igmp.mu.generalQueryJob.Cancel()
+	igmp.mu.generalQueryPending = false
+	for groupAddress, pending := range igmp.mu.groupQueries {
+		pending.job.Cancel()
+		delete(igmp.mu.groupQueries, groupAddress)
+	}
+}
+
+// sendGeneralQueryResponse sends a report holding a Current-State Record for
+// each joined group.
+func (igmp *igmpState) sendGeneralQueryResponse() {
+	igmp.sourceFilters.Lock()
+	groupAddresses := make([]tcpip.Address, 0, len(igmp.sourceFilters.groups))
+	for groupAddress := range igmp.sourceFilters.groups {
+		// The all-systems group is never reported, as per RFC 3376 section 5.
+		if groupAddress != header.IPv4AllSystems {
+			groupAddresses = append(groupAddresses, groupAddress)
+		}
+	}
+	sortAddresses(groupAddresses)
+	records := make([]header.IGMPv3ReportGroupAddressRecordSerializer, 0, len(groupAddresses))
+	for _, groupAddress := range groupAddresses {
+		if record, ok := currentStateRecord(groupAddress, igmp.sourceFilters.groups[groupAddress].state(), nil /* querySources */); ok {
+			records = append(records, record)
+		}
+	}
+	igmp.sourceFilters.Unlock()
+
+	// Note, the report is not split into multiple reports if it exceeds the
+	// interface's MTU as RFC 3376 section 5.2 suggests; hosts are not expected
+	// to join enough groups for this to matter.
+	_ = igmp.writeV3Report(records)
+}
+
+// sendGroupQueryResponseLocked sends the pending response to an IGMPv3
+// group-specific or group-and-source-specific query.
+//
+// Precondition: igmp.mu must be locked.
+func (igmp *igmpState) sendGroupQueryResponseLocked(groupAddress tcpip.Address) {
+	pending, ok := igmp.mu.groupQueries[groupAddress]
+	if !ok {
+		panic(fmt.Sprintf("expected to find pending query for group = %s", groupAddress))
+	}
+	delete(igmp.mu.groupQueries, groupAddress)
+
+	if !igmp.mu.genericMulticastProtocol.IsLocallyJoined(groupAddress) {
+		return
+	}
+	if record, ok := currentStateRecord(groupAddress, igmp.filterState(groupAddress), pending.sources); ok {
+		_ = igmp.writeV3Report([]header.IGMPv3ReportGroupAddressRecordSerializer{record})
+	}
+}
+
 func (igmp *igmpState) handleMembershipReport(groupAddress tcpip.Address) {
 	igmp.mu.Lock()
 	defer igmp.mu.Unlock()
+
+	// As per RFC 3376 section 7.2.1, hosts in IGMPv3 compatibility mode do not
+	// perform report suppression.
+	if igmp.v3Mode() {
+		return
+	}
 	igmp.mu.genericMulticastProtocol.HandleReport(groupAddress)
 }
 
-// writePacket assembles and sends an IGMP packet with the provided fields,
-// incrementing the provided stat counter on success.
+// filterState returns the interface-level filter state of a group.
+func (igmp *igmpState) filterState(groupAddress tcpip.Address) igmpFilterState {
+	igmp.sourceFilters.Lock()
+	defer igmp.sourceFilters.Unlock()
+	if f, ok := igmp.sourceFilters.groups[groupAddress]; ok {
+		return f.state()
+	}
+	return igmpFilterState{}
+}
+
+// maybeSendStateChangeLocked sends a State-Change Report for a group that
+// remains joined but whose filter state changed, as per RFC 3376 section 5.1.
+//
+// Precondition: igmp.mu must be locked.
+func (igmp *igmpState) maybeSendStateChangeLocked(groupAddress tcpip.Address, oldState, newState igmpFilterState) {
+	if !igmp.opts.Enabled || !igmp.ep.Enabled() || !igmp.v3Mode() || groupAddress == header.IPv4AllSystems {
+		return
+	}
+
+	// TODO(gvisor.dev/issue/4901): Retransmit State-Change Reports.
+	_ = igmp.writeV3Report(stateChangeRecords(groupAddress, oldState, newState))
+}
+
+// writeV3Report assembles and sends an IGMPv3 Membership Report holding the
+// provided records.
+//
+// Nothing is sent if there are no records.
+func (igmp *igmpState) writeV3Report(records []header.IGMPv3ReportGroupAddressRecordSerializer) *tcpip.Error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	serializer := header.IGMPv3ReportSerializer{Records: records}
+	igmpData := header.IGMP(buffer.NewView(serializer.Length()))
+	serializer.SerializeInto(igmpData)
+	igmpData.SetChecksum(header.IGMPCalculateChecksum(igmpData))
+	return igmp.sendPacket(header.IGMPv3RoutersAddress, igmpData)
+}
+
+// writePacket assembles and sends an IGMP packet with the provided fields.
 func (igmp *igmpState) writePacket(destAddress tcpip.Address, groupAddress tcpip.Address, igmpType header.IGMPType) *tcpip.Error {
 	igmpData := header.IGMP(buffer.NewView(header.IGMPReportMinimumSize))
 	igmpData.SetType(igmpType)
 	igmpData.SetGroupAddress(groupAddress)
 	igmpData.SetChecksum(header.IGMPCalculateChecksum(igmpData))
+	return igmp.sendPacket(destAddress, igmpData)
+}
 
+// sendPacket sends an IGMP message, incrementing the stat counter for its type
+// on success.
+func (igmp *igmpState) sendPacket(destAddress tcpip.Address, igmpData header.IGMP) *tcpip.Error {
 	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
 		ReserveHeaderBytes: int(igmp.ep.MaxHeaderLength()),
 		Data:               buffer.View(igmpData).ToVectorisedView(),
@@ -259,11 +797,13 @@ func (igmp *igmpState) writePacket(destAddress tcpip.Address, groupAddress tcpip
 		sent.Dropped.Increment()
 		return err
 	}
-	switch igmpType {
+	switch igmpType := igmpData.Type(); igmpType {
 	case header.IGMPv1MembershipReport:
 		sent.V1MembershipReport.Increment()
 	case header.IGMPv2MembershipReport:
 		sent.V2MembershipReport.Increment()
+	case header.IGMPv3MembershipReport:
+		sent.V3MembershipReport.Increment()
 	case header.IGMPLeaveGroup:
 		sent.LeaveGroup.Increment()
 	default:
@@ -279,8 +819,36 @@ func (igmp *igmpState) writePacket(destAddress tcpip.Address, groupAddress tcpip
 // If the group already exists in the membership map, returns
 // tcpip.ErrDuplicateAddress.
 func (igmp *igmpState) joinGroup(groupAddress tcpip.Address) {
+	igmp.join(groupAddress, "" /* source */)
+}
+
+// joinSourceGroup is like joinGroup but only accepts traffic for the group
+// from the specified source.
+func (igmp *igmpState) joinSourceGroup(groupAddress, source tcpip.Address) {
+	igmp.join(groupAddress, source)
+}
+
+// join records a membership of the group for the source, or for any source if
+// source is empty.
+func (igmp *igmpState) join(groupAddress, source tcpip.Address) {
 	igmp.mu.Lock()
 	defer igmp.mu.Unlock()
+
+	igmp.sourceFilters.Lock()
+	f, joined := igmp.sourceFilters.groups[groupAddress]
+	if !joined {
+		f = &igmpSourceFilter{sources: make(map[tcpip.Address]uint64)}
+		igmp.sourceFilters.groups[groupAddress] = f
+	}
+	oldState := f.state()
+	f.add(source)
+	newState := f.state()
+	igmp.sourceFilters.Unlock()
+
+	// A newly joined group is reported by the generic multicast protocol.
+	if joined {
+		igmp.maybeSendStateChangeLocked(groupAddress, oldState, newState)
+	}
 	igmp.mu.genericMulticastProtocol.JoinGroup(groupAddress, !igmp.ep.Enabled() /* dontInitialize */)
 }
 
@@ -295,15 +863,60 @@ func (igmp *igmpState) isInGroup(groupAddress tcpip.Address) bool {
 // delay timers associated with that group, and sends the Leave Group message
 // if required.
 func (igmp *igmpState) leaveGroup(groupAddress tcpip.Address) *tcpip.Error {
+	return igmp.leave(groupAddress, "" /* source */)
+}
+
+// leaveSourceGroup is like leaveGroup but for a source-specific membership.
+func (igmp *igmpState) leaveSourceGroup(groupAddress, source tcpip.Address) *tcpip.Error {
+	return igmp.leave(groupAddress, source)
+}
+
+// leave removes a membership of the group for the source, or for any source if
+// source is empty.
+func (igmp *igmpState) leave(groupAddress, source tcpip.Address) *tcpip.Error {
 	igmp.mu.Lock()
 	defer igmp.mu.Unlock()
 
+	igmp.sourceFilters.Lock()
+	f, ok := igmp.sourceFilters.groups[groupAddress]
+	if !ok {
+		igmp.sourceFilters.Unlock()
+		return tcpip.ErrBadLocalAddress
+	}
+	oldState := f.state()
+	if !f.remove(source) {
+		igmp.sourceFilters.Unlock()
+		return tcpip.ErrBadLocalAddress
+	}
+	last := f.joins() == 0
+	if last {
+		// Restore the filter so the generic multicast protocol reports the
+		// group's last state when leaving it below.
+		f.add(source)
+	}
+	newState := f.state()
+	igmp.sourceFilters.Unlock()
+
+	if !last {
+		igmp.maybeSendStateChangeLocked(groupAddress, oldState, newState)
+	}
+
 	// LeaveGroup returns false only if the group was not joined.
-	if igmp.mu.genericMulticastProtocol.LeaveGroup(groupAddress) {
-		return nil
+	if !igmp.mu.genericMulticastProtocol.LeaveGroup(groupAddress) {
+		panic(fmt.Sprintf("expected group %s to be joined", groupAddress))
 	}
 
-	return tcpip.ErrBadLocalAddress
+	if last {
+		igmp.sourceFilters.Lock()
+		delete(igmp.sourceFilters.groups, groupAddress)
+		igmp.sourceFilters.Unlock()
+
+		if pending, ok := igmp.mu.groupQueries[groupAddress]; ok {
+			pending.job.Cancel()
+			delete(igmp.mu.groupQueries, groupAddress)
+		}
+	}
+	return nil
 }
 
 // softLeaveAll leaves all groups from the perspective of IGMP, but remains
@@ -311,6 +924,7 @@ func (igmp *igmpState) leaveGroup(groupAddress tcpip.Address) *tcpip.Error {
 func (igmp *igmpState) softLeaveAll() {
 	igmp.mu.Lock()
 	defer igmp.mu.Unlock()
+	igmp.cancelV3QueryResponsesLocked()
 	igmp.mu.genericMulticastProtocol.MakeAllNonMember()
 }
 
@@ -16,7 +16,9 @@ package ipv4_test
 
 import (
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip/checker"
@@ -154,3 +156,360 @@ func TestIgmpV1Present(t *testing.T) {
 	}
 	validateIgmpPacket(t, p, multicastAddr, header.IGMPv1MembershipReport, 0, multicastAddr)
 }
+
+const (
+	v3GroupAddr1  = tcpip.Address("\xe0\x00\x00\x04")
+	v3GroupAddr2  = tcpip.Address("\xe0\x00\x00\x05")
+	v3SourceAddr1 = tcpip.Address("\x0a\x00\x00\x01")
+	v3SourceAddr2 = tcpip.Address("\x0a\x00\x00\x02")
+	v3SourceAddr3 = tcpip.Address("\x0a\x00\x00\x03")
+)
+
+func createV3Stack(t *testing.T) (*channel.Endpoint, *stack.Stack, *faketime.ManualClock) {
+	t.Helper()
+
+	e := channel.New(10, 1280, linkAddr)
+	clock := faketime.NewManualClock()
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocolWithOptions(ipv4.Options{
+			IGMP: ipv4.IGMPOptions{
+				Enabled:  true,
+				EnableV3: true,
+			},
+		})},
+		Clock: clock,
+	})
+	if err := s.CreateNIC(nicID, e); err != nil {
+		t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+	}
+
+	return e, s, clock
+}
+
+// readIGMPv3Report reads an IGMPv3 report from e and returns its records.
+func readIGMPv3Report(t *testing.T, e *channel.Endpoint) []header.IGMPv3ReportGroupAddressRecordSerializer {
+	t.Helper()
+
+	p, ok := e.Read()
+	if !ok {
+		t.Fatal("expected an IGMPv3 report to be sent")
+	}
+	ip := header.IPv4(stack.PayloadSince(p.Pkt.NetworkHeader()))
+	checker.IPv4(t, ip,
+		checker.DstAddr(header.IGMPv3RoutersAddress),
+		checker.TTL(header.IGMPTTL),
+		checker.IPv4RouterAlert(),
+	)
+	report := header.IGMPv3Report(ip.Payload())
+	if got := header.IGMP(report).Type(); got != header.IGMPv3MembershipReport {
+		t.Fatalf("got IGMP type = %x, want = %x", got, header.IGMPv3MembershipReport)
+	}
+	records, ok := report.GroupRecords()
+	if !ok {
+		t.Fatal("malformed IGMPv3 report")
+	}
+	return records
+}
+
+func createAndInjectIGMPv3Query(e *channel.Endpoint, maxRespCode uint8, groupAddress tcpip.Address, sources []tcpip.Address) {
+	queryLen := header.IGMPv3QueryMinimumSize + len(sources)*header.IPv4AddressSize
+	buf := buffer.NewView(header.IPv4MinimumSize + queryLen)
+
+	ip := header.IPv4(buf)
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(buf)),
+		TTL:         1,
+		Protocol:    uint8(header.IGMPProtocolNumber),
+		SrcAddr:     header.IPv4Any,
+		DstAddr:     header.IPv4AllSystems,
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+
+	igmp := header.IGMP(buf[header.IPv4MinimumSize:])
+	igmp.SetType(header.IGMPMembershipQuery)
+	igmp.SetMaxRespTime(maxRespCode)
+	igmp.SetGroupAddress(groupAddress)
+	// Number of sources.
+	igmp[10] = byte(len(sources) >> 8)
+	igmp[11] = byte(len(sources))
+	for i, source := range sources {
+		copy(igmp[header.IGMPv3QueryMinimumSize+i*header.IPv4AddressSize:], source)
+	}
+	igmp.SetChecksum(header.IGMPCalculateChecksum(igmp))
+
+	e.InjectInbound(ipv4.ProtocolNumber, &stack.PacketBuffer{
+		Data: buf.ToVectorisedView(),
+	})
+}
+
+// TestIGMPv3StateChangeReports tests that IGMPv3 State-Change Reports are sent
+// when the filter state of a group changes.
+func TestIGMPv3StateChangeReports(t *testing.T) {
+	e, s, clock := createV3Stack(t)
+
+	// expectReport checks that a report with the wanted records was sent.
+	//
+	// If repeated is true, the unsolicited report for a newly joined group is
+	// expected to be repeated after a delay.
+	expectReport := func(want []header.IGMPv3ReportGroupAddressRecordSerializer, repeated bool) {
+		t.Helper()
+
+		if diff := cmp.Diff(want, readIGMPv3Report(t, e)); diff != "" {
+			t.Errorf("report records mismatch (-want +got):\n%s", diff)
+		}
+		if repeated {
+			clock.Advance(ipv4.UnsolicitedReportIntervalMax)
+			if diff := cmp.Diff(want, readIGMPv3Report(t, e)); diff != "" {
+				t.Errorf("repeated report records mismatch (-want +got):\n%s", diff)
+			}
+		}
+		if p, ok := e.Read(); ok {
+			t.Fatalf("got unexpected packet = %#v", p)
+		}
+	}
+
+	if err := s.JoinGroup(ipv4.ProtocolNumber, nicID, v3GroupAddr1); err != nil {
+		t.Fatalf("JoinGroup(ipv4, %d, %s): %s", nicID, v3GroupAddr1, err)
+	}
+	expectReport([]header.IGMPv3ReportGroupAddressRecordSerializer{{
+		RecordType:   header.IGMPv3ReportRecordChangeToExcludeMode,
+		GroupAddress: v3GroupAddr1,
+	}}, true /* repeated */)
+
+	if err := s.JoinSourceGroup(ipv4.ProtocolNumber, nicID, v3GroupAddr2, v3SourceAddr1); err != nil {
+		t.Fatalf("JoinSourceGroup(ipv4, %d, %s, %s): %s", nicID, v3GroupAddr2, v3SourceAddr1, err)
+	}
+	expectReport([]header.IGMPv3ReportGroupAddressRecordSerializer{{
+		RecordType:   header.IGMPv3ReportRecordAllowNewSources,
+		GroupAddress: v3GroupAddr2,
+		Sources:      []tcpip.Address{v3SourceAddr1},
+	}}, true /* repeated */)
+
+	if err := s.JoinSourceGroup(ipv4.ProtocolNumber, nicID, v3GroupAddr2, v3SourceAddr2); err != nil {
+		t.Fatalf("JoinSourceGroup(ipv4, %d, %s, %s): %s", nicID, v3GroupAddr2, v3SourceAddr2, err)
+	}
+	expectReport([]header.IGMPv3ReportGroupAddressRecordSerializer{{
+		RecordType:   header.IGMPv3ReportRecordAllowNewSources,
+		GroupAddress: v3GroupAddr2,
+		Sources:      []tcpip.Address{v3SourceAddr2},
+	}}, false /* repeated */)
+
+	if err := s.LeaveSourceGroup(ipv4.ProtocolNumber, nicID, v3GroupAddr2, v3SourceAddr1); err != nil {
+		t.Fatalf("LeaveSourceGroup(ipv4, %d, %s, %s): %s", nicID, v3GroupAddr2, v3SourceAddr1, err)
+	}
+	expectReport([]header.IGMPv3ReportGroupAddressRecordSerializer{{
+		RecordType:   header.IGMPv3ReportRecordBlockOldSources,
+		GroupAddress: v3GroupAddr2,
+		Sources:      []tcpip.Address{v3SourceAddr1},
+	}}, false /* repeated */)
+	if err := s.LeaveSourceGroup(ipv4.ProtocolNumber, nicID, v3GroupAddr2, v3SourceAddr1); err != tcpip.ErrBadLocalAddress {
+		t.Errorf("got LeaveSourceGroup(ipv4, %d, %s, %s) = %s, want = %s", nicID, v3GroupAddr2, v3SourceAddr1, err, tcpip.ErrBadLocalAddress)
+	}
+
+	if err := s.JoinGroup(ipv4.ProtocolNumber, nicID, v3GroupAddr2); err != nil {
+		t.Fatalf("JoinGroup(ipv4, %d, %s): %s", nicID, v3GroupAddr2, err)
+	}
+	expectReport([]header.IGMPv3ReportGroupAddressRecordSerializer{{
+		RecordType:   header.IGMPv3ReportRecordChangeToExcludeMode,
+		GroupAddress: v3GroupAddr2,
+	}}, false /* repeated */)
+
+	if err := s.LeaveGroup(ipv4.ProtocolNumber, nicID, v3GroupAddr2); err != nil {
+		t.Fatalf("LeaveGroup(ipv4, %d, %s): %s", nicID, v3GroupAddr2, err)
+	}
+	expectReport([]header.IGMPv3ReportGroupAddressRecordSerializer{{
+		RecordType:   header.IGMPv3ReportRecordChangeToIncludeMode,
+		GroupAddress: v3GroupAddr2,
+		Sources:      []tcpip.Address{v3SourceAddr2},
+	}}, false /* repeated */)
+
+	if err := s.LeaveSourceGroup(ipv4.ProtocolNumber, nicID, v3GroupAddr2, v3SourceAddr2); err != nil {
+		t.Fatalf("LeaveSourceGroup(ipv4, %d, %s, %s): %s", nicID, v3GroupAddr2, v3SourceAddr2, err)
+	}
+	expectReport([]header.IGMPv3ReportGroupAddressRecordSerializer{{
+		RecordType:   header.IGMPv3ReportRecordBlockOldSources,
+		GroupAddress: v3GroupAddr2,
+		Sources:      []tcpip.Address{v3SourceAddr2},
+	}}, false /* repeated */)
+	if got, err := s.IsInGroup(nicID, v3GroupAddr2); err != nil || got {
+		t.Errorf("got s.IsInGroup(%d, %s) = (%t, %s), want = (false, nil)", nicID, v3GroupAddr2, got, err)
+	}
+
+	if err := s.LeaveGroup(ipv4.ProtocolNumber, nicID, v3GroupAddr1); err != nil {
+		t.Fatalf("LeaveGroup(ipv4, %d, %s): %s", nicID, v3GroupAddr1, err)
+	}
+	expectReport([]header.IGMPv3ReportGroupAddressRecordSerializer{{
+		RecordType:   header.IGMPv3ReportRecordChangeToIncludeMode,
+		GroupAddress: v3GroupAddr1,
+	}}, false /* repeated */)
+
+	if got, want := s.Stats().IGMP.PacketsSent.V3MembershipReport.Value(), uint64(10); got != want {
+		t.Errorf("got V3MembershipReport messages sent = %d, want = %d", got, want)
+	}
+}
+
+// TestIGMPv3QueryResponses tests that IGMPv3 queries are responded to with
+// Current-State Records.
+func TestIGMPv3QueryResponses(t *testing.T) {
+	const maxRespCode = 10
+	maxRespTime := header.IGMPv3MaximumResponseDelay(maxRespCode)
+
+	e, s, clock := createV3Stack(t)
+
+	if err := s.JoinGroup(ipv4.ProtocolNumber, nicID, v3GroupAddr1); err != nil {
+		t.Fatalf("JoinGroup(ipv4, %d, %s): %s", nicID, v3GroupAddr1, err)
+	}
+	if err := s.JoinSourceGroup(ipv4.ProtocolNumber, nicID, v3GroupAddr2, v3SourceAddr1); err != nil {
+		t.Fatalf("JoinSourceGroup(ipv4, %d, %s, %s): %s", nicID, v3GroupAddr2, v3SourceAddr1, err)
+	}
+	clock.Advance(ipv4.UnsolicitedReportIntervalMax)
+	e.Drain()
+
+	tests := []struct {
+		name         string
+		groupAddress tcpip.Address
+		sources      []tcpip.Address
+		want         []header.IGMPv3ReportGroupAddressRecordSerializer
+	}{
+		{
+			name:         "general",
+			groupAddress: header.IPv4Any,
+			want: []header.IGMPv3ReportGroupAddressRecordSerializer{
+				{
+					RecordType:   header.IGMPv3ReportRecordModeIsExclude,
+					GroupAddress: v3GroupAddr1,
+				},
+				{
+					RecordType:   header.IGMPv3ReportRecordModeIsInclude,
+					GroupAddress: v3GroupAddr2,
+					Sources:      []tcpip.Address{v3SourceAddr1},
+				},
+			},
+		},
+		{
+			name:         "group-specific",
+			groupAddress: v3GroupAddr2,
+			want: []header.IGMPv3ReportGroupAddressRecordSerializer{{
+				RecordType:   header.IGMPv3ReportRecordModeIsInclude,
+				GroupAddress: v3GroupAddr2,
+				Sources:      []tcpip.Address{v3SourceAddr1},
+			}},
+		},
+		{
+			name:         "group-and-source-specific include mode",
+			groupAddress: v3GroupAddr2,
+			sources:      []tcpip.Address{v3SourceAddr3, v3SourceAddr1},
+			want: []header.IGMPv3ReportGroupAddressRecordSerializer{{
+				RecordType:   header.IGMPv3ReportRecordModeIsInclude,
+				GroupAddress: v3GroupAddr2,
+				Sources:      []tcpip.Address{v3SourceAddr1},
+			}},
+		},
+		{
+			name:         "group-and-source-specific exclude mode",
+			groupAddress: v3GroupAddr1,
+			sources:      []tcpip.Address{v3SourceAddr3},
+			want: []header.IGMPv3ReportGroupAddressRecordSerializer{{
+				RecordType:   header.IGMPv3ReportRecordModeIsInclude,
+				GroupAddress: v3GroupAddr1,
+				Sources:      []tcpip.Address{v3SourceAddr3},
+			}},
+		},
+		{
+			name:         "group-and-source-specific no match",
+			groupAddress: v3GroupAddr2,
+			sources:      []tcpip.Address{v3SourceAddr3},
+		},
+		{
+			name:         "group not joined",
+			groupAddress: multicastAddr,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			createAndInjectIGMPv3Query(e, maxRespCode, test.groupAddress, test.sources)
+			clock.Advance(maxRespTime)
+
+			if len(test.want) == 0 {
+				if p, ok := e.Read(); ok {
+					t.Fatalf("got unexpected packet = %#v", p)
+				}
+				return
+			}
+			if diff := cmp.Diff(test.want, readIGMPv3Report(t, e)); diff != "" {
+				t.Errorf("report records mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestIGMPv3Fallback tests that hosts fall back to older versions of IGMP when
+// an older version querier is present.
+func TestIGMPv3Fallback(t *testing.T) {
+	const v2RouterPresentTimeout = 260 * time.Second
+
+	tests := []struct {
+		name        string
+		maxRespTime byte
+		wantType    header.IGMPType
+		timeout     time.Duration
+	}{
+		{
+			name:        "IGMPv2",
+			maxRespTime: 1,
+			wantType:    header.IGMPv2MembershipReport,
+			timeout:     v2RouterPresentTimeout,
+		},
+		{
+			name:        "IGMPv1",
+			maxRespTime: 0,
+			wantType:    header.IGMPv1MembershipReport,
+			timeout:     400 * time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, s, clock := createV3Stack(t)
+
+			createAndInjectIGMPPacket(e, header.IGMPMembershipQuery, test.maxRespTime, header.IPv4Any)
+
+			if err := s.JoinGroup(ipv4.ProtocolNumber, nicID, v3GroupAddr1); err != nil {
+				t.Fatalf("JoinGroup(ipv4, %d, %s): %s", nicID, v3GroupAddr1, err)
+			}
+			p, ok := e.Read()
+			if !ok {
+				t.Fatal("expected a report to be sent")
+			}
+			validateIgmpPacket(t, p, v3GroupAddr1, test.wantType, 0, v3GroupAddr1)
+			clock.Advance(ipv4.UnsolicitedReportIntervalMax)
+			e.Drain()
+
+			// IGMPv3 queries are answered with older version reports while the
+			// older version querier is present.
+			createAndInjectIGMPv3Query(e, 10 /* maxRespCode */, v3GroupAddr1, nil /* sources */)
+			clock.Advance(header.IGMPv3MaximumResponseDelay(10))
+			p, ok = e.Read()
+			if !ok {
+				t.Fatal("expected a report to be sent")
+			}
+			validateIgmpPacket(t, p, v3GroupAddr1, test.wantType, 0, v3GroupAddr1)
+
+			// Once the older version querier is no longer present, IGMPv3 reports
+			// are sent.
+			clock.Advance(test.timeout)
+			e.Drain()
+			if err := s.JoinGroup(ipv4.ProtocolNumber, nicID, v3GroupAddr2); err != nil {
+				t.Fatalf("JoinGroup(ipv4, %d, %s): %s", nicID, v3GroupAddr2, err)
+			}
+			want := []header.IGMPv3ReportGroupAddressRecordSerializer{{
+				RecordType:   header.IGMPv3ReportRecordChangeToExcludeMode,
+				GroupAddress: v3GroupAddr2,
+			}}
+			if diff := cmp.Diff(want, readIGMPv3Report(t, e)); diff != "" {
+				t.Errorf("report records mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
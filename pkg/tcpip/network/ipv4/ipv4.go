@@ -29,6 +29,7 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip/header/parse"
 	"gvisor.dev/gvisor/pkg/tcpip/network/fragmentation"
 	"gvisor.dev/gvisor/pkg/tcpip/network/hash"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ip"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
 
@@ -65,6 +66,7 @@ const (
 var ipv4BroadcastAddr = header.IPv4Broadcast.WithPrefix()
 
 var _ stack.GroupAddressableEndpoint = (*endpoint)(nil)
+var _ stack.SourceGroupAddressableEndpoint = (*endpoint)(nil)
 var _ stack.AddressableEndpoint = (*endpoint)(nil)
 var _ stack.NetworkEndpoint = (*endpoint)(nil)
 
@@ -74,12 +76,28 @@ type endpoint struct {
 	protocol   *protocol
 	igmp       igmpState
 
+	icmpRateLimits icmpRateLimitState
+
+	// reassemblyStats holds the fragment reassembly statistics for this
+	// endpoint.
+	reassemblyStats fragmentation.Stats
+
 	// enabled is set to 1 when the enpoint is enabled and 0 when it is
 	// disabled.
 	//
 	// Must be accessed using atomic operations.
 	enabled uint32
 
+	// multicastForwarding is set to 1 when the endpoint has multicast
+	// forwarding enabled and 0 when it is disabled.
+	//
+	// Must be accessed using atomic operations.
+	multicastForwarding uint32
+
+	// forwardingRoutes caches the routes of the flows of the packets
+	// forwarded by the endpoint.
+	forwardingRoutes stack.ForwardingRouteCache
+
 	mu struct {
 		sync.RWMutex
 
@@ -96,6 +114,7 @@ func (p *protocol) NewEndpoint(nic stack.NetworkInterface, _ stack.LinkAddressCa
 	}
 	e.mu.addressableEndpointState.Init(e)
 	e.igmp.init(e, p.options.IGMP)
+	e.icmpRateLimits.init(p.stack.Clock(), p.options.ICMPRateLimits)
 	return e
 }
 
@@ -204,6 +223,13 @@ func (e *endpoint) MTU() uint32 {
 	return networkMTU
 }
 
+var _ fragmentation.ReassemblyStatsEndpoint = (*endpoint)(nil)
+
+// ReassemblyStats implements fragmentation.ReassemblyStatsEndpoint.
+func (e *endpoint) ReassemblyStats() *fragmentation.Stats {
+	return &e.reassemblyStats
+}
+
 // MaxHeaderLength returns the maximum length needed by ipv4 headers (and
 // underlying protocols).
 func (e *endpoint) MaxHeaderLength() uint16 {
@@ -231,12 +257,18 @@ func (e *endpoint) addIPHeader(srcAddr, dstAddr tcpip.Address, pkt *stack.Packet
 	ip := header.IPv4(pkt.NetworkHeader().Push(hdrLen))
 	length := uint16(pkt.Size())
 	// RFC 6864 section 4.3 mandates uniqueness of ID values for non-atomic
-	// datagrams. Since the DF bit is never being set here, all datagrams
-	// are non-atomic and need an ID.
+	// datagrams. The DF bit is only set on path MTU probes and when the sending
+	// endpoint does path MTU discovery so most datagrams are non-atomic and
+	// need an ID; atomic datagrams get one too for simplicity.
 	id := atomic.AddUint32(&e.protocol.ids[hashRoute(srcAddr, dstAddr, params.Protocol, e.protocol.hashIV)%buckets], 1)
+	var flags uint8
+	if params.PathMTUProbe || params.DontFragment {
+		flags = header.IPv4FlagDontFragment
+	}
 	ip.Encode(&header.IPv4Fields{
 		TotalLength: length,
 		ID:          uint16(id),
+		Flags:       flags,
 		TTL:         params.TTL,
 		TOS:         params.TOS,
 		Protocol:    uint8(params.Protocol),
@@ -306,10 +338,10 @@ func (e *endpoint) WritePacket(r *stack.Route, gso *stack.GSO, params stack.Netw
 		}
 	}
 
-	return e.writePacket(r, gso, pkt, false /* headerIncluded */)
+	return e.writePacket(r, gso, pkt, false /* headerIncluded */, params.IgnorePathMTU)
 }
 
-func (e *endpoint) writePacket(r *stack.Route, gso *stack.GSO, pkt *stack.PacketBuffer, headerIncluded bool) *tcpip.Error {
+func (e *endpoint) writePacket(r *stack.Route, gso *stack.GSO, pkt *stack.PacketBuffer, headerIncluded, ignorePathMTU bool) *tcpip.Error {
 	if r.Loop&stack.PacketLoop != 0 {
 		pkt := pkt.CloneToInbound()
 		if e.protocol.stack.ParsePacketBuffer(ProtocolNumber, pkt) == stack.ParsedOK {
@@ -324,13 +356,17 @@ func (e *endpoint) writePacket(r *stack.Route, gso *stack.GSO, pkt *stack.Packet
 		return nil
 	}
 
-	networkMTU, err := calculateNetworkMTU(e.nic.MTU(), uint32(pkt.NetworkHeader().View().Size()))
+	networkMTU, err := calculateNetworkMTU(e.pathLinkMTU(r, pkt, ignorePathMTU), uint32(pkt.NetworkHeader().View().Size()))
 	if err != nil {
 		r.Stats().IP.OutgoingPacketErrors.Increment()
 		return err
 	}
 
 	if packetMustBeFragmented(pkt, networkMTU, gso) {
+		if dontFragment(pkt) {
+			r.Stats().IP.OutgoingPacketErrors.Increment()
+			return tcpip.ErrMessageTooLong
+		}
 		sent, remain, err := e.handleFragments(r, gso, networkMTU, pkt, func(fragPkt *stack.PacketBuffer) *tcpip.Error {
 			// TODO(gvisor.dev/issue/3884): Evaluate whether we want to send each
 			// fragment one by one using WritePacket() (current strategy) or if we
@@ -351,6 +387,29 @@ func (e *endpoint) writePacket(r *stack.Route, gso *stack.GSO, pkt *stack.Packet
 	return nil
 }
 
+// pathLinkMTU returns the MTU that pkt must fit in to reach r's destination:
+// the path MTU if it is known and smaller than the NIC's MTU, or the NIC's MTU
+// otherwise.
+//
+// Packets with the Don't Fragment flag set, such as path MTU probes, and
+// packets sent by endpoints ignoring the path MTU are not limited by the path
+// MTU.
+func (e *endpoint) pathLinkMTU(r *stack.Route, pkt *stack.PacketBuffer, ignorePathMTU bool) uint32 {
+	mtu := e.nic.MTU()
+	if ignorePathMTU || dontFragment(pkt) {
+		return mtu
+	}
+	if pmtu, ok := r.PathMTULimit(); ok && pmtu < mtu {
+		mtu = pmtu
+	}
+	return mtu
+}
+
+// dontFragment returns true if pkt has the Don't Fragment flag set.
+func dontFragment(pkt *stack.PacketBuffer) bool {
+	return header.IPv4(pkt.NetworkHeader().View()).Flags()&header.IPv4FlagDontFragment != 0
+}
+
 // WritePackets implements stack.NetworkEndpoint.WritePackets.
 func (e *endpoint) WritePackets(r *stack.Route, gso *stack.GSO, pkts stack.PacketBufferList, params stack.NetworkHeaderParams) (int, *tcpip.Error) {
 	if r.Loop&stack.PacketLoop != 0 {
@@ -362,13 +421,17 @@ func (e *endpoint) WritePackets(r *stack.Route, gso *stack.GSO, pkts stack.Packe
 
 	for pkt := pkts.Front(); pkt != nil; pkt = pkt.Next() {
 		e.addIPHeader(r.LocalAddress, r.RemoteAddress, pkt, params, nil /* options */)
-		networkMTU, err := calculateNetworkMTU(e.nic.MTU(), uint32(pkt.NetworkHeader().View().Size()))
+		networkMTU, err := calculateNetworkMTU(e.pathLinkMTU(r, pkt, params.IgnorePathMTU), uint32(pkt.NetworkHeader().View().Size()))
 		if err != nil {
 			r.Stats().IP.OutgoingPacketErrors.IncrementBy(uint64(pkts.Len()))
 			return 0, err
 		}
 
 		if packetMustBeFragmented(pkt, networkMTU, gso) {
+			if dontFragment(pkt) {
+				r.Stats().IP.OutgoingPacketErrors.IncrementBy(uint64(pkts.Len()))
+				return 0, tcpip.ErrMessageTooLong
+			}
 			// Keep track of the packet that is about to be fragmented so it can be
 			// removed once the fragmentation is done.
 			originalPkt := pkt
@@ -493,7 +556,7 @@ func (e *endpoint) WriteHeaderIncludedPacket(r *stack.Route, pkt *stack.PacketBu
 		return tcpip.ErrMalformedHeader
 	}
 
-	return e.writePacket(r, nil /* gso */, pkt, true /* headerIncluded */)
+	return e.writePacket(r, nil /* gso */, pkt, true /* headerIncluded */, false /* ignorePathMTU */)
 }
 
 // forwardPacket attempts to forward a packet to its final destination.
@@ -521,7 +584,15 @@ func (e *endpoint) forwardPacket(pkt *stack.PacketBuffer) *tcpip.Error {
 		return err
 	}
 
-	r, err := e.protocol.stack.FindRoute(0, "", dstAddr, ProtocolNumber, false /* multicastLoop */)
+	tos, _ := h.TOS()
+	key := stack.RoutePolicyKey{
+		Source:   h.SourceAddress(),
+		InputNIC: e.nic.ID(),
+		Mark:     pkt.Mark,
+		TOS:      tos,
+	}
+	key.SetTransport(pkt)
+	r, err := e.protocol.stack.FindForwardingRoute(&e.forwardingRoutes, dstAddr, ProtocolNumber, key)
 	if err != nil {
 		return err
 	}
@@ -557,6 +628,10 @@ func (e *endpoint) HandlePacket(pkt *stack.PacketBuffer) {
 		return
 	}
 
+	// The rules of the prerouting chain may log the NIC the packet was
+	// received at.
+	pkt.NICID = e.nic.ID()
+
 	// Loopback traffic skips the prerouting chain.
 	if !e.nic.IsLoopback() {
 		if ok := e.protocol.stack.IPTables().Check(stack.Prerouting, pkt, nil, nil, e.MainAddress().Address, ""); !ok {
@@ -628,6 +703,12 @@ func (e *endpoint) handlePacket(pkt *stack.PacketBuffer) {
 		}
 	}
 
+	// Multicast packets may be forwarded through multicast routes in addition
+	// to being delivered locally.
+	if header.IsV4MulticastAddress(dstAddr) {
+		e.forwardMulticastPacket(h, pkt)
+	}
+
 	// The destination address should be an address we own or a group we joined
 	// for us to receive the packet. Otherwise, attempt to forward the packet.
 	if addressEndpoint := e.AcquireAssignedAddress(dstAddr, e.nic.Promiscuous(), stack.CanBePrimaryEndpoint); addressEndpoint != nil {
@@ -635,6 +716,10 @@ func (e *endpoint) handlePacket(pkt *stack.PacketBuffer) {
 		addressEndpoint.DecRef()
 		pkt.NetworkPacketInfo.LocalAddressBroadcast = subnet.IsBroadcast(dstAddr) || dstAddr == header.IPv4Broadcast
 	} else if !e.IsInGroup(dstAddr) {
+		if header.IsV4MulticastAddress(dstAddr) && e.MulticastForwarding() {
+			// The packet was handled by multicast forwarding.
+			return
+		}
 		if !e.protocol.Forwarding() {
 			stats.IP.InvalidDestinationAddressesReceived.Increment()
 			return
@@ -690,8 +775,14 @@ func (e *endpoint) handlePacket(pkt *stack.PacketBuffer) {
 			h.More(),
 			proto,
 			pkt,
+			&e.reassemblyStats,
 		)
 		if err != nil {
+			if errors.Is(err, fragmentation.ErrSourceLimitReached) {
+				// The fragment is well formed; the source simply has too many
+				// reassemblies in progress.
+				return
+			}
 			stats.IP.MalformedPacketsReceived.Increment()
 			stats.IP.MalformedFragmentsReceived.Increment()
 			return
@@ -769,6 +860,7 @@ func (e *endpoint) Close() {
 	defer e.mu.Unlock()
 
 	e.disableLocked()
+	e.forwardingRoutes.Release()
 	e.mu.addressableEndpointState.Cleanup()
 }
 
@@ -861,6 +953,28 @@ func (e *endpoint) leaveGroupLocked(addr tcpip.Address) *tcpip.Error {
 	return e.igmp.leaveGroup(addr)
 }
 
+// JoinSourceGroup implements stack.SourceGroupAddressableEndpoint.
+func (e *endpoint) JoinSourceGroup(addr, source tcpip.Address) *tcpip.Error {
+	if !header.IsV4MulticastAddress(addr) {
+		return tcpip.ErrBadAddress
+	}
+	if len(source) != header.IPv4AddressSize || source == header.IPv4Any || header.IsV4MulticastAddress(source) {
+		return tcpip.ErrBadAddress
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.igmp.joinSourceGroup(addr, source)
+	return nil
+}
+
+// LeaveSourceGroup implements stack.SourceGroupAddressableEndpoint.
+func (e *endpoint) LeaveSourceGroup(addr, source tcpip.Address) *tcpip.Error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.igmp.leaveSourceGroup(addr, source)
+}
+
 // IsInGroup implements stack.GroupAddressableEndpoint.
 func (e *endpoint) IsInGroup(addr tcpip.Address) bool {
 	e.mu.RLock()
@@ -881,12 +995,29 @@ type protocol struct {
 	// Must be accessed using atomic operations.
 	defaultTTL uint32
 
+	// nonLocalBind is set to 1 when sockets may bind to addresses which
+	// aren't assigned to any NIC, and 0 otherwise.
+	//
+	// Must be accessed using atomic operations.
+	nonLocalBind uint32
+
 	// forwarding is set to 1 when the protocol has forwarding enabled and 0
 	// when it is disabled.
 	//
 	// Must be accessed using atomic operations.
 	forwarding uint32
 
+	mu struct {
+		sync.RWMutex
+
+		// multicastForwardingDisp is the multicast forwarding event dispatcher
+		// provided when multicast forwarding was enabled, or nil if multicast
+		// forwarding is disabled.
+		multicastForwardingDisp stack.MulticastForwardingEventDispatcher
+	}
+
+	multicastRouteTable ip.MulticastRouteTable
+
 	ids    []uint32
 	hashIV uint32
 
@@ -922,6 +1053,16 @@ func (p *protocol) SetOption(option tcpip.SettableNetworkProtocolOption) *tcpip.
 	case *tcpip.DefaultTTLOption:
 		p.SetDefaultTTL(uint8(*v))
 		return nil
+	case *tcpip.ReassemblyLimitsOption:
+		p.fragmentation.SetLimits(fragmentation.Limits(*v))
+		return nil
+	case *tcpip.NonLocalBindOption:
+		var b uint32
+		if *v {
+			b = 1
+		}
+		atomic.StoreUint32(&p.nonLocalBind, b)
+		return nil
 	default:
 		return tcpip.ErrUnknownProtocolOption
 	}
@@ -933,6 +1074,12 @@ func (p *protocol) Option(option tcpip.GettableNetworkProtocolOption) *tcpip.Err
 	case *tcpip.DefaultTTLOption:
 		*v = tcpip.DefaultTTLOption(p.DefaultTTL())
 		return nil
+	case *tcpip.ReassemblyLimitsOption:
+		*v = tcpip.ReassemblyLimitsOption(p.fragmentation.Limits())
+		return nil
+	case *tcpip.NonLocalBindOption:
+		*v = atomic.LoadUint32(&p.nonLocalBind) != 0
+		return nil
 	default:
 		return tcpip.ErrUnknownProtocolOption
 	}
@@ -949,7 +1096,9 @@ func (p *protocol) DefaultTTL() uint8 {
 }
 
 // Close implements stack.TransportProtocol.Close.
-func (*protocol) Close() {}
+func (p *protocol) Close() {
+	p.multicastRouteTable.Close()
+}
 
 // Wait implements stack.TransportProtocol.Wait.
 func (*protocol) Wait() {}
@@ -1030,6 +1179,10 @@ func hashRoute(srcAddr, dstAddr tcpip.Address, protocol tcpip.TransportProtocolN
 type Options struct {
 	// IGMP holds options for IGMP.
 	IGMP IGMPOptions
+
+	// ICMPRateLimits is the default limits applied by interfaces to the rate at
+	// which they generate ICMPv4 errors.
+	ICMPRateLimits ICMPRateLimits
 }
 
 // NewProtocolWithOptions returns an IPv4 network protocol.
@@ -1052,6 +1205,9 @@ func NewProtocolWithOptions(opts Options) stack.NetworkProtocolFactory {
 			options:    opts,
 		}
 		p.fragmentation = fragmentation.NewFragmentation(fragmentblockSize, fragmentation.HighFragThreshold, fragmentation.LowFragThreshold, ReassembleTimeout, s.Clock(), p)
+		if err := p.multicastRouteTable.Init(ip.DefaultMulticastRouteTableConfig(s.Clock())); err != nil {
+			panic(fmt.Sprintf("p.multicastRouteTable.Init(_): %s", err))
+		}
 		return p
 	}
 }
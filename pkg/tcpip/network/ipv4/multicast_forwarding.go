@@ -0,0 +1,268 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv4
+
+import (
+	"sync/atomic"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+var _ stack.MulticastForwardingNetworkProtocol = (*protocol)(nil)
+var _ stack.MulticastForwardingNetworkEndpoint = (*endpoint)(nil)
+
+// MulticastForwarding implements stack.MulticastForwardingNetworkEndpoint.
+func (e *endpoint) MulticastForwarding() bool {
+	return atomic.LoadUint32(&e.multicastForwarding) == 1
+}
+
+// SetMulticastForwarding implements stack.MulticastForwardingNetworkEndpoint.
+func (e *endpoint) SetMulticastForwarding(forwarding bool) {
+	var v uint32
+	if forwarding {
+		v = 1
+	}
+	atomic.StoreUint32(&e.multicastForwarding, v)
+}
+
+// isForwardableMulticastSource returns true if packets sent from addr may be
+// forwarded through multicast routes.
+//
+// As per RFC 3927 section 2.7, routers must not forward packets with
+// link-local source addresses.
+func isForwardableMulticastSource(addr tcpip.Address) bool {
+	return len(addr) == header.IPv4AddressSize &&
+		addr != header.IPv4Any &&
+		addr != header.IPv4Broadcast &&
+		!header.IsV4MulticastAddress(addr) &&
+		!header.IsV4LoopbackAddress(addr) &&
+		!header.IsV4LinkLocalAddress(addr)
+}
+
+// isForwardableMulticastDestination returns true if packets sent to addr may
+// be forwarded through multicast routes.
+//
+// As per RFC 5771 section 4, packets destined to the Local Network Control
+// Block (224.0.0.0/24) must not be forwarded.
+func isForwardableMulticastDestination(addr tcpip.Address) bool {
+	return header.IsV4MulticastAddress(addr) && !header.IsV4LinkLocalMulticastAddress(addr)
+}
+
+// forwardMulticastPacket forwards a multicast packet received by e through the
+// protocol's multicast routes.
+//
+// Packets that do not match a route are queued until the route is installed,
+// and the protocol's multicast forwarding event dispatcher is notified.
+func (e *endpoint) forwardMulticastPacket(h header.IPv4, pkt *stack.PacketBuffer) {
+	if !e.MulticastForwarding() {
+		return
+	}
+	disp := e.protocol.multicastForwardingDisp()
+	if disp == nil {
+		return
+	}
+
+	srcAddr, dstAddr := h.SourceAddress(), h.DestinationAddress()
+	if !isForwardableMulticastSource(srcAddr) || !isForwardableMulticastDestination(dstAddr) {
+		return
+	}
+
+	// As per RFC 1812 section 4.3.2.7, ICMP Time Exceeded messages are not sent
+	// for packets destined to multicast addresses.
+	if h.TTL() <= 1 {
+		return
+	}
+
+	key := stack.UnicastSourceAndMulticastDestination{Source: srcAddr, Destination: dstAddr}
+	if route, ok := e.protocol.multicastRouteTable.GetRoute(key); ok {
+		e.protocol.forwardValidatedMulticastPacket(pkt, route)
+		return
+	}
+
+	stats := e.protocol.stack.Stats().IP.MulticastForwarding
+	result := e.protocol.multicastRouteTable.GetRouteOrInsertPending(key, pkt.Clone())
+	switch result.State {
+	case ip.InstalledRouteFound:
+		e.protocol.forwardValidatedMulticastPacket(pkt, result.InstalledRoute)
+	case ip.NoRouteFoundAndPendingInserted:
+		stats.NoRoutePacketsQueued.Increment()
+		disp.OnMissingRoute(stack.MulticastPacketContext{
+			SourceAndDestination: key,
+			InputInterface:       e.nic.ID(),
+		})
+	case ip.PacketQueuedInPendingRoute:
+		stats.NoRoutePacketsQueued.Increment()
+	case ip.PendingQueueFull:
+		stats.PendingQueueFullDropped.Increment()
+	}
+}
+
+// forwardValidatedMulticastPacket forwards pkt, a multicast packet that is
+// eligible for forwarding, through the outgoing interfaces of route.
+func (p *protocol) forwardValidatedMulticastPacket(pkt *stack.PacketBuffer, route *ip.InstalledRoute) {
+	stats := p.stack.Stats().IP.MulticastForwarding
+	h := header.IPv4(pkt.NetworkHeader().View())
+
+	if pkt.NICID != route.ExpectedInputInterface {
+		// The packet may be looping; let the integrator know as it may need to
+		// update the route (e.g. with a PIM Assert).
+		stats.UnexpectedInputInterfaceDropped.Increment()
+		if disp := p.multicastForwardingDisp(); disp != nil {
+			disp.OnUnexpectedInputInterface(stack.MulticastPacketContext{
+				SourceAndDestination: stack.UnicastSourceAndMulticastDestination{
+					Source:      h.SourceAddress(),
+					Destination: h.DestinationAddress(),
+				},
+				InputInterface: pkt.NICID,
+			}, route.ExpectedInputInterface)
+		}
+		return
+	}
+
+	route.SetLastUsedTimestamp(p.stack.Clock().NowMonotonic())
+	for _, outgoing := range route.OutgoingInterfaces {
+		if h.TTL() < outgoing.MinTTL {
+			continue
+		}
+		if err := p.forwardMulticastPacketOnInterface(pkt, outgoing.ID); err != nil {
+			stats.OutgoingPacketErrors.Increment()
+			continue
+		}
+		stats.PacketsForwarded.Increment()
+	}
+}
+
+// forwardMulticastPacketOnInterface sends a copy of pkt, with a decremented
+// TTL, through the NIC with the specified ID.
+func (p *protocol) forwardMulticastPacketOnInterface(pkt *stack.PacketBuffer, nicID tcpip.NICID) *tcpip.Error {
+	h := header.IPv4(pkt.NetworkHeader().View())
+	r, err := p.stack.FindRoute(nicID, "", h.DestinationAddress(), ProtocolNumber, false /* multicastLoop */)
+	if err != nil {
+		return err
+	}
+	defer r.Release()
+
+	// We need to do a deep copy of the IP packet because
+	// WriteHeaderIncludedPacket takes ownership of the packet buffer, and the
+	// packet may be sent through multiple interfaces.
+	//
+	// The checksum is recalculated by WriteHeaderIncludedPacket.
+	newHdr := header.IPv4(stack.PayloadSince(pkt.NetworkHeader()))
+	newHdr.SetTTL(h.TTL() - 1)
+
+	return r.WriteHeaderIncludedPacket(stack.NewPacketBuffer(stack.PacketBufferOptions{
+		ReserveHeaderBytes: int(r.MaxHeaderLength()),
+		Data:               buffer.View(newHdr).ToVectorisedView(),
+	}))
+}
+
+// multicastForwardingDisp returns the protocol's multicast forwarding event
+// dispatcher, or nil if multicast forwarding is disabled.
+func (p *protocol) multicastForwardingDisp() stack.MulticastForwardingEventDispatcher {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.mu.multicastForwardingDisp
+}
+
+// validateMulticastRouteAddresses returns an error if a multicast route may
+// not be installed for addresses.
+func validateMulticastRouteAddresses(addresses stack.UnicastSourceAndMulticastDestination) *tcpip.Error {
+	if !isForwardableMulticastSource(addresses.Source) || !isForwardableMulticastDestination(addresses.Destination) {
+		return tcpip.ErrBadAddress
+	}
+	return nil
+}
+
+// AddMulticastRoute implements stack.MulticastForwardingNetworkProtocol.
+func (p *protocol) AddMulticastRoute(addresses stack.UnicastSourceAndMulticastDestination, route stack.MulticastRoute) *tcpip.Error {
+	if p.multicastForwardingDisp() == nil {
+		return tcpip.ErrInvalidEndpointState
+	}
+	if err := validateMulticastRouteAddresses(addresses); err != nil {
+		return err
+	}
+	if !p.stack.HasNIC(route.ExpectedInputInterface) {
+		return tcpip.ErrUnknownNICID
+	}
+	for _, outgoing := range route.OutgoingInterfaces {
+		if outgoing.ID == route.ExpectedInputInterface {
+			return tcpip.ErrInvalidOptionValue
+		}
+		if !p.stack.HasNIC(outgoing.ID) {
+			return tcpip.ErrUnknownNICID
+		}
+	}
+
+	route.OutgoingInterfaces = append([]stack.MulticastRouteOutgoingInterface(nil), route.OutgoingInterfaces...)
+	installedRoute := p.multicastRouteTable.NewInstalledRoute(route)
+	for _, pkt := range p.multicastRouteTable.AddInstalledRoute(addresses, installedRoute) {
+		p.forwardValidatedMulticastPacket(pkt, installedRoute)
+	}
+	return nil
+}
+
+// RemoveMulticastRoute implements stack.MulticastForwardingNetworkProtocol.
+func (p *protocol) RemoveMulticastRoute(addresses stack.UnicastSourceAndMulticastDestination) *tcpip.Error {
+	if err := validateMulticastRouteAddresses(addresses); err != nil {
+		return err
+	}
+	if !p.multicastRouteTable.RemoveInstalledRoute(addresses) {
+		return tcpip.ErrNoRoute
+	}
+	return nil
+}
+
+// MulticastRouteLastUsedTime implements
+// stack.MulticastForwardingNetworkProtocol.
+func (p *protocol) MulticastRouteLastUsedTime(addresses stack.UnicastSourceAndMulticastDestination) (int64, *tcpip.Error) {
+	if err := validateMulticastRouteAddresses(addresses); err != nil {
+		return 0, err
+	}
+	t, ok := p.multicastRouteTable.GetLastUsedTimestamp(addresses)
+	if !ok {
+		return 0, tcpip.ErrNoRoute
+	}
+	return t, nil
+}
+
+// EnableMulticastForwarding implements
+// stack.MulticastForwardingNetworkProtocol.
+func (p *protocol) EnableMulticastForwarding(disp stack.MulticastForwardingEventDispatcher) (bool, *tcpip.Error) {
+	if disp == nil {
+		return false, tcpip.ErrInvalidOptionValue
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.mu.multicastForwardingDisp != nil {
+		return true, nil
+	}
+	p.mu.multicastForwardingDisp = disp
+	return false, nil
+}
+
+// DisableMulticastForwarding implements
+// stack.MulticastForwardingNetworkProtocol.
+func (p *protocol) DisableMulticastForwarding() {
+	p.mu.Lock()
+	p.mu.multicastForwardingDisp = nil
+	p.mu.Unlock()
+
+	p.multicastRouteTable.Close()
+}
@@ -0,0 +1,409 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv4_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/checker"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	multicastNICID1 = 1
+	multicastNICID2 = 2
+	multicastNICID3 = 3
+)
+
+var (
+	multicastSrcAddr   = tcpip.Address(net.ParseIP("10.0.0.2").To4())
+	multicastGroupAddr = tcpip.Address(net.ParseIP("239.1.2.3").To4())
+)
+
+type missingRouteEvent struct {
+	context stack.MulticastPacketContext
+}
+
+type unexpectedInputInterfaceEvent struct {
+	context                stack.MulticastPacketContext
+	expectedInputInterface tcpip.NICID
+}
+
+var _ stack.MulticastForwardingEventDispatcher = (*fakeMulticastEventDispatcher)(nil)
+
+type fakeMulticastEventDispatcher struct {
+	missingRoute             []missingRouteEvent
+	unexpectedInputInterface []unexpectedInputInterfaceEvent
+}
+
+func (d *fakeMulticastEventDispatcher) OnMissingRoute(context stack.MulticastPacketContext) {
+	d.missingRoute = append(d.missingRoute, missingRouteEvent{context: context})
+}
+
+func (d *fakeMulticastEventDispatcher) OnUnexpectedInputInterface(context stack.MulticastPacketContext, expectedInputInterface tcpip.NICID) {
+	d.unexpectedInputInterface = append(d.unexpectedInputInterface, unexpectedInputInterfaceEvent{
+		context:                context,
+		expectedInputInterface: expectedInputInterface,
+	})
+}
+
+// newMulticastForwardingStack returns a stack with three NICs that have
+// multicast forwarding enabled.
+func newMulticastForwardingStack(t *testing.T, disp stack.MulticastForwardingEventDispatcher) (*stack.Stack, map[tcpip.NICID]*channel.Endpoint) {
+	t.Helper()
+
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+	})
+	eps := make(map[tcpip.NICID]*channel.Endpoint)
+	for i, nicID := range []tcpip.NICID{multicastNICID1, multicastNICID2, multicastNICID3} {
+		// Each endpoint may hold the packets queued for a missing route, as well
+		// as the IGMP reports sent when the NIC is enabled.
+		e := channel.New(4, 1500, "")
+		if err := s.CreateNIC(nicID, e); err != nil {
+			t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+		}
+		protocolAddr := tcpip.ProtocolAddress{
+			Protocol: ipv4.ProtocolNumber,
+			AddressWithPrefix: tcpip.AddressWithPrefix{
+				Address:   tcpip.Address(net.ParseIP(fmt.Sprintf("%d.0.0.1", 10+i)).To4()),
+				PrefixLen: 8,
+			},
+		}
+		if err := s.AddProtocolAddress(nicID, protocolAddr); err != nil {
+			t.Fatalf("AddProtocolAddress(%d, %#v): %s", nicID, protocolAddr, err)
+		}
+		if err := s.SetNICMulticastForwarding(nicID, ipv4.ProtocolNumber, true); err != nil {
+			t.Fatalf("SetNICMulticastForwarding(%d, %d, true): %s", nicID, ipv4.ProtocolNumber, err)
+		}
+		eps[nicID] = e
+	}
+
+	if alreadyEnabled, err := s.EnableMulticastForwardingForProtocol(ipv4.ProtocolNumber, disp); err != nil || alreadyEnabled {
+		t.Fatalf("got s.EnableMulticastForwardingForProtocol(%d, _) = (%t, %s), want = (false, nil)", ipv4.ProtocolNumber, alreadyEnabled, err)
+	}
+	for _, e := range eps {
+		e.Drain()
+	}
+	return s, eps
+}
+
+func injectMulticastPacket(e *channel.Endpoint, src, dst tcpip.Address, ttl uint8) {
+	payload := []byte{1, 2, 3, 4}
+	hdr := buffer.NewPrependable(header.IPv4MinimumSize)
+	ip := header.IPv4(hdr.Prepend(header.IPv4MinimumSize))
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(header.IPv4MinimumSize + len(payload)),
+		Protocol:    uint8(header.UDPProtocolNumber),
+		TTL:         ttl,
+		SrcAddr:     src,
+		DstAddr:     dst,
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+	vv := hdr.View().ToVectorisedView()
+	vv.AppendView(payload)
+	e.InjectInbound(ipv4.ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: vv,
+	}))
+}
+
+func checkForwardedMulticastPacket(t *testing.T, e *channel.Endpoint, ttl uint8) {
+	t.Helper()
+
+	p, ok := e.Read()
+	if !ok {
+		t.Fatal("expected forwarded multicast packet")
+	}
+	checker.IPv4(t, stack.PayloadSince(p.Pkt.NetworkHeader()),
+		checker.SrcAddr(multicastSrcAddr),
+		checker.DstAddr(multicastGroupAddr),
+		checker.TTL(ttl),
+	)
+}
+
+func TestMulticastForwarding(t *testing.T) {
+	const ttl = 5
+
+	var disp fakeMulticastEventDispatcher
+	s, eps := newMulticastForwardingStack(t, &disp)
+	stats := s.Stats().IP.MulticastForwarding
+	key := stack.UnicastSourceAndMulticastDestination{
+		Source:      multicastSrcAddr,
+		Destination: multicastGroupAddr,
+	}
+
+	// Packets without a route are queued and reported to the dispatcher once.
+	injectMulticastPacket(eps[multicastNICID1], multicastSrcAddr, multicastGroupAddr, ttl)
+	injectMulticastPacket(eps[multicastNICID1], multicastSrcAddr, multicastGroupAddr, ttl)
+	wantMissingRoute := []missingRouteEvent{{
+		context: stack.MulticastPacketContext{
+			SourceAndDestination: key,
+			InputInterface:       multicastNICID1,
+		},
+	}}
+	if diff := cmp.Diff(wantMissingRoute, disp.missingRoute, cmp.AllowUnexported(missingRouteEvent{})); diff != "" {
+		t.Errorf("missing route events mismatch (-want +got):\n%s", diff)
+	}
+	if got := stats.NoRoutePacketsQueued.Value(); got != 2 {
+		t.Errorf("got stats.NoRoutePacketsQueued.Value() = %d, want = 2", got)
+	}
+	for nicID, e := range eps {
+		if n := e.Drain(); n != 0 {
+			t.Errorf("got eps[%d].Drain() = %d, want = 0", nicID, n)
+		}
+	}
+
+	// Installing the route forwards the queued packets.
+	route := stack.MulticastRoute{
+		ExpectedInputInterface: multicastNICID1,
+		OutgoingInterfaces: []stack.MulticastRouteOutgoingInterface{
+			{ID: multicastNICID2, MinTTL: 1},
+			{ID: multicastNICID3, MinTTL: ttl + 1},
+		},
+	}
+	if err := s.AddMulticastRoute(ipv4.ProtocolNumber, key, route); err != nil {
+		t.Fatalf("s.AddMulticastRoute(%d, %#v, %#v): %s", ipv4.ProtocolNumber, key, route, err)
+	}
+	for i := 0; i < 2; i++ {
+		checkForwardedMulticastPacket(t, eps[multicastNICID2], ttl-1)
+	}
+	// The TTL of the packets is below the threshold of the third interface.
+	if n := eps[multicastNICID3].Drain(); n != 0 {
+		t.Errorf("got eps[%d].Drain() = %d, want = 0", multicastNICID3, n)
+	}
+	if got := stats.PacketsForwarded.Value(); got != 2 {
+		t.Errorf("got stats.PacketsForwarded.Value() = %d, want = 2", got)
+	}
+
+	// Packets matching the route are forwarded as they are received.
+	injectMulticastPacket(eps[multicastNICID1], multicastSrcAddr, multicastGroupAddr, ttl)
+	checkForwardedMulticastPacket(t, eps[multicastNICID2], ttl-1)
+	for _, nicID := range []tcpip.NICID{multicastNICID1, multicastNICID3} {
+		if n := eps[nicID].Drain(); n != 0 {
+			t.Errorf("got eps[%d].Drain() = %d, want = 0", nicID, n)
+		}
+	}
+	if _, err := s.MulticastRouteLastUsedTime(ipv4.ProtocolNumber, key); err != nil {
+		t.Errorf("s.MulticastRouteLastUsedTime(%d, %#v): %s", ipv4.ProtocolNumber, key, err)
+	}
+
+	// Packets received on an unexpected interface are dropped.
+	injectMulticastPacket(eps[multicastNICID2], multicastSrcAddr, multicastGroupAddr, ttl)
+	wantUnexpectedInputInterface := []unexpectedInputInterfaceEvent{{
+		context: stack.MulticastPacketContext{
+			SourceAndDestination: key,
+			InputInterface:       multicastNICID2,
+		},
+		expectedInputInterface: multicastNICID1,
+	}}
+	if diff := cmp.Diff(wantUnexpectedInputInterface, disp.unexpectedInputInterface, cmp.AllowUnexported(unexpectedInputInterfaceEvent{})); diff != "" {
+		t.Errorf("unexpected input interface events mismatch (-want +got):\n%s", diff)
+	}
+	if got := stats.UnexpectedInputInterfaceDropped.Value(); got != 1 {
+		t.Errorf("got stats.UnexpectedInputInterfaceDropped.Value() = %d, want = 1", got)
+	}
+	for nicID, e := range eps {
+		if n := e.Drain(); n != 0 {
+			t.Errorf("got eps[%d].Drain() = %d, want = 0", nicID, n)
+		}
+	}
+
+	// Packets are no longer forwarded once the route is removed.
+	if err := s.RemoveMulticastRoute(ipv4.ProtocolNumber, key); err != nil {
+		t.Fatalf("s.RemoveMulticastRoute(%d, %#v): %s", ipv4.ProtocolNumber, key, err)
+	}
+	if err := s.RemoveMulticastRoute(ipv4.ProtocolNumber, key); err != tcpip.ErrNoRoute {
+		t.Errorf("got s.RemoveMulticastRoute(%d, %#v) = %s, want = %s", ipv4.ProtocolNumber, key, err, tcpip.ErrNoRoute)
+	}
+	injectMulticastPacket(eps[multicastNICID1], multicastSrcAddr, multicastGroupAddr, ttl)
+	if got := len(disp.missingRoute); got != 2 {
+		t.Errorf("got len(disp.missingRoute) = %d, want = 2", got)
+	}
+	for nicID, e := range eps {
+		if n := e.Drain(); n != 0 {
+			t.Errorf("got eps[%d].Drain() = %d, want = 0", nicID, n)
+		}
+	}
+}
+
+func TestMulticastForwardingIneligiblePackets(t *testing.T) {
+	tests := []struct {
+		name    string
+		srcAddr tcpip.Address
+		dstAddr tcpip.Address
+		ttl     uint8
+	}{
+		{
+			name:    "TTL of one",
+			srcAddr: multicastSrcAddr,
+			dstAddr: multicastGroupAddr,
+			ttl:     1,
+		},
+		{
+			name:    "link-local group",
+			srcAddr: multicastSrcAddr,
+			dstAddr: tcpip.Address(net.ParseIP("224.0.0.251").To4()),
+			ttl:     5,
+		},
+		{
+			name:    "link-local source",
+			srcAddr: tcpip.Address(net.ParseIP("169.254.1.2").To4()),
+			dstAddr: multicastGroupAddr,
+			ttl:     5,
+		},
+		{
+			name:    "unspecified source",
+			srcAddr: header.IPv4Any,
+			dstAddr: multicastGroupAddr,
+			ttl:     5,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var disp fakeMulticastEventDispatcher
+			s, eps := newMulticastForwardingStack(t, &disp)
+
+			injectMulticastPacket(eps[multicastNICID1], test.srcAddr, test.dstAddr, test.ttl)
+			if got := len(disp.missingRoute); got != 0 {
+				t.Errorf("got len(disp.missingRoute) = %d, want = 0", got)
+			}
+			if got := s.Stats().IP.MulticastForwarding.NoRoutePacketsQueued.Value(); got != 0 {
+				t.Errorf("got NoRoutePacketsQueued.Value() = %d, want = 0", got)
+			}
+			for nicID, e := range eps {
+				if n := e.Drain(); n != 0 {
+					t.Errorf("got eps[%d].Drain() = %d, want = 0", nicID, n)
+				}
+			}
+		})
+	}
+}
+
+func TestMulticastForwardingDisabledOnNIC(t *testing.T) {
+	var disp fakeMulticastEventDispatcher
+	s, eps := newMulticastForwardingStack(t, &disp)
+	if err := s.SetNICMulticastForwarding(multicastNICID1, ipv4.ProtocolNumber, false); err != nil {
+		t.Fatalf("SetNICMulticastForwarding(%d, %d, false): %s", multicastNICID1, ipv4.ProtocolNumber, err)
+	}
+
+	injectMulticastPacket(eps[multicastNICID1], multicastSrcAddr, multicastGroupAddr, 5)
+	if got := len(disp.missingRoute); got != 0 {
+		t.Errorf("got len(disp.missingRoute) = %d, want = 0", got)
+	}
+}
+
+func TestAddMulticastRouteErrors(t *testing.T) {
+	validKey := stack.UnicastSourceAndMulticastDestination{
+		Source:      multicastSrcAddr,
+		Destination: multicastGroupAddr,
+	}
+	validRoute := stack.MulticastRoute{
+		ExpectedInputInterface: multicastNICID1,
+		OutgoingInterfaces: []stack.MulticastRouteOutgoingInterface{
+			{ID: multicastNICID2, MinTTL: 1},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		key     stack.UnicastSourceAndMulticastDestination
+		route   stack.MulticastRoute
+		wantErr *tcpip.Error
+	}{
+		{
+			name:  "valid",
+			key:   validKey,
+			route: validRoute,
+		},
+		{
+			name: "broadcast source",
+			key: stack.UnicastSourceAndMulticastDestination{
+				Source:      header.IPv4Broadcast,
+				Destination: multicastGroupAddr,
+			},
+			route:   validRoute,
+			wantErr: tcpip.ErrBadAddress,
+		},
+		{
+			name: "multicast source",
+			key: stack.UnicastSourceAndMulticastDestination{
+				Source:      multicastGroupAddr,
+				Destination: multicastGroupAddr,
+			},
+			route:   validRoute,
+			wantErr: tcpip.ErrBadAddress,
+		},
+		{
+			name: "link-local group",
+			key: stack.UnicastSourceAndMulticastDestination{
+				Source:      multicastSrcAddr,
+				Destination: header.IPv4AllSystems,
+			},
+			route:   validRoute,
+			wantErr: tcpip.ErrBadAddress,
+		},
+		{
+			name: "unknown input interface",
+			key:  validKey,
+			route: stack.MulticastRoute{
+				ExpectedInputInterface: 4,
+				OutgoingInterfaces:     validRoute.OutgoingInterfaces,
+			},
+			wantErr: tcpip.ErrUnknownNICID,
+		},
+		{
+			name: "unknown outgoing interface",
+			key:  validKey,
+			route: stack.MulticastRoute{
+				ExpectedInputInterface: multicastNICID1,
+				OutgoingInterfaces: []stack.MulticastRouteOutgoingInterface{
+					{ID: 4, MinTTL: 1},
+				},
+			},
+			wantErr: tcpip.ErrUnknownNICID,
+		},
+		{
+			name: "input interface is outgoing interface",
+			key:  validKey,
+			route: stack.MulticastRoute{
+				ExpectedInputInterface: multicastNICID1,
+				OutgoingInterfaces: []stack.MulticastRouteOutgoingInterface{
+					{ID: multicastNICID1, MinTTL: 1},
+				},
+			},
+			wantErr: tcpip.ErrInvalidOptionValue,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var disp fakeMulticastEventDispatcher
+			s, _ := newMulticastForwardingStack(t, &disp)
+
+			if err := s.AddMulticastRoute(ipv4.ProtocolNumber, test.key, test.route); err != test.wantErr {
+				t.Errorf("got s.AddMulticastRoute(%d, %#v, %#v) = %s, want = %s", ipv4.ProtocolNumber, test.key, test.route, err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv4_test
+
+import (
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/fragmentation"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+func TestReassemblyLimitsAndStats(t *testing.T) {
+	const nicID = 1
+	localAddr := tcpip.Address("\x0a\x00\x00\x01")
+	remoteAddr := tcpip.Address("\x0a\x00\x00\x02")
+
+	clock := faketime.NewManualClock()
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+		Clock:              clock,
+	})
+	e := channel.New(0, 1280, "")
+	if err := s.CreateNIC(nicID, e); err != nil {
+		t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+	}
+	if err := s.AddAddress(nicID, ipv4.ProtocolNumber, localAddr); err != nil {
+		t.Fatalf("AddAddress(%d, %d, %s) = %s", nicID, ipv4.ProtocolNumber, localAddr, err)
+	}
+
+	limits := tcpip.ReassemblyLimitsOption{
+		HighMemoryLimit:          1 << 20,
+		LowMemoryLimit:           1 << 19,
+		Timeout:                  10 * time.Second,
+		MaxReassembliesPerSource: 1,
+	}
+	if err := s.SetNetworkProtocolOption(ipv4.ProtocolNumber, &limits); err != nil {
+		t.Fatalf("SetNetworkProtocolOption(%d, &%#v) = %s", ipv4.ProtocolNumber, limits, err)
+	}
+	var got tcpip.ReassemblyLimitsOption
+	if err := s.NetworkProtocolOption(ipv4.ProtocolNumber, &got); err != nil {
+		t.Fatalf("NetworkProtocolOption(%d, _) = %s", ipv4.ProtocolNumber, err)
+	}
+	if got != limits {
+		t.Errorf("got ReassemblyLimitsOption = %#v, want = %#v", got, limits)
+	}
+
+	injectFragment := func(id uint16, offset uint16, more bool) {
+		const payloadLen = 8
+		totalLen := header.IPv4MinimumSize + payloadLen
+		hdr := buffer.NewPrependable(totalLen)
+		hdr.Prepend(payloadLen)
+		var flags uint8
+		if more {
+			flags = header.IPv4FlagMoreFragments
+		}
+		ip := header.IPv4(hdr.Prepend(header.IPv4MinimumSize))
+		ip.Encode(&header.IPv4Fields{
+			TotalLength:    uint16(totalLen),
+			ID:             id,
+			Flags:          flags,
+			FragmentOffset: offset,
+			Protocol:       uint8(udp.ProtocolNumber),
+			TTL:            64,
+			SrcAddr:        remoteAddr,
+			DstAddr:        localAddr,
+		})
+		ip.SetChecksum(^ip.CalculateChecksum())
+		e.InjectInbound(ipv4.ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Data: hdr.View().ToVectorisedView(),
+		}))
+	}
+
+	ep, err := s.GetNetworkEndpoint(nicID, ipv4.ProtocolNumber)
+	if err != nil {
+		t.Fatalf("s.GetNetworkEndpoint(%d, %d): %s", nicID, ipv4.ProtocolNumber, err)
+	}
+	stats := ep.(fragmentation.ReassemblyStatsEndpoint).ReassemblyStats()
+
+	// The second reassembly from the same source exceeds the per-source limit.
+	injectFragment(1, 0, true)
+	injectFragment(2, 0, true)
+	if got := stats.SourceLimitDrops.Value(); got != 1 {
+		t.Errorf("got SourceLimitDrops = %d, want = 1", got)
+	}
+	if got := s.Stats().IP.MalformedFragmentsReceived.Value(); got != 0 {
+		t.Errorf("got MalformedFragmentsReceived = %d, want = 0", got)
+	}
+
+	// Completing the first reassembly makes room for another one.
+	injectFragment(1, 8, false)
+	if got := stats.PacketsReassembled.Value(); got != 1 {
+		t.Errorf("got PacketsReassembled = %d, want = 1", got)
+	}
+	injectFragment(2, 0, true)
+	if got := stats.SourceLimitDrops.Value(); got != 1 {
+		t.Errorf("got SourceLimitDrops = %d, want = 1", got)
+	}
+
+	clock.Advance(limits.Timeout)
+	if got := stats.ReassemblyTimeouts.Value(); got != 1 {
+		t.Errorf("got ReassemblyTimeouts = %d, want = 1", got)
+	}
+	if got := stats.FragmentsReceived.Value(); got != 4 {
+		t.Errorf("got FragmentsReceived = %d, want = 4", got)
+	}
+}
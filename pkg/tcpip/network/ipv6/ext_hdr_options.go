@@ -0,0 +1,187 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv6
+
+import (
+	"fmt"
+
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// ExtHdrOptionsPolicy is the policy an endpoint applies to the options held in
+// the Hop-by-Hop and Destination Options extension headers of packets it
+// receives.
+//
+// Limits set to 0 are not enforced.
+type ExtHdrOptionsPolicy struct {
+	// MaxHopByHopOptions is the maximum number of non-padding options a
+	// Hop-by-Hop Options extension header may hold, equivalent to Linux's
+	// max_hbh_opts_number.
+	MaxHopByHopOptions int
+
+	// MaxHopByHopLength is the maximum length, in bytes, of a Hop-by-Hop
+	// Options extension header, equivalent to Linux's max_hbh_length.
+	MaxHopByHopLength int
+
+	// MaxDestinationOptions is the maximum number of non-padding options a
+	// Destination Options extension header may hold, equivalent to Linux's
+	// max_dst_opts_number.
+	MaxDestinationOptions int
+
+	// MaxDestinationLength is the maximum length, in bytes, of a Destination
+	// Options extension header, equivalent to Linux's max_dst_opts_length.
+	MaxDestinationLength int
+
+	// DropUnknown determines whether packets holding an unrecognized option are
+	// dropped even when the option's type indicates that it should be skipped,
+	// as permitted by RFC 8200 section 4.2.
+	DropUnknown bool
+
+	// RequireMLDRouterAlert determines whether MLD messages that are not
+	// received with a Router Alert option holding the MLD value are dropped, as
+	// per RFC 2710 section 3 and RFC 3810 section 5.
+	RequireMLDRouterAlert bool
+}
+
+// ExtHdrOptionsEndpoint is a network endpoint that applies an
+// ExtHdrOptionsPolicy to the packets it receives.
+type ExtHdrOptionsEndpoint interface {
+	// SetExtHdrOptionsPolicy sets the endpoint's extension header options
+	// policy.
+	SetExtHdrOptionsPolicy(ExtHdrOptionsPolicy)
+
+	// ExtHdrOptionsPolicy returns the endpoint's extension header options
+	// policy.
+	ExtHdrOptionsPolicy() ExtHdrOptionsPolicy
+}
+
+var _ ExtHdrOptionsEndpoint = (*endpoint)(nil)
+
+// SetExtHdrOptionsPolicy implements ExtHdrOptionsEndpoint.
+func (e *endpoint) SetExtHdrOptionsPolicy(p ExtHdrOptionsPolicy) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.mu.extHdrOptionsPolicy = p
+}
+
+// ExtHdrOptionsPolicy implements ExtHdrOptionsEndpoint.
+func (e *endpoint) ExtHdrOptionsPolicy() ExtHdrOptionsPolicy {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.mu.extHdrOptionsPolicy
+}
+
+// extHdrOptionsResult is the result of processing the options held in an
+// extension header.
+type extHdrOptionsResult struct {
+	// drop is true if the packet must be dropped.
+	drop bool
+
+	// routerAlert is true if a Router Alert option was found.
+	routerAlert bool
+
+	// routerAlertValue is the value of the last Router Alert option found.
+	routerAlertValue header.IPv6RouterAlertValue
+}
+
+// processExtHdrOptions processes the options held in a Hop-by-Hop or
+// Destination Options extension header, as per RFC 8200 section 4.2.
+//
+// maxOptions and maxLength are the limits on the number of non-padding options
+// and the length of the header; limits set to 0 are not enforced. hdrLength is
+// the length of the header and hdrOffset is the offset of its options from the
+// start of the IPv6 header. Router Alert options are only recognized if
+// hopByHop is true; RFC 2711 only defines the option for the Hop-by-Hop Options
+// extension header.
+func (e *endpoint) processExtHdrOptions(pkt *stack.PacketBuffer, optsIt header.IPv6OptionsExtHdrOptionsIterator, hopByHop bool, hdrLength int, hdrOffset uint32, policy *ExtHdrOptionsPolicy) extHdrOptionsResult {
+	stats := e.protocol.stack.Stats().IP
+	dstAddr := header.IPv6(pkt.NetworkHeader().View()).DestinationAddress()
+
+	maxOptions, maxLength := policy.MaxDestinationOptions, policy.MaxDestinationLength
+	if hopByHop {
+		maxOptions, maxLength = policy.MaxHopByHopOptions, policy.MaxHopByHopLength
+	}
+	if maxLength != 0 && hdrLength > maxLength {
+		stats.ExtHdrOptions.HeaderTooLongDropped.Increment()
+		return extHdrOptionsResult{drop: true}
+	}
+
+	var res extHdrOptionsResult
+	for count := 0; ; count++ {
+		opt, done, err := optsIt.Next()
+		if err != nil {
+			stats.MalformedPacketsReceived.Increment()
+			return extHdrOptionsResult{drop: true}
+		}
+		if done {
+			return res
+		}
+
+		if maxOptions != 0 && count >= maxOptions {
+			stats.ExtHdrOptions.TooManyOptionsDropped.Increment()
+			return extHdrOptionsResult{drop: true}
+		}
+
+		if opt, ok := opt.(*header.IPv6RouterAlertOption); ok && hopByHop {
+			stats.ExtHdrOptions.RouterAlertReceived.Increment()
+			res.routerAlert = true
+			res.routerAlertValue = opt.Value
+			continue
+		}
+
+		action := opt.UnknownAction()
+		if action == header.IPv6OptionUnknownActionSkip {
+			if !policy.DropUnknown {
+				continue
+			}
+			action = header.IPv6OptionUnknownActionDiscard
+		}
+
+		stats.ExtHdrOptions.UnknownOptionDropped.Increment()
+		switch action {
+		case header.IPv6OptionUnknownActionDiscard:
+			return extHdrOptionsResult{drop: true}
+		case header.IPv6OptionUnknownActionDiscardSendICMPNoMulticastDest:
+			if header.IsV6MulticastAddress(dstAddr) {
+				return extHdrOptionsResult{drop: true}
+			}
+			fallthrough
+		case header.IPv6OptionUnknownActionDiscardSendICMP:
+			// This case satisfies a requirement of RFC 8200 section 4.2
+			// which states that an unknown option starting with bits [10] should:
+			//
+			//    discard the packet and, regardless of whether or not the
+			//    packet's Destination Address was a multicast address, send an
+			//    ICMP Parameter Problem, Code 2, message to the packet's
+			//    Source Address, pointing to the unrecognized Option Type.
+			//
+			_ = e.protocol.returnError(&icmpReasonParameterProblem{
+				code:               header.ICMPv6UnknownOption,
+				pointer:            hdrOffset + optsIt.OptionOffset(),
+				respondToMulticast: true,
+			}, pkt)
+			return extHdrOptionsResult{drop: true}
+		default:
+			panic(fmt.Sprintf("unrecognized action for an unrecognized extension header option = %d", opt))
+		}
+	}
+}
+
+// isMLDRouterAlert returns true if res holds a Router Alert option with the MLD
+// value.
+func (res *extHdrOptionsResult) isMLDRouterAlert() bool {
+	return res.routerAlert && res.routerAlertValue == header.IPv6RouterAlertMLD
+}
@@ -0,0 +1,334 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv6
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+// injectWithExtHdrs injects an IPv6 packet from addr1 to dstAddr holding
+// extHdrs followed by the upper-layer payload with the specified protocol.
+func injectWithExtHdrs(e *channel.Endpoint, dstAddr tcpip.Address, extHdrs []byte, firstHdr uint8, payload []byte) {
+	hdr := buffer.NewPrependable(header.IPv6MinimumSize + len(extHdrs) + len(payload))
+	copy(hdr.Prepend(len(payload)), payload)
+	copy(hdr.Prepend(len(extHdrs)), extHdrs)
+	payloadLength := hdr.UsedLength()
+	ip := header.IPv6(hdr.Prepend(header.IPv6MinimumSize))
+	ip.Encode(&header.IPv6Fields{
+		PayloadLength: uint16(payloadLength),
+		NextHeader:    firstHdr,
+		HopLimit:      1,
+		SrcAddr:       addr1,
+		DstAddr:       dstAddr,
+	})
+	e.InjectInbound(ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: hdr.View().ToVectorisedView(),
+	}))
+}
+
+func TestExtHdrOptionsPolicy(t *testing.T) {
+	const nicID = 1
+
+	tests := []struct {
+		name         string
+		policy       ExtHdrOptionsPolicy
+		extHdr       func(nextHdr uint8) ([]byte, uint8)
+		shouldAccept bool
+		stat         func(tcpip.IPv6ExtHdrOptionStats) *tcpip.StatCounter
+	}{
+		{
+			name: "hopbyhop with router alert",
+			extHdr: func(nextHdr uint8) ([]byte, uint8) {
+				return []byte{nextHdr, 0, 5, 2, 0, 1, 1, 0}, hopByHopExtHdrID
+			},
+			shouldAccept: true,
+			stat:         func(s tcpip.IPv6ExtHdrOptionStats) *tcpip.StatCounter { return s.RouterAlertReceived },
+		},
+		{
+			name: "hopbyhop with malformed router alert",
+			extHdr: func(nextHdr uint8) ([]byte, uint8) {
+				return []byte{nextHdr, 0, 5, 3, 0, 1, 2, 0}, hopByHopExtHdrID
+			},
+			shouldAccept: false,
+		},
+		{
+			name: "hopbyhop within option limit",
+			policy: ExtHdrOptionsPolicy{
+				MaxHopByHopOptions: 2,
+			},
+			extHdr: func(nextHdr uint8) ([]byte, uint8) {
+				return []byte{
+					nextHdr, 1,
+					63, 0,
+					62, 0,
+					// Padding does not count towards the limit.
+					1, 8, 0, 0, 0, 0, 0, 0, 0, 0,
+				}, hopByHopExtHdrID
+			},
+			shouldAccept: true,
+		},
+		{
+			name: "hopbyhop over option limit",
+			policy: ExtHdrOptionsPolicy{
+				MaxHopByHopOptions: 2,
+			},
+			extHdr: func(nextHdr uint8) ([]byte, uint8) {
+				return []byte{
+					nextHdr, 1,
+					63, 0,
+					62, 0,
+					61, 0,
+					1, 6, 0, 0, 0, 0, 0, 0,
+				}, hopByHopExtHdrID
+			},
+			shouldAccept: false,
+			stat:         func(s tcpip.IPv6ExtHdrOptionStats) *tcpip.StatCounter { return s.TooManyOptionsDropped },
+		},
+		{
+			name: "hopbyhop over length limit",
+			policy: ExtHdrOptionsPolicy{
+				MaxHopByHopLength: 8,
+			},
+			extHdr: func(nextHdr uint8) ([]byte, uint8) {
+				return []byte{
+					nextHdr, 1,
+					1, 12, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+				}, hopByHopExtHdrID
+			},
+			shouldAccept: false,
+			stat:         func(s tcpip.IPv6ExtHdrOptionStats) *tcpip.StatCounter { return s.HeaderTooLongDropped },
+		},
+		{
+			name: "destination limits do not apply to hopbyhop",
+			policy: ExtHdrOptionsPolicy{
+				MaxDestinationOptions: 1,
+				MaxDestinationLength:  8,
+			},
+			extHdr: func(nextHdr uint8) ([]byte, uint8) {
+				return []byte{
+					nextHdr, 1,
+					63, 0,
+					62, 0,
+					1, 8, 0, 0, 0, 0, 0, 0, 0, 0,
+				}, hopByHopExtHdrID
+			},
+			shouldAccept: true,
+		},
+		{
+			name: "destination over option limit",
+			policy: ExtHdrOptionsPolicy{
+				MaxDestinationOptions: 1,
+			},
+			extHdr: func(nextHdr uint8) ([]byte, uint8) {
+				return []byte{nextHdr, 0, 63, 0, 62, 0, 1, 0}, destinationExtHdrID
+			},
+			shouldAccept: false,
+			stat:         func(s tcpip.IPv6ExtHdrOptionStats) *tcpip.StatCounter { return s.TooManyOptionsDropped },
+		},
+		{
+			name: "destination over length limit",
+			policy: ExtHdrOptionsPolicy{
+				MaxDestinationLength: 8,
+			},
+			extHdr: func(nextHdr uint8) ([]byte, uint8) {
+				return []byte{
+					nextHdr, 1,
+					1, 12, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+				}, destinationExtHdrID
+			},
+			shouldAccept: false,
+			stat:         func(s tcpip.IPv6ExtHdrOptionStats) *tcpip.StatCounter { return s.HeaderTooLongDropped },
+		},
+		{
+			name: "destination with skippable unknown",
+			extHdr: func(nextHdr uint8) ([]byte, uint8) {
+				return []byte{nextHdr, 0, 63, 0, 1, 2, 0, 0}, destinationExtHdrID
+			},
+			shouldAccept: true,
+		},
+		{
+			name: "destination with skippable unknown and drop unknown policy",
+			policy: ExtHdrOptionsPolicy{
+				DropUnknown: true,
+			},
+			extHdr: func(nextHdr uint8) ([]byte, uint8) {
+				return []byte{nextHdr, 0, 63, 0, 1, 2, 0, 0}, destinationExtHdrID
+			},
+			shouldAccept: false,
+			stat:         func(s tcpip.IPv6ExtHdrOptionStats) *tcpip.StatCounter { return s.UnknownOptionDropped },
+		},
+		{
+			name: "hopbyhop with router alert and drop unknown policy",
+			policy: ExtHdrOptionsPolicy{
+				DropUnknown: true,
+			},
+			extHdr: func(nextHdr uint8) ([]byte, uint8) {
+				return []byte{nextHdr, 0, 5, 2, 0, 0, 1, 0}, hopByHopExtHdrID
+			},
+			shouldAccept: true,
+			stat:         func(s tcpip.IPv6ExtHdrOptionStats) *tcpip.StatCounter { return s.RouterAlertReceived },
+		},
+		{
+			name: "destination with discard unknown",
+			extHdr: func(nextHdr uint8) ([]byte, uint8) {
+				return []byte{nextHdr, 0, 127, 0, 1, 2, 0, 0}, destinationExtHdrID
+			},
+			shouldAccept: false,
+			stat:         func(s tcpip.IPv6ExtHdrOptionStats) *tcpip.StatCounter { return s.UnknownOptionDropped },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := stack.New(stack.Options{
+				NetworkProtocols:   []stack.NetworkProtocolFactory{NewProtocol},
+				TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+			})
+			e := channel.New(1, header.IPv6MinimumMTU, linkAddr1)
+			if err := s.CreateNIC(nicID, e); err != nil {
+				t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+			}
+			if err := s.AddAddress(nicID, ProtocolNumber, addr2); err != nil {
+				t.Fatalf("AddAddress(%d, %d, %s) = %s", nicID, ProtocolNumber, addr2, err)
+			}
+			netEP, err := s.GetNetworkEndpoint(nicID, ProtocolNumber)
+			if err != nil {
+				t.Fatalf("GetNetworkEndpoint(%d, %d): %s", nicID, ProtocolNumber, err)
+			}
+			netEP.(ExtHdrOptionsEndpoint).SetExtHdrOptionsPolicy(test.policy)
+
+			const dstPort = 80
+			udpPayload := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+			u := header.UDP(make([]byte, header.UDPMinimumSize+len(udpPayload)))
+			u.Encode(&header.UDPFields{
+				SrcPort: 5555,
+				DstPort: dstPort,
+				Length:  uint16(len(u)),
+			})
+			copy(u.Payload(), udpPayload)
+			sum := header.PseudoHeaderChecksum(udp.ProtocolNumber, addr1, addr2, uint16(len(u)))
+			sum = header.Checksum(udpPayload, sum)
+			u.SetChecksum(^u.CalculateChecksum(sum))
+
+			extHdrBytes, ipv6NextHdr := test.extHdr(uint8(header.UDPProtocolNumber))
+			injectWithExtHdrs(e, addr2, extHdrBytes, ipv6NextHdr, u)
+
+			// Packets that are accepted are delivered to the transport layer, which
+			// responds with a port unreachable message as there is no endpoint
+			// bound to the destination port.
+			want := uint64(0)
+			if test.shouldAccept {
+				want = 1
+			}
+			if got := s.Stats().UDP.UnknownPortErrors.Value(); got != want {
+				t.Errorf("got UDP UnknownPortErrors = %d, want = %d", got, want)
+			}
+			if test.stat != nil {
+				if got := test.stat(s.Stats().IP.ExtHdrOptions).Value(); got != 1 {
+					t.Errorf("got stat = %d, want = 1", got)
+				}
+			}
+		})
+	}
+}
+
+func TestRequireMLDRouterAlert(t *testing.T) {
+	const nicID = 1
+
+	mldRouterAlert := []byte{
+		uint8(header.ICMPv6ProtocolNumber), 0,
+		// Router Alert (MLD).
+		5, 2, 0, 0,
+		// Pad2.
+		1, 0,
+	}
+	rsvpRouterAlert := []byte{
+		uint8(header.ICMPv6ProtocolNumber), 0,
+		// Router Alert (RSVP).
+		5, 2, 0, 1,
+		// Pad2.
+		1, 0,
+	}
+
+	tests := []struct {
+		name        string
+		require     bool
+		extHdrs     []byte
+		wantDropped uint64
+	}{
+		{
+			name:        "not required without router alert",
+			require:     false,
+			wantDropped: 0,
+		},
+		{
+			name:        "required without router alert",
+			require:     true,
+			wantDropped: 1,
+		},
+		{
+			name:        "required with MLD router alert",
+			require:     true,
+			extHdrs:     mldRouterAlert,
+			wantDropped: 0,
+		},
+		{
+			name:        "required with RSVP router alert",
+			require:     true,
+			extHdrs:     rsvpRouterAlert,
+			wantDropped: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{NewProtocolWithOptions(Options{
+					ExtHdrOptions: ExtHdrOptionsPolicy{
+						RequireMLDRouterAlert: test.require,
+					},
+				})},
+			})
+			e := channel.New(1, header.IPv6MinimumMTU, linkAddr1)
+			if err := s.CreateNIC(nicID, e); err != nil {
+				t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+			}
+
+			icmp := header.ICMPv6(make([]byte, header.ICMPv6HeaderSize+header.MLDMinimumSize))
+			icmp.SetType(header.ICMPv6MulticastListenerQuery)
+			icmp.SetChecksum(header.ICMPv6Checksum(icmp, addr1, header.IPv6AllNodesMulticastAddress, buffer.VectorisedView{}))
+
+			firstHdr := uint8(header.ICMPv6ProtocolNumber)
+			if len(test.extHdrs) != 0 {
+				firstHdr = hopByHopExtHdrID
+			}
+			injectWithExtHdrs(e, header.IPv6AllNodesMulticastAddress, test.extHdrs, firstHdr, icmp)
+
+			if got := s.Stats().ICMP.V6.PacketsReceived.MulticastListenerQuery.Value(); got != 1 {
+				t.Errorf("got MulticastListenerQuery = %d, want = 1", got)
+			}
+			if got := s.Stats().IP.ExtHdrOptions.MLDWithoutRouterAlertDropped.Value(); got != test.wantDropped {
+				t.Errorf("got MLDWithoutRouterAlertDropped = %d, want = %d", got, test.wantDropped)
+			}
+		})
+	}
+}
@@ -67,6 +67,11 @@ func (e *endpoint) handleControl(typ stack.ControlType, extra uint32, pkt *stack
 		p = fragHdr.TransportProtocol()
 	}
 
+	if typ == stack.ControlPacketTooBig && extra != 0 {
+		// extra is the network-layer payload MTU.
+		e.protocol.stack.UpdatePathMTU(ProtocolNumber, hdr.DestinationAddress(), extra+header.IPv6MinimumSize)
+	}
+
 	// Deliver the control packet to the transport endpoint.
 	e.dispatcher.DeliverTransportControlPacket(src, hdr.DestinationAddress(), ProtocolNumber, p, typ, extra, pkt)
 }
@@ -124,10 +129,22 @@ func getTargetLinkAddr(it header.NDPOptionIterator) (tcpip.LinkAddress, bool) {
 	})
 }
 
-func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
+// isFirstHopRouter returns true if router is the first-hop currently used to
+// reach dst through the endpoint.
+func (e *endpoint) isFirstHopRouter(router, dst tcpip.Address) bool {
+	r, err := e.protocol.stack.FindRoute(e.nic.ID(), "", dst, ProtocolNumber, false /* multicastLoop */)
+	if err != nil {
+		return false
+	}
+	defer r.Release()
+	return r.NextHop == router
+}
+
+func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader, mldRouterAlert bool) {
 	stats := e.protocol.stack.Stats().ICMP
 	sent := stats.V6.PacketsSent
 	received := stats.V6.PacketsReceived
+	ndpStats := e.protocol.stack.Stats().IP.NDP
 	// TODO(gvisor.dev/issue/170): ICMP packets don't have their
 	// TransportHeader fields set. See icmp/protocol.go:protocol.Parse for a
 	// full explanation.
@@ -151,15 +168,38 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 		return
 	}
 
-	isNDPValid := func() bool {
+	// isNDPValid returns true if the NDP message passes the validity checks
+	// common to all NDP messages and is at least minimumSize bytes long,
+	// including the ICMPv6 header.
+	isNDPValid := func(minimumSize int) bool {
+		// As per RFC 6980 section 5, nodes MUST silently drop NDP messages if the
+		// packet includes a fragmentation header.
+		//
+		// This prevents NDP spoofing attacks that use fragmentation to evade
+		// inspection of NDP messages (e.g. by RA Guard).
+		if hasFragmentHeader {
+			ndpStats.FragmentedMessagesDropped.Increment()
+			return false
+		}
+
 		// As per RFC 4861 sections 4.1 - 4.5, 6.1.1, 6.1.2, 7.1.1, 7.1.2 and
 		// 8.1, nodes MUST silently drop NDP packets where the Hop Limit field
 		// in the IPv6 header is not set to 255, or the ICMPv6 Code field is not
 		// set to 0.
-		//
-		// As per RFC 6980 section 5, nodes MUST silently drop NDP messages if the
-		// packet includes a fragmentation header.
-		return !hasFragmentHeader && iph.HopLimit() == header.NDPHopLimit && h.Code() == 0
+		if iph.HopLimit() != header.NDPHopLimit {
+			ndpStats.InvalidHopLimitDropped.Increment()
+			return false
+		}
+		if h.Code() != 0 {
+			ndpStats.InvalidCodeDropped.Increment()
+			return false
+		}
+
+		if pkt.Data.Size() < minimumSize {
+			ndpStats.MalformedMessagesDropped.Increment()
+			return false
+		}
+		return true
 	}
 
 	// TODO(b/112892170): Meaningfully handle all ICMP types.
@@ -189,13 +229,15 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 		switch header.ICMPv6(hdr).Code() {
 		case header.ICMPv6NetworkUnreachable:
 			e.handleControl(stack.ControlNetworkUnreachable, 0, pkt)
+		case header.ICMPv6AddressUnreachable:
+			e.handleControl(stack.ControlNoRoute, 0, pkt)
 		case header.ICMPv6PortUnreachable:
 			e.handleControl(stack.ControlPortUnreachable, 0, pkt)
 		}
 
 	case header.ICMPv6NeighborSolicit:
 		received.NeighborSolicit.Increment()
-		if !isNDPValid() || pkt.Data.Size() < header.ICMPv6NeighborSolicitMinimumSize {
+		if !isNDPValid(header.ICMPv6NeighborSolicitMinimumSize) {
 			received.Invalid.Increment()
 			return
 		}
@@ -210,11 +252,12 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 		// As per RFC 4861 section 4.3, the Target Address MUST NOT be a multicast
 		// address.
 		if header.IsV6MulticastAddress(targetAddr) {
+			ndpStats.InvalidTargetDropped.Increment()
 			received.Invalid.Increment()
 			return
 		}
 
-		if e.hasTentativeAddr(targetAddr) {
+		if e.isCheckingDuplicateAddress(targetAddr) {
 			// If the target address is tentative and the source of the packet is a
 			// unicast (specified) address, then the source of the packet is
 			// attempting to perform address resolution on the target. In this case,
@@ -229,7 +272,8 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 			if srcAddr == header.IPv6Any {
 				// We would get an error if the address no longer exists or the address
 				// is no longer tentative (DAD resolved between the call to
-				// hasTentativeAddr and this point). Both of these are valid scenarios:
+				// isCheckingDuplicateAddress and this point). Both of these are valid
+				// scenarios:
 				//   1) An address may be removed at any time.
 				//   2) As per RFC 4862 section 5.4, DAD is not a perfect:
 				//       "Note that the method for detecting duplicates
@@ -238,9 +282,11 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 				//
 				// TODO(gvisor.dev/issue/4046): Handle the scenario when a duplicate
 				// address is detected for an assigned address.
-				if err := e.dupTentativeAddrDetected(targetAddr); err != nil && err != tcpip.ErrBadAddress && err != tcpip.ErrInvalidEndpointState {
+				if err := e.dupTentativeAddrDetected(targetAddr, "" /* holderLinkAddr */); err != nil && err != tcpip.ErrBadAddress && err != tcpip.ErrInvalidEndpointState {
 					panic(fmt.Sprintf("unexpected error handling duplicate tentative address: %s", err))
 				}
+			} else {
+				ndpStats.TentativeTargetSolicitsIgnored.Increment()
 			}
 
 			// Do not handle neighbor solicitations targeted to an address that is
@@ -252,9 +298,17 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 		// so the packet is processed as defined in RFC 4861, as per RFC 4862
 		// section 5.4.3.
 
-		// Is the NS targeting us?
+		// Is the NS targeting us or an address we are proxying?
+		//
+		// As per RFC 4861 section 7.2.8, a proxy answers Neighbor Solicitations
+		// for target addresses it is willing to accept packets on behalf of.
+		proxied := false
 		if e.protocol.stack.CheckLocalAddress(e.nic.ID(), ProtocolNumber, targetAddr) == 0 {
-			return
+			if !e.isProxiedAddress(targetAddr) {
+				ndpStats.UnknownTargetSolicitsIgnored.Increment()
+				return
+			}
+			proxied = true
 		}
 
 		var sourceLinkAddr tcpip.LinkAddress
@@ -263,12 +317,14 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 			if err != nil {
 				// Options are not valid as per the wire format, silently drop the
 				// packet.
+				ndpStats.MalformedMessagesDropped.Increment()
 				received.Invalid.Increment()
 				return
 			}
 
 			sourceLinkAddr, ok = getSourceLinkAddr(it)
 			if !ok {
+				ndpStats.InvalidLinkLayerOptionDropped.Increment()
 				received.Invalid.Increment()
 				return
 			}
@@ -282,10 +338,12 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 		unspecifiedSource := srcAddr == header.IPv6Any
 		if len(sourceLinkAddr) == 0 {
 			if header.IsV6MulticastAddress(dstAddr) && !unspecifiedSource {
+				ndpStats.InvalidLinkLayerOptionDropped.Increment()
 				received.Invalid.Increment()
 				return
 			}
 		} else if unspecifiedSource {
+			ndpStats.InvalidLinkLayerOptionDropped.Increment()
 			received.Invalid.Increment()
 			return
 		} else if e.nud != nil {
@@ -301,6 +359,7 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 		//    - If the IP source address is the unspecified address, the IP
 		//      destination address is a solicited-node multicast address.
 		if unspecifiedSource && !header.IsSolicitedNodeAddr(dstAddr) {
+			ndpStats.InvalidDestinationDropped.Increment()
 			received.Invalid.Increment()
 			return
 		}
@@ -315,11 +374,23 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 			remoteAddr = header.IPv6AllNodesMulticastAddress
 		}
 
+		if !e.icmpRateLimits.allowND() {
+			sent.RateLimited.Increment()
+			return
+		}
+
 		// Even if we were able to receive a packet from some remote, we may not
 		// have a route to it - the remote may be blocked via routing rules. We must
 		// always consult our routing table and find a route to the remote before
 		// sending any packet.
-		r, err := e.protocol.stack.FindRoute(e.nic.ID(), targetAddr, remoteAddr, ProtocolNumber, false /* multicastLoop */)
+		//
+		// A proxied target address is not assigned to us so the source address of
+		// the advertisement is selected by the stack.
+		localAddr := targetAddr
+		if proxied {
+			localAddr = ""
+		}
+		r, err := e.protocol.stack.FindRoute(e.nic.ID(), localAddr, remoteAddr, ProtocolNumber, false /* multicastLoop */)
 		if err != nil {
 			// If we cannot find a route to the destination, silently drop the packet.
 			return
@@ -367,7 +438,12 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 		//   set the Solicited flag to one and [..].
 		//
 		na.SetSolicitedFlag(!unspecifiedSource)
-		na.SetOverrideFlag(true)
+		// As per RFC 4861 section 7.2.8, a proxy sets the Override flag to zero so
+		// the advertisement does not override the target's own advertisements.
+		// Proxied solicitations are only answered while forwarding so we also
+		// let the neighbor know we are a router.
+		na.SetOverrideFlag(!proxied)
+		na.SetRouterFlag(proxied)
 		na.SetTargetAddress(targetAddr)
 		na.Options().Serialize(optsSerializer)
 		packet.SetChecksum(header.ICMPv6Checksum(packet, r.LocalAddress, r.RemoteAddress, buffer.VectorisedView{}))
@@ -386,7 +462,7 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 
 	case header.ICMPv6NeighborAdvert:
 		received.NeighborAdvert.Increment()
-		if !isNDPValid() || pkt.Data.Size() < header.ICMPv6NeighborAdvertMinimumSize {
+		if !isNDPValid(header.ICMPv6NeighborAdvertMinimumSize) {
 			received.Invalid.Increment()
 			return
 		}
@@ -397,7 +473,7 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 		// NDP datagrams are very small and ToView() will not incur allocations.
 		na := header.NDPNeighborAdvert(payload.ToView())
 		targetAddr := na.TargetAddress()
-		if e.hasTentativeAddr(targetAddr) {
+		if e.isCheckingDuplicateAddress(targetAddr) {
 			// We just got an NA from a node that owns an address we are performing
 			// DAD on, implying the address is not unique. In this case we let the
 			// stack know so it can handle such a scenario and do nothing furthur with
@@ -405,7 +481,8 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 			//
 			// We would get an error if the address no longer exists or the address
 			// is no longer tentative (DAD resolved between the call to
-			// hasTentativeAddr and this point). Both of these are valid scenarios:
+			// isCheckingDuplicateAddress and this point). Both of these are valid
+			// scenarios:
 			//   1) An address may be removed at any time.
 			//   2) As per RFC 4862 section 5.4, DAD is not a perfect:
 			//       "Note that the method for detecting duplicates
@@ -414,7 +491,11 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 			//
 			// TODO(gvisor.dev/issue/4046): Handle the scenario when a duplicate
 			// address is detected for an assigned address.
-			if err := e.dupTentativeAddrDetected(targetAddr); err != nil && err != tcpip.ErrBadAddress && err != tcpip.ErrInvalidEndpointState {
+			var holderLinkAddr tcpip.LinkAddress
+			if it, err := na.Options().Iter(false /* check */); err == nil {
+				holderLinkAddr, _ = getTargetLinkAddr(it)
+			}
+			if err := e.dupTentativeAddrDetected(targetAddr, holderLinkAddr); err != nil && err != tcpip.ErrBadAddress && err != tcpip.ErrInvalidEndpointState {
 				panic(fmt.Sprintf("unexpected error handling duplicate tentative address: %s", err))
 			}
 			return
@@ -423,6 +504,7 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 		it, err := na.Options().Iter(false /* check */)
 		if err != nil {
 			// If we have a malformed NDP NA option, drop the packet.
+			ndpStats.MalformedMessagesDropped.Increment()
 			received.Invalid.Increment()
 			return
 		}
@@ -438,6 +520,7 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 		// DAD.
 		targetLinkAddr, ok := getTargetLinkAddr(it)
 		if !ok {
+			ndpStats.InvalidLinkLayerOptionDropped.Increment()
 			received.Invalid.Increment()
 			return
 		}
@@ -508,6 +591,14 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 
 	case header.ICMPv6TimeExceeded:
 		received.TimeExceeded.Increment()
+		hdr, ok := pkt.Data.PullUp(header.ICMPv6ErrorHeaderSize)
+		if !ok {
+			received.Invalid.Increment()
+			return
+		}
+		code := header.ICMPv6(hdr).Code()
+		pkt.Data.TrimFront(header.ICMPv6ErrorHeaderSize)
+		e.handleControl(stack.ControlTimeExceeded, uint32(code), pkt)
 
 	case header.ICMPv6ParamProblem:
 		received.ParamProblem.Increment()
@@ -520,16 +611,15 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 		//
 
 		// Is the NDP payload of sufficient size to hold a Router Solictation?
-		if !isNDPValid() || pkt.Data.Size()-header.ICMPv6HeaderSize < header.NDPRSMinimumSize {
+		if !isNDPValid(header.ICMPv6HeaderSize + header.NDPRSMinimumSize) {
 			received.Invalid.Increment()
 			return
 		}
 
-		stack := e.protocol.stack
-
-		// Is the networking stack operating as a router?
-		if !stack.Forwarding(ProtocolNumber) {
+		// Is the endpoint operating as a router?
+		if !e.Forwarding() {
 			// ... No, silently drop the packet.
+			ndpStats.RouterSolicitsIgnored.Increment()
 			received.RouterOnlyPacketsDroppedByHost.Increment()
 			return
 		}
@@ -540,12 +630,14 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 		it, err := rs.Options().Iter(false /* check */)
 		if err != nil {
 			// Options are not valid as per the wire format, silently drop the packet.
+			ndpStats.MalformedMessagesDropped.Increment()
 			received.Invalid.Increment()
 			return
 		}
 
 		sourceLinkAddr, ok := getSourceLinkAddr(it)
 		if !ok {
+			ndpStats.InvalidLinkLayerOptionDropped.Increment()
 			received.Invalid.Increment()
 			return
 		}
@@ -557,6 +649,7 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 			// NOT be included when the source IP address is the unspecified address.
 			// Otherwise, it SHOULD be included on link layers that have addresses.
 			if srcAddr == header.IPv6Any {
+				ndpStats.InvalidLinkLayerOptionDropped.Increment()
 				received.Invalid.Increment()
 				return
 			}
@@ -576,7 +669,7 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 		//
 
 		// Is the NDP payload of sufficient size to hold a Router Advertisement?
-		if !isNDPValid() || pkt.Data.Size()-header.ICMPv6HeaderSize < header.NDPRAMinimumSize {
+		if !isNDPValid(header.ICMPv6HeaderSize + header.NDPRAMinimumSize) {
 			received.Invalid.Increment()
 			return
 		}
@@ -586,6 +679,7 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 		// Is the IP Source Address a link-local address?
 		if !header.IsV6LinkLocalAddress(routerAddr) {
 			// ...No, silently drop the packet.
+			ndpStats.NonLinkLocalSourceDropped.Increment()
 			received.Invalid.Increment()
 			return
 		}
@@ -596,12 +690,14 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 		it, err := ra.Options().Iter(false /* check */)
 		if err != nil {
 			// Options are not valid as per the wire format, silently drop the packet.
+			ndpStats.MalformedMessagesDropped.Increment()
 			received.Invalid.Increment()
 			return
 		}
 
 		sourceLinkAddr, ok := getSourceLinkAddr(it)
 		if !ok {
+			ndpStats.InvalidLinkLayerOptionDropped.Increment()
 			received.Invalid.Increment()
 			return
 		}
@@ -611,6 +707,13 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 		// as RFC 4861 section 6.1.2 is concerned.
 		//
 
+		// Let the integrator reject the Router Advertisement before we act on any
+		// of its contents.
+		if raGuard := e.protocol.options.RAGuard; raGuard != nil && !raGuard.ShouldAcceptRouterAdvert(e.nic.ID(), routerAddr, sourceLinkAddr, ra) {
+			ndpStats.RouterAdvertsRejected.Increment()
+			return
+		}
+
 		// If the RA has the source link layer option, update the link address
 		// cache with the link address for the advertised router.
 		if len(sourceLinkAddr) != 0 && e.nud != nil {
@@ -622,8 +725,97 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 		e.mu.Unlock()
 
 	case header.ICMPv6RedirectMsg:
-		// TODO(gvisor.dev/issue/2285): Call `e.nud.HandleProbe` after validating
-		// this redirect message, as per RFC 4871 section 7.3.3:
+		received.RedirectMsg.Increment()
+
+		//
+		// Validate the Redirect as per RFC 4861 section 8.1.
+		//
+
+		// Is the NDP payload of sufficient size to hold a Redirect message?
+		if !isNDPValid(header.ICMPv6HeaderSize + header.NDPRedirectMinimumSize) {
+			received.Invalid.Increment()
+			return
+		}
+
+		routerAddr := srcAddr
+
+		// Is the IP Source Address a link-local address?
+		if !header.IsV6LinkLocalAddress(routerAddr) {
+			// ...No, silently drop the packet.
+			ndpStats.NonLinkLocalSourceDropped.Increment()
+			received.Invalid.Increment()
+			return
+		}
+
+		// Note that in the common case NDP datagrams are very small and ToView()
+		// will not incur allocations.
+		rd := header.NDPRedirect(payload.ToView())
+		dst := rd.DestinationAddress()
+		target := rd.TargetAddress()
+
+		// As per RFC 4861 section 8.1, the ICMP Destination Address MUST NOT be a
+		// multicast address, and the ICMP Target Address MUST be either a
+		// link-local address (when redirected to a router) or the same as the ICMP
+		// Destination Address (when redirected to the on-link destination).
+		if header.IsV6MulticastAddress(dst) || (target != dst && !header.IsV6LinkLocalAddress(target)) {
+			ndpStats.InvalidTargetDropped.Increment()
+			received.Invalid.Increment()
+			return
+		}
+
+		it, err := rd.Options().Iter(false /* check */)
+		if err != nil {
+			// Options are not valid as per the wire format, silently drop the packet.
+			ndpStats.MalformedMessagesDropped.Increment()
+			received.Invalid.Increment()
+			return
+		}
+
+		targetLinkAddr, ok := getTargetLinkAddr(it)
+		if !ok {
+			ndpStats.InvalidLinkLayerOptionDropped.Increment()
+			received.Invalid.Increment()
+			return
+		}
+
+		e.mu.RLock()
+		handleRedirects := e.mu.ndp.configs.HandleRedirects
+		lifetime := e.mu.ndp.configs.RedirectLifetime
+		e.mu.RUnlock()
+
+		// As per RFC 4861 section 8.2, a router MUST NOT update its routing tables
+		// upon receipt of a Redirect.
+		if !handleRedirects || e.Forwarding() {
+			ndpStats.RedirectsIgnored.Increment()
+			return
+		}
+
+		// As per RFC 4861 section 8.1, the IP source address of the Redirect MUST
+		// be the same as the current first-hop router for the specified ICMP
+		// Destination Address.
+		if !e.isFirstHopRouter(routerAddr, dst) {
+			ndpStats.RedirectsIgnored.Increment()
+			return
+		}
+
+		//
+		// At this point, we have a valid Redirect, as far as RFC 4861 section 8.1
+		// is concerned.
+		//
+
+		var nextHop tcpip.Address
+		if target != dst {
+			nextHop = target
+		}
+		if !e.redirects.update(dst, nextHop, lifetime) {
+			ndpStats.RedirectsIgnored.Increment()
+			return
+		}
+
+		// As per RFC 4861 section 8.3, if the Redirect contains a Target Link-Layer
+		// Address option, the Neighbor Cache entry for the target is created or
+		// updated and its reachability state set to STALE. This is done after
+		// validating the redirect message, as per RFC 4861 section 7.3.3:
 		//
 		//    "A Neighbor Cache entry enters the STALE state when created as a
 		//    result of receiving packets other than solicited Neighbor
@@ -638,10 +830,16 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 		//    link-layer address be modified due to receiving one of the above
 		//    messages, the state SHOULD also be set to STALE to provide prompt
 		//    verification that the path to the new link-layer address is working."
-		received.RedirectMsg.Increment()
-		if !isNDPValid() {
-			received.Invalid.Increment()
-			return
+		if len(targetLinkAddr) != 0 {
+			if e.nud != nil {
+				e.nud.HandleProbe(target, ProtocolNumber, targetLinkAddr, e.protocol)
+			} else {
+				e.linkAddrCache.AddLinkAddress(e.nic.ID(), target, targetLinkAddr)
+			}
+		}
+
+		if ndpDisp := e.protocol.options.NDPDisp; ndpDisp != nil {
+			ndpDisp.OnRedirectAccepted(e.nic.ID(), routerAddr, dst, target)
 		}
 
 	case header.ICMPv6MulticastListenerQuery, header.ICMPv6MulticastListenerReport, header.ICMPv6MulticastListenerDone:
@@ -663,6 +861,13 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 			return
 		}
 
+		// As per RFC 2710 section 3, MLD messages are sent with a Router Alert
+		// option in a Hop-by-Hop Options header.
+		if !mldRouterAlert && e.ExtHdrOptionsPolicy().RequireMLDRouterAlert {
+			e.protocol.stack.Stats().IP.ExtHdrOptions.MLDWithoutRouterAlertDropped.Increment()
+			return
+		}
+
 		if handler != nil {
 			handler(header.MLD(payload.ToView()))
 		}
@@ -687,6 +892,13 @@ func (p *protocol) LinkAddressRequest(targetAddr, localAddr tcpip.Address, remot
 		remoteLinkAddr = header.EthernetAddressFromMulticastIPv6Address(remoteAddr)
 	}
 
+	stat := p.stack.Stats().ICMP.V6.PacketsSent
+	ep, _ := p.stack.GetNetworkEndpoint(nic.ID(), ProtocolNumber)
+	if e, ok := ep.(*endpoint); ok && !e.icmpRateLimits.allowND() {
+		stat.RateLimited.Increment()
+		return nil
+	}
+
 	r, err := p.stack.FindRoute(nic.ID(), localAddr, remoteAddr, ProtocolNumber, false /* multicastLoop */)
 	if err != nil {
 		return err
@@ -709,7 +921,6 @@ func (p *protocol) LinkAddressRequest(targetAddr, localAddr tcpip.Address, remot
 	ns.Options().Serialize(optsSerializer)
 	packet.SetChecksum(header.ICMPv6Checksum(packet, r.LocalAddress, r.RemoteAddress, buffer.VectorisedView{}))
 
-	stat := p.stack.Stats().ICMP.V6.PacketsSent
 	if err := r.WritePacket(nil /* gso */, stack.NetworkHeaderParams{
 		Protocol: header.ICMPv6ProtocolNumber,
 		TTL:      header.NDPHopLimit,
@@ -935,6 +1146,11 @@ func (p *protocol) returnError(reason icmpReason, pkt *stack.PacketBuffer) *tcpi
 	default:
 		panic(fmt.Sprintf("unsupported ICMP type %T", reason))
 	}
+	ep, _ := p.stack.GetNetworkEndpoint(route.NICID(), ProtocolNumber)
+	if e, ok := ep.(*endpoint); ok && !e.icmpRateLimits.allowError(icmpHdr.Type()) {
+		sent.RateLimited.Increment()
+		return nil
+	}
 	icmpHdr.SetChecksum(header.ICMPv6Checksum(icmpHdr, route.LocalAddress, route.RemoteAddress, newPkt.Data))
 	if err := route.WritePacket(
 		nil, /* gso */
@@ -0,0 +1,198 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv6
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// ICMPv6TypeSet is a set of ICMPv6 message types.
+type ICMPv6TypeSet [4]uint64
+
+// Add adds t to the set.
+func (s *ICMPv6TypeSet) Add(t header.ICMPv6Type) {
+	s[t/64] |= 1 << (t % 64)
+}
+
+// Contains returns true if t is in the set.
+func (s ICMPv6TypeSet) Contains(t header.ICMPv6Type) bool {
+	return s[t/64]&(1<<(t%64)) != 0
+}
+
+// ICMPRateLimits are the limits an endpoint applies to the rate at which it
+// generates ICMPv6 errors and Neighbor Discovery messages, on top of the
+// stack-wide ICMP rate limit.
+//
+// Limits set to 0 are not enforced.
+type ICMPRateLimits struct {
+	// ErrorLimit is the maximum number of ICMPv6 error messages the endpoint
+	// generates per second, equivalent to Linux's net.ipv6.icmp.ratelimit.
+	ErrorLimit rate.Limit
+
+	// ErrorBurst is the maximum number of ICMPv6 error messages the endpoint
+	// generates in a single burst. A burst less than 1 is treated as 1.
+	ErrorBurst int
+
+	// ErrorExemptTypes holds the ICMPv6 error types that ErrorLimit does not
+	// apply to, the complement of Linux's net.ipv6.icmp.ratemask.
+	ErrorExemptTypes ICMPv6TypeSet
+
+	// NDLimit is the maximum number of Neighbor Solicitations sent to resolve
+	// or probe neighbors and Neighbor Advertisements sent in response to
+	// solicitations, per second.
+	//
+	// Neighbor Solicitations sent for Duplicate Address Detection and
+	// unsolicited Neighbor Advertisements are not limited as they are only sent
+	// when addresses are assigned.
+	NDLimit rate.Limit
+
+	// NDBurst is the maximum number of Neighbor Discovery messages subject to
+	// NDLimit that the endpoint sends in a single burst. A burst less than 1 is
+	// treated as 1.
+	NDBurst int
+}
+
+// ICMPRateLimitStats holds the number of messages an endpoint did not send
+// because of its ICMPRateLimits.
+type ICMPRateLimitStats struct {
+	// ErrorsRateLimited is the number of ICMPv6 error messages not sent.
+	ErrorsRateLimited tcpip.StatCounter
+
+	// NDRateLimited is the number of Neighbor Discovery messages not sent.
+	NDRateLimited tcpip.StatCounter
+}
+
+// ICMPRateLimitEndpoint is a network endpoint that limits the rate at which
+// it generates ICMPv6 messages.
+type ICMPRateLimitEndpoint interface {
+	// SetICMPRateLimits sets the endpoint's ICMPv6 rate limits.
+	SetICMPRateLimits(ICMPRateLimits)
+
+	// ICMPRateLimits returns the endpoint's ICMPv6 rate limits.
+	ICMPRateLimits() ICMPRateLimits
+
+	// ICMPRateLimitStats returns the endpoint's rate limiting statistics.
+	ICMPRateLimitStats() *ICMPRateLimitStats
+}
+
+var _ ICMPRateLimitEndpoint = (*endpoint)(nil)
+
+// SetICMPRateLimits implements ICMPRateLimitEndpoint.
+func (e *endpoint) SetICMPRateLimits(limits ICMPRateLimits) {
+	e.icmpRateLimits.setLimits(limits)
+}
+
+// ICMPRateLimits implements ICMPRateLimitEndpoint.
+func (e *endpoint) ICMPRateLimits() ICMPRateLimits {
+	e.icmpRateLimits.mu.Lock()
+	defer e.icmpRateLimits.mu.Unlock()
+	return e.icmpRateLimits.mu.limits
+}
+
+// ICMPRateLimitStats implements ICMPRateLimitEndpoint.
+func (e *endpoint) ICMPRateLimitStats() *ICMPRateLimitStats {
+	return &e.icmpRateLimits.stats
+}
+
+// icmpRateLimitState is the per-interface state used to limit the rate at
+// which ICMPv6 messages are generated.
+//
+// icmpRateLimitState is protected by its own lock instead of the endpoint's
+// lock as ICMPv6 errors are generated while the endpoint's lock may be held.
+//
+// icmpRateLimitState.init MUST be called to initialize the state.
+type icmpRateLimitState struct {
+	clock tcpip.Clock
+
+	stats ICMPRateLimitStats
+
+	mu struct {
+		sync.Mutex
+
+		limits ICMPRateLimits
+
+		// errors and nd are nil when their limit is not enforced.
+		errors *rate.Limiter
+		nd     *rate.Limiter
+	}
+}
+
+// init sets up an icmpRateLimitState struct, and is required to be called
+// before using a new icmpRateLimitState.
+func (s *icmpRateLimitState) init(clock tcpip.Clock, limits ICMPRateLimits) {
+	s.clock = clock
+	s.setLimits(limits)
+}
+
+// newLimiter returns a new token bucket rate limiter, or nil if limit is not
+// enforced.
+func newLimiter(limit rate.Limit, burst int) *rate.Limiter {
+	if limit <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(limit, burst)
+}
+
+// setLimits replaces the limits, refilling the token buckets.
+func (s *icmpRateLimitState) setLimits(limits ICMPRateLimits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mu.limits = limits
+	s.mu.errors = newLimiter(limits.ErrorLimit, limits.ErrorBurst)
+	s.mu.nd = newLimiter(limits.NDLimit, limits.NDBurst)
+}
+
+// now returns the current time according to the stack's clock so the rate
+// limiters follow the stack's notion of time.
+func (s *icmpRateLimitState) now() time.Time {
+	return time.Unix(0, s.clock.NowMonotonic())
+}
+
+// allowError returns true if an ICMPv6 error of type typ may be sent.
+func (s *icmpRateLimitState) allowError(typ header.ICMPv6Type) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.mu.errors == nil || s.mu.limits.ErrorExemptTypes.Contains(typ) {
+		return true
+	}
+	if s.mu.errors.AllowN(s.now(), 1) {
+		return true
+	}
+	s.stats.ErrorsRateLimited.Increment()
+	return false
+}
+
+// allowND returns true if a rate limited Neighbor Discovery message may be
+// sent.
+func (s *icmpRateLimitState) allowND() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.mu.nd == nil || s.mu.nd.AllowN(s.now(), 1) {
+		return true
+	}
+	s.stats.NDRateLimited.Increment()
+	return false
+}
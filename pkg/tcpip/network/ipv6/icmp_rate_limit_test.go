@@ -0,0 +1,233 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv6
+
+import (
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+// drainPackets reads all the packets sent through e and returns how many of
+// them were ICMPv6 messages of type typ.
+func drainPackets(e *channel.Endpoint, typ header.ICMPv6Type) int {
+	n := 0
+	for {
+		p, ok := e.Read()
+		if !ok {
+			return n
+		}
+		payload := stack.PayloadSince(p.Pkt.NetworkHeader())
+		if header.ICMPv6(header.IPv6(payload).Payload()).Type() == typ {
+			n++
+		}
+	}
+}
+
+func TestICMPErrorRateLimit(t *testing.T) {
+	const (
+		nicID     = 1
+		localPort = 1234
+	)
+
+	tests := []struct {
+		name        string
+		limits      ICMPRateLimits
+		wantSent    int
+		wantLimited uint64
+	}{
+		{
+			name:     "Not enforced",
+			wantSent: 3,
+		},
+		{
+			name:        "Limited",
+			limits:      ICMPRateLimits{ErrorLimit: 1, ErrorBurst: 2},
+			wantSent:    2,
+			wantLimited: 1,
+		},
+		{
+			name:        "Zero burst",
+			limits:      ICMPRateLimits{ErrorLimit: 1},
+			wantSent:    1,
+			wantLimited: 2,
+		},
+		{
+			name: "Exempt type",
+			limits: func() ICMPRateLimits {
+				limits := ICMPRateLimits{ErrorLimit: 1, ErrorBurst: 1}
+				limits.ErrorExemptTypes.Add(header.ICMPv6DstUnreachable)
+				return limits
+			}(),
+			wantSent: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := faketime.NewManualClock()
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{NewProtocolWithOptions(Options{
+					ICMPRateLimits: test.limits,
+				})},
+				TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+				Clock:              clock,
+			})
+			e := channel.New(10, 1280, linkAddr0)
+			if err := s.CreateNIC(nicID, e); err != nil {
+				t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+			}
+			if err := s.AddAddress(nicID, ProtocolNumber, lladdr0); err != nil {
+				t.Fatalf("AddAddress(%d, %d, %s) = %s", nicID, ProtocolNumber, lladdr0, err)
+			}
+			s.SetRouteTable([]tcpip.Route{{Destination: header.IPv6EmptySubnet, NIC: nicID}})
+
+			injectUDP := func() {
+				hdr := buffer.NewPrependable(header.IPv6MinimumSize + header.UDPMinimumSize)
+				u := header.UDP(hdr.Prepend(header.UDPMinimumSize))
+				u.Encode(&header.UDPFields{
+					SrcPort: localPort + 1,
+					DstPort: localPort,
+					Length:  header.UDPMinimumSize,
+				})
+				sum := header.PseudoHeaderChecksum(udp.ProtocolNumber, lladdr1, lladdr0, header.UDPMinimumSize)
+				u.SetChecksum(^u.CalculateChecksum(sum))
+				ip := header.IPv6(hdr.Prepend(header.IPv6MinimumSize))
+				ip.Encode(&header.IPv6Fields{
+					PayloadLength: header.UDPMinimumSize,
+					NextHeader:    uint8(udp.ProtocolNumber),
+					HopLimit:      64,
+					SrcAddr:       lladdr1,
+					DstAddr:       lladdr0,
+				})
+				e.InjectInbound(ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+					Data: hdr.View().ToVectorisedView(),
+				}))
+			}
+
+			for i := 0; i < 3; i++ {
+				injectUDP()
+			}
+			if got := drainPackets(e, header.ICMPv6DstUnreachable); got != test.wantSent {
+				t.Errorf("got %d Destination Unreachable messages, want = %d", got, test.wantSent)
+			}
+
+			ep, err := s.GetNetworkEndpoint(nicID, ProtocolNumber)
+			if err != nil {
+				t.Fatalf("s.GetNetworkEndpoint(%d, %d): %s", nicID, ProtocolNumber, err)
+			}
+			if got := ep.(ICMPRateLimitEndpoint).ICMPRateLimitStats().ErrorsRateLimited.Value(); got != test.wantLimited {
+				t.Errorf("got ErrorsRateLimited = %d, want = %d", got, test.wantLimited)
+			}
+			if got := s.Stats().ICMP.V6.PacketsSent.RateLimited.Value(); got != test.wantLimited {
+				t.Errorf("got RateLimited = %d, want = %d", got, test.wantLimited)
+			}
+
+			// Tokens should be replenished over time.
+			clock.Advance(time.Second)
+			injectUDP()
+			if got := drainPackets(e, header.ICMPv6DstUnreachable); got != 1 {
+				t.Errorf("got %d Destination Unreachable messages after replenishing tokens, want = 1", got)
+			}
+		})
+	}
+}
+
+func TestNDRateLimit(t *testing.T) {
+	const nicID = 1
+
+	clock := faketime.NewManualClock()
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{NewProtocol},
+		Clock:            clock,
+	})
+	e := channel.New(10, 1280, linkAddr0)
+	if err := s.CreateNIC(nicID, e); err != nil {
+		t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+	}
+	if err := s.AddAddress(nicID, ProtocolNumber, lladdr0); err != nil {
+		t.Fatalf("AddAddress(%d, %d, %s) = %s", nicID, ProtocolNumber, lladdr0, err)
+	}
+	s.SetRouteTable([]tcpip.Route{{Destination: header.IPv6EmptySubnet, NIC: nicID}})
+
+	netEP, err := s.GetNetworkEndpoint(nicID, ProtocolNumber)
+	if err != nil {
+		t.Fatalf("s.GetNetworkEndpoint(%d, %d): %s", nicID, ProtocolNumber, err)
+	}
+	ep := netEP.(ICMPRateLimitEndpoint)
+	limits := ICMPRateLimits{NDLimit: 1, NDBurst: 1}
+	ep.SetICMPRateLimits(limits)
+	if got := ep.ICMPRateLimits(); got != limits {
+		t.Errorf("got ep.ICMPRateLimits() = %+v, want = %+v", got, limits)
+	}
+
+	injectNS := func() {
+		optsSerializer := header.NDPOptionsSerializer{
+			header.NDPSourceLinkLayerAddressOption(linkAddr1),
+		}
+		nsSize := header.ICMPv6NeighborSolicitMinimumSize + optsSerializer.Length()
+		hdr := buffer.NewPrependable(header.IPv6MinimumSize + nsSize)
+		pkt := header.ICMPv6(hdr.Prepend(nsSize))
+		pkt.SetType(header.ICMPv6NeighborSolicit)
+		ns := header.NDPNeighborSolicit(pkt.MessageBody())
+		ns.SetTargetAddress(lladdr0)
+		ns.Options().Serialize(optsSerializer)
+		pkt.SetChecksum(header.ICMPv6Checksum(pkt, lladdr1, lladdr0, buffer.VectorisedView{}))
+		ip := header.IPv6(hdr.Prepend(header.IPv6MinimumSize))
+		ip.Encode(&header.IPv6Fields{
+			PayloadLength: uint16(nsSize),
+			NextHeader:    uint8(header.ICMPv6ProtocolNumber),
+			HopLimit:      header.NDPHopLimit,
+			SrcAddr:       lladdr1,
+			DstAddr:       lladdr0,
+		})
+		e.InjectInbound(ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Data: hdr.View().ToVectorisedView(),
+		}))
+	}
+
+	injectNS()
+	injectNS()
+	if got := drainPackets(e, header.ICMPv6NeighborAdvert); got != 1 {
+		t.Errorf("got %d Neighbor Advertisements, want = 1", got)
+	}
+
+	// Neighbor Solicitations sent to resolve neighbors share the same limit.
+	linkRes := s.NetworkProtocolInstance(ProtocolNumber).(stack.LinkAddressResolver)
+	if err := linkRes.LinkAddressRequest(lladdr2, lladdr0, "", &testInterface{LinkEndpoint: e, nicID: nicID}); err != nil {
+		t.Fatalf("LinkAddressRequest(%s, %s, '', _): %s", lladdr2, lladdr0, err)
+	}
+	if got := drainPackets(e, header.ICMPv6NeighborSolicit); got != 0 {
+		t.Errorf("got %d Neighbor Solicitations, want = 0", got)
+	}
+	if got := ep.ICMPRateLimitStats().NDRateLimited.Value(); got != 2 {
+		t.Errorf("got NDRateLimited = %d, want = 2", got)
+	}
+
+	clock.Advance(time.Second)
+	if err := linkRes.LinkAddressRequest(lladdr2, lladdr0, "", &testInterface{LinkEndpoint: e, nicID: nicID}); err != nil {
+		t.Fatalf("LinkAddressRequest(%s, %s, '', _): %s", lladdr2, lladdr0, err)
+	}
+	if got := drainPackets(e, header.ICMPv6NeighborSolicit); got != 1 {
+		t.Errorf("got %d Neighbor Solicitations, want = 1", got)
+	}
+}
@@ -269,7 +269,7 @@ func TestICMPCounts(t *testing.T) {
 				},
 				{
 					typ:  header.ICMPv6RedirectMsg,
-					size: header.ICMPv6MinimumSize,
+					size: header.ICMPv6RedirectMinimumSize,
 				},
 				{
 					typ:  header.ICMPv6MulticastListenerQuery,
@@ -427,7 +427,7 @@ func TestICMPCountsWithNeighborCache(t *testing.T) {
 		},
 		{
 			typ:  header.ICMPv6RedirectMsg,
-			size: header.ICMPv6MinimumSize,
+			size: header.ICMPv6RedirectMinimumSize,
 		},
 		{
 			typ:  header.ICMPv6MulticastListenerQuery,
@@ -779,7 +779,7 @@ func TestICMPChecksumValidationSimple(t *testing.T) {
 		{
 			name: "RedirectMsg",
 			typ:  header.ICMPv6RedirectMsg,
-			size: header.ICMPv6MinimumSize,
+			size: header.ICMPv6RedirectMinimumSize,
 			statCounter: func(stats tcpip.ICMPv6ReceivedPacketStats) *tcpip.StatCounter {
 				return stats.RedirectMsg
 			},
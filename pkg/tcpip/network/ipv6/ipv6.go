@@ -17,12 +17,14 @@ package ipv6
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash/fnv"
 	"sort"
 	"sync/atomic"
 	"time"
 
+	"gvisor.dev/gvisor/pkg/rand"
 	"gvisor.dev/gvisor/pkg/sync"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/buffer"
@@ -30,6 +32,7 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip/header/parse"
 	"gvisor.dev/gvisor/pkg/tcpip/network/fragmentation"
 	"gvisor.dev/gvisor/pkg/tcpip/network/hash"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ip"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
 
@@ -63,6 +66,11 @@ const (
 var _ stack.GroupAddressableEndpoint = (*endpoint)(nil)
 var _ stack.AddressableEndpoint = (*endpoint)(nil)
 var _ stack.NetworkEndpoint = (*endpoint)(nil)
+var _ stack.ForwardingNetworkEndpoint = (*endpoint)(nil)
+var _ stack.DuplicateAddressDetector = (*endpoint)(nil)
+var _ stack.CarrierChangeHandler = (*endpoint)(nil)
+var _ stack.AddressLifetimesNetworkEndpoint = (*endpoint)(nil)
+var _ ip.DADProtocol = (*endpoint)(nil)
 var _ stack.NDPEndpoint = (*endpoint)(nil)
 var _ NDPEndpoint = (*endpoint)(nil)
 
@@ -80,14 +88,39 @@ type endpoint struct {
 	// Must be accessed using atomic operations.
 	enabled uint32
 
+	// forwarding is set to 1 when the endpoint has forwarding enabled and 0
+	// when it is disabled.
+	//
+	// Must be accessed using atomic operations.
+	forwarding uint32
+
+	// multicastForwarding is set to 1 when the endpoint has multicast
+	// forwarding enabled and 0 when it is disabled.
+	//
+	// Must be accessed using atomic operations.
+	multicastForwarding uint32
+
+	// forwardingRoutes caches the routes of the flows of the packets
+	// forwarded by the endpoint.
+	forwardingRoutes stack.ForwardingRouteCache
+
 	mu struct {
 		sync.RWMutex
 
 		addressableEndpointState stack.AddressableEndpointState
 		ndp                      ndpState
+		extHdrOptionsPolicy      ExtHdrOptionsPolicy
 	}
 
 	mld mldState
+
+	redirects redirectState
+
+	icmpRateLimits icmpRateLimitState
+
+	// reassemblyStats holds the fragment reassembly statistics for this
+	// endpoint.
+	reassemblyStats fragmentation.Stats
 }
 
 // NICNameFromID is a function that returns a stable name for the specified NIC,
@@ -135,13 +168,114 @@ func (e *endpoint) SetNDPConfigurations(c NDPConfigurations) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	e.mu.ndp.configs = c
+	e.mu.ndp.dad.SetConfigsLocked(c.dadConfigs())
+}
+
+// AddNDPProxy implements NDPEndpoint.
+func (e *endpoint) AddNDPProxy(subnet tcpip.Subnet) *tcpip.Error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.mu.ndp.addProxy(subnet)
+}
+
+// RemoveNDPProxy implements NDPEndpoint.
+func (e *endpoint) RemoveNDPProxy(subnet tcpip.Subnet) *tcpip.Error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.mu.ndp.removeProxy(subnet)
+}
+
+// NDPProxies implements NDPEndpoint.
+func (e *endpoint) NDPProxies() []tcpip.Subnet {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	subnets := make([]tcpip.Subnet, 0, len(e.mu.ndp.proxiedPrefixes))
+	for subnet := range e.mu.ndp.proxiedPrefixes {
+		subnets = append(subnets, subnet)
+	}
+	return subnets
+}
+
+// NDPInfo implements NDPEndpoint.
+func (e *endpoint) NDPInfo() NDPInfo {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.mu.ndp.info()
+}
+
+// isProxiedAddress returns true if e answers Neighbor Solicitations for addr
+// on behalf of another node.
+//
+// As per Linux, proxied Neighbor Solicitations are only answered when
+// forwarding is enabled.
+func (e *endpoint) isProxiedAddress(addr tcpip.Address) bool {
+	if !e.Forwarding() {
+		return false
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.mu.ndp.isProxiedAddress(addr)
+}
+
+// isProxiedNeighborSolicitation returns true if the packet is a Neighbor
+// Solicitation that may target an address e is proxying.
+//
+// Such solicitations must be handled locally even though they are not
+// destined to e, as per Linux.
+func (e *endpoint) isProxiedNeighborSolicitation(h header.IPv6, pkt *stack.PacketBuffer) bool {
+	if !e.Forwarding() || h.TransportProtocol() != header.ICMPv6ProtocolNumber {
+		return false
+	}
+
+	if icmpHdr, ok := pkt.Data.PullUp(header.ICMPv6MinimumSize); !ok || header.ICMPv6(icmpHdr).Type() != header.ICMPv6NeighborSolicit {
+		return false
+	}
+
+	dstAddr := h.DestinationAddress()
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.mu.ndp.isProxiedAddress(dstAddr) || e.mu.ndp.isProxiedSolicitedNodeAddr(dstAddr)
+}
+
+// CheckDuplicateAddress implements stack.DuplicateAddressDetector.
+func (e *endpoint) CheckDuplicateAddress(addr tcpip.Address, h stack.DADCompletionHandler) stack.DADCheckAddressDisposition {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.mu.ndp.dad.CheckDuplicateAddressLocked(addr, h)
+}
+
+// SetDADConfigurations implements stack.DuplicateAddressDetector.
+func (e *endpoint) SetDADConfigurations(c stack.DADConfigurations) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.mu.ndp.configs.DupAddrDetectTransmits = c.DupAddrDetectTransmits
+	e.mu.ndp.configs.RetransmitTimer = c.RetransmitTimer
+	e.mu.ndp.configs.validate()
+	e.mu.ndp.dad.SetConfigsLocked(c)
+}
+
+// DuplicateAddressProtocol implements stack.DuplicateAddressDetector.
+func (*endpoint) DuplicateAddressProtocol() tcpip.NetworkProtocolNumber {
+	return ProtocolNumber
+}
+
+// SendDADMessage implements ip.DADProtocol.
+func (e *endpoint) SendDADMessage(addr tcpip.Address) *tcpip.Error {
+	return e.mu.ndp.sendDADPacket(addr)
 }
 
-// hasTentativeAddr returns true if addr is tentative on e.
-func (e *endpoint) hasTentativeAddr(addr tcpip.Address) bool {
+// isCheckingDuplicateAddress returns true if addr is tentative on e or if DAD
+// is being performed for addr on behalf of an integrator.
+func (e *endpoint) isCheckingDuplicateAddress(addr tcpip.Address) bool {
 	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.mu.ndp.dad.IsRunningLocked(addr) {
+		return true
+	}
 	addressEndpoint := e.getAddressRLocked(addr)
-	e.mu.RUnlock()
 	return addressEndpoint != nil && addressEndpoint.GetKind() == stack.PermanentTentative
 }
 
@@ -150,13 +284,20 @@ func (e *endpoint) hasTentativeAddr(addr tcpip.Address) bool {
 //
 // dupTentativeAddrDetected removes the tentative address if it exists. If the
 // address was generated via SLAAC, an attempt is made to generate a new
-// address.
-func (e *endpoint) dupTentativeAddrDetected(addr tcpip.Address) *tcpip.Error {
+// address. holderLinkAddr is the link address of the node holding addr, if
+// known.
+func (e *endpoint) dupTentativeAddrDetected(addr tcpip.Address, holderLinkAddr tcpip.LinkAddress) *tcpip.Error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
 	addressEndpoint := e.getAddressRLocked(addr)
 	if addressEndpoint == nil {
+		// DAD may be performed for addresses that are not assigned to the
+		// endpoint on behalf of integrators.
+		if e.mu.ndp.dad.IsRunningLocked(addr) {
+			e.mu.ndp.stopDuplicateAddressDetection(addr, &stack.DADDupAddrDetected{HolderLinkAddress: holderLinkAddr})
+			return nil
+		}
 		return tcpip.ErrBadAddress
 	}
 
@@ -164,6 +305,8 @@ func (e *endpoint) dupTentativeAddrDetected(addr tcpip.Address) *tcpip.Error {
 		return tcpip.ErrInvalidEndpointState
 	}
 
+	e.mu.ndp.stopDuplicateAddressDetection(addr, &stack.DADDupAddrDetected{HolderLinkAddress: holderLinkAddr})
+
 	// If the address is a SLAAC address, do not invalidate its SLAAC prefix as an
 	// attempt will be made to generate a new address for it.
 	if err := e.removePermanentEndpointLocked(addressEndpoint, false /* allowSLAACInvalidation */); err != nil {
@@ -187,24 +330,51 @@ func (e *endpoint) dupTentativeAddrDetected(addr tcpip.Address) *tcpip.Error {
 	return nil
 }
 
-// transitionForwarding transitions the endpoint's forwarding status to
-// forwarding.
+// Forwarding implements stack.ForwardingNetworkEndpoint.
+func (e *endpoint) Forwarding() bool {
+	return atomic.LoadUint32(&e.forwarding) == 1
+}
+
+// RedirectedNextHop implements stack.RedirectableNetworkEndpoint.
+func (e *endpoint) RedirectedNextHop(dst tcpip.Address) (tcpip.Address, bool) {
+	return e.redirects.lookup(dst)
+}
+
+// setForwarding sets the forwarding status for the endpoint.
+//
+// Returns true if the forwarding status was updated.
+func (e *endpoint) setForwarding(v bool) bool {
+	if v {
+		return atomic.SwapUint32(&e.forwarding, 1) == 0
+	}
+	return atomic.SwapUint32(&e.forwarding, 0) == 1
+}
+
+// SetForwarding implements stack.ForwardingNetworkEndpoint.
 //
-// Must only be called when the forwarding status changes.
-func (e *endpoint) transitionForwarding(forwarding bool) {
+// When the forwarding status changes, host-only NDP behaviour (router
+// solicitations, processing of Router Advertisements and SLAAC) is started or
+// stopped for this endpoint only; other endpoints are not affected.
+func (e *endpoint) SetForwarding(forwarding bool) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	if !e.setForwarding(forwarding) {
+		return
+	}
+
 	if !e.Enabled() {
 		return
 	}
 
 	if forwarding {
 		// When transitioning into an IPv6 router, host-only state (NDP discovered
-		// routers, discovered on-link prefixes, and auto-generated addresses) is
-		// cleaned up/invalidated and NDP router solicitations are stopped.
+		// routers, discovered on-link prefixes, auto-generated addresses and
+		// redirected first-hops) is cleaned up/invalidated and NDP router
+		// solicitations are stopped.
 		e.mu.ndp.stopSolicitingRouters()
 		e.mu.ndp.cleanupState(true /* hostOnly */)
+		e.redirects.clear()
 	} else {
 		// When transitioning into an IPv6 host, NDP router solicitations are
 		// started.
@@ -262,25 +432,7 @@ func (e *endpoint) Enable() *tcpip.Error {
 	//
 	// Addresses may have aleady completed DAD but in the time since the endpoint
 	// was last enabled, other devices may have acquired the same addresses.
-	var err *tcpip.Error
-	e.mu.addressableEndpointState.ForEachEndpoint(func(addressEndpoint stack.AddressEndpoint) bool {
-		addr := addressEndpoint.AddressWithPrefix().Address
-		if !header.IsV6UnicastAddress(addr) {
-			return true
-		}
-
-		switch addressEndpoint.GetKind() {
-		case stack.Permanent:
-			addressEndpoint.SetKind(stack.PermanentTentative)
-			fallthrough
-		case stack.PermanentTentative:
-			err = e.mu.ndp.startDuplicateAddressDetection(addr, addressEndpoint)
-			return err == nil
-		default:
-			return true
-		}
-	})
-	if err != nil {
+	if err := e.startDADForPermanentAddressesLocked(); err != nil {
 		return err
 	}
 
@@ -298,7 +450,7 @@ func (e *endpoint) Enable() *tcpip.Error {
 	// does. That is, routers do not learn from RAs (e.g. on-link prefixes
 	// and default routers). Therefore, soliciting RAs from other routers on
 	// a link is unnecessary for routers.
-	if !e.protocol.Forwarding() {
+	if !e.Forwarding() {
 		e.mu.ndp.startSolicitingRouters()
 	}
 
@@ -341,6 +493,7 @@ func (e *endpoint) disableLocked() {
 	e.mu.ndp.stopSolicitingRouters()
 	e.mu.ndp.cleanupState(false /* hostOnly */)
 	e.stopDADForPermanentAddressesLocked()
+	e.redirects.clear()
 
 	// The endpoint may have already left the multicast group.
 	if err := e.leaveGroupLocked(header.IPv6AllNodesMulticastAddress); err != nil && err != tcpip.ErrBadLocalAddress {
@@ -352,6 +505,60 @@ func (e *endpoint) disableLocked() {
 	e.mld.softLeaveAll()
 }
 
+// HandleCarrierChange implements stack.CarrierChangeHandler.
+//
+// As in Linux, routers are not solicited while the link is down, and once it
+// comes back up, DAD is performed again on the permanent addresses as other
+// devices may have acquired them in the meantime, and routers are solicited
+// again as the endpoint may have moved to another link.
+func (e *endpoint) HandleCarrierChange(up bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.isEnabled() {
+		return
+	}
+
+	if !up {
+		e.mu.ndp.stopSolicitingRouters()
+		e.stopDADForPermanentAddressesLocked()
+		return
+	}
+
+	// startDADForPermanentAddressesLocked only fails for addresses which are
+	// not unicast, which it skips.
+	_ = e.startDADForPermanentAddressesLocked()
+	if !e.Forwarding() {
+		e.mu.ndp.startSolicitingRouters()
+	}
+}
+
+// startDADForPermanentAddressesLocked starts DAD for all permanent unicast
+// addresses.
+//
+// Precondition: e.mu must be write locked.
+func (e *endpoint) startDADForPermanentAddressesLocked() *tcpip.Error {
+	var err *tcpip.Error
+	e.mu.addressableEndpointState.ForEachEndpoint(func(addressEndpoint stack.AddressEndpoint) bool {
+		addr := addressEndpoint.AddressWithPrefix().Address
+		if !header.IsV6UnicastAddress(addr) {
+			return true
+		}
+
+		switch addressEndpoint.GetKind() {
+		case stack.Permanent:
+			addressEndpoint.SetKind(stack.PermanentTentative)
+			fallthrough
+		case stack.PermanentTentative:
+			err = e.mu.ndp.startDuplicateAddressDetection(addr, addressEndpoint)
+			return err == nil
+		default:
+			return true
+		}
+	})
+	return err
+}
+
 // stopDADForPermanentAddressesLocked stops DAD for all permaneent addresses.
 //
 // Precondition: e.mu must be write locked.
@@ -364,7 +571,7 @@ func (e *endpoint) stopDADForPermanentAddressesLocked() {
 
 		addr := addressEndpoint.AddressWithPrefix().Address
 		if header.IsV6UnicastAddress(addr) {
-			e.mu.ndp.stopDuplicateAddressDetection(addr)
+			e.mu.ndp.stopDuplicateAddressDetection(addr, &stack.DADAborted{})
 		}
 
 		return true
@@ -386,6 +593,13 @@ func (e *endpoint) MTU() uint32 {
 	return networkMTU
 }
 
+var _ fragmentation.ReassemblyStatsEndpoint = (*endpoint)(nil)
+
+// ReassemblyStats implements fragmentation.ReassemblyStatsEndpoint.
+func (e *endpoint) ReassemblyStats() *fragmentation.Stats {
+	return &e.reassemblyStats
+}
+
 // MaxHeaderLength returns the maximum length needed by ipv6 headers (and
 // underlying protocols).
 func (e *endpoint) MaxHeaderLength() uint16 {
@@ -400,6 +614,7 @@ func (e *endpoint) addIPHeader(srcAddr, dstAddr tcpip.Address, pkt *stack.Packet
 		NextHeader:    uint8(params.Protocol),
 		HopLimit:      params.TTL,
 		TrafficClass:  params.TOS,
+		FlowLabel:     params.FlowLabel,
 		SrcAddr:       srcAddr,
 		DstAddr:       dstAddr,
 	})
@@ -456,6 +671,9 @@ func (e *endpoint) handleFragments(r *stack.Route, gso *stack.GSO, networkMTU ui
 
 // WritePacket writes a packet to the given destination address and protocol.
 func (e *endpoint) WritePacket(r *stack.Route, gso *stack.GSO, params stack.NetworkHeaderParams, pkt *stack.PacketBuffer) *tcpip.Error {
+	if params.FlowLabel == 0 && e.protocol.options.AutoFlowLabels {
+		params.FlowLabel = autoFlowLabel(r, params.Protocol, pkt, e.protocol.hashIV)
+	}
 	e.addIPHeader(r.LocalAddress, r.RemoteAddress, pkt, params)
 
 	// iptables filtering. All packets that reach here are locally
@@ -488,10 +706,10 @@ func (e *endpoint) WritePacket(r *stack.Route, gso *stack.GSO, params stack.Netw
 		}
 	}
 
-	return e.writePacket(r, gso, pkt, params.Protocol, false /* headerIncluded */)
+	return e.writePacket(r, gso, pkt, params.Protocol, false /* headerIncluded */, params.PathMTUProbe || params.IgnorePathMTU, params.PathMTUProbe || params.DontFragment)
 }
 
-func (e *endpoint) writePacket(r *stack.Route, gso *stack.GSO, pkt *stack.PacketBuffer, protocol tcpip.TransportProtocolNumber, headerIncluded bool) *tcpip.Error {
+func (e *endpoint) writePacket(r *stack.Route, gso *stack.GSO, pkt *stack.PacketBuffer, protocol tcpip.TransportProtocolNumber, headerIncluded, ignorePathMTU, dontFragment bool) *tcpip.Error {
 	if r.Loop&stack.PacketLoop != 0 {
 		pkt := pkt.CloneToInbound()
 		if e.protocol.stack.ParsePacketBuffer(ProtocolNumber, pkt) == stack.ParsedOK {
@@ -506,13 +724,21 @@ func (e *endpoint) writePacket(r *stack.Route, gso *stack.GSO, pkt *stack.Packet
 		return nil
 	}
 
-	networkMTU, err := calculateNetworkMTU(e.nic.MTU(), uint32(pkt.NetworkHeader().View().Size()))
+	linkMTU := e.nic.MTU()
+	if !ignorePathMTU {
+		linkMTU = e.pathLinkMTU(r)
+	}
+	networkMTU, err := calculateNetworkMTU(linkMTU, uint32(pkt.NetworkHeader().View().Size()))
 	if err != nil {
 		r.Stats().IP.OutgoingPacketErrors.Increment()
 		return err
 	}
 
 	if packetMustBeFragmented(pkt, networkMTU, gso) {
+		if dontFragment {
+			r.Stats().IP.OutgoingPacketErrors.Increment()
+			return tcpip.ErrMessageTooLong
+		}
 		sent, remain, err := e.handleFragments(r, gso, networkMTU, pkt, protocol, func(fragPkt *stack.PacketBuffer) *tcpip.Error {
 			// TODO(gvisor.dev/issue/3884): Evaluate whether we want to send each
 			// fragment one by one using WritePacket() (current strategy) or if we
@@ -534,6 +760,17 @@ func (e *endpoint) writePacket(r *stack.Route, gso *stack.GSO, pkt *stack.Packet
 	return nil
 }
 
+// pathLinkMTU returns the MTU that packets must fit in to reach r's
+// destination: the path MTU if it is known and smaller than the NIC's MTU, or
+// the NIC's MTU otherwise.
+func (e *endpoint) pathLinkMTU(r *stack.Route) uint32 {
+	mtu := e.nic.MTU()
+	if pmtu, ok := r.PathMTULimit(); ok && pmtu < mtu {
+		mtu = pmtu
+	}
+	return mtu
+}
+
 // WritePackets implements stack.NetworkEndpoint.WritePackets.
 func (e *endpoint) WritePackets(r *stack.Route, gso *stack.GSO, pkts stack.PacketBufferList, params stack.NetworkHeaderParams) (int, *tcpip.Error) {
 	if r.Loop&stack.PacketLoop != 0 {
@@ -543,7 +780,15 @@ func (e *endpoint) WritePackets(r *stack.Route, gso *stack.GSO, pkts stack.Packe
 		return pkts.Len(), nil
 	}
 
+	// All packets belong to the same flow.
+	if pb := pkts.Front(); pb != nil && params.FlowLabel == 0 && e.protocol.options.AutoFlowLabels {
+		params.FlowLabel = autoFlowLabel(r, params.Protocol, pb, e.protocol.hashIV)
+	}
+
 	linkMTU := e.nic.MTU()
+	if !params.PathMTUProbe && !params.IgnorePathMTU {
+		linkMTU = e.pathLinkMTU(r)
+	}
 	for pb := pkts.Front(); pb != nil; pb = pb.Next() {
 		e.addIPHeader(r.LocalAddress, r.RemoteAddress, pb, params)
 
@@ -553,6 +798,10 @@ func (e *endpoint) WritePackets(r *stack.Route, gso *stack.GSO, pkts stack.Packe
 			return 0, err
 		}
 		if packetMustBeFragmented(pb, networkMTU, gso) {
+			if params.DontFragment {
+				r.Stats().IP.OutgoingPacketErrors.IncrementBy(uint64(pkts.Len()))
+				return 0, tcpip.ErrMessageTooLong
+			}
 			// Keep track of the packet that is about to be fragmented so it can be
 			// removed once the fragmentation is done.
 			originalPkt := pb
@@ -655,7 +904,7 @@ func (e *endpoint) WriteHeaderIncludedPacket(r *stack.Route, pkt *stack.PacketBu
 		return tcpip.ErrMalformedHeader
 	}
 
-	return e.writePacket(r, nil /* gso */, pkt, proto, true /* headerIncluded */)
+	return e.writePacket(r, nil /* gso */, pkt, proto, true /* headerIncluded */, false /* ignorePathMTU */, false /* dontFragment */)
 }
 
 // forwardPacket attempts to forward a packet to its final destination.
@@ -685,12 +934,26 @@ func (e *endpoint) forwardPacket(pkt *stack.PacketBuffer) *tcpip.Error {
 		return err
 	}
 
-	r, err := e.protocol.stack.FindRoute(0, "", dstAddr, ProtocolNumber, false /* multicastLoop */)
+	tos, _ := h.TOS()
+	key := stack.RoutePolicyKey{
+		Source:   h.SourceAddress(),
+		InputNIC: e.nic.ID(),
+		Mark:     pkt.Mark,
+		TOS:      tos,
+	}
+	key.SetTransport(pkt)
+	r, err := e.protocol.stack.FindForwardingRoute(&e.forwardingRoutes, dstAddr, ProtocolNumber, key)
 	if err != nil {
 		return err
 	}
 	defer r.Release()
 
+	// As per RFC 4861 section 8.2, let the source know of a better first-hop if
+	// the packet is being forwarded back out the interface it arrived on.
+	if r.NICID() == e.nic.ID() {
+		e.maybeSendRedirect(r, pkt)
+	}
+
 	// We need to do a deep copy of the IP packet because
 	// WriteHeaderIncludedPacket takes ownership of the packet buffer, but we do
 	// not own it.
@@ -719,6 +982,10 @@ func (e *endpoint) HandlePacket(pkt *stack.PacketBuffer) {
 		return
 	}
 
+	// The rules of the prerouting chain may log the NIC the packet was
+	// received at.
+	pkt.NICID = e.nic.ID()
+
 	// Loopback traffic skips the prerouting chain.
 	if !e.nic.IsLoopback() {
 		if ok := e.protocol.stack.IPTables().Check(stack.Prerouting, pkt, nil, nil, e.MainAddress().Address, ""); !ok {
@@ -753,12 +1020,22 @@ func (e *endpoint) handlePacket(pkt *stack.PacketBuffer) {
 		return
 	}
 
+	// Multicast packets may be forwarded through multicast routes in addition
+	// to being delivered locally.
+	if header.IsV6MulticastAddress(dstAddr) {
+		e.forwardMulticastPacket(h, pkt)
+	}
+
 	// The destination address should be an address we own or a group we joined
 	// for us to receive the packet. Otherwise, attempt to forward the packet.
 	if addressEndpoint := e.AcquireAssignedAddress(dstAddr, e.nic.Promiscuous(), stack.CanBePrimaryEndpoint); addressEndpoint != nil {
 		addressEndpoint.DecRef()
-	} else if !e.IsInGroup(dstAddr) {
-		if !e.protocol.Forwarding() {
+	} else if !e.IsInGroup(dstAddr) && !e.isProxiedNeighborSolicitation(h, pkt) {
+		if header.IsV6MulticastAddress(dstAddr) && e.MulticastForwarding() {
+			// The packet was handled by multicast forwarding.
+			return
+		}
+		if !e.Forwarding() {
 			stats.IP.InvalidDestinationAddressesReceived.Increment()
 			return
 		}
@@ -776,6 +1053,8 @@ func (e *endpoint) handlePacket(pkt *stack.PacketBuffer) {
 	vv.Append(pkt.Data)
 	it := header.MakeIPv6PayloadIterator(header.IPv6ExtensionHeaderIdentifier(h.NextHeader()), vv)
 	hasFragmentHeader := false
+	mldRouterAlert := false
+	extHdrOptionsPolicy := e.ExtHdrOptionsPolicy()
 
 	// iptables filtering. All packets that reach here are intended for
 	// this machine and need not be forwarded.
@@ -810,48 +1089,11 @@ func (e *endpoint) handlePacket(pkt *stack.PacketBuffer) {
 				return
 			}
 
-			optsIt := extHdr.Iter()
-
-			for {
-				opt, done, err := optsIt.Next()
-				if err != nil {
-					stats.IP.MalformedPacketsReceived.Increment()
-					return
-				}
-				if done {
-					break
-				}
-
-				// We currently do not support any IPv6 Hop By Hop extension header
-				// options.
-				switch opt.UnknownAction() {
-				case header.IPv6OptionUnknownActionSkip:
-				case header.IPv6OptionUnknownActionDiscard:
-					return
-				case header.IPv6OptionUnknownActionDiscardSendICMPNoMulticastDest:
-					if header.IsV6MulticastAddress(dstAddr) {
-						return
-					}
-					fallthrough
-				case header.IPv6OptionUnknownActionDiscardSendICMP:
-					// This case satisfies a requirement of RFC 8200 section 4.2
-					// which states that an unknown option starting with bits [10] should:
-					//
-					//    discard the packet and, regardless of whether or not the
-					//    packet's Destination Address was a multicast address, send an
-					//    ICMP Parameter Problem, Code 2, message to the packet's
-					//    Source Address, pointing to the unrecognized Option Type.
-					//
-					_ = e.protocol.returnError(&icmpReasonParameterProblem{
-						code:               header.ICMPv6UnknownOption,
-						pointer:            it.ParseOffset() + optsIt.OptionOffset(),
-						respondToMulticast: true,
-					}, pkt)
-					return
-				default:
-					panic(fmt.Sprintf("unrecognized action for an unrecognized Hop By Hop extension header option = %d", opt))
-				}
+			res := e.processExtHdrOptions(pkt, extHdr.Iter(), true /* hopByHop */, extHdr.Length(), it.ParseOffset(), &extHdrOptionsPolicy)
+			if res.drop {
+				return
 			}
+			mldRouterAlert = res.isMLDRouterAlert()
 
 		case header.IPv6RoutingExtHdr:
 			// As per RFC 8200 section 4.4, if a node encounters a routing header with
@@ -1000,8 +1242,14 @@ func (e *endpoint) handlePacket(pkt *stack.PacketBuffer) {
 				extHdr.More(),
 				uint8(rawPayload.Identifier),
 				pkt,
+				&e.reassemblyStats,
 			)
 			if err != nil {
+				if errors.Is(err, fragmentation.ErrSourceLimitReached) {
+					// The fragment is well formed; the source simply has too many
+					// reassemblies in progress.
+					return
+				}
 				stats.IP.MalformedPacketsReceived.Increment()
 				stats.IP.MalformedFragmentsReceived.Increment()
 				return
@@ -1018,47 +1266,8 @@ func (e *endpoint) handlePacket(pkt *stack.PacketBuffer) {
 			}
 
 		case header.IPv6DestinationOptionsExtHdr:
-			optsIt := extHdr.Iter()
-
-			for {
-				opt, done, err := optsIt.Next()
-				if err != nil {
-					stats.IP.MalformedPacketsReceived.Increment()
-					return
-				}
-				if done {
-					break
-				}
-
-				// We currently do not support any IPv6 Destination extension header
-				// options.
-				switch opt.UnknownAction() {
-				case header.IPv6OptionUnknownActionSkip:
-				case header.IPv6OptionUnknownActionDiscard:
-					return
-				case header.IPv6OptionUnknownActionDiscardSendICMPNoMulticastDest:
-					if header.IsV6MulticastAddress(dstAddr) {
-						return
-					}
-					fallthrough
-				case header.IPv6OptionUnknownActionDiscardSendICMP:
-					// This case satisfies a requirement of RFC 8200 section 4.2
-					// which states that an unknown option starting with bits [10] should:
-					//
-					//    discard the packet and, regardless of whether or not the
-					//    packet's Destination Address was a multicast address, send an
-					//    ICMP Parameter Problem, Code 2, message to the packet's
-					//    Source Address, pointing to the unrecognized Option Type.
-					//
-					_ = e.protocol.returnError(&icmpReasonParameterProblem{
-						code:               header.ICMPv6UnknownOption,
-						pointer:            it.ParseOffset() + optsIt.OptionOffset(),
-						respondToMulticast: true,
-					}, pkt)
-					return
-				default:
-					panic(fmt.Sprintf("unrecognized action for an unrecognized Destination extension header option = %d", opt))
-				}
+			if res := e.processExtHdrOptions(pkt, extHdr.Iter(), false /* hopByHop */, extHdr.Length(), it.ParseOffset(), &extHdrOptionsPolicy); res.drop {
+				return
 			}
 
 		case header.IPv6RawPayloadHeader:
@@ -1076,7 +1285,7 @@ func (e *endpoint) handlePacket(pkt *stack.PacketBuffer) {
 			stats.IP.PacketsDelivered.Increment()
 			if p := tcpip.TransportProtocolNumber(extHdr.Identifier); p == header.ICMPv6ProtocolNumber {
 				pkt.TransportProtocolNumber = p
-				e.handleICMP(pkt, hasFragmentHeader)
+				e.handleICMP(pkt, hasFragmentHeader, mldRouterAlert)
 			} else {
 				stats.IP.PacketsDelivered.Increment()
 				switch res := e.dispatcher.DeliverTransportPacket(p, pkt); res {
@@ -1140,6 +1349,10 @@ func (e *endpoint) Close() {
 	e.disableLocked()
 	e.mu.ndp.removeSLAACAddresses(false /* keepLinkLocal */)
 	e.stopDADForPermanentAddressesLocked()
+	for addr := range e.mu.ndp.staticAddrLifetimes {
+		e.mu.ndp.cleanupStaticAddrLifetimes(addr)
+	}
+	e.forwardingRoutes.Release()
 	e.mu.addressableEndpointState.Cleanup()
 	e.mu.Unlock()
 
@@ -1208,6 +1421,27 @@ func (e *endpoint) RemovePermanentAddress(addr tcpip.Address) *tcpip.Error {
 	return e.removePermanentEndpointLocked(addressEndpoint, true)
 }
 
+// SetAddressLifetimes implements stack.AddressLifetimesNetworkEndpoint.
+//
+// Only statically added unicast addresses may have lifetimes; SLAAC addresses
+// are managed by NDP.
+func (e *endpoint) SetAddressLifetimes(addr tcpip.Address, lifetimes stack.AddressLifetimes) *tcpip.Error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	addressEndpoint := e.getAddressRLocked(addr)
+	if addressEndpoint == nil || !addressEndpoint.GetKind().IsPermanent() {
+		return tcpip.ErrBadLocalAddress
+	}
+
+	if addressEndpoint.ConfigType() != stack.AddressConfigStatic || !header.IsV6UnicastAddress(addr) {
+		return tcpip.ErrNotSupported
+	}
+
+	e.mu.ndp.setStaticAddrLifetimes(addressEndpoint, lifetimes)
+	return nil
+}
+
 // removePermanentEndpointLocked is like removePermanentAddressLocked except
 // it works with a stack.AddressEndpoint.
 //
@@ -1216,7 +1450,7 @@ func (e *endpoint) removePermanentEndpointLocked(addressEndpoint stack.AddressEn
 	addr := addressEndpoint.AddressWithPrefix()
 	unicast := header.IsV6UnicastAddress(addr.Address)
 	if unicast {
-		e.mu.ndp.stopDuplicateAddressDetection(addr.Address)
+		e.mu.ndp.stopDuplicateAddressDetection(addr.Address, &stack.DADAborted{})
 
 		// If we are removing an address generated via SLAAC, cleanup
 		// its SLAAC resources and notify the integrator.
@@ -1225,6 +1459,8 @@ func (e *endpoint) removePermanentEndpointLocked(addressEndpoint stack.AddressEn
 			e.mu.ndp.cleanupSLAACAddrResourcesAndNotify(addr, allowSLAACInvalidation)
 		case stack.AddressConfigSlaacTemp:
 			e.mu.ndp.cleanupTempSLAACAddrResourcesAndNotify(addr, allowSLAACInvalidation)
+		case stack.AddressConfigStatic:
+			e.mu.ndp.cleanupStaticAddrLifetimes(addr.Address)
 		}
 	}
 
@@ -1339,6 +1575,8 @@ func (e *endpoint) acquireOutgoingPrimaryAddressRLocked(remoteAddr tcpip.Address
 		panic(fmt.Sprintf("header.ScopeForIPv6Address(%s): %s", remoteAddr, err))
 	}
 
+	preferTemp := e.mu.ndp.configs.TempAddrPreference != PreferPublicAddrs
+
 	// Sort the addresses as per RFC 6724 section 5 rules 1-3.
 	//
 	// TODO(b/146021396): Implement rules 4-8 of RFC 6724 section 5.
@@ -1367,9 +1605,10 @@ func (e *endpoint) acquireOutgoingPrimaryAddressRLocked(remoteAddr tcpip.Address
 			return sbDep
 		}
 
-		// Prefer temporary addresses as per RFC 6724 section 5 rule 7.
+		// Prefer temporary addresses as per RFC 6724 section 5 rule 7, unless
+		// public addresses are configured to be preferred.
 		if saTemp, sbTemp := sa.addressEndpoint.ConfigType() == stack.AddressConfigSlaacTemp, sb.addressEndpoint.ConfigType() == stack.AddressConfigSlaacTemp; saTemp != sbTemp {
-			return saTemp
+			return saTemp == preferTemp
 		}
 
 		// sa and sb are equal, return the endpoint that is closest to the front of
@@ -1454,8 +1693,15 @@ type protocol struct {
 		sync.RWMutex
 
 		eps map[*endpoint]struct{}
+
+		// multicastForwardingDisp is the multicast forwarding event dispatcher
+		// provided when multicast forwarding was enabled, or nil if multicast
+		// forwarding is disabled.
+		multicastForwardingDisp stack.MulticastForwardingEventDispatcher
 	}
 
+	multicastRouteTable ip.MulticastRouteTable
+
 	ids    []uint32
 	hashIV uint32
 
@@ -1465,6 +1711,12 @@ type protocol struct {
 	// Must be accessed using atomic operations.
 	defaultTTL uint32
 
+	// nonLocalBind is set to 1 when sockets may bind to addresses which
+	// aren't assigned to any NIC, and 0 otherwise.
+	//
+	// Must be accessed using atomic operations.
+	nonLocalBind uint32
+
 	// forwarding is set to 1 when the protocol has forwarding enabled and 0
 	// when it is disabled.
 	//
@@ -1472,6 +1724,13 @@ type protocol struct {
 	forwarding uint32
 
 	fragmentation *fragmentation.Fragmentation
+
+	// opaqueIIDSecretKey is the secret key used to generate opaque IIDs when
+	// NDPConfigurations.AutoGenStablePrivacyAddresses is enabled and no secret
+	// key is provided by the integrator.
+	//
+	// Immutable after the protocol is created.
+	opaqueIIDSecretKey []byte
 }
 
 // Number returns the ipv6 protocol number.
@@ -1508,16 +1767,32 @@ func (p *protocol) NewEndpoint(nic stack.NetworkInterface, linkAddrCache stack.L
 	e.mu.ndp = ndpState{
 		ep:             e,
 		configs:        p.options.NDPConfigs,
-		dad:            make(map[tcpip.Address]dadState),
 		defaultRouters: make(map[tcpip.Address]defaultRouterState),
 		onLinkPrefixes: make(map[tcpip.Subnet]onLinkPrefixState),
 		slaacPrefixes:  make(map[tcpip.Subnet]slaacPrefixState),
+
+		proxiedPrefixes: make(map[tcpip.Subnet]struct{}),
+		routes:          &ndpRouteUpdater{},
+
+		staticAddrLifetimes: make(map[tcpip.Address]staticAddrLifetimesState),
 	}
+	e.mu.ndp.routes.init(p.stack)
+	e.mu.extHdrOptionsPolicy = p.options.ExtHdrOptions
 	e.mu.ndp.initializeTempAddrState()
+	e.mu.ndp.dad.Init(&e.mu, p.options.NDPConfigs.dadConfigs(), ip.DADOptions{
+		Clock:    p.stack.Clock(),
+		Protocol: e,
+		NICID:    nic.ID(),
+	})
 	e.mld.init(e, p.options.MLD)
+	e.redirects.init(e)
+	e.icmpRateLimits.init(p.stack.Clock(), p.options.ICMPRateLimits)
 
 	p.mu.Lock()
 	defer p.mu.Unlock()
+
+	// New endpoints inherit the protocol's default forwarding configuration.
+	e.setForwarding(p.Forwarding())
 	p.mu.eps[e] = struct{}{}
 	return e
 }
@@ -1534,6 +1809,16 @@ func (p *protocol) SetOption(option tcpip.SettableNetworkProtocolOption) *tcpip.
 	case *tcpip.DefaultTTLOption:
 		p.SetDefaultTTL(uint8(*v))
 		return nil
+	case *tcpip.ReassemblyLimitsOption:
+		p.fragmentation.SetLimits(fragmentation.Limits(*v))
+		return nil
+	case *tcpip.NonLocalBindOption:
+		var b uint32
+		if *v {
+			b = 1
+		}
+		atomic.StoreUint32(&p.nonLocalBind, b)
+		return nil
 	default:
 		return tcpip.ErrUnknownProtocolOption
 	}
@@ -1545,6 +1830,12 @@ func (p *protocol) Option(option tcpip.GettableNetworkProtocolOption) *tcpip.Err
 	case *tcpip.DefaultTTLOption:
 		*v = tcpip.DefaultTTLOption(p.DefaultTTL())
 		return nil
+	case *tcpip.ReassemblyLimitsOption:
+		*v = tcpip.ReassemblyLimitsOption(p.fragmentation.Limits())
+		return nil
+	case *tcpip.NonLocalBindOption:
+		*v = atomic.LoadUint32(&p.nonLocalBind) != 0
+		return nil
 	default:
 		return tcpip.ErrUnknownProtocolOption
 	}
@@ -1561,7 +1852,9 @@ func (p *protocol) DefaultTTL() uint8 {
 }
 
 // Close implements stack.TransportProtocol.Close.
-func (*protocol) Close() {}
+func (p *protocol) Close() {
+	p.multicastRouteTable.Close()
+}
 
 // Wait implements stack.TransportProtocol.Wait.
 func (*protocol) Wait() {}
@@ -1577,6 +1870,9 @@ func (*protocol) Parse(pkt *stack.PacketBuffer) (proto tcpip.TransportProtocolNu
 }
 
 // Forwarding implements stack.ForwardingNetworkProtocol.
+//
+// The protocol's forwarding configuration is the default for new endpoints;
+// the forwarding configuration of an individual endpoint may differ.
 func (p *protocol) Forwarding() bool {
 	return uint8(atomic.LoadUint32(&p.forwarding)) == 1
 }
@@ -1592,16 +1888,17 @@ func (p *protocol) setForwarding(v bool) bool {
 }
 
 // SetForwarding implements stack.ForwardingNetworkProtocol.
+//
+// SetForwarding updates the default forwarding configuration and applies it to
+// all existing endpoints.
 func (p *protocol) SetForwarding(v bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if !p.setForwarding(v) {
-		return
-	}
+	p.setForwarding(v)
 
 	for ep := range p.mu.eps {
-		ep.transitionForwarding(v)
+		ep.SetForwarding(v)
 	}
 }
 
@@ -1653,6 +1950,12 @@ type Options struct {
 	// receive NDP related events.
 	NDPDisp NDPDispatcher
 
+	// RAGuard is the Router Advertisement policy that an integrator can provide
+	// to reject Router Advertisements before they are processed.
+	//
+	// If nil, all valid Router Advertisements are processed.
+	RAGuard RouterAdvertGuard
+
 	// OpaqueIIDOpts hold the options for generating opaque interface
 	// identifiers (IIDs) as outlined by RFC 7217.
 	OpaqueIIDOpts OpaqueInterfaceIdentifierOptions
@@ -1674,6 +1977,22 @@ type Options struct {
 
 	// MLD holds options for MLD.
 	MLD MLDOptions
+
+	// ExtHdrOptions is the default policy applied by interfaces to the options
+	// held in the Hop-by-Hop and Destination Options extension headers of
+	// received packets.
+	ExtHdrOptions ExtHdrOptionsPolicy
+
+	// ICMPRateLimits is the default limits applied by interfaces to the rate at
+	// which they generate ICMPv6 errors and Neighbor Discovery messages.
+	ICMPRateLimits ICMPRateLimits
+
+	// AutoFlowLabels determines whether or not flow labels are generated for
+	// outgoing packets that do not have one, as per RFC 6437 section 3.
+	//
+	// The generated label is a hash of the packet's addresses, transport
+	// protocol and ports, so packets of the same flow get the same label.
+	AutoFlowLabels bool
 }
 
 // NewProtocolWithOptions returns an IPv6 network protocol.
@@ -1690,10 +2009,18 @@ func NewProtocolWithOptions(opts Options) stack.NetworkProtocolFactory {
 
 			ids:    ids,
 			hashIV: hashIV,
+
+			opaqueIIDSecretKey: make([]byte, header.OpaqueIIDSecretKeyMinBytes),
+		}
+		if _, err := rand.Read(p.opaqueIIDSecretKey); err != nil {
+			panic(fmt.Sprintf("unable to generate opaque IID secret key: %s", err))
 		}
 		p.fragmentation = fragmentation.NewFragmentation(header.IPv6FragmentExtHdrFragmentOffsetBytesPerUnit, fragmentation.HighFragThreshold, fragmentation.LowFragThreshold, ReassembleTimeout, s.Clock(), p)
 		p.mu.eps = make(map[*endpoint]struct{})
 		p.SetDefaultTTL(DefaultTTL)
+		if err := p.multicastRouteTable.Init(ip.DefaultMulticastRouteTableConfig(s.Clock())); err != nil {
+			panic(fmt.Sprintf("p.multicastRouteTable.Init(_): %s", err))
+		}
 		return p
 	}
 }
@@ -1730,6 +2057,30 @@ func hashRoute(r *stack.Route, hashIV uint32) uint32 {
 	return h.Sum32()
 }
 
+// autoFlowLabel returns a flow label for a packet sent on r.
+func autoFlowLabel(r *stack.Route, transProto tcpip.TransportProtocolNumber, pkt *stack.PacketBuffer, hashIV uint32) uint32 {
+	h := fnv.New32a()
+	// Hash's implementation of Write never returns an error.
+	_, _ = h.Write([]byte(r.LocalAddress))
+	_, _ = h.Write([]byte(r.RemoteAddress))
+
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, hashIV)
+	_, _ = h.Write(b)
+	_, _ = h.Write([]byte{uint8(transProto)})
+	switch transProto {
+	case header.TCPProtocolNumber, header.UDPProtocolNumber:
+		// The ports are the first 4 bytes of the TCP and UDP headers.
+		if v := pkt.TransportHeader().View(); len(v) >= 4 {
+			_, _ = h.Write(v[:4])
+		}
+	}
+
+	// Fold the hash into the 20 bits of the flow label, like Linux.
+	v := h.Sum32()
+	return (v ^ v>>12) & header.IPv6FlowLabelMask
+}
+
 func buildNextFragment(pf *fragmentation.PacketFragmenter, originalIPHeaders header.IPv6, transportProto tcpip.TransportProtocolNumber, id uint32) (*stack.PacketBuffer, bool) {
 	fragPkt, offset, copied, more := pf.BuildNextFragment()
 	fragPkt.NetworkProtocolNumber = ProtocolNumber
@@ -3049,3 +3049,262 @@ func TestForwarding(t *testing.T) {
 		})
 	}
 }
+
+func TestForwardingRedirect(t *testing.T) {
+	const nicID = 1
+
+	ipv6Addr := tcpip.AddressWithPrefix{
+		Address:   tcpip.Address(net.ParseIP("10::1").To16()),
+		PrefixLen: 64,
+	}
+	linkLocalAddr := tcpip.Address(net.ParseIP("fe80::1").To16())
+	remoteAddr := tcpip.Address(net.ParseIP("10::2").To16())
+	onLinkDst := tcpip.Address(net.ParseIP("10::3").To16())
+	linkLocalRouter := tcpip.Address(net.ParseIP("fe80::2").To16())
+	globalRouter := tcpip.Address(net.ParseIP("10::4").To16())
+	linkLocalRouterDst := tcpip.Address(net.ParseIP("12::1").To16())
+	globalRouterDst := tcpip.Address(net.ParseIP("13::1").To16())
+
+	tests := []struct {
+		name           string
+		sendRedirects  bool
+		dstAddr        tcpip.Address
+		expectRedirect bool
+		expectedTarget tcpip.Address
+	}{
+		{
+			name:           "On-link destination",
+			sendRedirects:  true,
+			dstAddr:        onLinkDst,
+			expectRedirect: true,
+			expectedTarget: onLinkDst,
+		},
+		{
+			name:           "Router with link-local address",
+			sendRedirects:  true,
+			dstAddr:        linkLocalRouterDst,
+			expectRedirect: true,
+			expectedTarget: linkLocalRouter,
+		},
+		{
+			name:           "Router with global address",
+			sendRedirects:  true,
+			dstAddr:        globalRouterDst,
+			expectRedirect: false,
+		},
+		{
+			name:           "Redirects disabled",
+			sendRedirects:  false,
+			dstAddr:        onLinkDst,
+			expectRedirect: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{NewProtocolWithOptions(Options{
+					NDPConfigs: NDPConfigurations{
+						SendRedirects: test.sendRedirects,
+					},
+				})},
+			})
+			// We expect at most a Redirect and the forwarded packet.
+			e := channel.New(2, header.IPv6MinimumMTU, "")
+			if err := s.CreateNIC(nicID, e); err != nil {
+				t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+			}
+			ipv6ProtoAddr := tcpip.ProtocolAddress{Protocol: ProtocolNumber, AddressWithPrefix: ipv6Addr}
+			if err := s.AddProtocolAddress(nicID, ipv6ProtoAddr); err != nil {
+				t.Fatalf("AddProtocolAddress(%d, %#v): %s", nicID, ipv6ProtoAddr, err)
+			}
+			if err := s.AddAddress(nicID, ProtocolNumber, linkLocalAddr); err != nil {
+				t.Fatalf("AddAddress(%d, %d, %s): %s", nicID, ProtocolNumber, linkLocalAddr, err)
+			}
+
+			s.SetRouteTable([]tcpip.Route{
+				{
+					Destination: ipv6Addr.Subnet(),
+					NIC:         nicID,
+				},
+				{
+					Destination: tcpip.AddressWithPrefix{Address: linkLocalRouterDst, PrefixLen: 64}.Subnet(),
+					Gateway:     linkLocalRouter,
+					NIC:         nicID,
+				},
+				{
+					Destination: tcpip.AddressWithPrefix{Address: globalRouterDst, PrefixLen: 64}.Subnet(),
+					Gateway:     globalRouter,
+					NIC:         nicID,
+				},
+			})
+
+			if err := s.SetForwarding(ProtocolNumber, true); err != nil {
+				t.Fatalf("SetForwarding(%d, true): %s", ProtocolNumber, err)
+			}
+
+			hdr := buffer.NewPrependable(header.IPv6MinimumSize + header.ICMPv6MinimumSize)
+			icmp := header.ICMPv6(hdr.Prepend(header.ICMPv6MinimumSize))
+			icmp.SetType(header.ICMPv6EchoRequest)
+			icmp.SetChecksum(header.ICMPv6Checksum(icmp, remoteAddr, test.dstAddr, buffer.VectorisedView{}))
+			ip := header.IPv6(hdr.Prepend(header.IPv6MinimumSize))
+			ip.Encode(&header.IPv6Fields{
+				PayloadLength: header.ICMPv6MinimumSize,
+				NextHeader:    uint8(header.ICMPv6ProtocolNumber),
+				HopLimit:      DefaultTTL,
+				SrcAddr:       remoteAddr,
+				DstAddr:       test.dstAddr,
+			})
+			e.InjectInbound(ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+				Data: hdr.View().ToVectorisedView(),
+			}))
+
+			if test.expectRedirect {
+				p, ok := e.Read()
+				if !ok {
+					t.Fatal("expected Redirect packet")
+				}
+
+				redirect := header.IPv6(stack.PayloadSince(p.Pkt.NetworkHeader()))
+				checker.IPv6(t, redirect,
+					checker.SrcAddr(linkLocalAddr),
+					checker.DstAddr(remoteAddr),
+					checker.TTL(header.NDPHopLimit),
+					checker.ICMPv6(
+						checker.ICMPv6Type(header.ICMPv6RedirectMsg),
+						checker.ICMPv6Code(0),
+					),
+				)
+
+				rd := header.NDPRedirect(header.ICMPv6(redirect.Payload()).MessageBody())
+				if got := rd.TargetAddress(); got != test.expectedTarget {
+					t.Errorf("got rd.TargetAddress() = %s, want = %s", got, test.expectedTarget)
+				}
+				if got := rd.DestinationAddress(); got != test.dstAddr {
+					t.Errorf("got rd.DestinationAddress() = %s, want = %s", got, test.dstAddr)
+				}
+
+				it, err := rd.Options().Iter(true /* check */)
+				if err != nil {
+					t.Fatalf("rd.Options().Iter(true): %s", err)
+				}
+				opt, done, err := it.Next()
+				if err != nil || done {
+					t.Fatalf("got it.Next() = (_, %t, %v), want = (_, false, nil)", done, err)
+				}
+				redirectedHeader, ok := opt.(header.NDPRedirectedHeader)
+				if !ok {
+					t.Fatalf("got option = %s, want = %T", opt, header.NDPRedirectedHeader(nil))
+				}
+				if diff := cmp.Diff([]byte(hdr.View()), []byte(redirectedHeader)); diff != "" {
+					t.Errorf("redirected header mismatch (-want +got):\n%s", diff)
+				}
+			}
+
+			p, ok := e.Read()
+			if !ok {
+				t.Fatal("expected forwarded packet")
+			}
+			checker.IPv6(t, stack.PayloadSince(p.Pkt.NetworkHeader()),
+				checker.SrcAddr(remoteAddr),
+				checker.DstAddr(test.dstAddr),
+				checker.TTL(DefaultTTL-1),
+			)
+
+			if n := e.Drain(); n != 0 {
+				t.Fatalf("got e.Drain() = %d, want = 0", n)
+			}
+		})
+	}
+}
+
+func TestAutoFlowLabels(t *testing.T) {
+	const (
+		nicID   = 1
+		dstPort = 80
+	)
+
+	// writePacket writes a UDP packet on r and returns its flow label.
+	writePacket := func(t *testing.T, r *stack.Route, e *channel.Endpoint, srcPort uint16, flowLabel uint32) uint32 {
+		t.Helper()
+
+		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+			ReserveHeaderBytes: header.UDPMinimumSize + int(r.MaxHeaderLength()),
+		})
+		header.UDP(pkt.TransportHeader().Push(header.UDPMinimumSize)).Encode(&header.UDPFields{
+			SrcPort: srcPort,
+			DstPort: dstPort,
+			Length:  header.UDPMinimumSize,
+		})
+		pkt.TransportProtocolNumber = udp.ProtocolNumber
+		if err := r.WritePacket(nil /* gso */, stack.NetworkHeaderParams{
+			Protocol:  udp.ProtocolNumber,
+			TTL:       DefaultTTL,
+			FlowLabel: flowLabel,
+		}, pkt); err != nil {
+			t.Fatalf("r.WritePacket(nil, _, _): %s", err)
+		}
+
+		p, ok := e.Read()
+		if !ok {
+			t.Fatal("expected a packet to be written")
+		}
+		_, label := header.IPv6(stack.PayloadSince(p.Pkt.NetworkHeader())).TOS()
+		return label
+	}
+
+	tests := []struct {
+		name           string
+		autoFlowLabels bool
+	}{
+		{name: "Disabled", autoFlowLabels: false},
+		{name: "Enabled", autoFlowLabels: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{NewProtocolWithOptions(Options{
+					AutoFlowLabels: test.autoFlowLabels,
+				})},
+			})
+			e := channel.New(4, header.IPv6MinimumMTU, linkAddr1)
+			if err := s.CreateNIC(nicID, e); err != nil {
+				t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+			}
+			if err := s.AddAddress(nicID, ProtocolNumber, addr1); err != nil {
+				t.Fatalf("s.AddAddress(%d, %d, %s): %s", nicID, ProtocolNumber, addr1, err)
+			}
+			s.SetRouteTable([]tcpip.Route{{Destination: header.IPv6EmptySubnet, NIC: nicID}})
+			r, err := s.FindRoute(nicID, addr1, addr2, ProtocolNumber, false /* multicastLoop */)
+			if err != nil {
+				t.Fatalf("s.FindRoute(%d, %s, %s, %d, false): %s", nicID, addr1, addr2, ProtocolNumber, err)
+			}
+			defer r.Release()
+
+			// Explicit flow labels are always used.
+			const explicitLabel = 0x12345
+			if got := writePacket(t, r, e, 1000, explicitLabel); got != explicitLabel {
+				t.Errorf("got flow label = %#x, want = %#x", got, explicitLabel)
+			}
+
+			label1 := writePacket(t, r, e, 1000, 0)
+			if !test.autoFlowLabels {
+				if label1 != 0 {
+					t.Errorf("got flow label = %#x, want = 0", label1)
+				}
+				return
+			}
+
+			if label1 == 0 {
+				t.Error("got flow label = 0, want non-zero")
+			}
+			if got := writePacket(t, r, e, 1000, 0); got != label1 {
+				t.Errorf("got flow label of same flow = %#x, want = %#x", got, label1)
+			}
+			if got := writePacket(t, r, e, 1001, 0); got == label1 {
+				t.Errorf("got flow label of different flow = %#x, want != %#x", got, label1)
+			}
+		})
+	}
+}
@@ -0,0 +1,436 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv6
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/checker"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	multicastNICID1 = 1
+	multicastNICID2 = 2
+	multicastNICID3 = 3
+)
+
+var (
+	multicastSrcAddr   = tcpip.Address(net.ParseIP("10::2").To16())
+	multicastGroupAddr = tcpip.Address(net.ParseIP("ff0e::1").To16())
+)
+
+type missingRouteEvent struct {
+	context stack.MulticastPacketContext
+}
+
+type unexpectedInputInterfaceEvent struct {
+	context                stack.MulticastPacketContext
+	expectedInputInterface tcpip.NICID
+}
+
+var _ stack.MulticastForwardingEventDispatcher = (*fakeMulticastEventDispatcher)(nil)
+
+type fakeMulticastEventDispatcher struct {
+	missingRoute             []missingRouteEvent
+	unexpectedInputInterface []unexpectedInputInterfaceEvent
+}
+
+func (d *fakeMulticastEventDispatcher) OnMissingRoute(context stack.MulticastPacketContext) {
+	d.missingRoute = append(d.missingRoute, missingRouteEvent{context: context})
+}
+
+func (d *fakeMulticastEventDispatcher) OnUnexpectedInputInterface(context stack.MulticastPacketContext, expectedInputInterface tcpip.NICID) {
+	d.unexpectedInputInterface = append(d.unexpectedInputInterface, unexpectedInputInterfaceEvent{
+		context:                context,
+		expectedInputInterface: expectedInputInterface,
+	})
+}
+
+// newMulticastForwardingStack returns a stack with three NICs that have
+// multicast forwarding enabled.
+func newMulticastForwardingStack(t *testing.T, disp stack.MulticastForwardingEventDispatcher) (*stack.Stack, map[tcpip.NICID]*channel.Endpoint) {
+	t.Helper()
+
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{NewProtocol},
+	})
+	eps := make(map[tcpip.NICID]*channel.Endpoint)
+	for i, nicID := range []tcpip.NICID{multicastNICID1, multicastNICID2, multicastNICID3} {
+		// Each endpoint may hold the packets queued for a missing route.
+		e := channel.New(2, header.IPv6MinimumMTU, "")
+		if err := s.CreateNIC(nicID, e); err != nil {
+			t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+		}
+		protocolAddr := tcpip.ProtocolAddress{
+			Protocol: ProtocolNumber,
+			AddressWithPrefix: tcpip.AddressWithPrefix{
+				Address:   tcpip.Address(net.ParseIP(fmt.Sprintf("%d::1", 10+i)).To16()),
+				PrefixLen: 64,
+			},
+		}
+		if err := s.AddProtocolAddress(nicID, protocolAddr); err != nil {
+			t.Fatalf("AddProtocolAddress(%d, %#v): %s", nicID, protocolAddr, err)
+		}
+		if err := s.SetNICMulticastForwarding(nicID, ProtocolNumber, true); err != nil {
+			t.Fatalf("SetNICMulticastForwarding(%d, %d, true): %s", nicID, ProtocolNumber, err)
+		}
+		eps[nicID] = e
+	}
+
+	if alreadyEnabled, err := s.EnableMulticastForwardingForProtocol(ProtocolNumber, disp); err != nil || alreadyEnabled {
+		t.Fatalf("got s.EnableMulticastForwardingForProtocol(%d, _) = (%t, %s), want = (false, nil)", ProtocolNumber, alreadyEnabled, err)
+	}
+	return s, eps
+}
+
+func injectMulticastPacket(e *channel.Endpoint, src, dst tcpip.Address, hopLimit uint8) {
+	payload := []byte{1, 2, 3, 4}
+	hdr := buffer.NewPrependable(header.IPv6MinimumSize)
+	ip := header.IPv6(hdr.Prepend(header.IPv6MinimumSize))
+	ip.Encode(&header.IPv6Fields{
+		PayloadLength: uint16(len(payload)),
+		NextHeader:    uint8(header.UDPProtocolNumber),
+		HopLimit:      hopLimit,
+		SrcAddr:       src,
+		DstAddr:       dst,
+	})
+	vv := hdr.View().ToVectorisedView()
+	vv.AppendView(payload)
+	e.InjectInbound(ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: vv,
+	}))
+}
+
+func checkForwardedMulticastPacket(t *testing.T, e *channel.Endpoint, hopLimit uint8) {
+	t.Helper()
+
+	p, ok := e.Read()
+	if !ok {
+		t.Fatal("expected forwarded multicast packet")
+	}
+	checker.IPv6(t, header.IPv6(stack.PayloadSince(p.Pkt.NetworkHeader())),
+		checker.SrcAddr(multicastSrcAddr),
+		checker.DstAddr(multicastGroupAddr),
+		checker.TTL(hopLimit),
+	)
+}
+
+func TestMulticastForwarding(t *testing.T) {
+	const hopLimit = 5
+
+	var disp fakeMulticastEventDispatcher
+	s, eps := newMulticastForwardingStack(t, &disp)
+	stats := s.Stats().IP.MulticastForwarding
+	key := stack.UnicastSourceAndMulticastDestination{
+		Source:      multicastSrcAddr,
+		Destination: multicastGroupAddr,
+	}
+
+	// Packets without a route are queued and reported to the dispatcher once.
+	injectMulticastPacket(eps[multicastNICID1], multicastSrcAddr, multicastGroupAddr, hopLimit)
+	injectMulticastPacket(eps[multicastNICID1], multicastSrcAddr, multicastGroupAddr, hopLimit)
+	wantMissingRoute := []missingRouteEvent{{
+		context: stack.MulticastPacketContext{
+			SourceAndDestination: key,
+			InputInterface:       multicastNICID1,
+		},
+	}}
+	if diff := cmp.Diff(wantMissingRoute, disp.missingRoute, cmp.AllowUnexported(missingRouteEvent{})); diff != "" {
+		t.Errorf("missing route events mismatch (-want +got):\n%s", diff)
+	}
+	if got := stats.NoRoutePacketsQueued.Value(); got != 2 {
+		t.Errorf("got stats.NoRoutePacketsQueued.Value() = %d, want = 2", got)
+	}
+	for nicID, e := range eps {
+		if n := e.Drain(); n != 0 {
+			t.Errorf("got eps[%d].Drain() = %d, want = 0", nicID, n)
+		}
+	}
+
+	// Installing the route forwards the queued packets.
+	route := stack.MulticastRoute{
+		ExpectedInputInterface: multicastNICID1,
+		OutgoingInterfaces: []stack.MulticastRouteOutgoingInterface{
+			{ID: multicastNICID2, MinTTL: 1},
+			{ID: multicastNICID3, MinTTL: 1},
+		},
+	}
+	if err := s.AddMulticastRoute(ProtocolNumber, key, route); err != nil {
+		t.Fatalf("s.AddMulticastRoute(%d, %#v, %#v): %s", ProtocolNumber, key, route, err)
+	}
+	for _, nicID := range []tcpip.NICID{multicastNICID2, multicastNICID3} {
+		for i := 0; i < 2; i++ {
+			checkForwardedMulticastPacket(t, eps[nicID], hopLimit-1)
+		}
+	}
+	if got := stats.PacketsForwarded.Value(); got != 4 {
+		t.Errorf("got stats.PacketsForwarded.Value() = %d, want = 4", got)
+	}
+
+	// Packets matching the route are forwarded as they are received.
+	injectMulticastPacket(eps[multicastNICID1], multicastSrcAddr, multicastGroupAddr, hopLimit)
+	for _, nicID := range []tcpip.NICID{multicastNICID2, multicastNICID3} {
+		checkForwardedMulticastPacket(t, eps[nicID], hopLimit-1)
+	}
+	if n := eps[multicastNICID1].Drain(); n != 0 {
+		t.Errorf("got eps[%d].Drain() = %d, want = 0", multicastNICID1, n)
+	}
+	if _, err := s.MulticastRouteLastUsedTime(ProtocolNumber, key); err != nil {
+		t.Errorf("s.MulticastRouteLastUsedTime(%d, %#v): %s", ProtocolNumber, key, err)
+	}
+
+	// Packets received on an unexpected interface are dropped.
+	injectMulticastPacket(eps[multicastNICID2], multicastSrcAddr, multicastGroupAddr, hopLimit)
+	wantUnexpectedInputInterface := []unexpectedInputInterfaceEvent{{
+		context: stack.MulticastPacketContext{
+			SourceAndDestination: key,
+			InputInterface:       multicastNICID2,
+		},
+		expectedInputInterface: multicastNICID1,
+	}}
+	if diff := cmp.Diff(wantUnexpectedInputInterface, disp.unexpectedInputInterface, cmp.AllowUnexported(unexpectedInputInterfaceEvent{})); diff != "" {
+		t.Errorf("unexpected input interface events mismatch (-want +got):\n%s", diff)
+	}
+	if got := stats.UnexpectedInputInterfaceDropped.Value(); got != 1 {
+		t.Errorf("got stats.UnexpectedInputInterfaceDropped.Value() = %d, want = 1", got)
+	}
+	for nicID, e := range eps {
+		if n := e.Drain(); n != 0 {
+			t.Errorf("got eps[%d].Drain() = %d, want = 0", nicID, n)
+		}
+	}
+
+	// Packets are no longer forwarded once the route is removed.
+	if err := s.RemoveMulticastRoute(ProtocolNumber, key); err != nil {
+		t.Fatalf("s.RemoveMulticastRoute(%d, %#v): %s", ProtocolNumber, key, err)
+	}
+	if err := s.RemoveMulticastRoute(ProtocolNumber, key); err != tcpip.ErrNoRoute {
+		t.Errorf("got s.RemoveMulticastRoute(%d, %#v) = %s, want = %s", ProtocolNumber, key, err, tcpip.ErrNoRoute)
+	}
+	injectMulticastPacket(eps[multicastNICID1], multicastSrcAddr, multicastGroupAddr, hopLimit)
+	if got := len(disp.missingRoute); got != 2 {
+		t.Errorf("got len(disp.missingRoute) = %d, want = 2", got)
+	}
+	for nicID, e := range eps {
+		if n := e.Drain(); n != 0 {
+			t.Errorf("got eps[%d].Drain() = %d, want = 0", nicID, n)
+		}
+	}
+}
+
+func TestMulticastForwardingIneligiblePackets(t *testing.T) {
+	tests := []struct {
+		name     string
+		nicID    tcpip.NICID
+		srcAddr  tcpip.Address
+		dstAddr  tcpip.Address
+		hopLimit uint8
+	}{
+		{
+			name:     "hop limit of one",
+			nicID:    multicastNICID1,
+			srcAddr:  multicastSrcAddr,
+			dstAddr:  multicastGroupAddr,
+			hopLimit: 1,
+		},
+		{
+			name:     "link-local group",
+			nicID:    multicastNICID1,
+			srcAddr:  multicastSrcAddr,
+			dstAddr:  tcpip.Address(net.ParseIP("ff02::1234").To16()),
+			hopLimit: 5,
+		},
+		{
+			name:     "link-local source",
+			nicID:    multicastNICID1,
+			srcAddr:  tcpip.Address(net.ParseIP("fe80::2").To16()),
+			dstAddr:  multicastGroupAddr,
+			hopLimit: 5,
+		},
+		{
+			name:     "unspecified source",
+			nicID:    multicastNICID1,
+			srcAddr:  header.IPv6Any,
+			dstAddr:  multicastGroupAddr,
+			hopLimit: 5,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var disp fakeMulticastEventDispatcher
+			s, eps := newMulticastForwardingStack(t, &disp)
+
+			injectMulticastPacket(eps[test.nicID], test.srcAddr, test.dstAddr, test.hopLimit)
+			if got := len(disp.missingRoute); got != 0 {
+				t.Errorf("got len(disp.missingRoute) = %d, want = 0", got)
+			}
+			if got := s.Stats().IP.MulticastForwarding.NoRoutePacketsQueued.Value(); got != 0 {
+				t.Errorf("got NoRoutePacketsQueued.Value() = %d, want = 0", got)
+			}
+			for nicID, e := range eps {
+				if n := e.Drain(); n != 0 {
+					t.Errorf("got eps[%d].Drain() = %d, want = 0", nicID, n)
+				}
+			}
+		})
+	}
+}
+
+func TestMulticastForwardingDisabledOnNIC(t *testing.T) {
+	var disp fakeMulticastEventDispatcher
+	s, eps := newMulticastForwardingStack(t, &disp)
+	if err := s.SetNICMulticastForwarding(multicastNICID1, ProtocolNumber, false); err != nil {
+		t.Fatalf("SetNICMulticastForwarding(%d, %d, false): %s", multicastNICID1, ProtocolNumber, err)
+	}
+
+	injectMulticastPacket(eps[multicastNICID1], multicastSrcAddr, multicastGroupAddr, 5)
+	if got := len(disp.missingRoute); got != 0 {
+		t.Errorf("got len(disp.missingRoute) = %d, want = 0", got)
+	}
+}
+
+func TestAddMulticastRouteErrors(t *testing.T) {
+	validKey := stack.UnicastSourceAndMulticastDestination{
+		Source:      multicastSrcAddr,
+		Destination: multicastGroupAddr,
+	}
+	validRoute := stack.MulticastRoute{
+		ExpectedInputInterface: multicastNICID1,
+		OutgoingInterfaces: []stack.MulticastRouteOutgoingInterface{
+			{ID: multicastNICID2, MinTTL: 1},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		key     stack.UnicastSourceAndMulticastDestination
+		route   stack.MulticastRoute
+		wantErr *tcpip.Error
+	}{
+		{
+			name:  "valid",
+			key:   validKey,
+			route: validRoute,
+		},
+		{
+			name: "link-local source",
+			key: stack.UnicastSourceAndMulticastDestination{
+				Source:      tcpip.Address(net.ParseIP("fe80::2").To16()),
+				Destination: multicastGroupAddr,
+			},
+			route:   validRoute,
+			wantErr: tcpip.ErrBadAddress,
+		},
+		{
+			name: "multicast source",
+			key: stack.UnicastSourceAndMulticastDestination{
+				Source:      multicastGroupAddr,
+				Destination: multicastGroupAddr,
+			},
+			route:   validRoute,
+			wantErr: tcpip.ErrBadAddress,
+		},
+		{
+			name: "link-local group",
+			key: stack.UnicastSourceAndMulticastDestination{
+				Source:      multicastSrcAddr,
+				Destination: tcpip.Address(net.ParseIP("ff02::1234").To16()),
+			},
+			route:   validRoute,
+			wantErr: tcpip.ErrBadAddress,
+		},
+		{
+			name: "unknown input interface",
+			key:  validKey,
+			route: stack.MulticastRoute{
+				ExpectedInputInterface: 4,
+				OutgoingInterfaces:     validRoute.OutgoingInterfaces,
+			},
+			wantErr: tcpip.ErrUnknownNICID,
+		},
+		{
+			name: "unknown outgoing interface",
+			key:  validKey,
+			route: stack.MulticastRoute{
+				ExpectedInputInterface: multicastNICID1,
+				OutgoingInterfaces: []stack.MulticastRouteOutgoingInterface{
+					{ID: 4, MinTTL: 1},
+				},
+			},
+			wantErr: tcpip.ErrUnknownNICID,
+		},
+		{
+			name: "input interface is outgoing interface",
+			key:  validKey,
+			route: stack.MulticastRoute{
+				ExpectedInputInterface: multicastNICID1,
+				OutgoingInterfaces: []stack.MulticastRouteOutgoingInterface{
+					{ID: multicastNICID1, MinTTL: 1},
+				},
+			},
+			wantErr: tcpip.ErrInvalidOptionValue,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var disp fakeMulticastEventDispatcher
+			s, _ := newMulticastForwardingStack(t, &disp)
+
+			if err := s.AddMulticastRoute(ProtocolNumber, test.key, test.route); err != test.wantErr {
+				t.Errorf("got s.AddMulticastRoute(%d, %#v, %#v) = %s, want = %s", ProtocolNumber, test.key, test.route, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestMulticastForwardingEnableDisable(t *testing.T) {
+	var disp fakeMulticastEventDispatcher
+	s, _ := newMulticastForwardingStack(t, &disp)
+
+	if alreadyEnabled, err := s.EnableMulticastForwardingForProtocol(ProtocolNumber, &disp); err != nil || !alreadyEnabled {
+		t.Errorf("got s.EnableMulticastForwardingForProtocol(%d, _) = (%t, %s), want = (true, nil)", ProtocolNumber, alreadyEnabled, err)
+	}
+
+	if err := s.DisableMulticastForwardingForProtocol(ProtocolNumber); err != nil {
+		t.Fatalf("s.DisableMulticastForwardingForProtocol(%d): %s", ProtocolNumber, err)
+	}
+	key := stack.UnicastSourceAndMulticastDestination{
+		Source:      multicastSrcAddr,
+		Destination: multicastGroupAddr,
+	}
+	route := stack.MulticastRoute{
+		ExpectedInputInterface: multicastNICID1,
+		OutgoingInterfaces: []stack.MulticastRouteOutgoingInterface{
+			{ID: multicastNICID2, MinTTL: 1},
+		},
+	}
+	if err := s.AddMulticastRoute(ProtocolNumber, key, route); err != tcpip.ErrInvalidEndpointState {
+		t.Errorf("got s.AddMulticastRoute(%d, %#v, %#v) = %s, want = %s", ProtocolNumber, key, route, err, tcpip.ErrInvalidEndpointState)
+	}
+
+	if _, err := s.EnableMulticastForwardingForProtocol(ProtocolNumber, nil); err != tcpip.ErrInvalidOptionValue {
+		t.Errorf("got s.EnableMulticastForwardingForProtocol(%d, nil) = (_, %s), want = (_, %s)", ProtocolNumber, err, tcpip.ErrInvalidOptionValue)
+	}
+}
@@ -17,12 +17,13 @@ package ipv6
 import (
 	"fmt"
 	"log"
-	"math/rand"
+	"sort"
 	"time"
 
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ip"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
 
@@ -91,6 +92,14 @@ const (
 	// Default = true.
 	defaultAutoGenGlobalAddresses = true
 
+	// defaultHandleRedirects is the default configuration for whether or not
+	// to handle incoming Redirect messages as a host.
+	defaultHandleRedirects = true
+
+	// defaultRedirectLifetime is the default amount of time a first-hop
+	// learned through a Redirect message is used for.
+	defaultRedirectLifetime = 10 * time.Minute
+
 	// minimumRtrSolicitationInterval is the minimum amount of time to wait
 	// between sending Router Solicitation messages. This limit is imposed
 	// to make sure that Router Solicitation messages are not sent all at
@@ -127,21 +136,22 @@ const (
 	defaultAutoGenTempGlobalAddresses = true
 
 	// defaultMaxTempAddrValidLifetime is the default maximum valid lifetime
-	// for temporary SLAAC addresses generated as part of RFC 4941.
+	// for temporary SLAAC addresses generated as part of RFC 8981.
 	//
-	// Default = 7 days (from RFC 4941 section 5).
-	defaultMaxTempAddrValidLifetime = 7 * 24 * time.Hour
+	// Default = 2 days (from RFC 8981 section 3.8).
+	defaultMaxTempAddrValidLifetime = 2 * 24 * time.Hour
 
 	// defaultMaxTempAddrPreferredLifetime is the default preferred lifetime
-	// for temporary SLAAC addresses generated as part of RFC 4941.
+	// for temporary SLAAC addresses generated as part of RFC 8981.
 	//
-	// Default = 1 day (from RFC 4941 section 5).
+	// Default = 1 day (from RFC 8981 section 3.8).
 	defaultMaxTempAddrPreferredLifetime = 24 * time.Hour
 
 	// defaultRegenAdvanceDuration is the default duration before the deprecation
 	// of a temporary address when a new address will be generated.
 	//
-	// Default = 5s (from RFC 4941 section 5).
+	// Default = 5s (from RFC 8981 section 3.8 with the default DAD
+	// configurations and TEMP_IDGEN_RETRIES = 3).
 	defaultRegenAdvanceDuration = 5 * time.Second
 
 	// minRegenAdvanceDuration is the minimum duration before the deprecation
@@ -165,7 +175,8 @@ var (
 	MinPrefixInformationValidLifetimeForUpdate = 2 * time.Hour
 
 	// MaxDesyncFactor is the upper bound for the preferred lifetime's desync
-	// factor for temporary SLAAC addresses.
+	// factor for temporary SLAAC addresses. As per RFC 8981 section 3.4, a new
+	// desync factor is picked for each temporary address.
 	//
 	// This is exported as a variable (instead of a constant) so tests
 	// can update it to a smaller value.
@@ -200,6 +211,95 @@ var (
 type NDPEndpoint interface {
 	// SetNDPConfigurations sets the NDP configurations.
 	SetNDPConfigurations(NDPConfigurations)
+
+	// AddNDPProxy adds a prefix of addresses for which the endpoint answers
+	// Neighbor Solicitations on behalf of other nodes, equivalent to Linux's
+	// proxy_ndp.
+	//
+	// A single address may be proxied by passing a prefix with a length of
+	// 128 bits.
+	//
+	// Proxied Neighbor Solicitations are only answered while the endpoint is
+	// forwarding packets.
+	AddNDPProxy(tcpip.Subnet) *tcpip.Error
+
+	// RemoveNDPProxy removes a prefix previously added with AddNDPProxy.
+	RemoveNDPProxy(tcpip.Subnet) *tcpip.Error
+
+	// NDPProxies returns the prefixes the endpoint is proxying Neighbor
+	// Solicitations for.
+	NDPProxies() []tcpip.Subnet
+
+	// NDPInfo returns a snapshot of the state learned through NDP and SLAAC.
+	NDPInfo() NDPInfo
+}
+
+// NDPRouterInfo holds information about a discovered default router.
+type NDPRouterInfo struct {
+	// Address is the link-local address of the router.
+	Address tcpip.Address
+
+	// ValidUntil is the time the router will be invalidated at.
+	ValidUntil time.Time
+}
+
+// NDPPrefixInfo holds information about a discovered on-link prefix.
+type NDPPrefixInfo struct {
+	// Prefix is the on-link prefix.
+	Prefix tcpip.Subnet
+
+	// ValidUntil is the time the prefix will be invalidated at.
+	//
+	// Zero if the prefix is valid forever.
+	ValidUntil time.Time
+}
+
+// NDPSLAACAddressInfo holds information about an address generated by SLAAC.
+type NDPSLAACAddressInfo struct {
+	// Address is the generated address.
+	Address tcpip.AddressWithPrefix
+
+	// Tentative is true if DAD has not yet resolved for the address.
+	Tentative bool
+
+	// Deprecated is true if the address is no longer preferred.
+	Deprecated bool
+
+	// ValidUntil is the time the address will be invalidated at.
+	//
+	// Zero if the address is valid forever.
+	ValidUntil time.Time
+
+	// PreferredUntil is the time the address will be deprecated at.
+	//
+	// Zero if the address is preferred forever.
+	PreferredUntil time.Time
+}
+
+// NDPSLAACPrefixInfo holds information about a SLAAC prefix.
+type NDPSLAACPrefixInfo struct {
+	// Prefix is the SLAAC prefix.
+	Prefix tcpip.Subnet
+
+	// StableAddress is the stable address generated for the prefix.
+	StableAddress NDPSLAACAddressInfo
+
+	// TempAddresses are the temporary addresses generated for the prefix.
+	TempAddresses []NDPSLAACAddressInfo
+}
+
+// NDPInfo is a snapshot of the state an IPv6 endpoint learned through NDP and
+// SLAAC.
+type NDPInfo struct {
+	// DefaultRouters are the discovered default routers.
+	DefaultRouters []NDPRouterInfo
+
+	// OnLinkPrefixes are the discovered on-link prefixes.
+	OnLinkPrefixes []NDPPrefixInfo
+
+	// SLAACPrefixes are the prefixes addresses were generated for through
+	// SLAAC.
+	SLAACPrefixes []NDPSLAACPrefixInfo
 }
 
 // DHCPv6ConfigurationFromNDPRA is a configuration available via DHCPv6 that an
@@ -228,19 +328,30 @@ const (
 	DHCPv6OtherConfigurations
 )
 
+// TempAddrPreference determines whether temporary SLAAC addresses are preferred
+// over public addresses when selecting the source address of outgoing packets,
+// as per RFC 6724 section 5 rule 7.
+type TempAddrPreference int
+
+const (
+	// PreferTempAddrs prefers valid temporary SLAAC addresses over public
+	// addresses, as recommended by RFC 6724 section 5 rule 7. This is
+	// equivalent to Linux's use_tempaddr=2.
+	PreferTempAddrs TempAddrPreference = iota
+
+	// PreferPublicAddrs prefers public addresses over temporary SLAAC
+	// addresses. Temporary addresses are still generated (as configured by
+	// AutoGenTempGlobalAddresses) and may be explicitly bound to. This is
+	// equivalent to Linux's use_tempaddr=1.
+	PreferPublicAddrs
+)
+
 // NDPDispatcher is the interface integrators of netstack must implement to
 // receive and handle NDP related events.
 type NDPDispatcher interface {
-	// OnDuplicateAddressDetectionStatus is called when the DAD process for an
-	// address (addr) on a NIC (with ID nicID) completes. resolved is set to true
-	// if DAD completed successfully (no duplicate addr detected); false otherwise
-	// (addr was detected to be a duplicate on the link the NIC is a part of, or
-	// it was stopped for some other reason, such as the address being removed).
-	// If an error occured during DAD, err is set and resolved must be ignored.
-	//
-	// This function is not permitted to block indefinitely. This function
-	// is also not permitted to call into the stack.
-	OnDuplicateAddressDetectionStatus(nicID tcpip.NICID, addr tcpip.Address, resolved bool, err *tcpip.Error)
+	// DADDispatcher is notified of the results of Duplicate Address Detection
+	// performed for IPv6 addresses, as per RFC 4862.
+	stack.DADDispatcher
 
 	// OnDefaultRouterDiscovered is called when a new default router is
 	// discovered. Implementations must return true if the newly discovered
@@ -326,6 +437,39 @@ type NDPDispatcher interface {
 	// This function is not permitted to block indefinitely. It must not
 	// call functions on the stack itself.
 	OnDHCPv6Configuration(tcpip.NICID, DHCPv6ConfigurationFromNDPRA)
+
+	// OnRedirectAccepted is called when a Redirect message sent by routerAddr
+	// is accepted and target becomes the first-hop for packets sent to dst. If
+	// target is the same as dst, dst is a neighbor (on-link).
+	//
+	// This function is not permitted to block indefinitely. It must not
+	// call functions on the stack itself.
+	OnRedirectAccepted(nicID tcpip.NICID, routerAddr, dst, target tcpip.Address)
+}
+
+// RouterAdvertGuard is the interface integrators of netstack may implement to
+// accept or reject Router Advertisements before they are processed, similar to
+// RA Guard as described by RFC 6105.
+//
+// Unlike the NDPDispatcher, which may only reject individual routers or
+// prefixes learned from a Router Advertisement, a RouterAdvertGuard may reject
+// a Router Advertisement as a whole.
+type RouterAdvertGuard interface {
+	// ShouldAcceptRouterAdvert is called when a valid Router Advertisement
+	// (as per RFC 4861 section 6.1.2) is received on a NIC (with ID nicID)
+	// from a router with the link-local address srcAddr. srcLinkAddr holds the
+	// link address from the Source Link-Layer Address option, if present.
+	//
+	// Implementations must return true if the Router Advertisement should be
+	// processed; false otherwise, in which case the Router Advertisement is
+	// silently dropped.
+	//
+	// ra is only valid for the duration of the call and must not be modified
+	// or held after ShouldAcceptRouterAdvert returns.
+	//
+	// This function is not permitted to block indefinitely. It must not
+	// call functions on the stack itself.
+	ShouldAcceptRouterAdvert(nicID tcpip.NICID, srcAddr tcpip.Address, srcLinkAddr tcpip.LinkAddress, ra header.NDPRouterAdvert) bool
 }
 
 // NDPConfigurations is the NDP configurations for the netstack.
@@ -389,13 +533,53 @@ type NDPConfigurations struct {
 	// MAC address), then no attempt is made to resolve the conflict.
 	AutoGenAddressConflictRetries uint8
 
+	// AutoGenStablePrivacyAddresses determines whether or not auto-generated
+	// addresses use stable, semantically opaque interface identifiers as per
+	// RFC 7217 when the integrator does not provide
+	// OpaqueInterfaceIdentifierOptions.NICNameFromID.
+	//
+	// The IID is generated from the NIC's name (or its ID if the NIC has no
+	// name) and OpaqueInterfaceIdentifierOptions.SecretKey. If no secret key
+	// is provided, a random key generated when the stack is created is used so
+	// addresses are stable for the lifetime of the stack.
+	//
+	// Unlike modified EUI-64 based IIDs, opaque IIDs support regenerating
+	// addresses in response to DAD conflicts.
+	AutoGenStablePrivacyAddresses bool
+
 	// AutoGenTempGlobalAddresses determines whether or not temporary SLAAC
 	// addresses are generated for an IPv6 endpoint as part of SLAAC privacy
-	// extensions, as per RFC 4941.
+	// extensions, as per RFC 8981.
 	//
 	// Ignored if AutoGenGlobalAddresses is false.
 	AutoGenTempGlobalAddresses bool
 
+	// InstallDiscoveredRoutes determines whether or not routes are added to
+	// the stack's route table for discovered default routers and on-link
+	// prefixes, and removed when the routers and prefixes are invalidated.
+	//
+	// A default route through each discovered router and a route to each
+	// discovered on-link prefix are added. Routes to on-link prefixes are
+	// added before less specific routes. Routes are added and removed
+	// asynchronously.
+	//
+	// Routers and prefixes are discovered when this is true even if no
+	// NDPDispatcher is provided. An NDPDispatcher may still reject them.
+	InstallDiscoveredRoutes bool
+
+	// TempAddrPreference determines whether temporary SLAAC addresses are
+	// preferred over public addresses during source address selection.
+	TempAddrPreference TempAddrPreference
+
+	// TempAddrExcludedPrefixes holds the subnets that temporary SLAAC addresses
+	// are not generated for. A SLAAC prefix is excluded if it is contained in
+	// any of the subnets (e.g. FC00::/7 excludes all unique local prefixes).
+	//
+	// Note, this option only affects the generation of new temporary addresses;
+	// temporary addresses that were already generated for a prefix are not
+	// invalidated.
+	TempAddrExcludedPrefixes []tcpip.Subnet
+
 	// MaxTempAddrValidLifetime is the maximum valid lifetime for temporary
 	// SLAAC addresses.
 	MaxTempAddrValidLifetime time.Duration
@@ -407,6 +591,33 @@ type NDPConfigurations struct {
 	// RegenAdvanceDuration is the duration before the deprecation of a temporary
 	// address when a new address will be generated.
 	RegenAdvanceDuration time.Duration
+
+	// HandleRedirects determines whether or not Redirect messages are processed
+	// to learn better first-hops for specific destinations, as per RFC 4861
+	// section 8. Redirects are never processed while the endpoint is
+	// forwarding packets.
+	HandleRedirects bool
+
+	// RedirectLifetime is the amount of time a first-hop learned through a
+	// Redirect message is used for.
+	//
+	// Must be greater than 0.
+	RedirectLifetime time.Duration
+
+	// SendRedirects determines whether or not Redirect messages are sent to the
+	// source of a packet that is forwarded back out the interface it was
+	// received on, as per RFC 4861 section 8.2. This configuration is ignored
+	// if the endpoint is not forwarding packets.
+	SendRedirects bool
+
+	// SendUnsolicitedNeighborAdverts determines whether or not an unsolicited
+	// Neighbor Advertisement is sent to the all-nodes multicast address when an
+	// address resolves DAD (or is assigned without DAD), as per RFC 4861
+	// section 7.2.6. As DAD is performed again when the endpoint is enabled,
+	// this also announces addresses when the NIC's link comes back up so
+	// neighbors quickly update stale link-layer addresses (e.g. after the
+	// node migrates to a different link-layer address).
+	SendUnsolicitedNeighborAdverts bool
 }
 
 // DefaultNDPConfigurations returns an NDPConfigurations populated with
@@ -426,6 +637,8 @@ func DefaultNDPConfigurations() NDPConfigurations {
 		MaxTempAddrValidLifetime:     defaultMaxTempAddrValidLifetime,
 		MaxTempAddrPreferredLifetime: defaultMaxTempAddrPreferredLifetime,
 		RegenAdvanceDuration:         defaultRegenAdvanceDuration,
+		HandleRedirects:              defaultHandleRedirects,
+		RedirectLifetime:             defaultRedirectLifetime,
 	}
 }
 
@@ -455,6 +668,18 @@ func (c *NDPConfigurations) validate() {
 	if c.RegenAdvanceDuration < minRegenAdvanceDuration {
 		c.RegenAdvanceDuration = minRegenAdvanceDuration
 	}
+
+	if c.RedirectLifetime <= 0 {
+		c.RedirectLifetime = defaultRedirectLifetime
+	}
+}
+
+// dadConfigs returns the DAD configurations held by c.
+func (c *NDPConfigurations) dadConfigs() stack.DADConfigurations {
+	return stack.DADConfigurations{
+		DupAddrDetectTransmits: c.DupAddrDetectTransmits,
+		RetransmitTimer:        c.RetransmitTimer,
+	}
 }
 
 // ndpState is the per-interface NDP state.
@@ -465,8 +690,8 @@ type ndpState struct {
 	// configs is the per-interface NDP configurations.
 	configs NDPConfigurations
 
-	// The DAD state to send the next NS message, or resolve the address.
-	dad map[tcpip.Address]dadState
+	// The DAD state used to send NS messages and resolve tentative addresses.
+	dad ip.DAD
 
 	// The default routers discovered through Router Advertisements.
 	defaultRouters map[tcpip.Address]defaultRouterState
@@ -489,22 +714,25 @@ type ndpState struct {
 	// IID.
 	temporaryIIDHistory [header.IIDSize]byte
 
-	// temporaryAddressDesyncFactor is the preferred lifetime's desync factor for
-	// temporary SLAAC addresses.
-	temporaryAddressDesyncFactor time.Duration
-}
+	// proxiedPrefixes holds the prefixes that Neighbor Solicitations are
+	// answered for on behalf of other nodes.
+	proxiedPrefixes map[tcpip.Subnet]struct{}
 
-// dadState holds the Duplicate Address Detection timer and channel to signal
-// to the DAD goroutine that DAD should stop.
-type dadState struct {
-	// The DAD timer to send the next NS message, or resolve the address.
-	job *tcpip.Job
+	// routes installs routes for discovered default routers and on-link
+	// prefixes.
+	routes *ndpRouteUpdater
 
-	// Used to let the DAD timer know that it has been stopped.
-	//
-	// Must only be read from or written to while protected by the lock of
-	// the IPv6 endpoint this dadState is associated with.
-	done *bool
+	// staticAddrLifetimes holds the lifetimes of statically added addresses
+	// that do not have infinite lifetimes.
+	staticAddrLifetimes map[tcpip.Address]staticAddrLifetimesState
+}
+
+// now returns the current time according to the stack's clock.
+//
+// NDP timestamps are derived from the stack's clock rather than the system's
+// wall clock so they agree with the jobs scheduled on the stack's clock.
+func (ndp *ndpState) now() time.Time {
+	return time.Unix(0, ndp.ep.protocol.stack.Clock().NowNanoseconds())
 }
 
 // defaultRouterState holds data associated with a default router discovered by
@@ -514,6 +742,13 @@ type defaultRouterState struct {
 	//
 	// Must not be nil.
 	invalidationJob *tcpip.Job
+
+	// The time the router will be invalidated at.
+	validUntil time.Time
+
+	// routeInstalled is true if a default route through the router was added
+	// to the stack's route table.
+	routeInstalled bool
 }
 
 // onLinkPrefixState holds data associated with an on-link prefix discovered by
@@ -524,6 +759,26 @@ type onLinkPrefixState struct {
 	//
 	// Must not be nil.
 	invalidationJob *tcpip.Job
+
+	// Nonzero only when the prefix is not valid forever.
+	validUntil time.Time
+
+	// routeInstalled is true if a route to the prefix was added to the stack's
+	// route table.
+	routeInstalled bool
+}
+
+// staticAddrLifetimesState holds the lifetimes of a statically added address.
+type staticAddrLifetimesState struct {
+	// Job to deprecate the address.
+	//
+	// Must not be nil.
+	deprecationJob *tcpip.Job
+
+	// Job to invalidate the address.
+	//
+	// Must not be nil.
+	invalidationJob *tcpip.Job
 }
 
 // tempSLAACAddrState holds state associated with a temporary SLAAC address.
@@ -545,6 +800,13 @@ type tempSLAACAddrState struct {
 
 	createdAt time.Time
 
+	// The times the address will be invalidated and deprecated at.
+	validUntil     time.Time
+	preferredUntil time.Time
+
+	// desyncFactor is the preferred lifetime's desync factor for the address.
+	desyncFactor time.Duration
+
 	// The address's endpoint.
 	//
 	// Must not be nil.
@@ -621,9 +883,43 @@ func (ndp *ndpState) startDuplicateAddressDetection(addr tcpip.Address, addressE
 		panic(fmt.Sprintf("ndpdad: addr %s is not tentative on NIC(%d)", addr, ndp.ep.nic.ID()))
 	}
 
-	// Should not attempt to perform DAD on an address that is currently in the
-	// DAD process.
-	if _, ok := ndp.dad[addr]; ok {
+	ret := ndp.dad.CheckDuplicateAddressLocked(addr, func(r stack.DADResult) {
+		_, succeeded := r.(*stack.DADSucceeded)
+		if succeeded {
+			if addressEndpoint.GetKind() != stack.PermanentTentative {
+				// The endpoint should still be marked as tentative since we were
+				// performing DAD on it.
+				panic(fmt.Sprintf("ndpdad: addr %s is no longer tentative on NIC(%d)", addr, ndp.ep.nic.ID()))
+			}
+
+			// DAD has resolved.
+			addressEndpoint.SetKind(stack.Permanent)
+			ndp.maybeSendUnsolicitedNeighborAdvert(addr)
+		}
+
+		if ndpDisp := ndp.ep.protocol.options.NDPDisp; ndpDisp != nil {
+			ndpDisp.OnDuplicateAddressDetectionResult(ndp.ep.nic.ID(), addr, r)
+		}
+
+		// If DAD resolved for a stable SLAAC address, attempt generation of a
+		// temporary SLAAC address.
+		//
+		// As per RFC 8981, temporary addresses are not tied to the stable address
+		// so a new temporary address is only generated if the prefix does not
+		// already have a temporary address that is pending regeneration.
+		if succeeded && addressEndpoint.ConfigType() == stack.AddressConfigSlaac {
+			prefix := addressEndpoint.AddressWithPrefix().Subnet()
+			if !ndp.hasActiveTempSLAACAddr(prefix) {
+				// Reset the generation attempts counter as we are starting the
+				// generation of a new address for the SLAAC prefix.
+				ndp.regenerateTempSLAACAddr(prefix, true /* resetGenAttempts */)
+			}
+		}
+	})
+
+	switch ret {
+	case stack.DADStarting:
+	case stack.DADAlreadyRunning:
 		// Should never happen because we should only ever call this function for
 		// newly created addresses. If we attemped to "add" an address that already
 		// existed, we would get an error since we attempted to add a duplicate
@@ -631,78 +927,19 @@ func (ndp *ndpState) startDuplicateAddressDetection(addr tcpip.Address, addressE
 		// the work that would have been done for an address that was brand new.
 		// See endpoint.addAddressLocked.
 		panic(fmt.Sprintf("ndpdad: already performing DAD for addr %s on NIC(%d)", addr, ndp.ep.nic.ID()))
-	}
-
-	remaining := ndp.configs.DupAddrDetectTransmits
-	if remaining == 0 {
+	case stack.DADDisabled:
 		addressEndpoint.SetKind(stack.Permanent)
+		ndp.maybeSendUnsolicitedNeighborAdvert(addr)
 
 		// Consider DAD to have resolved even if no DAD messages were actually
 		// transmitted.
 		if ndpDisp := ndp.ep.protocol.options.NDPDisp; ndpDisp != nil {
-			ndpDisp.OnDuplicateAddressDetectionStatus(ndp.ep.nic.ID(), addr, true, nil)
+			ndpDisp.OnDuplicateAddressDetectionResult(ndp.ep.nic.ID(), addr, &stack.DADSucceeded{})
 		}
-
-		return nil
+	default:
+		panic(fmt.Sprintf("unrecognized DAD check address disposition = %d", ret))
 	}
 
-	state := dadState{
-		job: ndp.ep.protocol.stack.NewJob(&ndp.ep.mu, func() {
-			state, ok := ndp.dad[addr]
-			if !ok {
-				panic(fmt.Sprintf("ndpdad: DAD timer fired but missing state for %s on NIC(%d)", addr, ndp.ep.nic.ID()))
-			}
-
-			if addressEndpoint.GetKind() != stack.PermanentTentative {
-				// The endpoint should still be marked as tentative since we are still
-				// performing DAD on it.
-				panic(fmt.Sprintf("ndpdad: addr %s is no longer tentative on NIC(%d)", addr, ndp.ep.nic.ID()))
-			}
-
-			dadDone := remaining == 0
-
-			var err *tcpip.Error
-			if !dadDone {
-				err = ndp.sendDADPacket(addr, addressEndpoint)
-			}
-
-			if dadDone {
-				// DAD has resolved.
-				addressEndpoint.SetKind(stack.Permanent)
-			} else if err == nil {
-				// DAD is not done and we had no errors when sending the last NDP NS,
-				// schedule the next DAD timer.
-				remaining--
-				state.job.Schedule(ndp.configs.RetransmitTimer)
-				return
-			}
-
-			// At this point we know that either DAD is done or we hit an error
-			// sending the last NDP NS. Either way, clean up addr's DAD state and let
-			// the integrator know DAD has completed.
-			delete(ndp.dad, addr)
-
-			if ndpDisp := ndp.ep.protocol.options.NDPDisp; ndpDisp != nil {
-				ndpDisp.OnDuplicateAddressDetectionStatus(ndp.ep.nic.ID(), addr, dadDone, err)
-			}
-
-			// If DAD resolved for a stable SLAAC address, attempt generation of a
-			// temporary SLAAC address.
-			if dadDone && addressEndpoint.ConfigType() == stack.AddressConfigSlaac {
-				// Reset the generation attempts counter as we are starting the generation
-				// of a new address for the SLAAC prefix.
-				ndp.regenerateTempSLAACAddr(addressEndpoint.AddressWithPrefix().Subnet(), true /* resetGenAttempts */)
-			}
-		}),
-	}
-
-	// We initially start a timer to fire immediately because some of the DAD work
-	// cannot be done while holding the IPv6 endpoint's lock. This is effectively
-	// the same as starting a goroutine but we use a timer that fires immediately
-	// so we can reset it for the next DAD iteration.
-	state.job.Schedule(0)
-	ndp.dad[addr] = state
-
 	return nil
 }
 
@@ -710,7 +947,7 @@ func (ndp *ndpState) startDuplicateAddressDetection(addr tcpip.Address, addressE
 // addr.
 //
 // addr must be a tentative IPv6 address on ndp's IPv6 endpoint.
-func (ndp *ndpState) sendDADPacket(addr tcpip.Address, addressEndpoint stack.AddressEndpoint) *tcpip.Error {
+func (ndp *ndpState) sendDADPacket(addr tcpip.Address) *tcpip.Error {
 	snmc := header.SolicitedNodeAddr(addr)
 
 	icmp := header.ICMPv6(buffer.NewView(header.ICMPv6NeighborSolicitMinimumSize))
@@ -738,6 +975,58 @@ func (ndp *ndpState) sendDADPacket(addr tcpip.Address, addressEndpoint stack.Add
 	return nil
 }
 
+// maybeSendUnsolicitedNeighborAdvert sends an unsolicited NA for addr to the
+// all-nodes multicast address if ndp is configured to do so.
+//
+// addr must be an assigned unicast IPv6 address on ndp's IPv6 endpoint.
+//
+// The IPv6 endpoint that ndp belongs to MUST be locked.
+func (ndp *ndpState) maybeSendUnsolicitedNeighborAdvert(addr tcpip.Address) {
+	if !ndp.configs.SendUnsolicitedNeighborAdverts || !ndp.ep.Enabled() {
+		return
+	}
+
+	// Nothing to announce if the link does not use link-layer addresses.
+	linkAddr := ndp.ep.nic.LinkAddress()
+	if len(linkAddr) == 0 {
+		return
+	}
+
+	optsSerializer := header.NDPOptionsSerializer{
+		header.NDPTargetLinkLayerAddressOption(linkAddr),
+	}
+	icmp := header.ICMPv6(buffer.NewView(header.ICMPv6NeighborAdvertMinimumSize + optsSerializer.Length()))
+	icmp.SetType(header.ICMPv6NeighborAdvert)
+	na := header.NDPNeighborAdvert(icmp.MessageBody())
+
+	// As per RFC 4861 section 7.2.6, the Solicited flag MUST be zero in an
+	// unsolicited advertisement. We want neighbors to replace any cached
+	// link-layer address for addr so the Override flag is set.
+	na.SetSolicitedFlag(false)
+	na.SetOverrideFlag(true)
+	na.SetRouterFlag(ndp.ep.Forwarding())
+	na.SetTargetAddress(addr)
+	na.Options().Serialize(optsSerializer)
+	icmp.SetChecksum(header.ICMPv6Checksum(icmp, addr, header.IPv6AllNodesMulticastAddress, buffer.VectorisedView{}))
+
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		ReserveHeaderBytes: int(ndp.ep.MaxHeaderLength()),
+		Data:               buffer.View(icmp).ToVectorisedView(),
+	})
+
+	sent := ndp.ep.protocol.stack.Stats().ICMP.V6.PacketsSent
+	ndp.ep.addIPHeader(addr, header.IPv6AllNodesMulticastAddress, pkt, stack.NetworkHeaderParams{
+		Protocol: header.ICMPv6ProtocolNumber,
+		TTL:      header.NDPHopLimit,
+	})
+
+	if err := ndp.ep.nic.WritePacketToRemote(header.EthernetAddressFromMulticastIPv6Address(header.IPv6AllNodesMulticastAddress), nil /* gso */, ProtocolNumber, pkt); err != nil {
+		sent.Dropped.Increment()
+		return
+	}
+	sent.NeighborAdvert.Increment()
+}
+
 // stopDuplicateAddressDetection ends a running Duplicate Address Detection
 // process. Note, this may leave the DAD process for a tentative address in
 // such a state forever, unless some other external event resolves the DAD
@@ -745,21 +1034,11 @@ func (ndp *ndpState) sendDADPacket(addr tcpip.Address, addressEndpoint stack.Add
 // (implying another node is attempting to use addr)). It is up to the caller
 // of this function to handle such a scenario.
 //
+// reason is reported to the integrator as the result of the DAD process.
+//
 // The IPv6 endpoint that ndp belongs to MUST be locked.
-func (ndp *ndpState) stopDuplicateAddressDetection(addr tcpip.Address) {
-	dad, ok := ndp.dad[addr]
-	if !ok {
-		// Not currently performing DAD on addr, just return.
-		return
-	}
-
-	dad.job.Cancel()
-	delete(ndp.dad, addr)
-
-	// Let the integrator know DAD did not resolve.
-	if ndpDisp := ndp.ep.protocol.options.NDPDisp; ndpDisp != nil {
-		ndpDisp.OnDuplicateAddressDetectionStatus(ndp.ep.nic.ID(), addr, false, nil)
-	}
+func (ndp *ndpState) stopDuplicateAddressDetection(addr tcpip.Address, reason stack.DADResult) {
+	ndp.dad.StopLocked(addr, reason)
 }
 
 // handleRA handles a Router Advertisement message that arrived on the NIC
@@ -769,11 +1048,12 @@ func (ndp *ndpState) stopDuplicateAddressDetection(addr tcpip.Address) {
 func (ndp *ndpState) handleRA(ip tcpip.Address, ra header.NDPRouterAdvert) {
 	// Is the IPv6 endpoint configured to handle RAs at all?
 	//
-	// Currently, the stack does not determine router interface status on a
-	// per-interface basis; it is a protocol-wide configuration, so we check the
-	// protocol's forwarding flag to determine if the IPv6 endpoint is forwarding
-	// packets.
-	if !ndp.configs.HandleRAs || ndp.ep.protocol.Forwarding() {
+	// Routers do not process RAs the same way hosts do, so we check the
+	// endpoint's forwarding flag to determine if the IPv6 endpoint is operating
+	// as a router on its interface.
+	ndpStats := ndp.ep.protocol.stack.Stats().IP.NDP
+	if !ndp.configs.HandleRAs || ndp.ep.Forwarding() {
+		ndpStats.RouterAdvertsIgnored.Increment()
 		return
 	}
 
@@ -811,6 +1091,8 @@ func (ndp *ndpState) handleRA(ip tcpip.Address, ra header.NDPRouterAdvert) {
 			// MaxDiscoveredDefaultRouters routers.
 			if len(ndp.defaultRouters) < MaxDiscoveredDefaultRouters {
 				ndp.rememberDefaultRouter(ip, rl)
+			} else {
+				ndpStats.DefaultRoutersIgnored.Increment()
 			}
 
 		case ok && rl != 0:
@@ -818,6 +1100,7 @@ func (ndp *ndpState) handleRA(ip tcpip.Address, ra header.NDPRouterAdvert) {
 			// the invalidation job.
 			rtr.invalidationJob.Cancel()
 			rtr.invalidationJob.Schedule(rl)
+			rtr.validUntil = ndp.now().Add(rl)
 			ndp.defaultRouters[ip] = rtr
 
 		case ok && rl == 0:
@@ -859,6 +1142,7 @@ func (ndp *ndpState) handleRA(ip tcpip.Address, ra header.NDPRouterAdvert) {
 			if header.IsV6LinkLocalAddress(prefix.ID()) {
 				// ...Yes, skip as per RFC 4861 section 6.3.4,
 				// and RFC 4862 section 5.5.3.b (for SLAAC).
+				ndpStats.LinkLocalPrefixesIgnored.Increment()
 				continue
 			}
 
@@ -866,6 +1150,7 @@ func (ndp *ndpState) handleRA(ip tcpip.Address, ra header.NDPRouterAdvert) {
 			if prefix.Prefix() == 0 {
 				// ...Yes, skip as this is an invalid prefix
 				// as all IPv6 addresses cannot be on-link.
+				ndpStats.ZeroLengthPrefixesIgnored.Increment()
 				continue
 			}
 
@@ -897,6 +1182,10 @@ func (ndp *ndpState) invalidateDefaultRouter(ip tcpip.Address) {
 	rtr.invalidationJob.Cancel()
 	delete(ndp.defaultRouters, ip)
 
+	if rtr.routeInstalled {
+		ndp.routes.queue(ndpRouteUpdate{route: ndp.defaultRouterRoute(ip)})
+	}
+
 	// Let the integrator know a discovered default router is invalidated.
 	if ndpDisp := ndp.ep.protocol.options.NDPDisp; ndpDisp != nil {
 		ndpDisp.OnDefaultRouterInvalidated(ndp.ep.nic.ID(), ip)
@@ -911,12 +1200,12 @@ func (ndp *ndpState) invalidateDefaultRouter(ip tcpip.Address) {
 // The IPv6 endpoint that ndp belongs to MUST be locked.
 func (ndp *ndpState) rememberDefaultRouter(ip tcpip.Address, rl time.Duration) {
 	ndpDisp := ndp.ep.protocol.options.NDPDisp
-	if ndpDisp == nil {
+	if ndpDisp == nil && !ndp.configs.InstallDiscoveredRoutes {
 		return
 	}
 
 	// Inform the integrator when we discovered a default router.
-	if !ndpDisp.OnDefaultRouterDiscovered(ndp.ep.nic.ID(), ip) {
+	if ndpDisp != nil && !ndpDisp.OnDefaultRouterDiscovered(ndp.ep.nic.ID(), ip) {
 		// Informed by the integrator to not remember the router, do
 		// nothing further.
 		return
@@ -929,6 +1218,12 @@ func (ndp *ndpState) rememberDefaultRouter(ip tcpip.Address, rl time.Duration) {
 	}
 
 	state.invalidationJob.Schedule(rl)
+	state.validUntil = ndp.now().Add(rl)
+
+	if ndp.configs.InstallDiscoveredRoutes {
+		state.routeInstalled = true
+		ndp.routes.queue(ndpRouteUpdate{route: ndp.defaultRouterRoute(ip), add: true})
+	}
 
 	ndp.defaultRouters[ip] = state
 }
@@ -941,12 +1236,12 @@ func (ndp *ndpState) rememberDefaultRouter(ip tcpip.Address, rl time.Duration) {
 // The IPv6 endpoint that ndp belongs to MUST be locked.
 func (ndp *ndpState) rememberOnLinkPrefix(prefix tcpip.Subnet, l time.Duration) {
 	ndpDisp := ndp.ep.protocol.options.NDPDisp
-	if ndpDisp == nil {
+	if ndpDisp == nil && !ndp.configs.InstallDiscoveredRoutes {
 		return
 	}
 
 	// Inform the integrator when we discovered an on-link prefix.
-	if !ndpDisp.OnOnLinkPrefixDiscovered(ndp.ep.nic.ID(), prefix) {
+	if ndpDisp != nil && !ndpDisp.OnOnLinkPrefixDiscovered(ndp.ep.nic.ID(), prefix) {
 		// Informed by the integrator to not remember the prefix, do
 		// nothing further.
 		return
@@ -960,6 +1255,12 @@ func (ndp *ndpState) rememberOnLinkPrefix(prefix tcpip.Subnet, l time.Duration)
 
 	if l < header.NDPInfiniteLifetime {
 		state.invalidationJob.Schedule(l)
+		state.validUntil = ndp.now().Add(l)
+	}
+
+	if ndp.configs.InstallDiscoveredRoutes {
+		state.routeInstalled = true
+		ndp.routes.queue(ndpRouteUpdate{route: ndp.onLinkPrefixRoute(prefix), add: true})
 	}
 
 	ndp.onLinkPrefixes[prefix] = state
@@ -980,6 +1281,10 @@ func (ndp *ndpState) invalidateOnLinkPrefix(prefix tcpip.Subnet) {
 	s.invalidationJob.Cancel()
 	delete(ndp.onLinkPrefixes, prefix)
 
+	if s.routeInstalled {
+		ndp.routes.queue(ndpRouteUpdate{route: ndp.onLinkPrefixRoute(prefix)})
+	}
+
 	// Let the integrator know a discovered on-link prefix is invalidated.
 	if ndpDisp := ndp.ep.protocol.options.NDPDisp; ndpDisp != nil {
 		ndpDisp.OnOnLinkPrefixInvalidated(ndp.ep.nic.ID(), prefix)
@@ -1009,8 +1314,12 @@ func (ndp *ndpState) handleOnLinkPrefixInformation(pi header.NDPPrefixInformatio
 		//
 		// Only remember it if we currently know about less than
 		// MaxDiscoveredOnLinkPrefixes on-link prefixes.
-		if ndp.configs.DiscoverOnLinkPrefixes && len(ndp.onLinkPrefixes) < MaxDiscoveredOnLinkPrefixes {
-			ndp.rememberOnLinkPrefix(prefix, vl)
+		if ndp.configs.DiscoverOnLinkPrefixes {
+			if len(ndp.onLinkPrefixes) < MaxDiscoveredOnLinkPrefixes {
+				ndp.rememberOnLinkPrefix(prefix, vl)
+			} else {
+				ndp.ep.protocol.stack.Stats().IP.NDP.OnLinkPrefixesIgnored.Increment()
+			}
 		}
 		return
 	}
@@ -1029,11 +1338,13 @@ func (ndp *ndpState) handleOnLinkPrefixInformation(pi header.NDPPrefixInformatio
 	// Update the invalidation job.
 
 	prefixState.invalidationJob.Cancel()
+	prefixState.validUntil = time.Time{}
 
 	if vl < header.NDPInfiniteLifetime {
 		// Prefix is valid for a finite lifetime, schedule the job to execute after
 		// the new valid lifetime.
 		prefixState.invalidationJob.Schedule(vl)
+		prefixState.validUntil = ndp.now().Add(vl)
 	}
 
 	ndp.onLinkPrefixes[prefix] = prefixState
@@ -1054,6 +1365,7 @@ func (ndp *ndpState) handleAutonomousPrefixInformation(pi header.NDPPrefixInform
 	// silently ignore the Prefix Information option, as per RFC 4862
 	// section 5.5.3.c.
 	if pl > vl {
+		ndp.ep.protocol.stack.Stats().IP.NDP.InvalidPrefixLifetimesIgnored.Increment()
 		return
 	}
 
@@ -1094,6 +1406,7 @@ func (ndp *ndpState) doSLAAC(prefix tcpip.Subnet, pl, vl time.Duration) {
 	// generate a valid IPv6 address from an interface identifier (IID), as
 	// per RFC 4862 sectiion 5.5.3.d.
 	if prefix.Prefix() != validPrefixLenForAutoGen {
+		ndp.ep.protocol.stack.Stats().IP.NDP.InvalidSLAACPrefixLengthsIgnored.Increment()
 		return
 	}
 
@@ -1118,7 +1431,7 @@ func (ndp *ndpState) doSLAAC(prefix tcpip.Subnet, pl, vl time.Duration) {
 		maxGenerationAttempts: ndp.configs.AutoGenAddressConflictRetries + 1,
 	}
 
-	now := time.Now()
+	now := ndp.now()
 
 	// The time an address is preferred until is needed to properly generate the
 	// address.
@@ -1177,6 +1490,33 @@ func (ndp *ndpState) addAndAcquireSLAACAddr(addr tcpip.AddressWithPrefix, config
 	return addressEndpoint
 }
 
+// opaqueIIDParams returns the NIC name and secret key used to generate opaque
+// IIDs as defined by RFC 7217.
+//
+// Returns false if addresses should not be generated with opaque IIDs.
+//
+// The IPv6 endpoint that ndp belongs to MUST be locked.
+func (ndp *ndpState) opaqueIIDParams() (string, []byte, bool) {
+	oIID := ndp.ep.protocol.options.OpaqueIIDOpts
+	if oIID.NICNameFromID != nil {
+		return oIID.NICNameFromID(ndp.ep.nic.ID(), ndp.ep.nic.Name()), oIID.SecretKey, true
+	}
+
+	if !ndp.configs.AutoGenStablePrivacyAddresses {
+		return "", nil, false
+	}
+
+	nicName := ndp.ep.nic.Name()
+	if len(nicName) == 0 {
+		nicName = fmt.Sprintf("nic%d", ndp.ep.nic.ID())
+	}
+	secretKey := oIID.SecretKey
+	if secretKey == nil {
+		secretKey = ndp.ep.protocol.opaqueIIDSecretKey
+	}
+	return nicName, secretKey, true
+}
+
 // generateSLAACAddr generates a SLAAC address for prefix.
 //
 // Returns true if an address was successfully generated.
@@ -1206,13 +1546,13 @@ func (ndp *ndpState) generateSLAACAddr(prefix tcpip.Subnet, state *slaacPrefixSt
 		}
 
 		dadCounter := state.generationAttempts + state.stableAddr.localGenerationFailures
-		if oIID := ndp.ep.protocol.options.OpaqueIIDOpts; oIID.NICNameFromID != nil {
+		if nicName, secretKey, ok := ndp.opaqueIIDParams(); ok {
 			addrBytes = header.AppendOpaqueInterfaceIdentifier(
 				addrBytes[:header.IIDOffsetInIPv6Address],
 				prefix,
-				oIID.NICNameFromID(ndp.ep.nic.ID(), ndp.ep.nic.Name()),
+				nicName,
 				dadCounter,
-				oIID.SecretKey,
+				secretKey,
 			)
 		} else if dadCounter == 0 {
 			// Modified-EUI64 based IIDs have no way to resolve DAD conflicts, so if
@@ -1249,7 +1589,7 @@ func (ndp *ndpState) generateSLAACAddr(prefix tcpip.Subnet, state *slaacPrefixSt
 		state.stableAddr.localGenerationFailures++
 	}
 
-	if addressEndpoint := ndp.addAndAcquireSLAACAddr(generatedAddr, stack.AddressConfigSlaac, time.Since(state.preferredUntil) >= 0 /* deprecated */); addressEndpoint != nil {
+	if addressEndpoint := ndp.addAndAcquireSLAACAddr(generatedAddr, stack.AddressConfigSlaac, ndp.now().Sub(state.preferredUntil) >= 0 /* deprecated */); addressEndpoint != nil {
 		state.stableAddr.addressEndpoint = addressEndpoint
 		state.generationAttempts++
 		return true
@@ -1288,7 +1628,7 @@ func (ndp *ndpState) regenerateSLAACAddr(prefix tcpip.Subnet) {
 func (ndp *ndpState) generateTempSLAACAddr(prefix tcpip.Subnet, prefixState *slaacPrefixState, resetGenAttempts bool) bool {
 	// Are we configured to auto-generate new temporary global addresses for the
 	// prefix?
-	if !ndp.configs.AutoGenTempGlobalAddresses || prefix == header.IPv6LinkLocalPrefix.Subnet() {
+	if !ndp.configs.AutoGenTempGlobalAddresses || prefix == header.IPv6LinkLocalPrefix.Subnet() || ndp.tempAddrExcluded(prefix) {
 		return false
 	}
 
@@ -1303,12 +1643,11 @@ func (ndp *ndpState) generateTempSLAACAddr(prefix tcpip.Subnet, prefixState *sla
 		return false
 	}
 
-	stableAddr := prefixState.stableAddr.addressEndpoint.AddressWithPrefix().Address
-	now := time.Now()
+	now := ndp.now()
 
-	// As per RFC 4941 section 3.3 step 4, the valid lifetime of a temporary
-	// address is the lower of the valid lifetime of the stable address or the
-	// maximum temporary address valid lifetime.
+	// As per RFC 8981 section 3.4 step 4, the valid lifetime of a temporary
+	// address is the lower of the valid lifetime of the prefix or the maximum
+	// temporary address valid lifetime.
 	vl := ndp.configs.MaxTempAddrValidLifetime
 	if prefixState.validUntil != (time.Time{}) {
 		if prefixVL := prefixState.validUntil.Sub(now); vl > prefixVL {
@@ -1321,15 +1660,19 @@ func (ndp *ndpState) generateTempSLAACAddr(prefix tcpip.Subnet, prefixState *sla
 		return false
 	}
 
-	// As per RFC 4941 section 3.3 step 4, the preferred lifetime of a temporary
-	// address is the lower of the preferred lifetime of the stable address or the
-	// maximum temporary address preferred lifetime - the temporary address desync
-	// factor.
-	pl := ndp.configs.MaxTempAddrPreferredLifetime - ndp.temporaryAddressDesyncFactor
+	// As per RFC 8981 section 3.4 step 4, the preferred lifetime of a temporary
+	// address is the lower of the preferred lifetime of the prefix or the
+	// maximum temporary address preferred lifetime - the address's desync
+	// factor. A new desync factor is picked for each temporary address.
+	var desyncFactor time.Duration
+	if MaxDesyncFactor != 0 {
+		desyncFactor = time.Duration(ndp.ep.protocol.stack.Rand().Int63n(int64(MaxDesyncFactor)))
+	}
+	pl := ndp.configs.MaxTempAddrPreferredLifetime - desyncFactor
 	if prefixState.preferredUntil != (time.Time{}) {
 		if prefixPL := prefixState.preferredUntil.Sub(now); pl > prefixPL {
-			// Respect the preferred lifetime of the prefix, as per RFC 4941 section
-			// 3.3 step 4.
+			// Respect the preferred lifetime of the prefix, as per RFC 8981 section
+			// 3.4 step 4.
 			pl = prefixPL
 		}
 	}
@@ -1352,7 +1695,7 @@ func (ndp *ndpState) generateTempSLAACAddr(prefix tcpip.Subnet, prefixState *sla
 			return false
 		}
 
-		generatedAddr = header.GenerateTempIPv6SLAACAddr(ndp.temporaryIIDHistory[:], stableAddr)
+		generatedAddr = header.GenerateTempIPv6SLAACAddr(ndp.temporaryIIDHistory[:], prefix)
 		if !ndp.ep.hasPermanentAddressRLocked(generatedAddr.Address) {
 			break
 		}
@@ -1417,6 +1760,9 @@ func (ndp *ndpState) generateTempSLAACAddr(prefix tcpip.Subnet, prefixState *sla
 			ndp.slaacPrefixes[prefix] = prefixState
 		}),
 		createdAt:       now,
+		validUntil:      now.Add(vl),
+		preferredUntil:  now.Add(pl),
+		desyncFactor:    desyncFactor,
 		addressEndpoint: addressEndpoint,
 	}
 
@@ -1430,6 +1776,32 @@ func (ndp *ndpState) generateTempSLAACAddr(prefix tcpip.Subnet, prefixState *sla
 	return true
 }
 
+// tempAddrExcluded returns true if temporary addresses must not be generated
+// for prefix.
+//
+// The IPv6 endpoint that ndp belongs to MUST be locked.
+func (ndp *ndpState) tempAddrExcluded(prefix tcpip.Subnet) bool {
+	for _, excluded := range ndp.configs.TempAddrExcludedPrefixes {
+		if prefix.Prefix() >= excluded.Prefix() && excluded.Contains(prefix.ID()) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasActiveTempSLAACAddr returns true if the SLAAC prefix has a temporary
+// address that has not yet been regenerated.
+//
+// The IPv6 endpoint that ndp belongs to MUST be locked.
+func (ndp *ndpState) hasActiveTempSLAACAddr(prefix tcpip.Subnet) bool {
+	for _, state := range ndp.slaacPrefixes[prefix].tempAddrs {
+		if !state.regenerated {
+			return true
+		}
+	}
+	return false
+}
+
 // regenerateTempSLAACAddr regenerates a temporary address for a SLAAC prefix.
 //
 // The IPv6 endpoint that ndp belongs to MUST be locked.
@@ -1461,7 +1833,7 @@ func (ndp *ndpState) refreshSLAACPrefixLifetimes(prefix tcpip.Subnet, prefixStat
 	// deprecation job so it can be reset.
 	prefixState.deprecationJob.Cancel()
 
-	now := time.Now()
+	now := ndp.now()
 
 	// Schedule the deprecation job if prefix has a finite preferred lifetime.
 	if pl < header.NDPInfiniteLifetime {
@@ -1498,7 +1870,7 @@ func (ndp *ndpState) refreshSLAACPrefixLifetimes(prefix tcpip.Subnet, prefixStat
 		if prefixState.validUntil == (time.Time{}) {
 			rl = header.NDPInfiniteLifetime
 		} else {
-			rl = time.Until(prefixState.validUntil)
+			rl = prefixState.validUntil.Sub(now)
 		}
 
 		if vl > MinPrefixInformationValidLifetimeForUpdate || vl > rl {
@@ -1520,14 +1892,12 @@ func (ndp *ndpState) refreshSLAACPrefixLifetimes(prefix tcpip.Subnet, prefixStat
 		return
 	}
 
-	// Note, we do not need to update the entries in the temporary address map
-	// after updating the jobs because the jobs are held as pointers.
 	var regenForAddr tcpip.Address
 	allAddressesRegenerated := true
 	for tempAddr, tempAddrState := range prefixState.tempAddrs {
-		// As per RFC 4941 section 3.3 step 4, the valid lifetime of a temporary
-		// address is the lower of the valid lifetime of the stable address or the
-		// maximum temporary address valid lifetime. Note, the valid lifetime of a
+		// As per RFC 8981 section 3.4 step 4, the valid lifetime of a temporary
+		// address is the lower of the valid lifetime of the prefix or the maximum
+		// temporary address valid lifetime. Note, the valid lifetime of a
 		// temporary address is relative to the address's creation time.
 		validUntil := tempAddrState.createdAt.Add(ndp.configs.MaxTempAddrValidLifetime)
 		if prefixState.validUntil != (time.Time{}) && validUntil.Sub(prefixState.validUntil) > 0 {
@@ -1543,13 +1913,14 @@ func (ndp *ndpState) refreshSLAACPrefixLifetimes(prefix tcpip.Subnet, prefixStat
 		}
 		tempAddrState.invalidationJob.Cancel()
 		tempAddrState.invalidationJob.Schedule(newValidLifetime)
-
-		// As per RFC 4941 section 3.3 step 4, the preferred lifetime of a temporary
-		// address is the lower of the preferred lifetime of the stable address or
-		// the maximum temporary address preferred lifetime - the temporary address
-		// desync factor. Note, the preferred lifetime of a temporary address is
-		// relative to the address's creation time.
-		preferredUntil := tempAddrState.createdAt.Add(ndp.configs.MaxTempAddrPreferredLifetime - ndp.temporaryAddressDesyncFactor)
+		tempAddrState.validUntil = validUntil
+
+		// As per RFC 8981 section 3.4 step 4, the preferred lifetime of a temporary
+		// address is the lower of the preferred lifetime of the prefix or the
+		// maximum temporary address preferred lifetime - the address's desync
+		// factor. Note, the preferred lifetime of a temporary address is relative
+		// to the address's creation time.
+		preferredUntil := tempAddrState.createdAt.Add(ndp.configs.MaxTempAddrPreferredLifetime - tempAddrState.desyncFactor)
 		if prefixState.preferredUntil != (time.Time{}) && preferredUntil.Sub(prefixState.preferredUntil) > 0 {
 			preferredUntil = prefixState.preferredUntil
 		}
@@ -1557,6 +1928,7 @@ func (ndp *ndpState) refreshSLAACPrefixLifetimes(prefix tcpip.Subnet, prefixStat
 		// If the address is no longer preferred, deprecate it immediately.
 		// Otherwise, schedule the deprecation job again.
 		newPreferredLifetime := preferredUntil.Sub(now)
+		tempAddrState.preferredUntil = preferredUntil
 		tempAddrState.deprecationJob.Cancel()
 		if newPreferredLifetime <= 0 {
 			ndp.deprecateSLAACAddress(tempAddrState.addressEndpoint)
@@ -1579,6 +1951,8 @@ func (ndp *ndpState) refreshSLAACPrefixLifetimes(prefix tcpip.Subnet, prefixStat
 				tempAddrState.regenJob.Schedule(newPreferredLifetime - ndp.configs.RegenAdvanceDuration)
 			}
 		}
+
+		prefixState.tempAddrs[tempAddr] = tempAddrState
 	}
 
 	// Generate a new temporary address if all of the existing temporary addresses
@@ -1807,7 +2181,7 @@ func (ndp *ndpState) startSolicitingRouters() {
 	// 4861 section 6.3.7.
 	var delay time.Duration
 	if ndp.configs.MaxRtrSolicitationDelay > 0 {
-		delay = time.Duration(rand.Int63n(int64(ndp.configs.MaxRtrSolicitationDelay)))
+		delay = time.Duration(ndp.ep.protocol.stack.Rand().Int63n(int64(ndp.configs.MaxRtrSolicitationDelay)))
 	}
 
 	ndp.rtrSolicitJob = ndp.ep.protocol.stack.NewJob(&ndp.ep.mu, func() {
@@ -1884,12 +2258,216 @@ func (ndp *ndpState) stopSolicitingRouters() {
 	ndp.rtrSolicitJob = nil
 }
 
+// info returns a snapshot of ndp's discovered routers, on-link prefixes and
+// SLAAC prefixes, sorted by address.
+//
+// The IPv6 endpoint that ndp belongs to MUST be locked.
+func (ndp *ndpState) info() NDPInfo {
+	var info NDPInfo
+
+	for addr, state := range ndp.defaultRouters {
+		info.DefaultRouters = append(info.DefaultRouters, NDPRouterInfo{
+			Address:    addr,
+			ValidUntil: state.validUntil,
+		})
+	}
+	sort.Slice(info.DefaultRouters, func(i, j int) bool {
+		return info.DefaultRouters[i].Address < info.DefaultRouters[j].Address
+	})
+
+	for prefix, state := range ndp.onLinkPrefixes {
+		info.OnLinkPrefixes = append(info.OnLinkPrefixes, NDPPrefixInfo{
+			Prefix:     prefix,
+			ValidUntil: state.validUntil,
+		})
+	}
+	sort.Slice(info.OnLinkPrefixes, func(i, j int) bool {
+		return subnetLess(info.OnLinkPrefixes[i].Prefix, info.OnLinkPrefixes[j].Prefix)
+	})
+
+	slaacAddrInfo := func(addressEndpoint stack.AddressEndpoint, validUntil, preferredUntil time.Time) NDPSLAACAddressInfo {
+		return NDPSLAACAddressInfo{
+			Address:        addressEndpoint.AddressWithPrefix(),
+			Tentative:      addressEndpoint.GetKind() == stack.PermanentTentative,
+			Deprecated:     addressEndpoint.Deprecated(),
+			ValidUntil:     validUntil,
+			PreferredUntil: preferredUntil,
+		}
+	}
+	for prefix, state := range ndp.slaacPrefixes {
+		prefixInfo := NDPSLAACPrefixInfo{Prefix: prefix}
+		if addressEndpoint := state.stableAddr.addressEndpoint; addressEndpoint != nil {
+			prefixInfo.StableAddress = slaacAddrInfo(addressEndpoint, state.validUntil, state.preferredUntil)
+		}
+		for _, tempState := range state.tempAddrs {
+			prefixInfo.TempAddresses = append(prefixInfo.TempAddresses, slaacAddrInfo(tempState.addressEndpoint, tempState.validUntil, tempState.preferredUntil))
+		}
+		sort.Slice(prefixInfo.TempAddresses, func(i, j int) bool {
+			return prefixInfo.TempAddresses[i].Address.Address < prefixInfo.TempAddresses[j].Address.Address
+		})
+		info.SLAACPrefixes = append(info.SLAACPrefixes, prefixInfo)
+	}
+	sort.Slice(info.SLAACPrefixes, func(i, j int) bool {
+		return subnetLess(info.SLAACPrefixes[i].Prefix, info.SLAACPrefixes[j].Prefix)
+	})
+
+	return info
+}
+
+// subnetLess returns true if a sorts before b.
+func subnetLess(a, b tcpip.Subnet) bool {
+	if a.ID() != b.ID() {
+		return a.ID() < b.ID()
+	}
+	return a.Prefix() < b.Prefix()
+}
+
 // initializeTempAddrState initializes state related to temporary SLAAC
 // addresses.
 func (ndp *ndpState) initializeTempAddrState() {
 	header.InitialTempIID(ndp.temporaryIIDHistory[:], ndp.ep.protocol.options.TempIIDSeed, ndp.ep.nic.ID())
+}
 
-	if MaxDesyncFactor != 0 {
-		ndp.temporaryAddressDesyncFactor = time.Duration(rand.Int63n(int64(MaxDesyncFactor)))
+// addProxy starts proxying Neighbor Solicitations for addresses in subnet.
+//
+// If subnet holds a single address, the solicited-node multicast group for
+// the address is joined so solicitations for it are received.
+//
+// The IPv6 endpoint that ndp belongs to MUST be locked.
+func (ndp *ndpState) addProxy(subnet tcpip.Subnet) *tcpip.Error {
+	if len(subnet.ID()) != header.IPv6AddressSize || !header.IsV6UnicastAddress(subnet.ID()) {
+		return tcpip.ErrBadAddress
+	}
+
+	if _, ok := ndp.proxiedPrefixes[subnet]; ok {
+		return tcpip.ErrDuplicateAddress
 	}
+
+	if subnet.Prefix() == header.IPv6AddressSize*8 {
+		if err := ndp.ep.joinGroupLocked(header.SolicitedNodeAddr(subnet.ID())); err != nil {
+			return err
+		}
+	}
+
+	ndp.proxiedPrefixes[subnet] = struct{}{}
+	return nil
+}
+
+// removeProxy stops proxying Neighbor Solicitations for addresses in subnet.
+//
+// The IPv6 endpoint that ndp belongs to MUST be locked.
+func (ndp *ndpState) removeProxy(subnet tcpip.Subnet) *tcpip.Error {
+	if _, ok := ndp.proxiedPrefixes[subnet]; !ok {
+		return tcpip.ErrBadAddress
+	}
+
+	if subnet.Prefix() == header.IPv6AddressSize*8 {
+		if err := ndp.ep.leaveGroupLocked(header.SolicitedNodeAddr(subnet.ID())); err != nil {
+			return err
+		}
+	}
+
+	delete(ndp.proxiedPrefixes, subnet)
+	return nil
+}
+
+// isProxiedAddress returns true if Neighbor Solicitations for addr are
+// answered on behalf of another node.
+//
+// The IPv6 endpoint that ndp belongs to MUST be read locked.
+func (ndp *ndpState) isProxiedAddress(addr tcpip.Address) bool {
+	for subnet := range ndp.proxiedPrefixes {
+		if subnet.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// isProxiedSolicitedNodeAddr returns true if addr may be the solicited-node
+// multicast address of an address in a proxied prefix that is not a single
+// address.
+//
+// Solicited-node multicast groups are only joined for proxied single
+// addresses, so solicitations for addresses in larger prefixes are accepted
+// based on their destination before their target address is checked.
+//
+// The IPv6 endpoint that ndp belongs to MUST be read locked.
+func (ndp *ndpState) isProxiedSolicitedNodeAddr(addr tcpip.Address) bool {
+	if !header.IsSolicitedNodeAddr(addr) {
+		return false
+	}
+
+	for subnet := range ndp.proxiedPrefixes {
+		if subnet.Prefix() != header.IPv6AddressSize*8 {
+			return true
+		}
+	}
+	return false
+}
+
+// setStaticAddrLifetimes sets the lifetimes of a statically added address,
+// scheduling jobs to deprecate and invalidate the address when its preferred
+// and valid lifetimes expire.
+//
+// The IPv6 endpoint that ndp belongs to MUST be locked.
+func (ndp *ndpState) setStaticAddrLifetimes(addressEndpoint stack.AddressEndpoint, lifetimes stack.AddressLifetimes) {
+	addr := addressEndpoint.AddressWithPrefix().Address
+	state, ok := ndp.staticAddrLifetimes[addr]
+	if !ok {
+		state = staticAddrLifetimesState{
+			deprecationJob: ndp.ep.protocol.stack.NewJob(&ndp.ep.mu, func() {
+				if addressEndpoint := ndp.ep.getAddressRLocked(addr); addressEndpoint != nil {
+					addressEndpoint.SetDeprecated(true)
+				}
+			}),
+			invalidationJob: ndp.ep.protocol.stack.NewJob(&ndp.ep.mu, func() {
+				addressEndpoint := ndp.ep.getAddressRLocked(addr)
+				if addressEndpoint == nil {
+					return
+				}
+
+				if err := ndp.ep.removePermanentEndpointLocked(addressEndpoint, false /* allowSLAACInvalidation */); err != nil {
+					panic(fmt.Sprintf("ndp: error removing static address %s: %s", addressEndpoint.AddressWithPrefix(), err))
+				}
+			}),
+		}
+	}
+
+	state.deprecationJob.Cancel()
+	state.invalidationJob.Cancel()
+
+	pl := lifetimes.PreferredLifetime
+	vl := lifetimes.ValidLifetime
+
+	// The address is deprecated immediately if its preferred lifetime is 0, as
+	// per RFC 4862 section 5.5.3.e for SLAAC addresses.
+	addressEndpoint.SetDeprecated(pl == 0)
+	if pl < header.NDPInfiniteLifetime && pl != 0 {
+		state.deprecationJob.Schedule(pl)
+	}
+	if vl < header.NDPInfiniteLifetime {
+		state.invalidationJob.Schedule(vl)
+	}
+
+	if pl >= header.NDPInfiniteLifetime && vl >= header.NDPInfiniteLifetime {
+		delete(ndp.staticAddrLifetimes, addr)
+		return
+	}
+	ndp.staticAddrLifetimes[addr] = state
+}
+
+// cleanupStaticAddrLifetimes stops the jobs that deprecate and invalidate a
+// statically added address.
+//
+// The IPv6 endpoint that ndp belongs to MUST be locked.
+func (ndp *ndpState) cleanupStaticAddrLifetimes(addr tcpip.Address) {
+	state, ok := ndp.staticAddrLifetimes[addr]
+	if !ok {
+		return
+	}
+
+	state.deprecationJob.Cancel()
+	state.invalidationJob.Cancel()
+	delete(ndp.staticAddrLifetimes, addr)
 }
@@ -0,0 +1,124 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv6
+
+import (
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// Metrics of the routes installed for the default routers and on-link prefixes
+// discovered by NDP, as in Linux. Static routes have a metric of zero by
+// default, so they take precedence over the discovered default routers.
+const (
+	// OnLinkPrefixRouteMetric is the metric of the routes to discovered
+	// on-link prefixes.
+	OnLinkPrefixRouteMetric = 256
+
+	// DefaultRouterRouteMetric is the metric of the default routes through
+	// discovered routers.
+	DefaultRouterRouteMetric = 1024
+)
+
+// ndpRouteUpdate is an update to the stack's route table for a discovered
+// default router or on-link prefix.
+type ndpRouteUpdate struct {
+	route tcpip.Route
+	add   bool
+}
+
+// ndpRouteUpdater installs and removes routes in the stack's route table for
+// the default routers and on-link prefixes discovered by an IPv6 endpoint.
+//
+// The stack's route table may not be modified while the endpoint is locked as
+// the stack locks endpoints while its own lock is held when finding routes.
+// Updates are instead queued while the endpoint is locked and applied in
+// order, asynchronously, without holding the endpoint's lock.
+//
+// ndpRouteUpdater.init MUST be called before using a new ndpRouteUpdater.
+type ndpRouteUpdater struct {
+	stack *stack.Stack
+
+	// applyMu serializes the application of queued updates so that updates
+	// are applied in the order they were queued.
+	applyMu sync.Mutex
+
+	mu struct {
+		sync.Mutex
+
+		// pending holds the updates that have not been applied yet.
+		pending []ndpRouteUpdate
+	}
+}
+
+// init sets up an ndpRouteUpdater.
+func (u *ndpRouteUpdater) init(s *stack.Stack) {
+	u.stack = s
+}
+
+// queue queues an update to be applied to the stack's route table.
+func (u *ndpRouteUpdater) queue(update ndpRouteUpdate) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.mu.pending = append(u.mu.pending, update)
+	if len(u.mu.pending) == 1 {
+		u.stack.Clock().AfterFunc(0, u.apply)
+	}
+}
+
+// apply applies the queued updates to the stack's route table.
+func (u *ndpRouteUpdater) apply() {
+	u.applyMu.Lock()
+	defer u.applyMu.Unlock()
+
+	u.mu.Lock()
+	pending := u.mu.pending
+	u.mu.pending = nil
+	u.mu.Unlock()
+
+	for _, update := range pending {
+		route := update.route
+		if update.add {
+			u.stack.InsertRoute(route)
+			continue
+		}
+		u.stack.RemoveRoutes(func(r tcpip.Route) bool {
+			return r == route
+		})
+	}
+}
+
+// defaultRouterRoute returns the default route through the router with the
+// link-local address ip.
+func (ndp *ndpState) defaultRouterRoute(ip tcpip.Address) tcpip.Route {
+	return tcpip.Route{
+		Destination: header.IPv6EmptySubnet,
+		Gateway:     ip,
+		NIC:         ndp.ep.nic.ID(),
+		Metric:      DefaultRouterRouteMetric,
+	}
+}
+
+// onLinkPrefixRoute returns the route to the on-link prefix.
+func (ndp *ndpState) onLinkPrefixRoute(prefix tcpip.Subnet) tcpip.Route {
+	return tcpip.Route{
+		Destination: prefix,
+		NIC:         ndp.ep.nic.ID(),
+		Metric:      OnLinkPrefixRouteMetric,
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip/checker"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
@@ -90,7 +91,7 @@ type testNDPDispatcher struct {
 	addr tcpip.Address
 }
 
-func (*testNDPDispatcher) OnDuplicateAddressDetectionStatus(tcpip.NICID, tcpip.Address, bool, *tcpip.Error) {
+func (*testNDPDispatcher) OnDuplicateAddressDetectionResult(tcpip.NICID, tcpip.Address, stack.DADResult) {
 }
 
 func (t *testNDPDispatcher) OnDefaultRouterDiscovered(_ tcpip.NICID, addr tcpip.Address) bool {
@@ -128,6 +129,9 @@ func (*testNDPDispatcher) OnDNSSearchListOption(tcpip.NICID, []string, time.Dura
 func (*testNDPDispatcher) OnDHCPv6Configuration(tcpip.NICID, DHCPv6ConfigurationFromNDPRA) {
 }
 
+func (*testNDPDispatcher) OnRedirectAccepted(tcpip.NICID, tcpip.Address, tcpip.Address, tcpip.Address) {
+}
+
 func TestStackNDPEndpointInvalidateDefaultRouter(t *testing.T) {
 	var ndpDisp testNDPDispatcher
 	s := stack.New(stack.Options{
@@ -727,6 +731,322 @@ func TestNeighorSolicitationResponse(t *testing.T) {
 	}
 }
 
+// TestNDPProxy tests that Neighbor Solicitations targeting proxied addresses
+// are answered on behalf of the proxied nodes.
+func TestNDPProxy(t *testing.T) {
+	const nicID = 1
+	nicAddr := lladdr0
+	nicLinkAddr := linkAddr0
+	remoteAddr := lladdr1
+	remoteLinkAddr := linkAddr1
+
+	proxiedAddr := tcpip.Address("\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01")
+	proxiedAddrSubnet := proxiedAddr.WithPrefix().Subnet()
+	proxiedPrefix := tcpip.AddressWithPrefix{
+		Address:   tcpip.Address("\x20\x01\x0d\xb8\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00"),
+		PrefixLen: 64,
+	}.Subnet()
+	proxiedPrefixAddr := tcpip.Address("\x20\x01\x0d\xb8\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x05")
+	unproxiedAddr := tcpip.Address("\x20\x01\x0d\xb8\x00\x00\x00\x02\x00\x00\x00\x00\x00\x00\x00\x01")
+
+	tests := []struct {
+		name          string
+		proxies       []tcpip.Subnet
+		forwarding    bool
+		target        tcpip.Address
+		nsDst         tcpip.Address
+		expectedReply bool
+	}{
+		{
+			name:          "Proxied address to solicited-node multicast destination",
+			proxies:       []tcpip.Subnet{proxiedAddrSubnet},
+			forwarding:    true,
+			target:        proxiedAddr,
+			nsDst:         header.SolicitedNodeAddr(proxiedAddr),
+			expectedReply: true,
+		},
+		{
+			name:          "Proxied address to unicast destination",
+			proxies:       []tcpip.Subnet{proxiedAddrSubnet},
+			forwarding:    true,
+			target:        proxiedAddr,
+			nsDst:         proxiedAddr,
+			expectedReply: true,
+		},
+		{
+			name:          "Proxied prefix to solicited-node multicast destination",
+			proxies:       []tcpip.Subnet{proxiedPrefix},
+			forwarding:    true,
+			target:        proxiedPrefixAddr,
+			nsDst:         header.SolicitedNodeAddr(proxiedPrefixAddr),
+			expectedReply: true,
+		},
+		{
+			name:          "Proxied address without forwarding",
+			proxies:       []tcpip.Subnet{proxiedAddrSubnet},
+			forwarding:    false,
+			target:        proxiedAddr,
+			nsDst:         header.SolicitedNodeAddr(proxiedAddr),
+			expectedReply: false,
+		},
+		{
+			name:          "Unproxied address",
+			proxies:       []tcpip.Subnet{proxiedAddrSubnet, proxiedPrefix},
+			forwarding:    true,
+			target:        unproxiedAddr,
+			nsDst:         header.SolicitedNodeAddr(unproxiedAddr),
+			expectedReply: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{NewProtocol},
+			})
+			e := channel.New(1, 1280, nicLinkAddr)
+			e.LinkEPCapabilities |= stack.CapabilityResolutionRequired
+			if err := s.CreateNIC(nicID, e); err != nil {
+				t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+			}
+			if err := s.AddAddress(nicID, ProtocolNumber, nicAddr); err != nil {
+				t.Fatalf("AddAddress(%d, %d, %s) = %s", nicID, ProtocolNumber, nicAddr, err)
+			}
+			if err := s.SetNICForwarding(nicID, ProtocolNumber, test.forwarding); err != nil {
+				t.Fatalf("s.SetNICForwarding(%d, %d, %t): %s", nicID, ProtocolNumber, test.forwarding, err)
+			}
+
+			ep, err := s.GetNetworkEndpoint(nicID, ProtocolNumber)
+			if err != nil {
+				t.Fatalf("s.GetNetworkEndpoint(%d, %d): %s", nicID, ProtocolNumber, err)
+			}
+			ndpEP := ep.(NDPEndpoint)
+			for _, subnet := range test.proxies {
+				if err := ndpEP.AddNDPProxy(subnet); err != nil {
+					t.Fatalf("ndpEP.AddNDPProxy(%s): %s", subnet, err)
+				}
+			}
+
+			s.SetRouteTable([]tcpip.Route{
+				tcpip.Route{
+					Destination: header.IPv6EmptySubnet,
+					NIC:         nicID,
+				},
+			})
+
+			nsOpts := header.NDPOptionsSerializer{
+				header.NDPSourceLinkLayerAddressOption(remoteLinkAddr),
+			}
+			ndpNSSize := header.ICMPv6NeighborSolicitMinimumSize + nsOpts.Length()
+			hdr := buffer.NewPrependable(header.IPv6MinimumSize + ndpNSSize)
+			pkt := header.ICMPv6(hdr.Prepend(ndpNSSize))
+			pkt.SetType(header.ICMPv6NeighborSolicit)
+			ns := header.NDPNeighborSolicit(pkt.MessageBody())
+			ns.SetTargetAddress(test.target)
+			ns.Options().Serialize(nsOpts)
+			pkt.SetChecksum(header.ICMPv6Checksum(pkt, remoteAddr, test.nsDst, buffer.VectorisedView{}))
+			payloadLength := hdr.UsedLength()
+			ip := header.IPv6(hdr.Prepend(header.IPv6MinimumSize))
+			ip.Encode(&header.IPv6Fields{
+				PayloadLength: uint16(payloadLength),
+				NextHeader:    uint8(header.ICMPv6ProtocolNumber),
+				HopLimit:      header.NDPHopLimit,
+				SrcAddr:       remoteAddr,
+				DstAddr:       test.nsDst,
+			})
+			e.InjectLinkAddr(ProtocolNumber, remoteLinkAddr, stack.NewPacketBuffer(stack.PacketBufferOptions{
+				Data: hdr.View().ToVectorisedView(),
+			}))
+
+			p, got := e.Read()
+			if !test.expectedReply {
+				if got {
+					t.Fatalf("unexpected response to NS = %+v", p.Pkt)
+				}
+				return
+			}
+			if !got {
+				t.Fatal("expected an NDP NA response")
+			}
+
+			if got := p.Route.RemoteLinkAddress(); got != remoteLinkAddr {
+				t.Errorf("got p.Route.RemoteLinkAddress() = %s, want = %s", got, remoteLinkAddr)
+			}
+			checker.IPv6(t, stack.PayloadSince(p.Pkt.NetworkHeader()),
+				checker.SrcAddr(nicAddr),
+				checker.DstAddr(remoteAddr),
+				checker.TTL(header.NDPHopLimit),
+				checker.NDPNA(
+					checker.NDPNASolicitedFlag(true),
+					checker.NDPNAOverrideFlag(false),
+					checker.NDPNARouterFlag(true),
+					checker.NDPNATargetAddress(test.target),
+					checker.NDPNAOptions([]header.NDPOption{
+						header.NDPTargetLinkLayerAddressOption(nicLinkAddr[:]),
+					}),
+				))
+		})
+	}
+}
+
+func TestNDPProxyConfiguration(t *testing.T) {
+	const nicID = 1
+
+	proxiedAddr := tcpip.Address("\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01")
+	proxiedAddrSubnet := proxiedAddr.WithPrefix().Subnet()
+
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{NewProtocol},
+	})
+	if err := s.CreateNIC(nicID, channel.New(0, 1280, linkAddr0)); err != nil {
+		t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+	}
+	ep, err := s.GetNetworkEndpoint(nicID, ProtocolNumber)
+	if err != nil {
+		t.Fatalf("s.GetNetworkEndpoint(%d, %d): %s", nicID, ProtocolNumber, err)
+	}
+	ndpEP := ep.(NDPEndpoint)
+	groupEP := ep.(stack.GroupAddressableEndpoint)
+	snmc := header.SolicitedNodeAddr(proxiedAddr)
+
+	if err := ndpEP.AddNDPProxy(proxiedAddrSubnet); err != nil {
+		t.Fatalf("ndpEP.AddNDPProxy(%s): %s", proxiedAddrSubnet, err)
+	}
+	if !groupEP.IsInGroup(snmc) {
+		t.Errorf("got groupEP.IsInGroup(%s) = false, want = true", snmc)
+	}
+	if err := ndpEP.AddNDPProxy(proxiedAddrSubnet); err != tcpip.ErrDuplicateAddress {
+		t.Errorf("got ndpEP.AddNDPProxy(%s) = %s, want = %s", proxiedAddrSubnet, err, tcpip.ErrDuplicateAddress)
+	}
+	if diff := cmp.Diff([]tcpip.Subnet{proxiedAddrSubnet}, ndpEP.NDPProxies()); diff != "" {
+		t.Errorf("NDP proxies mismatch (-want +got):\n%s", diff)
+	}
+
+	multicastSubnet := header.IPv6AllNodesMulticastAddress.WithPrefix().Subnet()
+	if err := ndpEP.AddNDPProxy(multicastSubnet); err != tcpip.ErrBadAddress {
+		t.Errorf("got ndpEP.AddNDPProxy(%s) = %s, want = %s", multicastSubnet, err, tcpip.ErrBadAddress)
+	}
+
+	if err := ndpEP.RemoveNDPProxy(proxiedAddrSubnet); err != nil {
+		t.Fatalf("ndpEP.RemoveNDPProxy(%s): %s", proxiedAddrSubnet, err)
+	}
+	if groupEP.IsInGroup(snmc) {
+		t.Errorf("got groupEP.IsInGroup(%s) = true, want = false", snmc)
+	}
+	if err := ndpEP.RemoveNDPProxy(proxiedAddrSubnet); err != tcpip.ErrBadAddress {
+		t.Errorf("got ndpEP.RemoveNDPProxy(%s) = %s, want = %s", proxiedAddrSubnet, err, tcpip.ErrBadAddress)
+	}
+	if got := ndpEP.NDPProxies(); len(got) != 0 {
+		t.Errorf("got ndpEP.NDPProxies() = %s, want = []", got)
+	}
+}
+
+// TestUnsolicitedNeighborAdvert tests that an unsolicited NA is sent when an
+// address resolves DAD, and again when the NIC is re-enabled.
+func TestUnsolicitedNeighborAdvert(t *testing.T) {
+	const nicID = 1
+
+	tests := []struct {
+		name         string
+		sendNA       bool
+		dadTransmits uint8
+	}{
+		{
+			name:         "Disabled",
+			sendNA:       false,
+			dadTransmits: 1,
+		},
+		{
+			name:         "Without DAD",
+			sendNA:       true,
+			dadTransmits: 0,
+		},
+		{
+			name:         "With DAD",
+			sendNA:       true,
+			dadTransmits: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := faketime.NewManualClock()
+			ndpConfigs := DefaultNDPConfigurations()
+			ndpConfigs.DupAddrDetectTransmits = test.dadTransmits
+			ndpConfigs.RetransmitTimer = time.Second
+			ndpConfigs.SendUnsolicitedNeighborAdverts = test.sendNA
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{NewProtocolWithOptions(Options{
+					NDPConfigs: ndpConfigs,
+				})},
+				Clock: clock,
+			})
+			e := channel.New(10, 1280, linkAddr1)
+			if err := s.CreateNIC(nicID, e); err != nil {
+				t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+			}
+
+			// checkNA reads all the packets sent by the stack and checks that an
+			// unsolicited NA for lladdr1 was sent iff the test expects it.
+			checkNA := func(t *testing.T) {
+				t.Helper()
+
+				clock.Advance(time.Duration(test.dadTransmits) * ndpConfigs.RetransmitTimer)
+
+				sentNA := false
+				for {
+					p, ok := e.Read()
+					if !ok {
+						break
+					}
+					payload := stack.PayloadSince(p.Pkt.NetworkHeader())
+					if header.ICMPv6(header.IPv6(payload).Payload()).Type() != header.ICMPv6NeighborAdvert {
+						continue
+					}
+					if sentNA {
+						t.Fatal("got more than one NA")
+					}
+					sentNA = true
+
+					if got, want := p.Route.RemoteLinkAddress(), header.EthernetAddressFromMulticastIPv6Address(header.IPv6AllNodesMulticastAddress); got != want {
+						t.Errorf("got p.Route.RemoteLinkAddress() = %s, want = %s", got, want)
+					}
+					checker.IPv6(t, payload,
+						checker.SrcAddr(lladdr1),
+						checker.DstAddr(header.IPv6AllNodesMulticastAddress),
+						checker.TTL(header.NDPHopLimit),
+						checker.NDPNA(
+							checker.NDPNASolicitedFlag(false),
+							checker.NDPNAOverrideFlag(true),
+							checker.NDPNARouterFlag(false),
+							checker.NDPNATargetAddress(lladdr1),
+							checker.NDPNAOptions([]header.NDPOption{
+								header.NDPTargetLinkLayerAddressOption(linkAddr1),
+							}),
+						))
+				}
+				if sentNA != test.sendNA {
+					t.Errorf("got sentNA = %t, want = %t", sentNA, test.sendNA)
+				}
+			}
+
+			if err := s.AddAddress(nicID, ProtocolNumber, lladdr1); err != nil {
+				t.Fatalf("AddAddress(%d, %d, %s) = %s", nicID, ProtocolNumber, lladdr1, err)
+			}
+			checkNA(t)
+
+			// The address should be announced again when the NIC comes back up.
+			if err := s.DisableNIC(nicID); err != nil {
+				t.Fatalf("DisableNIC(%d) = %s", nicID, err)
+			}
+			if err := s.EnableNIC(nicID); err != nil {
+				t.Fatalf("EnableNIC(%d) = %s", nicID, err)
+			}
+			checkNA(t)
+		})
+	}
+}
+
 // TestNeighorAdvertisementWithTargetLinkLayerOption tests that receiving a
 // valid NDP NA message with the Target Link Layer Address option results in a
 // new entry in the link address cache for the target of the message.
@@ -1061,7 +1381,7 @@ func TestNDPValidation(t *testing.T) {
 				{
 					name: "RedirectMsg",
 					typ:  header.ICMPv6RedirectMsg,
-					size: header.ICMPv6MinimumSize,
+					size: header.ICMPv6RedirectMinimumSize,
 					statCounter: func(stats tcpip.ICMPv6ReceivedPacketStats) *tcpip.StatCounter {
 						return stats.RedirectMsg
 					},
@@ -1074,6 +1394,8 @@ func TestNDPValidation(t *testing.T) {
 				hopLimit       uint8
 				code           header.ICMPv6Code
 				valid          bool
+				fragmented     bool
+				dropStat       func(tcpip.NDPStats) *tcpip.StatCounter
 			}{
 				{
 					name:           "Valid",
@@ -1088,6 +1410,7 @@ func TestNDPValidation(t *testing.T) {
 					hopLimit:       header.NDPHopLimit,
 					code:           0,
 					valid:          false,
+					fragmented:     true,
 				},
 				{
 					name:           "Invalid hop limit",
@@ -1095,6 +1418,7 @@ func TestNDPValidation(t *testing.T) {
 					hopLimit:       header.NDPHopLimit - 1,
 					code:           0,
 					valid:          false,
+					dropStat:       func(stats tcpip.NDPStats) *tcpip.StatCounter { return stats.InvalidHopLimitDropped },
 				},
 				{
 					name:           "Invalid ICMPv6 code",
@@ -1102,6 +1426,7 @@ func TestNDPValidation(t *testing.T) {
 					hopLimit:       header.NDPHopLimit,
 					code:           1,
 					valid:          false,
+					dropStat:       func(stats tcpip.NDPStats) *tcpip.StatCounter { return stats.InvalidCodeDropped },
 				},
 			}
 
@@ -1125,6 +1450,7 @@ func TestNDPValidation(t *testing.T) {
 								stats := s.Stats().ICMP.V6.PacketsReceived
 								invalid := stats.Invalid
 								routerOnly := stats.RouterOnlyPacketsDroppedByHost
+								fragmented := s.Stats().IP.NDP.FragmentedMessagesDropped
 								typStat := typ.statCounter(stats)
 
 								icmp := header.ICMPv6(buffer.NewView(typ.size + len(typ.extraData)))
@@ -1148,6 +1474,11 @@ func TestNDPValidation(t *testing.T) {
 									t.Errorf("got RouterOnlyPacketsReceivedByHost = %d, want = 0", got)
 								}
 
+								// FragmentedMessagesDropped count should initially be 0.
+								if got := fragmented.Value(); got != 0 {
+									t.Errorf("got FragmentedMessagesDropped = %d, want = 0", got)
+								}
+
 								if t.Failed() {
 									t.FailNow()
 								}
@@ -1177,6 +1508,21 @@ func TestNDPValidation(t *testing.T) {
 									t.Errorf("got RouterOnlyPacketsReceivedByHost = %d, want = %d", got, want)
 								}
 
+								want = 0
+								if test.fragmented {
+									// FragmentedMessagesDropped count should have increased.
+									want = 1
+								}
+								if got := fragmented.Value(); got != want {
+									t.Errorf("got FragmentedMessagesDropped = %d, want = %d", got, want)
+								}
+
+								if test.dropStat != nil {
+									if got := test.dropStat(s.Stats().IP.NDP).Value(); got != 1 {
+										t.Errorf("got NDP drop stat = %d, want = 1", got)
+									}
+								}
+
 							})
 						}
 					})
@@ -0,0 +1,234 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv6
+
+import (
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// MaxRedirectedDestinations is the maximum number of destinations that
+// first-hops learned through Redirect messages are remembered for. Redirects
+// for new destinations are ignored once MaxRedirectedDestinations destinations
+// are remembered.
+const MaxRedirectedDestinations = 128
+
+// redirectEntry holds data associated with a destination's first-hop learned
+// through a Redirect message.
+type redirectEntry struct {
+	// nextHop is the first-hop for the destination. Empty if the destination
+	// is a neighbor (on-link).
+	nextHop tcpip.Address
+
+	// Job to invalidate the redirect.
+	//
+	// Must not be nil.
+	invalidationJob *tcpip.Job
+}
+
+// redirectState is the per-interface cache of first-hops learned through
+// Redirect messages, as per RFC 4861 section 8.3.
+//
+// redirectState is protected by its own lock instead of the endpoint's lock as
+// it is queried by the stack when finding routes, while the stack's lock is
+// held.
+//
+// redirectState.init MUST be called to initialize the redirect state.
+type redirectState struct {
+	// The IPv6 endpoint this redirectState is for.
+	ep *endpoint
+
+	mu struct {
+		sync.Mutex
+
+		// entries maps a destination to its redirected first-hop.
+		entries map[tcpip.Address]redirectEntry
+	}
+}
+
+// init sets up a redirectState struct, and is required to be called before
+// using a new redirectState.
+func (r *redirectState) init(ep *endpoint) {
+	r.ep = ep
+	r.mu.entries = make(map[tcpip.Address]redirectEntry)
+}
+
+// lookup returns the first-hop learned for dst.
+//
+// Returns false if no first-hop has been learned for dst.
+func (r *redirectState) lookup(dst tcpip.Address) (tcpip.Address, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.mu.entries[dst]
+	return entry.nextHop, ok
+}
+
+// update remembers nextHop as the first-hop for dst for the specified lifetime.
+// If a first-hop was already learned for dst, it is replaced and its lifetime
+// is refreshed.
+//
+// Returns false if dst was not already known and the maximum number of
+// destinations are already remembered.
+func (r *redirectState) update(dst, nextHop tcpip.Address, lifetime time.Duration) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if entry, ok := r.mu.entries[dst]; ok {
+		entry.nextHop = nextHop
+		entry.invalidationJob.Cancel()
+		entry.invalidationJob.Schedule(lifetime)
+		r.mu.entries[dst] = entry
+		r.ep.protocol.stack.InvalidateRouteCaches()
+		return true
+	}
+
+	if len(r.mu.entries) >= MaxRedirectedDestinations {
+		return false
+	}
+
+	entry := redirectEntry{
+		nextHop: nextHop,
+		invalidationJob: r.ep.protocol.stack.NewJob(&r.mu, func() {
+			delete(r.mu.entries, dst)
+			r.ep.protocol.stack.InvalidateRouteCaches()
+		}),
+	}
+	entry.invalidationJob.Schedule(lifetime)
+	r.mu.entries[dst] = entry
+	r.ep.protocol.stack.InvalidateRouteCaches()
+	return true
+}
+
+// clear forgets all the first-hops learned through Redirect messages.
+func (r *redirectState) clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for dst, entry := range r.mu.entries {
+		entry.invalidationJob.Cancel()
+		delete(r.mu.entries, dst)
+	}
+	r.ep.protocol.stack.InvalidateRouteCaches()
+}
+
+// linkLocalAddressRLocked returns an assigned link-local address of the
+// endpoint, or the empty address if the endpoint has no assigned link-local
+// address.
+//
+// Precondition: e.mu must be read locked.
+func (e *endpoint) linkLocalAddressRLocked() tcpip.Address {
+	var addr tcpip.Address
+	e.mu.addressableEndpointState.ForEachPrimaryEndpoint(func(addressEndpoint stack.AddressEndpoint) {
+		if len(addr) != 0 || !addressEndpoint.IsAssigned(false /* allowExpired */) {
+			return
+		}
+
+		if a := addressEndpoint.AddressWithPrefix().Address; header.IsV6LinkLocalAddress(a) {
+			addr = a
+		}
+	})
+	return addr
+}
+
+// maybeSendRedirect sends a Redirect message to the source of pkt if pkt is
+// being forwarded through r back out the interface it was received on and a
+// better first-hop for the packet's destination is on the same link as the
+// source, as per RFC 4861 section 8.2.
+func (e *endpoint) maybeSendRedirect(r *stack.Route, pkt *stack.PacketBuffer) {
+	e.mu.RLock()
+	sendRedirects := e.mu.ndp.configs.SendRedirects
+	localAddr := e.linkLocalAddressRLocked()
+	e.mu.RUnlock()
+
+	// As per RFC 4861 section 4.5, the source of a Redirect MUST be the
+	// link-local address assigned to the interface it is sent from.
+	if !sendRedirects || len(localAddr) == 0 {
+		return
+	}
+
+	h := header.IPv6(pkt.NetworkHeader().View())
+	srcAddr := h.SourceAddress()
+	dstAddr := h.DestinationAddress()
+	if !header.IsV6UnicastAddress(srcAddr) {
+		return
+	}
+
+	// As per RFC 4861 section 8.2, the Target Address is the destination itself
+	// if the destination is a neighbor. Otherwise, it is the link-local address
+	// of the better first-hop router; hosts only accept link-local targets so
+	// we do not redirect to a router we only know by a global address.
+	target := r.NextHop
+	if len(target) == 0 {
+		target = dstAddr
+	} else if !header.IsV6LinkLocalAddress(target) {
+		return
+	}
+	if target == srcAddr {
+		return
+	}
+
+	// Redirects are only sent to neighbors.
+	srcRoute, err := e.protocol.stack.FindRoute(e.nic.ID(), localAddr, srcAddr, ProtocolNumber, false /* multicastLoop */)
+	if err != nil {
+		return
+	}
+	defer srcRoute.Release()
+	if len(srcRoute.NextHop) != 0 {
+		return
+	}
+
+	sent := e.protocol.stack.Stats().ICMP.V6.PacketsSent
+	if !e.protocol.stack.AllowICMPMessage() {
+		sent.RateLimited.Increment()
+		return
+	}
+
+	// As per RFC 4861 section 4.5, the Redirected Header option holds as much of
+	// the forwarded packet as fits without the Redirect exceeding the minimum
+	// IPv6 MTU.
+	redirected := stack.PayloadSince(pkt.NetworkHeader())
+	available := header.IPv6MinimumMTU - header.IPv6MinimumSize - header.ICMPv6RedirectMinimumSize - header.NDPOptionsSerializer{header.NDPRedirectedHeader(nil)}.Length()
+	if len(redirected) > available {
+		redirected = redirected[:available]
+	}
+	optsSerializer := header.NDPOptionsSerializer{
+		header.NDPRedirectedHeader(redirected),
+	}
+
+	redirectSize := header.ICMPv6RedirectMinimumSize + optsSerializer.Length()
+	redirectPkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		ReserveHeaderBytes: int(srcRoute.MaxHeaderLength()) + redirectSize,
+	})
+	redirectPkt.TransportProtocolNumber = header.ICMPv6ProtocolNumber
+	packet := header.ICMPv6(redirectPkt.TransportHeader().Push(redirectSize))
+	packet.SetType(header.ICMPv6RedirectMsg)
+	rd := header.NDPRedirect(packet.MessageBody())
+	rd.SetTargetAddress(target)
+	rd.SetDestinationAddress(dstAddr)
+	rd.Options().Serialize(optsSerializer)
+	packet.SetChecksum(header.ICMPv6Checksum(packet, srcRoute.LocalAddress, srcRoute.RemoteAddress, buffer.VectorisedView{}))
+
+	if err := srcRoute.WritePacket(nil /* gso */, stack.NetworkHeaderParams{Protocol: header.ICMPv6ProtocolNumber, TTL: header.NDPHopLimit, TOS: stack.DefaultTOS}, redirectPkt); err != nil {
+		sent.Dropped.Increment()
+		return
+	}
+	sent.RedirectMsg.Increment()
+}
@@ -170,7 +170,7 @@ func main() {
 
 	// Bind if a port is specified.
 	if localPort != 0 {
-		if err := ep.Bind(tcpip.FullAddress{0, "", localPort}); err != nil {
+		if err := ep.Bind(tcpip.FullAddress{NIC: 0, Addr: "", Port: localPort}); err != nil {
 			log.Fatal("Bind failed: ", err)
 		}
 	}
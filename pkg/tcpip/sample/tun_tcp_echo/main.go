@@ -170,7 +170,7 @@ func main() {
 
 	defer ep.Close()
 
-	if err := ep.Bind(tcpip.FullAddress{0, "", uint16(localPort)}); err != nil {
+	if err := ep.Bind(tcpip.FullAddress{NIC: 0, Addr: "", Port: uint16(localPort)}); err != nil {
 		log.Fatal("Bind failed: ", err)
 	}
 
@@ -16,6 +16,10 @@ package tcpip
 
 import (
 	"sync/atomic"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
 )
 
 // SocketOptionsHandler holds methods that help define endpoint specific
@@ -37,6 +41,13 @@ type SocketOptionsHandler interface {
 
 	// OnCorkOptionSet is invoked when TCP_CORK is set for an endpoint.
 	OnCorkOptionSet(v bool)
+
+	// OnQuickAckSet is invoked when TCP_QUICKACK is set for an endpoint.
+	OnQuickAckSet(v bool)
+
+	// OnReusePortFilterSet is invoked when a SO_REUSEPORT filter is
+	// attached to or detached from an endpoint.
+	OnReusePortFilterSet(f ReusePortFilter)
 }
 
 // DefaultSocketOptionsHandler is an embeddable type that implements no-op
@@ -60,6 +71,23 @@ func (*DefaultSocketOptionsHandler) OnDelayOptionSet(bool) {}
 // OnCorkOptionSet implements SocketOptionsHandler.OnCorkOptionSet.
 func (*DefaultSocketOptionsHandler) OnCorkOptionSet(bool) {}
 
+// OnQuickAckSet implements SocketOptionsHandler.OnQuickAckSet.
+func (*DefaultSocketOptionsHandler) OnQuickAckSet(bool) {}
+
+// OnReusePortFilterSet implements SocketOptionsHandler.OnReusePortFilterSet.
+func (*DefaultSocketOptionsHandler) OnReusePortFilterSet(ReusePortFilter) {}
+
+// ReusePortFilter selects which endpoint of a group of endpoints bound with
+// SO_REUSEPORT receives a packet, like a program attached with
+// SO_ATTACH_REUSEPORT_CBPF.
+type ReusePortFilter interface {
+	// Select returns the index of the endpoint which receives the packet
+	// with the given transport payload. Endpoints are indexed in the order
+	// in which they joined the group. ok is false if the filter failed to
+	// run.
+	Select(payload buffer.VectorisedView) (idx uint32, ok bool)
+}
+
 // SocketOptions contains all the variables which store values for SOL_SOCKET,
 // SOL_IP, SOL_IPV6 and SOL_TCP level options.
 //
@@ -97,6 +125,10 @@ type SocketOptions struct {
 	// non-loopback interface will be looped back. Analogous to inet->mc_loop.
 	multicastLoopEnabled uint32
 
+	// freeBindEnabled is used to specify if the socket may bind to addresses
+	// which aren't assigned to any NIC. Analogous to inet->freebind.
+	freeBindEnabled uint32
+
 	// receiveTOSEnabled is used to specify if the TOS ancillary message is
 	// passed with incoming packets.
 	receiveTOSEnabled uint32
@@ -105,6 +137,14 @@ type SocketOptions struct {
 	// message is passed with incoming packets.
 	receiveTClassEnabled uint32
 
+	// receiveFlowInfoEnabled is used to specify if the IPV6_FLOWINFO
+	// ancillary message is passed with incoming packets.
+	receiveFlowInfoEnabled uint32
+
+	// sendFlowInfoEnabled is used to specify if the flow label of the
+	// destination address is used for outgoing packets.
+	sendFlowInfoEnabled uint32
+
 	// receivePacketInfoEnabled is used to specify if more inforamtion is
 	// provided with incoming packets such as interface index and address.
 	receivePacketInfoEnabled uint32
@@ -130,6 +170,78 @@ type SocketOptions struct {
 	// corkOptionEnabled is used to specify if data should be held until segments
 	// are full by the TCP transport protocol.
 	corkOptionEnabled uint32
+
+	// recvErrEnabled determines whether extended reliable error message passing
+	// is enabled.
+	recvErrEnabled uint32
+
+	// errQueueMu protects errQueue and errQueueLen.
+	errQueueMu sync.Mutex `state:"nosave"`
+
+	// errQueue is the queue of extended errors reported to the socket. It is
+	// only populated while recvErrEnabled is set.
+	errQueue sockErrorList
+
+	// errQueueLen is the number of errors held in errQueue.
+	errQueueLen int
+
+	// zeroCopyEnabled is used to specify if sends with MSG_ZEROCOPY may avoid
+	// copying their payload.
+	zeroCopyEnabled uint32
+
+	// zeroCopyNextID is the sequence number of the next send with
+	// MSG_ZEROCOPY, reported in completion notifications.
+	zeroCopyNextID uint32
+
+	// reusePortFilterMu protects reusePortFilter.
+	reusePortFilterMu sync.Mutex `state:"nosave"`
+
+	// reusePortFilter is the filter attached with SO_ATTACH_REUSEPORT_CBPF,
+	// or nil.
+	reusePortFilter ReusePortFilter
+
+	// txTimeMu protects txTime.
+	txTimeMu sync.Mutex `state:"nosave"`
+
+	// txTime is the SO_TXTIME configuration.
+	txTime TxTimeOption
+
+	// busyPoll is the SO_BUSY_POLL budget, in nanoseconds, for which blocked
+	// reads poll the NICs for inbound packets before sleeping.
+	busyPoll int64
+
+	// timestamping holds the SO_TIMESTAMPING flags.
+	timestamping uint32
+
+	// mark is the SO_MARK mark of the packets sent by the socket, which
+	// selects the routing policy rules that apply to them.
+	mark uint32
+
+	// timestampingKey is the key of the next send for which transmit
+	// timestamps are requested, or for stream sockets the offset of the
+	// next byte sent. See TimestampingOptID.
+	timestampingKey uint32
+}
+
+// TxTimeOption is the SO_TXTIME configuration of a socket, which allows its
+// packets to be scheduled for transmission at a given time.
+//
+// +stateify savable
+type TxTimeOption struct {
+	// Enabled is set if the socket may schedule its packets.
+	Enabled bool
+
+	// ClockID is the clock of the transmit times. It is opaque to the stack,
+	// which is given transmit times in its own monotonic clock.
+	ClockID int32
+
+	// DeadlineMode is set if the transmit times are deadlines by which the
+	// packets must be transmitted, rather than their transmission times.
+	DeadlineMode bool
+
+	// ReportErrors is set if packets dropped because of their transmit time
+	// are reported to the error queue.
+	ReportErrors bool
 }
 
 // InitHandler initializes the handler. This must be called before using the
@@ -219,6 +331,16 @@ func (so *SocketOptions) SetMulticastLoop(v bool) {
 	storeAtomicBool(&so.multicastLoopEnabled, v)
 }
 
+// GetFreeBind gets value for IP_FREEBIND and IPV6_FREEBIND options.
+func (so *SocketOptions) GetFreeBind() bool {
+	return atomic.LoadUint32(&so.freeBindEnabled) != 0
+}
+
+// SetFreeBind sets value for IP_FREEBIND and IPV6_FREEBIND options.
+func (so *SocketOptions) SetFreeBind(v bool) {
+	storeAtomicBool(&so.freeBindEnabled, v)
+}
+
 // GetReceiveTOS gets value for IP_RECVTOS option.
 func (so *SocketOptions) GetReceiveTOS() bool {
 	return atomic.LoadUint32(&so.receiveTOSEnabled) != 0
@@ -239,6 +361,26 @@ func (so *SocketOptions) SetReceiveTClass(v bool) {
 	storeAtomicBool(&so.receiveTClassEnabled, v)
 }
 
+// GetReceiveFlowInfo gets value for IPV6_FLOWINFO option.
+func (so *SocketOptions) GetReceiveFlowInfo() bool {
+	return atomic.LoadUint32(&so.receiveFlowInfoEnabled) != 0
+}
+
+// SetReceiveFlowInfo sets value for IPV6_FLOWINFO option.
+func (so *SocketOptions) SetReceiveFlowInfo(v bool) {
+	storeAtomicBool(&so.receiveFlowInfoEnabled, v)
+}
+
+// GetSendFlowInfo gets value for IPV6_FLOWINFO_SEND option.
+func (so *SocketOptions) GetSendFlowInfo() bool {
+	return atomic.LoadUint32(&so.sendFlowInfoEnabled) != 0
+}
+
+// SetSendFlowInfo sets value for IPV6_FLOWINFO_SEND option.
+func (so *SocketOptions) SetSendFlowInfo(v bool) {
+	storeAtomicBool(&so.sendFlowInfoEnabled, v)
+}
+
 // GetReceivePacketInfo gets value for IP_PKTINFO option.
 func (so *SocketOptions) GetReceivePacketInfo() bool {
 	return atomic.LoadUint32(&so.receivePacketInfoEnabled) != 0
@@ -279,6 +421,7 @@ func (so *SocketOptions) GetQuickAck() bool {
 // SetQuickAck sets value for TCP_QUICKACK option.
 func (so *SocketOptions) SetQuickAck(v bool) {
 	storeAtomicBool(&so.quickAckEnabled, v)
+	so.handler.OnQuickAckSet(v)
 }
 
 // GetDelayOption gets inverted value for TCP_NODELAY option.
@@ -302,3 +445,239 @@ func (so *SocketOptions) SetCorkOption(v bool) {
 	storeAtomicBool(&so.corkOptionEnabled, v)
 	so.handler.OnCorkOptionSet(v)
 }
+
+// GetRecvError gets value for IP*_RECVERR option.
+func (so *SocketOptions) GetRecvError() bool {
+	return atomic.LoadUint32(&so.recvErrEnabled) != 0
+}
+
+// SetRecvError sets value for IP*_RECVERR option.
+//
+// Disabling the option discards all queued errors, like Linux does.
+func (so *SocketOptions) SetRecvError(v bool) {
+	storeAtomicBool(&so.recvErrEnabled, v)
+	if !v {
+		so.pruneErrQueue()
+	}
+}
+
+// GetZeroCopy gets value for SO_ZEROCOPY option.
+func (so *SocketOptions) GetZeroCopy() bool {
+	return atomic.LoadUint32(&so.zeroCopyEnabled) != 0
+}
+
+// SetZeroCopy sets value for SO_ZEROCOPY option.
+func (so *SocketOptions) SetZeroCopy(v bool) {
+	storeAtomicBool(&so.zeroCopyEnabled, v)
+}
+
+// GetReusePortFilter gets the filter attached with SO_ATTACH_REUSEPORT_CBPF.
+func (so *SocketOptions) GetReusePortFilter() ReusePortFilter {
+	so.reusePortFilterMu.Lock()
+	defer so.reusePortFilterMu.Unlock()
+	return so.reusePortFilter
+}
+
+// SetReusePortFilter attaches f to the endpoint, or detaches the current filter
+// if f is nil.
+func (so *SocketOptions) SetReusePortFilter(f ReusePortFilter) {
+	so.reusePortFilterMu.Lock()
+	so.reusePortFilter = f
+	so.reusePortFilterMu.Unlock()
+	so.handler.OnReusePortFilterSet(f)
+}
+
+// GetTxTime gets value for SO_TXTIME option.
+func (so *SocketOptions) GetTxTime() TxTimeOption {
+	so.txTimeMu.Lock()
+	defer so.txTimeMu.Unlock()
+	return so.txTime
+}
+
+// SetTxTime sets value for SO_TXTIME option.
+func (so *SocketOptions) SetTxTime(v TxTimeOption) {
+	so.txTimeMu.Lock()
+	so.txTime = v
+	so.txTimeMu.Unlock()
+}
+
+// GetBusyPoll gets value for SO_BUSY_POLL option.
+func (so *SocketOptions) GetBusyPoll() time.Duration {
+	return time.Duration(atomic.LoadInt64(&so.busyPoll))
+}
+
+// SetBusyPoll sets value for SO_BUSY_POLL option.
+func (so *SocketOptions) SetBusyPoll(v time.Duration) {
+	atomic.StoreInt64(&so.busyPoll, int64(v))
+}
+
+// GetMark gets value for SO_MARK option.
+func (so *SocketOptions) GetMark() uint32 {
+	return atomic.LoadUint32(&so.mark)
+}
+
+// SetMark sets value for SO_MARK option.
+func (so *SocketOptions) SetMark(v uint32) {
+	atomic.StoreUint32(&so.mark, v)
+}
+
+// SockErrOrigin represents the constants for error origin.
+type SockErrOrigin uint8
+
+const (
+	// SockExtErrorOriginNone represents an unknown error origin.
+	SockExtErrorOriginNone SockErrOrigin = iota
+
+	// SockExtErrorOriginLocal indicates a local error.
+	SockExtErrorOriginLocal
+
+	// SockExtErrorOriginICMP indicates an IPv4 ICMP error.
+	SockExtErrorOriginICMP
+
+	// SockExtErrorOriginICMP6 indicates an IPv6 ICMP error.
+	SockExtErrorOriginICMP6
+
+	// SockExtErrorOriginZeroCopy indicates a MSG_ZEROCOPY completion
+	// notification.
+	SockExtErrorOriginZeroCopy
+
+	// SockExtErrorOriginTxTime indicates a packet dropped because of its
+	// SO_TXTIME transmit time.
+	SockExtErrorOriginTxTime
+
+	// SockExtErrorOriginTimestamping indicates a SO_TIMESTAMPING transmit
+	// timestamp.
+	SockExtErrorOriginTimestamping
+)
+
+// Codes of SockExtErrorOriginTxTime errors, from uapi/linux/errqueue.h.
+const (
+	// SockExtErrorCodeTxTimeInvalidParam indicates that the transmit time
+	// of the packet was invalid.
+	SockExtErrorCodeTxTimeInvalidParam = 1
+
+	// SockExtErrorCodeTxTimeMissed indicates that the transmit time of the
+	// packet had passed when it was to be transmitted.
+	SockExtErrorCodeTxTimeMissed = 2
+)
+
+// IsICMPErr indicates if the error originated from an ICMP error.
+func (origin SockErrOrigin) IsICMPErr() bool {
+	return origin == SockExtErrorOriginICMP || origin == SockExtErrorOriginICMP6
+}
+
+// maxErrQueueLen is the maximum number of errors held in a socket's error
+// queue. Errors reported while the queue is full are dropped.
+//
+// Linux bounds the error queue by the socket's receive buffer size instead.
+const maxErrQueueLen = 64
+
+// SockError represents a queue entry in the per-socket error queue.
+//
+// +stateify savable
+type SockError struct {
+	sockErrorEntry
+
+	// Err is the error caused by the errant packet. It is nil for zerocopy
+	// completion notifications and transmit timestamps.
+	Err *Error `state:".(string)"`
+
+	// ErrOrigin indicates the error origin.
+	ErrOrigin SockErrOrigin
+
+	// ErrType is the type in the ICMP header, if the error originated from an
+	// ICMP message.
+	ErrType uint8
+
+	// ErrCode is the code in the ICMP header, if the error originated from an
+	// ICMP message.
+	ErrCode uint8
+
+	// ErrInfo is additional information about the error, e.g. the path MTU
+	// when Err is ErrMessageTooLong, the first send covered by a zerocopy
+	// completion notification, or the TimestampType of a transmit
+	// timestamp.
+	ErrInfo uint32
+
+	// ErrData is further information about the error, e.g. the last send
+	// covered by a zerocopy completion notification, or the key of the send
+	// of a transmit timestamp.
+	ErrData uint32
+
+	// Payload is the errant packet's payload, starting at the transport
+	// header.
+	Payload []byte
+
+	// Dst is the original destination address of the errant packet.
+	Dst FullAddress
+
+	// Offender is the original sender of the error.
+	Offender FullAddress
+
+	// NetProto is the network protocol being used to transmit the packet.
+	NetProto NetworkProtocolNumber
+
+	// Timestamp is the time of a transmit timestamp, in nanoseconds since
+	// the Unix epoch.
+	Timestamp int64
+}
+
+// pruneErrQueue resets the queue.
+func (so *SocketOptions) pruneErrQueue() {
+	so.errQueueMu.Lock()
+	so.errQueue.Reset()
+	so.errQueueLen = 0
+	so.errQueueMu.Unlock()
+}
+
+// DequeueErr dequeues a socket extended error from the error queue and returns
+// it. Returns nil if queue is empty.
+func (so *SocketOptions) DequeueErr() *SockError {
+	so.errQueueMu.Lock()
+	defer so.errQueueMu.Unlock()
+
+	err := so.errQueue.Front()
+	if err != nil {
+		so.errQueue.Remove(err)
+		so.errQueueLen--
+	}
+	return err
+}
+
+// PeekErr returns the error in the front of the error queue. Returns nil if
+// the error queue is empty.
+func (so *SocketOptions) PeekErr() *SockError {
+	so.errQueueMu.Lock()
+	defer so.errQueueMu.Unlock()
+	return so.errQueue.Front()
+}
+
+// QueueErr inserts the error at the back of the error queue. Returns false if
+// the error was not queued, either because IP*_RECVERR is not enabled or
+// because the queue is full.
+func (so *SocketOptions) QueueErr(err *SockError) bool {
+	if !so.GetRecvError() {
+		return false
+	}
+
+	so.errQueueMu.Lock()
+	defer so.errQueueMu.Unlock()
+	if so.errQueueLen >= maxErrQueueLen {
+		return false
+	}
+	so.errQueue.PushBack(err)
+	so.errQueueLen++
+	return true
+}
+
+// QueueLocalErr queues a local error onto the error queue.
+func (so *SocketOptions) QueueLocalErr(err *Error, net NetworkProtocolNumber, info uint32, dst FullAddress, payload []byte) bool {
+	return so.QueueErr(&SockError{
+		Err:       err,
+		ErrOrigin: SockExtErrorOriginLocal,
+		ErrInfo:   info,
+		Payload:   payload,
+		Dst:       dst,
+		NetProto:  net,
+	})
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+)
+
+// CaptureDirection is the direction of the packets a PacketCapture receives.
+type CaptureDirection int
+
+const (
+	// CaptureInbound is the direction of the packets received by a NIC.
+	CaptureInbound CaptureDirection = 1 << iota
+
+	// CaptureOutbound is the direction of the packets written by a NIC.
+	CaptureOutbound
+
+	// CaptureBoth is both directions.
+	CaptureBoth = CaptureInbound | CaptureOutbound
+)
+
+// PacketCapture receives copies of the packets going through a NIC, like
+// tcpdump. Unlike packet endpoints, it receives the outbound packets whatever
+// the link endpoint of the NIC.
+type PacketCapture interface {
+	// Capture is called with each packet going through the NIC nicID in
+	// the direction dir, for the network protocol protocol. packet starts
+	// at the network header, and must not be modified or retained.
+	//
+	// The inbound packets are captured after the ingress hook of the NIC
+	// ran, and the outbound packets before they're written to the link
+	// endpoint.
+	Capture(nicID tcpip.NICID, dir CaptureDirection, protocol tcpip.NetworkProtocolNumber, packet buffer.VectorisedView)
+}
+
+// AddPacketCapture starts capturing the packets going through the NIC id with
+// c.
+func (s *Stack) AddPacketCapture(id tcpip.NICID, c PacketCapture) *tcpip.Error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nic, ok := s.nics[id]
+	if !ok {
+		return tcpip.ErrUnknownNICID
+	}
+
+	nic.captureMu.Lock()
+	defer nic.captureMu.Unlock()
+	nic.captureMu.captures = append(nic.captureMu.captures, c)
+	return nil
+}
+
+// RemovePacketCapture stops capturing the packets going through the NIC id
+// with c. Once it returns, c isn't called anymore.
+func (s *Stack) RemovePacketCapture(id tcpip.NICID, c PacketCapture) *tcpip.Error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nic, ok := s.nics[id]
+	if !ok {
+		return tcpip.ErrUnknownNICID
+	}
+
+	nic.captureMu.Lock()
+	defer nic.captureMu.Unlock()
+	captures := nic.captureMu.captures[:0]
+	for _, other := range nic.captureMu.captures {
+		if other != c {
+			captures = append(captures, other)
+		}
+	}
+	nic.captureMu.captures = captures
+	return nil
+}
+
+// capture passes pkt to the packet captures of the NIC. The link header of
+// inbound packets is consumed, and outbound packets don't have one yet.
+func (n *NIC) capture(dir CaptureDirection, protocol tcpip.NetworkProtocolNumber, pkt *PacketBuffer) {
+	n.captureMu.RLock()
+	defer n.captureMu.RUnlock()
+	if len(n.captureMu.captures) == 0 {
+		return
+	}
+	packet := pkt.Data
+	if dir == CaptureOutbound {
+		packet = buffer.NewVectorisedView(pkt.Size(), pkt.Views())
+	}
+	for _, c := range n.captureMu.captures {
+		c.Capture(n.id, dir, protocol, packet)
+	}
+}
@@ -122,6 +122,9 @@ type conn struct {
 	// lastUsed is the last time the connection saw a relevant packet, and
 	// is updated by each packet on the connection. It is protected by mu.
 	lastUsed time.Time `state:".(unixTime)"`
+	// mark is the mark of the connection, as set by CONNMARK rules. It is
+	// protected by mu.
+	mark uint32
 }
 
 // timedOut returns whether the connection timed out based on its state.
@@ -140,6 +143,17 @@ func (cn *conn) timedOut(now time.Time) bool {
 	return now.Sub(cn.lastUsed) > defaultTimeout
 }
 
+// closed returns whether the connection was reset or closed.
+func (cn *conn) closed() bool {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	switch cn.tcb.State() {
+	case tcpconntrack.ResultReset, tcpconntrack.ResultClosedByPeer, tcpconntrack.ResultClosedBySelf:
+		return true
+	}
+	return false
+}
+
 // update the connection tracking state.
 //
 // Precondition: ct.mu must be held.
@@ -292,6 +306,7 @@ func (ct *ConnTrack) insertRedirectConn(pkt *PacketBuffer, hook Hook, port uint1
 		manip = manipDstOutput
 	}
 	conn := newConn(tid, replyTID, manip, hook)
+	conn.mark = pkt.connMark
 	ct.insertConn(conn)
 	return conn
 }
@@ -493,10 +508,36 @@ func (ct *ConnTrack) maybeInsertNoop(pkt *PacketBuffer, hook Hook) {
 		return
 	}
 	conn := newConn(tid, tid.reply(), manipNone, hook)
+	conn.mark = pkt.connMark
 	conn.updateLocked(header.TCP(pkt.TransportHeader().View()), hook)
 	ct.insertConn(conn)
 }
 
+// connMark returns the mark of the connection of pkt. If the connection isn't
+// tracked yet, it is the mark the connection will have once tracked.
+func (ct *ConnTrack) connMark(pkt *PacketBuffer) uint32 {
+	conn, _ := ct.connFor(pkt)
+	if conn == nil {
+		return pkt.connMark
+	}
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.mark
+}
+
+// updateConnMark clears the bits of mask of the mark of the connection of pkt,
+// and then XORs it with value.
+func (ct *ConnTrack) updateConnMark(pkt *PacketBuffer, mask, value uint32) {
+	conn, _ := ct.connFor(pkt)
+	if conn == nil {
+		pkt.connMark = (pkt.connMark &^ mask) ^ value
+		return
+	}
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.mark = (conn.mark &^ mask) ^ value
+}
+
 // bucket gets the conntrack bucket for a tupleID.
 func (ct *ConnTrack) bucket(id tupleID) int {
 	h := jenkins.Sum32(ct.seed)
@@ -0,0 +1,147 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// flowLabelTable holds the IPv6 flow labels leased by endpoints, as per
+// Linux's IPV6_FLOWLABEL_MGR socket option.
+type flowLabelTable struct {
+	mu sync.Mutex
+
+	// labels holds the leased flow labels.
+	//
+	// Protected by mu.
+	labels map[uint32]*flowLabelState
+}
+
+// flowLabelState is the state of a leased flow label.
+type flowLabelState struct {
+	share tcpip.FlowLabelShare
+	owner uint64
+
+	// leases is the number of endpoints that lease the label.
+	leases int
+}
+
+// FlowLabelLeases holds the IPv6 flow labels leased by an endpoint.
+//
+// The zero value holds no labels.
+type FlowLabelLeases struct {
+	labels map[uint32]struct{}
+}
+
+// Has returns true if label is leased.
+func (l *FlowLabelLeases) Has(label uint32) bool {
+	_, ok := l.labels[label]
+	return ok
+}
+
+// ManageFlowLabel leases, renews or releases a flow label on behalf of an
+// endpoint holding leases.
+//
+// Labels are released when the last endpoint leasing them releases them.
+// Linger and expiry times are not supported.
+func (s *Stack) ManageFlowLabel(leases *FlowLabelLeases, opt tcpip.FlowLabelManagerOption) *tcpip.Error {
+	if opt.Label == 0 || opt.Label&^header.IPv6FlowLabelMask != 0 {
+		return tcpip.ErrInvalidOptionValue
+	}
+
+	t := &s.flowLabels
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch opt.Action {
+	case tcpip.FlowLabelLease:
+		switch opt.Share {
+		case tcpip.FlowLabelShareExclusive, tcpip.FlowLabelShareProcess, tcpip.FlowLabelShareUser, tcpip.FlowLabelShareAny:
+		default:
+			return tcpip.ErrInvalidOptionValue
+		}
+		if leases.Has(opt.Label) {
+			if opt.Exclusive {
+				return tcpip.ErrDuplicateAddress
+			}
+			return nil
+		}
+
+		fl, ok := t.labels[opt.Label]
+		switch {
+		case !ok && !opt.Create:
+			return tcpip.ErrNoSuchFile
+		case !ok:
+			fl = &flowLabelState{share: opt.Share, owner: opt.Owner}
+			if t.labels == nil {
+				t.labels = make(map[uint32]*flowLabelState)
+			}
+			t.labels[opt.Label] = fl
+		case opt.Exclusive:
+			return tcpip.ErrDuplicateAddress
+		case fl.share == tcpip.FlowLabelShareExclusive, fl.share != opt.Share, fl.share != tcpip.FlowLabelShareAny && fl.owner != opt.Owner:
+			return tcpip.ErrNotPermitted
+		}
+
+		fl.leases++
+		if leases.labels == nil {
+			leases.labels = make(map[uint32]struct{})
+		}
+		leases.labels[opt.Label] = struct{}{}
+		return nil
+
+	case tcpip.FlowLabelRelease:
+		if !leases.Has(opt.Label) {
+			return tcpip.ErrNoSuchFile
+		}
+		delete(leases.labels, opt.Label)
+		t.releaseLocked(opt.Label)
+		return nil
+
+	case tcpip.FlowLabelRenew:
+		if !leases.Has(opt.Label) {
+			return tcpip.ErrNoSuchFile
+		}
+		return nil
+
+	default:
+		return tcpip.ErrInvalidOptionValue
+	}
+}
+
+// ReleaseFlowLabels releases all flow labels held by leases.
+func (s *Stack) ReleaseFlowLabels(leases *FlowLabelLeases) {
+	t := &s.flowLabels
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for label := range leases.labels {
+		t.releaseLocked(label)
+	}
+	leases.labels = nil
+}
+
+// releaseLocked releases a lease of label.
+//
+// Precondition: t.mu must be locked.
+func (t *flowLabelTable) releaseLocked(label uint32) {
+	fl := t.labels[label]
+	fl.leases--
+	if fl.leases == 0 {
+		delete(t.labels, label)
+	}
+}
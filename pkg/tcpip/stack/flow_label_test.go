@@ -0,0 +1,144 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack_test
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+func TestManageFlowLabel(t *testing.T) {
+	const label = 0x12345
+
+	lease := func(share tcpip.FlowLabelShare, owner uint64) tcpip.FlowLabelManagerOption {
+		return tcpip.FlowLabelManagerOption{
+			Label:  label,
+			Action: tcpip.FlowLabelLease,
+			Share:  share,
+			Owner:  owner,
+		}
+	}
+	create := func(share tcpip.FlowLabelShare, owner uint64) tcpip.FlowLabelManagerOption {
+		opt := lease(share, owner)
+		opt.Create = true
+		return opt
+	}
+
+	tests := []struct {
+		name    string
+		first   tcpip.FlowLabelManagerOption
+		second  tcpip.FlowLabelManagerOption
+		wantErr *tcpip.Error
+	}{
+		{
+			name:    "Exclusive",
+			first:   create(tcpip.FlowLabelShareExclusive, 0),
+			second:  lease(tcpip.FlowLabelShareExclusive, 0),
+			wantErr: tcpip.ErrNotPermitted,
+		},
+		{
+			name:   "Any",
+			first:  create(tcpip.FlowLabelShareAny, 1),
+			second: lease(tcpip.FlowLabelShareAny, 2),
+		},
+		{
+			name:    "Different share",
+			first:   create(tcpip.FlowLabelShareAny, 1),
+			second:  lease(tcpip.FlowLabelShareProcess, 1),
+			wantErr: tcpip.ErrNotPermitted,
+		},
+		{
+			name:   "Same process",
+			first:  create(tcpip.FlowLabelShareProcess, 1),
+			second: lease(tcpip.FlowLabelShareProcess, 1),
+		},
+		{
+			name:    "Different process",
+			first:   create(tcpip.FlowLabelShareProcess, 1),
+			second:  lease(tcpip.FlowLabelShareProcess, 2),
+			wantErr: tcpip.ErrNotPermitted,
+		},
+		{
+			name:    "Different user",
+			first:   create(tcpip.FlowLabelShareUser, 1),
+			second:  lease(tcpip.FlowLabelShareUser, 2),
+			wantErr: tcpip.ErrNotPermitted,
+		},
+		{
+			name:  "Exclusive create",
+			first: create(tcpip.FlowLabelShareAny, 0),
+			second: func() tcpip.FlowLabelManagerOption {
+				opt := create(tcpip.FlowLabelShareAny, 0)
+				opt.Exclusive = true
+				return opt
+			}(),
+			wantErr: tcpip.ErrDuplicateAddress,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := stack.New(stack.Options{})
+			var leases1, leases2 stack.FlowLabelLeases
+
+			if err := s.ManageFlowLabel(&leases1, test.first); err != nil {
+				t.Fatalf("s.ManageFlowLabel(_, %+v): %s", test.first, err)
+			}
+			if err := s.ManageFlowLabel(&leases2, test.second); err != test.wantErr {
+				t.Fatalf("got s.ManageFlowLabel(_, %+v) = %s, want = %s", test.second, err, test.wantErr)
+			}
+			if got, want := leases2.Has(label), test.wantErr == nil; got != want {
+				t.Errorf("got leases2.Has(%#x) = %t, want = %t", label, got, want)
+			}
+
+			// The label is removed once it is no longer leased.
+			s.ReleaseFlowLabels(&leases1)
+			s.ReleaseFlowLabels(&leases2)
+			if err := s.ManageFlowLabel(&leases1, lease(tcpip.FlowLabelShareAny, 0)); err != tcpip.ErrNoSuchFile {
+				t.Errorf("got s.ManageFlowLabel(_, _) = %s, want = %s", err, tcpip.ErrNoSuchFile)
+			}
+		})
+	}
+}
+
+func TestReleaseFlowLabel(t *testing.T) {
+	const label = 1
+
+	s := stack.New(stack.Options{})
+	var leases stack.FlowLabelLeases
+	opt := tcpip.FlowLabelManagerOption{
+		Label:  label,
+		Action: tcpip.FlowLabelRelease,
+	}
+	if err := s.ManageFlowLabel(&leases, opt); err != tcpip.ErrNoSuchFile {
+		t.Fatalf("got s.ManageFlowLabel(_, %+v) = %s, want = %s", opt, err, tcpip.ErrNoSuchFile)
+	}
+
+	opt.Action = tcpip.FlowLabelLease
+	opt.Share = tcpip.FlowLabelShareExclusive
+	opt.Create = true
+	if err := s.ManageFlowLabel(&leases, opt); err != nil {
+		t.Fatalf("s.ManageFlowLabel(_, %+v): %s", opt, err)
+	}
+	opt.Action = tcpip.FlowLabelRelease
+	if err := s.ManageFlowLabel(&leases, opt); err != nil {
+		t.Fatalf("s.ManageFlowLabel(_, %+v): %s", opt, err)
+	}
+	if leases.Has(label) {
+		t.Errorf("got leases.Has(%d) = true, want = false", label)
+	}
+}
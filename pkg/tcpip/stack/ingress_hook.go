@@ -0,0 +1,115 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// IngressAction is the action taken by an ingress hook on a packet.
+type IngressAction int
+
+const (
+	// IngressPass lets the NIC process the packet.
+	IngressPass IngressAction = iota
+
+	// IngressDrop drops the packet.
+	IngressDrop
+
+	// IngressRedirect delivers the packet to another NIC, as if it had
+	// received it. The hook of that NIC isn't run on the packet.
+	IngressRedirect
+)
+
+// IngressHook is attached to a NIC to filter the packets it receives, like
+// XDP programs in Linux. It is run before any processing of the packets,
+// including VLAN demultiplexing and the delivery to packet sockets.
+type IngressHook interface {
+	// Process returns the action to take on pkt, received by the NIC nicID
+	// for the network protocol protocol. The NIC to redirect pkt to is
+	// returned with IngressRedirect.
+	//
+	// pkt's link header is consumed, and its network header isn't parsed
+	// yet. pkt must not be modified or retained.
+	Process(nicID tcpip.NICID, protocol tcpip.NetworkProtocolNumber, pkt *PacketBuffer) (IngressAction, tcpip.NICID)
+}
+
+// IngressHookFunc is an adapter to use a function as an IngressHook.
+type IngressHookFunc func(nicID tcpip.NICID, protocol tcpip.NetworkProtocolNumber, pkt *PacketBuffer) (IngressAction, tcpip.NICID)
+
+// Process implements IngressHook.Process.
+func (f IngressHookFunc) Process(nicID tcpip.NICID, protocol tcpip.NetworkProtocolNumber, pkt *PacketBuffer) (IngressAction, tcpip.NICID) {
+	return f(nicID, protocol, pkt)
+}
+
+// IngressHookStats are the counters of the actions taken by the ingress hook
+// of a NIC.
+type IngressHookStats struct {
+	// Passed is the number of packets the hook let the NIC process.
+	Passed *tcpip.StatCounter
+
+	// Dropped is the number of packets the hook dropped.
+	Dropped *tcpip.StatCounter
+
+	// Redirected is the number of packets the hook redirected to another
+	// NIC.
+	Redirected *tcpip.StatCounter
+
+	// InvalidRedirects is the number of packets the hook redirected to a
+	// NIC which doesn't exist, which are dropped.
+	InvalidRedirects *tcpip.StatCounter
+}
+
+// SetIngressHook attaches hook to the NIC id, replacing its previous hook, if
+// any. A nil hook detaches the hook of the NIC.
+func (s *Stack) SetIngressHook(id tcpip.NICID, hook IngressHook) *tcpip.Error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nic, ok := s.nics[id]
+	if !ok {
+		return tcpip.ErrUnknownNICID
+	}
+
+	nic.mu.Lock()
+	nic.mu.ingressHook = hook
+	nic.mu.Unlock()
+	return nil
+}
+
+// runIngressHook runs hook on pkt, and returns whether the NIC must go on
+// processing pkt.
+func (n *NIC) runIngressHook(hook IngressHook, remote, local tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, pkt *PacketBuffer) bool {
+	action, id := hook.Process(n.id, protocol, pkt)
+	switch action {
+	case IngressPass:
+		n.stats.IngressHook.Passed.Increment()
+		return true
+	case IngressRedirect:
+		n.stack.mu.RLock()
+		dst, ok := n.stack.nics[id]
+		n.stack.mu.RUnlock()
+		if !ok {
+			n.stats.IngressHook.InvalidRedirects.Increment()
+			return false
+		}
+		n.stats.IngressHook.Redirected.Increment()
+		dst.deliverNetworkPacket(remote, local, protocol, pkt, false /* runHook */)
+		return false
+	default:
+		n.stats.IngressHook.Dropped.Increment()
+		return false
+	}
+}
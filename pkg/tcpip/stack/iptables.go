@@ -478,7 +478,11 @@ func (it *IPTables) checkRule(hook Hook, pkt *PacketBuffer, table Table, ruleIdx
 	}
 
 	// All the matchers matched, so run the target.
-	return rule.Target.Action(pkt, &it.connections, hook, gso, r, preroutingAddr)
+	verdict, jumpTo := rule.Target.Action(pkt, &it.connections, hook, gso, r, preroutingAddr)
+	if verdict == RuleContinue {
+		return RuleJump, ruleIdx + 1
+	}
+	return verdict, jumpTo
 }
 
 // OriginalDst returns the original destination of redirected connections. It
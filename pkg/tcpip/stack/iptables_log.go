@@ -0,0 +1,273 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+)
+
+// Names of the logging targets, as used by iptables.
+const (
+	LogTargetName   = "LOG"
+	NFLogTargetName = "NFLOG"
+)
+
+// MaxLogPrefixLen and MaxNFLogPrefixLen are the maximum lengths of the
+// prefixes of LogTargets and NFLogTargets, as in Linux.
+const (
+	MaxLogPrefixLen   = 29
+	MaxNFLogPrefixLen = 63
+)
+
+// DefaultLogLevel is the syslog level of LogTargets when none is given, as in
+// Linux.
+const DefaultLogLevel = 4
+
+// maxLogLevel is the highest, least important, syslog level.
+const maxLogLevel = 7
+
+// PacketLog is a packet logged by a LogTarget or an NFLogTarget.
+type PacketLog struct {
+	// Hook is the hook at which the packet was logged.
+	Hook Hook
+
+	// NetworkProtocol is the network protocol of the packet.
+	NetworkProtocol tcpip.NetworkProtocolNumber
+
+	// Time is the time at which the packet was logged.
+	Time time.Time
+
+	// InputNICID and InputInterface are the ID and the name of the NIC the
+	// packet was received at. They are only set for received packets.
+	InputNICID     tcpip.NICID
+	InputInterface string
+
+	// OutputNICID and OutputInterface are the ID and the name of the NIC
+	// the packet is sent through. They are only set for sent packets.
+	OutputNICID     tcpip.NICID
+	OutputInterface string
+
+	// Owner is the owner of the packet, for locally generated packets.
+	Owner tcpip.PacketOwner
+
+	// Mark is the mark of the packet.
+	Mark uint32
+
+	// LinkHeader is the link header of the packet, for received packets.
+	LinkHeader buffer.View
+
+	// Packet holds the packet, starting from its network header. It may be
+	// truncated.
+	Packet buffer.View
+}
+
+// PacketLogger is the backend of LogTargets, which receives the packets they
+// log.
+type PacketLogger interface {
+	// LogPacket logs a packet matched by a LogTarget configured with opts.
+	//
+	// It is called while the iptables are checked, and must not block.
+	LogPacket(opts LogOptions, log *PacketLog)
+}
+
+// NFLogListener receives the packets logged by NFLogTargets to a group it is
+// bound to, like the nfnetlink_log instances of Linux.
+type NFLogListener interface {
+	// NFLogPacket receives a packet matched by an NFLogTarget configured
+	// with opts.
+	//
+	// It is called while the iptables are checked, and must not block.
+	NFLogPacket(opts NFLogOptions, log *PacketLog)
+}
+
+// BindNFLogGroup binds l to the NFLOG group, so that l receives the packets
+// logged to the group. Only one listener may be bound to a group.
+func (s *Stack) BindNFLogGroup(group uint16, l NFLogListener) *tcpip.Error {
+	s.nflogMu.Lock()
+	defer s.nflogMu.Unlock()
+	if _, ok := s.nflogListeners[group]; ok {
+		return tcpip.ErrPortInUse
+	}
+	if s.nflogListeners == nil {
+		s.nflogListeners = make(map[uint16]NFLogListener)
+	}
+	s.nflogListeners[group] = l
+	return nil
+}
+
+// UnbindNFLogGroup unbinds l from the NFLOG group, if it is bound to it.
+func (s *Stack) UnbindNFLogGroup(group uint16, l NFLogListener) {
+	s.nflogMu.Lock()
+	defer s.nflogMu.Unlock()
+	if s.nflogListeners[group] == l {
+		delete(s.nflogListeners, group)
+	}
+}
+
+// nflogListener returns the listener bound to the NFLOG group, if any.
+func (s *Stack) nflogListener(group uint16) NFLogListener {
+	s.nflogMu.RLock()
+	defer s.nflogMu.RUnlock()
+	return s.nflogListeners[group]
+}
+
+// newPacketLog returns the log of pkt at hook.
+func (s *Stack) newPacketLog(pkt *PacketBuffer, hook Hook, r *Route) *PacketLog {
+	log := &PacketLog{
+		Hook:            hook,
+		NetworkProtocol: pkt.NetworkProtocolNumber,
+		Time:            time.Unix(0, s.clock.NowNanoseconds()),
+		Owner:           pkt.Owner,
+		Mark:            pkt.Mark,
+		Packet:          PayloadSince(pkt.NetworkHeader()),
+	}
+	switch hook {
+	case Prerouting, Input, Forward:
+		log.InputNICID = pkt.NICID
+		log.InputInterface = s.FindNICNameFromID(pkt.NICID)
+		log.LinkHeader = append(buffer.View(nil), pkt.LinkHeader().View()...)
+	}
+	switch hook {
+	case Forward, Output, Postrouting:
+		if r != nil {
+			log.OutputNICID = r.NICID()
+			log.OutputInterface = s.FindNICNameFromID(log.OutputNICID)
+		}
+	}
+	return log
+}
+
+// LogOptions holds the configuration of a LogTarget.
+type LogOptions struct {
+	// Level is the syslog level the packets are logged at.
+	Level uint8
+
+	// Prefix is prepended to the logs.
+	Prefix string
+
+	// TCPSequence logs the sequence numbers of TCP segments.
+	TCPSequence bool
+
+	// TCPOptions logs the options of TCP segments.
+	TCPOptions bool
+
+	// IPOptions logs the options of IPv4 packets and the extension headers
+	// of IPv6 packets.
+	IPOptions bool
+
+	// UID logs the owner of locally generated packets.
+	UID bool
+
+	// MACDecode decodes the link headers of received packets.
+	MACDecode bool
+}
+
+// LogTarget logs packets through the PacketLogger of a stack and lets them
+// continue on to the next rule, like Linux's LOG target.
+type LogTarget struct {
+	opts  LogOptions
+	stack *Stack
+
+	// NetworkProtocol is the network protocol the target is used with.
+	NetworkProtocol tcpip.NetworkProtocolNumber
+}
+
+// NewLogTarget returns a LogTarget logging packets through the PacketLogger of
+// s.
+func NewLogTarget(s *Stack, netProto tcpip.NetworkProtocolNumber, opts LogOptions) (*LogTarget, *tcpip.Error) {
+	if opts.Level > maxLogLevel || len(opts.Prefix) > MaxLogPrefixLen {
+		return nil, tcpip.ErrInvalidOptionValue
+	}
+	return &LogTarget{
+		opts:            opts,
+		stack:           s,
+		NetworkProtocol: netProto,
+	}, nil
+}
+
+// Options returns the configuration of the target.
+func (lt *LogTarget) Options() LogOptions {
+	return lt.opts
+}
+
+// Action implements Target.Action.
+func (lt *LogTarget) Action(pkt *PacketBuffer, _ *ConnTrack, hook Hook, _ *GSO, r *Route, _ tcpip.Address) (RuleVerdict, int) {
+	if l := lt.stack.packetLogger; l != nil {
+		l.LogPacket(lt.opts, lt.stack.newPacketLog(pkt, hook, r))
+	}
+	return RuleContinue, 0
+}
+
+// NFLogOptions holds the configuration of an NFLogTarget.
+type NFLogOptions struct {
+	// Group is the group the packets are logged to.
+	Group uint16
+
+	// Prefix is sent along with the packets.
+	Prefix string
+
+	// HasCopyLen truncates the packets to CopyLen bytes.
+	HasCopyLen bool
+	CopyLen    uint32
+
+	// Threshold is the number of packets to queue before sending them to
+	// the listener. It is only kept for the netfilter ABI: packets are
+	// always sent right away.
+	Threshold uint16
+}
+
+// NFLogTarget logs packets to the listener of a group and lets them continue
+// on to the next rule, like Linux's NFLOG target. Packets are dropped silently
+// if no listener is bound to the group.
+type NFLogTarget struct {
+	opts  NFLogOptions
+	stack *Stack
+
+	// NetworkProtocol is the network protocol the target is used with.
+	NetworkProtocol tcpip.NetworkProtocolNumber
+}
+
+// NewNFLogTarget returns an NFLogTarget logging packets to the listeners
+// bound to the groups of s.
+func NewNFLogTarget(s *Stack, netProto tcpip.NetworkProtocolNumber, opts NFLogOptions) (*NFLogTarget, *tcpip.Error) {
+	if len(opts.Prefix) > MaxNFLogPrefixLen {
+		return nil, tcpip.ErrInvalidOptionValue
+	}
+	return &NFLogTarget{
+		opts:            opts,
+		stack:           s,
+		NetworkProtocol: netProto,
+	}, nil
+}
+
+// Options returns the configuration of the target.
+func (nt *NFLogTarget) Options() NFLogOptions {
+	return nt.opts
+}
+
+// Action implements Target.Action.
+func (nt *NFLogTarget) Action(pkt *PacketBuffer, _ *ConnTrack, hook Hook, _ *GSO, r *Route, _ tcpip.Address) (RuleVerdict, int) {
+	if l := nt.stack.nflogListener(nt.opts.Group); l != nil {
+		log := nt.stack.newPacketLog(pkt, hook, r)
+		if nt.opts.HasCopyLen && uint32(len(log.Packet)) > nt.opts.CopyLen {
+			log.Packet = log.Packet[:nt.opts.CopyLen]
+		}
+		l.NFLogPacket(nt.opts, log)
+	}
+	return RuleContinue, 0
+}
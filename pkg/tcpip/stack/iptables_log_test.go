@@ -0,0 +1,177 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"bytes"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+type testPacketLogger struct {
+	opts []LogOptions
+	logs []*PacketLog
+}
+
+// LogPacket implements PacketLogger.
+func (l *testPacketLogger) LogPacket(opts LogOptions, log *PacketLog) {
+	l.opts = append(l.opts, opts)
+	l.logs = append(l.logs, log)
+}
+
+type testNFLogListener struct {
+	opts []NFLogOptions
+	logs []*PacketLog
+}
+
+// NFLogPacket implements NFLogListener.
+func (l *testNFLogListener) NFLogPacket(opts NFLogOptions, log *PacketLog) {
+	l.opts = append(l.opts, opts)
+	l.logs = append(l.logs, log)
+}
+
+func TestLogTargets(t *testing.T) {
+	const nicID = 1
+	const nicName = "eth0"
+
+	var logger testPacketLogger
+	s := New(Options{
+		Clock:        faketime.NewManualClock(),
+		PacketLogger: &logger,
+	})
+	if err := s.CreateNICWithOptions(nicID, &fwdTestLinkEndpoint{mtu: 1500}, NICOptions{Name: nicName}); err != nil {
+		t.Fatalf("s.CreateNICWithOptions(%d, _, _): %s", nicID, err)
+	}
+
+	logOpts := LogOptions{
+		Level:       DefaultLogLevel,
+		Prefix:      "log: ",
+		TCPSequence: true,
+	}
+	lt, err := NewLogTarget(s, header.IPv4ProtocolNumber, logOpts)
+	if err != nil {
+		t.Fatalf("NewLogTarget(_, _, %+v): %s", logOpts, err)
+	}
+	nflogOpts := NFLogOptions{
+		Group:      5,
+		Prefix:     "nflog: ",
+		HasCopyLen: true,
+		CopyLen:    header.IPv4MinimumSize,
+	}
+	nt, err := NewNFLogTarget(s, header.IPv4ProtocolNumber, nflogOpts)
+	if err != nil {
+		t.Fatalf("NewNFLogTarget(_, _, %+v): %s", nflogOpts, err)
+	}
+	if _, err := NewLogTarget(s, header.IPv4ProtocolNumber, LogOptions{Level: maxLogLevel + 1}); err != tcpip.ErrInvalidOptionValue {
+		t.Errorf("got NewLogTarget(_, _, {Level: %d}) = %s, want = %s", maxLogLevel+1, err, tcpip.ErrInvalidOptionValue)
+	}
+
+	// Log the packets checked at the Input hook and drop them.
+	it := s.IPTables()
+	table := Table{
+		Rules: []Rule{
+			{Target: lt},
+			{Target: nt},
+			{Target: &DropTarget{NetworkProtocol: header.IPv4ProtocolNumber}},
+			{Target: &AcceptTarget{NetworkProtocol: header.IPv4ProtocolNumber}},
+			{Target: &ErrorTarget{NetworkProtocol: header.IPv4ProtocolNumber}},
+		},
+		BuiltinChains: [NumHooks]int{
+			Prerouting:  HookUnset,
+			Input:       0,
+			Forward:     3,
+			Output:      3,
+			Postrouting: HookUnset,
+		},
+		Underflows: [NumHooks]int{
+			Prerouting:  HookUnset,
+			Input:       3,
+			Forward:     3,
+			Output:      3,
+			Postrouting: HookUnset,
+		},
+	}
+	if err := it.ReplaceTable(FilterID, table, false /* ipv6 */); err != nil {
+		t.Fatalf("it.ReplaceTable(FilterID, _, false): %s", err)
+	}
+
+	check := func() *PacketBuffer {
+		t.Helper()
+		pkt := newMatcherTestPacket(matcherTestAddr1, 1000, 64, header.TCPFlagSyn)
+		pkt.NICID = nicID
+		if it.Check(Input, pkt, nil, nil, "", "") {
+			t.Fatal("got it.Check(Input, _, nil, nil, \"\", \"\") = true, want = false")
+		}
+		return pkt
+	}
+
+	// NFLOG rules log nothing when no listener is bound to their group.
+	pkt := check()
+	if got := len(logger.logs); got != 1 {
+		t.Fatalf("got len(logger.logs) = %d, want = 1", got)
+	}
+	if got := logger.opts[0]; got != logOpts {
+		t.Errorf("got logger.opts[0] = %+v, want = %+v", got, logOpts)
+	}
+	log := logger.logs[0]
+	if log.Hook != Input || log.NetworkProtocol != header.IPv4ProtocolNumber {
+		t.Errorf("got (log.Hook, log.NetworkProtocol) = (%d, %d), want = (%d, %d)", log.Hook, log.NetworkProtocol, Input, header.IPv4ProtocolNumber)
+	}
+	if log.InputNICID != nicID || log.InputInterface != nicName || log.OutputNICID != 0 || log.OutputInterface != "" {
+		t.Errorf("got interfaces (%d, %q, %d, %q), want = (%d, %q, 0, \"\")", log.InputNICID, log.InputInterface, log.OutputNICID, log.OutputInterface, nicID, nicName)
+	}
+	if want := PayloadSince(pkt.NetworkHeader()); !bytes.Equal(log.Packet, want) {
+		t.Errorf("got log.Packet = %x, want = %x", log.Packet, want)
+	}
+
+	var listener, other testNFLogListener
+	if err := s.BindNFLogGroup(nflogOpts.Group, &listener); err != nil {
+		t.Fatalf("s.BindNFLogGroup(%d, _): %s", nflogOpts.Group, err)
+	}
+	if err := s.BindNFLogGroup(nflogOpts.Group, &other); err != tcpip.ErrPortInUse {
+		t.Errorf("got s.BindNFLogGroup(%d, _) = %s, want = %s", nflogOpts.Group, err, tcpip.ErrPortInUse)
+	}
+	pkt = check()
+	if got := len(logger.logs); got != 2 {
+		t.Errorf("got len(logger.logs) = %d, want = 2", got)
+	}
+	if got := len(listener.logs); got != 1 {
+		t.Fatalf("got len(listener.logs) = %d, want = 1", got)
+	}
+	if got := listener.opts[0]; got != nflogOpts {
+		t.Errorf("got listener.opts[0] = %+v, want = %+v", got, nflogOpts)
+	}
+	// The packets are truncated to the copy length.
+	if want := PayloadSince(pkt.NetworkHeader())[:nflogOpts.CopyLen]; !bytes.Equal(listener.logs[0].Packet, want) {
+		t.Errorf("got listener.logs[0].Packet = %x, want = %x", listener.logs[0].Packet, want)
+	}
+
+	// Other listeners may bind to the group once it is unbound.
+	s.UnbindNFLogGroup(nflogOpts.Group, &other)
+	s.UnbindNFLogGroup(nflogOpts.Group, &listener)
+	if err := s.BindNFLogGroup(nflogOpts.Group, &other); err != nil {
+		t.Fatalf("s.BindNFLogGroup(%d, _): %s", nflogOpts.Group, err)
+	}
+	check()
+	if got := len(listener.logs); got != 1 {
+		t.Errorf("got len(listener.logs) = %d, want = 1", got)
+	}
+	if got := len(other.logs); got != 1 {
+		t.Errorf("got len(other.logs) = %d, want = 1", got)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// Names of the targets and matchers of packet and connection marks, as used by
+// iptables.
+const (
+	MarkTargetName      = "MARK"
+	ConnMarkTargetName  = "CONNMARK"
+	MarkMatcherName     = "mark"
+	ConnMarkMatcherName = "connmark"
+)
+
+// MarkTarget sets the mark of packets, like Linux's MARK target. The bits of
+// Mask are cleared from the mark, which is then XORed with Mark. It doesn't
+// stop the traversal of the chain.
+type MarkTarget struct {
+	// Mark and Mask are immutable.
+	Mark uint32
+	Mask uint32
+
+	// NetworkProtocol is the network protocol the target is used with. It
+	// is immutable.
+	NetworkProtocol tcpip.NetworkProtocolNumber
+}
+
+// Action implements Target.Action.
+func (mt *MarkTarget) Action(pkt *PacketBuffer, _ *ConnTrack, _ Hook, _ *GSO, _ *Route, _ tcpip.Address) (RuleVerdict, int) {
+	pkt.Mark = (pkt.Mark &^ mt.Mask) ^ mt.Mark
+	return RuleContinue, 0
+}
+
+// ConnMarkMode is the operation of a ConnMarkTarget.
+type ConnMarkMode int
+
+const (
+	// ConnMarkSet clears the bits of CTMask from the mark of the
+	// connection, and then XORs it with CTMark.
+	ConnMarkSet ConnMarkMode = iota
+
+	// ConnMarkSave clears the bits of CTMask from the mark of the
+	// connection, and then XORs it with the bits of NFMask of the mark of
+	// the packet.
+	ConnMarkSave
+
+	// ConnMarkRestore clears the bits of NFMask from the mark of the
+	// packet, and then XORs it with the bits of CTMask of the mark of the
+	// connection.
+	ConnMarkRestore
+)
+
+// ConnMarkTarget sets the mark of connections or restores the mark of their
+// packets from it, like Linux's CONNMARK target. It doesn't stop the traversal
+// of the chain.
+//
+// As only TCP connections are tracked, the marks of the connections of other
+// packets are only kept while the packet traverses the tables.
+type ConnMarkTarget struct {
+	// Mode, CTMark, CTMask and NFMask are immutable.
+	Mode   ConnMarkMode
+	CTMark uint32
+	CTMask uint32
+	NFMask uint32
+
+	// NetworkProtocol is the network protocol the target is used with. It
+	// is immutable.
+	NetworkProtocol tcpip.NetworkProtocolNumber
+}
+
+// Action implements Target.Action.
+func (ct *ConnMarkTarget) Action(pkt *PacketBuffer, connections *ConnTrack, _ Hook, _ *GSO, _ *Route, _ tcpip.Address) (RuleVerdict, int) {
+	switch ct.Mode {
+	case ConnMarkSet:
+		connections.updateConnMark(pkt, ct.CTMask, ct.CTMark)
+	case ConnMarkSave:
+		connections.updateConnMark(pkt, ct.CTMask, pkt.Mark&ct.NFMask)
+	case ConnMarkRestore:
+		pkt.Mark = (pkt.Mark &^ ct.NFMask) ^ (connections.connMark(pkt) & ct.CTMask)
+	}
+	return RuleContinue, 0
+}
+
+// MarkMatcher matches the packets whose mark is Mark once masked with Mask,
+// like Linux's mark match. It implements Matcher.
+type MarkMatcher struct {
+	// Mark and Mask are immutable.
+	Mark uint32
+	Mask uint32
+
+	// Invert makes the packets whose masked mark is not Mark match. It is
+	// immutable.
+	Invert bool
+}
+
+// Name implements Matcher.Name.
+func (*MarkMatcher) Name() string {
+	return MarkMatcherName
+}
+
+// Match implements Matcher.Match.
+func (mm *MarkMatcher) Match(_ Hook, pkt *PacketBuffer, _ string) (bool, bool) {
+	return (pkt.Mark&mm.Mask == mm.Mark) != mm.Invert, false
+}
+
+// ConnMarkOptions holds the configuration of a ConnMarkMatcher.
+type ConnMarkOptions struct {
+	// Mark and Mask are the mark of the connections which match once
+	// masked, and the mask.
+	Mark uint32
+	Mask uint32
+
+	// Invert makes the packets of the connections whose masked mark is not
+	// Mark match.
+	Invert bool
+}
+
+// ConnMarkMatcher matches the packets of the connections whose mark is a
+// given mark once masked, like Linux's connmark match. It implements Matcher.
+type ConnMarkMatcher struct {
+	opts ConnMarkOptions
+	ct   *ConnTrack
+}
+
+// NewConnMarkMatcher returns a ConnMarkMatcher matching the connections
+// tracked by the iptables of s.
+func NewConnMarkMatcher(s *Stack, opts ConnMarkOptions) *ConnMarkMatcher {
+	return &ConnMarkMatcher{
+		opts: opts,
+		ct:   &s.IPTables().connections,
+	}
+}
+
+// Options returns the configuration of m.
+func (m *ConnMarkMatcher) Options() ConnMarkOptions {
+	return m.opts
+}
+
+// Name implements Matcher.Name.
+func (*ConnMarkMatcher) Name() string {
+	return ConnMarkMatcherName
+}
+
+// Match implements Matcher.Match.
+func (m *ConnMarkMatcher) Match(_ Hook, pkt *PacketBuffer, _ string) (bool, bool) {
+	return (m.ct.connMark(pkt)&m.opts.Mask == m.opts.Mark) != m.opts.Invert, false
+}
@@ -0,0 +1,109 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func TestMarkTargets(t *testing.T) {
+	s := New(Options{Clock: faketime.NewManualClock()})
+
+	// Restore the mark of the packets from the mark of their connection. The
+	// packets without mark are marked and their mark is saved to their
+	// connection, like the rules used to route all the packets of a
+	// connection alike.
+	it := s.IPTables()
+	table := Table{
+		Rules: []Rule{
+			{Target: &ConnMarkTarget{Mode: ConnMarkRestore, CTMask: 0xff, NFMask: 0xff, NetworkProtocol: header.IPv4ProtocolNumber}},
+			{
+				Matchers: []Matcher{&MarkMatcher{Mask: 0xff, Invert: true}},
+				Target:   &AcceptTarget{NetworkProtocol: header.IPv4ProtocolNumber},
+			},
+			{Target: &MarkTarget{Mark: 1, Mask: 0xff, NetworkProtocol: header.IPv4ProtocolNumber}},
+			{Target: &ConnMarkTarget{Mode: ConnMarkSave, CTMask: 0xff, NFMask: 0xff, NetworkProtocol: header.IPv4ProtocolNumber}},
+			{Target: &AcceptTarget{NetworkProtocol: header.IPv4ProtocolNumber}},
+			{Target: &ErrorTarget{NetworkProtocol: header.IPv4ProtocolNumber}},
+		},
+		BuiltinChains: [NumHooks]int{
+			Prerouting:  0,
+			Input:       HookUnset,
+			Forward:     HookUnset,
+			Output:      4,
+			Postrouting: HookUnset,
+		},
+		Underflows: [NumHooks]int{
+			Prerouting:  4,
+			Input:       HookUnset,
+			Forward:     HookUnset,
+			Output:      4,
+			Postrouting: HookUnset,
+		},
+	}
+	if err := it.ReplaceTable(MangleID, table, false /* ipv6 */); err != nil {
+		t.Fatalf("it.ReplaceTable(MangleID, _, false): %s", err)
+	}
+
+	check := func(pkt *PacketBuffer, wantMark uint32) {
+		t.Helper()
+		if !it.Check(Prerouting, pkt, nil, nil, "", "") {
+			t.Fatal("got it.Check(Prerouting, _, nil, nil, \"\", \"\") = false, want = true")
+		}
+		if pkt.Mark != wantMark {
+			t.Errorf("got pkt.Mark = %#x, want = %#x", pkt.Mark, wantMark)
+		}
+	}
+
+	// The bits of the mark outside the masks are kept.
+	syn := newMatcherTestPacket(matcherTestAddr1, 1000, 64, header.TCPFlagSyn)
+	syn.Mark = 0x100
+	check(syn, 0x101)
+	if got := it.connections.connMark(newMatcherTestPacket(matcherTestAddr1, 1000, 64, header.TCPFlagAck)); got != 1 {
+		t.Errorf("got the mark of the connection = %#x, want = 1", got)
+	}
+
+	// The later packets of the connection get the mark of the connection.
+	check(newMatcherTestPacket(matcherTestAddr1, 1000, 64, header.TCPFlagAck), 1)
+
+	m := NewConnMarkMatcher(s, ConnMarkOptions{Mark: 1, Mask: 0xff})
+	for _, test := range []struct {
+		srcPort uint16
+		want    bool
+	}{
+		{srcPort: 1000, want: true},
+		{srcPort: 1001, want: false},
+	} {
+		if got, hotdrop := m.Match(Prerouting, newMatcherTestPacket(matcherTestAddr1, test.srcPort, 64, header.TCPFlagAck), ""); got != test.want || hotdrop {
+			t.Errorf("got m.Match(Prerouting, packet from port %d, _) = (%t, %t), want = (%t, false)", test.srcPort, got, hotdrop, test.want)
+		}
+	}
+
+	// The marks of connections can be set directly.
+	set := ConnMarkTarget{Mode: ConnMarkSet, CTMark: 0x20, CTMask: 0xf0, NetworkProtocol: header.IPv4ProtocolNumber}
+	ack := newMatcherTestPacket(matcherTestAddr1, 1000, 64, header.TCPFlagAck)
+	if v, _ := set.Action(ack, &it.connections, Prerouting, nil, nil, ""); v != RuleContinue {
+		t.Errorf("got set.Action(...) = %d, want = %d", v, RuleContinue)
+	}
+	if got := it.connections.connMark(ack); got != 0x21 {
+		t.Errorf("got the mark of the connection = %#x, want = 0x21", got)
+	}
+	if ack.Mark != 0 {
+		t.Errorf("got ack.Mark = %#x, want = 0", ack.Mark)
+	}
+}
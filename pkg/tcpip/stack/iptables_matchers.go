@@ -0,0 +1,717 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// Names of the stateful matchers, as used by iptables.
+const (
+	ConnLimitMatcherName = "connlimit"
+	HashLimitMatcherName = "hashlimit"
+	RecentMatcherName    = "recent"
+)
+
+// connLimitGracePeriod is the time during which the connections counted by a
+// ConnLimitMatcher are counted even though they aren't tracked yet, like the
+// connections whose first packet is checked at the Output hook, which are
+// only tracked once the packet traversed the tables.
+const connLimitGracePeriod = time.Second
+
+// connLimitGCInterval is the interval between the removals of the closed
+// connections of the groups of a ConnLimitMatcher which no packet checked
+// lately.
+const connLimitGCInterval = time.Minute
+
+// Defaults of HashLimitOptions, as in Linux.
+const (
+	DefaultHashLimitMaxFlows   = 65536
+	DefaultHashLimitExpire     = 10 * time.Second
+	DefaultHashLimitGCInterval = time.Second
+)
+
+// Limits of the recent lists, like the ip_list_tot and ip_pkt_list_tot
+// parameters of Linux's xt_recent module.
+const (
+	// RecentMaxEntries is the number of addresses of a recent list. The
+	// least recently seen address is removed to make room for new ones.
+	RecentMaxEntries = 100
+
+	// RecentMaxHits is the number of times the addresses of a recent list
+	// are remembered to have been seen at.
+	RecentMaxHits = 20
+)
+
+// applyMask returns addr masked by the first len(addr) bytes of mask, an
+// IPv6-sized mask whose first 4 bytes apply to IPv4 addresses.
+func applyMask(addr, mask tcpip.Address) tcpip.Address {
+	masked := []byte(addr)
+	for i := range masked {
+		masked[i] &= mask[i]
+	}
+	return tcpip.Address(masked)
+}
+
+// ConnLimitOptions holds the configuration of a ConnLimitMatcher.
+type ConnLimitOptions struct {
+	// Limit is the number of connections above which the packets of a
+	// group match.
+	Limit uint32
+
+	// Mask is applied to the addresses connections are grouped by. It is
+	// IPv6-sized, and its first 4 bytes apply to IPv4 addresses.
+	Mask tcpip.Address
+
+	// Destination groups connections by destination rather than source
+	// address.
+	Destination bool
+
+	// Invert makes packets match when their group has at most Limit
+	// connections.
+	Invert bool
+}
+
+// ConnLimitMatcher matches the packets of the groups of hosts which have more
+// connections than a limit, like Linux's connlimit match. It implements
+// Matcher.
+//
+// Connections are counted when the packets of the group are checked by the
+// matcher, so that rules usually check the first packets of connections
+// only. As only TCP connections are tracked, other packets never match.
+type ConnLimitMatcher struct {
+	opts  ConnLimitOptions
+	clock tcpip.Clock
+	ct    *ConnTrack
+
+	mu sync.Mutex
+
+	// groups maps the masked addresses of groups to their connections. It
+	// is protected by mu.
+	groups map[tcpip.Address][]connLimitEntry
+
+	// lastGC is the monotonic time at which the closed connections of all
+	// the groups were last removed. It is protected by mu.
+	lastGC int64
+}
+
+// connLimitEntry is a connection counted by a ConnLimitMatcher.
+type connLimitEntry struct {
+	// tid is the original tuple of the connection.
+	tid tupleID
+
+	// added is the monotonic time at which the connection was counted.
+	added int64
+}
+
+// NewConnLimitMatcher returns a ConnLimitMatcher counting the connections
+// tracked by the iptables of s.
+func NewConnLimitMatcher(s *Stack, opts ConnLimitOptions) (*ConnLimitMatcher, *tcpip.Error) {
+	if len(opts.Mask) != header.IPv6AddressSize {
+		return nil, tcpip.ErrInvalidOptionValue
+	}
+	clock := s.Clock()
+	return &ConnLimitMatcher{
+		opts:   opts,
+		clock:  clock,
+		ct:     &s.IPTables().connections,
+		groups: make(map[tcpip.Address][]connLimitEntry),
+		lastGC: clock.NowMonotonic(),
+	}, nil
+}
+
+// Options returns the configuration of m.
+func (m *ConnLimitMatcher) Options() ConnLimitOptions {
+	return m.opts
+}
+
+// Name implements Matcher.Name.
+func (*ConnLimitMatcher) Name() string {
+	return ConnLimitMatcherName
+}
+
+// Match implements Matcher.Match.
+func (m *ConnLimitMatcher) Match(hook Hook, pkt *PacketBuffer, interfaceName string) (bool, bool) {
+	tid, err := packetToTupleID(pkt)
+	if err != nil {
+		return false, false
+	}
+	if conn, _ := m.ct.connForTID(tid); conn != nil {
+		tid = conn.original.tupleID
+	}
+	addr := pkt.Network().SourceAddress()
+	if m.opts.Destination {
+		addr = pkt.Network().DestinationAddress()
+	}
+	count := m.count(applyMask(addr, m.opts.Mask), tid)
+	return (count > int(m.opts.Limit)) != m.opts.Invert, false
+}
+
+// count counts the connection tid in the group of addr, and returns the
+// number of connections of the group.
+func (m *ConnLimitMatcher) count(addr tcpip.Address, tid tupleID) int {
+	now := m.clock.NowMonotonic()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := m.pruneLocked(m.groups[addr], now, tid)
+	found := false
+	for _, e := range entries {
+		if e.tid == tid {
+			found = true
+			break
+		}
+	}
+	if !found {
+		entries = append(entries, connLimitEntry{tid: tid, added: now})
+	}
+	m.groups[addr] = entries
+
+	if time.Duration(now-m.lastGC) > connLimitGCInterval {
+		for addr, entries := range m.groups {
+			if entries := m.pruneLocked(entries, now, tid); len(entries) != 0 {
+				m.groups[addr] = entries
+			} else {
+				delete(m.groups, addr)
+			}
+		}
+		m.lastGC = now
+	}
+	return len(entries)
+}
+
+// pruneLocked removes the connections which were closed or aren't tracked
+// anymore from entries, except keep.
+//
+// Precondition: m.mu must be locked.
+func (m *ConnLimitMatcher) pruneLocked(entries []connLimitEntry, now int64, keep tupleID) []connLimitEntry {
+	kept := entries[:0]
+	for _, e := range entries {
+		if e.tid != keep {
+			if conn, _ := m.ct.connForTID(e.tid); conn == nil {
+				if time.Duration(now-e.added) > connLimitGracePeriod {
+					continue
+				}
+			} else if conn.closed() {
+				continue
+			}
+		}
+		kept = append(kept, e)
+	}
+	return kept
+}
+
+// HashLimitOptions holds the configuration of a HashLimitMatcher.
+type HashLimitOptions struct {
+	// Name is the name of the table holding the state of the flows, which
+	// is shared by the matchers of the same name and network protocol.
+	Name string
+
+	// NetworkProtocol is the network protocol of the packets the matcher
+	// checks.
+	NetworkProtocol tcpip.NetworkProtocolNumber
+
+	// SrcAddr, DstAddr, SrcPort and DstPort select the fields of packets
+	// which identify their flow. The packets of each flow are limited
+	// independently.
+	SrcAddr bool
+	DstAddr bool
+	SrcPort bool
+	DstPort bool
+
+	// SrcPrefixLen and DstPrefixLen are the lengths of the prefixes of the
+	// source and destination addresses flows are identified by.
+	SrcPrefixLen uint8
+	DstPrefixLen uint8
+
+	// Interval is the average time between the packets of flows.
+	Interval time.Duration
+
+	// Burst is the number of packets flows may send at once.
+	Burst uint32
+
+	// Above makes the packets of flows above the rate match, rather than
+	// the packets within.
+	Above bool
+
+	// MaxFlows is the number of flows of the table above which the packets
+	// of new flows are dropped. If zero, DefaultHashLimitMaxFlows is used.
+	MaxFlows uint32
+
+	// Expire is the time after which idle flows are forgotten. If zero,
+	// DefaultHashLimitExpire is used.
+	Expire time.Duration
+
+	// GCInterval is the interval between the removals of expired flows. If
+	// zero, DefaultHashLimitGCInterval is used.
+	GCInterval time.Duration
+}
+
+// HashLimitMatcher matches the packets of flows within a rate, like Linux's
+// hashlimit match. It implements Matcher.
+//
+// The rate of each flow is limited with a token bucket which holds Burst
+// tokens and is refilled with a token every Interval.
+type HashLimitMatcher struct {
+	opts  HashLimitOptions
+	clock tcpip.Clock
+	table *hashLimitTable
+
+	// maxFlows, expire and gcInterval are the values of the options of the
+	// same name, or their default.
+	maxFlows   int
+	expire     time.Duration
+	gcInterval time.Duration
+}
+
+// hashLimitKey identifies a hashLimitTable.
+type hashLimitKey struct {
+	name     string
+	netProto tcpip.NetworkProtocolNumber
+}
+
+// hashLimitFlow identifies a flow of a hashLimitTable.
+type hashLimitFlow struct {
+	srcAddr tcpip.Address
+	dstAddr tcpip.Address
+	srcPort uint16
+	dstPort uint16
+}
+
+// hashLimitBucket is the token bucket of a flow.
+type hashLimitBucket struct {
+	// credit is the time during which the flow may send packets at the
+	// average rate without being limited, up to Burst * Interval.
+	credit int64
+
+	// updated is the monotonic time at which the bucket was last refilled.
+	updated int64
+
+	// expires is the monotonic time after which the flow is forgotten.
+	expires int64
+}
+
+// hashLimitTable holds the token buckets of the flows of the hashlimit
+// matchers of a name.
+type hashLimitTable struct {
+	mu sync.Mutex
+
+	// buckets is protected by mu.
+	buckets map[hashLimitFlow]*hashLimitBucket
+
+	// lastGC is the monotonic time at which the expired flows were last
+	// removed. It is protected by mu.
+	lastGC int64
+}
+
+// NewHashLimitMatcher returns a HashLimitMatcher whose flows are held by the
+// table of its name in the iptables of s, which is created if needed.
+func NewHashLimitMatcher(s *Stack, opts HashLimitOptions) (*HashLimitMatcher, *tcpip.Error) {
+	var addrLen int
+	switch opts.NetworkProtocol {
+	case header.IPv4ProtocolNumber:
+		addrLen = header.IPv4AddressSize
+	case header.IPv6ProtocolNumber:
+		addrLen = header.IPv6AddressSize
+	default:
+		return nil, tcpip.ErrUnknownProtocol
+	}
+	if len(opts.Name) == 0 ||
+		int(opts.SrcPrefixLen) > addrLen*8 ||
+		int(opts.DstPrefixLen) > addrLen*8 ||
+		opts.Interval < 0 ||
+		opts.Expire < 0 ||
+		opts.GCInterval < 0 {
+		return nil, tcpip.ErrInvalidOptionValue
+	}
+	// The credit of buckets must not overflow.
+	if opts.Burst != 0 && int64(opts.Interval) > math.MaxInt64/int64(opts.Burst) {
+		return nil, tcpip.ErrInvalidOptionValue
+	}
+	m := HashLimitMatcher{
+		opts:       opts,
+		clock:      s.Clock(),
+		maxFlows:   int(opts.MaxFlows),
+		expire:     opts.Expire,
+		gcInterval: opts.GCInterval,
+	}
+	if m.maxFlows == 0 {
+		m.maxFlows = DefaultHashLimitMaxFlows
+	}
+	if m.expire == 0 {
+		m.expire = DefaultHashLimitExpire
+	}
+	if m.gcInterval == 0 {
+		m.gcInterval = DefaultHashLimitGCInterval
+	}
+
+	it := s.IPTables()
+	key := hashLimitKey{name: opts.Name, netProto: opts.NetworkProtocol}
+	it.matchersMu.Lock()
+	defer it.matchersMu.Unlock()
+	t, ok := it.hashLimitTables[key]
+	if !ok {
+		t = &hashLimitTable{
+			buckets: make(map[hashLimitFlow]*hashLimitBucket),
+			lastGC:  m.clock.NowMonotonic(),
+		}
+		if it.hashLimitTables == nil {
+			it.hashLimitTables = make(map[hashLimitKey]*hashLimitTable)
+		}
+		it.hashLimitTables[key] = t
+	}
+	m.table = t
+	return &m, nil
+}
+
+// Options returns the configuration of m.
+func (m *HashLimitMatcher) Options() HashLimitOptions {
+	return m.opts
+}
+
+// Name implements Matcher.Name.
+func (*HashLimitMatcher) Name() string {
+	return HashLimitMatcherName
+}
+
+// Match implements Matcher.Match.
+func (m *HashLimitMatcher) Match(hook Hook, pkt *PacketBuffer, interfaceName string) (bool, bool) {
+	if pkt.NetworkProtocolNumber != m.opts.NetworkProtocol {
+		return false, false
+	}
+	flow, ok := m.flow(pkt)
+	if !ok {
+		// The ports of fragments are unknown, drop them as Linux does.
+		return false, true
+	}
+	now := m.clock.NowMonotonic()
+	cost := int64(m.opts.Interval)
+	capacity := cost * int64(m.opts.Burst)
+
+	t := m.table
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if time.Duration(now-t.lastGC) > m.gcInterval {
+		for flow, b := range t.buckets {
+			if now > b.expires {
+				delete(t.buckets, flow)
+			}
+		}
+		t.lastGC = now
+	}
+	b, ok := t.buckets[flow]
+	if !ok {
+		if len(t.buckets) >= m.maxFlows {
+			return false, true
+		}
+		b = &hashLimitBucket{credit: capacity}
+		t.buckets[flow] = b
+	} else {
+		b.credit += now - b.updated
+		if b.credit > capacity {
+			b.credit = capacity
+		}
+	}
+	b.updated = now
+	b.expires = now + int64(m.expire)
+
+	within := b.credit >= cost
+	if within {
+		b.credit -= cost
+	}
+	return within != m.opts.Above, false
+}
+
+// flow returns the flow of pkt. It returns false if the ports of the flow
+// are needed but pkt doesn't hold them.
+func (m *HashLimitMatcher) flow(pkt *PacketBuffer) (hashLimitFlow, bool) {
+	var flow hashLimitFlow
+	netHeader := pkt.Network()
+	if m.opts.SrcAddr {
+		flow.srcAddr = maskAddress(netHeader.SourceAddress(), int(m.opts.SrcPrefixLen))
+	}
+	if m.opts.DstAddr {
+		flow.dstAddr = maskAddress(netHeader.DestinationAddress(), int(m.opts.DstPrefixLen))
+	}
+	if !m.opts.SrcPort && !m.opts.DstPort {
+		return flow, true
+	}
+	switch netHeader.TransportProtocol() {
+	case header.TCPProtocolNumber, header.UDPProtocolNumber:
+	default:
+		// Other protocols have no ports.
+		return flow, true
+	}
+	// The source and destination ports are at the same offsets in TCP and
+	// UDP headers.
+	udpHeader := header.UDP(pkt.TransportHeader().View())
+	if len(udpHeader) < header.UDPMinimumSize {
+		return hashLimitFlow{}, false
+	}
+	if m.opts.SrcPort {
+		flow.srcPort = udpHeader.SourcePort()
+	}
+	if m.opts.DstPort {
+		flow.dstPort = udpHeader.DestinationPort()
+	}
+	return flow, true
+}
+
+// RecentCommand is the operation a RecentMatcher performs on its list.
+type RecentCommand int
+
+const (
+	// RecentCheck matches the packets whose address is in the list.
+	RecentCheck RecentCommand = iota
+
+	// RecentSet adds the addresses of packets to the list, or updates
+	// them, and always matches.
+	RecentSet
+
+	// RecentUpdate is like RecentCheck, but also updates the addresses of
+	// the packets which match.
+	RecentUpdate
+
+	// RecentRemove removes the addresses of packets from the list, and
+	// matches the packets whose address was in the list.
+	RecentRemove
+)
+
+// RecentOptions holds the configuration of a RecentMatcher.
+type RecentOptions struct {
+	// Name is the name of the list, which is shared by the matchers of the
+	// same name.
+	Name string
+
+	// Command is the operation the matcher performs on the list.
+	Command RecentCommand
+
+	// Within restricts RecentCheck and RecentUpdate to the times addresses
+	// were seen within, if not zero.
+	Within time.Duration
+
+	// HitCount is the number of times addresses must have been seen for
+	// RecentCheck and RecentUpdate to match, if not zero. It must not
+	// exceed RecentMaxHits.
+	HitCount uint32
+
+	// Reap makes RecentCheck and RecentUpdate remove the addresses which
+	// weren't seen within Within, which must not be zero.
+	Reap bool
+
+	// TTL restricts the matcher to the addresses last seen with the same
+	// TTL or hop limit as the packet.
+	TTL bool
+
+	// Destination makes the matcher use the destination address of packets
+	// rather than the source address.
+	Destination bool
+
+	// Mask is applied to the addresses of packets. It is IPv6-sized, and its
+	// first 4 bytes apply to IPv4 addresses.
+	Mask tcpip.Address
+
+	// Invert flips the meaning of the match.
+	Invert bool
+}
+
+// RecentMatcher matches the packets whose address was seen recently, like
+// Linux's recent match. It implements Matcher.
+//
+// The addresses are held by named lists, to which the matchers with
+// RecentSet add the addresses of packets, so that other matchers of the same
+// name can match them.
+type RecentMatcher struct {
+	opts  RecentOptions
+	clock tcpip.Clock
+	list  *recentList
+}
+
+// recentEntry is an address of a recent list.
+type recentEntry struct {
+	// ttl is the TTL or hop limit of the packet the address was last seen
+	// with.
+	ttl uint8
+
+	// stamps holds the monotonic times at which the address was last seen,
+	// oldest first.
+	stamps []int64
+}
+
+// update records that the address of e was seen at the monotonic time now
+// with ttl.
+func (e *recentEntry) update(now int64, ttl uint8) {
+	if len(e.stamps) == RecentMaxHits {
+		copy(e.stamps, e.stamps[1:])
+		e.stamps = e.stamps[:len(e.stamps)-1]
+	}
+	e.stamps = append(e.stamps, now)
+	e.ttl = ttl
+}
+
+// recentList is the list of the recent matchers of a name.
+type recentList struct {
+	mu sync.Mutex
+
+	// entries is protected by mu.
+	entries map[tcpip.Address]*recentEntry
+}
+
+// insertLocked adds addr to the list, which it makes room for by removing
+// the least recently seen address if needed.
+//
+// Precondition: l.mu must be locked.
+func (l *recentList) insertLocked(addr tcpip.Address) *recentEntry {
+	if len(l.entries) >= RecentMaxEntries {
+		var oldest tcpip.Address
+		var oldestStamp int64
+		first := true
+		for addr, e := range l.entries {
+			if stamp := e.stamps[len(e.stamps)-1]; first || stamp < oldestStamp {
+				oldest, oldestStamp, first = addr, stamp, false
+			}
+		}
+		delete(l.entries, oldest)
+	}
+	e := &recentEntry{}
+	l.entries[addr] = e
+	return e
+}
+
+// NewRecentMatcher returns a RecentMatcher whose addresses are held by the
+// list of its name in the iptables of s, which is created if needed.
+func NewRecentMatcher(s *Stack, opts RecentOptions) (*RecentMatcher, *tcpip.Error) {
+	if len(opts.Name) == 0 ||
+		len(opts.Mask) != header.IPv6AddressSize ||
+		opts.HitCount > RecentMaxHits ||
+		opts.Within < 0 {
+		return nil, tcpip.ErrInvalidOptionValue
+	}
+	switch opts.Command {
+	case RecentCheck, RecentUpdate:
+		if opts.Reap && opts.Within == 0 {
+			return nil, tcpip.ErrInvalidOptionValue
+		}
+	case RecentSet, RecentRemove:
+		if opts.Within != 0 || opts.HitCount != 0 || opts.Reap || opts.TTL {
+			return nil, tcpip.ErrInvalidOptionValue
+		}
+	default:
+		return nil, tcpip.ErrInvalidOptionValue
+	}
+
+	it := s.IPTables()
+	it.matchersMu.Lock()
+	defer it.matchersMu.Unlock()
+	l, ok := it.recentLists[opts.Name]
+	if !ok {
+		l = &recentList{entries: make(map[tcpip.Address]*recentEntry)}
+		if it.recentLists == nil {
+			it.recentLists = make(map[string]*recentList)
+		}
+		it.recentLists[opts.Name] = l
+	}
+	return &RecentMatcher{
+		opts:  opts,
+		clock: s.Clock(),
+		list:  l,
+	}, nil
+}
+
+// Options returns the configuration of m.
+func (m *RecentMatcher) Options() RecentOptions {
+	return m.opts
+}
+
+// Name implements Matcher.Name.
+func (*RecentMatcher) Name() string {
+	return RecentMatcherName
+}
+
+// Match implements Matcher.Match.
+func (m *RecentMatcher) Match(hook Hook, pkt *PacketBuffer, interfaceName string) (bool, bool) {
+	var ttl uint8
+	switch pkt.NetworkProtocolNumber {
+	case header.IPv4ProtocolNumber:
+		ttl = header.IPv4(pkt.NetworkHeader().View()).TTL()
+	case header.IPv6ProtocolNumber:
+		ttl = header.IPv6(pkt.NetworkHeader().View()).HopLimit()
+	default:
+		return false, false
+	}
+	addr := pkt.Network().SourceAddress()
+	if m.opts.Destination {
+		addr = pkt.Network().DestinationAddress()
+	}
+	addr = applyMask(addr, m.opts.Mask)
+	now := m.clock.NowMonotonic()
+
+	l := m.list
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	matches := m.opts.Invert
+	e, ok := l.entries[addr]
+	if ok && m.opts.TTL && e.ttl != ttl {
+		ok = false
+	}
+	if !ok {
+		if m.opts.Command != RecentSet {
+			return matches, false
+		}
+		if e == nil {
+			e = l.insertLocked(addr)
+		} else {
+			// Forget the times the address was seen with another
+			// TTL.
+			e.stamps = e.stamps[:0]
+		}
+		e.update(now, ttl)
+		return !matches, false
+	}
+
+	switch m.opts.Command {
+	case RecentSet:
+		matches = !matches
+	case RecentRemove:
+		delete(l.entries, addr)
+		return !matches, false
+	case RecentCheck, RecentUpdate:
+		since := now - int64(m.opts.Within)
+		hits := uint32(0)
+		for _, stamp := range e.stamps {
+			if m.opts.Within != 0 && stamp < since {
+				continue
+			}
+			hits++
+			if m.opts.HitCount == 0 || hits >= m.opts.HitCount {
+				matches = !matches
+				break
+			}
+		}
+		if m.opts.Reap && e.stamps[len(e.stamps)-1] < since {
+			delete(l.entries, addr)
+			return matches, false
+		}
+	}
+	if m.opts.Command == RecentSet || (m.opts.Command == RecentUpdate && matches) {
+		e.update(now, ttl)
+	}
+	return matches, false
+}
@@ -0,0 +1,296 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+const (
+	matcherTestAddr1 = tcpip.Address("\x0a\x00\x00\x01")
+	matcherTestAddr2 = tcpip.Address("\x0a\x00\x00\x02")
+	matcherTestAddr3 = tcpip.Address("\x0a\x00\x00\x03")
+
+	// matcherTestMask is an IPv6-sized mask which doesn't mask IPv4
+	// addresses.
+	matcherTestMask = tcpip.Address("\xff\xff\xff\xff\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+)
+
+// newMatcherTestPacket returns an IPv4 packet carrying a TCP segment with
+// flags from src to the remote port 80 of matcherTestAddr3.
+func newMatcherTestPacket(src tcpip.Address, srcPort uint16, ttl uint8, flags uint8) *PacketBuffer {
+	hdr := buffer.NewView(header.IPv4MinimumSize + header.TCPMinimumSize)
+	header.IPv4(hdr).Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(hdr)),
+		TTL:         ttl,
+		Protocol:    uint8(header.TCPProtocolNumber),
+		SrcAddr:     src,
+		DstAddr:     matcherTestAddr3,
+	})
+	header.TCP(hdr[header.IPv4MinimumSize:]).Encode(&header.TCPFields{
+		SrcPort:    srcPort,
+		DstPort:    80,
+		DataOffset: header.TCPMinimumSize,
+		Flags:      flags,
+	})
+	pkt := NewPacketBuffer(PacketBufferOptions{
+		Data: hdr.ToVectorisedView(),
+	})
+	pkt.NetworkProtocolNumber = header.IPv4ProtocolNumber
+	pkt.NetworkHeader().Consume(header.IPv4MinimumSize)
+	pkt.TransportProtocolNumber = header.TCPProtocolNumber
+	pkt.TransportHeader().Consume(header.TCPMinimumSize)
+	return pkt
+}
+
+// newMatcherTestStack returns a stack whose iptables track connections.
+func newMatcherTestStack(t *testing.T, clock tcpip.Clock) *Stack {
+	t.Helper()
+	s := New(Options{Clock: clock})
+	it := s.IPTables()
+	if err := it.ReplaceTable(FilterID, it.GetTable(FilterID, false /* ipv6 */), false /* ipv6 */); err != nil {
+		t.Fatalf("it.ReplaceTable(FilterID, _, false): %s", err)
+	}
+	return s
+}
+
+func TestConnLimitMatcher(t *testing.T) {
+	clock := faketime.NewManualClock()
+	s := newMatcherTestStack(t, clock)
+	m, err := NewConnLimitMatcher(s, ConnLimitOptions{
+		Limit: 2,
+		Mask:  matcherTestMask,
+	})
+	if err != nil {
+		t.Fatalf("NewConnLimitMatcher(_, _): %s", err)
+	}
+
+	// connect tracks a connection from src:srcPort and checks its SYN.
+	connect := func(src tcpip.Address, srcPort uint16, want bool) {
+		t.Helper()
+		pkt := newMatcherTestPacket(src, srcPort, 64, header.TCPFlagSyn)
+		s.IPTables().connections.maybeInsertNoop(pkt, Prerouting)
+		if got, hotdrop := m.Match(Input, pkt, ""); got != want || hotdrop {
+			t.Errorf("got m.Match(Input, SYN from %s:%d, _) = (%t, %t), want = (%t, false)", src, srcPort, got, hotdrop, want)
+		}
+	}
+	connect(matcherTestAddr1, 1000, false)
+	connect(matcherTestAddr1, 1001, false)
+	connect(matcherTestAddr1, 1002, true)
+	// The connections of other hosts are counted separately.
+	connect(matcherTestAddr2, 1000, false)
+	// Connections are counted once.
+	connect(matcherTestAddr1, 1000, true)
+
+	// Closed connections aren't counted.
+	for _, port := range []uint16{1001, 1002} {
+		conn, _ := s.IPTables().connections.connFor(newMatcherTestPacket(matcherTestAddr1, port, 64, header.TCPFlagSyn))
+		if conn == nil {
+			t.Fatalf("connection from %s:%d isn't tracked", matcherTestAddr1, port)
+		}
+		// Reset the connection from the server.
+		rst := make(header.TCP, header.TCPMinimumSize)
+		rst.Encode(&header.TCPFields{
+			SrcPort:    80,
+			DstPort:    port,
+			AckNum:     1,
+			DataOffset: header.TCPMinimumSize,
+			Flags:      header.TCPFlagRst | header.TCPFlagAck,
+		})
+		conn.mu.Lock()
+		conn.updateLocked(rst, Output)
+		conn.mu.Unlock()
+	}
+	connect(matcherTestAddr1, 1003, false)
+
+	// Connections which aren't tracked yet are counted for a while.
+	pkt := newMatcherTestPacket(matcherTestAddr1, 1004, 64, header.TCPFlagSyn)
+	if got, hotdrop := m.Match(Output, pkt, ""); !got || hotdrop {
+		t.Errorf("got m.Match(Output, _, _) = (%t, %t), want = (true, false)", got, hotdrop)
+	}
+	connect(matcherTestAddr1, 1003, true)
+	clock.Advance(connLimitGracePeriod + time.Second)
+	connect(matcherTestAddr1, 1003, false)
+}
+
+func TestHashLimitMatcher(t *testing.T) {
+	clock := faketime.NewManualClock()
+	s := newMatcherTestStack(t, clock)
+	opts := HashLimitOptions{
+		Name:            "test",
+		NetworkProtocol: header.IPv4ProtocolNumber,
+		SrcAddr:         true,
+		SrcPrefixLen:    32,
+		Interval:        time.Second,
+		Burst:           2,
+		MaxFlows:        2,
+		Expire:          10 * time.Second,
+	}
+	m, err := NewHashLimitMatcher(s, opts)
+	if err != nil {
+		t.Fatalf("NewHashLimitMatcher(_, %#v): %s", opts, err)
+	}
+	check := func(m *HashLimitMatcher, src tcpip.Address, want, wantHotdrop bool) {
+		t.Helper()
+		pkt := newMatcherTestPacket(src, 1000, 64, header.TCPFlagSyn)
+		if got, hotdrop := m.Match(Input, pkt, ""); got != want || hotdrop != wantHotdrop {
+			t.Errorf("got m.Match(Input, packet from %s, _) = (%t, %t), want = (%t, %t)", src, got, hotdrop, want, wantHotdrop)
+		}
+	}
+
+	// Flows may send Burst packets at once, and then a packet every
+	// Interval.
+	check(m, matcherTestAddr1, true, false)
+	check(m, matcherTestAddr1, true, false)
+	check(m, matcherTestAddr1, false, false)
+	check(m, matcherTestAddr2, true, false)
+	clock.Advance(time.Second)
+	check(m, matcherTestAddr1, true, false)
+	check(m, matcherTestAddr1, false, false)
+
+	// Matchers of the same name share the flows.
+	opts.Above = true
+	above, err := NewHashLimitMatcher(s, opts)
+	if err != nil {
+		t.Fatalf("NewHashLimitMatcher(_, %#v): %s", opts, err)
+	}
+	check(above, matcherTestAddr1, true, false)
+	check(above, matcherTestAddr2, false, false)
+
+	// The packets of new flows are dropped when there are MaxFlows flows,
+	// until flows expire.
+	check(m, matcherTestAddr3, false, true)
+	clock.Advance(opts.Expire + time.Second)
+	check(m, matcherTestAddr3, true, false)
+
+	// Matchers of other names have their own flows.
+	opts.Name = "other"
+	opts.Above = false
+	other, err := NewHashLimitMatcher(s, opts)
+	if err != nil {
+		t.Fatalf("NewHashLimitMatcher(_, %#v): %s", opts, err)
+	}
+	check(other, matcherTestAddr3, true, false)
+	check(other, matcherTestAddr3, true, false)
+	check(other, matcherTestAddr3, false, false)
+}
+
+func TestRecentMatcher(t *testing.T) {
+	clock := faketime.NewManualClock()
+	s := newMatcherTestStack(t, clock)
+	newMatcher := func(opts RecentOptions) *RecentMatcher {
+		t.Helper()
+		opts.Mask = matcherTestMask
+		m, err := NewRecentMatcher(s, opts)
+		if err != nil {
+			t.Fatalf("NewRecentMatcher(_, %#v): %s", opts, err)
+		}
+		return m
+	}
+	set := newMatcher(RecentOptions{Name: "test", Command: RecentSet})
+	check := newMatcher(RecentOptions{
+		Name:     "test",
+		Command:  RecentCheck,
+		Within:   time.Minute,
+		HitCount: 3,
+	})
+	remove := newMatcher(RecentOptions{Name: "test", Command: RecentRemove})
+	match := func(m *RecentMatcher, src tcpip.Address, want bool) {
+		t.Helper()
+		pkt := newMatcherTestPacket(src, 1000, 64, header.TCPFlagSyn)
+		if got, hotdrop := m.Match(Input, pkt, ""); got != want || hotdrop {
+			t.Errorf("got m.Match(Input, packet from %s, _) = (%t, %t), want = (%t, false)", src, got, hotdrop, want)
+		}
+	}
+
+	match(check, matcherTestAddr1, false)
+	for i := 0; i < 3; i++ {
+		match(set, matcherTestAddr1, true)
+		clock.Advance(time.Second)
+	}
+	match(check, matcherTestAddr1, true)
+	match(check, matcherTestAddr2, false)
+
+	// Lists are shared by the matchers of the same name only.
+	match(newMatcher(RecentOptions{Name: "test", Command: RecentCheck}), matcherTestAddr1, true)
+	match(newMatcher(RecentOptions{Name: "other", Command: RecentCheck}), matcherTestAddr1, false)
+
+	// The times addresses were seen before Within are ignored.
+	clock.Advance(time.Minute - 2*time.Second)
+	match(check, matcherTestAddr1, false)
+
+	match(remove, matcherTestAddr1, true)
+	match(remove, matcherTestAddr1, false)
+	match(newMatcher(RecentOptions{Name: "test", Command: RecentCheck}), matcherTestAddr1, false)
+
+	// The least recently seen address is removed when lists are full.
+	for i := 0; i < RecentMaxEntries+1; i++ {
+		match(set, tcpip.Address([]byte{10, 1, byte(i >> 8), byte(i)}), true)
+		clock.Advance(time.Millisecond)
+	}
+	match(newMatcher(RecentOptions{Name: "test", Command: RecentCheck}), "\x0a\x01\x00\x00", false)
+	match(newMatcher(RecentOptions{Name: "test", Command: RecentCheck}), "\x0a\x01\x00\x01", true)
+}
+
+func TestRecentMatcherTTL(t *testing.T) {
+	clock := faketime.NewManualClock()
+	s := newMatcherTestStack(t, clock)
+	set, err := NewRecentMatcher(s, RecentOptions{Name: "test", Command: RecentSet, Mask: matcherTestMask})
+	if err != nil {
+		t.Fatalf("NewRecentMatcher(_, _): %s", err)
+	}
+	update, err := NewRecentMatcher(s, RecentOptions{
+		Name:    "test",
+		Command: RecentUpdate,
+		Within:  time.Minute,
+		Reap:    true,
+		TTL:     true,
+		Mask:    matcherTestMask,
+	})
+	if err != nil {
+		t.Fatalf("NewRecentMatcher(_, _): %s", err)
+	}
+	match := func(m *RecentMatcher, ttl uint8, want bool) {
+		t.Helper()
+		pkt := newMatcherTestPacket(matcherTestAddr1, 1000, ttl, header.TCPFlagSyn)
+		if got, hotdrop := m.Match(Input, pkt, ""); got != want || hotdrop {
+			t.Errorf("got m.Match(Input, packet with TTL %d, _) = (%t, %t), want = (%t, false)", ttl, got, hotdrop, want)
+		}
+	}
+
+	match(set, 64, true)
+	match(update, 63, false)
+	match(update, 64, true)
+	// Updates keep addresses from being reaped.
+	clock.Advance(time.Minute - time.Second)
+	match(update, 64, true)
+	clock.Advance(time.Minute - time.Second)
+	match(update, 64, true)
+	clock.Advance(time.Minute + time.Second)
+	match(update, 64, false)
+
+	// The address was reaped.
+	check, err := NewRecentMatcher(s, RecentOptions{Name: "test", Command: RecentCheck, Mask: matcherTestMask})
+	if err != nil {
+		t.Fatalf("NewRecentMatcher(_, _): %s", err)
+	}
+	match(check, 64, false)
+}
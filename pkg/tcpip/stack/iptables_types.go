@@ -75,6 +75,10 @@ const (
 
 	// RuleReturn indicates the packet should return to the previous chain.
 	RuleReturn
+
+	// RuleContinue indicates the packet should continue on to the next
+	// rule, as after non-terminating targets like LogTarget.
+	RuleContinue
 )
 
 // IPTables holds all the tables for a netstack.
@@ -101,6 +105,17 @@ type IPTables struct {
 
 	// reaperDone can be signaled to stop the reaper goroutine.
 	reaperDone chan struct{}
+
+	// matchersMu protects hashLimitTables and recentLists.
+	matchersMu sync.Mutex `state:"nosave"`
+
+	// hashLimitTables and recentLists hold the state of the hashlimit and
+	// recent matchers by name. They outlive the matchers, so that the state
+	// is kept when tables are replaced.
+	//
+	// TODO(gvisor.dev/issue/170): Save the state of the matchers.
+	hashLimitTables map[hashLimitKey]*hashLimitTable `state:"nosave"`
+	recentLists     map[string]*recentList           `state:"nosave"`
 }
 
 // VisitTargets traverses all the targets of all tables and replaces each with
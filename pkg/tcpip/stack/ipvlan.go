@@ -0,0 +1,239 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// IPVLANMode is the mode of an IPVLAN NIC.
+type IPVLANMode int
+
+const (
+	// IPVLANModeL2 delivers the multicast and broadcast packets received by
+	// the parent to the IPVLAN NIC.
+	IPVLANModeL2 IPVLANMode = iota
+
+	// IPVLANModeL3 only delivers the packets for the addresses of the IPVLAN
+	// NIC to it, including the address resolution requests for them.
+	IPVLANModeL3
+)
+
+// IPVLANOptions specify the details of an IPVLAN NIC.
+type IPVLANOptions struct {
+	UpperNICOptions
+
+	// Mode is the mode of the NIC.
+	Mode IPVLANMode
+}
+
+// ipvlanEndpoint is the link endpoint of an IPVLAN NIC. It shares the link
+// address of its parent NIC, through which it sends packets, and receives the
+// packets for its addresses from its parent NIC.
+//
+// The packets between the IPVLAN NICs of a parent are delivered directly,
+// without being sent through the parent.
+type ipvlanEndpoint struct {
+	upperEndpoint
+	mode IPVLANMode
+
+	// nic is the NIC of the endpoint. It is immutable once the NIC is
+	// created.
+	nic *NIC
+}
+
+var _ upperLinkEndpoint = (*ipvlanEndpoint)(nil)
+
+// siblings returns the IPVLAN NICs of the parent of e.
+func (e *ipvlanEndpoint) siblings() []*ipvlanEndpoint {
+	e.parent.mu.RLock()
+	defer e.parent.mu.RUnlock()
+	return e.parent.mu.ipvlans
+}
+
+// WritePacket implements LinkEndpoint.WritePacket.
+func (e *ipvlanEndpoint) WritePacket(r *Route, gso *GSO, protocol tcpip.NetworkProtocolNumber, pkt *PacketBuffer) *tcpip.Error {
+	local := e.LinkAddress()
+	vv := buffer.NewVectorisedView(pkt.Size(), pkt.Views())
+	siblings := e.siblings()
+	if dst := findIPVLAN(siblings, protocol, &vv); dst != nil && dst != e {
+		dst.deliver(local, local, protocol, pkt.CloneToInbound())
+		return nil
+	}
+
+	if remote := r.RemoteLinkAddress(); e.mode == IPVLANModeL2 && header.IsMulticastEthernetAddress(remote) {
+		for _, s := range siblings {
+			if s != e && s.mode == IPVLANModeL2 {
+				s.deliver(local, remote, protocol, pkt.CloneToInbound())
+			}
+		}
+	}
+	return e.parent.LinkEndpoint.WritePacket(r, gso, protocol, pkt)
+}
+
+// WritePackets implements LinkEndpoint.WritePackets.
+func (e *ipvlanEndpoint) WritePackets(r *Route, gso *GSO, pkts PacketBufferList, protocol tcpip.NetworkProtocolNumber) (int, *tcpip.Error) {
+	n := 0
+	for pkt := pkts.Front(); pkt != nil; pkt = pkt.Next() {
+		if err := e.WritePacket(r, gso, protocol, pkt); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// unregister implements upperLinkEndpoint.unregister.
+func (e *ipvlanEndpoint) unregister() {
+	p := e.parent
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var ipvlans []*ipvlanEndpoint
+	for _, ep := range p.mu.ipvlans {
+		if ep != e {
+			ipvlans = append(ipvlans, ep)
+		}
+	}
+	p.mu.ipvlans = ipvlans
+}
+
+// ipvlanDestination returns the address the packet with the network header
+// at the front of vv is for, along with the protocol of the address. For
+// address resolution packets, it is the address being resolved.
+func ipvlanDestination(protocol tcpip.NetworkProtocolNumber, vv *buffer.VectorisedView) (tcpip.NetworkProtocolNumber, tcpip.Address, bool) {
+	switch protocol {
+	case header.IPv4ProtocolNumber:
+		v, ok := vv.PullUp(header.IPv4MinimumSize)
+		if !ok {
+			return 0, "", false
+		}
+		return protocol, header.IPv4(v).DestinationAddress(), true
+	case header.ARPProtocolNumber:
+		v, ok := vv.PullUp(header.ARPSize)
+		if !ok {
+			return 0, "", false
+		}
+		return header.IPv4ProtocolNumber, tcpip.Address(header.ARP(v).ProtocolAddressTarget()), true
+	case header.IPv6ProtocolNumber:
+		v, ok := vv.PullUp(header.IPv6MinimumSize)
+		if !ok {
+			return 0, "", false
+		}
+		ip := header.IPv6(v)
+		dst := ip.DestinationAddress()
+		if !header.IsV6MulticastAddress(dst) || ip.TransportProtocol() != header.ICMPv6ProtocolNumber {
+			return protocol, dst, true
+		}
+		// Neighbor solicitations are sent to the solicited-node multicast
+		// address of their target.
+		v, ok = vv.PullUp(header.IPv6MinimumSize + header.ICMPv6NeighborSolicitMinimumSize)
+		if !ok {
+			return protocol, dst, true
+		}
+		icmp := header.ICMPv6(v[header.IPv6MinimumSize:])
+		if icmp.Type() != header.ICMPv6NeighborSolicit {
+			return protocol, dst, true
+		}
+		return protocol, header.NDPNeighborSolicit(icmp.MessageBody()).TargetAddress(), true
+	default:
+		return 0, "", false
+	}
+}
+
+// findIPVLAN returns the IPVLAN NIC among ipvlans with the address the packet
+// with the network header at the front of vv is for, or nil.
+func findIPVLAN(ipvlans []*ipvlanEndpoint, protocol tcpip.NetworkProtocolNumber, vv *buffer.VectorisedView) *ipvlanEndpoint {
+	if len(ipvlans) == 0 {
+		return nil
+	}
+	proto, addr, ok := ipvlanDestination(protocol, vv)
+	if !ok {
+		return nil
+	}
+	for _, ep := range ipvlans {
+		if ep.nic.hasAddress(proto, addr) {
+			return ep
+		}
+	}
+	return nil
+}
+
+// deliverIPVLAN handles pkt, a packet received by n, a NIC with the IPVLAN
+// NICs ipvlans. It returns true if the packet was delivered to one of the
+// IPVLAN NICs only, and false if it must also be handled by n.
+func (n *NIC) deliverIPVLAN(ipvlans []*ipvlanEndpoint, remote, local tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, pkt *PacketBuffer) bool {
+	if dst := findIPVLAN(ipvlans, protocol, &pkt.Data); dst != nil {
+		dst.deliver(remote, local, protocol, pkt)
+		return true
+	}
+
+	if header.IsMulticastEthernetAddress(local) {
+		for _, dst := range ipvlans {
+			if dst.mode == IPVLANModeL2 {
+				dst.deliver(remote, local, protocol, pkt.Clone())
+			}
+		}
+	}
+	return false
+}
+
+// CreateIPVLAN creates the IPVLAN NIC id on top of the NIC parent. The IPVLAN
+// NIC shares the link address of parent, through which it sends packets, and
+// receives the packets for its addresses received by parent.
+//
+// A NIC may not have both MACVLAN and IPVLAN NICs. The IPVLAN NIC is removed
+// when its parent is.
+func (s *Stack) CreateIPVLAN(id, parent tcpip.NICID, opts IPVLANOptions) *tcpip.Error {
+	switch opts.Mode {
+	case IPVLANModeL2, IPVLANModeL3:
+	default:
+		return tcpip.ErrInvalidOptionValue
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, err := s.addressedUpperParentLocked(parent)
+	if err != nil {
+		return err
+	}
+	p.mu.RLock()
+	hasMACVLANs := len(p.mu.macvlans) != 0
+	p.mu.RUnlock()
+	if hasMACVLANs {
+		return tcpip.ErrNotSupported
+	}
+
+	ep := &ipvlanEndpoint{
+		upperEndpoint: upperEndpoint{parent: p},
+		mode:          opts.Mode,
+	}
+	nic, err := s.createUpperNICLocked(id, ep, opts.UpperNICOptions)
+	if err != nil {
+		return err
+	}
+	ep.nic = nic
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// The slice is replaced rather than modified so that it may be used by
+	// NIC.DeliverNetworkPacket without holding p.mu.
+	ipvlans := make([]*ipvlanEndpoint, 0, len(p.mu.ipvlans)+1)
+	ipvlans = append(ipvlans, p.mu.ipvlans...)
+	p.mu.ipvlans = append(ipvlans, ep)
+	return nil
+}
@@ -0,0 +1,195 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"sync/atomic"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// vrfMTU is the MTU of VRF devices, as in Linux's drivers/net/vrf.c.
+const vrfMTU = 64 << 10
+
+// vrfEndpoint is the link endpoint of a VRF device. A VRF device never sends
+// nor receives packets itself: the packets of its routing domain are sent and
+// received by the NICs enslaved to it.
+type vrfEndpoint struct {
+	dispatcher NetworkDispatcher
+}
+
+// MTU implements LinkEndpoint.MTU.
+func (*vrfEndpoint) MTU() uint32 {
+	return vrfMTU
+}
+
+// MaxHeaderLength implements LinkEndpoint.MaxHeaderLength.
+func (*vrfEndpoint) MaxHeaderLength() uint16 {
+	return 0
+}
+
+// LinkAddress implements LinkEndpoint.LinkAddress.
+func (*vrfEndpoint) LinkAddress() tcpip.LinkAddress {
+	return ""
+}
+
+// WritePacket implements LinkEndpoint.WritePacket.
+func (*vrfEndpoint) WritePacket(*Route, *GSO, tcpip.NetworkProtocolNumber, *PacketBuffer) *tcpip.Error {
+	return tcpip.ErrNoRoute
+}
+
+// WritePackets implements LinkEndpoint.WritePackets.
+func (*vrfEndpoint) WritePackets(*Route, *GSO, PacketBufferList, tcpip.NetworkProtocolNumber) (int, *tcpip.Error) {
+	return 0, tcpip.ErrNoRoute
+}
+
+// Capabilities implements LinkEndpoint.Capabilities.
+func (*vrfEndpoint) Capabilities() LinkEndpointCapabilities {
+	return 0
+}
+
+// Attach implements LinkEndpoint.Attach.
+func (e *vrfEndpoint) Attach(dispatcher NetworkDispatcher) {
+	e.dispatcher = dispatcher
+}
+
+// IsAttached implements LinkEndpoint.IsAttached.
+func (e *vrfEndpoint) IsAttached() bool {
+	return e.dispatcher != nil
+}
+
+// Wait implements LinkEndpoint.Wait.
+func (*vrfEndpoint) Wait() {}
+
+// ARPHardwareType implements LinkEndpoint.ARPHardwareType.
+func (*vrfEndpoint) ARPHardwareType() header.ARPHardwareType {
+	return header.ARPHardwareNone
+}
+
+// AddHeader implements LinkEndpoint.AddHeader.
+func (*vrfEndpoint) AddHeader(tcpip.LinkAddress, tcpip.LinkAddress, tcpip.NetworkProtocolNumber, *PacketBuffer) {
+}
+
+// CreateVRF creates a VRF device with the provided id and name. As in Linux, a
+// VRF device is the L3 master device (l3mdev) of the NICs enslaved to it with
+// SetNICMaster, which form a routing domain of their own:
+//
+//   * The routes through the NICs of the domain are only used by the sockets
+//     bound to the VRF device, or to one of its NICs, with SO_BINDTODEVICE.
+//   * The packets received by the NICs of the domain are delivered to the
+//     sockets bound to the receiving NIC or to the VRF device, and to
+//     unbound sockets only if L3MasterDeviceAcceptOption is enabled.
+func (s *Stack) CreateVRF(id tcpip.NICID, name string) *tcpip.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.createNICLocked(id, &vrfEndpoint{}, NICOptions{Name: name}, true /* l3mdev */)
+}
+
+// SetNICMaster enslaves the NIC id to the VRF device master, or releases it
+// from its VRF device if master is 0.
+func (s *Stack) SetNICMaster(id, master tcpip.NICID) *tcpip.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nic, ok := s.nics[id]
+	if !ok {
+		return tcpip.ErrUnknownNICID
+	}
+	if nic.l3mdev {
+		// VRF devices can't be nested.
+		return tcpip.ErrNotSupported
+	}
+	if master != 0 {
+		m, ok := s.nics[master]
+		if !ok {
+			return tcpip.ErrUnknownNICID
+		}
+		if !m.l3mdev {
+			return tcpip.ErrInvalidOptionValue
+		}
+	}
+
+	atomic.StoreInt32(&nic.master, int32(master))
+	s.InvalidateRouteCaches()
+	return nil
+}
+
+// NICMaster returns the ID of the VRF device the NIC id is enslaved to, or 0
+// if it isn't enslaved.
+func (s *Stack) NICMaster(id tcpip.NICID) (tcpip.NICID, *tcpip.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nic, ok := s.nics[id]
+	if !ok {
+		return 0, tcpip.ErrUnknownNICID
+	}
+	if nic.l3mdev {
+		return 0, nil
+	}
+	return nic.l3MasterDevice(), nil
+}
+
+// IsVRF returns true if the NIC id is a VRF device.
+func (s *Stack) IsVRF(id tcpip.NICID) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nic, ok := s.nics[id]
+	return ok && nic.l3mdev
+}
+
+// l3MasterDeviceAccept returns true if the packets received by the NICs
+// enslaved to a VRF device are delivered to the sockets bound to no device.
+func (s *Stack) l3MasterDeviceAccept() bool {
+	return atomic.LoadUint32(&s.l3mdevAccept) != 0
+}
+
+// l3MasterDeviceRLocked returns the ID of the L3 master device of the NIC id,
+// as NIC.l3MasterDevice does, or 0 if there is no such NIC.
+//
+// Precondition: s.mu must be read locked.
+func (s *Stack) l3MasterDeviceRLocked(id tcpip.NICID) tcpip.NICID {
+	if nic, ok := s.nics[id]; ok {
+		return nic.l3MasterDevice()
+	}
+	return 0
+}
+
+// isVRFRLocked returns true if the NIC id is a VRF device.
+//
+// Precondition: s.mu must be read locked.
+func (s *Stack) isVRFRLocked(id tcpip.NICID) bool {
+	nic, ok := s.nics[id]
+	return ok && nic.l3mdev
+}
+
+// l3MasterDevice returns the ID of the L3 master device of n: n itself if it
+// is a VRF device, the VRF device it is enslaved to if any, and 0 otherwise.
+func (n *NIC) l3MasterDevice() tcpip.NICID {
+	if n.l3mdev {
+		return n.id
+	}
+	return tcpip.NICID(atomic.LoadInt32(&n.master))
+}
+
+// boundToDevice returns true if n may be used by a socket bound to the device
+// id, that is n is the device, or a NIC enslaved to it if it is a VRF device.
+// If id is 0, n must not belong to the routing domain of a VRF device.
+func (n *NIC) boundToDevice(id tcpip.NICID) bool {
+	return n.id == id || n.l3MasterDevice() == id
+}
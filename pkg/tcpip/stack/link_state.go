@@ -0,0 +1,103 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"sync/atomic"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+var _ CarrierDispatcher = (*NIC)(nil)
+
+// Carrier returns true if the link of the NIC is up. The carrier of a NIC is
+// independent of whether it is enabled, which is its administrative state.
+func (n *NIC) Carrier() bool {
+	return atomic.LoadUint32(&n.carrier) == 1
+}
+
+// DeliverCarrierChange implements CarrierDispatcher.DeliverCarrierChange.
+//
+// As in Linux, the dynamic neighbor entries are removed when the carrier is
+// lost, the network endpoints of an enabled NIC are told about the change, and
+// the permanent addresses are announced again when the carrier comes back. The
+// NICs layered on the NIC, like VLANs, share its carrier.
+func (n *NIC) DeliverCarrierChange(up bool) {
+	n.linkStateMu.Lock()
+	defer n.linkStateMu.Unlock()
+
+	var v uint32
+	if up {
+		v = 1
+	}
+	if atomic.SwapUint32(&n.carrier, v) == v {
+		return
+	}
+
+	if !up && n.neigh != nil {
+		n.neigh.clearDynamic()
+	}
+
+	if n.Enabled() {
+		for _, ep := range n.networkEndpoints {
+			if h, ok := ep.(CarrierChangeHandler); ok {
+				h.HandleCarrierChange(up)
+			}
+		}
+		if up {
+			n.announceAddresses()
+		}
+	}
+
+	if disp := n.stack.nicEventDisp; disp != nil {
+		disp.OnCarrierChanged(n.id, up)
+	}
+
+	var uppers []*NIC
+	n.stack.mu.RLock()
+	for _, upper := range n.stack.nics {
+		if ep, ok := upper.LinkEndpoint.(upperLinkEndpoint); ok && ep.parentNIC() == n {
+			uppers = append(uppers, upper)
+		}
+	}
+	n.stack.mu.RUnlock()
+	for _, upper := range uppers {
+		upper.DeliverCarrierChange(up)
+	}
+}
+
+// setAdminState enables or disables the NIC, and reports the change to the
+// NIC event dispatcher of the stack.
+func (n *NIC) setAdminState(up bool) *tcpip.Error {
+	n.linkStateMu.Lock()
+	defer n.linkStateMu.Unlock()
+
+	if n.Enabled() == up {
+		return nil
+	}
+
+	var err *tcpip.Error
+	if up {
+		err = n.enable()
+	} else {
+		n.disable()
+	}
+
+	// The NIC is enabled even if one of its network endpoints failed to be.
+	if disp := n.stack.nicEventDisp; disp != nil && n.Enabled() == up {
+		disp.OnAdminStateChanged(n.id, up)
+	}
+	return err
+}
@@ -46,6 +46,11 @@ type linkAddrCache struct {
 		sync.Mutex
 		table map[tcpip.FullAddress]*linkAddrEntry
 		lru   linkAddrEntryList
+
+		// static holds the entries added with addStatic. Static entries never
+		// expire, are not evicted and are not replaced by learned link
+		// addresses.
+		static map[tcpip.FullAddress]tcpip.LinkAddress
 	}
 }
 
@@ -134,11 +139,122 @@ func (c *linkAddrCache) add(k tcpip.FullAddress, v tcpip.LinkAddress) {
 	expiration := time.Now().Add(c.ageLimit)
 
 	c.cache.Lock()
+	defer c.cache.Unlock()
+	if _, ok := c.cache.static[k]; ok {
+		return
+	}
 	entry := c.getOrCreateEntryLocked(k)
 	entry.linkAddr = v
 
 	entry.changeState(ready, expiration)
-	c.cache.Unlock()
+}
+
+// addStatic adds a static k -> v mapping to the cache, replacing any mapping
+// that was learned for k.
+func (c *linkAddrCache) addStatic(k tcpip.FullAddress, v tcpip.LinkAddress) {
+	c.cache.Lock()
+	defer c.cache.Unlock()
+	c.cache.static[k] = v
+	if entry, ok := c.cache.table[k]; ok {
+		// Waiters are notified with the static link address.
+		entry.linkAddr = v
+		c.removeEntryLocked(entry, ready)
+	}
+}
+
+// remove removes the static or learned mapping for k from the cache.
+//
+// Returns true if a mapping was found.
+func (c *linkAddrCache) remove(k tcpip.FullAddress) bool {
+	c.cache.Lock()
+	defer c.cache.Unlock()
+	_, ok := c.cache.static[k]
+	delete(c.cache.static, k)
+	if entry, found := c.cache.table[k]; found {
+		c.removeEntryLocked(entry, failed)
+		ok = true
+	}
+	return ok
+}
+
+// failed returns true if the resolution of the link address of k failed and
+// hasn't expired yet.
+func (c *linkAddrCache) failed(k tcpip.FullAddress) bool {
+	c.cache.Lock()
+	defer c.cache.Unlock()
+	entry, ok := c.cache.table[k]
+	return ok && entry.s == failed && !time.Now().After(entry.expiration)
+}
+
+// removeNIC removes all the mappings of the specified NIC from the cache.
+func (c *linkAddrCache) removeNIC(nicID tcpip.NICID) {
+	c.cache.Lock()
+	defer c.cache.Unlock()
+	for k := range c.cache.static {
+		if k.NIC == nicID {
+			delete(c.cache.static, k)
+		}
+	}
+	for k, entry := range c.cache.table {
+		if k.NIC == nicID {
+			c.removeEntryLocked(entry, failed)
+		}
+	}
+}
+
+// removeEntryLocked removes entry from the cache, moving it to state s to
+// notify any waiters.
+//
+// Precondition: c.cache must be locked.
+func (c *linkAddrCache) removeEntryLocked(entry *linkAddrEntry, s entryState) {
+	delete(c.cache.table, entry.addr)
+	c.cache.lru.Remove(entry)
+	entry.changeState(s, time.Time{})
+}
+
+// entries returns the mappings of the specified NIC as neighbor entries.
+//
+// Learned mappings are reported as Reachable until they expire and as Stale
+// afterwards, as the cache does not perform Neighbor Unreachability Detection.
+func (c *linkAddrCache) entries(nicID tcpip.NICID) []NeighborEntry {
+	now := time.Now()
+
+	c.cache.Lock()
+	defer c.cache.Unlock()
+	var entries []NeighborEntry
+	for k, linkAddr := range c.cache.static {
+		if k.NIC == nicID {
+			entries = append(entries, NeighborEntry{
+				Addr:     k.Addr,
+				LinkAddr: linkAddr,
+				State:    Static,
+			})
+		}
+	}
+	for k, entry := range c.cache.table {
+		if k.NIC != nicID {
+			continue
+		}
+		neigh := NeighborEntry{
+			Addr:     k.Addr,
+			LinkAddr: entry.linkAddr,
+		}
+		switch entry.s {
+		case incomplete:
+			neigh.State = Incomplete
+		case ready:
+			neigh.State = Reachable
+			if now.After(entry.expiration) {
+				neigh.State = Stale
+			}
+		case failed:
+			neigh.State = Failed
+		default:
+			panic(fmt.Sprintf("invalid cache entry state: %s", entry.s))
+		}
+		entries = append(entries, neigh)
+	}
+	return entries
 }
 
 // getOrCreateEntryLocked retrieves a cache entry associated with k. The
@@ -189,6 +305,9 @@ func (c *linkAddrCache) get(k tcpip.FullAddress, linkRes LinkAddressResolver, lo
 
 	c.cache.Lock()
 	defer c.cache.Unlock()
+	if linkAddr, ok := c.cache.static[k]; ok {
+		return linkAddr, nil, nil
+	}
 	entry := c.getOrCreateEntryLocked(k)
 	switch s := entry.s; s {
 	case ready, failed:
@@ -291,5 +410,6 @@ func newLinkAddrCache(ageLimit, resolutionTimeout time.Duration, resolutionAttem
 		resolutionAttempts: resolutionAttempts,
 	}
 	c.cache.table = make(map[tcpip.FullAddress]*linkAddrEntry, linkAddrCacheSize)
+	c.cache.static = make(map[tcpip.FullAddress]tcpip.LinkAddress)
 	return c
 }
@@ -21,6 +21,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"gvisor.dev/gvisor/pkg/sleep"
 	"gvisor.dev/gvisor/pkg/sync"
 	"gvisor.dev/gvisor/pkg/tcpip"
@@ -283,6 +285,69 @@ func TestStaticResolution(t *testing.T) {
 	}
 }
 
+func TestCacheStaticEntries(t *testing.T) {
+	c := newLinkAddrCache(1<<63-1, time.Millisecond, 1)
+	linkRes := &testLinkAddressResolver{cache: c, delay: time.Minute}
+	e := testAddrs[0]
+	static := e.linkAddr + "static"
+
+	// Adding a static entry completes pending resolution.
+	_, ch, err := c.get(e.addr, linkRes, "", nil, nil)
+	if err != tcpip.ErrWouldBlock {
+		t.Fatalf("c.get(%q), got error: %v, want: error ErrWouldBlock", string(e.addr.Addr), err)
+	}
+	c.addStatic(e.addr, static)
+	select {
+	case <-ch:
+	default:
+		t.Fatal("expected pending resolution to complete")
+	}
+
+	// Static entries are not replaced by learned link addresses and do not
+	// expire.
+	c.add(e.addr, e.linkAddr)
+	time.Sleep(10 * time.Millisecond)
+	got, _, err := c.get(e.addr, linkRes, "", nil, nil)
+	if err != nil {
+		t.Errorf("c.get(%q)=%q, got error: %v", string(e.addr.Addr), got, err)
+	}
+	if got != static {
+		t.Errorf("c.get(%q)=%q, want %q", string(e.addr.Addr), got, static)
+	}
+
+	learned := testAddrs[1]
+	c.add(learned.addr, learned.linkAddr)
+	other := tcpip.FullAddress{NIC: 2, Addr: learned.addr.Addr}
+	c.addStatic(other, learned.linkAddr)
+	want := []NeighborEntry{
+		{Addr: e.addr.Addr, LinkAddr: static, State: Static},
+		{Addr: learned.addr.Addr, LinkAddr: learned.linkAddr, State: Reachable},
+	}
+	if diff := cmp.Diff(want, c.entries(e.addr.NIC), cmpopts.SortSlices(func(a, b NeighborEntry) bool {
+		return a.Addr < b.Addr
+	})); diff != "" {
+		t.Errorf("c.entries(%d) mismatch (-want +got):\n%s", e.addr.NIC, diff)
+	}
+
+	if !c.remove(e.addr) {
+		t.Errorf("c.remove(%#v) = false, want = true", e.addr)
+	}
+	if c.remove(e.addr) {
+		t.Errorf("c.remove(%#v) = true, want = false", e.addr)
+	}
+	if _, _, err := c.get(e.addr, nil, "", nil, nil); err != tcpip.ErrNoLinkAddress {
+		t.Errorf("c.get(%q), got error: %v, want: error ErrNoLinkAddress", string(e.addr.Addr), err)
+	}
+
+	c.removeNIC(e.addr.NIC)
+	if entries := c.entries(e.addr.NIC); len(entries) != 0 {
+		t.Errorf("got c.entries(%d) = %#v, want = []", e.addr.NIC, entries)
+	}
+	if entries := c.entries(other.NIC); len(entries) != 1 {
+		t.Errorf("got c.entries(%d) = %#v, want 1 entry", other.NIC, entries)
+	}
+}
+
 // TestCacheWaker verifies that RemoveWaker removes a waker previously added
 // through get().
 func TestCacheWaker(t *testing.T) {
@@ -0,0 +1,232 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// MACVLANMode is the mode of a MACVLAN NIC, which determines how it
+// communicates with the other MACVLAN NICs of its parent.
+type MACVLANMode int
+
+const (
+	// MACVLANModePrivate isolates the MACVLAN NIC from the other MACVLAN NICs
+	// of its parent, even if the external switch reflects their frames.
+	MACVLANModePrivate MACVLANMode = iota
+
+	// MACVLANModeVEPA sends all the frames of the MACVLAN NIC through the
+	// parent, including the ones for the other MACVLAN NICs of the parent
+	// which are only received if the external switch reflects them.
+	MACVLANModeVEPA
+
+	// MACVLANModeBridge delivers the frames for the other MACVLAN NICs of
+	// the parent in bridge mode directly, without sending them through the
+	// parent.
+	MACVLANModeBridge
+)
+
+// MACVLANOptions specify the details of a MACVLAN NIC.
+type MACVLANOptions struct {
+	UpperNICOptions
+
+	// LinkAddress is the link address of the NIC. It must be a unicast
+	// ethernet address which isn't used by the parent or its other MACVLAN
+	// NICs.
+	LinkAddress tcpip.LinkAddress
+
+	// Mode is the mode of the NIC.
+	Mode MACVLANMode
+}
+
+// macvlanEndpoint is the link endpoint of a MACVLAN NIC. It sends packets
+// with its own link address through the link endpoint of its parent NIC, and
+// receives the frames addressed to its link address from its parent NIC, as
+// well as the multicast and broadcast frames.
+type macvlanEndpoint struct {
+	upperEndpoint
+	linkAddr tcpip.LinkAddress
+	mode     MACVLANMode
+}
+
+var _ upperLinkEndpoint = (*macvlanEndpoint)(nil)
+
+// LinkAddress implements LinkEndpoint.LinkAddress.
+func (e *macvlanEndpoint) LinkAddress() tcpip.LinkAddress {
+	return e.linkAddr
+}
+
+// siblings returns the MACVLAN NICs of the parent of e.
+func (e *macvlanEndpoint) siblings() map[tcpip.LinkAddress]*macvlanEndpoint {
+	e.parent.mu.RLock()
+	defer e.parent.mu.RUnlock()
+	return e.parent.mu.macvlans
+}
+
+// WritePacket implements LinkEndpoint.WritePacket.
+func (e *macvlanEndpoint) WritePacket(r *Route, gso *GSO, protocol tcpip.NetworkProtocolNumber, pkt *PacketBuffer) *tcpip.Error {
+	if e.mode == MACVLANModeBridge {
+		remote := r.RemoteLinkAddress()
+		if header.IsMulticastEthernetAddress(remote) {
+			for _, s := range e.siblings() {
+				if s != e && s.mode == MACVLANModeBridge {
+					s.deliver(e.linkAddr, remote, protocol, pkt.CloneToInbound())
+				}
+			}
+		} else if s, ok := e.siblings()[remote]; ok && s.mode == MACVLANModeBridge {
+			s.deliver(e.linkAddr, remote, protocol, pkt.CloneToInbound())
+			return nil
+		}
+	}
+	return e.parent.LinkEndpoint.WritePacket(r, gso, protocol, pkt)
+}
+
+// WritePackets implements LinkEndpoint.WritePackets.
+func (e *macvlanEndpoint) WritePackets(r *Route, gso *GSO, pkts PacketBufferList, protocol tcpip.NetworkProtocolNumber) (int, *tcpip.Error) {
+	n := 0
+	for pkt := pkts.Front(); pkt != nil; pkt = pkt.Next() {
+		if err := e.WritePacket(r, gso, protocol, pkt); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// unregister implements upperLinkEndpoint.unregister.
+func (e *macvlanEndpoint) unregister() {
+	p := e.parent
+	p.mu.Lock()
+	macvlans := make(map[tcpip.LinkAddress]*macvlanEndpoint, len(p.mu.macvlans))
+	for addr, ep := range p.mu.macvlans {
+		if ep != e {
+			macvlans[addr] = ep
+		}
+	}
+	if len(macvlans) == 0 {
+		macvlans = nil
+	}
+	p.mu.macvlans = macvlans
+	p.mu.Unlock()
+
+	if f, ok := p.LinkEndpoint.(LinkAddressFilter); ok {
+		f.RemoveFilterAddress(e.linkAddr)
+	}
+}
+
+// deliverMACVLAN handles pkt, a frame received by n, a NIC with the MACVLAN
+// NICs macvlans. It returns true if the frame was delivered to one of the
+// MACVLAN NICs only, and false if it must also be handled by n.
+func (n *NIC) deliverMACVLAN(macvlans map[tcpip.LinkAddress]*macvlanEndpoint, remote, local tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, pkt *PacketBuffer) bool {
+	// src is set if the frame was sent by one of the MACVLAN NICs and
+	// reflected by the external switch.
+	src := macvlans[remote]
+
+	if !header.IsMulticastEthernetAddress(local) {
+		dst, ok := macvlans[local]
+		if !ok {
+			return false
+		}
+		if src != nil && (src.mode == MACVLANModePrivate || dst.mode == MACVLANModePrivate) {
+			// Drop the frames between private MACVLAN NICs.
+			return true
+		}
+		dst.deliver(remote, local, protocol, pkt)
+		return true
+	}
+
+	for _, dst := range macvlans {
+		if src != nil {
+			// The frames reflected by the external switch were already
+			// delivered to the MACVLAN NICs in bridge mode when they were sent
+			// by a MACVLAN NIC in bridge mode, and must not reach private
+			// MACVLAN NICs.
+			if dst == src || dst.mode == MACVLANModePrivate || src.mode == MACVLANModePrivate {
+				continue
+			}
+			if src.mode == MACVLANModeBridge && dst.mode == MACVLANModeBridge {
+				continue
+			}
+		}
+		dst.deliver(remote, local, protocol, pkt.Clone())
+	}
+	return false
+}
+
+// CreateMACVLAN creates the MACVLAN NIC id on top of the NIC parent. The
+// MACVLAN NIC has a link address of its own, with which it sends packets
+// through parent, and receives the frames addressed to it as well as the
+// multicast and broadcast frames received by parent.
+//
+// A NIC may not have both MACVLAN and IPVLAN NICs. The MACVLAN NIC is removed
+// when its parent is.
+func (s *Stack) CreateMACVLAN(id, parent tcpip.NICID, opts MACVLANOptions) *tcpip.Error {
+	if !header.IsValidUnicastEthernetAddress(opts.LinkAddress) {
+		return tcpip.ErrBadAddress
+	}
+	switch opts.Mode {
+	case MACVLANModePrivate, MACVLANModeVEPA, MACVLANModeBridge:
+	default:
+		return tcpip.ErrInvalidOptionValue
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, err := s.addressedUpperParentLocked(parent)
+	if err != nil {
+		return err
+	}
+	if opts.LinkAddress == p.LinkEndpoint.LinkAddress() {
+		return tcpip.ErrDuplicateAddress
+	}
+
+	p.mu.RLock()
+	_, dup := p.mu.macvlans[opts.LinkAddress]
+	hasIPVLANs := len(p.mu.ipvlans) != 0
+	p.mu.RUnlock()
+	if dup {
+		return tcpip.ErrDuplicateAddress
+	}
+	if hasIPVLANs {
+		return tcpip.ErrNotSupported
+	}
+
+	ep := &macvlanEndpoint{
+		upperEndpoint: upperEndpoint{parent: p},
+		linkAddr:      opts.LinkAddress,
+		mode:          opts.Mode,
+	}
+	if _, err := s.createUpperNICLocked(id, ep, opts.UpperNICOptions); err != nil {
+		return err
+	}
+
+	if f, ok := p.LinkEndpoint.(LinkAddressFilter); ok {
+		f.AddFilterAddress(opts.LinkAddress)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// The map is replaced rather than modified so that it may be used by
+	// NIC.DeliverNetworkPacket without holding p.mu.
+	macvlans := make(map[tcpip.LinkAddress]*macvlanEndpoint, len(p.mu.macvlans)+1)
+	for addr, ep := range p.mu.macvlans {
+		macvlans[addr] = ep
+	}
+	macvlans[opts.LinkAddress] = ep
+	p.mu.macvlans = macvlans
+	return nil
+}
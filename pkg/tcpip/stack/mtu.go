@@ -0,0 +1,155 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"sync/atomic"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// MTUChangeHandler is implemented by the transport endpoints which adapt to the
+// MTU changes of the NICs, such as TCP endpoints lowering their maximum
+// segment size.
+type MTUChangeHandler interface {
+	// HandleMTUChanged is called when the MTU of the NIC nicID changes to
+	// mtu.
+	HandleMTUChanged(nicID tcpip.NICID, mtu uint32)
+}
+
+// SetNICMTU sets the MTU of the NIC id, like ip link set mtu. mtu may not
+// exceed the MTU of the link endpoint of the NIC, nor be smaller than the
+// minimum MTU of IPv4. If mtu is 0, the MTU of the link endpoint is used.
+//
+// The MTU of the NICs layered on the NIC, like VLANs, is capped by the MTU of
+// the NIC. The routes through the NICs whose MTU changes send packets of the
+// new size right away, the TCP connections adjust their maximum segment size,
+// and the path MTUs learned for the destinations routed through them are
+// forgotten if the MTU of the NIC limits them.
+func (s *Stack) SetNICMTU(id tcpip.NICID, mtu uint32) *tcpip.Error {
+	s.mu.RLock()
+	nic, ok := s.nics[id]
+	s.mu.RUnlock()
+	if !ok {
+		return tcpip.ErrUnknownNICID
+	}
+	if mtu != 0 && (mtu < header.IPv4MinimumMTU || mtu > nic.LinkEndpoint.MTU()) {
+		return tcpip.ErrInvalidOptionValue
+	}
+
+	s.mtuMu.Lock()
+	defer s.mtuMu.Unlock()
+
+	s.mu.RLock()
+	nics := append([]*NIC{nic}, s.upperNICsRLocked(nic)...)
+	s.mu.RUnlock()
+	old := make([]uint32, len(nics))
+	for i, n := range nics {
+		old[i] = n.MTU()
+	}
+
+	atomic.StoreUint32(&nic.mtu, mtu)
+
+	for i, n := range nics {
+		if mtu := n.MTU(); mtu != old[i] {
+			s.nicMTUChanged(n.id, old[i], mtu)
+		}
+	}
+	return nil
+}
+
+// MTU returns the MTU of the NIC: the MTU set at runtime, if any, or the MTU of
+// its link endpoint.
+func (n *NIC) MTU() uint32 {
+	linkMTU := n.LinkEndpoint.MTU()
+	if mtu := atomic.LoadUint32(&n.mtu); mtu != 0 && mtu < linkMTU {
+		return mtu
+	}
+	return linkMTU
+}
+
+// upperNICsRLocked returns the NICs layered on nic, directly or not.
+//
+// Precondition: s.mu must be read locked.
+func (s *Stack) upperNICsRLocked(nic *NIC) []*NIC {
+	var uppers []*NIC
+	for _, n := range s.nics {
+		if ep, ok := n.LinkEndpoint.(upperLinkEndpoint); ok && ep.parentNIC() == nic {
+			uppers = append(uppers, n)
+			uppers = append(uppers, s.upperNICsRLocked(n)...)
+		}
+	}
+	return uppers
+}
+
+// nicMTUChanged propagates the change of the MTU of the NIC id from old to mtu.
+//
+// Precondition: s.mtuMu must be locked.
+func (s *Stack) nicMTUChanged(id tcpip.NICID, old, mtu uint32) {
+	// As in Linux, the path MTUs which are no smaller than the lowest of the
+	// two MTUs are forgotten: they are either made redundant by the new MTU,
+	// or were capped by the former one.
+	limit := mtu
+	if old < limit {
+		limit = old
+	}
+	s.mu.RLock()
+	c := &s.pathMTUs
+	c.mu.Lock()
+	for k, e := range c.entries {
+		if e.mtu >= limit && s.routedThroughRLocked(id, k.addr) {
+			c.removeLocked(k)
+		}
+	}
+	c.mu.Unlock()
+	s.mu.RUnlock()
+
+	for _, ep := range s.RegisteredEndpoints() {
+		if h, ok := ep.(MTUChangeHandler); ok {
+			h.HandleMTUChanged(id, mtu)
+		}
+	}
+
+	if disp := s.nicEventDisp; disp != nil {
+		disp.OnMTUChanged(id, mtu)
+	}
+}
+
+// routedThroughRLocked returns true if the packets sent to addr are routed
+// through the NIC id by any of the route tables.
+//
+// Precondition: s.mu must be read locked.
+func (s *Stack) routedThroughRLocked(id tcpip.NICID, addr tcpip.Address) bool {
+	routes := s.routeTable()
+	for _, table := range routes.tables(len(addr)) {
+		// All the next hops of the multipath route found in the route table
+		// may be used.
+		var first *tcpip.Route
+		for _, e := range routes.match(table, addr) {
+			route := &e.route
+			if first != nil && (first.Weight == 0 || route.Weight == 0 || first.Destination != route.Destination) {
+				continue
+			}
+			if route.NIC == id {
+				return true
+			}
+			if first == nil {
+				first = route
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"math/bits"
+	"sync/atomic"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/hash/jenkins"
+)
+
+// multipathHash returns the hash of the flow of the packets sent from src to
+// dst with the attributes key, which selects the next hop of the multipath
+// routes they are sent through. The hashed fields depend on the multipath hash
+// policy of the stack.
+func (s *Stack) multipathHash(src, dst tcpip.Address, key RoutePolicyKey) uint32 {
+	h := jenkins.Sum32(s.seed)
+	h.Write([]byte(src))
+	h.Write([]byte(dst))
+	if MultipathHashPolicyOption(atomic.LoadUint32(&s.multipathHashPolicy)) == MultipathHashL4 {
+		h.Write([]byte{
+			byte(key.TransportProtocol),
+			byte(key.SrcPort >> 8),
+			byte(key.SrcPort),
+			byte(key.DstPort >> 8),
+			byte(key.DstPort),
+		})
+	}
+	return h.Sum32()
+}
+
+// multipathNextHopRLocked returns the row of the multipath route, whose first
+// row is rows[0], holding the next hop selected by hash for the packets sent
+// to dst, or false if none of its rows may be used. The other rows of the
+// route are those of rows, which are in order, to the same destination.
+//
+// The rows whose NIC isn't enabled or for which usable returns false are
+// ignored. So are the rows whose next hop is known to be unreachable, unless
+// all of them are, like Linux does with the fib_multipath_use_neigh sysctl.
+//
+// Precondition: s.mu must be read locked.
+func (s *Stack) multipathNextHopRLocked(rows []*routeEntry, dst tcpip.Address, hash uint32, usable func(*NIC) bool) (tcpip.Route, bool) {
+	type nextHop struct {
+		route  tcpip.Route
+		failed bool
+	}
+	var (
+		hops             []nextHop
+		total, reachable uint64
+	)
+	route := rows[0].route
+	for _, e := range rows {
+		r := e.route
+		if r.Weight == 0 || r.TableID() != route.TableID() || r.Destination != route.Destination {
+			continue
+		}
+		nic, ok := s.nics[r.NIC]
+		if !ok || !nic.Enabled() || !usable(nic) {
+			continue
+		}
+		neighbor := r.Gateway
+		if len(neighbor) == 0 {
+			neighbor = dst
+		}
+		failed := len(neighbor) != 0 && nic.neighborFailed(neighbor)
+		hops = append(hops, nextHop{route: r, failed: failed})
+		total += uint64(r.Weight)
+		if !failed {
+			reachable += uint64(r.Weight)
+		}
+	}
+	if len(hops) == 0 {
+		return tcpip.Route{}, false
+	}
+
+	// If all the next hops failed, the route is used as if none did.
+	allFailed := reachable == 0
+	if !allFailed {
+		total = reachable
+	}
+
+	// Select the next hop which owns the slot of the hash in the sequence of
+	// the slots of the next hops, sized after their weight.
+	slot, _ := bits.Mul64(uint64(hash)<<32, total)
+	for _, hop := range hops {
+		if hop.failed && !allFailed {
+			continue
+		}
+		if slot < uint64(hop.route.Weight) {
+			return hop.route, true
+		}
+		slot -= uint64(hop.route.Weight)
+	}
+	panic("unreachable: the slot of the hash is owned by no next hop")
+}
+
+// containsSubnet returns true if subnets contains subnet.
+func containsSubnet(subnets []tcpip.Subnet, subnet tcpip.Subnet) bool {
+	for _, s := range subnets {
+		if s == subnet {
+			return true
+		}
+	}
+	return false
+}
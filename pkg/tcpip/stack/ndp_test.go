@@ -97,7 +97,7 @@ func prefixSubnetAddr(offset uint8, linkAddr tcpip.LinkAddress) (tcpip.AddressWi
 }
 
 // ndpDADEvent is a set of parameters that was passed to
-// ndpDispatcher.OnDuplicateAddressDetectionStatus.
+// ndpDispatcher.OnDuplicateAddressDetectionResult.
 type ndpDADEvent struct {
 	nicID    tcpip.NICID
 	addr     tcpip.Address
@@ -154,6 +154,13 @@ type ndpDHCPv6Event struct {
 	configuration ipv6.DHCPv6ConfigurationFromNDPRA
 }
 
+type ndpRedirectEvent struct {
+	nicID      tcpip.NICID
+	routerAddr tcpip.Address
+	dst        tcpip.Address
+	target     tcpip.Address
+}
+
 var _ ipv6.NDPDispatcher = (*ndpDispatcher)(nil)
 
 // ndpDispatcher implements NDPDispatcher so tests can know when various NDP
@@ -169,18 +176,26 @@ type ndpDispatcher struct {
 	dnsslC               chan ndpDNSSLEvent
 	routeTable           []tcpip.Route
 	dhcpv6ConfigurationC chan ndpDHCPv6Event
+	redirectC            chan ndpRedirectEvent
 }
 
-// Implements ipv6.NDPDispatcher.OnDuplicateAddressDetectionStatus.
-func (n *ndpDispatcher) OnDuplicateAddressDetectionStatus(nicID tcpip.NICID, addr tcpip.Address, resolved bool, err *tcpip.Error) {
-	if n.dadC != nil {
-		n.dadC <- ndpDADEvent{
-			nicID,
-			addr,
-			resolved,
-			err,
-		}
+// Implements stack.DADDispatcher.OnDuplicateAddressDetectionResult.
+func (n *ndpDispatcher) OnDuplicateAddressDetectionResult(nicID tcpip.NICID, addr tcpip.Address, res stack.DADResult) {
+	if n.dadC == nil {
+		return
+	}
+
+	e := ndpDADEvent{
+		nicID: nicID,
+		addr:  addr,
 	}
+	switch res := res.(type) {
+	case *stack.DADSucceeded:
+		e.resolved = true
+	case *stack.DADError:
+		e.err = res.Err
+	}
+	n.dadC <- e
 }
 
 // Implements ipv6.NDPDispatcher.OnDefaultRouterDiscovered.
@@ -296,6 +311,18 @@ func (n *ndpDispatcher) OnDHCPv6Configuration(nicID tcpip.NICID, configuration i
 	}
 }
 
+// Implements ipv6.NDPDispatcher.OnRedirectAccepted.
+func (n *ndpDispatcher) OnRedirectAccepted(nicID tcpip.NICID, routerAddr, dst, target tcpip.Address) {
+	if c := n.redirectC; c != nil {
+		c <- ndpRedirectEvent{
+			nicID,
+			routerAddr,
+			dst,
+			target,
+		}
+	}
+}
+
 // channelLinkWithHeaderLength is a channel.Endpoint with a configurable
 // header length.
 type channelLinkWithHeaderLength struct {
@@ -708,6 +735,58 @@ func TestDADFail(t *testing.T) {
 	}
 }
 
+// TestDADFailWithAddProtocolAddressWithDAD tests that the stack's DAD
+// dispatcher is notified when DAD fails for an address added with
+// AddProtocolAddressWithDAD and that the address is removed.
+func TestDADFailWithAddProtocolAddressWithDAD(t *testing.T) {
+	const nicID = 1
+
+	ndpDisp := ndpDispatcher{
+		dadC: make(chan ndpDADEvent, 2),
+	}
+	ndpConfigs := ipv6.DefaultNDPConfigurations()
+	ndpConfigs.RetransmitTimer = time.Second * 2
+
+	e := channel.New(0, 1280, linkAddr1)
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv6.NewProtocolWithOptions(ipv6.Options{
+			NDPDisp:    &ndpDisp,
+			NDPConfigs: ndpConfigs,
+		})},
+		DADDisp: &ndpDisp,
+	})
+	if err := s.CreateNIC(nicID, e); err != nil {
+		t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+	}
+
+	protocolAddr := tcpip.ProtocolAddress{
+		Protocol:          header.IPv6ProtocolNumber,
+		AddressWithPrefix: addr1.WithPrefix(),
+	}
+	if err := s.AddProtocolAddressWithDAD(nicID, protocolAddr, stack.CanBePrimaryEndpoint); err != nil {
+		t.Fatalf("AddProtocolAddressWithDAD(%d, %+v, %d) = %s", nicID, protocolAddr, stack.CanBePrimaryEndpoint, err)
+	}
+
+	// Receive a packet to simulate an address conflict.
+	rxNDPSolicit(e, addr1)
+
+	// Both the NDP dispatcher and the stack's DAD dispatcher should be notified
+	// of the failure.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-time.After(time.Duration(ndpConfigs.DupAddrDetectTransmits)*ndpConfigs.RetransmitTimer + time.Second):
+			t.Fatal("timed out waiting for DAD failure")
+		case e := <-ndpDisp.dadC:
+			if diff := checkDADEvent(e, nicID, addr1, false, nil); diff != "" {
+				t.Errorf("dad event mismatch (-want +got):\n%s", diff)
+			}
+		}
+	}
+	if err := s.RemoveAddress(nicID, addr1); err != tcpip.ErrBadLocalAddress {
+		t.Errorf("got RemoveAddress(%d, %s) = %v, want = %s", nicID, addr1, err, tcpip.ErrBadLocalAddress)
+	}
+}
+
 func TestDADStop(t *testing.T) {
 	const nicID = 1
 
@@ -1180,6 +1259,288 @@ func TestRouterDiscoveryDispatcherNoRemember(t *testing.T) {
 	}
 }
 
+var _ ipv6.RouterAdvertGuard = (*raGuard)(nil)
+
+// raGuard is an ipv6.RouterAdvertGuard that only accepts Router Advertisements
+// from a single router.
+type raGuard struct {
+	allowedRouter tcpip.Address
+	calls         int
+}
+
+// Implements ipv6.RouterAdvertGuard.ShouldAcceptRouterAdvert.
+func (g *raGuard) ShouldAcceptRouterAdvert(_ tcpip.NICID, srcAddr tcpip.Address, _ tcpip.LinkAddress, _ header.NDPRouterAdvert) bool {
+	g.calls++
+	return srcAddr == g.allowedRouter
+}
+
+func TestRouterAdvertGuard(t *testing.T) {
+	const nicID = 1
+
+	ndpDisp := ndpDispatcher{
+		routerC:        make(chan ndpRouterEvent, 1),
+		rememberRouter: true,
+	}
+	guard := raGuard{allowedRouter: llAddr2}
+	e := channel.New(0, 1280, linkAddr1)
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv6.NewProtocolWithOptions(ipv6.Options{
+			NDPConfigs: ipv6.NDPConfigurations{
+				HandleRAs:              true,
+				DiscoverDefaultRouters: true,
+			},
+			NDPDisp: &ndpDisp,
+			RAGuard: &guard,
+		})},
+	})
+
+	if err := s.CreateNIC(nicID, e); err != nil {
+		t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+	}
+
+	rejected := s.Stats().IP.NDP.RouterAdvertsRejected
+
+	// An RA from a router rejected by the guard should not be processed.
+	e.InjectInbound(header.IPv6ProtocolNumber, raBuf(llAddr3, 1000))
+	select {
+	case e := <-ndpDisp.routerC:
+		t.Fatalf("unexpected router event = %+v", e)
+	default:
+	}
+	if got := rejected.Value(); got != 1 {
+		t.Errorf("got rejected.Value() = %d, want = 1", got)
+	}
+
+	// An RA from a router accepted by the guard should be processed.
+	e.InjectInbound(header.IPv6ProtocolNumber, raBuf(llAddr2, 1000))
+	select {
+	case e := <-ndpDisp.routerC:
+		if diff := checkRouterEvent(e, llAddr2, true); diff != "" {
+			t.Errorf("router event mismatch (-want +got):\n%s", diff)
+		}
+	default:
+		t.Fatal("expected router discovery event")
+	}
+	if got := rejected.Value(); got != 1 {
+		t.Errorf("got rejected.Value() = %d, want = 1", got)
+	}
+
+	if guard.calls != 2 {
+		t.Errorf("got guard.calls = %d, want = 2", guard.calls)
+	}
+}
+
+// redirectBuf returns a valid NDP Redirect message sent by src, redirecting
+// packets to dst to target.
+func redirectBuf(src, target, dst tcpip.Address, optSer header.NDPOptionsSerializer) *stack.PacketBuffer {
+	icmpSize := header.ICMPv6HeaderSize + header.NDPRedirectMinimumSize + int(optSer.Length())
+	hdr := buffer.NewPrependable(header.IPv6MinimumSize + icmpSize)
+	pkt := header.ICMPv6(hdr.Prepend(icmpSize))
+	pkt.SetType(header.ICMPv6RedirectMsg)
+	pkt.SetCode(0)
+	rd := header.NDPRedirect(pkt.MessageBody())
+	rd.SetTargetAddress(target)
+	rd.SetDestinationAddress(dst)
+	rd.Options().Serialize(optSer)
+	pkt.SetChecksum(header.ICMPv6Checksum(pkt, src, llAddr1, buffer.VectorisedView{}))
+	payloadLength := hdr.UsedLength()
+	iph := header.IPv6(hdr.Prepend(header.IPv6MinimumSize))
+	iph.Encode(&header.IPv6Fields{
+		PayloadLength: uint16(payloadLength),
+		NextHeader:    uint8(icmp.ProtocolNumber6),
+		HopLimit:      header.NDPHopLimit,
+		SrcAddr:       src,
+		DstAddr:       llAddr1,
+	})
+
+	return stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: hdr.View().ToVectorisedView(),
+	})
+}
+
+// TestRedirect tests that valid Redirect messages sent by the current first-hop
+// for a destination update the first-hop used to reach that destination until
+// the redirect expires.
+func TestRedirect(t *testing.T) {
+	const (
+		nicID            = 1
+		redirectLifetime = time.Minute
+	)
+
+	ndpDisp := ndpDispatcher{
+		redirectC: make(chan ndpRedirectEvent, 1),
+	}
+	clock := faketime.NewManualClock()
+	e := channel.New(0, 1280, linkAddr1)
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv6.NewProtocolWithOptions(ipv6.Options{
+			NDPConfigs: ipv6.NDPConfigurations{
+				HandleRedirects:  true,
+				RedirectLifetime: redirectLifetime,
+			},
+			NDPDisp: &ndpDisp,
+		})},
+		Clock: clock,
+	})
+
+	if err := s.CreateNIC(nicID, e); err != nil {
+		t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+	}
+	for _, addr := range []tcpip.Address{llAddr1, addr1} {
+		if err := s.AddAddress(nicID, ipv6.ProtocolNumber, addr); err != nil {
+			t.Fatalf("AddAddress(%d, %d, %s) = %s", nicID, ipv6.ProtocolNumber, addr, err)
+		}
+	}
+	s.SetRouteTable([]tcpip.Route{{
+		Destination: header.IPv6EmptySubnet,
+		Gateway:     llAddr2,
+		NIC:         nicID,
+	}})
+
+	expectNextHop := func(want tcpip.Address) {
+		t.Helper()
+
+		r, err := s.FindRoute(nicID, "", dstAddr.Addr, ipv6.ProtocolNumber, false /* multicastLoop */)
+		if err != nil {
+			t.Fatalf("FindRoute(%d, '', %s, %d, false) = %s", nicID, dstAddr.Addr, ipv6.ProtocolNumber, err)
+		}
+		defer r.Release()
+		if r.NextHop != want {
+			t.Errorf("got r.NextHop = %s, want = %s", r.NextHop, want)
+		}
+	}
+
+	expectRedirectEvent := func(routerAddr, target tcpip.Address) {
+		t.Helper()
+
+		select {
+		case e := <-ndpDisp.redirectC:
+			want := ndpRedirectEvent{nicID: nicID, routerAddr: routerAddr, dst: dstAddr.Addr, target: target}
+			if diff := cmp.Diff(want, e, cmp.AllowUnexported(e)); diff != "" {
+				t.Errorf("redirect event mismatch (-want +got):\n%s", diff)
+			}
+		default:
+			t.Fatal("expected redirect event")
+		}
+	}
+
+	expectNoRedirectEvent := func() {
+		t.Helper()
+
+		select {
+		case e := <-ndpDisp.redirectC:
+			t.Fatalf("unexpected redirect event = %+v", e)
+		default:
+		}
+	}
+
+	ignored := s.Stats().IP.NDP.RedirectsIgnored
+	invalid := s.Stats().ICMP.V6.PacketsReceived.Invalid
+
+	expectNextHop(llAddr2)
+
+	// A redirect from a node that is not the current first-hop for the
+	// destination should be ignored.
+	e.InjectInbound(header.IPv6ProtocolNumber, redirectBuf(llAddr3, llAddr3, dstAddr.Addr, nil))
+	expectNoRedirectEvent()
+	expectNextHop(llAddr2)
+	if got := ignored.Value(); got != 1 {
+		t.Errorf("got ignored.Value() = %d, want = 1", got)
+	}
+
+	// A redirect to a target that is neither link-local nor the destination is
+	// invalid.
+	e.InjectInbound(header.IPv6ProtocolNumber, redirectBuf(llAddr2, addr3, dstAddr.Addr, nil))
+	expectNoRedirectEvent()
+	expectNextHop(llAddr2)
+	if got := invalid.Value(); got != 1 {
+		t.Errorf("got invalid.Value() = %d, want = 1", got)
+	}
+
+	// A redirect from the current first-hop should update the first-hop for the
+	// destination.
+	e.InjectInbound(header.IPv6ProtocolNumber, redirectBuf(llAddr2, llAddr3, dstAddr.Addr, header.NDPOptionsSerializer{
+		header.NDPTargetLinkLayerAddressOption(linkAddr3),
+	}))
+	expectRedirectEvent(llAddr2, llAddr3)
+	expectNextHop(llAddr3)
+
+	// The redirected first-hop may redirect the destination again, this time to
+	// the destination itself which makes it a neighbor.
+	clock.Advance(redirectLifetime / 2)
+	e.InjectInbound(header.IPv6ProtocolNumber, redirectBuf(llAddr3, dstAddr.Addr, dstAddr.Addr, nil))
+	expectRedirectEvent(llAddr3, dstAddr.Addr)
+	expectNextHop("")
+
+	// The second redirect should have refreshed the lifetime.
+	clock.Advance(redirectLifetime / 2)
+	expectNextHop("")
+
+	// The redirect should be forgotten once its lifetime expires.
+	clock.Advance(redirectLifetime / 2)
+	expectNextHop(llAddr2)
+
+	if got := ignored.Value(); got != 1 {
+		t.Errorf("got ignored.Value() = %d, want = 1", got)
+	}
+	if got := invalid.Value(); got != 1 {
+		t.Errorf("got invalid.Value() = %d, want = 1", got)
+	}
+}
+
+// TestRedirectIgnoredWhenForwarding tests that Redirect messages are not acted
+// on when the stack is acting as a router.
+func TestRedirectIgnoredWhenForwarding(t *testing.T) {
+	const nicID = 1
+
+	ndpDisp := ndpDispatcher{
+		redirectC: make(chan ndpRedirectEvent, 1),
+	}
+	e := channel.New(0, 1280, linkAddr1)
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv6.NewProtocolWithOptions(ipv6.Options{
+			NDPConfigs: ipv6.NDPConfigurations{
+				HandleRedirects: true,
+			},
+			NDPDisp: &ndpDisp,
+		})},
+	})
+	s.SetForwarding(ipv6.ProtocolNumber, true)
+
+	if err := s.CreateNIC(nicID, e); err != nil {
+		t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+	}
+	for _, addr := range []tcpip.Address{llAddr1, addr1} {
+		if err := s.AddAddress(nicID, ipv6.ProtocolNumber, addr); err != nil {
+			t.Fatalf("AddAddress(%d, %d, %s) = %s", nicID, ipv6.ProtocolNumber, addr, err)
+		}
+	}
+	s.SetRouteTable([]tcpip.Route{{
+		Destination: header.IPv6EmptySubnet,
+		Gateway:     llAddr2,
+		NIC:         nicID,
+	}})
+
+	e.InjectInbound(header.IPv6ProtocolNumber, redirectBuf(llAddr2, llAddr3, dstAddr.Addr, nil))
+	select {
+	case e := <-ndpDisp.redirectC:
+		t.Fatalf("unexpected redirect event = %+v", e)
+	default:
+	}
+	if got := s.Stats().IP.NDP.RedirectsIgnored.Value(); got != 1 {
+		t.Errorf("got RedirectsIgnored.Value() = %d, want = 1", got)
+	}
+
+	r, err := s.FindRoute(nicID, "", dstAddr.Addr, ipv6.ProtocolNumber, false /* multicastLoop */)
+	if err != nil {
+		t.Fatalf("FindRoute(%d, '', %s, %d, false) = %s", nicID, dstAddr.Addr, ipv6.ProtocolNumber, err)
+	}
+	defer r.Release()
+	if r.NextHop != llAddr2 {
+		t.Errorf("got r.NextHop = %s, want = %s", r.NextHop, llAddr2)
+	}
+}
+
 func TestRouterDiscovery(t *testing.T) {
 	ndpDisp := ndpDispatcher{
 		routerC:        make(chan ndpRouterEvent, 1),
@@ -1328,6 +1689,87 @@ func TestRouterDiscoveryMaxRouters(t *testing.T) {
 			}
 		}
 	}
+
+	if got := s.Stats().IP.NDP.DefaultRoutersIgnored.Value(); got != 2 {
+		t.Errorf("got DefaultRoutersIgnored = %d, want = 2", got)
+	}
+}
+
+// TestNDPIgnoredStats tests that Router Advertisements and Prefix Information
+// options that are ignored are counted.
+func TestNDPIgnoredStats(t *testing.T) {
+	const nicID = 1
+
+	globalPrefix, _, _ := prefixSubnetAddr(0, "")
+	linkLocalPrefix := tcpip.AddressWithPrefix{Address: header.LinkLocalAddr(linkAddr2), PrefixLen: 64}
+	zeroLengthPrefix := tcpip.AddressWithPrefix{Address: globalPrefix.Address}
+	shortPrefix := tcpip.AddressWithPrefix{Address: globalPrefix.Address, PrefixLen: 48}
+
+	tests := []struct {
+		name      string
+		handleRAs bool
+		pkt       *stack.PacketBuffer
+		stat      func(tcpip.NDPStats) *tcpip.StatCounter
+	}{
+		{
+			name:      "Not handling RAs",
+			handleRAs: false,
+			pkt:       raBuf(llAddr2, 1000),
+			stat:      func(s tcpip.NDPStats) *tcpip.StatCounter { return s.RouterAdvertsIgnored },
+		},
+		{
+			name:      "Link-local prefix",
+			handleRAs: true,
+			pkt:       raBufWithPI(llAddr2, 0, linkLocalPrefix, true, true, 100, 100),
+			stat:      func(s tcpip.NDPStats) *tcpip.StatCounter { return s.LinkLocalPrefixesIgnored },
+		},
+		{
+			name:      "Zero length prefix",
+			handleRAs: true,
+			pkt:       raBufWithPI(llAddr2, 0, zeroLengthPrefix, true, true, 100, 100),
+			stat:      func(s tcpip.NDPStats) *tcpip.StatCounter { return s.ZeroLengthPrefixesIgnored },
+		},
+		{
+			name:      "Preferred lifetime greater than valid lifetime",
+			handleRAs: true,
+			pkt:       raBufWithPI(llAddr2, 0, globalPrefix, false, true, 100, 200),
+			stat:      func(s tcpip.NDPStats) *tcpip.StatCounter { return s.InvalidPrefixLifetimesIgnored },
+		},
+		{
+			name:      "SLAAC prefix length",
+			handleRAs: true,
+			pkt:       raBufWithPI(llAddr2, 0, shortPrefix, false, true, 100, 100),
+			stat:      func(s tcpip.NDPStats) *tcpip.StatCounter { return s.InvalidSLAACPrefixLengthsIgnored },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := channel.New(0, 1280, linkAddr1)
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{ipv6.NewProtocolWithOptions(ipv6.Options{
+					NDPConfigs: ipv6.NDPConfigurations{
+						HandleRAs:              test.handleRAs,
+						DiscoverDefaultRouters: true,
+						DiscoverOnLinkPrefixes: true,
+						AutoGenGlobalAddresses: true,
+					},
+				})},
+			})
+			if err := s.CreateNIC(nicID, e); err != nil {
+				t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+			}
+
+			stat := test.stat(s.Stats().IP.NDP)
+			e.InjectInbound(header.IPv6ProtocolNumber, test.pkt)
+			if got := stat.Value(); got != 1 {
+				t.Errorf("got stat = %d, want = 1", got)
+			}
+			if got := s.NICInfo()[nicID].ProtocolAddresses; len(got) != 0 {
+				t.Errorf("got NICInfo()[%d].ProtocolAddresses = %v, want = []", nicID, got)
+			}
+		})
+	}
 }
 
 // TestNoPrefixDiscovery tests that prefix discovery will not be performed if
@@ -1621,6 +2063,77 @@ func TestPrefixDiscoveryWithInfiniteLifetime(t *testing.T) {
 	expectPrefixEvent(subnet, false)
 }
 
+// TestInstallDiscoveredRoutes tests that routes are added to the route table
+// for discovered default routers and on-link prefixes, and removed when they
+// are invalidated.
+func TestInstallDiscoveredRoutes(t *testing.T) {
+	const (
+		nicID           = 1
+		lifetimeSeconds = 10
+	)
+
+	prefix, subnet, _ := prefixSubnetAddr(0, "")
+	clock := faketime.NewManualClock()
+	e := channel.New(0, 1280, linkAddr1)
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv6.NewProtocolWithOptions(ipv6.Options{
+			NDPConfigs: ipv6.NDPConfigurations{
+				HandleRAs:               true,
+				DiscoverDefaultRouters:  true,
+				DiscoverOnLinkPrefixes:  true,
+				InstallDiscoveredRoutes: true,
+			},
+		})},
+		Clock: clock,
+	})
+	if err := s.CreateNIC(nicID, e); err != nil {
+		t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+	}
+
+	// The discovered default routers take precedence over the static routes
+	// with a higher metric.
+	staticRoute := tcpip.Route{Destination: header.IPv6EmptySubnet, Gateway: llAddr3, NIC: nicID}
+	backupRoute := tcpip.Route{Destination: header.IPv6EmptySubnet, Gateway: llAddr4, NIC: nicID, Metric: ipv6.DefaultRouterRouteMetric + 1}
+	s.SetRouteTable([]tcpip.Route{staticRoute, backupRoute})
+
+	checkRoutes := func(want []tcpip.Route) {
+		t.Helper()
+
+		// Routes are updated asynchronously.
+		clock.Advance(0)
+		if diff := cmp.Diff(want, s.GetRouteTable()); diff != "" {
+			t.Errorf("route table mismatch (-want +got):\n%s", diff)
+		}
+	}
+
+	// The route to the on-link prefix should take precedence over the less
+	// specific default routes.
+	e.InjectInbound(header.IPv6ProtocolNumber, raBufWithPI(llAddr2, lifetimeSeconds, prefix, true /* onLink */, false /* auto */, lifetimeSeconds, 0))
+	routerRoute := tcpip.Route{Destination: header.IPv6EmptySubnet, Gateway: llAddr2, NIC: nicID, Metric: ipv6.DefaultRouterRouteMetric}
+	prefixRoute := tcpip.Route{Destination: subnet, NIC: nicID, Metric: ipv6.OnLinkPrefixRouteMetric}
+	checkRoutes([]tcpip.Route{prefixRoute, staticRoute, routerRoute, backupRoute})
+
+	// Refreshing the router and prefix should not add duplicate routes.
+	e.InjectInbound(header.IPv6ProtocolNumber, raBufWithPI(llAddr2, lifetimeSeconds, prefix, true /* onLink */, false /* auto */, lifetimeSeconds, 0))
+	checkRoutes([]tcpip.Route{prefixRoute, staticRoute, routerRoute, backupRoute})
+
+	// Rx an RA with a zero router lifetime to invalidate the router.
+	e.InjectInbound(header.IPv6ProtocolNumber, raBuf(llAddr2, 0))
+	checkRoutes([]tcpip.Route{prefixRoute, staticRoute, backupRoute})
+
+	// The route to the prefix should be removed when the prefix expires.
+	clock.Advance(lifetimeSeconds * time.Second)
+	checkRoutes([]tcpip.Route{staticRoute, backupRoute})
+
+	// Routes should be removed when the NIC is disabled.
+	e.InjectInbound(header.IPv6ProtocolNumber, raBufWithPI(llAddr2, lifetimeSeconds, prefix, true /* onLink */, false /* auto */, lifetimeSeconds, 0))
+	checkRoutes([]tcpip.Route{prefixRoute, staticRoute, routerRoute, backupRoute})
+	if err := s.DisableNIC(nicID); err != nil {
+		t.Fatalf("s.DisableNIC(%d): %s", nicID, err)
+	}
+	checkRoutes([]tcpip.Route{staticRoute, backupRoute})
+}
+
 // TestPrefixDiscoveryMaxRouters tests that only
 // ipv6.MaxDiscoveredOnLinkPrefixes discovered on-link prefixes are remembered.
 func TestPrefixDiscoveryMaxOnLinkPrefixes(t *testing.T) {
@@ -1687,6 +2200,176 @@ func TestPrefixDiscoveryMaxOnLinkPrefixes(t *testing.T) {
 	}
 }
 
+func TestStaticAddressLifetimes(t *testing.T) {
+	const (
+		nicID             = 1
+		preferredLifetime = 10 * time.Second
+		validLifetime     = 20 * time.Second
+	)
+
+	_, _, addr1 := prefixSubnetAddr(0, linkAddr1)
+	_, _, addr2 := prefixSubnetAddr(0, linkAddr2)
+	clock := faketime.NewManualClock()
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv6.NewProtocol},
+		Clock:            clock,
+	})
+	if err := s.CreateNIC(nicID, channel.New(0, 1280, linkAddr1)); err != nil {
+		t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+	}
+
+	protocolAddr1 := tcpip.ProtocolAddress{Protocol: header.IPv6ProtocolNumber, AddressWithPrefix: addr1}
+	protocolAddr2 := tcpip.ProtocolAddress{Protocol: header.IPv6ProtocolNumber, AddressWithPrefix: addr2}
+	lifetimes := stack.AddressLifetimes{PreferredLifetime: preferredLifetime, ValidLifetime: validLifetime}
+	if err := s.AddProtocolAddressWithLifetimes(nicID, protocolAddr1, stack.CanBePrimaryEndpoint, lifetimes); err != nil {
+		t.Fatalf("s.AddProtocolAddressWithLifetimes(%d, %+v, %d, %+v): %s", nicID, protocolAddr1, stack.CanBePrimaryEndpoint, lifetimes, err)
+	}
+	if err := s.AddProtocolAddress(nicID, protocolAddr2); err != nil {
+		t.Fatalf("s.AddProtocolAddress(%d, %+v): %s", nicID, protocolAddr2, err)
+	}
+
+	expectPrimaryAddr := func(addr tcpip.AddressWithPrefix) {
+		t.Helper()
+
+		if got, err := s.GetMainNICAddress(nicID, header.IPv6ProtocolNumber); err != nil {
+			t.Fatalf("s.GetMainNICAddress(%d, %d): %s", nicID, header.IPv6ProtocolNumber, err)
+		} else if got != addr {
+			t.Errorf("got s.GetMainNICAddress(%d, %d) = %s, want = %s", nicID, header.IPv6ProtocolNumber, got, addr)
+		}
+	}
+	expectPrimaryAddr(addr1)
+
+	// The address should be deprecated when its preferred lifetime expires.
+	clock.Advance(preferredLifetime)
+	expectPrimaryAddr(addr2)
+	if !containsV6Addr(s.NICInfo()[nicID].ProtocolAddresses, addr1) {
+		t.Fatalf("should have %s in the list of addresses", addr1)
+	}
+
+	// Refreshing the lifetimes should undeprecate the address and extend its
+	// valid lifetime.
+	if err := s.SetAddressLifetimes(nicID, header.IPv6ProtocolNumber, addr1.Address, lifetimes); err != nil {
+		t.Fatalf("s.SetAddressLifetimes(%d, %d, %s, %+v): %s", nicID, header.IPv6ProtocolNumber, addr1.Address, lifetimes, err)
+	}
+	expectPrimaryAddr(addr1)
+	clock.Advance(validLifetime - time.Nanosecond)
+	expectPrimaryAddr(addr2)
+	if !containsV6Addr(s.NICInfo()[nicID].ProtocolAddresses, addr1) {
+		t.Fatalf("should have %s in the list of addresses", addr1)
+	}
+
+	// The address should be removed when its valid lifetime expires.
+	clock.Advance(time.Nanosecond)
+	if containsV6Addr(s.NICInfo()[nicID].ProtocolAddresses, addr1) {
+		t.Fatalf("should not have %s in the list of addresses", addr1)
+	}
+
+	// Addresses added without lifetimes should never expire until lifetimes are
+	// set.
+	clock.Advance(time.Hour)
+	if !containsV6Addr(s.NICInfo()[nicID].ProtocolAddresses, addr2) {
+		t.Fatalf("should have %s in the list of addresses", addr2)
+	}
+	zeroPreferred := stack.AddressLifetimes{ValidLifetime: validLifetime}
+	if err := s.SetAddressLifetimes(nicID, header.IPv6ProtocolNumber, addr2.Address, zeroPreferred); err != nil {
+		t.Fatalf("s.SetAddressLifetimes(%d, %d, %s, %+v): %s", nicID, header.IPv6ProtocolNumber, addr2.Address, zeroPreferred, err)
+	}
+	clock.Advance(validLifetime)
+	if containsV6Addr(s.NICInfo()[nicID].ProtocolAddresses, addr2) {
+		t.Fatalf("should not have %s in the list of addresses", addr2)
+	}
+
+	// Removing an address should stop its lifetime jobs so that re-adding the
+	// address does not inherit them.
+	if err := s.AddProtocolAddressWithLifetimes(nicID, protocolAddr1, stack.CanBePrimaryEndpoint, lifetimes); err != nil {
+		t.Fatalf("s.AddProtocolAddressWithLifetimes(%d, %+v, %d, %+v): %s", nicID, protocolAddr1, stack.CanBePrimaryEndpoint, lifetimes, err)
+	}
+	if err := s.RemoveAddress(nicID, addr1.Address); err != nil {
+		t.Fatalf("s.RemoveAddress(%d, %s): %s", nicID, addr1.Address, err)
+	}
+	if err := s.AddProtocolAddress(nicID, protocolAddr1); err != nil {
+		t.Fatalf("s.AddProtocolAddress(%d, %+v): %s", nicID, protocolAddr1, err)
+	}
+	clock.Advance(validLifetime)
+	if !containsV6Addr(s.NICInfo()[nicID].ProtocolAddresses, addr1) {
+		t.Fatalf("should have %s in the list of addresses", addr1)
+	}
+}
+
+func TestStaticAddressLifetimesErrors(t *testing.T) {
+	const nicID = 1
+
+	_, _, addr1 := prefixSubnetAddr(0, linkAddr1)
+	_, _, addr2 := prefixSubnetAddr(0, linkAddr2)
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv6.NewProtocol},
+	})
+	if err := s.CreateNIC(nicID, channel.New(0, 1280, linkAddr1)); err != nil {
+		t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+	}
+	protocolAddr1 := tcpip.ProtocolAddress{Protocol: header.IPv6ProtocolNumber, AddressWithPrefix: addr1}
+
+	tests := []struct {
+		name      string
+		nicID     tcpip.NICID
+		addr      tcpip.Address
+		lifetimes stack.AddressLifetimes
+		want      *tcpip.Error
+	}{
+		{
+			name:      "Zero valid lifetime",
+			nicID:     nicID,
+			addr:      addr1.Address,
+			lifetimes: stack.AddressLifetimes{},
+			want:      tcpip.ErrInvalidOptionValue,
+		},
+		{
+			name:      "Preferred lifetime greater than valid lifetime",
+			nicID:     nicID,
+			addr:      addr1.Address,
+			lifetimes: stack.AddressLifetimes{PreferredLifetime: 2 * time.Second, ValidLifetime: time.Second},
+			want:      tcpip.ErrInvalidOptionValue,
+		},
+		{
+			name:      "Unknown NIC",
+			nicID:     nicID + 1,
+			addr:      addr1.Address,
+			lifetimes: stack.AddressLifetimes{PreferredLifetime: time.Second, ValidLifetime: time.Second},
+			want:      tcpip.ErrUnknownNICID,
+		},
+		{
+			name:      "Unknown address",
+			nicID:     nicID,
+			addr:      addr2.Address,
+			lifetimes: stack.AddressLifetimes{PreferredLifetime: time.Second, ValidLifetime: time.Second},
+			want:      tcpip.ErrBadLocalAddress,
+		},
+	}
+
+	if err := s.AddProtocolAddress(nicID, protocolAddr1); err != nil {
+		t.Fatalf("s.AddProtocolAddress(%d, %+v): %s", nicID, protocolAddr1, err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := s.SetAddressLifetimes(test.nicID, header.IPv6ProtocolNumber, test.addr, test.lifetimes); err != test.want {
+				t.Errorf("got s.SetAddressLifetimes(%d, %d, %s, %+v) = %s, want = %s", test.nicID, header.IPv6ProtocolNumber, test.addr, test.lifetimes, err, test.want)
+			}
+		})
+	}
+
+	// Failing to set lifetimes on a new address should not leave the address
+	// behind.
+	protocolAddr2 := tcpip.ProtocolAddress{Protocol: header.IPv6ProtocolNumber, AddressWithPrefix: addr2}
+	invalid := stack.AddressLifetimes{}
+	if err := s.AddProtocolAddressWithLifetimes(nicID, protocolAddr2, stack.CanBePrimaryEndpoint, invalid); err != tcpip.ErrInvalidOptionValue {
+		t.Errorf("got s.AddProtocolAddressWithLifetimes(%d, %+v, %d, %+v) = %s, want = %s", nicID, protocolAddr2, stack.CanBePrimaryEndpoint, invalid, err, tcpip.ErrInvalidOptionValue)
+	}
+	if containsV6Addr(s.NICInfo()[nicID].ProtocolAddresses, addr2) {
+		t.Errorf("should not have %s in the list of addresses", addr2)
+	}
+}
+
 // Checks to see if list contains an IPv6 address, item.
 func containsV6Addr(list []tcpip.ProtocolAddress, item tcpip.AddressWithPrefix) bool {
 	protocolAddress := tcpip.ProtocolAddress{
@@ -1925,8 +2608,8 @@ func TestAutoGenTempAddr(t *testing.T) {
 				seed := []byte{uint8(i)}
 				var tempIIDHistory [header.IIDSize]byte
 				header.InitialTempIID(tempIIDHistory[:], seed, nicID)
-				newTempAddr := func(stableAddr tcpip.Address) tcpip.AddressWithPrefix {
-					return header.GenerateTempIPv6SLAACAddr(tempIIDHistory[:], stableAddr)
+				newTempAddr := func(subnet tcpip.Subnet) tcpip.AddressWithPrefix {
+					return header.GenerateTempIPv6SLAACAddr(tempIIDHistory[:], subnet)
 				}
 
 				ndpDisp := ndpDispatcher{
@@ -2016,7 +2699,7 @@ func TestAutoGenTempAddr(t *testing.T) {
 
 				// Receive an RA with prefix1 in an NDP Prefix Information option (PI)
 				// with non-zero valid & preferred lifetimes.
-				tempAddr1 := newTempAddr(addr1.Address)
+				tempAddr1 := newTempAddr(addr1.Subnet())
 				e.InjectInbound(header.IPv6ProtocolNumber, raBufWithPI(llAddr2, 0, prefix1, true, true, 100, 100))
 				expectAutoGenAddrEvent(tempAddr1, newAddr)
 				expectDADEventAsync(tempAddr1.Address)
@@ -2038,7 +2721,7 @@ func TestAutoGenTempAddr(t *testing.T) {
 
 				// Receive an RA with prefix2 in a PI w/ non-zero valid and preferred
 				// lifetimes.
-				tempAddr2 := newTempAddr(addr2.Address)
+				tempAddr2 := newTempAddr(addr2.Subnet())
 				e.InjectInbound(header.IPv6ProtocolNumber, raBufWithPI(llAddr2, 0, prefix2, true, true, 100, 100))
 				expectAutoGenAddrEvent(addr2, newAddr)
 				expectDADEventAsync(addr2.Address)
@@ -2226,7 +2909,7 @@ func TestNoAutoGenTempAddrWithoutStableAddr(t *testing.T) {
 	prefix, _, addr := prefixSubnetAddr(0, linkAddr1)
 	var tempIIDHistory [header.IIDSize]byte
 	header.InitialTempIID(tempIIDHistory[:], nil, nicID)
-	tempAddr := header.GenerateTempIPv6SLAACAddr(tempIIDHistory[:], addr.Address)
+	tempAddr := header.GenerateTempIPv6SLAACAddr(tempIIDHistory[:], addr.Subnet())
 
 	ndpDisp := ndpDispatcher{
 		dadC:         make(chan ndpDADEvent, 1),
@@ -2278,16 +2961,93 @@ func TestNoAutoGenTempAddrWithoutStableAddr(t *testing.T) {
 		if diff := checkDADEvent(e, nicID, addr.Address, true, nil); diff != "" {
 			t.Errorf("dad event mismatch (-want +got):\n%s", diff)
 		}
-	case <-time.After(dadTransmits*retransmitTimer + defaultAsyncPositiveEventTimeout):
-		t.Fatal("timed out waiting for DAD event")
+	case <-time.After(dadTransmits*retransmitTimer + defaultAsyncPositiveEventTimeout):
+		t.Fatal("timed out waiting for DAD event")
+	}
+	select {
+	case e := <-ndpDisp.autoGenAddrC:
+		if diff := checkAutoGenAddrEvent(e, tempAddr, newAddr); diff != "" {
+			t.Errorf("auto-gen addr event mismatch (-want +got):\n%s", diff)
+		}
+	case <-time.After(defaultAsyncPositiveEventTimeout):
+		t.Fatal("timed out waiting for addr auto gen event")
+	}
+}
+
+// TestAutoGenTempAddrExcludedPrefixes tests that temporary SLAAC addresses are
+// not generated for excluded prefixes.
+func TestAutoGenTempAddrExcludedPrefixes(t *testing.T) {
+	const nicID = 1
+
+	savedMaxDesyncFactor := ipv6.MaxDesyncFactor
+	defer func() {
+		ipv6.MaxDesyncFactor = savedMaxDesyncFactor
+	}()
+	ipv6.MaxDesyncFactor = 0
+
+	ulaPrefix := tcpip.AddressWithPrefix{
+		Address:   tcpip.Address("\xfd\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00"),
+		PrefixLen: 64,
+	}
+	ulaAddr := addrForSubnet(ulaPrefix.Subnet(), linkAddr1)
+	globalPrefix, globalSubnet, globalAddr := prefixSubnetAddr(0, linkAddr1)
+	var tempIIDHistory [header.IIDSize]byte
+	header.InitialTempIID(tempIIDHistory[:], nil, nicID)
+	tempAddr := header.GenerateTempIPv6SLAACAddr(tempIIDHistory[:], globalSubnet)
+
+	ulaSubnet, err := tcpip.NewSubnet("\xfc"+tcpip.Address(make([]byte, header.IPv6AddressSize-1)), "\xfe"+tcpip.AddressMask(make([]byte, header.IPv6AddressSize-1)))
+	if err != nil {
+		t.Fatalf("tcpip.NewSubnet(_, _): %s", err)
+	}
+
+	ndpDisp := ndpDispatcher{
+		autoGenAddrC: make(chan ndpAutoGenAddrEvent, 2),
+	}
+	e := channel.New(0, 1280, linkAddr1)
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv6.NewProtocolWithOptions(ipv6.Options{
+			NDPConfigs: ipv6.NDPConfigurations{
+				HandleRAs:                  true,
+				AutoGenGlobalAddresses:     true,
+				AutoGenTempGlobalAddresses: true,
+				TempAddrExcludedPrefixes:   []tcpip.Subnet{ulaSubnet},
+			},
+			NDPDisp: &ndpDisp,
+		})},
+	})
+	if err := s.CreateNIC(nicID, e); err != nil {
+		t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+	}
+
+	expectAutoGenAddrEvent := func(addr tcpip.AddressWithPrefix) {
+		t.Helper()
+
+		select {
+		case e := <-ndpDisp.autoGenAddrC:
+			if diff := checkAutoGenAddrEvent(e, addr, newAddr); diff != "" {
+				t.Errorf("auto-gen addr event mismatch (-want +got):\n%s", diff)
+			}
+		default:
+			t.Fatal("expected addr auto gen event")
+		}
 	}
+
+	// Only a stable address should be generated for the unique local prefix.
+	e.InjectInbound(header.IPv6ProtocolNumber, raBufWithPI(llAddr2, 0, ulaPrefix, true, true, 100, 100))
+	expectAutoGenAddrEvent(ulaAddr)
 	select {
 	case e := <-ndpDisp.autoGenAddrC:
-		if diff := checkAutoGenAddrEvent(e, tempAddr, newAddr); diff != "" {
-			t.Errorf("auto-gen addr event mismatch (-want +got):\n%s", diff)
-		}
-	case <-time.After(defaultAsyncPositiveEventTimeout):
-		t.Fatal("timed out waiting for addr auto gen event")
+		t.Fatalf("unexpected auto gen addr event = %+v", e)
+	default:
+	}
+
+	// Both stable and temporary addresses should be generated for the global
+	// prefix.
+	e.InjectInbound(header.IPv6ProtocolNumber, raBufWithPI(llAddr2, 0, globalPrefix, true, true, 100, 100))
+	expectAutoGenAddrEvent(globalAddr)
+	expectAutoGenAddrEvent(tempAddr)
+	if mismatch := addressCheck(s.NICInfo()[nicID].ProtocolAddresses, []tcpip.AddressWithPrefix{ulaAddr, globalAddr, tempAddr}, nil); mismatch != "" {
+		t.Fatal(mismatch)
 	}
 }
 
@@ -2316,9 +3076,9 @@ func TestAutoGenTempAddrRegen(t *testing.T) {
 	prefix, _, addr := prefixSubnetAddr(0, linkAddr1)
 	var tempIIDHistory [header.IIDSize]byte
 	header.InitialTempIID(tempIIDHistory[:], nil, nicID)
-	tempAddr1 := header.GenerateTempIPv6SLAACAddr(tempIIDHistory[:], addr.Address)
-	tempAddr2 := header.GenerateTempIPv6SLAACAddr(tempIIDHistory[:], addr.Address)
-	tempAddr3 := header.GenerateTempIPv6SLAACAddr(tempIIDHistory[:], addr.Address)
+	tempAddr1 := header.GenerateTempIPv6SLAACAddr(tempIIDHistory[:], addr.Subnet())
+	tempAddr2 := header.GenerateTempIPv6SLAACAddr(tempIIDHistory[:], addr.Subnet())
+	tempAddr3 := header.GenerateTempIPv6SLAACAddr(tempIIDHistory[:], addr.Subnet())
 
 	ndpDisp := ndpDispatcher{
 		autoGenAddrC: make(chan ndpAutoGenAddrEvent, 2),
@@ -2465,9 +3225,9 @@ func TestAutoGenTempAddrRegenJobUpdates(t *testing.T) {
 	prefix, _, addr := prefixSubnetAddr(0, linkAddr1)
 	var tempIIDHistory [header.IIDSize]byte
 	header.InitialTempIID(tempIIDHistory[:], nil, nicID)
-	tempAddr1 := header.GenerateTempIPv6SLAACAddr(tempIIDHistory[:], addr.Address)
-	tempAddr2 := header.GenerateTempIPv6SLAACAddr(tempIIDHistory[:], addr.Address)
-	tempAddr3 := header.GenerateTempIPv6SLAACAddr(tempIIDHistory[:], addr.Address)
+	tempAddr1 := header.GenerateTempIPv6SLAACAddr(tempIIDHistory[:], addr.Subnet())
+	tempAddr2 := header.GenerateTempIPv6SLAACAddr(tempIIDHistory[:], addr.Subnet())
+	tempAddr3 := header.GenerateTempIPv6SLAACAddr(tempIIDHistory[:], addr.Subnet())
 
 	ndpDisp := ndpDispatcher{
 		autoGenAddrC: make(chan ndpAutoGenAddrEvent, 2),
@@ -2618,13 +3378,10 @@ func TestMixedSLAACAddrConflictRegen(t *testing.T) {
 			Address:   tcpip.Address(header.AppendOpaqueInterfaceIdentifier(addrBytes[:header.IIDOffsetInIPv6Address], subnet, nicName, uint8(i), nil)),
 			PrefixLen: header.IIDOffsetInIPv6Address * 8,
 		}
-		// When generating temporary addresses, the resolved stable address for the
-		// SLAAC prefix will be the first address stable address generated for the
-		// prefix as we will not simulate address conflicts for the stable addresses
-		// in tests involving temporary addresses. Address conflicts for stable
-		// addresses will be done in their own tests.
-		tempAddrsWithOpaqueIID[i] = header.GenerateTempIPv6SLAACAddr(tempIIDHistoryWithOpaqueIID[:], stableAddrsWithOpaqueIID[0].Address)
-		tempAddrsWithModifiedEUI64[i] = header.GenerateTempIPv6SLAACAddr(tempIIDHistoryWithModifiedEUI64[:], stableAddrWithModifiedEUI64.Address)
+		// Temporary addresses are generated independently of the stable address
+		// for the SLAAC prefix.
+		tempAddrsWithOpaqueIID[i] = header.GenerateTempIPv6SLAACAddr(tempIIDHistoryWithOpaqueIID[:], subnet)
+		tempAddrsWithModifiedEUI64[i] = header.GenerateTempIPv6SLAACAddr(tempIIDHistoryWithModifiedEUI64[:], subnet)
 	}
 
 	tests := []struct {
@@ -3862,6 +4619,158 @@ func TestAutoGenAddrWithOpaqueIID(t *testing.T) {
 	}
 }
 
+// TestAutoGenAddrWithStablePrivacyIID tests that SLAAC generated addresses use
+// the built-in RFC 7217 opaque interface identifier generator when configured
+// to do so, and that DAD conflicts are resolved without the integrator
+// providing a NIC name function.
+func TestAutoGenAddrWithStablePrivacyIID(t *testing.T) {
+	const nicID = 1
+	const dadTransmits = 1
+	const retransmitTimer = time.Second
+
+	var secretKeyBuf [header.OpaqueIIDSecretKeyMinBytes]byte
+	secretKey := secretKeyBuf[:]
+	if _, err := rand.Read(secretKey); err != nil {
+		t.Fatalf("rand.Read(_): %s", err)
+	}
+
+	prefix, subnet, eui64Addr := prefixSubnetAddr(0, linkAddr1)
+
+	addrForSubnet := func(iidName string, dadCounter uint8) tcpip.AddressWithPrefix {
+		addrBytes := []byte(subnet.ID())
+		return tcpip.AddressWithPrefix{
+			Address:   tcpip.Address(header.AppendOpaqueInterfaceIdentifier(addrBytes[:header.IIDOffsetInIPv6Address], subnet, iidName, dadCounter, secretKey)),
+			PrefixLen: 64,
+		}
+	}
+
+	tests := []struct {
+		name      string
+		nicName   string
+		secretKey []byte
+
+		// iidName is the NIC name expected to be used when generating the IID.
+		//
+		// Empty if the expected address cannot be computed by the test (the
+		// stack generated its own secret key).
+		iidName string
+	}{
+		{
+			name:      "Named NIC",
+			nicName:   "eth0",
+			secretKey: secretKey,
+			iidName:   "eth0",
+		},
+		{
+			name:      "Unnamed NIC",
+			secretKey: secretKey,
+			iidName:   "nic1",
+		},
+		{
+			name:    "Stack generated secret key",
+			nicName: "eth0",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ndpDisp := ndpDispatcher{
+				dadC:         make(chan ndpDADEvent, 1),
+				autoGenAddrC: make(chan ndpAutoGenAddrEvent, 2),
+			}
+			clock := faketime.NewManualClock()
+			e := channel.New(0, 1280, linkAddr1)
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{ipv6.NewProtocolWithOptions(ipv6.Options{
+					NDPConfigs: ipv6.NDPConfigurations{
+						DupAddrDetectTransmits:        dadTransmits,
+						RetransmitTimer:               retransmitTimer,
+						HandleRAs:                     true,
+						AutoGenGlobalAddresses:        true,
+						AutoGenAddressConflictRetries: 1,
+						AutoGenStablePrivacyAddresses: true,
+					},
+					NDPDisp: &ndpDisp,
+					OpaqueIIDOpts: ipv6.OpaqueInterfaceIdentifierOptions{
+						SecretKey: test.secretKey,
+					},
+				})},
+				Clock: clock,
+			})
+			opts := stack.NICOptions{Name: test.nicName}
+			if err := s.CreateNICWithOptions(nicID, e, opts); err != nil {
+				t.Fatalf("CreateNICWithOptions(%d, _, %+v) = %s", nicID, opts, err)
+			}
+
+			expectNewAddr := func(dadCounter uint8) tcpip.AddressWithPrefix {
+				t.Helper()
+
+				select {
+				case e := <-ndpDisp.autoGenAddrC:
+					if e.eventType != newAddr {
+						t.Fatalf("got auto-gen addr event type = %d, want = %d", e.eventType, newAddr)
+					}
+					if test.iidName != "" {
+						if diff := checkAutoGenAddrEvent(e, addrForSubnet(test.iidName, dadCounter), newAddr); diff != "" {
+							t.Errorf("auto-gen addr event mismatch (-want +got):\n%s", diff)
+						}
+					}
+					if !subnet.Contains(e.addr.Address) {
+						t.Errorf("got new address %s, want address in %s", e.addr, subnet)
+					}
+					if e.addr == eui64Addr {
+						t.Errorf("got new address %s, want an opaque IID", e.addr)
+					}
+					return e.addr
+				default:
+					t.Fatal("expected addr auto gen event")
+				}
+				return tcpip.AddressWithPrefix{}
+			}
+
+			e.InjectInbound(header.IPv6ProtocolNumber, raBufWithPI(llAddr2, 0, prefix, true, true, 100, 100))
+			addr := expectNewAddr(0)
+
+			// Simulate a DAD conflict; a different address should be generated for
+			// the prefix.
+			rxNDPSolicit(e, addr.Address)
+			select {
+			case e := <-ndpDisp.autoGenAddrC:
+				if diff := checkAutoGenAddrEvent(e, addr, invalidatedAddr); diff != "" {
+					t.Errorf("auto-gen addr event mismatch (-want +got):\n%s", diff)
+				}
+			default:
+				t.Fatal("expected addr auto gen event")
+			}
+			select {
+			case e := <-ndpDisp.dadC:
+				if diff := checkDADEvent(e, nicID, addr.Address, false, nil); diff != "" {
+					t.Errorf("dad event mismatch (-want +got):\n%s", diff)
+				}
+			default:
+				t.Fatal("expected DAD event")
+			}
+
+			regenAddr := expectNewAddr(1)
+			if regenAddr == addr {
+				t.Fatalf("got regenerated address = %s, want a different address", regenAddr)
+			}
+			clock.Advance(dadTransmits * retransmitTimer)
+			select {
+			case e := <-ndpDisp.dadC:
+				if diff := checkDADEvent(e, nicID, regenAddr.Address, true, nil); diff != "" {
+					t.Errorf("dad event mismatch (-want +got):\n%s", diff)
+				}
+			default:
+				t.Fatal("expected DAD event")
+			}
+			if !containsV6Addr(s.NICInfo()[nicID].ProtocolAddresses, regenAddr) {
+				t.Fatalf("should have %s in the list of addresses", regenAddr)
+			}
+		})
+	}
+}
+
 func TestAutoGenAddrInResponseToDADConflicts(t *testing.T) {
 	const nicID = 1
 	const nicName = "nic"
@@ -4012,7 +4921,7 @@ func TestAutoGenAddrInResponseToDADConflicts(t *testing.T) {
 				return []tcpip.AddressWithPrefix{stableAddrForTempAddrTest}
 			},
 			addrGenFn: func(_ uint8, tempIIDHistory []byte) tcpip.AddressWithPrefix {
-				return header.GenerateTempIPv6SLAACAddr(tempIIDHistory, stableAddrForTempAddrTest.Address)
+				return header.GenerateTempIPv6SLAACAddr(tempIIDHistory, stableAddrForTempAddrTest.Subnet())
 			},
 		},
 	}
@@ -5297,6 +6206,26 @@ func TestStopStartSolicitingRouters(t *testing.T) {
 			},
 		},
 
+		// Tests that when forwarding is enabled or disabled on a NIC, router
+		// solicitations are stopped or started, respectively.
+		{
+			name: "Enable and disable NIC forwarding",
+			startFn: func(t *testing.T, s *stack.Stack) {
+				t.Helper()
+
+				if err := s.SetNICForwarding(nicID, ipv6.ProtocolNumber, false); err != nil {
+					t.Fatalf("s.SetNICForwarding(%d, %d, false): %s", nicID, ipv6.ProtocolNumber, err)
+				}
+			},
+			stopFn: func(t *testing.T, s *stack.Stack, _ bool) {
+				t.Helper()
+
+				if err := s.SetNICForwarding(nicID, ipv6.ProtocolNumber, true); err != nil {
+					t.Fatalf("s.SetNICForwarding(%d, %d, true): %s", nicID, ipv6.ProtocolNumber, err)
+				}
+			},
+		},
+
 		// Tests that when a NIC is enabled or disabled, router solicitations
 		// are started or stopped, respectively.
 		{
@@ -5421,3 +6350,284 @@ func TestStopStartSolicitingRouters(t *testing.T) {
 		})
 	}
 }
+
+// TestCarrierChange tests that DAD and router solicitation are stopped when
+// the carrier of a NIC is lost, and restarted when it comes back.
+func TestCarrierChange(t *testing.T) {
+	const nicID = 1
+	const retransmitTimer = time.Second
+
+	clock := faketime.NewManualClock()
+	ndpDisp := ndpDispatcher{
+		dadC: make(chan ndpDADEvent, 1),
+	}
+	e := channel.New(10, 1280, linkAddr1)
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv6.NewProtocolWithOptions(ipv6.Options{
+			NDPDisp: &ndpDisp,
+			NDPConfigs: ipv6.NDPConfigurations{
+				RetransmitTimer:         retransmitTimer,
+				DupAddrDetectTransmits:  1,
+				MaxRtrSolicitations:     1,
+				RtrSolicitationInterval: retransmitTimer,
+			},
+		})},
+		Clock: clock,
+	})
+	if err := s.CreateNIC(nicID, e); err != nil {
+		t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+	}
+	if err := s.AddAddress(nicID, header.IPv6ProtocolNumber, addr1); err != nil {
+		t.Fatalf("AddAddress(%d, %d, %s): %s", nicID, header.IPv6ProtocolNumber, addr1, err)
+	}
+
+	checkDAD := func(resolved bool) {
+		t.Helper()
+
+		select {
+		case e := <-ndpDisp.dadC:
+			if diff := checkDADEvent(e, nicID, addr1, resolved, nil); diff != "" {
+				t.Errorf("dad event mismatch (-want +got):\n%s", diff)
+			}
+		default:
+			t.Fatal("expected DAD event")
+		}
+	}
+	sent := s.Stats().ICMP.V6.PacketsSent
+
+	// DAD is aborted when the carrier is lost, and nothing is sent until it
+	// comes back.
+	e.SetCarrier(false)
+	checkDAD(false /* resolved */)
+	ns, rs := sent.NeighborSolicit.Value(), sent.RouterSolicit.Value()
+	clock.Advance(2 * retransmitTimer)
+	select {
+	case e := <-ndpDisp.dadC:
+		t.Fatalf("unexpected DAD event = %#v", e)
+	default:
+	}
+	if got := sent.NeighborSolicit.Value(); got != ns {
+		t.Errorf("got NeighborSolicit = %d, want = %d", got, ns)
+	}
+	if got := sent.RouterSolicit.Value(); got != rs {
+		t.Errorf("got RouterSolicit = %d, want = %d", got, rs)
+	}
+
+	// DAD and router solicitation restart once the carrier is back.
+	e.SetCarrier(true)
+	clock.Advance(retransmitTimer)
+	checkDAD(true /* resolved */)
+	if got, want := sent.NeighborSolicit.Value(), ns+1; got != want {
+		t.Errorf("got NeighborSolicit = %d, want = %d", got, want)
+	}
+	if got, want := sent.RouterSolicit.Value(), rs+1; got != want {
+		t.Errorf("got RouterSolicit = %d, want = %d", got, want)
+	}
+
+	// Addresses which completed DAD are checked again after a link flap.
+	e.SetCarrier(false)
+	e.SetCarrier(true)
+	clock.Advance(retransmitTimer)
+	checkDAD(true /* resolved */)
+	if got, want := sent.NeighborSolicit.Value(), ns+2; got != want {
+		t.Errorf("got NeighborSolicit = %d, want = %d", got, want)
+	}
+}
+
+// TestNDPInfo tests that the state learned through NDP and SLAAC can be
+// queried from an IPv6 endpoint.
+// zeroRandSource is a math/rand.Source that always generates 0.
+type zeroRandSource struct{}
+
+// Int63 implements math/rand.Source.
+func (zeroRandSource) Int63() int64 { return 0 }
+
+// Seed implements math/rand.Source.
+func (zeroRandSource) Seed(int64) {}
+
+// TestNDPUsesStackClockAndRand tests that NDP derives its timestamps from the
+// stack's clock and its random delays from the stack's random source.
+func TestNDPUsesStackClockAndRand(t *testing.T) {
+	const (
+		nicID                 = 1
+		routerLifetimeSeconds = 1000
+		prefixLifetimeSeconds = 3 * 60 * 60
+	)
+
+	prefix, _, _ := prefixSubnetAddr(0, linkAddr1)
+	ndpDisp := ndpDispatcher{
+		rememberRouter: true,
+		rememberPrefix: true,
+	}
+	clock := faketime.NewManualClock()
+	clock.Advance(time.Hour)
+	e := channel.New(1, 1280, linkAddr1)
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv6.NewProtocolWithOptions(ipv6.Options{
+			NDPConfigs: ipv6.NDPConfigurations{
+				HandleRAs:                    true,
+				DiscoverDefaultRouters:       true,
+				AutoGenGlobalAddresses:       true,
+				AutoGenTempGlobalAddresses:   true,
+				MaxTempAddrValidLifetime:     ipv6.MinMaxTempAddrValidLifetime,
+				MaxTempAddrPreferredLifetime: ipv6.MinMaxTempAddrPreferredLifetime,
+				MaxRtrSolicitations:          1,
+				RtrSolicitationInterval:      time.Second,
+				MaxRtrSolicitationDelay:      time.Second,
+			},
+			NDPDisp: &ndpDisp,
+		})},
+		Clock:      clock,
+		RandSource: zeroRandSource{},
+	})
+	if err := s.CreateNIC(nicID, e); err != nil {
+		t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+	}
+
+	// The random delay before the first RS is drawn from the stack's random
+	// source, so it is sent right away.
+	clock.Advance(0)
+	if p, ok := e.Read(); !ok {
+		t.Fatal("expected router solicitation packet")
+	} else {
+		checker.IPv6(t, stack.PayloadSince(p.Pkt.NetworkHeader()), checker.NDPRS())
+	}
+
+	ep, err := s.GetNetworkEndpoint(nicID, header.IPv6ProtocolNumber)
+	if err != nil {
+		t.Fatalf("s.GetNetworkEndpoint(%d, %d): %s", nicID, header.IPv6ProtocolNumber, err)
+	}
+	ndpEP := ep.(ipv6.NDPEndpoint)
+
+	e.InjectInbound(header.IPv6ProtocolNumber, raBufWithPI(llAddr2, routerLifetimeSeconds, prefix, false /* onLink */, true /* auto */, prefixLifetimeSeconds, prefixLifetimeSeconds))
+	now := time.Unix(0, clock.NowNanoseconds())
+
+	checkTime := func(name string, got time.Time, lifetime time.Duration) {
+		t.Helper()
+
+		if want := now.Add(lifetime); !got.Equal(want) {
+			t.Errorf("got %s = %s, want = %s", name, got, want)
+		}
+	}
+
+	info := ndpEP.NDPInfo()
+	if len(info.DefaultRouters) != 1 {
+		t.Fatalf("got info.DefaultRouters = %+v, want a single router", info.DefaultRouters)
+	}
+	checkTime("router ValidUntil", info.DefaultRouters[0].ValidUntil, routerLifetimeSeconds*time.Second)
+	if len(info.SLAACPrefixes) != 1 || len(info.SLAACPrefixes[0].TempAddresses) != 1 {
+		t.Fatalf("got info.SLAACPrefixes = %+v, want a single prefix with a single temporary address", info.SLAACPrefixes)
+	}
+	slaacPrefix := info.SLAACPrefixes[0]
+	checkTime("stable address ValidUntil", slaacPrefix.StableAddress.ValidUntil, prefixLifetimeSeconds*time.Second)
+
+	// The desync factor is drawn from the stack's random source so the
+	// temporary address's preferred lifetime is not shortened.
+	checkTime("temporary address PreferredUntil", slaacPrefix.TempAddresses[0].PreferredUntil, ipv6.MinMaxTempAddrPreferredLifetime)
+}
+
+func TestNDPInfo(t *testing.T) {
+	const (
+		nicID                  = 1
+		routerLifetimeSeconds  = 1000
+		prefixValidSeconds     = 3 * 60 * 60
+		prefixPreferredSeconds = 2 * 60 * 60
+	)
+
+	savedMaxDesyncFactor := ipv6.MaxDesyncFactor
+	defer func() {
+		ipv6.MaxDesyncFactor = savedMaxDesyncFactor
+	}()
+	ipv6.MaxDesyncFactor = 0
+
+	prefix, subnet, stableAddr := prefixSubnetAddr(0, linkAddr1)
+	var tempIIDHistory [header.IIDSize]byte
+	header.InitialTempIID(tempIIDHistory[:], nil, nicID)
+	tempAddr := header.GenerateTempIPv6SLAACAddr(tempIIDHistory[:], subnet)
+
+	ndpDisp := ndpDispatcher{
+		rememberRouter: true,
+		rememberPrefix: true,
+	}
+	e := channel.New(0, 1280, linkAddr1)
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv6.NewProtocolWithOptions(ipv6.Options{
+			NDPConfigs: ipv6.NDPConfigurations{
+				HandleRAs:                    true,
+				DiscoverDefaultRouters:       true,
+				DiscoverOnLinkPrefixes:       true,
+				AutoGenGlobalAddresses:       true,
+				AutoGenTempGlobalAddresses:   true,
+				MaxTempAddrValidLifetime:     ipv6.MinMaxTempAddrValidLifetime,
+				MaxTempAddrPreferredLifetime: ipv6.MinMaxTempAddrPreferredLifetime,
+			},
+			NDPDisp: &ndpDisp,
+		})},
+	})
+	if err := s.CreateNIC(nicID, e); err != nil {
+		t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+	}
+	ep, err := s.GetNetworkEndpoint(nicID, header.IPv6ProtocolNumber)
+	if err != nil {
+		t.Fatalf("s.GetNetworkEndpoint(%d, %d): %s", nicID, header.IPv6ProtocolNumber, err)
+	}
+	ndpEP := ep.(ipv6.NDPEndpoint)
+
+	if diff := cmp.Diff(ipv6.NDPInfo{}, ndpEP.NDPInfo()); diff != "" {
+		t.Errorf("NDP info mismatch (-want +got):\n%s", diff)
+	}
+
+	before := time.Now()
+	e.InjectInbound(header.IPv6ProtocolNumber, raBufWithPI(llAddr2, routerLifetimeSeconds, prefix, true, true, prefixValidSeconds, prefixPreferredSeconds))
+	after := time.Now()
+
+	checkTime := func(name string, got time.Time, lifetime time.Duration) {
+		t.Helper()
+
+		if got.Before(before.Add(lifetime)) || got.After(after.Add(lifetime)) {
+			t.Errorf("got %s = %s, want between %s and %s", name, got, before.Add(lifetime), after.Add(lifetime))
+		}
+	}
+
+	info := ndpEP.NDPInfo()
+	if len(info.DefaultRouters) != 1 || info.DefaultRouters[0].Address != llAddr2 {
+		t.Fatalf("got info.DefaultRouters = %+v, want a single router with address %s", info.DefaultRouters, llAddr2)
+	}
+	checkTime("router ValidUntil", info.DefaultRouters[0].ValidUntil, routerLifetimeSeconds*time.Second)
+
+	if len(info.OnLinkPrefixes) != 1 || info.OnLinkPrefixes[0].Prefix != subnet {
+		t.Fatalf("got info.OnLinkPrefixes = %+v, want a single prefix %s", info.OnLinkPrefixes, subnet)
+	}
+	checkTime("on-link prefix ValidUntil", info.OnLinkPrefixes[0].ValidUntil, prefixValidSeconds*time.Second)
+
+	if len(info.SLAACPrefixes) != 1 {
+		t.Fatalf("got info.SLAACPrefixes = %+v, want a single prefix", info.SLAACPrefixes)
+	}
+	slaacPrefix := info.SLAACPrefixes[0]
+	if slaacPrefix.Prefix != subnet {
+		t.Errorf("got SLAAC prefix = %s, want = %s", slaacPrefix.Prefix, subnet)
+	}
+	if got := slaacPrefix.StableAddress; got.Address != stableAddr || got.Tentative || got.Deprecated {
+		t.Errorf("got stable address = %+v, want non-tentative, non-deprecated %s", got, stableAddr)
+	}
+	checkTime("stable address ValidUntil", slaacPrefix.StableAddress.ValidUntil, prefixValidSeconds*time.Second)
+	checkTime("stable address PreferredUntil", slaacPrefix.StableAddress.PreferredUntil, prefixPreferredSeconds*time.Second)
+	if len(slaacPrefix.TempAddresses) != 1 {
+		t.Fatalf("got temporary addresses = %+v, want a single address", slaacPrefix.TempAddresses)
+	}
+	if got := slaacPrefix.TempAddresses[0]; got.Address != tempAddr || got.Tentative || got.Deprecated {
+		t.Errorf("got temporary address = %+v, want non-tentative, non-deprecated %s", got, tempAddr)
+	}
+	checkTime("temporary address ValidUntil", slaacPrefix.TempAddresses[0].ValidUntil, ipv6.MinMaxTempAddrValidLifetime)
+	checkTime("temporary address PreferredUntil", slaacPrefix.TempAddresses[0].PreferredUntil, ipv6.MinMaxTempAddrPreferredLifetime)
+
+	// Invalidating the router and prefix should remove them from the snapshot.
+	e.InjectInbound(header.IPv6ProtocolNumber, raBufWithPI(llAddr2, 0, prefix, true, true, 0, 0))
+	info = ndpEP.NDPInfo()
+	if len(info.DefaultRouters) != 0 {
+		t.Errorf("got info.DefaultRouters = %+v, want = []", info.DefaultRouters)
+	}
+	if len(info.OnLinkPrefixes) != 0 {
+		t.Errorf("got info.OnLinkPrefixes = %+v, want = []", info.OnLinkPrefixes)
+	}
+}
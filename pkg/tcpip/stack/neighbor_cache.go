@@ -16,13 +16,17 @@ package stack
 
 import (
 	"fmt"
+	"time"
 
 	"gvisor.dev/gvisor/pkg/sleep"
 	"gvisor.dev/gvisor/pkg/sync"
 	"gvisor.dev/gvisor/pkg/tcpip"
 )
 
-const neighborCacheSize = 512 // max entries per interface
+// forcedGCInterval is the minimum duration between two garbage collections
+// of the neighbor cache triggered by reaching GCThresh2, and the duration for
+// which entries which just became Stale are spared by them, as in Linux.
+const forcedGCInterval = 5 * time.Second
 
 // NeighborStats holds metrics for the neighbor table.
 type NeighborStats struct {
@@ -31,10 +35,12 @@ type NeighborStats struct {
 	FailedEntryLookups *tcpip.StatCounter
 }
 
-// neighborCache maps IP addresses to link addresses. It uses the Least
-// Recently Used (LRU) eviction strategy to implement a bounded cache for
-// dynamically acquired entries. It contains the state machine and configuration
-// for running Neighbor Unreachability Detection (NUD).
+// neighborCache maps IP addresses to link addresses. It uses garbage
+// collection and the Least Recently Used (LRU) eviction strategy to implement
+// a bounded cache for dynamically acquired entries, whose bounds are set by
+// the GCThresh1, GCThresh2 and GCThresh3 NUD configurations. It contains the
+// state machine and configuration for running Neighbor Unreachability
+// Detection (NUD).
 //
 // There are two types of entries in the neighbor cache:
 //  1. Dynamic entries are discovered automatically by neighbor discovery
@@ -59,7 +65,18 @@ type neighborCache struct {
 		// count tracks the amount of dynamic entries in the cache. This is
 		// needed since static entries do not count towards the LRU cache
 		// eviction strategy.
-		count uint16
+		count uint32
+	}
+	gc struct {
+		// job runs the periodic garbage collection while the cache holds
+		// more than GCThresh1 dynamic entries. It is scheduled if scheduled is
+		// true, and created when it is first scheduled.
+		job       *tcpip.Job
+		scheduled bool
+
+		// lastForced is the time, in nanoseconds, of the last garbage
+		// collection triggered by reaching GCThresh2.
+		lastForced int64
 	}
 }
 
@@ -69,11 +86,11 @@ var _ NUDHandler = (*neighborCache)(nil)
 // returned entry is always refreshed in the cache (it is reachable via the
 // map, and its place is bumped in LRU).
 //
-// If a matching entry exists in the cache, it is returned. If no matching
-// entry exists and the cache is full, an existing entry is evicted via LRU,
-// reset to state incomplete, and returned. If no matching entry exists and the
-// cache is not full, a new entry with state incomplete is allocated and
-// returned.
+// If a matching entry exists in the cache, it is returned. Otherwise, a new
+// entry with state incomplete is allocated and returned, after the cache is
+// garbage collected if it holds at least GCThresh2 dynamic entries. If the
+// cache is still full, existing entries are evicted via LRU and reset to state
+// unknown.
 func (n *neighborCache) getOrCreateEntry(remoteAddr tcpip.Address, linkRes LinkAddressResolver) *neighborEntry {
 	n.mu.Lock()
 	defer n.mu.Unlock()
@@ -91,7 +108,12 @@ func (n *neighborCache) getOrCreateEntry(remoteAddr tcpip.Address, linkRes LinkA
 	// The entry that needs to be created must be dynamic since all static
 	// entries are directly added to the cache via addStaticEntry.
 	entry := newNeighborEntry(n.nic, remoteAddr, n.state, linkRes)
-	if n.dynamic.count == neighborCacheSize {
+	config := n.state.Config()
+	now := n.nic.stack.clock.NowNanoseconds()
+	if n.dynamic.count >= config.GCThresh3 || (n.dynamic.count >= config.GCThresh2 && time.Duration(now-n.gc.lastForced) >= forcedGCInterval) {
+		n.forcedGCLocked(now, config.GCThresh2)
+	}
+	for n.dynamic.count >= config.GCThresh3 {
 		e := n.dynamic.lru.Back()
 		e.mu.Lock()
 
@@ -107,9 +129,86 @@ func (n *neighborCache) getOrCreateEntry(remoteAddr tcpip.Address, linkRes LinkA
 	n.cache[remoteAddr] = entry
 	n.dynamic.lru.PushFront(entry)
 	n.dynamic.count++
+	n.scheduleGCLocked(config)
 	return entry
 }
 
+// reclaimableLocked returns true if entry may be removed by the garbage
+// collection at the time now, in nanoseconds: if it is Failed, or if it has
+// been Stale for longer than staleTime. The other dynamic entries are either
+// being resolved or in use.
+//
+// Precondition: entry.mu MUST be locked.
+func reclaimableLocked(entry *neighborEntry, now int64, staleTime time.Duration) bool {
+	switch entry.neigh.State {
+	case Failed:
+		return true
+	case Stale:
+		return time.Duration(now-entry.neigh.UpdatedAtNanos) > staleTime
+	default:
+		return false
+	}
+}
+
+// forcedGCLocked removes the reclaimable dynamic entries, from the least
+// recently used, until the cache holds fewer than target dynamic entries, like
+// Linux's neigh_forced_gc.
+//
+// Precondition: n.mu MUST be locked.
+func (n *neighborCache) forcedGCLocked(now int64, target uint32) {
+	n.gc.lastForced = now
+	for e := n.dynamic.lru.Back(); e != nil && n.dynamic.count >= target; {
+		prev := e.Prev()
+		e.mu.Lock()
+		if reclaimableLocked(e, now, forcedGCInterval) {
+			n.removeEntryLocked(e)
+		}
+		e.mu.Unlock()
+		e = prev
+	}
+}
+
+// scheduleGCLocked schedules the periodic garbage collection if the cache
+// holds more than config.GCThresh1 dynamic entries and it isn't scheduled yet.
+//
+// Precondition: n.mu MUST be locked.
+func (n *neighborCache) scheduleGCLocked(config NUDConfigurations) {
+	if n.gc.scheduled || n.dynamic.count <= config.GCThresh1 {
+		return
+	}
+	if n.gc.job == nil {
+		n.gc.job = n.nic.stack.newJob(&n.mu, n.periodicGCLocked)
+	}
+	// Like Linux's neigh_periodic_work.
+	n.gc.job.Schedule(config.BaseReachableTime / 2)
+	n.gc.scheduled = true
+}
+
+// periodicGCLocked removes the reclaimable dynamic entries which have been
+// Stale for longer than GCStaleTime, if the cache holds more than GCThresh1
+// dynamic entries.
+//
+// Precondition: n.mu MUST be locked.
+func (n *neighborCache) periodicGCLocked() {
+	n.gc.scheduled = false
+	config := n.state.Config()
+	if n.dynamic.count <= config.GCThresh1 {
+		return
+	}
+
+	now := n.nic.stack.clock.NowNanoseconds()
+	for e := n.dynamic.lru.Back(); e != nil; {
+		prev := e.Prev()
+		e.mu.Lock()
+		if reclaimableLocked(e, now, config.GCStaleTime) {
+			n.removeEntryLocked(e)
+		}
+		e.mu.Unlock()
+		e = prev
+	}
+	n.scheduleGCLocked(config)
+}
+
 // entry looks up the neighbor cache for translating address to link address
 // (e.g. IP -> MAC). If the LinkEndpoint requests address resolution and there
 // is a LinkAddressResolver registered with the network protocol, the cache
@@ -268,6 +367,22 @@ func (n *neighborCache) removeEntry(addr tcpip.Address) bool {
 	return true
 }
 
+// failed returns true if the neighbor with the given address is known to be
+// unreachable, that is, if its entry is in the Failed state.
+func (n *neighborCache) failed(addr tcpip.Address) bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	entry, ok := n.cache[addr]
+	if !ok {
+		return false
+	}
+
+	entry.mu.RLock()
+	defer entry.mu.RUnlock()
+	return entry.neigh.State == Failed
+}
+
 // clear removes all dynamic and static entries from the neighbor cache.
 func (n *neighborCache) clear() {
 	n.mu.Lock()
@@ -284,6 +399,26 @@ func (n *neighborCache) clear() {
 	n.dynamic.lru = neighborEntryList{}
 	n.cache = make(map[tcpip.Address]*neighborEntry)
 	n.dynamic.count = 0
+
+	if n.gc.scheduled {
+		n.gc.job.Cancel()
+		n.gc.scheduled = false
+	}
+}
+
+// clearDynamic removes the dynamic entries from the neighbor cache, keeping
+// the static ones.
+func (n *neighborCache) clearDynamic() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, entry := range n.cache {
+		entry.mu.Lock()
+		if entry.neigh.State != Static {
+			n.removeEntryLocked(entry)
+		}
+		entry.mu.Unlock()
+	}
 }
 
 // config returns the NUD configuration.
@@ -34,6 +34,10 @@ import (
 )
 
 const (
+	// neighborCacheSize is the number of dynamic entries a neighbor cache holds
+	// when it is full, with the default NUD configurations.
+	neighborCacheSize = defaultGCThresh3
+
 	// entryStoreSize is the default number of entries that will be generated and
 	// added to the entry store. This number needs to be larger than the size of
 	// the neighbor cache to give ample opportunity for verifying behavior during
@@ -90,7 +94,7 @@ func newTestNeighborCache(nudDisp NUDDispatcher, config NUDConfigurations, clock
 			stats: makeNICStats(),
 		},
 		state: NewNUDState(config, rng),
-		cache: make(map[tcpip.Address]*neighborEntry, neighborCacheSize),
+		cache: make(map[tcpip.Address]*neighborEntry),
 	}
 	neigh.nic.neigh = neigh
 	return neigh
@@ -1219,6 +1223,187 @@ func TestNeighborCacheClear(t *testing.T) {
 	}
 }
 
+// TestNeighborCacheClearDynamic verifies that clearDynamic removes the dynamic
+// entries but keeps the static ones.
+func TestNeighborCacheClearDynamic(t *testing.T) {
+	config := DefaultNUDConfigurations()
+
+	clock := faketime.NewManualClock()
+	neigh := newTestNeighborCache(nil, config, clock)
+	store := newTestEntryStore()
+	linkRes := &testNeighborResolver{
+		clock:   clock,
+		neigh:   neigh,
+		entries: store,
+		delay:   typicalLatency,
+	}
+
+	// Add a dynamic entry.
+	entry, ok := store.entry(0)
+	if !ok {
+		t.Fatalf("store.entry(0) not found")
+	}
+	if _, _, err := neigh.entry(entry.Addr, "", linkRes, nil); err != tcpip.ErrWouldBlock {
+		t.Errorf("got neigh.entry(%s, '', _, nil) = %v, want = %s", entry.Addr, err, tcpip.ErrWouldBlock)
+	}
+	clock.Advance(typicalLatency)
+
+	// Add a static entry.
+	neigh.addStaticEntry(entryTestAddr1, entryTestLinkAddr1)
+
+	neigh.clearDynamic()
+
+	wantEntries := []NeighborEntry{
+		{
+			Addr:     entryTestAddr1,
+			LinkAddr: entryTestLinkAddr1,
+			State:    Static,
+		},
+	}
+	if diff := cmp.Diff(neigh.entries(), wantEntries, entryDiffOpts()...); diff != "" {
+		t.Errorf("neighbor entries mismatch (-got, +want):\n%s", diff)
+	}
+	if neigh.dynamic.count != 0 {
+		t.Errorf("got neigh.dynamic.count = %d, want = 0", neigh.dynamic.count)
+	}
+}
+
+// TestNeighborCacheForcedGC verifies that adding an entry to a cache holding
+// GCThresh2 dynamic entries removes the least recently used reclaimable
+// entries, at most once every forcedGCInterval.
+func TestNeighborCacheForcedGC(t *testing.T) {
+	config := DefaultNUDConfigurations()
+	config.MinRandomFactor = 1
+	config.MaxRandomFactor = 1
+	config.GCStaleTime = infiniteDuration
+	config.GCThresh1 = 2
+	config.GCThresh2 = 4
+	config.GCThresh3 = 8
+
+	clock := faketime.NewManualClock()
+	neigh := newTestNeighborCache(nil, config, clock)
+	store := newTestEntryStore()
+	linkRes := &testNeighborResolver{
+		clock:   clock,
+		neigh:   neigh,
+		entries: store,
+		delay:   typicalLatency,
+	}
+
+	addEntry := func(i int) NeighborEntry {
+		t.Helper()
+		entry, ok := store.entry(i)
+		if !ok {
+			t.Fatalf("store.entry(%d) not found", i)
+		}
+		if _, _, err := neigh.entry(entry.Addr, "", linkRes, nil); err != tcpip.ErrWouldBlock {
+			t.Fatalf("got neigh.entry(%s, '', _, nil) = %v, want = %s", entry.Addr, err, tcpip.ErrWouldBlock)
+		}
+		return entry
+	}
+
+	// Resolve GCThresh2 entries and let them become Stale.
+	var entries []NeighborEntry
+	for i := 0; i < int(config.GCThresh2); i++ {
+		entries = append(entries, addEntry(i))
+		clock.Advance(typicalLatency)
+	}
+	clock.Advance(config.BaseReachableTime + 2*forcedGCInterval)
+
+	// Use the least recently used entry, which moves it to the Delay state.
+	if _, _, err := neigh.entry(entries[0].Addr, "", linkRes, nil); err != nil {
+		t.Fatalf("neigh.entry(%s, '', _, nil): %s", entries[0].Addr, err)
+	}
+
+	// The least recently used Stale entry is removed to make room for the new
+	// one.
+	entries = append(entries, addEntry(int(config.GCThresh2)))
+	wantEntries := []NeighborEntry{
+		{Addr: entries[0].Addr, LinkAddr: entries[0].LinkAddr, State: Delay},
+		{Addr: entries[2].Addr, LinkAddr: entries[2].LinkAddr, State: Stale},
+		{Addr: entries[3].Addr, LinkAddr: entries[3].LinkAddr, State: Stale},
+		{Addr: entries[4].Addr, State: Incomplete},
+	}
+	if diff := cmp.Diff(neigh.entries(), wantEntries, entryDiffOptsWithSort()...); diff != "" {
+		t.Fatalf("neighbor entries mismatch (-got, +want):\n%s", diff)
+	}
+
+	// The cache isn't garbage collected again within forcedGCInterval.
+	entries = append(entries, addEntry(int(config.GCThresh2)+1))
+	wantEntries = append(wantEntries, NeighborEntry{Addr: entries[5].Addr, State: Incomplete})
+	if diff := cmp.Diff(neigh.entries(), wantEntries, entryDiffOptsWithSort()...); diff != "" {
+		t.Errorf("neighbor entries mismatch (-got, +want):\n%s", diff)
+	}
+	if got, want := neigh.dynamic.count, uint32(len(wantEntries)); got != want {
+		t.Errorf("got neigh.dynamic.count = %d, want = %d", got, want)
+	}
+}
+
+// TestNeighborCachePeriodicGC verifies that the entries which have been Stale
+// for longer than GCStaleTime are removed periodically, as long as the cache
+// holds more than GCThresh1 dynamic entries.
+func TestNeighborCachePeriodicGC(t *testing.T) {
+	config := DefaultNUDConfigurations()
+	config.MinRandomFactor = 1
+	config.MaxRandomFactor = 1
+	config.GCStaleTime = time.Minute
+	config.GCThresh1 = 2
+
+	clock := faketime.NewManualClock()
+	neigh := newTestNeighborCache(nil, config, clock)
+	store := newTestEntryStore()
+	linkRes := &testNeighborResolver{
+		clock:   clock,
+		neigh:   neigh,
+		entries: store,
+		delay:   typicalLatency,
+	}
+
+	var wantEntries []NeighborEntry
+	for i := 0; i <= int(config.GCThresh1); i++ {
+		entry, ok := store.entry(i)
+		if !ok {
+			t.Fatalf("store.entry(%d) not found", i)
+		}
+		if _, _, err := neigh.entry(entry.Addr, "", linkRes, nil); err != tcpip.ErrWouldBlock {
+			t.Fatalf("got neigh.entry(%s, '', _, nil) = %v, want = %s", entry.Addr, err, tcpip.ErrWouldBlock)
+		}
+		clock.Advance(typicalLatency)
+		wantEntries = append(wantEntries, NeighborEntry{
+			Addr:     entry.Addr,
+			LinkAddr: entry.LinkAddr,
+			State:    Stale,
+		})
+	}
+
+	// Static entries are never garbage collected.
+	neigh.addStaticEntry(entryTestAddr1, entryTestLinkAddr1)
+	staticEntry := NeighborEntry{
+		Addr:     entryTestAddr1,
+		LinkAddr: entryTestLinkAddr1,
+		State:    Static,
+	}
+
+	clock.Advance(config.BaseReachableTime + config.GCStaleTime/2)
+	if diff := cmp.Diff(neigh.entries(), append(wantEntries, staticEntry), entryDiffOptsWithSort()...); diff != "" {
+		t.Fatalf("neighbor entries mismatch (-got, +want):\n%s", diff)
+	}
+
+	// The entries became Stale a millisecond apart, so the first one is
+	// removed alone, after which the cache holds GCThresh1 dynamic entries and
+	// isn't garbage collected anymore.
+	clock.Advance(config.GCStaleTime)
+	if diff := cmp.Diff(neigh.entries(), append(wantEntries[1:], staticEntry), entryDiffOptsWithSort()...); diff != "" {
+		t.Errorf("neighbor entries mismatch (-got, +want):\n%s", diff)
+	}
+	if neigh.dynamic.count != config.GCThresh1 {
+		t.Errorf("got neigh.dynamic.count = %d, want = %d", neigh.dynamic.count, config.GCThresh1)
+	}
+	if neigh.gc.scheduled {
+		t.Error("got neigh.gc.scheduled = true, want = false")
+	}
+}
+
 // TestNeighborCacheClearThenOverflow verifies that the LRU cache eviction
 // strategy keeps count of the dynamic entry count when all entries are
 // cleared.
@@ -209,6 +209,12 @@ func (e *neighborEntry) setStateLocked(next NeighborState) {
 	e.neigh.UpdatedAtNanos = e.nic.stack.clock.NowNanoseconds()
 	config := e.nudState.Config()
 
+	// The next hops of multipath routes are selected among the neighbors
+	// which aren't known to be unreachable.
+	if (prev == Failed) != (next == Failed) {
+		e.nic.stack.InvalidateRouteCaches()
+	}
+
 	switch next {
 	case Incomplete:
 		panic(fmt.Sprintf("should never transition to Incomplete with setStateLocked; neigh = %#v, prev state = %s", e.neigh, prev))
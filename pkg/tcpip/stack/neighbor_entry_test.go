@@ -244,7 +244,7 @@ func entryTestSetup(c NUDConfigurations) (*neighborEntry, *testNUDDispatcher, *e
 	nic.neigh = &neighborCache{
 		nic:   &nic,
 		state: nudState,
-		cache: make(map[tcpip.Address]*neighborEntry, neighborCacheSize),
+		cache: make(map[tcpip.Address]*neighborEntry),
 	}
 	nic.neigh.cache[entryTestAddr1] = entry
 
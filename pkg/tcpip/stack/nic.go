@@ -45,11 +45,64 @@ type NIC struct {
 	// methods, but the map reference and entries must be constant.
 	networkEndpoints map[tcpip.NetworkProtocolNumber]NetworkEndpoint
 
+	// duplicateAddressDetectors holds the network endpoints that can perform
+	// duplicate address detection, keyed by the protocol of the addresses they
+	// check.
+	//
+	// The map reference and entries must be constant.
+	duplicateAddressDetectors map[tcpip.NetworkProtocolNumber]DuplicateAddressDetector
+
+	// addressAnnouncers holds the network endpoints that can announce assigned
+	// addresses to neighbors, keyed by the protocol of the addresses they
+	// announce.
+	//
+	// The map reference and entries must be constant.
+	addressAnnouncers map[tcpip.NetworkProtocolNumber]AddressAnnouncer
+
 	// enabled is set to 1 when the NIC is enabled and 0 when it is disabled.
 	//
 	// Must be accessed using atomic operations.
 	enabled uint32
 
+	// l3mdev is true if the NIC is a VRF device. It is immutable.
+	l3mdev bool
+
+	// master is the ID of the VRF device the NIC is enslaved to, or 0.
+	//
+	// Must be accessed using atomic operations.
+	master int32
+
+	// removed is set to 1 when the NIC is removed from the stack.
+	//
+	// Must be accessed using atomic operations.
+	removed uint32
+
+	// disabledOffloads are the offloads of the link endpoint turned off at
+	// runtime, as LinkEndpointCapabilities.
+	//
+	// Must be accessed using atomic operations.
+	disabledOffloads uint32
+
+	// offloadMu serializes the changes to disabledOffloads, so they're
+	// reported in order.
+	offloadMu sync.Mutex
+
+	// carrier is 1 when the link of the link endpoint is up, and 0 when it
+	// is down.
+	//
+	// Must be accessed using atomic operations.
+	carrier uint32
+
+	// linkStateMu serializes the changes to the administrative state and the
+	// carrier of the NIC, so they're reported in order.
+	linkStateMu sync.Mutex
+
+	// mtu is the MTU set at runtime, or 0 to use the MTU of the link
+	// endpoint.
+	//
+	// Must be accessed using atomic operations.
+	mtu uint32
+
 	mu struct {
 		sync.RWMutex
 		spoofing    bool
@@ -57,6 +110,24 @@ type NIC struct {
 		// packetEPs is protected by mu, but the contained PacketEndpoint
 		// values are not.
 		packetEPs map[tcpip.NetworkProtocolNumber][]PacketEndpoint
+		// vlans holds the VLAN NICs layered on the NIC.
+		vlans map[vlanKey]*vlanEndpoint
+		// macvlans holds the MACVLAN NICs layered on the NIC, keyed by link
+		// address. It is replaced rather than modified.
+		macvlans map[tcpip.LinkAddress]*macvlanEndpoint
+		// ipvlans holds the IPVLAN NICs layered on the NIC. It is replaced
+		// rather than modified.
+		ipvlans []*ipvlanEndpoint
+		// ingressHook is run on the packets received by the NIC, if set.
+		ingressHook IngressHook
+	}
+
+	// captureMu protects captures. It is separate from mu as packets may be
+	// written with mu held.
+	captureMu struct {
+		sync.RWMutex
+		// captures receive the packets going through the NIC.
+		captures []PacketCapture
 	}
 }
 
@@ -68,6 +139,8 @@ type NICStats struct {
 	DisabledRx DirectionStats
 
 	Neighbor NeighborStats
+
+	IngressHook IngressHookStats
 }
 
 func makeNICStats() NICStats {
@@ -100,9 +173,16 @@ func newNIC(stack *Stack, id tcpip.NICID, name string, ep LinkEndpoint, ctx NICC
 		name:             name,
 		context:          ctx,
 		stats:            makeNICStats(),
+		carrier:          1,
 		networkEndpoints: make(map[tcpip.NetworkProtocolNumber]NetworkEndpoint),
+
+		duplicateAddressDetectors: make(map[tcpip.NetworkProtocolNumber]DuplicateAddressDetector),
+		addressAnnouncers:         make(map[tcpip.NetworkProtocolNumber]AddressAnnouncer),
 	}
 	nic.mu.packetEPs = make(map[tcpip.NetworkProtocolNumber][]PacketEndpoint)
+	if c, ok := ep.(CarrierReporter); ok && !c.Carrier() {
+		nic.carrier = 0
+	}
 
 	// Check for Neighbor Unreachability Detection support.
 	var nud NUDHandler
@@ -111,7 +191,7 @@ func newNIC(stack *Stack, id tcpip.NICID, name string, ep LinkEndpoint, ctx NICC
 		nic.neigh = &neighborCache{
 			nic:   nic,
 			state: NewNUDState(stack.nudConfigs, rng),
-			cache: make(map[tcpip.Address]*neighborEntry, neighborCacheSize),
+			cache: make(map[tcpip.Address]*neighborEntry),
 		}
 
 		// An interface value that holds a nil pointer but non-nil type is not the
@@ -130,7 +210,15 @@ func newNIC(stack *Stack, id tcpip.NICID, name string, ep LinkEndpoint, ctx NICC
 	for _, netProto := range stack.networkProtocols {
 		netNum := netProto.Number()
 		nic.mu.packetEPs[netNum] = nil
-		nic.networkEndpoints[netNum] = netProto.NewEndpoint(nic, stack, nud, nic)
+		netEP := netProto.NewEndpoint(nic, stack, nud, nic)
+		nic.networkEndpoints[netNum] = netEP
+
+		if d, ok := netEP.(DuplicateAddressDetector); ok {
+			nic.duplicateAddressDetectors[d.DuplicateAddressProtocol()] = d
+		}
+		if a, ok := netEP.(AddressAnnouncer); ok {
+			nic.addressAnnouncers[a.AnnouncedAddressProtocol()] = a
+		}
 	}
 
 	nic.LinkEndpoint.Attach(nic)
@@ -175,6 +263,7 @@ func (n *NIC) disableLocked() {
 	if !n.setEnabled(false) {
 		return
 	}
+	n.stack.InvalidateRouteCaches()
 
 	// TODO(gvisor.dev/issue/1491): Should Routes that are currently bound to n be
 	// invalidated? Currently, Routes will continue to work when a NIC is enabled
@@ -199,6 +288,7 @@ func (n *NIC) enable() *tcpip.Error {
 	if !n.setEnabled(true) {
 		return nil
 	}
+	n.stack.InvalidateRouteCaches()
 
 	for _, ep := range n.networkEndpoints {
 		if err := ep.Enable(); err != nil {
@@ -206,9 +296,27 @@ func (n *NIC) enable() *tcpip.Error {
 		}
 	}
 
+	// Let neighbors know about the addresses we hold now that the link is up as
+	// our link address may have changed while we were disabled.
+	n.announceAddresses()
+
 	return nil
 }
 
+// announceAddresses announces the permanent addresses of n to neighbors.
+func (n *NIC) announceAddresses() {
+	for protocol := range n.addressAnnouncers {
+		addressableEndpoint, ok := n.networkEndpoints[protocol].(AddressableEndpoint)
+		if !ok {
+			continue
+		}
+
+		for _, a := range addressableEndpoint.PermanentAddresses() {
+			n.announceAddress(protocol, a.Address)
+		}
+	}
+}
+
 // remove detaches NIC from the link endpoint and releases network endpoint
 // resources. This guarantees no packets between this NIC and the network
 // stack.
@@ -216,17 +324,29 @@ func (n *NIC) remove() *tcpip.Error {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
+	atomic.StoreUint32(&n.removed, 1)
 	n.disableLocked()
 
 	for _, ep := range n.networkEndpoints {
 		ep.Close()
 	}
 
+	// The neighbor entries are removed, which cancels their timers and wakes
+	// up the senders waiting for link resolution.
+	if n.neigh != nil {
+		n.neigh.clear()
+	}
+
 	// Detach from link endpoint, so no packet comes in.
 	n.LinkEndpoint.Attach(nil)
 	return nil
 }
 
+// isRemoved returns true if the NIC was removed from the stack.
+func (n *NIC) isRemoved() bool {
+	return atomic.LoadUint32(&n.removed) == 1
+}
+
 // setPromiscuousMode enables or disables promiscuous mode.
 func (n *NIC) setPromiscuousMode(enable bool) {
 	n.mu.Lock()
@@ -267,6 +387,10 @@ func (n *NIC) WritePacket(r *Route, gso *GSO, protocol tcpip.NetworkProtocolNumb
 	if ch, err := r.Resolve(nil); err != nil {
 		if err == tcpip.ErrWouldBlock {
 			r := r.Clone()
+			if n.LinkEndpoint.Capabilities()&CapabilityZeroCopyTX != 0 {
+				// The packet outlives this call.
+				pkt.ownData()
+			}
 			n.stack.linkResQueue.enqueue(ch, r, protocol, pkt)
 			return nil
 		}
@@ -289,6 +413,7 @@ func (n *NIC) writePacket(r *Route, gso *GSO, protocol tcpip.NetworkProtocolNumb
 	// WritePacket takes ownership of pkt, calculate numBytes first.
 	numBytes := pkt.Size()
 
+	n.capture(CaptureOutbound, protocol, pkt)
 	if err := n.LinkEndpoint.WritePacket(r, gso, protocol, pkt); err != nil {
 		return err
 	}
@@ -302,6 +427,9 @@ func (n *NIC) writePacket(r *Route, gso *GSO, protocol tcpip.NetworkProtocolNumb
 func (n *NIC) WritePackets(r *Route, gso *GSO, pkts PacketBufferList, protocol tcpip.NetworkProtocolNumber) (int, *tcpip.Error) {
 	// TODO(gvisor.dev/issue/4458): Queue packets whie link address resolution
 	// is being peformed like WritePacket.
+	for pkt := pkts.Front(); pkt != nil; pkt = pkt.Next() {
+		n.capture(CaptureOutbound, protocol, pkt)
+	}
 	writtenPackets, err := n.LinkEndpoint.WritePackets(r, gso, pkts, protocol)
 	n.stats.Tx.Packets.IncrementBy(uint64(writtenPackets))
 	writtenBytes := 0
@@ -412,22 +540,129 @@ func (n *NIC) getAddressOrCreateTempInner(protocol tcpip.NetworkProtocolNumber,
 // addAddress adds a new address to n, so that it starts accepting packets
 // targeted at the given address (and network protocol).
 func (n *NIC) addAddress(protocolAddress tcpip.ProtocolAddress, peb PrimaryEndpointBehavior) *tcpip.Error {
+	addressEndpoint, err := n.addAndAcquirePermanentAddress(protocolAddress, peb)
+	if err != nil {
+		return err
+	}
+
+	// We have no need for the address endpoint.
+	addressEndpoint.DecRef()
+
+	if n.Enabled() {
+		n.announceAddress(protocolAddress.Protocol, protocolAddress.AddressWithPrefix.Address)
+	}
+	return nil
+}
+
+// addAndAcquirePermanentAddress adds a permanent address to the network
+// endpoint for the address's protocol and returns the acquired address
+// endpoint.
+func (n *NIC) addAndAcquirePermanentAddress(protocolAddress tcpip.ProtocolAddress, peb PrimaryEndpointBehavior) (AddressEndpoint, *tcpip.Error) {
 	ep, ok := n.networkEndpoints[protocolAddress.Protocol]
 	if !ok {
-		return tcpip.ErrUnknownProtocol
+		return nil, tcpip.ErrUnknownProtocol
 	}
 
 	addressableEndpoint, ok := ep.(AddressableEndpoint)
 	if !ok {
-		return tcpip.ErrNotSupported
+		return nil, tcpip.ErrNotSupported
 	}
 
 	addressEndpoint, err := addressableEndpoint.AddAndAcquirePermanentAddress(protocolAddress.AddressWithPrefix, peb, AddressConfigStatic, false /* deprecated */)
-	if err == nil {
-		// We have no need for the address endpoint.
+	if err != nil {
+		return nil, err
+	}
+	n.stack.InvalidateRouteCaches()
+	return addressEndpoint, nil
+}
+
+// addAddressWithDAD adds a permanent address to n and performs duplicate
+// address detection for it.
+//
+// The address is kept tentative until DAD resolves. If DAD fails, the address
+// is removed from n. h, if not nil, is called with the result of DAD.
+func (n *NIC) addAddressWithDAD(protocolAddress tcpip.ProtocolAddress, peb PrimaryEndpointBehavior, h DADCompletionHandler) *tcpip.Error {
+	d, ok := n.duplicateAddressDetectors[protocolAddress.Protocol]
+	if !ok {
+		return tcpip.ErrNotSupported
+	}
+
+	if !n.Enabled() {
+		return tcpip.ErrNotPermitted
+	}
+
+	addressEndpoint, err := n.addAndAcquirePermanentAddress(protocolAddress, peb)
+	if err != nil {
+		return err
+	}
+
+	addr := protocolAddress.AddressWithPrefix.Address
+
+	// Some network endpoints (e.g. IPv6) perform DAD for every address added to
+	// them; such addresses are already tentative and the endpoint takes care of
+	// removing them if a duplicate is detected. We only need to be notified of
+	// the result.
+	managedByEndpoint := addressEndpoint.GetKind() == PermanentTentative
+	if !managedByEndpoint {
+		addressEndpoint.SetKind(PermanentTentative)
+	}
+
+	complete := func(r DADResult) {
+		if !managedByEndpoint {
+			n.handleDADResult(protocolAddress.Protocol, addressEndpoint, r)
+		}
 		addressEndpoint.DecRef()
+
+		if disp := n.stack.dadDisp; disp != nil {
+			disp.OnDuplicateAddressDetectionResult(n.ID(), addr, r)
+		}
+		if h != nil {
+			h(r)
+		}
+	}
+
+	switch ret := d.CheckDuplicateAddress(addr, complete); ret {
+	case DADStarting, DADAlreadyRunning:
+	case DADDisabled:
+		// Consider DAD to have resolved even if no DAD messages were actually
+		// transmitted.
+		complete(&DADSucceeded{})
+	default:
+		panic(fmt.Sprintf("unrecognized DAD check address disposition = %d", ret))
+	}
+
+	return nil
+}
+
+// handleDADResult assigns or removes a tentative address endpoint based on the
+// result of DAD performed for it.
+//
+// Does nothing if the address endpoint is no longer tentative, e.g. because
+// it was removed while DAD was being performed.
+func (n *NIC) handleDADResult(protocol tcpip.NetworkProtocolNumber, addressEndpoint AddressEndpoint, r DADResult) {
+	if addressEndpoint.GetKind() != PermanentTentative {
+		return
+	}
+
+	addr := addressEndpoint.AddressWithPrefix().Address
+	if _, ok := r.(*DADSucceeded); ok {
+		addressEndpoint.SetKind(Permanent)
+		n.announceAddress(protocol, addr)
+		return
+	}
+
+	// The address may not be used if DAD did not succeed.
+	_ = n.removeAddress(addr)
+}
+
+// announceAddress announces addr to neighbors if n has an AddressAnnouncer for
+// addresses of the specified protocol.
+//
+// Announcements are best-effort so errors are ignored.
+func (n *NIC) announceAddress(protocol tcpip.NetworkProtocolNumber, addr tcpip.Address) {
+	if a, ok := n.addressAnnouncers[protocol]; ok {
+		_ = a.AnnounceAddress(addr)
 	}
-	return err
 }
 
 // allPermanentAddresses returns all permanent addresses associated with
@@ -483,6 +718,55 @@ func (n *NIC) primaryAddress(proto tcpip.NetworkProtocolNumber) tcpip.AddressWit
 }
 
 // removeAddress removes an address from n.
+// addAddressWithLifetimes adds a new address to n, with the specified
+// lifetimes.
+func (n *NIC) addAddressWithLifetimes(protocolAddress tcpip.ProtocolAddress, peb PrimaryEndpointBehavior, lifetimes AddressLifetimes) *tcpip.Error {
+	if !lifetimes.valid() {
+		return tcpip.ErrInvalidOptionValue
+	}
+
+	ep, ok := n.networkEndpoints[protocolAddress.Protocol]
+	if !ok {
+		return tcpip.ErrUnknownProtocol
+	}
+
+	lifetimesEP, ok := ep.(AddressLifetimesNetworkEndpoint)
+	if !ok {
+		return tcpip.ErrNotSupported
+	}
+
+	if err := n.addAddress(protocolAddress, peb); err != nil {
+		return err
+	}
+
+	addr := protocolAddress.AddressWithPrefix.Address
+	if err := lifetimesEP.SetAddressLifetimes(addr, lifetimes); err != nil {
+		// The address may have been removed already, e.g. if it failed DAD.
+		_ = n.removeAddress(addr)
+		return err
+	}
+	return nil
+}
+
+// setAddressLifetimes sets the lifetimes of a permanent address on n.
+func (n *NIC) setAddressLifetimes(protocol tcpip.NetworkProtocolNumber, addr tcpip.Address, lifetimes AddressLifetimes) *tcpip.Error {
+	if !lifetimes.valid() {
+		return tcpip.ErrInvalidOptionValue
+	}
+
+	ep, ok := n.networkEndpoints[protocol]
+	if !ok {
+		return tcpip.ErrUnknownProtocol
+	}
+
+	lifetimesEP, ok := ep.(AddressLifetimesNetworkEndpoint)
+	if !ok {
+		return tcpip.ErrNotSupported
+	}
+
+	return lifetimesEP.SetAddressLifetimes(addr, lifetimes)
+}
+
 func (n *NIC) removeAddress(addr tcpip.Address) *tcpip.Error {
 	for _, ep := range n.networkEndpoints {
 		addressableEndpoint, ok := ep.(AddressableEndpoint)
@@ -493,6 +777,7 @@ func (n *NIC) removeAddress(addr tcpip.Address) *tcpip.Error {
 		if err := addressableEndpoint.RemovePermanentAddress(addr); err == tcpip.ErrBadLocalAddress {
 			continue
 		} else {
+			n.stack.InvalidateRouteCaches()
 			return err
 		}
 	}
@@ -502,12 +787,22 @@ func (n *NIC) removeAddress(addr tcpip.Address) *tcpip.Error {
 
 func (n *NIC) neighbors() ([]NeighborEntry, *tcpip.Error) {
 	if n.neigh == nil {
-		return nil, tcpip.ErrNotSupported
+		return n.stack.linkAddrCache.entries(n.id), nil
 	}
 
 	return n.neigh.entries(), nil
 }
 
+// neighborFailed returns true if the neighbor with the given address is known
+// to be unreachable through the NIC.
+func (n *NIC) neighborFailed(addr tcpip.Address) bool {
+	if n.neigh == nil {
+		return n.stack.linkAddrCache.failed(tcpip.FullAddress{NIC: n.id, Addr: addr})
+	}
+
+	return n.neigh.failed(addr)
+}
+
 func (n *NIC) removeWaker(addr tcpip.Address, w *sleep.Waker) {
 	if n.neigh == nil {
 		return
@@ -518,7 +813,8 @@ func (n *NIC) removeWaker(addr tcpip.Address, w *sleep.Waker) {
 
 func (n *NIC) addStaticNeighbor(addr tcpip.Address, linkAddress tcpip.LinkAddress) *tcpip.Error {
 	if n.neigh == nil {
-		return tcpip.ErrNotSupported
+		n.stack.linkAddrCache.addStatic(tcpip.FullAddress{NIC: n.id, Addr: addr}, linkAddress)
+		return nil
 	}
 
 	n.neigh.addStaticEntry(addr, linkAddress)
@@ -526,11 +822,13 @@ func (n *NIC) addStaticNeighbor(addr tcpip.Address, linkAddress tcpip.LinkAddres
 }
 
 func (n *NIC) removeNeighbor(addr tcpip.Address) *tcpip.Error {
+	var removed bool
 	if n.neigh == nil {
-		return tcpip.ErrNotSupported
+		removed = n.stack.linkAddrCache.remove(tcpip.FullAddress{NIC: n.id, Addr: addr})
+	} else {
+		removed = n.neigh.removeEntry(addr)
 	}
-
-	if !n.neigh.removeEntry(addr) {
+	if !removed {
 		return tcpip.ErrBadAddress
 	}
 	return nil
@@ -538,7 +836,8 @@ func (n *NIC) removeNeighbor(addr tcpip.Address) *tcpip.Error {
 
 func (n *NIC) clearNeighbors() *tcpip.Error {
 	if n.neigh == nil {
-		return tcpip.ErrNotSupported
+		n.stack.linkAddrCache.removeNIC(n.id)
+		return nil
 	}
 
 	n.neigh.clear()
@@ -582,6 +881,38 @@ func (n *NIC) leaveGroup(protocol tcpip.NetworkProtocolNumber, addr tcpip.Addres
 	return gep.LeaveGroup(addr)
 }
 
+// joinSourceGroup joins the multicast group addr, only accepting traffic sent
+// by source.
+func (n *NIC) joinSourceGroup(protocol tcpip.NetworkProtocolNumber, addr, source tcpip.Address) *tcpip.Error {
+	ep, ok := n.networkEndpoints[protocol]
+	if !ok {
+		return tcpip.ErrNotSupported
+	}
+
+	gep, ok := ep.(SourceGroupAddressableEndpoint)
+	if !ok {
+		return tcpip.ErrNotSupported
+	}
+
+	return gep.JoinSourceGroup(addr, source)
+}
+
+// leaveSourceGroup leaves a source-specific membership of the multicast group
+// addr.
+func (n *NIC) leaveSourceGroup(protocol tcpip.NetworkProtocolNumber, addr, source tcpip.Address) *tcpip.Error {
+	ep, ok := n.networkEndpoints[protocol]
+	if !ok {
+		return tcpip.ErrNotSupported
+	}
+
+	gep, ok := ep.(SourceGroupAddressableEndpoint)
+	if !ok {
+		return tcpip.ErrNotSupported
+	}
+
+	return gep.LeaveSourceGroup(addr, source)
+}
+
 // isInGroup returns true if n has joined the multicast group addr.
 func (n *NIC) isInGroup(addr tcpip.Address) bool {
 	for _, ep := range n.networkEndpoints {
@@ -605,6 +936,12 @@ func (n *NIC) isInGroup(addr tcpip.Address) bool {
 // This rule applies only to the slice itself, not to the items of the slice;
 // the ownership of the items is not retained by the caller.
 func (n *NIC) DeliverNetworkPacket(remote, local tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, pkt *PacketBuffer) {
+	n.deliverNetworkPacket(remote, local, protocol, pkt, true /* runHook */)
+}
+
+// deliverNetworkPacket delivers pkt received by the NIC, running the ingress
+// hook of the NIC on it first if runHook is set.
+func (n *NIC) deliverNetworkPacket(remote, local tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, pkt *PacketBuffer, runHook bool) {
 	n.mu.RLock()
 	enabled := n.Enabled()
 	// If the NIC is not yet enabled, don't receive any packets.
@@ -619,6 +956,35 @@ func (n *NIC) DeliverNetworkPacket(remote, local tcpip.LinkAddress, protocol tcp
 	n.stats.Rx.Packets.Increment()
 	n.stats.Rx.Bytes.IncrementBy(uint64(pkt.Data.Size()))
 
+	if hook := n.mu.ingressHook; hook != nil && runHook {
+		n.mu.RUnlock()
+		if !n.runIngressHook(hook, remote, local, protocol, pkt) {
+			return
+		}
+		n.mu.RLock()
+	}
+
+	n.capture(CaptureInbound, protocol, pkt)
+
+	if header.IsVLANProtocol(protocol) {
+		n.mu.RUnlock()
+		if !n.deliverTagged(remote, local, protocol, pkt) {
+			n.stack.stats.UnknownProtocolRcvdPackets.Increment()
+		}
+		return
+	}
+
+	if macvlans, ipvlans := n.mu.macvlans, n.mu.ipvlans; len(macvlans) != 0 || len(ipvlans) != 0 {
+		n.mu.RUnlock()
+		if len(macvlans) != 0 && n.deliverMACVLAN(macvlans, remote, local, protocol, pkt) {
+			return
+		}
+		if len(ipvlans) != 0 && n.deliverIPVLAN(ipvlans, remote, local, protocol, pkt) {
+			return
+		}
+		n.mu.RLock()
+	}
+
 	networkEndpoint, ok := n.networkEndpoints[protocol]
 	if !ok {
 		n.mu.RUnlock()
@@ -631,7 +997,11 @@ func (n *NIC) DeliverNetworkPacket(remote, local tcpip.LinkAddress, protocol tcp
 	if local == "" {
 		local = n.LinkEndpoint.LinkAddress()
 	}
-	pkt.RXTransportChecksumValidated = n.LinkEndpoint.Capabilities()&CapabilityRXChecksumOffload != 0
+	// The link endpoint may have validated the checksum of this packet even
+	// if it doesn't validate all of them.
+	if n.capabilities()&CapabilityRXChecksumOffload != 0 {
+		pkt.RXTransportChecksumValidated = true
+	}
 
 	// Are any packet type sockets listening for this network protocol?
 	packetEPs := n.mu.packetEPs[protocol]
@@ -687,6 +1057,10 @@ func (n *NIC) DeliverOutboundPacket(remote, local tcpip.LinkAddress, protocol tc
 	n.mu.RUnlock()
 	for _, ep := range packetEPs {
 		p := pkt.Clone()
+		if n.LinkEndpoint.Capabilities()&CapabilityZeroCopyTX != 0 {
+			// Packet endpoints hold onto the packet.
+			p.ownData()
+		}
 		p.PktType = tcpip.PacketOutgoing
 		// Add the link layer header as outgoing packets are intercepted
 		// before the link layer header is created.
@@ -750,6 +1124,7 @@ func (n *NIC) DeliverTransportPacket(protocol tcpip.TransportProtocolNumber, pkt
 		RemotePort:    srcPort,
 		RemoteAddress: src,
 	}
+	pkt.L3MasterNICID = n.l3MasterDevice()
 	if n.stack.demux.deliverPacket(protocol, pkt, id) {
 		return TransportPacketHandled
 	}
@@ -824,6 +1199,117 @@ func (n *NIC) nudConfigs() (NUDConfigurations, *tcpip.Error) {
 	return n.neigh.config(), nil
 }
 
+// forwarding returns the forwarding configuration for the specified protocol
+// on n.
+func (n *NIC) forwarding(protocol tcpip.NetworkProtocolNumber) (bool, *tcpip.Error) {
+	ep, ok := n.networkEndpoints[protocol]
+	if !ok {
+		return false, tcpip.ErrUnknownProtocol
+	}
+
+	forwardingEP, ok := ep.(ForwardingNetworkEndpoint)
+	if !ok {
+		return false, tcpip.ErrNotSupported
+	}
+
+	return forwardingEP.Forwarding(), nil
+}
+
+// canForward returns true if the packets sent through n may be forwarded
+// through other NICs. The forwarding configuration of the protocol is used if
+// it isn't configured per NIC.
+func (n *NIC) canForward(protocol tcpip.NetworkProtocolNumber) bool {
+	if forwarding, err := n.forwarding(protocol); err == nil {
+		return forwarding
+	}
+	return n.stack.Forwarding(protocol)
+}
+
+// setForwarding sets the forwarding configuration for the specified protocol
+// on n.
+func (n *NIC) setForwarding(protocol tcpip.NetworkProtocolNumber, enable bool) *tcpip.Error {
+	ep, ok := n.networkEndpoints[protocol]
+	if !ok {
+		return tcpip.ErrUnknownProtocol
+	}
+
+	forwardingEP, ok := ep.(ForwardingNetworkEndpoint)
+	if !ok {
+		return tcpip.ErrNotSupported
+	}
+
+	forwardingEP.SetForwarding(enable)
+	n.stack.InvalidateRouteCaches()
+	return nil
+}
+
+// multicastForwarding returns the multicast forwarding configuration for the
+// specified protocol on n.
+func (n *NIC) multicastForwarding(protocol tcpip.NetworkProtocolNumber) (bool, *tcpip.Error) {
+	ep, ok := n.networkEndpoints[protocol]
+	if !ok {
+		return false, tcpip.ErrUnknownProtocol
+	}
+
+	forwardingEP, ok := ep.(MulticastForwardingNetworkEndpoint)
+	if !ok {
+		return false, tcpip.ErrNotSupported
+	}
+
+	return forwardingEP.MulticastForwarding(), nil
+}
+
+// setMulticastForwarding sets the multicast forwarding configuration for the
+// specified protocol on n.
+func (n *NIC) setMulticastForwarding(protocol tcpip.NetworkProtocolNumber, enable bool) *tcpip.Error {
+	ep, ok := n.networkEndpoints[protocol]
+	if !ok {
+		return tcpip.ErrUnknownProtocol
+	}
+
+	forwardingEP, ok := ep.(MulticastForwardingNetworkEndpoint)
+	if !ok {
+		return tcpip.ErrNotSupported
+	}
+
+	forwardingEP.SetMulticastForwarding(enable)
+	return nil
+}
+
+// checkDuplicateAddress performs duplicate address detection for an address
+// of the specified protocol on n.
+func (n *NIC) checkDuplicateAddress(protocol tcpip.NetworkProtocolNumber, addr tcpip.Address, h DADCompletionHandler) (DADCheckAddressDisposition, *tcpip.Error) {
+	d, ok := n.duplicateAddressDetectors[protocol]
+	if !ok {
+		return 0, tcpip.ErrNotSupported
+	}
+
+	return d.CheckDuplicateAddress(addr, h), nil
+}
+
+// setDADConfigurations sets the DAD configurations for addresses of the
+// specified protocol on n.
+func (n *NIC) setDADConfigurations(protocol tcpip.NetworkProtocolNumber, c DADConfigurations) *tcpip.Error {
+	d, ok := n.duplicateAddressDetectors[protocol]
+	if !ok {
+		return tcpip.ErrNotSupported
+	}
+
+	d.SetDADConfigurations(c)
+	return nil
+}
+
+// redirectedNextHop returns the first-hop learned through a redirect message
+// for packets of the specified protocol to dst.
+func (n *NIC) redirectedNextHop(protocol tcpip.NetworkProtocolNumber, dst tcpip.Address) (tcpip.Address, bool) {
+	ep, ok := n.networkEndpoints[protocol].(RedirectableNetworkEndpoint)
+	if !ok {
+		return "", false
+	}
+
+	return ep.RedirectedNextHop(dst)
+}
+
 // setNUDConfigs sets the NUD configurations for n.
 //
 // Note, if c contains invalid NUD configuration values, it will be fixed to
@@ -0,0 +1,56 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import "gvisor.dev/gvisor/pkg/tcpip"
+
+// NICEventDispatcher is the interface integrators of netstack must implement
+// to receive NIC related events.
+type NICEventDispatcher interface {
+	// OnOffloadsChanged is called when the offloads enabled on the NIC nicID
+	// change.
+	//
+	// This function is not permitted to block indefinitely. It may call
+	// into the stack, but not to change the offloads of the NIC.
+	OnOffloadsChanged(nicID tcpip.NICID, offloads NICOffloads)
+
+	// OnMTUChanged is called when the MTU of the NIC nicID changes to mtu,
+	// once the change is propagated to the routes and endpoints using the
+	// NIC.
+	//
+	// This function is not permitted to block indefinitely. It may call
+	// into the stack, but not to change the MTU of a NIC.
+	OnMTUChanged(nicID tcpip.NICID, mtu uint32)
+
+	// OnAdminStateChanged is called when the NIC nicID is enabled or
+	// disabled with Stack.EnableNIC or Stack.DisableNIC.
+	//
+	// This function is not permitted to block indefinitely. It may call
+	// into the stack, but not to change the state of the NIC.
+	OnAdminStateChanged(nicID tcpip.NICID, up bool)
+
+	// OnCarrierChanged is called when the carrier of the NIC nicID goes up
+	// or down, once the network endpoints of the NIC reacted to it.
+	//
+	// This function is not permitted to block indefinitely. It may call
+	// into the stack, but not to change the state of the NIC.
+	OnCarrierChanged(nicID tcpip.NICID, up bool)
+
+	// OnNICRemoved is called when the NIC nicID is removed from the stack,
+	// once its resources are released.
+	//
+	// This function is not permitted to block indefinitely.
+	OnNICRemoved(nicID tcpip.NICID)
+}
@@ -117,6 +117,30 @@ const (
 	// leaves the specifics of any garbage collection mechanism up to the
 	// implementation.
 	defaultUnreachableTime = 5 * time.Second
+
+	// defaultGCStaleTime is the default duration after which an entry in the
+	// STALE state is removed by the periodic garbage collection.
+	//
+	// Default taken from gc_stale_time of Linux.
+	defaultGCStaleTime = 60 * time.Second
+
+	// defaultGCThresh1 is the default number of dynamic entries below which
+	// the periodic garbage collection doesn't remove entries.
+	//
+	// Default taken from gc_thresh1 of Linux.
+	defaultGCThresh1 = 128
+
+	// defaultGCThresh2 is the default soft limit on the number of dynamic
+	// entries.
+	//
+	// Default taken from gc_thresh2 of Linux.
+	defaultGCThresh2 = 512
+
+	// defaultGCThresh3 is the default hard limit on the number of dynamic
+	// entries.
+	//
+	// Default taken from gc_thresh3 of Linux.
+	defaultGCThresh3 = 1024
 )
 
 // NUDDispatcher is the interface integrators of netstack must implement to
@@ -282,6 +306,34 @@ type NUDConfigurations struct {
 	// UnreachableTime describes how long an entry will remain in the FAILED
 	// state before being removed from the neighbor cache.
 	UnreachableTime time.Duration
+
+	// GCStaleTime is the duration after which an entry in the STALE state is
+	// removed by the periodic garbage collection, which runs every
+	// BaseReachableTime/2 while the neighbor cache holds more than GCThresh1
+	// dynamic entries.
+	//
+	// Must be greater than 0.
+	GCStaleTime time.Duration
+
+	// GCThresh1, GCThresh2 and GCThresh3 bound the number of dynamic entries
+	// of the neighbor cache, like gc_thresh1, gc_thresh2 and gc_thresh3 of
+	// Linux, except that entries are counted per interface:
+	//
+	//  - The periodic garbage collection doesn't remove entries while the
+	//    cache holds GCThresh1 dynamic entries or fewer.
+	//  - When an entry is added to a cache holding at least GCThresh2 dynamic
+	//    entries, and no entry was added in these conditions in the last 5
+	//    seconds, the FAILED entries and the entries which have been STALE
+	//    for more than 5 seconds are removed, from the least recently used,
+	//    until the cache holds fewer than GCThresh2 dynamic entries.
+	//  - When an entry is added to a cache holding GCThresh3 dynamic entries,
+	//    the entries are removed as when GCThresh2 is reached, and if the
+	//    cache is still full, the least recently used entries are evicted.
+	//
+	// Must be greater than 0.
+	GCThresh1 uint32
+	GCThresh2 uint32
+	GCThresh3 uint32
 }
 
 // DefaultNUDConfigurations returns a NUDConfigurations populated with default
@@ -300,6 +352,10 @@ func DefaultNUDConfigurations() NUDConfigurations {
 		MaxAnycastDelayTime:          defaultMaxAnycastDelayTime,
 		MaxReachabilityConfirmations: defaultMaxReachbilityConfirmations,
 		UnreachableTime:              defaultUnreachableTime,
+		GCStaleTime:                  defaultGCStaleTime,
+		GCThresh1:                    defaultGCThresh1,
+		GCThresh2:                    defaultGCThresh2,
+		GCThresh3:                    defaultGCThresh3,
 	}
 }
 
@@ -332,6 +388,18 @@ func (c *NUDConfigurations) resetInvalidFields() {
 	if c.UnreachableTime == 0 {
 		c.UnreachableTime = defaultUnreachableTime
 	}
+	if c.GCStaleTime == 0 {
+		c.GCStaleTime = defaultGCStaleTime
+	}
+	if c.GCThresh1 == 0 {
+		c.GCThresh1 = defaultGCThresh1
+	}
+	if c.GCThresh2 == 0 {
+		c.GCThresh2 = defaultGCThresh2
+	}
+	if c.GCThresh3 == 0 {
+		c.GCThresh3 = defaultGCThresh3
+	}
 }
 
 // calcMaxRandomFactor calculates the maximum value of the random factor used
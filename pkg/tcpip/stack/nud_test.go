@@ -38,6 +38,10 @@ const (
 	defaultMaxAnycastDelayTime         = time.Second
 	defaultMaxReachbilityConfirmations = 3
 	defaultUnreachableTime             = 5 * time.Second
+	defaultGCStaleTime                 = 60 * time.Second
+	defaultGCThresh1                   = 128
+	defaultGCThresh2                   = 512
+	defaultGCThresh3                   = 1024
 
 	defaultFakeRandomNum = 0.5
 )
@@ -617,6 +621,78 @@ func TestNUDConfigurationsUnreachableTime(t *testing.T) {
 	}
 }
 
+func TestNUDConfigurationsGarbageCollection(t *testing.T) {
+	tests := []struct {
+		name        string
+		gcStaleTime time.Duration
+		gcThresh    [3]uint32
+		want        stack.NUDConfigurations
+	}{
+		// Invalid cases
+		{
+			name: "EqualToZero",
+			want: stack.NUDConfigurations{
+				GCStaleTime: defaultGCStaleTime,
+				GCThresh1:   defaultGCThresh1,
+				GCThresh2:   defaultGCThresh2,
+				GCThresh3:   defaultGCThresh3,
+			},
+		},
+		// Valid cases
+		{
+			name:        "MoreThanZero",
+			gcStaleTime: time.Millisecond,
+			gcThresh:    [3]uint32{1, 2, 3},
+			want: stack.NUDConfigurations{
+				GCStaleTime: time.Millisecond,
+				GCThresh1:   1,
+				GCThresh2:   2,
+				GCThresh3:   3,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			const nicID = 1
+
+			c := stack.DefaultNUDConfigurations()
+			c.GCStaleTime = test.gcStaleTime
+			c.GCThresh1 = test.gcThresh[0]
+			c.GCThresh2 = test.gcThresh[1]
+			c.GCThresh3 = test.gcThresh[2]
+
+			e := channel.New(0, 1280, linkAddr1)
+			e.LinkEPCapabilities |= stack.CapabilityResolutionRequired
+
+			s := stack.New(stack.Options{
+				// A neighbor cache is required to store NUDConfigurations. The
+				// networking stack will only allocate neighbor caches if a protocol
+				// providing link address resolution is specified (e.g. ARP or IPv6).
+				NetworkProtocols: []stack.NetworkProtocolFactory{ipv6.NewProtocol},
+				NUDConfigs:       c,
+				UseNeighborCache: true,
+			})
+			if err := s.CreateNIC(nicID, e); err != nil {
+				t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+			}
+			sc, err := s.NUDConfigurations(nicID)
+			if err != nil {
+				t.Fatalf("got stack.NUDConfigurations(%d) = %s", nicID, err)
+			}
+			got := stack.NUDConfigurations{
+				GCStaleTime: sc.GCStaleTime,
+				GCThresh1:   sc.GCThresh1,
+				GCThresh2:   sc.GCThresh2,
+				GCThresh3:   sc.GCThresh3,
+			}
+			if got != test.want {
+				t.Errorf("got garbage collection configurations = %+v, want = %+v", got, test.want)
+			}
+		})
+	}
+}
+
 // TestNUDStateReachableTime verifies the correctness of the ReachableTime
 // computation.
 func TestNUDStateReachableTime(t *testing.T) {
@@ -0,0 +1,108 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"sync/atomic"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// OffloadCapabilities are the link endpoint capabilities which may be turned
+// off and back on at runtime, like with ethtool -K.
+const OffloadCapabilities = CapabilityTXChecksumOffload | CapabilityRXChecksumOffload | CapabilityHardwareGSO | CapabilitySoftwareGSO
+
+// NICOffloads is the state of the offloads of a NIC.
+type NICOffloads struct {
+	// Supported are the offloads supported by the link endpoint of the NIC.
+	Supported LinkEndpointCapabilities
+
+	// Enabled are the offloads used by the stack, a subset of Supported.
+	Enabled LinkEndpointCapabilities
+}
+
+// NICOffloads returns the state of the offloads of the NIC id.
+func (s *Stack) NICOffloads(id tcpip.NICID) (NICOffloads, *tcpip.Error) {
+	s.mu.RLock()
+	nic, ok := s.nics[id]
+	s.mu.RUnlock()
+	if !ok {
+		return NICOffloads{}, tcpip.ErrUnknownNICID
+	}
+	return nic.offloads(), nil
+}
+
+// SetNICOffloads enables or disables the offloads caps on the NIC id. caps
+// must be a subset of OffloadCapabilities, and only the offloads supported by
+// the link endpoint of the NIC may be enabled.
+//
+// The changes apply to the packets sent and received afterwards: the stack
+// computes and verifies the checksums the NIC doesn't offload. The TCP
+// connections established before GSO is disabled keep using it, as the link
+// endpoint still supports it.
+func (s *Stack) SetNICOffloads(id tcpip.NICID, caps LinkEndpointCapabilities, enable bool) *tcpip.Error {
+	if caps&^OffloadCapabilities != 0 {
+		return tcpip.ErrInvalidOptionValue
+	}
+
+	s.mu.RLock()
+	nic, ok := s.nics[id]
+	s.mu.RUnlock()
+	if !ok {
+		return tcpip.ErrUnknownNICID
+	}
+	return nic.setOffloads(caps, enable)
+}
+
+// capabilities returns the capabilities of the link endpoint of the NIC,
+// without the offloads disabled at runtime.
+func (n *NIC) capabilities() LinkEndpointCapabilities {
+	return n.LinkEndpoint.Capabilities() &^ LinkEndpointCapabilities(atomic.LoadUint32(&n.disabledOffloads))
+}
+
+// offloads returns the state of the offloads of the NIC.
+func (n *NIC) offloads() NICOffloads {
+	supported := n.LinkEndpoint.Capabilities() & OffloadCapabilities
+	return NICOffloads{
+		Supported: supported,
+		Enabled:   supported &^ LinkEndpointCapabilities(atomic.LoadUint32(&n.disabledOffloads)),
+	}
+}
+
+// setOffloads enables or disables the offloads caps on the NIC, and reports
+// the change to the NIC event dispatcher of the stack.
+func (n *NIC) setOffloads(caps LinkEndpointCapabilities, enable bool) *tcpip.Error {
+	n.offloadMu.Lock()
+	defer n.offloadMu.Unlock()
+
+	old := n.offloads()
+	disabled := LinkEndpointCapabilities(atomic.LoadUint32(&n.disabledOffloads))
+	if enable {
+		if caps&^old.Supported != 0 {
+			return tcpip.ErrNotSupported
+		}
+		disabled &^= caps
+	} else {
+		disabled |= caps
+	}
+	atomic.StoreUint32(&n.disabledOffloads, uint32(disabled))
+
+	if offloads := n.offloads(); offloads != old {
+		if disp := n.stack.nicEventDisp; disp != nil {
+			disp.OnOffloadsChanged(n.id, offloads)
+		}
+	}
+	return nil
+}
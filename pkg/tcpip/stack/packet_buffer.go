@@ -15,6 +15,7 @@ package stack
 
 import (
 	"fmt"
+	"sync/atomic"
 
 	"gvisor.dev/gvisor/pkg/sync"
 	"gvisor.dev/gvisor/pkg/tcpip"
@@ -116,12 +117,66 @@ type PacketBuffer struct {
 	// NICID is the ID of the interface the network packet was received at.
 	NICID tcpip.NICID
 
+	// L3MasterNICID is the ID of the VRF device of the interface the network
+	// packet was received at, if any.
+	L3MasterNICID tcpip.NICID
+
 	// RXTransportChecksumValidated indicates that transport checksum verification
 	// may be safely skipped.
 	RXTransportChecksumValidated bool
 
+	// ProcessInline indicates that the packet is delivered on the goroutine
+	// which wrote it to a loopback NIC, outside of the processing of another
+	// such packet. Transport protocols may then process it right away rather
+	// than handing it to another goroutine.
+	ProcessInline bool
+
 	// NetworkPacketInfo holds an incoming packet's network-layer information.
 	NetworkPacketInfo NetworkPacketInfo
+
+	// TxTime is the transmit time of an outgoing packet, as set by
+	// SO_TXTIME. It is honored by queueing disciplines such as
+	// link/qdisc/etf.
+	TxTime TxTime
+
+	// Mark is the mark of the packet. Locally generated packets carry the
+	// SO_MARK of their socket, and iptables rules with the MARK target may
+	// change it. It selects the routing policy rules which apply to the
+	// packet.
+	Mark uint32
+
+	// connMark is the mark set by CONNMARK rules for the connection of the
+	// packet while it isn't tracked yet. It is given to the connection once
+	// it is tracked.
+	connMark uint32
+
+	// txDone is called once the packet has left the stack. See
+	// SetTransmitDone.
+	txDone func()
+
+	// txDeferred is set if a link endpoint holds on to the packet after
+	// its WritePacket(s) returns. See DeferTransmitDone.
+	txDeferred bool
+
+	// txDoneCalled is set once txDone is called. It is accessed
+	// atomically.
+	txDoneCalled uint32
+}
+
+// TxTime is the transmit time of an outgoing packet.
+type TxTime struct {
+	// Time is the time at which the packet must be transmitted, in
+	// nanoseconds of the stack's monotonic clock. Zero means the packet is
+	// transmitted as soon as possible.
+	Time int64
+
+	// Deadline is set if Time is the deadline by which the packet must be
+	// transmitted, in which case it may be transmitted earlier.
+	Deadline bool
+
+	// Missed if not nil is called when the packet is dropped because it
+	// can't be transmitted by Time.
+	Missed func()
 }
 
 // NewPacketBuffer creates a new PacketBuffer with opts.
@@ -135,6 +190,35 @@ func NewPacketBuffer(opts PacketBufferOptions) *PacketBuffer {
 	return pk
 }
 
+// SetTransmitDone sets a function to be called once the outbound packet has
+// left the stack, i.e. it was written to the device or dropped.
+//
+// The writer of the packet must call TransmitDone after the packet is written
+// to a route, unless TransmitDoneDeferred returns true.
+func (pk *PacketBuffer) SetTransmitDone(f func()) {
+	pk.txDone = f
+}
+
+// DeferTransmitDone is called by link endpoints which hold on to the packet
+// after WritePacket(s) returns, e.g. to queue it. They must call TransmitDone
+// once the packet is written or dropped.
+func (pk *PacketBuffer) DeferTransmitDone() {
+	pk.txDeferred = true
+}
+
+// TransmitDoneDeferred returns true if DeferTransmitDone was called.
+func (pk *PacketBuffer) TransmitDoneDeferred() bool {
+	return pk.txDeferred
+}
+
+// TransmitDone calls the function set by SetTransmitDone, if any. Only the
+// first call has an effect.
+func (pk *PacketBuffer) TransmitDone() {
+	if pk.txDone != nil && atomic.CompareAndSwapUint32(&pk.txDoneCalled, 0, 1) {
+		pk.txDone()
+	}
+}
+
 // ReservedHeaderBytes returns the number of bytes initially reserved for
 // headers.
 func (pk *PacketBuffer) ReservedHeaderBytes() int {
@@ -263,11 +347,22 @@ func (pk *PacketBuffer) Clone() *PacketBuffer {
 		TransportProtocolNumber:      pk.TransportProtocolNumber,
 		PktType:                      pk.PktType,
 		NICID:                        pk.NICID,
+		L3MasterNICID:                pk.L3MasterNICID,
 		RXTransportChecksumValidated: pk.RXTransportChecksumValidated,
 		NetworkPacketInfo:            pk.NetworkPacketInfo,
+		TxTime:                       pk.TxTime,
+		Mark:                         pk.Mark,
+		connMark:                     pk.connMark,
 	}
 }
 
+// ownData replaces the payload of pk with a copy of it, so that pk no longer
+// references memory owned by the sender of the packet. See
+// CapabilityZeroCopyTX.
+func (pk *PacketBuffer) ownData() {
+	pk.Data = buffer.NewViewFromBytes(pk.Data.ToView()).ToVectorisedView()
+}
+
 // SourceLinkAddress returns the source link address of the packet.
 func (pk *PacketBuffer) SourceLinkAddress() tcpip.LinkAddress {
 	link := pk.LinkHeader().View()
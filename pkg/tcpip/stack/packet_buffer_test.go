@@ -340,6 +340,33 @@ func TestPacketHeaderConsumeThenPushPanics(t *testing.T) {
 	}
 }
 
+func TestPacketBufferTransmitDone(t *testing.T) {
+	pk := NewPacketBuffer(PacketBufferOptions{})
+	// TransmitDone without a function set is a no-op.
+	pk.TransmitDone()
+
+	calls := 0
+	pk.SetTransmitDone(func() { calls++ })
+	if pk.TransmitDoneDeferred() {
+		t.Errorf("got pk.TransmitDoneDeferred() = true, want = false")
+	}
+	pk.DeferTransmitDone()
+	if !pk.TransmitDoneDeferred() {
+		t.Errorf("got pk.TransmitDoneDeferred() = false, want = true")
+	}
+	if calls != 0 {
+		t.Errorf("got %d calls before TransmitDone, want = 0", calls)
+	}
+	pk.TransmitDone()
+	pk.TransmitDone()
+	if calls != 1 {
+		t.Errorf("got %d calls after TransmitDone, want = 1", calls)
+	}
+	if clone := pk.Clone(); clone.TransmitDoneDeferred() {
+		t.Errorf("got pk.Clone().TransmitDoneDeferred() = true, want = false")
+	}
+}
+
 func checkInitialPacketBuffer(t *testing.T, pk *PacketBuffer, opts PacketBufferOptions) {
 	t.Helper()
 	reserved := opts.ReserveHeaderBytes
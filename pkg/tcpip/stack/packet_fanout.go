@@ -0,0 +1,193 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/hash/jenkins"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// packetFanoutTable holds the fanout groups of packet endpoints, as per
+// Linux's PACKET_FANOUT socket option.
+type packetFanoutTable struct {
+	mu sync.Mutex
+
+	// groups holds the groups by ID.
+	//
+	// Protected by mu.
+	groups map[uint16]*packetFanoutGroup
+}
+
+// packetFanoutGroup is a fanout group. The group is registered for the
+// packets of its members in place of them, and delivers each packet to a
+// single member.
+type packetFanoutGroup struct {
+	// The following fields are immutable.
+	mode     tcpip.PacketFanoutMode
+	nicID    tcpip.NICID
+	netProto tcpip.NetworkProtocolNumber
+	seed     uint32
+
+	mu      sync.RWMutex
+	members []PacketEndpoint
+
+	// next is the number of packets delivered round-robin. It must be
+	// accessed using atomic operations.
+	next uint32
+}
+
+// JoinPacketFanout adds ep to the fanout group id, creating the group with
+// mode if it doesn't exist. The members of a group must receive the packets of
+// the same netProto on the same NIC, or all NICs if nicID is 0, and ep must be
+// unregistered for them.
+func (s *Stack) JoinPacketFanout(id uint16, mode tcpip.PacketFanoutMode, nicID tcpip.NICID, netProto tcpip.NetworkProtocolNumber, ep PacketEndpoint) *tcpip.Error {
+	switch mode {
+	case tcpip.PacketFanoutHash, tcpip.PacketFanoutLB, tcpip.PacketFanoutCPU:
+	default:
+		return tcpip.ErrInvalidOptionValue
+	}
+
+	t := &s.packetFanouts
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	g, ok := t.groups[id]
+	if !ok {
+		g = &packetFanoutGroup{
+			mode:     mode,
+			nicID:    nicID,
+			netProto: netProto,
+			seed:     s.seed,
+		}
+		if err := s.RegisterPacketEndpoint(nicID, netProto, g); err != nil {
+			return err
+		}
+		if t.groups == nil {
+			t.groups = make(map[uint16]*packetFanoutGroup)
+		}
+		t.groups[id] = g
+	} else if g.mode != mode || g.nicID != nicID || g.netProto != netProto {
+		return tcpip.ErrInvalidOptionValue
+	}
+
+	g.mu.Lock()
+	g.members = append(g.members, ep)
+	g.mu.Unlock()
+	return nil
+}
+
+// LeavePacketFanout removes ep from the fanout group id. The group is removed
+// once it has no members.
+func (s *Stack) LeavePacketFanout(id uint16, ep PacketEndpoint) {
+	t := &s.packetFanouts
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	g, ok := t.groups[id]
+	if !ok {
+		return
+	}
+	g.mu.Lock()
+	for i, m := range g.members {
+		if m == ep {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			break
+		}
+	}
+	empty := len(g.members) == 0
+	g.mu.Unlock()
+
+	if empty {
+		s.UnregisterPacketEndpoint(g.nicID, g.netProto, g)
+		delete(t.groups, id)
+	}
+}
+
+// HandlePacket implements PacketEndpoint.HandlePacket.
+func (g *packetFanoutGroup) HandlePacket(nicID tcpip.NICID, addr tcpip.LinkAddress, netProto tcpip.NetworkProtocolNumber, pkt *PacketBuffer) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	n := uint32(len(g.members))
+	if n == 0 {
+		return
+	}
+	var idx uint32
+	switch g.mode {
+	case tcpip.PacketFanoutHash:
+		idx = reciprocalScale(g.flowHash(netProto, pkt, true /* symmetric */), n)
+	case tcpip.PacketFanoutLB:
+		idx = (atomic.AddUint32(&g.next, 1) - 1) % n
+	case tcpip.PacketFanoutCPU:
+		idx = reciprocalScale(g.flowHash(netProto, pkt, false /* symmetric */), n)
+	}
+	g.members[idx].HandlePacket(nicID, addr, netProto, pkt)
+}
+
+// flowHash returns the hash of the flow of pkt, made of its addresses,
+// transport protocol and ports. If symmetric is true, the hash is the same for
+// both directions of the flow.
+func (g *packetFanoutGroup) flowHash(netProto tcpip.NetworkProtocolNumber, pkt *PacketBuffer, symmetric bool) uint32 {
+	v := PayloadSince(pkt.NetworkHeader())
+	var src, dst tcpip.Address
+	var transProto uint8
+	var transport []byte
+	switch netProto {
+	case header.IPv4ProtocolNumber:
+		h := header.IPv4(v)
+		if !h.IsValid(len(v)) {
+			return 0
+		}
+		src, dst = h.SourceAddress(), h.DestinationAddress()
+		transProto = h.Protocol()
+		if !h.More() && h.FragmentOffset() == 0 {
+			transport = h.Payload()
+		}
+	case header.IPv6ProtocolNumber:
+		h := header.IPv6(v)
+		if !h.IsValid(len(v)) {
+			return 0
+		}
+		src, dst = h.SourceAddress(), h.DestinationAddress()
+		transProto = h.NextHeader()
+		transport = v[header.IPv6MinimumSize:]
+	default:
+		return 0
+	}
+
+	var srcPort, dstPort uint16
+	switch tcpip.TransportProtocolNumber(transProto) {
+	case header.TCPProtocolNumber, header.UDPProtocolNumber:
+		if len(transport) >= 4 {
+			srcPort = binary.BigEndian.Uint16(transport)
+			dstPort = binary.BigEndian.Uint16(transport[2:])
+		}
+	}
+	if symmetric && (src > dst || (src == dst && srcPort > dstPort)) {
+		src, dst = dst, src
+		srcPort, dstPort = dstPort, srcPort
+	}
+
+	h := jenkins.Sum32(g.seed)
+	h.Write([]byte(src))
+	h.Write([]byte(dst))
+	h.Write([]byte{transProto, byte(srcPort >> 8), byte(srcPort), byte(dstPort >> 8), byte(dstPort)})
+	return h.Sum32()
+}
@@ -0,0 +1,275 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+const (
+	// plpmtudMaxProbes is the maximum number of times a probe of a given size
+	// is lost before the size is deemed unsupported by the path, as per RFC
+	// 8899 section 5.1.2 (MAX_PROBES).
+	plpmtudMaxProbes = 3
+
+	// plpmtudRaiseTimeout is the time after which a complete search is
+	// restarted to discover path MTU increases, as per RFC 8899 section 5.1.1
+	// (PMTU_RAISE_TIMER).
+	plpmtudRaiseTimeout = 600 * time.Second
+
+	// plpmtudIPv4BasePLPMTU is the base PLPMTU used for IPv4 paths, as
+	// recommended by RFC 8899 section 5.1.2 (BASE_PLPMTU).
+	plpmtudIPv4BasePLPMTU = 1200
+)
+
+// PLPMTUD performs datagram packetization layer path MTU discovery for the
+// path to a single destination, as per RFC 8899 section 5.
+//
+// The packetization layer sends probes of the size returned by Info and
+// reports their outcome with ProbeResult. The PLPMTU found by a complete search
+// is recorded in the stack's path MTU cache so that all endpoints sending to
+// the destination benefit from it.
+//
+// PLPMTUD is safe for concurrent use.
+type PLPMTUD struct {
+	stack    *Stack
+	netProto tcpip.NetworkProtocolNumber
+	remote   tcpip.Address
+
+	// minPLPMTU, basePLPMTU and maxPLPMTU are the MIN_PLPMTU, BASE_PLPMTU and
+	// MAX_PLPMTU of RFC 8899 section 5.1.2.
+	minPLPMTU  uint32
+	basePLPMTU uint32
+	maxPLPMTU  uint32
+
+	mu sync.Mutex
+
+	// The following fields are protected by mu.
+	state  tcpip.PLPMTUDState
+	plpmtu uint32
+
+	// high is the smallest size that is known to not reach the destination.
+	high uint32
+
+	probeSize  uint32
+	probeCount int
+
+	// blackHoleCount is the number of packets no larger than the PLPMTU that
+	// were deemed lost since the last acknowledged packet.
+	blackHoleCount int
+
+	// raiseAt is the monotonic time, in nanoseconds, at which a complete
+	// search is restarted.
+	raiseAt int64
+}
+
+// NewPLPMTUD returns a PLPMTUD for the path to r's destination.
+func NewPLPMTUD(r *Route) *PLPMTUD {
+	p := &PLPMTUD{
+		stack:     r.outgoingNIC.stack,
+		netProto:  r.NetProto,
+		remote:    r.RemoteAddress,
+		minPLPMTU: minPathMTU(r.NetProto),
+		maxPLPMTU: r.MaxMTU() + r.networkHeaderLength(),
+	}
+	p.basePLPMTU = p.minPLPMTU
+	if r.NetProto == header.IPv4ProtocolNumber {
+		p.basePLPMTU = plpmtudIPv4BasePLPMTU
+	}
+	if p.basePLPMTU > p.maxPLPMTU {
+		p.basePLPMTU = p.maxPLPMTU
+	}
+	if p.minPLPMTU > p.basePLPMTU {
+		p.minPLPMTU = p.basePLPMTU
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.enterBaseLocked()
+	return p
+}
+
+// enterBaseLocked (re)starts the search by confirming the base PLPMTU.
+//
+// Precondition: p.mu must be locked.
+func (p *PLPMTUD) enterBaseLocked() {
+	p.state = tcpip.PLPMTUDBase
+	p.plpmtu = p.basePLPMTU
+	p.high = p.maxPLPMTU + 1
+	p.probeSize = p.basePLPMTU
+	p.probeCount = 0
+	p.blackHoleCount = 0
+}
+
+// enterErrorLocked stops the search as the path does not support the base
+// PLPMTU. The search is restarted after the raise timer expires.
+//
+// Precondition: p.mu must be locked.
+func (p *PLPMTUD) enterErrorLocked(plpmtu uint32) {
+	p.state = tcpip.PLPMTUDError
+	p.plpmtu = plpmtu
+	p.high = p.basePLPMTU
+	p.probeSize = 0
+	p.raiseAt = p.stack.clock.NowMonotonic() + plpmtudRaiseTimeout.Nanoseconds()
+}
+
+// nextProbeLocked selects the size of the next probe, or completes the search
+// if the PLPMTU has been found.
+//
+// Precondition: p.mu must be locked.
+func (p *PLPMTUD) nextProbeLocked() {
+	p.probeCount = 0
+	if p.high-p.plpmtu <= 1 {
+		p.state = tcpip.PLPMTUDSearchComplete
+		p.probeSize = 0
+		p.raiseAt = p.stack.clock.NowMonotonic() + plpmtudRaiseTimeout.Nanoseconds()
+		p.stack.confirmPathMTU(p.netProto, p.remote, p.plpmtu, p.maxPLPMTU)
+		return
+	}
+
+	p.state = tcpip.PLPMTUDSearching
+	if p.high > p.maxPLPMTU {
+		// Optimistically probe the largest size first; most paths support the
+		// MTU of the local link.
+		p.probeSize = p.maxPLPMTU
+		return
+	}
+	p.probeSize = p.plpmtu + (p.high-p.plpmtu)/2
+}
+
+// maybeRaiseLocked restarts a complete or failed search once the raise timer
+// expires.
+//
+// Precondition: p.mu must be locked.
+func (p *PLPMTUD) maybeRaiseLocked() {
+	if p.stack.clock.NowMonotonic() < p.raiseAt {
+		return
+	}
+	switch p.state {
+	case tcpip.PLPMTUDSearchComplete:
+		p.high = p.maxPLPMTU + 1
+		p.nextProbeLocked()
+	case tcpip.PLPMTUDError:
+		p.enterBaseLocked()
+	}
+}
+
+// Info returns the state of the search.
+func (p *PLPMTUD) Info() tcpip.PLPMTUDInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.maybeRaiseLocked()
+	return tcpip.PLPMTUDInfo{
+		State:     p.state,
+		PLPMTU:    p.plpmtu,
+		ProbeSize: p.probeSize,
+	}
+}
+
+// ProbeResult handles the outcome of sending a packet of the specified size.
+//
+// Results for probes other than the current probe are ignored. Repeated
+// losses of packets no larger than the PLPMTU indicate a black hole and
+// restart the search from the base PLPMTU, as per RFC 8899 section 4.3.
+func (p *PLPMTUD) ProbeResult(size uint32, acked bool) *tcpip.Error {
+	if size == 0 || size > p.maxPLPMTU {
+		return tcpip.ErrInvalidOptionValue
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if size != p.probeSize {
+		if size > p.plpmtu {
+			return nil
+		}
+		if acked {
+			p.blackHoleCount = 0
+			return nil
+		}
+		p.blackHoleCount++
+		if p.blackHoleCount >= plpmtudMaxProbes {
+			p.enterBaseLocked()
+			p.stack.confirmPathMTU(p.netProto, p.remote, p.plpmtu, p.maxPLPMTU)
+		}
+		return nil
+	}
+
+	if acked {
+		p.plpmtu = size
+		p.blackHoleCount = 0
+		p.nextProbeLocked()
+		return nil
+	}
+
+	p.probeCount++
+	if p.probeCount < plpmtudMaxProbes {
+		return nil
+	}
+
+	if p.state == tcpip.PLPMTUDBase {
+		// The path does not support the base PLPMTU.
+		p.enterErrorLocked(p.minPLPMTU)
+		p.stack.confirmPathMTU(p.netProto, p.remote, p.plpmtu, p.maxPLPMTU)
+		return nil
+	}
+
+	p.high = size
+	p.nextProbeLocked()
+	return nil
+}
+
+// PacketTooBig handles a Packet Too Big or Fragmentation Needed message
+// reporting a network-layer payload MTU of networkMTU for the path, as per RFC
+// 8899 section 4.6.
+func (p *PLPMTUD) PacketTooBig(networkMTU uint32) {
+	if networkMTU == 0 {
+		return
+	}
+	hdrLen := uint32(p.stack.networkProtocols[p.netProto].MinimumPacketSize())
+	mtu := networkMTU + hdrLen
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// A PTB message reporting a size larger than the packets sent is
+	// inconsistent and must be ignored.
+	if mtu >= p.high || (p.probeSize != 0 && mtu >= p.probeSize) {
+		return
+	}
+
+	p.high = mtu + 1
+	if mtu <= p.plpmtu {
+		if mtu < p.minPLPMTU {
+			mtu = p.minPLPMTU
+		}
+		if mtu < p.basePLPMTU {
+			p.enterErrorLocked(mtu)
+			return
+		}
+		p.plpmtu = mtu
+		p.nextProbeLocked()
+		return
+	}
+
+	// Probe the reported size to confirm it, as per RFC 8899 section 4.6.2.
+	p.state = tcpip.PLPMTUDSearching
+	p.probeSize = mtu
+	p.probeCount = 0
+}
@@ -0,0 +1,257 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"sync/atomic"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+const (
+	// DefaultPathMTUExpiry is the default time after which a learned path MTU
+	// is forgotten, equivalent to Linux's net.ipv4.route.mtu_expires.
+	DefaultPathMTUExpiry = 10 * time.Minute
+
+	// maxPathMTUEntries is the maximum number of destinations the path MTU
+	// cache holds.
+	maxPathMTUEntries = 4096
+)
+
+// PathMTUInfo holds the path MTU learned for a destination.
+type PathMTUInfo struct {
+	// NetProto is the network protocol used to reach the destination.
+	NetProto tcpip.NetworkProtocolNumber
+
+	// Destination is the address of the destination.
+	Destination tcpip.Address
+
+	// MTU is the maximum size, in bytes, of a network-layer packet that can be
+	// sent to the destination without fragmentation.
+	MTU uint32
+
+	// Expires is the time left before the path MTU is forgotten.
+	Expires time.Duration
+}
+
+type pathMTUKey struct {
+	netProto tcpip.NetworkProtocolNumber
+	addr     tcpip.Address
+}
+
+type pathMTUEntry struct {
+	mtu uint32
+
+	// expiresAt is the monotonic time, in nanoseconds, at which the entry
+	// expires.
+	expiresAt int64
+}
+
+// pathMTUCache holds the path MTUs learned from Packet Too Big messages and
+// packetization layer path MTU discovery, as per RFC 1191, RFC 8201 and
+// RFC 8899.
+type pathMTUCache struct {
+	// size is the number of entries in the cache, used to avoid taking mu on
+	// the write path when the cache is empty.
+	//
+	// Must be accessed using atomic operations.
+	size int32
+
+	mu sync.RWMutex
+
+	// expiry is the lifetime of an entry.
+	//
+	// Protected by mu.
+	expiry time.Duration
+
+	// entries holds the learned path MTUs.
+	//
+	// Protected by mu.
+	entries map[pathMTUKey]pathMTUEntry
+}
+
+func (c *pathMTUCache) init() {
+	c.expiry = DefaultPathMTUExpiry
+	c.entries = make(map[pathMTUKey]pathMTUEntry)
+}
+
+func (c *pathMTUCache) get(key pathMTUKey, now int64) (uint32, bool) {
+	if atomic.LoadInt32(&c.size) == 0 {
+		return 0, false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.entries[key]
+	if !ok || now >= e.expiresAt {
+		return 0, false
+	}
+	return e.mtu, true
+}
+
+// setLocked sets the path MTU for key.
+//
+// Precondition: c.mu must be locked.
+func (c *pathMTUCache) setLocked(key pathMTUKey, mtu uint32, now int64) {
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxPathMTUEntries {
+		c.removeExpiredLocked(now)
+		if len(c.entries) >= maxPathMTUEntries {
+			// Evict the entry that expires soonest to make room.
+			var oldest pathMTUKey
+			oldestExpiresAt := int64(-1)
+			for k, e := range c.entries {
+				if oldestExpiresAt == -1 || e.expiresAt < oldestExpiresAt {
+					oldest, oldestExpiresAt = k, e.expiresAt
+				}
+			}
+			c.removeLocked(oldest)
+		}
+	}
+
+	c.entries[key] = pathMTUEntry{
+		mtu:       mtu,
+		expiresAt: now + c.expiry.Nanoseconds(),
+	}
+	atomic.StoreInt32(&c.size, int32(len(c.entries)))
+}
+
+// removeLocked removes the path MTU for key.
+//
+// Precondition: c.mu must be locked.
+func (c *pathMTUCache) removeLocked(key pathMTUKey) bool {
+	if _, ok := c.entries[key]; !ok {
+		return false
+	}
+	delete(c.entries, key)
+	atomic.StoreInt32(&c.size, int32(len(c.entries)))
+	return true
+}
+
+// removeExpiredLocked removes the entries that have expired.
+//
+// Precondition: c.mu must be locked.
+func (c *pathMTUCache) removeExpiredLocked(now int64) {
+	for k, e := range c.entries {
+		if now >= e.expiresAt {
+			delete(c.entries, k)
+		}
+	}
+	atomic.StoreInt32(&c.size, int32(len(c.entries)))
+}
+
+// PathMTU returns the path MTU learned for the destination addr, if any.
+//
+// The returned MTU is the maximum size of a network-layer packet, including
+// the network-layer header.
+func (s *Stack) PathMTU(netProto tcpip.NetworkProtocolNumber, addr tcpip.Address) (uint32, bool) {
+	return s.pathMTUs.get(pathMTUKey{netProto: netProto, addr: addr}, s.clock.NowMonotonic())
+}
+
+// UpdatePathMTU records that the path MTU to the destination addr is mtu, as
+// reported by a Packet Too Big or Fragmentation Needed message.
+//
+// As per RFC 1191 section 3 and RFC 8201 section 4, such messages may only
+// reduce a path MTU; increases are only learned through expiry or
+// packetization layer path MTU discovery. MTUs smaller than the minimum MTU of
+// the network protocol are ignored.
+func (s *Stack) UpdatePathMTU(netProto tcpip.NetworkProtocolNumber, addr tcpip.Address, mtu uint32) {
+	if mtu < minPathMTU(netProto) {
+		return
+	}
+
+	now := s.clock.NowMonotonic()
+	key := pathMTUKey{netProto: netProto, addr: addr}
+	c := &s.pathMTUs
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok && now < e.expiresAt && e.mtu <= mtu {
+		return
+	}
+	c.setLocked(key, mtu, now)
+}
+
+// confirmPathMTU records that the path MTU to the destination addr is mtu, as
+// confirmed by packetization layer path MTU discovery. Unlike UpdatePathMTU,
+// the path MTU may be raised.
+//
+// If mtu is not smaller than linkMTU, the path MTU is forgotten as the link
+// MTU already applies.
+func (s *Stack) confirmPathMTU(netProto tcpip.NetworkProtocolNumber, addr tcpip.Address, mtu, linkMTU uint32) {
+	key := pathMTUKey{netProto: netProto, addr: addr}
+	c := &s.pathMTUs
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if mtu >= linkMTU {
+		c.removeLocked(key)
+		return
+	}
+	c.setLocked(key, mtu, s.clock.NowMonotonic())
+}
+
+// PathMTUs returns the path MTUs the stack has learned.
+func (s *Stack) PathMTUs() []PathMTUInfo {
+	now := s.clock.NowMonotonic()
+	c := &s.pathMTUs
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeExpiredLocked(now)
+	infos := make([]PathMTUInfo, 0, len(c.entries))
+	for k, e := range c.entries {
+		infos = append(infos, PathMTUInfo{
+			NetProto:    k.netProto,
+			Destination: k.addr,
+			MTU:         e.mtu,
+			Expires:     time.Duration(e.expiresAt - now),
+		})
+	}
+	return infos
+}
+
+// FlushPathMTU forgets the path MTU learned for the destination addr.
+//
+// Returns tcpip.ErrBadAddress if no path MTU is known for addr.
+func (s *Stack) FlushPathMTU(netProto tcpip.NetworkProtocolNumber, addr tcpip.Address) *tcpip.Error {
+	c := &s.pathMTUs
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.removeLocked(pathMTUKey{netProto: netProto, addr: addr}) {
+		return tcpip.ErrBadAddress
+	}
+	return nil
+}
+
+// FlushPathMTUs forgets all the path MTUs the stack has learned.
+func (s *Stack) FlushPathMTUs() {
+	c := &s.pathMTUs
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[pathMTUKey]pathMTUEntry)
+	atomic.StoreInt32(&c.size, 0)
+}
+
+// minPathMTU returns the smallest path MTU accepted for netProto.
+func minPathMTU(netProto tcpip.NetworkProtocolNumber) uint32 {
+	switch netProto {
+	case header.IPv4ProtocolNumber:
+		return header.IPv4MinimumMTU
+	case header.IPv6ProtocolNumber:
+		return header.IPv6MinimumMTU
+	default:
+		return 0
+	}
+}
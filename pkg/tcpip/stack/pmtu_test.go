@@ -0,0 +1,412 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/link/ethernet"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	pmtuNICID   = 1
+	pmtuLinkMTU = 1500
+	pmtuLocal   = tcpip.Address("\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01")
+	pmtuRemote  = tcpip.Address("\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02")
+)
+
+func newPathMTUStack(t *testing.T) (*stack.Stack, *faketime.ManualClock) {
+	t.Helper()
+
+	clock := faketime.NewManualClock()
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv6.NewProtocol},
+		Clock:            clock,
+	})
+	if err := s.CreateNIC(pmtuNICID, channel.New(0, pmtuLinkMTU, "")); err != nil {
+		t.Fatalf("CreateNIC(%d, _) = %s", pmtuNICID, err)
+	}
+	if err := s.AddAddress(pmtuNICID, ipv6.ProtocolNumber, pmtuLocal); err != nil {
+		t.Fatalf("AddAddress(%d, %d, %s) = %s", pmtuNICID, ipv6.ProtocolNumber, pmtuLocal, err)
+	}
+	s.SetRouteTable([]tcpip.Route{{
+		Destination: header.IPv6EmptySubnet,
+		NIC:         pmtuNICID,
+	}})
+	return s, clock
+}
+
+func findPathMTURoute(t *testing.T, s *stack.Stack) *stack.Route {
+	t.Helper()
+
+	r, err := s.FindRoute(pmtuNICID, pmtuLocal, pmtuRemote, ipv6.ProtocolNumber, false /* multicastLoop */)
+	if err != nil {
+		t.Fatalf("FindRoute(%d, %s, %s, %d, false) = %s", pmtuNICID, pmtuLocal, pmtuRemote, ipv6.ProtocolNumber, err)
+	}
+	return r
+}
+
+func TestPathMTUCache(t *testing.T) {
+	s, clock := newPathMTUStack(t)
+	r := findPathMTURoute(t, s)
+	defer r.Release()
+
+	checkPathMTU := func(wantMTU uint32, wantOK bool) {
+		t.Helper()
+
+		if mtu, ok := s.PathMTU(ipv6.ProtocolNumber, pmtuRemote); mtu != wantMTU || ok != wantOK {
+			t.Errorf("got s.PathMTU(%d, %s) = (%d, %t), want = (%d, %t)", ipv6.ProtocolNumber, pmtuRemote, mtu, ok, wantMTU, wantOK)
+		}
+		wantRouteMTU := uint32(pmtuLinkMTU - header.IPv6MinimumSize)
+		if wantOK {
+			wantRouteMTU = wantMTU - header.IPv6MinimumSize
+		}
+		if got := r.MTU(); got != wantRouteMTU {
+			t.Errorf("got r.MTU() = %d, want = %d", got, wantRouteMTU)
+		}
+		if got, want := r.PathMTU(), wantRouteMTU+header.IPv6MinimumSize; got != want {
+			t.Errorf("got r.PathMTU() = %d, want = %d", got, want)
+		}
+		if got, want := r.MaxMTU(), uint32(pmtuLinkMTU-header.IPv6MinimumSize); got != want {
+			t.Errorf("got r.MaxMTU() = %d, want = %d", got, want)
+		}
+	}
+
+	checkPathMTU(0, false)
+
+	// MTUs smaller than the IPv6 minimum MTU are ignored.
+	s.UpdatePathMTU(ipv6.ProtocolNumber, pmtuRemote, header.IPv6MinimumMTU-1)
+	checkPathMTU(0, false)
+
+	s.UpdatePathMTU(ipv6.ProtocolNumber, pmtuRemote, 1400)
+	checkPathMTU(1400, true)
+
+	// The path MTU may only be lowered.
+	s.UpdatePathMTU(ipv6.ProtocolNumber, pmtuRemote, 1450)
+	checkPathMTU(1400, true)
+	s.UpdatePathMTU(ipv6.ProtocolNumber, pmtuRemote, 1300)
+	checkPathMTU(1300, true)
+
+	infos := s.PathMTUs()
+	if len(infos) != 1 {
+		t.Fatalf("got len(s.PathMTUs()) = %d, want = 1", len(infos))
+	}
+	if got, want := infos[0], (stack.PathMTUInfo{
+		NetProto:    ipv6.ProtocolNumber,
+		Destination: pmtuRemote,
+		MTU:         1300,
+		Expires:     stack.DefaultPathMTUExpiry,
+	}); got != want {
+		t.Errorf("got s.PathMTUs()[0] = %#v, want = %#v", got, want)
+	}
+
+	// The path MTU is forgotten once it expires.
+	clock.Advance(stack.DefaultPathMTUExpiry - time.Nanosecond)
+	checkPathMTU(1300, true)
+	clock.Advance(time.Nanosecond)
+	checkPathMTU(0, false)
+	if infos := s.PathMTUs(); len(infos) != 0 {
+		t.Errorf("got s.PathMTUs() = %#v, want = []", infos)
+	}
+
+	// An expired path MTU may be raised.
+	s.UpdatePathMTU(ipv6.ProtocolNumber, pmtuRemote, 1450)
+	checkPathMTU(1450, true)
+
+	if err := s.FlushPathMTU(ipv6.ProtocolNumber, pmtuRemote); err != nil {
+		t.Fatalf("s.FlushPathMTU(%d, %s) = %s", ipv6.ProtocolNumber, pmtuRemote, err)
+	}
+	checkPathMTU(0, false)
+	if err := s.FlushPathMTU(ipv6.ProtocolNumber, pmtuRemote); err != tcpip.ErrBadAddress {
+		t.Errorf("got s.FlushPathMTU(%d, %s) = %v, want = %s", ipv6.ProtocolNumber, pmtuRemote, err, tcpip.ErrBadAddress)
+	}
+
+	s.UpdatePathMTU(ipv6.ProtocolNumber, pmtuRemote, 1450)
+	s.FlushPathMTUs()
+	checkPathMTU(0, false)
+}
+
+func TestPathMTUExpiryOption(t *testing.T) {
+	s, clock := newPathMTUStack(t)
+
+	const expiry = time.Minute
+	if err := s.SetOption(stack.PathMTUExpiryOption(0)); err != tcpip.ErrInvalidOptionValue {
+		t.Errorf("got s.SetOption(stack.PathMTUExpiryOption(0)) = %v, want = %s", err, tcpip.ErrInvalidOptionValue)
+	}
+	opt := stack.PathMTUExpiryOption(expiry)
+	if err := s.SetOption(opt); err != nil {
+		t.Fatalf("s.SetOption(%#v) = %s", opt, err)
+	}
+	var got stack.PathMTUExpiryOption
+	if err := s.Option(&got); err != nil {
+		t.Fatalf("s.Option(_) = %s", err)
+	}
+	if got != opt {
+		t.Errorf("got s.Option(_) = %d, want = %d", got, opt)
+	}
+
+	s.UpdatePathMTU(ipv6.ProtocolNumber, pmtuRemote, 1400)
+	clock.Advance(expiry)
+	if mtu, ok := s.PathMTU(ipv6.ProtocolNumber, pmtuRemote); ok {
+		t.Errorf("got s.PathMTU(%d, %s) = (%d, true), want = (_, false)", ipv6.ProtocolNumber, pmtuRemote, mtu)
+	}
+}
+
+func TestPLPMTUD(t *testing.T) {
+	s, clock := newPathMTUStack(t)
+	r := findPathMTURoute(t, s)
+	defer r.Release()
+
+	p := stack.NewPLPMTUD(r)
+
+	checkInfo := func(want tcpip.PLPMTUDInfo) {
+		t.Helper()
+
+		if got := p.Info(); got != want {
+			t.Fatalf("got p.Info() = %#v, want = %#v", got, want)
+		}
+	}
+	probe := func(size uint32, acked bool) {
+		t.Helper()
+
+		if err := p.ProbeResult(size, acked); err != nil {
+			t.Fatalf("p.ProbeResult(%d, %t) = %s", size, acked, err)
+		}
+	}
+	lose := func(size uint32) {
+		t.Helper()
+
+		for i := 0; i < 3; i++ {
+			probe(size, false)
+		}
+	}
+	checkPathMTU := func(wantMTU uint32, wantOK bool) {
+		t.Helper()
+
+		if mtu, ok := s.PathMTU(ipv6.ProtocolNumber, pmtuRemote); mtu != wantMTU || ok != wantOK {
+			t.Errorf("got s.PathMTU(%d, %s) = (%d, %t), want = (%d, %t)", ipv6.ProtocolNumber, pmtuRemote, mtu, ok, wantMTU, wantOK)
+		}
+	}
+
+	// The search starts by confirming the base PLPMTU.
+	checkInfo(tcpip.PLPMTUDInfo{State: tcpip.PLPMTUDBase, PLPMTU: header.IPv6MinimumMTU, ProbeSize: header.IPv6MinimumMTU})
+	if err := p.ProbeResult(pmtuLinkMTU+1, true); err != tcpip.ErrInvalidOptionValue {
+		t.Errorf("got p.ProbeResult(%d, true) = %v, want = %s", pmtuLinkMTU+1, err, tcpip.ErrInvalidOptionValue)
+	}
+	probe(header.IPv6MinimumMTU, true)
+
+	// The MTU of the local link is probed first.
+	checkInfo(tcpip.PLPMTUDInfo{State: tcpip.PLPMTUDSearching, PLPMTU: header.IPv6MinimumMTU, ProbeSize: pmtuLinkMTU})
+
+	// A single loss does not end the probe.
+	probe(pmtuLinkMTU, false)
+	checkInfo(tcpip.PLPMTUDInfo{State: tcpip.PLPMTUDSearching, PLPMTU: header.IPv6MinimumMTU, ProbeSize: pmtuLinkMTU})
+	probe(pmtuLinkMTU, false)
+	probe(pmtuLinkMTU, false)
+
+	// The search continues halfway between the PLPMTU and the lost size.
+	checkInfo(tcpip.PLPMTUDInfo{State: tcpip.PLPMTUDSearching, PLPMTU: header.IPv6MinimumMTU, ProbeSize: 1390})
+	probe(1390, true)
+
+	// The path MTU is only recorded once the search completes.
+	checkPathMTU(0, false)
+
+	// Complete the search, finding a path MTU of 1400.
+	for {
+		info := p.Info()
+		if info.State == tcpip.PLPMTUDSearchComplete {
+			break
+		}
+		if info.State != tcpip.PLPMTUDSearching {
+			t.Fatalf("got p.Info() = %#v, want State = %d", info, tcpip.PLPMTUDSearching)
+		}
+		if info.ProbeSize <= 1400 {
+			probe(info.ProbeSize, true)
+		} else {
+			lose(info.ProbeSize)
+		}
+	}
+	checkInfo(tcpip.PLPMTUDInfo{State: tcpip.PLPMTUDSearchComplete, PLPMTU: 1400})
+	checkPathMTU(1400, true)
+	if got, want := r.MTU(), uint32(1400-header.IPv6MinimumSize); got != want {
+		t.Errorf("got r.MTU() = %d, want = %d", got, want)
+	}
+
+	// Repeated losses of packets no larger than the PLPMTU indicate a black
+	// hole.
+	probe(1000, false)
+	probe(1000, true)
+	probe(1000, false)
+	probe(1000, false)
+	checkInfo(tcpip.PLPMTUDInfo{State: tcpip.PLPMTUDSearchComplete, PLPMTU: 1400})
+	probe(1000, false)
+	checkInfo(tcpip.PLPMTUDInfo{State: tcpip.PLPMTUDBase, PLPMTU: header.IPv6MinimumMTU, ProbeSize: header.IPv6MinimumMTU})
+	checkPathMTU(header.IPv6MinimumMTU, true)
+
+	// The path does not support the base PLPMTU.
+	lose(header.IPv6MinimumMTU)
+	checkInfo(tcpip.PLPMTUDInfo{State: tcpip.PLPMTUDError, PLPMTU: header.IPv6MinimumMTU})
+
+	// The search is restarted once the raise timer expires.
+	clock.Advance(600 * time.Second)
+	checkInfo(tcpip.PLPMTUDInfo{State: tcpip.PLPMTUDBase, PLPMTU: header.IPv6MinimumMTU, ProbeSize: header.IPv6MinimumMTU})
+	probe(header.IPv6MinimumMTU, true)
+	probe(pmtuLinkMTU, true)
+	checkInfo(tcpip.PLPMTUDInfo{State: tcpip.PLPMTUDSearchComplete, PLPMTU: pmtuLinkMTU})
+
+	// A PLPMTU equal to the link MTU is not cached.
+	checkPathMTU(0, false)
+}
+
+func TestPLPMTUDPacketTooBig(t *testing.T) {
+	s, _ := newPathMTUStack(t)
+	r := findPathMTURoute(t, s)
+	defer r.Release()
+
+	p := stack.NewPLPMTUD(r)
+	if err := p.ProbeResult(header.IPv6MinimumMTU, true); err != nil {
+		t.Fatalf("p.ProbeResult(%d, true) = %s", header.IPv6MinimumMTU, err)
+	}
+
+	// PTB messages reporting a size no smaller than the probe are ignored.
+	p.PacketTooBig(pmtuLinkMTU - header.IPv6MinimumSize)
+	if got, want := p.Info(), (tcpip.PLPMTUDInfo{State: tcpip.PLPMTUDSearching, PLPMTU: header.IPv6MinimumMTU, ProbeSize: pmtuLinkMTU}); got != want {
+		t.Fatalf("got p.Info() = %#v, want = %#v", got, want)
+	}
+
+	// The size reported by a PTB message is probed next.
+	p.PacketTooBig(1420 - header.IPv6MinimumSize)
+	if got, want := p.Info(), (tcpip.PLPMTUDInfo{State: tcpip.PLPMTUDSearching, PLPMTU: header.IPv6MinimumMTU, ProbeSize: 1420}); got != want {
+		t.Fatalf("got p.Info() = %#v, want = %#v", got, want)
+	}
+	if err := p.ProbeResult(1420, true); err != nil {
+		t.Fatalf("p.ProbeResult(1420, true) = %s", err)
+	}
+	if got, want := p.Info(), (tcpip.PLPMTUDInfo{State: tcpip.PLPMTUDSearchComplete, PLPMTU: 1420}); got != want {
+		t.Fatalf("got p.Info() = %#v, want = %#v", got, want)
+	}
+}
+
+func TestSetNICMTU(t *testing.T) {
+	const vlanNICID = 2
+
+	var events nicEventRecorder
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv6.NewProtocol},
+		NICEventDisp:     &events,
+	})
+	// VLANs are only supported on top of Ethernet NICs.
+	if err := s.CreateNIC(pmtuNICID, ethernet.New(channel.New(0, pmtuLinkMTU, "\x02\x03\x03\x04\x05\x06"))); err != nil {
+		t.Fatalf("CreateNIC(%d, _) = %s", pmtuNICID, err)
+	}
+	if err := s.CreateVLAN(vlanNICID, pmtuNICID, 10, stack.VLANOptions{}); err != nil {
+		t.Fatalf("CreateVLAN(%d, %d, 10, {}) = %s", vlanNICID, pmtuNICID, err)
+	}
+	if err := s.AddAddress(pmtuNICID, ipv6.ProtocolNumber, pmtuLocal); err != nil {
+		t.Fatalf("AddAddress(%d, %d, %s) = %s", pmtuNICID, ipv6.ProtocolNumber, pmtuLocal, err)
+	}
+	s.SetRouteTable([]tcpip.Route{{
+		Destination: header.IPv6EmptySubnet,
+		NIC:         pmtuNICID,
+	}})
+	r := findPathMTURoute(t, s)
+	defer r.Release()
+
+	setMTU := func(mtu uint32) {
+		t.Helper()
+
+		if err := s.SetNICMTU(pmtuNICID, mtu); err != nil {
+			t.Fatalf("s.SetNICMTU(%d, %d): %s", pmtuNICID, mtu, err)
+		}
+	}
+	checkMTU := func(nicMTU, pathMTU uint32, pathMTUOK bool) {
+		t.Helper()
+
+		infos := s.NICInfo()
+		for _, id := range []tcpip.NICID{pmtuNICID, vlanNICID} {
+			if got := infos[id].MTU; got != nicMTU {
+				t.Errorf("got s.NICInfo()[%d].MTU = %d, want = %d", id, got, nicMTU)
+			}
+		}
+		if mtu, ok := s.PathMTU(ipv6.ProtocolNumber, pmtuRemote); mtu != pathMTU || ok != pathMTUOK {
+			t.Errorf("got s.PathMTU(%d, %s) = (%d, %t), want = (%d, %t)", ipv6.ProtocolNumber, pmtuRemote, mtu, ok, pathMTU, pathMTUOK)
+		}
+		want := nicMTU
+		if pathMTUOK && pathMTU < want {
+			want = pathMTU
+		}
+		if got, want := r.MTU(), want-header.IPv6MinimumSize; got != want {
+			t.Errorf("got r.MTU() = %d, want = %d", got, want)
+		}
+	}
+
+	s.UpdatePathMTU(ipv6.ProtocolNumber, pmtuRemote, 1400)
+	checkMTU(pmtuLinkMTU, 1400, true)
+
+	// The path MTU still applies when it is smaller than the MTU of the NIC.
+	setMTU(1450)
+	checkMTU(1450, 1400, true)
+
+	// The path MTU is forgotten once the MTU of the NIC limits it.
+	setMTU(1300)
+	checkMTU(1300, 0, false)
+
+	// Setting the same MTU again doesn't change it.
+	setMTU(1300)
+
+	// The path MTUs capped by the former MTU are forgotten when it's raised.
+	s.UpdatePathMTU(ipv6.ProtocolNumber, pmtuRemote, 1300)
+	setMTU(0)
+	checkMTU(pmtuLinkMTU, 0, false)
+
+	s.UpdatePathMTU(ipv6.ProtocolNumber, pmtuRemote, 1280)
+	setMTU(1400)
+	checkMTU(1400, 1280, true)
+
+	want := []nicMTU{
+		{nicID: pmtuNICID, mtu: 1450},
+		{nicID: vlanNICID, mtu: 1450},
+		{nicID: pmtuNICID, mtu: 1300},
+		{nicID: vlanNICID, mtu: 1300},
+		{nicID: pmtuNICID, mtu: pmtuLinkMTU},
+		{nicID: vlanNICID, mtu: pmtuLinkMTU},
+		{nicID: pmtuNICID, mtu: 1400},
+		{nicID: vlanNICID, mtu: 1400},
+	}
+	if diff := cmp.Diff(want, events.mtus, cmp.AllowUnexported(nicMTU{})); diff != "" {
+		t.Errorf("MTU changes mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, mtu := range []uint32{header.IPv4MinimumMTU - 1, pmtuLinkMTU + 1} {
+		if err := s.SetNICMTU(pmtuNICID, mtu); err != tcpip.ErrInvalidOptionValue {
+			t.Errorf("got s.SetNICMTU(%d, %d) = %v, want = %s", pmtuNICID, mtu, err, tcpip.ErrInvalidOptionValue)
+		}
+	}
+	// The MTU of the VLAN NIC is capped by the MTU of its parent.
+	if err := s.SetNICMTU(vlanNICID, 1450); err != tcpip.ErrInvalidOptionValue {
+		t.Errorf("got s.SetNICMTU(%d, 1450) = %v, want = %s", vlanNICID, err, tcpip.ErrInvalidOptionValue)
+	}
+	if err := s.SetNICMTU(3, 1400); err != tcpip.ErrUnknownNICID {
+		t.Errorf("got s.SetNICMTU(3, 1400) = %v, want = %s", err, tcpip.ErrUnknownNICID)
+	}
+}
@@ -16,6 +16,7 @@ package stack
 
 import (
 	"fmt"
+	"time"
 
 	"gvisor.dev/gvisor/pkg/sleep"
 	"gvisor.dev/gvisor/pkg/tcpip"
@@ -54,12 +55,13 @@ type TransportEndpointID struct {
 type ControlType int
 
 // The following are the allowed values for ControlType values.
-// TODO(http://gvisor.dev/issue/3210): Support time exceeded messages.
+// TODO(http://gvisor.dev/issue/3210): Support time exceeded messages over IPv4.
 const (
 	ControlNetworkUnreachable ControlType = iota
 	ControlNoRoute
 	ControlPacketTooBig
 	ControlPortUnreachable
+	ControlTimeExceeded
 	ControlUnknown
 )
 
@@ -103,6 +105,14 @@ type TransportEndpoint interface {
 	Wait()
 }
 
+// NICRemovalHandler is implemented by the transport endpoints which react to
+// the removal of the NIC they're bound to.
+type NICRemovalHandler interface {
+	// HandleNICRemoved is called when the NIC nicID, which the endpoint is
+	// bound to, is removed from the stack.
+	HandleNICRemoved(nicID tcpip.NICID)
+}
+
 // RawTransportEndpoint is the interface that needs to be implemented by raw
 // transport protocol endpoints. RawTransportEndpoints receive the entire
 // packet - including the network and transport headers - as delivered to
@@ -270,6 +280,28 @@ type NetworkHeaderParams struct {
 
 	// TOS refers to TypeOfService or TrafficClass field of the IP-header.
 	TOS uint8
+
+	// FlowLabel refers to the Flow Label field of the IPv6 header. It is
+	// ignored by other network protocols.
+	//
+	// If zero, the network protocol may generate a flow label.
+	FlowLabel uint32
+
+	// PathMTUProbe is true if the packet is a packetization layer path MTU
+	// probe. Probes are sent with the Don't Fragment flag set where applicable
+	// and are not fragmented to fit a known path MTU, as per RFC 8899 section
+	// 4.1.
+	PathMTUProbe bool
+
+	// DontFragment is true if the packet may not be fragmented. IPv4 packets
+	// are sent with the Don't Fragment flag set, and packets that do not fit
+	// in the MTU are rejected with tcpip.ErrMessageTooLong.
+	DontFragment bool
+
+	// IgnorePathMTU is true if the packet is only limited by the MTU of the
+	// outgoing interface, not by the path MTU to its destination or the MTU
+	// of its route.
+	IgnorePathMTU bool
 }
 
 // GroupAddressableEndpoint is an endpoint that supports group addressing.
@@ -287,6 +319,20 @@ type GroupAddressableEndpoint interface {
 	IsInGroup(group tcpip.Address) bool
 }
 
+// SourceGroupAddressableEndpoint is a GroupAddressableEndpoint that supports
+// source-specific group memberships, as used by Source-Specific Multicast.
+type SourceGroupAddressableEndpoint interface {
+	GroupAddressableEndpoint
+
+	// JoinSourceGroup joins the specified group, only accepting traffic sent
+	// by the specified source.
+	JoinSourceGroup(group, source tcpip.Address) *tcpip.Error
+
+	// LeaveSourceGroup attempts to leave a source-specific membership of the
+	// specified group.
+	LeaveSourceGroup(group, source tcpip.Address) *tcpip.Error
+}
+
 // PrimaryEndpointBehavior is an enumeration of an AddressEndpoint's primary
 // behavior.
 type PrimaryEndpointBehavior int
@@ -575,6 +621,201 @@ type ForwardingNetworkProtocol interface {
 	SetForwarding(bool)
 }
 
+// ForwardingNetworkEndpoint is a network endpoint that may forward packets
+// independently of other endpoints of the same protocol.
+type ForwardingNetworkEndpoint interface {
+	NetworkEndpoint
+
+	// Forwarding returns the forwarding configuration for the endpoint.
+	Forwarding() bool
+
+	// SetForwarding sets the forwarding configuration for the endpoint.
+	SetForwarding(bool)
+}
+
+// AddressLifetimes holds the preferred and valid lifetimes of an address, as
+// per the IFA_CACHEINFO attribute of Linux's RTM_NEWADDR messages.
+//
+// A lifetime of header.NDPInfiniteLifetime or greater never expires.
+type AddressLifetimes struct {
+	// PreferredLifetime is the duration the address remains preferred for. The
+	// address is deprecated when its preferred lifetime expires, so it is
+	// avoided when selecting the source address of new connections.
+	//
+	// Must not be greater than ValidLifetime.
+	PreferredLifetime time.Duration
+
+	// ValidLifetime is the duration the address remains valid for. The
+	// address is removed when its valid lifetime expires.
+	//
+	// Must be greater than 0.
+	ValidLifetime time.Duration
+}
+
+// valid returns true if the lifetimes may be assigned to an address.
+func (l AddressLifetimes) valid() bool {
+	return l.ValidLifetime > 0 && l.PreferredLifetime >= 0 && l.PreferredLifetime <= l.ValidLifetime
+}
+
+// AddressLifetimesNetworkEndpoint is a network endpoint that may deprecate and
+// remove permanent addresses when their lifetimes expire.
+type AddressLifetimesNetworkEndpoint interface {
+	NetworkEndpoint
+
+	// SetAddressLifetimes sets the lifetimes of a permanent address, replacing
+	// any lifetimes previously set. Addresses have infinite lifetimes until
+	// their lifetimes are set.
+	//
+	// Returns tcpip.ErrBadLocalAddress if the endpoint does not have the
+	// permanent address, and tcpip.ErrNotSupported if the address may not have
+	// lifetimes, e.g. because its lifetimes are managed by the endpoint.
+	SetAddressLifetimes(addr tcpip.Address, lifetimes AddressLifetimes) *tcpip.Error
+}
+
+// UnicastSourceAndMulticastDestination is a tuple that represents a unicast
+// source address and a multicast destination address.
+type UnicastSourceAndMulticastDestination struct {
+	// Source represents a unicast source address.
+	Source tcpip.Address
+
+	// Destination represents a multicast destination address.
+	Destination tcpip.Address
+}
+
+// MulticastRouteOutgoingInterface represents an outgoing interface in a
+// multicast route.
+type MulticastRouteOutgoingInterface struct {
+	// ID corresponds to the outgoing NIC.
+	ID tcpip.NICID
+
+	// MinTTL represents the minimum TTL/HopLimit a multicast packet must have
+	// to be sent through the outgoing interface.
+	MinTTL uint8
+}
+
+// MulticastRoute is a multicast route.
+type MulticastRoute struct {
+	// ExpectedInputInterface is the interface on which packets using this
+	// route are expected to ingress.
+	ExpectedInputInterface tcpip.NICID
+
+	// OutgoingInterfaces is the set of interfaces that a multicast packet
+	// should be forwarded out of.
+	//
+	// Packets matching a route with no outgoing interfaces are dropped.
+	OutgoingInterfaces []MulticastRouteOutgoingInterface
+}
+
+// MulticastPacketContext is the context in which a multicast packet triggered
+// a multicast forwarding event.
+type MulticastPacketContext struct {
+	// SourceAndDestination contains the unicast source address and the
+	// multicast destination address found in the relevant multicast packet.
+	SourceAndDestination UnicastSourceAndMulticastDestination
+
+	// InputInterface is the interface on which the relevant multicast packet
+	// arrived.
+	InputInterface tcpip.NICID
+}
+
+// MulticastForwardingEventDispatcher is the interface that integrators should
+// implement to handle multicast routing events, such as the upcalls a
+// multicast routing daemon relies on.
+type MulticastForwardingEventDispatcher interface {
+	// OnMissingRoute is called when an incoming multicast packet does not
+	// match any installed route.
+	//
+	// The packet that triggered this event may be queued so that it can be
+	// transmitted once a route is installed. Even then, it may still be
+	// dropped as per the routing table's GC/eviction policy.
+	OnMissingRoute(MulticastPacketContext)
+
+	// OnUnexpectedInputInterface is called when a multicast packet arrives at
+	// an interface that does not match the installed route's expected input
+	// interface.
+	//
+	// This may be an indication of a routing loop. The packet that triggered
+	// this event is dropped without being forwarded.
+	OnUnexpectedInputInterface(context MulticastPacketContext, expectedInputInterface tcpip.NICID)
+}
+
+// MulticastForwardingNetworkProtocol is a NetworkProtocol that may forward
+// multicast packets.
+type MulticastForwardingNetworkProtocol interface {
+	NetworkProtocol
+
+	// AddMulticastRoute adds a route to the multicast routing table such that
+	// packets matching the addresses will be forwarded using the provided
+	// route.
+	//
+	// Returns an error if the addresses or route is invalid.
+	AddMulticastRoute(UnicastSourceAndMulticastDestination, MulticastRoute) *tcpip.Error
+
+	// RemoveMulticastRoute removes the route matching the provided addresses
+	// from the multicast routing table.
+	//
+	// Returns an error if the addresses are invalid or a matching route is not
+	// found.
+	RemoveMulticastRoute(UnicastSourceAndMulticastDestination) *tcpip.Error
+
+	// MulticastRouteLastUsedTime returns the monotonic time, in nanoseconds,
+	// at which the route matching the addresses was last used to forward a
+	// packet.
+	//
+	// Returns an error if the addresses are invalid or a matching route was
+	// not found.
+	MulticastRouteLastUsedTime(UnicastSourceAndMulticastDestination) (int64, *tcpip.Error)
+
+	// EnableMulticastForwarding enables multicast forwarding for the protocol.
+	//
+	// Returns true if forwarding was already enabled, in which case the
+	// dispatcher is not updated. Returns an error if the dispatcher is nil.
+	EnableMulticastForwarding(MulticastForwardingEventDispatcher) (bool, *tcpip.Error)
+
+	// DisableMulticastForwarding disables multicast forwarding for the
+	// protocol and removes all installed and pending routes.
+	DisableMulticastForwarding()
+}
+
+// MulticastForwardingNetworkEndpoint is a network endpoint that may forward
+// multicast packets independently of other endpoints of the same protocol.
+type MulticastForwardingNetworkEndpoint interface {
+	NetworkEndpoint
+
+	// MulticastForwarding returns the multicast forwarding configuration for
+	// the endpoint.
+	MulticastForwarding() bool
+
+	// SetMulticastForwarding sets the multicast forwarding configuration for
+	// the endpoint.
+	SetMulticastForwarding(bool)
+}
+
+// RedirectableNetworkEndpoint is a network endpoint that may learn better
+// first-hops for specific destinations through redirect messages.
+type RedirectableNetworkEndpoint interface {
+	NetworkEndpoint
+
+	// RedirectedNextHop returns the first-hop that packets to dst should be
+	// sent to, as learned through a redirect message. An empty next-hop
+	// indicates that dst is a neighbor (on-link).
+	//
+	// Returns false if no redirect is known for dst.
+	RedirectedNextHop(dst tcpip.Address) (tcpip.Address, bool)
+}
+
+// AddressAnnouncer announces the addresses assigned to a NIC to neighboring
+// nodes on the link so they may update their neighbor caches (e.g. with
+// gratuitous ARP).
+type AddressAnnouncer interface {
+	// AnnounceAddress announces that addr is assigned to the NIC.
+	AnnounceAddress(addr tcpip.Address) *tcpip.Error
+
+	// AnnouncedAddressProtocol returns the network protocol of the addresses
+	// the receiver announces.
+	AnnouncedAddressProtocol() tcpip.NetworkProtocolNumber
+}
+
 // NetworkProtocol is the interface that needs to be implemented by network
 // protocols (e.g., ipv4, ipv6) that want to be part of the networking stack.
 type NetworkProtocol interface {
@@ -670,6 +911,11 @@ const (
 	// CapabilitySoftwareGSO indicates the link endpoint supports of sending
 	// multiple packets using a single call (LinkEndpoint.WritePackets).
 	CapabilitySoftwareGSO
+
+	// CapabilityZeroCopyTX indicates that the link endpoint is done with the
+	// payload of outgoing packets once WritePacket(s) returns, so the payload
+	// may reference memory owned by the sender (e.g. with MSG_ZEROCOPY).
+	CapabilityZeroCopyTX
 )
 
 // NetworkLinkEndpoint is a data-link layer that supports sending network
@@ -767,6 +1013,57 @@ type InjectableLinkEndpoint interface {
 	InjectOutbound(dest tcpip.Address, packet []byte) *tcpip.Error
 }
 
+// BusyPoller is a LinkEndpoint whose inbound queues can be polled, as sockets
+// with SO_BUSY_POLL do while they wait for data instead of sleeping.
+type BusyPoller interface {
+	// BusyPoll dispatches the packets pending on the inbound queues of the
+	// endpoint without blocking, and returns true if it dispatched any.
+	BusyPoll() bool
+}
+
+// LinkAddressFilter is a LinkEndpoint which drops the unicast frames which
+// aren't addressed to its link address, unless their destination was added to
+// its filter. The NICs layered on a NIC with a link address of their own, like
+// MACVLAN NICs, add their link address to the filter of the NIC's endpoint.
+type LinkAddressFilter interface {
+	// AddFilterAddress makes the endpoint receive the unicast frames
+	// addressed to addr.
+	AddFilterAddress(addr tcpip.LinkAddress)
+
+	// RemoveFilterAddress makes the endpoint drop the unicast frames addressed
+	// to addr again.
+	RemoveFilterAddress(addr tcpip.LinkAddress)
+}
+
+// CarrierReporter is a LinkEndpoint which knows whether its link is up, like
+// the carrier of a network device in Linux. The link endpoints which don't
+// implement it are assumed to always have a carrier.
+//
+// The endpoint reports the changes of its carrier to its dispatcher if the
+// dispatcher implements CarrierDispatcher.
+type CarrierReporter interface {
+	// Carrier returns true if the link of the endpoint is up.
+	Carrier() bool
+}
+
+// CarrierDispatcher is a NetworkDispatcher which tracks the carrier of the
+// link endpoint attached to it. NICs implement it.
+type CarrierDispatcher interface {
+	// DeliverCarrierChange is called by the link endpoint when its carrier
+	// goes up or down.
+	//
+	// It must not be called while writing packets.
+	DeliverCarrierChange(up bool)
+}
+
+// CarrierChangeHandler is a NetworkEndpoint which reacts to the changes of the
+// carrier of its NIC.
+type CarrierChangeHandler interface {
+	// HandleCarrierChange is called when the carrier of the NIC of the
+	// endpoint goes up or down, while the NIC is enabled.
+	HandleCarrierChange(up bool)
+}
+
 // A LinkAddressResolver is an extension to a NetworkProtocol that
 // can resolve link addresses.
 type LinkAddressResolver interface {
@@ -862,6 +1159,9 @@ type GSO struct {
 
 	// MaxSize is maximum GSO packet size.
 	MaxSize uint32
+
+	// MaxSegs is the maximum number of segments in a GSO packet.
+	MaxSegs uint16
 }
 
 // GSOEndpoint provides access to GSO properties.
@@ -76,6 +76,24 @@ type Route struct {
 	// linkRes is set if link address resolution is enabled for this protocol on
 	// the route's NIC.
 	linkRes LinkAddressResolver
+
+	// mtu is the MTU of the route table entry the route was built from, or 0 if
+	// the entry has none.
+	mtu uint32
+
+	// mtuLocked is true if the route table entry the route was built from has
+	// its MTU locked.
+	mtuLocked bool
+
+	// mark is the mark of the packets the route was found for, which is
+	// given to the packets written to the route.
+	mark uint32
+
+	// forwardingNIC is the NIC whose forwarding configuration allows the
+	// packets of the route to be forwarded from localAddressNIC to
+	// outgoingNIC, or nil if the forwarding configuration of the protocol
+	// does.
+	forwardingNIC *NIC
 }
 
 // constructAndValidateRoute validates and initializes a route. It takes
@@ -225,17 +243,23 @@ func (r *Route) RequiresTXTransportChecksum() bool {
 	if r.local() {
 		return false
 	}
-	return r.outgoingNIC.LinkEndpoint.Capabilities()&CapabilityTXChecksumOffload == 0
+	return r.outgoingNIC.capabilities()&CapabilityTXChecksumOffload == 0
 }
 
 // HasSoftwareGSOCapability returns true if the route supports software GSO.
 func (r *Route) HasSoftwareGSOCapability() bool {
-	return r.outgoingNIC.LinkEndpoint.Capabilities()&CapabilitySoftwareGSO != 0
+	return r.outgoingNIC.capabilities()&CapabilitySoftwareGSO != 0
 }
 
 // HasHardwareGSOCapability returns true if the route supports hardware GSO.
 func (r *Route) HasHardwareGSOCapability() bool {
-	return r.outgoingNIC.LinkEndpoint.Capabilities()&CapabilityHardwareGSO != 0
+	return r.outgoingNIC.capabilities()&CapabilityHardwareGSO != 0
+}
+
+// HasZeroCopyTXCapability returns true if the payload of packets written to
+// the route may reference memory owned by the sender.
+func (r *Route) HasZeroCopyTXCapability() bool {
+	return r.Loop&PacketLoop == 0 && r.outgoingNIC.LinkEndpoint.Capabilities()&CapabilityZeroCopyTX != 0
 }
 
 // HasSaveRestoreCapability returns true if the route supports save/restore.
@@ -248,12 +272,29 @@ func (r *Route) HasDisconncetOkCapability() bool {
 	return r.outgoingNIC.LinkEndpoint.Capabilities()&CapabilityDisconnectOk != 0
 }
 
-// GSOMaxSize returns the maximum GSO packet size.
+// GSOMaxSize returns the maximum GSO packet size, which is limited by both the
+// link and the stack's GSOOption.
 func (r *Route) GSOMaxSize() uint32 {
-	if gso, ok := r.outgoingNIC.LinkEndpoint.(GSOEndpoint); ok {
-		return gso.GSOMaxSize()
+	gso, ok := r.outgoingNIC.LinkEndpoint.(GSOEndpoint)
+	if !ok {
+		return 0
 	}
-	return 0
+	size := gso.GSOMaxSize()
+	s := r.outgoingNIC.stack
+	s.mu.RLock()
+	if size > s.gso.MaxSize {
+		size = s.gso.MaxSize
+	}
+	s.mu.RUnlock()
+	return size
+}
+
+// GSOMaxSegs returns the maximum number of segments in a GSO packet.
+func (r *Route) GSOMaxSegs() uint16 {
+	s := r.outgoingNIC.stack
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.gso.MaxSegs
 }
 
 // ResolveWith immediately resolves a route with the specified remote link
@@ -371,21 +412,42 @@ func (r *Route) isValidForOutgoingRLocked() bool {
 		return false
 	}
 
-	// If the source NIC and outgoing NIC are different, make sure the stack has
-	// forwarding enabled, or the packet will be handled locally.
-	if r.outgoingNIC != r.localAddressNIC && !r.outgoingNIC.stack.Forwarding(r.NetProto) && (!r.outgoingNIC.stack.handleLocal || !r.outgoingNIC.hasAddress(r.NetProto, r.RemoteAddress)) {
+	// If the source NIC and outgoing NIC are different, make sure forwarding
+	// is enabled, or the packet will be handled locally.
+	if r.outgoingNIC != r.localAddressNIC && !r.canForward() && (!r.outgoingNIC.stack.handleLocal || !r.outgoingNIC.hasAddress(r.NetProto, r.RemoteAddress)) {
 		return false
 	}
 
 	return true
 }
 
+// canForward returns true if the packets of r may be forwarded from the NIC
+// of its local address to its outgoing NIC.
+func (r *Route) canForward() bool {
+	if r.forwardingNIC != nil {
+		return r.forwardingNIC.canForward(r.NetProto)
+	}
+	return r.outgoingNIC.stack.Forwarding(r.NetProto)
+}
+
+// invalidForOutgoingErr returns the error of the writes to a route which isn't
+// valid for outgoing traffic.
+func (r *Route) invalidForOutgoingErr() *tcpip.Error {
+	if r.outgoingNIC.isRemoved() {
+		return tcpip.ErrUnknownDevice
+	}
+	return tcpip.ErrInvalidEndpointState
+}
+
 // WritePacket writes the packet through the given route.
 func (r *Route) WritePacket(gso *GSO, params NetworkHeaderParams, pkt *PacketBuffer) *tcpip.Error {
 	if !r.isValidForOutgoing() {
-		return tcpip.ErrInvalidEndpointState
+		return r.invalidForOutgoingErr()
 	}
 
+	// TODO(gvisor.dev/issue/170): Route the packets again when the rules of
+	// the Output hook change their mark, as Linux does.
+	pkt.Mark = r.mark
 	return r.outgoingNIC.getNetworkEndpoint(r.NetProto).WritePacket(r, gso, params, pkt)
 }
 
@@ -393,9 +455,12 @@ func (r *Route) WritePacket(gso *GSO, params NetworkHeaderParams, pkt *PacketBuf
 // the number of packets written.
 func (r *Route) WritePackets(gso *GSO, pkts PacketBufferList, params NetworkHeaderParams) (int, *tcpip.Error) {
 	if !r.isValidForOutgoing() {
-		return 0, tcpip.ErrInvalidEndpointState
+		return 0, r.invalidForOutgoingErr()
 	}
 
+	for pkt := pkts.Front(); pkt != nil; pkt = pkt.Next() {
+		pkt.Mark = r.mark
+	}
 	return r.outgoingNIC.getNetworkEndpoint(r.NetProto).WritePackets(r, gso, pkts, params)
 }
 
@@ -403,9 +468,10 @@ func (r *Route) WritePackets(gso *GSO, pkts PacketBufferList, params NetworkHead
 // header through the given route.
 func (r *Route) WriteHeaderIncludedPacket(pkt *PacketBuffer) *tcpip.Error {
 	if !r.isValidForOutgoing() {
-		return tcpip.ErrInvalidEndpointState
+		return r.invalidForOutgoingErr()
 	}
 
+	pkt.Mark = r.mark
 	return r.outgoingNIC.getNetworkEndpoint(r.NetProto).WriteHeaderIncludedPacket(r, pkt)
 }
 
@@ -414,8 +480,107 @@ func (r *Route) DefaultTTL() uint8 {
 	return r.outgoingNIC.getNetworkEndpoint(r.NetProto).DefaultTTL()
 }
 
-// MTU returns the MTU of the underlying network endpoint.
+// MTU returns the MTU of the underlying network endpoint, or the MTU of the
+// path to the route's destination if it is known and smaller.
 func (r *Route) MTU() uint32 {
+	mtu := r.outgoingNIC.getNetworkEndpoint(r.NetProto).MTU()
+	if pmtu, ok := r.PathMTULimit(); ok {
+		// The path MTU includes the network-layer header.
+		if hdrLen := r.networkHeaderLength(); pmtu > hdrLen && pmtu-hdrLen < mtu {
+			mtu = pmtu - hdrLen
+		}
+	}
+	return mtu
+}
+
+// PathMTULimit returns the limit on the size of network-layer packets,
+// including the network-layer header, sent on the route that does not come
+// from the outgoing NIC: the smallest of the MTU of the route table entry and,
+// unless the entry's MTU is locked, the path MTU learned for the route's
+// destination.
+//
+// Returns false if there is no such limit.
+func (r *Route) PathMTULimit() (uint32, bool) {
+	mtu, ok := r.mtu, r.mtu != 0
+	if r.mtuLocked {
+		return mtu, ok
+	}
+	if pmtu, pok := r.outgoingNIC.stack.PathMTU(r.NetProto, r.RemoteAddress); pok && (!ok || pmtu < mtu) {
+		mtu, ok = pmtu, true
+	}
+	return mtu, ok
+}
+
+// MTULocked returns true if the MTU of the route table entry the route was
+// built from is locked.
+func (r *Route) MTULocked() bool {
+	return r.mtuLocked
+}
+
+// PMTUDiscoveryParams returns whether a packet carrying size bytes of
+// network-layer payload, sent on the route by an endpoint whose path MTU
+// discovery setting is mode, may not be fragmented and whether it ignores the
+// path MTU, for use in NetworkHeaderParams. mode is one of the
+// tcpip.PMTUDiscovery* values.
+//
+// Returns tcpip.ErrMessageTooLong if the packet may not be fragmented but does
+// not fit in the MTU that applies to it, as Linux does.
+func (r *Route) PMTUDiscoveryParams(mode, size int) (dontFragment, ignorePathMTU bool, err *tcpip.Error) {
+	mtu := r.pmtuDiscoveryMTU(mode)
+	switch mode {
+	case tcpip.PMTUDiscoveryProbe, tcpip.PMTUDiscoveryInterface, tcpip.PMTUDiscoveryOmit:
+		ignorePathMTU = true
+	}
+
+	switch mode {
+	case tcpip.PMTUDiscoveryWant:
+		// Packets that do not fit are fragmented rather than rejected.
+		return !r.mtuLocked && size <= int(mtu), false, nil
+	case tcpip.PMTUDiscoveryDo, tcpip.PMTUDiscoveryProbe, tcpip.PMTUDiscoveryInterface:
+		if size > int(mtu) {
+			return false, false, tcpip.ErrMessageTooLong
+		}
+		return mode != tcpip.PMTUDiscoveryInterface, ignorePathMTU, nil
+	default:
+		return false, ignorePathMTU, nil
+	}
+}
+
+// PMTUDiscoveryPathMTU returns the maximum size of a network-layer packet,
+// including the network-layer header, that an endpoint whose path MTU
+// discovery setting is mode can send on the route without fragmentation. mode
+// is one of the tcpip.PMTUDiscovery* values.
+func (r *Route) PMTUDiscoveryPathMTU(mode int) uint32 {
+	return r.pmtuDiscoveryMTU(mode) + r.networkHeaderLength()
+}
+
+// pmtuDiscoveryMTU returns the MTU, excluding the network-layer header, that
+// applies to packets sent on the route by an endpoint whose path MTU discovery
+// setting is mode.
+func (r *Route) pmtuDiscoveryMTU(mode int) uint32 {
+	switch mode {
+	case tcpip.PMTUDiscoveryProbe, tcpip.PMTUDiscoveryInterface, tcpip.PMTUDiscoveryOmit:
+		return r.MaxMTU()
+	default:
+		return r.MTU()
+	}
+}
+
+// PathMTU returns the maximum size of a network-layer packet, including the
+// network-layer header, that can be sent on the route without fragmentation.
+func (r *Route) PathMTU() uint32 {
+	return r.MTU() + r.networkHeaderLength()
+}
+
+// networkHeaderLength returns the minimum length of the network-layer header
+// of packets sent on the route.
+func (r *Route) networkHeaderLength() uint32 {
+	return uint32(r.outgoingNIC.stack.networkProtocols[r.NetProto].MinimumPacketSize())
+}
+
+// MaxMTU returns the MTU of the underlying network endpoint. Unlike MTU, it is
+// not limited by the known MTU of the path to the route's destination.
+func (r *Route) MaxMTU() uint32 {
 	return r.outgoingNIC.getNetworkEndpoint(r.NetProto).MTU()
 }
 
@@ -446,6 +611,10 @@ func (r *Route) Clone() *Route {
 		outgoingNIC:      r.outgoingNIC,
 		linkCache:        r.linkCache,
 		linkRes:          r.linkRes,
+		mtu:              r.mtu,
+		mtuLocked:        r.mtuLocked,
+		mark:             r.mark,
+		forwardingNIC:    r.forwardingNIC,
 	}
 
 	newRoute.mu.Lock()
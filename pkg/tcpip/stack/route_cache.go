@@ -0,0 +1,165 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"sync/atomic"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// forwardingRouteCacheSize is the number of RouteCaches of a
+// ForwardingRouteCache.
+const forwardingRouteCacheSize = 64
+
+// RouteCache caches the route found for a flow, like the destination cache of
+// Linux's sockets, so that the packets of the flow are sent without finding a
+// route for each of them.
+//
+// The cached route is used until the route generation of the stack changes,
+// which happens when the route tables, the routing policy, the NICs or their
+// addresses change.
+//
+// The zero value is an empty cache. It is safe for concurrent use.
+type RouteCache struct {
+	mu sync.Mutex
+
+	// The fields below are protected by mu.
+
+	// key holds the arguments the route was found with.
+	key routeCacheKey
+
+	// route is the cached route, or nil.
+	route *Route
+
+	// gen is the route generation of the stack when the route was found.
+	gen uint64
+}
+
+// routeCacheKey holds the arguments of FindRouteWithCache.
+type routeCacheKey struct {
+	id            tcpip.NICID
+	localAddr     tcpip.Address
+	remoteAddr    tcpip.Address
+	netProto      tcpip.NetworkProtocolNumber
+	multicastLoop bool
+	policy        RoutePolicyKey
+}
+
+// Release releases the cached route, if any.
+func (c *RouteCache) Release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.releaseLocked()
+}
+
+// releaseLocked releases the cached route, if any.
+//
+// Precondition: c.mu must be locked.
+func (c *RouteCache) releaseLocked() {
+	if c.route != nil {
+		c.route.Release()
+		c.route = nil
+	}
+}
+
+// ForwardingRouteCache caches the routes of the flows of the packets forwarded
+// by a network endpoint. The flows are mapped to a fixed number of RouteCaches
+// by their hash, so that those whose hash collide evict each other.
+//
+// The zero value is an empty cache. It is safe for concurrent use.
+type ForwardingRouteCache struct {
+	caches [forwardingRouteCacheSize]RouteCache
+}
+
+// Release releases the cached routes.
+func (c *ForwardingRouteCache) Release() {
+	for i := range c.caches {
+		c.caches[i].Release()
+	}
+}
+
+// InvalidateRouteCaches increments the route generation of the stack, which
+// invalidates the routes cached by RouteCaches. It must be called when the
+// routes found by FindRoute may change.
+func (s *Stack) InvalidateRouteCaches() {
+	atomic.AddUint64(&s.routeGen, 1)
+}
+
+// FindRouteWithCache is like FindRouteWithPolicy, but returns a copy of the
+// route cached by c if it was found with the same arguments at the current
+// route generation of the stack. Otherwise, the route it finds is cached. If
+// c is nil, nothing is cached.
+//
+// As with FindRoute, the returned route must be released by the caller.
+func (s *Stack) FindRouteWithCache(c *RouteCache, id tcpip.NICID, localAddr, remoteAddr tcpip.Address, netProto tcpip.NetworkProtocolNumber, multicastLoop bool, key RoutePolicyKey) (*Route, *tcpip.Error) {
+	if c == nil {
+		return s.FindRouteWithPolicy(id, localAddr, remoteAddr, netProto, multicastLoop, key)
+	}
+
+	// The transport of the packets is only used to select the next hop of
+	// multipath routes with the MultipathHashL4 policy. Otherwise, the
+	// flows which only differ by their ports share the cached route.
+	if MultipathHashPolicyOption(atomic.LoadUint32(&s.multipathHashPolicy)) != MultipathHashL4 {
+		key.TransportProtocol, key.SrcPort, key.DstPort = 0, 0, 0
+	}
+	k := routeCacheKey{
+		id:            id,
+		localAddr:     localAddr,
+		remoteAddr:    remoteAddr,
+		netProto:      netProto,
+		multicastLoop: multicastLoop,
+		policy:        key,
+	}
+	// The generation is loaded before the route is found, so that the route
+	// is invalidated by the changes concurrent with its lookup.
+	gen := atomic.LoadUint64(&s.routeGen)
+
+	c.mu.Lock()
+	if r := c.route; r != nil && c.gen == gen && c.key == k {
+		// The route may have been made invalid by changes which don't
+		// change the route generation, such as the expiry of its local
+		// address.
+		if r.isValidForOutgoing() {
+			r = r.Clone()
+			c.mu.Unlock()
+			return r, nil
+		}
+	}
+	c.releaseLocked()
+	c.mu.Unlock()
+
+	r, err := s.FindRouteWithPolicy(id, localAddr, remoteAddr, netProto, multicastLoop, key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.releaseLocked()
+	c.key, c.gen, c.route = k, gen, r.Clone()
+	return r, nil
+}
+
+// FindForwardingRoute returns the route through which the packets sent to
+// remoteAddr with the attributes key are forwarded, like FindRouteWithPolicy.
+// The routes of the flows of the packets are cached by c.
+//
+// As with FindRoute, the returned route must be released by the caller.
+func (s *Stack) FindForwardingRoute(c *ForwardingRouteCache, remoteAddr tcpip.Address, netProto tcpip.NetworkProtocolNumber, key RoutePolicyKey) (*Route, *tcpip.Error) {
+	i := s.multipathHash(key.Source, remoteAddr, key) % forwardingRouteCacheSize
+	return s.FindRouteWithCache(&c.caches[i], 0 /* id */, "" /* localAddr */, remoteAddr, netProto, false /* multicastLoop */, key)
+}
@@ -0,0 +1,161 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"encoding/binary"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// Priorities of the default routing policy rules, as in Linux.
+const (
+	mainRouteRulePriority    = 32766
+	defaultRouteRulePriority = 32767
+)
+
+// defaultRouteRules returns the default routing policy rules of netProto,
+// which look up the main route table and then the default route table.
+func defaultRouteRules(netProto tcpip.NetworkProtocolNumber) []tcpip.RouteRule {
+	return []tcpip.RouteRule{
+		{Priority: mainRouteRulePriority, NetProto: netProto, Table: tcpip.MainRouteTable},
+		{Priority: defaultRouteRulePriority, NetProto: netProto, Table: tcpip.DefaultRouteTable},
+	}
+}
+
+// RoutePolicyKey holds the attributes of a packet, other than its addresses,
+// which are matched by the routing policy rules. Along with the addresses, they
+// are also hashed to balance the flows between the next hops of multipath
+// routes.
+type RoutePolicyKey struct {
+	// Source is the source address of the packet if it isn't the local
+	// address of the route, as for forwarded packets.
+	Source tcpip.Address
+
+	// InputNIC is the NIC the packet was received through, or 0 if it is
+	// generated locally.
+	InputNIC tcpip.NICID
+
+	// Mark is the mark of the packet.
+	Mark uint32
+
+	// TOS is the Type of Service or Traffic Class of the packet.
+	TOS uint8
+
+	// TransportProtocol, SrcPort and DstPort are the transport protocol and
+	// ports of the packet, if known. They are only hashed with the
+	// MultipathHashL4 policy.
+	TransportProtocol tcpip.TransportProtocolNumber
+	SrcPort           uint16
+	DstPort           uint16
+}
+
+// SetTransport sets the transport protocol and ports of k to those of pkt, if
+// it holds the header of a TCP or UDP segment.
+func (k *RoutePolicyKey) SetTransport(pkt *PacketBuffer) {
+	switch pkt.TransportProtocolNumber {
+	case header.TCPProtocolNumber, header.UDPProtocolNumber:
+	default:
+		return
+	}
+	v := pkt.TransportHeader().View()
+	if v.IsEmpty() {
+		// The transport header isn't parsed when the stack doesn't have the
+		// transport protocol, as routers may not.
+		var ok bool
+		if v, ok = pkt.Data.PullUp(4); !ok {
+			return
+		}
+	}
+	if len(v) < 4 {
+		return
+	}
+	// The ports of TCP and UDP are at the same offsets.
+	k.TransportProtocol = pkt.TransportProtocolNumber
+	k.SrcPort = binary.BigEndian.Uint16(v)
+	k.DstPort = binary.BigEndian.Uint16(v[2:])
+}
+
+// routeRuleMatches returns true if rule matches the packets sent from src to
+// dst bound to the NIC id, with the attributes key.
+func routeRuleMatches(rule *tcpip.RouteRule, id tcpip.NICID, src, dst tcpip.Address, key RoutePolicyKey) bool {
+	if rule.Source != (tcpip.Subnet{}) && !rule.Source.Contains(src) {
+		return false
+	}
+	if rule.Destination != (tcpip.Subnet{}) && !rule.Destination.Contains(dst) {
+		return false
+	}
+	if (key.Mark^rule.Mark)&rule.MarkMask != 0 {
+		return false
+	}
+	if rule.InputNIC != 0 && rule.InputNIC != key.InputNIC {
+		return false
+	}
+	if rule.OutputNIC != 0 && rule.OutputNIC != id {
+		return false
+	}
+	return rule.TOS == 0 || rule.TOS == key.TOS
+}
+
+// RouteRules returns the routing policy rules of the network protocol, in the
+// order they are evaluated.
+func (s *Stack) RouteRules(netProto tcpip.NetworkProtocolNumber) []tcpip.RouteRule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]tcpip.RouteRule(nil), s.routeRules[netProto]...)
+}
+
+// AddRouteRule adds a rule to the routing policy of the network protocol of
+// the rule. The rule is evaluated after the rules with a lower or equal
+// priority.
+func (s *Stack) AddRouteRule(rule tcpip.RouteRule) *tcpip.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rules, ok := s.routeRules[rule.NetProto]
+	if !ok {
+		return tcpip.ErrUnknownProtocol
+	}
+	i := 0
+	for ; i < len(rules); i++ {
+		if rules[i].Priority > rule.Priority {
+			break
+		}
+	}
+	rules = append(rules, tcpip.RouteRule{})
+	copy(rules[i+1:], rules[i:])
+	rules[i] = rule
+	s.routeRules[rule.NetProto] = rules
+	s.InvalidateRouteCaches()
+	return nil
+}
+
+// RemoveRouteRule removes the first rule of the routing policy of the network
+// protocol which matches, and returns true if there was one.
+func (s *Stack) RemoveRouteRule(netProto tcpip.NetworkProtocolNumber, match func(tcpip.RouteRule) bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rules := s.routeRules[netProto]
+	for i, rule := range rules {
+		if match(rule) {
+			s.routeRules[netProto] = append(rules[:i:i], rules[i+1:]...)
+			s.InvalidateRouteCaches()
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,446 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"math/bits"
+	"sort"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// routeOrderSpacing is the difference between the order keys of consecutive
+// rows when they are numbered, which leaves room for the rows inserted between
+// them.
+const routeOrderSpacing = 1 << 32
+
+// routeEntry is a row of the route table.
+type routeEntry struct {
+	route tcpip.Route
+
+	// order increases with the position of the row in the route table.
+	// Unlike the position, it doesn't change as rows are inserted or removed
+	// before the row.
+	order uint64
+}
+
+// routeTrieKey identifies the trie which holds the rows of a route table
+// whose destination has addresses of a given length.
+type routeTrieKey struct {
+	table   uint32
+	addrLen int
+}
+
+// routeTable is a snapshot of the route table of a stack. It is immutable, so
+// that lookups don't need to hold any lock while updates build a modified copy
+// of it, which only copies the nodes of the tries they modify.
+//
+// The rows of each route table and destination address length are indexed by
+// their destination in a path-compressed binary trie, so that the rows
+// matching an address are found in time proportional to the length of the
+// address rather than to the number of rows.
+type routeTable struct {
+	// entries holds the rows of all the route tables, in order.
+	entries []*routeEntry
+
+	// tries holds the root of the trie of each route table and destination
+	// address length.
+	tries map[routeTrieKey]*routeTrieNode
+
+	// unindexed holds the rows, in order, whose destination has a mask which
+	// isn't a prefix and which therefore can't be held by a trie. They are
+	// matched one by one.
+	unindexed []*routeEntry
+}
+
+// routeTrieNode is a node of a path-compressed binary trie of routes.
+type routeTrieNode struct {
+	// prefix is the destination of the rows held by the node, of which only
+	// the first prefixLen bits may be set. It is a prefix of the destination
+	// of the rows held by the children of the node.
+	prefix    tcpip.Address
+	prefixLen int
+
+	// entries holds the rows whose destination is prefix, in order.
+	entries []*routeEntry
+
+	// children holds the subtries of the destinations whose bit following
+	// prefix is 0 and 1, respectively.
+	children [2]*routeTrieNode
+}
+
+// newRouteTable returns a route table holding routes, in order.
+func newRouteTable(routes []tcpip.Route) *routeTable {
+	rt := &routeTable{
+		entries: make([]*routeEntry, 0, len(routes)),
+		tries:   make(map[routeTrieKey]*routeTrieNode),
+	}
+	for i, route := range routes {
+		e := &routeEntry{route: route, order: uint64(i+1) * routeOrderSpacing}
+		rt.entries = append(rt.entries, e)
+		rt.addToTrie(e)
+	}
+	return rt
+}
+
+// routes returns the rows of the route table, in order.
+func (rt *routeTable) routes() []tcpip.Route {
+	var routes []tcpip.Route
+	for _, e := range rt.entries {
+		routes = append(routes, e.route)
+	}
+	return routes
+}
+
+// match returns the rows of the route table table whose destination contains
+// addr, in order. All the rows of the route table match an empty address.
+func (rt *routeTable) match(table uint32, addr tcpip.Address) []*routeEntry {
+	var matches []*routeEntry
+	if len(addr) == 0 {
+		for _, e := range rt.entries {
+			if e.route.TableID() == table {
+				matches = append(matches, e)
+			}
+		}
+		return matches
+	}
+
+	matches = rt.tries[routeTrieKey{table: table, addrLen: len(addr)}].lookup(addr, matches)
+	for _, e := range rt.unindexed {
+		if e.route.TableID() == table && e.route.Destination.Contains(addr) {
+			matches = append(matches, e)
+		}
+	}
+
+	// The rows held by each node are in order, but those of different nodes
+	// are not if the route table isn't sorted by prefix length. There are few
+	// of them, sort them by insertion.
+	for i := 1; i < len(matches); i++ {
+		for j := i; j > 0 && matches[j].order < matches[j-1].order; j-- {
+			matches[j], matches[j-1] = matches[j-1], matches[j]
+		}
+	}
+	return matches
+}
+
+// tables returns the identifiers of the route tables which may hold rows
+// whose destination has addresses of length addrLen.
+func (rt *routeTable) tables(addrLen int) []uint32 {
+	var tables []uint32
+	add := func(table uint32) {
+		for _, t := range tables {
+			if t == table {
+				return
+			}
+		}
+		tables = append(tables, table)
+	}
+	for k := range rt.tries {
+		if k.addrLen == addrLen {
+			add(k.table)
+		}
+	}
+	for _, e := range rt.unindexed {
+		add(e.route.TableID())
+	}
+	return tables
+}
+
+// insertionIndex returns the index of the route table at which route is
+// inserted: before the first row with a shorter prefix or with the same prefix
+// and a higher metric and, unless after is true, before the rows with the
+// same prefix and metric.
+func (rt *routeTable) insertionIndex(route tcpip.Route, after bool) int {
+	prefixLen := route.Destination.Prefix()
+	for i, e := range rt.entries {
+		r := &e.route
+		if p := r.Destination.Prefix(); p != prefixLen {
+			if p < prefixLen {
+				return i
+			}
+			continue
+		}
+		if r.Metric > route.Metric || (!after && r.Metric == route.Metric) {
+			return i
+		}
+	}
+	return len(rt.entries)
+}
+
+// clone returns a copy of rt, whose tries may be replaced.
+func (rt *routeTable) clone() *routeTable {
+	c := &routeTable{
+		tries:     make(map[routeTrieKey]*routeTrieNode, len(rt.tries)),
+		unindexed: rt.unindexed,
+	}
+	for k, root := range rt.tries {
+		c.tries[k] = root
+	}
+	return c
+}
+
+// insert returns a copy of rt with route inserted at index i.
+func (rt *routeTable) insert(i int, route tcpip.Route) *routeTable {
+	var prev, next uint64
+	if i > 0 {
+		prev = rt.entries[i-1].order
+	}
+	if i < len(rt.entries) {
+		next = rt.entries[i].order
+	} else {
+		next = prev + routeOrderSpacing
+	}
+	if next <= prev || next-prev < 2 {
+		// There is no order key left between the neighbors of the row,
+		// renumber all the rows.
+		routes := make([]tcpip.Route, 0, len(rt.entries)+1)
+		routes = append(routes, rt.routes()[:i]...)
+		routes = append(routes, route)
+		for _, e := range rt.entries[i:] {
+			routes = append(routes, e.route)
+		}
+		return newRouteTable(routes)
+	}
+
+	e := &routeEntry{route: route, order: prev + (next-prev)/2}
+	c := rt.clone()
+	c.entries = make([]*routeEntry, 0, len(rt.entries)+1)
+	c.entries = append(c.entries, rt.entries[:i]...)
+	c.entries = append(c.entries, e)
+	c.entries = append(c.entries, rt.entries[i:]...)
+	c.addToTrie(e)
+	return c
+}
+
+// replace returns a copy of rt in which the route of the row old, if not nil,
+// is replaced with route, and without the rows for which remove returns true.
+func (rt *routeTable) replace(old *routeEntry, route tcpip.Route, remove func(*routeEntry) bool) *routeTable {
+	c := rt.clone()
+	c.entries = make([]*routeEntry, 0, len(rt.entries))
+	for _, e := range rt.entries {
+		switch {
+		case e == old:
+			c.removeFromTrie(e)
+			e = &routeEntry{route: route, order: old.order}
+			c.addToTrie(e)
+		case remove(e):
+			c.removeFromTrie(e)
+			continue
+		}
+		c.entries = append(c.entries, e)
+	}
+	return c
+}
+
+// remove returns a copy of rt without the rows for which match returns true.
+func (rt *routeTable) remove(match func(*routeEntry) bool) *routeTable {
+	return rt.replace(nil, tcpip.Route{}, match)
+}
+
+// addToTrie adds e to the trie of its route table.
+//
+// Precondition: rt must be a copy made by clone or newRouteTable, which isn't
+// visible to the lookups yet.
+func (rt *routeTable) addToTrie(e *routeEntry) {
+	dst := &e.route.Destination
+	prefixLen, ok := prefixLength(dst.Mask())
+	if !ok {
+		rt.unindexed = insertRouteEntry(rt.unindexed, e)
+		return
+	}
+	k := routeTrieKey{table: e.route.TableID(), addrLen: len(dst.ID())}
+	rt.tries[k] = rt.tries[k].insert(dst.ID(), prefixLen, e)
+}
+
+// removeFromTrie removes e from the trie of its route table.
+//
+// Precondition: same as addToTrie.
+func (rt *routeTable) removeFromTrie(e *routeEntry) {
+	dst := &e.route.Destination
+	prefixLen, ok := prefixLength(dst.Mask())
+	if !ok {
+		rt.unindexed = removeRouteEntry(rt.unindexed, e)
+		return
+	}
+	k := routeTrieKey{table: e.route.TableID(), addrLen: len(dst.ID())}
+	if root := rt.tries[k].remove(dst.ID(), prefixLen, e); root != nil {
+		rt.tries[k] = root
+	} else {
+		delete(rt.tries, k)
+	}
+}
+
+// lookup appends the rows held by the trie rooted at n whose destination
+// contains addr to matches, and returns it.
+func (n *routeTrieNode) lookup(addr tcpip.Address, matches []*routeEntry) []*routeEntry {
+	for n != nil && commonPrefixLength(n.prefix, addr, n.prefixLen) == n.prefixLen {
+		matches = append(matches, n.entries...)
+		if n.prefixLen == len(addr)*8 {
+			break
+		}
+		n = n.children[addressBit(addr, n.prefixLen)]
+	}
+	return matches
+}
+
+// insert returns a copy of the trie rooted at n, which may be nil, to which e
+// is added. The destination of e is the first prefixLen bits of addr.
+func (n *routeTrieNode) insert(addr tcpip.Address, prefixLen int, e *routeEntry) *routeTrieNode {
+	if n == nil {
+		return &routeTrieNode{prefix: addr, prefixLen: prefixLen, entries: []*routeEntry{e}}
+	}
+	maxLen := n.prefixLen
+	if prefixLen < maxLen {
+		maxLen = prefixLen
+	}
+	common := commonPrefixLength(n.prefix, addr, maxLen)
+	switch {
+	case common == n.prefixLen && common == prefixLen:
+		c := *n
+		c.entries = insertRouteEntry(n.entries, e)
+		return &c
+	case common == n.prefixLen:
+		// The destination of e is in the subtrie of a child of n.
+		c := *n
+		b := addressBit(addr, common)
+		c.children[b] = n.children[b].insert(addr, prefixLen, e)
+		return &c
+	case common == prefixLen:
+		// The destination of e is a prefix of that of n.
+		c := &routeTrieNode{prefix: addr, prefixLen: prefixLen, entries: []*routeEntry{e}}
+		c.children[addressBit(n.prefix, common)] = n
+		return c
+	default:
+		// The destinations of n and e diverge after their common prefix.
+		c := &routeTrieNode{prefix: maskAddress(addr, common), prefixLen: common}
+		c.children[addressBit(n.prefix, common)] = n
+		c.children[addressBit(addr, common)] = &routeTrieNode{prefix: addr, prefixLen: prefixLen, entries: []*routeEntry{e}}
+		return c
+	}
+}
+
+// remove returns a copy of the trie rooted at n without e, or nil if the trie
+// becomes empty. The destination of e is the first prefixLen bits of addr.
+func (n *routeTrieNode) remove(addr tcpip.Address, prefixLen int, e *routeEntry) *routeTrieNode {
+	if n == nil || n.prefixLen > prefixLen || commonPrefixLength(n.prefix, addr, n.prefixLen) != n.prefixLen {
+		return n
+	}
+	c := *n
+	if n.prefixLen == prefixLen {
+		c.entries = removeRouteEntry(n.entries, e)
+	} else {
+		b := addressBit(addr, n.prefixLen)
+		c.children[b] = n.children[b].remove(addr, prefixLen, e)
+	}
+
+	// Nodes which hold no row are only needed to join two subtries.
+	if len(c.entries) != 0 {
+		return &c
+	}
+	switch {
+	case c.children[0] == nil:
+		return c.children[1]
+	case c.children[1] == nil:
+		return c.children[0]
+	default:
+		return &c
+	}
+}
+
+// insertRouteEntry returns a copy of entries, which is in order, with e
+// inserted in order.
+func insertRouteEntry(entries []*routeEntry, e *routeEntry) []*routeEntry {
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].order > e.order
+	})
+	c := make([]*routeEntry, 0, len(entries)+1)
+	c = append(c, entries[:i]...)
+	c = append(c, e)
+	return append(c, entries[i:]...)
+}
+
+// removeRouteEntry returns a copy of entries without e.
+func removeRouteEntry(entries []*routeEntry, e *routeEntry) []*routeEntry {
+	c := make([]*routeEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry != e {
+			c = append(c, entry)
+		}
+	}
+	return c
+}
+
+// prefixLength returns the length of the prefix of mask, and false if mask
+// isn't a prefix: if it has bits set after a bit which isn't.
+func prefixLength(mask tcpip.AddressMask) (int, bool) {
+	n := 0
+	for i := 0; i < len(mask); i++ {
+		ones := bits.LeadingZeros8(^mask[i])
+		n += ones
+		if ones == 8 {
+			continue
+		}
+		if mask[i]<<ones != 0 {
+			return 0, false
+		}
+		for _, b := range []byte(mask[i+1:]) {
+			if b != 0 {
+				return 0, false
+			}
+		}
+		break
+	}
+	return n, true
+}
+
+// commonPrefixLength returns the length of the longest common prefix of a and
+// b, up to maxLen bits.
+func commonPrefixLength(a, b tcpip.Address, maxLen int) int {
+	n := 0
+	for i := 0; n < maxLen; i++ {
+		if x := a[i] ^ b[i]; x != 0 {
+			n += bits.LeadingZeros8(x)
+			break
+		}
+		n += 8
+	}
+	if n > maxLen {
+		return maxLen
+	}
+	return n
+}
+
+// addressBit returns the bit of addr at index i, counting from the most
+// significant bit of its first byte.
+func addressBit(addr tcpip.Address, i int) int {
+	return int(addr[i/8]>>(7-i%8)) & 1
+}
+
+// maskAddress returns addr with only its first prefixLen bits.
+func maskAddress(addr tcpip.Address, prefixLen int) tcpip.Address {
+	b := []byte(addr)
+	for i := range b {
+		switch {
+		case prefixLen >= 8:
+			prefixLen -= 8
+		case prefixLen > 0:
+			b[i] &^= 0xff >> prefixLen
+			prefixLen = 0
+		default:
+			b[i] = 0
+		}
+	}
+	return tcpip.Address(b)
+}
@@ -0,0 +1,170 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// randomRoute returns a route to a random IPv4 destination, in one of two
+// route tables. Some of the destinations have a mask which isn't a prefix.
+func randomRoute(r *rand.Rand) tcpip.Route {
+	addr := make([]byte, 4)
+	r.Read(addr)
+	mask := tcpip.AddressMask(net.CIDRMask(r.Intn(33), 32))
+	if r.Intn(10) == 0 {
+		mask = tcpip.AddressMask("\xff\x00\xff\x00")
+	}
+	for i := range addr {
+		addr[i] &= mask[i]
+	}
+	subnet, err := tcpip.NewSubnet(tcpip.Address(addr), mask)
+	if err != nil {
+		panic(err)
+	}
+	return tcpip.Route{
+		Destination: subnet,
+		NIC:         tcpip.NICID(r.Intn(1000)),
+		Table:       uint32(r.Intn(2)),
+	}
+}
+
+// randomAddress returns an address which is likely to be contained by the
+// destination of some of routes.
+func randomAddress(r *rand.Rand, routes []tcpip.Route) tcpip.Address {
+	addr := make([]byte, 4)
+	r.Read(addr)
+	if len(routes) == 0 || r.Intn(4) == 0 {
+		return tcpip.Address(addr)
+	}
+	dst := routes[r.Intn(len(routes))].Destination
+	id, mask := dst.ID(), dst.Mask()
+	for i := range addr {
+		addr[i] = id[i] | addr[i]&^mask[i]
+	}
+	return tcpip.Address(addr)
+}
+
+// linearMatch returns the routes of routes which match addr in table, in
+// order.
+func linearMatch(routes []tcpip.Route, table uint32, addr tcpip.Address) []tcpip.Route {
+	var matches []tcpip.Route
+	for _, route := range routes {
+		if route.TableID() == table && route.Destination.Contains(addr) {
+			matches = append(matches, route)
+		}
+	}
+	return matches
+}
+
+func entryRoutes(entries []*routeEntry) []tcpip.Route {
+	var routes []tcpip.Route
+	for _, e := range entries {
+		routes = append(routes, e.route)
+	}
+	return routes
+}
+
+func TestRouteTableMatch(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	rt := newRouteTable(nil)
+	var routes []tcpip.Route
+
+	for i := 0; i < 1000; i++ {
+		prev, prevRoutes := rt, routes
+
+		switch op := r.Intn(10); {
+		case op == 0:
+			// Insert routes at the same index repeatedly, so that the order
+			// keys between their neighbors are exhausted.
+			j := r.Intn(len(routes) + 1)
+			for k := 0; k < 40; k++ {
+				route := randomRoute(r)
+				rt = rt.insert(j, route)
+				routes = append(append(append([]tcpip.Route(nil), routes[:j]...), route), routes[j:]...)
+			}
+		case op < 6 || len(routes) == 0:
+			route := randomRoute(r)
+			j := r.Intn(len(routes) + 1)
+			rt = rt.insert(j, route)
+			routes = append(append(append([]tcpip.Route(nil), routes[:j]...), route), routes[j:]...)
+		case op < 8:
+			j := r.Intn(len(routes))
+			route := randomRoute(r)
+			old := rt.entries[j]
+			rt = rt.replace(old, route, func(*routeEntry) bool { return false })
+			routes = append([]tcpip.Route(nil), routes...)
+			routes[j] = route
+		default:
+			nic := tcpip.NICID(r.Intn(1000))
+			rt = rt.remove(func(e *routeEntry) bool { return e.route.NIC >= nic })
+			var filtered []tcpip.Route
+			for _, route := range routes {
+				if route.NIC < nic {
+					filtered = append(filtered, route)
+				}
+			}
+			routes = filtered
+		}
+
+		if diff := cmp.Diff(routes, rt.routes(), cmp.AllowUnexported(tcpip.Subnet{})); diff != "" {
+			t.Fatalf("step %d: routes mismatch (-want +got):\n%s", i, diff)
+		}
+		// Updates must not modify the former snapshot.
+		if diff := cmp.Diff(prevRoutes, prev.routes(), cmp.AllowUnexported(tcpip.Subnet{})); diff != "" {
+			t.Fatalf("step %d: former routes mismatch (-want +got):\n%s", i, diff)
+		}
+
+		for j := 0; j < 10; j++ {
+			addr := randomAddress(r, routes)
+			for _, table := range []uint32{tcpip.MainRouteTable, 1} {
+				want := linearMatch(routes, table, addr)
+				if diff := cmp.Diff(want, entryRoutes(rt.match(table, addr)), cmp.AllowUnexported(tcpip.Subnet{})); diff != "" {
+					t.Fatalf("step %d: match(%d, %s) mismatch (-want +got):\n%s", i, table, addr, diff)
+				}
+				want = linearMatch(prevRoutes, table, addr)
+				if diff := cmp.Diff(want, entryRoutes(prev.match(table, addr)), cmp.AllowUnexported(tcpip.Subnet{})); diff != "" {
+					t.Fatalf("step %d: former match(%d, %s) mismatch (-want +got):\n%s", i, table, addr, diff)
+				}
+			}
+		}
+	}
+}
+
+func TestPrefixLength(t *testing.T) {
+	tests := []struct {
+		mask   tcpip.AddressMask
+		want   int
+		wantOK bool
+	}{
+		{mask: "", want: 0, wantOK: true},
+		{mask: "\x00\x00\x00\x00", want: 0, wantOK: true},
+		{mask: "\xff\xff\xf0\x00", want: 20, wantOK: true},
+		{mask: "\xff\xff\xff\xff", want: 32, wantOK: true},
+		{mask: "\xff\x00\xff\x00", wantOK: false},
+		{mask: "\xff\xd0\x00\x00", wantOK: false},
+	}
+	for _, test := range tests {
+		got, ok := prefixLength(test.mask)
+		if got != test.want || ok != test.wantOK {
+			t.Errorf("prefixLength(%q) = (%d, %t), want = (%d, %t)", test.mask, got, ok, test.want, test.wantOK)
+		}
+	}
+}
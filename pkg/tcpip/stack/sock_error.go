@@ -0,0 +1,108 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// NewSockErrorFromControl returns the extended socket error that describes a
+// control packet delivered to a transport endpoint through HandleControlPacket,
+// for endpoints that have IP_RECVERR or IPV6_RECVERR enabled.
+//
+// id, typ, extra and pkt are the arguments passed to HandleControlPacket:
+// pkt.Data must hold the errant packet from its transport header onwards and
+// pkt.NetworkHeader() must hold the header of the ICMP packet which carried it.
+//
+// Returns nil if the control packet cannot be described as a socket error.
+func NewSockErrorFromControl(id TransportEndpointID, typ ControlType, extra uint32, pkt *PacketBuffer) *tcpip.SockError {
+	sockErr := tcpip.SockError{
+		Payload: pkt.Data.ToView(),
+		Dst: tcpip.FullAddress{
+			Addr: id.RemoteAddress,
+			Port: id.RemotePort,
+		},
+		NetProto: pkt.NetworkProtocolNumber,
+	}
+
+	switch pkt.NetworkProtocolNumber {
+	case header.IPv4ProtocolNumber:
+		sockErr.ErrOrigin = tcpip.SockExtErrorOriginICMP
+		sockErr.ErrType = uint8(header.ICMPv4DstUnreachable)
+		if h := pkt.NetworkHeader().View(); len(h) >= header.IPv4MinimumSize {
+			sockErr.Offender.Addr = header.IPv4(h).SourceAddress()
+		}
+
+		switch typ {
+		case ControlNetworkUnreachable:
+			sockErr.Err = tcpip.ErrNetworkUnreachable
+			sockErr.ErrCode = uint8(header.ICMPv4NetUnreachable)
+		case ControlNoRoute:
+			sockErr.Err = tcpip.ErrNoRoute
+			sockErr.ErrCode = uint8(header.ICMPv4HostUnreachable)
+		case ControlPortUnreachable:
+			sockErr.Err = tcpip.ErrConnectionRefused
+			sockErr.ErrCode = uint8(header.ICMPv4PortUnreachable)
+		case ControlPacketTooBig:
+			sockErr.Err = tcpip.ErrMessageTooLong
+			sockErr.ErrCode = uint8(header.ICMPv4FragmentationNeeded)
+			if extra != 0 {
+				// extra is the network-layer payload MTU.
+				sockErr.ErrInfo = extra + header.IPv4MinimumSize
+			}
+		default:
+			return nil
+		}
+
+	case header.IPv6ProtocolNumber:
+		sockErr.ErrOrigin = tcpip.SockExtErrorOriginICMP6
+		sockErr.ErrType = uint8(header.ICMPv6DstUnreachable)
+		if h := pkt.NetworkHeader().View(); len(h) >= header.IPv6MinimumSize {
+			sockErr.Offender.Addr = header.IPv6(h).SourceAddress()
+		}
+
+		switch typ {
+		case ControlNetworkUnreachable:
+			sockErr.Err = tcpip.ErrNetworkUnreachable
+			sockErr.ErrCode = uint8(header.ICMPv6NetworkUnreachable)
+		case ControlNoRoute:
+			sockErr.Err = tcpip.ErrNoRoute
+			sockErr.ErrCode = uint8(header.ICMPv6AddressUnreachable)
+		case ControlPortUnreachable:
+			sockErr.Err = tcpip.ErrConnectionRefused
+			sockErr.ErrCode = uint8(header.ICMPv6PortUnreachable)
+		case ControlPacketTooBig:
+			sockErr.Err = tcpip.ErrMessageTooLong
+			sockErr.ErrType = uint8(header.ICMPv6PacketTooBig)
+			if extra != 0 {
+				// extra is the network-layer payload MTU.
+				sockErr.ErrInfo = extra + header.IPv6MinimumSize
+			}
+		case ControlTimeExceeded:
+			sockErr.Err = tcpip.ErrNoRoute
+			sockErr.ErrType = uint8(header.ICMPv6TimeExceeded)
+			// extra is the code of the ICMPv6 message.
+			sockErr.ErrCode = uint8(extra)
+		default:
+			return nil
+		}
+
+	default:
+		return nil
+	}
+
+	return &sockErr
+}
@@ -74,6 +74,23 @@ type TCPCubicState struct {
 	WEst                    float64
 }
 
+// TCPBBRState is used to hold a copy of the internal BBR state when the
+// TCPProbeFunc is invoked.
+type TCPBBRState struct {
+	Mode        string
+	MaxBW       float64
+	MinRTT      time.Duration
+	RoundCount  uint64
+	PacingGain  float64
+	CwndGain    float64
+	FilledPipe  bool
+	PacingRate  uint64
+	CycleIndex  int
+	PriorCwnd   int
+	FullBW      float64
+	FullBWCount int
+}
+
 // TCPRACKState is used to hold a copy of the internal RACK state when the
 // TCPProbeFunc is invoked.
 type TCPRACKState struct {
@@ -222,6 +239,9 @@ type TCPSenderState struct {
 	// Cubic holds the state related to CUBIC congestion control.
 	Cubic TCPCubicState
 
+	// BBR holds the state related to BBR congestion control.
+	BBR TCPBBRState
+
 	// RACKState holds the state related to RACK loss detection algorithm.
 	RACKState TCPRACKState
 }
@@ -385,14 +405,31 @@ type Stack struct {
 	mu   sync.RWMutex
 	nics map[tcpip.NICID]*NIC
 
+	// mtuMu serializes the changes to the MTUs of the NICs, so they're
+	// reported in order.
+	mtuMu sync.Mutex
+
 	// cleanupEndpointsMu protects cleanupEndpoints.
 	cleanupEndpointsMu sync.Mutex
 	cleanupEndpoints   map[TransportEndpoint]struct{}
 
-	// route is the route table passed in by the user via SetRouteTable(),
-	// it is used by FindRoute() to build a route for a specific
-	// destination.
-	routeTable []tcpip.Route
+	// routeMu serializes the updates of the route table.
+	routeMu sync.Mutex
+
+	// routes holds the *routeTable passed in by the user via
+	// SetRouteTable() and modified since, which is used by FindRoute() to
+	// build a route for a specific destination. It holds the rows of all
+	// the route tables. Updates replace it rather than modify it, so that
+	// lookups aren't stalled by them.
+	routes atomic.Value
+
+	// routeGen is the route generation of the stack, which is incremented
+	// atomically to invalidate the routes cached by RouteCaches.
+	routeGen uint64
+
+	// routeRules holds the routing policy rules of each network protocol,
+	// sorted by priority. They select the route tables FindRoute() looks up.
+	routeRules map[tcpip.NetworkProtocolNumber][]tcpip.RouteRule
 
 	*ports.PortManager
 
@@ -418,6 +455,15 @@ type Stack struct {
 	// by the stack.
 	icmpRateLimiter *ICMPRateLimiter
 
+	// flowLabels holds the IPv6 flow labels leased by endpoints.
+	flowLabels flowLabelTable
+
+	// pathMTUs holds the path MTUs learned for destinations.
+	pathMTUs pathMTUCache
+
+	// packetFanouts holds the fanout groups of packet endpoints.
+	packetFanouts packetFanoutTable
+
 	// seed is a one-time random value initialized at stack startup
 	// and is used to seed the TCP port picking on active connections
 	//
@@ -435,6 +481,23 @@ type Stack struct {
 	// integrator NUD related events.
 	nudDisp NUDDispatcher
 
+	// dadDisp is the DAD event dispatcher that is used to send the netstack
+	// integrator the results of DAD requested when adding addresses.
+	dadDisp DADDispatcher
+
+	// nicEventDisp is the event dispatcher that is used to send the netstack
+	// integrator NIC related events.
+	nicEventDisp NICEventDispatcher
+
+	// packetLogger receives the packets logged by LOG rules.
+	packetLogger PacketLogger
+
+	// nflogMu protects nflogListeners.
+	nflogMu sync.RWMutex
+
+	// nflogListeners maps the NFLOG groups to the listener bound to them.
+	nflogListeners map[uint16]NFLogListener
+
 	// uniqueIDGenerator is a generator of unique identifiers.
 	uniqueIDGenerator UniqueID
 
@@ -453,6 +516,21 @@ type Stack struct {
 	// receiveBufferSize holds the min/default/max receive buffer sizes for
 	// endpoints other than TCP.
 	receiveBufferSize ReceiveBufferSizeOption
+
+	// gso holds the limits of GSO packets.
+	gso GSOOption
+
+	// l3mdevAccept is 1 if the packets received by the NICs enslaved to a VRF
+	// device are delivered to the sockets bound to no device, and 0
+	// otherwise.
+	//
+	// Must be accessed using atomic operations.
+	l3mdevAccept uint32
+
+	// multipathHashPolicy holds the MultipathHashPolicyOption of the stack.
+	//
+	// Must be accessed using atomic operations.
+	multipathHashPolicy uint32
 }
 
 // UniqueID is an abstract generator of unique identifiers.
@@ -510,6 +588,15 @@ type Options struct {
 	// receive NUD related events.
 	NUDDisp NUDDispatcher
 
+	// DADDisp is the DAD event dispatcher that an integrator can provide to
+	// receive the results of duplicate address detection performed for
+	// addresses added with AddProtocolAddressWithDAD.
+	DADDisp DADDispatcher
+
+	// NICEventDisp is the event dispatcher that an integrator can provide to
+	// receive NIC related events.
+	NICEventDisp NICEventDispatcher
+
 	// RawFactory produces raw endpoints. Raw endpoints are enabled only if
 	// this is non-nil.
 	RawFactory RawFactory
@@ -524,6 +611,10 @@ type Options struct {
 	// IPTables are the initial iptables rules. If nil, iptables will allow
 	// all traffic.
 	IPTables *IPTables
+
+	// PacketLogger is the backend an integrator can provide to receive the
+	// packets logged by LOG rules. If nil, LOG rules log nothing.
+	PacketLogger PacketLogger
 }
 
 // TransportEndpointInfo holds useful information about a transport endpoint
@@ -636,6 +727,7 @@ func New(opts Options) *Stack {
 		transportProtocols: make(map[tcpip.TransportProtocolNumber]*transportProtocolState),
 		networkProtocols:   make(map[tcpip.NetworkProtocolNumber]NetworkProtocol),
 		linkAddrResolvers:  make(map[tcpip.NetworkProtocolNumber]LinkAddressResolver),
+		routeRules:         make(map[tcpip.NetworkProtocolNumber][]tcpip.RouteRule),
 		nics:               make(map[tcpip.NICID]*NIC),
 		cleanupEndpoints:   make(map[TransportEndpoint]struct{}),
 		linkAddrCache:      newLinkAddrCache(ageLimit, resolutionTimeout, resolutionAttempts),
@@ -650,6 +742,9 @@ func New(opts Options) *Stack {
 		useNeighborCache:   opts.UseNeighborCache,
 		uniqueIDGenerator:  opts.UniqueID,
 		nudDisp:            opts.NUDDisp,
+		dadDisp:            opts.DADDisp,
+		nicEventDisp:       opts.NICEventDisp,
+		packetLogger:       opts.PacketLogger,
 		randomGenerator:    mathrand.New(randSrc),
 		sendBufferSize: SendBufferSizeOption{
 			Min:     MinBufferSize,
@@ -661,13 +756,20 @@ func New(opts Options) *Stack {
 			Default: DefaultBufferSize,
 			Max:     DefaultMaxBufferSize,
 		},
+		gso: GSOOption{
+			MaxSize: SoftwareGSOMaxSize,
+			MaxSegs: DefaultGSOMaxSegs,
+		},
 	}
 	s.linkResQueue.init()
+	s.pathMTUs.init()
+	s.routes.Store(newRouteTable(nil))
 
 	// Add specified network protocols.
 	for _, netProtoFactory := range opts.NetworkProtocols {
 		netProto := netProtoFactory(s)
 		s.networkProtocols[netProto.Number()] = netProto
+		s.routeRules[netProto.Number()] = defaultRouteRules(netProto.Number())
 		if r, ok := netProto.(LinkAddressResolver); ok {
 			s.linkAddrResolvers[r.LinkAddressProtocol()] = r
 		}
@@ -782,8 +884,20 @@ func (s *Stack) Stats() tcpip.Stats {
 	return s.stats
 }
 
+// udpStats returns the stats of transProto, which must be UDP or UDP-Lite.
+func (s *Stack) udpStats(transProto tcpip.TransportProtocolNumber) tcpip.UDPStats {
+	if transProto == header.UDPLiteProtocolNumber {
+		return s.stats.UDPLite
+	}
+	return s.stats.UDP
+}
+
 // SetForwarding enables or disables packet forwarding between NICs for the
 // passed protocol.
+//
+// The configuration is applied to all existing NICs and is used as the default
+// for NICs created afterwards. Use SetNICForwarding to configure forwarding on
+// a specific NIC.
 func (s *Stack) SetForwarding(protocolNum tcpip.NetworkProtocolNumber, enable bool) *tcpip.Error {
 	protocol, ok := s.networkProtocols[protocolNum]
 	if !ok {
@@ -796,6 +910,7 @@ func (s *Stack) SetForwarding(protocolNum tcpip.NetworkProtocolNumber, enable bo
 	}
 
 	forwardingProtocol.SetForwarding(enable)
+	s.InvalidateRouteCaches()
 	return nil
 }
 
@@ -815,43 +930,298 @@ func (s *Stack) Forwarding(protocolNum tcpip.NetworkProtocolNumber) bool {
 	return forwardingProtocol.Forwarding()
 }
 
+// SetNICForwarding enables or disables packet forwarding on the specified NIC
+// for the passed protocol.
+//
+// Packets received on a NIC with forwarding enabled that are not destined to
+// the stack are forwarded. For protocols that differentiate between host and
+// router behaviour (e.g. IPv6 NDP), the NIC operates as a router.
+func (s *Stack) SetNICForwarding(id tcpip.NICID, protocol tcpip.NetworkProtocolNumber, enable bool) *tcpip.Error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nic, ok := s.nics[id]
+	if !ok {
+		return tcpip.ErrUnknownNICID
+	}
+
+	return nic.setForwarding(protocol, enable)
+}
+
+// NICForwarding returns the forwarding configuration of the specified NIC for
+// the passed protocol.
+func (s *Stack) NICForwarding(id tcpip.NICID, protocol tcpip.NetworkProtocolNumber) (bool, *tcpip.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nic, ok := s.nics[id]
+	if !ok {
+		return false, tcpip.ErrUnknownNICID
+	}
+
+	return nic.forwarding(protocol)
+}
+
+// multicastForwardingProtocol returns the multicast forwarding capable network
+// protocol with the passed number.
+func (s *Stack) multicastForwardingProtocol(protocolNum tcpip.NetworkProtocolNumber) (MulticastForwardingNetworkProtocol, *tcpip.Error) {
+	protocol, ok := s.networkProtocols[protocolNum]
+	if !ok {
+		return nil, tcpip.ErrUnknownProtocol
+	}
+
+	forwardingProtocol, ok := protocol.(MulticastForwardingNetworkProtocol)
+	if !ok {
+		return nil, tcpip.ErrNotSupported
+	}
+	return forwardingProtocol, nil
+}
+
+// EnableMulticastForwardingForProtocol enables multicast forwarding for the
+// passed protocol, reporting multicast routing events to disp.
+//
+// Returns true if forwarding was already enabled, in which case disp is not
+// used. Multicast packets are only forwarded when they are received on a NIC
+// with multicast forwarding enabled (see SetNICMulticastForwarding).
+func (s *Stack) EnableMulticastForwardingForProtocol(protocol tcpip.NetworkProtocolNumber, disp MulticastForwardingEventDispatcher) (bool, *tcpip.Error) {
+	forwardingProtocol, err := s.multicastForwardingProtocol(protocol)
+	if err != nil {
+		return false, err
+	}
+	return forwardingProtocol.EnableMulticastForwarding(disp)
+}
+
+// DisableMulticastForwardingForProtocol disables multicast forwarding for the
+// passed protocol and removes all of its multicast routes.
+func (s *Stack) DisableMulticastForwardingForProtocol(protocol tcpip.NetworkProtocolNumber) *tcpip.Error {
+	forwardingProtocol, err := s.multicastForwardingProtocol(protocol)
+	if err != nil {
+		return err
+	}
+	forwardingProtocol.DisableMulticastForwarding()
+	return nil
+}
+
+// AddMulticastRoute adds a multicast route to be used for the specified
+// addresses and protocol.
+//
+// Packets that were queued while waiting for the route are forwarded once it
+// is added.
+func (s *Stack) AddMulticastRoute(protocol tcpip.NetworkProtocolNumber, addresses UnicastSourceAndMulticastDestination, route MulticastRoute) *tcpip.Error {
+	forwardingProtocol, err := s.multicastForwardingProtocol(protocol)
+	if err != nil {
+		return err
+	}
+	return forwardingProtocol.AddMulticastRoute(addresses, route)
+}
+
+// RemoveMulticastRoute removes the multicast route that matches the specified
+// addresses and protocol.
+func (s *Stack) RemoveMulticastRoute(protocol tcpip.NetworkProtocolNumber, addresses UnicastSourceAndMulticastDestination) *tcpip.Error {
+	forwardingProtocol, err := s.multicastForwardingProtocol(protocol)
+	if err != nil {
+		return err
+	}
+	return forwardingProtocol.RemoveMulticastRoute(addresses)
+}
+
+// MulticastRouteLastUsedTime returns the monotonic time, in nanoseconds, at
+// which the multicast route that matches the specified addresses and protocol
+// was last used to forward a packet.
+func (s *Stack) MulticastRouteLastUsedTime(protocol tcpip.NetworkProtocolNumber, addresses UnicastSourceAndMulticastDestination) (int64, *tcpip.Error) {
+	forwardingProtocol, err := s.multicastForwardingProtocol(protocol)
+	if err != nil {
+		return 0, err
+	}
+	return forwardingProtocol.MulticastRouteLastUsedTime(addresses)
+}
+
+// SetNICMulticastForwarding enables or disables multicast packet forwarding on
+// the specified NIC for the passed protocol.
+//
+// Multicast packets received on a NIC with multicast forwarding enabled are
+// forwarded through the protocol's multicast routes, in addition to being
+// delivered locally if the stack joined their group.
+func (s *Stack) SetNICMulticastForwarding(id tcpip.NICID, protocol tcpip.NetworkProtocolNumber, enable bool) *tcpip.Error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nic, ok := s.nics[id]
+	if !ok {
+		return tcpip.ErrUnknownNICID
+	}
+
+	return nic.setMulticastForwarding(protocol, enable)
+}
+
+// NICMulticastForwarding returns the multicast forwarding configuration of
+// the specified NIC for the passed protocol.
+func (s *Stack) NICMulticastForwarding(id tcpip.NICID, protocol tcpip.NetworkProtocolNumber) (bool, *tcpip.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nic, ok := s.nics[id]
+	if !ok {
+		return false, tcpip.ErrUnknownNICID
+	}
+
+	return nic.multicastForwarding(protocol)
+}
+
+// CheckDuplicateAddress performs duplicate address detection for the address
+// on the specified NIC.
+//
+// The completion handler is called once DAD completes. If DAD is disabled for
+// the protocol, the handler is never called.
+func (s *Stack) CheckDuplicateAddress(nicID tcpip.NICID, protocol tcpip.NetworkProtocolNumber, addr tcpip.Address, h DADCompletionHandler) (DADCheckAddressDisposition, *tcpip.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nic, ok := s.nics[nicID]
+	if !ok {
+		return 0, tcpip.ErrUnknownNICID
+	}
+
+	return nic.checkDuplicateAddress(protocol, addr, h)
+}
+
+// SetDADConfigurations sets the duplicate address detection configurations
+// for addresses of the specified protocol on the specified NIC.
+//
+// Note, if c contains invalid values, the default values are used for the
+// erroneous fields.
+func (s *Stack) SetDADConfigurations(nicID tcpip.NICID, protocol tcpip.NetworkProtocolNumber, c DADConfigurations) *tcpip.Error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nic, ok := s.nics[nicID]
+	if !ok {
+		return tcpip.ErrUnknownNICID
+	}
+
+	return nic.setDADConfigurations(protocol, c)
+}
+
 // SetRouteTable assigns the route table to be used by this stack. It
 // specifies which NIC to use for given destination address ranges.
-//
-// This method takes ownership of the table.
 func (s *Stack) SetRouteTable(table []tcpip.Route) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.routeMu.Lock()
+	defer s.routeMu.Unlock()
 
-	s.routeTable = table
+	s.storeRouteTableLocked(newRouteTable(table))
 }
 
 // GetRouteTable returns the route table which is currently in use.
 func (s *Stack) GetRouteTable() []tcpip.Route {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	return append([]tcpip.Route(nil), s.routeTable...)
+	return s.routeTable().routes()
+}
+
+// routeTable returns the snapshot of the route table which is currently in
+// use.
+func (s *Stack) routeTable() *routeTable {
+	return s.routes.Load().(*routeTable)
+}
+
+// storeRouteTableLocked replaces the route table with rt.
+//
+// Precondition: s.routeMu must be locked.
+func (s *Stack) storeRouteTableLocked(rt *routeTable) {
+	s.routes.Store(rt)
+	s.InvalidateRouteCaches()
 }
 
 // AddRoute appends a route to the route table.
 func (s *Stack) AddRoute(route tcpip.Route) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.routeTable = append(s.routeTable, route)
+	s.routeMu.Lock()
+	defer s.routeMu.Unlock()
+
+	rt := s.routeTable()
+	s.storeRouteTableLocked(rt.insert(len(rt.entries), route))
 }
 
-// RemoveRoutes removes matching routes from the route table.
-func (s *Stack) RemoveRoutes(match func(tcpip.Route) bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// InsertRoute adds a route to the route table before the first route with a
+// shorter prefix, or with the same prefix and a higher metric, so that it takes
+// precedence over less specific routes and routes with a higher metric. Routes
+// with the same prefix and metric are used in the order they were inserted.
+func (s *Stack) InsertRoute(route tcpip.Route) {
+	s.routeMu.Lock()
+	defer s.routeMu.Unlock()
+
+	rt := s.routeTable()
+	s.storeRouteTableLocked(rt.insert(rt.insertionIndex(route, true /* after */), route))
+}
+
+// AddRouteOptions holds the options of AddRouteWithOptions, which match the
+// flags of Linux's RTM_NEWROUTE requests.
+type AddRouteOptions struct {
+	// Exclusive makes AddRouteWithOptions fail if the route table of the
+	// route already holds a route with the same destination and metric.
+	Exclusive bool
 
-	var filteredRoutes []tcpip.Route
-	for _, route := range s.routeTable {
-		if !match(route) {
-			filteredRoutes = append(filteredRoutes, route)
+	// Replace makes the route replace the first route of its route table
+	// with the same destination and metric, if any. All the next hops of
+	// the replaced route are replaced if it is a multipath route.
+	Replace bool
+
+	// Append makes the route be added after the routes of its route table
+	// with the same destination and metric, rather than before them.
+	Append bool
+}
+
+// AddRouteWithOptions adds a route to the route table before the first route
+// with a shorter prefix, or with the same prefix and a higher metric, as
+// InsertRoute does, and places it among the routes with the same destination
+// and metric as opts requires.
+//
+// Returns ErrDuplicateAddress if the route table already holds an identical
+// route, through the same gateway and NIC, or any route with the same
+// destination and metric with opts.Exclusive.
+func (s *Stack) AddRouteWithOptions(route tcpip.Route, opts AddRouteOptions) *tcpip.Error {
+	s.routeMu.Lock()
+	defer s.routeMu.Unlock()
+
+	rt := s.routeTable()
+	var first *routeEntry
+	for _, e := range rt.match(route.TableID(), route.Destination.ID()) {
+		existing := &e.route
+		if !sameRouteKey(existing, &route) {
+			continue
 		}
+		if first == nil {
+			first = e
+		}
+		if opts.Exclusive || (!opts.Replace && existing.Gateway == route.Gateway && existing.NIC == route.NIC) {
+			return tcpip.ErrDuplicateAddress
+		}
+	}
+
+	if opts.Replace && first != nil {
+		// All the next hops of a multipath route are replaced.
+		multipath := first.route.Weight != 0
+		s.storeRouteTableLocked(rt.replace(first, route, func(e *routeEntry) bool {
+			return multipath && e.route.Weight != 0 && sameRouteKey(&e.route, &route)
+		}))
+		return nil
 	}
-	s.routeTable = filteredRoutes
+	s.storeRouteTableLocked(rt.insert(rt.insertionIndex(route, opts.Append), route))
+	return nil
+}
+
+// sameRouteKey returns true if a and b are alternative routes to the same
+// destination, in the same route table and with the same metric.
+func sameRouteKey(a, b *tcpip.Route) bool {
+	return a.TableID() == b.TableID() && a.Destination == b.Destination && a.Metric == b.Metric
+}
+
+// RemoveRoutes removes matching routes from the route table. match is called
+// on the routes in the order of the route table.
+func (s *Stack) RemoveRoutes(match func(tcpip.Route) bool) {
+	s.routeMu.Lock()
+	defer s.routeMu.Unlock()
+
+	s.storeRouteTableLocked(s.routeTable().remove(func(e *routeEntry) bool {
+		return match(e.route)
+	}))
 }
 
 // NewEndpoint creates a new transport layer endpoint of the given protocol.
@@ -923,6 +1293,13 @@ func (s *Stack) CreateNICWithOptions(id tcpip.NICID, ep LinkEndpoint, opts NICOp
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	return s.createNICLocked(id, ep, opts, false /* l3mdev */)
+}
+
+// createNICLocked creates a NIC, which is a VRF device if l3mdev is true.
+//
+// Precondition: s.mu must be locked.
+func (s *Stack) createNICLocked(id tcpip.NICID, ep LinkEndpoint, opts NICOptions, l3mdev bool) *tcpip.Error {
 	// Make sure id is unique.
 	if _, ok := s.nics[id]; ok {
 		return tcpip.ErrDuplicateNICID
@@ -938,7 +1315,9 @@ func (s *Stack) CreateNICWithOptions(id tcpip.NICID, ep LinkEndpoint, opts NICOp
 	}
 
 	n := newNIC(s, id, opts.Name, ep, opts.Context)
+	n.l3mdev = l3mdev
 	s.nics[id] = n
+	s.InvalidateRouteCaches()
 	if !opts.Disabled {
 		return n.enable()
 	}
@@ -968,28 +1347,25 @@ func (s *Stack) GetLinkEndpointByName(name string) LinkEndpoint {
 // delivering packets to it.
 func (s *Stack) EnableNIC(id tcpip.NICID) *tcpip.Error {
 	s.mu.RLock()
-	defer s.mu.RUnlock()
-
 	nic, ok := s.nics[id]
+	s.mu.RUnlock()
 	if !ok {
 		return tcpip.ErrUnknownNICID
 	}
 
-	return nic.enable()
+	return nic.setAdminState(true)
 }
 
 // DisableNIC disables the given NIC.
 func (s *Stack) DisableNIC(id tcpip.NICID) *tcpip.Error {
 	s.mu.RLock()
-	defer s.mu.RUnlock()
-
 	nic, ok := s.nics[id]
+	s.mu.RUnlock()
 	if !ok {
 		return tcpip.ErrUnknownNICID
 	}
 
-	nic.disable()
-	return nil
+	return nic.setAdminState(false)
 }
 
 // CheckNIC checks if a NIC is usable.
@@ -1005,38 +1381,63 @@ func (s *Stack) CheckNIC(id tcpip.NICID) bool {
 	return nic.Enabled()
 }
 
-// RemoveNIC removes NIC and all related routes from the network stack.
+// RemoveNIC removes NIC and all related routes from the network stack, along
+// with the NICs layered on it.
+//
+// The transport endpoints bound to a removed NIC are then told about it if
+// they implement NICRemovalHandler, and so is the NIC event dispatcher.
 func (s *Stack) RemoveNIC(id tcpip.NICID) *tcpip.Error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	removed, err := s.removeNICLocked(id)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
 
-	return s.removeNICLocked(id)
+	for _, id := range removed {
+		for _, ep := range s.demux.boundEndpoints(id) {
+			if h, ok := ep.(NICRemovalHandler); ok {
+				h.HandleNICRemoved(id)
+			}
+		}
+		if s.nicEventDisp != nil {
+			s.nicEventDisp.OnNICRemoved(id)
+		}
+	}
+	return nil
 }
 
 // removeNICLocked removes NIC and all related routes from the network stack.
+// It returns the IDs of the NICs removed, the NICs layered on it first.
 //
 // s.mu must be locked.
-func (s *Stack) removeNICLocked(id tcpip.NICID) *tcpip.Error {
+func (s *Stack) removeNICLocked(id tcpip.NICID) ([]tcpip.NICID, *tcpip.Error) {
 	nic, ok := s.nics[id]
 	if !ok {
-		return tcpip.ErrUnknownNICID
+		return nil, tcpip.ErrUnknownNICID
 	}
 	delete(s.nics, id)
+	removed := append(s.removeUpperNICsLocked(nic), id)
+	s.InvalidateRouteCaches()
 
-	// Remove routes in-place. n tracks the number of routes written.
-	n := 0
-	for i, r := range s.routeTable {
-		s.routeTable[i] = tcpip.Route{}
-		if r.NIC != id {
-			// Keep this route.
-			s.routeTable[n] = r
-			n++
+	// The NICs enslaved to a VRF device are released when it is removed.
+	if nic.l3mdev {
+		for _, n := range s.nics {
+			atomic.CompareAndSwapInt32(&n.master, int32(id), 0)
 		}
 	}
 
-	s.routeTable = s.routeTable[:n]
+	s.routeMu.Lock()
+	s.storeRouteTableLocked(s.routeTable().remove(func(e *routeEntry) bool {
+		return e.route.NIC == id
+	}))
+	s.routeMu.Unlock()
+
+	// Static neighbors must not apply to a NIC that is later created with the
+	// same ID.
+	s.linkAddrCache.removeNIC(id)
 
-	return nic.remove()
+	return removed, nic.remove()
 }
 
 // NICInfo captures the name and addresses assigned to a NIC.
@@ -1071,6 +1472,35 @@ func (s *Stack) HasNIC(id tcpip.NICID) bool {
 	return ok
 }
 
+// BusyPoll polls the inbound queues of the NIC id, or of all NICs if id is 0,
+// for sockets with SO_BUSY_POLL. If id is a VRF device, the NICs enslaved to
+// it are polled. It returns true if any packet was dispatched.
+//
+// Only the NICs whose link endpoints implement BusyPoller are polled.
+func (s *Stack) BusyPoll(id tcpip.NICID) bool {
+	var pollers []BusyPoller
+	s.mu.RLock()
+	for _, nic := range s.nics {
+		if id != 0 && !nic.boundToDevice(id) {
+			continue
+		}
+		if p, ok := nic.LinkEndpoint.(BusyPoller); ok && nic.Enabled() {
+			pollers = append(pollers, p)
+		}
+	}
+	s.mu.RUnlock()
+
+	// The packets are dispatched without s.mu held as their delivery may
+	// need it.
+	polled := false
+	for _, p := range pollers {
+		if p.BusyPoll() {
+			polled = true
+		}
+	}
+	return polled
+}
+
 // NICInfo returns a map of NICIDs to their associated information.
 func (s *Stack) NICInfo() map[tcpip.NICID]NICInfo {
 	s.mu.RLock()
@@ -1079,8 +1509,9 @@ func (s *Stack) NICInfo() map[tcpip.NICID]NICInfo {
 	nics := make(map[tcpip.NICID]NICInfo)
 	for id, nic := range s.nics {
 		flags := NICStateFlags{
-			Up:          true, // Netstack interfaces are always up.
-			Running:     nic.Enabled(),
+			Up:          nic.Enabled(),
+			LowerUp:     nic.Carrier(),
+			Running:     nic.Enabled() && nic.Carrier(),
 			Promiscuous: nic.Promiscuous(),
 			Loopback:    nic.IsLoopback(),
 		}
@@ -1089,7 +1520,7 @@ func (s *Stack) NICInfo() map[tcpip.NICID]NICInfo {
 			LinkAddress:       nic.LinkEndpoint.LinkAddress(),
 			ProtocolAddresses: nic.primaryAddresses(),
 			Flags:             flags,
-			MTU:               nic.LinkEndpoint.MTU(),
+			MTU:               nic.MTU(),
 			Stats:             nic.stats,
 			Context:           nic.context,
 			ARPHardwareType:   nic.LinkEndpoint.ARPHardwareType(),
@@ -1100,10 +1531,15 @@ func (s *Stack) NICInfo() map[tcpip.NICID]NICInfo {
 
 // NICStateFlags holds information about the state of an NIC.
 type NICStateFlags struct {
-	// Up indicates whether the interface is running.
+	// Up indicates whether the interface is administratively up, that is
+	// enabled.
 	Up bool
 
-	// Running indicates whether resources are allocated.
+	// LowerUp indicates whether the link of the interface is up, that is
+	// whether it has a carrier.
+	LowerUp bool
+
+	// Running indicates whether the interface is up and has a carrier.
 	Running bool
 
 	// Promiscuous indicates whether the interface is in promiscuous mode.
@@ -1164,6 +1600,78 @@ func (s *Stack) AddProtocolAddressWithOptions(id tcpip.NICID, protocolAddress tc
 	return nic.addAddress(protocolAddress, peb)
 }
 
+// AddProtocolAddressWithLifetimes is the same as AddProtocolAddressWithOptions,
+// but the address is deprecated and removed when its preferred and valid
+// lifetimes expire, like a SLAAC address.
+//
+// Returns tcpip.ErrNotSupported if the NIC's endpoint for the address's
+// protocol does not support address lifetimes, and
+// tcpip.ErrInvalidOptionValue if the lifetimes are invalid.
+func (s *Stack) AddProtocolAddressWithLifetimes(id tcpip.NICID, protocolAddress tcpip.ProtocolAddress, peb PrimaryEndpointBehavior, lifetimes AddressLifetimes) *tcpip.Error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nic, ok := s.nics[id]
+	if !ok {
+		return tcpip.ErrUnknownNICID
+	}
+
+	return nic.addAddressWithLifetimes(protocolAddress, peb, lifetimes)
+}
+
+// SetAddressLifetimes sets the preferred and valid lifetimes of a permanent
+// address on the specified NIC, e.g. when the lease the address was obtained
+// through is renewed.
+//
+// The new lifetimes replace the address's current lifetimes. Addresses added
+// without lifetimes have infinite lifetimes until their lifetimes are set.
+func (s *Stack) SetAddressLifetimes(id tcpip.NICID, protocol tcpip.NetworkProtocolNumber, addr tcpip.Address, lifetimes AddressLifetimes) *tcpip.Error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nic, ok := s.nics[id]
+	if !ok {
+		return tcpip.ErrUnknownNICID
+	}
+
+	return nic.setAddressLifetimes(protocol, addr, lifetimes)
+}
+
+// AddProtocolAddressWithDAD is the same as AddProtocolAddressWithOptions, but
+// also performs duplicate address detection for the address before it is
+// used.
+//
+// The address is tentative until DAD resolves. The result of DAD is delivered
+// to the stack's DADDispatcher. If DAD fails (e.g. a duplicate address is
+// detected), the address is removed from the NIC.
+//
+// Returns tcpip.ErrNotSupported if the NIC cannot perform DAD for addresses of
+// the protocol and tcpip.ErrNotPermitted if the NIC is disabled.
+func (s *Stack) AddProtocolAddressWithDAD(id tcpip.NICID, protocolAddress tcpip.ProtocolAddress, peb PrimaryEndpointBehavior) *tcpip.Error {
+	return s.AddProtocolAddressWithDADHandler(id, protocolAddress, peb, nil)
+}
+
+// AddProtocolAddressWithDADHandler is the same as AddProtocolAddressWithDAD,
+// but also calls h with the result of DAD for this address.
+//
+// h is called exactly once if the address is added successfully, when DAD
+// completes, so callers may wait on h to know when the address is usable or
+// has failed DAD. h may be called before this function returns, e.g. if DAD
+// is disabled.
+//
+// h is not permitted to block indefinitely or to call into the stack.
+func (s *Stack) AddProtocolAddressWithDADHandler(id tcpip.NICID, protocolAddress tcpip.ProtocolAddress, peb PrimaryEndpointBehavior, h DADCompletionHandler) *tcpip.Error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nic, ok := s.nics[id]
+	if !ok {
+		return tcpip.ErrUnknownNICID
+	}
+
+	return nic.addAddressWithDAD(protocolAddress, peb, h)
+}
+
 // RemoveAddress removes an existing network-layer address from the specified
 // NIC.
 func (s *Stack) RemoveAddress(id tcpip.NICID, addr tcpip.Address) *tcpip.Error {
@@ -1276,8 +1784,11 @@ func (s *Stack) findLocalRouteRLocked(localAddressNICID tcpip.NICID, localAddr,
 		localAddr = remoteAddr
 	}
 
-	if localAddressNICID == 0 {
+	if localAddressNICID == 0 || s.isVRFRLocked(localAddressNICID) {
 		for _, localAddressNIC := range s.nics {
+			if !localAddressNIC.boundToDevice(localAddressNICID) {
+				continue
+			}
 			if r := s.findLocalRouteFromNICRLocked(localAddressNIC, localAddr, remoteAddr, netProto); r != nil {
 				return r
 			}
@@ -1301,10 +1812,35 @@ func (s *Stack) findLocalRouteRLocked(localAddressNICID tcpip.NICID, localAddr,
 // disabled. If forwarding is enabled and the NIC is unspecified, the route may
 // leave through any interface unless the route is link-local.
 //
+// If the NIC is a VRF device, the route may leave through any NIC enslaved to
+// it. If no NIC is specified, the route may not leave through such a NIC, as
+// the routes through it belong to the routing domain of the VRF device.
+//
 // If no local address is provided, the stack will select a local address. If no
 // remote address is provided, the stack wil use a remote address equal to the
 // local address.
+//
+// The route tables looked up are selected by the routing policy rules, which
+// are matched against a locally generated packet sent from the local address
+// through the NIC, if specified.
 func (s *Stack) FindRoute(id tcpip.NICID, localAddr, remoteAddr tcpip.Address, netProto tcpip.NetworkProtocolNumber, multicastLoop bool) (*Route, *tcpip.Error) {
+	return s.FindRouteWithPolicy(id, localAddr, remoteAddr, netProto, multicastLoop, RoutePolicyKey{})
+}
+
+// FindRouteWithPolicy is like FindRoute, but the routing policy rules are
+// matched against a packet with the attributes key. The packets written to the
+// returned route are given the mark of key.
+func (s *Stack) FindRouteWithPolicy(id tcpip.NICID, localAddr, remoteAddr tcpip.Address, netProto tcpip.NetworkProtocolNumber, multicastLoop bool, key RoutePolicyKey) (*Route, *tcpip.Error) {
+	r, err := s.findRouteWithPolicy(id, localAddr, remoteAddr, netProto, multicastLoop, key)
+	if err != nil {
+		return nil, err
+	}
+	r.mark = key.Mark
+	return r, nil
+}
+
+// findRouteWithPolicy implements FindRouteWithPolicy.
+func (s *Stack) findRouteWithPolicy(id tcpip.NICID, localAddr, remoteAddr tcpip.Address, netProto tcpip.NetworkProtocolNumber, multicastLoop bool, key RoutePolicyKey) (*Route, *tcpip.Error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -1320,9 +1856,12 @@ func (s *Stack) FindRoute(id tcpip.NICID, localAddr, remoteAddr tcpip.Address, n
 		}
 	}
 
+	// The routes of a VRF device are those through the NICs enslaved to it.
+	isVRF := s.isVRFRLocked(id)
+
 	// If the interface is specified and we do not need a route, return a route
 	// through the interface if the interface is valid and enabled.
-	if id != 0 && !needRoute {
+	if id != 0 && !needRoute && !isVRF {
 		if nic, ok := s.nics[id]; ok && nic.Enabled() {
 			if addressEndpoint := s.getAddressEP(nic, localAddr, remoteAddr, netProto); addressEndpoint != nil {
 				return makeRoute(
@@ -1344,13 +1883,84 @@ func (s *Stack) FindRoute(id tcpip.NICID, localAddr, remoteAddr tcpip.Address, n
 		return nil, tcpip.ErrNetworkUnreachable
 	}
 
-	canForward := s.Forwarding(netProto) && !header.IsV6LinkLocalAddress(localAddr) && !isLinkLocal
+	canForward := s.canForwardRLocked(id, netProto) && !header.IsV6LinkLocalAddress(localAddr) && !isLinkLocal
+
+	src := key.Source
+	if len(src) == 0 {
+		src = localAddr
+	}
+	routes := s.routeTable()
+	for i := range s.routeRules[netProto] {
+		rule := &s.routeRules[netProto][i]
+		if !routeRuleMatches(rule, id, src, remoteAddr, key) {
+			continue
+		}
+		if r, err := s.findRouteInTableRLocked(routes, rule.TableID(), id, localAddr, remoteAddr, netProto, multicastLoop, needRoute, canForward, src, key); r != nil || err != nil {
+			return r, err
+		}
+	}
+
+	if needRoute {
+		return nil, tcpip.ErrNoRoute
+	}
+	if header.IsV6LoopbackAddress(remoteAddr) {
+		return nil, tcpip.ErrBadLocalAddress
+	}
+	return nil, tcpip.ErrNetworkUnreachable
+}
+
+// canForwardRLocked returns true if the packets of the routes bound to the NIC
+// with the given ID may be forwarded through other NICs. The routes which
+// aren't bound to a NIC use the forwarding configuration of the protocol.
+//
+// Precondition: s.mu must be read locked.
+func (s *Stack) canForwardRLocked(id tcpip.NICID, netProto tcpip.NetworkProtocolNumber) bool {
+	if nic, ok := s.nics[id]; ok {
+		return nic.canForward(netProto)
+	}
+	return s.Forwarding(netProto)
+}
+
+// findRouteInTableRLocked is like FindRoute, but only looks up the routes of
+// the route table with the given identifier in routes. It returns neither a route nor
+// an error if the table has no usable route to the remote address, so that
+// the next route table selected by the routing policy is looked up.
+//
+// The next hop of multipath routes is selected by hashing the flow of the
+// packets sent from src with the attributes key.
+//
+// Precondition: s.mu must be read locked.
+func (s *Stack) findRouteInTableRLocked(routes *routeTable, table uint32, id tcpip.NICID, localAddr, remoteAddr tcpip.Address, netProto tcpip.NetworkProtocolNumber, multicastLoop, needRoute, canForward bool, src tcpip.Address, key RoutePolicyKey) (*Route, *tcpip.Error) {
+	isVRF := s.isVRFRLocked(id)
+
+	// usable returns true if a route through nic may be used, either because
+	// it is bound to the NIC or because the packets may be forwarded through
+	// it.
+	usable := func(nic *NIC) bool {
+		return nic.boundToDevice(id) || (canForward && nic.l3MasterDevice() == s.l3MasterDeviceRLocked(id))
+	}
+
+	// multipaths holds the destinations of the multipath routes whose next
+	// hop was already selected.
+	var multipaths []tcpip.Subnet
 
 	// Find a route to the remote with the route table.
 	var chosenRoute tcpip.Route
-	for _, route := range s.routeTable {
-		if len(remoteAddr) != 0 && !route.Destination.Contains(remoteAddr) {
-			continue
+	matches := routes.match(table, remoteAddr)
+	for i, e := range matches {
+		route := e.route
+
+		// The rows of a multipath route are considered as one route, through
+		// the next hop selected for the flow.
+		if route.Weight != 0 {
+			if containsSubnet(multipaths, route.Destination) {
+				continue
+			}
+			multipaths = append(multipaths, route.Destination)
+			var ok bool
+			if route, ok = s.multipathNextHopRLocked(matches[i:], remoteAddr, s.multipathHash(src, remoteAddr, key), usable); !ok {
+				continue
+			}
 		}
 
 		nic, ok := s.nics[route.NIC]
@@ -1358,16 +1968,22 @@ func (s *Stack) FindRoute(id tcpip.NICID, localAddr, remoteAddr tcpip.Address, n
 			continue
 		}
 
-		if id == 0 || id == route.NIC {
+		if nic.boundToDevice(id) {
 			if addressEndpoint := s.getAddressEP(nic, localAddr, remoteAddr, netProto); addressEndpoint != nil {
 				var gateway tcpip.Address
 				if needRoute {
 					gateway = route.Gateway
+					// Prefer a better first-hop learned through a redirect for the
+					// remote, if one is known on the route's interface.
+					if nextHop, ok := nic.redirectedNextHop(netProto, remoteAddr); ok {
+						gateway = nextHop
+					}
 				}
 				r := constructAndValidateRoute(netProto, addressEndpoint, nic /* outgoingNIC */, nic /* outgoingNIC */, gateway, localAddr, remoteAddr, s.handleLocal, multicastLoop)
 				if r == nil {
 					panic(fmt.Sprintf("non-forwarding route validation failed with route table entry = %#v, id = %d, localAddr = %s, remoteAddr = %s", route, id, localAddr, remoteAddr))
 				}
+				r.mtu, r.mtuLocked = route.MTU, route.MTULocked
 				return r, nil
 			}
 		}
@@ -1378,7 +1994,10 @@ func (s *Stack) FindRoute(id tcpip.NICID, localAddr, remoteAddr tcpip.Address, n
 		// is assigned to the outgoing interface. There is no requirement to do this
 		// from any RFC but simply a choice made to better follow a strong host
 		// model which the netstack follows at the time of writing.
-		if canForward && chosenRoute == (tcpip.Route{}) {
+		//
+		// Packets are only forwarded within the routing domain of the
+		// specified NIC.
+		if canForward && chosenRoute == (tcpip.Route{}) && nic.l3MasterDevice() == s.l3MasterDeviceRLocked(id) {
 			chosenRoute = route
 		}
 	}
@@ -1398,10 +2017,12 @@ func (s *Stack) FindRoute(id tcpip.NICID, localAddr, remoteAddr tcpip.Address, n
 		}
 
 		// Use the specified NIC to get the local address endpoint.
-		if id != 0 {
+		if id != 0 && !isVRF {
 			if aNIC, ok := s.nics[id]; ok {
 				if addressEndpoint := s.getAddressEP(aNIC, localAddr, remoteAddr, netProto); addressEndpoint != nil {
 					if r := constructAndValidateRoute(netProto, addressEndpoint, aNIC /* localAddressNIC */, nic /* outgoingNIC */, gateway, localAddr, remoteAddr, s.handleLocal, multicastLoop); r != nil {
+						r.mtu, r.mtuLocked = chosenRoute.MTU, chosenRoute.MTULocked
+						r.forwardingNIC = aNIC
 						return r, nil
 					}
 				}
@@ -1410,29 +2031,28 @@ func (s *Stack) FindRoute(id tcpip.NICID, localAddr, remoteAddr tcpip.Address, n
 			return nil, tcpip.ErrNoRoute
 		}
 
-		if id == 0 {
+		if id == 0 || isVRF {
 			// If an interface is not specified, try to find a NIC that holds the local
 			// address endpoint to construct a route.
 			for _, aNIC := range s.nics {
+				if !aNIC.boundToDevice(id) {
+					continue
+				}
 				addressEndpoint := s.getAddressEP(aNIC, localAddr, remoteAddr, netProto)
 				if addressEndpoint == nil {
 					continue
 				}
 
 				if r := constructAndValidateRoute(netProto, addressEndpoint, aNIC /* localAddressNIC */, nic /* outgoingNIC */, gateway, localAddr, remoteAddr, s.handleLocal, multicastLoop); r != nil {
+					r.mtu, r.mtuLocked = chosenRoute.MTU, chosenRoute.MTULocked
+					r.forwardingNIC = s.nics[id]
 					return r, nil
 				}
 			}
 		}
 	}
 
-	if needRoute {
-		return nil, tcpip.ErrNoRoute
-	}
-	if header.IsV6LoopbackAddress(remoteAddr) {
-		return nil, tcpip.ErrBadLocalAddress
-	}
-	return nil, tcpip.ErrNetworkUnreachable
+	return nil, nil
 }
 
 // CheckNetworkProtocol checks if a given network protocol is enabled in the
@@ -1477,6 +2097,20 @@ func (s *Stack) CheckLocalAddress(nicID tcpip.NICID, protocol tcpip.NetworkProto
 	return 0
 }
 
+// CanBindNonLocal returns true if a socket with options ops may bind to an
+// address of protocol which isn't assigned to any NIC, that is if the socket
+// has IP_FREEBIND set or if the protocol has NonLocalBindOption enabled.
+func (s *Stack) CanBindNonLocal(protocol tcpip.NetworkProtocolNumber, ops *tcpip.SocketOptions) bool {
+	if ops.GetFreeBind() {
+		return true
+	}
+	var nonLocalBind tcpip.NonLocalBindOption
+	if err := s.NetworkProtocolOption(protocol, &nonLocalBind); err != nil {
+		return false
+	}
+	return bool(nonLocalBind)
+}
+
 // SetPromiscuousMode enables or disables promiscuous mode in the given NIC.
 func (s *Stack) SetPromiscuousMode(nicID tcpip.NICID, enable bool) *tcpip.Error {
 	s.mu.RLock()
@@ -1532,6 +2166,9 @@ func (s *Stack) GetLinkAddress(nicID tcpip.NICID, addr, localAddr tcpip.Address,
 }
 
 // Neighbors returns all IP to MAC address associations.
+//
+// If the stack does not use the neighbor cache, learned associations are
+// reported as Reachable until they expire and as Stale afterwards.
 func (s *Stack) Neighbors(nicID tcpip.NICID) ([]NeighborEntry, *tcpip.Error) {
 	s.mu.RLock()
 	nic, ok := s.nics[nicID]
@@ -1568,6 +2205,10 @@ func (s *Stack) RemoveWaker(nicID tcpip.NICID, addr tcpip.Address, waker *sleep.
 }
 
 // AddStaticNeighbor statically associates an IP address to a MAC address.
+//
+// Static neighbors are used without resolution and are never replaced by
+// learned link addresses or removed by Neighbor Unreachability Detection;
+// they remain until they are removed with RemoveNeighbor or ClearNeighbors.
 func (s *Stack) AddStaticNeighbor(nicID tcpip.NICID, addr tcpip.Address, linkAddr tcpip.LinkAddress) *tcpip.Error {
 	s.mu.RLock()
 	nic, ok := s.nics[nicID]
@@ -1616,6 +2257,14 @@ func (s *Stack) RegisterTransportEndpoint(nicID tcpip.NICID, netProtos []tcpip.N
 	return s.demux.registerEndpoint(netProtos, protocol, id, ep, flags, bindToDevice)
 }
 
+// SetReusePortFilter attaches filter to the SO_REUSEPORT group which the
+// endpoint registered with the given parameters belongs to, so that it
+// selects the endpoint which receives each packet sent to the group. A nil
+// filter detaches the current one.
+func (s *Stack) SetReusePortFilter(netProtos []tcpip.NetworkProtocolNumber, protocol tcpip.TransportProtocolNumber, id TransportEndpointID, ep TransportEndpoint, filter tcpip.ReusePortFilter, bindToDevice tcpip.NICID) {
+	s.demux.setReusePortFilter(netProtos, protocol, id, ep, filter, bindToDevice)
+}
+
 // CheckRegisterTransportEndpoint checks if an endpoint can be registered with
 // the stack transport dispatcher.
 func (s *Stack) CheckRegisterTransportEndpoint(nicID tcpip.NICID, netProtos []tcpip.NetworkProtocolNumber, protocol tcpip.TransportProtocolNumber, id TransportEndpointID, flags ports.Flags, bindToDevice tcpip.NICID) *tcpip.Error {
@@ -1649,7 +2298,10 @@ func (s *Stack) CompleteTransportEndpointCleanup(ep TransportEndpoint) {
 // FindTransportEndpoint finds an endpoint that most closely matches the provided
 // id. If no endpoint is found it returns nil.
 func (s *Stack) FindTransportEndpoint(netProto tcpip.NetworkProtocolNumber, transProto tcpip.TransportProtocolNumber, id TransportEndpointID, nicID tcpip.NICID) TransportEndpoint {
-	return s.demux.findTransportEndpoint(netProto, transProto, id, nicID)
+	s.mu.RLock()
+	master := s.l3MasterDeviceRLocked(nicID)
+	s.mu.RUnlock()
+	return s.demux.findTransportEndpoint(netProto, transProto, id, nicID, master)
 }
 
 // RegisterRawTransportEndpoint registers the given endpoint with the stack
@@ -1913,6 +2565,30 @@ func (s *Stack) LeaveGroup(protocol tcpip.NetworkProtocolNumber, nicID tcpip.NIC
 	return tcpip.ErrUnknownNICID
 }
 
+// JoinSourceGroup joins the given multicast group on the given NIC, only
+// accepting traffic sent by sourceAddr.
+func (s *Stack) JoinSourceGroup(protocol tcpip.NetworkProtocolNumber, nicID tcpip.NICID, multicastAddr, sourceAddr tcpip.Address) *tcpip.Error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if nic, ok := s.nics[nicID]; ok {
+		return nic.joinSourceGroup(protocol, multicastAddr, sourceAddr)
+	}
+	return tcpip.ErrUnknownNICID
+}
+
+// LeaveSourceGroup leaves a source-specific membership of the given multicast
+// group on the given NIC.
+func (s *Stack) LeaveSourceGroup(protocol tcpip.NetworkProtocolNumber, nicID tcpip.NICID, multicastAddr, sourceAddr tcpip.Address) *tcpip.Error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if nic, ok := s.nics[nicID]; ok {
+		return nic.leaveSourceGroup(protocol, multicastAddr, sourceAddr)
+	}
+	return tcpip.ErrUnknownNICID
+}
+
 // IsInGroup returns true if the NIC with ID nicID has joined the multicast
 // group multicastAddr.
 func (s *Stack) IsInGroup(nicID tcpip.NICID, multicastAddr tcpip.Address) (bool, *tcpip.Error) {
@@ -1963,8 +2639,8 @@ func (s *Stack) AllowICMPMessage() bool {
 // GetNetworkEndpoint returns the NetworkEndpoint with the specified protocol
 // number installed on the specified NIC.
 func (s *Stack) GetNetworkEndpoint(nicID tcpip.NICID, proto tcpip.NetworkProtocolNumber) (NetworkEndpoint, *tcpip.Error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
 	nic, ok := s.nics[nicID]
 	if !ok {
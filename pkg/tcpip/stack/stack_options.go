@@ -14,7 +14,12 @@
 
 package stack
 
-import "gvisor.dev/gvisor/pkg/tcpip"
+import (
+	"sync/atomic"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
 
 const (
 	// MinBufferSize is the smallest size of a receive or send buffer.
@@ -27,6 +32,10 @@ const (
 	// DefaultMaxBufferSize is the default maximum permitted size of a
 	// send/receive buffer.
 	DefaultMaxBufferSize = 4 << 20 // 4 MiB
+
+	// DefaultGSOMaxSegs is the default maximum number of segments in a GSO
+	// packet, as per Linux's GSO_MAX_SEGS.
+	DefaultGSOMaxSegs = 65535
 )
 
 // SendBufferSizeOption is used by stack.(Stack*).Option/SetOption to
@@ -45,6 +54,40 @@ type ReceiveBufferSizeOption struct {
 	Max     int
 }
 
+// PathMTUExpiryOption is used by stack.(Stack*).Option/SetOption to get/set
+// the time after which a learned path MTU is forgotten.
+type PathMTUExpiryOption time.Duration
+
+// GSOOption is used by stack.(Stack*).Option/SetOption to get/set the
+// maximum size and number of segments of the packets built by transport
+// protocols for GSO. The limits apply on top of the ones of the links.
+type GSOOption struct {
+	MaxSize uint32
+	MaxSegs uint16
+}
+
+// L3MasterDeviceAcceptOption is used by stack.(Stack*).Option/SetOption to
+// get/set whether the packets received by the NICs enslaved to a VRF device are
+// delivered to the sockets bound to no device, as per Linux's
+// net.ipv4.tcp_l3mdev_accept and net.ipv4.udp_l3mdev_accept sysctls.
+type L3MasterDeviceAcceptOption bool
+
+// MultipathHashPolicyOption is used by stack.(Stack*).Option/SetOption to
+// get/set the fields of the packets hashed to select the next hop of the
+// multipath routes they are sent through, as per Linux's
+// net.ipv4.fib_multipath_hash_policy sysctl.
+type MultipathHashPolicyOption uint32
+
+const (
+	// MultipathHashL3 hashes the source and destination addresses of the
+	// packets. This is the default.
+	MultipathHashL3 MultipathHashPolicyOption = iota
+
+	// MultipathHashL4 hashes the 5-tuple of the packets: their addresses,
+	// transport protocol and ports.
+	MultipathHashL4
+)
+
 // SetOption allows setting stack wide options.
 func (s *Stack) SetOption(option interface{}) *tcpip.Error {
 	switch v := option.(type) {
@@ -80,6 +123,42 @@ func (s *Stack) SetOption(option interface{}) *tcpip.Error {
 		s.mu.Unlock()
 		return nil
 
+	case PathMTUExpiryOption:
+		if v <= 0 {
+			return tcpip.ErrInvalidOptionValue
+		}
+
+		s.pathMTUs.mu.Lock()
+		s.pathMTUs.expiry = time.Duration(v)
+		s.pathMTUs.mu.Unlock()
+		return nil
+
+	case GSOOption:
+		if v.MaxSize == 0 || v.MaxSize > SoftwareGSOMaxSize || v.MaxSegs == 0 {
+			return tcpip.ErrInvalidOptionValue
+		}
+
+		s.mu.Lock()
+		s.gso = v
+		s.mu.Unlock()
+		return nil
+
+	case L3MasterDeviceAcceptOption:
+		var accept uint32
+		if v {
+			accept = 1
+		}
+		atomic.StoreUint32(&s.l3mdevAccept, accept)
+		return nil
+
+	case MultipathHashPolicyOption:
+		if v != MultipathHashL3 && v != MultipathHashL4 {
+			return tcpip.ErrInvalidOptionValue
+		}
+		atomic.StoreUint32(&s.multipathHashPolicy, uint32(v))
+		s.InvalidateRouteCaches()
+		return nil
+
 	default:
 		return tcpip.ErrUnknownProtocolOption
 	}
@@ -100,6 +179,26 @@ func (s *Stack) Option(option interface{}) *tcpip.Error {
 		s.mu.RUnlock()
 		return nil
 
+	case *PathMTUExpiryOption:
+		s.pathMTUs.mu.RLock()
+		*v = PathMTUExpiryOption(s.pathMTUs.expiry)
+		s.pathMTUs.mu.RUnlock()
+		return nil
+
+	case *GSOOption:
+		s.mu.RLock()
+		*v = s.gso
+		s.mu.RUnlock()
+		return nil
+
+	case *L3MasterDeviceAcceptOption:
+		*v = L3MasterDeviceAcceptOption(s.l3MasterDeviceAccept())
+		return nil
+
+	case *MultipathHashPolicyOption:
+		*v = MultipathHashPolicyOption(atomic.LoadUint32(&s.multipathHashPolicy))
+		return nil
+
 	default:
 		return tcpip.ErrUnknownProtocolOption
 	}
@@ -33,6 +33,7 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/link/ethernet"
 	"gvisor.dev/gvisor/pkg/tcpip/link/loopback"
 	"gvisor.dev/gvisor/pkg/tcpip/network/arp"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
@@ -695,8 +696,10 @@ func TestRemoveUnknownNIC(t *testing.T) {
 func TestRemoveNIC(t *testing.T) {
 	const nicID = 1
 
+	var events nicEventRecorder
 	s := stack.New(stack.Options{
 		NetworkProtocols: []stack.NetworkProtocolFactory{fakeNetFactory},
+		NICEventDisp:     &events,
 	})
 
 	e := linkEPWithMockedAttach{
@@ -726,24 +729,30 @@ func TestRemoveNIC(t *testing.T) {
 	if e.isAttached() {
 		t.Error("link endpoint for removed NIC still attached to a network dispatcher")
 	}
+	if diff := cmp.Diff([]tcpip.NICID{nicID}, events.removed); diff != "" {
+		t.Errorf("removed NICs mismatch (-want +got):\n%s", diff)
+	}
 }
 
 func TestRouteWithDownNIC(t *testing.T) {
 	tests := []struct {
-		name   string
-		downFn func(s *stack.Stack, nicID tcpip.NICID) *tcpip.Error
-		upFn   func(s *stack.Stack, nicID tcpip.NICID) *tcpip.Error
+		name    string
+		downFn  func(s *stack.Stack, nicID tcpip.NICID) *tcpip.Error
+		upFn    func(s *stack.Stack, nicID tcpip.NICID) *tcpip.Error
+		sendErr *tcpip.Error
 	}{
 		{
-			name:   "Disabled NIC",
-			downFn: (*stack.Stack).DisableNIC,
-			upFn:   (*stack.Stack).EnableNIC,
+			name:    "Disabled NIC",
+			downFn:  (*stack.Stack).DisableNIC,
+			upFn:    (*stack.Stack).EnableNIC,
+			sendErr: tcpip.ErrInvalidEndpointState,
 		},
 
 		// Once a NIC is removed, it cannot be brought up.
 		{
-			name:   "Removed NIC",
-			downFn: (*stack.Stack).RemoveNIC,
+			name:    "Removed NIC",
+			downFn:  (*stack.Stack).RemoveNIC,
+			sendErr: tcpip.ErrUnknownDevice,
 		},
 	}
 
@@ -890,15 +899,15 @@ func TestRouteWithDownNIC(t *testing.T) {
 				if err := test.downFn(s, nicID1); err != nil {
 					t.Fatalf("test.downFn(_, %d): %s", nicID1, err)
 				}
-				testFailingSend(t, r1, ep1, buf, tcpip.ErrInvalidEndpointState)
+				testFailingSend(t, r1, ep1, buf, test.sendErr)
 				testSend(t, r2, ep2, buf)
 
 				// Writes with Routes that use NIC2 after being brought down should fail.
 				if err := test.downFn(s, nicID2); err != nil {
 					t.Fatalf("test.downFn(_, %d): %s", nicID2, err)
 				}
-				testFailingSend(t, r1, ep1, buf, tcpip.ErrInvalidEndpointState)
-				testFailingSend(t, r2, ep2, buf, tcpip.ErrInvalidEndpointState)
+				testFailingSend(t, r1, ep1, buf, test.sendErr)
+				testFailingSend(t, r2, ep2, buf, test.sendErr)
 
 				if upFn := test.upFn; upFn != nil {
 					// Writes with Routes that use NIC1 after being brought up should
@@ -911,7 +920,7 @@ func TestRouteWithDownNIC(t *testing.T) {
 						t.Fatalf("test.upFn(_, %d): %s", nicID1, err)
 					}
 					testSend(t, r1, ep1, buf)
-					testFailingSend(t, r2, ep2, buf, tcpip.ErrInvalidEndpointState)
+					testFailingSend(t, r2, ep2, buf, test.sendErr)
 				}
 			})
 		}
@@ -2219,6 +2228,391 @@ func TestNICStats(t *testing.T) {
 	}
 }
 
+func TestIngressHook(t *testing.T) {
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{fakeNetFactory},
+	})
+	ep1 := channel.New(10, defaultMTU, "")
+	if err := s.CreateNIC(1, ep1); err != nil {
+		t.Fatal("CreateNIC failed:", err)
+	}
+	if err := s.AddAddress(1, fakeNetNumber, "\x01"); err != nil {
+		t.Fatal("AddAddress failed:", err)
+	}
+	ep2 := channel.New(10, defaultMTU, "")
+	if err := s.CreateNIC(2, ep2); err != nil {
+		t.Fatal("CreateNIC failed:", err)
+	}
+	if err := s.AddAddress(2, fakeNetNumber, "\x02"); err != nil {
+		t.Fatal("AddAddress failed:", err)
+	}
+
+	if err := s.SetIngressHook(3, nil); err != tcpip.ErrUnknownNICID {
+		t.Errorf("got s.SetIngressHook(3, nil) = %v, want = %s", err, tcpip.ErrUnknownNICID)
+	}
+
+	// The hook drops the packets for \x01 with a non-zero first payload
+	// byte, and redirects the packets for \x02 to the NIC in their first
+	// payload byte.
+	var hookNICs []tcpip.NICID
+	hook := stack.IngressHookFunc(func(nicID tcpip.NICID, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) (stack.IngressAction, tcpip.NICID) {
+		hookNICs = append(hookNICs, nicID)
+		v := pkt.Data.ToView()
+		switch {
+		case v[dstAddrOffset] == 2:
+			return stack.IngressRedirect, tcpip.NICID(v[fakeNetHeaderLen])
+		case v[fakeNetHeaderLen] != 0:
+			return stack.IngressDrop, 0
+		default:
+			return stack.IngressPass, 0
+		}
+	})
+	for _, id := range []tcpip.NICID{1, 2} {
+		if err := s.SetIngressHook(id, hook); err != nil {
+			t.Fatalf("s.SetIngressHook(%d, _): %s", id, err)
+		}
+	}
+
+	fakeNet := s.NetworkProtocolInstance(fakeNetNumber).(*fakeNetworkProtocol)
+	buf := buffer.NewView(30)
+	buf[dstAddrOffset] = 1
+	testRecv(t, fakeNet, 1, ep1, buf)
+	buf[fakeNetHeaderLen] = 1
+	testFailingRecv(t, fakeNet, 1, ep1, buf)
+
+	// Packets are redirected to NIC 2 without running its hook, and
+	// packets redirected to unknown NICs are dropped.
+	buf[dstAddrOffset] = 2
+	buf[fakeNetHeaderLen] = 2
+	testRecv(t, fakeNet, 2, ep1, buf)
+	buf[fakeNetHeaderLen] = 3
+	testFailingRecv(t, fakeNet, 2, ep1, buf)
+
+	if diff := cmp.Diff([]tcpip.NICID{1, 1, 1, 1}, hookNICs); diff != "" {
+		t.Errorf("hook NICs mismatch (-want +got):\n%s", diff)
+	}
+	stats := s.NICInfo()[1].Stats.IngressHook
+	for _, c := range []struct {
+		name    string
+		counter *tcpip.StatCounter
+		want    uint64
+	}{
+		{name: "Passed", counter: stats.Passed, want: 1},
+		{name: "Dropped", counter: stats.Dropped, want: 1},
+		{name: "Redirected", counter: stats.Redirected, want: 1},
+		{name: "InvalidRedirects", counter: stats.InvalidRedirects, want: 1},
+	} {
+		if got := c.counter.Value(); got != c.want {
+			t.Errorf("got IngressHook.%s.Value() = %d, want = %d", c.name, got, c.want)
+		}
+	}
+	if got, want := s.NICInfo()[2].Stats.Rx.Packets.Value(), uint64(1); got != want {
+		t.Errorf("got NIC 2 Rx.Packets.Value() = %d, want = %d", got, want)
+	}
+
+	// Detached hooks aren't run anymore.
+	if err := s.SetIngressHook(1, nil); err != nil {
+		t.Fatalf("s.SetIngressHook(1, nil): %s", err)
+	}
+	buf[dstAddrOffset] = 1
+	buf[fakeNetHeaderLen] = 1
+	testRecv(t, fakeNet, 1, ep1, buf)
+	if got := len(hookNICs); got != 4 {
+		t.Errorf("got %d hook runs after detaching the hook, want = 4", got)
+	}
+}
+
+// capturedPacket is a packet captured by a packetRecorder.
+type capturedPacket struct {
+	nicID    tcpip.NICID
+	dir      stack.CaptureDirection
+	protocol tcpip.NetworkProtocolNumber
+	data     string
+}
+
+// packetRecorder is a stack.PacketCapture recording the packets it captures.
+type packetRecorder struct {
+	packets []capturedPacket
+}
+
+// Capture implements stack.PacketCapture.Capture.
+func (r *packetRecorder) Capture(nicID tcpip.NICID, dir stack.CaptureDirection, protocol tcpip.NetworkProtocolNumber, packet buffer.VectorisedView) {
+	r.packets = append(r.packets, capturedPacket{
+		nicID:    nicID,
+		dir:      dir,
+		protocol: protocol,
+		data:     string(packet.ToView()),
+	})
+}
+
+func TestPacketCapture(t *testing.T) {
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{fakeNetFactory},
+	})
+	ep := channel.New(10, defaultMTU, "")
+	if err := s.CreateNIC(1, ep); err != nil {
+		t.Fatal("CreateNIC failed:", err)
+	}
+	if err := s.AddAddress(1, fakeNetNumber, "\x01"); err != nil {
+		t.Fatal("AddAddress failed:", err)
+	}
+	{
+		subnet, err := tcpip.NewSubnet("\x00", "\x00")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.SetRouteTable([]tcpip.Route{{Destination: subnet, Gateway: "\x00", NIC: 1}})
+	}
+
+	var r packetRecorder
+	if err := s.AddPacketCapture(2, &r); err != tcpip.ErrUnknownNICID {
+		t.Errorf("got s.AddPacketCapture(2, _) = %v, want = %s", err, tcpip.ErrUnknownNICID)
+	}
+	if err := s.AddPacketCapture(1, &r); err != nil {
+		t.Fatalf("s.AddPacketCapture(1, _): %s", err)
+	}
+
+	// Both the inbound and the outbound packets are captured, from their
+	// network header.
+	fakeNet := s.NetworkProtocolInstance(fakeNetNumber).(*fakeNetworkProtocol)
+	buf := buffer.NewView(30)
+	buf[dstAddrOffset] = 1
+	testRecv(t, fakeNet, 1, ep, buf)
+	testSendTo(t, s, "\x03", ep, buffer.View("payload"))
+
+	out := make([]byte, fakeNetHeaderLen)
+	out[dstAddrOffset] = 3
+	out[srcAddrOffset] = 1
+	out[protocolNumberOffset] = byte(fakeTransNumber)
+	want := []capturedPacket{
+		{nicID: 1, dir: stack.CaptureInbound, protocol: fakeNetNumber, data: string(buf)},
+		{nicID: 1, dir: stack.CaptureOutbound, protocol: fakeNetNumber, data: string(out) + "payload"},
+	}
+	if diff := cmp.Diff(want, r.packets, cmp.AllowUnexported(capturedPacket{})); diff != "" {
+		t.Errorf("captured packets mismatch (-want +got):\n%s", diff)
+	}
+
+	// Removed captures don't capture packets anymore.
+	if err := s.RemovePacketCapture(1, &r); err != nil {
+		t.Fatalf("s.RemovePacketCapture(1, _): %s", err)
+	}
+	testRecv(t, fakeNet, 1, ep, buf)
+	if got := len(r.packets); got != 2 {
+		t.Errorf("got %d captured packets after removing the capture, want = 2", got)
+	}
+}
+
+// nicEventRecorder is a stack.NICEventDispatcher recording the events it
+// receives.
+type nicEventRecorder struct {
+	offloads    []stack.NICOffloads
+	mtus        []nicMTU
+	adminStates []nicLinkState
+	carriers    []nicLinkState
+	removed     []tcpip.NICID
+}
+
+// nicLinkState is the administrative state or the carrier of a NIC.
+type nicLinkState struct {
+	nicID tcpip.NICID
+	up    bool
+}
+
+// nicMTU is the MTU of a NIC.
+type nicMTU struct {
+	nicID tcpip.NICID
+	mtu   uint32
+}
+
+// OnOffloadsChanged implements stack.NICEventDispatcher.OnOffloadsChanged.
+func (r *nicEventRecorder) OnOffloadsChanged(_ tcpip.NICID, offloads stack.NICOffloads) {
+	r.offloads = append(r.offloads, offloads)
+}
+
+// OnMTUChanged implements stack.NICEventDispatcher.OnMTUChanged.
+func (r *nicEventRecorder) OnMTUChanged(nicID tcpip.NICID, mtu uint32) {
+	r.mtus = append(r.mtus, nicMTU{nicID: nicID, mtu: mtu})
+}
+
+// OnAdminStateChanged implements
+// stack.NICEventDispatcher.OnAdminStateChanged.
+func (r *nicEventRecorder) OnAdminStateChanged(nicID tcpip.NICID, up bool) {
+	r.adminStates = append(r.adminStates, nicLinkState{nicID: nicID, up: up})
+}
+
+// OnCarrierChanged implements stack.NICEventDispatcher.OnCarrierChanged.
+func (r *nicEventRecorder) OnCarrierChanged(nicID tcpip.NICID, up bool) {
+	r.carriers = append(r.carriers, nicLinkState{nicID: nicID, up: up})
+}
+
+// OnNICRemoved implements stack.NICEventDispatcher.OnNICRemoved.
+func (r *nicEventRecorder) OnNICRemoved(nicID tcpip.NICID) {
+	r.removed = append(r.removed, nicID)
+}
+
+func TestNICOffloads(t *testing.T) {
+	var events nicEventRecorder
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{fakeNetFactory},
+		NICEventDisp:     &events,
+	})
+	ep := channel.New(10, defaultMTU, "")
+	ep.LinkEPCapabilities = stack.CapabilityTXChecksumOffload | stack.CapabilityRXChecksumOffload | stack.CapabilityHardwareGSO
+	if err := s.CreateNIC(1, ep); err != nil {
+		t.Fatal("CreateNIC failed:", err)
+	}
+	if err := s.AddAddress(1, fakeNetNumber, "\x01"); err != nil {
+		t.Fatal("AddAddress failed:", err)
+	}
+	{
+		subnet, err := tcpip.NewSubnet("\x00", "\x00")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.SetRouteTable([]tcpip.Route{{Destination: subnet, Gateway: "\x00", NIC: 1}})
+	}
+
+	supported := stack.CapabilityTXChecksumOffload | stack.CapabilityRXChecksumOffload | stack.CapabilityHardwareGSO
+	checkOffloads := func(want stack.LinkEndpointCapabilities) {
+		t.Helper()
+		got, err := s.NICOffloads(1)
+		if err != nil {
+			t.Fatalf("s.NICOffloads(1): %s", err)
+		}
+		if want := (stack.NICOffloads{Supported: supported, Enabled: want}); got != want {
+			t.Errorf("got s.NICOffloads(1) = %#v, want = %#v", got, want)
+		}
+
+		r, err := s.FindRoute(1, "\x01", "\x03", fakeNetNumber, false /* multicastLoop */)
+		if err != nil {
+			t.Fatalf("s.FindRoute(1, 1, 3, %d, false): %s", fakeNetNumber, err)
+		}
+		defer r.Release()
+		if got, want := r.RequiresTXTransportChecksum(), want&stack.CapabilityTXChecksumOffload == 0; got != want {
+			t.Errorf("got r.RequiresTXTransportChecksum() = %t, want = %t", got, want)
+		}
+		if got, want := r.HasHardwareGSOCapability(), want&stack.CapabilityHardwareGSO != 0; got != want {
+			t.Errorf("got r.HasHardwareGSOCapability() = %t, want = %t", got, want)
+		}
+	}
+	checkOffloads(supported)
+
+	if err := s.SetNICOffloads(1, stack.CapabilityTXChecksumOffload|stack.CapabilityHardwareGSO, false); err != nil {
+		t.Fatalf("s.SetNICOffloads(1, TX|GSO, false): %s", err)
+	}
+	checkOffloads(stack.CapabilityRXChecksumOffload)
+
+	// Disabling offloads again doesn't change them.
+	if err := s.SetNICOffloads(1, stack.CapabilityTXChecksumOffload, false); err != nil {
+		t.Fatalf("s.SetNICOffloads(1, TX, false): %s", err)
+	}
+	if err := s.SetNICOffloads(1, stack.CapabilityTXChecksumOffload, true); err != nil {
+		t.Fatalf("s.SetNICOffloads(1, TX, true): %s", err)
+	}
+	checkOffloads(stack.CapabilityTXChecksumOffload | stack.CapabilityRXChecksumOffload)
+
+	want := []stack.NICOffloads{
+		{Supported: supported, Enabled: stack.CapabilityRXChecksumOffload},
+		{Supported: supported, Enabled: stack.CapabilityTXChecksumOffload | stack.CapabilityRXChecksumOffload},
+	}
+	if diff := cmp.Diff(want, events.offloads); diff != "" {
+		t.Errorf("offload changes mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := s.SetNICOffloads(1, stack.CapabilitySoftwareGSO, true); err != tcpip.ErrNotSupported {
+		t.Errorf("got s.SetNICOffloads(1, SoftwareGSO, true) = %v, want = %s", err, tcpip.ErrNotSupported)
+	}
+	if err := s.SetNICOffloads(1, stack.CapabilityLoopback, false); err != tcpip.ErrInvalidOptionValue {
+		t.Errorf("got s.SetNICOffloads(1, Loopback, false) = %v, want = %s", err, tcpip.ErrInvalidOptionValue)
+	}
+	if err := s.SetNICOffloads(2, stack.CapabilityTXChecksumOffload, false); err != tcpip.ErrUnknownNICID {
+		t.Errorf("got s.SetNICOffloads(2, TX, false) = %v, want = %s", err, tcpip.ErrUnknownNICID)
+	}
+	if _, err := s.NICOffloads(2); err != tcpip.ErrUnknownNICID {
+		t.Errorf("got s.NICOffloads(2) = %v, want = %s", err, tcpip.ErrUnknownNICID)
+	}
+}
+
+func TestNICLinkState(t *testing.T) {
+	const vlanNICID = 2
+
+	var events nicEventRecorder
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{fakeNetFactory},
+		NICEventDisp:     &events,
+	})
+	ep := channel.New(10, defaultMTU, "\x02\x03\x03\x04\x05\x06")
+	if err := s.CreateNIC(1, ethernet.New(ep)); err != nil {
+		t.Fatal("CreateNIC failed:", err)
+	}
+	if err := s.CreateVLAN(vlanNICID, 1, 10, stack.VLANOptions{}); err != nil {
+		t.Fatal("CreateVLAN failed:", err)
+	}
+
+	checkFlags := func(id tcpip.NICID, up, lowerUp bool) {
+		t.Helper()
+		want := stack.NICStateFlags{Up: up, LowerUp: lowerUp, Running: up && lowerUp}
+		if got := s.NICInfo()[id].Flags; got != want {
+			t.Errorf("got s.NICInfo()[%d].Flags = %#v, want = %#v", id, got, want)
+		}
+	}
+	checkFlags(1, true, true)
+	checkFlags(vlanNICID, true, true)
+
+	// The carrier of the VLAN NIC follows the carrier of its parent.
+	ep.SetCarrier(false)
+	checkFlags(1, true, false)
+	checkFlags(vlanNICID, true, false)
+
+	// Losing the carrier again doesn't change it.
+	ep.SetCarrier(false)
+
+	if err := s.DisableNIC(1); err != nil {
+		t.Fatalf("s.DisableNIC(1): %s", err)
+	}
+	checkFlags(1, false, false)
+	checkFlags(vlanNICID, true, false)
+
+	// Disabling the NIC again doesn't change it.
+	if err := s.DisableNIC(1); err != nil {
+		t.Fatalf("s.DisableNIC(1): %s", err)
+	}
+
+	ep.SetCarrier(true)
+	checkFlags(1, false, true)
+	checkFlags(vlanNICID, true, true)
+
+	if err := s.EnableNIC(1); err != nil {
+		t.Fatalf("s.EnableNIC(1): %s", err)
+	}
+	checkFlags(1, true, true)
+
+	wantAdminStates := []nicLinkState{
+		{nicID: 1, up: false},
+		{nicID: 1, up: true},
+	}
+	if diff := cmp.Diff(wantAdminStates, events.adminStates, cmp.AllowUnexported(nicLinkState{})); diff != "" {
+		t.Errorf("administrative state changes mismatch (-want +got):\n%s", diff)
+	}
+	wantCarriers := []nicLinkState{
+		{nicID: 1, up: false},
+		{nicID: vlanNICID, up: false},
+		{nicID: 1, up: true},
+		{nicID: vlanNICID, up: true},
+	}
+	if diff := cmp.Diff(wantCarriers, events.carriers, cmp.AllowUnexported(nicLinkState{})); diff != "" {
+		t.Errorf("carrier changes mismatch (-want +got):\n%s", diff)
+	}
+
+	// NICs are created with the carrier of their link endpoint.
+	ep = channel.New(10, defaultMTU, "")
+	ep.SetCarrier(false)
+	if err := s.CreateNIC(3, ep); err != nil {
+		t.Fatal("CreateNIC failed:", err)
+	}
+	checkFlags(3, true, false)
+}
+
 // TestNICContextPreservation tests that you can read out via stack.NICInfo the
 // Context data you pass via NICContext.Context in stack.CreateNICWithOptions.
 func TestNICContextPreservation(t *testing.T) {
@@ -2735,8 +3129,8 @@ func TestIPv6SourceAddressSelectionScopeAndSameAddress(t *testing.T) {
 
 	var tempIIDHistory [header.IIDSize]byte
 	header.InitialTempIID(tempIIDHistory[:], nil, nicID)
-	tempGlobalAddr1 := header.GenerateTempIPv6SLAACAddr(tempIIDHistory[:], stableGlobalAddr1.Address).Address
-	tempGlobalAddr2 := header.GenerateTempIPv6SLAACAddr(tempIIDHistory[:], stableGlobalAddr2.Address).Address
+	tempGlobalAddr1 := header.GenerateTempIPv6SLAACAddr(tempIIDHistory[:], stableGlobalAddr1.Subnet()).Address
+	tempGlobalAddr2 := header.GenerateTempIPv6SLAACAddr(tempIIDHistory[:], stableGlobalAddr2.Subnet()).Address
 
 	// Rule 3 is not tested here, and is instead tested by NDP's AutoGenAddr test.
 	tests := []struct {
@@ -2744,6 +3138,7 @@ func TestIPv6SourceAddressSelectionScopeAndSameAddress(t *testing.T) {
 		slaacPrefixForTempAddrBeforeNICAddrAdd tcpip.AddressWithPrefix
 		nicAddrs                               []tcpip.Address
 		slaacPrefixForTempAddrAfterNICAddrAdd  tcpip.AddressWithPrefix
+		tempAddrPreference                     ipv6.TempAddrPreference
 		connectAddr                            tcpip.Address
 		expectedLocalAddr                      tcpip.Address
 	}{
@@ -2850,6 +3245,22 @@ func TestIPv6SourceAddressSelectionScopeAndSameAddress(t *testing.T) {
 			connectAddr:                           globalAddr2,
 			expectedLocalAddr:                     tempGlobalAddr1,
 		},
+		{
+			name:                                   "Public Global most preferred when preferring public addresses (last address)",
+			slaacPrefixForTempAddrBeforeNICAddrAdd: prefix1,
+			nicAddrs:                               []tcpip.Address{linkLocalAddr1, uniqueLocalAddr1, globalAddr1},
+			tempAddrPreference:                     ipv6.PreferPublicAddrs,
+			connectAddr:                            globalAddr2,
+			expectedLocalAddr:                      stableGlobalAddr1.Address,
+		},
+		{
+			name:                                  "Public Global most preferred when preferring public addresses (first address)",
+			nicAddrs:                              []tcpip.Address{linkLocalAddr1, uniqueLocalAddr1, globalAddr1},
+			slaacPrefixForTempAddrAfterNICAddrAdd: prefix1,
+			tempAddrPreference:                    ipv6.PreferPublicAddrs,
+			connectAddr:                           globalAddr2,
+			expectedLocalAddr:                     stableGlobalAddr1.Address,
+		},
 
 		// Test returning the endpoint that is closest to the front when
 		// candidate addresses are "equal" from the perspective of RFC 6724
@@ -2890,6 +3301,7 @@ func TestIPv6SourceAddressSelectionScopeAndSameAddress(t *testing.T) {
 						HandleRAs:                  true,
 						AutoGenGlobalAddresses:     true,
 						AutoGenTempGlobalAddresses: true,
+						TempAddrPreference:         test.tempAddrPreference,
 					},
 					NDPDisp: &ndpDispatcher{},
 				})},
@@ -3584,6 +3996,88 @@ func TestResolveWith(t *testing.T) {
 	}
 }
 
+func TestStaticNeighbors(t *testing.T) {
+	const (
+		nicID          = 1
+		remoteLinkAddr = tcpip.LinkAddress("\x02\x02\x03\x04\x05\x06")
+	)
+	remoteAddr := tcpip.Address([]byte{192, 168, 1, 59})
+
+	for _, useNeighborCache := range []bool{true, false} {
+		t.Run(fmt.Sprintf("UseNeighborCache=%t", useNeighborCache), func(t *testing.T) {
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocol, arp.NewProtocol},
+				UseNeighborCache: useNeighborCache,
+			})
+			ep := channel.New(1, defaultMTU, "\x02\x02\x03\x04\x05\x07")
+			ep.LinkEPCapabilities |= stack.CapabilityResolutionRequired
+			if err := s.CreateNIC(nicID, ep); err != nil {
+				t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+			}
+			addr := tcpip.ProtocolAddress{
+				Protocol: header.IPv4ProtocolNumber,
+				AddressWithPrefix: tcpip.AddressWithPrefix{
+					Address:   tcpip.Address([]byte{192, 168, 1, 58}),
+					PrefixLen: 24,
+				},
+			}
+			if err := s.AddProtocolAddress(nicID, addr); err != nil {
+				t.Fatalf("AddProtocolAddress(%d, %#v): %s", nicID, addr, err)
+			}
+			s.SetRouteTable([]tcpip.Route{{Destination: header.IPv4EmptySubnet, NIC: nicID}})
+
+			if err := s.AddStaticNeighbor(nicID, remoteAddr, remoteLinkAddr); err != nil {
+				t.Fatalf("s.AddStaticNeighbor(%d, %s, %s): %s", nicID, remoteAddr, remoteLinkAddr, err)
+			}
+			neighbors, err := s.Neighbors(nicID)
+			if err != nil {
+				t.Fatalf("s.Neighbors(%d): %s", nicID, err)
+			}
+			want := []stack.NeighborEntry{{Addr: remoteAddr, LinkAddr: remoteLinkAddr, State: stack.Static}}
+			if diff := cmp.Diff(want, neighbors, cmp.FilterPath(func(p cmp.Path) bool {
+				return p.Last().String() == ".UpdatedAtNanos"
+			}, cmp.Ignore())); diff != "" {
+				t.Errorf("neighbors mismatch (-want +got):\n%s", diff)
+			}
+
+			// Static neighbors are used without resolution.
+			r, err := s.FindRoute(nicID, "" /* localAddr */, remoteAddr, header.IPv4ProtocolNumber, false /* multicastLoop */)
+			if err != nil {
+				t.Fatalf("FindRoute(%d, '', %s, %d): %s", nicID, remoteAddr, header.IPv4ProtocolNumber, err)
+			}
+			if _, err := r.Resolve(nil); err != nil {
+				t.Fatalf("r.Resolve(nil): %s", err)
+			}
+			if got := r.RemoteLinkAddress(); got != remoteLinkAddr {
+				t.Errorf("got r.RemoteLinkAddress() = %s, want = %s", got, remoteLinkAddr)
+			}
+			r.Release()
+			if pkt, ok := ep.Read(); ok {
+				t.Errorf("unexpected packet sent, Proto=%d", pkt.Proto)
+			}
+
+			if err := s.RemoveNeighbor(nicID, remoteAddr); err != nil {
+				t.Fatalf("s.RemoveNeighbor(%d, %s): %s", nicID, remoteAddr, err)
+			}
+			if err := s.RemoveNeighbor(nicID, remoteAddr); err != tcpip.ErrBadAddress {
+				t.Errorf("got s.RemoveNeighbor(%d, %s) = %s, want = %s", nicID, remoteAddr, err, tcpip.ErrBadAddress)
+			}
+
+			if err := s.AddStaticNeighbor(nicID, remoteAddr, remoteLinkAddr); err != nil {
+				t.Fatalf("s.AddStaticNeighbor(%d, %s, %s): %s", nicID, remoteAddr, remoteLinkAddr, err)
+			}
+			if err := s.ClearNeighbors(nicID); err != nil {
+				t.Fatalf("s.ClearNeighbors(%d): %s", nicID, err)
+			}
+			if neighbors, err := s.Neighbors(nicID); err != nil {
+				t.Fatalf("s.Neighbors(%d): %s", nicID, err)
+			} else if len(neighbors) != 0 {
+				t.Errorf("got s.Neighbors(%d) = %#v, want = []", nicID, neighbors)
+			}
+		})
+	}
+}
+
 // TestRouteReleaseAfterAddrRemoval tests that releasing a Route after its
 // associated address is removed should not cause a panic.
 func TestRouteReleaseAfterAddrRemoval(t *testing.T) {
@@ -3796,33 +4290,605 @@ func TestRemoveRoutes(t *testing.T) {
 	}
 }
 
-func TestFindRouteWithForwarding(t *testing.T) {
+func TestRouteRules(t *testing.T) {
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{fakeNetFactory},
+	})
+
+	main := tcpip.RouteRule{Priority: 32766, NetProto: fakeNetNumber, Table: tcpip.MainRouteTable}
+	def := tcpip.RouteRule{Priority: 32767, NetProto: fakeNetNumber, Table: tcpip.DefaultRouteTable}
+	if diff := cmp.Diff([]tcpip.RouteRule{main, def}, s.RouteRules(fakeNetNumber)); diff != "" {
+		t.Fatalf("default route rules mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := s.AddRouteRule(tcpip.RouteRule{NetProto: fakeNetNumber - 1}); err != tcpip.ErrUnknownProtocol {
+		t.Fatalf("got s.AddRouteRule(_) = %v, want = %s", err, tcpip.ErrUnknownProtocol)
+	}
+
+	// Rules with the same priority are evaluated in the order they were
+	// added.
+	rule1 := tcpip.RouteRule{Priority: 100, NetProto: fakeNetNumber, Table: 1}
+	rule2 := tcpip.RouteRule{Priority: 100, NetProto: fakeNetNumber, Table: 2}
+	rule3 := tcpip.RouteRule{Priority: 10, NetProto: fakeNetNumber, Table: 3}
+	for _, rule := range []tcpip.RouteRule{rule1, rule2, rule3} {
+		if err := s.AddRouteRule(rule); err != nil {
+			t.Fatalf("s.AddRouteRule(%s): %s", rule, err)
+		}
+	}
+	if diff := cmp.Diff([]tcpip.RouteRule{rule3, rule1, rule2, main, def}, s.RouteRules(fakeNetNumber)); diff != "" {
+		t.Fatalf("route rules mismatch (-want +got):\n%s", diff)
+	}
+
+	// Only the first matching rule is removed.
+	matchPriority := func(r tcpip.RouteRule) bool { return r.Priority == 100 }
+	if !s.RemoveRouteRule(fakeNetNumber, matchPriority) {
+		t.Fatal("got s.RemoveRouteRule(_, _) = false, want = true")
+	}
+	if diff := cmp.Diff([]tcpip.RouteRule{rule3, rule2, main, def}, s.RouteRules(fakeNetNumber)); diff != "" {
+		t.Fatalf("route rules mismatch (-want +got):\n%s", diff)
+	}
+	if !s.RemoveRouteRule(fakeNetNumber, matchPriority) {
+		t.Fatal("got s.RemoveRouteRule(_, _) = false, want = true")
+	}
+	if s.RemoveRouteRule(fakeNetNumber, matchPriority) {
+		t.Fatal("got s.RemoveRouteRule(_, _) = true, want = false")
+	}
+}
+
+func TestFindRouteWithPolicy(t *testing.T) {
 	const (
 		nicID1 = 1
 		nicID2 = 2
 
 		nic1Addr   = tcpip.Address("\x01")
 		nic2Addr   = tcpip.Address("\x02")
-		remoteAddr = tcpip.Address("\x03")
+		remoteAddr = tcpip.Address("\x05")
+
+		table = 100
 	)
 
-	type netCfg struct {
-		proto      tcpip.NetworkProtocolNumber
-		factory    stack.NetworkProtocolFactory
-		nic1Addr   tcpip.Address
-		nic2Addr   tcpip.Address
-		remoteAddr tcpip.Address
+	subnet, err := tcpip.NewSubnet("\x00", "\x00")
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	fakeNetCfg := netCfg{
-		proto:      fakeNetNumber,
-		factory:    fakeNetFactory,
-		nic1Addr:   nic1Addr,
-		nic2Addr:   nic2Addr,
-		remoteAddr: remoteAddr,
+	nic2Subnet, err := tcpip.NewSubnet(nic2Addr, "\xff")
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	globalIPv6Addr1 := tcpip.Address(net.ParseIP("a::1").To16())
+	remoteSubnet, err := tcpip.NewSubnet(remoteAddr, "\xff")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		rule      tcpip.RouteRule
+		id        tcpip.NICID
+		localAddr tcpip.Address
+		key       stack.RoutePolicyKey
+		wantNIC   tcpip.NICID
+		wantErr   *tcpip.Error
+	}{
+		{
+			name:    "Main table",
+			wantNIC: nicID1,
+		},
+		{
+			name:      "Local address not in main table",
+			localAddr: nic2Addr,
+			wantErr:   tcpip.ErrNoRoute,
+		},
+		{
+			name:      "Source",
+			rule:      tcpip.RouteRule{Source: nic2Subnet},
+			localAddr: nic2Addr,
+			wantNIC:   nicID2,
+		},
+		{
+			name:    "Source not matching",
+			rule:    tcpip.RouteRule{Source: nic2Subnet},
+			wantNIC: nicID1,
+		},
+		{
+			name:    "Destination",
+			rule:    tcpip.RouteRule{Destination: remoteSubnet},
+			wantNIC: nicID2,
+		},
+		{
+			name:    "Mark",
+			rule:    tcpip.RouteRule{Mark: 1, MarkMask: 0xff},
+			key:     stack.RoutePolicyKey{Mark: 0x101},
+			wantNIC: nicID2,
+		},
+		{
+			name:    "Mark not matching",
+			rule:    tcpip.RouteRule{Mark: 1, MarkMask: 0xff},
+			key:     stack.RoutePolicyKey{Mark: 2},
+			wantNIC: nicID1,
+		},
+		{
+			name:    "Input NIC",
+			rule:    tcpip.RouteRule{InputNIC: nicID1},
+			key:     stack.RoutePolicyKey{InputNIC: nicID1},
+			wantNIC: nicID2,
+		},
+		{
+			name:    "Input NIC on locally generated packet",
+			rule:    tcpip.RouteRule{InputNIC: nicID1},
+			wantNIC: nicID1,
+		},
+		{
+			name:    "Output NIC",
+			rule:    tcpip.RouteRule{OutputNIC: nicID2},
+			id:      nicID2,
+			wantNIC: nicID2,
+		},
+		{
+			name:    "Output NIC without rule",
+			id:      nicID2,
+			wantErr: tcpip.ErrNoRoute,
+		},
+		{
+			name:    "TOS",
+			rule:    tcpip.RouteRule{TOS: 0x10},
+			key:     stack.RoutePolicyKey{TOS: 0x10},
+			wantNIC: nicID2,
+		},
+		{
+			name:    "TOS not matching",
+			rule:    tcpip.RouteRule{TOS: 0x10},
+			key:     stack.RoutePolicyKey{TOS: 0x08},
+			wantNIC: nicID1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{fakeNetFactory},
+			})
+			for _, nic := range []struct {
+				id   tcpip.NICID
+				addr tcpip.Address
+			}{{nicID1, nic1Addr}, {nicID2, nic2Addr}} {
+				if err := s.CreateNIC(nic.id, channel.New(0, defaultMTU, "")); err != nil {
+					t.Fatalf("CreateNIC(%d, _): %s", nic.id, err)
+				}
+				if err := s.AddAddress(nic.id, fakeNetNumber, nic.addr); err != nil {
+					t.Fatalf("AddAddress(%d, %d, %s): %s", nic.id, fakeNetNumber, nic.addr, err)
+				}
+			}
+			s.SetRouteTable([]tcpip.Route{
+				{Destination: subnet, NIC: nicID1},
+				{Destination: subnet, NIC: nicID2, Table: table},
+			})
+			if test.rule != (tcpip.RouteRule{}) {
+				test.rule.NetProto = fakeNetNumber
+				test.rule.Table = table
+				if err := s.AddRouteRule(test.rule); err != nil {
+					t.Fatalf("s.AddRouteRule(%s): %s", test.rule, err)
+				}
+			}
+
+			r, err := s.FindRouteWithPolicy(test.id, test.localAddr, remoteAddr, fakeNetNumber, false /* multicastLoop */, test.key)
+			if err != test.wantErr {
+				t.Fatalf("got s.FindRouteWithPolicy(%d, %s, %s, %d, false, %#v) = (_, %v), want = (_, %v)", test.id, test.localAddr, remoteAddr, fakeNetNumber, test.key, err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer r.Release()
+			if got := r.NICID(); got != test.wantNIC {
+				t.Errorf("got r.NICID() = %d, want = %d", got, test.wantNIC)
+			}
+		})
+	}
+}
+
+func TestFindRouteWithPolicyFallsThrough(t *testing.T) {
+	const nicID = 1
+
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{fakeNetFactory},
+	})
+	if err := s.CreateNIC(nicID, channel.New(0, defaultMTU, "")); err != nil {
+		t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+	}
+	if err := s.AddAddress(nicID, fakeNetNumber, "\x01"); err != nil {
+		t.Fatalf("AddAddress(%d, %d, 1): %s", nicID, fakeNetNumber, err)
+	}
+	{
+		subnet, err := tcpip.NewSubnet("\x00", "\x00")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.SetRouteTable([]tcpip.Route{{Destination: subnet, NIC: nicID, Table: tcpip.DefaultRouteTable}})
+	}
+
+	// The rules looking up tables without a route to the remote address are
+	// skipped.
+	if err := s.AddRouteRule(tcpip.RouteRule{Priority: 10, NetProto: fakeNetNumber, Table: 200}); err != nil {
+		t.Fatalf("s.AddRouteRule(_): %s", err)
+	}
+	r, err := s.FindRoute(0, "", "\x05", fakeNetNumber, false /* multicastLoop */)
+	if err != nil {
+		t.Fatalf("s.FindRoute(0, \"\", 5, %d, false): %s", fakeNetNumber, err)
+	}
+	r.Release()
+
+	// Without a rule looking up the default table, there is no route.
+	s.RemoveRouteRule(fakeNetNumber, func(r tcpip.RouteRule) bool {
+		return r.Table == tcpip.DefaultRouteTable
+	})
+	if _, err := s.FindRoute(0, "", "\x05", fakeNetNumber, false /* multicastLoop */); err != tcpip.ErrNoRoute {
+		t.Fatalf("got s.FindRoute(0, \"\", 5, %d, false) = (_, %v), want = (_, %s)", fakeNetNumber, err, tcpip.ErrNoRoute)
+	}
+}
+
+func TestFindRouteWithPolicyMarksPackets(t *testing.T) {
+	const (
+		nicID1 = 1
+		nicID2 = 2
+		table  = 100
+		mark   = 0x101
+	)
+
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{fakeNetFactory},
+	})
+	eps := make(map[tcpip.NICID]*channel.Endpoint)
+	for _, id := range []tcpip.NICID{nicID1, nicID2} {
+		ep := channel.New(1, defaultMTU, "")
+		eps[id] = ep
+		if err := s.CreateNIC(id, ep); err != nil {
+			t.Fatalf("CreateNIC(%d, _): %s", id, err)
+		}
+		if err := s.AddAddress(id, fakeNetNumber, tcpip.Address([]byte{byte(id)})); err != nil {
+			t.Fatalf("AddAddress(%d, %d, %d): %s", id, fakeNetNumber, id, err)
+		}
+	}
+	subnet, err := tcpip.NewSubnet("\x00", "\x00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetRouteTable([]tcpip.Route{
+		{Destination: subnet, NIC: nicID1},
+		{Destination: subnet, NIC: nicID2, Table: table},
+	})
+	rule := tcpip.RouteRule{NetProto: fakeNetNumber, Mark: 1, MarkMask: 0xff, Table: table}
+	if err := s.AddRouteRule(rule); err != nil {
+		t.Fatalf("s.AddRouteRule(%s): %s", rule, err)
+	}
+
+	// The packets written to the routes found for a mark carry the mark,
+	// like the packets of the sockets with SO_MARK.
+	for _, test := range []struct {
+		mark    uint32
+		wantNIC tcpip.NICID
+	}{
+		{mark: 0, wantNIC: nicID1},
+		{mark: mark, wantNIC: nicID2},
+	} {
+		key := stack.RoutePolicyKey{Mark: test.mark}
+		r, err := s.FindRouteWithPolicy(0, "", "\x05", fakeNetNumber, false /* multicastLoop */, key)
+		if err != nil {
+			t.Fatalf("s.FindRouteWithPolicy(0, \"\", 5, %d, false, %#v): %s", fakeNetNumber, key, err)
+		}
+		if got := r.NICID(); got != test.wantNIC {
+			t.Errorf("got r.NICID() = %d, want = %d", got, test.wantNIC)
+		}
+		if err := send(r, nil); err != nil {
+			t.Fatalf("send(_, nil): %s", err)
+		}
+		r.Release()
+		p, ok := eps[test.wantNIC].Read()
+		if !ok {
+			t.Fatalf("expected a packet to be written to NIC %d", test.wantNIC)
+		}
+		if p.Pkt.Mark != test.mark {
+			t.Errorf("got p.Pkt.Mark = %#x, want = %#x", p.Pkt.Mark, test.mark)
+		}
+	}
+}
+
+func TestFindRouteMultipath(t *testing.T) {
+	const (
+		nicID1 = 1
+		nicID2 = 2
+	)
+
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{fakeNetFactory},
+	})
+	for _, id := range []tcpip.NICID{nicID1, nicID2} {
+		if err := s.CreateNIC(id, channel.New(0, defaultMTU, "")); err != nil {
+			t.Fatalf("CreateNIC(%d, _): %s", id, err)
+		}
+		if err := s.AddAddress(id, fakeNetNumber, tcpip.Address([]byte{byte(id)})); err != nil {
+			t.Fatalf("AddAddress(%d, %d, %d): %s", id, fakeNetNumber, id, err)
+		}
+	}
+	subnet, err := tcpip.NewSubnet("\x00", "\x00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// findRoutes returns the NICs of the routes to the remote addresses 3 to
+	// 255.
+	findRoutes := func() map[tcpip.Address]tcpip.NICID {
+		t.Helper()
+		nics := make(map[tcpip.Address]tcpip.NICID)
+		for i := 3; i < 256; i++ {
+			remoteAddr := tcpip.Address([]byte{byte(i)})
+			r, err := s.FindRoute(0, "", remoteAddr, fakeNetNumber, false /* multicastLoop */)
+			if err != nil {
+				t.Fatalf("s.FindRoute(0, \"\", %d, %d, false): %s", i, fakeNetNumber, err)
+			}
+			nics[remoteAddr] = r.NICID()
+			r.Release()
+		}
+		return nics
+	}
+	countRoutes := func(nics map[tcpip.Address]tcpip.NICID, id tcpip.NICID) int {
+		n := 0
+		for _, nicID := range nics {
+			if nicID == id {
+				n++
+			}
+		}
+		return n
+	}
+
+	// A row without weight isn't part of the multipath route.
+	s.SetRouteTable([]tcpip.Route{
+		{Destination: subnet, NIC: nicID1},
+		{Destination: subnet, NIC: nicID2, Weight: 1},
+	})
+	if n := countRoutes(findRoutes(), nicID1); n != 253 {
+		t.Errorf("got %d routes through NIC %d without multipath route, want = 253", n, nicID1)
+	}
+
+	// The flows are balanced in proportion to the weights of the next hops,
+	// and each flow is always sent through the same next hop.
+	s.SetRouteTable([]tcpip.Route{
+		{Destination: subnet, NIC: nicID1, Weight: 1},
+		{Destination: subnet, NIC: nicID2, Weight: 3},
+	})
+	nics := findRoutes()
+	if n := countRoutes(nics, nicID1); n < 30 || n > 100 {
+		t.Errorf("got %d routes through NIC %d with weight 1 out of 4, want about 63", n, nicID1)
+	}
+	if diff := cmp.Diff(nics, findRoutes()); diff != "" {
+		t.Errorf("routes mismatch (-first +second):\n%s", diff)
+	}
+
+	// Disabled next hops are ignored.
+	if err := s.DisableNIC(nicID2); err != nil {
+		t.Fatalf("s.DisableNIC(%d): %s", nicID2, err)
+	}
+	if n := countRoutes(findRoutes(), nicID1); n != 253 {
+		t.Errorf("got %d routes through NIC %d with NIC %d disabled, want = 253", n, nicID1, nicID2)
+	}
+}
+
+func TestAddRouteWithOptions(t *testing.T) {
+	defaultSubnet, err := tcpip.NewSubnet("\x00", "\x00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subnet, err := tcpip.NewSubnet("\x10", "\xf0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefixRoute := tcpip.Route{Destination: subnet, NIC: 1}
+	defaultRoute1 := tcpip.Route{Destination: defaultSubnet, Gateway: "\x11", NIC: 1, Metric: 10}
+	defaultRoute2 := tcpip.Route{Destination: defaultSubnet, Gateway: "\x12", NIC: 1, Metric: 10}
+	newRoute := tcpip.Route{Destination: defaultSubnet, Gateway: "\x13", NIC: 1, Metric: 10}
+	multipathRoute1 := tcpip.Route{Destination: defaultSubnet, Gateway: "\x11", NIC: 1, Metric: 10, Weight: 1}
+	multipathRoute2 := tcpip.Route{Destination: defaultSubnet, Gateway: "\x12", NIC: 1, Metric: 10, Weight: 1}
+	otherTableRoute := tcpip.Route{Destination: defaultSubnet, Gateway: "\x11", NIC: 1, Metric: 10, Table: 100}
+
+	tests := []struct {
+		name    string
+		table   []tcpip.Route
+		route   tcpip.Route
+		opts    stack.AddRouteOptions
+		want    []tcpip.Route
+		wantErr *tcpip.Error
+	}{
+		{
+			name:  "Lower metric",
+			table: []tcpip.Route{prefixRoute, defaultRoute1},
+			route: tcpip.Route{Destination: defaultSubnet, NIC: 1, Metric: 5},
+			want:  []tcpip.Route{prefixRoute, {Destination: defaultSubnet, NIC: 1, Metric: 5}, defaultRoute1},
+		},
+		{
+			name:  "Higher metric",
+			table: []tcpip.Route{prefixRoute, defaultRoute1},
+			route: tcpip.Route{Destination: defaultSubnet, NIC: 1, Metric: 20},
+			want:  []tcpip.Route{prefixRoute, defaultRoute1, {Destination: defaultSubnet, NIC: 1, Metric: 20}},
+		},
+		{
+			name:  "Longer prefix",
+			table: []tcpip.Route{defaultRoute1},
+			route: prefixRoute,
+			want:  []tcpip.Route{prefixRoute, defaultRoute1},
+		},
+		{
+			name:  "Prepend",
+			table: []tcpip.Route{prefixRoute, defaultRoute1, defaultRoute2},
+			route: newRoute,
+			want:  []tcpip.Route{prefixRoute, newRoute, defaultRoute1, defaultRoute2},
+		},
+		{
+			name:  "Append",
+			table: []tcpip.Route{prefixRoute, defaultRoute1, defaultRoute2},
+			route: newRoute,
+			opts:  stack.AddRouteOptions{Append: true},
+			want:  []tcpip.Route{prefixRoute, defaultRoute1, defaultRoute2, newRoute},
+		},
+		{
+			name:    "Identical",
+			table:   []tcpip.Route{prefixRoute, defaultRoute1},
+			route:   defaultRoute1,
+			opts:    stack.AddRouteOptions{Append: true},
+			want:    []tcpip.Route{prefixRoute, defaultRoute1},
+			wantErr: tcpip.ErrDuplicateAddress,
+		},
+		{
+			name:    "Exclusive",
+			table:   []tcpip.Route{prefixRoute, defaultRoute1},
+			route:   newRoute,
+			opts:    stack.AddRouteOptions{Exclusive: true},
+			want:    []tcpip.Route{prefixRoute, defaultRoute1},
+			wantErr: tcpip.ErrDuplicateAddress,
+		},
+		{
+			name:  "Exclusive in other table",
+			table: []tcpip.Route{otherTableRoute},
+			route: newRoute,
+			opts:  stack.AddRouteOptions{Exclusive: true},
+			want:  []tcpip.Route{newRoute, otherTableRoute},
+		},
+		{
+			name:  "Replace",
+			table: []tcpip.Route{prefixRoute, defaultRoute1, defaultRoute2},
+			route: newRoute,
+			opts:  stack.AddRouteOptions{Replace: true},
+			want:  []tcpip.Route{prefixRoute, newRoute, defaultRoute2},
+		},
+		{
+			name:  "Replace identical",
+			table: []tcpip.Route{defaultRoute1},
+			route: defaultRoute1,
+			opts:  stack.AddRouteOptions{Replace: true},
+			want:  []tcpip.Route{defaultRoute1},
+		},
+		{
+			name:  "Replace multipath",
+			table: []tcpip.Route{prefixRoute, multipathRoute1, multipathRoute2},
+			route: newRoute,
+			opts:  stack.AddRouteOptions{Replace: true},
+			want:  []tcpip.Route{prefixRoute, newRoute},
+		},
+		{
+			name:  "Replace missing",
+			table: []tcpip.Route{prefixRoute, otherTableRoute},
+			route: newRoute,
+			opts:  stack.AddRouteOptions{Replace: true},
+			want:  []tcpip.Route{prefixRoute, newRoute, otherTableRoute},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := stack.New(stack.Options{})
+			s.SetRouteTable(append([]tcpip.Route(nil), test.table...))
+			if err := s.AddRouteWithOptions(test.route, test.opts); err != test.wantErr {
+				t.Errorf("got s.AddRouteWithOptions(%s, %+v) = %v, want = %v", test.route, test.opts, err, test.wantErr)
+			}
+			if diff := cmp.Diff(test.want, s.GetRouteTable()); diff != "" {
+				t.Errorf("route table mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFindRouteWithCache(t *testing.T) {
+	const (
+		nicID1 = 1
+		nicID2 = 2
+	)
+
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{fakeNetFactory},
+	})
+	for _, id := range []tcpip.NICID{nicID1, nicID2} {
+		if err := s.CreateNIC(id, channel.New(0, defaultMTU, "")); err != nil {
+			t.Fatalf("CreateNIC(%d, _): %s", id, err)
+		}
+		if err := s.AddAddress(id, fakeNetNumber, tcpip.Address([]byte{byte(id)})); err != nil {
+			t.Fatalf("AddAddress(%d, %d, %d): %s", id, fakeNetNumber, id, err)
+		}
+	}
+	subnet, err := tcpip.NewSubnet("\x00", "\x00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetRouteTable([]tcpip.Route{{Destination: subnet, NIC: nicID1}})
+
+	var c stack.RouteCache
+	defer c.Release()
+	checkRoute := func(remoteAddr tcpip.Address, wantNIC tcpip.NICID) {
+		t.Helper()
+		r, err := s.FindRouteWithCache(&c, 0, "", remoteAddr, fakeNetNumber, false /* multicastLoop */, stack.RoutePolicyKey{})
+		if err != nil {
+			t.Fatalf("s.FindRouteWithCache(_, 0, \"\", %s, %d, false, {}): %s", remoteAddr, fakeNetNumber, err)
+		}
+		defer r.Release()
+		if got := r.NICID(); got != wantNIC {
+			t.Errorf("got r.NICID() = %d, want = %d", got, wantNIC)
+		}
+		if got := r.RemoteAddress; got != remoteAddr {
+			t.Errorf("got r.RemoteAddress = %s, want = %s", got, remoteAddr)
+		}
+	}
+
+	checkRoute("\x03", nicID1)
+	checkRoute("\x03", nicID1)
+	checkRoute("\x04", nicID1)
+
+	// Changes to the route table invalidate the cached route.
+	s.SetRouteTable([]tcpip.Route{{Destination: subnet, NIC: nicID2}})
+	checkRoute("\x04", nicID2)
+	if err := s.AddRouteWithOptions(tcpip.Route{Destination: subnet, NIC: nicID1}, stack.AddRouteOptions{}); err != nil {
+		t.Fatalf("s.AddRouteWithOptions(_, {}): %s", err)
+	}
+	checkRoute("\x04", nicID1)
+
+	// So do changes to the NICs.
+	if err := s.DisableNIC(nicID1); err != nil {
+		t.Fatalf("s.DisableNIC(%d): %s", nicID1, err)
+	}
+	checkRoute("\x04", nicID2)
+	if err := s.EnableNIC(nicID1); err != nil {
+		t.Fatalf("s.EnableNIC(%d): %s", nicID1, err)
+	}
+	checkRoute("\x04", nicID1)
+
+	// And to their addresses.
+	if err := s.RemoveAddress(nicID1, "\x01"); err != nil {
+		t.Fatalf("s.RemoveAddress(%d, 1): %s", nicID1, err)
+	}
+	checkRoute("\x04", nicID2)
+}
+
+func TestFindRouteWithForwarding(t *testing.T) {
+	const (
+		nicID1 = 1
+		nicID2 = 2
+
+		nic1Addr   = tcpip.Address("\x01")
+		nic2Addr   = tcpip.Address("\x02")
+		remoteAddr = tcpip.Address("\x03")
+	)
+
+	type netCfg struct {
+		proto      tcpip.NetworkProtocolNumber
+		factory    stack.NetworkProtocolFactory
+		nic1Addr   tcpip.Address
+		nic2Addr   tcpip.Address
+		remoteAddr tcpip.Address
+	}
+
+	fakeNetCfg := netCfg{
+		proto:      fakeNetNumber,
+		factory:    fakeNetFactory,
+		nic1Addr:   nic1Addr,
+		nic2Addr:   nic2Addr,
+		remoteAddr: remoteAddr,
+	}
+
+	globalIPv6Addr1 := tcpip.Address(net.ParseIP("a::1").To16())
 	globalIPv6Addr2 := tcpip.Address(net.ParseIP("a::2").To16())
 
 	ipv6LinkLocalNIC1WithGlobalRemote := netCfg{
@@ -4164,6 +5230,118 @@ func TestFindRouteWithForwarding(t *testing.T) {
 	}
 }
 
+func TestFindRouteWithNICForwarding(t *testing.T) {
+	const (
+		nicID1 = 1
+		nicID2 = 2
+	)
+
+	nic1Addr := tcpip.Address(net.ParseIP("a::1").To16())
+	nic2Addr := tcpip.Address(net.ParseIP("b::1").To16())
+	remoteAddr := tcpip.Address(net.ParseIP("b::2").To16())
+
+	tests := []struct {
+		name             string
+		forwarding       bool
+		nic1Forwarding   bool
+		id               tcpip.NICID
+		wantFindRouteErr *tcpip.Error
+
+		// wantSendErr is the error of the writes to the route once the
+		// forwarding configuration of NIC1 is flipped.
+		wantSendErr *tcpip.Error
+	}{
+		{
+			name:             "forwarding enabled on the specified NIC only",
+			forwarding:       false,
+			nic1Forwarding:   true,
+			id:               nicID1,
+			wantFindRouteErr: nil,
+			wantSendErr:      tcpip.ErrInvalidEndpointState,
+		},
+		{
+			name:             "forwarding disabled on the specified NIC only",
+			forwarding:       true,
+			nic1Forwarding:   false,
+			id:               nicID1,
+			wantFindRouteErr: tcpip.ErrNoRoute,
+		},
+		{
+			name:             "forwarding enabled on a NIC without NIC specified",
+			forwarding:       false,
+			nic1Forwarding:   true,
+			wantFindRouteErr: tcpip.ErrNoRoute,
+		},
+		{
+			name:             "forwarding disabled on a NIC without NIC specified",
+			forwarding:       true,
+			nic1Forwarding:   false,
+			wantFindRouteErr: nil,
+			wantSendErr:      nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{ipv6.NewProtocol},
+			})
+
+			ep1 := channel.New(1, defaultMTU, "")
+			if err := s.CreateNIC(nicID1, ep1); err != nil {
+				t.Fatalf("CreateNIC(%d, _): %s:", nicID1, err)
+			}
+			ep2 := channel.New(1, defaultMTU, "")
+			if err := s.CreateNIC(nicID2, ep2); err != nil {
+				t.Fatalf("CreateNIC(%d, _): %s:", nicID2, err)
+			}
+			if err := s.AddAddress(nicID1, ipv6.ProtocolNumber, nic1Addr); err != nil {
+				t.Fatalf("AddAddress(%d, %d, %s): %s", nicID1, ipv6.ProtocolNumber, nic1Addr, err)
+			}
+			if err := s.AddAddress(nicID2, ipv6.ProtocolNumber, nic2Addr); err != nil {
+				t.Fatalf("AddAddress(%d, %d, %s): %s", nicID2, ipv6.ProtocolNumber, nic2Addr, err)
+			}
+
+			// SetForwarding configures all the NICs, the configuration of
+			// NIC1 is then changed alone.
+			if err := s.SetForwarding(ipv6.ProtocolNumber, test.forwarding); err != nil {
+				t.Fatalf("SetForwarding(%d, %t): %s", ipv6.ProtocolNumber, test.forwarding, err)
+			}
+			if err := s.SetNICForwarding(nicID1, ipv6.ProtocolNumber, test.nic1Forwarding); err != nil {
+				t.Fatalf("SetNICForwarding(%d, %d, %t): %s", nicID1, ipv6.ProtocolNumber, test.nic1Forwarding, err)
+			}
+
+			s.SetRouteTable([]tcpip.Route{{Destination: remoteAddr.WithPrefix().Subnet(), NIC: nicID2}})
+
+			r, err := s.FindRoute(test.id, nic1Addr, remoteAddr, ipv6.ProtocolNumber, false /* multicastLoop */)
+			if err != test.wantFindRouteErr {
+				t.Fatalf("FindRoute(%d, %s, %s, %d, false) = %s, want = %s", test.id, nic1Addr, remoteAddr, ipv6.ProtocolNumber, err, test.wantFindRouteErr)
+			}
+			if err != nil {
+				return
+			}
+			defer r.Release()
+
+			data := buffer.View([]byte{1, 2, 3, 4})
+			if err := send(r, data); err != nil {
+				t.Fatalf("send(_, _): %s", err)
+			}
+			if n := ep2.Drain(); n != 1 {
+				t.Errorf("got %d packets from ep2, want = 1", n)
+			}
+
+			// Only the routes bound to NIC1 depend on its forwarding
+			// configuration.
+			if err := s.SetNICForwarding(nicID1, ipv6.ProtocolNumber, !test.nic1Forwarding); err != nil {
+				t.Fatalf("SetNICForwarding(%d, %d, %t): %s", nicID1, ipv6.ProtocolNumber, !test.nic1Forwarding, err)
+			}
+			if err := send(r, data); err != test.wantSendErr {
+				t.Fatalf("got send(_, _) = %s, want = %s", err, test.wantSendErr)
+			}
+		})
+	}
+}
+
 func TestWritePacketToRemote(t *testing.T) {
 	const nicID = 1
 	const MTU = 1280
@@ -4223,3 +5401,66 @@ func TestWritePacketToRemote(t *testing.T) {
 		}
 	})
 }
+
+func TestGSOOption(t *testing.T) {
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{fakeNetFactory},
+	})
+	ep := channel.New(10, defaultMTU, "")
+	if err := s.CreateNIC(1, ep); err != nil {
+		t.Fatalf("CreateNIC(1, _): %s", err)
+	}
+	if err := s.AddAddress(1, fakeNetNumber, "\x01"); err != nil {
+		t.Fatalf("AddAddress(1, %d, 1): %s", fakeNetNumber, err)
+	}
+	{
+		subnet, err := tcpip.NewSubnet("\x00", "\x00")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.SetRouteTable([]tcpip.Route{{Destination: subnet, NIC: 1}})
+	}
+
+	for _, opt := range []stack.GSOOption{
+		{MaxSize: 0, MaxSegs: 1},
+		{MaxSize: stack.SoftwareGSOMaxSize + 1, MaxSegs: 1},
+		{MaxSize: stack.SoftwareGSOMaxSize, MaxSegs: 0},
+	} {
+		if err := s.SetOption(opt); err != tcpip.ErrInvalidOptionValue {
+			t.Errorf("got s.SetOption(%#v) = %v, want = %s", opt, err, tcpip.ErrInvalidOptionValue)
+		}
+	}
+
+	// The limits of the stack apply on top of the ones of the link.
+	opt := stack.GSOOption{MaxSize: ep.GSOMaxSize() / 2, MaxSegs: 4}
+	if err := s.SetOption(opt); err != nil {
+		t.Fatalf("s.SetOption(%#v) = %s", opt, err)
+	}
+	var got stack.GSOOption
+	if err := s.Option(&got); err != nil {
+		t.Fatalf("s.Option(_) = %s", err)
+	}
+	if got != opt {
+		t.Errorf("got s.Option(_) = %#v, want = %#v", got, opt)
+	}
+
+	r, err := s.FindRoute(0, "", "\x02", fakeNetNumber, false /* multicastLoop */)
+	if err != nil {
+		t.Fatalf("FindRoute(0, \"\", 2, %d, false): %s", fakeNetNumber, err)
+	}
+	defer r.Release()
+	if got, want := r.GSOMaxSize(), opt.MaxSize; got != want {
+		t.Errorf("got r.GSOMaxSize() = %d, want = %d", got, want)
+	}
+	if got, want := r.GSOMaxSegs(), opt.MaxSegs; got != want {
+		t.Errorf("got r.GSOMaxSegs() = %d, want = %d", got, want)
+	}
+
+	opt.MaxSize = stack.SoftwareGSOMaxSize
+	if err := s.SetOption(opt); err != nil {
+		t.Fatalf("s.SetOption(%#v) = %s", opt, err)
+	}
+	if got, want := r.GSOMaxSize(), ep.GSOMaxSize(); got != want {
+		t.Errorf("got r.GSOMaxSize() = %d, want = %d", got, want)
+	}
+}
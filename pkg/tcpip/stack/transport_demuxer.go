@@ -150,17 +150,41 @@ func (epsByNIC *endpointsByNIC) transportEndpoints() []TransportEndpoint {
 	return eps
 }
 
+// findLocked returns the endpoints bound to the device which receives the
+// packets of the NIC nicID, whose L3 master device is master: the NIC itself,
+// else its VRF device, else any device. The endpoints bound to any device only
+// receive the packets of a NIC enslaved to a VRF device if the stack has
+// L3MasterDeviceAcceptOption enabled.
+//
+// Precondition: epsByNIC.mu must be read locked.
+func (epsByNIC *endpointsByNIC) findLocked(nicID, master tcpip.NICID) (*multiPortEndpoint, bool) {
+	if mpep, ok := epsByNIC.endpoints[nicID]; ok {
+		return mpep, true
+	}
+	if master != 0 {
+		if mpep, ok := epsByNIC.endpoints[master]; ok {
+			return mpep, true
+		}
+	}
+	mpep, ok := epsByNIC.endpoints[0]
+	if !ok {
+		return nil, false
+	}
+	if master != 0 && !mpep.demux.stack.l3MasterDeviceAccept() {
+		return nil, false
+	}
+	return mpep, true
+}
+
 // HandlePacket is called by the stack when new packets arrive to this transport
 // endpoint.
 func (epsByNIC *endpointsByNIC) handlePacket(id TransportEndpointID, pkt *PacketBuffer) {
 	epsByNIC.mu.RLock()
 
-	mpep, ok := epsByNIC.endpoints[pkt.NICID]
+	mpep, ok := epsByNIC.findLocked(pkt.NICID, pkt.L3MasterNICID)
 	if !ok {
-		if mpep, ok = epsByNIC.endpoints[0]; !ok {
-			epsByNIC.mu.RUnlock() // Don't use defer for performance reasons.
-			return
-		}
+		epsByNIC.mu.RUnlock() // Don't use defer for performance reasons.
+		return
 	}
 
 	// If this is a broadcast or multicast datagram, deliver the datagram to all
@@ -171,7 +195,7 @@ func (epsByNIC *endpointsByNIC) handlePacket(id TransportEndpointID, pkt *Packet
 		return
 	}
 	// multiPortEndpoints are guaranteed to have at least one element.
-	transEP := selectEndpoint(id, mpep, epsByNIC.seed)
+	transEP := selectEndpoint(id, mpep, epsByNIC.seed, pkt)
 	if queuedProtocol, mustQueue := mpep.demux.queuedProtocols[protocolIDs{mpep.netProto, mpep.transProto}]; mustQueue {
 		queuedProtocol.QueuePacket(transEP, id, pkt)
 		epsByNIC.mu.RUnlock()
@@ -187,10 +211,7 @@ func (epsByNIC *endpointsByNIC) handleControlPacket(n *NIC, id TransportEndpoint
 	epsByNIC.mu.RLock()
 	defer epsByNIC.mu.RUnlock()
 
-	mpep, ok := epsByNIC.endpoints[n.ID()]
-	if !ok {
-		mpep, ok = epsByNIC.endpoints[0]
-	}
+	mpep, ok := epsByNIC.findLocked(n.ID(), n.l3MasterDevice())
 	if !ok {
 		return
 	}
@@ -199,7 +220,7 @@ func (epsByNIC *endpointsByNIC) handleControlPacket(n *NIC, id TransportEndpoint
 	// broadcast like we are doing with handlePacket above?
 
 	// multiPortEndpoints are guaranteed to have at least one element.
-	selectEndpoint(id, mpep, epsByNIC.seed).HandleControlPacket(id, typ, extra, pkt)
+	selectEndpoint(id, mpep, epsByNIC.seed, nil /* pkt */).HandleControlPacket(id, typ, extra, pkt)
 }
 
 // registerEndpoint returns true if it succeeds. It fails and returns
@@ -247,6 +268,23 @@ func (epsByNIC *endpointsByNIC) unregisterEndpoint(bindToDevice tcpip.NICID, t T
 	return len(epsByNIC.endpoints) == 0
 }
 
+// setReusePortFilter attaches filter to the group of endpoints bound to
+// bindToDevice if t belongs to it.
+func (epsByNIC *endpointsByNIC) setReusePortFilter(bindToDevice tcpip.NICID, t TransportEndpoint, filter tcpip.ReusePortFilter) {
+	epsByNIC.mu.Lock()
+	defer epsByNIC.mu.Unlock()
+	multiPortEp, ok := epsByNIC.endpoints[bindToDevice]
+	if !ok {
+		return
+	}
+	for _, endpoint := range multiPortEp.endpoints {
+		if endpoint == t {
+			multiPortEp.filter = filter
+			return
+		}
+	}
+}
+
 // transportDemuxer demultiplexes packets targeted at a transport endpoint
 // (i.e., after they've been parsed by the network layer). It does two levels
 // of demultiplexing: first based on the network and transport protocols, then
@@ -334,6 +372,11 @@ type multiPortEndpoint struct {
 	// were bound. This is required for UDP SO_REUSEADDR.
 	endpoints []TransportEndpoint
 	flags     ports.FlagCounter
+
+	// filter selects the endpoint which receives a packet if set. It is
+	// protected by the mutex of the endpointsByNIC holding this
+	// multiPortEndpoint.
+	filter tcpip.ReusePortFilter `state:"nosave"`
 }
 
 func (ep *multiPortEndpoint) transportEndpoints() []TransportEndpoint {
@@ -354,7 +397,11 @@ func reciprocalScale(val, n uint32) uint32 {
 // selectEndpoint calculates a hash of destination and source addresses and
 // ports then uses it to select a socket. In this case, all packets from one
 // address will be sent to same endpoint.
-func selectEndpoint(id TransportEndpointID, mpep *multiPortEndpoint, seed uint32) TransportEndpoint {
+//
+// If a filter is attached to mpep and pkt is not nil, the filter selects the
+// socket instead. The hash is used if the filter fails or selects an index
+// out of range, like Linux does.
+func selectEndpoint(id TransportEndpointID, mpep *multiPortEndpoint, seed uint32, pkt *PacketBuffer) TransportEndpoint {
 	if len(mpep.endpoints) == 1 {
 		return mpep.endpoints[0]
 	}
@@ -363,6 +410,12 @@ func selectEndpoint(id TransportEndpointID, mpep *multiPortEndpoint, seed uint32
 		return mpep.endpoints[len(mpep.endpoints)-1]
 	}
 
+	if mpep.filter != nil && pkt != nil {
+		if idx, ok := mpep.filter.Select(pkt.Data); ok && idx < uint32(len(mpep.endpoints)) {
+			return mpep.endpoints[idx]
+		}
+	}
+
 	payload := []byte{
 		byte(id.LocalPort),
 		byte(id.LocalPort >> 8),
@@ -518,6 +571,54 @@ func (d *transportDemuxer) unregisterEndpoint(netProtos []tcpip.NetworkProtocolN
 	}
 }
 
+// setReusePortFilter attaches filter to the SO_REUSEPORT group which ep, bound
+// with id and bindToDevice, belongs to. A nil filter detaches the current one.
+func (d *transportDemuxer) setReusePortFilter(netProtos []tcpip.NetworkProtocolNumber, protocol tcpip.TransportProtocolNumber, id TransportEndpointID, ep TransportEndpoint, filter tcpip.ReusePortFilter, bindToDevice tcpip.NICID) {
+	if id.RemotePort != 0 {
+		// SO_REUSEPORT only applies to bound/listening endpoints.
+		return
+	}
+
+	for _, n := range netProtos {
+		eps, ok := d.protocol[protocolIDs{n, protocol}]
+		if !ok {
+			continue
+		}
+		eps.mu.RLock()
+		epsByNIC, ok := eps.endpoints[id]
+		eps.mu.RUnlock()
+		if !ok {
+			continue
+		}
+		epsByNIC.setReusePortFilter(bindToDevice, ep, filter)
+	}
+}
+
+// boundEndpoints returns the endpoints bound to the device nicID.
+func (d *transportDemuxer) boundEndpoints(nicID tcpip.NICID) []TransportEndpoint {
+	seen := make(map[TransportEndpoint]struct{})
+	var es []TransportEndpoint
+	for _, eps := range d.protocol {
+		eps.mu.RLock()
+		for _, epsByNIC := range eps.endpoints {
+			epsByNIC.mu.RLock()
+			if mpep, ok := epsByNIC.endpoints[nicID]; ok {
+				// Endpoints registered for several network protocols are
+				// only returned once.
+				for _, ep := range mpep.transportEndpoints() {
+					if _, ok := seen[ep]; !ok {
+						seen[ep] = struct{}{}
+						es = append(es, ep)
+					}
+				}
+			}
+			epsByNIC.mu.RUnlock()
+		}
+		eps.mu.RUnlock()
+	}
+	return es
+}
+
 // deliverPacket attempts to find one or more matching transport endpoints, and
 // then, if matches are found, delivers the packet to them. Returns true if
 // the packet no longer needs to be handled.
@@ -527,15 +628,15 @@ func (d *transportDemuxer) deliverPacket(protocol tcpip.TransportProtocolNumber,
 		return false
 	}
 
-	// If the packet is a UDP broadcast or multicast, then find all matching
-	// transport endpoints.
-	if protocol == header.UDPProtocolNumber && isInboundMulticastOrBroadcast(pkt, id.LocalAddress) {
+	// If the packet is a UDP or UDP-Lite broadcast or multicast, then find all
+	// matching transport endpoints.
+	if (protocol == header.UDPProtocolNumber || protocol == header.UDPLiteProtocolNumber) && isInboundMulticastOrBroadcast(pkt, id.LocalAddress) {
 		eps.mu.RLock()
 		destEPs := eps.findAllEndpointsLocked(id)
 		eps.mu.RUnlock()
 		// Fail if we didn't find at least one matching transport endpoint.
 		if len(destEPs) == 0 {
-			d.stack.stats.UDP.UnknownPortErrors.Increment()
+			d.stack.udpStats(protocol).UnknownPortErrors.Increment()
 			return false
 		}
 		// handlePacket takes ownership of pkt, so each endpoint needs its own
@@ -558,12 +659,20 @@ func (d *transportDemuxer) deliverPacket(protocol tcpip.TransportProtocolNumber,
 		return true
 	}
 
+	// Likewise, SCTP packets with a non-unicast destination address are
+	// silently discarded, as per RFC 4960 section 8.4.
+	if protocol == header.SCTPProtocolNumber && (!isSpecified(id.RemoteAddress) || isInboundMulticastOrBroadcast(pkt, id.LocalAddress)) {
+		d.stack.stats.SCTP.InvalidPacketsReceived.Increment()
+		return true
+	}
+
 	eps.mu.RLock()
 	ep := eps.findEndpointLocked(id)
 	eps.mu.RUnlock()
 	if ep == nil {
-		if protocol == header.UDPProtocolNumber {
-			d.stack.stats.UDP.UnknownPortErrors.Increment()
+		switch protocol {
+		case header.UDPProtocolNumber, header.UDPLiteProtocolNumber:
+			d.stack.udpStats(protocol).UnknownPortErrors.Increment()
 		}
 		return false
 	}
@@ -614,8 +723,10 @@ func (d *transportDemuxer) deliverControlPacket(n *NIC, net tcpip.NetworkProtoco
 	return true
 }
 
-// findTransportEndpoint find a single endpoint that most closely matches the provided id.
-func (d *transportDemuxer) findTransportEndpoint(netProto tcpip.NetworkProtocolNumber, transProto tcpip.TransportProtocolNumber, id TransportEndpointID, nicID tcpip.NICID) TransportEndpoint {
+// findTransportEndpoint find a single endpoint that most closely matches the
+// provided id, for a packet received by the NIC nicID whose L3 master device is
+// master.
+func (d *transportDemuxer) findTransportEndpoint(netProto tcpip.NetworkProtocolNumber, transProto tcpip.TransportProtocolNumber, id TransportEndpointID, nicID, master tcpip.NICID) TransportEndpoint {
 	eps, ok := d.protocol[protocolIDs{netProto, transProto}]
 	if !ok {
 		return nil
@@ -631,15 +742,13 @@ func (d *transportDemuxer) findTransportEndpoint(netProto tcpip.NetworkProtocolN
 	epsByNIC.mu.RLock()
 	eps.mu.RUnlock()
 
-	mpep, ok := epsByNIC.endpoints[nicID]
+	mpep, ok := epsByNIC.findLocked(nicID, master)
 	if !ok {
-		if mpep, ok = epsByNIC.endpoints[0]; !ok {
-			epsByNIC.mu.RUnlock() // Don't use defer for performance reasons.
-			return nil
-		}
+		epsByNIC.mu.RUnlock() // Don't use defer for performance reasons.
+		return nil
 	}
 
-	ep := selectEndpoint(id, mpep, epsByNIC.seed)
+	ep := selectEndpoint(id, mpep, epsByNIC.seed, nil /* pkt */)
 	epsByNIC.mu.RUnlock()
 	return ep
 }
@@ -383,3 +383,78 @@ func TestBindToDeviceDistribution(t *testing.T) {
 		}
 	}
 }
+
+// payloadIndexFilter is a tcpip.ReusePortFilter which selects the endpoint
+// whose index is the first byte of the payload.
+type payloadIndexFilter struct{}
+
+// Select implements tcpip.ReusePortFilter.Select.
+func (payloadIndexFilter) Select(payload buffer.VectorisedView) (uint32, bool) {
+	if payload.Size() == 0 {
+		return 0, false
+	}
+	return uint32(payload.ToView()[0]), true
+}
+
+// TestReusePortFilter checks that a filter attached to a SO_REUSEPORT group
+// selects the endpoint which receives each packet, whether it is attached
+// before or after the endpoint is bound.
+func TestReusePortFilter(t *testing.T) {
+	const nEndpoints = 3
+	for _, test := range []struct {
+		name       string
+		attachedAt int
+		beforeBind bool
+	}{
+		{name: "BeforeBind", attachedAt: 0, beforeBind: true},
+		{name: "AfterBind", attachedAt: 2, beforeBind: false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := newDualTestContextMultiNIC(t, defaultMTU, []tcpip.NICID{1})
+
+			var eps [nEndpoints]tcpip.Endpoint
+			for i := range eps {
+				var wq waiter.Queue
+				ep, err := c.s.NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+				if err != nil {
+					t.Fatalf("NewEndpoint failed: %s", err)
+				}
+				defer ep.Close()
+				eps[i] = ep
+
+				ep.SocketOptions().SetReusePort(true)
+				if i == test.attachedAt && test.beforeBind {
+					ep.SocketOptions().SetReusePortFilter(payloadIndexFilter{})
+				}
+				if err := ep.Bind(tcpip.FullAddress{Addr: testDstAddrV4, Port: testDstPort}); err != nil {
+					t.Fatalf("ep.Bind(...) on endpoint %d failed: %s", i, err)
+				}
+			}
+			if !test.beforeBind {
+				eps[test.attachedAt].SocketOptions().SetReusePortFilter(payloadIndexFilter{})
+			}
+
+			const npackets = 30
+			for i := 0; i < npackets; i++ {
+				payload := newPayload()
+				payload[0] = byte(i % nEndpoints)
+				c.sendV4Packet(payload, &headers{srcPort: testSrcPort + uint16(i), dstPort: testDstPort}, 1)
+			}
+
+			for i, ep := range eps {
+				for n := 0; n < npackets/nEndpoints; n++ {
+					v, _, err := ep.Read(nil)
+					if err != nil {
+						t.Fatalf("ep.Read(nil) on endpoint %d after %d packets failed: %s", i, n, err)
+					}
+					if got := int(v[0]); got != i {
+						t.Errorf("endpoint %d got packet for endpoint %d", i, got)
+					}
+				}
+				if _, _, err := ep.Read(nil); err != tcpip.ErrWouldBlock {
+					t.Errorf("ep.Read(nil) on endpoint %d = %s, want %s", i, err, tcpip.ErrWouldBlock)
+				}
+			}
+		})
+	}
+}
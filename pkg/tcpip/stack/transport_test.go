@@ -370,7 +370,7 @@ func TestTransportReceive(t *testing.T) {
 		t.Fatalf("NewEndpoint failed: %v", err)
 	}
 
-	if err := ep.Connect(tcpip.FullAddress{0, "\x02", 0}); err != nil {
+	if err := ep.Connect(tcpip.FullAddress{NIC: 0, Addr: "\x02", Port: 0}); err != nil {
 		t.Fatalf("Connect failed: %v", err)
 	}
 
@@ -441,7 +441,7 @@ func TestTransportControlReceive(t *testing.T) {
 		t.Fatalf("NewEndpoint failed: %v", err)
 	}
 
-	if err := ep.Connect(tcpip.FullAddress{0, "\x02", 0}); err != nil {
+	if err := ep.Connect(tcpip.FullAddress{NIC: 0, Addr: "\x02", Port: 0}); err != nil {
 		t.Fatalf("Connect failed: %v", err)
 	}
 
@@ -518,7 +518,7 @@ func TestTransportSend(t *testing.T) {
 		t.Fatalf("NewEndpoint failed: %v", err)
 	}
 
-	if err := ep.Connect(tcpip.FullAddress{0, "\x02", 0}); err != nil {
+	if err := ep.Connect(tcpip.FullAddress{NIC: 0, Addr: "\x02", Port: 0}); err != nil {
 		t.Fatalf("Connect failed: %v", err)
 	}
 
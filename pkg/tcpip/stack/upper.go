@@ -0,0 +1,204 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// upperLinkEndpoint is the link endpoint of a NIC layered on a parent NIC,
+// like a VLAN or MACVLAN NIC, which sends and receives packets through its
+// parent.
+type upperLinkEndpoint interface {
+	LinkEndpoint
+
+	// parentNIC returns the NIC the endpoint is layered on.
+	parentNIC() *NIC
+
+	// unregister removes the endpoint from the NICs layered on its parent.
+	unregister()
+}
+
+// upperEndpoint implements the parts of upperLinkEndpoint common to all the
+// NICs layered on a parent NIC.
+type upperEndpoint struct {
+	parent *NIC
+
+	mu         sync.RWMutex
+	dispatcher NetworkDispatcher
+}
+
+// parentNIC implements upperLinkEndpoint.parentNIC.
+func (e *upperEndpoint) parentNIC() *NIC {
+	return e.parent
+}
+
+// MTU implements LinkEndpoint.MTU. As in Linux, it is the MTU of the parent
+// NIC.
+func (e *upperEndpoint) MTU() uint32 {
+	return e.parent.MTU()
+}
+
+// Carrier implements CarrierReporter.Carrier. As in Linux, it is the carrier
+// of the parent NIC.
+func (e *upperEndpoint) Carrier() bool {
+	return e.parent.Carrier()
+}
+
+// MaxHeaderLength implements LinkEndpoint.MaxHeaderLength.
+func (e *upperEndpoint) MaxHeaderLength() uint16 {
+	return e.parent.LinkEndpoint.MaxHeaderLength()
+}
+
+// LinkAddress implements LinkEndpoint.LinkAddress.
+func (e *upperEndpoint) LinkAddress() tcpip.LinkAddress {
+	return e.parent.LinkEndpoint.LinkAddress()
+}
+
+// Capabilities implements LinkEndpoint.Capabilities.
+func (e *upperEndpoint) Capabilities() LinkEndpointCapabilities {
+	return e.parent.capabilities()
+}
+
+// Attach implements LinkEndpoint.Attach.
+func (e *upperEndpoint) Attach(dispatcher NetworkDispatcher) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dispatcher = dispatcher
+}
+
+// IsAttached implements LinkEndpoint.IsAttached.
+func (e *upperEndpoint) IsAttached() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.dispatcher != nil
+}
+
+// Wait implements LinkEndpoint.Wait.
+func (*upperEndpoint) Wait() {}
+
+// ARPHardwareType implements LinkEndpoint.ARPHardwareType.
+func (*upperEndpoint) ARPHardwareType() header.ARPHardwareType {
+	return header.ARPHardwareEther
+}
+
+// AddHeader implements LinkEndpoint.AddHeader.
+func (e *upperEndpoint) AddHeader(local, remote tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, pkt *PacketBuffer) {
+	e.parent.LinkEndpoint.AddHeader(local, remote, protocol, pkt)
+}
+
+// AddFilterAddress implements LinkAddressFilter.AddFilterAddress by adding
+// addr to the filter of the parent NIC, so that MACVLAN NICs may be layered on
+// VLAN NICs.
+func (e *upperEndpoint) AddFilterAddress(addr tcpip.LinkAddress) {
+	if f, ok := e.parent.LinkEndpoint.(LinkAddressFilter); ok {
+		f.AddFilterAddress(addr)
+	}
+}
+
+// RemoveFilterAddress implements LinkAddressFilter.RemoveFilterAddress.
+func (e *upperEndpoint) RemoveFilterAddress(addr tcpip.LinkAddress) {
+	if f, ok := e.parent.LinkEndpoint.(LinkAddressFilter); ok {
+		f.RemoveFilterAddress(addr)
+	}
+}
+
+// deliver delivers pkt, a packet received by the parent NIC, to the NIC of e.
+func (e *upperEndpoint) deliver(remote, local tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, pkt *PacketBuffer) {
+	e.mu.RLock()
+	d := e.dispatcher
+	e.mu.RUnlock()
+	if d != nil {
+		d.DeliverNetworkPacket(remote, local, protocol, pkt)
+	}
+}
+
+// UpperNICOptions specify the details common to the NICs layered on a parent
+// NIC, like VLAN, IPVLAN and MACVLAN NICs.
+type UpperNICOptions struct {
+	// Name is the name of the NIC.
+	Name string
+
+	// Disabled specifies whether to avoid calling Attach on the NIC's link
+	// endpoint when it is created, as NICOptions.Disabled does.
+	Disabled bool
+}
+
+// createUpperNICLocked creates the NIC id for ep, an endpoint layered on its
+// parent NIC.
+//
+// Precondition: s.mu must be locked.
+func (s *Stack) createUpperNICLocked(id tcpip.NICID, ep upperLinkEndpoint, opts UpperNICOptions) (*NIC, *tcpip.Error) {
+	if err := s.createNICLocked(id, ep, NICOptions{Name: opts.Name, Disabled: opts.Disabled}, false /* l3mdev */); err != nil {
+		return nil, err
+	}
+	return s.nics[id], nil
+}
+
+// upperParentLocked returns the NIC with the given ID if NICs may be layered
+// on it.
+//
+// Precondition: s.mu must be locked.
+func (s *Stack) upperParentLocked(id tcpip.NICID) (*NIC, *tcpip.Error) {
+	p, ok := s.nics[id]
+	if !ok {
+		return nil, tcpip.ErrUnknownNICID
+	}
+	if p.l3mdev || p.LinkEndpoint.ARPHardwareType() != header.ARPHardwareEther {
+		return nil, tcpip.ErrNotSupported
+	}
+	return p, nil
+}
+
+// addressedUpperParentLocked is like upperParentLocked, for the NICs which
+// are addressed by their own link or network addresses on the link of their
+// parent, like MACVLAN and IPVLAN NICs.
+//
+// Precondition: s.mu must be locked.
+func (s *Stack) addressedUpperParentLocked(id tcpip.NICID) (*NIC, *tcpip.Error) {
+	p, err := s.upperParentLocked(id)
+	if err != nil {
+		return nil, err
+	}
+	switch p.LinkEndpoint.(type) {
+	case *macvlanEndpoint, *ipvlanEndpoint:
+		// Linux layers the NICs created on top of MACVLAN and IPVLAN NICs
+		// on their parent instead, which callers are expected to do.
+		return nil, tcpip.ErrNotSupported
+	}
+	return p, nil
+}
+
+// removeUpperNICsLocked removes the NICs layered on nic, and removes nic from
+// the NICs layered on its parent if it has one. It returns the IDs of the NICs
+// removed.
+//
+// Precondition: s.mu must be locked.
+func (s *Stack) removeUpperNICsLocked(nic *NIC) []tcpip.NICID {
+	if ep, ok := nic.LinkEndpoint.(upperLinkEndpoint); ok {
+		ep.unregister()
+	}
+
+	var removed []tcpip.NICID
+	for id, n := range s.nics {
+		if ep, ok := n.LinkEndpoint.(upperLinkEndpoint); ok && ep.parentNIC() == nic {
+			ids, _ := s.removeNICLocked(id)
+			removed = append(removed, ids...)
+		}
+	}
+	return removed
+}
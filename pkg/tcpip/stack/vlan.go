@@ -0,0 +1,215 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// VLANOptions specify the details of a VLAN NIC.
+type VLANOptions struct {
+	UpperNICOptions
+
+	// Protocol is the TPID of the VLAN tag, header.VLANProtocol8021Q or
+	// header.VLANProtocol8021AD. If 0, header.VLANProtocol8021Q is used.
+	Protocol tcpip.NetworkProtocolNumber
+}
+
+// VLANInfo describes a VLAN NIC.
+type VLANInfo struct {
+	// Parent is the NIC the VLAN NIC sends and receives packets through.
+	Parent tcpip.NICID
+
+	// ID is the VLAN identifier of the NIC.
+	ID uint16
+
+	// Protocol is the TPID of the VLAN tag of the NIC.
+	Protocol tcpip.NetworkProtocolNumber
+}
+
+// vlanKey identifies the VLAN NICs of a NIC.
+type vlanKey struct {
+	protocol tcpip.NetworkProtocolNumber
+	id       uint16
+}
+
+// vlanEndpoint is the link endpoint of a VLAN NIC. It tags the packets it
+// sends with its VLAN identifier and sends them through the link endpoint of
+// its parent NIC, and receives the untagged packets of its VLAN from its
+// parent NIC.
+//
+// As with Linux's REORDER_HDR, the tag is not visible to the packet sockets
+// of the VLAN NIC.
+type vlanEndpoint struct {
+	upperEndpoint
+	info VLANInfo
+}
+
+var _ upperLinkEndpoint = (*vlanEndpoint)(nil)
+
+// MaxHeaderLength implements LinkEndpoint.MaxHeaderLength.
+func (e *vlanEndpoint) MaxHeaderLength() uint16 {
+	return e.parent.LinkEndpoint.MaxHeaderLength() + header.VLANMinimumSize
+}
+
+// Capabilities implements LinkEndpoint.Capabilities. Segmentation offloads
+// aren't supported as the tagged packets are built by the VLAN NIC.
+func (e *vlanEndpoint) Capabilities() LinkEndpointCapabilities {
+	return e.parent.capabilities() &^ (CapabilityHardwareGSO | CapabilitySoftwareGSO)
+}
+
+// WritePacket implements LinkEndpoint.WritePacket.
+func (e *vlanEndpoint) WritePacket(r *Route, _ *GSO, protocol tcpip.NetworkProtocolNumber, pkt *PacketBuffer) *tcpip.Error {
+	tag := buffer.NewView(header.VLANMinimumSize)
+	header.VLAN(tag).Encode(&header.VLANFields{
+		ID:   e.info.ID,
+		Type: protocol,
+	})
+	views := append([]buffer.View{tag}, pkt.Views()...)
+	tagged := NewPacketBuffer(PacketBufferOptions{
+		ReserveHeaderBytes: int(e.parent.LinkEndpoint.MaxHeaderLength()),
+		Data:               buffer.NewVectorisedView(len(tag)+pkt.Size(), views),
+	})
+	tagged.Owner = pkt.Owner
+	tagged.Hash = pkt.Hash
+	tagged.NetworkProtocolNumber = e.info.Protocol
+	return e.parent.LinkEndpoint.WritePacket(r, nil /* gso */, e.info.Protocol, tagged)
+}
+
+// WritePackets implements LinkEndpoint.WritePackets.
+func (e *vlanEndpoint) WritePackets(r *Route, gso *GSO, pkts PacketBufferList, protocol tcpip.NetworkProtocolNumber) (int, *tcpip.Error) {
+	n := 0
+	for pkt := pkts.Front(); pkt != nil; pkt = pkt.Next() {
+		if err := e.WritePacket(r, gso, protocol, pkt); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// unregister implements upperLinkEndpoint.unregister.
+func (e *vlanEndpoint) unregister() {
+	p := e.parent
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.mu.vlans, vlanKey{protocol: e.info.Protocol, id: e.info.ID})
+}
+
+// deliverTagged handles pkt, a packet with a VLAN tag of TPID protocol
+// received by n. It returns false if n has no VLAN NIC for the tag, in which
+// case pkt is left untouched.
+//
+// Like Linux, the packets with a VLAN identifier of 0, which are only tagged
+// with a priority, are delivered to n itself.
+func (n *NIC) deliverTagged(remote, local tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, pkt *PacketBuffer) bool {
+	v, ok := pkt.Data.PullUp(header.VLANMinimumSize)
+	if !ok {
+		return false
+	}
+	tag := header.VLAN(v)
+	id, inner := tag.ID(), tag.Type()
+
+	if id == 0 {
+		pkt.Data.TrimFront(header.VLANMinimumSize)
+		n.DeliverNetworkPacket(remote, local, inner, pkt)
+		return true
+	}
+
+	n.mu.RLock()
+	vlan, ok := n.mu.vlans[vlanKey{protocol: protocol, id: id}]
+	n.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	pkt.Data.TrimFront(header.VLANMinimumSize)
+	vlan.deliver(remote, local, inner, pkt)
+	return true
+}
+
+// CreateVLAN creates the NIC id for the VLAN vlanID of the NIC parent. The
+// packets sent through the VLAN NIC are tagged with vlanID and sent through
+// parent, and the packets received by parent with the tag of vlanID are
+// untagged and delivered to the VLAN NIC.
+//
+// VLAN NICs may be created on top of other VLAN NICs, which is how QinQ
+// (802.1ad) frames are handled: the outer VLAN NIC uses
+// header.VLANProtocol8021AD and the inner one header.VLANProtocol8021Q.
+//
+// The VLAN NIC is removed when its parent is.
+func (s *Stack) CreateVLAN(id, parent tcpip.NICID, vlanID uint16, opts VLANOptions) *tcpip.Error {
+	protocol := opts.Protocol
+	if protocol == 0 {
+		protocol = header.VLANProtocol8021Q
+	}
+	if !header.IsVLANProtocol(protocol) || vlanID == 0 || vlanID > header.VLANMaximumID {
+		return tcpip.ErrInvalidOptionValue
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, err := s.upperParentLocked(parent)
+	if err != nil {
+		return err
+	}
+
+	key := vlanKey{protocol: protocol, id: vlanID}
+	p.mu.RLock()
+	_, ok := p.mu.vlans[key]
+	p.mu.RUnlock()
+	if ok {
+		return tcpip.ErrDuplicateNICID
+	}
+
+	ep := &vlanEndpoint{
+		upperEndpoint: upperEndpoint{parent: p},
+		info: VLANInfo{
+			Parent:   parent,
+			ID:       vlanID,
+			Protocol: protocol,
+		},
+	}
+	if _, err := s.createUpperNICLocked(id, ep, opts.UpperNICOptions); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	if p.mu.vlans == nil {
+		p.mu.vlans = make(map[vlanKey]*vlanEndpoint)
+	}
+	p.mu.vlans[key] = ep
+	p.mu.Unlock()
+	return nil
+}
+
+// GetVLANInfo returns the details of the VLAN NIC id. It returns
+// tcpip.ErrNotSupported if id isn't a VLAN NIC.
+func (s *Stack) GetVLANInfo(id tcpip.NICID) (VLANInfo, *tcpip.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nic, ok := s.nics[id]
+	if !ok {
+		return VLANInfo{}, tcpip.ErrUnknownNICID
+	}
+	ep, ok := nic.LinkEndpoint.(*vlanEndpoint)
+	if !ok {
+		return VLANInfo{}, tcpip.ErrNotSupported
+	}
+	return ep.info, nil
+}
@@ -112,6 +112,7 @@ var (
 	ErrNotPermitted              = &Error{msg: "operation not permitted"}
 	ErrAddressFamilyNotSupported = &Error{msg: "address family not supported by protocol"}
 	ErrMalformedHeader           = &Error{msg: "header is malformed"}
+	ErrCanceled                  = &Error{msg: "operation canceled"}
 )
 
 var messageToError map[string]*Error
@@ -161,6 +162,7 @@ func StringToError(s string) *Error {
 			ErrNotPermitted,
 			ErrAddressFamilyNotSupported,
 			ErrMalformedHeader,
+			ErrCanceled,
 		}
 
 		messageToError = make(map[string]*Error)
@@ -425,6 +427,12 @@ type FullAddress struct {
 	//
 	// This may not be used by all endpoint types.
 	Port uint16
+
+	// FlowLabel is the IPv6 flow label, as in the sin6_flowinfo field of an
+	// IPv6 socket address.
+	//
+	// This may not be used by all endpoint types.
+	FlowLabel uint32
 }
 
 // Payloader is an interface that provides data.
@@ -468,6 +476,14 @@ type ControlMessages struct {
 	// the read data was received.
 	Timestamp int64
 
+	// HasTimestamping indicates whether Timestamping is valid/set.
+	HasTimestamping bool
+
+	// Timestamping is the software timestamp (in ns) reported with
+	// SCM_TIMESTAMPING, i.e. the receive time of the packet, or the time of
+	// a transmit timestamp read from the error queue.
+	Timestamping int64
+
 	// HasInq indicates whether Inq is valid/set.
 	HasInq bool
 
@@ -486,11 +502,83 @@ type ControlMessages struct {
 	// TClass is the IPv6 traffic class of the associated packet.
 	TClass uint32
 
+	// HasFlowInfo indicates whether FlowInfo is valid/set.
+	HasFlowInfo bool
+
+	// FlowInfo is the IPv6 traffic class and flow label of the associated
+	// packet, as they appear in the IPv6 header.
+	FlowInfo uint32
+
 	// HasIPPacketInfo indicates whether PacketInfo is set.
 	HasIPPacketInfo bool
 
 	// PacketInfo holds interface and address data on an incoming packet.
 	PacketInfo IPPacketInfo
+
+	// SockErr is the dequeued socket error on recvmsg(MSG_ERRQUEUE).
+	SockErr *SockError
+
+	// HasTxTime indicates whether TxTime is set.
+	HasTxTime bool
+
+	// TxTime is the SCM_TXTIME transmit time of a sent message, in
+	// nanoseconds of the clock set with SO_TXTIME.
+	TxTime uint64
+
+	// HasSCTPRcvInfo indicates whether SCTPRcvInfo is set.
+	HasSCTPRcvInfo bool
+
+	// SCTPRcvInfo describes the SCTP message read, as per SCTP_RCVINFO.
+	SCTPRcvInfo SCTPRcvInfo
+
+	// HasSCTPSndInfo indicates whether SCTPSndInfo is set.
+	HasSCTPSndInfo bool
+
+	// SCTPSndInfo describes how to send the SCTP message written, as per
+	// SCTP_SNDINFO.
+	SCTPSndInfo SCTPSndInfo
+}
+
+// SCTPRcvInfo describes a message received on an SCTP association.
+//
+// +stateify savable
+type SCTPRcvInfo struct {
+	// Stream is the stream the message was received on.
+	Stream uint16
+
+	// SSN is the stream sequence number of the message. It is meaningless
+	// for unordered messages.
+	SSN uint16
+
+	// Unordered is true if the message was sent unordered.
+	Unordered bool
+
+	// PPID is the payload protocol identifier of the message.
+	PPID uint32
+
+	// TSN is the transmission sequence number of the last chunk of the
+	// message.
+	TSN uint32
+
+	// CumTSN is the cumulative TSN acknowledged by the association when
+	// the message was delivered.
+	CumTSN uint32
+}
+
+// SCTPSndInfo describes how to send a message on an SCTP association, as per
+// SCTP_SNDINFO.
+//
+// +stateify savable
+type SCTPSndInfo struct {
+	// Stream is the stream to send the message on.
+	Stream uint16
+
+	// Unordered is true if the message may be delivered out of order.
+	Unordered bool
+
+	// PPID is the payload protocol identifier of the message, which is
+	// opaque to SCTP.
+	PPID uint32
 }
 
 // PacketOwner is used to get UID and GID of the packet.
@@ -664,6 +752,66 @@ type PacketEndpoint interface {
 	ReadPacket(*FullAddress, *LinkPacketInfo) (buffer.View, ControlMessages, *Error)
 }
 
+// ReadMessage is a datagram read by BatchEndpoint.ReadBatch.
+type ReadMessage struct {
+	// Data is the payload of the datagram.
+	Data buffer.View
+
+	// Addr is the sender of the datagram.
+	Addr FullAddress
+
+	// Info holds the link information of the datagram, for packet
+	// endpoints.
+	Info LinkPacketInfo
+
+	// Control holds the control messages of the datagram.
+	Control ControlMessages
+}
+
+// WriteMessage is a datagram written by BatchEndpoint.WriteBatch.
+type WriteMessage struct {
+	// Payload is the payload of the datagram.
+	Payload Payloader
+
+	// Options are the options the datagram is written with.
+	Options WriteOptions
+}
+
+// BatchEndpoint are additional methods that are implemented by datagram
+// endpoints which read and write several datagrams at once, as for
+// recvmmsg(2) and sendmmsg(2). The endpoint is locked once for the whole batch
+// rather than once per datagram.
+type BatchEndpoint interface {
+	// ReadBatch reads up to len(msgs) datagrams into msgs, and returns the
+	// number of datagrams read.
+	//
+	// This method does not block if there is no data pending. It will also
+	// either return an error or datagrams, never both.
+	ReadBatch(msgs []ReadMessage) (int, *Error)
+
+	// WriteBatch writes the datagrams of msgs in order, and returns the
+	// number of datagrams written, each of which is written entirely. The
+	// error, if any, is that of the first datagram which wasn't written.
+	//
+	// As for Endpoint.Write, if address resolution is required for that
+	// datagram, ErrNoLinkAddress and a notification channel are returned
+	// for the caller to block before writing the remaining datagrams.
+	WriteBatch(msgs []WriteMessage) (int, <-chan struct{}, *Error)
+}
+
+// PacketRing is a ring of buffers shared with the application, into which a
+// packet endpoint delivers the packets it receives instead of queueing them to
+// be read, as per PACKET_RX_RING.
+type PacketRing interface {
+	// Deliver copies a packet received at timestampNS into the ring. data
+	// holds the packet data, addr the sender address and info additional
+	// link layer information, as returned by ReadPacket.
+	//
+	// Deliver returns false if the ring is full, in which case the packet
+	// is dropped.
+	Deliver(data buffer.VectorisedView, addr FullAddress, info LinkPacketInfo, timestampNS int64) bool
+}
+
 // EndpointInfo is the interface implemented by each endpoint info struct.
 type EndpointInfo interface {
 	// IsEndpointInfo is an empty method to implement the tcpip.EndpointInfo
@@ -694,6 +842,24 @@ type WriteOptions struct {
 	// endpoint. If Atomic is false, then data fetched from the Payloader may be
 	// discarded if available endpoint buffer space is unsufficient.
 	Atomic bool
+
+	// PathMTUProbe means that the datagram is a packetization layer path MTU
+	// probe, as per RFC 8899. Probes are sent unfragmented, even when they are
+	// larger than the known path MTU.
+	PathMTUProbe bool
+
+	// TxTime if not zero is the time at which the datagram must be
+	// transmitted, as per SO_TXTIME, in nanoseconds of the stack's monotonic
+	// clock.
+	TxTime int64
+
+	// TxTimeReported is the SCM_TXTIME value from which TxTime was
+	// converted. It is reported along with the errors of SO_TXTIME.
+	TxTimeReported uint64
+
+	// SCTPSndInfo if not nil overrides the SCTPDefaultSndInfoOption of an
+	// SCTP endpoint for the message written.
+	SCTPSndInfo *SCTPSndInfo
 }
 
 // SockOptInt represents socket options which values have the int type.
@@ -714,16 +880,22 @@ const (
 	// endpoint.
 	IPv6TrafficClassOption
 
+	// IPv6ChecksumOption is used by SetSockOptInt/GetSockOptInt to specify
+	// the offset of the checksum the stack computes in the packets sent by
+	// a raw IPv6 endpoint, and validates in the packets it receives, as per
+	// the IPV6_CHECKSUM socket option. A negative offset disables
+	// checksums.
+	IPv6ChecksumOption
+
 	// MaxSegOption is used by SetSockOptInt/GetSockOptInt to set/get the
 	// current Maximum Segment Size(MSS) value as specified using the
 	// TCP_MAXSEG option.
 	MaxSegOption
 
-	// MTUDiscoverOption is used to set/get the path MTU discovery setting.
-	//
-	// NOTE: Setting this option to any other value than PMTUDiscoveryDont
-	// is not supported and will fail as such, and getting this option will
-	// always return PMTUDiscoveryDont.
+	// MTUDiscoverOption is used by SetSockOptInt/GetSockOptInt to set/get the
+	// path MTU discovery setting, as per the IP_MTU_DISCOVER and
+	// IPV6_MTU_DISCOVER socket options. The setting is one of the
+	// PMTUDiscovery* values and defaults to PMTUDiscoveryDont.
 	MTUDiscoverOption
 
 	// MulticastTTLOption is used by SetSockOptInt/GetSockOptInt to control
@@ -765,24 +937,124 @@ const (
 	//
 	// NOTE: This option is currently only stubed out and is a no-op
 	TCPWindowClampOption
+
+	// PathMTUOption is used by GetSockOptInt to get the MTU of the path to a
+	// connected endpoint's peer, as per the IP_MTU and IPV6_MTU socket
+	// options. The MTU is the maximum size of a network-layer packet,
+	// including the network-layer header.
+	PathMTUOption
+
+	// MulticastRouterOption is used by SetSockOptInt/GetSockOptInt on a raw
+	// IGMP or ICMPv6 endpoint to make it the stack's IPv4 or IPv6 multicast
+	// router (1) or to stop being the multicast router (0), as per the
+	// MRT_INIT/MRT6_INIT and MRT_DONE/MRT6_DONE socket options. Only one
+	// endpoint may be the multicast router of a protocol at a time.
+	//
+	// The multicast router receives multicast routing upcalls, and is the only
+	// endpoint that may set the multicast routing options.
+	MulticastRouterOption
+
+	// TCPRepairOption is used by SetSockOptInt/GetSockOptInt to put a TCP
+	// endpoint in (TCPRepairOn) or out of (TCPRepairOff and
+	// TCPRepairOffNoWindowProbe) repair mode, as per TCP_REPAIR.
+	//
+	// An endpoint in repair mode sends no segments: connecting it moves it
+	// straight to the established state, writes and peeks operate on the
+	// queue selected by TCPRepairQueueOption, and closing it discards it
+	// without notifying the peer. It is used to checkpoint and restore
+	// established connections.
+	TCPRepairOption
+
+	// TCPRepairQueueOption is used by SetSockOptInt/GetSockOptInt to select
+	// the queue (TCPNoQueue, TCPRecvQueue or TCPSendQueue) operated on by
+	// TCPQueueSeqOption and by the writes and peeks of an endpoint in repair
+	// mode.
+	TCPRepairQueueOption
+
+	// TCPQueueSeqOption is used by SetSockOptInt/GetSockOptInt to set or get
+	// the sequence number of the queue selected by TCPRepairQueueOption: the
+	// next sequence number sent for the send queue, and the next sequence
+	// number expected for the receive queue. It may only be set on an
+	// endpoint in repair mode that is not connected yet.
+	TCPQueueSeqOption
+
+	// SCTPRecvRcvInfoOption is used by SetSockOptInt/GetSockOptInt to
+	// enable or disable the SCTPRcvInfo control message of the messages
+	// read from an SCTP endpoint, as per SCTP_RECVRCVINFO.
+	SCTPRecvRcvInfoOption
+
+	// UDPLiteSendCsCovOption is used by SetSockOptInt/GetSockOptInt to set
+	// or get the checksum coverage of the datagrams sent by a UDP-Lite
+	// endpoint, as per UDPLITE_SEND_CSCOV. The coverage includes the
+	// UDP-Lite header; 0 means that the checksum covers whole datagrams.
+	UDPLiteSendCsCovOption
+
+	// UDPLiteRecvCsCovOption is used by SetSockOptInt/GetSockOptInt to set
+	// or get the minimum checksum coverage of the datagrams received by a
+	// UDP-Lite endpoint, as per UDPLITE_RECV_CSCOV. Datagrams with a smaller
+	// coverage are dropped; 0 means that all coverages are accepted.
+	UDPLiteRecvCsCovOption
+)
+
+const (
+	// TCPRepairOffNoWindowProbe is a setting of the TCPRepairOption to leave
+	// repair mode without sending a window probe to the peer.
+	TCPRepairOffNoWindowProbe = -1
+
+	// TCPRepairOff is a setting of the TCPRepairOption to leave repair mode.
+	// A connected endpoint sends a window probe to the peer.
+	TCPRepairOff = 0
+
+	// TCPRepairOn is a setting of the TCPRepairOption to enter repair mode.
+	TCPRepairOn = 1
+)
+
+const (
+	// TCPNoQueue is a setting of the TCPRepairQueueOption selecting no
+	// queue.
+	TCPNoQueue = iota
+
+	// TCPRecvQueue is a setting of the TCPRepairQueueOption selecting the
+	// receive queue.
+	TCPRecvQueue
+
+	// TCPSendQueue is a setting of the TCPRepairQueueOption selecting the
+	// send queue.
+	TCPSendQueue
 )
 
 const (
 	// PMTUDiscoveryWant is a setting of the MTUDiscoverOption to use
-	// per-route settings.
+	// per-route settings: packets that fit in the path MTU are sent with the
+	// Don't Fragment flag set unless the route's MTU is locked, and larger
+	// packets are fragmented.
 	PMTUDiscoveryWant int = iota
 
 	// PMTUDiscoveryDont is a setting of the MTUDiscoverOption to disable
-	// path MTU discovery.
+	// path MTU discovery. Packets are sent without the Don't Fragment flag
+	// and are fragmented to fit the path MTU.
 	PMTUDiscoveryDont
 
 	// PMTUDiscoveryDo is a setting of the MTUDiscoverOption to always do
-	// path MTU discovery.
+	// path MTU discovery. Packets are sent with the Don't Fragment flag set
+	// and writes larger than the path MTU fail with ErrMessageTooLong.
 	PMTUDiscoveryDo
 
 	// PMTUDiscoveryProbe is a setting of the MTUDiscoverOption to set DF
-	// but ignore path MTU.
+	// but ignore path MTU. Writes larger than the MTU of the outgoing
+	// interface fail with ErrMessageTooLong.
 	PMTUDiscoveryProbe
+
+	// PMTUDiscoveryInterface is a setting of the MTUDiscoverOption to ignore
+	// the path MTU and never fragment. Packets are sent without the Don't
+	// Fragment flag and writes larger than the MTU of the outgoing interface
+	// fail with ErrMessageTooLong.
+	PMTUDiscoveryInterface
+
+	// PMTUDiscoveryOmit is a setting of the MTUDiscoverOption to ignore the
+	// path MTU. Packets are sent without the Don't Fragment flag and are
+	// fragmented to fit the MTU of the outgoing interface.
+	PMTUDiscoveryOmit
 )
 
 // GettableNetworkProtocolOption is a marker interface for network protocol
@@ -805,6 +1077,39 @@ func (*DefaultTTLOption) isGettableNetworkProtocolOption() {}
 
 func (*DefaultTTLOption) isSettableNetworkProtocolOption() {}
 
+// ReassemblyLimitsOption is used by stack.(*Stack).NetworkProtocolOption to
+// specify the resource limits of the IPv4 or IPv6 reassembly cache.
+type ReassemblyLimitsOption struct {
+	// HighMemoryLimit is the limit on the memory consumed by the fragments
+	// being reassembled. The oldest reassemblies are discarded when the limit
+	// is exceeded.
+	HighMemoryLimit int
+
+	// LowMemoryLimit is the memory consumption reassemblies are discarded down
+	// to once HighMemoryLimit is exceeded.
+	LowMemoryLimit int
+
+	// Timeout is the maximum time allowed to reassemble a packet.
+	Timeout time.Duration
+
+	// MaxReassembliesPerSource is the maximum number of packets from a single
+	// source address that may be reassembled concurrently, or 0 for no limit.
+	MaxReassembliesPerSource int
+}
+
+func (*ReassemblyLimitsOption) isGettableNetworkProtocolOption() {}
+
+func (*ReassemblyLimitsOption) isSettableNetworkProtocolOption() {}
+
+// NonLocalBindOption is used by stack.(*Stack).NetworkProtocolOption to
+// specify whether sockets may bind to addresses of the protocol which aren't
+// assigned to any NIC, as with Linux's ip_nonlocal_bind sysctl.
+type NonLocalBindOption bool
+
+func (*NonLocalBindOption) isGettableNetworkProtocolOption() {}
+
+func (*NonLocalBindOption) isSettableNetworkProtocolOption() {}
+
 // GettableTransportProtocolOption is a marker interface for transport protocol
 // options that may be queried.
 type GettableTransportProtocolOption interface {
@@ -913,12 +1218,181 @@ func (*BindToDeviceOption) isGettableSocketOption() {}
 
 func (*BindToDeviceOption) isSettableSocketOption() {}
 
-// TCPInfoOption is used by GetSockOpt to expose TCP statistics.
-//
-// TODO(b/64800844): Add and populate stat fields.
+// TCPCongestionControlState indicates the congestion control state of a TCP
+// sender.
+type TCPCongestionControlState int
+
+const (
+	// TCPStateOpen indicates that the sender is receiving ACKs in order and
+	// no loss or dupACKs have been detected.
+	TCPStateOpen TCPCongestionControlState = iota
+
+	// TCPStateDisorder indicates that the sender received some SACK blocks
+	// or dupACKs.
+	TCPStateDisorder
+
+	// TCPStateRecovery indicates that the sender is in fast or SACK based
+	// recovery.
+	TCPStateRecovery
+
+	// TCPStateLoss indicates that the sender is recovering from a
+	// retransmission timeout.
+	TCPStateLoss
+
+	// TCPStateCWR indicates that the sender reduced its congestion window
+	// in response to an ECN congestion signal.
+	TCPStateCWR
+)
+
+// TCPInfoOption is used by GetSockOpt to expose TCP statistics, mirroring
+// Linux's struct tcp_info. Packet counts are in units of segments of at most
+// SndMSS bytes.
 type TCPInfoOption struct {
-	RTT    time.Duration
+	// RTT is the smoothed round trip time.
+	RTT time.Duration
+
+	// RTTVar is the round trip time variation.
 	RTTVar time.Duration
+
+	// RTO is the retransmission timeout.
+	RTO time.Duration
+
+	// MinRTT is the minimum round trip time observed on the connection.
+	MinRTT time.Duration
+
+	// RcvRTT is the round trip time estimated by the receiver.
+	RcvRTT time.Duration
+
+	// CcState is the congestion control state of the sender.
+	CcState TCPCongestionControlState
+
+	// Retransmits is the number of consecutive retransmission timeouts
+	// since data was last acknowledged.
+	Retransmits uint8
+
+	// Probes is the number of unacknowledged zero window or keepalive
+	// probes.
+	Probes uint8
+
+	// Timestamps, SACK and WindowScale are set if the corresponding options
+	// were negotiated.
+	Timestamps  bool
+	SACK        bool
+	WindowScale bool
+
+	// ECN is set if ECN was negotiated. ECNSeen is set if a segment with an
+	// ECN codepoint other than Not-ECT was received.
+	ECN     bool
+	ECNSeen bool
+
+	// SndWndScale and RcvWndScale are the window scales of the peer and of
+	// the endpoint.
+	SndWndScale uint8
+	RcvWndScale uint8
+
+	// SndMSS and RcvMSS are the maximum segment sizes used for sending and
+	// advertised to the peer.
+	SndMSS uint32
+	RcvMSS uint32
+
+	// Unacked, Sacked, Lost and Retrans are the number of packets sent but
+	// not cumulatively acknowledged, selectively acknowledged, deemed lost
+	// and retransmitted but not yet acknowledged.
+	Unacked uint32
+	Sacked  uint32
+	Lost    uint32
+	Retrans uint32
+
+	// LastDataSent, LastDataRecv and LastAckRecv are the time elapsed since
+	// data was last sent, data was last received and an ACK was last
+	// received.
+	LastDataSent time.Duration
+	LastDataRecv time.Duration
+	LastAckRecv  time.Duration
+
+	// PMTU is the path MTU.
+	PMTU uint32
+
+	// SndSsthresh is the slow start threshold, in packets.
+	SndSsthresh uint32
+
+	// SndCwnd is the congestion window, in packets.
+	SndCwnd uint32
+
+	// SndWnd is the send window advertised by the peer, in bytes.
+	SndWnd uint32
+
+	// RcvWnd is the receive window advertised to the peer, in bytes.
+	RcvWnd uint32
+
+	// RcvSpace is the amount of data the application copied out of the
+	// receive buffer during the last receiver round trip time, as used for
+	// receive buffer auto-tuning.
+	RcvSpace uint32
+
+	// Reordering is the largest distance, in segments, by which the sender
+	// saw segments delivered out of order. It is at least the duplicate ACK
+	// threshold.
+	Reordering uint32
+
+	// TotalRetrans is the number of segments retransmitted on the
+	// connection.
+	TotalRetrans uint32
+
+	// PacingRate is the pacing rate of the sender, in bytes per second. It
+	// is zero if the sender is not paced.
+	PacingRate uint64
+
+	// BytesSent, BytesRetrans, BytesAcked and BytesReceived are the number
+	// of bytes sent (including retransmissions), retransmitted,
+	// cumulatively acknowledged by the peer and received in order.
+	BytesSent     uint64
+	BytesRetrans  uint64
+	BytesAcked    uint64
+	BytesReceived uint64
+
+	// SegsOut and SegsIn are the number of segments sent and received.
+	SegsOut uint32
+	SegsIn  uint32
+
+	// DataSegsOut and DataSegsIn are the number of segments carrying data
+	// sent and received.
+	DataSegsOut uint32
+	DataSegsIn  uint32
+
+	// NotSentBytes is the number of bytes in the send buffer that have not
+	// been sent yet.
+	NotSentBytes uint32
+
+	// DeliveryRate is the most recent delivery rate estimate, in bytes per
+	// second.
+	DeliveryRate uint64
+
+	// DeliveryRateAppLimited is set if DeliveryRate was sampled while the
+	// sender was application-limited.
+	DeliveryRateAppLimited bool
+
+	// BusyTime is the time spent with data in flight or queued for sending.
+	// RwndLimited and SndBufLimited are the parts of BusyTime spent limited
+	// by the receive window and by the send buffer.
+	BusyTime      time.Duration
+	RwndLimited   time.Duration
+	SndBufLimited time.Duration
+
+	// Delivered is the number of packets delivered, including
+	// retransmissions. DeliveredCE is the number of those that were
+	// marked with ECN CE.
+	Delivered   uint32
+	DeliveredCE uint32
+
+	// DSACKDups is the number of D-SACK blocks received.
+	DSACKDups uint32
+
+	// ReordSeen is the number of times reordering was detected.
+	ReordSeen uint32
+
+	// RcvOOOPack is the number of out-of-order packets received.
+	RcvOOOPack uint32
 }
 
 func (*TCPInfoOption) isGettableSocketOption() {}
@@ -991,12 +1465,191 @@ func (*TCPTimeWaitTimeoutOption) isSettableTransportProtocolOption() {}
 // accept to return a completed connection only when there is data to be
 // read. This usually means the listening socket will drop the final ACK
 // for a handshake till the specified timeout until a segment with data arrives.
+// As in Linux, the timeout is rounded up to the next retransmission of the
+// SYN-ACK.
 type TCPDeferAcceptOption time.Duration
 
 func (*TCPDeferAcceptOption) isGettableSocketOption() {}
 
 func (*TCPDeferAcceptOption) isSettableSocketOption() {}
 
+// TCPMD5SigOption is used by SetSockOpt to set or remove the TCP MD5 signature
+// key used with the peers matching a prefix, as per RFC 2385.
+type TCPMD5SigOption struct {
+	// Addr is the address of the peers.
+	Addr Address
+
+	// PrefixLen is the number of leading bits of Addr matched against the
+	// addresses of the peers.
+	PrefixLen int
+
+	// NIC restricts the key to the peers reached through a NIC, if
+	// non-zero.
+	NIC NICID
+
+	// Key is the key, or empty to remove the key of the prefix.
+	Key []byte
+}
+
+func (*TCPMD5SigOption) isSettableSocketOption() {}
+
+// TCPAOAddKeyOption is used by SetSockOpt to add or replace a TCP
+// Authentication Option master key tuple used with the peers matching a
+// prefix, as per RFC 5925.
+type TCPAOAddKeyOption struct {
+	// Addr is the address of the peers.
+	Addr Address
+
+	// PrefixLen is the number of leading bits of Addr matched against the
+	// addresses of the peers.
+	PrefixLen int
+
+	// NIC restricts the key to the peers reached through a NIC, if
+	// non-zero.
+	NIC NICID
+
+	// Algorithm is the name of the MAC algorithm, as used by Linux (e.g.
+	// "hmac(sha1)").
+	Algorithm string
+
+	// SendID is the KeyID of the segments sent with the key.
+	SendID uint8
+
+	// RecvID is the KeyID of the segments received with the key.
+	RecvID uint8
+
+	// MACLength is the length of the MACs sent with the key, or zero for
+	// the default length of the algorithm.
+	MACLength int
+
+	// Key is the master key.
+	Key []byte
+
+	// SetCurrent makes the key the one segments are sent with.
+	SetCurrent bool
+
+	// SetRNext makes the key the one the peers are asked to send
+	// segments with.
+	SetRNext bool
+}
+
+func (*TCPAOAddKeyOption) isSettableSocketOption() {}
+
+// TCPAODelKeyOption is used by SetSockOpt to remove a TCP Authentication
+// Option master key tuple.
+type TCPAODelKeyOption struct {
+	// Addr, PrefixLen, NIC, SendID and RecvID identify the key, as in
+	// TCPAOAddKeyOption.
+	Addr      Address
+	PrefixLen int
+	NIC       NICID
+	SendID    uint8
+	RecvID    uint8
+
+	// SetCurrent and Current, if set, are the SendID of the key segments
+	// are sent with once the key is removed.
+	SetCurrent bool
+	Current    uint8
+
+	// SetRNext and RNext, if set, are the RecvID of the key the peers
+	// are asked to send segments with once the key is removed.
+	SetRNext bool
+	RNext    uint8
+}
+
+func (*TCPAODelKeyOption) isSettableSocketOption() {}
+
+// TCPAOInfoOption is used by SetSockOpt/GetSockOpt to set or get the TCP
+// Authentication Option state of an endpoint.
+type TCPAOInfoOption struct {
+	// SetCurrent and Current, if set, are the SendID of the key segments
+	// are sent with.
+	SetCurrent bool
+	Current    uint8
+
+	// SetRNext and RNext, if set, are the RecvID of the key the peers are
+	// asked to send segments with.
+	SetRNext bool
+	RNext    uint8
+
+	// Required is true if segments without a TCP Authentication Option
+	// are dropped, even when no key is used with their sender.
+	Required bool
+
+	// SetCounters sets the counters below. They are always returned by
+	// GetSockOpt.
+	SetCounters bool
+
+	// Good is the number of segments with a valid MAC.
+	Good uint64
+
+	// Bad is the number of segments dropped because of an invalid MAC.
+	Bad uint64
+
+	// KeyNotFound is the number of segments dropped because no key
+	// matched their KeyID.
+	KeyNotFound uint64
+
+	// AORequired is the number of segments dropped because they lacked
+	// a TCP Authentication Option.
+	AORequired uint64
+}
+
+func (*TCPAOInfoOption) isGettableSocketOption() {}
+
+func (*TCPAOInfoOption) isSettableSocketOption() {}
+
+// TCPRepairWindowOption is used by SetSockOpt/GetSockOpt to save and restore
+// the window state of a connected TCP endpoint in repair mode, as per
+// TCP_REPAIR_WINDOW.
+type TCPRepairWindowOption struct {
+	// SndWl1 is the sequence number of the segment that last updated the
+	// send window.
+	SndWl1 uint32
+
+	// SndWnd is the send window.
+	SndWnd uint32
+
+	// MaxWindow is the largest send window advertised by the peer.
+	MaxWindow uint32
+
+	// RcvWnd is the receive window advertised at RcvWup.
+	RcvWnd uint32
+
+	// RcvWup is the next sequence number expected when the receive window
+	// was last advertised.
+	RcvWup uint32
+}
+
+func (*TCPRepairWindowOption) isGettableSocketOption() {}
+
+func (*TCPRepairWindowOption) isSettableSocketOption() {}
+
+// TCPRepairOptionsOption is used by SetSockOpt to restore the options
+// negotiated by the handshake of a connected TCP endpoint in repair mode
+// which hasn't sent any data yet, as per TCP_REPAIR_OPTIONS.
+//
+// The options which aren't set are left unchanged.
+type TCPRepairOptionsOption struct {
+	// MSS, if non-zero, is the maximum segment size advertised by the
+	// peer.
+	MSS uint16
+
+	// WindowScale, if set, enables window scaling with the shift counts
+	// SndWndScale and RcvWndScale.
+	WindowScale bool
+	SndWndScale uint8
+	RcvWndScale uint8
+
+	// SACKPermitted, if set, enables SACK.
+	SACKPermitted bool
+
+	// Timestamp, if set, enables timestamps.
+	Timestamp bool
+}
+
+func (*TCPRepairOptionsOption) isSettableSocketOption() {}
+
 // TCPMinRTOOption is use by SetSockOpt/GetSockOpt to allow overriding
 // default MinRTO used by the Stack.
 type TCPMinRTOOption time.Duration
@@ -1036,6 +1689,11 @@ func (*TCPMaxRetriesOption) isSettableTransportProtocolOption() {}
 // TCPSynRcvdCountThresholdOption is used by SetSockOpt/GetSockOpt to specify
 // the number of endpoints that can be in SYN-RCVD state before the stack
 // switches to using SYN cookies.
+//
+// As a transport protocol option, it bounds the number of endpoints in
+// SYN-RCVD state across the stack. As a socket option, it additionally bounds
+// the number of endpoints in SYN-RCVD state for a listening endpoint; zero
+// means no bound other than the listen backlog.
 type TCPSynRcvdCountThresholdOption uint64
 
 func (*TCPSynRcvdCountThresholdOption) isGettableSocketOption() {}
@@ -1046,6 +1704,189 @@ func (*TCPSynRcvdCountThresholdOption) isGettableTransportProtocolOption() {}
 
 func (*TCPSynRcvdCountThresholdOption) isSettableTransportProtocolOption() {}
 
+// TCPSynCookiesOption is used by SetSockOpt/GetSockOpt and
+// stack.(*Stack).SetTransportProtocolOption to specify when SYN cookies are
+// used to accept connections. The transport protocol option applies to
+// listening endpoints which don't set their own.
+type TCPSynCookiesOption uint8
+
+func (*TCPSynCookiesOption) isGettableSocketOption() {}
+
+func (*TCPSynCookiesOption) isSettableSocketOption() {}
+
+func (*TCPSynCookiesOption) isGettableTransportProtocolOption() {}
+
+func (*TCPSynCookiesOption) isSettableTransportProtocolOption() {}
+
+const (
+	// TCPSynCookiesDefault indicates that a listening endpoint follows the
+	// transport protocol option. It is only valid as a socket option.
+	TCPSynCookiesDefault TCPSynCookiesOption = iota
+
+	// TCPSynCookiesOnOverflow indicates that SYN cookies are used once the
+	// number of endpoints in SYN-RCVD state reaches its threshold. See
+	// TCPSynRcvdCountThresholdOption.
+	TCPSynCookiesOnOverflow
+
+	// TCPSynCookiesAlways indicates that SYN cookies are used to accept all
+	// connections.
+	TCPSynCookiesAlways
+
+	// TCPSynCookiesOff indicates that SYN cookies are never used. SYNs
+	// received once the number of endpoints in SYN-RCVD state reaches its
+	// threshold are dropped.
+	TCPSynCookiesOff
+)
+
+// TCPECNOption is used by SetSockOpt/GetSockOpt and
+// stack.(*Stack).SetTransportProtocolOption to specify whether Explicit
+// Congestion Notification is negotiated for TCP connections. The transport
+// protocol option applies to endpoints which don't set their own.
+type TCPECNOption uint8
+
+func (*TCPECNOption) isGettableSocketOption() {}
+
+func (*TCPECNOption) isSettableSocketOption() {}
+
+func (*TCPECNOption) isGettableTransportProtocolOption() {}
+
+func (*TCPECNOption) isSettableTransportProtocolOption() {}
+
+const (
+	// TCPECNDefault indicates that an endpoint follows the transport
+	// protocol option. It is only valid as a socket option.
+	TCPECNDefault TCPECNOption = iota
+
+	// TCPECNDisabled indicates that ECN is never negotiated.
+	TCPECNDisabled
+
+	// TCPECNPassive indicates that ECN is negotiated when requested by the
+	// peer, but not requested for outgoing connections.
+	TCPECNPassive
+
+	// TCPECNEnabled indicates that ECN, as per RFC 3168, is requested for
+	// outgoing connections and negotiated when requested by the peer.
+	TCPECNEnabled
+
+	// TCPECNAccurate indicates that Accurate ECN, as per RFC 9768, is
+	// requested for outgoing connections, and Accurate ECN or ECN is
+	// negotiated when requested by the peer.
+	TCPECNAccurate
+)
+
+// TCPAutocorkOption is used by stack.(*Stack).SetTransportProtocolOption to
+// enable or disable TCP auto-corking. When enabled, a small segment is held
+// back while the connection has data in flight and data queued below the
+// endpoint, so that following writes can be coalesced into it.
+type TCPAutocorkOption bool
+
+func (*TCPAutocorkOption) isGettableTransportProtocolOption() {}
+
+func (*TCPAutocorkOption) isSettableTransportProtocolOption() {}
+
+// TCPLimitOutputBytesOption is used by
+// stack.(*Stack).SetTransportProtocolOption to specify the maximum number of
+// bytes a TCP connection may have queued below the endpoint, e.g. in a
+// queueing discipline, before it stops sending (TCP small queues).
+type TCPLimitOutputBytesOption int
+
+func (*TCPLimitOutputBytesOption) isGettableTransportProtocolOption() {}
+
+func (*TCPLimitOutputBytesOption) isSettableTransportProtocolOption() {}
+
+// TCPNotSentLowatOption is used by SetSockOpt/GetSockOpt and
+// stack.(*Stack).SetTransportProtocolOption to specify the TCP_NOTSENT_LOWAT
+// threshold: a TCP endpoint isn't writable while it has at least that many
+// bytes of data written but not yet sent. A zero socket option value selects
+// the stack-wide threshold.
+type TCPNotSentLowatOption uint32
+
+func (*TCPNotSentLowatOption) isGettableSocketOption() {}
+
+func (*TCPNotSentLowatOption) isSettableSocketOption() {}
+
+func (*TCPNotSentLowatOption) isGettableTransportProtocolOption() {}
+
+func (*TCPNotSentLowatOption) isSettableTransportProtocolOption() {}
+
+// TCPDelayedAckTimeoutOption is used by
+// stack.(*Stack).SetTransportProtocolOption to specify the maximum time the
+// acknowledgment of received data is delayed by TCP connections which have
+// TCP_QUICKACK disabled. A zero value disables delayed acknowledgments.
+type TCPDelayedAckTimeoutOption time.Duration
+
+func (*TCPDelayedAckTimeoutOption) isGettableTransportProtocolOption() {}
+
+func (*TCPDelayedAckTimeoutOption) isSettableTransportProtocolOption() {}
+
+// TCPDelayedAckSegmentsOption is used by
+// stack.(*Stack).SetTransportProtocolOption to specify the number of
+// unacknowledged data segments after which a TCP connection sends an
+// acknowledgment without delay.
+type TCPDelayedAckSegmentsOption int
+
+func (*TCPDelayedAckSegmentsOption) isGettableTransportProtocolOption() {}
+
+func (*TCPDelayedAckSegmentsOption) isSettableTransportProtocolOption() {}
+
+// TCPOutOfOrderLimitOption is used by
+// stack.(*Stack).SetTransportProtocolOption to specify the maximum number of
+// bytes, including the segment overhead, a TCP connection may hold in its
+// out-of-order queue. The queue is also bounded by a quarter of the receive
+// buffer of the connection.
+type TCPOutOfOrderLimitOption int
+
+func (*TCPOutOfOrderLimitOption) isGettableTransportProtocolOption() {}
+
+func (*TCPOutOfOrderLimitOption) isSettableTransportProtocolOption() {}
+
+// TCPMetrics is the cached metrics of the TCP connections from a local address
+// to a remote address.
+type TCPMetrics struct {
+	// LocalAddress and RemoteAddress are the addresses of the connections.
+	LocalAddress  Address
+	RemoteAddress Address
+
+	// Age is the time since the metrics were last updated.
+	Age time.Duration
+
+	// RTT and RTTVar are the smoothed round-trip time and its variation, or
+	// zero if unknown.
+	RTT    time.Duration
+	RTTVar time.Duration
+
+	// Ssthresh is the slow start threshold, in segments, or zero if
+	// unknown.
+	Ssthresh int
+
+	// Cwnd is the congestion window, in segments, or zero if unknown.
+	Cwnd int
+}
+
+// TCPMetricsOption is used by stack.(*Stack).TransportProtocolOption to dump
+// the per-destination TCP metrics cache, which remembers the metrics of closed
+// TCP connections for the following connections to the same destinations, as
+// per Linux's tcp_metrics.
+type TCPMetricsOption []TCPMetrics
+
+func (*TCPMetricsOption) isGettableTransportProtocolOption() {}
+
+// TCPMetricsFlushOption is used by stack.(*Stack).SetTransportProtocolOption
+// to discard the TCP metrics cached for a remote address, or for all
+// addresses if empty.
+type TCPMetricsFlushOption Address
+
+func (*TCPMetricsFlushOption) isSettableTransportProtocolOption() {}
+
+// TCPMetricsSaveOption is used by stack.(*Stack).SetTransportProtocolOption
+// to enable or disable caching the metrics of the TCP connections that are
+// closed. It is the opposite of Linux's net.ipv4.tcp_no_metrics_save.
+type TCPMetricsSaveOption bool
+
+func (*TCPMetricsSaveOption) isGettableTransportProtocolOption() {}
+
+func (*TCPMetricsSaveOption) isSettableTransportProtocolOption() {}
+
 // TCPSynRetriesOption is used by SetSockOpt/GetSockOpt to specify stack-wide
 // default for number of times SYN is retransmitted before aborting a connect.
 type TCPSynRetriesOption uint8
@@ -1058,6 +1899,176 @@ func (*TCPSynRetriesOption) isGettableTransportProtocolOption() {}
 
 func (*TCPSynRetriesOption) isSettableTransportProtocolOption() {}
 
+// TCPTunablesOption is used by stack.(*Stack).TransportProtocolOption and
+// stack.(*Stack).SetTransportProtocolOption to get and set at once the
+// stack-wide TCP tunables equivalent to Linux's net.ipv4.tcp_* sysctls. The
+// new values apply to the connections established afterwards.
+type TCPTunablesOption struct {
+	// SynRetries is tcp_syn_retries, see TCPSynRetriesOption. It must be
+	// at least 1.
+	SynRetries uint8
+
+	// MaxRetries is tcp_retries2, see TCPMaxRetriesOption.
+	MaxRetries uint64
+
+	// FinTimeout is tcp_fin_timeout, see TCPLingerTimeoutOption.
+	FinTimeout time.Duration
+
+	// ReceiveBufferSize is tcp_rmem, the bounds of the receive buffer
+	// autotuning, see TCPReceiveBufferSizeRangeOption.
+	ReceiveBufferSize TCPReceiveBufferSizeRangeOption
+
+	// SendBufferSize is tcp_wmem, see TCPSendBufferSizeRangeOption.
+	SendBufferSize TCPSendBufferSizeRangeOption
+
+	// ModerateReceiveBuffer is tcp_moderate_rcvbuf, see
+	// TCPModerateReceiveBufferOption.
+	ModerateReceiveBuffer bool
+
+	// SlowStartAfterIdle is tcp_slow_start_after_idle. If set, the
+	// congestion window is reduced to the initial window when a connection
+	// resumes sending after being idle for a retransmission timeout, as per
+	// RFC 5681 section 4.1.
+	SlowStartAfterIdle bool
+
+	// MTUProbing is tcp_mtu_probing set to 1. If set, the MSS of a
+	// connection is reduced after repeated retransmission timeouts, in
+	// case its full sized segments are dropped by a path MTU black hole.
+	MTUProbing bool
+}
+
+func (*TCPTunablesOption) isGettableTransportProtocolOption() {}
+
+func (*TCPTunablesOption) isSettableTransportProtocolOption() {}
+
+// SCTPInitMsgOption is used by SetSockOpt/GetSockOpt to specify the
+// parameters of the associations initiated by an SCTP endpoint, as per
+// SCTP_INITMSG. Zero values leave the current parameters unchanged when set.
+type SCTPInitMsgOption struct {
+	// OutboundStreams is the number of streams requested to send on.
+	OutboundStreams uint16
+
+	// MaxInboundStreams is the maximum number of streams to receive on.
+	MaxInboundStreams uint16
+
+	// MaxAttempts is the maximum number of times the INIT chunk is sent.
+	MaxAttempts uint16
+
+	// MaxInitTimeout is the maximum retransmission timeout of the INIT
+	// chunk.
+	MaxInitTimeout time.Duration
+}
+
+func (*SCTPInitMsgOption) isGettableSocketOption() {}
+
+func (*SCTPInitMsgOption) isSettableSocketOption() {}
+
+// SCTPRTOInfoOption is used by SetSockOpt/GetSockOpt to specify the
+// retransmission timeout bounds of an SCTP endpoint, as per SCTP_RTOINFO. Zero
+// values leave the current bounds unchanged when set.
+type SCTPRTOInfoOption struct {
+	// Initial is the retransmission timeout used before a round-trip
+	// time is measured.
+	Initial time.Duration
+
+	// Min is the minimum retransmission timeout.
+	Min time.Duration
+
+	// Max is the maximum retransmission timeout.
+	Max time.Duration
+}
+
+func (*SCTPRTOInfoOption) isGettableSocketOption() {}
+
+func (*SCTPRTOInfoOption) isSettableSocketOption() {}
+
+// SCTPDefaultSndInfoOption is used by SetSockOpt/GetSockOpt to specify how the
+// messages written to an SCTP endpoint are sent when the write doesn't
+// specify it, as per SCTP_DEFAULT_SNDINFO.
+type SCTPDefaultSndInfoOption SCTPSndInfo
+
+func (*SCTPDefaultSndInfoOption) isGettableSocketOption() {}
+
+func (*SCTPDefaultSndInfoOption) isSettableSocketOption() {}
+
+// SCTPPathInfo describes a path to a peer address of an SCTP association.
+type SCTPPathInfo struct {
+	// Address is the peer address of the path.
+	Address FullAddress
+
+	// Active is true if the peer address is reachable.
+	Active bool
+
+	// Confirmed is true if the peer address was confirmed by a heartbeat.
+	Confirmed bool
+
+	// Cwnd is the congestion window of the path, in bytes.
+	Cwnd uint32
+
+	// SRTT is the smoothed round-trip time of the path.
+	SRTT time.Duration
+
+	// RTO is the retransmission timeout of the path.
+	RTO time.Duration
+
+	// MTU is the path MTU, including the network headers.
+	MTU uint32
+}
+
+// SCTPStatusOption is used by GetSockOpt to get the status of the association
+// of an SCTP endpoint, as per SCTP_STATUS.
+type SCTPStatusOption struct {
+	// State is the state of the endpoint.
+	State uint32
+
+	// ReceiveWindow is the receive window advertised by the peer.
+	ReceiveWindow uint32
+
+	// UnackedData is the number of DATA chunks sent but not acknowledged.
+	UnackedData uint16
+
+	// PendingData is the number of DATA chunks queued but not sent.
+	PendingData uint16
+
+	// InboundStreams is the number of streams the peer may send on.
+	InboundStreams uint16
+
+	// OutboundStreams is the number of streams the endpoint may send on.
+	OutboundStreams uint16
+
+	// FragmentationPoint is the size above which messages are fragmented.
+	FragmentationPoint uint32
+
+	// Primary describes the primary path of the association.
+	Primary SCTPPathInfo
+
+	// Paths describes all the paths of the association, including the
+	// primary path.
+	Paths []SCTPPathInfo
+}
+
+func (*SCTPStatusOption) isGettableSocketOption() {}
+
+// ICMPv6FilterOption is used by SetSockOpt/GetSockOpt to specify the types of
+// the ICMPv6 messages which are not delivered to an ICMPv6 endpoint, as per
+// ICMP6_FILTER.
+//
+// +stateify savable
+type ICMPv6FilterOption struct {
+	// DenyType is a bitmap of the ICMPv6 types which are filtered out:
+	// type t is filtered out if bit t%32 of DenyType[t/32] is set.
+	DenyType [8]uint32
+}
+
+// ShouldDeny returns true if ICMPv6 messages of type t are filtered out.
+func (f *ICMPv6FilterOption) ShouldDeny(t uint8) bool {
+	return f.DenyType[t/32]&(1<<(t%32)) != 0
+}
+
+func (*ICMPv6FilterOption) isGettableSocketOption() {}
+
+func (*ICMPv6FilterOption) isSettableSocketOption() {}
+
 // MulticastInterfaceOption is used by SetSockOpt/GetSockOpt to specify a
 // default interface for multicast.
 type MulticastInterfaceOption struct {
@@ -1087,6 +2098,210 @@ type RemoveMembershipOption MembershipOption
 
 func (*RemoveMembershipOption) isSettableSocketOption() {}
 
+// SourceMembershipOption is used to identify a source-specific multicast
+// membership on an interface.
+type SourceMembershipOption struct {
+	NIC           NICID
+	InterfaceAddr Address
+	MulticastAddr Address
+	SourceAddr    Address
+}
+
+// AddSourceMembershipOption identifies a multicast group to join on some
+// interface, only receiving traffic sent by the specified source.
+type AddSourceMembershipOption SourceMembershipOption
+
+func (*AddSourceMembershipOption) isSettableSocketOption() {}
+
+// RemoveSourceMembershipOption identifies a source-specific multicast
+// membership to leave on some interface.
+type RemoveSourceMembershipOption SourceMembershipOption
+
+func (*RemoveSourceMembershipOption) isSettableSocketOption() {}
+
+// AddMulticastRoutingInterfaceOption is used by SetSockOpt on a multicast
+// router endpoint to enable multicast forwarding on a NIC and refer to it by a
+// multicast interface index, as per the MRT_ADD_VIF and MRT6_ADD_MIF socket
+// options.
+type AddMulticastRoutingInterfaceOption struct {
+	// Index is the multicast interface index.
+	Index uint16
+
+	// NIC is the NIC the multicast interface refers to.
+	NIC NICID
+
+	// InterfaceAddr is a local address of the NIC the multicast interface
+	// refers to. It is only used if NIC is zero.
+	InterfaceAddr Address
+
+	// Threshold is the minimum TTL/hop limit packets must have to be
+	// forwarded out of the multicast interface.
+	Threshold uint8
+}
+
+func (*AddMulticastRoutingInterfaceOption) isSettableSocketOption() {}
+
+// RemoveMulticastRoutingInterfaceOption is used by SetSockOpt on a multicast
+// router endpoint to remove a multicast interface and disable multicast
+// forwarding on its NIC, as per the MRT_DEL_VIF and MRT6_DEL_MIF socket
+// options.
+type RemoveMulticastRoutingInterfaceOption struct {
+	// Index is the multicast interface index.
+	Index uint16
+}
+
+func (*RemoveMulticastRoutingInterfaceOption) isSettableSocketOption() {}
+
+// AddMulticastRouteOption is used by SetSockOpt on a multicast router endpoint
+// to install a multicast forwarding cache entry, as per the MRT_ADD_MFC and
+// MRT6_ADD_MFC socket options.
+//
+// Interfaces are identified by their multicast interface indices. Outgoing
+// interfaces that do not exist are ignored.
+type AddMulticastRouteOption struct {
+	// Source is the unicast source address of the packets to forward.
+	Source Address
+
+	// Group is the multicast destination address of the packets to forward.
+	Group Address
+
+	// InputInterface is the interface packets are expected to arrive on.
+	InputInterface uint16
+
+	// OutputInterfaces are the interfaces packets are forwarded out of.
+	OutputInterfaces []uint16
+}
+
+func (*AddMulticastRouteOption) isSettableSocketOption() {}
+
+// RemoveMulticastRouteOption is used by SetSockOpt on a multicast router
+// endpoint to remove a multicast forwarding cache entry, as per the
+// MRT_DEL_MFC and MRT6_DEL_MFC socket options.
+type RemoveMulticastRouteOption struct {
+	// Source is the unicast source address of the forwarded packets.
+	Source Address
+
+	// Group is the multicast destination address of the forwarded packets.
+	Group Address
+}
+
+func (*RemoveMulticastRouteOption) isSettableSocketOption() {}
+
+// FlowLabelAction is an action on a leased IPv6 flow label.
+type FlowLabelAction int
+
+const (
+	// FlowLabelLease leases a flow label, creating it if requested.
+	FlowLabelLease FlowLabelAction = iota
+
+	// FlowLabelRelease releases a leased flow label.
+	FlowLabelRelease
+
+	// FlowLabelRenew renews a leased flow label.
+	FlowLabelRenew
+)
+
+// FlowLabelShare is the sharing mode of a leased IPv6 flow label.
+type FlowLabelShare int
+
+const (
+	// FlowLabelShareExclusive labels may only be leased by the endpoint that
+	// created them.
+	FlowLabelShareExclusive FlowLabelShare = iota + 1
+
+	// FlowLabelShareProcess labels may be leased by endpoints of the same
+	// process.
+	FlowLabelShareProcess
+
+	// FlowLabelShareUser labels may be leased by endpoints of the same user.
+	FlowLabelShareUser
+
+	// FlowLabelShareAny labels may be leased by any endpoint.
+	FlowLabelShareAny
+)
+
+// FlowLabelManagerOption is used by SetSockOpt to lease, renew or release an
+// IPv6 flow label, as per the IPV6_FLOWLABEL_MGR socket option.
+//
+// Endpoints may only send packets with flow labels they have leased.
+type FlowLabelManagerOption struct {
+	// Label is the flow label.
+	Label uint32
+
+	// Action is the action to perform on Label.
+	Action FlowLabelAction
+
+	// Share is the sharing mode of Label when it is created.
+	Share FlowLabelShare
+
+	// Owner identifies the process (for FlowLabelShareProcess) or the user
+	// (for FlowLabelShareUser) leasing Label.
+	Owner uint64
+
+	// Create indicates that Label is created if it does not exist.
+	Create bool
+
+	// Exclusive indicates that Label must not already exist.
+	Exclusive bool
+}
+
+func (*FlowLabelManagerOption) isSettableSocketOption() {}
+
+// PLPMTUDState is the state of a datagram packetization layer path MTU
+// discovery (DPLPMTUD) search, as per RFC 8899 section 5.2.
+type PLPMTUDState int
+
+const (
+	// PLPMTUDBase indicates that the base PLPMTU is being confirmed.
+	PLPMTUDBase PLPMTUDState = iota
+
+	// PLPMTUDSearching indicates that larger PLPMTUs are being probed.
+	PLPMTUDSearching
+
+	// PLPMTUDSearchComplete indicates that the search has found the largest
+	// PLPMTU the path supports. The search is restarted after the raise timer
+	// expires.
+	PLPMTUDSearchComplete
+
+	// PLPMTUDError indicates that the path does not support the base PLPMTU.
+	PLPMTUDError
+)
+
+// PLPMTUDInfo is used by GetSockOpt to get the state of the datagram
+// packetization layer path MTU discovery (DPLPMTUD) performed for a connected
+// endpoint, as per RFC 8899.
+//
+// Getting the option starts the search if it is not already running. Sizes are
+// sizes of network-layer packets, including the network-layer header.
+type PLPMTUDInfo struct {
+	// State is the state of the search.
+	State PLPMTUDState
+
+	// PLPMTU is the largest packet size confirmed to reach the peer.
+	PLPMTU uint32
+
+	// ProbeSize is the size of the next probe to send, or 0 if no probe needs
+	// to be sent. Probes should be written with WriteOptions.PathMTUProbe set.
+	ProbeSize uint32
+}
+
+func (*PLPMTUDInfo) isGettableSocketOption() {}
+
+// PLPMTUDProbeResultOption is used by SetSockOpt to report the outcome of a
+// datagram packetization layer path MTU discovery probe, or of a packet no
+// larger than the PLPMTU, as determined by the packetization layer's
+// acknowledgements.
+type PLPMTUDProbeResultOption struct {
+	// Size is the size of the network-layer packet.
+	Size uint32
+
+	// Acked is true if the packet was acknowledged by the peer and false if it
+	// was deemed lost.
+	Acked bool
+}
+
+func (*PLPMTUDProbeResultOption) isSettableSocketOption() {}
+
 // OutOfBandInlineOption is used by SetSockOpt/GetSockOpt to specify whether
 // TCP out-of-band data is delivered along with the normal in-band data.
 type OutOfBandInlineOption int
@@ -1101,6 +2316,53 @@ type SocketDetachFilterOption int
 
 func (*SocketDetachFilterOption) isSettableSocketOption() {}
 
+// PacketRingOption is used by SetSockOpt to set the ring into which a packet
+// endpoint delivers the packets it receives. A nil Ring restores the delivery
+// of the packets to the receive queue.
+type PacketRingOption struct {
+	Ring PacketRing
+}
+
+func (*PacketRingOption) isSettableSocketOption() {}
+
+// PacketFanoutMode is the algorithm distributing the packets received by a
+// fanout group among its members.
+type PacketFanoutMode int
+
+const (
+	// PacketFanoutHash distributes the packets by the hash of their flow,
+	// the same for both directions of the flow.
+	PacketFanoutHash PacketFanoutMode = iota
+
+	// PacketFanoutLB distributes the packets round-robin.
+	PacketFanoutLB
+
+	// PacketFanoutCPU distributes the packets by the CPU which receives
+	// them. The packets are received by the goroutines of the link
+	// endpoints rather than CPUs, so they are distributed by the
+	// direction-dependent hash of their flow, as receive side scaling
+	// distributes them among CPUs.
+	PacketFanoutCPU
+)
+
+// PacketFanoutOption is used by SetSockOpt/GetSockOpt to join, or get, the
+// fanout group of a packet endpoint, as per PACKET_FANOUT. The members of a
+// fanout group share the packets they receive, each packet being received by
+// a single member.
+//
+// +stateify savable
+type PacketFanoutOption struct {
+	// ID identifies the group in the stack.
+	ID uint16
+
+	// Mode is the algorithm distributing the packets of the group.
+	Mode PacketFanoutMode
+}
+
+func (*PacketFanoutOption) isGettableSocketOption() {}
+
+func (*PacketFanoutOption) isSettableSocketOption() {}
+
 // OriginalDestinationOption is used to get the original destination address
 // and port of a redirected packet.
 type OriginalDestinationOption FullAddress
@@ -1174,6 +2436,53 @@ type Route struct {
 
 	// NIC is the id of the nic to be used if this row is viable.
 	NIC NICID
+
+	// MTU is the maximum size of a network-layer packet, including the
+	// network-layer header, sent through this row, or 0 if packets are only
+	// limited by the MTU of the NIC.
+	MTU uint32
+
+	// MTULocked is true if the path MTU of destinations reached through this
+	// row may not be lowered by path MTU discovery. Packets sent through the
+	// row are then only sent with the Don't Fragment flag set when the
+	// sending endpoint requires it.
+	MTULocked bool
+
+	// Table is the route table holding this row, or 0 for MainRouteTable.
+	Table uint32
+
+	// Metric is the priority of this row among the rows of its route table
+	// whose destination has the same prefix length, the lowest metric having
+	// the highest priority. Routes inserted in the route table of a stack are
+	// placed after the rows with a longer prefix or a lower metric, so that
+	// the first row matching a destination is the preferred one.
+	Metric uint32
+
+	// Weight is the weight of this row among the next hops of a multipath
+	// route, or 0 if the row is not part of a multipath route. The rows of a
+	// route table with the same destination and a non-zero weight form a
+	// multipath route, which balances the flows to the destination between
+	// its next hops in proportion to their weight.
+	Weight uint32
+}
+
+// Well-known route tables, which have the same identifiers as in Linux.
+const (
+	// DefaultRouteTable is the route table looked up by the routing policy
+	// rule with the lowest precedence.
+	DefaultRouteTable uint32 = 253
+
+	// MainRouteTable is the route table holding the rows that don't specify
+	// a table.
+	MainRouteTable uint32 = 254
+)
+
+// TableID returns the identifier of the route table holding r.
+func (r Route) TableID() uint32 {
+	if r.Table == 0 {
+		return MainRouteTable
+	}
+	return r.Table
 }
 
 // String implements the fmt.Stringer interface.
@@ -1184,6 +2493,22 @@ func (r Route) String() string {
 		fmt.Fprintf(&out, " via %s", r.Gateway)
 	}
 	fmt.Fprintf(&out, " nic %d", r.NIC)
+	if r.MTU != 0 || r.MTULocked {
+		out.WriteString(" mtu ")
+		if r.MTULocked {
+			out.WriteString("lock ")
+		}
+		fmt.Fprintf(&out, "%d", r.MTU)
+	}
+	if table := r.TableID(); table != MainRouteTable {
+		fmt.Fprintf(&out, " table %d", table)
+	}
+	if r.Metric != 0 {
+		fmt.Fprintf(&out, " metric %d", r.Metric)
+	}
+	if r.Weight != 0 {
+		fmt.Fprintf(&out, " weight %d", r.Weight)
+	}
 	return out.String()
 }
 
@@ -1193,6 +2518,87 @@ func (r Route) Equal(to Route) bool {
 	return r == to
 }
 
+// RouteRule is a rule of the routing policy of a stack. The rules are
+// evaluated in order of priority and the route table of the first rule that
+// matches a packet and holds a route to its destination is used to route it,
+// like the rules of Linux's routing policy database.
+//
+// The zero value of each selector matches all packets.
+type RouteRule struct {
+	// Priority orders the rules, the rules with the lowest priority are
+	// evaluated first. Rules with the same priority are evaluated in the
+	// order they were added.
+	Priority uint32
+
+	// NetProto is the network protocol of the packets the rule matches.
+	NetProto NetworkProtocolNumber
+
+	// Source must contain the source address of the packets the rule
+	// matches.
+	Source Subnet
+
+	// Destination must contain the destination address of the packets the
+	// rule matches.
+	Destination Subnet
+
+	// Mark is the mark of the packets the rule matches, after masking with
+	// MarkMask.
+	Mark     uint32
+	MarkMask uint32
+
+	// InputNIC is the NIC the packets the rule matches were received
+	// through. Rules specifying an input NIC don't match locally generated
+	// packets.
+	InputNIC NICID
+
+	// OutputNIC is the NIC the packets the rule matches are bound to.
+	OutputNIC NICID
+
+	// TOS is the Type of Service or Traffic Class of the packets the rule
+	// matches.
+	TOS uint8
+
+	// Table is the route table looked up for the packets the rule matches,
+	// or 0 for MainRouteTable.
+	Table uint32
+}
+
+// TableID returns the identifier of the route table looked up by r.
+func (r RouteRule) TableID() uint32 {
+	if r.Table == 0 {
+		return MainRouteTable
+	}
+	return r.Table
+}
+
+// String implements the fmt.Stringer interface.
+func (r RouteRule) String() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "%d: from ", r.Priority)
+	if r.Source == (Subnet{}) {
+		out.WriteString("all")
+	} else {
+		fmt.Fprintf(&out, "%s", r.Source)
+	}
+	if r.Destination != (Subnet{}) {
+		fmt.Fprintf(&out, " to %s", r.Destination)
+	}
+	if r.TOS != 0 {
+		fmt.Fprintf(&out, " tos %#x", r.TOS)
+	}
+	if r.MarkMask != 0 {
+		fmt.Fprintf(&out, " fwmark %#x/%#x", r.Mark, r.MarkMask)
+	}
+	if r.InputNIC != 0 {
+		fmt.Fprintf(&out, " iif %d", r.InputNIC)
+	}
+	if r.OutputNIC != 0 {
+		fmt.Fprintf(&out, " oif %d", r.OutputNIC)
+	}
+	fmt.Fprintf(&out, " lookup %d", r.TableID())
+	return out.String()
+}
+
 // TransportProtocolNumber is the number of a transport protocol.
 type TransportProtocolNumber uint32
 
@@ -1430,6 +2836,10 @@ type IGMPPacketStats struct {
 	// messages counted.
 	V2MembershipReport *StatCounter
 
+	// V3MembershipReport is the total number of Version 3 Membership Report
+	// messages counted.
+	V3MembershipReport *StatCounter
+
 	// LeaveGroup is the total number of Leave Group messages counted.
 	LeaveGroup *StatCounter
 }
@@ -1527,6 +2937,161 @@ type IPStats struct {
 
 	// OptionUnknownReceived is the number of unknown IP options seen.
 	OptionUnknownReceived *StatCounter
+
+	// NDP collects NDP-specific stats.
+	NDP NDPStats
+
+	// ExtHdrOptions collects stats for the options held in IPv6 Hop-by-Hop and
+	// Destination Options extension headers.
+	ExtHdrOptions IPv6ExtHdrOptionStats
+
+	// MulticastForwarding collects stats for multicast forwarding.
+	MulticastForwarding MulticastForwardingStats
+}
+
+// MulticastForwardingStats collects stats for multicast forwarding.
+type MulticastForwardingStats struct {
+	// PacketsForwarded is the number of multicast packets sent through an
+	// outgoing interface of a multicast route.
+	PacketsForwarded *StatCounter
+
+	// NoRoutePacketsQueued is the number of multicast packets queued because
+	// they did not match a multicast route.
+	NoRoutePacketsQueued *StatCounter
+
+	// PendingQueueFullDropped is the number of multicast packets dropped
+	// because they did not match a multicast route and too many packets were
+	// already queued for the route.
+	PendingQueueFullDropped *StatCounter
+
+	// UnexpectedInputInterfaceDropped is the number of multicast packets
+	// dropped because they were received on an interface other than the
+	// expected input interface of their multicast route.
+	UnexpectedInputInterfaceDropped *StatCounter
+
+	// OutgoingPacketErrors is the number of multicast packets that could not
+	// be sent through an outgoing interface.
+	OutgoingPacketErrors *StatCounter
+}
+
+// IPv6ExtHdrOptionStats collects stats for the options held in IPv6 Hop-by-Hop
+// and Destination Options extension headers.
+type IPv6ExtHdrOptionStats struct {
+	// RouterAlertReceived is the number of Router Alert options seen.
+	RouterAlertReceived *StatCounter
+
+	// TooManyOptionsDropped is the number of packets dropped because an
+	// options extension header held more options than permitted.
+	TooManyOptionsDropped *StatCounter
+
+	// HeaderTooLongDropped is the number of packets dropped because an options
+	// extension header was longer than permitted.
+	HeaderTooLongDropped *StatCounter
+
+	// UnknownOptionDropped is the number of packets dropped because they held
+	// an unrecognized option, either as required by the option's type or by
+	// the interface's policy.
+	UnknownOptionDropped *StatCounter
+
+	// MLDWithoutRouterAlertDropped is the number of MLD messages dropped
+	// because they were not received with a Router Alert option.
+	MLDWithoutRouterAlertDropped *StatCounter
+}
+
+// NDPStats collects NDP-specific stats.
+type NDPStats struct {
+	// RouterAdvertsRejected is the number of valid Router Advertisements
+	// dropped because they were rejected by the integrator's Router
+	// Advertisement policy.
+	RouterAdvertsRejected *StatCounter
+
+	// FragmentedMessagesDropped is the number of NDP messages dropped because
+	// they were received with a Fragment extension header, as per RFC 6980.
+	FragmentedMessagesDropped *StatCounter
+
+	// RedirectsIgnored is the number of valid Redirect messages that were not
+	// acted on, either because redirects are not being processed or because
+	// the sender is not the current first-hop for the redirected destination.
+	RedirectsIgnored *StatCounter
+
+	// InvalidHopLimitDropped is the number of NDP messages dropped because
+	// their IPv6 Hop Limit was not 255, as per RFC 4861.
+	InvalidHopLimitDropped *StatCounter
+
+	// InvalidCodeDropped is the number of NDP messages dropped because their
+	// ICMPv6 Code was not 0, as per RFC 4861.
+	InvalidCodeDropped *StatCounter
+
+	// MalformedMessagesDropped is the number of NDP messages dropped because
+	// they were too short or held malformed options.
+	MalformedMessagesDropped *StatCounter
+
+	// NonLinkLocalSourceDropped is the number of Router Advertisements and
+	// Redirect messages dropped because their source was not a link-local
+	// address.
+	NonLinkLocalSourceDropped *StatCounter
+
+	// InvalidLinkLayerOptionDropped is the number of NDP messages dropped
+	// because they held more than one link-layer address option, held a
+	// link-layer address option when sent from the unspecified address, or
+	// were missing a required link-layer address option.
+	InvalidLinkLayerOptionDropped *StatCounter
+
+	// InvalidTargetDropped is the number of Neighbor Solicitations and
+	// Redirect messages dropped because of their target or destination
+	// address, e.g. because it was a multicast address.
+	InvalidTargetDropped *StatCounter
+
+	// InvalidDestinationDropped is the number of Neighbor Solicitations sent
+	// from the unspecified address that were dropped because they were not
+	// sent to a solicited-node multicast address.
+	InvalidDestinationDropped *StatCounter
+
+	// TentativeTargetSolicitsIgnored is the number of Neighbor Solicitations
+	// ignored because they were sent by another node resolving an address
+	// that is still tentative.
+	TentativeTargetSolicitsIgnored *StatCounter
+
+	// UnknownTargetSolicitsIgnored is the number of Neighbor Solicitations
+	// ignored because their target was neither assigned nor proxied.
+	UnknownTargetSolicitsIgnored *StatCounter
+
+	// RouterSolicitsIgnored is the number of Router Solicitations ignored
+	// because the interface was not operating as a router.
+	RouterSolicitsIgnored *StatCounter
+
+	// RouterAdvertsIgnored is the number of Router Advertisements ignored
+	// because the interface was not configured to handle them or was operating
+	// as a router.
+	RouterAdvertsIgnored *StatCounter
+
+	// DefaultRoutersIgnored is the number of new default routers that were not
+	// remembered because the maximum number of discovered default routers was
+	// reached.
+	DefaultRoutersIgnored *StatCounter
+
+	// LinkLocalPrefixesIgnored is the number of Prefix Information options
+	// ignored because they held the link-local prefix.
+	LinkLocalPrefixesIgnored *StatCounter
+
+	// ZeroLengthPrefixesIgnored is the number of Prefix Information options
+	// ignored because their prefix length was 0.
+	ZeroLengthPrefixesIgnored *StatCounter
+
+	// OnLinkPrefixesIgnored is the number of new on-link prefixes that were
+	// not remembered because the maximum number of discovered on-link prefixes
+	// was reached.
+	OnLinkPrefixesIgnored *StatCounter
+
+	// InvalidPrefixLifetimesIgnored is the number of Prefix Information
+	// options with the autonomous flag set that were ignored because their
+	// preferred lifetime was greater than their valid lifetime.
+	InvalidPrefixLifetimesIgnored *StatCounter
+
+	// InvalidSLAACPrefixLengthsIgnored is the number of Prefix Information
+	// options with the autonomous flag set that were ignored because their
+	// prefix length could not be used to generate addresses.
+	InvalidSLAACPrefixLengthsIgnored *StatCounter
 }
 
 // TCPStats collects TCP-specific stats.
@@ -1627,6 +3192,65 @@ type TCPStats struct {
 
 	// ChecksumErrors is the number of segments dropped due to bad checksums.
 	ChecksumErrors *StatCounter
+
+	// MD5NotFound is the number of segments dropped because they weren't
+	// signed while a TCP MD5 signature key is configured for their sender.
+	MD5NotFound *StatCounter
+
+	// MD5Unexpected is the number of segments dropped because they were
+	// signed while no TCP MD5 signature key is configured for their
+	// sender.
+	MD5Unexpected *StatCounter
+
+	// MD5Failure is the number of segments dropped because of an invalid
+	// TCP MD5 signature.
+	MD5Failure *StatCounter
+
+	// AOGood is the number of segments with a valid TCP Authentication
+	// Option MAC.
+	AOGood *StatCounter
+
+	// AOBad is the number of segments dropped because of an invalid TCP
+	// Authentication Option MAC.
+	AOBad *StatCounter
+
+	// AOKeyNotFound is the number of segments dropped because no TCP
+	// Authentication Option key matched their KeyID.
+	AOKeyNotFound *StatCounter
+
+	// AORequired is the number of segments dropped because they lacked a
+	// TCP Authentication Option.
+	AORequired *StatCounter
+
+	// ECNCongestionResponses is the number of times the congestion window
+	// was reduced in response to ECN feedback.
+	ECNCongestionResponses *StatCounter
+
+	// AutoCorking is the number of times a small segment was held back by
+	// auto-corking.
+	AutoCorking *StatCounter
+
+	// SmallQueueThrottled is the number of times sending was stopped
+	// because too much data of the connection was queued below the
+	// endpoint.
+	SmallQueueThrottled *StatCounter
+
+	// DeferAcceptDrop is the number of bare ACKs completing a handshake
+	// which were dropped because of TCP_DEFER_ACCEPT.
+	DeferAcceptDrop *StatCounter
+
+	// OutOfOrderQueued is the number of out-of-order segments queued
+	// until the preceding data is received.
+	OutOfOrderQueued *StatCounter
+
+	// OutOfOrderDropped is the number of out-of-order segments dropped
+	// because the out-of-order queue was full.
+	OutOfOrderDropped *StatCounter
+
+	// OutOfOrderPruned is the number of out-of-order segments removed from
+	// a full out-of-order queue, reneging on their SACK, to make room for
+	// a segment closer to the left edge of the window.
+	OutOfOrderPruned *StatCounter
 }
 
 // UDPStats collects UDP-specific stats.
@@ -1657,6 +3281,90 @@ type UDPStats struct {
 	ChecksumErrors *StatCounter
 }
 
+// MPTCPStats collects Multipath TCP-specific stats.
+type MPTCPStats struct {
+	// ActiveOpenings is the number of connections opened with MPTCP.
+	ActiveOpenings *StatCounter
+
+	// PassiveOpenings is the number of connections accepted with MPTCP.
+	PassiveOpenings *StatCounter
+
+	// Fallbacks is the number of MPTCP connections which fell back to
+	// regular TCP because the peer doesn't support MPTCP.
+	Fallbacks *StatCounter
+
+	// JoinsAccepted is the number of subflows which joined a connection.
+	JoinsAccepted *StatCounter
+
+	// JoinsFailed is the number of MP_JOIN handshakes which failed, either
+	// because the token is unknown or the authentication failed.
+	JoinsFailed *StatCounter
+
+	// Reinjections is the number of times the unacknowledged data of a
+	// failed subflow was retransmitted on the other subflows.
+	Reinjections *StatCounter
+}
+
+// SCTPStats collects SCTP-specific stats.
+type SCTPStats struct {
+	// CurrentEstablished is the number of SCTP associations in the
+	// established or shutdown pending states.
+	CurrentEstablished *StatCounter
+
+	// ActiveEstablishments is the number of associations initiated by the
+	// stack which were established.
+	ActiveEstablishments *StatCounter
+
+	// PassiveEstablishments is the number of associations initiated by the
+	// peers which were established.
+	PassiveEstablishments *StatCounter
+
+	// Aborts is the number of associations aborted, by either side.
+	Aborts *StatCounter
+
+	// Shutdowns is the number of associations shut down gracefully.
+	Shutdowns *StatCounter
+
+	// OutOfTheBlue is the number of packets received which belonged to no
+	// association, as per RFC 4960 section 8.4.
+	OutOfTheBlue *StatCounter
+
+	// ChecksumErrors is the number of packets dropped due to bad
+	// checksums.
+	ChecksumErrors *StatCounter
+
+	// InvalidPacketsReceived is the number of packets dropped because they
+	// were malformed, addressed to a non-unicast address, or carried the
+	// wrong verification tag.
+	InvalidPacketsReceived *StatCounter
+
+	// PacketsSent is the number of SCTP packets sent.
+	PacketsSent *StatCounter
+
+	// PacketsReceived is the number of SCTP packets received.
+	PacketsReceived *StatCounter
+
+	// DataChunksSent is the number of DATA chunks sent, including
+	// retransmissions.
+	DataChunksSent *StatCounter
+
+	// DataChunksReceived is the number of DATA chunks received, including
+	// duplicates.
+	DataChunksReceived *StatCounter
+
+	// Retransmissions is the number of DATA chunks retransmitted after a
+	// retransmission timeout.
+	Retransmissions *StatCounter
+
+	// FastRetransmissions is the number of DATA chunks retransmitted after
+	// being reported missing by three SACKs.
+	FastRetransmissions *StatCounter
+
+	// PathFailovers is the number of times an association switched its
+	// primary path because the previous one became unreachable.
+	PathFailovers *StatCounter
+}
+
 // Stats holds statistics about the networking stack.
 //
 // All fields are optional.
@@ -1686,6 +3394,15 @@ type Stats struct {
 
 	// UDP breaks out UDP-specific stats.
 	UDP UDPStats
+
+	// UDPLite breaks out UDP-Lite-specific stats.
+	UDPLite UDPStats
+
+	// MPTCP breaks out Multipath TCP-specific stats.
+	MPTCP MPTCPStats
+
+	// SCTP breaks out SCTP-specific stats.
+	SCTP SCTPStats
 }
 
 // ReceiveErrors collects packet receive errors within transport endpoint.
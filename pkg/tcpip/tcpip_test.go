@@ -15,10 +15,14 @@
 package tcpip
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"net"
 	"strings"
 	"testing"
+
+	"gvisor.dev/gvisor/pkg/state"
 )
 
 func TestSubnetContains(t *testing.T) {
@@ -270,3 +274,55 @@ func TestAddressUnspecified(t *testing.T) {
 		})
 	}
 }
+
+func TestBorrowedSend(t *testing.T) {
+	released := 0
+	z := NewBorrowedSend(func() { released++ })
+	z.IncRef()
+	z.MarkCopied()
+	if z.DecRef() {
+		t.Errorf("got z.DecRef() = true, want = false")
+	}
+	if released != 0 {
+		t.Errorf("got released = %d before the last reference was dropped, want = 0", released)
+	}
+	if z.DecRef() {
+		t.Errorf("got z.DecRef() = true, want = false")
+	}
+	if released != 1 {
+		t.Errorf("got released = %d, want = 1", released)
+	}
+}
+
+func TestSaveRestoreControlMessages(t *testing.T) {
+	want := ControlMessages{
+		HasSCTPRcvInfo: true,
+		SCTPRcvInfo: SCTPRcvInfo{
+			Stream:    1,
+			SSN:       2,
+			Unordered: true,
+			PPID:      3,
+			TSN:       4,
+			CumTSN:    5,
+		},
+		HasSCTPSndInfo: true,
+		SCTPSndInfo: SCTPSndInfo{
+			Stream:    6,
+			Unordered: true,
+			PPID:      7,
+		},
+	}
+
+	var buf bytes.Buffer
+	ctx := context.Background()
+	if _, err := state.Save(ctx, &buf, &want); err != nil {
+		t.Fatal("state.Save:", err)
+	}
+	var got ControlMessages
+	if _, err := state.Load(ctx, bytes.NewReader(buf.Bytes()), &got); err != nil {
+		t.Fatal("state.Load:", err)
+	}
+	if got != want {
+		t.Errorf("got restored control messages = %+v, want = %+v", got, want)
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration_test
+
+import (
+	"net"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// TestVRF tests that the NICs enslaved to a VRF device form a routing domain
+// which is only used by the sockets bound to the VRF device.
+func TestVRF(t *testing.T) {
+	const (
+		nic1ID     = 1
+		nic2ID     = 2
+		vrfID      = 3
+		localPort  = 1000
+		remotePort = 2000
+	)
+	vrfAddr := tcpip.ProtocolAddress{
+		Protocol: ipv4.ProtocolNumber,
+		AddressWithPrefix: tcpip.AddressWithPrefix{
+			Address:   tcpip.Address(net.ParseIP("10.0.0.1").To4()),
+			PrefixLen: 24,
+		},
+	}
+	vrfRemoteAddr := tcpip.Address(net.ParseIP("10.0.0.2").To4())
+
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+	})
+	e1 := channel.New(1, defaultMTU, "")
+	if err := s.CreateNIC(nic1ID, e1); err != nil {
+		t.Fatalf("CreateNIC(%d, _): %s", nic1ID, err)
+	}
+	e2 := channel.New(1, defaultMTU, "")
+	if err := s.CreateNIC(nic2ID, e2); err != nil {
+		t.Fatalf("CreateNIC(%d, _): %s", nic2ID, err)
+	}
+	if err := s.CreateVRF(vrfID, "vrf0"); err != nil {
+		t.Fatalf("CreateVRF(%d, _): %s", vrfID, err)
+	}
+	if err := s.SetNICMaster(nic2ID, vrfID); err != nil {
+		t.Fatalf("SetNICMaster(%d, %d): %s", nic2ID, vrfID, err)
+	}
+	if err := s.SetNICMaster(vrfID, vrfID); err != tcpip.ErrNotSupported {
+		t.Fatalf("got SetNICMaster(%d, %d) = %v, want = %s", vrfID, vrfID, err, tcpip.ErrNotSupported)
+	}
+	if err := s.SetNICMaster(nic1ID, nic2ID); err != tcpip.ErrInvalidOptionValue {
+		t.Fatalf("got SetNICMaster(%d, %d) = %v, want = %s", nic1ID, nic2ID, err, tcpip.ErrInvalidOptionValue)
+	}
+	if master, err := s.NICMaster(nic2ID); err != nil || master != vrfID {
+		t.Fatalf("got NICMaster(%d) = (%d, %v), want = (%d, nil)", nic2ID, master, err, vrfID)
+	}
+	if err := s.AddProtocolAddress(nic1ID, ipv4Addr1); err != nil {
+		t.Fatalf("AddProtocolAddress(%d, %+v): %s", nic1ID, ipv4Addr1, err)
+	}
+	if err := s.AddProtocolAddress(nic2ID, vrfAddr); err != nil {
+		t.Fatalf("AddProtocolAddress(%d, %+v): %s", nic2ID, vrfAddr, err)
+	}
+	s.SetRouteTable([]tcpip.Route{
+		{Destination: vrfAddr.AddressWithPrefix.Subnet(), NIC: nic2ID},
+		{Destination: header.IPv4EmptySubnet, NIC: nic1ID},
+	})
+
+	t.Run("Routes", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			bindID   tcpip.NICID
+			remote   tcpip.Address
+			wantNIC  tcpip.NICID
+			wantAddr tcpip.Address
+			wantErr  *tcpip.Error
+		}{
+			{name: "Unbound", remote: vrfRemoteAddr, wantNIC: nic1ID, wantAddr: ipv4Addr1.AddressWithPrefix.Address},
+			{name: "Bound to VRF", bindID: vrfID, remote: vrfRemoteAddr, wantNIC: nic2ID, wantAddr: vrfAddr.AddressWithPrefix.Address},
+			{name: "Bound to VRF slave", bindID: nic2ID, remote: vrfRemoteAddr, wantNIC: nic2ID, wantAddr: vrfAddr.AddressWithPrefix.Address},
+			{name: "Bound to VRF outside domain", bindID: vrfID, remote: ipv4Addr2.AddressWithPrefix.Address, wantErr: tcpip.ErrNoRoute},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				r, err := s.FindRoute(test.bindID, "", test.remote, ipv4.ProtocolNumber, false /* multicastLoop */)
+				if err != test.wantErr {
+					t.Fatalf("got FindRoute(%d, '', %s, %d, false) = (_, %v), want = (_, %v)", test.bindID, test.remote, ipv4.ProtocolNumber, err, test.wantErr)
+				}
+				if err != nil {
+					return
+				}
+				defer r.Release()
+				if got := r.NICID(); got != test.wantNIC {
+					t.Errorf("got r.NICID() = %d, want = %d", got, test.wantNIC)
+				}
+				if r.LocalAddress != test.wantAddr {
+					t.Errorf("got r.LocalAddress = %s, want = %s", r.LocalAddress, test.wantAddr)
+				}
+			})
+		}
+	})
+
+	newEndpoint := func(t *testing.T, bindID tcpip.NICID) tcpip.Endpoint {
+		var wq waiter.Queue
+		ep, err := s.NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+		if err != nil {
+			t.Fatalf("NewEndpoint(%d, %d, _): %s", udp.ProtocolNumber, ipv4.ProtocolNumber, err)
+		}
+		t.Cleanup(ep.Close)
+		opt := tcpip.BindToDeviceOption(bindID)
+		if err := ep.SetSockOpt(&opt); err != nil {
+			t.Fatalf("SetSockOpt(&%T(%d)): %s", opt, opt, err)
+		}
+		bindAddr := tcpip.FullAddress{Port: localPort}
+		if err := ep.Bind(bindAddr); err != nil {
+			t.Fatalf("Bind(%#v): %s", bindAddr, err)
+		}
+		return ep
+	}
+
+	// rx injects a UDP datagram from vrfRemoteAddr on the VRF slave.
+	rx := func() {
+		payload := buffer.View{1, 2, 3, 4}
+		totalLen := header.IPv4MinimumSize + header.UDPMinimumSize + len(payload)
+		hdr := buffer.NewPrependable(totalLen)
+		copy(hdr.Prepend(len(payload)), payload)
+		u := header.UDP(hdr.Prepend(header.UDPMinimumSize))
+		u.Encode(&header.UDPFields{
+			SrcPort: remotePort,
+			DstPort: localPort,
+			Length:  uint16(header.UDPMinimumSize + len(payload)),
+		})
+		ip := header.IPv4(hdr.Prepend(header.IPv4MinimumSize))
+		ip.Encode(&header.IPv4Fields{
+			TotalLength: uint16(totalLen),
+			Protocol:    uint8(udp.ProtocolNumber),
+			TTL:         ttl,
+			SrcAddr:     vrfRemoteAddr,
+			DstAddr:     vrfAddr.AddressWithPrefix.Address,
+		})
+		ip.SetChecksum(^ip.CalculateChecksum())
+		e2.InjectInbound(header.IPv4ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Data: hdr.View().ToVectorisedView(),
+		}))
+	}
+
+	t.Run("Receive", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			bindID   tcpip.NICID
+			accept   bool
+			received bool
+		}{
+			{name: "Bound to VRF", bindID: vrfID, received: true},
+			{name: "Bound to VRF slave", bindID: nic2ID, received: true},
+			{name: "Bound to other NIC", bindID: nic1ID},
+			{name: "Unbound", bindID: 0},
+			{name: "Unbound with l3mdev_accept", bindID: 0, accept: true, received: true},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				opt := stack.L3MasterDeviceAcceptOption(test.accept)
+				if err := s.SetOption(opt); err != nil {
+					t.Fatalf("SetOption(%#v): %s", opt, err)
+				}
+				ep := newEndpoint(t, test.bindID)
+
+				rx()
+				var addr tcpip.FullAddress
+				_, _, err := ep.Read(&addr)
+				if !test.received {
+					if err != tcpip.ErrWouldBlock {
+						t.Fatalf("got ep.Read(_) = (_, _, %v), want = (_, _, %s)", err, tcpip.ErrWouldBlock)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("ep.Read(_): %s", err)
+				}
+				if want := (tcpip.FullAddress{NIC: nic2ID, Addr: vrfRemoteAddr, Port: remotePort}); addr != want {
+					t.Errorf("got ep.Read(_) from %#v, want = %#v", addr, want)
+				}
+			})
+		}
+	})
+}
@@ -15,6 +15,8 @@
 package integration_test
 
 import (
+	"bytes"
+	"fmt"
 	"testing"
 	"time"
 
@@ -35,7 +37,7 @@ var _ ipv6.NDPDispatcher = (*ndpDispatcher)(nil)
 
 type ndpDispatcher struct{}
 
-func (*ndpDispatcher) OnDuplicateAddressDetectionStatus(tcpip.NICID, tcpip.Address, bool, *tcpip.Error) {
+func (*ndpDispatcher) OnDuplicateAddressDetectionResult(tcpip.NICID, tcpip.Address, stack.DADResult) {
 }
 
 func (*ndpDispatcher) OnDefaultRouterDiscovered(tcpip.NICID, tcpip.Address) bool {
@@ -64,6 +66,8 @@ func (*ndpDispatcher) OnDNSSearchListOption(tcpip.NICID, []string, time.Duration
 
 func (*ndpDispatcher) OnDHCPv6Configuration(tcpip.NICID, ipv6.DHCPv6ConfigurationFromNDPRA) {}
 
+func (*ndpDispatcher) OnRedirectAccepted(tcpip.NICID, tcpip.Address, tcpip.Address, tcpip.Address) {}
+
 // TestInitialLoopbackAddresses tests that the loopback interface does not
 // auto-generate a link-local address when it is brought up.
 func TestInitialLoopbackAddresses(t *testing.T) {
@@ -483,3 +487,122 @@ func TestLoopbackAcceptAllInSubnetTCP(t *testing.T) {
 		})
 	}
 }
+
+// TestLoopbackTCPTransfer tests that data sent in both directions of a TCP
+// connection over a loopback interface at the same time is received intact.
+func TestLoopbackTCPTransfer(t *testing.T) {
+	const (
+		nicID     = 1
+		localPort = 80
+		dataSize  = 1 << 20
+	)
+
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol},
+	})
+	defer s.Close()
+	if err := s.CreateNIC(nicID, loopback.New()); err != nil {
+		t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+	}
+	protocolAddr := tcpip.ProtocolAddress{
+		Protocol:          header.IPv4ProtocolNumber,
+		AddressWithPrefix: ipv4Addr,
+	}
+	if err := s.AddProtocolAddress(nicID, protocolAddr); err != nil {
+		t.Fatalf("AddProtocolAddress(%d, %#v): %s", nicID, protocolAddr, err)
+	}
+	s.SetRouteTable([]tcpip.Route{
+		tcpip.Route{
+			Destination: header.IPv4EmptySubnet,
+			NIC:         nicID,
+		},
+	})
+
+	var listenerWQ waiter.Queue
+	listenerWE, listenerCH := waiter.NewChannelEntry(nil)
+	listenerWQ.EventRegister(&listenerWE, waiter.EventIn)
+	defer listenerWQ.EventUnregister(&listenerWE)
+	listeningEndpoint, err := s.NewEndpoint(tcp.ProtocolNumber, header.IPv4ProtocolNumber, &listenerWQ)
+	if err != nil {
+		t.Fatalf("s.NewEndpoint(%d, %d, _): %s", tcp.ProtocolNumber, header.IPv4ProtocolNumber, err)
+	}
+	defer listeningEndpoint.Close()
+	addr := tcpip.FullAddress{Addr: ipv4Addr.Address, Port: localPort}
+	if err := listeningEndpoint.Bind(addr); err != nil {
+		t.Fatalf("listeningEndpoint.Bind(%#v): %s", addr, err)
+	}
+	if err := listeningEndpoint.Listen(1); err != nil {
+		t.Fatalf("listeningEndpoint.Listen(1): %s", err)
+	}
+
+	var connectingWQ waiter.Queue
+	connectingEndpoint, err := s.NewEndpoint(tcp.ProtocolNumber, header.IPv4ProtocolNumber, &connectingWQ)
+	if err != nil {
+		t.Fatalf("s.NewEndpoint(%d, %d, _): %s", tcp.ProtocolNumber, header.IPv4ProtocolNumber, err)
+	}
+	defer connectingEndpoint.Close()
+	if err := connectingEndpoint.Connect(addr); err != tcpip.ErrConnectStarted {
+		t.Fatalf("connectingEndpoint.Connect(%#v): %s", addr, err)
+	}
+
+	<-listenerCH
+	acceptedEndpoint, acceptedWQ, err := listeningEndpoint.Accept(nil)
+	if err != nil {
+		t.Fatalf("listeningEndpoint.Accept(nil): %s", err)
+	}
+	defer acceptedEndpoint.Close()
+
+	data := make([]byte, dataSize)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	// transfer sends data on ep while reading as much from it.
+	transfer := func(ep tcpip.Endpoint, wq *waiter.Queue) error {
+		we, ch := waiter.NewChannelEntry(nil)
+		wq.EventRegister(&we, waiter.EventIn|waiter.EventOut)
+		defer wq.EventUnregister(&we)
+
+		var received []byte
+		for toSend := data; len(toSend) != 0 || len(received) != dataSize; {
+			progress := false
+			if len(toSend) != 0 {
+				n, _, err := ep.Write(tcpip.SlicePayload(toSend), tcpip.WriteOptions{})
+				switch err {
+				case nil:
+					toSend = toSend[n:]
+					progress = true
+				case tcpip.ErrWouldBlock:
+				default:
+					return fmt.Errorf("ep.Write(_, {}): %s", err)
+				}
+			}
+			v, _, err := ep.Read(nil)
+			switch err {
+			case nil:
+				received = append(received, v...)
+				progress = true
+			case tcpip.ErrWouldBlock:
+			default:
+				return fmt.Errorf("ep.Read(nil): %s", err)
+			}
+			if !progress {
+				<-ch
+			}
+		}
+		if !bytes.Equal(received, data) {
+			return fmt.Errorf("received data doesn't match the sent data")
+		}
+		return nil
+	}
+
+	errs := make(chan error, 2)
+	go func() { errs <- transfer(connectingEndpoint, &connectingWQ) }()
+	go func() { errs <- transfer(acceptedEndpoint, acceptedWQ) }()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/link/ethernet"
+	"gvisor.dev/gvisor/pkg/tcpip/network/arp"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	upperParentID   = 1
+	upperLocalPort  = 1000
+	upperRemotePort = 2000
+)
+
+var (
+	upperRemoteAddr = tcpip.Address(net.ParseIP("192.168.0.100").To4())
+	upperPayload    = []byte{1, 2, 3, 4}
+)
+
+// newUpperTestStack returns a stack with an ethernet NIC on which the tests
+// layer MACVLAN and IPVLAN NICs.
+func newUpperTestStack(t *testing.T) (*stack.Stack, *channel.Endpoint) {
+	t.Helper()
+
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{arp.NewProtocol, ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+	})
+	e := channel.New(1, defaultMTU, linkAddr1)
+	if err := s.CreateNIC(upperParentID, ethernet.New(e)); err != nil {
+		t.Fatalf("CreateNIC(%d, _): %s", upperParentID, err)
+	}
+	return s, e
+}
+
+// addUpperAddress assigns addr to the NIC id and binds a UDP endpoint to it.
+func addUpperAddress(t *testing.T, s *stack.Stack, id tcpip.NICID, addr tcpip.Address) tcpip.Endpoint {
+	t.Helper()
+
+	protocolAddr := tcpip.ProtocolAddress{
+		Protocol:          ipv4.ProtocolNumber,
+		AddressWithPrefix: tcpip.AddressWithPrefix{Address: addr, PrefixLen: 24},
+	}
+	if err := s.AddProtocolAddress(id, protocolAddr); err != nil {
+		t.Fatalf("AddProtocolAddress(%d, %+v): %s", id, protocolAddr, err)
+	}
+	s.AddRoute(tcpip.Route{Destination: protocolAddr.AddressWithPrefix.Subnet(), NIC: id})
+	if err := s.AddStaticNeighbor(id, upperRemoteAddr, linkAddr2); err != nil {
+		t.Fatalf("AddStaticNeighbor(%d, %s, %s): %s", id, upperRemoteAddr, linkAddr2, err)
+	}
+
+	var wq waiter.Queue
+	ep, err := s.NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+	if err != nil {
+		t.Fatalf("NewEndpoint(%d, %d, _): %s", udp.ProtocolNumber, ipv4.ProtocolNumber, err)
+	}
+	t.Cleanup(ep.Close)
+	bindAddr := tcpip.FullAddress{Addr: addr, Port: upperLocalPort}
+	if err := ep.Bind(bindAddr); err != nil {
+		t.Fatalf("Bind(%#v): %s", bindAddr, err)
+	}
+	return ep
+}
+
+// injectUpperUDP injects a UDP packet from upperRemoteAddr to dst in an
+// ethernet frame addressed to dstLinkAddr.
+func injectUpperUDP(e *channel.Endpoint, dstLinkAddr tcpip.LinkAddress, dst tcpip.Address) {
+	udpLen := header.UDPMinimumSize + len(upperPayload)
+	ipLen := header.IPv4MinimumSize + udpLen
+	hdr := buffer.NewPrependable(header.EthernetMinimumSize + ipLen)
+	copy(hdr.Prepend(len(upperPayload)), upperPayload)
+	header.UDP(hdr.Prepend(header.UDPMinimumSize)).Encode(&header.UDPFields{
+		SrcPort: upperRemotePort,
+		DstPort: upperLocalPort,
+		Length:  uint16(udpLen),
+	})
+	ip := header.IPv4(hdr.Prepend(header.IPv4MinimumSize))
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(ipLen),
+		Protocol:    uint8(udp.ProtocolNumber),
+		TTL:         ttl,
+		SrcAddr:     upperRemoteAddr,
+		DstAddr:     dst,
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+	header.Ethernet(hdr.Prepend(header.EthernetMinimumSize)).Encode(&header.EthernetFields{
+		SrcAddr: linkAddr2,
+		DstAddr: dstLinkAddr,
+		Type:    header.IPv4ProtocolNumber,
+	})
+	e.InjectInbound(0, stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: hdr.View().ToVectorisedView(),
+	}))
+}
+
+// checkUpperRead checks that ep received the payload from src through the NIC
+// id.
+func checkUpperRead(t *testing.T, ep tcpip.Endpoint, id tcpip.NICID, src tcpip.Address) {
+	t.Helper()
+
+	var addr tcpip.FullAddress
+	v, _, err := ep.Read(&addr)
+	if err != nil {
+		t.Fatalf("Read(_): %s", err)
+	}
+	if !bytes.Equal(v, upperPayload) {
+		t.Errorf("got Read(_) = %x, want = %x", v, upperPayload)
+	}
+	if addr.NIC != id || addr.Addr != src {
+		t.Errorf("got Read(_) from (NIC %d, %s), want = (NIC %d, %s)", addr.NIC, addr.Addr, id, src)
+	}
+}
+
+// checkNoUpperRead checks that ep didn't receive anything.
+func checkNoUpperRead(t *testing.T, ep tcpip.Endpoint) {
+	t.Helper()
+
+	if _, _, err := ep.Read(nil); err != tcpip.ErrWouldBlock {
+		t.Errorf("got Read(_) = (_, _, %v), want = (_, _, %s)", err, tcpip.ErrWouldBlock)
+	}
+}
+
+// writeUpper writes the payload from ep to dst.
+func writeUpper(t *testing.T, ep tcpip.Endpoint, dst tcpip.Address) {
+	t.Helper()
+
+	wOpts := tcpip.WriteOptions{To: &tcpip.FullAddress{Addr: dst, Port: upperLocalPort}}
+	if _, _, err := ep.Write(tcpip.SlicePayload(upperPayload), wOpts); err != nil {
+		t.Fatalf("Write(_, %#v): %s", wOpts, err)
+	}
+}
+
+// checkUpperFrame checks that e sent an ethernet frame from srcLinkAddr.
+func checkUpperFrame(t *testing.T, e *channel.Endpoint, srcLinkAddr tcpip.LinkAddress) {
+	t.Helper()
+
+	p, ok := e.Read()
+	if !ok {
+		t.Fatal("got no packet sent through the parent NIC")
+	}
+	vv := buffer.NewVectorisedView(p.Pkt.Size(), p.Pkt.Views())
+	eth := header.Ethernet(vv.ToView())
+	if got := eth.SourceAddress(); got != srcLinkAddr {
+		t.Errorf("got eth.SourceAddress() = %s, want = %s", got, srcLinkAddr)
+	}
+	if got := eth.DestinationAddress(); got != linkAddr2 {
+		t.Errorf("got eth.DestinationAddress() = %s, want = %s", got, linkAddr2)
+	}
+}
+
+func TestMACVLAN(t *testing.T) {
+	addr1 := tcpip.Address(net.ParseIP("192.168.0.1").To4())
+	addr2 := tcpip.Address(net.ParseIP("192.168.0.2").To4())
+
+	tests := []struct {
+		name string
+		mode stack.MACVLANMode
+		// bridged is true if the packets between the MACVLAN NICs are
+		// delivered without being sent through the parent NIC.
+		bridged bool
+	}{
+		{name: "Private", mode: stack.MACVLANModePrivate},
+		{name: "VEPA", mode: stack.MACVLANModeVEPA},
+		{name: "Bridge", mode: stack.MACVLANModeBridge, bridged: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, e := newUpperTestStack(t)
+			for _, c := range []struct {
+				id       tcpip.NICID
+				linkAddr tcpip.LinkAddress
+			}{{id: 2, linkAddr: linkAddr3}, {id: 3, linkAddr: linkAddr4}} {
+				opts := stack.MACVLANOptions{LinkAddress: c.linkAddr, Mode: test.mode}
+				if err := s.CreateMACVLAN(c.id, upperParentID, opts); err != nil {
+					t.Fatalf("CreateMACVLAN(%d, %d, %#v): %s", c.id, upperParentID, opts, err)
+				}
+			}
+			if err := s.CreateMACVLAN(4, upperParentID, stack.MACVLANOptions{LinkAddress: linkAddr3}); err != tcpip.ErrDuplicateAddress {
+				t.Errorf("got CreateMACVLAN(4, %d, _) with a used link address = %v, want = %s", upperParentID, err, tcpip.ErrDuplicateAddress)
+			}
+			if err := s.CreateIPVLAN(4, upperParentID, stack.IPVLANOptions{}); err != tcpip.ErrNotSupported {
+				t.Errorf("got CreateIPVLAN(4, %d, _) = %v, want = %s", upperParentID, err, tcpip.ErrNotSupported)
+			}
+			ep1 := addUpperAddress(t, s, 2, addr1)
+			ep2 := addUpperAddress(t, s, 3, addr2)
+			if err := s.AddStaticNeighbor(2, addr2, linkAddr4); err != nil {
+				t.Fatalf("AddStaticNeighbor(2, %s, %s): %s", addr2, linkAddr4, err)
+			}
+
+			// The frames addressed to the link address of a MACVLAN NIC are
+			// only received by it.
+			injectUpperUDP(e, linkAddr3, addr1)
+			checkUpperRead(t, ep1, 2, upperRemoteAddr)
+			injectUpperUDP(e, linkAddr4, addr2)
+			checkUpperRead(t, ep2, 3, upperRemoteAddr)
+			injectUpperUDP(e, linkAddr4, addr1)
+			checkNoUpperRead(t, ep1)
+
+			// The MACVLAN NICs send frames with their own link address.
+			writeUpper(t, ep1, upperRemoteAddr)
+			checkUpperFrame(t, e, linkAddr3)
+
+			// The packets between the MACVLAN NICs only skip the parent in
+			// bridge mode.
+			writeUpper(t, ep1, addr2)
+			if test.bridged {
+				checkUpperRead(t, ep2, 3, addr1)
+				if p, ok := e.Read(); ok {
+					t.Errorf("got unexpected packet sent through the parent NIC: %#v", p)
+				}
+			} else {
+				checkNoUpperRead(t, ep2)
+				if _, ok := e.Read(); !ok {
+					t.Error("got no packet sent through the parent NIC")
+				}
+			}
+
+			// The MACVLAN NICs are removed with their parent.
+			if err := s.RemoveNIC(upperParentID); err != nil {
+				t.Fatalf("RemoveNIC(%d): %s", upperParentID, err)
+			}
+			for _, id := range []tcpip.NICID{2, 3} {
+				if s.HasNIC(id) {
+					t.Errorf("got HasNIC(%d) = true after removing NIC %d, want = false", id, upperParentID)
+				}
+			}
+		})
+	}
+}
+
+func TestIPVLAN(t *testing.T) {
+	addr1 := tcpip.Address(net.ParseIP("192.168.0.1").To4())
+	addr2 := tcpip.Address(net.ParseIP("192.168.0.2").To4())
+
+	for _, mode := range []struct {
+		name string
+		mode stack.IPVLANMode
+	}{
+		{name: "L2", mode: stack.IPVLANModeL2},
+		{name: "L3", mode: stack.IPVLANModeL3},
+	} {
+		t.Run(mode.name, func(t *testing.T) {
+			s, e := newUpperTestStack(t)
+			for _, id := range []tcpip.NICID{2, 3} {
+				opts := stack.IPVLANOptions{Mode: mode.mode}
+				if err := s.CreateIPVLAN(id, upperParentID, opts); err != nil {
+					t.Fatalf("CreateIPVLAN(%d, %d, %#v): %s", id, upperParentID, opts, err)
+				}
+			}
+			if err := s.CreateMACVLAN(4, upperParentID, stack.MACVLANOptions{LinkAddress: linkAddr3}); err != tcpip.ErrNotSupported {
+				t.Errorf("got CreateMACVLAN(4, %d, _) = %v, want = %s", upperParentID, err, tcpip.ErrNotSupported)
+			}
+			ep1 := addUpperAddress(t, s, 2, addr1)
+			ep2 := addUpperAddress(t, s, 3, addr2)
+			if err := s.AddStaticNeighbor(2, addr2, linkAddr1); err != nil {
+				t.Fatalf("AddStaticNeighbor(2, %s, %s): %s", addr2, linkAddr1, err)
+			}
+
+			// The packets are delivered to the IPVLAN NIC with their
+			// destination address.
+			injectUpperUDP(e, linkAddr1, addr1)
+			checkUpperRead(t, ep1, 2, upperRemoteAddr)
+			checkNoUpperRead(t, ep2)
+			injectUpperUDP(e, linkAddr1, addr2)
+			checkUpperRead(t, ep2, 3, upperRemoteAddr)
+			checkNoUpperRead(t, ep1)
+
+			// The IPVLAN NICs send frames with the link address of their
+			// parent.
+			writeUpper(t, ep1, upperRemoteAddr)
+			checkUpperFrame(t, e, linkAddr1)
+
+			// The packets between the IPVLAN NICs are delivered directly.
+			writeUpper(t, ep1, addr2)
+			checkUpperRead(t, ep2, 3, addr1)
+			if p, ok := e.Read(); ok {
+				t.Errorf("got unexpected packet sent through the parent NIC: %#v", p)
+			}
+
+			// The IPVLAN NICs are removed with their parent.
+			if err := s.RemoveNIC(upperParentID); err != nil {
+				t.Fatalf("RemoveNIC(%d): %s", upperParentID, err)
+			}
+			for _, id := range []tcpip.NICID{2, 3} {
+				if s.HasNIC(id) {
+					t.Errorf("got HasNIC(%d) = true after removing NIC %d, want = false", id, upperParentID)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/arp"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// multipathRouter is a router with a multipath default route through two
+// uplinks.
+type multipathRouter struct {
+	s     *stack.Stack
+	clock *faketime.ManualClock
+
+	// eps holds the link endpoints of the downlink and the uplinks.
+	eps map[tcpip.NICID]*channel.Endpoint
+}
+
+const (
+	multipathDownlinkID = 1
+	multipathUplink1ID  = 2
+	multipathUplink2ID  = 3
+)
+
+var (
+	multipathSrcAddr  = tcpip.Address(net.ParseIP("192.168.1.2").To4())
+	multipathDstAddr  = tcpip.Address(net.ParseIP("10.0.0.2").To4())
+	multipathGateways = map[tcpip.NICID]tcpip.Address{
+		multipathUplink1ID: tcpip.Address(net.ParseIP("172.16.2.2").To4()),
+		multipathUplink2ID: tcpip.Address(net.ParseIP("172.16.3.2").To4()),
+	}
+)
+
+// newMultipathRouter returns a router whose multipath default route balances
+// the flows with the L4 hash policy. The link addresses of the gateways of the
+// uplinks in staticGateways are static neighbors, the others are resolved with
+// ARP.
+func newMultipathRouter(t *testing.T, staticGateways ...tcpip.NICID) *multipathRouter {
+	t.Helper()
+
+	clock := faketime.NewManualClock()
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{arp.NewProtocol, ipv4.NewProtocol},
+		UseNeighborCache: true,
+		Clock:            clock,
+	})
+	if err := s.SetForwarding(ipv4.ProtocolNumber, true); err != nil {
+		t.Fatalf("SetForwarding(%d, true): %s", ipv4.ProtocolNumber, err)
+	}
+	if err := s.SetOption(stack.MultipathHashL4); err != nil {
+		t.Fatalf("SetOption(%d): %s", stack.MultipathHashL4, err)
+	}
+	eps := make(map[tcpip.NICID]*channel.Endpoint)
+	for i, id := range []tcpip.NICID{multipathDownlinkID, multipathUplink1ID, multipathUplink2ID} {
+		eps[id] = channel.New(256, defaultMTU, tcpip.LinkAddress([]byte{2, 0, 0, 0, 0, byte(id)}))
+		eps[id].LinkEPCapabilities |= stack.CapabilityResolutionRequired
+		if err := s.CreateNIC(id, eps[id]); err != nil {
+			t.Fatalf("CreateNIC(%d, _): %s", id, err)
+		}
+		addr := tcpip.ProtocolAddress{
+			Protocol: ipv4.ProtocolNumber,
+			AddressWithPrefix: tcpip.AddressWithPrefix{
+				Address:   tcpip.Address(net.IPv4(172, 16, byte(i+1), 1).To4()),
+				PrefixLen: 24,
+			},
+		}
+		if err := s.AddProtocolAddress(id, addr); err != nil {
+			t.Fatalf("AddProtocolAddress(%d, %+v): %s", id, addr, err)
+		}
+	}
+	for _, id := range staticGateways {
+		if err := s.AddStaticNeighbor(id, multipathGateways[id], "\x02\x00\x00\x00\x01\x00"); err != nil {
+			t.Fatalf("AddStaticNeighbor(%d, %s, _): %s", id, multipathGateways[id], err)
+		}
+	}
+	s.SetRouteTable([]tcpip.Route{
+		{Destination: header.IPv4EmptySubnet, Gateway: multipathGateways[multipathUplink1ID], NIC: multipathUplink1ID, Weight: 1},
+		{Destination: header.IPv4EmptySubnet, Gateway: multipathGateways[multipathUplink2ID], NIC: multipathUplink2ID, Weight: 1},
+	})
+	return &multipathRouter{s: s, clock: clock, eps: eps}
+}
+
+// forward injects a UDP datagram sent from srcPort through the downlink and
+// returns the uplink it is forwarded through, or 0 if it isn't forwarded yet.
+func (r *multipathRouter) forward(t *testing.T, srcPort uint16) tcpip.NICID {
+	t.Helper()
+
+	totalLen := header.IPv4MinimumSize + header.UDPMinimumSize
+	hdr := buffer.NewPrependable(totalLen)
+	udp := header.UDP(hdr.Prepend(header.UDPMinimumSize))
+	udp.Encode(&header.UDPFields{
+		SrcPort: srcPort,
+		DstPort: 53,
+		Length:  header.UDPMinimumSize,
+	})
+	ip := header.IPv4(hdr.Prepend(header.IPv4MinimumSize))
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(totalLen),
+		Protocol:    uint8(header.UDPProtocolNumber),
+		TTL:         ttl,
+		SrcAddr:     multipathSrcAddr,
+		DstAddr:     multipathDstAddr,
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+	r.eps[multipathDownlinkID].InjectInbound(header.IPv4ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: hdr.View().ToVectorisedView(),
+	}))
+
+	var uplink tcpip.NICID
+	for _, id := range []tcpip.NICID{multipathUplink1ID, multipathUplink2ID} {
+		for {
+			pkt, ok := r.eps[id].Read()
+			if !ok {
+				break
+			}
+			if pkt.Proto != header.IPv4ProtocolNumber {
+				continue
+			}
+			if uplink != 0 {
+				t.Fatalf("got datagram from port %d forwarded through NICs %d and %d", srcPort, uplink, id)
+			}
+			uplink = id
+		}
+	}
+	return uplink
+}
+
+// TestMultipathForwarding tests that the flows forwarded through a multipath
+// route are balanced between its next hops.
+func TestMultipathForwarding(t *testing.T) {
+	r := newMultipathRouter(t, multipathUplink1ID, multipathUplink2ID)
+
+	uplinks := make(map[uint16]tcpip.NICID)
+	counts := make(map[tcpip.NICID]int)
+	for port := uint16(1000); port < 1064; port++ {
+		uplink := r.forward(t, port)
+		if uplink == 0 {
+			t.Fatalf("got datagram from port %d not forwarded", port)
+		}
+		uplinks[port] = uplink
+		counts[uplink]++
+	}
+	for _, id := range []tcpip.NICID{multipathUplink1ID, multipathUplink2ID} {
+		if counts[id] == 0 {
+			t.Errorf("got no flow forwarded through NIC %d", id)
+		}
+	}
+
+	// Each flow is always forwarded through the same next hop.
+	for port, want := range uplinks {
+		if got := r.forward(t, port); got != want {
+			t.Errorf("got datagram from port %d forwarded through NIC %d, want = %d", port, got, want)
+		}
+	}
+}
+
+// TestMultipathForwardingFailedNextHop tests that the next hops of a multipath
+// route whose gateway is unreachable are not used.
+func TestMultipathForwardingFailedNextHop(t *testing.T) {
+	r := newMultipathRouter(t, multipathUplink2ID)
+
+	// Send datagrams until one waits for the resolution of the link address
+	// of the gateway of the first uplink, which fails.
+	for port := uint16(1000); r.forward(t, port) == multipathUplink2ID; port++ {
+	}
+	config := stack.DefaultNUDConfigurations()
+	r.clock.Advance(config.RetransmitTimer * time.Duration(config.MaxMulticastProbes))
+	neighbors, err := r.s.Neighbors(multipathUplink1ID)
+	if err != nil {
+		t.Fatalf("Neighbors(%d): %s", multipathUplink1ID, err)
+	}
+	if len(neighbors) != 1 || neighbors[0].State != stack.Failed {
+		t.Fatalf("got neighbors of NIC %d = %+v, want a failed gateway", multipathUplink1ID, neighbors)
+	}
+
+	for port := uint16(1000); port < 1064; port++ {
+		if got := r.forward(t, port); got != multipathUplink2ID {
+			t.Errorf("got datagram from port %d forwarded through NIC %d, want = %d", port, got, multipathUplink2ID)
+		}
+	}
+}
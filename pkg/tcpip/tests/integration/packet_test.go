@@ -0,0 +1,330 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/raw"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// testRing is a tcpip.PacketRing holding at most limit packets.
+type testRing struct {
+	limit   int
+	packets []buffer.View
+	addrs   []tcpip.FullAddress
+}
+
+// Deliver implements tcpip.PacketRing.Deliver.
+func (r *testRing) Deliver(data buffer.VectorisedView, addr tcpip.FullAddress, _ tcpip.LinkPacketInfo, _ int64) bool {
+	if len(r.packets) == r.limit {
+		return false
+	}
+	r.packets = append(r.packets, data.ToView())
+	r.addrs = append(r.addrs, addr)
+	return true
+}
+
+func newPacketTestStack(t *testing.T, nicID tcpip.NICID) (*stack.Stack, *channel.Endpoint) {
+	t.Helper()
+
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		RawFactory:       raw.EndpointFactory{},
+	})
+	e := channel.New(4, defaultMTU, linkAddr1)
+	if err := s.CreateNIC(nicID, e); err != nil {
+		t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+	}
+	return s, e
+}
+
+// TestPacketWrite tests that packets written to cooked and raw packet
+// endpoints are sent to the link address they are written to.
+func TestPacketWrite(t *testing.T) {
+	const nicID = 1
+	payload := buffer.View{1, 2, 3, 4}
+
+	tests := []struct {
+		name   string
+		cooked bool
+		data   buffer.View
+		to     tcpip.FullAddress
+	}{
+		{
+			name:   "Cooked",
+			cooked: true,
+			data:   payload,
+			to:     tcpip.FullAddress{NIC: nicID, Addr: tcpip.Address(linkAddr2)},
+		},
+		{
+			name: "Raw",
+			data: func() buffer.View {
+				eth := header.Ethernet(make([]byte, header.EthernetMinimumSize))
+				eth.Encode(&header.EthernetFields{
+					SrcAddr: linkAddr1,
+					DstAddr: linkAddr2,
+					Type:    header.IPv4ProtocolNumber,
+				})
+				return append(buffer.View(eth), payload...)
+			}(),
+			to: tcpip.FullAddress{NIC: nicID},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, e := newPacketTestStack(t, nicID)
+			var wq waiter.Queue
+			ep, err := s.NewPacketEndpoint(test.cooked, header.IPv4ProtocolNumber, &wq)
+			if err != nil {
+				t.Fatalf("NewPacketEndpoint(%t, %d, _): %s", test.cooked, header.IPv4ProtocolNumber, err)
+			}
+			defer ep.Close()
+
+			if _, _, err := ep.Write(tcpip.SlicePayload(test.data), tcpip.WriteOptions{}); err != tcpip.ErrDestinationRequired {
+				t.Errorf("got Write(_, {}) = %v, want = %s", err, tcpip.ErrDestinationRequired)
+			}
+
+			wOpts := tcpip.WriteOptions{To: &test.to}
+			n, _, err := ep.Write(tcpip.SlicePayload(test.data), wOpts)
+			if err != nil {
+				t.Fatalf("Write(_, %#v): %s", wOpts, err)
+			}
+			if want := int64(len(test.data)); n != want {
+				t.Errorf("got Write(_, %#v) = %d, want = %d", wOpts, n, want)
+			}
+
+			p, ok := e.Read()
+			if !ok {
+				t.Fatal("expected a packet to be sent")
+			}
+			if got := p.Route.RemoteLinkAddress(); got != linkAddr2 {
+				t.Errorf("got p.Route.RemoteLinkAddress() = %s, want = %s", got, linkAddr2)
+			}
+			if p.Proto != header.IPv4ProtocolNumber {
+				t.Errorf("got p.Proto = %d, want = %d", p.Proto, header.IPv4ProtocolNumber)
+			}
+			if got := p.Pkt.Data.ToView(); !bytes.Equal(got, payload) {
+				t.Errorf("got p.Pkt.Data = %x, want = %x", got, payload)
+			}
+		})
+	}
+}
+
+// TestPacketRing tests that the packets received by a packet endpoint are
+// delivered into the ring set with PacketRingOption instead of being queued,
+// and dropped when the ring is full.
+func TestPacketRing(t *testing.T) {
+	const nicID = 1
+	s, e := newPacketTestStack(t, nicID)
+	var wq waiter.Queue
+	ep, err := s.NewPacketEndpoint(true /* cooked */, header.IPv4ProtocolNumber, &wq)
+	if err != nil {
+		t.Fatalf("NewPacketEndpoint(true, %d, _): %s", header.IPv4ProtocolNumber, err)
+	}
+	defer ep.Close()
+
+	ring := testRing{limit: 1}
+	if err := ep.SetSockOpt(&tcpip.PacketRingOption{Ring: &ring}); err != nil {
+		t.Fatalf("SetSockOpt(&PacketRingOption{...}): %s", err)
+	}
+
+	rx := func(b byte) {
+		e.InjectInbound(header.IPv4ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Data: buffer.View{b}.ToVectorisedView(),
+		}))
+	}
+	rx(1)
+	rx(2)
+
+	if got, want := ring.packets, []buffer.View{{1}}; len(got) != len(want) || !bytes.Equal(got[0], want[0]) {
+		t.Fatalf("got ring.packets = %v, want = %v", got, want)
+	}
+	if got := ring.addrs[0].NIC; got != nicID {
+		t.Errorf("got ring.addrs[0].NIC = %d, want = %d", got, nicID)
+	}
+	if _, _, err := ep.Read(nil); err != tcpip.ErrWouldBlock {
+		t.Errorf("got Read(nil) = %v, want = %s", err, tcpip.ErrWouldBlock)
+	}
+	stats := ep.Stats().(*tcpip.TransportEndpointStats)
+	if got := stats.PacketsReceived.Value(); got != 1 {
+		t.Errorf("got PacketsReceived = %d, want = 1", got)
+	}
+	if got := stats.ReceiveErrors.ReceiveBufferOverflow.Value(); got != 1 {
+		t.Errorf("got ReceiveErrors.ReceiveBufferOverflow = %d, want = 1", got)
+	}
+
+	// Without a ring, the packets are queued to be read.
+	if err := ep.SetSockOpt(&tcpip.PacketRingOption{}); err != nil {
+		t.Fatalf("SetSockOpt(&PacketRingOption{}): %s", err)
+	}
+	rx(3)
+	if v, _, err := ep.Read(nil); err != nil {
+		t.Errorf("Read(nil): %s", err)
+	} else if want := (buffer.View{3}); !bytes.Equal(v, want) {
+		t.Errorf("got Read(nil) = %x, want = %x", v, want)
+	}
+}
+
+// TestPacketFanout tests that the packets received by the members of a fanout
+// group are each received by a single member, as distributed by the mode of
+// the group.
+func TestPacketFanout(t *testing.T) {
+	const (
+		nicID   = 1
+		groupID = 1
+		members = 2
+		packets = 8
+	)
+
+	rxUDP := func(e *channel.Endpoint, src, dst tcpip.Address, srcPort, dstPort uint16) {
+		hdr := buffer.NewPrependable(header.IPv4MinimumSize + header.UDPMinimumSize)
+		u := header.UDP(hdr.Prepend(header.UDPMinimumSize))
+		u.Encode(&header.UDPFields{
+			SrcPort: srcPort,
+			DstPort: dstPort,
+			Length:  header.UDPMinimumSize,
+		})
+		ip := header.IPv4(hdr.Prepend(header.IPv4MinimumSize))
+		ip.Encode(&header.IPv4Fields{
+			TotalLength: header.IPv4MinimumSize + header.UDPMinimumSize,
+			Protocol:    uint8(header.UDPProtocolNumber),
+			TTL:         ttl,
+			SrcAddr:     src,
+			DstAddr:     dst,
+		})
+		ip.SetChecksum(^ip.CalculateChecksum())
+		e.InjectInbound(header.IPv4ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Data: hdr.View().ToVectorisedView(),
+		}))
+	}
+
+	// received returns the number of packets each of eps received.
+	received := func(eps []tcpip.Endpoint) []int {
+		counts := make([]int, len(eps))
+		for i, ep := range eps {
+			for {
+				if _, _, err := ep.Read(nil); err != nil {
+					break
+				}
+				counts[i]++
+			}
+		}
+		return counts
+	}
+
+	newGroup := func(t *testing.T, s *stack.Stack, mode tcpip.PacketFanoutMode) []tcpip.Endpoint {
+		var eps []tcpip.Endpoint
+		for i := 0; i < members; i++ {
+			var wq waiter.Queue
+			ep, err := s.NewPacketEndpoint(true /* cooked */, header.IPv4ProtocolNumber, &wq)
+			if err != nil {
+				t.Fatalf("NewPacketEndpoint(true, %d, _): %s", header.IPv4ProtocolNumber, err)
+			}
+			t.Cleanup(ep.Close)
+			opt := tcpip.PacketFanoutOption{ID: groupID, Mode: mode}
+			if err := ep.SetSockOpt(&opt); err != nil {
+				t.Fatalf("SetSockOpt(%#v): %s", opt, err)
+			}
+			eps = append(eps, ep)
+		}
+		return eps
+	}
+
+	src := ipv4Addr2.AddressWithPrefix.Address
+	dst := ipv4Addr1.AddressWithPrefix.Address
+
+	t.Run("LB", func(t *testing.T) {
+		s, e := newPacketTestStack(t, nicID)
+		eps := newGroup(t, s, tcpip.PacketFanoutLB)
+		for i := 0; i < packets; i++ {
+			rxUDP(e, src, dst, 1000, 2000)
+		}
+		if got, want := received(eps), []int{packets / members, packets / members}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got packets received = %v, want = %v", got, want)
+		}
+	})
+
+	t.Run("Hash", func(t *testing.T) {
+		s, e := newPacketTestStack(t, nicID)
+		eps := newGroup(t, s, tcpip.PacketFanoutHash)
+
+		// Both directions of a flow are received by the same member.
+		for i := 0; i < packets/2; i++ {
+			rxUDP(e, src, dst, 1000, 2000)
+			rxUDP(e, dst, src, 2000, 1000)
+		}
+		counts := received(eps)
+		if got := counts[0] + counts[1]; got != packets {
+			t.Fatalf("got %d packets received, want = %d", got, packets)
+		}
+		if counts[0] != 0 && counts[1] != 0 {
+			t.Errorf("got packets received = %v, want all packets received by a single member", counts)
+		}
+
+		// Other flows are spread among the members.
+		for port := uint16(0); port < 64; port++ {
+			rxUDP(e, src, dst, port, 2000)
+		}
+		for i, n := range received(eps) {
+			if n == 0 {
+				t.Errorf("member %d received no packets of 64 flows", i)
+			}
+		}
+	})
+
+	t.Run("Join", func(t *testing.T) {
+		s, _ := newPacketTestStack(t, nicID)
+		eps := newGroup(t, s, tcpip.PacketFanoutLB)
+
+		var got tcpip.PacketFanoutOption
+		if err := eps[0].GetSockOpt(&got); err != nil {
+			t.Fatalf("GetSockOpt(&PacketFanoutOption{}): %s", err)
+		}
+		if want := (tcpip.PacketFanoutOption{ID: groupID, Mode: tcpip.PacketFanoutLB}); got != want {
+			t.Errorf("got GetSockOpt(&PacketFanoutOption{}) = %#v, want = %#v", got, want)
+		}
+
+		opt := tcpip.PacketFanoutOption{ID: groupID + 1}
+		if err := eps[0].SetSockOpt(&opt); err != tcpip.ErrAlreadyConnecting {
+			t.Errorf("got SetSockOpt(%#v) = %v from a member, want = %s", opt, err, tcpip.ErrAlreadyConnecting)
+		}
+
+		var wq waiter.Queue
+		ep, err := s.NewPacketEndpoint(true /* cooked */, header.IPv4ProtocolNumber, &wq)
+		if err != nil {
+			t.Fatalf("NewPacketEndpoint(true, %d, _): %s", header.IPv4ProtocolNumber, err)
+		}
+		defer ep.Close()
+		opt = tcpip.PacketFanoutOption{ID: groupID, Mode: tcpip.PacketFanoutHash}
+		if err := ep.SetSockOpt(&opt); err != tcpip.ErrInvalidOptionValue {
+			t.Errorf("got SetSockOpt(%#v) = %v with another mode, want = %s", opt, err, tcpip.ErrInvalidOptionValue)
+		}
+		addr := tcpip.FullAddress{NIC: nicID}
+		if err := eps[0].Bind(addr); err != tcpip.ErrInvalidEndpointState {
+			t.Errorf("got Bind(%#v) = %v from a member, want = %s", addr, err, tcpip.ErrInvalidEndpointState)
+		}
+	})
+}
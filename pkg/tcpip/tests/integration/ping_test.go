@@ -0,0 +1,204 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration_test
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/icmp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// TestPingICMPv6 tests that ICMPv6 echo replies and the errors caused by echo
+// requests are delivered to the ping endpoint which sent the echo requests, as
+// allowed by its ICMP6_FILTER socket option.
+func TestPingICMPv6(t *testing.T) {
+	const (
+		nicID  = 1
+		ident1 = 1
+		ident2 = 2
+	)
+
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv6.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{icmp.NewProtocol6},
+	})
+	e := channel.New(1, defaultMTU, "")
+	if err := s.CreateNIC(nicID, e); err != nil {
+		t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+	}
+	protoAddr := tcpip.ProtocolAddress{Protocol: header.IPv6ProtocolNumber, AddressWithPrefix: ipv6Addr}
+	if err := s.AddProtocolAddress(nicID, protoAddr); err != nil {
+		t.Fatalf("AddProtocolAddress(%d, %+v): %s", nicID, protoAddr, err)
+	}
+	s.SetRouteTable([]tcpip.Route{{Destination: header.IPv6EmptySubnet, NIC: nicID}})
+
+	newEndpoint := func(ident uint16) tcpip.Endpoint {
+		var wq waiter.Queue
+		ep, err := s.NewEndpoint(icmp.ProtocolNumber6, header.IPv6ProtocolNumber, &wq)
+		if err != nil {
+			t.Fatalf("NewEndpoint(%d, %d, _): %s", icmp.ProtocolNumber6, header.IPv6ProtocolNumber, err)
+		}
+		t.Cleanup(ep.Close)
+		addr := tcpip.FullAddress{Port: ident}
+		if err := ep.Bind(addr); err != nil {
+			t.Fatalf("Bind(%#v): %s", addr, err)
+		}
+		ep.SocketOptions().SetRecvError(true)
+		return ep
+	}
+	ep1 := newEndpoint(ident1)
+	ep2 := newEndpoint(ident2)
+
+	// Send an echo request from ep1, and keep it to be quoted by errors.
+	echo := header.ICMPv6(make([]byte, header.ICMPv6EchoMinimumSize))
+	echo.SetType(header.ICMPv6EchoRequest)
+	wOpts := tcpip.WriteOptions{To: &tcpip.FullAddress{Addr: remoteIPv6Addr}}
+	if _, _, err := ep1.Write(tcpip.SlicePayload(echo), wOpts); err != nil {
+		t.Fatalf("ep1.Write(_, %#v): %s", wOpts, err)
+	}
+	p, ok := e.Read()
+	if !ok {
+		t.Fatal("expected an echo request to be sent")
+	}
+	request := stack.PayloadSince(p.Pkt.NetworkHeader())
+
+	rxICMPv6 := func(typ header.ICMPv6Type, code header.ICMPv6Code, ident uint16, payload buffer.View) {
+		totalLen := header.IPv6MinimumSize + header.ICMPv6MinimumSize + len(payload)
+		hdr := buffer.NewPrependable(totalLen)
+		copy(hdr.Prepend(len(payload)), payload)
+		pkt := header.ICMPv6(hdr.Prepend(header.ICMPv6MinimumSize))
+		pkt.SetType(typ)
+		pkt.SetCode(code)
+		if typ == header.ICMPv6EchoReply {
+			pkt.SetIdent(ident)
+		}
+		pkt.SetChecksum(0)
+		pkt.SetChecksum(header.ICMPv6Checksum(pkt[:header.ICMPv6MinimumSize], remoteIPv6Addr, ipv6Addr.Address, payload.ToVectorisedView()))
+		ip := header.IPv6(hdr.Prepend(header.IPv6MinimumSize))
+		ip.Encode(&header.IPv6Fields{
+			PayloadLength: uint16(header.ICMPv6MinimumSize + len(payload)),
+			NextHeader:    uint8(icmp.ProtocolNumber6),
+			HopLimit:      ttl,
+			SrcAddr:       remoteIPv6Addr,
+			DstAddr:       ipv6Addr.Address,
+		})
+		e.InjectInbound(header.IPv6ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Data: hdr.View().ToVectorisedView(),
+		}))
+	}
+
+	setFilter := func(deny ...header.ICMPv6Type) {
+		var f tcpip.ICMPv6FilterOption
+		for _, typ := range deny {
+			f.DenyType[typ/32] |= 1 << (typ % 32)
+		}
+		if err := ep1.SetSockOpt(&f); err != nil {
+			t.Fatalf("ep1.SetSockOpt(%#v): %s", f, err)
+		}
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			typ      header.ICMPv6Type
+			code     header.ICMPv6Code
+			wantErr  *tcpip.Error
+			filtered bool
+		}{
+			{
+				name:    "Time exceeded",
+				typ:     header.ICMPv6TimeExceeded,
+				code:    header.ICMPv6HopLimitExceeded,
+				wantErr: tcpip.ErrNoRoute,
+			},
+			{
+				name:    "Address unreachable",
+				typ:     header.ICMPv6DstUnreachable,
+				code:    header.ICMPv6AddressUnreachable,
+				wantErr: tcpip.ErrNoRoute,
+			},
+			{
+				name:     "Filtered time exceeded",
+				typ:      header.ICMPv6TimeExceeded,
+				code:     header.ICMPv6HopLimitExceeded,
+				filtered: true,
+			},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				if test.filtered {
+					setFilter(test.typ)
+					defer setFilter()
+				}
+				rxICMPv6(test.typ, test.code, 0, request)
+
+				sockErr := ep1.SocketOptions().DequeueErr()
+				switch {
+				case test.filtered:
+					if sockErr != nil {
+						t.Fatalf("got ep1 DequeueErr() = %#v, want = nil", sockErr)
+					}
+				case sockErr == nil:
+					t.Fatal("got ep1 DequeueErr() = nil, want non-nil error")
+				default:
+					if sockErr.Err != test.wantErr {
+						t.Errorf("got sockErr.Err = %s, want = %s", sockErr.Err, test.wantErr)
+					}
+					if got, want := sockErr.ErrType, uint8(test.typ); got != want {
+						t.Errorf("got sockErr.ErrType = %d, want = %d", got, want)
+					}
+					if got, want := sockErr.ErrCode, uint8(test.code); got != want {
+						t.Errorf("got sockErr.ErrCode = %d, want = %d", got, want)
+					}
+					if got, want := sockErr.Offender.Addr, remoteIPv6Addr; got != want {
+						t.Errorf("got sockErr.Offender.Addr = %s, want = %s", got, want)
+					}
+				}
+				if sockErr := ep2.SocketOptions().DequeueErr(); sockErr != nil {
+					t.Errorf("got ep2 DequeueErr() = %#v, want = nil", sockErr)
+				}
+			})
+		}
+	})
+
+	t.Run("Echo replies", func(t *testing.T) {
+		for _, filtered := range []bool{false, true} {
+			if filtered {
+				setFilter(header.ICMPv6EchoReply)
+				defer setFilter()
+			}
+			rxICMPv6(header.ICMPv6EchoReply, 0, ident1, nil)
+
+			_, _, err := ep1.Read(nil)
+			if filtered {
+				if err != tcpip.ErrWouldBlock {
+					t.Errorf("got ep1.Read(nil) = %s with filtered echo replies, want = %s", err, tcpip.ErrWouldBlock)
+				}
+			} else if err != nil {
+				t.Errorf("ep1.Read(nil): %s", err)
+			}
+			if _, _, err := ep2.Read(nil); err != tcpip.ErrWouldBlock {
+				t.Errorf("got ep2.Read(nil) = %s, want = %s", err, tcpip.ErrWouldBlock)
+			}
+		}
+	})
+}
@@ -0,0 +1,148 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration_test
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/icmp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/raw"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// TestRawIPv6Checksum tests that raw IPv6 endpoints compute and validate the
+// checksum at the offset set with the IPV6_CHECKSUM socket option.
+func TestRawIPv6Checksum(t *testing.T) {
+	const (
+		nicID = 1
+
+		// checksumOffset is the offset of the checksum in the UDP header.
+		checksumOffset = 6
+	)
+
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv6.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol, icmp.NewProtocol6},
+		RawFactory:         raw.EndpointFactory{},
+	})
+	e := channel.New(4, defaultMTU, "")
+	if err := s.CreateNIC(nicID, e); err != nil {
+		t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+	}
+	protoAddr := tcpip.ProtocolAddress{Protocol: header.IPv6ProtocolNumber, AddressWithPrefix: ipv6Addr}
+	if err := s.AddProtocolAddress(nicID, protoAddr); err != nil {
+		t.Fatalf("AddProtocolAddress(%d, %+v): %s", nicID, protoAddr, err)
+	}
+	s.SetRouteTable([]tcpip.Route{{Destination: header.IPv6EmptySubnet, NIC: nicID}})
+
+	newEndpoint := func(transProto tcpip.TransportProtocolNumber) tcpip.Endpoint {
+		var wq waiter.Queue
+		ep, err := s.NewRawEndpoint(transProto, header.IPv6ProtocolNumber, &wq, true /* associated */)
+		if err != nil {
+			t.Fatalf("NewRawEndpoint(%d, %d, _, true): %s", transProto, header.IPv6ProtocolNumber, err)
+		}
+		t.Cleanup(ep.Close)
+		return ep
+	}
+
+	t.Run("ICMPv6", func(t *testing.T) {
+		ep := newEndpoint(header.ICMPv6ProtocolNumber)
+		if v, err := ep.GetSockOptInt(tcpip.IPv6ChecksumOption); err != nil || v != header.ICMPv6ChecksumOffset {
+			t.Errorf("got GetSockOptInt(IPv6ChecksumOption) = (%d, %v), want = (%d, nil)", v, err, header.ICMPv6ChecksumOffset)
+		}
+		if err := ep.SetSockOptInt(tcpip.IPv6ChecksumOption, -1); err != tcpip.ErrInvalidOptionValue {
+			t.Errorf("got SetSockOptInt(IPv6ChecksumOption, -1) = %v, want = %s", err, tcpip.ErrInvalidOptionValue)
+		}
+	})
+
+	ep := newEndpoint(udp.ProtocolNumber)
+	if v, err := ep.GetSockOptInt(tcpip.IPv6ChecksumOption); err != nil || v != -1 {
+		t.Errorf("got GetSockOptInt(IPv6ChecksumOption) = (%d, %v), want = (-1, nil)", v, err)
+	}
+	if err := ep.SetSockOptInt(tcpip.IPv6ChecksumOption, checksumOffset+1); err != tcpip.ErrInvalidOptionValue {
+		t.Errorf("got SetSockOptInt(IPv6ChecksumOption, %d) = %v, want = %s", checksumOffset+1, err, tcpip.ErrInvalidOptionValue)
+	}
+	if err := ep.SetSockOptInt(tcpip.IPv6ChecksumOption, checksumOffset); err != nil {
+		t.Fatalf("SetSockOptInt(IPv6ChecksumOption, %d): %s", checksumOffset, err)
+	}
+
+	t.Run("Send", func(t *testing.T) {
+		payload := buffer.View{0, 1, 0, 2, 0, 12, 0xaa, 0xbb, 3, 4, 5, 6}
+		wOpts := tcpip.WriteOptions{To: &tcpip.FullAddress{Addr: remoteIPv6Addr}}
+		if _, _, err := ep.Write(tcpip.SlicePayload(payload), wOpts); err != nil {
+			t.Fatalf("Write(_, %#v): %s", wOpts, err)
+		}
+		p, ok := e.Read()
+		if !ok {
+			t.Fatal("expected a packet to be sent")
+		}
+		sent := stack.PayloadSince(p.Pkt.NetworkHeader())[header.IPv6MinimumSize:]
+		xsum := header.PseudoHeaderChecksum(udp.ProtocolNumber, ipv6Addr.Address, remoteIPv6Addr, uint16(len(sent)))
+		if got := header.Checksum(sent, xsum); got != 0xffff {
+			t.Errorf("got checksum of the packet sent = %#x, want = 0xffff", got)
+		}
+
+		short := tcpip.SlicePayload(payload[:checksumOffset+1])
+		if _, _, err := ep.Write(short, wOpts); err != tcpip.ErrInvalidOptionValue {
+			t.Errorf("got Write(%v, %#v) = %v, want = %s", short, wOpts, err, tcpip.ErrInvalidOptionValue)
+		}
+	})
+
+	t.Run("Receive", func(t *testing.T) {
+		rx := func(corrupt bool) {
+			payload := buffer.View{0, 1, 0, 2, 0, 12, 0, 0, 3, 4, 5, 6}
+			xsum := header.PseudoHeaderChecksum(udp.ProtocolNumber, remoteIPv6Addr, ipv6Addr.Address, uint16(len(payload)))
+			binary.BigEndian.PutUint16(payload[checksumOffset:], ^header.Checksum(payload, xsum))
+			if corrupt {
+				payload[len(payload)-1]++
+			}
+			hdr := buffer.NewPrependable(header.IPv6MinimumSize)
+			ip := header.IPv6(hdr.Prepend(header.IPv6MinimumSize))
+			ip.Encode(&header.IPv6Fields{
+				PayloadLength: uint16(len(payload)),
+				NextHeader:    uint8(udp.ProtocolNumber),
+				HopLimit:      ttl,
+				SrcAddr:       remoteIPv6Addr,
+				DstAddr:       ipv6Addr.Address,
+			})
+			vv := hdr.View().ToVectorisedView()
+			vv.AppendView(payload)
+			e.InjectInbound(header.IPv6ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+				Data: vv,
+			}))
+		}
+
+		rx(true /* corrupt */)
+		if _, _, err := ep.Read(nil); err != tcpip.ErrWouldBlock {
+			t.Errorf("got Read(nil) = %v with a bad checksum, want = %s", err, tcpip.ErrWouldBlock)
+		}
+		if got := ep.Stats().(*tcpip.TransportEndpointStats).ReceiveErrors.ChecksumErrors.Value(); got != 1 {
+			t.Errorf("got ReceiveErrors.ChecksumErrors = %d, want = 1", got)
+		}
+
+		rx(false /* corrupt */)
+		if _, _, err := ep.Read(nil); err != nil {
+			t.Errorf("Read(nil): %s", err)
+		}
+	})
+}
@@ -0,0 +1,123 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration_test
+
+import (
+	"net"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// TestRouteRulesForwarding tests that forwarded packets are routed with the
+// route table selected by the routing policy rules matching them.
+func TestRouteRulesForwarding(t *testing.T) {
+	const (
+		nic1ID    = 1
+		nic2ID    = 2
+		uplink1ID = 3
+		uplink2ID = 4
+
+		uplink2Table = 100
+	)
+	parseAddr := func(s string) tcpip.Address {
+		return tcpip.Address(net.ParseIP(s).To4())
+	}
+	remoteAddr := parseAddr("10.0.0.2")
+	uplink2Subnet := tcpip.AddressWithPrefix{Address: parseAddr("192.168.2.0"), PrefixLen: 24}.Subnet()
+
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+	})
+	if err := s.SetForwarding(ipv4.ProtocolNumber, true); err != nil {
+		t.Fatalf("SetForwarding(%d, true): %s", ipv4.ProtocolNumber, err)
+	}
+	eps := make(map[tcpip.NICID]*channel.Endpoint)
+	for i, id := range []tcpip.NICID{nic1ID, nic2ID, uplink1ID, uplink2ID} {
+		eps[id] = channel.New(1, defaultMTU, "")
+		if err := s.CreateNIC(id, eps[id]); err != nil {
+			t.Fatalf("CreateNIC(%d, _): %s", id, err)
+		}
+		addr := tcpip.ProtocolAddress{
+			Protocol: ipv4.ProtocolNumber,
+			AddressWithPrefix: tcpip.AddressWithPrefix{
+				Address:   tcpip.Address(net.IPv4(172, 16, byte(i), 1).To4()),
+				PrefixLen: 24,
+			},
+		}
+		if err := s.AddProtocolAddress(id, addr); err != nil {
+			t.Fatalf("AddProtocolAddress(%d, %+v): %s", id, addr, err)
+		}
+	}
+	s.SetRouteTable([]tcpip.Route{
+		{Destination: header.IPv4EmptySubnet, NIC: uplink1ID},
+		{Destination: header.IPv4EmptySubnet, NIC: uplink2ID, Table: uplink2Table},
+	})
+	for _, rule := range []tcpip.RouteRule{
+		{Priority: 100, NetProto: ipv4.ProtocolNumber, InputNIC: nic2ID, Table: uplink2Table},
+		{Priority: 200, NetProto: ipv4.ProtocolNumber, Source: uplink2Subnet, Table: uplink2Table},
+		{Priority: 300, NetProto: ipv4.ProtocolNumber, TOS: 0x10, Table: uplink2Table},
+	} {
+		if err := s.AddRouteRule(rule); err != nil {
+			t.Fatalf("AddRouteRule(%s): %s", rule, err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		nicID      tcpip.NICID
+		srcAddr    tcpip.Address
+		tos        uint8
+		wantUplink tcpip.NICID
+	}{
+		{name: "Main table", nicID: nic1ID, srcAddr: parseAddr("192.168.1.2"), wantUplink: uplink1ID},
+		{name: "Input NIC", nicID: nic2ID, srcAddr: parseAddr("192.168.1.2"), wantUplink: uplink2ID},
+		{name: "Source", nicID: nic1ID, srcAddr: parseAddr("192.168.2.2"), wantUplink: uplink2ID},
+		{name: "TOS", nicID: nic1ID, srcAddr: parseAddr("192.168.1.2"), tos: 0x10, wantUplink: uplink2ID},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload := buffer.View{1, 2, 3, 4}
+			totalLen := header.IPv4MinimumSize + len(payload)
+			hdr := buffer.NewPrependable(totalLen)
+			copy(hdr.Prepend(len(payload)), payload)
+			ip := header.IPv4(hdr.Prepend(header.IPv4MinimumSize))
+			ip.Encode(&header.IPv4Fields{
+				TOS:         test.tos,
+				TotalLength: uint16(totalLen),
+				Protocol:    uint8(header.UDPProtocolNumber),
+				TTL:         ttl,
+				SrcAddr:     test.srcAddr,
+				DstAddr:     remoteAddr,
+			})
+			ip.SetChecksum(^ip.CalculateChecksum())
+			eps[test.nicID].InjectInbound(header.IPv4ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+				Data: hdr.View().ToVectorisedView(),
+			}))
+
+			for _, id := range []tcpip.NICID{uplink1ID, uplink2ID} {
+				_, ok := eps[id].Read()
+				if want := id == test.wantUplink; ok != want {
+					t.Errorf("got packet forwarded through NIC %d = %t, want = %t", id, ok, want)
+				}
+			}
+		})
+	}
+}
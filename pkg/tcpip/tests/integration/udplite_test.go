@@ -0,0 +1,196 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration_test
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// TestUDPLite tests that UDP-Lite endpoints send and receive datagrams whose
+// checksum covers as many bytes as set with the checksum coverage options.
+func TestUDPLite(t *testing.T) {
+	const (
+		nicID      = 1
+		localPort  = 1000
+		remotePort = 2000
+		csCov      = 12
+	)
+	localAddr := ipv4Addr1.AddressWithPrefix.Address
+	remoteAddr := ipv4Addr2.AddressWithPrefix.Address
+
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol, udp.NewLiteProtocol},
+	})
+	e := channel.New(1, defaultMTU, "")
+	if err := s.CreateNIC(nicID, e); err != nil {
+		t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+	}
+	if err := s.AddProtocolAddress(nicID, ipv4Addr1); err != nil {
+		t.Fatalf("AddProtocolAddress(%d, %+v): %s", nicID, ipv4Addr1, err)
+	}
+	s.SetRouteTable([]tcpip.Route{{Destination: header.IPv4EmptySubnet, NIC: nicID}})
+
+	newEndpoint := func(transProto tcpip.TransportProtocolNumber) tcpip.Endpoint {
+		var wq waiter.Queue
+		ep, err := s.NewEndpoint(transProto, header.IPv4ProtocolNumber, &wq)
+		if err != nil {
+			t.Fatalf("NewEndpoint(%d, %d, _): %s", transProto, header.IPv4ProtocolNumber, err)
+		}
+		t.Cleanup(ep.Close)
+		return ep
+	}
+
+	t.Run("UDP", func(t *testing.T) {
+		ep := newEndpoint(udp.ProtocolNumber)
+		if err := ep.SetSockOptInt(tcpip.UDPLiteSendCsCovOption, csCov); err != tcpip.ErrUnknownProtocolOption {
+			t.Errorf("got SetSockOptInt(UDPLiteSendCsCovOption, %d) = %v, want = %s", csCov, err, tcpip.ErrUnknownProtocolOption)
+		}
+	})
+
+	ep := newEndpoint(udp.LiteProtocolNumber)
+	bindAddr := tcpip.FullAddress{Port: localPort}
+	if err := ep.Bind(bindAddr); err != nil {
+		t.Fatalf("Bind(%#v): %s", bindAddr, err)
+	}
+
+	t.Run("Options", func(t *testing.T) {
+		for _, opt := range []tcpip.SockOptInt{tcpip.UDPLiteSendCsCovOption, tcpip.UDPLiteRecvCsCovOption} {
+			for _, test := range []struct{ set, want int }{
+				{set: 1, want: header.UDPMinimumSize},
+				{set: 1 << 16, want: header.UDPMaximumSize},
+				{set: 0, want: 0},
+			} {
+				if err := ep.SetSockOptInt(opt, test.set); err != nil {
+					t.Fatalf("SetSockOptInt(%d, %d): %s", opt, test.set, err)
+				}
+				if v, err := ep.GetSockOptInt(opt); err != nil || v != test.want {
+					t.Errorf("got GetSockOptInt(%d) = (%d, %v) after setting %d, want = (%d, nil)", opt, v, err, test.set, test.want)
+				}
+			}
+		}
+	})
+
+	t.Run("Send", func(t *testing.T) {
+		if err := ep.SetSockOptInt(tcpip.UDPLiteSendCsCovOption, csCov); err != nil {
+			t.Fatalf("SetSockOptInt(UDPLiteSendCsCovOption, %d): %s", csCov, err)
+		}
+		defer func() {
+			if err := ep.SetSockOptInt(tcpip.UDPLiteSendCsCovOption, 0); err != nil {
+				t.Fatalf("SetSockOptInt(UDPLiteSendCsCovOption, 0): %s", err)
+			}
+		}()
+
+		payload := buffer.View{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+		wOpts := tcpip.WriteOptions{To: &tcpip.FullAddress{Addr: remoteAddr, Port: remotePort}}
+		if _, _, err := ep.Write(tcpip.SlicePayload(payload), wOpts); err != nil {
+			t.Fatalf("Write(_, %#v): %s", wOpts, err)
+		}
+		p, ok := e.Read()
+		if !ok {
+			t.Fatal("expected a packet to be sent")
+		}
+		ip := header.IPv4(stack.PayloadSince(p.Pkt.NetworkHeader()))
+		if got := tcpip.TransportProtocolNumber(ip.Protocol()); got != udp.LiteProtocolNumber {
+			t.Errorf("got ip.Protocol() = %d, want = %d", got, udp.LiteProtocolNumber)
+		}
+		sent := header.UDP(ip.Payload())
+		if got := sent.Length(); got != csCov {
+			t.Errorf("got sent.Length() = %d, want = %d", got, csCov)
+		}
+		xsum := header.PseudoHeaderChecksum(udp.LiteProtocolNumber, localAddr, remoteAddr, uint16(len(sent)))
+		if got := header.Checksum(sent[:csCov], xsum); got != 0xffff {
+			t.Errorf("got checksum of the covered bytes = %#x, want = 0xffff", got)
+		}
+	})
+
+	t.Run("Receive", func(t *testing.T) {
+		// rx injects a datagram whose checksum covers csCov bytes. The
+		// checksum is computed over cover bytes, and set to 0 if cover is 0.
+		rx := func(csCov, cover uint16) {
+			payload := buffer.View{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+			totalLen := header.IPv4MinimumSize + header.UDPMinimumSize + len(payload)
+			hdr := buffer.NewPrependable(totalLen)
+			copy(hdr.Prepend(len(payload)), payload)
+			u := header.UDP(hdr.Prepend(header.UDPMinimumSize))
+			u.Encode(&header.UDPFields{
+				SrcPort: remotePort,
+				DstPort: localPort,
+				Length:  csCov,
+			})
+			if cover != 0 {
+				datagram := hdr.View()
+				xsum := header.PseudoHeaderChecksum(udp.LiteProtocolNumber, remoteAddr, localAddr, uint16(len(datagram)))
+				u.SetChecksum(^header.Checksum(datagram[:cover], xsum))
+			}
+			ip := header.IPv4(hdr.Prepend(header.IPv4MinimumSize))
+			ip.Encode(&header.IPv4Fields{
+				TotalLength: uint16(totalLen),
+				Protocol:    uint8(udp.LiteProtocolNumber),
+				TTL:         ttl,
+				SrcAddr:     remoteAddr,
+				DstAddr:     localAddr,
+			})
+			ip.SetChecksum(^ip.CalculateChecksum())
+			e.InjectInbound(header.IPv4ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+				Data: hdr.View().ToVectorisedView(),
+			}))
+		}
+		const size = header.UDPMinimumSize + 10
+
+		tests := []struct {
+			name      string
+			csCov     uint16
+			cover     uint16
+			recvCsCov int
+			received  bool
+		}{
+			{name: "Full coverage", csCov: 0, cover: size, received: true},
+			{name: "Partial coverage", csCov: csCov, cover: csCov, received: true},
+			{name: "Partial coverage allowed", csCov: csCov, cover: csCov, recvCsCov: csCov, received: true},
+			{name: "Full coverage above minimum", csCov: 0, cover: size, recvCsCov: size + 1, received: true},
+			{name: "Partial coverage below minimum", csCov: csCov, cover: csCov, recvCsCov: csCov + 1},
+			{name: "Bad checksum", csCov: csCov, cover: csCov + 2},
+			{name: "No checksum", csCov: csCov},
+			{name: "Coverage below header", csCov: header.UDPMinimumSize - 1, cover: header.UDPMinimumSize - 1},
+			{name: "Coverage above length", csCov: size + 2, cover: size},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				if err := ep.SetSockOptInt(tcpip.UDPLiteRecvCsCovOption, test.recvCsCov); err != nil {
+					t.Fatalf("SetSockOptInt(UDPLiteRecvCsCovOption, %d): %s", test.recvCsCov, err)
+				}
+				rx(test.csCov, test.cover)
+				_, _, err := ep.Read(nil)
+				if test.received {
+					if err != nil {
+						t.Errorf("Read(nil): %s", err)
+					}
+				} else if err != tcpip.ErrWouldBlock {
+					t.Errorf("got Read(nil) = %v, want = %s", err, tcpip.ErrWouldBlock)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,208 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration_test
+
+import (
+	"bytes"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/link/ethernet"
+	"gvisor.dev/gvisor/pkg/tcpip/network/arp"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// TestVLAN tests that VLAN NICs tag the packets they send and receive the
+// packets tagged with their VLAN.
+func TestVLAN(t *testing.T) {
+	const (
+		parentID   = 1
+		localPort  = 1000
+		remotePort = 2000
+	)
+
+	type tag struct {
+		protocol tcpip.NetworkProtocolNumber
+		id       uint16
+	}
+	tests := []struct {
+		name string
+		// tags are the tags of the VLAN NICs, from the outermost.
+		tags []tag
+	}{
+		{
+			name: "802.1Q",
+			tags: []tag{{protocol: header.VLANProtocol8021Q, id: 10}},
+		},
+		{
+			name: "QinQ",
+			tags: []tag{
+				{protocol: header.VLANProtocol8021AD, id: 100},
+				{protocol: header.VLANProtocol8021Q, id: 10},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := stack.New(stack.Options{
+				NetworkProtocols:   []stack.NetworkProtocolFactory{arp.NewProtocol, ipv4.NewProtocol},
+				TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+			})
+			e := channel.New(1, defaultMTU, linkAddr1)
+			if err := s.CreateNIC(parentID, ethernet.New(e)); err != nil {
+				t.Fatalf("CreateNIC(%d, _): %s", parentID, err)
+			}
+			nicID := tcpip.NICID(parentID)
+			for _, tag := range test.tags {
+				parent := nicID
+				nicID++
+				if err := s.CreateVLAN(nicID, parent, tag.id, stack.VLANOptions{Protocol: tag.protocol}); err != nil {
+					t.Fatalf("CreateVLAN(%d, %d, %d, _): %s", nicID, parent, tag.id, err)
+				}
+				if err := s.CreateVLAN(nicID+1, parent, tag.id, stack.VLANOptions{Protocol: tag.protocol}); err != tcpip.ErrDuplicateNICID {
+					t.Fatalf("got CreateVLAN(%d, %d, %d, _) = %v, want = %s", nicID+1, parent, tag.id, err, tcpip.ErrDuplicateNICID)
+				}
+				want := stack.VLANInfo{Parent: parent, ID: tag.id, Protocol: tag.protocol}
+				if got, err := s.GetVLANInfo(nicID); err != nil || got != want {
+					t.Fatalf("got GetVLANInfo(%d) = (%#v, %v), want = (%#v, nil)", nicID, got, err, want)
+				}
+			}
+			if err := s.AddProtocolAddress(nicID, ipv4Addr1); err != nil {
+				t.Fatalf("AddProtocolAddress(%d, %+v): %s", nicID, ipv4Addr1, err)
+			}
+			s.SetRouteTable([]tcpip.Route{{Destination: ipv4Addr1.AddressWithPrefix.Subnet(), NIC: nicID}})
+			remoteAddr := ipv4Addr2.AddressWithPrefix.Address
+			if err := s.AddStaticNeighbor(nicID, remoteAddr, linkAddr2); err != nil {
+				t.Fatalf("AddStaticNeighbor(%d, %s, %s): %s", nicID, remoteAddr, linkAddr2, err)
+			}
+
+			var wq waiter.Queue
+			ep, err := s.NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+			if err != nil {
+				t.Fatalf("NewEndpoint(%d, %d, _): %s", udp.ProtocolNumber, ipv4.ProtocolNumber, err)
+			}
+			defer ep.Close()
+			bindAddr := tcpip.FullAddress{Port: localPort}
+			if err := ep.Bind(bindAddr); err != nil {
+				t.Fatalf("Bind(%#v): %s", bindAddr, err)
+			}
+
+			// Packets are sent with the tags of the VLAN NICs.
+			payload := []byte{1, 2, 3, 4}
+			wOpts := tcpip.WriteOptions{To: &tcpip.FullAddress{Addr: remoteAddr, Port: remotePort}}
+			if _, _, err := ep.Write(tcpip.SlicePayload(payload), wOpts); err != nil {
+				t.Fatalf("Write(_, %#v): %s", wOpts, err)
+			}
+			p, ok := e.Read()
+			if !ok {
+				t.Fatal("got no packet sent through the parent NIC")
+			}
+			vv := buffer.NewVectorisedView(p.Pkt.Size(), p.Pkt.Views())
+			frame := vv.ToView()
+			eth := header.Ethernet(frame)
+			if got, want := eth.Type(), test.tags[0].protocol; got != want {
+				t.Fatalf("got eth.Type() = %#x, want = %#x", got, want)
+			}
+			if got := eth.DestinationAddress(); got != linkAddr2 {
+				t.Errorf("got eth.DestinationAddress() = %s, want = %s", got, linkAddr2)
+			}
+			b := frame[header.EthernetMinimumSize:]
+			for i, tag := range test.tags {
+				vlan := header.VLAN(b)
+				if got := vlan.ID(); got != tag.id {
+					t.Errorf("got tag %d ID() = %d, want = %d", i, got, tag.id)
+				}
+				want := header.IPv4ProtocolNumber
+				if i+1 < len(test.tags) {
+					want = test.tags[i+1].protocol
+				}
+				if got := vlan.Type(); got != want {
+					t.Errorf("got tag %d Type() = %#x, want = %#x", i, got, want)
+				}
+				b = b[header.VLANMinimumSize:]
+			}
+			if got := header.IPv4(b).DestinationAddress(); got != remoteAddr {
+				t.Errorf("got DestinationAddress() = %s, want = %s", got, remoteAddr)
+			}
+
+			// Packets with the tags of the VLAN NICs are received by the
+			// innermost one.
+			udpLen := header.UDPMinimumSize + len(payload)
+			ipLen := header.IPv4MinimumSize + udpLen
+			tagsLen := len(test.tags) * header.VLANMinimumSize
+			hdr := buffer.NewPrependable(header.EthernetMinimumSize + tagsLen + ipLen)
+			copy(hdr.Prepend(len(payload)), payload)
+			header.UDP(hdr.Prepend(header.UDPMinimumSize)).Encode(&header.UDPFields{
+				SrcPort: remotePort,
+				DstPort: localPort,
+				Length:  uint16(udpLen),
+			})
+			ip := header.IPv4(hdr.Prepend(header.IPv4MinimumSize))
+			ip.Encode(&header.IPv4Fields{
+				TotalLength: uint16(ipLen),
+				Protocol:    uint8(udp.ProtocolNumber),
+				TTL:         ttl,
+				SrcAddr:     remoteAddr,
+				DstAddr:     ipv4Addr1.AddressWithPrefix.Address,
+			})
+			ip.SetChecksum(^ip.CalculateChecksum())
+			inner := header.IPv4ProtocolNumber
+			for i := len(test.tags) - 1; i >= 0; i-- {
+				header.VLAN(hdr.Prepend(header.VLANMinimumSize)).Encode(&header.VLANFields{
+					ID:   test.tags[i].id,
+					Type: inner,
+				})
+				inner = test.tags[i].protocol
+			}
+			header.Ethernet(hdr.Prepend(header.EthernetMinimumSize)).Encode(&header.EthernetFields{
+				SrcAddr: linkAddr2,
+				DstAddr: linkAddr1,
+				Type:    inner,
+			})
+			e.InjectInbound(0, stack.NewPacketBuffer(stack.PacketBufferOptions{
+				Data: hdr.View().ToVectorisedView(),
+			}))
+
+			var addr tcpip.FullAddress
+			v, _, err := ep.Read(&addr)
+			if err != nil {
+				t.Fatalf("Read(_): %s", err)
+			}
+			if !bytes.Equal(v, payload) {
+				t.Errorf("got Read(_) = %x, want = %x", v, payload)
+			}
+			if want := (tcpip.FullAddress{NIC: nicID, Addr: remoteAddr, Port: remotePort}); addr != want {
+				t.Errorf("got Read(_) from %#v, want = %#v", addr, want)
+			}
+
+			// The VLAN NICs are removed with their parent.
+			if err := s.RemoveNIC(parentID); err != nil {
+				t.Fatalf("RemoveNIC(%d): %s", parentID, err)
+			}
+			for id := tcpip.NICID(parentID); id <= nicID; id++ {
+				if s.HasNIC(id) {
+					t.Errorf("got HasNIC(%d) = true after removing NIC %d, want = false", id, parentID)
+				}
+			}
+		})
+	}
+}
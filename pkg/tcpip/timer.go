@@ -204,3 +204,14 @@ func NewJob(c Clock, l sync.Locker, f func()) *Job {
 		fn:     f,
 	}
 }
+
+// ChanAfter returns a channel that is closed after d elapses on c, and a
+// function to stop the timer.
+//
+// It lets goroutines wait for timers of the stack's clock in select
+// statements, like time.After does for the real clock.
+func ChanAfter(c Clock, d time.Duration) (<-chan struct{}, func()) {
+	ch := make(chan struct{})
+	t := c.AfterFunc(d, func() { close(ch) })
+	return ch, func() { t.Stop() }
+}
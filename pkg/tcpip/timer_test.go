@@ -20,6 +20,7 @@ import (
 	"time"
 
 	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
 )
 
 const (
@@ -266,3 +267,31 @@ func TestManyJobReschedulesUnderLock(t *testing.T) {
 	case <-time.After(middleDuration):
 	}
 }
+
+func TestChanAfter(t *testing.T) {
+	clock := faketime.NewManualClock()
+
+	expired := func(ch <-chan struct{}) bool {
+		select {
+		case <-ch:
+			return true
+		default:
+			return false
+		}
+	}
+
+	ch, _ := tcpip.ChanAfter(clock, time.Second)
+	stoppedCh, stop := tcpip.ChanAfter(clock, time.Second)
+	stop()
+	clock.Advance(time.Second - 1)
+	if expired(ch) {
+		t.Fatal("channel closed before its timer expired")
+	}
+	clock.Advance(1)
+	if !expired(ch) {
+		t.Error("channel not closed after its timer expired")
+	}
+	if expired(stoppedCh) {
+		t.Error("channel of a stopped timer closed")
+	}
+}
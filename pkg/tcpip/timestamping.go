@@ -0,0 +1,204 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcpip
+
+import (
+	"sync/atomic"
+)
+
+// TimestampingFlags are the SO_TIMESTAMPING flags of a socket. They have the
+// values of Linux's SOF_TIMESTAMPING_* flags.
+type TimestampingFlags uint32
+
+// Timestamping flags, as described in Linux's
+// Documentation/networking/timestamping.rst. Only software timestamps are
+// generated; the hardware flags are accepted but have no effect.
+const (
+	// TimestampingTxHardware requests hardware transmit timestamps.
+	TimestampingTxHardware TimestampingFlags = 1 << iota
+
+	// TimestampingTxSoftware requests a timestamp when a send leaves the
+	// stack (SCM_TSTAMP_SND).
+	TimestampingTxSoftware
+
+	// TimestampingRxHardware requests hardware receive timestamps.
+	TimestampingRxHardware
+
+	// TimestampingRxSoftware requests receive timestamps.
+	TimestampingRxSoftware
+
+	// TimestampingSoftware reports software timestamps.
+	TimestampingSoftware
+
+	// TimestampingSysHardware is deprecated and ignored.
+	TimestampingSysHardware
+
+	// TimestampingRawHardware reports hardware timestamps.
+	TimestampingRawHardware
+
+	// TimestampingOptID correlates each transmit timestamp with its send
+	// through a key.
+	TimestampingOptID
+
+	// TimestampingTxSched requests a timestamp when a send is handed to
+	// the network layer (SCM_TSTAMP_SCHED).
+	TimestampingTxSched
+
+	// TimestampingTxAck requests a timestamp when all the data of a send
+	// is acknowledged (SCM_TSTAMP_ACK). It only applies to TCP.
+	TimestampingTxAck
+
+	// TimestampingOptCmsg reports IP_PKTINFO with transmit timestamps.
+	TimestampingOptCmsg
+
+	// TimestampingOptTsonly reports transmit timestamps without the data
+	// of the send.
+	TimestampingOptTsonly
+
+	// TimestampingOptStats reports statistics with transmit timestamps.
+	TimestampingOptStats
+
+	// TimestampingOptPktinfo reports the receiving interface with receive
+	// timestamps.
+	TimestampingOptPktinfo
+
+	// TimestampingOptTxSwhw reports both software and hardware transmit
+	// timestamps.
+	TimestampingOptTxSwhw
+
+	// TimestampingMask is the mask of all the timestamping flags.
+	TimestampingMask = TimestampingOptTxSwhw<<1 - 1
+
+	// TimestampingTxFlags is the mask of the flags requesting software
+	// transmit timestamps.
+	TimestampingTxFlags = TimestampingTxSoftware | TimestampingTxSched | TimestampingTxAck
+)
+
+// TimestampType is the type of a transmit timestamp, which is reported in the
+// ErrInfo of its notification. Its values are those of Linux's SCM_TSTAMP_*.
+type TimestampType uint32
+
+// Types of transmit timestamps.
+const (
+	// TimestampSnd is taken when a send leaves the stack.
+	TimestampSnd TimestampType = iota
+
+	// TimestampSched is taken when a send is handed to the network layer.
+	TimestampSched
+
+	// TimestampAck is taken when all the data of a send is acknowledged.
+	TimestampAck
+)
+
+// flag returns the flag requesting timestamps of type t.
+func (t TimestampType) flag() TimestampingFlags {
+	switch t {
+	case TimestampSnd:
+		return TimestampingTxSoftware
+	case TimestampSched:
+		return TimestampingTxSched
+	case TimestampAck:
+		return TimestampingTxAck
+	default:
+		panic("unknown timestamp type")
+	}
+}
+
+// GetTimestamping gets value for SO_TIMESTAMPING option.
+func (so *SocketOptions) GetTimestamping() TimestampingFlags {
+	return TimestampingFlags(atomic.LoadUint32(&so.timestamping))
+}
+
+// SetTimestamping sets value for SO_TIMESTAMPING option. As in Linux, the keys
+// of transmit timestamps restart from 0 when TimestampingOptID is set.
+func (so *SocketOptions) SetTimestamping(v TimestampingFlags) {
+	old := TimestampingFlags(atomic.SwapUint32(&so.timestamping, uint32(v)))
+	if v&TimestampingOptID != 0 && old&TimestampingOptID == 0 {
+		atomic.StoreUint32(&so.timestampingKey, 0)
+	}
+}
+
+// TxTimestampRequest is the request of a send for transmit timestamps, which
+// endpoints report as the send goes through the stack.
+type TxTimestampRequest struct {
+	so       *SocketOptions
+	flags    TimestampingFlags
+	key      uint32
+	payload  []byte
+	netProto NetworkProtocolNumber
+}
+
+// NewTxTimestampRequest returns the request for transmit timestamps of a send
+// of payload, or nil if the socket doesn't request any. The key of the send is
+// its index among the sends of the socket, or for stream sockets the offset of
+// its last byte in the stream.
+func (so *SocketOptions) NewTxTimestampRequest(payload []byte, stream bool, netProto NetworkProtocolNumber) *TxTimestampRequest {
+	flags := so.GetTimestamping()
+	if flags&TimestampingTxFlags == 0 || (stream && len(payload) == 0) {
+		return nil
+	}
+
+	var key uint32
+	if stream {
+		key = atomic.AddUint32(&so.timestampingKey, uint32(len(payload))) - 1
+	} else {
+		key = atomic.AddUint32(&so.timestampingKey, 1) - 1
+	}
+	r := &TxTimestampRequest{
+		so:       so,
+		flags:    flags,
+		key:      key,
+		netProto: netProto,
+	}
+	if flags&TimestampingOptTsonly == 0 {
+		r.payload = append([]byte(nil), payload...)
+	}
+	return r
+}
+
+// Report queues the transmit timestamp of type t of the send, taken at now in
+// nanoseconds since the Unix epoch, if the send requested it.
+//
+// Report returns true if the timestamp was queued, in which case the caller
+// must notify the socket's waiters of waiter.EventErr.
+//
+// Unlike other errors, timestamps are queued regardless of IP*_RECVERR.
+func (r *TxTimestampRequest) Report(t TimestampType, now int64) bool {
+	if r == nil || r.flags&t.flag() == 0 {
+		return false
+	}
+
+	var key uint32
+	if r.flags&TimestampingOptID != 0 {
+		key = r.key
+	}
+
+	so := r.so
+	so.errQueueMu.Lock()
+	defer so.errQueueMu.Unlock()
+	if so.errQueueLen >= maxErrQueueLen {
+		return false
+	}
+	so.errQueue.PushBack(&SockError{
+		ErrOrigin: SockExtErrorOriginTimestamping,
+		ErrInfo:   uint32(t),
+		ErrData:   key,
+		Payload:   r.payload,
+		NetProto:  r.netProto,
+		Timestamp: now,
+	})
+	so.errQueueLen++
+	return true
+}
@@ -78,6 +78,9 @@ type endpoint struct {
 	// linger is used for SO_LINGER socket option.
 	linger tcpip.LingerOption
 
+	// icmpv6Filter is used for the ICMP6_FILTER socket option.
+	icmpv6Filter tcpip.ICMPv6FilterOption
+
 	// owner is used to get uid and gid of the packet.
 	owner tcpip.PacketOwner
 
@@ -293,7 +296,7 @@ func (e *endpoint) write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, <-c
 		}
 
 		// Find the endpoint.
-		r, err := e.stack.FindRoute(nicID, e.BindAddr, dst.Addr, netProto, false /* multicastLoop */)
+		r, err := e.stack.FindRouteWithPolicy(nicID, e.BindAddr, dst.Addr, netProto, false /* multicastLoop */, stack.RoutePolicyKey{Mark: e.ops.GetMark()})
 		if err != nil {
 			return 0, nil, err
 		}
@@ -315,13 +318,17 @@ func (e *endpoint) write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, <-c
 	if err != nil {
 		return 0, nil, err
 	}
+	if opts.PathMTUProbe && len(v) > int(route.MaxMTU()) {
+		// Probes are never fragmented.
+		return 0, nil, tcpip.ErrMessageTooLong
+	}
 
 	switch e.NetProto {
 	case header.IPv4ProtocolNumber:
-		err = send4(route, e.ID.LocalPort, v, e.ttl, e.owner)
+		err = send4(route, e.ID.LocalPort, v, e.ttl, opts.PathMTUProbe, e.owner)
 
 	case header.IPv6ProtocolNumber:
-		err = send6(route, e.ID.LocalPort, v, e.ttl)
+		err = send6(route, e.ID.LocalPort, v, e.ttl, opts.PathMTUProbe)
 	}
 
 	if err != nil {
@@ -346,6 +353,14 @@ func (e *endpoint) SetSockOpt(opt tcpip.SettableSocketOption) *tcpip.Error {
 		e.mu.Lock()
 		e.linger = *v
 		e.mu.Unlock()
+
+	case *tcpip.ICMPv6FilterOption:
+		if e.NetProto != header.IPv6ProtocolNumber {
+			return tcpip.ErrUnknownProtocolOption
+		}
+		e.mu.Lock()
+		e.icmpv6Filter = *v
+		e.mu.Unlock()
 	}
 	return nil
 }
@@ -392,6 +407,14 @@ func (e *endpoint) GetSockOptInt(opt tcpip.SockOptInt) (int, *tcpip.Error) {
 		e.rcvMu.Unlock()
 		return v, nil
 
+	case tcpip.PathMTUOption:
+		e.mu.RLock()
+		defer e.mu.RUnlock()
+		if e.state != stateConnected {
+			return -1, tcpip.ErrNotConnected
+		}
+		return int(e.route.PathMTU()), nil
+
 	default:
 		return -1, tcpip.ErrUnknownProtocolOption
 	}
@@ -406,12 +429,21 @@ func (e *endpoint) GetSockOpt(opt tcpip.GettableSocketOption) *tcpip.Error {
 		e.mu.Unlock()
 		return nil
 
+	case *tcpip.ICMPv6FilterOption:
+		if e.NetProto != header.IPv6ProtocolNumber {
+			return tcpip.ErrUnknownProtocolOption
+		}
+		e.mu.RLock()
+		*o = e.icmpv6Filter
+		e.mu.RUnlock()
+		return nil
+
 	default:
 		return tcpip.ErrUnknownProtocolOption
 	}
 }
 
-func send4(r *stack.Route, ident uint16, data buffer.View, ttl uint8, owner tcpip.PacketOwner) *tcpip.Error {
+func send4(r *stack.Route, ident uint16, data buffer.View, ttl uint8, pathMTUProbe bool, owner tcpip.PacketOwner) *tcpip.Error {
 	if len(data) < header.ICMPv4MinimumSize {
 		return tcpip.ErrInvalidEndpointState
 	}
@@ -442,10 +474,10 @@ func send4(r *stack.Route, ident uint16, data buffer.View, ttl uint8, owner tcpi
 	if ttl == 0 {
 		ttl = r.DefaultTTL()
 	}
-	return r.WritePacket(nil /* gso */, stack.NetworkHeaderParams{Protocol: header.ICMPv4ProtocolNumber, TTL: ttl, TOS: stack.DefaultTOS}, pkt)
+	return r.WritePacket(nil /* gso */, stack.NetworkHeaderParams{Protocol: header.ICMPv4ProtocolNumber, TTL: ttl, TOS: stack.DefaultTOS, PathMTUProbe: pathMTUProbe}, pkt)
 }
 
-func send6(r *stack.Route, ident uint16, data buffer.View, ttl uint8) *tcpip.Error {
+func send6(r *stack.Route, ident uint16, data buffer.View, ttl uint8, pathMTUProbe bool) *tcpip.Error {
 	if len(data) < header.ICMPv6EchoMinimumSize {
 		return tcpip.ErrInvalidEndpointState
 	}
@@ -472,7 +504,7 @@ func send6(r *stack.Route, ident uint16, data buffer.View, ttl uint8) *tcpip.Err
 	if ttl == 0 {
 		ttl = r.DefaultTTL()
 	}
-	return r.WritePacket(nil /* gso */, stack.NetworkHeaderParams{Protocol: header.ICMPv6ProtocolNumber, TTL: ttl, TOS: stack.DefaultTOS}, pkt)
+	return r.WritePacket(nil /* gso */, stack.NetworkHeaderParams{Protocol: header.ICMPv6ProtocolNumber, TTL: ttl, TOS: stack.DefaultTOS, PathMTUProbe: pathMTUProbe}, pkt)
 }
 
 // checkV4MappedLocked determines the effective network protocol and converts
@@ -520,7 +552,7 @@ func (e *endpoint) Connect(addr tcpip.FullAddress) *tcpip.Error {
 	}
 
 	// Find a route to the desired destination.
-	r, err := e.stack.FindRoute(nicID, e.BindAddr, addr.Addr, netProto, false /* multicastLoop */)
+	r, err := e.stack.FindRouteWithPolicy(nicID, e.BindAddr, addr.Addr, netProto, false /* multicastLoop */, stack.RoutePolicyKey{Mark: e.ops.GetMark()})
 	if err != nil {
 		return err
 	}
@@ -638,8 +670,9 @@ func (e *endpoint) bindLocked(addr tcpip.FullAddress) *tcpip.Error {
 	netProtos := []tcpip.NetworkProtocolNumber{netProto}
 
 	if len(addr.Addr) != 0 {
-		// A local address was specified, verify that it's valid.
-		if e.stack.CheckLocalAddress(addr.NIC, netProto, addr.Addr) == 0 {
+		// A local address was specified, verify that it's valid unless
+		// non-local binds are allowed.
+		if e.stack.CheckLocalAddress(addr.NIC, netProto, addr.Addr) == 0 && !e.stack.CanBindNonLocal(netProto, &e.ops) {
 			return tcpip.ErrBadLocalAddress
 		}
 	}
@@ -726,9 +759,23 @@ func (e *endpoint) Readiness(mask waiter.EventMask) waiter.EventMask {
 		e.rcvMu.Unlock()
 	}
 
+	if e.ops.PeekErr() != nil {
+		result |= waiter.EventErr
+	}
 	return result
 }
 
+// filtered returns true if ICMP messages of type t must not be delivered to
+// the endpoint, as per its ICMP6_FILTER socket option.
+func (e *endpoint) filtered(t uint8) bool {
+	if e.NetProto != header.IPv6ProtocolNumber {
+		return false
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.icmpv6Filter.ShouldDeny(t)
+}
+
 // HandlePacket is called by the stack when new packets arrive to this transport
 // endpoint.
 func (e *endpoint) HandlePacket(id stack.TransportEndpointID, pkt *stack.PacketBuffer) {
@@ -752,6 +799,9 @@ func (e *endpoint) HandlePacket(id stack.TransportEndpointID, pkt *stack.PacketB
 			e.stats.ReceiveErrors.MalformedPacketsReceived.Increment()
 			return
 		}
+		if e.filtered(uint8(h.Type())) {
+			return
+		}
 	}
 
 	e.rcvMu.Lock()
@@ -799,7 +849,20 @@ func (e *endpoint) HandlePacket(id stack.TransportEndpointID, pkt *stack.PacketB
 }
 
 // HandleControlPacket implements stack.TransportEndpoint.HandleControlPacket.
+//
+// The errors caused by the echo requests sent by the endpoint are queued to its
+// error queue, if IP_RECVERR or IPV6_RECVERR is enabled.
 func (e *endpoint) HandleControlPacket(id stack.TransportEndpointID, typ stack.ControlType, extra uint32, pkt *stack.PacketBuffer) {
+	if !e.ops.GetRecvError() {
+		return
+	}
+	sockErr := stack.NewSockErrorFromControl(id, typ, extra, pkt)
+	if sockErr == nil || e.filtered(sockErr.ErrType) {
+		return
+	}
+	if e.ops.QueueErr(sockErr) {
+		e.waiterQueue.Notify(waiter.EventErr)
+	}
 }
 
 // State implements tcpip.Endpoint.State. The ICMP endpoint currently doesn't
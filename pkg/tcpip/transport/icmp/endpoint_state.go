@@ -76,14 +76,14 @@ func (e *endpoint) Resume(s *stack.Stack) {
 
 	var err *tcpip.Error
 	if e.state == stateConnected {
-		e.route, err = e.stack.FindRoute(e.RegisterNICID, e.BindAddr, e.ID.RemoteAddress, e.NetProto, false /* multicastLoop */)
+		e.route, err = e.stack.FindRouteWithPolicy(e.RegisterNICID, e.BindAddr, e.ID.RemoteAddress, e.NetProto, false /* multicastLoop */, stack.RoutePolicyKey{Mark: e.ops.GetMark()})
 		if err != nil {
 			panic(err)
 		}
 
 		e.ID.LocalAddress = e.route.LocalAddress
 	} else if len(e.ID.LocalAddress) != 0 { // stateBound
-		if e.stack.CheckLocalAddress(e.RegisterNICID, e.NetProto, e.ID.LocalAddress) == 0 {
+		if e.stack.CheckLocalAddress(e.RegisterNICID, e.NetProto, e.ID.LocalAddress) == 0 && !e.stack.CanBindNonLocal(e.NetProto, &e.ops) {
 			panic(tcpip.ErrBadLocalAddress)
 		}
 	}
@@ -86,14 +86,23 @@ func (p *protocol) MinimumPacketSize() int {
 	panic(fmt.Sprint("unknown protocol number: ", p.number))
 }
 
-// ParsePorts in case of ICMP sets src to 0, dst to ICMP ID, and err to nil.
+// ParsePorts in case of ICMP sets err to nil, and src to the ICMP ID for echo
+// requests, dst to the ICMP ID otherwise. Echo replies are thus delivered to
+// the endpoint bound to their ID, as are the errors carrying the echo requests
+// it sent.
 func (p *protocol) ParsePorts(v buffer.View) (src, dst uint16, err *tcpip.Error) {
 	switch p.number {
 	case ProtocolNumber4:
 		hdr := header.ICMPv4(v)
+		if hdr.Type() == header.ICMPv4Echo {
+			return hdr.Ident(), 0, nil
+		}
 		return 0, hdr.Ident(), nil
 	case ProtocolNumber6:
 		hdr := header.ICMPv6(v)
+		if hdr.Type() == header.ICMPv6EchoRequest {
+			return hdr.Ident(), 0, nil
+		}
 		return 0, hdr.Ident(), nil
 	}
 	panic(fmt.Sprint("unknown protocol number: ", p.number))
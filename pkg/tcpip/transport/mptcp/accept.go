@@ -0,0 +1,212 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mptcp
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// Listen implements tcpip.Endpoint.Listen.
+func (e *endpoint) Listen(backlog int) *tcpip.Error {
+	e.mu.Lock()
+	first := false
+	switch e.state {
+	case stateInitial:
+		first = true
+	case stateListen:
+	default:
+		e.mu.Unlock()
+		return tcpip.ErrInvalidEndpointState
+	}
+	e.mu.Unlock()
+
+	if first {
+		e.primary.SetSubflowListenHandler(e)
+	}
+	if err := e.primary.Listen(backlog); err != nil {
+		if first {
+			e.primary.SetSubflowListenHandler(nil)
+		}
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if backlog < 1 {
+		backlog = 1
+	}
+	e.backlog = backlog
+	if first {
+		e.state = stateListen
+		e.listenDone = make(chan struct{})
+		go e.acceptLoop(e.listenDone) // S/R-SAFE: MPTCP endpoints can't be saved.
+	}
+	return nil
+}
+
+// Accept implements tcpip.Endpoint.Accept.
+func (e *endpoint) Accept(peerAddr *tcpip.FullAddress) (tcpip.Endpoint, *waiter.Queue, *tcpip.Error) {
+	e.mu.Lock()
+	if e.state != stateListen {
+		e.mu.Unlock()
+		return nil, nil, tcpip.ErrInvalidEndpointState
+	}
+	if len(e.acceptQueue) == 0 {
+		e.mu.Unlock()
+		return nil, nil, tcpip.ErrWouldBlock
+	}
+	m := e.acceptQueue[0]
+	e.acceptQueue = e.acceptQueue[1:]
+	e.mu.Unlock()
+
+	if peerAddr != nil {
+		*peerAddr, _ = m.GetRemoteAddress()
+	}
+	return m, m.waiterQueue, nil
+}
+
+// HandleSyn implements tcp.SubflowListenHandler.HandleSyn.
+func (e *endpoint) HandleSyn(id stack.TransportEndpointID, synOptions []byte) (tcp.SubflowHandler, bool) {
+	opts := header.ParseMPTCPOptions(synOptions)
+	switch {
+	case opts.HasJoin:
+		m := e.protocol.lookupToken(opts.Join.Token)
+		if m == nil {
+			e.stack.Stats().MPTCP.JoinsFailed.Increment()
+			return nil, false
+		}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.state != stateConnected || m.fallback || !m.hasRemoteKey || len(m.subflows) >= maxSubflows {
+			e.stack.Stats().MPTCP.JoinsFailed.Increment()
+			return nil, false
+		}
+		sf := newSubflow(m, false /* active */, true /* join */)
+		sf.backup = opts.Join.Backup
+		sf.remoteAddrID = opts.Join.AddrID
+		sf.remoteNonce = opts.Join.Nonce
+		for _, a := range m.announced {
+			if a.opt.Addr == id.LocalAddress {
+				sf.localAddrID = a.opt.AddrID
+			}
+		}
+		return sf, true
+
+	case opts.HasCapable:
+		if opts.Capable.Version != header.MPTCPVersion || opts.Capable.Flags&header.MPCapableFlagChecksum != 0 {
+			// Fall back to regular TCP.
+			return nil, true
+		}
+		e.mu.Lock()
+		netProto := e.netProto
+		e.mu.Unlock()
+		m := newMeta(e.protocol, netProto, &waiter.Queue{})
+		m.mu.Lock()
+		m.passive = true
+		m.state = stateConnecting
+		m.initLocked()
+		m.mu.Unlock()
+		return newSubflow(m, false /* active */, false /* join */), true
+
+	default:
+		return nil, true
+	}
+}
+
+// acceptLoop accepts the subflows accepted by the listening TCP endpoint of
+// e, until done is closed.
+func (e *endpoint) acceptLoop(done chan struct{}) {
+	we, ch := waiter.NewChannelEntry(nil)
+	q := e.primarySubflow.queue
+	q.EventRegister(&we, waiter.EventIn)
+	defer q.EventUnregister(&we)
+
+	for {
+		ep, wq, err := e.primary.Accept(nil)
+		switch err {
+		case nil:
+			e.handleAccepted(ep.(tcp.Subflow), wq)
+		case tcpip.ErrWouldBlock:
+			select {
+			case <-ch:
+			case <-done:
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// handleAccepted handles the TCP endpoint ep accepted by the listening TCP
+// endpoint of e, whose waiter queue is wq.
+func (e *endpoint) handleAccepted(ep tcp.Subflow, wq *waiter.Queue) {
+	sf, ok := ep.SubflowHandler().(*subflow)
+	if !ok {
+		// This is a regular TCP connection.
+		m := newMeta(e.protocol, e.netProto, &waiter.Queue{})
+		m.state = stateConnected
+		m.fallback = true
+		sf = newSubflow(m, false /* active */, false /* join */)
+		sf.state = subflowFallback
+	}
+
+	m := sf.meta
+	var announced []announcement
+	if !sf.join {
+		announced = m.addressesToAnnounce(ep)
+	}
+	m.mu.Lock()
+	if sf.state == subflowClosed || m.state == stateClosed || (sf.join && len(m.subflows) >= maxSubflows) {
+		m.mu.Unlock()
+		ep.Abort()
+		return
+	}
+	sf.ep = ep
+	sf.watch(wq)
+	m.subflows = append(m.subflows, sf)
+	owner := m.owner
+	if sf.join {
+		m.mu.Unlock()
+		if owner != nil {
+			ep.SetOwner(owner)
+		}
+		// Data pending on the other subflows may now be sent on this
+		// one.
+		m.handleSubflowEvent(sf, waiter.EventOut)
+		return
+	}
+	m.primary = ep
+	m.primarySubflow = sf
+	if m.state == stateConnected && !m.fallback {
+		m.announced = announced
+	}
+	m.mu.Unlock()
+	ep.NotifySubflow()
+
+	e.mu.Lock()
+	if e.state != stateListen || len(e.acceptQueue) >= e.backlog {
+		e.mu.Unlock()
+		m.Abort()
+		return
+	}
+	e.acceptQueue = append(e.acceptQueue, m)
+	e.mu.Unlock()
+	e.waiterQueue.Notify(waiter.EventIn)
+}
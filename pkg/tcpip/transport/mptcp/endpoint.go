@@ -0,0 +1,1050 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mptcp
+
+import (
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+type endpointState int
+
+const (
+	// stateInitial is the state of an endpoint which is neither
+	// connected nor listening.
+	stateInitial endpointState = iota
+
+	// stateConnecting is the state of an endpoint whose first subflow is
+	// being established.
+	stateConnecting
+
+	// stateConnected is the state of a connected endpoint.
+	stateConnected
+
+	// stateListen is the state of a listening endpoint.
+	stateListen
+
+	// stateClosed is the state of a closed endpoint.
+	stateClosed
+)
+
+// oooSegment is data received out of order at the connection level.
+type oooSegment struct {
+	dsn  uint64
+	data []byte
+}
+
+// announcement is an address announced to the peer with ADD_ADDR.
+type announcement struct {
+	opt    header.AddAddrOption
+	echoed bool
+}
+
+// endpoint is an MPTCP connection, which is made of one or more subflows. It
+// implements tcpip.Endpoint.
+//
+// Until the first subflow is established, and after falling back to regular
+// TCP, the endpoint passes all the operations through to the TCP endpoint of
+// its first subflow.
+//
+// Lock order:
+//
+//	endpoint.sndMu
+//	  subflow.readMu
+//	    TCP endpoint locks
+//	      endpoint.mu
+//	        protocol.mu
+//
+// Methods of the TCP endpoints must not be called with endpoint.mu held,
+// except for the lock-free NotifySubflow.
+type endpoint struct {
+	stack       *stack.Stack
+	protocol    *protocol
+	netProto    tcpip.NetworkProtocolNumber
+	waiterQueue *waiter.Queue
+
+	// primary is the TCP endpoint of the first subflow and primarySubflow
+	// is its handler. They are immutable once the endpoint is visible to
+	// users.
+	primary        tcp.Subflow
+	primarySubflow *subflow
+
+	// sndMu serializes the writes to the subflows, so that the mappings
+	// recorded by the subflows match the bytes written to them.
+	sndMu sync.Mutex
+
+	// pushing is true if a goroutine was started to write the pending data
+	// to the subflows. It is protected by mu.
+	pushing bool
+
+	mu sync.Mutex
+
+	// The following fields are protected by mu.
+	state    endpointState
+	fallback bool
+	passive  bool
+	owner    tcpip.PacketOwner
+
+	// localKey and remoteKey are the keys exchanged with MP_CAPABLE.
+	// remoteKey is valid if hasRemoteKey is true. peerConfirmed is true
+	// once the peer is known to have received the keys.
+	localKey      uint64
+	remoteKey     uint64
+	hasRemoteKey  bool
+	peerConfirmed bool
+
+	// subflows are the subflows of the connection, including the ones
+	// being established.
+	subflows []*subflow
+
+	// hardError is the error which terminated the connection.
+	hardError *tcpip.Error
+
+	// sndBuf holds the data between sndUna, the first unacknowledged data
+	// sequence number, and sndNxt, the next data sequence number to
+	// allocate. The data up to sndQueued was written to subflows.
+	sndBuf       []byte
+	sndBufSize   int
+	sndUna       uint64
+	sndQueued    uint64
+	sndNxt       uint64
+	sndClosed    bool
+	dataFinAcked bool
+
+	// rcvQueue holds the data received in order and not read yet. rcvNxt
+	// is the next data sequence number expected, which is valid if
+	// rcvKnown is true. ooo holds the data received out of order, sorted by
+	// data sequence number.
+	rcvQueue    []byte
+	rcvBufSize  int
+	rcvKnown    bool
+	rcvNxt      uint64
+	ooo         []oooSegment
+	peerFin     bool
+	peerFinSeq  uint64
+	rcvClosed   bool
+	rcvShutdown bool
+
+	// announced are the addresses announced to the peer, pendingEcho is
+	// the echo of the last address announced by the peer, and joined are
+	// the IDs of the addresses announced by the peer which were joined.
+	announced   []announcement
+	pendingEcho *header.AddAddrOption
+	joined      map[uint8]bool
+
+	// acceptQueue holds the connections accepted by a listening endpoint
+	// and listenDone is closed when it stops listening.
+	acceptQueue []*endpoint
+	backlog     int
+	listenDone  chan struct{}
+}
+
+// newMeta returns an endpoint without any subflow.
+func newMeta(p *protocol, netProto tcpip.NetworkProtocolNumber, waiterQueue *waiter.Queue) *endpoint {
+	e := &endpoint{
+		stack:       p.stack,
+		protocol:    p,
+		netProto:    netProto,
+		waiterQueue: waiterQueue,
+		joined:      make(map[uint8]bool),
+	}
+
+	var ss tcpip.TCPSendBufferSizeRangeOption
+	if err := p.stack.TransportProtocolOption(tcp.ProtocolNumber, &ss); err == nil {
+		e.sndBufSize = ss.Default
+	}
+	var rs tcpip.TCPReceiveBufferSizeRangeOption
+	if err := p.stack.TransportProtocolOption(tcp.ProtocolNumber, &rs); err == nil {
+		e.rcvBufSize = rs.Default
+	}
+	return e
+}
+
+// newEndpoint returns a new endpoint, whose first subflow is a new TCP
+// endpoint.
+func newEndpoint(p *protocol, netProto tcpip.NetworkProtocolNumber, waiterQueue *waiter.Queue) (*endpoint, *tcpip.Error) {
+	e := newMeta(p, netProto, waiterQueue)
+	q := &waiter.Queue{}
+	ep, err := p.stack.NewEndpoint(tcp.ProtocolNumber, netProto, q)
+	if err != nil {
+		return nil, err
+	}
+	sf := newSubflow(e, true /* active */, false /* join */)
+	sf.ep = ep.(tcp.Subflow)
+	sf.watch(q)
+	e.primary = sf.ep
+	e.primarySubflow = sf
+	return e, nil
+}
+
+// initLocked initializes the connection level state of a new connection.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) initLocked() {
+	e.localKey = e.protocol.newKey()
+	e.sndUna = header.MPTCPIDSN(e.localKey) + 1
+	e.sndQueued = e.sndUna
+	e.sndNxt = e.sndUna
+}
+
+// tcpEndpoint returns the TCP endpoint which implements the connection if it
+// isn't using MPTCP, either because it isn't connected, because its first
+// subflow failed to connect, or because it fell back to regular TCP.
+func (e *endpoint) tcpEndpoint() tcp.Subflow {
+	e.mu.Lock()
+	state, fallback := e.state, e.fallback
+	e.mu.Unlock()
+	switch {
+	case fallback, state == stateInitial:
+		return e.primary
+	case state == stateConnecting:
+		if s := tcp.EndpointState(e.primary.State()); s == tcp.StateError || s == tcp.StateClose {
+			return e.primary
+		}
+	}
+	return nil
+}
+
+// usableSubflowsLocked returns the subflows which can carry data. Backup
+// subflows are only returned if no regular subflow is usable.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) usableSubflowsLocked() []*subflow {
+	var regular, backup []*subflow
+	for _, sf := range e.subflows {
+		switch {
+		case !sf.usableLocked():
+		case sf.backup:
+			backup = append(backup, sf)
+		default:
+			regular = append(regular, sf)
+		}
+	}
+	if len(regular) == 0 {
+		return backup
+	}
+	return regular
+}
+
+// setRemoteKeyLocked records the key of the peer.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) setRemoteKeyLocked(key uint64) {
+	if e.hasRemoteKey {
+		return
+	}
+	e.remoteKey = key
+	e.hasRemoteKey = true
+	e.rcvNxt = header.MPTCPIDSN(key) + 1
+	e.rcvKnown = true
+	e.drainOOOLocked()
+	if e.passive {
+		e.protocol.registerToken(e.localKey, e)
+	}
+}
+
+// deliverLocked delivers the data v, whose first byte has the data sequence
+// number dsn.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) deliverLocked(dsn uint64, v []byte) {
+	if e.rcvKnown {
+		if d := int64(e.rcvNxt - dsn); d > 0 {
+			if d >= int64(len(v)) {
+				return
+			}
+			v = v[d:]
+			dsn += uint64(d)
+		}
+		if dsn == e.rcvNxt {
+			e.rcvQueue = append(e.rcvQueue, v...)
+			e.rcvNxt += uint64(len(v))
+			e.drainOOOLocked()
+			return
+		}
+	}
+
+	i := len(e.ooo)
+	for i > 0 && int64(e.ooo[i-1].dsn-dsn) > 0 {
+		i--
+	}
+	e.ooo = append(e.ooo, oooSegment{})
+	copy(e.ooo[i+1:], e.ooo[i:])
+	e.ooo[i] = oooSegment{dsn: dsn, data: v}
+}
+
+// drainOOOLocked delivers the data received out of order which became in
+// order.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) drainOOOLocked() {
+	for len(e.ooo) > 0 {
+		s := &e.ooo[0]
+		if int64(s.dsn-e.rcvNxt) > 0 {
+			break
+		}
+		if d := e.rcvNxt - s.dsn; d < uint64(len(s.data)) {
+			e.rcvQueue = append(e.rcvQueue, s.data[d:]...)
+			e.rcvNxt += uint64(len(s.data)) - d
+		}
+		e.ooo = e.ooo[1:]
+	}
+	if len(e.ooo) == 0 {
+		e.ooo = nil
+	}
+	e.checkDataFinLocked()
+}
+
+// checkDataFinLocked consumes the DATA_FIN of the peer once all the data
+// before it was received. It returns true if it did.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) checkDataFinLocked() bool {
+	if !e.peerFin || e.rcvClosed || !e.rcvKnown || e.rcvNxt != e.peerFinSeq {
+		return false
+	}
+	e.rcvNxt++
+	e.rcvClosed = true
+	return true
+}
+
+// handleDataAckLocked handles the data acknowledgement number ack. It returns
+// the events to notify the waiters of.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) handleDataAckLocked(ack uint64) waiter.EventMask {
+	limit := e.sndNxt
+	if e.sndClosed {
+		// The DATA_FIN takes one data sequence number.
+		limit++
+	}
+	if int64(ack-e.sndUna) <= 0 || int64(ack-limit) > 0 {
+		return 0
+	}
+	n := ack - e.sndUna
+	if n > uint64(len(e.sndBuf)) {
+		e.dataFinAcked = true
+		n = uint64(len(e.sndBuf))
+	}
+	e.sndBuf = e.sndBuf[n:]
+	if len(e.sndBuf) == 0 {
+		e.sndBuf = nil
+	}
+	e.sndUna += n
+	if int64(e.sndQueued-e.sndUna) < 0 {
+		e.sndQueued = e.sndUna
+	}
+	return waiter.EventOut
+}
+
+// handleOptionsLocked handles the MPTCP options opts of the segment seg
+// received on the subflow sf. It returns the events to notify the waiters
+// of.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) handleOptionsLocked(sf *subflow, seg tcp.SubflowSegment, opts *header.MPTCPOptions) waiter.EventMask {
+	var notify waiter.EventMask
+	if seg.Flags&header.TCPFlagAck != 0 {
+		sf.handleAckLocked(seg.Ack)
+	}
+
+	if c := &opts.Capable; opts.HasCapable && c.NumKeys == 2 && !sf.active && !sf.join {
+		// The keys are repeated by the third ACK and the first data
+		// segment of the connection.
+		if c.ReceiverKey == e.localKey {
+			e.setRemoteKeyLocked(c.SenderKey)
+		}
+		if c.HasDataLen && e.hasRemoteKey {
+			sf.addRcvMappingLocked(mapping{
+				ssn:    seg.SSN,
+				dsn:    header.MPTCPIDSN(e.remoteKey) + 1,
+				length: uint32(c.DataLen),
+			})
+		}
+	}
+
+	if d := &opts.DSS; opts.HasDSS {
+		if sf.active && !sf.join && !e.peerConfirmed {
+			e.peerConfirmed = true
+		}
+		if d.HasDataAck {
+			notify |= e.handleDataAckLocked(d.ExpandedDataAck(e.sndUna))
+		}
+		if d.HasMapping {
+			dsn := d.ExpandedDSN(e.rcvNxt)
+			length := uint32(d.DataLen)
+			if d.DataFIN && length > 0 {
+				e.peerFin = true
+				e.peerFinSeq = dsn + uint64(length) - 1
+				length--
+				if e.checkDataFinLocked() {
+					notify |= waiter.EventIn
+					// Acknowledge the DATA_FIN even if it isn't
+					// carried by data.
+					if sf.ep != nil {
+						sf.ep.NotifySubflow()
+					}
+				}
+			}
+			sf.addRcvMappingLocked(mapping{
+				ssn:    d.SSN,
+				dsn:    dsn,
+				length: length,
+			})
+		}
+	}
+
+	if a := &opts.AddAddr; opts.HasAddAddr {
+		if a.Echo {
+			for i := range e.announced {
+				if e.announced[i].opt.AddrID == a.AddrID {
+					e.announced[i].echoed = true
+				}
+			}
+		} else {
+			e.handleAddAddrLocked(sf, *a)
+		}
+	}
+
+	if len(opts.RemoveAddrIDs) > 0 {
+		var removed []*subflow
+		for _, s := range e.subflows {
+			for _, id := range opts.RemoveAddrIDs {
+				if s.remoteAddrID == id && s.ep != nil && s != sf {
+					removed = append(removed, s)
+					break
+				}
+			}
+		}
+		for _, s := range removed {
+			go e.abortSubflow(s)
+		}
+	}
+
+	if opts.HasPrio {
+		sf.backup = opts.PrioBackup
+	}
+
+	if opts.HasFastclose && opts.FastcloseKey == e.localKey {
+		// The peer aborted the connection, as per RFC 8684 section
+		// 3.5.
+		e.hardError = tcpip.ErrConnectionReset
+		for _, s := range e.subflows {
+			if s.ep != nil {
+				go e.abortSubflow(s)
+			}
+		}
+		notify |= waiter.EventIn | waiter.EventOut | waiter.EventHUp | waiter.EventErr
+	}
+	return notify
+}
+
+// handleSubflowEvent handles the events mask notified by the TCP endpoint of
+// the subflow sf.
+func (e *endpoint) handleSubflowEvent(sf *subflow, mask waiter.EventMask) {
+	e.mu.Lock()
+	state, fallback := e.state, e.fallback
+	push := false
+	if mask&waiter.EventOut != 0 && e.sndQueued != e.sndNxt && !e.pushing {
+		e.pushing = true
+		push = true
+	}
+	e.mu.Unlock()
+
+	switch {
+	case state == stateListen:
+		// The events of the listening TCP endpoint are handled by
+		// acceptLoop.
+		return
+	case fallback, state != stateConnected:
+		e.waiterQueue.Notify(mask)
+		return
+	}
+	if mask&(waiter.EventHUp|waiter.EventErr) != 0 {
+		go e.checkSubflow(sf)
+	}
+	if push {
+		go e.push()
+	}
+	if mask &= waiter.EventIn | waiter.EventOut; mask != 0 {
+		e.waiterQueue.Notify(mask)
+	}
+}
+
+// checkSubflow removes the subflow sf from the connection if it failed.
+func (e *endpoint) checkSubflow(sf *subflow) {
+	e.mu.Lock()
+	ep := sf.ep
+	e.mu.Unlock()
+	if ep != nil && tcp.EndpointState(ep.State()) == tcp.StateError {
+		e.subflowFailed(sf)
+	}
+}
+
+// abortSubflow resets the subflow sf and removes it from the connection.
+func (e *endpoint) abortSubflow(sf *subflow) {
+	sf.ep.Abort()
+	e.subflowFailed(sf)
+}
+
+// subflowFailed removes the failed subflow sf from the connection. The data
+// which wasn't acknowledged at the connection level is reinjected on the
+// other subflows, and the connection fails with the subflow if it was the
+// last one.
+func (e *endpoint) subflowFailed(sf *subflow) {
+	err := sf.lastError()
+	e.mu.Lock()
+	if sf.state == subflowClosed {
+		e.mu.Unlock()
+		return
+	}
+	carriedData := sf.state == subflowEstablished
+	sf.removeLocked()
+	var notify waiter.EventMask
+	reinject := false
+	if e.state == stateConnected && !e.fallback {
+		switch {
+		case len(e.subflows) == 0:
+			if e.hardError == nil {
+				e.hardError = err
+			}
+			notify = waiter.EventIn | waiter.EventOut | waiter.EventHUp | waiter.EventErr
+		case carriedData && e.sndQueued != e.sndUna:
+			e.sndQueued = e.sndUna
+			e.stack.Stats().MPTCP.Reinjections.Increment()
+			reinject = true
+		}
+	}
+	e.mu.Unlock()
+
+	sf.queue.EventUnregister(&sf.entry)
+	sf.ep.Abort()
+	if reinject {
+		e.push()
+	}
+	if notify != 0 {
+		e.waiterQueue.Notify(notify)
+	}
+}
+
+// push writes the data which wasn't written to any subflow yet to the
+// subflows with available buffer space.
+func (e *endpoint) push() {
+	e.sndMu.Lock()
+	defer e.sndMu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		e.pushing = false
+		e.mu.Unlock()
+	}()
+
+	for {
+		e.mu.Lock()
+		if e.state != stateConnected || e.fallback || e.sndQueued == e.sndNxt {
+			e.mu.Unlock()
+			return
+		}
+		sfs := e.usableSubflowsLocked()
+		e.mu.Unlock()
+
+		// Send on the subflow with the most buffer space.
+		var sf *subflow
+		space := 0
+		for _, s := range sfs {
+			if n := s.ep.SendBufferAvailable(); n > space {
+				sf, space = s, n
+			}
+		}
+		if sf == nil {
+			return
+		}
+
+		e.mu.Lock()
+		if !sf.usableLocked() {
+			e.mu.Unlock()
+			continue
+		}
+		n := int(e.sndNxt - e.sndQueued)
+		if n > space {
+			n = space
+		}
+		if n > maxMappingSize {
+			n = maxMappingSize
+		}
+		off := int(e.sndQueued - e.sndUna)
+		v := e.sndBuf[off : off+n]
+		sf.addSndMappingLocked(e.sndQueued, n)
+		e.sndQueued += uint64(n)
+		e.mu.Unlock()
+
+		// Buffer space may only grow while sndMu is held, so the whole
+		// chunk is written unless the subflow failed.
+		if written, _, err := sf.ep.Write(tcpip.SlicePayload(v), tcpip.WriteOptions{Atomic: true}); err != nil || written != int64(n) {
+			e.sndMu.Unlock()
+			e.subflowFailed(sf)
+			e.sndMu.Lock()
+		}
+	}
+}
+
+// pull reads the data received by the subflows, until the receive queue of
+// the connection is full.
+func (e *endpoint) pull() {
+	e.mu.Lock()
+	if e.state != stateConnected || e.fallback || len(e.rcvQueue) >= e.rcvBufSize {
+		e.mu.Unlock()
+		return
+	}
+	var sfs []*subflow
+	for _, sf := range e.subflows {
+		if sf.usableLocked() {
+			sfs = append(sfs, sf)
+		}
+	}
+	rcvNxt := e.rcvNxt
+	e.mu.Unlock()
+
+	eof := 0
+	var failed []*subflow
+	for _, sf := range sfs {
+		sf.readMu.Lock()
+		for {
+			v, _, err := sf.ep.Read(nil)
+			if err != nil {
+				if err == tcpip.ErrClosedForReceive {
+					eof++
+				}
+				break
+			}
+			sf.ep.ModerateRecvBuf(len(v))
+			e.mu.Lock()
+			ok := sf.mapReceivedLocked(v)
+			full := len(e.rcvQueue) >= e.rcvBufSize
+			e.mu.Unlock()
+			if !ok {
+				// The peer sent data without a mapping.
+				failed = append(failed, sf)
+				break
+			}
+			if full {
+				break
+			}
+		}
+		sf.readMu.Unlock()
+	}
+	for _, sf := range failed {
+		e.abortSubflow(sf)
+	}
+
+	e.mu.Lock()
+	if len(sfs) > 0 && eof == len(sfs) {
+		// All the subflows were closed by the peer.
+		e.rcvClosed = true
+	}
+	var ack *subflow
+	if e.rcvNxt != rcvNxt && len(sfs) > 0 {
+		ack = sfs[0]
+	}
+	e.mu.Unlock()
+
+	// Let the peer release the data acknowledged at the connection level.
+	if ack != nil {
+		ack.ep.NotifySubflow()
+	}
+}
+
+// readErrorLocked returns the error returned by Read when no data is
+// available.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) readErrorLocked() *tcpip.Error {
+	switch {
+	case e.hardError != nil:
+		return e.hardError
+	case e.rcvClosed, e.rcvShutdown, e.state == stateClosed:
+		return tcpip.ErrClosedForReceive
+	default:
+		return tcpip.ErrWouldBlock
+	}
+}
+
+// writeErrorLocked returns the error returned by Write, if any.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) writeErrorLocked() *tcpip.Error {
+	switch {
+	case e.hardError != nil:
+		return e.hardError
+	case e.sndClosed, e.state == stateClosed:
+		return tcpip.ErrClosedForSend
+	case e.state != stateConnected:
+		return tcpip.ErrWouldBlock
+	default:
+		return nil
+	}
+}
+
+// Close implements tcpip.Endpoint.Close.
+func (e *endpoint) Close() {
+	e.close(false /* abort */)
+}
+
+// Abort implements tcpip.Endpoint.Abort.
+func (e *endpoint) Abort() {
+	e.close(true /* abort */)
+}
+
+func (e *endpoint) close(abort bool) {
+	e.mu.Lock()
+	if e.state == stateClosed {
+		e.mu.Unlock()
+		return
+	}
+	state := e.state
+	e.state = stateClosed
+	e.sndClosed = true
+	sfs := e.subflows
+	e.subflows = nil
+	queued := e.acceptQueue
+	e.acceptQueue = nil
+	if state == stateListen {
+		close(e.listenDone)
+	}
+	e.mu.Unlock()
+
+	if e.passive {
+		e.protocol.unregisterToken(e.localKey, e)
+	}
+	for _, m := range queued {
+		m.Abort()
+	}
+
+	eps := []tcp.Subflow{e.primary}
+	for _, sf := range sfs {
+		if sf.ep != e.primary {
+			eps = append(eps, sf.ep)
+		}
+	}
+	for _, ep := range eps {
+		if abort {
+			ep.Abort()
+		} else {
+			ep.Close()
+		}
+	}
+	e.waiterQueue.Notify(waiter.EventHUp | waiter.EventErr | waiter.EventIn | waiter.EventOut)
+}
+
+// Read implements tcpip.Endpoint.Read.
+func (e *endpoint) Read(addr *tcpip.FullAddress) (buffer.View, tcpip.ControlMessages, *tcpip.Error) {
+	if ep := e.tcpEndpoint(); ep != nil {
+		return ep.Read(addr)
+	}
+	e.pull()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.rcvQueue) == 0 {
+		return nil, tcpip.ControlMessages{}, e.readErrorLocked()
+	}
+	v := buffer.View(e.rcvQueue)
+	e.rcvQueue = nil
+	return v, tcpip.ControlMessages{}, nil
+}
+
+// Write implements tcpip.Endpoint.Write.
+func (e *endpoint) Write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, <-chan struct{}, *tcpip.Error) {
+	if ep := e.tcpEndpoint(); ep != nil {
+		return ep.Write(p, opts)
+	}
+
+	e.mu.Lock()
+	if err := e.writeErrorLocked(); err != nil {
+		e.mu.Unlock()
+		return 0, nil, err
+	}
+	avail := e.sndBufSize - len(e.sndBuf)
+	e.mu.Unlock()
+	if avail <= 0 {
+		return 0, nil, tcpip.ErrWouldBlock
+	}
+
+	v, perr := p.Payload(avail)
+	if perr != nil || len(v) == 0 {
+		return 0, nil, perr
+	}
+
+	e.mu.Lock()
+	if err := e.writeErrorLocked(); err != nil {
+		e.mu.Unlock()
+		return 0, nil, err
+	}
+	e.sndBuf = append(e.sndBuf, v...)
+	e.sndNxt += uint64(len(v))
+	e.mu.Unlock()
+
+	e.push()
+	return int64(len(v)), nil, nil
+}
+
+// Peek implements tcpip.Endpoint.Peek.
+func (e *endpoint) Peek(vec [][]byte) (int64, tcpip.ControlMessages, *tcpip.Error) {
+	if ep := e.tcpEndpoint(); ep != nil {
+		return ep.Peek(vec)
+	}
+	e.pull()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.rcvQueue) == 0 {
+		return 0, tcpip.ControlMessages{}, e.readErrorLocked()
+	}
+	v := e.rcvQueue
+	var num int64
+	for _, b := range vec {
+		n := copy(b, v)
+		v = v[n:]
+		num += int64(n)
+		if len(v) == 0 {
+			break
+		}
+	}
+	return num, tcpip.ControlMessages{}, nil
+}
+
+// Connect implements tcpip.Endpoint.Connect.
+func (e *endpoint) Connect(addr tcpip.FullAddress) *tcpip.Error {
+	e.mu.Lock()
+	first := false
+	switch e.state {
+	case stateInitial:
+		first = true
+		e.state = stateConnecting
+		e.initLocked()
+		e.subflows = append(e.subflows, e.primarySubflow)
+	case stateListen:
+		e.mu.Unlock()
+		return tcpip.ErrInvalidEndpointState
+	}
+	e.mu.Unlock()
+
+	if first {
+		e.primary.SetSubflowHandler(e.primarySubflow)
+	}
+	// The connection is implemented by the first subflow until it's
+	// established, so subsequent calls report its progress.
+	err := e.primary.Connect(addr)
+	if first && err != nil && err != tcpip.ErrConnectStarted {
+		e.primary.SetSubflowHandler(nil)
+		e.mu.Lock()
+		e.state = stateInitial
+		e.subflows = nil
+		e.mu.Unlock()
+	}
+	return err
+}
+
+// Disconnect implements tcpip.Endpoint.Disconnect.
+func (*endpoint) Disconnect() *tcpip.Error {
+	return tcpip.ErrNotSupported
+}
+
+// Shutdown implements tcpip.Endpoint.Shutdown.
+func (e *endpoint) Shutdown(flags tcpip.ShutdownFlags) *tcpip.Error {
+	if ep := e.tcpEndpoint(); ep != nil {
+		return ep.Shutdown(flags)
+	}
+
+	e.mu.Lock()
+	switch e.state {
+	case stateListen:
+		e.mu.Unlock()
+		return e.primary.Shutdown(flags)
+	case stateConnected:
+	default:
+		e.mu.Unlock()
+		return tcpip.ErrNotConnected
+	}
+	if flags&tcpip.ShutdownRead != 0 {
+		e.rcvShutdown = true
+	}
+	var sfs []*subflow
+	if flags&tcpip.ShutdownWrite != 0 && !e.sndClosed {
+		e.sndClosed = true
+		sfs = append(sfs, e.subflows...)
+	}
+	e.mu.Unlock()
+
+	// The FINs of the subflows carry the DATA_FIN.
+	for _, sf := range sfs {
+		if sf.ep != nil {
+			sf.ep.Shutdown(tcpip.ShutdownWrite)
+		}
+	}
+	e.waiterQueue.Notify(waiter.EventIn | waiter.EventOut)
+	return nil
+}
+
+// Bind implements tcpip.Endpoint.Bind.
+func (e *endpoint) Bind(addr tcpip.FullAddress) *tcpip.Error {
+	return e.primary.Bind(addr)
+}
+
+// GetLocalAddress implements tcpip.Endpoint.GetLocalAddress.
+func (e *endpoint) GetLocalAddress() (tcpip.FullAddress, *tcpip.Error) {
+	return e.primary.GetLocalAddress()
+}
+
+// GetRemoteAddress implements tcpip.Endpoint.GetRemoteAddress.
+func (e *endpoint) GetRemoteAddress() (tcpip.FullAddress, *tcpip.Error) {
+	return e.primary.GetRemoteAddress()
+}
+
+// Readiness implements tcpip.Endpoint.Readiness.
+func (e *endpoint) Readiness(mask waiter.EventMask) waiter.EventMask {
+	e.mu.Lock()
+	if e.state == stateListen {
+		var result waiter.EventMask
+		if mask&waiter.EventIn != 0 && len(e.acceptQueue) > 0 {
+			result |= waiter.EventIn
+		}
+		e.mu.Unlock()
+		return result
+	}
+	e.mu.Unlock()
+
+	if ep := e.tcpEndpoint(); ep != nil {
+		return ep.Readiness(mask)
+	}
+	if mask&waiter.EventIn != 0 {
+		e.pull()
+	}
+
+	e.mu.Lock()
+	if e.state == stateConnecting {
+		e.mu.Unlock()
+		return 0
+	}
+	var result waiter.EventMask
+	if e.hardError != nil {
+		result |= waiter.EventErr | waiter.EventHUp | waiter.EventIn | waiter.EventOut
+	}
+	if e.state == stateClosed || (e.rcvClosed && e.sndClosed && e.dataFinAcked) {
+		result |= waiter.EventHUp
+	}
+	if len(e.rcvQueue) > 0 || e.rcvClosed || e.rcvShutdown {
+		result |= waiter.EventIn
+	}
+	if e.sndClosed || len(e.sndBuf) < e.sndBufSize {
+		result |= waiter.EventOut
+	}
+	e.mu.Unlock()
+	return result & mask
+}
+
+// SetSockOpt implements tcpip.Endpoint.SetSockOpt.
+func (e *endpoint) SetSockOpt(opt tcpip.SettableSocketOption) *tcpip.Error {
+	return e.primary.SetSockOpt(opt)
+}
+
+// SetSockOptInt implements tcpip.Endpoint.SetSockOptInt.
+func (e *endpoint) SetSockOptInt(opt tcpip.SockOptInt, v int) *tcpip.Error {
+	return e.primary.SetSockOptInt(opt, v)
+}
+
+// GetSockOpt implements tcpip.Endpoint.GetSockOpt.
+func (e *endpoint) GetSockOpt(opt tcpip.GettableSocketOption) *tcpip.Error {
+	return e.primary.GetSockOpt(opt)
+}
+
+// GetSockOptInt implements tcpip.Endpoint.GetSockOptInt.
+func (e *endpoint) GetSockOptInt(opt tcpip.SockOptInt) (int, *tcpip.Error) {
+	switch opt {
+	case tcpip.ReceiveQueueSizeOption, tcpip.SendQueueSizeOption:
+		if e.tcpEndpoint() != nil {
+			break
+		}
+		e.pull()
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if opt == tcpip.ReceiveQueueSizeOption {
+			return len(e.rcvQueue), nil
+		}
+		return len(e.sndBuf), nil
+	}
+	return e.primary.GetSockOptInt(opt)
+}
+
+// State implements tcpip.Endpoint.State.
+func (e *endpoint) State() uint32 {
+	return e.primary.State()
+}
+
+// ModerateRecvBuf implements tcpip.Endpoint.ModerateRecvBuf.
+func (e *endpoint) ModerateRecvBuf(copied int) {
+	// The receive buffers of the subflows are moderated as their data is
+	// read by pull.
+	if ep := e.tcpEndpoint(); ep != nil {
+		ep.ModerateRecvBuf(copied)
+	}
+}
+
+// Info implements tcpip.Endpoint.Info.
+func (e *endpoint) Info() tcpip.EndpointInfo {
+	return e.primary.Info()
+}
+
+// Stats implements tcpip.Endpoint.Stats.
+func (e *endpoint) Stats() tcpip.EndpointStats {
+	return e.primary.Stats()
+}
+
+// SetOwner implements tcpip.Endpoint.SetOwner.
+func (e *endpoint) SetOwner(owner tcpip.PacketOwner) {
+	e.mu.Lock()
+	e.owner = owner
+	var eps []tcp.Subflow
+	for _, sf := range e.subflows {
+		if sf.ep != nil && sf.ep != e.primary {
+			eps = append(eps, sf.ep)
+		}
+	}
+	e.mu.Unlock()
+	e.primary.SetOwner(owner)
+	for _, ep := range eps {
+		ep.SetOwner(owner)
+	}
+}
+
+// LastError implements tcpip.Endpoint.LastError.
+func (e *endpoint) LastError() *tcpip.Error {
+	e.mu.Lock()
+	err := e.hardError
+	e.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return e.primary.LastError()
+}
+
+// SocketOptions implements tcpip.Endpoint.SocketOptions.
+func (e *endpoint) SocketOptions() *tcpip.SocketOptions {
+	return e.primary.SocketOptions()
+}
@@ -0,0 +1,279 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mptcp_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/link/pipe"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/mptcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	nicID1 = 1
+	nicID2 = 2
+
+	port = 8080
+
+	timeout = 10 * time.Second
+)
+
+var (
+	clientAddrs = []tcpip.AddressWithPrefix{
+		{Address: "\x0a\x00\x01\x01", PrefixLen: 24},
+		{Address: "\x0a\x00\x02\x01", PrefixLen: 24},
+	}
+	serverAddrs = []tcpip.AddressWithPrefix{
+		{Address: "\x0a\x00\x01\x02", PrefixLen: 24},
+		{Address: "\x0a\x00\x02\x02", PrefixLen: 24},
+	}
+)
+
+func newStack(t *testing.T, addrs []tcpip.AddressWithPrefix, links []*pipe.Endpoint) *stack.Stack {
+	t.Helper()
+
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, mptcp.NewProtocol},
+	})
+	for i, ep := range links {
+		nicID := tcpip.NICID(i + 1)
+		if err := s.CreateNIC(nicID, ep); err != nil {
+			t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+		}
+		protocolAddr := tcpip.ProtocolAddress{
+			Protocol:          ipv4.ProtocolNumber,
+			AddressWithPrefix: addrs[i],
+		}
+		if err := s.AddProtocolAddress(nicID, protocolAddr); err != nil {
+			t.Fatalf("AddProtocolAddress(%d, %+v): %s", nicID, protocolAddr, err)
+		}
+		s.AddRoute(tcpip.Route{Destination: addrs[i].Subnet(), NIC: nicID})
+	}
+	return s
+}
+
+// newStacks returns a client and a server stack connected by two links on
+// different subnets.
+func newStacks(t *testing.T) (*stack.Stack, *stack.Stack) {
+	t.Helper()
+
+	c1, s1 := pipe.New("\x00\x00\x00\x00\x01\x01", "\x00\x00\x00\x00\x01\x02")
+	c2, s2 := pipe.New("\x00\x00\x00\x00\x02\x01", "\x00\x00\x00\x00\x02\x02")
+	return newStack(t, clientAddrs, []*pipe.Endpoint{c1, c2}), newStack(t, serverAddrs, []*pipe.Endpoint{s1, s2})
+}
+
+// connect connects an endpoint of the client stack using the protocol
+// clientProto to an endpoint of the server stack listening with the protocol
+// serverProto.
+func connect(t *testing.T, client, server *stack.Stack, clientProto, serverProto tcpip.TransportProtocolNumber) (tcpip.Endpoint, *waiter.Queue, tcpip.Endpoint, *waiter.Queue) {
+	t.Helper()
+
+	var lwq waiter.Queue
+	lep, err := server.NewEndpoint(serverProto, ipv4.ProtocolNumber, &lwq)
+	if err != nil {
+		t.Fatalf("NewEndpoint(%d, %d, _): %s", serverProto, ipv4.ProtocolNumber, err)
+	}
+	t.Cleanup(lep.Close)
+	if err := lep.Bind(tcpip.FullAddress{Port: port}); err != nil {
+		t.Fatalf("Bind: %s", err)
+	}
+	if err := lep.Listen(10); err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	lwe, lch := waiter.NewChannelEntry(nil)
+	lwq.EventRegister(&lwe, waiter.EventIn)
+	defer lwq.EventUnregister(&lwe)
+
+	var cwq waiter.Queue
+	cep, err := client.NewEndpoint(clientProto, ipv4.ProtocolNumber, &cwq)
+	if err != nil {
+		t.Fatalf("NewEndpoint(%d, %d, _): %s", clientProto, ipv4.ProtocolNumber, err)
+	}
+	t.Cleanup(cep.Close)
+	cwe, cch := waiter.NewChannelEntry(nil)
+	cwq.EventRegister(&cwe, waiter.EventOut)
+	defer cwq.EventUnregister(&cwe)
+
+	addr := tcpip.FullAddress{Addr: serverAddrs[0].Address, Port: port}
+	if err := cep.Connect(addr); err != tcpip.ErrConnectStarted {
+		t.Fatalf("got Connect(%+v) = %v, want = %s", addr, err, tcpip.ErrConnectStarted)
+	}
+	select {
+	case <-cch:
+	case <-time.After(timeout):
+		t.Fatal("timed out waiting for the connection")
+	}
+	if err := cep.Connect(addr); err != nil {
+		t.Fatalf("Connect(%+v): %s", addr, err)
+	}
+
+	for {
+		sep, swq, err := lep.Accept(nil)
+		if err == tcpip.ErrWouldBlock {
+			select {
+			case <-lch:
+				continue
+			case <-time.After(timeout):
+				t.Fatal("timed out waiting for the connection to be accepted")
+			}
+		}
+		if err != nil {
+			t.Fatalf("Accept: %s", err)
+		}
+		t.Cleanup(sep.Close)
+		return cep, &cwq, sep, swq
+	}
+}
+
+// transfer writes data to src and checks that it's read from dst, after src
+// is shut down for writing.
+func transfer(t *testing.T, src tcpip.Endpoint, srcQueue *waiter.Queue, dst tcpip.Endpoint, dstQueue *waiter.Queue, data []byte) {
+	t.Helper()
+
+	we, ch := waiter.NewChannelEntry(nil)
+	srcQueue.EventRegister(&we, waiter.EventOut)
+	defer srcQueue.EventUnregister(&we)
+	rwe, rch := waiter.NewChannelEntry(nil)
+	dstQueue.EventRegister(&rwe, waiter.EventIn)
+	defer dstQueue.EventUnregister(&rwe)
+
+	done := make(chan struct{})
+	var got []byte
+	go func() {
+		defer close(done)
+		for {
+			v, _, err := dst.Read(nil)
+			switch err {
+			case nil:
+				got = append(got, v...)
+			case tcpip.ErrWouldBlock:
+				select {
+				case <-rch:
+				case <-time.After(timeout):
+					return
+				}
+			default:
+				return
+			}
+		}
+	}()
+
+	for rest := data; len(rest) > 0; {
+		n, _, err := src.Write(tcpip.SlicePayload(rest), tcpip.WriteOptions{})
+		switch err {
+		case nil:
+			rest = rest[n:]
+		case tcpip.ErrWouldBlock:
+			select {
+			case <-ch:
+			case <-time.After(timeout):
+				t.Fatalf("timed out writing, %d bytes left", len(rest))
+			}
+		default:
+			t.Fatalf("Write: %s", err)
+		}
+	}
+	if err := src.Shutdown(tcpip.ShutdownWrite); err != nil {
+		t.Fatalf("Shutdown: %s", err)
+	}
+
+	<-done
+	if !bytes.Equal(got, data) {
+		t.Fatalf("got %d bytes, want %d bytes", len(got), len(data))
+	}
+}
+
+// waitFor polls f until it returns true.
+func waitFor(t *testing.T, desc string, f func() bool) {
+	t.Helper()
+
+	for start := time.Now(); !f(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > timeout {
+			t.Fatalf("timed out waiting for %s", desc)
+		}
+	}
+}
+
+func payload(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i * 7)
+	}
+	return b
+}
+
+func TestTransferWithJoin(t *testing.T) {
+	client, server := newStacks(t)
+	cep, cwq, sep, swq := connect(t, client, server, mptcp.ProtocolNumber, mptcp.ProtocolNumber)
+
+	if got := client.Stats().MPTCP.ActiveOpenings.Value(); got != 1 {
+		t.Errorf("got client ActiveOpenings = %d, want = 1", got)
+	}
+	if got := server.Stats().MPTCP.PassiveOpenings.Value(); got != 1 {
+		t.Errorf("got server PassiveOpenings = %d, want = 1", got)
+	}
+
+	// The server announces its second address, which the client joins.
+	waitFor(t, "the second subflow", func() bool {
+		return client.Stats().MPTCP.JoinsAccepted.Value() == 1 && server.Stats().MPTCP.JoinsAccepted.Value() == 1
+	})
+
+	before := client.NICInfo()[nicID2].Stats.Tx.Bytes.Value()
+	data := payload(4 << 20)
+	transfer(t, cep, cwq, sep, swq, data)
+	if sent := client.NICInfo()[nicID2].Stats.Tx.Bytes.Value() - before; sent == 0 {
+		t.Errorf("no data sent on the second subflow")
+	}
+
+	// The connection is still usable in the other direction.
+	transfer(t, sep, swq, cep, cwq, payload(1<<20))
+
+	if got := client.Stats().MPTCP.Fallbacks.Value(); got != 0 {
+		t.Errorf("got client Fallbacks = %d, want = 0", got)
+	}
+}
+
+func TestFallbackToTCPServer(t *testing.T) {
+	client, server := newStacks(t)
+	cep, cwq, sep, swq := connect(t, client, server, mptcp.ProtocolNumber, tcp.ProtocolNumber)
+
+	if got := client.Stats().MPTCP.Fallbacks.Value(); got != 1 {
+		t.Errorf("got client Fallbacks = %d, want = 1", got)
+	}
+	transfer(t, cep, cwq, sep, swq, payload(1<<20))
+	transfer(t, sep, swq, cep, cwq, payload(1<<20))
+}
+
+func TestFallbackForTCPClient(t *testing.T) {
+	client, server := newStacks(t)
+	cep, cwq, sep, swq := connect(t, client, server, tcp.ProtocolNumber, mptcp.ProtocolNumber)
+
+	transfer(t, cep, cwq, sep, swq, payload(1<<20))
+	transfer(t, sep, swq, cep, cwq, payload(1<<20))
+	if got := server.Stats().MPTCP.Fallbacks.Value(); got != 0 {
+		t.Errorf("got server Fallbacks = %d, want = 0", got)
+	}
+	if got := server.Stats().MPTCP.PassiveOpenings.Value(); got != 0 {
+		t.Errorf("got server PassiveOpenings = %d, want = 0", got)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mptcp
+
+import (
+	"sort"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// The path manager spreads connections across the NICs of the stack: the
+// passive end of a connection announces an address of each of its NICs other
+// than the NIC of the first subflow with ADD_ADDR, and the active end opens a
+// subflow to each address announced by the peer, from the address routed to
+// it.
+
+// addressesToAnnounce returns the addresses announced to the peer of a
+// connection whose first subflow is ep.
+func (e *endpoint) addressesToAnnounce(ep tcp.Subflow) []announcement {
+	local, err := ep.GetLocalAddress()
+	if err != nil {
+		return nil
+	}
+	nics := e.stack.NICInfo()
+	ids := make([]tcpip.NICID, 0, len(nics))
+	for id := range nics {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var announced []announcement
+	for _, id := range ids {
+		info := nics[id]
+		if info.Flags.Loopback || !info.Flags.Running {
+			continue
+		}
+		var addr tcpip.Address
+		for _, pa := range info.ProtocolAddresses {
+			a := pa.AddressWithPrefix.Address
+			if a == local.Addr {
+				// This is the NIC of the first subflow.
+				addr = ""
+				break
+			}
+			if len(addr) == 0 && len(a) == len(local.Addr) && !header.IsV6LinkLocalAddress(a) {
+				addr = a
+			}
+		}
+		if len(addr) == 0 {
+			continue
+		}
+		if len(announced) == maxSubflows-1 {
+			break
+		}
+		announced = append(announced, announcement{
+			opt: header.AddAddrOption{
+				AddrID: uint8(len(announced) + 1),
+				Addr:   addr,
+			},
+		})
+	}
+	return announced
+}
+
+// handleAddAddrLocked handles the address a announced by the peer on the
+// subflow sf.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) handleAddAddrLocked(sf *subflow, a header.AddAddrOption) {
+	if !e.hasRemoteKey || a.HMAC != header.MPTCPAddAddrHMAC(e.remoteKey, e.localKey, a.AddrID, a.Addr, a.Port) {
+		return
+	}
+	echo := a
+	echo.Echo = true
+	echo.HMAC = 0
+	e.pendingEcho = &echo
+	if sf.ep != nil {
+		sf.ep.NotifySubflow()
+	}
+
+	// Only the active end opens subflows, since the passive end may not be
+	// reachable from the peer, as per RFC 8684 section 3.4.
+	if e.passive || e.joined[a.AddrID] || len(e.subflows) >= maxSubflows {
+		return
+	}
+	e.joined[a.AddrID] = true
+	go e.join(a) // S/R-SAFE: MPTCP endpoints can't be saved.
+}
+
+// join opens a subflow to the address a announced by the peer.
+func (e *endpoint) join(a header.AddAddrOption) {
+	primaryLocal, err := e.primary.GetLocalAddress()
+	if err != nil {
+		return
+	}
+	remote, err := e.primary.GetRemoteAddress()
+	if err != nil {
+		return
+	}
+	remote.NIC = 0
+	remote.Addr = a.Addr
+	if a.Port != 0 {
+		remote.Port = a.Port
+	}
+
+	netProto := header.IPv4ProtocolNumber
+	if len(a.Addr) == header.IPv6AddressSize {
+		netProto = header.IPv6ProtocolNumber
+	}
+	r, err := e.stack.FindRoute(0 /* nic */, "" /* localAddr */, a.Addr, netProto, false /* multicastLoop */)
+	if err != nil {
+		return
+	}
+	local := tcpip.FullAddress{NIC: r.NICID(), Addr: r.LocalAddress}
+	r.Release()
+
+	q := &waiter.Queue{}
+	ep, err := e.stack.NewEndpoint(tcp.ProtocolNumber, netProto, q)
+	if err != nil {
+		return
+	}
+	sf := newSubflow(e, true /* active */, true /* join */)
+	sf.ep = ep.(tcp.Subflow)
+	sf.remoteAddrID = a.AddrID
+	// The address of the first subflow has ID 0 and the other addresses
+	// are identified by their NIC.
+	if local.Addr != primaryLocal.Addr {
+		sf.localAddrID = uint8(local.NIC)
+	}
+	sf.watch(q)
+	sf.ep.SetSubflowHandler(sf)
+
+	e.mu.Lock()
+	if e.state != stateConnected || e.fallback || len(e.subflows) >= maxSubflows {
+		e.mu.Unlock()
+		sf.queue.EventUnregister(&sf.entry)
+		ep.Close()
+		return
+	}
+	e.subflows = append(e.subflows, sf)
+	owner := e.owner
+	e.mu.Unlock()
+
+	if owner != nil {
+		sf.ep.SetOwner(owner)
+	}
+	err = sf.ep.Bind(local)
+	if err == nil {
+		err = sf.ep.Connect(remote)
+	}
+	if err != nil && err != tcpip.ErrConnectStarted {
+		e.subflowFailed(sf)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mptcp contains the implementation of Multipath TCP, as per RFC 8684.
+//
+// A Multipath TCP endpoint spreads a single byte stream over several TCP
+// connections, called subflows, which are implemented by the tcp package.
+// The first subflow is negotiated with the MP_CAPABLE option; further
+// subflows are opened from the addresses of the other NICs of the stack with
+// the MP_JOIN option. Connections to peers that don't support Multipath TCP
+// fall back to regular TCP.
+package mptcp
+
+import (
+	"encoding/binary"
+
+	"gvisor.dev/gvisor/pkg/rand"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	// ProtocolNumber is the MPTCP protocol number, which is the value of
+	// IPPROTO_MPTCP. It is never carried by packets, which are sent by the
+	// TCP subflows.
+	ProtocolNumber tcpip.TransportProtocolNumber = 262
+
+	// maxSubflows is the maximum number of subflows of a connection.
+	maxSubflows = 8
+)
+
+type protocol struct {
+	stack *stack.Stack
+
+	mu sync.Mutex
+
+	// tokens maps the tokens of the connections accepted by the stack to
+	// their endpoints, so that subflows can join them. It is protected by
+	// mu.
+	tokens map[uint32]*endpoint
+}
+
+// Number returns the MPTCP protocol number.
+func (*protocol) Number() tcpip.TransportProtocolNumber {
+	return ProtocolNumber
+}
+
+// NewEndpoint creates a new MPTCP endpoint.
+func (p *protocol) NewEndpoint(netProto tcpip.NetworkProtocolNumber, waiterQueue *waiter.Queue) (tcpip.Endpoint, *tcpip.Error) {
+	e, err := newEndpoint(p, netProto, waiterQueue)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// NewRawEndpoint implements stack.TransportProtocol.NewRawEndpoint. Raw MPTCP
+// endpoints are not supported.
+func (*protocol) NewRawEndpoint(tcpip.NetworkProtocolNumber, *waiter.Queue) (tcpip.Endpoint, *tcpip.Error) {
+	return nil, tcpip.ErrNotSupported
+}
+
+// MinimumPacketSize returns the minimum valid size of the segments of a
+// subflow.
+func (*protocol) MinimumPacketSize() int {
+	return header.TCPMinimumSize
+}
+
+// ParsePorts returns the source and destination ports stored in the given
+// segment of a subflow.
+func (*protocol) ParsePorts(v buffer.View) (src, dst uint16, err *tcpip.Error) {
+	h := header.TCP(v)
+	return h.SourcePort(), h.DestinationPort(), nil
+}
+
+// HandleUnknownDestinationPacket implements
+// stack.TransportProtocol.HandleUnknownDestinationPacket. Packets are never
+// delivered to this protocol.
+func (*protocol) HandleUnknownDestinationPacket(stack.TransportEndpointID, *stack.PacketBuffer) stack.UnknownDestinationPacketDisposition {
+	return stack.UnknownDestinationPacketUnhandled
+}
+
+// SetOption implements stack.TransportProtocol.SetOption.
+func (*protocol) SetOption(tcpip.SettableTransportProtocolOption) *tcpip.Error {
+	return tcpip.ErrUnknownProtocolOption
+}
+
+// Option implements stack.TransportProtocol.Option.
+func (*protocol) Option(tcpip.GettableTransportProtocolOption) *tcpip.Error {
+	return tcpip.ErrUnknownProtocolOption
+}
+
+// Close implements stack.TransportProtocol.Close.
+func (*protocol) Close() {}
+
+// Wait implements stack.TransportProtocol.Wait.
+func (*protocol) Wait() {}
+
+// Parse implements stack.TransportProtocol.Parse.
+func (*protocol) Parse(*stack.PacketBuffer) bool {
+	return false
+}
+
+// newKey returns a new random key, whose token is not used by any connection
+// of the stack.
+func (p *protocol) newKey() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b := make([]byte, 8)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		key := binary.LittleEndian.Uint64(b)
+		if _, ok := p.tokens[header.MPTCPToken(key)]; !ok {
+			return key
+		}
+	}
+}
+
+// registerToken makes the connection e, whose local key is key, joinable.
+func (p *protocol) registerToken(key uint64, e *endpoint) {
+	p.mu.Lock()
+	p.tokens[header.MPTCPToken(key)] = e
+	p.mu.Unlock()
+}
+
+// unregisterToken undoes registerToken.
+func (p *protocol) unregisterToken(key uint64, e *endpoint) {
+	token := header.MPTCPToken(key)
+	p.mu.Lock()
+	if p.tokens[token] == e {
+		delete(p.tokens, token)
+	}
+	p.mu.Unlock()
+}
+
+// lookupToken returns the connection with the given token, if any.
+func (p *protocol) lookupToken(token uint32) *endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.tokens[token]
+}
+
+// randomNonce returns a random nonce for an MP_JOIN handshake.
+func randomNonce() uint32 {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+// NewProtocol returns an MPTCP transport protocol. The stack must also have
+// the TCP transport protocol, which implements the subflows.
+func NewProtocol(s *stack.Stack) stack.TransportProtocol {
+	return &protocol{
+		stack:  s,
+		tokens: make(map[uint32]*endpoint),
+	}
+}
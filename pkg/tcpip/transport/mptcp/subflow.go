@@ -0,0 +1,529 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mptcp
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// maxMappingSize is the largest number of bytes covered by a data sequence
+// mapping.
+const maxMappingSize = 1<<16 - 1
+
+type subflowState int
+
+const (
+	// subflowSynSent is the state of an active subflow whose SYN was sent.
+	subflowSynSent subflowState = iota
+
+	// subflowSynRcvd is the state of a passive subflow whose SYN was
+	// received.
+	subflowSynRcvd
+
+	// subflowPreEstablished is the state of an active MP_JOIN subflow
+	// waiting for the fourth ACK of its handshake, as per RFC 8684
+	// section 3.2.
+	subflowPreEstablished
+
+	// subflowEstablished is the state of a subflow which carries data.
+	subflowEstablished
+
+	// subflowFallback is the state of the subflow of a connection which
+	// fell back to regular TCP.
+	subflowFallback
+
+	// subflowClosed is the state of a subflow removed from its connection.
+	subflowClosed
+)
+
+// mapping is a data sequence mapping, which maps a range of the sequence
+// space of a subflow to the data sequence space of the connection.
+type mapping struct {
+	// ssn is the relative subflow sequence number of the first byte.
+	ssn uint32
+
+	// dsn is the data sequence number of the first byte.
+	dsn uint64
+
+	// length is the number of bytes covered by the mapping.
+	length uint32
+}
+
+// contains returns true if the mapping covers the byte at ssn.
+func (m *mapping) contains(ssn uint32) bool {
+	return ssn-m.ssn < m.length
+}
+
+// subflow is a TCP connection carrying data of an MPTCP connection. It
+// implements tcp.SubflowHandler.
+type subflow struct {
+	meta *endpoint
+
+	// queue is the waiter queue of ep. Its events are forwarded to the
+	// waiter queue of meta. It is immutable once ep is set.
+	queue *waiter.Queue
+	entry waiter.Entry
+
+	// active is true if the subflow was opened by this end of the
+	// connection and join is true if it was opened with MP_JOIN. They are
+	// immutable.
+	active bool
+	join   bool
+
+	// localAddrID, remoteAddrID and localNonce are the parameters of an
+	// MP_JOIN handshake. They are immutable.
+	localAddrID  uint8
+	remoteAddrID uint8
+	localNonce   uint32
+
+	// readMu serializes reads from ep, so that the bytes read are mapped
+	// in order.
+	readMu sync.Mutex
+
+	// The following fields are protected by meta.mu.
+
+	// ep is the TCP endpoint of the subflow. It's nil until a passive
+	// subflow is accepted.
+	ep tcp.Subflow
+
+	state       subflowState
+	backup      bool
+	remoteNonce uint32
+
+	// sndNxt is the relative sequence number of the next byte written to
+	// ep, sndAcked is the relative sequence number acknowledged by the
+	// peer, and sndMaps are the mappings of the bytes that may still be
+	// (re)transmitted.
+	sndNxt   uint32
+	sndAcked uint32
+	sndMaps  []mapping
+
+	// rcvNxt is the relative sequence number of the next byte read from
+	// ep and rcvMaps are the mappings received for the bytes that have
+	// not been read yet, sorted by sequence number.
+	rcvNxt  uint32
+	rcvMaps []mapping
+}
+
+func newSubflow(meta *endpoint, active, join bool) *subflow {
+	sf := &subflow{
+		meta:   meta,
+		active: active,
+		join:   join,
+		sndNxt: 1,
+		rcvNxt: 1,
+	}
+	if active {
+		sf.state = subflowSynSent
+	} else {
+		sf.state = subflowSynRcvd
+	}
+	if join {
+		sf.localNonce = randomNonce()
+	}
+	sf.entry.Callback = sf
+	return sf
+}
+
+// watch forwards the events of queue, the waiter queue of the TCP endpoint
+// of the subflow, to the connection.
+func (sf *subflow) watch(queue *waiter.Queue) {
+	sf.queue = queue
+	sf.queue.EventRegister(&sf.entry, waiter.EventIn|waiter.EventOut|waiter.EventHUp|waiter.EventErr)
+}
+
+// Callback implements waiter.EntryCallback.Callback.
+func (sf *subflow) Callback(_ *waiter.Entry, mask waiter.EventMask) {
+	sf.meta.handleSubflowEvent(sf, mask)
+}
+
+// usableLocked returns true if data can be sent on the subflow.
+//
+// Precondition: sf.meta.mu must be held.
+func (sf *subflow) usableLocked() bool {
+	return sf.state == subflowEstablished && sf.ep != nil
+}
+
+// joinHMAC returns the HMAC of the MP_JOIN handshake sent by this end of the
+// subflow.
+//
+// Precondition: sf.meta.mu must be held.
+func (sf *subflow) joinHMAC() []byte {
+	return header.MPTCPJoinHMAC(sf.meta.localKey, sf.meta.remoteKey, sf.localNonce, sf.remoteNonce)
+}
+
+// peerJoinHMAC returns the HMAC of the MP_JOIN handshake expected from the
+// peer.
+//
+// Precondition: sf.meta.mu must be held.
+func (sf *subflow) peerJoinHMAC() []byte {
+	return header.MPTCPJoinHMAC(sf.meta.remoteKey, sf.meta.localKey, sf.remoteNonce, sf.localNonce)
+}
+
+// SynOptions implements tcp.SubflowHandler.SynOptions.
+func (sf *subflow) SynOptions(synAck bool, b []byte) int {
+	m := sf.meta
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if sf.join {
+		j := header.MPJoinOption{
+			Backup: sf.backup,
+			AddrID: sf.localAddrID,
+			Nonce:  sf.localNonce,
+		}
+		if !synAck {
+			j.Token = header.MPTCPToken(m.remoteKey)
+			return header.EncodeMPJoinSynOption(j, b)
+		}
+		j.TruncatedHMAC = binary.BigEndian.Uint64(sf.joinHMAC())
+		return header.EncodeMPJoinSynAckOption(j, b)
+	}
+
+	c := header.MPCapableOption{
+		Version: header.MPTCPVersion,
+		Flags:   header.MPCapableFlagHMACSHA256,
+	}
+	if synAck {
+		c.NumKeys = 1
+		c.SenderKey = m.localKey
+	}
+	return header.EncodeMPCapableOption(c, b)
+}
+
+// Options implements tcp.SubflowHandler.Options.
+func (sf *subflow) Options(seg tcp.SubflowSegment, b []byte) int {
+	m := sf.meta
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch sf.state {
+	case subflowPreEstablished:
+		// Keep sending the HMAC of the third ACK until the fourth ACK
+		// is received.
+		j := header.MPJoinOption{}
+		copy(j.HMAC[:], sf.joinHMAC())
+		return header.EncodeMPJoinAckOption(j, b)
+	case subflowEstablished:
+	default:
+		return 0
+	}
+
+	var mp *mapping
+	if seg.Len > 0 {
+		mp = sf.sndMappingLocked(seg.SSN)
+	}
+
+	// The initiator of the connection keeps sending MP_CAPABLE until it
+	// receives a DSS from the peer, as per RFC 8684 section 3.1. The first
+	// data segment carries the mapping of its data.
+	if sf.active && !sf.join && !m.peerConfirmed {
+		c := header.MPCapableOption{
+			Version:     header.MPTCPVersion,
+			Flags:       header.MPCapableFlagHMACSHA256,
+			NumKeys:     2,
+			SenderKey:   m.localKey,
+			ReceiverKey: m.remoteKey,
+		}
+		switch {
+		case seg.Len == 0:
+			return header.EncodeMPCapableOption(c, b)
+		case mp != nil && mp.ssn == 1 && mp.dsn == header.MPTCPIDSN(m.localKey)+1:
+			c.HasDataLen = true
+			c.DataLen = uint16(mp.length)
+			return header.EncodeMPCapableOption(c, b)
+		}
+	}
+
+	var d header.DSSOption
+	if m.rcvKnown {
+		d.HasDataAck = true
+		d.DataAck = m.rcvNxt
+	}
+	switch {
+	case mp != nil:
+		d.HasMapping = true
+		d.DSN = mp.dsn
+		d.SSN = mp.ssn
+		d.DataLen = uint16(mp.length)
+	case seg.Len == 0 && m.sndClosed && !m.dataFinAcked:
+		// A DATA_FIN which isn't carried by data is sent with a
+		// mapping of length 1 and a zero subflow sequence number, as
+		// per RFC 8684 section 3.3.3.
+		d.HasMapping = true
+		d.DSN = m.sndNxt
+		d.DataLen = 1
+		d.DataFIN = true
+	}
+
+	n := 0
+	if d.HasDataAck || d.HasMapping {
+		n = header.EncodeDSSOption(d, b)
+	}
+	if d.HasMapping {
+		return n
+	}
+	if a := m.pendingEcho; a != nil {
+		if l := header.EncodeAddAddrOption(*a, b[n:]); l != 0 {
+			n += l
+			m.pendingEcho = nil
+		} else if seg.Len == 0 {
+			// There isn't enough room for both the DSS and the echo;
+			// the data ACK is carried by the following segments.
+			n = header.EncodeAddAddrOption(*a, b)
+			m.pendingEcho = nil
+		}
+	}
+	// Addresses are announced on the segments without mappings until the
+	// peer echoes them.
+	for i := range m.announced {
+		if a := &m.announced[i]; !a.echoed && m.hasRemoteKey {
+			opt := a.opt
+			opt.HMAC = header.MPTCPAddAddrHMAC(m.localKey, m.remoteKey, opt.AddrID, opt.Addr, opt.Port)
+			n += header.EncodeAddAddrOption(opt, b[n:])
+			break
+		}
+	}
+	return n
+}
+
+// MaxOptionsSize implements tcp.SubflowHandler.MaxOptionsSize.
+func (*subflow) MaxOptionsSize() int {
+	return header.MPTCPMaxDSSLength
+}
+
+// HandleSegment implements tcp.SubflowHandler.HandleSegment.
+func (sf *subflow) HandleSegment(seg tcp.SubflowSegment) bool {
+	opts := header.ParseMPTCPOptions(seg.Options)
+	m := sf.meta
+	m.mu.Lock()
+	ok, notify := sf.handleSegmentLocked(seg, &opts)
+	m.mu.Unlock()
+	if notify != 0 {
+		m.waiterQueue.Notify(notify)
+	}
+	return ok
+}
+
+// handleSegmentLocked handles the received segment seg, whose MPTCP options
+// are opts. It returns false if the subflow must be reset, and the events to
+// notify the waiters of the connection of.
+//
+// Precondition: sf.meta.mu must be held.
+func (sf *subflow) handleSegmentLocked(seg tcp.SubflowSegment, opts *header.MPTCPOptions) (bool, waiter.EventMask) {
+	m := sf.meta
+	switch sf.state {
+	case subflowSynSent:
+		if sf.join {
+			// The SYN-ACK must authenticate the peer.
+			if !opts.HasJoin {
+				return false, 0
+			}
+			sf.remoteNonce = opts.Join.Nonce
+			want := binary.BigEndian.Uint64(sf.peerJoinHMAC())
+			if opts.Join.TruncatedHMAC != want {
+				m.stack.Stats().MPTCP.JoinsFailed.Increment()
+				return false, 0
+			}
+			sf.backup = sf.backup || opts.Join.Backup
+			sf.state = subflowPreEstablished
+			return true, 0
+		}
+		c := &opts.Capable
+		if !opts.HasCapable || c.NumKeys < 1 || c.Version != header.MPTCPVersion || c.Flags&header.MPCapableFlagChecksum != 0 {
+			// The peer doesn't support MPTCP, or requires DSS
+			// checksums which aren't supported.
+			sf.state = subflowFallback
+			m.fallback = true
+			m.stack.Stats().MPTCP.Fallbacks.Increment()
+			return true, 0
+		}
+		sf.state = subflowEstablished
+		m.state = stateConnected
+		m.setRemoteKeyLocked(c.SenderKey)
+		m.stack.Stats().MPTCP.ActiveOpenings.Increment()
+		return true, waiter.EventOut
+
+	case subflowSynRcvd:
+		if sf.join {
+			if !opts.HasJoin || subtle.ConstantTimeCompare(opts.Join.HMAC[:], sf.peerJoinHMAC()[:header.MPJoinHMACLength]) != 1 {
+				m.stack.Stats().MPTCP.JoinsFailed.Increment()
+				return false, 0
+			}
+			sf.state = subflowEstablished
+			m.stack.Stats().MPTCP.JoinsAccepted.Increment()
+			return true, waiter.EventOut
+		}
+		switch {
+		case opts.HasCapable && opts.Capable.NumKeys == 2:
+			if opts.Capable.ReceiverKey != m.localKey {
+				return false, 0
+			}
+		case opts.HasDSS:
+			// The third ACK was lost; the keys are carried by the
+			// MP_CAPABLE option of the first data segment.
+		default:
+			sf.state = subflowFallback
+			m.fallback = true
+			m.stack.Stats().MPTCP.Fallbacks.Increment()
+			return true, 0
+		}
+		sf.state = subflowEstablished
+		m.state = stateConnected
+		m.stack.Stats().MPTCP.PassiveOpenings.Increment()
+
+	case subflowPreEstablished:
+		// This is the fourth ACK of the MP_JOIN handshake.
+		sf.state = subflowEstablished
+		m.stack.Stats().MPTCP.JoinsAccepted.Increment()
+		notify := m.handleOptionsLocked(sf, seg, opts)
+		return true, notify | waiter.EventOut
+
+	case subflowEstablished:
+
+	default:
+		return true, 0
+	}
+	return true, m.handleOptionsLocked(sf, seg, opts)
+}
+
+// sndMappingLocked returns the mapping of the byte sent at ssn, if any.
+//
+// Precondition: sf.meta.mu must be held.
+func (sf *subflow) sndMappingLocked(ssn uint32) *mapping {
+	for i := range sf.sndMaps {
+		if sf.sndMaps[i].contains(ssn) {
+			return &sf.sndMaps[i]
+		}
+	}
+	return nil
+}
+
+// addSndMappingLocked records that the length bytes starting at dsn are
+// written next to the subflow.
+//
+// Precondition: sf.meta.mu and sf.meta.sndMu must be held.
+func (sf *subflow) addSndMappingLocked(dsn uint64, length int) {
+	sf.sndMaps = append(sf.sndMaps, mapping{
+		ssn:    sf.sndNxt,
+		dsn:    dsn,
+		length: uint32(length),
+	})
+	sf.sndNxt += uint32(length)
+}
+
+// handleAckLocked releases the mappings of the bytes acknowledged by the
+// relative acknowledgement number ack.
+//
+// Precondition: sf.meta.mu must be held.
+func (sf *subflow) handleAckLocked(ack uint32) {
+	if int32(ack-sf.sndAcked) <= 0 || int32(ack-sf.sndNxt) > 0 {
+		return
+	}
+	sf.sndAcked = ack
+	i := 0
+	for ; i < len(sf.sndMaps); i++ {
+		mp := &sf.sndMaps[i]
+		if int32(mp.ssn+mp.length-ack) > 0 {
+			break
+		}
+	}
+	sf.sndMaps = append(sf.sndMaps[:0], sf.sndMaps[i:]...)
+}
+
+// addRcvMappingLocked records a mapping received from the peer.
+//
+// Precondition: sf.meta.mu must be held.
+func (sf *subflow) addRcvMappingLocked(mp mapping) {
+	if mp.length == 0 || int32(mp.ssn+mp.length-sf.rcvNxt) <= 0 {
+		return
+	}
+	// Mappings are repeated by every segment they cover, so most of them
+	// are already known.
+	i := len(sf.rcvMaps)
+	for i > 0 {
+		prev := &sf.rcvMaps[i-1]
+		if prev.ssn == mp.ssn {
+			return
+		}
+		if int32(prev.ssn-mp.ssn) < 0 {
+			break
+		}
+		i--
+	}
+	sf.rcvMaps = append(sf.rcvMaps, mapping{})
+	copy(sf.rcvMaps[i+1:], sf.rcvMaps[i:])
+	sf.rcvMaps[i] = mp
+}
+
+// mapReceivedLocked maps the bytes v read from the subflow to the data
+// sequence space and delivers them to the connection. It returns false if
+// some of them aren't covered by any mapping.
+//
+// Precondition: sf.meta.mu and sf.readMu must be held.
+func (sf *subflow) mapReceivedLocked(v []byte) bool {
+	m := sf.meta
+	for len(v) > 0 {
+		for len(sf.rcvMaps) > 0 && !sf.rcvMaps[0].contains(sf.rcvNxt) && int32(sf.rcvMaps[0].ssn-sf.rcvNxt) <= 0 {
+			// The mapping was fully consumed.
+			sf.rcvMaps = sf.rcvMaps[1:]
+		}
+		if len(sf.rcvMaps) == 0 || !sf.rcvMaps[0].contains(sf.rcvNxt) {
+			return false
+		}
+		mp := &sf.rcvMaps[0]
+		off := sf.rcvNxt - mp.ssn
+		n := int(mp.length - off)
+		if n > len(v) {
+			n = len(v)
+		}
+		m.deliverLocked(mp.dsn+uint64(off), v[:n])
+		sf.rcvNxt += uint32(n)
+		v = v[n:]
+	}
+	return true
+}
+
+// removeLocked removes the subflow from its connection.
+//
+// Precondition: sf.meta.mu must be held.
+func (sf *subflow) removeLocked() {
+	if sf.state == subflowClosed {
+		return
+	}
+	sf.state = subflowClosed
+	m := sf.meta
+	for i, s := range m.subflows {
+		if s == sf {
+			m.subflows = append(m.subflows[:i], m.subflows[i+1:]...)
+			break
+		}
+	}
+}
+
+// lastError returns the error of the TCP endpoint of the subflow.
+func (sf *subflow) lastError() *tcpip.Error {
+	if err := sf.ep.LastError(); err != nil {
+		return err
+	}
+	return tcpip.ErrConnectionReset
+}
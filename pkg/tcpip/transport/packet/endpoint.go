@@ -76,6 +76,9 @@ type endpoint struct {
 	rcvBufSizeMax int `state:".(int)"`
 	rcvBufSize    int
 	rcvClosed     bool
+	// ring is the ring the received packets are delivered into instead
+	// of rcvList, if set with PacketRingOption.
+	ring tcpip.PacketRing
 
 	// The following fields are protected by mu.
 	mu            sync.RWMutex `state:"nosave"`
@@ -87,6 +90,11 @@ type endpoint struct {
 	boundNIC      tcpip.NICID
 	// linger is used for SO_LINGER socket option.
 	linger tcpip.LingerOption
+	// fanout is the fanout group the endpoint joined with
+	// PacketFanoutOption, if fanoutJoined is true. The group is
+	// registered for the packets of the endpoint in place of it.
+	fanout       tcpip.PacketFanoutOption
+	fanoutJoined bool
 
 	// lastErrorMu protects lastError.
 	lastErrorMu sync.Mutex   `state:"nosave"`
@@ -142,7 +150,11 @@ func (ep *endpoint) Close() {
 		return
 	}
 
-	ep.stack.UnregisterPacketEndpoint(0, ep.netProto, ep)
+	if ep.fanoutJoined {
+		ep.stack.LeavePacketFanout(ep.fanout.ID, ep)
+	} else {
+		ep.stack.UnregisterPacketEndpoint(0, ep.netProto, ep)
+	}
 
 	ep.rcvMu.Lock()
 	defer ep.rcvMu.Unlock()
@@ -195,14 +207,119 @@ func (ep *endpoint) ReadPacket(addr *tcpip.FullAddress, info *tcpip.LinkPacketIn
 	return packet.data.ToView(), tcpip.ControlMessages{HasTimestamp: true, Timestamp: packet.timestampNS}, nil
 }
 
+// ReadBatch implements tcpip.BatchEndpoint.ReadBatch.
+func (ep *endpoint) ReadBatch(msgs []tcpip.ReadMessage) (int, *tcpip.Error) {
+	ep.rcvMu.Lock()
+
+	if ep.rcvList.Empty() {
+		err := tcpip.ErrWouldBlock
+		if ep.rcvClosed {
+			ep.stats.ReadErrors.ReadClosed.Increment()
+			err = tcpip.ErrClosedForReceive
+		}
+		ep.rcvMu.Unlock()
+		return 0, err
+	}
+
+	packets := make([]*packet, 0, len(msgs))
+	for len(packets) < len(msgs) && !ep.rcvList.Empty() {
+		packet := ep.rcvList.Front()
+		ep.rcvList.Remove(packet)
+		ep.rcvBufSize -= packet.data.Size()
+		packets = append(packets, packet)
+	}
+
+	ep.rcvMu.Unlock()
+
+	for i, packet := range packets {
+		msgs[i] = tcpip.ReadMessage{
+			Data:    packet.data.ToView(),
+			Addr:    packet.senderAddr,
+			Info:    packet.packetInfo,
+			Control: tcpip.ControlMessages{HasTimestamp: true, Timestamp: packet.timestampNS},
+		}
+	}
+	return len(packets), nil
+}
+
 // Read implements tcpip.Endpoint.Read.
 func (ep *endpoint) Read(addr *tcpip.FullAddress) (buffer.View, tcpip.ControlMessages, *tcpip.Error) {
 	return ep.ReadPacket(addr, nil)
 }
 
-func (*endpoint) Write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, <-chan struct{}, *tcpip.Error) {
-	// TODO(gvisor.dev/issue/173): Implement.
-	return 0, nil, tcpip.ErrInvalidOptionValue
+// Write implements tcpip.Endpoint.Write.
+//
+// The packet is sent on the NIC of opts.To, or the NIC the endpoint is bound
+// to. Raw endpoints write the link header along with the payload, and cooked
+// endpoints write to the link address of opts.To.
+func (ep *endpoint) Write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, <-chan struct{}, *tcpip.Error) {
+	ep.mu.RLock()
+	closed := ep.closed
+	nicID := ep.boundNIC
+	ep.mu.RUnlock()
+	if closed {
+		return 0, nil, tcpip.ErrClosedForSend
+	}
+	return ep.write(p, opts, nicID)
+}
+
+// WriteBatch implements tcpip.BatchEndpoint.WriteBatch.
+func (ep *endpoint) WriteBatch(msgs []tcpip.WriteMessage) (int, <-chan struct{}, *tcpip.Error) {
+	ep.mu.RLock()
+	closed := ep.closed
+	nicID := ep.boundNIC
+	ep.mu.RUnlock()
+	if closed {
+		return 0, nil, tcpip.ErrClosedForSend
+	}
+
+	for i, m := range msgs {
+		if _, _, err := ep.write(m.Payload, m.Options, nicID); err != nil {
+			return i, nil, err
+		}
+	}
+	return len(msgs), nil, nil
+}
+
+// write writes p with opts on the NIC of opts.To, or nicID if opts.To has
+// none.
+func (ep *endpoint) write(p tcpip.Payloader, opts tcpip.WriteOptions, nicID tcpip.NICID) (int64, <-chan struct{}, *tcpip.Error) {
+	var remote tcpip.LinkAddress
+	if opts.To != nil {
+		if opts.To.NIC != 0 {
+			nicID = opts.To.NIC
+		}
+		remote = tcpip.LinkAddress(opts.To.Addr)
+	} else if ep.cooked {
+		return 0, nil, tcpip.ErrDestinationRequired
+	}
+	if nicID == 0 {
+		return 0, nil, tcpip.ErrDestinationRequired
+	}
+
+	v, err := p.FullPayload()
+	if err != nil {
+		return 0, nil, err
+	}
+	payload := buffer.View(v)
+	netProto := ep.netProto
+	if !ep.cooked {
+		// The link header is written by the NIC, from the fields of
+		// the ethernet header written by the application.
+		if len(payload) < header.EthernetMinimumSize {
+			return 0, nil, tcpip.ErrInvalidOptionValue
+		}
+		eth := header.Ethernet(payload)
+		remote = eth.DestinationAddress()
+		netProto = eth.Type()
+		payload = payload[header.EthernetMinimumSize:]
+	}
+
+	if err := ep.stack.WritePacketToRemote(nicID, remote, netProto, append(buffer.View(nil), payload...).ToVectorisedView()); err != nil {
+		return 0, nil, err
+	}
+	ep.stats.PacketsSent.Increment()
+	return int64(len(v)), nil, nil
 }
 
 // Peek implements tcpip.Endpoint.Peek.
@@ -259,6 +376,12 @@ func (ep *endpoint) Bind(addr tcpip.FullAddress) *tcpip.Error {
 		return nil
 	}
 
+	// The members of a fanout group must receive the packets of the same
+	// NIC.
+	if ep.fanoutJoined {
+		return tcpip.ErrInvalidEndpointState
+	}
+
 	// Unregister endpoint with all the nics.
 	ep.stack.UnregisterPacketEndpoint(0, ep.netProto, ep)
 	ep.bound = false
@@ -316,6 +439,31 @@ func (ep *endpoint) SetSockOpt(opt tcpip.SettableSocketOption) *tcpip.Error {
 		ep.mu.Unlock()
 		return nil
 
+	case *tcpip.PacketRingOption:
+		ep.rcvMu.Lock()
+		ep.ring = v.Ring
+		ep.rcvMu.Unlock()
+		return nil
+
+	case *tcpip.PacketFanoutOption:
+		ep.mu.Lock()
+		defer ep.mu.Unlock()
+
+		// As in Linux, an endpoint can't leave or change its group.
+		if ep.fanoutJoined {
+			return tcpip.ErrAlreadyConnecting
+		}
+		ep.stack.UnregisterPacketEndpoint(ep.boundNIC, ep.netProto, ep)
+		if err := ep.stack.JoinPacketFanout(v.ID, v.Mode, ep.boundNIC, ep.netProto, ep); err != nil {
+			if err := ep.stack.RegisterPacketEndpoint(ep.boundNIC, ep.netProto, ep); err != nil {
+				panic(fmt.Sprintf("RegisterPacketEndpoint(%d, %d, _): %s", ep.boundNIC, ep.netProto, err))
+			}
+			return err
+		}
+		ep.fanout = *v
+		ep.fanoutJoined = true
+		return nil
+
 	default:
 		return tcpip.ErrUnknownProtocolOption
 	}
@@ -383,6 +531,12 @@ func (ep *endpoint) GetSockOpt(opt tcpip.GettableSocketOption) *tcpip.Error {
 		ep.mu.Unlock()
 		return nil
 
+	case *tcpip.PacketFanoutOption:
+		ep.mu.RLock()
+		*o = ep.fanout
+		ep.mu.RUnlock()
+		return nil
+
 	default:
 		return tcpip.ErrNotSupported
 	}
@@ -430,7 +584,10 @@ func (ep *endpoint) HandlePacket(nicID tcpip.NICID, localAddr tcpip.LinkAddress,
 		return
 	}
 
-	if ep.rcvBufSize >= ep.rcvBufSizeMax {
+	// Packets delivered into a ring are not accounted in the receive
+	// buffer.
+	ring := ep.ring
+	if ring == nil && ep.rcvBufSize >= ep.rcvBufSizeMax {
 		ep.rcvMu.Unlock()
 		ep.stack.Stats().DroppedPackets.Increment()
 		ep.stats.ReceiveErrors.ReceiveBufferOverflow.Increment()
@@ -509,6 +666,17 @@ func (ep *endpoint) HandlePacket(nicID tcpip.NICID, localAddr tcpip.LinkAddress,
 	}
 	packet.timestampNS = ep.stack.Clock().NowNanoseconds()
 
+	if ring != nil {
+		ep.rcvMu.Unlock()
+		if !ring.Deliver(packet.data, packet.senderAddr, packet.packetInfo, packet.timestampNS) {
+			ep.stack.Stats().DroppedPackets.Increment()
+			ep.stats.ReceiveErrors.ReceiveBufferOverflow.Increment()
+			return
+		}
+		ep.stats.PacketsReceived.Increment()
+		return
+	}
+
 	ep.rcvList.PushBack(&packet)
 	ep.rcvBufSize += packet.data.Size()
 
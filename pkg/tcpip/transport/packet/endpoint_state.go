@@ -66,6 +66,13 @@ func (ep *endpoint) afterLoad() {
 	// StackFromEnv is a stack used specifically for save/restore.
 	ep.stack = stack.StackFromEnv
 
+	if ep.fanoutJoined {
+		if err := ep.stack.JoinPacketFanout(ep.fanout.ID, ep.fanout.Mode, ep.boundNIC, ep.netProto, ep); err != nil {
+			panic(*err)
+		}
+		return
+	}
+
 	// TODO(gvisor.dev/173): Once bind is supported, choose the right NIC.
 	if err := ep.stack.RegisterPacketEndpoint(0, ep.netProto, ep); err != nil {
 		panic(*err)
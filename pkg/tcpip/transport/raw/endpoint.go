@@ -26,6 +26,7 @@
 package raw
 
 import (
+	"encoding/binary"
 	"fmt"
 
 	"gvisor.dev/gvisor/pkg/sync"
@@ -81,6 +82,13 @@ type endpoint struct {
 	closed        bool
 	connected     bool
 	bound         bool
+	// pmtud is the path MTU discovery setting, one of the
+	// tcpip.PMTUDiscovery* values. It does not apply to packets written with
+	// their network header included.
+	pmtud int
+	// checksumOffset is the offset of the checksum in the packets of IPv6
+	// endpoints, or -1 if checksums are disabled, as per IPV6_CHECKSUM.
+	checksumOffset int
 	// route is the route to a remote network endpoint. It is set via
 	// Connect(), and is valid only when conneted is true.
 	route *stack.Route                 `state:"manual"`
@@ -93,6 +101,10 @@ type endpoint struct {
 
 	// ops is used to get socket level options.
 	ops tcpip.SocketOptions
+
+	// mroute holds the multicast routing state of the endpoint when it is the
+	// stack's multicast router.
+	mroute multicastRouter `state:"nosave"`
 }
 
 // NewEndpoint returns a raw  endpoint for the given protocols.
@@ -111,10 +123,17 @@ func newEndpoint(s *stack.Stack, netProto tcpip.NetworkProtocolNumber, transProt
 			NetProto:   netProto,
 			TransProto: transProto,
 		},
-		waiterQueue:   waiterQueue,
-		rcvBufSizeMax: 32 * 1024,
-		sndBufSizeMax: 32 * 1024,
-		associated:    associated,
+		waiterQueue:    waiterQueue,
+		rcvBufSizeMax:  32 * 1024,
+		sndBufSizeMax:  32 * 1024,
+		associated:     associated,
+		pmtud:          tcpip.PMTUDiscoveryDont,
+		checksumOffset: -1,
+	}
+	if netProto == header.IPv6ProtocolNumber && transProto == header.ICMPv6ProtocolNumber {
+		// Like Linux, the checksum of ICMPv6 packets is always computed
+		// and validated, as per RFC 3542 section 3.1.
+		e.checksumOffset = header.ICMPv6ChecksumOffset
 	}
 	e.ops.InitHandler(e)
 	e.ops.SetHeaderIncluded(!associated)
@@ -160,6 +179,7 @@ func (e *endpoint) Close() {
 		return
 	}
 
+	e.stopMulticastRouter()
 	e.stack.UnregisterRawTransportEndpoint(e.RegisterNICID, e.NetProto, e.TransProto, e)
 
 	e.rcvMu.Lock()
@@ -333,7 +353,7 @@ func (e *endpoint) write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, <-c
 
 	// Find the route to the destination. If BindAddress is 0,
 	// FindRoute will choose an appropriate source address.
-	route, err := e.stack.FindRoute(nic, e.BindAddr, opts.To.Addr, e.NetProto, false)
+	route, err := e.stack.FindRouteWithPolicy(nic, e.BindAddr, opts.To.Addr, e.NetProto, false, stack.RoutePolicyKey{Mark: e.ops.GetMark()})
 	if err != nil {
 		e.mu.RUnlock()
 		return 0, nil, err
@@ -368,15 +388,26 @@ func (e *endpoint) finishWrite(payloadBytes []byte, route *stack.Route) (int64,
 			return 0, nil, err
 		}
 	} else {
+		dontFragment, ignorePathMTU, err := route.PMTUDiscoveryParams(e.pmtud, len(payloadBytes))
+		if err != nil {
+			return 0, nil, err
+		}
 		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
 			ReserveHeaderBytes: int(route.MaxHeaderLength()),
 			Data:               buffer.View(payloadBytes).ToVectorisedView(),
 		})
+		if e.checksumOffset >= 0 && e.NetProto == header.IPv6ProtocolNumber {
+			if err := e.setChecksum(pkt, route); err != nil {
+				return 0, nil, err
+			}
+		}
 		pkt.Owner = e.owner
 		if err := route.WritePacket(nil /* gso */, stack.NetworkHeaderParams{
-			Protocol: e.TransProto,
-			TTL:      route.DefaultTTL(),
-			TOS:      stack.DefaultTOS,
+			Protocol:      e.TransProto,
+			TTL:           route.DefaultTTL(),
+			TOS:           stack.DefaultTOS,
+			DontFragment:  dontFragment,
+			IgnorePathMTU: ignorePathMTU,
 		}, pkt); err != nil {
 			return 0, nil, err
 		}
@@ -385,6 +416,47 @@ func (e *endpoint) finishWrite(payloadBytes []byte, route *stack.Route) (int64,
 	return int64(len(payloadBytes)), nil, nil
 }
 
+// setChecksum computes the checksum of the payload of pkt, sent on route, and
+// writes it at the checksum offset of the endpoint.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) setChecksum(pkt *stack.PacketBuffer, route *stack.Route) *tcpip.Error {
+	size := pkt.Data.Size()
+	off := e.checksumOffset
+	if off+2 > size {
+		return tcpip.ErrInvalidOptionValue
+	}
+	// The payload may be the caller's buffer, so it is copied before the
+	// checksum is written.
+	v := pkt.Data.ToOwnedView()
+	v[off], v[off+1] = 0, 0
+	xsum := header.PseudoHeaderChecksum(e.TransProto, route.LocalAddress, route.RemoteAddress, uint16(size))
+	xsum = ^header.Checksum(v, xsum)
+	binary.BigEndian.PutUint16(v[off:], xsum)
+	pkt.Data = v.ToVectorisedView()
+	return nil
+}
+
+// checksumValid returns true if the checksum of the transport payload of pkt is
+// valid, for endpoints which validate it.
+func (e *endpoint) checksumValid(pkt *stack.PacketBuffer) bool {
+	e.mu.RLock()
+	off := e.checksumOffset
+	e.mu.RUnlock()
+	if off < 0 || e.NetProto != header.IPv6ProtocolNumber || pkt.RXTransportChecksumValidated {
+		return true
+	}
+	transport := pkt.TransportHeader().View()
+	size := len(transport) + pkt.Data.Size()
+	if off+2 > size {
+		return false
+	}
+	netHdr := pkt.Network()
+	xsum := header.PseudoHeaderChecksum(e.TransProto, netHdr.SourceAddress(), netHdr.DestinationAddress(), uint16(size))
+	xsum = header.Checksum(transport, xsum)
+	return header.ChecksumVV(pkt.Data, xsum) == 0xffff
+}
+
 // Peek implements tcpip.Endpoint.Peek.
 func (e *endpoint) Peek([][]byte) (int64, tcpip.ControlMessages, *tcpip.Error) {
 	return 0, tcpip.ControlMessages{}, nil
@@ -421,7 +493,7 @@ func (e *endpoint) Connect(addr tcpip.FullAddress) *tcpip.Error {
 	}
 
 	// Find a route to the destination.
-	route, err := e.stack.FindRoute(nic, tcpip.Address(""), addr.Addr, e.NetProto, false)
+	route, err := e.stack.FindRouteWithPolicy(nic, tcpip.Address(""), addr.Addr, e.NetProto, false, stack.RoutePolicyKey{Mark: e.ops.GetMark()})
 	if err != nil {
 		return err
 	}
@@ -530,6 +602,18 @@ func (e *endpoint) SetSockOpt(opt tcpip.SettableSocketOption) *tcpip.Error {
 		e.mu.Unlock()
 		return nil
 
+	case *tcpip.AddMulticastRoutingInterfaceOption:
+		return e.addMulticastInterface(v)
+
+	case *tcpip.RemoveMulticastRoutingInterfaceOption:
+		return e.removeMulticastInterface(v)
+
+	case *tcpip.AddMulticastRouteOption:
+		return e.addMulticastRoute(v)
+
+	case *tcpip.RemoveMulticastRouteOption:
+		return e.removeMulticastRoute(v)
+
 	default:
 		return tcpip.ErrUnknownProtocolOption
 	}
@@ -574,6 +658,35 @@ func (e *endpoint) SetSockOptInt(opt tcpip.SockOptInt, v int) *tcpip.Error {
 		e.rcvMu.Unlock()
 		return nil
 
+	case tcpip.MulticastRouterOption:
+		return e.setMulticastRouter(v != 0)
+
+	case tcpip.MTUDiscoverOption:
+		if v < tcpip.PMTUDiscoveryWant || v > tcpip.PMTUDiscoveryOmit {
+			return tcpip.ErrInvalidOptionValue
+		}
+		e.mu.Lock()
+		e.pmtud = v
+		e.mu.Unlock()
+		return nil
+
+	case tcpip.IPv6ChecksumOption:
+		if e.NetProto != header.IPv6ProtocolNumber {
+			return tcpip.ErrUnknownProtocolOption
+		}
+		// Like Linux, the checksum offset of ICMPv6 endpoints cannot be
+		// changed, and must be even.
+		if e.TransProto == header.ICMPv6ProtocolNumber || (v > 0 && v%2 != 0) {
+			return tcpip.ErrInvalidOptionValue
+		}
+		if v < 0 {
+			v = -1
+		}
+		e.mu.Lock()
+		e.checksumOffset = v
+		e.mu.Unlock()
+		return nil
+
 	default:
 		return tcpip.ErrUnknownProtocolOption
 	}
@@ -618,6 +731,27 @@ func (e *endpoint) GetSockOptInt(opt tcpip.SockOptInt) (int, *tcpip.Error) {
 		e.rcvMu.Unlock()
 		return v, nil
 
+	case tcpip.MulticastRouterOption:
+		if e.isMulticastRouter() {
+			return 1, nil
+		}
+		return 0, nil
+
+	case tcpip.MTUDiscoverOption:
+		e.mu.RLock()
+		v := e.pmtud
+		e.mu.RUnlock()
+		return v, nil
+
+	case tcpip.IPv6ChecksumOption:
+		if e.NetProto != header.IPv6ProtocolNumber {
+			return -1, tcpip.ErrUnknownProtocolOption
+		}
+		e.mu.RLock()
+		v := e.checksumOffset
+		e.mu.RUnlock()
+		return v, nil
+
 	default:
 		return -1, tcpip.ErrUnknownProtocolOption
 	}
@@ -625,6 +759,12 @@ func (e *endpoint) GetSockOptInt(opt tcpip.SockOptInt) (int, *tcpip.Error) {
 
 // HandlePacket implements stack.RawTransportEndpoint.HandlePacket.
 func (e *endpoint) HandlePacket(pkt *stack.PacketBuffer) {
+	if !e.checksumValid(pkt) {
+		e.stack.Stats().DroppedPackets.Increment()
+		e.stats.ReceiveErrors.ChecksumErrors.Increment()
+		return
+	}
+
 	e.rcvMu.Lock()
 
 	// Drop the packet if our buffer is currently full or if this is an unassociated
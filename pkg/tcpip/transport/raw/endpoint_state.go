@@ -79,7 +79,7 @@ func (e *endpoint) Resume(s *stack.Stack) {
 		// to a route instead of the route by value, we pass the empty address
 		// directly. Obviously this was always wrong since we should provide the
 		// remote address we were connected to, to properly restore the route.
-		e.route, err = e.stack.FindRoute(e.RegisterNICID, e.BindAddr, "", e.NetProto, false)
+		e.route, err = e.stack.FindRouteWithPolicy(e.RegisterNICID, e.BindAddr, "", e.NetProto, false, stack.RoutePolicyKey{Mark: e.ops.GetMark()})
 		if err != nil {
 			panic(err)
 		}
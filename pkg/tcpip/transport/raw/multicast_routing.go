@@ -0,0 +1,335 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raw
+
+import (
+	"encoding/binary"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// Multicast routing upcall message types, as per the IGMPMSG_* and MRT6MSG_*
+// constants in Linux's include/uapi/linux/mroute.h and mroute6.h.
+const (
+	multicastUpcallNoCache  = 1
+	multicastUpcallWrongMIF = 2
+)
+
+// Offsets and size of the fields of an IPv6 multicast routing upcall message,
+// laid out as Linux's struct mrt6msg.
+//
+// The first byte is always zero so that upcalls can be distinguished from
+// ICMPv6 messages, whose type is never zero.
+const (
+	multicastUpcallMsgType = 1
+	multicastUpcallMIF     = 2
+	multicastUpcallSrc     = 8
+	multicastUpcallDst     = multicastUpcallSrc + header.IPv6AddressSize
+	multicastUpcallSize    = multicastUpcallDst + header.IPv6AddressSize
+)
+
+// Offsets of the fields of an IPv4 multicast routing upcall message, laid out
+// as Linux's struct igmpmsg.
+//
+// The message overlays an IPv4 header whose protocol field is zero so that
+// upcalls can be distinguished from IGMP packets. The message type and
+// interface index replace the header's TTL and checksum fields.
+const (
+	ipv4MulticastUpcallMsgType = 8
+	ipv4MulticastUpcallVIF     = 10
+)
+
+// multicastInterface is a NIC that takes part in multicast routing.
+type multicastInterface struct {
+	nic       tcpip.NICID
+	threshold uint8
+}
+
+// multicastRouter holds the state of an endpoint acting as the stack's IPv4 or
+// IPv6 multicast router.
+type multicastRouter struct {
+	mu sync.Mutex
+
+	// enabled is true when the endpoint is the stack's multicast router.
+	enabled bool
+
+	// mifs maps multicast interface indices to multicast interfaces.
+	mifs map[uint16]multicastInterface
+}
+
+var _ stack.MulticastForwardingEventDispatcher = (*endpoint)(nil)
+
+// canBeMulticastRouter returns true if e may become the stack's multicast
+// router for its network protocol. As on Linux, only raw IGMP endpoints may
+// route IPv4 multicast packets and only raw ICMPv6 endpoints may route IPv6
+// multicast packets.
+func (e *endpoint) canBeMulticastRouter() bool {
+	if !e.associated {
+		return false
+	}
+	switch e.NetProto {
+	case header.IPv4ProtocolNumber:
+		return e.TransProto == header.IGMPProtocolNumber
+	case header.IPv6ProtocolNumber:
+		return e.TransProto == header.ICMPv6ProtocolNumber
+	default:
+		return false
+	}
+}
+
+// setMulticastRouter makes e the stack's multicast router or stops it from
+// being the multicast router.
+func (e *endpoint) setMulticastRouter(enable bool) *tcpip.Error {
+	if !e.canBeMulticastRouter() {
+		return tcpip.ErrNotSupported
+	}
+
+	e.mroute.mu.Lock()
+	defer e.mroute.mu.Unlock()
+
+	if !enable {
+		if !e.mroute.enabled {
+			return tcpip.ErrNotPermitted
+		}
+		e.stopMulticastRouterLocked()
+		return nil
+	}
+
+	if e.mroute.enabled {
+		return tcpip.ErrPortInUse
+	}
+	alreadyEnabled, err := e.stack.EnableMulticastForwardingForProtocol(e.NetProto, e)
+	if err != nil {
+		return err
+	}
+	if alreadyEnabled {
+		// Another endpoint is the multicast router.
+		return tcpip.ErrPortInUse
+	}
+	e.mroute.enabled = true
+	e.mroute.mifs = make(map[uint16]multicastInterface)
+	return nil
+}
+
+// isMulticastRouter returns true if e is the stack's multicast router.
+func (e *endpoint) isMulticastRouter() bool {
+	e.mroute.mu.Lock()
+	defer e.mroute.mu.Unlock()
+	return e.mroute.enabled
+}
+
+// stopMulticastRouterLocked removes e's multicast interfaces and disables
+// multicast forwarding.
+//
+// Precondition: e.mroute.mu must be locked and e must be the multicast router.
+func (e *endpoint) stopMulticastRouterLocked() {
+	for _, mif := range e.mroute.mifs {
+		// The NIC may have been removed since the interface was added.
+		_ = e.stack.SetNICMulticastForwarding(mif.nic, e.NetProto, false)
+	}
+	if err := e.stack.DisableMulticastForwardingForProtocol(e.NetProto); err != nil {
+		panic(err)
+	}
+	e.mroute.enabled = false
+	e.mroute.mifs = nil
+}
+
+// stopMulticastRouter stops e from being the stack's multicast router, if it
+// is the multicast router.
+func (e *endpoint) stopMulticastRouter() {
+	e.mroute.mu.Lock()
+	defer e.mroute.mu.Unlock()
+	if e.mroute.enabled {
+		e.stopMulticastRouterLocked()
+	}
+}
+
+// addMulticastInterface handles the AddMulticastRoutingInterfaceOption.
+func (e *endpoint) addMulticastInterface(v *tcpip.AddMulticastRoutingInterfaceOption) *tcpip.Error {
+	e.mroute.mu.Lock()
+	defer e.mroute.mu.Unlock()
+
+	if !e.mroute.enabled {
+		return tcpip.ErrNotPermitted
+	}
+	if _, ok := e.mroute.mifs[v.Index]; ok {
+		return tcpip.ErrPortInUse
+	}
+	nicID := v.NIC
+	if nicID == 0 {
+		if nicID = e.stack.CheckLocalAddress(0 /* nicID */, e.NetProto, v.InterfaceAddr); nicID == 0 {
+			return tcpip.ErrBadLocalAddress
+		}
+	}
+	if err := e.stack.SetNICMulticastForwarding(nicID, e.NetProto, true); err != nil {
+		return err
+	}
+	e.mroute.mifs[v.Index] = multicastInterface{
+		nic:       nicID,
+		threshold: v.Threshold,
+	}
+	return nil
+}
+
+// removeMulticastInterface handles the RemoveMulticastRoutingInterfaceOption.
+func (e *endpoint) removeMulticastInterface(v *tcpip.RemoveMulticastRoutingInterfaceOption) *tcpip.Error {
+	e.mroute.mu.Lock()
+	defer e.mroute.mu.Unlock()
+
+	if !e.mroute.enabled {
+		return tcpip.ErrNotPermitted
+	}
+	mif, ok := e.mroute.mifs[v.Index]
+	if !ok {
+		return tcpip.ErrBadLocalAddress
+	}
+	delete(e.mroute.mifs, v.Index)
+	return e.stack.SetNICMulticastForwarding(mif.nic, e.NetProto, false)
+}
+
+// addMulticastRoute handles the AddMulticastRouteOption.
+func (e *endpoint) addMulticastRoute(v *tcpip.AddMulticastRouteOption) *tcpip.Error {
+	route, err := func() (stack.MulticastRoute, *tcpip.Error) {
+		e.mroute.mu.Lock()
+		defer e.mroute.mu.Unlock()
+
+		if !e.mroute.enabled {
+			return stack.MulticastRoute{}, tcpip.ErrNotPermitted
+		}
+		input, ok := e.mroute.mifs[v.InputInterface]
+		if !ok {
+			return stack.MulticastRoute{}, tcpip.ErrInvalidOptionValue
+		}
+		route := stack.MulticastRoute{ExpectedInputInterface: input.nic}
+		for _, index := range v.OutputInterfaces {
+			if index == v.InputInterface {
+				continue
+			}
+			if output, ok := e.mroute.mifs[index]; ok {
+				route.OutgoingInterfaces = append(route.OutgoingInterfaces, stack.MulticastRouteOutgoingInterface{
+					ID:     output.nic,
+					MinTTL: output.threshold,
+				})
+			}
+		}
+		return route, nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	// The route is added without holding e.mroute.mu as forwarding the packets
+	// queued for the route may result in upcalls.
+	return e.stack.AddMulticastRoute(e.NetProto, stack.UnicastSourceAndMulticastDestination{
+		Source:      v.Source,
+		Destination: v.Group,
+	}, route)
+}
+
+// removeMulticastRoute handles the RemoveMulticastRouteOption.
+func (e *endpoint) removeMulticastRoute(v *tcpip.RemoveMulticastRouteOption) *tcpip.Error {
+	if !e.isMulticastRouter() {
+		return tcpip.ErrNotPermitted
+	}
+	return e.stack.RemoveMulticastRoute(e.NetProto, stack.UnicastSourceAndMulticastDestination{
+		Source:      v.Source,
+		Destination: v.Group,
+	})
+}
+
+// OnMissingRoute implements stack.MulticastForwardingEventDispatcher.
+func (e *endpoint) OnMissingRoute(context stack.MulticastPacketContext) {
+	e.multicastUpcall(multicastUpcallNoCache, context)
+}
+
+// OnUnexpectedInputInterface implements
+// stack.MulticastForwardingEventDispatcher.
+func (e *endpoint) OnUnexpectedInputInterface(context stack.MulticastPacketContext, _ tcpip.NICID) {
+	e.multicastUpcall(multicastUpcallWrongMIF, context)
+}
+
+// multicastUpcall queues a multicast routing upcall message to be read by the
+// multicast routing daemon.
+func (e *endpoint) multicastUpcall(msgType uint8, context stack.MulticastPacketContext) {
+	index, ok := func() (uint16, bool) {
+		e.mroute.mu.Lock()
+		defer e.mroute.mu.Unlock()
+		for index, mif := range e.mroute.mifs {
+			if mif.nic == context.InputInterface {
+				return index, true
+			}
+		}
+		return 0, false
+	}()
+	if !ok {
+		return
+	}
+
+	var msg buffer.View
+	switch e.NetProto {
+	case header.IPv4ProtocolNumber:
+		msg = buffer.NewView(header.IPv4MinimumSize)
+		header.IPv4(msg).Encode(&header.IPv4Fields{
+			TotalLength: header.IPv4MinimumSize,
+			SrcAddr:     context.SourceAndDestination.Source,
+			DstAddr:     context.SourceAndDestination.Destination,
+		})
+		msg[ipv4MulticastUpcallMsgType] = msgType
+		// The low and high bytes of the interface index are stored in
+		// separate fields.
+		msg[ipv4MulticastUpcallVIF] = uint8(index)
+		msg[ipv4MulticastUpcallVIF+1] = uint8(index >> 8)
+	default:
+		msg = buffer.NewView(multicastUpcallSize)
+		msg[multicastUpcallMsgType] = msgType
+		// The interface index is in host byte order, and all supported
+		// architectures are little-endian.
+		binary.LittleEndian.PutUint16(msg[multicastUpcallMIF:], index)
+		copy(msg[multicastUpcallSrc:], context.SourceAndDestination.Source)
+		copy(msg[multicastUpcallDst:], context.SourceAndDestination.Destination)
+	}
+
+	e.rcvMu.Lock()
+	if e.rcvClosed {
+		e.rcvMu.Unlock()
+		e.stats.ReceiveErrors.ClosedReceiver.Increment()
+		return
+	}
+	if e.rcvBufSize >= e.rcvBufSizeMax {
+		e.rcvMu.Unlock()
+		e.stats.ReceiveErrors.ReceiveBufferOverflow.Increment()
+		return
+	}
+	wasEmpty := e.rcvBufSize == 0
+	packet := &rawPacket{
+		data:        msg.ToVectorisedView(),
+		timestampNS: e.stack.Clock().NowNanoseconds(),
+		senderAddr: tcpip.FullAddress{
+			NIC: context.InputInterface,
+		},
+	}
+	e.rcvList.PushBack(packet)
+	e.rcvBufSize += packet.data.Size()
+	e.rcvMu.Unlock()
+
+	if wasEmpty {
+		e.waiterQueue.Notify(waiter.EventIn)
+	}
+}
@@ -0,0 +1,1265 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sctp
+
+import (
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/rand"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	// assocMaxRetrans is the number of consecutive retransmissions after
+	// which the peer is considered unreachable, as per RFC 4960 section
+	// 8.1 (Association.Max.Retrans).
+	assocMaxRetrans = 10
+
+	// pathMaxRetrans is the number of consecutive retransmissions to an
+	// address after which it is considered inactive, as per RFC 4960
+	// section 8.2 (Path.Max.Retrans).
+	pathMaxRetrans = 5
+
+	// heartbeatInterval is the interval of the heartbeats sent to the
+	// idle addresses of the peer, as per RFC 4960 section 8.3 (HB.interval).
+	heartbeatInterval = 30 * time.Second
+
+	// heartbeatInfoSize is the size of the heartbeat information sent in
+	// HEARTBEAT chunks: the index of the path, a nonce and the time the
+	// chunk was sent.
+	heartbeatInfoSize = 4 + 8 + 8
+
+	// maxLocalAddresses is the largest number of addresses listed in INIT
+	// and INIT ACK chunks.
+	maxLocalAddresses = 16
+)
+
+// association is the state of an SCTP association. It is protected by the mu
+// of its endpoint.
+type association struct {
+	// netProto is the network protocol of the addresses of the
+	// association.
+	netProto tcpip.NetworkProtocolNumber
+
+	// localTag and peerTag are the verification tags of the association.
+	// peerTag is zero until the peer's is known.
+	localTag uint32
+	peerTag  uint32
+
+	// paths are the paths to the addresses of the peer. primary is the
+	// index of the primary path, the one the association was set up on.
+	paths   []*path
+	primary int
+
+	// errorCount is the number of consecutive unacknowledged
+	// retransmissions and heartbeats.
+	errorCount int
+
+	// initTimer is the T1-init or T1-cookie timer retransmitting the INIT
+	// or COOKIE ECHO chunk while the association is set up.
+	initTimer    timer
+	initAttempts int
+	initRTO      time.Duration
+	stateCookie  []byte
+
+	// shutdownTimer is the T2-shutdown timer, retransmitting the SHUTDOWN
+	// or SHUTDOWN ACK chunk.
+	shutdownTimer timer
+	shutdownRTO   time.Duration
+
+	sender
+	receiver
+}
+
+// newAssociation returns a new association of e with random verification tag
+// and initial TSN.
+func newAssociation(e *endpoint, netProto tcpip.NetworkProtocolNumber) *association {
+	a := &association{
+		netProto: netProto,
+		localTag: randomTag(),
+		initRTO:  e.rtoInfo.Initial,
+	}
+	a.nextTSN = randomTag()
+	a.cumAcked = a.nextTSN - 1
+	return a
+}
+
+// randomTag returns a non-zero random verification tag.
+func randomTag() uint32 {
+	var b [4]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			panic(err)
+		}
+		if v := binary.BigEndian.Uint32(b[:]); v != 0 {
+			return v
+		}
+	}
+}
+
+// peerParams are the parameters of the association advertised by the peer.
+type peerParams struct {
+	tag             uint32
+	tsn             uint32
+	rwnd            uint32
+	outboundStreams uint16
+	inboundStreams  uint16
+}
+
+// setPeerParamsLocked sets the parameters advertised by the peer and
+// initializes the streams of the association.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) setPeerParamsLocked(pp peerParams) {
+	a := e.a
+	a.peerTag = pp.tag
+	a.peerRwnd = pp.rwnd
+	a.outboundStreams = pp.inboundStreams
+	if e.initMsg.OutboundStreams < a.outboundStreams {
+		a.outboundStreams = e.initMsg.OutboundStreams
+	}
+	a.ssn = make([]uint16, a.outboundStreams)
+	a.inboundStreams = pp.outboundStreams
+	if e.initMsg.MaxInboundStreams < a.inboundStreams {
+		a.inboundStreams = e.initMsg.MaxInboundStreams
+	}
+	a.streams = make([]inStream, a.inboundStreams)
+	a.cumTSN = pp.tsn - 1
+	a.received = make(map[uint32]struct{})
+	a.fragments = make(map[uint32]*rcvChunk)
+	a.rcvAdvertised = uint32(e.rcvBufSize)
+}
+
+// path is a path to one of the addresses of the peer of an association.
+type path struct {
+	addr  tcpip.Address
+	route *stack.Route
+
+	// active is false once the peer is considered unreachable through
+	// the path, until a heartbeat is acknowledged. confirmed is true once
+	// the peer is known to own addr, as per RFC 4960 section 5.4.
+	active     bool
+	confirmed  bool
+	errorCount int
+
+	// Retransmission timeout computation, as per RFC 4960 section 6.3.1.
+	rto         time.Duration
+	srtt        time.Duration
+	rttvar      time.Duration
+	rttMeasured bool
+
+	// rttPending is true while the round-trip time is measured with the
+	// chunk whose TSN is rttTSN, sent at rttSentAt.
+	rttPending bool
+	rttTSN     uint32
+	rttSentAt  int64
+
+	// Congestion control, as per RFC 4960 section 7.2.
+	cwnd       int
+	ssthresh   int
+	pba        int
+	flightSize int
+
+	// t3 is the T3-rtx retransmission timer of the path.
+	t3 timer
+
+	// hbTimer is the timer of the heartbeats of the path. hbOutstanding
+	// is true while the heartbeat with nonce hbNonce is not acknowledged.
+	hbTimer       timer
+	hbOutstanding bool
+	hbNonce       uint64
+}
+
+// mtu returns the largest size of an SCTP packet sent on the path.
+func (p *path) mtu() int {
+	return int(p.route.MTU())
+}
+
+// usable returns true if data can be sent on the path.
+func (p *path) usable() bool {
+	return p.active && p.confirmed
+}
+
+// info returns the status of the path.
+func (p *path) info(e *endpoint) tcpip.SCTPPathInfo {
+	return tcpip.SCTPPathInfo{
+		Address: tcpip.FullAddress{
+			Addr: p.addr,
+			Port: e.ID.RemotePort,
+			NIC:  p.route.NICID(),
+		},
+		Active:    p.active,
+		Confirmed: p.confirmed,
+		Cwnd:      uint32(p.cwnd),
+		SRTT:      p.srtt,
+		RTO:       p.rto,
+		MTU:       uint32(p.mtu()),
+	}
+}
+
+// updateRTO updates the retransmission timeout of the path with the round-trip
+// time measurement r, as per RFC 4960 section 6.3.1.
+func (p *path) updateRTO(r time.Duration, info tcpip.SCTPRTOInfoOption) {
+	if !p.rttMeasured {
+		p.srtt = r
+		p.rttvar = r / 2
+		p.rttMeasured = true
+	} else {
+		diff := p.srtt - r
+		if diff < 0 {
+			diff = -diff
+		}
+		p.rttvar = (3*p.rttvar + diff) / 4
+		p.srtt = (7*p.srtt + r) / 8
+	}
+	p.rto = p.srtt + 4*p.rttvar
+	if p.rto < info.Min {
+		p.rto = info.Min
+	}
+	if p.rto > info.Max {
+		p.rto = info.Max
+	}
+}
+
+// backoff doubles the retransmission timeout of the path, as per RFC 4960
+// section 6.3.3.
+func (p *path) backoff(info tcpip.SCTPRTOInfoOption) {
+	p.rto *= 2
+	if p.rto > info.Max {
+		p.rto = info.Max
+	}
+}
+
+// addPathLocked adds a path to addr over route r to the association, and
+// registers the endpoint to receive the packets from addr. It takes ownership
+// of r.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) addPathLocked(addr tcpip.Address, r *stack.Route, confirmed bool) *tcpip.Error {
+	a := e.a
+	if a.pathTo(addr) != nil {
+		r.Release()
+		return nil
+	}
+	if err := e.registerLocked(0 /* nicID */, []tcpip.NetworkProtocolNumber{a.netProto}, stack.TransportEndpointID{
+		LocalPort:     e.ID.LocalPort,
+		RemotePort:    e.ID.RemotePort,
+		RemoteAddress: addr,
+	}); err != nil {
+		r.Release()
+		return err
+	}
+	p := &path{
+		addr:      addr,
+		route:     r,
+		active:    true,
+		confirmed: confirmed,
+		rto:       e.rtoInfo.Initial,
+	}
+	// The initial congestion window, as per RFC 4960 section 7.2.1.
+	mtu := p.mtu()
+	p.cwnd = 4380
+	if p.cwnd < 2*mtu {
+		p.cwnd = 2 * mtu
+	}
+	if p.cwnd > 4*mtu {
+		p.cwnd = 4 * mtu
+	}
+	p.ssthresh = int(a.peerRwnd)
+	if p.ssthresh == 0 {
+		p.ssthresh = MaxBufferSize
+	}
+	a.paths = append(a.paths, p)
+	return nil
+}
+
+// addPeerPathsLocked adds paths to the addresses listed by the peer. The
+// addresses which are not reachable, or of another network protocol than the
+// association's, are ignored.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) addPeerPathsLocked(addrs []tcpip.Address) {
+	a := e.a
+	for _, addr := range addrs {
+		if a.pathTo(addr) != nil || !e.sameNetProto(addr) {
+			continue
+		}
+		r, err := e.stack.FindRoute(e.BindNICID, e.BindAddr, addr, a.netProto, false /* multicastLoop */)
+		if err != nil {
+			continue
+		}
+		if err := e.addPathLocked(addr, r, false /* confirmed */); err != nil {
+			continue
+		}
+	}
+}
+
+// sameNetProto returns true if addr is of the network protocol of the
+// association.
+func (e *endpoint) sameNetProto(addr tcpip.Address) bool {
+	if e.a.netProto == header.IPv4ProtocolNumber {
+		return len(addr) == header.IPv4AddressSize
+	}
+	return len(addr) == header.IPv6AddressSize
+}
+
+// pathTo returns the path to addr, or nil if addr is not an address of the
+// peer.
+func (a *association) pathTo(addr tcpip.Address) *path {
+	for _, p := range a.paths {
+		if p.addr == addr {
+			return p
+		}
+	}
+	return nil
+}
+
+// activePath returns the path data is sent on: the primary path, unless it is
+// inactive and another path is usable.
+func (a *association) activePath() *path {
+	if p := a.paths[a.primary]; p.usable() {
+		return p
+	}
+	for _, p := range a.paths {
+		if p.usable() {
+			return p
+		}
+	}
+	return a.paths[a.primary]
+}
+
+// alternatePath returns a usable path other than p if there is one, to
+// retransmit the chunks sent on p, as per RFC 4960 section 6.4.1.
+func (a *association) alternatePath(p *path) *path {
+	if p == nil {
+		return a.activePath()
+	}
+	for _, q := range a.paths {
+		if q != p && q.usable() {
+			return q
+		}
+	}
+	return a.activePath()
+}
+
+// pathErrorLocked records an unacknowledged retransmission or heartbeat on p,
+// as per RFC 4960 sections 8.1 and 8.2. It returns true if the association
+// was aborted.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) pathErrorLocked(p *path) bool {
+	a := e.a
+	if p.confirmed {
+		a.errorCount++
+		if a.errorCount > assocMaxRetrans {
+			e.abortLocked(tcpip.ErrTimeout, true /* send */)
+			return true
+		}
+	}
+	p.errorCount++
+	if p.errorCount > pathMaxRetrans && p.active {
+		wasActive := a.activePath() == p
+		p.active = false
+		if wasActive && a.activePath() != p {
+			e.stack.Stats().SCTP.PathFailovers.Increment()
+		}
+	}
+	return false
+}
+
+// localAddressesLocked returns the local addresses listed in INIT and INIT ACK
+// chunks sent to peerAddr: the bound address, or all the addresses of the
+// stack of network protocol netProto in the scope of peerAddr if the endpoint
+// is bound to the wildcard address.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) localAddressesLocked(netProto tcpip.NetworkProtocolNumber, peerAddr tcpip.Address) []tcpip.Address {
+	if e.BindAddr != "" {
+		return []tcpip.Address{e.BindAddr}
+	}
+	loopback := isLoopback(peerAddr)
+	var addrs []tcpip.Address
+	for nicID, pas := range e.stack.AllAddresses() {
+		if e.BindNICID != 0 && nicID != e.BindNICID {
+			continue
+		}
+		for _, pa := range pas {
+			addr := pa.AddressWithPrefix.Address
+			if pa.Protocol != netProto || isLoopback(addr) != loopback || header.IsV6LinkLocalAddress(addr) {
+				continue
+			}
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	if len(addrs) > maxLocalAddresses {
+		addrs = addrs[:maxLocalAddresses]
+	}
+	return addrs
+}
+
+// isLoopback returns true if addr is a loopback address.
+func isLoopback(addr tcpip.Address) bool {
+	return header.IsV4LoopbackAddress(addr) || addr == header.IPv6Loopback
+}
+
+// supportedAddressTypes returns the types of addresses the endpoint supports.
+func (e *endpoint) supportedAddressTypes() []header.SCTPParameterType {
+	if e.NetProto == header.IPv4ProtocolNumber {
+		return []header.SCTPParameterType{header.SCTPParameterIPv4Address}
+	}
+	if e.ops.GetV6Only() {
+		return []header.SCTPParameterType{header.SCTPParameterIPv6Address}
+	}
+	return []header.SCTPParameterType{header.SCTPParameterIPv4Address, header.SCTPParameterIPv6Address}
+}
+
+// queueChunkLocked queues a single control chunk to be sent on p.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) queueChunkLocked(p *path, typ header.SCTPChunkType, flags uint8, value []byte) {
+	b := make(buffer.View, header.SCTPChunkSize(len(value)))
+	header.EncodeSCTPChunk(b, typ, flags, value)
+	e.queuePacketLocked(p, e.a.peerTag, b)
+}
+
+// queueReplyLocked queues chunks to be sent to the source of pk, with the
+// peer's verification tag.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) queueReplyLocked(pk *packet, chunks buffer.View) {
+	if p := e.a.pathTo(pk.id.RemoteAddress); p != nil {
+		e.queuePacketLocked(p, e.a.peerTag, chunks)
+		return
+	}
+	if o := e.protocol.reply(pk, e.a.peerTag, chunks); o != nil {
+		o.owner = e.owner
+		e.pending = append(e.pending, o)
+	}
+}
+
+// sendInitLocked sends the INIT chunk starting the setup of an association,
+// and arms the T1-init timer to retransmit it.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) sendInitLocked() {
+	a := e.a
+	p := a.paths[a.primary]
+	f := header.SCTPInitFields{
+		InitiateTag:              a.localTag,
+		AdvertisedReceiverWindow: uint32(e.rcvBufSize),
+		OutboundStreams:          e.initMsg.OutboundStreams,
+		InboundStreams:           e.initMsg.MaxInboundStreams,
+		InitialTSN:               a.nextTSN,
+		Addresses:                e.localAddressesLocked(a.netProto, p.addr),
+		SupportedAddressTypes:    e.supportedAddressTypes(),
+	}
+	b := make(buffer.View, header.SCTPInitChunkSize(f))
+	header.EncodeSCTPInitChunk(b, header.SCTPChunkInit, f)
+	// The INIT chunk is sent with a zero verification tag, as per RFC 4960
+	// section 8.5.1.
+	e.queuePacketLocked(p, 0 /* tag */, b)
+	e.armInitTimerLocked(e.sendInitLocked)
+}
+
+// sendCookieEchoLocked sends the COOKIE ECHO chunk carrying the state cookie
+// of the peer, and arms the T1-cookie timer to retransmit it.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) sendCookieEchoLocked() {
+	a := e.a
+	e.queueChunkLocked(a.paths[a.primary], header.SCTPChunkCookieEcho, 0 /* flags */, a.stateCookie)
+	e.armInitTimerLocked(e.sendCookieEchoLocked)
+}
+
+// armInitTimerLocked arms the T1 timer to call resend, until the maximum
+// number of attempts is reached, as per RFC 4960 section 5.1.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) armInitTimerLocked(resend func()) {
+	a := e.a
+	e.armTimerLocked(&a.initTimer, a.initRTO, func() {
+		a.initAttempts++
+		if a.initAttempts >= int(e.initMsg.MaxAttempts) {
+			e.abortLocked(tcpip.ErrTimeout, false /* send */)
+			return
+		}
+		a.initRTO *= 2
+		if a.initRTO > e.initMsg.MaxInitTimeout {
+			a.initRTO = e.initMsg.MaxInitTimeout
+		}
+		resend()
+	})
+}
+
+// establishLocked moves the association to the established state.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) establishLocked() {
+	a := e.a
+	e.stopTimerLocked(&a.initTimer)
+	a.stateCookie = nil
+	a.paths[a.primary].confirmed = true
+	e.setStateLocked(StateEstablished)
+	for _, p := range a.paths {
+		e.armHeartbeatLocked(p)
+	}
+	e.events |= waiter.EventOut
+}
+
+// handleListenLocked handles a packet received by a listening endpoint, as per
+// RFC 4960 section 5.1.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) handleListenLocked(pk *packet) {
+	switch first := pk.chunks[0]; first.Type() {
+	case header.SCTPChunkInit:
+		e.handleInitLocked(pk, first)
+	case header.SCTPChunkCookieEcho:
+		e.handleCookieEchoLocked(pk, first)
+	default:
+		if o := e.protocol.replyToOutOfTheBlue(pk); o != nil {
+			e.pending = append(e.pending, o)
+		}
+	}
+}
+
+// validInit returns the fields of the INIT or INIT ACK chunk c, and false if c
+// is invalid or its mandatory parameters are invalid, as per RFC 4960 section
+// 3.3.2.
+func validInit(c header.SCTPChunk) (header.SCTPInitFields, bool) {
+	if !c.IsValid() {
+		return header.SCTPInitFields{}, false
+	}
+	f, ok := header.SCTPInitChunk(c).Fields()
+	if !ok || f.InitiateTag == 0 || f.OutboundStreams == 0 || f.InboundStreams == 0 {
+		return header.SCTPInitFields{}, false
+	}
+	return f, true
+}
+
+// handleInitLocked responds to an INIT chunk with an INIT ACK chunk carrying a
+// state cookie, which holds the state of the association until it is echoed
+// by the peer, as per RFC 4960 sections 5.1 and 5.2.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) handleInitLocked(pk *packet, c header.SCTPChunk) {
+	// An INIT chunk is sent alone, with a zero verification tag, as per
+	// RFC 4960 section 8.5.1.
+	if len(pk.chunks) != 1 || pk.hdr.VerificationTag() != 0 {
+		return
+	}
+	f, ok := validInit(c)
+	if !ok {
+		if o := e.protocol.reply(pk, header.SCTPInitChunk(c).InitiateTag(), abortChunk(0 /* flags */, header.SCTPCauseInvalidParameter)); o != nil {
+			e.pending = append(e.pending, o)
+		}
+		return
+	}
+
+	ck := cookie{
+		createdAt:       e.stack.Clock().NowMonotonic(),
+		localTag:        randomTag(),
+		peerTag:         f.InitiateTag,
+		localTSN:        randomTag(),
+		peerTSN:         f.InitialTSN,
+		peerRwnd:        f.AdvertisedReceiverWindow,
+		outboundStreams: f.OutboundStreams,
+		inboundStreams:  f.InboundStreams,
+		localPort:       pk.id.LocalPort,
+		peerPort:        pk.id.RemotePort,
+		peerAddrs:       []tcpip.Address{pk.id.RemoteAddress},
+	}
+	for _, addr := range f.Addresses {
+		if addr != pk.id.RemoteAddress {
+			ck.peerAddrs = append(ck.peerAddrs, addr)
+		}
+	}
+
+	a := e.a
+	if e.EndpointState().associated() {
+		switch e.EndpointState() {
+		case StateCookieWait, StateCookieEchoed:
+			// The INIT chunks of both endpoints crossed: respond with
+			// the parameters of ours, as per RFC 4960 section 5.2.1.
+			ck.localTag = a.localTag
+			ck.localTSN = a.nextTSN
+		default:
+			// The peer may have restarted: respond with new
+			// parameters, and the tags of the existing association
+			// to recognize the restart when the cookie is echoed,
+			// as per RFC 4960 section 5.2.2.
+			ck.tieLocalTag = a.localTag
+			ck.tiePeerTag = a.peerTag
+		}
+	}
+
+	ack := header.SCTPInitFields{
+		InitiateTag:              ck.localTag,
+		AdvertisedReceiverWindow: uint32(e.rcvBufSize),
+		OutboundStreams:          e.initMsg.OutboundStreams,
+		InboundStreams:           e.initMsg.MaxInboundStreams,
+		InitialTSN:               ck.localTSN,
+		Addresses:                e.localAddressesLocked(pk.netProto, pk.id.RemoteAddress),
+		StateCookie:              e.protocol.encodeCookie(&ck),
+	}
+	b := make(buffer.View, header.SCTPInitChunkSize(ack))
+	header.EncodeSCTPInitChunk(b, header.SCTPChunkInitAck, ack)
+	// The INIT ACK chunk is sent with the tag of the INIT chunk, as per
+	// RFC 4960 section 8.5.
+	if o := e.protocol.reply(pk, f.InitiateTag, b); o != nil {
+		o.owner = e.owner
+		e.pending = append(e.pending, o)
+	}
+}
+
+// handleCookieEchoLocked handles a COOKIE ECHO chunk received by a listening
+// endpoint: it creates the association carried by the cookie as a new
+// endpoint, queued to be accepted, as per RFC 4960 section 5.1.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) handleCookieEchoLocked(pk *packet, c header.SCTPChunk) {
+	ck, ok := e.protocol.decodeCookie(c.Value(), e.stack.Clock().NowMonotonic())
+	if !ok || pk.hdr.VerificationTag() != ck.localTag || ck.tieLocalTag != 0 {
+		return
+	}
+	if len(e.acceptQueue) >= e.backlog {
+		// The peer retransmits the COOKIE ECHO chunk until the
+		// association is accepted.
+		return
+	}
+
+	n := e.newAcceptedEndpoint()
+	n.mu.Lock()
+	n.ID = stack.TransportEndpointID{
+		LocalPort:     pk.id.LocalPort,
+		LocalAddress:  pk.id.LocalAddress,
+		RemotePort:    pk.id.RemotePort,
+		RemoteAddress: pk.id.RemoteAddress,
+	}
+	n.RegisterNICID = pk.nicID
+	if !n.acceptCookieLocked(pk, ck) {
+		n.unregisterLocked()
+		n.releasePathsLocked()
+		n.setStateLocked(StateClosed)
+		n.mu.Unlock()
+		return
+	}
+	e.stack.Stats().SCTP.PassiveEstablishments.Increment()
+	n.handleChunksLocked(pk, pk.chunks[1:])
+
+	// The packets of the new endpoint are written along with the
+	// listening endpoint's, as nothing waits on it yet.
+	e.pending = append(e.pending, n.pending...)
+	n.pending = nil
+	n.events = 0
+	n.mu.Unlock()
+
+	e.acceptQueue = append(e.acceptQueue, n)
+	e.events |= waiter.EventIn
+}
+
+// acceptCookieLocked establishes the association carried by the state cookie
+// ck of pk, and acknowledges it. It returns false if the source of pk is
+// unreachable.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) acceptCookieLocked(pk *packet, ck cookie) bool {
+	e.a = newAssociation(e, pk.netProto)
+	e.a.localTag = ck.localTag
+	e.a.nextTSN = ck.localTSN
+	e.a.cumAcked = ck.localTSN - 1
+	e.setPeerParamsLocked(peerParams{
+		tag:             ck.peerTag,
+		tsn:             ck.peerTSN,
+		rwnd:            ck.peerRwnd,
+		outboundStreams: ck.outboundStreams,
+		inboundStreams:  ck.inboundStreams,
+	})
+	r, err := e.protocol.replyRoute(pk)
+	if err != nil {
+		return false
+	}
+	if err := e.addPathLocked(pk.id.RemoteAddress, r, true /* confirmed */); err != nil {
+		return false
+	}
+	e.addPeerPathsLocked(ck.peerAddrs[1:])
+	e.connectNotified = true
+	e.establishLocked()
+	e.queueChunkLocked(e.a.paths[e.a.primary], header.SCTPChunkCookieAck, 0 /* flags */, nil)
+	return true
+}
+
+// handleAssociationLocked handles a packet received by an association.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) handleAssociationLocked(pk *packet) {
+	a := e.a
+	tag := pk.hdr.VerificationTag()
+
+	// Check the verification tag, as per RFC 4960 section 8.5.
+	switch first := pk.chunks[0]; first.Type() {
+	case header.SCTPChunkInit:
+		e.handleInitLocked(pk, first)
+		return
+	case header.SCTPChunkCookieEcho:
+		e.handleCookieEchoInAssociationLocked(pk, first)
+		return
+	}
+	for _, c := range pk.chunks {
+		switch c.Type() {
+		case header.SCTPChunkAbort:
+			if tag == a.localTag || (c.Flags()&header.SCTPFlagT != 0 && tag == a.peerTag && a.peerTag != 0) {
+				err := tcpip.ErrConnectionReset
+				if e.EndpointState() == StateCookieWait {
+					err = tcpip.ErrConnectionRefused
+				}
+				e.closeAssociationLocked(err)
+			}
+			return
+		case header.SCTPChunkShutdownComplete:
+			if c.Flags()&header.SCTPFlagT != 0 && tag == a.peerTag && a.peerTag != 0 {
+				tag = a.localTag
+			}
+		}
+	}
+	if tag != a.localTag {
+		return
+	}
+	e.handleChunksLocked(pk, pk.chunks)
+}
+
+// handleChunksLocked handles the chunks of a packet received by an
+// association, whose verification tag was checked.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) handleChunksLocked(pk *packet, chunks []header.SCTPChunk) {
+	a := e.a
+	a.dataReceived = false
+	for _, c := range chunks {
+		if !e.EndpointState().associated() {
+			return
+		}
+		if !c.IsValid() {
+			// A malformed chunk ends the processing of the packet.
+			e.stack.Stats().SCTP.InvalidPacketsReceived.Increment()
+			break
+		}
+		if !e.handleChunkLocked(pk, c) {
+			break
+		}
+	}
+	if !e.EndpointState().associated() {
+		return
+	}
+	if a.dataReceived {
+		e.dataReceivedLocked(pk)
+	}
+	e.transmitLocked()
+}
+
+// handleChunkLocked handles a chunk received by an association. It returns
+// false if the rest of the packet must be discarded.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) handleChunkLocked(pk *packet, c header.SCTPChunk) bool {
+	a := e.a
+	s := e.EndpointState()
+	switch c.Type() {
+	case header.SCTPChunkData:
+		switch s {
+		case StateEstablished, StateShutdownPending, StateShutdownSent:
+			e.handleDataLocked(pk, header.SCTPDataChunk(c))
+		}
+
+	case header.SCTPChunkInitAck:
+		if s == StateCookieWait {
+			e.handleInitAckLocked(pk, c)
+		}
+
+	case header.SCTPChunkCookieAck:
+		if s == StateCookieEchoed {
+			e.stack.Stats().SCTP.ActiveEstablishments.Increment()
+			e.establishLocked()
+		}
+
+	case header.SCTPChunkSack:
+		if s.established() {
+			e.handleSackLocked(header.SCTPSackChunk(c))
+		}
+
+	case header.SCTPChunkHeartbeat:
+		// The heartbeat information is echoed back, as per RFC 4960
+		// section 8.3.
+		if s.established() {
+			b := make(buffer.View, header.SCTPChunkSize(len(c.Value())))
+			header.EncodeSCTPChunk(b, header.SCTPChunkHeartbeatAck, 0 /* flags */, c.Value())
+			e.queueReplyLocked(pk, b)
+		}
+
+	case header.SCTPChunkHeartbeatAck:
+		if s.established() {
+			e.handleHeartbeatAckLocked(header.SCTPHeartbeatChunk(c))
+		}
+
+	case header.SCTPChunkShutdown:
+		e.handleShutdownLocked(header.SCTPShutdownChunk(c))
+
+	case header.SCTPChunkShutdownAck:
+		switch s {
+		case StateShutdownSent, StateShutdownAckSent:
+			// Complete the shutdown, as per RFC 4960 section 9.2.
+			e.queueChunkLocked(a.activePath(), header.SCTPChunkShutdownComplete, 0 /* flags */, nil)
+			e.stack.Stats().SCTP.Shutdowns.Increment()
+			e.closeAssociationLocked(nil)
+			return false
+		}
+
+	case header.SCTPChunkShutdownComplete:
+		if s == StateShutdownAckSent {
+			e.stack.Stats().SCTP.Shutdowns.Increment()
+			e.closeAssociationLocked(nil)
+			return false
+		}
+
+	case header.SCTPChunkError:
+		for _, cause := range mustErrorCauses(c) {
+			if header.SCTPErrorCauseCode(cause.Type()) == header.SCTPCauseStaleCookie && s == StateCookieEchoed {
+				// Restart the setup of the association, as
+				// per RFC 4960 section 5.2.6.
+				e.stopTimerLocked(&a.initTimer)
+				a.stateCookie = nil
+				e.setStateLocked(StateCookieWait)
+				e.sendInitLocked()
+			}
+		}
+
+	case header.SCTPChunkInit, header.SCTPChunkCookieEcho, header.SCTPChunkAbort:
+		// INIT and COOKIE ECHO chunks are only valid first in a
+		// packet, and ABORT chunks were handled.
+
+	default:
+		// The highest-order two bits of the type of unrecognized
+		// chunks specify the action to take, as per RFC 4960 section
+		// 3.2.
+		typ := c.Type()
+		if typ&0x40 != 0 && s.established() {
+			v := make([]byte, header.SCTPParameterSize(len(c)))
+			header.EncodeSCTPErrorCause(v, header.SCTPCauseUnrecognizedChunk, c)
+			b := make(buffer.View, header.SCTPChunkSize(len(v)))
+			header.EncodeSCTPChunk(b, header.SCTPChunkError, 0 /* flags */, v)
+			e.queueReplyLocked(pk, b)
+		}
+		return typ&0x80 != 0
+	}
+	return true
+}
+
+// mustErrorCauses returns the error causes of the ERROR chunk c, or nil if
+// they are malformed.
+func mustErrorCauses(c header.SCTPChunk) []header.SCTPParameter {
+	causes, ok := header.SCTPErrorCauses(c)
+	if !ok {
+		return nil
+	}
+	return causes
+}
+
+// handleInitAckLocked handles the INIT ACK chunk responding to the INIT
+// chunk of the endpoint, and echoes the state cookie it carries, as per RFC
+// 4960 section 5.1.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) handleInitAckLocked(pk *packet, c header.SCTPChunk) {
+	f, ok := validInit(c)
+	if !ok || len(f.StateCookie) == 0 {
+		e.abortLocked(tcpip.ErrConnectionRefused, true /* send */)
+		return
+	}
+	a := e.a
+	e.stopTimerLocked(&a.initTimer)
+	e.setPeerParamsLocked(peerParams{
+		tag:             f.InitiateTag,
+		tsn:             f.InitialTSN,
+		rwnd:            f.AdvertisedReceiverWindow,
+		outboundStreams: f.OutboundStreams,
+		inboundStreams:  f.InboundStreams,
+	})
+	for _, p := range a.paths {
+		p.ssthresh = int(a.peerRwnd)
+	}
+	e.addPeerPathsLocked(f.Addresses)
+	a.stateCookie = append([]byte(nil), f.StateCookie...)
+	a.initAttempts = 0
+	a.initRTO = e.rtoInfo.Initial
+	e.setStateLocked(StateCookieEchoed)
+	e.sendCookieEchoLocked()
+}
+
+// handleCookieEchoInAssociationLocked handles a COOKIE ECHO chunk received by
+// an association, as per RFC 4960 section 5.2.4.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) handleCookieEchoInAssociationLocked(pk *packet, c header.SCTPChunk) {
+	a := e.a
+	ck, ok := e.protocol.decodeCookie(c.Value(), e.stack.Clock().NowMonotonic())
+	if !ok || pk.hdr.VerificationTag() != ck.localTag {
+		return
+	}
+	s := e.EndpointState()
+	switch {
+	case ck.localTag != a.localTag && ck.peerTag != a.peerTag && ck.tieLocalTag == a.localTag && ck.tiePeerTag == a.peerTag:
+		// Action A: the peer restarted. The data in flight is lost and
+		// the association starts over with the new parameters.
+		if !s.established() {
+			return
+		}
+		e.restartLocked(ck)
+
+	case ck.localTag == a.localTag && (ck.peerTag != a.peerTag || s == StateCookieWait || s == StateCookieEchoed):
+		// Action B: the INIT chunks of both endpoints crossed, or
+		// action D while the association is set up.
+		e.setPeerParamsLocked(peerParams{
+			tag:             ck.peerTag,
+			tsn:             ck.peerTSN,
+			rwnd:            ck.peerRwnd,
+			outboundStreams: ck.outboundStreams,
+			inboundStreams:  ck.inboundStreams,
+		})
+		e.addPeerPathsLocked(ck.peerAddrs)
+		if !s.established() {
+			e.stack.Stats().SCTP.ActiveEstablishments.Increment()
+			e.establishLocked()
+		}
+
+	case ck.localTag == a.localTag && ck.peerTag == a.peerTag:
+		// Action D: the COOKIE ACK chunk was lost.
+
+	default:
+		// Action C, or a cookie of another association.
+		return
+	}
+	e.queueReplyLocked(pk, func() buffer.View {
+		b := make(buffer.View, header.SCTPChunkSize(0))
+		header.EncodeSCTPChunk(b, header.SCTPChunkCookieAck, 0 /* flags */, nil)
+		return b
+	}())
+	e.handleChunksLocked(pk, pk.chunks[1:])
+}
+
+// restartLocked restarts the association with the parameters of the cookie ck
+// echoed by the restarted peer. The messages received and not read yet are
+// kept.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) restartLocked(ck cookie) {
+	a := e.a
+	e.stopTimerLocked(&a.sackTimer)
+	e.stopTimerLocked(&a.shutdownTimer)
+	for _, p := range a.paths {
+		e.stopTimerLocked(&p.t3)
+		p.flightSize = 0
+		p.rttPending = false
+		p.errorCount = 0
+	}
+	a.errorCount = 0
+	a.sender = sender{}
+	rcvQueue, rcvQueued := a.rcvQueue, a.rcvQueued
+	a.receiver = receiver{}
+	a.rcvQueue, a.rcvQueued, a.rcvBufUsed = rcvQueue, rcvQueued, rcvQueued
+
+	a.localTag = ck.localTag
+	a.nextTSN = ck.localTSN
+	a.cumAcked = ck.localTSN - 1
+	e.setPeerParamsLocked(peerParams{
+		tag:             ck.peerTag,
+		tsn:             ck.peerTSN,
+		rwnd:            ck.peerRwnd,
+		outboundStreams: ck.outboundStreams,
+		inboundStreams:  ck.inboundStreams,
+	})
+	e.addPeerPathsLocked(ck.peerAddrs)
+	e.setStateLocked(StateEstablished)
+	e.events |= waiter.EventOut
+}
+
+// armHeartbeatLocked arms the heartbeat timer of p, as per RFC 4960 section
+// 8.3. The addresses not confirmed yet are probed every RTO.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) armHeartbeatLocked(p *path) {
+	d := p.rto
+	if p.confirmed {
+		d += heartbeatInterval
+	}
+	e.armTimerLocked(&p.hbTimer, d, func() {
+		e.heartbeatLocked(p)
+	})
+}
+
+// heartbeatLocked sends a HEARTBEAT chunk on p, after accounting for the
+// previous one if it was not acknowledged.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) heartbeatLocked(p *path) {
+	a := e.a
+	if p.hbOutstanding {
+		if e.pathErrorLocked(p) {
+			return
+		}
+		p.backoff(e.rtoInfo)
+	}
+	index := -1
+	for i, q := range a.paths {
+		if q == p {
+			index = i
+		}
+	}
+	var nonce [8]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		panic(err)
+	}
+	p.hbNonce = binary.BigEndian.Uint64(nonce[:])
+	p.hbOutstanding = true
+
+	info := make([]byte, heartbeatInfoSize)
+	binary.BigEndian.PutUint32(info, uint32(index))
+	binary.BigEndian.PutUint64(info[4:], p.hbNonce)
+	binary.BigEndian.PutUint64(info[12:], uint64(e.stack.Clock().NowMonotonic()))
+	b := make(buffer.View, header.SCTPHeartbeatChunkSize(len(info)))
+	header.EncodeSCTPHeartbeatChunk(b, header.SCTPChunkHeartbeat, info)
+	e.queuePacketLocked(p, a.peerTag, b)
+	e.armHeartbeatLocked(p)
+}
+
+// handleHeartbeatAckLocked handles the acknowledgement of a heartbeat, which
+// confirms the address it was sent to and marks it as active, as per RFC 4960
+// section 8.3.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) handleHeartbeatAckLocked(c header.SCTPHeartbeatChunk) {
+	a := e.a
+	info, ok := c.Info()
+	if !ok || len(info) != heartbeatInfoSize {
+		return
+	}
+	index := int(binary.BigEndian.Uint32(info))
+	if index >= len(a.paths) {
+		return
+	}
+	p := a.paths[index]
+	if !p.hbOutstanding || binary.BigEndian.Uint64(info[4:]) != p.hbNonce {
+		return
+	}
+	p.hbOutstanding = false
+	p.errorCount = 0
+	a.errorCount = 0
+	p.updateRTO(time.Duration(e.stack.Clock().NowMonotonic()-int64(binary.BigEndian.Uint64(info[12:]))), e.rtoInfo)
+	if !p.confirmed {
+		p.confirmed = true
+		e.armHeartbeatLocked(p)
+	}
+	p.active = true
+}
+
+// handleShutdownLocked handles a SHUTDOWN chunk, as per RFC 4960 section 9.2.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) handleShutdownLocked(c header.SCTPShutdownChunk) {
+	a := e.a
+	switch e.EndpointState() {
+	case StateEstablished, StateShutdownPending:
+		e.setStateLocked(StateShutdownReceived)
+		e.events |= waiter.EventIn
+		e.ackLocked(c.CumulativeTSNAck(), nil /* gaps */, a.peerRwnd)
+		e.maybeShutdownLocked()
+	case StateShutdownSent:
+		// Both endpoints shut down the association simultaneously.
+		e.ackLocked(c.CumulativeTSNAck(), nil /* gaps */, a.peerRwnd)
+		e.events |= waiter.EventIn
+		e.sendShutdownAckLocked()
+	case StateShutdownReceived:
+		e.ackLocked(c.CumulativeTSNAck(), nil /* gaps */, a.peerRwnd)
+		e.maybeShutdownLocked()
+	}
+}
+
+// shutdownLocked starts the graceful shutdown of the association, which
+// completes once the data queued is acknowledged.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) shutdownLocked() {
+	switch e.EndpointState() {
+	case StateEstablished:
+		e.setStateLocked(StateShutdownPending)
+		e.maybeShutdownLocked()
+	case StateShutdownReceived:
+		e.maybeShutdownLocked()
+	}
+}
+
+// maybeShutdownLocked sends the SHUTDOWN or SHUTDOWN ACK chunk once all the
+// data sent is acknowledged, while the association is shut down.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) maybeShutdownLocked() {
+	if len(e.a.chunks) != 0 {
+		return
+	}
+	switch e.EndpointState() {
+	case StateShutdownPending:
+		e.sendShutdownLocked()
+	case StateShutdownReceived:
+		e.sendShutdownAckLocked()
+	}
+}
+
+// sendShutdownLocked sends a SHUTDOWN chunk, and arms the T2-shutdown timer to
+// retransmit it.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) sendShutdownLocked() {
+	a := e.a
+	if e.EndpointState() != StateShutdownSent {
+		e.setStateLocked(StateShutdownSent)
+		a.shutdownRTO = a.activePath().rto
+	}
+	b := make(buffer.View, header.SCTPShutdownChunkLength)
+	header.EncodeSCTPShutdownChunk(b, a.cumTSN)
+	e.queuePacketLocked(a.activePath(), a.peerTag, b)
+	e.armShutdownTimerLocked(e.sendShutdownLocked)
+}
+
+// sendShutdownAckLocked sends a SHUTDOWN ACK chunk, and arms the T2-shutdown
+// timer to retransmit it.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) sendShutdownAckLocked() {
+	a := e.a
+	if e.EndpointState() != StateShutdownAckSent {
+		e.setStateLocked(StateShutdownAckSent)
+		a.shutdownRTO = a.activePath().rto
+	}
+	e.queueChunkLocked(a.activePath(), header.SCTPChunkShutdownAck, 0 /* flags */, nil)
+	e.armShutdownTimerLocked(e.sendShutdownAckLocked)
+}
+
+// armShutdownTimerLocked arms the T2-shutdown timer to call resend, until the
+// peer is considered unreachable.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) armShutdownTimerLocked(resend func()) {
+	a := e.a
+	e.armTimerLocked(&a.shutdownTimer, a.shutdownRTO, func() {
+		a.errorCount++
+		if a.errorCount > assocMaxRetrans {
+			e.abortLocked(tcpip.ErrTimeout, true /* send */)
+			return
+		}
+		a.shutdownRTO *= 2
+		if a.shutdownRTO > e.rtoInfo.Max {
+			a.shutdownRTO = e.rtoInfo.Max
+		}
+		resend()
+	})
+}
+
+// abortLocked aborts the association, sending an ABORT chunk to the peer if
+// send is true.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) abortLocked(err *tcpip.Error, send bool) {
+	a := e.a
+	if send && len(a.paths) != 0 {
+		// The peer's tag is not known before the INIT ACK chunk is
+		// received; the ABORT chunk then carries ours, and the T bit,
+		// as per RFC 4960 section 8.5.1.
+		tag, flags := a.peerTag, uint8(0)
+		if tag == 0 {
+			tag, flags = a.localTag, header.SCTPFlagT
+		}
+		e.queuePacketLocked(a.activePath(), tag, abortChunk(flags, 0 /* cause */))
+	}
+	e.stack.Stats().SCTP.Aborts.Increment()
+	e.closeAssociationLocked(err)
+}
+
+// closeAssociationLocked ends the association, with the error err if it was not
+// shut down gracefully.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) closeAssociationLocked(err *tcpip.Error) {
+	a := e.a
+	e.stopTimerLocked(&a.initTimer)
+	e.stopTimerLocked(&a.shutdownTimer)
+	e.stopTimerLocked(&a.sackTimer)
+	e.unregisterLocked()
+	e.releasePathsLocked()
+	a.chunks = nil
+	a.unsent = 0
+	a.sndBufUsed = 0
+	a.fragments = nil
+	a.received = nil
+
+	e.hardError = err
+	e.setStateLocked(StateClosed)
+	if e.closed {
+		e.releasePortLocked()
+	}
+	e.events |= waiter.EventIn | waiter.EventOut | waiter.EventHUp
+	if err != nil {
+		e.events |= waiter.EventErr
+	}
+}
+
+// releasePathsLocked stops the timers of the paths of the association and
+// releases their routes.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) releasePathsLocked() {
+	for _, p := range e.a.paths {
+		e.stopTimerLocked(&p.t3)
+		e.stopTimerLocked(&p.hbTimer)
+		p.route.Release()
+		p.active = false
+	}
+}
+
+// statusLocked returns the status of the association.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) statusLocked() tcpip.SCTPStatusOption {
+	a := e.a
+	st := tcpip.SCTPStatusOption{
+		State:              uint32(e.EndpointState()),
+		ReceiveWindow:      a.peerRwnd,
+		UnackedData:        uint16(len(a.chunks) - a.unsent),
+		PendingData:        uint16(a.unsent),
+		InboundStreams:     a.inboundStreams,
+		OutboundStreams:    a.outboundStreams,
+		FragmentationPoint: uint32(e.fragmentSizeLocked()),
+		Primary:            a.activePath().info(e),
+	}
+	for _, p := range a.paths {
+		st.Paths = append(st.Paths, p.info(e))
+	}
+	return st
+}
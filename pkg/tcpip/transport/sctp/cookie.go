@@ -0,0 +1,136 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sctp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+const (
+	// cookieSecretSize is the size of the key of the MACs of the state
+	// cookies.
+	cookieSecretSize = 32
+
+	// cookieLifetime is how long a state cookie is valid, as Linux's
+	// default net.sctp.valid_cookie_life.
+	cookieLifetime = 60 * time.Second
+
+	// cookieFixedSize is the size of a state cookie without its MAC and
+	// peer addresses.
+	cookieFixedSize = 8 + 7*4 + 4*2 + 1
+)
+
+// cookie is the state of an association carried by the state cookie of the
+// INIT ACK chunks, so that an endpoint holds no state for the association
+// until the peer echoes the cookie, as per RFC 4960 section 5.1.3.
+type cookie struct {
+	// createdAt is when the cookie was created, in nanoseconds of the
+	// stack's monotonic clock.
+	createdAt int64
+
+	localTag uint32
+	peerTag  uint32
+	localTSN uint32
+	peerTSN  uint32
+	peerRwnd uint32
+
+	// tieLocalTag and tiePeerTag are the tags of the existing association
+	// when the INIT was received by one, as per RFC 4960 section 5.2.2.
+	tieLocalTag uint32
+	tiePeerTag  uint32
+
+	outboundStreams uint16
+	inboundStreams  uint16
+	localPort       uint16
+	peerPort        uint16
+
+	// peerAddrs are the addresses of the peer, the source address of the
+	// INIT first.
+	peerAddrs []tcpip.Address
+}
+
+// encodeCookie returns the state cookie carrying c.
+func (p *protocol) encodeCookie(c *cookie) []byte {
+	n := sha256.Size + cookieFixedSize
+	for _, addr := range c.peerAddrs {
+		n += 1 + len(addr)
+	}
+	b := make([]byte, n)
+	v := b[sha256.Size:]
+	binary.BigEndian.PutUint64(v, uint64(c.createdAt))
+	v = v[8:]
+	for _, u := range []uint32{c.localTag, c.peerTag, c.localTSN, c.peerTSN, c.peerRwnd, c.tieLocalTag, c.tiePeerTag} {
+		binary.BigEndian.PutUint32(v, u)
+		v = v[4:]
+	}
+	for _, u := range []uint16{c.outboundStreams, c.inboundStreams, c.localPort, c.peerPort} {
+		binary.BigEndian.PutUint16(v, u)
+		v = v[2:]
+	}
+	v[0] = uint8(len(c.peerAddrs))
+	v = v[1:]
+	for _, addr := range c.peerAddrs {
+		v[0] = uint8(len(addr))
+		copy(v[1:], addr)
+		v = v[1+len(addr):]
+	}
+	copy(b, p.cookieMAC(b[sha256.Size:]))
+	return b
+}
+
+// decodeCookie returns the state carried by the state cookie b. It returns
+// false if b was not created by encodeCookie or has expired at now.
+func (p *protocol) decodeCookie(b []byte, now int64) (cookie, bool) {
+	if len(b) < sha256.Size+cookieFixedSize || !hmac.Equal(b[:sha256.Size], p.cookieMAC(b[sha256.Size:])) {
+		return cookie{}, false
+	}
+	var c cookie
+	v := b[sha256.Size:]
+	c.createdAt = int64(binary.BigEndian.Uint64(v))
+	v = v[8:]
+	for _, u := range []*uint32{&c.localTag, &c.peerTag, &c.localTSN, &c.peerTSN, &c.peerRwnd, &c.tieLocalTag, &c.tiePeerTag} {
+		*u = binary.BigEndian.Uint32(v)
+		v = v[4:]
+	}
+	for _, u := range []*uint16{&c.outboundStreams, &c.inboundStreams, &c.localPort, &c.peerPort} {
+		*u = binary.BigEndian.Uint16(v)
+		v = v[2:]
+	}
+	n := int(v[0])
+	v = v[1:]
+	for i := 0; i < n; i++ {
+		if len(v) == 0 || len(v) < 1+int(v[0]) {
+			return cookie{}, false
+		}
+		c.peerAddrs = append(c.peerAddrs, tcpip.Address(v[1:1+v[0]]))
+		v = v[1+v[0]:]
+	}
+	if len(c.peerAddrs) == 0 || now-c.createdAt > cookieLifetime.Nanoseconds() {
+		return cookie{}, false
+	}
+	return c, true
+}
+
+// cookieMAC returns the MAC of the state v of a cookie.
+func (p *protocol) cookieMAC(v []byte) []byte {
+	h := hmac.New(sha256.New, p.secret[:])
+	h.Write(v)
+	return h.Sum(nil)
+}
@@ -0,0 +1,1234 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sctp
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/ports"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// EndpointState represents the state of an SCTP endpoint, as per RFC 4960
+// section 4 for the states of an association.
+type EndpointState uint32
+
+// Endpoint states. Note that are represented in a netstack-specific manner and
+// may not be meaningful externally. Specifically, they need to be translated to
+// Linux's representation for these states if presented to userspace.
+const (
+	StateInitial EndpointState = iota
+	StateBound
+	StateListen
+	StateCookieWait
+	StateCookieEchoed
+	StateEstablished
+	StateShutdownPending
+	StateShutdownSent
+	StateShutdownReceived
+	StateShutdownAckSent
+	StateClosed
+)
+
+// String implements fmt.Stringer.String.
+func (s EndpointState) String() string {
+	switch s {
+	case StateInitial:
+		return "INITIAL"
+	case StateBound:
+		return "BOUND"
+	case StateListen:
+		return "LISTEN"
+	case StateCookieWait:
+		return "COOKIE-WAIT"
+	case StateCookieEchoed:
+		return "COOKIE-ECHOED"
+	case StateEstablished:
+		return "ESTABLISHED"
+	case StateShutdownPending:
+		return "SHUTDOWN-PENDING"
+	case StateShutdownSent:
+		return "SHUTDOWN-SENT"
+	case StateShutdownReceived:
+		return "SHUTDOWN-RECEIVED"
+	case StateShutdownAckSent:
+		return "SHUTDOWN-ACK-SENT"
+	case StateClosed:
+		return "CLOSED"
+	default:
+		panic(fmt.Sprintf("unreachable state %d", s))
+	}
+}
+
+// associated returns true if the endpoint is an association being set up,
+// established, or being shut down.
+func (s EndpointState) associated() bool {
+	return s >= StateCookieWait && s < StateClosed
+}
+
+// established returns true if an association is established and data may be
+// exchanged in at least one direction.
+func (s EndpointState) established() bool {
+	return s >= StateEstablished && s < StateClosed
+}
+
+// Default parameters of the associations, as Linux's.
+const (
+	defaultOutboundStreams   = 10
+	defaultMaxInboundStreams = header.SCTPMaxStreams
+	defaultMaxInitAttempts   = 8
+	defaultRTOInitial        = 3 * time.Second
+	defaultRTOMin            = time.Second
+	defaultRTOMax            = 60 * time.Second
+)
+
+// maxInboundQueued is the maximum number of inbound packets queued to an
+// endpoint and not handled yet.
+const maxInboundQueued = 1024
+
+// registration is a transport endpoint ID registered with the stack.
+type registration struct {
+	nicID     tcpip.NICID
+	netProtos []tcpip.NetworkProtocolNumber
+	id        stack.TransportEndpointID
+}
+
+// endpoint represents an SCTP endpoint, which is either listening or a single
+// association. This struct serves as the interface between users of the
+// endpoint and the protocol implementation; it is legal to have concurrent
+// goroutines make calls into the endpoint, they are properly synchronized.
+//
+// It implements tcpip.Endpoint.
+type endpoint struct {
+	stack.TransportEndpointInfo
+	tcpip.DefaultSocketOptionsHandler
+
+	// The following fields are initialized at creation time and do not
+	// change throughout the lifetime of the endpoint.
+	stack       *stack.Stack
+	protocol    *protocol
+	waiterQueue *waiter.Queue
+	uniqueID    uint64
+
+	// mu protects all the following fields.
+	mu sync.Mutex
+
+	// state must be read/set using the EndpointState()/setStateLocked()
+	// methods.
+	state EndpointState
+
+	// pending are the packets to write and events are the events to notify
+	// once mu is released, see unlock.
+	pending []*outboundPacket
+	events  waiter.EventMask
+
+	// hardError is the error which ended the association, returned once by
+	// Connect or Read.
+	hardError *tcpip.Error
+
+	// connectNotified is true once Connect reported the establishment of
+	// the association.
+	connectNotified bool
+
+	// closed is true once Close was called. The association is then shut
+	// down in the background.
+	closed bool
+
+	lastErrorMu sync.Mutex
+	lastError   *tcpip.Error
+
+	// inboundMu protects inbound and processing. The packets delivered by
+	// the stack are handled by a separate goroutine, as handling them may
+	// unregister the endpoint, which the stack doesn't allow while it is
+	// delivering a packet.
+	inboundMu  sync.Mutex
+	inbound    []func()
+	processing bool
+
+	// Values used to reserve a port and register the endpoint.
+	portFlags         ports.Flags
+	bindToDevice      tcpip.NICID
+	boundPortFlags    ports.Flags
+	boundBindToDevice tcpip.NICID
+	portReserved      bool
+	reservedNetProtos []tcpip.NetworkProtocolNumber
+	registrations     []registration
+
+	// The following fields are used by listening endpoints.
+	backlog     int
+	acceptQueue []*endpoint
+
+	// a is the association of the endpoint, if any. It is kept after the
+	// association ends for the messages still unread.
+	a *association
+
+	// Parameters of the association, set by socket options.
+	initMsg    tcpip.SCTPInitMsgOption
+	rtoInfo    tcpip.SCTPRTOInfoOption
+	sndInfo    tcpip.SCTPSndInfo
+	rcvInfo    bool
+	maxSeg     int
+	sndBufSize int
+	rcvBufSize int
+
+	shutdownFlags tcpip.ShutdownFlags
+	linger        tcpip.LingerOption
+	owner         tcpip.PacketOwner
+
+	stats tcpip.TransportEndpointStats
+	ops   tcpip.SocketOptions
+}
+
+func newEndpoint(p *protocol, netProto tcpip.NetworkProtocolNumber, waiterQueue *waiter.Queue) *endpoint {
+	e := &endpoint{
+		stack:    p.stack,
+		protocol: p,
+		TransportEndpointInfo: stack.TransportEndpointInfo{
+			NetProto:   netProto,
+			TransProto: ProtocolNumber,
+		},
+		waiterQueue: waiterQueue,
+		uniqueID:    p.stack.UniqueID(),
+		initMsg: tcpip.SCTPInitMsgOption{
+			OutboundStreams:   defaultOutboundStreams,
+			MaxInboundStreams: defaultMaxInboundStreams,
+			MaxAttempts:       defaultMaxInitAttempts,
+			MaxInitTimeout:    defaultRTOMax,
+		},
+		rtoInfo: tcpip.SCTPRTOInfoOption{
+			Initial: defaultRTOInitial,
+			Min:     defaultRTOMin,
+			Max:     defaultRTOMax,
+		},
+		sndBufSize: DefaultBufferSize,
+		rcvBufSize: DefaultBufferSize,
+	}
+	e.ops.InitHandler(e)
+	// Nagle's algorithm is enabled by default, as in Linux.
+	e.ops.SetDelayOption(true)
+	return e
+}
+
+// newAcceptedEndpoint returns a new endpoint for an association accepted by
+// the listening endpoint e, which inherits its socket options.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) newAcceptedEndpoint() *endpoint {
+	n := newEndpoint(e.protocol, e.NetProto, &waiter.Queue{})
+	n.initMsg = e.initMsg
+	n.rtoInfo = e.rtoInfo
+	n.sndInfo = e.sndInfo
+	n.rcvInfo = e.rcvInfo
+	n.maxSeg = e.maxSeg
+	n.sndBufSize = e.sndBufSize
+	n.rcvBufSize = e.rcvBufSize
+	n.linger = e.linger
+	n.owner = e.owner
+	n.bindToDevice = e.bindToDevice
+	n.ops.SetDelayOption(e.ops.GetDelayOption())
+	n.ops.SetV6Only(e.ops.GetV6Only())
+	n.BindNICID = e.BindNICID
+	n.BindAddr = e.BindAddr
+	return n
+}
+
+// setStateLocked updates the state of the endpoint. This method is unexported
+// as the only place we should update the state is in this package but we allow
+// the state to be read freely without holding e.mu.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) setStateLocked(s EndpointState) {
+	counted := func(s EndpointState) bool {
+		return s == StateEstablished || s == StateShutdownPending
+	}
+	old := e.EndpointState()
+	switch {
+	case counted(s) && !counted(old):
+		e.stack.Stats().SCTP.CurrentEstablished.Increment()
+	case !counted(s) && counted(old):
+		e.stack.Stats().SCTP.CurrentEstablished.Decrement()
+	}
+	atomic.StoreUint32((*uint32)(&e.state), uint32(s))
+}
+
+// EndpointState returns the current state of the endpoint.
+func (e *endpoint) EndpointState() EndpointState {
+	return EndpointState(atomic.LoadUint32((*uint32)(&e.state)))
+}
+
+// unlock releases e.mu, then writes the packets and notifies the events queued
+// while it was held. Packets are never written with e.mu held since looped
+// back packets are delivered synchronously, possibly to e itself.
+func (e *endpoint) unlock() {
+	pending := e.pending
+	e.pending = nil
+	events := e.events
+	e.events = 0
+	e.mu.Unlock()
+
+	for _, o := range pending {
+		o.write()
+	}
+	if events != 0 {
+		e.waiterQueue.Notify(events)
+	}
+}
+
+// queuePacketLocked queues chunks to be sent to path p when e.mu is released.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) queuePacketLocked(p *path, tag uint32, chunks buffer.View) {
+	e.pending = append(e.pending, &outboundPacket{
+		route:      p.route.Clone(),
+		localPort:  e.ID.LocalPort,
+		remotePort: e.ID.RemotePort,
+		tag:        tag,
+		chunks:     chunks,
+		owner:      e.owner,
+	})
+}
+
+// timer is a timer of an endpoint whose expirations are ignored once it is
+// stopped or rearmed, even if its function is already waiting for e.mu.
+type timer struct {
+	t   tcpip.Timer
+	gen uint64
+}
+
+// enabled returns true if the timer is armed.
+func (t *timer) enabled() bool {
+	return t.t != nil
+}
+
+// armTimerLocked arms t to call f with e.mu held after d.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) armTimerLocked(t *timer, d time.Duration, f func()) {
+	e.stopTimerLocked(t)
+	gen := t.gen
+	t.t = e.stack.Clock().AfterFunc(d, func() {
+		e.mu.Lock()
+		if t.gen != gen {
+			e.unlock()
+			return
+		}
+		t.t = nil
+		f()
+		e.unlock()
+	})
+}
+
+// stopTimerLocked stops t.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) stopTimerLocked(t *timer) {
+	if t.t != nil {
+		t.t.Stop()
+		t.t = nil
+	}
+	t.gen++
+}
+
+// UniqueID implements stack.TransportEndpoint.UniqueID.
+func (e *endpoint) UniqueID() uint64 {
+	return e.uniqueID
+}
+
+// LastError implements tcpip.Endpoint.LastError.
+func (e *endpoint) LastError() *tcpip.Error {
+	e.lastErrorMu.Lock()
+	defer e.lastErrorMu.Unlock()
+
+	err := e.lastError
+	e.lastError = nil
+	return err
+}
+
+// takeHardErrorLocked returns and clears the error which ended the
+// association.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) takeHardErrorLocked() *tcpip.Error {
+	err := e.hardError
+	e.hardError = nil
+	return err
+}
+
+// Abort implements stack.TransportEndpoint.Abort.
+func (e *endpoint) Abort() {
+	e.mu.Lock()
+	if e.EndpointState().associated() {
+		e.abortLocked(tcpip.ErrConnectionAborted, true /* send */)
+	}
+	e.unlock()
+	e.Close()
+}
+
+// Close puts the endpoint in a closed state and frees all resources associated
+// with it. An established association is shut down gracefully in the
+// background, unless it has unread data or SO_LINGER is set with a zero
+// timeout, in which case it is aborted.
+func (e *endpoint) Close() {
+	e.mu.Lock()
+	if e.closed {
+		e.unlock()
+		return
+	}
+	e.closed = true
+	e.shutdownFlags = tcpip.ShutdownRead | tcpip.ShutdownWrite
+
+	var acceptQueue []*endpoint
+	switch s := e.EndpointState(); s {
+	case StateListen:
+		acceptQueue = e.acceptQueue
+		e.acceptQueue = nil
+		e.unregisterLocked()
+		e.setStateLocked(StateClosed)
+	case StateCookieWait, StateCookieEchoed:
+		e.abortLocked(tcpip.ErrConnectionAborted, true /* send */)
+	case StateEstablished, StateShutdownPending, StateShutdownSent, StateShutdownReceived, StateShutdownAckSent:
+		if len(e.a.rcvQueue) != 0 || (e.linger.Enabled && e.linger.Timeout == 0) {
+			e.abortLocked(tcpip.ErrConnectionAborted, true /* send */)
+		} else {
+			e.shutdownLocked()
+		}
+	default:
+		e.setStateLocked(StateClosed)
+	}
+	if e.a != nil {
+		e.a.rcvQueue = nil
+		e.a.rcvQueued = 0
+		e.a.rcvBufUsed = 0
+	}
+	if e.EndpointState() == StateClosed {
+		e.releasePortLocked()
+	}
+	e.events |= waiter.EventHUp | waiter.EventErr | waiter.EventIn | waiter.EventOut
+	e.unlock()
+
+	// The associations not accepted yet are aborted.
+	for _, n := range acceptQueue {
+		n.Close()
+	}
+}
+
+// releasePortLocked releases the port reserved by the endpoint, if any.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) releasePortLocked() {
+	if !e.portReserved {
+		return
+	}
+	e.stack.ReleasePort(e.reservedNetProtos, ProtocolNumber, e.BindAddr, e.ID.LocalPort, e.boundPortFlags, e.boundBindToDevice, tcpip.FullAddress{})
+	e.portReserved = false
+	e.boundPortFlags = ports.Flags{}
+	e.boundBindToDevice = 0
+}
+
+// registerLocked registers id with the stack.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) registerLocked(nicID tcpip.NICID, netProtos []tcpip.NetworkProtocolNumber, id stack.TransportEndpointID) *tcpip.Error {
+	if err := e.stack.RegisterTransportEndpoint(nicID, netProtos, ProtocolNumber, id, e, e.boundPortFlags, e.boundBindToDevice); err != nil {
+		return err
+	}
+	e.registrations = append(e.registrations, registration{
+		nicID:     nicID,
+		netProtos: netProtos,
+		id:        id,
+	})
+	return nil
+}
+
+// unregisterLocked unregisters all the IDs of the endpoint from the stack.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) unregisterLocked() {
+	for _, r := range e.registrations {
+		e.stack.UnregisterTransportEndpoint(r.nicID, r.netProtos, ProtocolNumber, r.id, e, e.boundPortFlags, e.boundBindToDevice)
+	}
+	e.registrations = nil
+}
+
+// ModerateRecvBuf implements tcpip.Endpoint.ModerateRecvBuf.
+func (*endpoint) ModerateRecvBuf(int) {}
+
+// Read reads the next message received on the association. This method does
+// not block if there is no message pending.
+func (e *endpoint) Read(addr *tcpip.FullAddress) (buffer.View, tcpip.ControlMessages, *tcpip.Error) {
+	if err := e.LastError(); err != nil {
+		return buffer.View{}, tcpip.ControlMessages{}, err
+	}
+
+	e.mu.Lock()
+	defer e.unlock()
+
+	if e.a == nil || len(e.a.rcvQueue) == 0 {
+		err := e.readErrorLocked()
+		if err == tcpip.ErrClosedForReceive {
+			e.stats.ReadErrors.ReadClosed.Increment()
+		}
+		return buffer.View{}, tcpip.ControlMessages{}, err
+	}
+
+	a := e.a
+	m := a.rcvQueue[0]
+	a.rcvQueue[0] = nil
+	a.rcvQueue = a.rcvQueue[1:]
+	a.rcvQueued -= len(m.data)
+	a.rcvBufUsed -= len(m.data)
+	e.windowUpdateLocked()
+
+	if addr != nil {
+		*addr = e.remoteAddressLocked()
+	}
+	var cm tcpip.ControlMessages
+	if e.rcvInfo {
+		cm.HasSCTPRcvInfo = true
+		cm.SCTPRcvInfo = m.info
+	}
+	e.stats.PacketsReceived.Increment()
+	return m.data, cm, nil
+}
+
+// readErrorLocked returns the error of a read when no message is pending.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) readErrorLocked() *tcpip.Error {
+	switch s := e.EndpointState(); {
+	case s == StateInitial || s == StateBound || s == StateListen:
+		return tcpip.ErrNotConnected
+	case s == StateCookieWait || s == StateCookieEchoed:
+		return tcpip.ErrWouldBlock
+	case e.shutdownFlags&tcpip.ShutdownRead != 0:
+		return tcpip.ErrClosedForReceive
+	case s == StateClosed:
+		if err := e.takeHardErrorLocked(); err != nil {
+			return err
+		}
+		return tcpip.ErrClosedForReceive
+	case s == StateShutdownReceived || s == StateShutdownAckSent:
+		// The peer won't send more data.
+		return tcpip.ErrClosedForReceive
+	default:
+		return tcpip.ErrWouldBlock
+	}
+}
+
+// Write writes a message to the association. Messages are written atomically:
+// either completely, or not at all.
+func (e *endpoint) Write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, <-chan struct{}, *tcpip.Error) {
+	if err := e.LastError(); err != nil {
+		return 0, nil, err
+	}
+
+	e.mu.Lock()
+	defer e.unlock()
+
+	n, err := e.writeLocked(p, opts)
+	if err != nil {
+		if err == tcpip.ErrClosedForSend {
+			e.stats.WriteErrors.WriteClosed.Increment()
+		}
+		return 0, nil, err
+	}
+	e.stats.PacketsSent.Increment()
+	return n, nil, nil
+}
+
+// writeLocked queues a message written to the association.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) writeLocked(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, *tcpip.Error) {
+	switch s := e.EndpointState(); s {
+	case StateInitial, StateBound, StateListen:
+		return 0, tcpip.ErrNotConnected
+	case StateCookieWait, StateCookieEchoed:
+		return 0, tcpip.ErrWouldBlock
+	case StateEstablished:
+		if e.shutdownFlags&tcpip.ShutdownWrite != 0 {
+			return 0, tcpip.ErrClosedForSend
+		}
+	case StateClosed:
+		if e.hardError != nil {
+			return 0, tcpip.ErrConnectionReset
+		}
+		return 0, tcpip.ErrClosedForSend
+	default:
+		return 0, tcpip.ErrClosedForSend
+	}
+	if opts.To != nil {
+		return 0, tcpip.ErrAlreadyConnected
+	}
+
+	a := e.a
+	info := e.sndInfo
+	if opts.SCTPSndInfo != nil {
+		info = *opts.SCTPSndInfo
+	}
+	if info.Stream >= a.outboundStreams {
+		return 0, tcpip.ErrInvalidOptionValue
+	}
+
+	v, err := p.FullPayload()
+	if err != nil {
+		return 0, err
+	}
+	if len(v) == 0 {
+		// DATA chunks carry at least one byte, as per RFC 4960 section
+		// 6.2.
+		return 0, tcpip.ErrInvalidOptionValue
+	}
+	if len(v) > e.sndBufSize {
+		return 0, tcpip.ErrMessageTooLong
+	}
+	if a.sndBufUsed+len(v) > e.sndBufSize {
+		return 0, tcpip.ErrWouldBlock
+	}
+
+	e.queueMessageLocked(buffer.NewViewFromBytes(v), info)
+	e.transmitLocked()
+	return int64(len(v)), nil
+}
+
+// Peek implements tcpip.Endpoint.Peek.
+func (*endpoint) Peek([][]byte) (int64, tcpip.ControlMessages, *tcpip.Error) {
+	return 0, tcpip.ControlMessages{}, nil
+}
+
+// SetSockOpt implements tcpip.Endpoint.SetSockOpt.
+func (e *endpoint) SetSockOpt(opt tcpip.SettableSocketOption) *tcpip.Error {
+	e.mu.Lock()
+	defer e.unlock()
+
+	switch v := opt.(type) {
+	case *tcpip.SCTPInitMsgOption:
+		if v.OutboundStreams != 0 {
+			e.initMsg.OutboundStreams = v.OutboundStreams
+		}
+		if v.MaxInboundStreams != 0 {
+			e.initMsg.MaxInboundStreams = v.MaxInboundStreams
+		}
+		if v.MaxAttempts != 0 {
+			e.initMsg.MaxAttempts = v.MaxAttempts
+		}
+		if v.MaxInitTimeout != 0 {
+			e.initMsg.MaxInitTimeout = v.MaxInitTimeout
+		}
+
+	case *tcpip.SCTPRTOInfoOption:
+		rto := e.rtoInfo
+		if v.Initial != 0 {
+			rto.Initial = v.Initial
+		}
+		if v.Min != 0 {
+			rto.Min = v.Min
+		}
+		if v.Max != 0 {
+			rto.Max = v.Max
+		}
+		if rto.Min > rto.Max || rto.Initial < rto.Min || rto.Initial > rto.Max {
+			return tcpip.ErrInvalidOptionValue
+		}
+		e.rtoInfo = rto
+
+	case *tcpip.SCTPDefaultSndInfoOption:
+		e.sndInfo = tcpip.SCTPSndInfo(*v)
+
+	case *tcpip.BindToDeviceOption:
+		id := tcpip.NICID(*v)
+		if id != 0 && !e.stack.HasNIC(id) {
+			return tcpip.ErrUnknownDevice
+		}
+		e.bindToDevice = id
+
+	case *tcpip.LingerOption:
+		e.linger = *v
+	}
+	return nil
+}
+
+// SetSockOptInt implements tcpip.Endpoint.SetSockOptInt.
+func (e *endpoint) SetSockOptInt(opt tcpip.SockOptInt, v int) *tcpip.Error {
+	e.mu.Lock()
+	defer e.unlock()
+
+	switch opt {
+	case tcpip.SendBufferSizeOption:
+		e.sndBufSize = clampBufferSize(v)
+		e.events |= waiter.EventOut
+
+	case tcpip.ReceiveBufferSizeOption:
+		e.rcvBufSize = clampBufferSize(v)
+		if e.a != nil {
+			e.windowUpdateLocked()
+		}
+
+	case tcpip.MaxSegOption:
+		if v < 0 || (v != 0 && v < header.SCTPDataChunkHeaderLength) {
+			return tcpip.ErrInvalidOptionValue
+		}
+		e.maxSeg = v
+
+	case tcpip.SCTPRecvRcvInfoOption:
+		e.rcvInfo = v != 0
+	}
+	return nil
+}
+
+// clampBufferSize returns v within the bounds of the buffer sizes.
+func clampBufferSize(v int) int {
+	if v < MinBufferSize {
+		return MinBufferSize
+	}
+	if v > MaxBufferSize {
+		return MaxBufferSize
+	}
+	return v
+}
+
+// GetSockOpt implements tcpip.Endpoint.GetSockOpt.
+func (e *endpoint) GetSockOpt(opt tcpip.GettableSocketOption) *tcpip.Error {
+	e.mu.Lock()
+	defer e.unlock()
+
+	switch o := opt.(type) {
+	case *tcpip.SCTPInitMsgOption:
+		*o = e.initMsg
+
+	case *tcpip.SCTPRTOInfoOption:
+		*o = e.rtoInfo
+
+	case *tcpip.SCTPDefaultSndInfoOption:
+		*o = tcpip.SCTPDefaultSndInfoOption(e.sndInfo)
+
+	case *tcpip.SCTPStatusOption:
+		if e.a == nil || !e.EndpointState().associated() {
+			return tcpip.ErrNotConnected
+		}
+		*o = e.statusLocked()
+
+	case *tcpip.BindToDeviceOption:
+		*o = tcpip.BindToDeviceOption(e.bindToDevice)
+
+	case *tcpip.LingerOption:
+		*o = e.linger
+
+	default:
+		return tcpip.ErrUnknownProtocolOption
+	}
+	return nil
+}
+
+// GetSockOptInt implements tcpip.Endpoint.GetSockOptInt.
+func (e *endpoint) GetSockOptInt(opt tcpip.SockOptInt) (int, *tcpip.Error) {
+	e.mu.Lock()
+	defer e.unlock()
+
+	switch opt {
+	case tcpip.SendBufferSizeOption:
+		return e.sndBufSize, nil
+
+	case tcpip.ReceiveBufferSizeOption:
+		return e.rcvBufSize, nil
+
+	case tcpip.ReceiveQueueSizeOption:
+		if e.a == nil || len(e.a.rcvQueue) == 0 {
+			return 0, nil
+		}
+		return len(e.a.rcvQueue[0].data), nil
+
+	case tcpip.SendQueueSizeOption:
+		if e.a == nil {
+			return 0, nil
+		}
+		return e.a.sndBufUsed, nil
+
+	case tcpip.MaxSegOption:
+		if e.a == nil || !e.EndpointState().associated() {
+			return e.maxSeg, nil
+		}
+		return e.fragmentSizeLocked(), nil
+
+	case tcpip.SCTPRecvRcvInfoOption:
+		if e.rcvInfo {
+			return 1, nil
+		}
+		return 0, nil
+
+	default:
+		return -1, tcpip.ErrUnknownProtocolOption
+	}
+}
+
+// checkV4MappedLocked determines the effective network protocol and converts
+// addr to its canonical form.
+func (e *endpoint) checkV4MappedLocked(addr tcpip.FullAddress) (tcpip.FullAddress, tcpip.NetworkProtocolNumber, *tcpip.Error) {
+	unwrapped, netProto, err := e.TransportEndpointInfo.AddrNetProtoLocked(addr, e.ops.GetV6Only())
+	if err != nil {
+		return tcpip.FullAddress{}, 0, err
+	}
+	return unwrapped, netProto, nil
+}
+
+// Bind binds the endpoint to a specific local address and port. Specifying a
+// NIC is optional.
+func (e *endpoint) Bind(addr tcpip.FullAddress) *tcpip.Error {
+	e.mu.Lock()
+	defer e.unlock()
+
+	return e.bindLocked(addr)
+}
+
+// bindLocked reserves the local address and port of the endpoint.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) bindLocked(addr tcpip.FullAddress) *tcpip.Error {
+	// Don't allow binding once endpoint is not in the initial state
+	// anymore.
+	if e.EndpointState() != StateInitial {
+		return tcpip.ErrInvalidEndpointState
+	}
+
+	addr, netProto, err := e.checkV4MappedLocked(addr)
+	if err != nil {
+		return err
+	}
+
+	// Expand netProtos to include v4 and v6 if the caller is binding to a
+	// wildcard (empty) address, and this is an IPv6 endpoint with v6only
+	// set to false.
+	netProtos := []tcpip.NetworkProtocolNumber{netProto}
+	if netProto == header.IPv6ProtocolNumber && !e.ops.GetV6Only() && addr.Addr == "" {
+		netProtos = []tcpip.NetworkProtocolNumber{
+			header.IPv6ProtocolNumber,
+			header.IPv4ProtocolNumber,
+		}
+	}
+
+	nicID := addr.NIC
+	if len(addr.Addr) != 0 {
+		// A local address was specified, verify that it's valid unless
+		// non-local binds are allowed.
+		nicID = e.stack.CheckLocalAddress(addr.NIC, netProto, addr.Addr)
+		if nicID == 0 {
+			if !e.stack.CanBindNonLocal(netProto, &e.ops) {
+				return tcpip.ErrBadLocalAddress
+			}
+			nicID = addr.NIC
+		}
+	}
+
+	port, err := e.stack.ReservePort(netProtos, ProtocolNumber, addr.Addr, addr.Port, e.portFlags, e.bindToDevice, tcpip.FullAddress{}, nil /* testPort */)
+	if err != nil {
+		return err
+	}
+	e.portReserved = true
+	e.reservedNetProtos = netProtos
+	e.boundPortFlags = e.portFlags
+	e.boundBindToDevice = e.bindToDevice
+	e.ID = stack.TransportEndpointID{
+		LocalAddress: addr.Addr,
+		LocalPort:    port,
+	}
+	e.BindNICID = nicID
+	e.BindAddr = addr.Addr
+	e.RegisterNICID = nicID
+	e.setStateLocked(StateBound)
+	return nil
+}
+
+// Listen puts the endpoint in "listen" mode, which allows it to accept new
+// associations.
+func (e *endpoint) Listen(backlog int) *tcpip.Error {
+	e.mu.Lock()
+	defer e.unlock()
+
+	switch e.EndpointState() {
+	case StateListen:
+		// Adjust the size of the backlog iff we can fit existing
+		// pending associations into the new one.
+		if len(e.acceptQueue) > backlog {
+			return tcpip.ErrInvalidEndpointState
+		}
+		e.backlog = backlog
+		return nil
+	case StateInitial:
+		if err := e.bindLocked(tcpip.FullAddress{}); err != nil {
+			return err
+		}
+	case StateBound:
+	default:
+		return tcpip.ErrInvalidEndpointState
+	}
+
+	if err := e.registerLocked(e.BindNICID, e.reservedNetProtos, e.ID); err != nil {
+		return err
+	}
+	e.backlog = backlog
+	e.setStateLocked(StateListen)
+	return nil
+}
+
+// Accept returns a new endpoint for an association accepted by a listening
+// endpoint.
+func (e *endpoint) Accept(peerAddr *tcpip.FullAddress) (tcpip.Endpoint, *waiter.Queue, *tcpip.Error) {
+	e.mu.Lock()
+	defer e.unlock()
+
+	if e.EndpointState() != StateListen {
+		return nil, nil, tcpip.ErrInvalidEndpointState
+	}
+	if len(e.acceptQueue) == 0 {
+		return nil, nil, tcpip.ErrWouldBlock
+	}
+	n := e.acceptQueue[0]
+	e.acceptQueue[0] = nil
+	e.acceptQueue = e.acceptQueue[1:]
+
+	if peerAddr != nil {
+		n.mu.Lock()
+		*peerAddr = n.remoteAddressLocked()
+		n.mu.Unlock()
+	}
+	return n, n.waiterQueue, nil
+}
+
+// Connect starts the setup of an association with the given peer address.
+func (e *endpoint) Connect(addr tcpip.FullAddress) *tcpip.Error {
+	e.mu.Lock()
+	defer e.unlock()
+
+	switch s := e.EndpointState(); s {
+	case StateInitial, StateBound:
+	case StateCookieWait, StateCookieEchoed:
+		return tcpip.ErrAlreadyConnecting
+	case StateListen:
+		return tcpip.ErrInvalidEndpointState
+	case StateClosed:
+		if err := e.takeHardErrorLocked(); err != nil {
+			return err
+		}
+		if e.a != nil {
+			return tcpip.ErrAlreadyConnected
+		}
+		return tcpip.ErrInvalidEndpointState
+	default:
+		// The association is established. If the caller hasn't been
+		// notified yet, return success.
+		if !e.connectNotified {
+			e.connectNotified = true
+			return nil
+		}
+		return tcpip.ErrAlreadyConnected
+	}
+
+	addr, netProto, err := e.checkV4MappedLocked(addr)
+	if err != nil {
+		return err
+	}
+	if addr.Port == 0 {
+		return tcpip.ErrInvalidEndpointState
+	}
+	nicID := addr.NIC
+	if e.BindNICID != 0 {
+		if nicID != 0 && nicID != e.BindNICID {
+			return tcpip.ErrNoRoute
+		}
+		nicID = e.BindNICID
+	}
+	r, err := e.stack.FindRoute(nicID, e.BindAddr, addr.Addr, netProto, false /* multicastLoop */)
+	if err != nil {
+		return err
+	}
+	if r.IsOutboundBroadcast() || header.IsV4MulticastAddress(addr.Addr) || header.IsV6MulticastAddress(addr.Addr) {
+		r.Release()
+		return tcpip.ErrNetworkUnreachable
+	}
+
+	if e.EndpointState() == StateInitial {
+		netProtos := []tcpip.NetworkProtocolNumber{netProto}
+		port, err := e.stack.ReservePort(netProtos, ProtocolNumber, "", 0, e.portFlags, e.bindToDevice, tcpip.FullAddress{}, nil /* testPort */)
+		if err != nil {
+			r.Release()
+			return err
+		}
+		e.portReserved = true
+		e.reservedNetProtos = netProtos
+		e.boundPortFlags = e.portFlags
+		e.boundBindToDevice = e.bindToDevice
+		e.ID.LocalPort = port
+	}
+
+	e.ID.RemotePort = addr.Port
+	e.a = newAssociation(e, netProto)
+	if err := e.addPathLocked(addr.Addr, r, true /* confirmed */); err != nil {
+		e.a = nil
+		return err
+	}
+	e.ID.LocalAddress = r.LocalAddress
+	e.ID.RemoteAddress = addr.Addr
+	e.RegisterNICID = r.NICID()
+
+	e.setStateLocked(StateCookieWait)
+	e.sendInitLocked()
+	return tcpip.ErrConnectStarted
+}
+
+// ConnectEndpoint is not supported.
+func (*endpoint) ConnectEndpoint(tcpip.Endpoint) *tcpip.Error {
+	return tcpip.ErrInvalidEndpointState
+}
+
+// Disconnect implements tcpip.Endpoint.Disconnect.
+func (*endpoint) Disconnect() *tcpip.Error {
+	return tcpip.ErrNotSupported
+}
+
+// Shutdown closes the read and/or write end of the endpoint. Shutting down the
+// write end shuts down the association gracefully once the data written is
+// acknowledged, as per RFC 4960 section 9.2.
+func (e *endpoint) Shutdown(flags tcpip.ShutdownFlags) *tcpip.Error {
+	e.mu.Lock()
+	defer e.unlock()
+
+	if !e.EndpointState().established() {
+		return tcpip.ErrNotConnected
+	}
+	e.shutdownFlags |= flags
+	if flags&tcpip.ShutdownRead != 0 {
+		e.events |= waiter.EventIn
+	}
+	if flags&tcpip.ShutdownWrite != 0 {
+		e.shutdownLocked()
+	}
+	return nil
+}
+
+// GetLocalAddress returns the address to which the endpoint is bound.
+func (e *endpoint) GetLocalAddress() (tcpip.FullAddress, *tcpip.Error) {
+	e.mu.Lock()
+	defer e.unlock()
+
+	addr := e.ID.LocalAddress
+	if e.EndpointState().associated() {
+		addr = e.a.activePath().route.LocalAddress
+	}
+	return tcpip.FullAddress{
+		Addr: addr,
+		Port: e.ID.LocalPort,
+		NIC:  e.RegisterNICID,
+	}, nil
+}
+
+// GetRemoteAddress returns the primary address of the peer of the
+// association.
+func (e *endpoint) GetRemoteAddress() (tcpip.FullAddress, *tcpip.Error) {
+	e.mu.Lock()
+	defer e.unlock()
+
+	if !e.EndpointState().established() {
+		return tcpip.FullAddress{}, tcpip.ErrNotConnected
+	}
+	return e.remoteAddressLocked(), nil
+}
+
+// remoteAddressLocked returns the address of the active path to the peer.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) remoteAddressLocked() tcpip.FullAddress {
+	addr := e.ID.RemoteAddress
+	if e.a != nil && len(e.a.paths) != 0 {
+		addr = e.a.activePath().addr
+	}
+	return tcpip.FullAddress{
+		Addr: addr,
+		Port: e.ID.RemotePort,
+		NIC:  e.RegisterNICID,
+	}
+}
+
+// Readiness returns the current readiness of the endpoint. For example, if
+// waiter.EventIn is set, the endpoint is immediately readable.
+func (e *endpoint) Readiness(mask waiter.EventMask) waiter.EventMask {
+	e.mu.Lock()
+	defer e.unlock()
+
+	var result waiter.EventMask
+	switch s := e.EndpointState(); {
+	case s == StateListen:
+		if len(e.acceptQueue) != 0 {
+			result |= waiter.EventIn
+		}
+	case s == StateInitial || s == StateBound || s == StateCookieWait || s == StateCookieEchoed:
+	case s == StateClosed:
+		result |= waiter.EventIn | waiter.EventOut | waiter.EventHUp
+		if e.hardError != nil {
+			result |= waiter.EventErr
+		}
+	default:
+		a := e.a
+		if len(a.rcvQueue) != 0 || e.shutdownFlags&tcpip.ShutdownRead != 0 || s == StateShutdownReceived || s == StateShutdownAckSent {
+			result |= waiter.EventIn
+		}
+		if s != StateEstablished || e.shutdownFlags&tcpip.ShutdownWrite != 0 || a.sndBufUsed < e.sndBufSize {
+			result |= waiter.EventOut
+		}
+	}
+	return result & mask
+}
+
+// HandlePacket is called by the stack when new packets arrive to this
+// transport endpoint.
+func (e *endpoint) HandlePacket(id stack.TransportEndpointID, pkt *stack.PacketBuffer) {
+	pk, ok := e.protocol.parsePacket(id, pkt)
+	if !ok {
+		e.stats.ReceiveErrors.MalformedPacketsReceived.Increment()
+		return
+	}
+
+	e.enqueue(func() {
+		switch s := e.EndpointState(); {
+		case s == StateListen:
+			e.handleListenLocked(pk)
+		case s.associated():
+			e.handleAssociationLocked(pk)
+		default:
+			e.stats.ReceiveErrors.ClosedReceiver.Increment()
+		}
+	})
+}
+
+// HandleControlPacket implements stack.TransportEndpoint.HandleControlPacket.
+func (e *endpoint) HandleControlPacket(id stack.TransportEndpointID, typ stack.ControlType, extra uint32, pkt *stack.PacketBuffer) {
+	switch typ {
+	case stack.ControlPortUnreachable:
+		// The peer doesn't support SCTP.
+		e.enqueue(func() {
+			if e.EndpointState() == StateCookieWait {
+				e.abortLocked(tcpip.ErrConnectionRefused, false /* send */)
+			}
+		})
+	}
+}
+
+// enqueue queues f to be called with e.mu held by the goroutine handling the
+// inbound packets, which it starts if needed. f is dropped if too many are
+// already queued.
+func (e *endpoint) enqueue(f func()) {
+	e.inboundMu.Lock()
+	if len(e.inbound) >= maxInboundQueued {
+		e.inboundMu.Unlock()
+		e.stats.ReceiveErrors.ReceiveBufferOverflow.Increment()
+		e.stack.Stats().DroppedPackets.Increment()
+		return
+	}
+	e.inbound = append(e.inbound, f)
+	start := !e.processing
+	e.processing = true
+	e.inboundMu.Unlock()
+
+	if start {
+		go e.processInbound() // S/R-SAFE: not saved.
+	}
+}
+
+// processInbound calls the functions queued by enqueue in order, until none
+// is left.
+func (e *endpoint) processInbound() {
+	for {
+		e.inboundMu.Lock()
+		if len(e.inbound) == 0 {
+			e.processing = false
+			e.inboundMu.Unlock()
+			return
+		}
+		f := e.inbound[0]
+		e.inbound[0] = nil
+		e.inbound = e.inbound[1:]
+		e.inboundMu.Unlock()
+
+		e.mu.Lock()
+		f()
+		e.unlock()
+	}
+}
+
+// State implements tcpip.Endpoint.State.
+func (e *endpoint) State() uint32 {
+	return uint32(e.EndpointState())
+}
+
+// Info returns a copy of the endpoint info.
+func (e *endpoint) Info() tcpip.EndpointInfo {
+	e.mu.Lock()
+	// Make a copy of the endpoint info.
+	ret := e.TransportEndpointInfo
+	e.unlock()
+	return &ret
+}
+
+// Stats returns a pointer to the endpoint stats.
+func (e *endpoint) Stats() tcpip.EndpointStats {
+	return &e.stats
+}
+
+// Wait implements stack.TransportEndpoint.Wait.
+func (*endpoint) Wait() {}
+
+// SetOwner implements tcpip.Endpoint.SetOwner.
+func (e *endpoint) SetOwner(owner tcpip.PacketOwner) {
+	e.mu.Lock()
+	e.owner = owner
+	e.unlock()
+}
+
+// SocketOptions implements tcpip.Endpoint.SocketOptions.
+func (e *endpoint) SocketOptions() *tcpip.SocketOptions {
+	return &e.ops
+}
+
+// OnReuseAddressSet implements tcpip.SocketOptionsHandler.OnReuseAddressSet.
+func (e *endpoint) OnReuseAddressSet(v bool) {
+	e.mu.Lock()
+	e.portFlags.MostRecent = v
+	e.unlock()
+}
+
+// OnReusePortSet implements tcpip.SocketOptionsHandler.OnReusePortSet.
+func (e *endpoint) OnReusePortSet(v bool) {
+	e.mu.Lock()
+	e.portFlags.LoadBalanced = v
+	e.unlock()
+}
+
+// OnDelayOptionSet implements tcpip.SocketOptionsHandler.OnDelayOptionSet.
+func (e *endpoint) OnDelayOptionSet(v bool) {
+	if !v {
+		// Send the messages delayed by the Nagle algorithm.
+		e.mu.Lock()
+		if e.EndpointState().established() {
+			e.transmitLocked()
+		}
+		e.unlock()
+	}
+}
@@ -0,0 +1,319 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sctp contains the implementation of the SCTP transport protocol, as
+// per RFC 4960.
+//
+// Endpoints are one-to-one style sockets: each endpoint either listens for
+// associations, which are accepted as new endpoints, or is a single
+// association. Associations are multi-homed: the addresses listed by the peer
+// at setup are monitored with heartbeats, and the association fails over to
+// another one when its primary path becomes unreachable. Messages are sent on
+// ordered or unordered streams and acknowledged with SACK chunks. The dynamic
+// address reconfiguration, partial reliability and stream reconfiguration
+// extensions are not supported.
+package sctp
+
+import (
+	"gvisor.dev/gvisor/pkg/rand"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/header/parse"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/raw"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	// ProtocolNumber is the sctp protocol number.
+	ProtocolNumber = header.SCTPProtocolNumber
+
+	// MinBufferSize is the smallest size of a receive or send buffer.
+	MinBufferSize = 4 << 10 // 4KiB
+
+	// DefaultBufferSize is the default size of the send and receive
+	// buffers of an endpoint.
+	DefaultBufferSize = 208 << 10 // 208KiB, as Linux.
+
+	// MaxBufferSize is the largest size a receive or send buffer can grow
+	// to.
+	MaxBufferSize = 4 << 20 // 4MiB
+)
+
+type protocol struct {
+	stack *stack.Stack
+
+	// secret is the key of the MACs of the state cookies.
+	secret [cookieSecretSize]byte
+}
+
+// Number returns the sctp protocol number.
+func (*protocol) Number() tcpip.TransportProtocolNumber {
+	return ProtocolNumber
+}
+
+// NewEndpoint creates a new sctp endpoint.
+func (p *protocol) NewEndpoint(netProto tcpip.NetworkProtocolNumber, waiterQueue *waiter.Queue) (tcpip.Endpoint, *tcpip.Error) {
+	return newEndpoint(p, netProto, waiterQueue), nil
+}
+
+// NewRawEndpoint creates a new raw SCTP endpoint. It implements
+// stack.TransportProtocol.NewRawEndpoint.
+func (p *protocol) NewRawEndpoint(netProto tcpip.NetworkProtocolNumber, waiterQueue *waiter.Queue) (tcpip.Endpoint, *tcpip.Error) {
+	return raw.NewEndpoint(p.stack, netProto, header.SCTPProtocolNumber, waiterQueue)
+}
+
+// MinimumPacketSize returns the minimum valid sctp packet size.
+func (*protocol) MinimumPacketSize() int {
+	return header.SCTPMinimumSize
+}
+
+// ParsePorts returns the source and destination ports stored in the given sctp
+// packet.
+func (*protocol) ParsePorts(v buffer.View) (src, dst uint16, err *tcpip.Error) {
+	h := header.SCTP(v)
+	return h.SourcePort(), h.DestinationPort(), nil
+}
+
+// HandleUnknownDestinationPacket handles the packets which belong to no
+// association and are not addressed to a listening endpoint, as per RFC 4960
+// section 8.4.
+func (p *protocol) HandleUnknownDestinationPacket(id stack.TransportEndpointID, pkt *stack.PacketBuffer) stack.UnknownDestinationPacketDisposition {
+	pk, ok := p.parsePacket(id, pkt)
+	if !ok {
+		return stack.UnknownDestinationPacketMalformed
+	}
+	if r := p.replyToOutOfTheBlue(pk); r != nil {
+		r.write()
+	}
+	return stack.UnknownDestinationPacketHandled
+}
+
+// SetOption implements stack.TransportProtocol.SetOption.
+func (*protocol) SetOption(tcpip.SettableTransportProtocolOption) *tcpip.Error {
+	return tcpip.ErrUnknownProtocolOption
+}
+
+// Option implements stack.TransportProtocol.Option.
+func (*protocol) Option(tcpip.GettableTransportProtocolOption) *tcpip.Error {
+	return tcpip.ErrUnknownProtocolOption
+}
+
+// Close implements stack.TransportProtocol.Close.
+func (*protocol) Close() {}
+
+// Wait implements stack.TransportProtocol.Wait.
+func (*protocol) Wait() {}
+
+// Parse implements stack.TransportProtocol.Parse.
+func (*protocol) Parse(pkt *stack.PacketBuffer) bool {
+	return parse.SCTP(pkt)
+}
+
+// NewProtocol returns an SCTP transport protocol.
+func NewProtocol(s *stack.Stack) stack.TransportProtocol {
+	p := &protocol{stack: s}
+	if _, err := rand.Read(p.secret[:]); err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// packet is an incoming SCTP packet.
+type packet struct {
+	id             stack.TransportEndpointID
+	hdr            header.SCTP
+	chunks         []header.SCTPChunk
+	netProto       tcpip.NetworkProtocolNumber
+	nicID          tcpip.NICID
+	remoteLinkAddr tcpip.LinkAddress
+}
+
+// parsePacket validates pkt and splits it in chunks. It returns false if pkt
+// is malformed or has a bad checksum.
+func (p *protocol) parsePacket(id stack.TransportEndpointID, pkt *stack.PacketBuffer) (*packet, bool) {
+	stats := p.stack.Stats().SCTP
+	stats.PacketsReceived.Increment()
+
+	// The checksum covers the whole packet, which is handled in one piece.
+	v := make(buffer.View, 0, header.SCTPMinimumSize+pkt.Data.Size())
+	v = append(v, pkt.TransportHeader().View()...)
+	v = append(v, pkt.Data.ToView()...)
+	hdr := header.SCTP(v)
+	if !pkt.RXTransportChecksumValidated && !hdr.IsChecksumValid() {
+		stats.ChecksumErrors.Increment()
+		return nil, false
+	}
+	chunks, ok := header.ParseSCTPChunks(hdr.Chunks())
+	if !ok || len(chunks) == 0 {
+		stats.InvalidPacketsReceived.Increment()
+		return nil, false
+	}
+	return &packet{
+		id:             id,
+		hdr:            hdr,
+		chunks:         chunks,
+		netProto:       pkt.NetworkProtocolNumber,
+		nicID:          pkt.NICID,
+		remoteLinkAddr: pkt.SourceLinkAddress(),
+	}, true
+}
+
+// hasChunk returns true if pk contains a chunk of type typ.
+func (pk *packet) hasChunk(typ header.SCTPChunkType) bool {
+	for _, c := range pk.chunks {
+		if c.Type() == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// replyRoute returns a route to the source of pk.
+func (p *protocol) replyRoute(pk *packet) (*stack.Route, *tcpip.Error) {
+	r, err := p.stack.FindRoute(pk.nicID, pk.id.LocalAddress, pk.id.RemoteAddress, pk.netProto, false /* multicastLoop */)
+	if err != nil {
+		return nil, err
+	}
+	r.ResolveWith(pk.remoteLinkAddr)
+	return r, nil
+}
+
+// reply returns a packet carrying chunks in response to pk, or nil if the
+// source of pk is unreachable.
+func (p *protocol) reply(pk *packet, tag uint32, chunks buffer.View) *outboundPacket {
+	r, err := p.replyRoute(pk)
+	if err != nil {
+		return nil
+	}
+	return &outboundPacket{
+		route:      r,
+		localPort:  pk.id.LocalPort,
+		remotePort: pk.id.RemotePort,
+		tag:        tag,
+		chunks:     chunks,
+	}
+}
+
+// replyToOutOfTheBlue returns the response to pk, which belongs to no
+// association, as per RFC 4960 section 8.4. It returns nil if pk must be
+// silently discarded.
+func (p *protocol) replyToOutOfTheBlue(pk *packet) *outboundPacket {
+	p.stack.Stats().SCTP.OutOfTheBlue.Increment()
+	if pk.hasChunk(header.SCTPChunkAbort) {
+		return nil
+	}
+	first := pk.chunks[0]
+	switch first.Type() {
+	case header.SCTPChunkInit:
+		// The ABORT is sent with the Initiate Tag of the INIT, and without
+		// the T bit.
+		if !first.IsValid() {
+			return nil
+		}
+		return p.reply(pk, header.SCTPInitChunk(first).InitiateTag(), abortChunk(0 /* flags */, 0 /* cause */))
+	case header.SCTPChunkShutdownAck:
+		b := make(buffer.View, header.SCTPChunkSize(0))
+		header.EncodeSCTPChunk(b, header.SCTPChunkShutdownComplete, header.SCTPFlagT, nil)
+		return p.reply(pk, pk.hdr.VerificationTag(), b)
+	case header.SCTPChunkShutdownComplete, header.SCTPChunkCookieAck, header.SCTPChunkError:
+		return nil
+	default:
+		return p.reply(pk, pk.hdr.VerificationTag(), abortChunk(header.SCTPFlagT, 0 /* cause */))
+	}
+}
+
+// abortChunk returns an ABORT chunk with the given flags, and with an error
+// cause unless cause is zero.
+func abortChunk(flags uint8, cause header.SCTPErrorCauseCode) buffer.View {
+	var causes []byte
+	if cause != 0 {
+		causes = make([]byte, header.SCTPParameterSize(0))
+		header.EncodeSCTPErrorCause(causes, cause, nil)
+	}
+	b := make(buffer.View, header.SCTPChunkSize(len(causes)))
+	header.EncodeSCTPChunk(b, header.SCTPChunkAbort, flags, causes)
+	return b
+}
+
+// outboundPacket is an SCTP packet to be written.
+type outboundPacket struct {
+	route      *stack.Route
+	localPort  uint16
+	remotePort uint16
+	tag        uint32
+	chunks     buffer.View
+	ttl        uint8
+	tos        uint8
+	owner      tcpip.PacketOwner
+}
+
+// write writes the packet and releases its route. If the link address of the
+// route needs to be resolved, the packet is written once it is. The packet is
+// dropped if the resolution fails, like the packets lost in the network.
+func (o *outboundPacket) write() {
+	if o.route.IsResolutionRequired() {
+		ch, err := o.route.Resolve(nil)
+		switch err {
+		case nil:
+		case tcpip.ErrWouldBlock:
+			go func() {
+				<-ch
+				if _, err := o.route.Resolve(nil); err != nil {
+					o.route.Release()
+					return
+				}
+				o.writeResolved()
+			}()
+			return
+		default:
+			o.route.Release()
+			return
+		}
+	}
+	o.writeResolved()
+}
+
+// writeResolved writes the packet on its resolved route and releases it.
+func (o *outboundPacket) writeResolved() {
+	defer o.route.Release()
+
+	v := make(buffer.View, header.SCTPMinimumSize+len(o.chunks))
+	h := header.SCTP(v)
+	h.Encode(&header.SCTPFields{
+		SrcPort:         o.localPort,
+		DstPort:         o.remotePort,
+		VerificationTag: o.tag,
+	})
+	copy(h.Chunks(), o.chunks)
+	h.SetChecksum(h.CalculateChecksum())
+
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		ReserveHeaderBytes: header.SCTPMinimumSize + int(o.route.MaxHeaderLength()),
+		Data:               buffer.View(h.Chunks()).ToVectorisedView(),
+	})
+	pkt.Owner = o.owner
+	copy(pkt.TransportHeader().Push(header.SCTPMinimumSize), v)
+	pkt.TransportProtocolNumber = ProtocolNumber
+
+	ttl := o.ttl
+	if ttl == 0 {
+		ttl = o.route.DefaultTTL()
+	}
+	if err := o.route.WritePacket(nil /* gso */, stack.NetworkHeaderParams{Protocol: ProtocolNumber, TTL: ttl, TOS: o.tos}, pkt); err != nil {
+		return
+	}
+	o.route.Stats().SCTP.PacketsSent.Increment()
+}
@@ -0,0 +1,372 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sctp
+
+import (
+	"sort"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	// sackDelay is how long the acknowledgement of received DATA chunks is
+	// delayed, as per RFC 4960 section 6.2.
+	sackDelay = 200 * time.Millisecond
+
+	// sackFrequency is the number of packets carrying DATA chunks after
+	// which a SACK chunk is sent without delay.
+	sackFrequency = 2
+
+	// maxGapAckBlocks and maxDuplicateTSNs bound the size of the SACK
+	// chunks sent.
+	maxGapAckBlocks  = 64
+	maxDuplicateTSNs = 16
+)
+
+// rcvChunk is a received DATA chunk which is not reassembled yet.
+type rcvChunk struct {
+	tsn    uint32
+	flags  uint8
+	stream uint16
+	ssn    uint16
+	ppid   uint32
+	data   buffer.View
+}
+
+// message is a reassembled message.
+type message struct {
+	data buffer.View
+	info tcpip.SCTPRcvInfo
+}
+
+// inStream is an inbound stream, delivering its ordered messages by stream
+// sequence number.
+type inStream struct {
+	nextSSN uint16
+	pending map[uint16]*message
+}
+
+// receiver is the receive side of an association.
+type receiver struct {
+	// inboundStreams is the number of streams the messages are received
+	// on.
+	inboundStreams uint16
+	streams        []inStream
+
+	// cumTSN is the last TSN received cumulatively. received is the set
+	// of the TSNs received after it.
+	cumTSN   uint32
+	received map[uint32]struct{}
+
+	// fragments are the received DATA chunks which are not reassembled
+	// yet, by TSN.
+	fragments map[uint32]*rcvChunk
+
+	// dupTSNs are the duplicate TSNs received since the last SACK chunk.
+	dupTSNs []uint32
+
+	// rcvQueue is the queue of messages to read, whose size is
+	// rcvQueued.
+	rcvQueue  []*message
+	rcvQueued int
+
+	// rcvBufUsed is the size of the user data received and not read,
+	// including the chunks which are not reassembled yet or delivered in
+	// order yet.
+	rcvBufUsed int
+
+	// rcvAdvertised is the receiver window advertised in the last SACK
+	// chunk.
+	rcvAdvertised uint32
+
+	// sackTimer delays the acknowledgement of the DATA chunks received in
+	// unackedPackets packets. sackPath is the path of the last one.
+	sackTimer      timer
+	unackedPackets int
+	sackPath       *path
+
+	// dataReceived is true if the packet being handled carries DATA
+	// chunks, and sackNow is true if it must be acknowledged without
+	// delay.
+	dataReceived bool
+	sackNow      bool
+}
+
+// rwndLocked returns the receiver window of the association.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) rwndLocked() uint32 {
+	if e.a.rcvBufUsed >= e.rcvBufSize {
+		return 0
+	}
+	return uint32(e.rcvBufSize - e.a.rcvBufUsed)
+}
+
+// handleDataLocked handles a DATA chunk, as per RFC 4960 section 6.2.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) handleDataLocked(pk *packet, d header.SCTPDataChunk) {
+	a := e.a
+	e.stack.Stats().SCTP.DataChunksReceived.Increment()
+	a.dataReceived = true
+	a.sackPath = a.pathTo(pk.id.RemoteAddress)
+
+	tsn := d.TSN()
+	if _, ok := a.received[tsn]; ok || !seqLess(a.cumTSN, tsn) {
+		if len(a.dupTSNs) < maxDuplicateTSNs {
+			a.dupTSNs = append(a.dupTSNs, tsn)
+		}
+		a.sackNow = true
+		return
+	}
+	if d.StreamID() >= a.inboundStreams {
+		// The chunk is acknowledged, and discarded, as per RFC 4960
+		// section 6.5.
+		v := make([]byte, header.SCTPParameterSize(4))
+		header.EncodeSCTPErrorCause(v, header.SCTPCauseInvalidStream, []byte{byte(d.StreamID() >> 8), byte(d.StreamID()), 0, 0})
+		b := make(buffer.View, header.SCTPChunkSize(len(v)))
+		header.EncodeSCTPChunk(b, header.SCTPChunkError, 0 /* flags */, v)
+		e.queueReplyLocked(pk, b)
+		e.receivedLocked(tsn)
+		a.sackNow = true
+		return
+	}
+	data := d.UserData()
+	if a.rcvBufUsed+len(data) > e.rcvBufSize {
+		// The chunk doesn't fit in the receive buffer and is dropped,
+		// unless it completes the chunks held for reassembly, which
+		// could otherwise never be delivered.
+		if tsn != a.cumTSN+1 || a.rcvBufUsed == a.rcvQueued {
+			a.sackNow = true
+			return
+		}
+	}
+	e.receivedLocked(tsn)
+	a.rcvBufUsed += len(data)
+	e.reassembleLocked(&rcvChunk{
+		tsn:    tsn,
+		flags:  header.SCTPChunk(d).Flags(),
+		stream: d.StreamID(),
+		ssn:    d.StreamSequence(),
+		ppid:   d.PayloadProtocol(),
+		data:   append(buffer.View(nil), data...),
+	})
+}
+
+// receivedLocked records the reception of the chunk whose TSN is tsn.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) receivedLocked(tsn uint32) {
+	a := e.a
+	a.received[tsn] = struct{}{}
+	for {
+		if _, ok := a.received[a.cumTSN+1]; !ok {
+			break
+		}
+		a.cumTSN++
+		delete(a.received, a.cumTSN)
+	}
+	if len(a.received) != 0 {
+		// Gaps are reported without delay, as per RFC 4960 section
+		// 6.7.
+		a.sackNow = true
+	}
+}
+
+// reassembleLocked adds c to the chunks held for reassembly, and delivers the
+// message it completes, if any.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) reassembleLocked(c *rcvChunk) {
+	a := e.a
+	a.fragments[c.tsn] = c
+
+	// Fragments of a message have consecutive TSNs, as per RFC 4960
+	// section 6.9.
+	sameMessage := func(f *rcvChunk) bool {
+		if f.stream != c.stream || f.flags&header.SCTPDataFlagUnordered != c.flags&header.SCTPDataFlagUnordered {
+			return false
+		}
+		return f.flags&header.SCTPDataFlagUnordered != 0 || f.ssn == c.ssn
+	}
+	first := c
+	for first.flags&header.SCTPDataFlagBeginning == 0 {
+		f, ok := a.fragments[first.tsn-1]
+		if !ok || !sameMessage(f) || f.flags&header.SCTPDataFlagEnd != 0 {
+			return
+		}
+		first = f
+	}
+	last := c
+	for last.flags&header.SCTPDataFlagEnd == 0 {
+		f, ok := a.fragments[last.tsn+1]
+		if !ok || !sameMessage(f) || f.flags&header.SCTPDataFlagBeginning != 0 {
+			return
+		}
+		last = f
+	}
+
+	var data buffer.View
+	if first == last {
+		data = first.data
+		delete(a.fragments, first.tsn)
+	} else {
+		for tsn := first.tsn; ; tsn++ {
+			data = append(data, a.fragments[tsn].data...)
+			delete(a.fragments, tsn)
+			if tsn == last.tsn {
+				break
+			}
+		}
+	}
+	m := &message{
+		data: data,
+		info: tcpip.SCTPRcvInfo{
+			Stream:    c.stream,
+			SSN:       c.ssn,
+			Unordered: c.flags&header.SCTPDataFlagUnordered != 0,
+			PPID:      first.ppid,
+			TSN:       last.tsn,
+			CumTSN:    a.cumTSN,
+		},
+	}
+	if m.info.Unordered {
+		e.deliverLocked(m)
+		return
+	}
+
+	st := &a.streams[c.stream]
+	switch {
+	case c.ssn == st.nextSSN:
+		e.deliverLocked(m)
+		st.nextSSN++
+		for {
+			m, ok := st.pending[st.nextSSN]
+			if !ok {
+				break
+			}
+			delete(st.pending, st.nextSSN)
+			e.deliverLocked(m)
+			st.nextSSN++
+		}
+	case int16(c.ssn-st.nextSSN) > 0:
+		if st.pending == nil {
+			st.pending = make(map[uint16]*message)
+		}
+		st.pending[c.ssn] = m
+	default:
+		// A message already delivered.
+		a.rcvBufUsed -= len(m.data)
+	}
+}
+
+// deliverLocked queues m to be read.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) deliverLocked(m *message) {
+	a := e.a
+	a.rcvQueue = append(a.rcvQueue, m)
+	a.rcvQueued += len(m.data)
+	e.events |= waiter.EventIn
+}
+
+// dataReceivedLocked acknowledges the DATA chunks of pk, without delay if
+// required, as per RFC 4960 section 6.2.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) dataReceivedLocked(pk *packet) {
+	a := e.a
+	a.unackedPackets++
+	if a.sackNow || a.unackedPackets >= sackFrequency || e.EndpointState() != StateEstablished {
+		e.sendSackLocked()
+	} else if !a.sackTimer.enabled() {
+		e.armTimerLocked(&a.sackTimer, sackDelay, e.sendSackLocked)
+	}
+	if e.EndpointState() == StateShutdownSent {
+		// DATA chunks received while shutting down are responded to
+		// with a SHUTDOWN chunk, as per RFC 4960 section 9.2.
+		e.sendShutdownLocked()
+	}
+}
+
+// sendSackLocked sends a SACK chunk acknowledging the DATA chunks received.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) sendSackLocked() {
+	a := e.a
+	e.stopTimerLocked(&a.sackTimer)
+	f := header.SCTPSackFields{
+		CumulativeTSNAck:         a.cumTSN,
+		AdvertisedReceiverWindow: e.rwndLocked(),
+		DuplicateTSNs:            a.dupTSNs,
+	}
+	if len(a.received) != 0 {
+		offsets := make([]uint32, 0, len(a.received))
+		for tsn := range a.received {
+			offsets = append(offsets, tsn-a.cumTSN)
+		}
+		sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+		for _, off := range offsets {
+			if off > 0xffff {
+				break
+			}
+			if n := len(f.GapAckBlocks); n != 0 && uint32(f.GapAckBlocks[n-1].End)+1 == off {
+				f.GapAckBlocks[n-1].End++
+				continue
+			}
+			if len(f.GapAckBlocks) == maxGapAckBlocks {
+				break
+			}
+			f.GapAckBlocks = append(f.GapAckBlocks, header.SCTPGapAckBlock{Start: uint16(off), End: uint16(off)})
+		}
+	}
+	b := make(buffer.View, header.SCTPSackChunkSize(f))
+	header.EncodeSCTPSackChunk(b, f)
+
+	p := a.sackPath
+	if p == nil || !p.active {
+		p = a.activePath()
+	}
+	e.queuePacketLocked(p, a.peerTag, b)
+	a.rcvAdvertised = f.AdvertisedReceiverWindow
+	a.dupTSNs = nil
+	a.unackedPackets = 0
+	a.sackNow = false
+}
+
+// windowUpdateLocked sends a SACK chunk advertising the receiver window once
+// it grew significantly since it was last advertised.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) windowUpdateLocked() {
+	switch e.EndpointState() {
+	case StateEstablished, StateShutdownPending, StateShutdownSent:
+	default:
+		return
+	}
+	a := e.a
+	rwnd := e.rwndLocked()
+	threshold := uint32(e.rcvBufSize / 2)
+	if mtu := uint32(a.activePath().mtu()); mtu < threshold {
+		threshold = mtu
+	}
+	if rwnd > a.rcvAdvertised && rwnd-a.rcvAdvertised >= threshold {
+		e.sendSackLocked()
+	}
+}
@@ -0,0 +1,494 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sctp_test
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/link/pipe"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/sctp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const port = 8080
+
+var (
+	clientAddrs = []tcpip.AddressWithPrefix{
+		{Address: "\x0a\x00\x01\x01", PrefixLen: 24},
+		{Address: "\x0a\x00\x02\x01", PrefixLen: 24},
+	}
+	serverAddrs = []tcpip.AddressWithPrefix{
+		{Address: "\x0a\x00\x01\x02", PrefixLen: 24},
+		{Address: "\x0a\x00\x02\x02", PrefixLen: 24},
+	}
+)
+
+// lossyEndpoint is a pipe endpoint which drops the packets written while down
+// is set.
+type lossyEndpoint struct {
+	*pipe.Endpoint
+	down int32
+}
+
+// WritePacket implements stack.LinkEndpoint.WritePacket.
+func (e *lossyEndpoint) WritePacket(r *stack.Route, gso *stack.GSO, proto tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) *tcpip.Error {
+	if atomic.LoadInt32(&e.down) != 0 {
+		return nil
+	}
+	return e.Endpoint.WritePacket(r, gso, proto, pkt)
+}
+
+func (e *lossyEndpoint) setDown(down bool) {
+	var v int32
+	if down {
+		v = 1
+	}
+	atomic.StoreInt32(&e.down, v)
+}
+
+type testContext struct {
+	t      *testing.T
+	clock  *faketime.ManualClock
+	client *stack.Stack
+	server *stack.Stack
+
+	// clientLinks and serverLinks are the links of the client and the
+	// server, on each subnet.
+	clientLinks []*lossyEndpoint
+	serverLinks []*lossyEndpoint
+}
+
+func newStack(t *testing.T, clock tcpip.Clock, addrs []tcpip.AddressWithPrefix, links []*lossyEndpoint) *stack.Stack {
+	t.Helper()
+
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{sctp.NewProtocol},
+		Clock:              clock,
+	})
+	for i, ep := range links {
+		nicID := tcpip.NICID(i + 1)
+		if err := s.CreateNIC(nicID, ep); err != nil {
+			t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+		}
+		protocolAddr := tcpip.ProtocolAddress{
+			Protocol:          ipv4.ProtocolNumber,
+			AddressWithPrefix: addrs[i],
+		}
+		if err := s.AddProtocolAddress(nicID, protocolAddr); err != nil {
+			t.Fatalf("AddProtocolAddress(%d, %+v): %s", nicID, protocolAddr, err)
+		}
+		s.AddRoute(tcpip.Route{Destination: addrs[i].Subnet(), NIC: nicID})
+	}
+	return s
+}
+
+// newTestContext returns a client and a server stack connected by two links
+// on different subnets.
+func newTestContext(t *testing.T) *testContext {
+	t.Helper()
+
+	c1, s1 := pipe.New("\x00\x00\x00\x00\x01\x01", "\x00\x00\x00\x00\x01\x02")
+	c2, s2 := pipe.New("\x00\x00\x00\x00\x02\x01", "\x00\x00\x00\x00\x02\x02")
+	c := &testContext{
+		t:           t,
+		clock:       faketime.NewManualClock(),
+		clientLinks: []*lossyEndpoint{{Endpoint: c1}, {Endpoint: c2}},
+		serverLinks: []*lossyEndpoint{{Endpoint: s1}, {Endpoint: s2}},
+	}
+	c.client = newStack(t, c.clock, clientAddrs, c.clientLinks)
+	c.server = newStack(t, c.clock, serverAddrs, c.serverLinks)
+	return c
+}
+
+// setLinkDown sets whether the link on the subnet i drops packets.
+func (c *testContext) setLinkDown(i int, down bool) {
+	c.clientLinks[i].setDown(down)
+	c.serverLinks[i].setDown(down)
+}
+
+// listen returns a server endpoint listening on port.
+func (c *testContext) listen() tcpip.Endpoint {
+	c.t.Helper()
+
+	var wq waiter.Queue
+	ep, err := c.server.NewEndpoint(sctp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+	if err != nil {
+		c.t.Fatalf("NewEndpoint(%d, %d, _): %s", sctp.ProtocolNumber, ipv4.ProtocolNumber, err)
+	}
+	c.t.Cleanup(ep.Close)
+	// Nagle's algorithm is disabled since the clock only advances when
+	// told to, which delays the acknowledgements.
+	ep.SocketOptions().SetDelayOption(false)
+	if err := ep.Bind(tcpip.FullAddress{Port: port}); err != nil {
+		c.t.Fatalf("Bind: %s", err)
+	}
+	if err := ep.Listen(10); err != nil {
+		c.t.Fatalf("Listen: %s", err)
+	}
+	return ep
+}
+
+// newClient returns a new client endpoint.
+func (c *testContext) newClient() (tcpip.Endpoint, *waiter.Queue) {
+	c.t.Helper()
+
+	var wq waiter.Queue
+	ep, err := c.client.NewEndpoint(sctp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+	if err != nil {
+		c.t.Fatalf("NewEndpoint(%d, %d, _): %s", sctp.ProtocolNumber, ipv4.ProtocolNumber, err)
+	}
+	c.t.Cleanup(ep.Close)
+	ep.SocketOptions().SetDelayOption(false)
+	return ep, &wq
+}
+
+// connect returns a client endpoint and the server endpoint of its
+// association, accepted by the listening endpoint lep.
+func (c *testContext) connect(lep tcpip.Endpoint) (tcpip.Endpoint, tcpip.Endpoint) {
+	c.t.Helper()
+
+	cep, _ := c.newClient()
+	addr := tcpip.FullAddress{Addr: serverAddrs[0].Address, Port: port}
+	if err := cep.Connect(addr); err != tcpip.ErrConnectStarted {
+		c.t.Fatalf("got Connect(%+v) = %v, want = %s", addr, err, tcpip.ErrConnectStarted)
+	}
+	waitFor(c.t, "the association to be set up", func() bool {
+		return cep.Readiness(waiter.EventOut) != 0
+	})
+	if err := cep.Connect(addr); err != nil {
+		c.t.Fatalf("Connect(%+v): %s", addr, err)
+	}
+	if err := cep.Connect(addr); err != tcpip.ErrAlreadyConnected {
+		c.t.Fatalf("got Connect(%+v) = %v, want = %s", addr, err, tcpip.ErrAlreadyConnected)
+	}
+	var (
+		peerAddr tcpip.FullAddress
+		sep      tcpip.Endpoint
+		err      *tcpip.Error
+	)
+	waitFor(c.t, "the association to be accepted", func() bool {
+		sep, _, err = lep.Accept(&peerAddr)
+		return err != tcpip.ErrWouldBlock
+	})
+	if err != nil {
+		c.t.Fatalf("Accept: %s", err)
+	}
+	c.t.Cleanup(sep.Close)
+	if peerAddr.Addr != clientAddrs[0].Address {
+		c.t.Errorf("got Accept peer address = %s, want = %s", peerAddr.Addr, clientAddrs[0].Address)
+	}
+	return cep, sep
+}
+
+// waitFor waits until cond returns true, as packets are handled
+// asynchronously.
+func waitFor(t *testing.T, desc string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", desc)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func write(t *testing.T, ep tcpip.Endpoint, data []byte, opts tcpip.WriteOptions) {
+	t.Helper()
+
+	n, _, err := ep.Write(tcpip.SlicePayload(data), opts)
+	if err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if n != int64(len(data)) {
+		t.Fatalf("got Write(...) = %d, want = %d", n, len(data))
+	}
+}
+
+func read(t *testing.T, ep tcpip.Endpoint, want []byte) tcpip.ControlMessages {
+	t.Helper()
+
+	var (
+		v   []byte
+		cm  tcpip.ControlMessages
+		err *tcpip.Error
+	)
+	waitFor(t, "a message", func() bool {
+		v, cm, err = ep.Read(nil)
+		return err != tcpip.ErrWouldBlock
+	})
+	if err != nil {
+		t.Fatalf("Read: %s", err)
+	}
+	if !bytes.Equal(v, want) {
+		t.Fatalf("got Read(nil) = %q, want = %q", v, want)
+	}
+	return cm
+}
+
+// readError returns the error of the first read which doesn't block.
+func readError(t *testing.T, ep tcpip.Endpoint) *tcpip.Error {
+	t.Helper()
+
+	var err *tcpip.Error
+	waitFor(t, "a read not to block", func() bool {
+		_, _, err = ep.Read(nil)
+		return err != tcpip.ErrWouldBlock
+	})
+	return err
+}
+
+// waitForState waits until ep is in the state want.
+func waitForState(t *testing.T, ep tcpip.Endpoint, want sctp.EndpointState) {
+	t.Helper()
+
+	waitFor(t, fmt.Sprintf("state %s", want), func() bool {
+		return sctp.EndpointState(ep.State()) == want
+	})
+}
+
+func TestAssociation(t *testing.T) {
+	c := newTestContext(t)
+	cep, sep := c.connect(c.listen())
+	if got, want := c.client.Stats().SCTP.ActiveEstablishments.Value(), uint64(1); got != want {
+		t.Errorf("got ActiveEstablishments = %d, want = %d", got, want)
+	}
+	if got, want := c.server.Stats().SCTP.PassiveEstablishments.Value(), uint64(1); got != want {
+		t.Errorf("got PassiveEstablishments = %d, want = %d", got, want)
+	}
+
+	// Messages are delivered whole, in both directions.
+	write(t, cep, []byte("hello"), tcpip.WriteOptions{})
+	write(t, cep, []byte("world"), tcpip.WriteOptions{})
+	read(t, sep, []byte("hello"))
+	read(t, sep, []byte("world"))
+	if _, _, err := sep.Read(nil); err != tcpip.ErrWouldBlock {
+		t.Fatalf("got Read(nil) = %v, want = %s", err, tcpip.ErrWouldBlock)
+	}
+	write(t, sep, []byte("reply"), tcpip.WriteOptions{})
+	read(t, cep, []byte("reply"))
+
+	// Messages larger than a packet are fragmented and reassembled.
+	large := bytes.Repeat([]byte("0123456789"), 1000)
+	write(t, cep, large, tcpip.WriteOptions{})
+	read(t, sep, large)
+
+	// Shutting down the client completes once its data is acknowledged.
+	if err := cep.Shutdown(tcpip.ShutdownWrite); err != nil {
+		t.Fatalf("Shutdown(ShutdownWrite): %s", err)
+	}
+	if err := readError(t, sep); err != tcpip.ErrClosedForReceive {
+		t.Fatalf("got Read(nil) = %v, want = %s", err, tcpip.ErrClosedForReceive)
+	}
+	waitForState(t, cep, sctp.StateClosed)
+	waitForState(t, sep, sctp.StateClosed)
+	if got := c.server.Stats().SCTP.CurrentEstablished.Value(); got != 0 {
+		t.Errorf("got server CurrentEstablished = %d, want = 0", got)
+	}
+}
+
+func TestStreams(t *testing.T) {
+	c := newTestContext(t)
+	cep, sep := c.connect(c.listen())
+	if err := sep.SetSockOptInt(tcpip.SCTPRecvRcvInfoOption, 1); err != nil {
+		t.Fatalf("SetSockOptInt(SCTPRecvRcvInfoOption, 1): %s", err)
+	}
+
+	var status tcpip.SCTPStatusOption
+	if err := cep.GetSockOpt(&status); err != nil {
+		t.Fatalf("GetSockOpt(&%T): %s", status, err)
+	}
+	if status.OutboundStreams != 10 || status.InboundStreams != 10 {
+		t.Errorf("got streams (outbound, inbound) = (%d, %d), want = (10, 10)", status.OutboundStreams, status.InboundStreams)
+	}
+
+	for _, info := range []tcpip.SCTPSndInfo{
+		{Stream: 3, PPID: 51},
+		{Stream: 3, PPID: 52},
+		{Stream: 9, Unordered: true, PPID: 53},
+	} {
+		info := info
+		write(t, cep, []byte("message"), tcpip.WriteOptions{SCTPSndInfo: &info})
+		cm := read(t, sep, []byte("message"))
+		if !cm.HasSCTPRcvInfo {
+			t.Fatalf("got HasSCTPRcvInfo = false")
+		}
+		got := cm.SCTPRcvInfo
+		if got.Stream != info.Stream || got.Unordered != info.Unordered || got.PPID != info.PPID {
+			t.Errorf("got SCTPRcvInfo = %+v, want stream %d, unordered %t and PPID %d", got, info.Stream, info.Unordered, info.PPID)
+		}
+	}
+
+	// Messages can't be sent on the streams not negotiated.
+	info := tcpip.SCTPSndInfo{Stream: 10}
+	if _, _, err := cep.Write(tcpip.SlicePayload("message"), tcpip.WriteOptions{SCTPSndInfo: &info}); err != tcpip.ErrInvalidOptionValue {
+		t.Fatalf("got Write(...) = %v, want = %s", err, tcpip.ErrInvalidOptionValue)
+	}
+}
+
+func TestRetransmission(t *testing.T) {
+	c := newTestContext(t)
+	cep, sep := c.connect(c.listen())
+
+	c.setLinkDown(0, true)
+	c.setLinkDown(1, true)
+	write(t, cep, []byte("lost"), tcpip.WriteOptions{})
+	if _, _, err := sep.Read(nil); err != tcpip.ErrWouldBlock {
+		t.Fatalf("got Read(nil) = %v, want = %s", err, tcpip.ErrWouldBlock)
+	}
+
+	// The message is retransmitted once the retransmission timer expires.
+	c.setLinkDown(0, false)
+	c.setLinkDown(1, false)
+	c.clock.Advance(3 * time.Second)
+	read(t, sep, []byte("lost"))
+	if got := c.client.Stats().SCTP.Retransmissions.Value(); got == 0 {
+		t.Errorf("got Retransmissions = 0, want > 0")
+	}
+}
+
+func TestMultiHoming(t *testing.T) {
+	c := newTestContext(t)
+	cep, sep := c.connect(c.listen())
+
+	// The addresses listed by the peer are confirmed by heartbeats.
+	c.clock.Advance(3 * time.Second)
+	var status tcpip.SCTPStatusOption
+	waitFor(t, "the paths to be confirmed", func() bool {
+		if err := cep.GetSockOpt(&status); err != nil {
+			t.Fatalf("GetSockOpt(&%T): %s", status, err)
+		}
+		for _, p := range status.Paths {
+			if !p.Active || !p.Confirmed {
+				return false
+			}
+		}
+		return true
+	})
+	if len(status.Paths) != 2 {
+		t.Fatalf("got %d paths, want = 2", len(status.Paths))
+	}
+
+	// The messages lost on the primary path are retransmitted on the other
+	// one.
+	c.setLinkDown(0, true)
+	write(t, cep, []byte("failover"), tcpip.WriteOptions{})
+	c.clock.Advance(3 * time.Second)
+	read(t, sep, []byte("failover"))
+
+	// The primary path is considered inactive once it's unreachable.
+	for i := 0; i < 10; i++ {
+		write(t, cep, []byte("failover"), tcpip.WriteOptions{})
+		c.clock.Advance(time.Minute)
+		read(t, sep, []byte("failover"))
+	}
+	if got := c.client.Stats().SCTP.PathFailovers.Value(); got == 0 {
+		t.Errorf("got PathFailovers = 0, want > 0")
+	}
+	waitFor(t, "the primary path to change", func() bool {
+		if err := cep.GetSockOpt(&status); err != nil {
+			t.Fatalf("GetSockOpt(&%T): %s", status, err)
+		}
+		return status.Primary.Address.Addr == serverAddrs[1].Address
+	})
+}
+
+func TestAbort(t *testing.T) {
+	c := newTestContext(t)
+	cep, sep := c.connect(c.listen())
+
+	// Closing an endpoint with unread data aborts the association.
+	write(t, cep, []byte("unread"), tcpip.WriteOptions{})
+	waitFor(t, "the message", func() bool {
+		return sep.Readiness(waiter.EventIn) != 0
+	})
+	sep.Close()
+	if err := readError(t, cep); err != tcpip.ErrConnectionReset {
+		t.Fatalf("got Read(nil) = %v, want = %s", err, tcpip.ErrConnectionReset)
+	}
+	if got := c.server.Stats().SCTP.Aborts.Value(); got != 1 {
+		t.Errorf("got Aborts = %d, want = 1", got)
+	}
+}
+
+func TestConnectionRefused(t *testing.T) {
+	c := newTestContext(t)
+	cep, _ := c.newClient()
+
+	// The INIT chunk is responded to with an ABORT chunk by the stack.
+	addr := tcpip.FullAddress{Addr: serverAddrs[0].Address, Port: port}
+	if err := cep.Connect(addr); err != tcpip.ErrConnectStarted {
+		t.Fatalf("got Connect(%+v) = %v, want = %s", addr, err, tcpip.ErrConnectStarted)
+	}
+	var err *tcpip.Error
+	waitFor(t, "the association setup to fail", func() bool {
+		err = cep.Connect(addr)
+		return err != tcpip.ErrAlreadyConnecting
+	})
+	if err != tcpip.ErrConnectionRefused {
+		t.Fatalf("got Connect(%+v) = %v, want = %s", addr, err, tcpip.ErrConnectionRefused)
+	}
+	if got := c.server.Stats().SCTP.OutOfTheBlue.Value(); got != 1 {
+		t.Errorf("got OutOfTheBlue = %d, want = 1", got)
+	}
+}
+
+func TestSockOpts(t *testing.T) {
+	c := newTestContext(t)
+	ep, _ := c.newClient()
+
+	rto := tcpip.SCTPRTOInfoOption{Initial: time.Second, Min: 2 * time.Second}
+	if err := ep.SetSockOpt(&rto); err != tcpip.ErrInvalidOptionValue {
+		t.Errorf("got SetSockOpt(&%+v) = %v, want = %s", rto, err, tcpip.ErrInvalidOptionValue)
+	}
+	rto = tcpip.SCTPRTOInfoOption{Initial: time.Second, Min: 500 * time.Millisecond, Max: 10 * time.Second}
+	if err := ep.SetSockOpt(&rto); err != nil {
+		t.Fatalf("SetSockOpt(&%+v): %s", rto, err)
+	}
+	var got tcpip.SCTPRTOInfoOption
+	if err := ep.GetSockOpt(&got); err != nil {
+		t.Fatalf("GetSockOpt(&%T): %s", got, err)
+	}
+	if got != rto {
+		t.Errorf("got SCTPRTOInfoOption = %+v, want = %+v", got, rto)
+	}
+
+	// Unset fields keep their value.
+	if err := ep.SetSockOpt(&tcpip.SCTPInitMsgOption{OutboundStreams: 5}); err != nil {
+		t.Fatalf("SetSockOpt(&SCTPInitMsgOption{OutboundStreams: 5}): %s", err)
+	}
+	var initMsg tcpip.SCTPInitMsgOption
+	if err := ep.GetSockOpt(&initMsg); err != nil {
+		t.Fatalf("GetSockOpt(&%T): %s", initMsg, err)
+	}
+	if initMsg.OutboundStreams != 5 || initMsg.MaxInboundStreams == 0 || initMsg.MaxAttempts == 0 {
+		t.Errorf("got SCTPInitMsgOption = %+v, want OutboundStreams = 5 and the other fields set", initMsg)
+	}
+
+	var status tcpip.SCTPStatusOption
+	if err := ep.GetSockOpt(&status); err != tcpip.ErrNotConnected {
+		t.Errorf("got GetSockOpt(&%T) = %v, want = %s", status, err, tcpip.ErrNotConnected)
+	}
+}
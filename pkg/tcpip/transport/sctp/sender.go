@@ -0,0 +1,511 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sctp
+
+import (
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// fastRetransmitThreshold is the number of SACK chunks reporting a DATA chunk
+// as missing after which it is retransmitted, as per RFC 4960 section 7.2.4.
+const fastRetransmitThreshold = 3
+
+// dataChunk is a DATA chunk sent or queued to be sent on an association.
+type dataChunk struct {
+	tsn    uint32
+	flags  uint8
+	stream uint16
+	ssn    uint16
+	ppid   uint32
+	data   buffer.View
+
+	// path is the path the chunk was last sent on.
+	path *path
+
+	// transmissions is the number of times the chunk was sent.
+	transmissions int
+
+	// inFlight is true while the chunk is sent and neither acknowledged
+	// nor considered lost.
+	inFlight bool
+
+	// gapAcked is true if the chunk was acknowledged by a gap ack block,
+	// but not cumulatively.
+	gapAcked bool
+
+	// retransmit is true if the chunk is considered lost and must be
+	// retransmitted.
+	retransmit bool
+
+	// missing is the number of SACK chunks reporting the chunk as missing.
+	// fastRetransmitted is true once the chunk was fast retransmitted,
+	// which only happens once.
+	missing           int
+	fastRetransmitted bool
+}
+
+// sender is the send side of an association.
+type sender struct {
+	// outboundStreams is the number of streams the messages are sent on,
+	// and ssn the next stream sequence number of each stream.
+	outboundStreams uint16
+	ssn             []uint16
+
+	// nextTSN is the TSN of the next DATA chunk queued. cumAcked is the
+	// last TSN acknowledged cumulatively by the peer.
+	nextTSN  uint32
+	cumAcked uint32
+
+	// peerRwnd is the receiver window of the peer, as per RFC 4960
+	// section 6.2.1.
+	peerRwnd uint32
+
+	// chunks are the DATA chunks which are not acknowledged cumulatively,
+	// in TSN order. The last unsent ones were not sent yet.
+	chunks []*dataChunk
+	unsent int
+
+	// sndBufUsed is the size of the user data of chunks.
+	sndBufUsed int
+
+	// flight is the size of the user data of the chunks in flight.
+	flight int
+
+	// fastRecovery is true until the TSN fastRecoveryExit is acknowledged
+	// once a chunk is fast retransmitted, as per RFC 4960 section 7.2.4.
+	fastRecovery     bool
+	fastRecoveryExit uint32
+}
+
+// seqLess returns true if TSN a precedes TSN b, using serial number
+// arithmetic as per RFC 4960 section 1.6.
+func seqLess(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// fragmentSizeLocked returns the largest size of the user data of a DATA
+// chunk: the largest that fits in a packet on every path, unless a smaller
+// size was set with the SCTP_MAXSEG option.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) fragmentSizeLocked() int {
+	size := 0
+	for _, p := range e.a.paths {
+		if s := p.mtu() - header.SCTPMinimumSize - header.SCTPDataChunkHeaderLength; size == 0 || s < size {
+			size = s
+		}
+	}
+	if e.maxSeg != 0 && e.maxSeg < size {
+		size = e.maxSeg
+	}
+	// Keep the user data of each fragment aligned.
+	return size &^ 3
+}
+
+// queueMessageLocked splits a message in DATA chunks, which are queued to be
+// sent.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) queueMessageLocked(v buffer.View, info tcpip.SCTPSndInfo) {
+	a := e.a
+	var ssn uint16
+	flags := uint8(header.SCTPDataFlagBeginning)
+	if info.Unordered {
+		flags |= header.SCTPDataFlagUnordered
+	} else {
+		ssn = a.ssn[info.Stream]
+		a.ssn[info.Stream]++
+	}
+	size := e.fragmentSizeLocked()
+	for len(v) != 0 {
+		n := len(v)
+		if n > size {
+			n = size
+		}
+		c := &dataChunk{
+			tsn:    a.nextTSN,
+			flags:  flags,
+			stream: info.Stream,
+			ssn:    ssn,
+			ppid:   info.PPID,
+			data:   v[:n],
+		}
+		v = v[n:]
+		if len(v) == 0 {
+			c.flags |= header.SCTPDataFlagEnd
+		}
+		flags &^= header.SCTPDataFlagBeginning
+		a.nextTSN++
+		a.chunks = append(a.chunks, c)
+		a.unsent++
+		a.sndBufUsed += n
+	}
+}
+
+// transmitLocked sends the DATA chunks to retransmit, then the unsent ones, as
+// allowed by the congestion window of their path and the receiver window of
+// the peer.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) transmitLocked() {
+	switch e.EndpointState() {
+	case StateEstablished, StateShutdownPending, StateShutdownReceived:
+	default:
+		return
+	}
+	a := e.a
+
+	// Retransmissions are sent on an alternate path, as per RFC 4960
+	// section 6.4.1.
+	var rtx []*dataChunk
+	for _, c := range a.chunks[:len(a.chunks)-a.unsent] {
+		if c.retransmit {
+			rtx = append(rtx, c)
+		}
+	}
+	if len(rtx) != 0 {
+		p := a.alternatePath(rtx[0].path)
+		if n := e.sendChunksLocked(p, rtx, false /* new */); n < len(rtx) {
+			// The congestion window is full.
+			return
+		}
+	}
+
+	if a.unsent == 0 {
+		return
+	}
+	unsent := a.chunks[len(a.chunks)-a.unsent:]
+	if e.ops.GetDelayOption() && a.flight != 0 {
+		// Nagle's algorithm: hold small messages while data is in
+		// flight, unless a full chunk can be sent.
+		size := 0
+		for _, c := range unsent {
+			size += len(c.data)
+		}
+		if size < e.fragmentSizeLocked() {
+			return
+		}
+	}
+	a.unsent -= e.sendChunksLocked(a.activePath(), unsent, true /* new */)
+}
+
+// sendChunksLocked sends chunks on p, bundled in as few packets as possible,
+// until the congestion window of p is full or, for new chunks, the receiver
+// window of the peer is. It returns the number of chunks sent.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) sendChunksLocked(p *path, chunks []*dataChunk, new bool) int {
+	a := e.a
+	stats := e.stack.Stats().SCTP
+	maxSize := p.mtu() - header.SCTPMinimumSize
+	now := e.stack.Clock().NowMonotonic()
+	sent := 0
+	for sent < len(chunks) && p.flightSize < p.cwnd {
+		var b buffer.View
+		for sent < len(chunks) {
+			c := chunks[sent]
+			size := header.SCTPDataChunkSize(len(c.data))
+			if len(b) != 0 && len(b)+size > maxSize {
+				break
+			}
+			// New data is only sent within the receiver window of the
+			// peer, or one chunk at a time to probe a zero window, as
+			// per RFC 4960 section 6.1.
+			if new && uint32(len(c.data)) > a.peerRwnd && a.flight != 0 {
+				break
+			}
+			off := len(b)
+			b = append(b, make(buffer.View, size)...)
+			header.EncodeSCTPDataChunk(b[off:], header.SCTPDataFields{
+				Flags:           c.flags,
+				TSN:             c.tsn,
+				StreamID:        c.stream,
+				StreamSequence:  c.ssn,
+				PayloadProtocol: c.ppid,
+			}, c.data)
+
+			c.path = p
+			c.transmissions++
+			c.retransmit = false
+			c.inFlight = true
+			p.flightSize += len(c.data)
+			a.flight += len(c.data)
+			if uint32(len(c.data)) < a.peerRwnd {
+				a.peerRwnd -= uint32(len(c.data))
+			} else {
+				a.peerRwnd = 0
+			}
+			stats.DataChunksSent.Increment()
+			switch {
+			case c.transmissions > 1:
+				stats.Retransmissions.Increment()
+				// Karn's algorithm: retransmitted chunks are not
+				// used to measure the round-trip time.
+				if p.rttPending && p.rttTSN == c.tsn {
+					p.rttPending = false
+				}
+			case !p.rttPending:
+				p.rttPending = true
+				p.rttTSN = c.tsn
+				p.rttSentAt = now
+			}
+			sent++
+		}
+		if len(b) == 0 {
+			break
+		}
+		e.queuePacketLocked(p, a.peerTag, b)
+		if !p.t3.enabled() {
+			e.armT3Locked(p)
+		}
+	}
+	return sent
+}
+
+// armT3Locked arms the T3-rtx timer of p, as per RFC 4960 section 6.3.2.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) armT3Locked(p *path) {
+	e.armTimerLocked(&p.t3, p.rto, func() {
+		e.t3ExpiredLocked(p)
+	})
+}
+
+// t3ExpiredLocked handles the expiration of the T3-rtx timer of p: the chunks
+// in flight on p are considered lost, as per RFC 4960 section 6.3.3.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) t3ExpiredLocked(p *path) {
+	a := e.a
+	p.backoff(e.rtoInfo)
+	mtu := p.mtu()
+	p.ssthresh = p.cwnd / 2
+	if p.ssthresh < 4*mtu {
+		p.ssthresh = 4 * mtu
+	}
+	p.cwnd = mtu
+	p.pba = 0
+	p.rttPending = false
+	for _, c := range a.chunks[:len(a.chunks)-a.unsent] {
+		if c.inFlight && c.path == p {
+			a.removeFromFlight(c)
+			c.retransmit = true
+		}
+	}
+	if e.pathErrorLocked(p) {
+		return
+	}
+	e.transmitLocked()
+}
+
+// removeFromFlight removes c from the data in flight.
+func (a *association) removeFromFlight(c *dataChunk) {
+	if !c.inFlight {
+		return
+	}
+	c.inFlight = false
+	c.path.flightSize -= len(c.data)
+	a.flight -= len(c.data)
+}
+
+// handleSackLocked handles a SACK chunk, as per RFC 4960 section 6.2.1.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) handleSackLocked(s header.SCTPSackChunk) {
+	e.ackLocked(s.CumulativeTSNAck(), s.GapAckBlocks(), s.AdvertisedReceiverWindow())
+	e.maybeShutdownLocked()
+}
+
+// ackLocked handles the acknowledgement of the DATA chunks up to cum, and of
+// the ones in the gap ack blocks gaps, by a peer whose receiver window is
+// arwnd.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) ackLocked(cum uint32, gaps []header.SCTPGapAckBlock, arwnd uint32) {
+	a := e.a
+	if seqLess(cum, a.cumAcked) || !seqLess(cum, a.nextTSN-uint32(a.unsent)) {
+		// An old SACK chunk, or the acknowledgement of chunks never
+		// sent.
+		return
+	}
+
+	// flightBefore is the data in flight on each path before the chunks
+	// are acknowledged, to determine whether the congestion window was
+	// fully used.
+	flightBefore := make(map[*path]int, len(a.paths))
+	for _, p := range a.paths {
+		flightBefore[p] = p.flightSize
+	}
+	acked := make(map[*path]int)
+	now := e.stack.Clock().NowMonotonic()
+
+	// Remove the chunks acknowledged cumulatively.
+	n := 0
+	for ; n < len(a.chunks)-a.unsent && !seqLess(cum, a.chunks[n].tsn); n++ {
+		c := a.chunks[n]
+		p := c.path
+		a.removeFromFlight(c)
+		if !c.gapAcked {
+			acked[p] += len(c.data)
+		}
+		if p.rttPending && p.rttTSN == c.tsn {
+			p.rttPending = false
+			p.updateRTO(time.Duration(now-p.rttSentAt), e.rtoInfo)
+		}
+		p.errorCount = 0
+		a.sndBufUsed -= len(c.data)
+		a.chunks[n] = nil
+	}
+	if n != 0 {
+		a.chunks = a.chunks[n:]
+		a.errorCount = 0
+		e.events |= waiter.EventOut
+	}
+	cumAdvanced := seqLess(a.cumAcked, cum)
+	a.cumAcked = cum
+
+	// Mark the chunks acknowledged by the gap ack blocks, and count the
+	// missing reports of the others, as per RFC 4960 section 7.2.4.
+	highest := cum
+	if len(gaps) != 0 {
+		highest = cum + uint32(gaps[len(gaps)-1].End)
+	}
+	var highestNewlyAcked uint32
+	newlyAcked := false
+	sent := a.chunks[:len(a.chunks)-a.unsent]
+	for _, c := range sent {
+		off := c.tsn - cum
+		inGap := false
+		for _, g := range gaps {
+			if off >= uint32(g.Start) && off <= uint32(g.End) {
+				inGap = true
+				break
+			}
+		}
+		switch {
+		case inGap && !c.gapAcked:
+			c.gapAcked = true
+			c.retransmit = false
+			a.removeFromFlight(c)
+			highestNewlyAcked = c.tsn
+			newlyAcked = true
+		case !inGap && c.gapAcked:
+			// The peer reneged on the chunk, as per RFC 4960
+			// section 6.2.
+			c.gapAcked = false
+		}
+	}
+	var fastRetransmit []*dataChunk
+	for _, c := range sent {
+		if !seqLess(c.tsn, highest) {
+			break
+		}
+		if c.gapAcked || c.retransmit || c.fastRetransmitted {
+			continue
+		}
+		if a.fastRecovery && (!newlyAcked || !seqLess(c.tsn, highestNewlyAcked)) {
+			continue
+		}
+		c.missing++
+		if c.missing >= fastRetransmitThreshold {
+			fastRetransmit = append(fastRetransmit, c)
+		}
+	}
+	if len(fastRetransmit) != 0 && !a.fastRecovery {
+		// Enter fast recovery, adjusting the congestion window of the
+		// paths of the lost chunks once.
+		adjusted := make(map[*path]bool)
+		for _, c := range fastRetransmit {
+			p := c.path
+			if adjusted[p] {
+				continue
+			}
+			adjusted[p] = true
+			p.ssthresh = p.cwnd / 2
+			if mtu := p.mtu(); p.ssthresh < 4*mtu {
+				p.ssthresh = 4 * mtu
+			}
+			p.cwnd = p.ssthresh
+			p.pba = 0
+		}
+		a.fastRecovery = true
+		a.fastRecoveryExit = a.nextTSN - uint32(a.unsent) - 1
+	}
+	for _, c := range fastRetransmit {
+		if c.path.rttPending && c.path.rttTSN == c.tsn {
+			c.path.rttPending = false
+		}
+		a.removeFromFlight(c)
+		c.retransmit = true
+		c.fastRetransmitted = true
+		e.stack.Stats().SCTP.FastRetransmissions.Increment()
+	}
+	if a.fastRecovery && !seqLess(cum, a.fastRecoveryExit) {
+		a.fastRecovery = false
+	}
+
+	// Grow the congestion windows, as per RFC 4960 sections 7.2.1 and
+	// 7.2.2.
+	if cumAdvanced {
+		for p, n := range acked {
+			p.onAcked(n, flightBefore[p], a.fastRecovery)
+		}
+	}
+
+	if arwnd > uint32(a.flight) {
+		a.peerRwnd = arwnd - uint32(a.flight)
+	} else {
+		a.peerRwnd = 0
+	}
+
+	// Restart the T3-rtx timers of the paths whose chunks were
+	// acknowledged, and stop the ones of the paths without data in
+	// flight, as per RFC 4960 section 6.3.2.
+	for _, p := range a.paths {
+		switch {
+		case p.flightSize == 0:
+			e.stopTimerLocked(&p.t3)
+		case acked[p] != 0:
+			e.armT3Locked(p)
+		}
+	}
+}
+
+// onAcked grows the congestion window of p once n bytes in flight on p are
+// acknowledged, when flightBefore bytes were in flight.
+func (p *path) onAcked(n, flightBefore int, fastRecovery bool) {
+	mtu := p.mtu()
+	if p.cwnd <= p.ssthresh {
+		// Slow start.
+		if !fastRecovery && flightBefore >= p.cwnd {
+			if n > mtu {
+				n = mtu
+			}
+			p.cwnd += n
+		}
+		return
+	}
+	// Congestion avoidance.
+	p.pba += n
+	if p.pba >= p.cwnd && flightBefore >= p.cwnd {
+		p.pba -= p.cwnd
+		p.cwnd += mtu
+	}
+}
@@ -197,6 +197,14 @@ func (l *listenContext) isCookieValid(id stack.TransportEndpointID, cookie seqnu
 	return (v - l.cookieHash(id, cookieTS, 1)) & hashMask, true
 }
 
+// mark returns the SO_MARK of the listening endpoint, if any.
+func (l *listenContext) mark() uint32 {
+	if l.listenEP == nil {
+		return 0
+	}
+	return l.listenEP.ops.GetMark()
+}
+
 // createConnectingEndpoint creates a new endpoint in a connecting state, with
 // the connection parameters given by the arguments.
 func (l *listenContext) createConnectingEndpoint(s *segment, iss seqnum.Value, irs seqnum.Value, rcvdSynOpts *header.TCPSynOptions, queue *waiter.Queue) (*endpoint, *tcpip.Error) {
@@ -206,7 +214,10 @@ func (l *listenContext) createConnectingEndpoint(s *segment, iss seqnum.Value, i
 		netProto = s.netProto
 	}
 
-	route, err := l.stack.FindRoute(s.nicID, s.dstAddr, s.srcAddr, s.netProto, false /* multicastLoop */)
+	// Like Linux, accepted endpoints inherit the SO_MARK of the listening
+	// endpoint.
+	mark := l.mark()
+	route, err := l.stack.FindRouteWithPolicy(s.nicID, s.dstAddr, s.srcAddr, s.netProto, false /* multicastLoop */, stack.RoutePolicyKey{Mark: mark})
 	if err != nil {
 		return nil, err
 	}
@@ -214,6 +225,7 @@ func (l *listenContext) createConnectingEndpoint(s *segment, iss seqnum.Value, i
 
 	n := newEndpoint(l.stack, netProto, queue)
 	n.ops.SetV6Only(l.v6Only)
+	n.ops.SetMark(mark)
 	n.ID = s.id
 	n.boundNICID = s.nicID
 	n.route = route
@@ -224,6 +236,11 @@ func (l *listenContext) createConnectingEndpoint(s *segment, iss seqnum.Value, i
 
 	n.maybeEnableTimestamp(rcvdSynOpts)
 	n.maybeEnableSACKPermitted(rcvdSynOpts)
+	if l.listenEP != nil {
+		n.inheritMD5Keys(l.listenEP)
+		n.inheritAO(l.listenEP)
+	}
+	n.synchronizeAO(iss, irs)
 
 	n.initGSO()
 
@@ -240,10 +257,11 @@ func (l *listenContext) createConnectingEndpoint(s *segment, iss seqnum.Value, i
 // handshake in progress, which includes the new endpoint in the SYN-RCVD
 // state.
 //
-// On success, a handshake h is returned with h.ep.mu held.
+// On success, a handshake h is returned with h.ep.mu held. If subflow is not
+// nil, the new endpoint is a Multipath TCP subflow handled by it.
 //
 // Precondition: if l.listenEP != nil, l.listenEP.mu must be locked.
-func (l *listenContext) startHandshake(s *segment, opts *header.TCPSynOptions, queue *waiter.Queue, owner tcpip.PacketOwner) (*handshake, *tcpip.Error) {
+func (l *listenContext) startHandshake(s *segment, opts *header.TCPSynOptions, queue *waiter.Queue, owner tcpip.PacketOwner, subflow SubflowHandler) (*handshake, *tcpip.Error) {
 	// Create new endpoint.
 	irs := s.sequenceNumber
 	isn := generateSecureISN(s.id, l.stack.Seed())
@@ -257,6 +275,7 @@ func (l *listenContext) startHandshake(s *segment, opts *header.TCPSynOptions, q
 	// the endpoint is done initializing.
 	ep.mu.Lock()
 	ep.owner = owner
+	ep.subflow = subflow
 
 	// listenEP is nil when listenContext is used by tcp.Forwarder.
 	deferAccept := time.Duration(0)
@@ -306,6 +325,9 @@ func (l *listenContext) startHandshake(s *segment, opts *header.TCPSynOptions, q
 
 	// Initialize and start the handshake.
 	h := ep.newPassiveHandshake(isn, irs, opts, deferAccept)
+	var ecnFl uint8
+	h.ecn, ecnFl, h.ae = acceptECN(ep.ecnOption(), s)
+	h.flags |= ecnFl
 	if err := h.start(); err != nil {
 		l.cleanupFailedHandshake(h)
 		return nil, err
@@ -318,7 +340,7 @@ func (l *listenContext) startHandshake(s *segment, opts *header.TCPSynOptions, q
 //
 // Precondition: if l.listenEP != nil, l.listenEP.mu must be locked.
 func (l *listenContext) performHandshake(s *segment, opts *header.TCPSynOptions, queue *waiter.Queue, owner tcpip.PacketOwner) (*endpoint, *tcpip.Error) {
-	h, err := l.startHandshake(s, opts, queue, owner)
+	h, err := l.startHandshake(s, opts, queue, owner, nil /* subflow */)
 	if err != nil {
 		return nil, err
 	}
@@ -427,6 +449,11 @@ func (e *endpoint) propagateInheritableOptionsLocked(n *endpoint) {
 	n.boundBindToDevice = e.boundBindToDevice
 	n.boundPortFlags = e.boundPortFlags
 	n.userMSS = e.userMSS
+	n.ecnOpt = e.ecnOpt
+
+	e.sndBufMu.Lock()
+	n.notSentLowat = e.notSentLowat
+	e.sndBufMu.Unlock()
 }
 
 // reserveTupleLocked reserves an accepted endpoint's tuple.
@@ -471,10 +498,10 @@ func (e *endpoint) notifyAborted() {
 // cookies to accept connections.
 //
 // Precondition: if ctx.listenEP != nil, ctx.listenEP.mu must be locked.
-func (e *endpoint) handleSynSegment(ctx *listenContext, s *segment, opts *header.TCPSynOptions) *tcpip.Error {
+func (e *endpoint) handleSynSegment(ctx *listenContext, s *segment, opts *header.TCPSynOptions, subflow SubflowHandler) *tcpip.Error {
 	defer s.decRef()
 
-	h, err := ctx.startHandshake(s, opts, &waiter.Queue{}, e.owner)
+	h, err := ctx.startHandshake(s, opts, &waiter.Queue{}, e.owner, subflow)
 	if err != nil {
 		e.stack.Stats().TCP.FailedConnectionAttempts.Increment()
 		e.stats.FailedConnectionAttempts.Increment()
@@ -522,6 +549,40 @@ func (e *endpoint) acceptQueueIsFull() bool {
 	return full
 }
 
+// synRcvdIsFull returns true if the listening endpoint's bound on the number
+// of connections in SYN-RCVD state has been reached.
+//
+// Precondition: e.mu must be locked.
+func (e *endpoint) synRcvdIsFull() bool {
+	return e.maxSynRcvd != 0 && e.synRcvdCount >= e.maxSynRcvd
+}
+
+// synCookiesInUse returns true if an ACK received by the listening endpoint
+// may complete a handshake started with a SYN cookie.
+//
+// Precondition: e.mu must be locked.
+func (e *endpoint) synCookiesInUse(ctx *listenContext) bool {
+	switch e.synCookiesMode(ctx) {
+	case tcpip.TCPSynCookiesAlways:
+		return true
+	case tcpip.TCPSynCookiesOff:
+		return false
+	default:
+		return e.synRcvdIsFull() || ctx.synRcvdCount.synCookiesInUse()
+	}
+}
+
+// synCookiesMode returns the SYN cookie mode in effect for the listening
+// endpoint.
+//
+// Precondition: e.mu must be locked.
+func (e *endpoint) synCookiesMode(ctx *listenContext) tcpip.TCPSynCookiesOption {
+	if e.synCookies != tcpip.TCPSynCookiesDefault {
+		return e.synCookies
+	}
+	return ctx.synRcvdCount.SynCookies()
+}
+
 // handleListenSegment is called when a listening endpoint receives a segment
 // and needs to handle it.
 //
@@ -540,16 +601,26 @@ func (e *endpoint) handleListenSegment(ctx *listenContext, s *segment) *tcpip.Er
 	}
 
 	switch {
-	case s.flags == header.TCPFlagSyn:
+	case s.flags&^ecnFlags == header.TCPFlagSyn:
 		opts := parseSynSegmentOptions(s)
-		if ctx.synRcvdCount.inc() {
+		mode := e.synCookiesMode(ctx)
+		if mode != tcpip.TCPSynCookiesAlways && !e.synRcvdIsFull() && ctx.synRcvdCount.inc() {
 			// Only handle the syn if the following conditions hold
 			//   - accept queue is not full.
 			//   - number of connections in synRcvd state is less than the
 			//     backlog.
 			if !e.acceptQueueIsFull() && e.incSynRcvdCount() {
+				var subflow SubflowHandler
+				if e.subflowListen != nil {
+					var ok bool
+					if subflow, ok = e.subflowListen.HandleSyn(s.id, s.options); !ok {
+						e.synRcvdCount--
+						ctx.synRcvdCount.dec()
+						return replyWithReset(e.stack, s, e.sendTOS, e.ttl)
+					}
+				}
 				s.incRef()
-				_ = e.handleSynSegment(ctx, s, &opts)
+				_ = e.handleSynSegment(ctx, s, &opts, subflow)
 				return nil
 			}
 			ctx.synRcvdCount.dec()
@@ -557,54 +628,56 @@ func (e *endpoint) handleListenSegment(ctx *listenContext, s *segment) *tcpip.Er
 			e.stats.ReceiveErrors.ListenOverflowSynDrop.Increment()
 			e.stack.Stats().DroppedPackets.Increment()
 			return nil
-		} else {
-			// If cookies are in use but the endpoint accept queue
-			// is full then drop the syn.
-			if e.acceptQueueIsFull() {
-				e.stack.Stats().TCP.ListenOverflowSynDrop.Increment()
-				e.stats.ReceiveErrors.ListenOverflowSynDrop.Increment()
-				e.stack.Stats().DroppedPackets.Increment()
-				return nil
-			}
-			cookie := ctx.createCookie(s.id, s.sequenceNumber, encodeMSS(opts.MSS))
-
-			route, err := e.stack.FindRoute(s.nicID, s.dstAddr, s.srcAddr, s.netProto, false /* multicastLoop */)
-			if err != nil {
-				return err
-			}
-			defer route.Release()
-			route.ResolveWith(s.remoteLinkAddr)
+		}
 
-			// Send SYN without window scaling because we currently
-			// don't encode this information in the cookie.
-			//
-			// Enable Timestamp option if the original syn did have
-			// the timestamp option specified.
-			//
-			// Use the user supplied MSS on the listening socket for
-			// new connections, if available.
-			synOpts := header.TCPSynOptions{
-				WS:    -1,
-				TS:    opts.TS,
-				TSVal: tcpTimeStamp(time.Now(), timeStampOffset()),
-				TSEcr: opts.TSVal,
-				MSS:   calculateAdvertisedMSS(e.userMSS, route),
-			}
-			fields := tcpFields{
-				id:     s.id,
-				ttl:    e.ttl,
-				tos:    e.sendTOS,
-				flags:  header.TCPFlagSyn | header.TCPFlagAck,
-				seq:    cookie,
-				ack:    s.sequenceNumber + 1,
-				rcvWnd: ctx.rcvWnd,
-			}
-			if err := e.sendSynTCP(route, fields, synOpts); err != nil {
-				return err
-			}
-			e.stack.Stats().TCP.ListenOverflowSynCookieSent.Increment()
+		// Either cookies are always in use, or too many connections
+		// are in SYN-RCVD state. Drop the syn if cookies are disabled
+		// or the endpoint accept queue is full.
+		if mode == tcpip.TCPSynCookiesOff || e.acceptQueueIsFull() {
+			e.stack.Stats().TCP.ListenOverflowSynDrop.Increment()
+			e.stats.ReceiveErrors.ListenOverflowSynDrop.Increment()
+			e.stack.Stats().DroppedPackets.Increment()
 			return nil
 		}
+		cookie := ctx.createCookie(s.id, s.sequenceNumber, encodeMSS(opts.MSS))
+
+		route, err := e.stack.FindRouteWithPolicy(s.nicID, s.dstAddr, s.srcAddr, s.netProto, false /* multicastLoop */, stack.RoutePolicyKey{Mark: e.ops.GetMark()})
+		if err != nil {
+			return err
+		}
+		defer route.Release()
+		route.ResolveWith(s.remoteLinkAddr)
+
+		// Send SYN without window scaling because we currently
+		// don't encode this information in the cookie.
+		//
+		// Enable Timestamp option if the original syn did have
+		// the timestamp option specified.
+		//
+		// Use the user supplied MSS on the listening socket for
+		// new connections, if available.
+		synOpts := header.TCPSynOptions{
+			WS:    -1,
+			TS:    opts.TS,
+			TSVal: tcpTimeStamp(time.Now(), timeStampOffset()),
+			TSEcr: opts.TSVal,
+			MSS:   calculateAdvertisedMSS(e.userMSS, route),
+		}
+		fields := tcpFields{
+			id:     s.id,
+			ttl:    e.ttl,
+			tos:    e.sendTOS,
+			flags:  header.TCPFlagSyn | header.TCPFlagAck,
+			seq:    cookie,
+			ack:    s.sequenceNumber + 1,
+			rcvWnd: ctx.rcvWnd,
+		}
+		if err := e.sendSynTCP(route, fields, synOpts); err != nil {
+			return err
+		}
+		e.stack.Stats().TCP.ListenOverflowSynCookieSent.Increment()
+		e.stats.ReceiveErrors.ListenOverflowSynCookieSent.Increment()
+		return nil
 
 	case (s.flags & header.TCPFlagAck) != 0:
 		if e.acceptQueueIsFull() {
@@ -619,7 +692,7 @@ func (e *endpoint) handleListenSegment(ctx *listenContext, s *segment) *tcpip.Er
 			return nil
 		}
 
-		if !ctx.synRcvdCount.synCookiesInUse() {
+		if !e.synCookiesInUse(ctx) {
 			// When not using SYN cookies, as per RFC 793, section 3.9, page 64:
 			// Any acknowledgment is bad if it arrives on a connection still in
 			// the LISTEN state.  An acceptable reset segment should be formed
@@ -654,10 +727,12 @@ func (e *endpoint) handleListenSegment(ctx *listenContext, s *segment) *tcpip.Er
 		data, ok := ctx.isCookieValid(s.id, iss, irs)
 		if !ok || int(data) >= len(mssTable) {
 			e.stack.Stats().TCP.ListenOverflowInvalidSynCookieRcvd.Increment()
+			e.stats.ReceiveErrors.ListenOverflowInvalidSynCookieRcvd.Increment()
 			e.stack.Stats().DroppedPackets.Increment()
 			return nil
 		}
 		e.stack.Stats().TCP.ListenOverflowSynCookieRcvd.Increment()
+		e.stats.ReceiveErrors.ListenOverflowSynCookieRcvd.Increment()
 		// Create newly accepted endpoint and deliver it.
 		rcvdSynOptions := &header.TCPSynOptions{
 			MSS: mssTable[data],
@@ -0,0 +1,491 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp
+
+import (
+	"crypto/subtle"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/seqnum"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// maxAOKeyLen is the maximum length of a TCP-AO master key, as in Linux.
+const maxAOKeyLen = 80
+
+// aoKey is a TCP Authentication Option master key tuple (MKT), used with the
+// peers matching a prefix, as per RFC 5925 section 3.1.
+//
+// +stateify savable
+type aoKey struct {
+	peerPrefix
+	alg    header.TCPAOAlgorithm
+	sendID uint8
+	recvID uint8
+	macLen int
+	key    []byte
+
+	// sendTrafficKey and recvTrafficKey are the traffic keys of the
+	// non-SYN segments of the connection, derived from key once needed.
+	sendTrafficKey []byte
+	recvTrafficKey []byte
+}
+
+// aoSNE tracks the sequence number extension (SNE) of one direction of a
+// connection, as per RFC 5925 section 6.2.
+//
+// +stateify savable
+type aoSNE struct {
+	// seq is the highest sequence number seen, and sne its extension.
+	seq seqnum.Value
+	sne uint32
+}
+
+// extension returns the extension of the sequence number seq, which is
+// expected to be close to the highest sequence number seen.
+func (s *aoSNE) extension(seq seqnum.Value) uint32 {
+	switch {
+	case s.seq.LessThan(seq) && seq < s.seq:
+		// The sequence numbers wrapped since the highest one seen.
+		return s.sne + 1
+	case seq.LessThan(s.seq) && seq > s.seq:
+		// seq was sent before the sequence numbers last wrapped.
+		return s.sne - 1
+	}
+	return s.sne
+}
+
+// update records the sequence number seq of a segment.
+func (s *aoSNE) update(seq seqnum.Value) {
+	if s.seq.LessThan(seq) {
+		s.sne = s.extension(seq)
+		s.seq = seq
+	}
+}
+
+// aoInfo is the TCP Authentication Option state of an endpoint.
+//
+// +stateify savable
+type aoInfo struct {
+	keys []aoKey
+
+	// hasCurrent and current are the SendID of the key segments are sent
+	// with, if set. Otherwise, the key matching the peer with the longest
+	// prefix is used.
+	hasCurrent bool
+	current    uint8
+
+	// hasRNext and rnext are the RecvID of the key the peer is asked to
+	// send segments with, if set. Otherwise, the RecvID of the key
+	// segments are sent with is used.
+	hasRNext bool
+	rnext    uint8
+
+	// required is true if segments without a TCP Authentication Option
+	// are dropped, even when no key is used with their sender.
+	required bool
+
+	// synchronized is true once the initial sequence numbers of the
+	// connection, iss and irs, are known.
+	synchronized bool
+	iss          seqnum.Value
+	irs          seqnum.Value
+	sndSNE       aoSNE
+	rcvSNE       aoSNE
+
+	// The counters of the segments received, as returned by TCP_AO_INFO.
+	good        uint64
+	bad         uint64
+	keyNotFound uint64
+	aoRequired  uint64
+}
+
+// usedWith returns true if a key is used with the peer addr reached through
+// nic.
+func (a *aoInfo) usedWith(addr tcpip.Address, nic tcpip.NICID) bool {
+	for i := range a.keys {
+		if a.keys[i].matches(addr, nic) {
+			return true
+		}
+	}
+	return false
+}
+
+// sendKey returns the key segments are sent to the peer addr reached through
+// nic with, if any.
+func (a *aoInfo) sendKey(addr tcpip.Address, nic tcpip.NICID) *aoKey {
+	var best *aoKey
+	for i := range a.keys {
+		k := &a.keys[i]
+		if !k.matches(addr, nic) {
+			continue
+		}
+		if a.hasCurrent && k.sendID == a.current {
+			return k
+		}
+		if best == nil || k.prefixLen > best.prefixLen {
+			best = k
+		}
+	}
+	return best
+}
+
+// recvKey returns the key whose RecvID is keyID used with the peer addr
+// reached through nic, if any.
+func (a *aoInfo) recvKey(addr tcpip.Address, nic tcpip.NICID, keyID uint8) *aoKey {
+	for i := range a.keys {
+		if k := &a.keys[i]; k.recvID == keyID && k.matches(addr, nic) {
+			return k
+		}
+	}
+	return nil
+}
+
+// rnextKeyID returns the RNextKeyID of the segments sent to the peer addr
+// reached through nic with the key k.
+func (a *aoInfo) rnextKeyID(k *aoKey, addr tcpip.Address, nic tcpip.NICID) uint8 {
+	if a.hasRNext && a.recvKey(addr, nic, a.rnext) != nil {
+		return a.rnext
+	}
+	return k.recvID
+}
+
+// hasKey returns true if a key matches the given IDs.
+func (a *aoInfo) hasKey(id uint8, send bool) bool {
+	for i := range a.keys {
+		k := &a.keys[i]
+		if (send && k.sendID == id) || (!send && k.recvID == id) {
+			return true
+		}
+	}
+	return false
+}
+
+// aoSignature describes the TCP Authentication Option of a sent segment.
+type aoSignature struct {
+	alg        header.TCPAOAlgorithm
+	keyID      uint8
+	rnextKeyID uint8
+	macLen     int
+	trafficKey []byte
+	sne        uint32
+}
+
+// addAOKey adds or replaces the TCP-AO key described by opt.
+func (e *endpoint) addAOKey(opt *tcpip.TCPAOAddKeyOption) *tcpip.Error {
+	alg, ok := header.TCPAOAlgorithmByName(opt.Algorithm)
+	if !ok {
+		return tcpip.ErrInvalidOptionValue
+	}
+	macLen := opt.MACLength
+	if macLen == 0 {
+		macLen = header.TCPAODefaultMACLength
+	}
+	if len(opt.Key) == 0 || len(opt.Key) > maxAOKeyLen || macLen < 0 || macLen > header.TCPAOMaxMACLength || macLen > alg.MACSize() || opt.PrefixLen < 0 || opt.PrefixLen > len(opt.Addr)*8 {
+		return tcpip.ErrInvalidOptionValue
+	}
+	key := aoKey{
+		peerPrefix: peerPrefix{
+			addr:      opt.Addr,
+			prefixLen: opt.PrefixLen,
+			nic:       opt.NIC,
+		},
+		alg:    alg,
+		sendID: opt.SendID,
+		recvID: opt.RecvID,
+		macLen: macLen,
+		key:    append([]byte(nil), opt.Key...),
+	}
+
+	e.aoMu.Lock()
+	defer e.aoMu.Unlock()
+	replaced := false
+	for i := range e.ao.keys {
+		k := &e.ao.keys[i]
+		if k.peerPrefix != key.peerPrefix {
+			continue
+		}
+		if k.sendID == key.sendID && k.recvID == key.recvID {
+			*k = key
+			replaced = true
+			break
+		}
+		// The IDs of the keys used with a peer must be unique, as per
+		// RFC 5925 section 3.1.
+		if k.sendID == key.sendID || k.recvID == key.recvID {
+			return tcpip.ErrInvalidOptionValue
+		}
+	}
+	if !replaced {
+		e.ao.keys = append(e.ao.keys, key)
+	}
+	if opt.SetCurrent {
+		e.ao.hasCurrent, e.ao.current = true, key.sendID
+	}
+	if opt.SetRNext {
+		e.ao.hasRNext, e.ao.rnext = true, key.recvID
+	}
+	return nil
+}
+
+// delAOKey removes the TCP-AO key described by opt.
+func (e *endpoint) delAOKey(opt *tcpip.TCPAODelKeyOption) *tcpip.Error {
+	e.aoMu.Lock()
+	defer e.aoMu.Unlock()
+	for i := range e.ao.keys {
+		k := &e.ao.keys[i]
+		if k.addr != opt.Addr || k.prefixLen != opt.PrefixLen || k.nic != opt.NIC || k.sendID != opt.SendID || k.recvID != opt.RecvID {
+			continue
+		}
+		e.ao.keys = append(e.ao.keys[:i], e.ao.keys[i+1:]...)
+		if opt.SetCurrent {
+			e.ao.hasCurrent, e.ao.current = true, opt.Current
+		} else if e.ao.hasCurrent && e.ao.current == opt.SendID && !e.ao.hasKey(opt.SendID, true) {
+			e.ao.hasCurrent = false
+		}
+		if opt.SetRNext {
+			e.ao.hasRNext, e.ao.rnext = true, opt.RNext
+		} else if e.ao.hasRNext && e.ao.rnext == opt.RecvID && !e.ao.hasKey(opt.RecvID, false) {
+			e.ao.hasRNext = false
+		}
+		return nil
+	}
+	return tcpip.ErrNoSuchFile
+}
+
+// setAOInfo sets the TCP-AO state of the endpoint described by opt.
+func (e *endpoint) setAOInfo(opt *tcpip.TCPAOInfoOption) *tcpip.Error {
+	e.aoMu.Lock()
+	defer e.aoMu.Unlock()
+	if (opt.SetCurrent && !e.ao.hasKey(opt.Current, true)) || (opt.SetRNext && !e.ao.hasKey(opt.RNext, false)) {
+		return tcpip.ErrNoSuchFile
+	}
+	if opt.SetCurrent {
+		e.ao.hasCurrent, e.ao.current = true, opt.Current
+	}
+	if opt.SetRNext {
+		e.ao.hasRNext, e.ao.rnext = true, opt.RNext
+	}
+	e.ao.required = opt.Required
+	if opt.SetCounters {
+		e.ao.good = opt.Good
+		e.ao.bad = opt.Bad
+		e.ao.keyNotFound = opt.KeyNotFound
+		e.ao.aoRequired = opt.AORequired
+	}
+	return nil
+}
+
+// getAOInfo returns the TCP-AO state of the endpoint.
+func (e *endpoint) getAOInfo() tcpip.TCPAOInfoOption {
+	e.aoMu.Lock()
+	defer e.aoMu.Unlock()
+	return tcpip.TCPAOInfoOption{
+		SetCurrent:  e.ao.hasCurrent,
+		Current:     e.ao.current,
+		SetRNext:    e.ao.hasRNext,
+		RNext:       e.ao.rnext,
+		Required:    e.ao.required,
+		Good:        e.ao.good,
+		Bad:         e.ao.bad,
+		KeyNotFound: e.ao.keyNotFound,
+		AORequired:  e.ao.aoRequired,
+	}
+}
+
+// inheritAO copies the TCP-AO keys and settings of the listening endpoint l
+// to the endpoint e it accepted.
+func (e *endpoint) inheritAO(l *endpoint) {
+	l.aoMu.Lock()
+	ao := aoInfo{
+		keys:       append([]aoKey(nil), l.ao.keys...),
+		hasCurrent: l.ao.hasCurrent,
+		current:    l.ao.current,
+		hasRNext:   l.ao.hasRNext,
+		rnext:      l.ao.rnext,
+		required:   l.ao.required,
+	}
+	l.aoMu.Unlock()
+
+	e.aoMu.Lock()
+	e.ao = ao
+	e.aoMu.Unlock()
+}
+
+// synchronizeAO records the initial sequence numbers of the connection, from
+// which the traffic keys of its non-SYN segments are derived.
+func (e *endpoint) synchronizeAO(iss, irs seqnum.Value) {
+	e.aoMu.Lock()
+	defer e.aoMu.Unlock()
+	e.ao.synchronized = true
+	e.ao.iss = iss
+	e.ao.irs = irs
+	e.ao.sndSNE = aoSNE{seq: iss}
+	e.ao.rcvSNE = aoSNE{seq: irs}
+	for i := range e.ao.keys {
+		e.ao.keys[i].sendTrafficKey = nil
+		e.ao.keys[i].recvTrafficKey = nil
+	}
+}
+
+// peerAOMACLen returns the length of the MAC of the segments sent to the peer
+// of a connected endpoint, or 0 if they aren't authenticated.
+func (e *endpoint) peerAOMACLen() int {
+	var nic tcpip.NICID
+	if e.route != nil {
+		nic = e.route.NICID()
+	}
+	e.aoMu.Lock()
+	defer e.aoMu.Unlock()
+	if k := e.ao.sendKey(e.ID.RemoteAddress, nic); k != nil {
+		return k.macLen
+	}
+	return 0
+}
+
+// aoSign returns the TCP Authentication Option of a segment with the given
+// flags, sequence and acknowledgement numbers sent through r to the peer
+// identified by id, or nil if the segment isn't authenticated.
+func (e *endpoint) aoSign(r *stack.Route, id stack.TransportEndpointID, flags byte, seq, ack seqnum.Value) *aoSignature {
+	e.aoMu.Lock()
+	defer e.aoMu.Unlock()
+	k := e.ao.sendKey(id.RemoteAddress, r.NICID())
+	if k == nil {
+		return nil
+	}
+	sig := &aoSignature{
+		alg:        k.alg,
+		keyID:      k.sendID,
+		rnextKeyID: e.ao.rnextKeyID(k, id.RemoteAddress, r.NICID()),
+		macLen:     k.macLen,
+	}
+	switch {
+	case flags&header.TCPFlagSyn != 0:
+		// The initial sequence number of the peer is zero for a SYN,
+		// as per RFC 5925 section 5.2.
+		var dstISN seqnum.Value
+		if flags&header.TCPFlagAck != 0 {
+			dstISN = ack - 1
+		}
+		sig.trafficKey = header.TCPAOTrafficKey(k.alg, k.key, r.LocalAddress, r.RemoteAddress, id.LocalPort, id.RemotePort, seq, dstISN)
+	case e.ao.synchronized:
+		if k.sendTrafficKey == nil {
+			k.sendTrafficKey = header.TCPAOTrafficKey(k.alg, k.key, r.LocalAddress, r.RemoteAddress, id.LocalPort, id.RemotePort, e.ao.iss, e.ao.irs)
+		}
+		sig.trafficKey = k.sendTrafficKey
+		e.ao.sndSNE.update(seq)
+		sig.sne = e.ao.sndSNE.extension(seq)
+	default:
+		// The initial sequence number of the peer isn't known yet,
+		// e.g. when resetting a connection in SYN-SENT, so the segment
+		// can't be authenticated.
+		return nil
+	}
+	return sig
+}
+
+// checkAO returns true if the received segment s carries the TCP
+// Authentication Option expected by the endpoint, or none if none is
+// expected, as per RFC 5925 section 7.3.
+func (e *endpoint) checkAO(s *segment) bool {
+	opt, ok := header.ParseTCPAOOption(s.options)
+	stats := e.stack.Stats().TCP
+
+	e.aoMu.Lock()
+	defer e.aoMu.Unlock()
+	if !e.ao.usedWith(s.id.RemoteAddress, s.nicID) {
+		switch {
+		case ok:
+			e.ao.keyNotFound++
+			stats.AOKeyNotFound.Increment()
+			return false
+		case e.ao.required:
+			e.ao.aoRequired++
+			stats.AORequired.Increment()
+			return false
+		}
+		return true
+	}
+	if !ok {
+		e.ao.aoRequired++
+		stats.AORequired.Increment()
+		return false
+	}
+	k := e.ao.recvKey(s.id.RemoteAddress, s.nicID, opt.KeyID)
+	if k == nil {
+		e.ao.keyNotFound++
+		stats.AOKeyNotFound.Increment()
+		return false
+	}
+
+	var (
+		trafficKey []byte
+		sne        uint32
+	)
+	switch {
+	case s.flagIsSet(header.TCPFlagSyn):
+		var dstISN seqnum.Value
+		if s.flagIsSet(header.TCPFlagAck) {
+			dstISN = s.ackNumber - 1
+		}
+		trafficKey = header.TCPAOTrafficKey(k.alg, k.key, s.srcAddr, s.dstAddr, s.id.RemotePort, s.id.LocalPort, s.sequenceNumber, dstISN)
+	case e.ao.synchronized:
+		if k.recvTrafficKey == nil {
+			k.recvTrafficKey = header.TCPAOTrafficKey(k.alg, k.key, s.srcAddr, s.dstAddr, s.id.RemotePort, s.id.LocalPort, e.ao.irs, e.ao.iss)
+		}
+		trafficKey = k.recvTrafficKey
+		sne = e.ao.rcvSNE.extension(s.sequenceNumber)
+	default:
+		// A listening endpoint receives the final ACK of a handshake
+		// completed with SYN cookies, which acknowledges the initial
+		// sequence numbers.
+		trafficKey = header.TCPAOTrafficKey(k.alg, k.key, s.srcAddr, s.dstAddr, s.id.RemotePort, s.id.LocalPort, s.sequenceNumber-1, s.ackNumber-1)
+	}
+	mac := header.TCPAOMAC(k.alg, trafficKey, sne, s.srcAddr, s.dstAddr, s.hdr, s.data, k.macLen)
+	if len(opt.MAC) != k.macLen || subtle.ConstantTimeCompare(mac, opt.MAC) != 1 {
+		e.ao.bad++
+		stats.AOBad.Increment()
+		return false
+	}
+	e.ao.good++
+	stats.AOGood.Increment()
+
+	if e.ao.synchronized && !s.flagIsSet(header.TCPFlagSyn) {
+		e.ao.rcvSNE.update(s.sequenceNumber)
+	}
+	// Switch to the key the peer asks for, as per RFC 5925 section 7.5.2.
+	if e.ao.synchronized && (!e.ao.hasCurrent || e.ao.current != opt.RNextKeyID) {
+		for i := range e.ao.keys {
+			if k := &e.ao.keys[i]; k.sendID == opt.RNextKeyID && k.matches(s.id.RemoteAddress, s.nicID) {
+				e.ao.hasCurrent, e.ao.current = true, opt.RNextKeyID
+				break
+			}
+		}
+	}
+	return true
+}
+
+// prependAOOption inserts the TCP Authentication Option sig before the options
+// of a SYN segment, which leave enough room for it. As in Linux, the option is
+// the first one.
+func prependAOOption(options []byte, sig *aoSignature) []byte {
+	n := len(options)
+	size := (header.TCPOptionAOMinimumLength + sig.macLen + 3) &^ 3
+	options = options[:n+size]
+	copy(options[size:], options[:n])
+	header.EncodeTCPAOOption(sig.keyID, sig.rnextKeyID, sig.macLen, options)
+	return options
+}
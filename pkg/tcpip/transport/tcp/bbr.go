@@ -0,0 +1,614 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp
+
+import (
+	"time"
+)
+
+// bbrMode is the state of the BBR state machine.
+type bbrMode int
+
+const (
+	// bbrStartup rapidly ramps up the sending rate to fill the pipe.
+	bbrStartup bbrMode = iota
+
+	// bbrDrain drains the queue created during startup.
+	bbrDrain
+
+	// bbrProbeBW cycles the pacing gain to probe for more bandwidth while
+	// sharing the bottleneck fairly.
+	bbrProbeBW
+
+	// bbrProbeRTT cuts the amount of data in flight to probe for the
+	// minimum RTT.
+	bbrProbeRTT
+)
+
+// String implements fmt.Stringer.
+func (m bbrMode) String() string {
+	switch m {
+	case bbrStartup:
+		return "Startup"
+	case bbrDrain:
+		return "Drain"
+	case bbrProbeBW:
+		return "ProbeBW"
+	case bbrProbeRTT:
+		return "ProbeRTT"
+	default:
+		return "Unknown"
+	}
+}
+
+const (
+	// bbrHighGain is the gain used in startup to double the sending rate
+	// every round, 2/ln(2).
+	bbrHighGain = 2.885
+
+	// bbrDrainGain is the pacing gain used in drain to drain the queue
+	// created in startup in a single round.
+	bbrDrainGain = 1 / bbrHighGain
+
+	// bbrCwndGain is the congestion window gain used in ProbeBW, to allow
+	// for delayed and stretched ACKs.
+	bbrCwndGain = 2
+
+	// bbrBWFilterLen is the length, in rounds, of the window of the max
+	// bandwidth filter.
+	bbrBWFilterLen = 10
+
+	// bbrMinRTTWindow is the length of the window of the min RTT filter.
+	bbrMinRTTWindow = 10 * time.Second
+
+	// bbrProbeRTTDuration is the minimum time spent in ProbeRTT.
+	bbrProbeRTTDuration = 200 * time.Millisecond
+
+	// bbrFullBWThresh is the bandwidth growth factor below which startup
+	// considers the pipe to be full.
+	bbrFullBWThresh = 1.25
+
+	// bbrFullBWCount is the number of rounds without bandwidth growth
+	// after which startup considers the pipe to be full.
+	bbrFullBWCount = 3
+
+	// bbrMinCwnd is the minimum congestion window, in packets.
+	bbrMinCwnd = 4
+
+	// bbrPacingMargin is the fraction by which the pacing rate is reduced
+	// below the estimated bandwidth to avoid building queues.
+	bbrPacingMargin = 0.01
+
+	// bbrQuantizationBudget is the number of packets added to the target
+	// congestion window to account for delayed ACKs and segmentation
+	// offload.
+	bbrQuantizationBudget = 3
+)
+
+// bbrPacingGainCycle is the sequence of pacing gains used in ProbeBW. Each
+// phase lasts for roughly one min RTT.
+var bbrPacingGainCycle = [...]float64{5.0 / 4, 3.0 / 4, 1, 1, 1, 1, 1, 1}
+
+// maxFilterSample is a sample held by windowedMaxFilter.
+//
+// +stateify savable
+type maxFilterSample struct {
+	t uint64
+	v float64
+}
+
+// windowedMaxFilter tracks the maximum of a series of samples over a sliding
+// window using Kathleen Nichols' algorithm, which holds the best, second best
+// and third best samples from successive sub-windows.
+//
+// +stateify savable
+type windowedMaxFilter struct {
+	s [3]maxFilterSample
+}
+
+// get returns the maximum sample in the window.
+func (f *windowedMaxFilter) get() float64 {
+	return f.s[0].v
+}
+
+// reset forgets all samples and starts over from the sample v taken at t.
+func (f *windowedMaxFilter) reset(t uint64, v float64) {
+	f.s[0] = maxFilterSample{t: t, v: v}
+	f.s[1] = f.s[0]
+	f.s[2] = f.s[0]
+}
+
+// update adds the sample v taken at t to a window of length win, and returns
+// the maximum sample in the window.
+func (f *windowedMaxFilter) update(win, t uint64, v float64) float64 {
+	sample := maxFilterSample{t: t, v: v}
+	if v >= f.s[0].v || t-f.s[2].t > win {
+		// The new sample is a new maximum, or nothing left in the
+		// window: start over.
+		f.reset(t, v)
+		return v
+	}
+
+	if v >= f.s[1].v {
+		f.s[1] = sample
+		f.s[2] = sample
+	} else if v >= f.s[2].v {
+		f.s[2] = sample
+	}
+
+	// Expire and update the best samples as the window advances.
+	dt := t - f.s[0].t
+	switch {
+	case dt > win:
+		// The best sample has expired, promote the others.
+		f.s[0] = f.s[1]
+		f.s[1] = f.s[2]
+		f.s[2] = sample
+		if t-f.s[0].t > win {
+			f.s[0] = f.s[1]
+			f.s[1] = f.s[2]
+			f.s[2] = sample
+		}
+	case f.s[1].t == f.s[0].t && dt > win/4:
+		// A quarter of the window has passed without a second best
+		// sample, so take one from the second quarter.
+		f.s[1] = sample
+		f.s[2] = sample
+	case f.s[2].t == f.s[1].t && dt > win/2:
+		// Half the window has passed without a third best sample, so
+		// take one from the second half.
+		f.s[2] = sample
+	}
+	return f.s[0].v
+}
+
+// bbrState stores the variables related to the BBR (version 1) congestion
+// control algorithm.
+//
+// BBR builds a model of the path from delivery rate samples (see
+// deliveryRate), estimating the bottleneck bandwidth as the windowed max of
+// the delivery rate and the round-trip propagation delay as the windowed min
+// of the RTT, and paces sending at the estimated bandwidth.
+//
+// See: https://tools.ietf.org/html/draft-cardwell-iccrg-bbr-congestion-control-00
+//
+// +stateify savable
+type bbrState struct {
+	s *sender
+
+	// mode is the current state of the state machine.
+	mode bbrMode
+
+	// maxBW estimates the bottleneck bandwidth, in packets per second, as
+	// the max delivery rate over the last bbrBWFilterLen rounds.
+	maxBW windowedMaxFilter
+
+	// minRTT estimates the round-trip propagation delay as the min RTT
+	// over the last bbrMinRTTWindow. It is zero until the first RTT
+	// sample.
+	minRTT time.Duration
+
+	// minRTTStamp is the time at which minRTT was last updated.
+	minRTTStamp time.Time `state:".(unixTime)"`
+
+	// roundCount is the number of round trips elapsed on the connection.
+	roundCount uint64
+
+	// nextRoundDelivered is the value of deliveryRate.delivered that
+	// marks the end of the current round trip.
+	nextRoundDelivered int
+
+	// roundStart is true if the ACK being processed started a new round.
+	roundStart bool
+
+	// pacingGain and cwndGain are the gains applied to the bandwidth and
+	// the bandwidth-delay product to compute the pacing rate and the
+	// congestion window.
+	pacingGain float64
+	cwndGain   float64
+
+	// filledPipe is true once startup has found the bottleneck bandwidth.
+	filledPipe bool
+
+	// fullBW is the bandwidth estimate used as the baseline to detect
+	// that startup has filled the pipe, and fullBWCount the number of
+	// rounds without significant growth over it.
+	fullBW      float64
+	fullBWCount int
+
+	// cycleIndex is the current phase in bbrPacingGainCycle, and
+	// cycleStamp the time at which it started.
+	cycleIndex int
+	cycleStamp time.Time `state:".(unixTime)"`
+
+	// probeRTTDoneStamp is the earliest time at which ProbeRTT may end. It
+	// is zero until the amount of data in flight has dropped to
+	// bbrMinCwnd.
+	probeRTTDoneStamp time.Time `state:".(unixTime)"`
+
+	// probeRTTRoundDone is true once a full round has elapsed in ProbeRTT.
+	probeRTTRoundDone bool
+
+	// priorCwnd is the congestion window before loss recovery or ProbeRTT,
+	// restored on exiting them.
+	priorCwnd int
+
+	// inRecovery is true while the sender is in loss recovery.
+	inRecovery bool
+
+	// packetConservation is true during the first round of loss recovery,
+	// during which at most one packet is sent per packet delivered.
+	packetConservation bool
+
+	// hasSeenRTT is true once the initial pacing rate has been computed
+	// from an RTT sample.
+	hasSeenRTT bool
+}
+
+// newBBRCC returns a BBR state in startup, with the pacing rate initialized
+// from the initial congestion window.
+func newBBRCC(s *sender) *bbrState {
+	now := time.Now()
+	b := &bbrState{
+		s:           s,
+		minRTTStamp: now,
+		cycleStamp:  now,
+		priorCwnd:   s.sndCwnd,
+	}
+	b.nextRoundDelivered = s.dr.delivered
+	b.enterStartup()
+	b.initPacingRate()
+	return b
+}
+
+// HandleNDupAcks implements congestionControl.HandleNDupAcks.
+func (b *bbrState) HandleNDupAcks() {
+	// BBR does not reduce its sending rate in response to loss. Leave the
+	// window at the amount of data in flight; packet conservation governs
+	// sending until a round has elapsed.
+	b.saveCwnd()
+	b.s.sndSsthresh = b.s.outstanding
+	if b.s.sndSsthresh < bbrMinCwnd {
+		b.s.sndSsthresh = bbrMinCwnd
+	}
+}
+
+// HandleRTOExpired implements congestionControl.HandleRTOExpired.
+func (b *bbrState) HandleRTOExpired() {
+	b.saveCwnd()
+	b.s.sndCwnd = 1
+}
+
+// Update implements congestionControl.Update.
+//
+// BBR updates its state from rate samples in HandleRateSample instead.
+func (b *bbrState) Update(packetsAcked int) {}
+
+// PostRecovery implements congestionControl.PostRecovery.
+func (b *bbrState) PostRecovery() {
+	b.restoreCwnd()
+}
+
+// HandleRateSample implements rateSampleHandler.HandleRateSample.
+func (b *bbrState) HandleRateSample(rs *rateSample) {
+	now := time.Now()
+	b.updateBW(rs)
+	b.updateCyclePhase(rs, now)
+	b.checkFullBWReached(rs)
+	b.checkDrain(now)
+	b.updateMinRTT(rs, now)
+	b.updateGains()
+	b.setPacingRate()
+	b.setCwnd(rs)
+}
+
+// saveCwnd remembers the congestion window before it is reduced.
+func (b *bbrState) saveCwnd() {
+	if !b.inRecovery && b.mode != bbrProbeRTT {
+		b.priorCwnd = b.s.sndCwnd
+		return
+	}
+	// Loss recovery and ProbeRTT reduce the window, so keep the window
+	// from before they began.
+	if b.s.sndCwnd > b.priorCwnd {
+		b.priorCwnd = b.s.sndCwnd
+	}
+}
+
+// restoreCwnd restores the congestion window saved by saveCwnd.
+func (b *bbrState) restoreCwnd() {
+	if b.s.sndCwnd < b.priorCwnd {
+		b.s.sndCwnd = b.priorCwnd
+	}
+}
+
+// bdp returns the estimated bandwidth-delay product, in packets, scaled by
+// gain.
+func (b *bbrState) bdp(bw, gain float64) int {
+	// Without an RTT sample, there is no estimate of the path, so use
+	// the initial congestion window.
+	if b.minRTT == 0 {
+		return InitialCwnd
+	}
+	bdp := bw * b.minRTT.Seconds() * gain
+	// Round up so that the window is never below the actual BDP.
+	n := int(bdp)
+	if float64(n) < bdp {
+		n++
+	}
+	return n
+}
+
+// enterStartup enters startup.
+func (b *bbrState) enterStartup() {
+	b.mode = bbrStartup
+}
+
+// resetMode enters startup if the pipe hasn't been filled yet, and ProbeBW
+// otherwise.
+func (b *bbrState) resetMode(now time.Time) {
+	if !b.filledPipe {
+		b.enterStartup()
+		return
+	}
+	b.enterProbeBW(now)
+}
+
+// enterProbeBW enters ProbeBW at a random phase of the gain cycle other than
+// the draining phase.
+func (b *bbrState) enterProbeBW(now time.Time) {
+	b.mode = bbrProbeBW
+	n := len(bbrPacingGainCycle)
+	b.cycleIndex = n - 1 - b.s.ep.stack.Rand().Intn(n-1)
+	b.advanceCyclePhase(now)
+}
+
+// advanceCyclePhase moves to the next phase of the gain cycle.
+func (b *bbrState) advanceCyclePhase(now time.Time) {
+	b.cycleIndex = (b.cycleIndex + 1) % len(bbrPacingGainCycle)
+	b.cycleStamp = now
+}
+
+// updateBW updates the round count and the bandwidth estimate.
+func (b *bbrState) updateBW(rs *rateSample) {
+	b.roundStart = false
+	if rs.delivered < 0 || rs.interval <= 0 {
+		return
+	}
+
+	// A round ends when a packet sent after the round started is
+	// delivered.
+	if rs.priorDelivered >= b.nextRoundDelivered {
+		b.nextRoundDelivered = b.s.dr.delivered
+		b.roundCount++
+		b.roundStart = true
+		b.packetConservation = false
+	}
+
+	// Application-limited samples underestimate the bandwidth, so only
+	// use them if they're higher than the current estimate.
+	bw := float64(rs.delivered) / rs.interval.Seconds()
+	if !rs.isAppLimited || bw >= b.maxBW.get() {
+		b.maxBW.update(bbrBWFilterLen, b.roundCount, bw)
+	}
+}
+
+// updateCyclePhase advances the gain cycle in ProbeBW when the current phase
+// is done.
+func (b *bbrState) updateCyclePhase(rs *rateSample, now time.Time) {
+	if b.mode == bbrProbeBW && b.isNextCyclePhase(rs, now) {
+		b.advanceCyclePhase(now)
+	}
+}
+
+// isNextCyclePhase returns true if the current phase of the gain cycle is
+// done.
+func (b *bbrState) isNextCyclePhase(rs *rateSample, now time.Time) bool {
+	full := now.Sub(b.cycleStamp) > b.minRTT
+	gain := bbrPacingGainCycle[b.cycleIndex]
+	switch {
+	case gain > 1:
+		// Probe for bandwidth until a min RTT has elapsed and enough
+		// data is in flight to use the extra bandwidth, if any.
+		return full && rs.priorInFlight >= b.bdp(b.maxBW.get(), gain)
+	case gain < 1:
+		// Drain the queue created by probing, ending early if it has
+		// already been drained.
+		return full || rs.priorInFlight <= b.bdp(b.maxBW.get(), 1)
+	default:
+		return full
+	}
+}
+
+// checkFullBWReached checks whether startup has filled the pipe, i.e. the
+// bandwidth estimate has stopped growing significantly.
+func (b *bbrState) checkFullBWReached(rs *rateSample) {
+	if b.filledPipe || !b.roundStart || rs.isAppLimited {
+		return
+	}
+	if bw := b.maxBW.get(); bw >= b.fullBW*bbrFullBWThresh {
+		b.fullBW = bw
+		b.fullBWCount = 0
+		return
+	}
+	b.fullBWCount++
+	b.filledPipe = b.fullBWCount >= bbrFullBWCount
+}
+
+// checkDrain moves from startup to drain once the pipe is filled, and from
+// drain to ProbeBW once the queue is drained.
+func (b *bbrState) checkDrain(now time.Time) {
+	s := b.s
+	if b.mode == bbrStartup && b.filledPipe {
+		b.mode = bbrDrain
+		s.sndSsthresh = b.bdp(b.maxBW.get(), 1)
+	}
+	if b.mode == bbrDrain && s.outstanding <= b.bdp(b.maxBW.get(), 1) {
+		b.enterProbeBW(now)
+	}
+}
+
+// updateMinRTT updates the min RTT estimate, and enters or exits ProbeRTT.
+func (b *bbrState) updateMinRTT(rs *rateSample, now time.Time) {
+	s := b.s
+	expired := now.Sub(b.minRTTStamp) > bbrMinRTTWindow
+	if rs.rtt > 0 && (b.minRTT == 0 || rs.rtt <= b.minRTT || expired) {
+		b.minRTT = rs.rtt
+		b.minRTTStamp = now
+	}
+
+	if expired && b.mode != bbrProbeRTT {
+		b.saveCwnd()
+		b.mode = bbrProbeRTT
+		b.probeRTTDoneStamp = time.Time{}
+	}
+
+	if b.mode != bbrProbeRTT {
+		return
+	}
+
+	// Samples taken in ProbeRTT are limited by the reduced window, so
+	// mark the connection as application-limited.
+	s.dr.appLimited = s.dr.delivered + s.outstanding
+	if s.dr.appLimited == 0 {
+		s.dr.appLimited = 1
+	}
+
+	// Maintain bbrMinCwnd in flight for at least bbrProbeRTTDuration and
+	// one round.
+	if b.probeRTTDoneStamp.IsZero() {
+		if s.outstanding <= bbrMinCwnd {
+			b.probeRTTDoneStamp = now.Add(bbrProbeRTTDuration)
+			b.probeRTTRoundDone = false
+			b.nextRoundDelivered = s.dr.delivered
+		}
+		return
+	}
+	if b.roundStart {
+		b.probeRTTRoundDone = true
+	}
+	if b.probeRTTRoundDone && now.After(b.probeRTTDoneStamp) {
+		b.minRTTStamp = now
+		b.restoreCwnd()
+		b.resetMode(now)
+	}
+}
+
+// updateGains sets the pacing and congestion window gains for the current
+// mode.
+func (b *bbrState) updateGains() {
+	switch b.mode {
+	case bbrStartup:
+		b.pacingGain = bbrHighGain
+		b.cwndGain = bbrHighGain
+	case bbrDrain:
+		b.pacingGain = bbrDrainGain
+		b.cwndGain = bbrHighGain
+	case bbrProbeBW:
+		b.pacingGain = bbrPacingGainCycle[b.cycleIndex]
+		b.cwndGain = bbrCwndGain
+	case bbrProbeRTT:
+		b.pacingGain = 1
+		b.cwndGain = 1
+	}
+}
+
+// rate converts a bandwidth in packets per second into a pacing rate in bytes
+// per second, scaled by gain.
+func (b *bbrState) rate(bw, gain float64) uint64 {
+	return uint64(bw * float64(b.s.maxPayloadSize) * gain * (1 - bbrPacingMargin))
+}
+
+// initPacingRate initializes the pacing rate from the initial congestion
+// window and the smoothed RTT, if available, or a nominal 1ms otherwise.
+func (b *bbrState) initPacingRate() {
+	s := b.s
+	rtt := time.Millisecond
+	s.rtt.Lock()
+	if s.rtt.srttInited && s.rtt.srtt > 0 {
+		rtt = s.rtt.srtt
+		b.hasSeenRTT = true
+	}
+	s.rtt.Unlock()
+	bw := float64(s.sndCwnd) / rtt.Seconds()
+	s.pacingRate = b.rate(bw, bbrHighGain)
+}
+
+// setPacingRate sets the pacing rate from the bandwidth estimate. The rate is
+// only reduced once the pipe has been filled, so that startup isn't slowed
+// by early low samples.
+func (b *bbrState) setPacingRate() {
+	if !b.hasSeenRTT {
+		b.initPacingRate()
+	}
+	rate := b.rate(b.maxBW.get(), b.pacingGain)
+	if rate == 0 {
+		return
+	}
+	if b.filledPipe || rate > b.s.pacingRate {
+		b.s.pacingRate = rate
+	}
+}
+
+// setCwndForRecovery adjusts the congestion window on entering and exiting
+// loss recovery. It returns true if the window is governed by packet
+// conservation.
+func (b *bbrState) setCwndForRecovery(rs *rateSample) bool {
+	s := b.s
+	recovering := s.fr.active || s.state == RTORecovery
+	switch {
+	case recovering && !b.inRecovery:
+		// Start packet conservation for one round.
+		b.inRecovery = true
+		b.packetConservation = true
+		b.nextRoundDelivered = s.dr.delivered
+	case !recovering && b.inRecovery:
+		b.inRecovery = false
+		b.packetConservation = false
+		b.restoreCwnd()
+	}
+	if !b.packetConservation {
+		return false
+	}
+	if cwnd := s.outstanding + rs.ackedSacked; s.sndCwnd < cwnd {
+		s.sndCwnd = cwnd
+	}
+	return true
+}
+
+// setCwnd sets the congestion window from the bandwidth-delay product.
+func (b *bbrState) setCwnd(rs *rateSample) {
+	s := b.s
+	if rs.ackedSacked > 0 && !b.setCwndForRecovery(rs) {
+		target := b.bdp(b.maxBW.get(), b.cwndGain) + bbrQuantizationBudget
+		cwnd := s.sndCwnd
+		if b.filledPipe {
+			cwnd += rs.ackedSacked
+			if cwnd > target {
+				cwnd = target
+			}
+		} else if cwnd < target || s.dr.delivered < InitialCwnd {
+			cwnd += rs.ackedSacked
+		}
+		if cwnd < bbrMinCwnd {
+			cwnd = bbrMinCwnd
+		}
+		s.sndCwnd = cwnd
+	}
+
+	if b.mode == bbrProbeRTT && s.sndCwnd > bbrMinCwnd {
+		s.sndCwnd = bbrMinCwnd
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp
+
+import (
+	"time"
+)
+
+// saveMinRTTStamp is invoked by stateify.
+func (b *bbrState) saveMinRTTStamp() unixTime {
+	return unixTime{b.minRTTStamp.Unix(), b.minRTTStamp.UnixNano()}
+}
+
+// loadMinRTTStamp is invoked by stateify.
+func (b *bbrState) loadMinRTTStamp(unix unixTime) {
+	b.minRTTStamp = time.Unix(unix.second, unix.nano)
+}
+
+// saveCycleStamp is invoked by stateify.
+func (b *bbrState) saveCycleStamp() unixTime {
+	return unixTime{b.cycleStamp.Unix(), b.cycleStamp.UnixNano()}
+}
+
+// loadCycleStamp is invoked by stateify.
+func (b *bbrState) loadCycleStamp(unix unixTime) {
+	b.cycleStamp = time.Unix(unix.second, unix.nano)
+}
+
+// saveProbeRTTDoneStamp is invoked by stateify.
+func (b *bbrState) saveProbeRTTDoneStamp() unixTime {
+	return unixTime{b.probeRTTDoneStamp.Unix(), b.probeRTTDoneStamp.UnixNano()}
+}
+
+// loadProbeRTTDoneStamp is invoked by stateify.
+func (b *bbrState) loadProbeRTTDoneStamp(unix unixTime) {
+	b.probeRTTDoneStamp = time.Unix(unix.second, unix.nano)
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp
+
+import (
+	"testing"
+)
+
+func TestWindowedMaxFilter(t *testing.T) {
+	const win = 10
+
+	type sample struct {
+		t    uint64
+		v    float64
+		want float64
+	}
+	tests := []struct {
+		name    string
+		samples []sample
+	}{
+		{
+			name: "increasing",
+			samples: []sample{
+				{t: 0, v: 1, want: 1},
+				{t: 1, v: 2, want: 2},
+				{t: 2, v: 3, want: 3},
+			},
+		},
+		{
+			name: "max held within window",
+			samples: []sample{
+				{t: 0, v: 10, want: 10},
+				{t: 3, v: 5, want: 10},
+				{t: 6, v: 4, want: 10},
+				{t: 10, v: 3, want: 10},
+			},
+		},
+		{
+			name: "max expires",
+			samples: []sample{
+				{t: 0, v: 10, want: 10},
+				{t: 3, v: 5, want: 10},
+				{t: 6, v: 4, want: 10},
+				{t: 11, v: 3, want: 5},
+			},
+		},
+		{
+			name: "all samples expire",
+			samples: []sample{
+				{t: 0, v: 10, want: 10},
+				{t: 1, v: 9, want: 10},
+				{t: 30, v: 1, want: 1},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var f windowedMaxFilter
+			for _, s := range test.samples {
+				if got := f.update(win, s.t, s.v); got != s.want {
+					t.Errorf("f.update(%d, %d, %f) = %f, want = %f", win, s.t, s.v, got, s.want)
+				}
+				if got := f.get(); got != s.want {
+					t.Errorf("got f.get() = %f, want = %f", got, s.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp
+
+import (
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip/seqnum"
+)
+
+// chronoType is what limits the sender while it is busy. Types are ordered by
+// priority: while a type is being timed, starting a lower priority type has no
+// effect.
+type chronoType int
+
+const (
+	// chronoNone indicates that the sender is idle.
+	chronoNone chronoType = iota
+
+	// chronoBusy indicates that the sender has data in flight or queued.
+	chronoBusy
+
+	// chronoRwndLimited indicates that the sender is stalled by the
+	// receive window of the peer.
+	chronoRwndLimited
+
+	// chronoSndBufLimited indicates that the sender is stalled by the
+	// send buffer.
+	chronoSndBufLimited
+
+	numChronoTypes
+)
+
+// chrono accounts the time the sender spends in each chronoType, like Linux's
+// tcp_chrono_*() functions.
+//
+// +stateify savable
+type chrono struct {
+	// typ is the type being timed.
+	typ chronoType
+
+	// start is the time at which timing typ started.
+	start time.Time `state:".(unixTime)"`
+
+	// stats holds the time spent in each type, excluding the time spent in
+	// typ since start.
+	stats [numChronoTypes]time.Duration
+}
+
+// set switches the type being timed to typ.
+func (c *chrono) set(typ chronoType, now time.Time) {
+	if c.typ > chronoNone {
+		c.stats[c.typ] += now.Sub(c.start)
+	}
+	c.typ = typ
+	c.start = now
+}
+
+// durations returns the time spent in each type up to now.
+func (c *chrono) durations(now time.Time) [numChronoTypes]time.Duration {
+	stats := c.stats
+	if c.typ > chronoNone {
+		stats[c.typ] += now.Sub(c.start)
+	}
+	return stats
+}
+
+// startChrono starts timing typ, unless a higher priority type is being timed.
+func (s *sender) startChrono(typ chronoType) {
+	if typ > s.chrono.typ {
+		s.chrono.set(typ, time.Now())
+	}
+}
+
+// stopChrono stops timing typ. The sender goes back to busy if it still has
+// data in flight or queued, and idle otherwise.
+func (s *sender) stopChrono(typ chronoType) {
+	switch {
+	case s.writeList.Front() == nil:
+		if s.chrono.typ != chronoNone {
+			s.chrono.set(chronoNone, time.Now())
+		}
+	case typ == s.chrono.typ:
+		s.chrono.set(chronoBusy, time.Now())
+	}
+}
+
+// updateSendChrono updates the chrono after the sender tried to send data
+// ending at end, the right edge of the send window.
+func (s *sender) updateSendChrono(end seqnum.Value) {
+	if s.writeList.Front() == nil {
+		return
+	}
+	s.startChrono(chronoBusy)
+	if s.writeNext != nil && !s.sndNxt.LessThan(end) {
+		s.startChrono(chronoRwndLimited)
+	} else {
+		s.stopChrono(chronoRwndLimited)
+	}
+}
@@ -17,6 +17,7 @@ package tcp
 import (
 	"encoding/binary"
 	"math"
+	"sync/atomic"
 	"time"
 
 	"gvisor.dev/gvisor/pkg/rand"
@@ -106,6 +107,13 @@ type handshake struct {
 
 	// sendSYNOpts is the cached values for the SYN options to be sent.
 	sendSYNOpts header.TCPSynOptions
+
+	// ae is the Accurate ECN flag of the SYN/SYN-ACK to be sent. The other
+	// ECN flags are part of flags.
+	ae bool
+
+	// ecn is the flavour of ECN negotiated by the handshake.
+	ecn ecnState
 }
 
 func (e *endpoint) newHandshake() *handshake {
@@ -154,6 +162,8 @@ func (h *handshake) resetState() {
 
 	h.state = handshakeSynSent
 	h.flags = header.TCPFlagSyn
+	h.ae = false
+	h.ecn = ecnOff
 	h.ackNum = 0
 	h.mss = 0
 	h.iss = generateSecureISN(h.ep.ID, h.ep.stack.Seed())
@@ -264,7 +274,10 @@ func (h *handshake) synSentState(s *segment) *tcpip.Error {
 
 	// Remember the sequence we'll ack from now on.
 	h.ackNum = s.sequenceNumber + 1
-	h.flags |= header.TCPFlagAck
+	h.ep.synchronizeAO(h.iss, s.sequenceNumber)
+	synFlags, synAE := h.flags, h.ae
+	h.flags = h.flags&^ecnFlags | header.TCPFlagAck
+	h.ae = false
 	h.mss = rcvSynOpts.MSS
 	h.sndWndScale = rcvSynOpts.WS
 
@@ -272,8 +285,17 @@ func (h *handshake) synSentState(s *segment) *tcpip.Error {
 	// and the handshake is completed.
 	if s.flagIsSet(header.TCPFlagAck) {
 		h.state = handshakeCompleted
+		h.ecn = connectECN(synFlags, synAE, s)
 
 		h.ep.transitionToStateEstablishedLocked(h)
+		if h.ecn == ecnAccurate {
+			h.ep.rcv.ecnHandshakeACE = accECNHandshakeACE(s.ecn)
+		}
+
+		if !h.ep.handleSubflowSegment(s) {
+			h.ep.sendRaw(buffer.VectorisedView{}, header.TCPFlagRst|header.TCPFlagAck, h.iss+1, h.ackNum, 0)
+			return tcpip.ErrConnectionAborted
+		}
 
 		h.ep.sendRaw(buffer.VectorisedView{}, header.TCPFlagAck, h.iss+1, h.ackNum, h.rcvWnd>>h.effectiveRcvWndScale())
 		return nil
@@ -379,8 +401,9 @@ func (h *handshake) synRcvdState(s *segment) *tcpip.Error {
 	if s.flagIsSet(header.TCPFlagAck) {
 		// If deferAccept is not zero and this is a bare ACK and the
 		// timeout is not hit then drop the ACK.
-		if h.deferAccept != 0 && s.data.Size() == 0 && time.Since(h.startTime) < h.deferAccept {
+		if h.deferAccept != 0 && s.logicalLen() == 0 && time.Since(h.startTime) < h.deferAccept {
 			h.acked = true
+			h.ep.stack.Stats().TCP.DeferAcceptDrop.Increment()
 			h.ep.stack.Stats().DroppedPackets.Increment()
 			return nil
 		}
@@ -397,13 +420,31 @@ func (h *handshake) synRcvdState(s *segment) *tcpip.Error {
 		if h.ep.sendTSOk && s.parsedOptions.TS {
 			h.ep.updateRecentTimestamp(s.parsedOptions.TSVal, h.ackNum, s.sequenceNumber)
 		}
+
+		// As per RFC 9768 section 3.2.2.1, a zero ACE field in the
+		// ACK means that the ECN flags were cleared along the path.
+		if h.ecn == ecnAccurate && s.ace() == 0 {
+			h.ecn = ecnOff
+		}
 		h.state = handshakeCompleted
 
 		h.ep.transitionToStateEstablishedLocked(h)
 
-		// If the segment has data then requeue it for the receiver
-		// to process it again once main loop is started.
-		if s.data.Size() > 0 {
+		if h.ep.subflow != nil {
+			if !h.ep.handleSubflowSegment(s) {
+				h.ep.sendRaw(buffer.VectorisedView{}, header.TCPFlagRst|header.TCPFlagAck, h.iss+1, h.ackNum, 0)
+				return tcpip.ErrConnectionAborted
+			}
+
+			// Acknowledge the ACK completing the handshake, as
+			// required by RFC 8684 section 3.2 for subflows joining
+			// a Multipath TCP connection.
+			h.ep.sendRaw(buffer.VectorisedView{}, header.TCPFlagAck, h.iss+1, h.ackNum, h.rcvWnd>>h.effectiveRcvWndScale())
+		}
+
+		// If the segment has data or a FIN then requeue it for the
+		// receiver to process it again once main loop is started.
+		if s.logicalLen() > 0 {
 			s.incRef()
 			h.ep.enqueueSegment(s)
 		}
@@ -496,6 +537,9 @@ func (h *handshake) resolveRoute() *tcpip.Error {
 				<-h.ep.undrain
 				h.ep.mu.Lock()
 			}
+			if n&notifyDeviceRemoved != 0 {
+				return tcpip.ErrUnknownDevice
+			}
 			if n&notifyError != 0 {
 				return h.ep.lastErrorLocked()
 			}
@@ -535,6 +579,14 @@ func (h *handshake) start() *tcpip.Error {
 		MSS:           h.ep.amss,
 	}
 
+	// Request ECN in the SYN. Its negotiation in a SYN-ACK is done when
+	// accepting the connection.
+	if h.state == handshakeSynSent {
+		ecnFl, ae := h.ep.requestECN()
+		h.flags |= ecnFl
+		h.ae = ae
+	}
+
 	// start() is also called in a listen context so we want to make sure we only
 	// send the TS/SACK option when we received the TS/SACK in the initial SYN.
 	if h.state == handshakeSynRcvd {
@@ -553,6 +605,7 @@ func (h *handshake) start() *tcpip.Error {
 		ttl:    h.ep.ttl,
 		tos:    h.ep.sendTOS,
 		flags:  h.flags,
+		ae:     h.ae,
 		seq:    h.iss,
 		ack:    h.ackNum,
 		rcvWnd: h.rcvWnd,
@@ -602,6 +655,7 @@ func (h *handshake) complete() *tcpip.Error {
 					ttl:    h.ep.ttl,
 					tos:    h.ep.sendTOS,
 					flags:  h.flags,
+					ae:     h.ae,
 					seq:    h.iss,
 					ack:    h.ackNum,
 					rcvWnd: h.rcvWnd,
@@ -630,6 +684,9 @@ func (h *handshake) complete() *tcpip.Error {
 				<-h.ep.undrain
 				h.ep.mu.Lock()
 			}
+			if n&notifyDeviceRemoved != 0 {
+				return tcpip.ErrUnknownDevice
+			}
 			if n&notifyError != 0 {
 				return h.ep.lastErrorLocked()
 			}
@@ -649,6 +706,26 @@ type backoffTimer struct {
 	t          *time.Timer
 }
 
+// deferAcceptTimeout returns the time during which the final ACK of passive
+// handshakes is dropped for a TCP_DEFER_ACCEPT of d. As Linux converts
+// TCP_DEFER_ACCEPT to a number of SYN-ACK retransmissions, d is rounded up to
+// the time of the retransmission following it in the schedule of
+// handshake.complete. It is capped at the last retransmission, so that the
+// peer can complete a deferred handshake with another ACK.
+func deferAcceptTimeout(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	// The SYN-ACK is retransmitted when the timer expires only if the
+	// doubled timeout doesn't exceed MaxRTO.
+	timeout, period := time.Second, time.Second
+	for d > period && 4*timeout <= MaxRTO {
+		timeout *= 2
+		period += timeout
+	}
+	return period
+}
+
 func newBackoffTimer(timeout, maxTimeout time.Duration, f func()) (*backoffTimer, *tcpip.Error) {
 	if timeout > maxTimeout {
 		return nil, tcpip.ErrTimeout
@@ -755,15 +832,53 @@ type tcpFields struct {
 	ttl    uint8
 	tos    uint8
 	flags  byte
+	ae     bool
 	seq    seqnum.Value
 	ack    seqnum.Value
 	rcvWnd seqnum.Size
 	opts   []byte
 	txHash uint32
+
+	// md5Key is the TCP MD5 signature key of the segment, if any. The
+	// options must then start with the signature option.
+	md5Key []byte
+
+	// ao is the TCP Authentication Option of the segment, if any. The
+	// options must then start with it.
+	ao *aoSignature
+
+	// dontFragment and ignorePathMTU are the path MTU discovery parameters of
+	// the segment, as per stack.NetworkHeaderParams.
+	dontFragment  bool
+	ignorePathMTU bool
+
+	// txDone, if set, is called with the payload size of each packet of
+	// the segment once the packet has left the stack.
+	txDone func(size int)
 }
 
 func (e *endpoint) sendSynTCP(r *stack.Route, tf tcpFields, opts header.TCPSynOptions) *tcpip.Error {
-	tf.opts = makeSynOptions(opts)
+	// The TCP Authentication Option takes precedence over TCP MD5
+	// signatures.
+	tf.ao = e.aoSign(r, tf.id, tf.flags, tf.seq, tf.ack)
+	if tf.ao == nil {
+		tf.md5Key = e.md5KeyFor(tf.id.RemoteAddress, r.NICID())
+	}
+	switch {
+	case tf.ao != nil:
+		// Authenticated connections don't use subflow handlers.
+		tf.opts = prependAOOption(makeSynOptions(opts), tf.ao)
+	case tf.md5Key != nil:
+		// As in Linux, timestamps aren't offered along with a signature
+		// so that the options fit, and signed connections don't use
+		// subflow handlers.
+		if tf.flags&header.TCPFlagAck == 0 {
+			opts.TS = false
+		}
+		tf.opts = prependMD5Option(makeSynOptions(opts))
+	default:
+		tf.opts = e.addSubflowSynOptions(tf.flags&header.TCPFlagAck != 0, makeSynOptions(opts))
+	}
 	// We ignore SYN send errors and let the callers re-attempt send.
 	if err := e.sendTCP(r, tf, buffer.VectorisedView{}, nil); err != nil {
 		e.stats.SendErrors.SynSendToNetworkFailed.Increment()
@@ -773,7 +888,18 @@ func (e *endpoint) sendSynTCP(r *stack.Route, tf tcpFields, opts header.TCPSynOp
 }
 
 func (e *endpoint) sendTCP(r *stack.Route, tf tcpFields, data buffer.VectorisedView, gso *stack.GSO) *tcpip.Error {
+	// Segments of connections being repaired are dropped as if they were
+	// lost, and retransmitted once the repair is over.
+	if e.repair {
+		return nil
+	}
 	tf.txHash = e.txHash
+	// Segments are sized to fit the path MTU so they are never rejected.
+	tf.dontFragment, tf.ignorePathMTU, _ = r.PMTUDiscoveryParams(e.pmtudSetting(), 0 /* size */)
+	if size := data.Size(); size != 0 {
+		atomic.AddInt64(&e.queuedBytes, int64(size))
+		tf.txDone = e.transmitDone
+	}
 	if err := sendTCP(r, tf, data, gso, e.owner); err != nil {
 		e.stats.SendErrors.SegmentSendToNetworkFailed.Increment()
 		return err
@@ -793,9 +919,20 @@ func buildTCPHdr(r *stack.Route, tf tcpFields, pkt *stack.PacketBuffer, gso *sta
 		AckNum:     uint32(tf.ack),
 		DataOffset: uint8(header.TCPMinimumSize + optLen),
 		Flags:      tf.flags,
+		AE:         tf.ae,
 		WindowSize: uint16(tf.rcvWnd),
 	})
 	copy(tcp[header.TCPMinimumSize:], tf.opts)
+	if tf.md5Key != nil {
+		// The digest follows the two NOPs, the kind and the length of
+		// the signature option.
+		sum := header.TCPMD5Digest(tf.md5Key, r.LocalAddress, r.RemoteAddress, tcp, pkt.Data)
+		copy(tcp[header.TCPMinimumSize+4:], sum[:])
+	}
+	if tf.ao != nil {
+		mac := header.TCPAOMAC(tf.ao.alg, tf.ao.trafficKey, tf.ao.sne, r.LocalAddress, r.RemoteAddress, tcp, pkt.Data, tf.ao.macLen)
+		copy(tcp[header.TCPMinimumSize+header.TCPOptionAOMinimumLength:], mac)
+	}
 
 	xsum := r.PseudoHeaderChecksum(ProtocolNumber, uint16(pkt.Size()))
 	// Only calculate the checksum if offloading isn't supported.
@@ -829,6 +966,9 @@ func sendTCPBatch(r *stack.Route, tf tcpFields, data buffer.VectorisedView, gso
 	size := data.Size()
 	hdrSize := header.TCPMinimumSize + int(r.MaxHeaderLength()) + optLen
 	var pkts stack.PacketBufferList
+	// The list links of the packets are reused by queueing link endpoints,
+	// so keep the packets separately to complete their transmission.
+	var txPkts []*stack.PacketBuffer
 	for i := 0; i < n; i++ {
 		packetSize := mss
 		if packetSize > size {
@@ -846,13 +986,22 @@ func sendTCPBatch(r *stack.Route, tf tcpFields, data buffer.VectorisedView, gso
 		data.ReadToVV(&pkt.Data, packetSize)
 		buildTCPHdr(r, tf, pkt, gso)
 		tf.seq = tf.seq.Add(seqnum.Size(packetSize))
+		if tf.txDone != nil {
+			setTransmitDone(pkt, tf.txDone, packetSize)
+			txPkts = append(txPkts, pkt)
+		}
 		pkts.PushBack(pkt)
 	}
 
 	if tf.ttl == 0 {
 		tf.ttl = r.DefaultTTL()
 	}
-	sent, err := r.WritePackets(gso, pkts, stack.NetworkHeaderParams{Protocol: ProtocolNumber, TTL: tf.ttl, TOS: tf.tos})
+	sent, err := r.WritePackets(gso, pkts, stack.NetworkHeaderParams{Protocol: ProtocolNumber, TTL: tf.ttl, TOS: tf.tos, DontFragment: tf.dontFragment, IgnorePathMTU: tf.ignorePathMTU})
+	for _, pkt := range txPkts {
+		if !pkt.TransmitDoneDeferred() {
+			pkt.TransmitDone()
+		}
+	}
 	if err != nil {
 		r.Stats().TCP.SegmentSendErrors.IncrementBy(uint64(n - sent))
 	}
@@ -878,12 +1027,19 @@ func sendTCP(r *stack.Route, tf tcpFields, data buffer.VectorisedView, gso *stac
 	})
 	pkt.Hash = tf.txHash
 	pkt.Owner = owner
+	if tf.txDone != nil {
+		setTransmitDone(pkt, tf.txDone, data.Size())
+	}
 	buildTCPHdr(r, tf, pkt, gso)
 
 	if tf.ttl == 0 {
 		tf.ttl = r.DefaultTTL()
 	}
-	if err := r.WritePacket(gso, stack.NetworkHeaderParams{Protocol: ProtocolNumber, TTL: tf.ttl, TOS: tf.tos}, pkt); err != nil {
+	err := r.WritePacket(gso, stack.NetworkHeaderParams{Protocol: ProtocolNumber, TTL: tf.ttl, TOS: tf.tos, DontFragment: tf.dontFragment, IgnorePathMTU: tf.ignorePathMTU}, pkt)
+	if !pkt.TransmitDoneDeferred() {
+		pkt.TransmitDone()
+	}
+	if err != nil {
 		r.Stats().TCP.SegmentSendErrors.Increment()
 		return err
 	}
@@ -894,14 +1050,31 @@ func sendTCP(r *stack.Route, tf tcpFields, data buffer.VectorisedView, gso *stac
 	return nil
 }
 
-// makeOptions makes an options slice.
-func (e *endpoint) makeOptions(sackBlocks []header.SACKBlock) []byte {
+// setTransmitDone arranges for txDone to be called with size once pkt has left
+// the stack.
+func setTransmitDone(pkt *stack.PacketBuffer, txDone func(int), size int) {
+	pkt.SetTransmitDone(func() {
+		txDone(size)
+	})
+}
+
+// makeOptions makes an options slice. seg describes the segment the options
+// are for to the subflow handler; it may be nil if the options are not sent.
+// md5 is true if the segment is signed with a TCP MD5 signature, and ao is the
+// TCP Authentication Option of the segment, if any.
+func (e *endpoint) makeOptions(sackBlocks []header.SACKBlock, seg *SubflowSegment, md5 bool, ao *aoSignature) []byte {
 	options := getOptions()
 	offset := 0
 
 	// N.B. the ordering here matches the ordering used by Linux internally
 	// and described in the raw makeOptions function. We don't include
 	// unnecessary cases here (post connection.)
+	if md5 {
+		offset += header.EncodeMD5Option(options[offset:])
+	}
+	if ao != nil {
+		offset += header.EncodeTCPAOOption(ao.keyID, ao.rnextKeyID, ao.macLen, options[offset:])
+	}
 	if e.sendTSOk {
 		// Embed the timestamp if timestamp has been enabled.
 		//
@@ -919,7 +1092,13 @@ func (e *endpoint) makeOptions(sackBlocks []header.SACKBlock) []byte {
 		offset += header.EncodeNOP(options[offset:])
 		offset += header.EncodeTSOption(e.timestamp(), e.recentTimestamp(), options[offset:])
 	}
-	if e.sackPermitted && len(sackBlocks) > 0 {
+	if e.subflow != nil && seg != nil {
+		offset += e.subflow.Options(*seg, options[offset:])
+		offset += header.AddTCPOptionPadding(options, offset)
+	}
+	// The subflow options take precedence over SACK blocks, which are
+	// only added if there's room for at least one of them.
+	if e.sackPermitted && len(sackBlocks) > 0 && len(options)-offset >= 4+8 {
 		offset += header.EncodeNOP(options[offset:])
 		offset += header.EncodeNOP(options[offset:])
 		offset += header.EncodeSACKBlocks(sackBlocks, options[offset:])
@@ -935,20 +1114,51 @@ func (e *endpoint) makeOptions(sackBlocks []header.SACKBlock) []byte {
 
 // sendRaw sends a TCP segment to the endpoint's peer.
 func (e *endpoint) sendRaw(data buffer.VectorisedView, flags byte, seq, ack seqnum.Value, rcvWnd seqnum.Size) *tcpip.Error {
+	return e.sendRawECN(data, flags, seq, ack, rcvWnd, false /* ect */)
+}
+
+// sendRawECN is like sendRaw, but also marks the segment ECN capable if ect is
+// true and ECN was negotiated.
+func (e *endpoint) sendRawECN(data buffer.VectorisedView, flags byte, seq, ack seqnum.Value, rcvWnd seqnum.Size, ect bool) *tcpip.Error {
+	tos := e.sendTOS
+	var ae bool
+	if e.ecn != ecnOff {
+		flags, ae = e.rcv.ecnFeedback(flags)
+		if ect {
+			tos |= header.IPECNECT0
+		}
+	}
+
 	var sackBlocks []header.SACKBlock
 	if e.EndpointState() == StateEstablished && e.rcv.pendingRcvdSegments.Len() > 0 && (flags&header.TCPFlagAck != 0) {
 		sackBlocks = e.sack.Blocks[:e.sack.NumBlocks]
 	}
-	options := e.makeOptions(sackBlocks)
+	var seg *SubflowSegment
+	if e.subflow != nil {
+		seg = &SubflowSegment{
+			Flags: flags,
+			SSN:   uint32(seq - e.subflowISS),
+			Len:   data.Size(),
+		}
+	}
+	var md5Key []byte
+	ao := e.aoSign(e.route, e.ID, flags, seq, ack)
+	if ao == nil {
+		md5Key = e.peerMD5Key()
+	}
+	options := e.makeOptions(sackBlocks, seg, md5Key != nil, ao)
 	err := e.sendTCP(e.route, tcpFields{
 		id:     e.ID,
 		ttl:    e.ttl,
-		tos:    e.sendTOS,
+		tos:    tos,
 		flags:  flags,
+		ae:     ae,
 		seq:    seq,
 		ack:    ack,
 		rcvWnd: rcvWnd,
 		opts:   options,
+		md5Key: md5Key,
+		ao:     ao,
 	}, data, e.gso)
 	putOptions(options)
 	return err
@@ -1040,6 +1250,7 @@ func (e *endpoint) transitionToStateEstablishedLocked(h *handshake) {
 	// receive window scaling if the peer doesn't support it
 	// (indicated by a negative send window scale).
 	e.snd = newSender(e, h.iss, h.ackNum-1, h.sndWnd, h.mss, h.sndWndScale)
+	e.applyMetricsLocked()
 
 	e.rcvListMu.Lock()
 	e.rcv = newReceiver(e, h.ackNum-1, h.rcvWnd, h.effectiveRcvWndScale())
@@ -1049,6 +1260,10 @@ func (e *endpoint) transitionToStateEstablishedLocked(h *handshake) {
 	e.rcvAutoParams.prevCopied = int(h.rcvWnd)
 	e.rcvListMu.Unlock()
 
+	e.subflowISS = h.iss
+	e.subflowIRS = h.ackNum - 1
+	e.ecn = h.ecn
+
 	e.setEndpointState(StateEstablished)
 }
 
@@ -1189,10 +1404,8 @@ func (e *endpoint) handleSegments(fastPath bool) *tcpip.Error {
 		e.newSegmentWaker.Assert()
 	}
 
-	// Send an ACK for all processed packets if needed.
-	if e.rcv.rcvNxt != e.snd.maxSentAck {
-		e.snd.sendAck()
-	}
+	// Send or delay an ACK for all processed packets if needed.
+	e.rcv.ackIfNeeded()
 
 	e.resetKeepaliveTimer(true /* receivedData */)
 
@@ -1246,6 +1459,10 @@ func (e *endpoint) handleSegment(s *segment) (cont bool, err *tcpip.Error) {
 		// send window scale.
 		s.window <<= e.snd.sndWndScale
 
+		if !e.handleSubflowSegment(s) {
+			return false, tcpip.ErrConnectionAborted
+		}
+
 		// RFC 793, page 41 states that "once in the ESTABLISHED
 		// state all segments must carry current acknowledgment
 		// information."
@@ -1308,7 +1525,7 @@ func (e *endpoint) keepaliveTimerExpired() *tcpip.Error {
 	// seg.seq = snd.nxt-1.
 	e.keepalive.unacked++
 	e.keepalive.Unlock()
-	e.snd.sendSegmentFromView(buffer.VectorisedView{}, header.TCPFlagAck, e.snd.sndNxt-1)
+	e.snd.sendSegmentFromView(buffer.VectorisedView{}, header.TCPFlagAck, e.snd.sndNxt-1, false /* ect */)
 	e.resetKeepaliveTimer(false)
 	return nil
 }
@@ -1354,6 +1571,11 @@ func (e *endpoint) protocolMainLoop(handshake bool, wakerInitDone chan<- struct{
 		// e.mu is expected to be hold upon entering this section.
 		if e.snd != nil {
 			e.snd.resendTimer.cleanup()
+			e.snd.pacingTimer.cleanup()
+			e.snd.corkTimer.cleanup()
+		}
+		if e.rcv != nil {
+			e.rcv.delAckTimer.cleanup()
 		}
 
 		if closeTimer != nil {
@@ -1433,6 +1655,33 @@ func (e *endpoint) protocolMainLoop(handshake bool, wakerInitDone chan<- struct{
 				return nil
 			},
 		},
+		{
+			w: &e.snd.pacingWaker,
+			f: func() *tcpip.Error {
+				if e.snd.pacingTimer.checkExpiration() {
+					e.snd.sendData()
+				}
+				return nil
+			},
+		},
+		{
+			w: &e.snd.corkWaker,
+			f: func() *tcpip.Error {
+				if e.snd.corkTimer.checkExpiration() {
+					e.snd.sendCorked()
+				}
+				return nil
+			},
+		},
+		{
+			w: &e.rcv.delAckWaker,
+			f: func() *tcpip.Error {
+				if e.rcv.delAckTimer.checkExpiration() && e.rcv.rcvNxt != e.snd.maxSentAck {
+					e.snd.sendAck()
+				}
+				return nil
+			},
+		},
 		{
 			w: &e.newSegmentWaker,
 			f: func() *tcpip.Error {
@@ -1458,6 +1707,10 @@ func (e *endpoint) protocolMainLoop(handshake bool, wakerInitDone chan<- struct{
 					mtu := e.sndMTU
 					e.sndBufMu.Unlock()
 
+					// The MTU of the outgoing NIC may have been lowered.
+					if routeMTU := int(e.route.MTU()); routeMTU < mtu {
+						mtu = routeMTU
+					}
 					e.snd.updateMaxPayloadSize(mtu, count)
 				}
 
@@ -1469,6 +1722,10 @@ func (e *endpoint) protocolMainLoop(handshake bool, wakerInitDone chan<- struct{
 					return tcpip.ErrConnectionReset
 				}
 
+				if n&notifyDeviceRemoved != 0 {
+					return tcpip.ErrUnknownDevice
+				}
+
 				if n&notifyClose != 0 && closeTimer == nil {
 					if e.EndpointState() == StateFinWait2 && e.closed {
 						// The socket has been closed and we are in FIN_WAIT2
@@ -1484,6 +1741,18 @@ func (e *endpoint) protocolMainLoop(handshake bool, wakerInitDone chan<- struct{
 					e.resetKeepaliveTimer(true)
 				}
 
+				if n&notifySubflow != 0 {
+					e.snd.sendAck()
+				}
+
+				if n&notifyTransmitDone != 0 {
+					e.snd.sendData()
+				}
+
+				if n&notifyQuickAck != 0 && e.rcv.rcvNxt != e.snd.maxSentAck {
+					e.snd.sendAck()
+				}
+
 				if n&notifyDrain != 0 {
 					for !e.segmentQueue.empty() {
 						if err := e.handleSegments(false /* fastPath */); err != nil {
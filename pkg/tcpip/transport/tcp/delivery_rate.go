@@ -0,0 +1,249 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp
+
+import (
+	"time"
+)
+
+// deliveryRate holds the state used to estimate the delivery rate of the
+// connection.
+//
+// See: https://tools.ietf.org/html/draft-cheng-iccrg-delivery-rate-estimation-00
+//
+// +stateify savable
+type deliveryRate struct {
+	// delivered is the total number of packets delivered (cumulatively or
+	// selectively acknowledged) on the connection. It is C.delivered in
+	// the draft.
+	delivered int
+
+	// deliveredTime is the time at which delivered was last updated. It
+	// is C.delivered_time in the draft.
+	deliveredTime time.Time `state:".(unixTime)"`
+
+	// firstSentTime is the send time of the packet that was most recently
+	// marked as delivered, or the send time of the first packet sent
+	// after the connection went idle. It is C.first_sent_time in the
+	// draft.
+	firstSentTime time.Time `state:".(unixTime)"`
+
+	// appLimited is the index of the last packet transmitted while the
+	// connection was application-limited, or zero if the connection is
+	// not application-limited. It is C.app_limited in the draft.
+	appLimited int
+
+	// minRTT is the minimum RTT observed in rate samples on the
+	// connection.
+	minRTT time.Duration
+
+	// rateDelivered and rateInterval are the delivered and interval of
+	// the rate sample last reported by TCP_INFO. rateAppLimited is true if
+	// that sample was application-limited. Like Linux, a new sample only
+	// replaces the reported one if it is not application-limited or its
+	// rate is at least as high.
+	rateDelivered  int
+	rateInterval   time.Duration
+	rateAppLimited bool
+
+	// sample is the rate sample being generated for the ACK currently
+	// being processed.
+	sample rateSample `state:"nosave"`
+}
+
+// rateSample is a delivery rate sample generated when an ACK is processed.
+type rateSample struct {
+	// priorDelivered is deliveryRate.delivered at the time the most
+	// recently sent packet acknowledged by this ACK was transmitted.
+	priorDelivered int
+
+	// priorTime is deliveryRate.deliveredTime at the time the most
+	// recently sent packet acknowledged by this ACK was transmitted. It is
+	// zero if this ACK delivered no new packets.
+	priorTime time.Time
+
+	// sendElapsed and ackElapsed are the lengths of the send and ACK
+	// phases of the sampling interval.
+	sendElapsed time.Duration
+	ackElapsed  time.Duration
+
+	// delivered is the number of packets delivered over interval. It is
+	// negative if the sample is invalid.
+	delivered int
+
+	// interval is the length of the sampling interval. It is negative if
+	// the sample is invalid.
+	interval time.Duration
+
+	// rtt is the RTT measured using the most recently sent packet
+	// acknowledged by this ACK that was never retransmitted, or zero if
+	// there is no such packet.
+	rtt time.Duration
+
+	// ackedSacked is the number of packets newly cumulatively or
+	// selectively acknowledged by this ACK.
+	ackedSacked int
+
+	// priorInFlight is the number of outstanding packets before this ACK
+	// was processed.
+	priorInFlight int
+
+	// isAppLimited is true if the most recently sent packet acknowledged
+	// by this ACK was transmitted while the connection was
+	// application-limited.
+	isAppLimited bool
+}
+
+// rateSampleHandler is implemented by congestion control algorithms which use
+// delivery rate samples, e.g. BBR. The sender invokes HandleRateSample for
+// every ACK, after the ACK has been applied to the send state.
+type rateSampleHandler interface {
+	// HandleRateSample is invoked with the rate sample generated from the
+	// most recent ACK. The sample may be invalid, as indicated by a
+	// negative interval.
+	HandleRateSample(rs *rateSample)
+}
+
+// isValid returns true if the sample can be used to compute a delivery rate.
+func (rs *rateSample) isValid() bool {
+	return rs.delivered > 0 && rs.interval > 0
+}
+
+// startRateSample resets the rate sample before an ACK is processed.
+func (s *sender) startRateSample() {
+	s.dr.sample = rateSample{priorInFlight: s.outstanding}
+}
+
+// onSegmentSent snapshots the delivery rate state into seg as it is about to be
+// (re)transmitted.
+func (s *sender) onSegmentSent(seg *segment, now time.Time) {
+	dr := &s.dr
+	// If there are no packets in flight, start a new sampling interval
+	// from now rather than from the last delivery.
+	if s.outstanding == 0 {
+		dr.firstSentTime = now
+		dr.deliveredTime = now
+	}
+	seg.txDelivered = dr.delivered
+	seg.txDeliveredTime = dr.deliveredTime
+	seg.txFirstSentTime = dr.firstSentTime
+	seg.txAppLimited = dr.appLimited != 0
+}
+
+// onPacketsDelivered accounts for count packets delivered that do not
+// complete a segment, e.g. due to a partial cumulative ACK of a GSO segment.
+func (s *sender) onPacketsDelivered(count int) {
+	if count <= 0 {
+		return
+	}
+	s.dr.delivered += count
+	s.dr.deliveredTime = time.Now()
+	s.dr.sample.ackedSacked += count
+}
+
+// onSegmentDelivered updates the delivery rate state when seg is cumulatively
+// or selectively acknowledged for the first time.
+func (s *sender) onSegmentDelivered(seg *segment) {
+	if seg.xmitCount == 0 {
+		return
+	}
+	dr := &s.dr
+	rs := &dr.sample
+	s.onPacketsDelivered(s.pCount(seg))
+
+	// Use the most recently sent packet to generate the sample, as it
+	// covers the shortest interval.
+	if rs.priorTime.IsZero() || seg.txDelivered > rs.priorDelivered {
+		rs.priorDelivered = seg.txDelivered
+		rs.priorTime = seg.txDeliveredTime
+		rs.isAppLimited = seg.txAppLimited
+		rs.sendElapsed = seg.xmitTime.Sub(seg.txFirstSentTime)
+		dr.firstSentTime = seg.xmitTime
+	}
+
+	// Never take RTT samples from retransmitted segments, as the ACK is
+	// ambiguous.
+	if seg.xmitCount == 1 {
+		rs.rtt = dr.deliveredTime.Sub(seg.xmitTime)
+	}
+}
+
+// generateRateSample completes the rate sample for the ACK being processed.
+func (s *sender) generateRateSample() *rateSample {
+	dr := &s.dr
+	rs := &dr.sample
+
+	// Clear the application-limited mark once all packets sent while
+	// application-limited have been delivered.
+	if dr.appLimited != 0 && dr.delivered > dr.appLimited {
+		dr.appLimited = 0
+	}
+
+	if rs.rtt > 0 && (dr.minRTT == 0 || rs.rtt < dr.minRTT) {
+		dr.minRTT = rs.rtt
+	}
+
+	if rs.priorTime.IsZero() {
+		rs.delivered = -1
+		rs.interval = -1
+		return rs
+	}
+	rs.delivered = dr.delivered - rs.priorDelivered
+	rs.ackElapsed = dr.deliveredTime.Sub(rs.priorTime)
+
+	// Use the longer of the send and ACK phases so that ACK compression
+	// can't inflate the rate.
+	rs.interval = rs.sendElapsed
+	if rs.ackElapsed > rs.interval {
+		rs.interval = rs.ackElapsed
+	}
+
+	// An interval shorter than the minimum RTT implies the rate is
+	// overestimated, e.g. because a spuriously retransmitted segment was
+	// acknowledged.
+	if rs.interval < dr.minRTT {
+		rs.interval = -1
+		return rs
+	}
+
+	if rs.isValid() && (!rs.isAppLimited || int64(rs.delivered)*int64(dr.rateInterval) >= int64(dr.rateDelivered)*int64(rs.interval)) {
+		dr.rateDelivered = rs.delivered
+		dr.rateInterval = rs.interval
+		dr.rateAppLimited = rs.isAppLimited
+	}
+	return rs
+}
+
+// deliveryRateBytes returns the delivery rate in bytes per second of the rate
+// sample last reported by TCP_INFO.
+func (s *sender) deliveryRateBytes() uint64 {
+	dr := &s.dr
+	if dr.rateInterval <= 0 || dr.rateDelivered <= 0 {
+		return 0
+	}
+	return uint64(dr.rateDelivered) * uint64(s.maxPayloadSize) * uint64(time.Second) / uint64(dr.rateInterval)
+}
+
+// checkAppLimited marks the connection as application-limited if there is no
+// more data to send and the congestion window is not full.
+func (s *sender) checkAppLimited() {
+	if s.writeNext != nil || s.outstanding >= s.sndCwnd {
+		return
+	}
+	s.dr.appLimited = s.dr.delivered + s.outstanding
+	if s.dr.appLimited == 0 {
+		s.dr.appLimited = 1
+	}
+}
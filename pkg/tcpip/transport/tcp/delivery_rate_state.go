@@ -0,0 +1,39 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp
+
+import (
+	"time"
+)
+
+// saveDeliveredTime is invoked by stateify.
+func (dr *deliveryRate) saveDeliveredTime() unixTime {
+	return unixTime{dr.deliveredTime.Unix(), dr.deliveredTime.UnixNano()}
+}
+
+// loadDeliveredTime is invoked by stateify.
+func (dr *deliveryRate) loadDeliveredTime(unix unixTime) {
+	dr.deliveredTime = time.Unix(unix.second, unix.nano)
+}
+
+// saveFirstSentTime is invoked by stateify.
+func (dr *deliveryRate) saveFirstSentTime() unixTime {
+	return unixTime{dr.firstSentTime.Unix(), dr.firstSentTime.UnixNano()}
+}
+
+// loadFirstSentTime is invoked by stateify.
+func (dr *deliveryRate) loadFirstSentTime(unix unixTime) {
+	dr.firstSentTime = time.Unix(unix.second, unix.nano)
+}
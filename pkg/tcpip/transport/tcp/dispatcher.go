@@ -116,14 +116,7 @@ func (p *processor) start(wg *sync.WaitGroup) {
 			if ep.EndpointState() == StateEstablished && ep.mu.TryLock() {
 				// If the endpoint is in a connected state then we do direct delivery
 				// to ensure low latency and avoid scheduler interactions.
-				switch err := ep.handleSegments(true /* fastPath */); {
-				case err != nil:
-					// Send any active resets if required.
-					ep.resetConnectionLocked(err)
-					fallthrough
-				case ep.EndpointState() == StateClose:
-					ep.notifyProtocolGoroutine(notifyTickleWorker)
-				case !ep.segmentQueue.empty():
+				if handleConnectedSegments(ep) {
 					p.epQ.enqueue(ep)
 				}
 				ep.mu.Unlock()
@@ -134,6 +127,24 @@ func (p *processor) start(wg *sync.WaitGroup) {
 	}
 }
 
+// handleConnectedSegments processes the segments queued to the connected
+// endpoint ep. It returns true if segments remain to be processed.
+//
+// Precondition: ep.mu must be held.
+func handleConnectedSegments(ep *endpoint) bool {
+	switch err := ep.handleSegments(true /* fastPath */); {
+	case err != nil:
+		// Send any active resets if required.
+		ep.resetConnectionLocked(err)
+		fallthrough
+	case ep.EndpointState() == StateClose:
+		ep.notifyProtocolGoroutine(notifyTickleWorker)
+	case !ep.segmentQueue.empty():
+		return true
+	}
+	return false
+}
+
 // dispatcher manages a pool of TCP endpoint processors which are responsible
 // for the processing of inbound segments. This fixed pool of processor
 // goroutines do full tcp processing. The processor is selected based on the
@@ -192,6 +203,11 @@ func (d *dispatcher) queuePacket(stackEP stack.TransportEndpoint, id stack.Trans
 		return
 	}
 
+	if !ep.checkAO(s) || !ep.checkMD5(s) {
+		s.decRef()
+		return
+	}
+
 	ep.stack.Stats().TCP.ValidSegmentsReceived.Increment()
 	ep.stats.SegmentsReceived.Increment()
 	if (s.flags & header.TCPFlagRst) != 0 {
@@ -210,6 +226,16 @@ func (d *dispatcher) queuePacket(stackEP stack.TransportEndpoint, id stack.Trans
 		return
 	}
 
+	// Packets looped back on the goroutine of their sender are processed
+	// right away if possible, as done by the processors.
+	if pkt.ProcessInline && ep.mu.TryLock() {
+		pending := handleConnectedSegments(ep)
+		ep.mu.Unlock()
+		if !pending {
+			return
+		}
+	}
+
 	d.selectProcessor(id).queueEndpoint(ep)
 }
 
@@ -0,0 +1,153 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/loopback"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const loopbackAddr = tcpip.Address("\x7f\x00\x00\x01")
+
+// connectLoopback returns the two endpoints of a connection established over
+// a loopback NIC, and the wait queue of the accepted one.
+func connectLoopback(t *testing.T) (*endpoint, *endpoint, *waiter.Queue) {
+	t.Helper()
+
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{NewProtocol},
+	})
+	t.Cleanup(s.Close)
+	if err := s.CreateNIC(1, loopback.New()); err != nil {
+		t.Fatalf("CreateNIC(1, _): %s", err)
+	}
+	if err := s.AddAddress(1, ipv4.ProtocolNumber, loopbackAddr); err != nil {
+		t.Fatalf("AddAddress(1, %d, %s): %s", ipv4.ProtocolNumber, loopbackAddr, err)
+	}
+	s.SetRouteTable([]tcpip.Route{{Destination: header.IPv4EmptySubnet, NIC: 1}})
+
+	var listenerWQ waiter.Queue
+	listener, err := s.NewEndpoint(ProtocolNumber, ipv4.ProtocolNumber, &listenerWQ)
+	if err != nil {
+		t.Fatalf("NewEndpoint(%d, %d, _): %s", ProtocolNumber, ipv4.ProtocolNumber, err)
+	}
+	t.Cleanup(listener.Close)
+	if err := listener.Bind(tcpip.FullAddress{Addr: loopbackAddr}); err != nil {
+		t.Fatalf("Bind: %s", err)
+	}
+	if err := listener.Listen(1); err != nil {
+		t.Fatalf("Listen(1): %s", err)
+	}
+	addr, err := listener.GetLocalAddress()
+	if err != nil {
+		t.Fatalf("GetLocalAddress(): %s", err)
+	}
+
+	var clientWQ waiter.Queue
+	client, err := s.NewEndpoint(ProtocolNumber, ipv4.ProtocolNumber, &clientWQ)
+	if err != nil {
+		t.Fatalf("NewEndpoint(%d, %d, _): %s", ProtocolNumber, ipv4.ProtocolNumber, err)
+	}
+	t.Cleanup(client.Close)
+
+	we, ch := waiter.NewChannelEntry(nil)
+	listenerWQ.EventRegister(&we, waiter.EventIn)
+	defer listenerWQ.EventUnregister(&we)
+	if err := client.Connect(addr); err != nil && err != tcpip.ErrConnectStarted {
+		t.Fatalf("Connect(%#v): %s", addr, err)
+	}
+
+	server, serverWQ, err := listener.Accept(nil)
+	if err == tcpip.ErrWouldBlock {
+		select {
+		case <-ch:
+			server, serverWQ, err = listener.Accept(nil)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the connection")
+		}
+	}
+	if err != nil {
+		t.Fatalf("Accept(nil): %s", err)
+	}
+	t.Cleanup(server.Close)
+
+	return client.(*endpoint), server.(*endpoint), serverWQ
+}
+
+func TestLoopbackSegmentsProcessedInline(t *testing.T) {
+	client, server, _ := connectLoopback(t)
+
+	data := []byte{1, 2, 3, 4}
+	if _, _, err := client.Write(tcpip.SlicePayload(data), tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write(_, _): %s", err)
+	}
+
+	// The segment was processed by the writer, so the data is received
+	// when Write returns.
+	if !server.segmentQueue.empty() {
+		t.Error("got the segment queued, want it processed")
+	}
+	v, _, err := server.Read(nil)
+	if err != nil {
+		t.Fatalf("Read(nil): %s", err)
+	}
+	if !bytes.Equal(v, data) {
+		t.Errorf("got Read(nil) = %x, want = %x", v, data)
+	}
+}
+
+func TestLoopbackSegmentsQueuedWhenLocked(t *testing.T) {
+	client, server, serverWQ := connectLoopback(t)
+
+	we, ch := waiter.NewChannelEntry(nil)
+	serverWQ.EventRegister(&we, waiter.EventIn)
+	defer serverWQ.EventUnregister(&we)
+
+	// The segment can't be processed by the writer while the receiver is
+	// locked, so it must be queued.
+	data := []byte{1, 2, 3, 4}
+	server.LockUser()
+	if _, _, err := client.Write(tcpip.SlicePayload(data), tcpip.WriteOptions{}); err != nil {
+		server.UnlockUser()
+		t.Fatalf("Write(_, _): %s", err)
+	}
+	queued := !server.segmentQueue.empty()
+	server.UnlockUser()
+	if !queued {
+		t.Fatal("got the segment processed, want it queued")
+	}
+
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the data")
+	}
+	v, _, err := server.Read(nil)
+	if err != nil {
+		t.Fatalf("Read(nil): %s", err)
+	}
+	if !bytes.Equal(v, data) {
+		t.Errorf("got Read(nil) = %x, want = %x", v, data)
+	}
+}
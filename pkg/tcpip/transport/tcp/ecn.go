@@ -0,0 +1,228 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp
+
+import (
+	"fmt"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// ecnState is the flavour of Explicit Congestion Notification negotiated for
+// a connection.
+type ecnState uint8
+
+const (
+	// ecnOff indicates that ECN wasn't negotiated.
+	ecnOff ecnState = iota
+
+	// ecnClassic indicates that ECN was negotiated as per RFC 3168.
+	ecnClassic
+
+	// ecnAccurate indicates that Accurate ECN was negotiated as per
+	// RFC 9768.
+	ecnAccurate
+)
+
+// ecnFlags are the TCP flags used to negotiate and signal ECN.
+const ecnFlags = header.TCPFlagEce | header.TCPFlagCwr
+
+// accECNInitialCEP is the initial value of the counters of CE marked packets
+// kept by Accurate ECN senders and receivers. See RFC 9768 section 3.2.1.
+const accECNInitialCEP = 5
+
+// ace returns the 3-bit ACE field of an Accurate ECN segment, made of the AE,
+// CWR and ECE flags.
+func (s *segment) ace() uint8 {
+	ace := (s.flags & ecnFlags) >> 6
+	if s.ae {
+		ace |= 4
+	}
+	return ace
+}
+
+// aceFlags returns the flags and AE flag which encode the given ACE field.
+func aceFlags(ace uint8) (flags uint8, ae bool) {
+	return (ace & 3) << 6, ace&4 != 0
+}
+
+// accECNHandshakeACE returns the ACE field which reflects the ECN codepoint of
+// a SYN in the SYN-ACK, or of a SYN-ACK in the final ACK of the handshake. See
+// RFC 9768 section 3.1.2 and 3.2.2.1.
+func accECNHandshakeACE(ecn uint8) uint8 {
+	switch ecn {
+	case header.IPECNECT1:
+		return 0b011
+	case header.IPECNECT0:
+		return 0b100
+	case header.IPECNCE:
+		return 0b110
+	default:
+		return 0b010
+	}
+}
+
+// ecnOption returns the ECN setting in effect for the endpoint.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) ecnOption() tcpip.TCPECNOption {
+	if e.ecnOpt != tcpip.TCPECNDefault {
+		return e.ecnOpt
+	}
+	var opt tcpip.TCPECNOption
+	if err := e.stack.TransportProtocolOption(ProtocolNumber, &opt); err != nil {
+		panic(fmt.Sprintf("e.stack.TransportProtocolOption(%d, %+v) = %v", ProtocolNumber, &opt, err))
+	}
+	return opt
+}
+
+// requestECN returns the flags and AE flag of a SYN requesting the flavour of
+// ECN configured for the endpoint.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) requestECN() (flags uint8, ae bool) {
+	switch e.ecnOption() {
+	case tcpip.TCPECNEnabled:
+		return ecnFlags, false
+	case tcpip.TCPECNAccurate:
+		return ecnFlags, true
+	default:
+		return 0, false
+	}
+}
+
+// acceptECN returns the flavour of ECN negotiated by a SYN-ACK sent in response
+// to syn, along with the SYN-ACK flags and AE flag which convey it.
+func acceptECN(opt tcpip.TCPECNOption, syn *segment) (state ecnState, flags uint8, ae bool) {
+	if opt == tcpip.TCPECNDisabled || !syn.flagsAreSet(ecnFlags) {
+		return ecnOff, 0, false
+	}
+	if syn.ae && opt == tcpip.TCPECNAccurate {
+		flags, ae := aceFlags(accECNHandshakeACE(syn.ecn))
+		return ecnAccurate, flags, ae
+	}
+	// Peers which don't support Accurate ECN, or which don't want to use
+	// it, respond with ECN as per RFC 3168.
+	return ecnClassic, header.TCPFlagEce, false
+}
+
+// connectECN returns the flavour of ECN negotiated by synAck in response to a
+// SYN sent with the given flags and AE flag.
+func connectECN(synFlags uint8, synAE bool, synAck *segment) ecnState {
+	if synFlags&ecnFlags != ecnFlags {
+		return ecnOff
+	}
+	if synAE {
+		// See RFC 9768 section 3.1.2, Table 2. Reserved combinations
+		// of flags also indicate Accurate ECN.
+		switch synAck.ace() {
+		case 0b000:
+			return ecnOff
+		case 0b001:
+			return ecnClassic
+		default:
+			return ecnAccurate
+		}
+	}
+	if synAck.flags&ecnFlags == header.TCPFlagEce {
+		return ecnClassic
+	}
+	return ecnOff
+}
+
+// handleECN accounts for the ECN codepoint of a received segment.
+func (r *receiver) handleECN(s *segment) {
+	if s.ecn != header.IPECNNotECT {
+		r.ecnSeen = true
+	}
+	switch r.ep.ecn {
+	case ecnClassic:
+		// As per RFC 3168 section 6.1.3, ECN-Echo is set on every ACK
+		// from the receipt of a CE packet until that of a CWR packet.
+		if s.flagIsSet(header.TCPFlagCwr) {
+			r.ecnEcho = false
+		}
+		if s.ecn == header.IPECNCE {
+			r.ecnEcho = true
+		}
+	case ecnAccurate:
+		if s.ecn == header.IPECNCE {
+			r.ecnCEP++
+		}
+	}
+}
+
+// ecnFeedback returns the flags and AE flag of an outgoing segment with the
+// given flags, with the ECN feedback for the peer added.
+func (r *receiver) ecnFeedback(flags uint8) (uint8, bool) {
+	if flags&header.TCPFlagAck == 0 || flags&header.TCPFlagRst != 0 {
+		return flags, false
+	}
+	switch r.ep.ecn {
+	case ecnClassic:
+		if r.ecnEcho {
+			flags |= header.TCPFlagEce
+		}
+		return flags, false
+	case ecnAccurate:
+		ace := uint8(r.ecnCEP & 7)
+		if r.ecnHandshakeACE != 0 {
+			ace = r.ecnHandshakeACE
+			r.ecnHandshakeACE = 0
+		}
+		aceFl, ae := aceFlags(ace)
+		return flags&^ecnFlags | aceFl, ae
+	default:
+		return flags, false
+	}
+}
+
+// handleECN handles the ECN feedback of an ACK which acknowledged delivered
+// new packets, reducing the congestion window at most once per window of data
+// in response to congestion as per RFC 3168 section 6.1.2.
+func (s *sender) handleECN(rcvdSeg *segment, delivered int) {
+	var congested bool
+	switch s.ep.ecn {
+	case ecnClassic:
+		congested = rcvdSeg.flagIsSet(header.TCPFlagEce)
+		if congested {
+			s.deliveredCE += uint32(delivered)
+		}
+	case ecnAccurate:
+		// The ACE field counts CE marked packets modulo 8. See RFC 9768
+		// section 3.2.2.5.
+		ce := (rcvdSeg.ace() - uint8(s.ecnCEP)) & 7
+		s.ecnCEP += uint32(ce)
+		s.deliveredCE += uint32(ce)
+		congested = ce > 0
+	}
+	if !congested || s.fr.active || s.state == RTORecovery || rcvdSeg.ackNumber.LessThan(s.ecnRecover) {
+		return
+	}
+
+	s.cc.HandleNDupAcks()
+	s.sndCwnd = s.sndSsthresh
+	s.ecnRecover = s.sndNxt
+	// Only RFC 3168 receivers wait for CWR to stop echoing congestion.
+	s.ecnCWRPending = s.ep.ecn == ecnClassic
+	s.ep.stack.Stats().TCP.ECNCongestionResponses.Increment()
+}
+
+// inCWR returns true if the sender is reducing its congestion window in
+// response to ECN feedback.
+func (s *sender) inCWR() bool {
+	return s.sndUna.LessThan(s.ecnRecover)
+}
@@ -175,6 +175,18 @@ const (
 	// say TIME_WAIT.
 	notifyTickleWorker
 	notifyError
+	// notifySubflow is a request from a subflow handler to send an ACK
+	// carrying its current options.
+	notifySubflow
+	// notifyTransmitDone is sent when queued data left the stack while
+	// sending was throttled.
+	notifyTransmitDone
+	// notifyQuickAck is sent when TCP_QUICKACK is enabled so that a
+	// delayed acknowledgment is sent right away.
+	notifyQuickAck
+	// notifyDeviceRemoved is sent when the NIC the endpoint is bound to is
+	// removed from the stack.
+	notifyDeviceRemoved
 )
 
 // SACKInfo holds TCP SACK related information for a given endpoint.
@@ -245,6 +257,18 @@ type ReceiveErrors struct {
 	// in the handshake was dropped due to overflow.
 	ListenOverflowAckDrop tcpip.StatCounter
 
+	// ListenOverflowSynCookieSent is the number of times a SYN cookie was
+	// sent.
+	ListenOverflowSynCookieSent tcpip.StatCounter
+
+	// ListenOverflowSynCookieRcvd is the number of times a valid SYN cookie
+	// was received.
+	ListenOverflowSynCookieRcvd tcpip.StatCounter
+
+	// ListenOverflowInvalidSynCookieRcvd is the number of times an invalid
+	// SYN cookie was received.
+	ListenOverflowInvalidSynCookieRcvd tcpip.StatCounter
+
 	// ZeroRcvWindowState is the number of times we advertised
 	// a zero receive window when rcvList is full.
 	ZeroRcvWindowState tcpip.StatCounter
@@ -278,6 +302,25 @@ type SendErrors struct {
 	Timeouts tcpip.StatCounter
 }
 
+// OutOfOrderStats collect statistics about the out-of-order queue of the
+// endpoint.
+type OutOfOrderStats struct {
+	// Queued is the number of out-of-order segments queued.
+	Queued tcpip.StatCounter
+
+	// Dropped is the number of out-of-order segments dropped because the
+	// out-of-order queue was full.
+	Dropped tcpip.StatCounter
+
+	// Pruned is the number of queued out-of-order segments removed to
+	// make room for a segment closer to the left edge of the window.
+	Pruned tcpip.StatCounter
+
+	// MaxDepth is the largest number of segments held in the out-of-order
+	// queue at once.
+	MaxDepth tcpip.StatCounter
+}
+
 // Stats holds statistics about the endpoint.
 type Stats struct {
 	// SegmentsReceived is the number of TCP segments received that
@@ -303,6 +346,9 @@ type Stats struct {
 
 	// WriteErrors collects segment write errors from an endpoint write call.
 	WriteErrors tcpip.WriteErrors
+
+	// OutOfOrder collects statistics about the out-of-order queue.
+	OutOfOrder OutOfOrderStats
 }
 
 // IsEndpointStats is an empty method to implement the tcpip.EndpointStats
@@ -522,6 +568,36 @@ type endpoint struct {
 	// in SYN-RCVD state.
 	synRcvdCount int
 
+	// maxSynRcvd if non-zero bounds synRcvdCount for a listening endpoint.
+	// Once reached, SYNs are handled according to the endpoint's SYN cookie
+	// mode.
+	maxSynRcvd int
+
+	// synCookies is the SYN cookie mode of a listening endpoint. If
+	// tcpip.TCPSynCookiesDefault, the stack-wide mode is used.
+	synCookies tcpip.TCPSynCookiesOption
+
+	// ecnOpt is the ECN setting of the endpoint. If tcpip.TCPECNDefault,
+	// the stack-wide setting is used.
+	ecnOpt tcpip.TCPECNOption
+
+	// ecn is the flavour of ECN negotiated for the connection.
+	ecn ecnState
+
+	// autocork and limitOutputBytes are the stack-wide auto-corking and
+	// TCP small queue settings at the time the endpoint was created.
+	autocork         bool
+	limitOutputBytes int
+
+	// queuedBytes is the number of bytes of data segments which were
+	// written to a route but haven't left the stack yet, e.g. because they
+	// are held by a queueing discipline. It is accessed atomically.
+	queuedBytes int64 `state:"nosave"`
+
+	// queueThrottled is set if sending stopped until queuedBytes decreases.
+	// It is accessed atomically.
+	queueThrottled uint32 `state:"nosave"`
+
 	// userMSS if non-zero is the MSS value explicitly set by the user
 	// for this endpoint using the TCP_MAXSEG setsockopt.
 	userMSS uint16
@@ -537,6 +613,19 @@ type endpoint struct {
 	// this value.
 	windowClamp uint32
 
+	// repair is set while the endpoint is in repair mode. An endpoint in
+	// repair mode sends no segments.
+	repair bool
+
+	// repairQueue is the queue selected by tcpip.TCPRepairQueueOption.
+	repairQueue int
+
+	// repairSndSeq and repairRcvSeq are the sequence numbers of the send
+	// and receive queues set by tcpip.TCPQueueSeqOption before an endpoint
+	// in repair mode is connected.
+	repairSndSeq seqnum.Value
+	repairRcvSeq seqnum.Value
+
 	// The following fields are used to manage the send buffer. When
 	// segments are ready to be sent, they are added to sndQueue and the
 	// protocol goroutine is signaled via sndWaker.
@@ -552,6 +641,44 @@ type endpoint struct {
 	sndWaker      sleep.Waker `state:"manual"`
 	sndCloseWaker sleep.Waker `state:"manual"`
 
+	// sndBufQueued and sndBufAcked are the total number of bytes ever added
+	// to and released from the send buffer. They are protected by sndBufMu.
+	sndBufQueued uint64
+	sndBufAcked  uint64
+
+	// sndBufInFlight is the number of bytes of the send buffer which were
+	// sent but not yet acknowledged. It is protected by sndBufMu.
+	sndBufInFlight int
+
+	// notSentLowat is the TCP_NOTSENT_LOWAT threshold set on the endpoint,
+	// or zero to use stackNotSentLowat, the stack-wide threshold at the
+	// time the endpoint was created. They are protected by sndBufMu.
+	notSentLowat      uint32
+	stackNotSentLowat uint32
+
+	// zeroCopySends holds the MSG_ZEROCOPY sends whose payload is referenced
+	// by the send queue, in the order they were written. It is protected by
+	// sndBufMu.
+	//
+	// Pending completion notifications are lost across save/restore.
+	zeroCopySends []zeroCopySend `state:"nosave"`
+
+	// txTimestamps holds the sends for which SO_TIMESTAMPING transmit
+	// timestamps were requested, in the order they were written. It is
+	// protected by sndBufMu.
+	//
+	// Pending timestamps are lost across save/restore.
+	txTimestamps []txTimestamp `state:"nosave"`
+
+	// md5Mu protects md5Keys, the TCP MD5 signature keys of the endpoint.
+	md5Mu   sync.Mutex `state:"nosave"`
+	md5Keys []md5Key
+
+	// aoMu protects ao, the TCP Authentication Option state of the
+	// endpoint.
+	aoMu sync.Mutex `state:"nosave"`
+	ao   aoInfo
+
 	// cc stores the name of the Congestion Control algorithm to use for
 	// this endpoint.
 	cc tcpip.CongestionControlOption
@@ -652,6 +779,12 @@ type endpoint struct {
 	// applied while sending packets. Defaults to 0 as on Linux.
 	sendTOS uint8
 
+	// pmtud is the path MTU discovery setting, one of the
+	// tcpip.PMTUDiscovery* values.
+	//
+	// Must be accessed using atomic operations.
+	pmtud int32
+
 	gso *stack.GSO
 
 	// TODO(b/142022063): Add ability to save and restore per endpoint stats.
@@ -679,6 +812,20 @@ type endpoint struct {
 
 	// ops is used to get socket level options.
 	ops tcpip.SocketOptions
+
+	// subflow is the handler of the options of a Multipath TCP subflow, if
+	// the endpoint is one. It is protected by mu.
+	subflow SubflowHandler `state:"nosave"`
+
+	// subflowListen is invoked with the options of SYNs received by a
+	// listening endpoint to create subflow handlers. It is protected by mu.
+	subflowListen SubflowListenHandler `state:"nosave"`
+
+	// subflowISS and subflowIRS are the initial send and receive sequence
+	// numbers of the connection, which subflow sequence numbers are
+	// relative to. They are protected by mu.
+	subflowISS seqnum.Value `state:"nosave"`
+	subflowIRS seqnum.Value `state:"nosave"`
 }
 
 // UniqueID implements stack.TransportEndpoint.UniqueID.
@@ -861,6 +1008,7 @@ func newEndpoint(s *stack.Stack, netProto tcpip.NetworkProtocolNumber, waiterQue
 		rcvBufSize:  DefaultReceiveBufferSize,
 		sndBufSize:  DefaultSendBufferSize,
 		sndMTU:      int(math.MaxInt32),
+		pmtud:       int32(tcpip.PMTUDiscoveryDont),
 		keepalive: keepalive{
 			// Linux defaults.
 			idle:     2 * time.Hour,
@@ -871,6 +1019,10 @@ func newEndpoint(s *stack.Stack, netProto tcpip.NetworkProtocolNumber, waiterQue
 		txHash:        s.Rand().Uint32(),
 		windowClamp:   DefaultReceiveBufferSize,
 		maxSynRetries: DefaultSynRetries,
+
+		autocork:          true,
+		limitOutputBytes:  DefaultLimitOutputBytes,
+		stackNotSentLowat: DefaultNotSentLowat,
 	}
 	e.ops.InitHandler(e)
 	e.ops.SetMulticastLoop(true)
@@ -911,6 +1063,21 @@ func newEndpoint(s *stack.Stack, netProto tcpip.NetworkProtocolNumber, waiterQue
 		e.maxSynRetries = uint8(synRetries)
 	}
 
+	var autocork tcpip.TCPAutocorkOption
+	if err := s.TransportProtocolOption(ProtocolNumber, &autocork); err == nil {
+		e.autocork = bool(autocork)
+	}
+
+	var lob tcpip.TCPLimitOutputBytesOption
+	if err := s.TransportProtocolOption(ProtocolNumber, &lob); err == nil {
+		e.limitOutputBytes = int(lob)
+	}
+
+	var lowat tcpip.TCPNotSentLowatOption
+	if err := s.TransportProtocolOption(ProtocolNumber, &lowat); err == nil {
+		e.stackNotSentLowat = uint32(lowat)
+	}
+
 	if p := s.GetTCPProbe(); p != nil {
 		e.probe = p
 	}
@@ -955,7 +1122,7 @@ func (e *endpoint) Readiness(mask waiter.EventMask) waiter.EventMask {
 		// Determine if the endpoint is writable if requested.
 		if (mask & waiter.EventOut) != 0 {
 			e.sndBufMu.Lock()
-			if e.sndClosed || e.sndBufUsed < e.sndBufSize {
+			if e.sndClosed || e.sndBufUsed < e.sndBufSize && e.notSentLocked() < e.notSentLowatLocked() {
 				result |= waiter.EventOut
 			}
 			e.sndBufMu.Unlock()
@@ -971,6 +1138,10 @@ func (e *endpoint) Readiness(mask waiter.EventMask) waiter.EventMask {
 		}
 	}
 
+	if e.ops.PeekErr() != nil {
+		result |= waiter.EventErr
+	}
+
 	return result
 }
 
@@ -1040,7 +1211,9 @@ func (e *endpoint) Close() {
 		return
 	}
 
-	if e.linger.Enabled && e.linger.Timeout == 0 {
+	// A connection being repaired is discarded without notifying the peer,
+	// as its RST isn't sent.
+	if e.repair || (e.linger.Enabled && e.linger.Timeout == 0) {
 		s := e.EndpointState()
 		isResetState := s == StateEstablished || s == StateCloseWait || s == StateFinWait1 || s == StateFinWait2 || s == StateSynRecv
 		if isResetState {
@@ -1165,6 +1338,15 @@ func (e *endpoint) cleanupLocked() {
 		e.route = nil
 	}
 
+	// Release the payload of MSG_ZEROCOPY sends that will never be
+	// acknowledged.
+	e.sndBufMu.Lock()
+	zeroCopySends := e.zeroCopySends
+	e.zeroCopySends = nil
+	e.txTimestamps = nil
+	e.sndBufMu.Unlock()
+	e.completeZeroCopySends(zeroCopySends)
+
 	e.stack.CompleteTransportEndpointCleanup(e)
 	tcpip.DeleteDanglingEndpoint(e)
 }
@@ -1310,6 +1492,11 @@ func (e *endpoint) Read(*tcpip.FullAddress) (buffer.View, tcpip.ControlMessages,
 	e.LockUser()
 	defer e.UnlockUser()
 
+	// The queues of a connection being repaired may only be peeked.
+	if e.repair {
+		return buffer.View{}, tcpip.ControlMessages{}, tcpip.ErrNotPermitted
+	}
+
 	// When in SYN-SENT state, let the caller block on the receive.
 	// An application can initiate a non-blocking connect and then block
 	// on a receive. It can expect to read any data after the handshake
@@ -1410,16 +1597,50 @@ func (e *endpoint) isEndpointWritableLocked() (int, *tcpip.Error) {
 
 	avail := e.sndBufSize - e.sndBufUsed
 	if avail <= 0 {
+		// The sender is now limited by the send buffer until some of
+		// its contents are acknowledged.
+		if e.snd != nil {
+			e.snd.startChrono(chronoSndBufLimited)
+		}
 		return 0, tcpip.ErrWouldBlock
 	}
+
+	// Don't queue more data than allowed by TCP_NOTSENT_LOWAT.
+	if room := e.notSentLowatLocked() - e.notSentLocked(); room < avail {
+		if room <= 0 {
+			return 0, tcpip.ErrWouldBlock
+		}
+		avail = room
+	}
 	return avail, nil
 }
 
+// notSentLocked returns the number of bytes written to the endpoint which
+// weren't sent yet.
+//
+// Precondition: e.sndBufMu must be held.
+func (e *endpoint) notSentLocked() int {
+	if notSent := e.sndBufUsed - e.sndBufInFlight; notSent > 0 {
+		return notSent
+	}
+	return 0
+}
+
+// notSentLowatLocked returns the TCP_NOTSENT_LOWAT threshold in effect for the
+// endpoint.
+//
+// Precondition: e.sndBufMu must be held.
+func (e *endpoint) notSentLowatLocked() int {
+	if e.notSentLowat != 0 {
+		return int(e.notSentLowat)
+	}
+	return int(e.stackNotSentLowat)
+}
+
 // Write writes data to the endpoint's peer.
 func (e *endpoint) Write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, <-chan struct{}, *tcpip.Error) {
 	// Linux completely ignores any address passed to sendto(2) for TCP sockets
-	// (without the MSG_FASTOPEN flag). Corking is unimplemented, so opts.More
-	// and opts.EndOfRecord are also ignored.
+	// (without the MSG_FASTOPEN flag). opts.EndOfRecord is also ignored.
 
 	e.LockUser()
 	e.sndBufMu.Lock()
@@ -1432,6 +1653,23 @@ func (e *endpoint) Write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, <-c
 		return 0, nil, err
 	}
 
+	// Data written to the receive queue of a connection being repaired
+	// is received, and data written to its send queue is queued as usual.
+	if e.repair && e.repairQueue != tcpip.TCPSendQueue {
+		e.sndBufMu.Unlock()
+		defer e.UnlockUser()
+		if e.repairQueue == tcpip.TCPNoQueue {
+			return 0, nil, tcpip.ErrInvalidOptionValue
+		}
+		n, err := e.writeRecvQueueLocked(p)
+		return n, nil, err
+	}
+
+	// The payload of MSG_ZEROCOPY sends is borrowed from the sender when the
+	// route allows it, and held until it is acknowledged.
+	_, zeroCopy := p.(tcpip.ZeroCopyPayloader)
+	borrow := zeroCopy && e.route.HasZeroCopyTXCapability()
+
 	// We can release locks while copying data.
 	//
 	// This is not possible if atomic is set, because we can't allow the
@@ -1443,7 +1681,7 @@ func (e *endpoint) Write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, <-c
 	}
 
 	// Fetch data.
-	v, perr := p.Payload(avail)
+	v, perr := fetchPayload(p, avail, borrow)
 	if perr != nil || len(v) == 0 {
 		// Note that perr may be nil if len(v) == 0.
 		if opts.Atomic {
@@ -1456,9 +1694,13 @@ func (e *endpoint) Write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, <-c
 	queueAndSend := func() (int64, <-chan struct{}, *tcpip.Error) {
 		// Add data to the send queue.
 		s := newOutgoingSegment(e.ID, v)
+		s.more = opts.More
 		e.sndBufUsed += len(v)
+		e.sndBufQueued += uint64(len(v))
 		e.sndBufInQueue += seqnum.Size(len(v))
 		e.sndQueue.PushBack(s)
+		e.queueZeroCopySendLocked(p)
+		e.queueTxTimestampLocked(v)
 		e.sndBufMu.Unlock()
 
 		// Do the work inline.
@@ -1512,6 +1754,18 @@ func (e *endpoint) Peek(vec [][]byte) (int64, tcpip.ControlMessages, *tcpip.Erro
 		return 0, tcpip.ControlMessages{}, tcpip.ErrInvalidEndpointState
 	}
 
+	if e.repair {
+		switch e.repairQueue {
+		case tcpip.TCPNoQueue:
+			return 0, tcpip.ControlMessages{}, tcpip.ErrInvalidOptionValue
+		case tcpip.TCPSendQueue:
+			if e.snd == nil {
+				return 0, tcpip.ControlMessages{}, nil
+			}
+			return e.peekSendQueueLocked(vec), tcpip.ControlMessages{}, nil
+		}
+	}
+
 	e.rcvListMu.Lock()
 	defer e.rcvListMu.Unlock()
 
@@ -1636,6 +1890,18 @@ func (e *endpoint) OnReusePortSet(v bool) {
 	e.UnlockUser()
 }
 
+// OnReusePortFilterSet implements
+// tcpip.SocketOptionsHandler.OnReusePortFilterSet.
+func (e *endpoint) OnReusePortFilterSet(f tcpip.ReusePortFilter) {
+	e.LockUser()
+	// Only listening endpoints belong to a SO_REUSEPORT group. The filter
+	// of a bound endpoint is attached when it starts listening.
+	if e.EndpointState() == StateListen {
+		e.stack.SetReusePortFilter(e.effectiveNetProtos, ProtocolNumber, e.ID, e, f, e.boundBindToDevice)
+	}
+	e.UnlockUser()
+}
+
 // OnKeepAliveSet implements tcpip.SocketOptionsHandler.OnKeepAliveSet.
 func (e *endpoint) OnKeepAliveSet(v bool) {
 	e.notifyProtocolGoroutine(notifyKeepaliveChanged)
@@ -1657,6 +1923,14 @@ func (e *endpoint) OnCorkOptionSet(v bool) {
 	}
 }
 
+// OnQuickAckSet implements tcpip.SocketOptionsHandler.OnQuickAckSet.
+func (e *endpoint) OnQuickAckSet(v bool) {
+	if v && e.EndpointState().connected() {
+		// Acknowledge the delayed data.
+		e.notifyProtocolGoroutine(notifyQuickAck)
+	}
+}
+
 // SetSockOptInt sets a socket option.
 func (e *endpoint) SetSockOptInt(opt tcpip.SockOptInt, v int) *tcpip.Error {
 	// Lower 2 bits represents ECN bits. RFC 3168, section 23.1
@@ -1694,11 +1968,10 @@ func (e *endpoint) SetSockOptInt(opt tcpip.SockOptInt, v int) *tcpip.Error {
 		e.notifyProtocolGoroutine(notifyMSSChanged)
 
 	case tcpip.MTUDiscoverOption:
-		// Return not supported if attempting to set this option to
-		// anything other than path MTU discovery disabled.
-		if v != tcpip.PMTUDiscoveryDont {
-			return tcpip.ErrNotSupported
+		if v < tcpip.PMTUDiscoveryWant || v > tcpip.PMTUDiscoveryOmit {
+			return tcpip.ErrInvalidOptionValue
 		}
+		atomic.StoreInt32(&e.pmtud, int32(v))
 
 	case tcpip.ReceiveBufferSizeOption:
 		// Make sure the receive buffer size is within the min and max
@@ -1810,6 +2083,21 @@ func (e *endpoint) SetSockOptInt(opt tcpip.SockOptInt, v int) *tcpip.Error {
 		e.LockUser()
 		e.windowClamp = uint32(v)
 		e.UnlockUser()
+
+	case tcpip.TCPRepairOption:
+		e.LockUser()
+		defer e.UnlockUser()
+		return e.setRepairLocked(v)
+
+	case tcpip.TCPRepairQueueOption:
+		e.LockUser()
+		defer e.UnlockUser()
+		return e.setRepairQueueLocked(v)
+
+	case tcpip.TCPQueueSeqOption:
+		e.LockUser()
+		defer e.UnlockUser()
+		return e.setQueueSeqLocked(v)
 	}
 	return nil
 }
@@ -1841,6 +2129,28 @@ func (e *endpoint) SetSockOpt(opt tcpip.SettableSocketOption) *tcpip.Error {
 	case *tcpip.OutOfBandInlineOption:
 		// We don't currently support disabling this option.
 
+	case *tcpip.TCPMD5SigOption:
+		return e.setMD5Key(v)
+
+	case *tcpip.TCPAOAddKeyOption:
+		return e.addAOKey(v)
+
+	case *tcpip.TCPAODelKeyOption:
+		return e.delAOKey(v)
+
+	case *tcpip.TCPAOInfoOption:
+		return e.setAOInfo(v)
+
+	case *tcpip.TCPRepairOptionsOption:
+		e.LockUser()
+		defer e.UnlockUser()
+		return e.setRepairOptionsLocked(v)
+
+	case *tcpip.TCPRepairWindowOption:
+		e.LockUser()
+		defer e.UnlockUser()
+		return e.setRepairWindowLocked(v)
+
 	case *tcpip.TCPUserTimeoutOption:
 		e.LockUser()
 		e.userTimeout = time.Duration(*v)
@@ -1900,10 +2210,39 @@ func (e *endpoint) SetSockOpt(opt tcpip.SettableSocketOption) *tcpip.Error {
 
 	case *tcpip.TCPDeferAcceptOption:
 		e.LockUser()
-		if time.Duration(*v) > MaxRTO {
-			*v = tcpip.TCPDeferAcceptOption(MaxRTO)
+		e.deferAccept = deferAcceptTimeout(time.Duration(*v))
+		e.UnlockUser()
+
+	case *tcpip.TCPNotSentLowatOption:
+		e.sndBufMu.Lock()
+		e.notSentLowat = uint32(*v)
+		e.sndBufMu.Unlock()
+
+		// The endpoint may have become writable.
+		e.waiterQueue.Notify(waiter.EventOut)
+
+	case *tcpip.TCPECNOption:
+		if *v > tcpip.TCPECNAccurate {
+			return tcpip.ErrInvalidOptionValue
+		}
+		e.LockUser()
+		e.ecnOpt = *v
+		e.UnlockUser()
+
+	case *tcpip.TCPSynCookiesOption:
+		if *v > tcpip.TCPSynCookiesOff {
+			return tcpip.ErrInvalidOptionValue
+		}
+		e.LockUser()
+		e.synCookies = *v
+		e.UnlockUser()
+
+	case *tcpip.TCPSynRcvdCountThresholdOption:
+		if *v > math.MaxInt32 {
+			return tcpip.ErrInvalidOptionValue
 		}
-		e.deferAccept = time.Duration(*v)
+		e.LockUser()
+		e.maxSynRcvd = int(*v)
 		e.UnlockUser()
 
 	case *tcpip.SocketDetachFilterOption:
@@ -1966,9 +2305,7 @@ func (e *endpoint) GetSockOptInt(opt tcpip.SockOptInt) (int, *tcpip.Error) {
 		return v, nil
 
 	case tcpip.MTUDiscoverOption:
-		// Always return the path MTU discovery disabled setting since
-		// it's the only one supported.
-		return tcpip.PMTUDiscoveryDont, nil
+		return e.pmtudSetting(), nil
 
 	case tcpip.ReceiveQueueSizeOption:
 		return e.readyReceiveSize()
@@ -2003,6 +2340,29 @@ func (e *endpoint) GetSockOptInt(opt tcpip.SockOptInt) (int, *tcpip.Error) {
 		e.UnlockUser()
 		return v, nil
 
+	case tcpip.TCPRepairOption:
+		e.LockUser()
+		repair := e.repair
+		e.UnlockUser()
+		if repair {
+			return tcpip.TCPRepairOn, nil
+		}
+		return tcpip.TCPRepairOff, nil
+
+	case tcpip.TCPRepairQueueOption:
+		e.LockUser()
+		repair, v := e.repair, e.repairQueue
+		e.UnlockUser()
+		if !repair {
+			return -1, tcpip.ErrInvalidOptionValue
+		}
+		return v, nil
+
+	case tcpip.TCPQueueSeqOption:
+		e.LockUser()
+		defer e.UnlockUser()
+		return e.queueSeqLocked()
+
 	case tcpip.MulticastTTLOption:
 		return 1, nil
 
@@ -2019,17 +2379,19 @@ func (e *endpoint) GetSockOpt(opt tcpip.GettableSocketOption) *tcpip.Error {
 		*o = tcpip.BindToDeviceOption(e.bindToDevice)
 		e.UnlockUser()
 
+	case *tcpip.TCPAOInfoOption:
+		*o = e.getAOInfo()
+
+	case *tcpip.TCPRepairWindowOption:
+		e.LockUser()
+		defer e.UnlockUser()
+		return e.repairWindowLocked(o)
+
 	case *tcpip.TCPInfoOption:
 		*o = tcpip.TCPInfoOption{}
 		e.LockUser()
-		snd := e.snd
+		e.tcpInfoLocked(o)
 		e.UnlockUser()
-		if snd != nil {
-			snd.rtt.Lock()
-			o.RTT = snd.rtt.srtt
-			o.RTTVar = snd.rtt.rttvar
-			snd.rtt.Unlock()
-		}
 
 	case *tcpip.KeepaliveIdleOption:
 		e.keepalive.Lock()
@@ -2065,6 +2427,26 @@ func (e *endpoint) GetSockOpt(opt tcpip.GettableSocketOption) *tcpip.Error {
 		*o = tcpip.TCPDeferAcceptOption(e.deferAccept)
 		e.UnlockUser()
 
+	case *tcpip.TCPNotSentLowatOption:
+		e.sndBufMu.Lock()
+		*o = tcpip.TCPNotSentLowatOption(e.notSentLowat)
+		e.sndBufMu.Unlock()
+
+	case *tcpip.TCPECNOption:
+		e.LockUser()
+		*o = e.ecnOpt
+		e.UnlockUser()
+
+	case *tcpip.TCPSynCookiesOption:
+		e.LockUser()
+		*o = e.synCookies
+		e.UnlockUser()
+
+	case *tcpip.TCPSynRcvdCountThresholdOption:
+		e.LockUser()
+		*o = tcpip.TCPSynRcvdCountThresholdOption(e.maxSynRcvd)
+		e.UnlockUser()
+
 	case *tcpip.OriginalDestinationOption:
 		e.LockUser()
 		ipt := e.stack.IPTables()
@@ -2178,8 +2560,14 @@ func (e *endpoint) connect(addr tcpip.FullAddress, handshake bool, run bool) *tc
 		return tcpip.ErrInvalidEndpointState
 	}
 
+	// An endpoint bound to a device only finds routes through the device, or
+	// its routing domain if it is a VRF device.
+	if nicID == 0 {
+		nicID = e.bindToDevice
+	}
+
 	// Find a route to the desired destination.
-	r, err := e.stack.FindRoute(nicID, e.ID.LocalAddress, addr.Addr, netProto, false /* multicastLoop */)
+	r, err := e.stack.FindRouteWithPolicy(nicID, e.ID.LocalAddress, addr.Addr, netProto, false /* multicastLoop */, stack.RoutePolicyKey{Mark: e.ops.GetMark()})
 	if err != nil {
 		return err
 	}
@@ -2309,6 +2697,18 @@ func (e *endpoint) connect(addr tcpip.FullAddress, handshake bool, run bool) *tc
 
 	e.initGSO()
 
+	// A connection being repaired is established without a handshake.
+	if e.repair {
+		e.isConnectNotified = true
+		e.transitionToStateEstablishedLocked(e.repairHandshake())
+		if run {
+			if err := e.startMainLoop(false /* handshake */); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
 	// Connect in the restore phase does not perform handshake. Restore its
 	// connection setting here.
 	if !handshake {
@@ -2536,6 +2936,9 @@ func (e *endpoint) listen(backlog int) *tcpip.Error {
 	if err := e.stack.RegisterTransportEndpoint(e.boundNICID, e.effectiveNetProtos, ProtocolNumber, e.ID, e, e.boundPortFlags, e.boundBindToDevice); err != nil {
 		return err
 	}
+	if f := e.ops.GetReusePortFilter(); f != nil {
+		e.stack.SetReusePortFilter(e.effectiveNetProtos, ProtocolNumber, e.ID, e, f, e.boundBindToDevice)
+	}
 
 	e.isRegistered = true
 	e.setEndpointState(StateListen)
@@ -2634,11 +3037,14 @@ func (e *endpoint) bindLocked(addr tcpip.FullAddress) (err *tcpip.Error) {
 
 	var nic tcpip.NICID
 	// If an address is specified, we must ensure that it's one of our
-	// local addresses.
+	// local addresses, unless non-local binds are allowed.
 	if len(addr.Addr) != 0 {
 		nic = e.stack.CheckLocalAddress(addr.NIC, netProto, addr.Addr)
 		if nic == 0 {
-			return tcpip.ErrBadLocalAddress
+			if !e.stack.CanBindNonLocal(netProto, &e.ops) {
+				return tcpip.ErrBadLocalAddress
+			}
+			nic = addr.NIC
 		}
 		e.ID.LocalAddress = addr.Addr
 	}
@@ -2727,10 +3133,30 @@ func (e *endpoint) enqueueSegment(s *segment) bool {
 	return true
 }
 
+// pmtudSetting returns the path MTU discovery setting of the endpoint.
+func (e *endpoint) pmtudSetting() int {
+	return int(atomic.LoadInt32(&e.pmtud))
+}
+
+// acceptsPacketTooBig returns true if the endpoint lowers its maximum segment
+// size when it learns that the path MTU decreased. As in Linux, endpoints that
+// ignore the path MTU don't.
+func (e *endpoint) acceptsPacketTooBig() bool {
+	switch e.pmtudSetting() {
+	case tcpip.PMTUDiscoveryInterface, tcpip.PMTUDiscoveryOmit:
+		return false
+	default:
+		return true
+	}
+}
+
 // HandleControlPacket implements stack.TransportEndpoint.HandleControlPacket.
 func (e *endpoint) HandleControlPacket(id stack.TransportEndpointID, typ stack.ControlType, extra uint32, pkt *stack.PacketBuffer) {
 	switch typ {
 	case stack.ControlPacketTooBig:
+		if !e.acceptsPacketTooBig() {
+			return
+		}
 		e.sndBufMu.Lock()
 		e.packetTooBigCount++
 		if v := int(extra); v < e.sndMTU {
@@ -2754,21 +3180,61 @@ func (e *endpoint) HandleControlPacket(id stack.TransportEndpointID, typ stack.C
 	}
 }
 
+// HandleMTUChanged implements stack.MTUChangeHandler.HandleMTUChanged. The
+// maximum segment size of the connection is lowered if the MTU of its route
+// became smaller.
+func (e *endpoint) HandleMTUChanged(tcpip.NICID, uint32) {
+	e.notifyProtocolGoroutine(notifyMTUChanged)
+}
+
+// HandleNICRemoved implements stack.NICRemovalHandler.HandleNICRemoved. The
+// connections bound to the NIC fail with ErrUnknownDevice.
+func (e *endpoint) HandleNICRemoved(tcpip.NICID) {
+	e.notifyProtocolGoroutine(notifyDeviceRemoved)
+}
+
 // updateSndBufferUsage is called by the protocol goroutine when room opens up
 // in the send buffer. The number of newly available bytes is v.
 func (e *endpoint) updateSndBufferUsage(v int) {
 	e.sndBufMu.Lock()
 	notify := e.sndBufUsed >= e.sndBufSize>>1
 	e.sndBufUsed -= v
+	if e.sndBufInFlight -= v; e.sndBufInFlight < 0 {
+		e.sndBufInFlight = 0
+	}
 	// We only notify when there is half the sndBufSize available after
 	// a full buffer event occurs. This ensures that we don't wake up
 	// writers to queue just 1-2 segments and go back to sleep.
 	notify = notify && e.sndBufUsed < e.sndBufSize>>1
+	e.sndBufAcked += uint64(v)
+	zeroCopySends := e.ackedZeroCopySendsLocked()
+	txTimestamps := e.ackedTxTimestampsLocked()
+	e.sndBufMu.Unlock()
+
+	if notify {
+		e.waiterQueue.Notify(waiter.EventOut)
+	}
+	e.completeZeroCopySends(zeroCopySends)
+	e.reportAckedTxTimestamps(txTimestamps)
+}
+
+// updateSndBufferInFlight is called by the protocol goroutine when new data is
+// sent. The number of bytes sent but not yet acknowledged is v.
+func (e *endpoint) updateSndBufferInFlight(v int) {
+	e.sndBufMu.Lock()
+	// As in Linux, writers are only woken up once the data not yet sent
+	// drops below half the TCP_NOTSENT_LOWAT threshold.
+	threshold := e.notSentLowatLocked() >> 1
+	notify := e.notSentLocked() >= threshold
+	e.sndBufInFlight = v
+	notify = notify && e.notSentLocked() < threshold
+	txTimestamps := e.sentTxTimestampsLocked()
 	e.sndBufMu.Unlock()
 
 	if notify {
 		e.waiterQueue.Notify(waiter.EventOut)
 	}
+	e.reportSentTxTimestamps(txTimestamps)
 }
 
 // readyToRead is called by the protocol goroutine when a new segment is ready
@@ -2933,14 +3399,147 @@ func (e *endpoint) maybeEnableSACKPermitted(synOpts *header.TCPSynOptions) {
 
 // maxOptionSize return the maximum size of TCP options.
 func (e *endpoint) maxOptionSize() (size int) {
+	if e.subflow != nil {
+		// The options of the subflow handler may fill the option
+		// space.
+		return maxOptionSize
+	}
 	var maxSackBlocks [header.TCPMaxSACKBlocks]header.SACKBlock
-	options := e.makeOptions(maxSackBlocks[:])
+	var ao *aoSignature
+	if n := e.peerAOMACLen(); n != 0 {
+		ao = &aoSignature{macLen: n}
+	}
+	options := e.makeOptions(maxSackBlocks[:], nil, ao == nil && e.peerMD5Key() != nil, ao)
 	size = len(options)
 	putOptions(options)
 
 	return size
 }
 
+// tcpInfoLocked fills o from the sender and receiver state.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) tcpInfoLocked(o *tcpip.TCPInfoOption) {
+	o.Timestamps = e.sendTSOk
+	o.SACK = e.sackPermitted
+	o.ECN = e.ecn != ecnOff
+	o.RcvMSS = uint32(e.amss)
+	o.PMTU = e.route.MTU()
+	o.TotalRetrans = uint32(e.stats.SendErrors.Retransmits.Value())
+	o.SegsOut = uint32(e.stats.SegmentsSent.Value())
+	o.SegsIn = uint32(e.stats.SegmentsReceived.Value())
+
+	e.rcvListMu.Lock()
+	o.RcvRTT = e.rcvAutoParams.rtt
+	o.RcvSpace = uint32(e.rcvAutoParams.prevCopied)
+	e.rcvListMu.Unlock()
+
+	now := time.Now()
+	if rcv := e.rcv; rcv != nil {
+		o.RcvWndScale = rcv.rcvWndScale
+		o.RcvWnd = uint32(rcv.rcvWnd)
+		o.BytesReceived = rcv.bytesReceived
+		o.DataSegsIn = rcv.dataSegsIn
+		o.RcvOOOPack = rcv.oooPackets
+		o.ECNSeen = rcv.ecnSeen
+		if !rcv.lastRcvdAckTime.IsZero() {
+			o.LastAckRecv = now.Sub(rcv.lastRcvdAckTime)
+		}
+		if !rcv.lastRcvdDataTime.IsZero() {
+			o.LastDataRecv = now.Sub(rcv.lastRcvdDataTime)
+		}
+	}
+
+	snd := e.snd
+	if snd == nil {
+		return
+	}
+	snd.rtt.Lock()
+	o.RTT = snd.rtt.srtt
+	o.RTTVar = snd.rtt.rttvar
+	snd.rtt.Unlock()
+	o.RTO = snd.rto
+	o.MinRTT = snd.dr.minRTT
+
+	switch snd.state {
+	case Open:
+		o.CcState = tcpip.TCPStateOpen
+	case Disorder:
+		o.CcState = tcpip.TCPStateDisorder
+	case FastRecovery, SACKRecovery:
+		o.CcState = tcpip.TCPStateRecovery
+	case RTORecovery:
+		o.CcState = tcpip.TCPStateLoss
+	}
+	if o.CcState == tcpip.TCPStateOpen && snd.inCWR() {
+		o.CcState = tcpip.TCPStateCWR
+	}
+
+	o.Retransmits = snd.retransmits
+	if snd.zeroWindowProbing {
+		o.Probes = uint8(snd.unackZeroWindowProbes)
+	} else {
+		e.keepalive.Lock()
+		o.Probes = uint8(e.keepalive.unacked)
+		e.keepalive.Unlock()
+	}
+	o.SndWndScale = snd.sndWndScale
+	o.WindowScale = o.SndWndScale > 0 || o.RcvWndScale > 0
+	o.SndMSS = uint32(snd.maxPayloadSize)
+
+	for seg := snd.writeList.Front(); seg != nil && seg != snd.writeNext; seg = seg.Next() {
+		o.Unacked++
+		r := header.SACKBlock{Start: seg.sequenceNumber, End: seg.sequenceNumber.Add(seqnum.Size(seg.data.Size()))}
+		if e.scoreboard.IsSACKED(r) {
+			o.Sacked++
+		} else if e.scoreboard.IsRangeLost(r) {
+			o.Lost++
+		}
+		if seg.xmitCount > 1 {
+			o.Retrans++
+		}
+	}
+
+	if !snd.lastDataSendTime.IsZero() {
+		o.LastDataSent = now.Sub(snd.lastDataSendTime)
+	}
+	// Like Linux, report an unset slow start threshold as
+	// TCP_INFINITE_SSTHRESH.
+	o.SndSsthresh = math.MaxInt32
+	if snd.sndSsthresh < math.MaxInt32 {
+		o.SndSsthresh = uint32(snd.sndSsthresh)
+	}
+	o.SndCwnd = uint32(snd.sndCwnd)
+	o.SndWnd = uint32(snd.sndWnd)
+	o.Reordering = snd.rc.reordering
+	o.PacingRate = snd.pacingRate
+	o.BytesSent = snd.bytesSent
+	o.BytesRetrans = snd.bytesRetrans
+	o.BytesAcked = snd.bytesAcked
+	o.DataSegsOut = snd.dataSegsOut
+
+	e.sndBufMu.Lock()
+	if notSent := e.sndBufUsed - int(snd.sndUna.Size(snd.sndNxt)); notSent > 0 {
+		o.NotSentBytes = uint32(notSent)
+	}
+	e.sndBufMu.Unlock()
+
+	o.DeliveryRate = snd.deliveryRateBytes()
+	o.DeliveryRateAppLimited = snd.dr.rateAppLimited
+
+	// Like Linux, busy time includes the time spent limited by the
+	// receive window or send buffer.
+	chrono := snd.chrono.durations(now)
+	o.BusyTime = chrono[chronoBusy] + chrono[chronoRwndLimited] + chrono[chronoSndBufLimited]
+	o.RwndLimited = chrono[chronoRwndLimited]
+	o.SndBufLimited = chrono[chronoSndBufLimited]
+
+	o.Delivered = uint32(snd.dr.delivered)
+	o.DeliveredCE = snd.deliveredCE
+	o.DSACKDups = snd.dsackDups
+	o.ReordSeen = snd.rc.reordSeen
+}
+
 // completeState makes a full copy of the endpoint and returns it. This is used
 // before invoking the probe. The state returned may not be fully consistent if
 // there are intervening syscalls when the state is being copied.
@@ -3038,6 +3637,23 @@ func (e *endpoint) completeState() stack.TCPEndpointState {
 		}
 	}
 
+	if bbr, ok := e.snd.cc.(*bbrState); ok {
+		s.Sender.BBR = stack.TCPBBRState{
+			Mode:        bbr.mode.String(),
+			MaxBW:       bbr.maxBW.get(),
+			MinRTT:      bbr.minRTT,
+			RoundCount:  bbr.roundCount,
+			PacingGain:  bbr.pacingGain,
+			CwndGain:    bbr.cwndGain,
+			FilledPipe:  bbr.filledPipe,
+			PacingRate:  e.snd.pacingRate,
+			CycleIndex:  bbr.cycleIndex,
+			PriorCwnd:   bbr.priorCwnd,
+			FullBW:      bbr.fullBW,
+			FullBWCount: bbr.fullBWCount,
+		}
+	}
+
 	rc := e.snd.rc
 	s.Sender.RACKState = stack.TCPRACKState{
 		XmitTime:    rc.xmitTime,
@@ -3065,15 +3681,21 @@ func (e *endpoint) initHardwareGSO() {
 	gso.NeedsCsum = true
 	gso.CsumOffset = header.TCPChecksumOffset
 	gso.MaxSize = e.route.GSOMaxSize()
+	gso.MaxSegs = e.route.GSOMaxSegs()
 	e.gso = gso
 }
 
 func (e *endpoint) initGSO() {
+	if e.peerMD5Key() != nil || e.peerAOMACLen() != 0 {
+		// Signed segments can't be segmented by the link endpoint.
+		return
+	}
 	if e.route.HasHardwareGSOCapability() {
 		e.initHardwareGSO()
 	} else if e.route.HasSoftwareGSOCapability() {
 		e.gso = &stack.GSO{
 			MaxSize:   e.route.GSOMaxSize(),
+			MaxSegs:   e.route.GSOMaxSegs(),
 			Type:      stack.GSOSW,
 			NeedsCsum: false,
 		}
@@ -0,0 +1,179 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp
+
+import (
+	"crypto/subtle"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// maxMD5KeyLen is the maximum length of a TCP MD5 signature key, as in Linux.
+const maxMD5KeyLen = 80
+
+// peerPrefix describes the peers a signature key is used with: the peers
+// whose address matches a prefix, optionally reached through a given NIC.
+//
+// +stateify savable
+type peerPrefix struct {
+	addr      tcpip.Address
+	prefixLen int
+	nic       tcpip.NICID
+}
+
+// matches returns true if the key is used with the peer addr reached through
+// nic.
+func (p *peerPrefix) matches(addr tcpip.Address, nic tcpip.NICID) bool {
+	if len(addr) != len(p.addr) || (p.nic != 0 && p.nic != nic) {
+		return false
+	}
+	n, bits := p.prefixLen/8, p.prefixLen%8
+	if addr[:n] != p.addr[:n] {
+		return false
+	}
+	if bits == 0 {
+		return true
+	}
+	mask := byte(0xff) << (8 - bits)
+	return addr[n]&mask == p.addr[n]&mask
+}
+
+// md5Key is a TCP MD5 signature key, used with the peers matching a prefix, as
+// per RFC 2385.
+//
+// +stateify savable
+type md5Key struct {
+	peerPrefix
+	key []byte
+}
+
+// setMD5Key adds, replaces or removes the TCP MD5 signature key described by
+// opt.
+func (e *endpoint) setMD5Key(opt *tcpip.TCPMD5SigOption) *tcpip.Error {
+	if len(opt.Key) > maxMD5KeyLen || opt.PrefixLen < 0 || opt.PrefixLen > len(opt.Addr)*8 {
+		return tcpip.ErrInvalidOptionValue
+	}
+
+	e.md5Mu.Lock()
+	defer e.md5Mu.Unlock()
+	for i := range e.md5Keys {
+		k := &e.md5Keys[i]
+		if k.addr != opt.Addr || k.prefixLen != opt.PrefixLen || k.nic != opt.NIC {
+			continue
+		}
+		if len(opt.Key) == 0 {
+			e.md5Keys = append(e.md5Keys[:i], e.md5Keys[i+1:]...)
+		} else {
+			k.key = append([]byte(nil), opt.Key...)
+		}
+		return nil
+	}
+	if len(opt.Key) == 0 {
+		return tcpip.ErrNoSuchFile
+	}
+	e.md5Keys = append(e.md5Keys, md5Key{
+		peerPrefix: peerPrefix{
+			addr:      opt.Addr,
+			prefixLen: opt.PrefixLen,
+			nic:       opt.NIC,
+		},
+		key: append([]byte(nil), opt.Key...),
+	})
+	return nil
+}
+
+// md5KeyFor returns the TCP MD5 signature key used with the peer addr reached
+// through nic, if any. As in Linux, the key with the longest matching prefix
+// is used.
+func (e *endpoint) md5KeyFor(addr tcpip.Address, nic tcpip.NICID) []byte {
+	e.md5Mu.Lock()
+	defer e.md5Mu.Unlock()
+	var best *md5Key
+	for i := range e.md5Keys {
+		k := &e.md5Keys[i]
+		if k.matches(addr, nic) && (best == nil || k.prefixLen > best.prefixLen) {
+			best = k
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return best.key
+}
+
+// peerMD5Key returns the TCP MD5 signature key used with the peer of a
+// connected endpoint, if any.
+func (e *endpoint) peerMD5Key() []byte {
+	var nic tcpip.NICID
+	if e.route != nil {
+		nic = e.route.NICID()
+	}
+	return e.md5KeyFor(e.ID.RemoteAddress, nic)
+}
+
+// inheritMD5Keys copies the TCP MD5 signature keys of the listening endpoint l
+// to the endpoint e it accepted.
+func (e *endpoint) inheritMD5Keys(l *endpoint) {
+	l.md5Mu.Lock()
+	keys := append([]md5Key(nil), l.md5Keys...)
+	l.md5Mu.Unlock()
+
+	e.md5Mu.Lock()
+	e.md5Keys = keys
+	e.md5Mu.Unlock()
+}
+
+// checkMD5 returns true if the received segment s carries the TCP MD5
+// signature expected by the endpoint, or no signature if none is expected.
+func (e *endpoint) checkMD5(s *segment) bool {
+	e.aoMu.Lock()
+	ao := e.ao.usedWith(s.id.RemoteAddress, s.nicID)
+	e.aoMu.Unlock()
+	if ao {
+		// The TCP Authentication Option takes precedence.
+		return true
+	}
+	key := e.md5KeyFor(s.id.RemoteAddress, s.nicID)
+	digest, ok := header.TCPMD5OptionDigest(s.options)
+	switch {
+	case key == nil && !ok:
+		return true
+	case key == nil:
+		e.stack.Stats().TCP.MD5Unexpected.Increment()
+		return false
+	case !ok:
+		e.stack.Stats().TCP.MD5NotFound.Increment()
+		return false
+	}
+	want := header.TCPMD5Digest(key, s.srcAddr, s.dstAddr, s.hdr, s.data)
+	if subtle.ConstantTimeCompare(digest, want[:]) != 1 {
+		e.stack.Stats().TCP.MD5Failure.Increment()
+		return false
+	}
+	return true
+}
+
+// prependMD5Option inserts a TCP MD5 signature option before the options of a
+// SYN segment, which leave enough room for it. As in Linux, the signature is
+// the first option.
+func prependMD5Option(options []byte) []byte {
+	const size = 2 + header.TCPOptionMD5Length
+	n := len(options)
+	options = options[:n+size]
+	copy(options[size:], options[:n])
+	header.EncodeMD5Option(options)
+	return options
+}
@@ -0,0 +1,264 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp
+
+import (
+	"math"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+const (
+	// metricsTimeout is the time after which the metrics cached for a
+	// destination are discarded, as in Linux.
+	metricsTimeout = time.Hour
+
+	// maxMetricsEntries is the maximum number of destinations whose
+	// metrics are cached. The least recently updated entry is evicted to
+	// make room for a new one.
+	maxMetricsEntries = 4096
+)
+
+// metricsKey identifies the connections sharing cached metrics.
+type metricsKey struct {
+	local  tcpip.Address
+	remote tcpip.Address
+}
+
+// metricsEntry holds the metrics cached for a destination.
+type metricsEntry struct {
+	updated  time.Time
+	rtt      time.Duration
+	rttVar   time.Duration
+	ssthresh int
+	cwnd     int
+}
+
+// metricsCache is a per-destination cache of the metrics of closed
+// connections, used to bootstrap the following connections to the same
+// destinations, as per Linux's tcp_metrics.
+type metricsCache struct {
+	mu      sync.Mutex
+	entries map[metricsKey]*metricsEntry
+}
+
+// lookupLocked returns the entry of k, or nil if no fresh metrics are cached.
+//
+// Precondition: c.mu must be held.
+func (c *metricsCache) lookupLocked(k metricsKey, now time.Time) *metricsEntry {
+	m, ok := c.entries[k]
+	if !ok {
+		return nil
+	}
+	if now.Sub(m.updated) > metricsTimeout {
+		delete(c.entries, k)
+		return nil
+	}
+	return m
+}
+
+// apply bootstraps the sender of a new connection with the metrics cached for
+// its destination: the slow start threshold, the congestion window hint, and
+// the RTO computed from the cached RTT, which doesn't seed the RTT estimator.
+func (c *metricsCache) apply(k metricsKey, s *sender) {
+	c.mu.Lock()
+	m := c.lookupLocked(k, time.Now())
+	if m == nil {
+		c.mu.Unlock()
+		return
+	}
+	rtt, rttVar, ssthresh, cwnd := m.rtt, m.rttVar, m.ssthresh, m.cwnd
+	c.mu.Unlock()
+
+	if ssthresh != 0 {
+		s.sndSsthresh = ssthresh
+	}
+	if cwnd > s.sndCwnd {
+		s.sndCwnd = cwnd
+		if s.sndCwnd > s.sndSsthresh {
+			s.sndCwnd = s.sndSsthresh
+		}
+	}
+	if rtt != 0 {
+		s.rto = rtt + 4*rttVar
+		if s.rto < s.minRTO {
+			s.rto = s.minRTO
+		}
+		if s.rto > s.maxRTO {
+			s.rto = s.maxRTO
+		}
+	}
+}
+
+// update caches the metrics of a connection which is being closed. As in
+// Linux, increases of the RTT are cached immediately while decreases are
+// smoothed, and the congestion state is only trusted when it's meaningful.
+func (c *metricsCache) update(k metricsKey, s *sender) {
+	s.rtt.Lock()
+	srtt, rttVar, srttInited := s.rtt.srtt, s.rtt.rttvar, s.rtt.srttInited
+	s.rtt.Unlock()
+	// The connection failed to estimate the RTT, so its congestion state
+	// can't be trusted either.
+	if !srttInited {
+		return
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := c.lookupLocked(k, now)
+	if m == nil {
+		if c.entries == nil {
+			c.entries = make(map[metricsKey]*metricsEntry)
+		}
+		if len(c.entries) >= maxMetricsEntries {
+			c.evictLocked()
+		}
+		m = &metricsEntry{
+			rtt:    srtt,
+			rttVar: rttVar,
+		}
+		c.entries[k] = m
+	}
+	m.updated = now
+
+	d := m.rtt - srtt
+	if d <= 0 {
+		m.rtt = srtt
+	} else {
+		m.rtt -= d / 8
+	}
+	if d < 0 {
+		d = -d
+	}
+	if d /= 2; d < rttVar {
+		d = rttVar
+	}
+	if d >= m.rttVar {
+		m.rttVar = d
+	} else {
+		m.rttVar -= (m.rttVar - d) / 4
+	}
+
+	switch {
+	case s.sndSsthresh == math.MaxInt64:
+		// The connection didn't leave the initial slow start.
+		if m.ssthresh != 0 && s.sndCwnd/2 > m.ssthresh {
+			m.ssthresh = s.sndCwnd / 2
+		}
+		if s.sndCwnd > m.cwnd {
+			m.cwnd = s.sndCwnd
+		}
+	case s.sndCwnd >= s.sndSsthresh && s.state == Open:
+		// The congestion window is reliable in congestion avoidance.
+		m.ssthresh = s.sndCwnd / 2
+		if m.ssthresh < s.sndSsthresh {
+			m.ssthresh = s.sndSsthresh
+		}
+		m.cwnd = (m.cwnd + s.sndCwnd) / 2
+	default:
+		// The congestion window is meaningless while recovering or
+		// slow starting again.
+		m.cwnd = (m.cwnd + s.sndSsthresh) / 2
+		if m.ssthresh != 0 && s.sndSsthresh > m.ssthresh {
+			m.ssthresh = s.sndSsthresh
+		}
+	}
+}
+
+// evictLocked discards the least recently updated entry.
+//
+// Precondition: c.mu must be held.
+func (c *metricsCache) evictLocked() {
+	var oldest metricsKey
+	var oldestTime time.Time
+	for k, m := range c.entries {
+		if oldestTime.IsZero() || m.updated.Before(oldestTime) {
+			oldest, oldestTime = k, m.updated
+		}
+	}
+	delete(c.entries, oldest)
+}
+
+// dump returns the fresh metrics of the cache.
+func (c *metricsCache) dump() tcpip.TCPMetricsOption {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var v tcpip.TCPMetricsOption
+	for k := range c.entries {
+		m := c.lookupLocked(k, now)
+		if m == nil {
+			continue
+		}
+		v = append(v, tcpip.TCPMetrics{
+			LocalAddress:  k.local,
+			RemoteAddress: k.remote,
+			Age:           now.Sub(m.updated),
+			RTT:           m.rtt,
+			RTTVar:        m.rttVar,
+			Ssthresh:      m.ssthresh,
+			Cwnd:          m.cwnd,
+		})
+	}
+	return v
+}
+
+// flush discards the metrics cached for the remote address addr, or all the
+// metrics if addr is empty.
+func (c *metricsCache) flush(addr tcpip.Address) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.entries {
+		if addr == "" || k.remote == addr {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// destinationMetrics returns the metrics cache of the stack of e, and whether
+// the metrics of closed connections are saved to it.
+func (e *endpoint) destinationMetrics() (*metricsCache, bool) {
+	p, ok := e.stack.TransportProtocolInstance(ProtocolNumber).(*protocol)
+	if !ok {
+		return nil, false
+	}
+	p.mu.RLock()
+	save := p.saveMetrics
+	p.mu.RUnlock()
+	return &p.metrics, save
+}
+
+// applyMetricsLocked bootstraps the sender of a new connection with the
+// metrics cached for its destination.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) applyMetricsLocked() {
+	if c, _ := e.destinationMetrics(); c != nil {
+		c.apply(metricsKey{local: e.ID.LocalAddress, remote: e.ID.RemoteAddress}, e.snd)
+	}
+}
+
+// saveMetricsLocked caches the metrics of a connection which is being closed
+// gracefully.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) saveMetricsLocked() {
+	if c, save := e.destinationMetrics(); save {
+		c.update(metricsKey{local: e.ID.LocalAddress, remote: e.ID.RemoteAddress}, e.snd)
+	}
+}
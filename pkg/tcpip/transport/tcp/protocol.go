@@ -16,6 +16,7 @@
 package tcp
 
 import (
+	"math"
 	"runtime"
 	"strings"
 	"time"
@@ -68,11 +69,39 @@ const (
 	// DefaultSynRetries is the default value for the number of SYN retransmits
 	// before a connect is aborted.
 	DefaultSynRetries = 6
+
+	// DefaultLimitOutputBytes is the default maximum number of bytes an
+	// endpoint may have queued below it, as in Linux.
+	DefaultLimitOutputBytes = 1 << 20 // 1MB
+
+	// DefaultNotSentLowat is the default TCP_NOTSENT_LOWAT threshold,
+	// which doesn't limit the data written but not yet sent, as in Linux.
+	DefaultNotSentLowat = math.MaxUint32
+
+	// DefaultDelayedAckTimeout is the default maximum time the
+	// acknowledgment of received data is delayed, as Linux's
+	// TCP_DELACK_MIN.
+	DefaultDelayedAckTimeout = 40 * time.Millisecond
+
+	// MaxDelayedAckTimeout is the maximum delay of an acknowledgment
+	// allowed by RFC 1122 section 4.2.3.2.
+	MaxDelayedAckTimeout = 500 * time.Millisecond
+
+	// DefaultDelayedAckSegments is the default number of unacknowledged
+	// data segments after which an acknowledgment is sent immediately, as
+	// recommended by RFC 5681 section 4.2.
+	DefaultDelayedAckSegments = 2
+
+	// DefaultOutOfOrderLimit is the default maximum number of bytes held
+	// in the out-of-order queue of an endpoint, which is a quarter of the
+	// largest receive buffer.
+	DefaultOutOfOrderLimit = MaxBufferSize >> 2
 )
 
 const (
 	ccReno  = "reno"
 	ccCubic = "cubic"
+	ccBBR   = "bbr"
 )
 
 // syncRcvdCounter tracks the number of endpoints in the SYN-RCVD state. The
@@ -83,6 +112,10 @@ type synRcvdCounter struct {
 	value     uint64
 	pending   sync.WaitGroup
 	threshold uint64
+
+	// synCookies is the stack-wide SYN cookie mode. It is never
+	// tcpip.TCPSynCookiesDefault.
+	synCookies tcpip.TCPSynCookiesOption
 }
 
 // inc tries to increment the global number of endpoints in SYN-RCVD state. It
@@ -132,6 +165,20 @@ func (s *synRcvdCounter) Threshold() uint64 {
 	return s.threshold
 }
 
+// SetSynCookies sets the stack-wide SYN cookie mode.
+func (s *synRcvdCounter) SetSynCookies(mode tcpip.TCPSynCookiesOption) {
+	s.Lock()
+	defer s.Unlock()
+	s.synCookies = mode
+}
+
+// SynCookies returns the stack-wide SYN cookie mode.
+func (s *synRcvdCounter) SynCookies() tcpip.TCPSynCookiesOption {
+	s.Lock()
+	defer s.Unlock()
+	return s.synCookies
+}
+
 type protocol struct {
 	stack *stack.Stack
 
@@ -152,6 +199,17 @@ type protocol struct {
 	maxRetries                 uint32
 	synRcvdCount               synRcvdCounter
 	synRetries                 uint8
+	slowStartAfterIdle         bool
+	mtuProbing                 bool
+	ecn                        tcpip.TCPECNOption
+	autocork                   bool
+	limitOutputBytes           int
+	notSentLowat               uint32
+	delayedAckTimeout          time.Duration
+	delayedAckSegments         int
+	outOfOrderLimit            int
+	saveMetrics                bool
+	metrics                    metricsCache
 	dispatcher                 dispatcher
 }
 
@@ -261,6 +319,12 @@ func replyWithReset(stack *stack.Stack, s *segment, tos, ttl uint8) *tcpip.Error
 	}, buffer.VectorisedView{}, nil /* gso */, nil /* PacketOwner */)
 }
 
+// validBufferSizeRange returns whether minSize, defSize and maxSize are a
+// valid range of buffer sizes.
+func validBufferSizeRange(minSize, defSize, maxSize int) bool {
+	return minSize > 0 && defSize >= minSize && defSize <= maxSize
+}
+
 // SetOption implements stack.TransportProtocol.SetOption.
 func (p *protocol) SetOption(option tcpip.SettableTransportProtocolOption) *tcpip.Error {
 	switch v := option.(type) {
@@ -283,7 +347,7 @@ func (p *protocol) SetOption(option tcpip.SettableTransportProtocolOption) *tcpi
 		return nil
 
 	case *tcpip.TCPSendBufferSizeRangeOption:
-		if v.Min <= 0 || v.Default < v.Min || v.Default > v.Max {
+		if !validBufferSizeRange(v.Min, v.Default, v.Max) {
 			return tcpip.ErrInvalidOptionValue
 		}
 		p.mu.Lock()
@@ -292,7 +356,7 @@ func (p *protocol) SetOption(option tcpip.SettableTransportProtocolOption) *tcpi
 		return nil
 
 	case *tcpip.TCPReceiveBufferSizeRangeOption:
-		if v.Min <= 0 || v.Default < v.Min || v.Default > v.Max {
+		if !validBufferSizeRange(v.Min, v.Default, v.Max) {
 			return tcpip.ErrInvalidOptionValue
 		}
 		p.mu.Lock()
@@ -380,6 +444,13 @@ func (p *protocol) SetOption(option tcpip.SettableTransportProtocolOption) *tcpi
 		p.mu.Unlock()
 		return nil
 
+	case *tcpip.TCPSynCookiesOption:
+		if *v < tcpip.TCPSynCookiesOnOverflow || *v > tcpip.TCPSynCookiesOff {
+			return tcpip.ErrInvalidOptionValue
+		}
+		p.synRcvdCount.SetSynCookies(*v)
+		return nil
+
 	case *tcpip.TCPSynRetriesOption:
 		if *v < 1 || *v > 255 {
 			return tcpip.ErrInvalidOptionValue
@@ -389,6 +460,96 @@ func (p *protocol) SetOption(option tcpip.SettableTransportProtocolOption) *tcpi
 		p.mu.Unlock()
 		return nil
 
+	case *tcpip.TCPTunablesOption:
+		if v.SynRetries < 1 || !validBufferSizeRange(v.ReceiveBufferSize.Min, v.ReceiveBufferSize.Default, v.ReceiveBufferSize.Max) || !validBufferSizeRange(v.SendBufferSize.Min, v.SendBufferSize.Default, v.SendBufferSize.Max) {
+			return tcpip.ErrInvalidOptionValue
+		}
+		p.mu.Lock()
+		p.synRetries = v.SynRetries
+		p.maxRetries = uint32(v.MaxRetries)
+		if v.FinTimeout < 0 {
+			p.lingerTimeout = 0
+		} else {
+			p.lingerTimeout = v.FinTimeout
+		}
+		p.recvBufferSize = v.ReceiveBufferSize
+		p.sendBufferSize = v.SendBufferSize
+		p.moderateReceiveBuffer = v.ModerateReceiveBuffer
+		p.slowStartAfterIdle = v.SlowStartAfterIdle
+		p.mtuProbing = v.MTUProbing
+		p.mu.Unlock()
+		return nil
+
+	case *tcpip.TCPECNOption:
+		if *v < tcpip.TCPECNDisabled || *v > tcpip.TCPECNAccurate {
+			return tcpip.ErrInvalidOptionValue
+		}
+		p.mu.Lock()
+		p.ecn = *v
+		p.mu.Unlock()
+		return nil
+
+	case *tcpip.TCPAutocorkOption:
+		p.mu.Lock()
+		p.autocork = bool(*v)
+		p.mu.Unlock()
+		return nil
+
+	case *tcpip.TCPLimitOutputBytesOption:
+		if *v < 0 {
+			return tcpip.ErrInvalidOptionValue
+		}
+		p.mu.Lock()
+		p.limitOutputBytes = int(*v)
+		p.mu.Unlock()
+		return nil
+
+	case *tcpip.TCPNotSentLowatOption:
+		if *v == 0 {
+			return tcpip.ErrInvalidOptionValue
+		}
+		p.mu.Lock()
+		p.notSentLowat = uint32(*v)
+		p.mu.Unlock()
+		return nil
+
+	case *tcpip.TCPDelayedAckTimeoutOption:
+		if *v < 0 || time.Duration(*v) > MaxDelayedAckTimeout {
+			return tcpip.ErrInvalidOptionValue
+		}
+		p.mu.Lock()
+		p.delayedAckTimeout = time.Duration(*v)
+		p.mu.Unlock()
+		return nil
+
+	case *tcpip.TCPDelayedAckSegmentsOption:
+		if *v < 1 {
+			return tcpip.ErrInvalidOptionValue
+		}
+		p.mu.Lock()
+		p.delayedAckSegments = int(*v)
+		p.mu.Unlock()
+		return nil
+
+	case *tcpip.TCPOutOfOrderLimitOption:
+		if *v <= 0 {
+			return tcpip.ErrInvalidOptionValue
+		}
+		p.mu.Lock()
+		p.outOfOrderLimit = int(*v)
+		p.mu.Unlock()
+		return nil
+
+	case *tcpip.TCPMetricsSaveOption:
+		p.mu.Lock()
+		p.saveMetrics = bool(*v)
+		p.mu.Unlock()
+		return nil
+
+	case *tcpip.TCPMetricsFlushOption:
+		p.metrics.flush(tcpip.Address(*v))
+		return nil
+
 	default:
 		return tcpip.ErrUnknownProtocolOption
 	}
@@ -487,12 +648,83 @@ func (p *protocol) Option(option tcpip.GettableTransportProtocolOption) *tcpip.E
 		p.mu.RUnlock()
 		return nil
 
+	case *tcpip.TCPSynCookiesOption:
+		*v = p.synRcvdCount.SynCookies()
+		return nil
+
 	case *tcpip.TCPSynRetriesOption:
 		p.mu.RLock()
 		*v = tcpip.TCPSynRetriesOption(p.synRetries)
 		p.mu.RUnlock()
 		return nil
 
+	case *tcpip.TCPTunablesOption:
+		p.mu.RLock()
+		*v = tcpip.TCPTunablesOption{
+			SynRetries:            p.synRetries,
+			MaxRetries:            uint64(p.maxRetries),
+			FinTimeout:            p.lingerTimeout,
+			ReceiveBufferSize:     p.recvBufferSize,
+			SendBufferSize:        p.sendBufferSize,
+			ModerateReceiveBuffer: p.moderateReceiveBuffer,
+			SlowStartAfterIdle:    p.slowStartAfterIdle,
+			MTUProbing:            p.mtuProbing,
+		}
+		p.mu.RUnlock()
+		return nil
+
+	case *tcpip.TCPECNOption:
+		p.mu.RLock()
+		*v = p.ecn
+		p.mu.RUnlock()
+		return nil
+
+	case *tcpip.TCPAutocorkOption:
+		p.mu.RLock()
+		*v = tcpip.TCPAutocorkOption(p.autocork)
+		p.mu.RUnlock()
+		return nil
+
+	case *tcpip.TCPLimitOutputBytesOption:
+		p.mu.RLock()
+		*v = tcpip.TCPLimitOutputBytesOption(p.limitOutputBytes)
+		p.mu.RUnlock()
+		return nil
+
+	case *tcpip.TCPNotSentLowatOption:
+		p.mu.RLock()
+		*v = tcpip.TCPNotSentLowatOption(p.notSentLowat)
+		p.mu.RUnlock()
+		return nil
+
+	case *tcpip.TCPDelayedAckTimeoutOption:
+		p.mu.RLock()
+		*v = tcpip.TCPDelayedAckTimeoutOption(p.delayedAckTimeout)
+		p.mu.RUnlock()
+		return nil
+
+	case *tcpip.TCPDelayedAckSegmentsOption:
+		p.mu.RLock()
+		*v = tcpip.TCPDelayedAckSegmentsOption(p.delayedAckSegments)
+		p.mu.RUnlock()
+		return nil
+
+	case *tcpip.TCPOutOfOrderLimitOption:
+		p.mu.RLock()
+		*v = tcpip.TCPOutOfOrderLimitOption(p.outOfOrderLimit)
+		p.mu.RUnlock()
+		return nil
+
+	case *tcpip.TCPMetricsSaveOption:
+		p.mu.RLock()
+		*v = tcpip.TCPMetricsSaveOption(p.saveMetrics)
+		p.mu.RUnlock()
+		return nil
+
+	case *tcpip.TCPMetricsOption:
+		*v = p.metrics.dump()
+		return nil
+
 	default:
 		return tcpip.ErrUnknownProtocolOption
 	}
@@ -534,12 +766,21 @@ func NewProtocol(s *stack.Stack) stack.TransportProtocol {
 			Max:     MaxBufferSize,
 		},
 		congestionControl:          ccReno,
-		availableCongestionControl: []string{ccReno, ccCubic},
+		availableCongestionControl: []string{ccReno, ccCubic, ccBBR},
 		lingerTimeout:              DefaultTCPLingerTimeout,
 		timeWaitTimeout:            DefaultTCPTimeWaitTimeout,
 		timeWaitReuse:              tcpip.TCPTimeWaitReuseLoopbackOnly,
-		synRcvdCount:               synRcvdCounter{threshold: SynRcvdCountThreshold},
+		synRcvdCount:               synRcvdCounter{threshold: SynRcvdCountThreshold, synCookies: tcpip.TCPSynCookiesOnOverflow},
 		synRetries:                 DefaultSynRetries,
+		slowStartAfterIdle:         true,
+		ecn:                        tcpip.TCPECNPassive,
+		autocork:                   true,
+		limitOutputBytes:           DefaultLimitOutputBytes,
+		notSentLowat:               DefaultNotSentLowat,
+		delayedAckTimeout:          DefaultDelayedAckTimeout,
+		delayedAckSegments:         DefaultDelayedAckSegments,
+		outOfOrderLimit:            DefaultOutOfOrderLimit,
+		saveMetrics:                true,
 		minRTO:                     MinRTO,
 		maxRTO:                     MaxRTO,
 		maxRetries:                 MaxRetries,
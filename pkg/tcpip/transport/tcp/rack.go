@@ -52,6 +52,15 @@ type rackControl struct {
 	// connection.
 	reorderSeen bool
 
+	// reordSeen is the number of times reordering has been detected on
+	// this connection.
+	reordSeen uint32
+
+	// reordering is the largest distance, in segments, by which a segment
+	// was delivered out of order, like Linux's tp->reordering. It is at
+	// least nDupAckThreshold.
+	reordering uint32
+
 	// xmitTime is the latest transmission timestamp of rackControl.seg.
 	xmitTime time.Time `state:".(unixTime)"`
 }
@@ -111,7 +120,10 @@ func (rc *rackControl) update(seg *segment, ackSeg *segment, offset uint32) {
 //   RACK.fack is (selectively or cumulatively) acknowledged, it has been
 //   delivered out of order. The sender sets RACK.reord to TRUE if such segment
 //   is identified.
-func (rc *rackControl) detectReorder(seg *segment) {
+//
+// The distance between the segment and RACK.fack, in segments of size mss,
+// raises the reordering degree reported by TCP_INFO.
+func (rc *rackControl) detectReorder(seg *segment, mss int) {
 	endSeq := seg.sequenceNumber.Add(seqnum.Size(seg.data.Size()))
 	if rc.fack.LessThan(endSeq) {
 		rc.fack = endSeq
@@ -120,6 +132,17 @@ func (rc *rackControl) detectReorder(seg *segment) {
 
 	if endSeq.LessThan(rc.fack) && seg.xmitCount == 1 {
 		rc.reorderSeen = true
+		rc.reordSeen++
+		if mss > 0 {
+			// Like Linux, round the distance up to whole segments.
+			dist := (uint32(seg.sequenceNumber.Size(rc.fack)) + uint32(mss) - 1) / uint32(mss)
+			if dist > maxReordering {
+				dist = maxReordering
+			}
+			if dist > rc.reordering {
+				rc.reordering = dist
+			}
+		}
 	}
 }
 
@@ -19,6 +19,7 @@ import (
 	"math"
 	"time"
 
+	"gvisor.dev/gvisor/pkg/sleep"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 	"gvisor.dev/gvisor/pkg/tcpip/seqnum"
@@ -62,12 +63,61 @@ type receiver struct {
 	// overhead) currently queued in pendingRcvdSegments.
 	pendingBufUsed int
 
+	// oooLimit is the maximum value of pendingBufUsed, in addition to a
+	// quarter of the receive buffer size.
+	oooLimit int
+
 	// Time when the last ack was received.
 	lastRcvdAckTime time.Time `state:".(unixTime)"`
+
+	// Time when the last segment carrying data was received.
+	lastRcvdDataTime time.Time `state:".(unixTime)"`
+
+	// bytesReceived is the number of payload bytes consumed in sequence.
+	bytesReceived uint64
+
+	// dataSegsIn is the number of acceptable segments received that
+	// carried data.
+	dataSegsIn uint32
+
+	// oooPackets is the number of out-of-order segments queued in
+	// pendingRcvdSegments.
+	oooPackets uint32
+
+	// ecnSeen is set once a segment with an ECN codepoint other than
+	// Not-ECT is received.
+	ecnSeen bool
+
+	// ecnEcho is set while the ECN-Echo flag must be set on ACKs sent to
+	// an RFC 3168 ECN peer.
+	ecnEcho bool
+
+	// ecnCEP is the number of CE marked packets received from an Accurate
+	// ECN peer, starting at accECNInitialCEP.
+	ecnCEP uint32
+
+	// ecnHandshakeACE if non-zero is the ACE field of the final ACK of
+	// an Accurate ECN handshake, which is yet to be sent.
+	ecnHandshakeACE uint8
+
+	// delAckTimeout and delAckSegs are the maximum delay of an
+	// acknowledgment and the number of unacknowledged data segments after
+	// which it is sent immediately, when TCP_QUICKACK is disabled.
+	delAckTimeout time.Duration
+	delAckSegs    int
+
+	// unackedSegs is the number of data segments received since the last
+	// acknowledgment was sent.
+	unackedSegs int
+
+	// delAckTimer and delAckWaker are used to send a delayed
+	// acknowledgment.
+	delAckTimer timer       `state:"nosave"`
+	delAckWaker sleep.Waker `state:"nosave"`
 }
 
 func newReceiver(ep *endpoint, irs seqnum.Value, rcvWnd seqnum.Size, rcvWndScale uint8) *receiver {
-	return &receiver{
+	r := &receiver{
 		ep:              ep,
 		rcvNxt:          irs + 1,
 		rcvAcc:          irs.Add(rcvWnd + 1),
@@ -75,7 +125,26 @@ func newReceiver(ep *endpoint, irs seqnum.Value, rcvWnd seqnum.Size, rcvWndScale
 		rcvWUP:          irs + 1,
 		rcvWndScale:     rcvWndScale,
 		lastRcvdAckTime: time.Now(),
+		ecnCEP:          accECNInitialCEP,
+		delAckSegs:      DefaultDelayedAckSegments,
+		oooLimit:        DefaultOutOfOrderLimit,
+	}
+
+	var timeout tcpip.TCPDelayedAckTimeoutOption
+	if err := ep.stack.TransportProtocolOption(ProtocolNumber, &timeout); err == nil {
+		r.delAckTimeout = time.Duration(timeout)
+	}
+	var segs tcpip.TCPDelayedAckSegmentsOption
+	if err := ep.stack.TransportProtocolOption(ProtocolNumber, &segs); err == nil {
+		r.delAckSegs = int(segs)
 	}
+	var oooLimit tcpip.TCPOutOfOrderLimitOption
+	if err := ep.stack.TransportProtocolOption(ProtocolNumber, &oooLimit); err == nil {
+		r.oooLimit = int(oooLimit)
+	}
+
+	r.delAckTimer.init(&r.delAckWaker)
+	return r
 }
 
 // acceptable checks if the segment sequence number range is acceptable
@@ -184,6 +253,29 @@ func (r *receiver) getSendParams() (rcvNxt seqnum.Value, rcvWnd seqnum.Size) {
 	return r.rcvNxt, scaledWnd
 }
 
+// ackIfNeeded acknowledges the data received since the last acknowledgment was
+// sent. Unless TCP_QUICKACK is enabled, the acknowledgment is delayed until
+// enough segments are received or the delayed ACK timer expires, as per
+// RFC 1122 section 4.2.3.2 and RFC 5681 section 4.2.
+func (r *receiver) ackIfNeeded() {
+	if r.rcvNxt == r.ep.snd.maxSentAck {
+		return
+	}
+	if r.delAckTimeout == 0 || r.unackedSegs == 0 || r.unackedSegs >= r.delAckSegs || r.ep.ops.GetQuickAck() {
+		r.ep.snd.sendAck()
+		return
+	}
+	if !r.delAckTimer.enabled() {
+		r.delAckTimer.enable(r.delAckTimeout)
+	}
+}
+
+// ackSent is called when a segment acknowledging rcvNxt is sent.
+func (r *receiver) ackSent() {
+	r.unackedSegs = 0
+	r.delAckTimer.disable()
+}
+
 // nonZeroWindow is called when the receive window grows from zero to nonzero;
 // in such cases we may need to send an ack to indicate to our peer that it can
 // resume sending data.
@@ -225,6 +317,7 @@ func (r *receiver) consumeSegment(s *segment, segSeq seqnum.Value, segLen seqnum
 
 	// Update the segment that we're expecting to consume.
 	r.rcvNxt = segSeq.Add(segLen)
+	r.bytesReceived += uint64(segLen)
 
 	// In cases of a misbehaving sender which could send more than the
 	// advertised window, we could end up in a situation where we get a
@@ -264,12 +357,14 @@ func (r *receiver) consumeSegment(s *segment, segSeq seqnum.Value, segLen seqnum
 		case StateFinWait1:
 			if s.flagIsSet(header.TCPFlagAck) {
 				// FIN-ACK, transition to TIME-WAIT.
+				r.ep.saveMetricsLocked()
 				r.ep.setEndpointState(StateTimeWait)
 			} else {
 				// Simultaneous close, expecting a final ACK.
 				r.ep.setEndpointState(StateClosing)
 			}
 		case StateFinWait2:
+			r.ep.saveMetricsLocked()
 			r.ep.setEndpointState(StateTimeWait)
 		}
 
@@ -307,8 +402,10 @@ func (r *receiver) consumeSegment(s *segment, segSeq seqnum.Value, segLen seqnum
 			// not close within 2MSL.
 			r.ep.notifyProtocolGoroutine(notifyClose)
 		case StateClosing:
+			r.ep.saveMetricsLocked()
 			r.ep.setEndpointState(StateTimeWait)
 		case StateLastAck:
+			r.ep.saveMetricsLocked()
 			r.ep.transitionToStateCloseLocked()
 		}
 	}
@@ -467,26 +564,20 @@ func (r *receiver) handleRcvdSegment(s *segment) (drop bool, err *tcpip.Error) {
 		}
 	}
 
+	r.handleECN(s)
+
 	// Store the time of the last ack.
 	r.lastRcvdAckTime = time.Now()
+	if segLen > 0 {
+		r.lastRcvdDataTime = r.lastRcvdAckTime
+		r.dataSegsIn++
+		r.unackedSegs++
+	}
 
 	// Defer segment processing if it can't be consumed now.
 	if !r.consumeSegment(s, segSeq, segLen) {
 		if segLen > 0 || s.flagIsSet(header.TCPFlagFin) {
-			// We only store the segment if it's within our buffer size limit.
-			//
-			// Only use 75% of the receive buffer queue for out-of-order
-			// segments. This ensures that we always leave some space for the inorder
-			// segments to arrive allowing pending segments to be processed and
-			// delivered to the user.
-			if r.ep.receiveBufferAvailable() > 0 && r.pendingBufUsed < r.ep.receiveBufferSize()>>2 {
-				r.ep.rcvListMu.Lock()
-				r.pendingBufUsed += s.segMemSize()
-				r.ep.rcvListMu.Unlock()
-				s.incRef()
-				heap.Push(&r.pendingRcvdSegments, s)
-				UpdateSACKBlocks(&r.ep.sack, segSeq, segSeq.Add(segLen), r.rcvNxt)
-			}
+			r.queueOutOfOrder(s, segSeq, segLen)
 
 			// Immediately send an ack so that the peer knows it may
 			// have to retransmit.
@@ -524,6 +615,87 @@ func (r *receiver) handleRcvdSegment(s *segment) (drop bool, err *tcpip.Error) {
 	return false, nil
 }
 
+// outOfOrderLimit returns the maximum number of bytes held in the out-of-order
+// queue.
+//
+// Only a quarter of the receive buffer is used for out-of-order segments. This
+// ensures that we always leave some space for the in-order segments to arrive
+// allowing pending segments to be processed and delivered to the user.
+func (r *receiver) outOfOrderLimit() int {
+	limit := r.ep.receiveBufferSize() >> 2
+	if limit > r.oooLimit {
+		limit = r.oooLimit
+	}
+	return limit
+}
+
+// queueOutOfOrder stores the out-of-order segment s until the preceding data
+// is received, if it's within the out-of-order queue limit.
+//
+// When the queue is full, the queued segments beyond s are pruned to make room
+// for it, as in Linux's tcp_prune_ofo_queue, since s is more useful to fill
+// the hole at the left edge of the window. The peer is told that the data of
+// the pruned segments is reneged on by clearing the SACK blocks, which must
+// not be relied upon as per RFC 2018 section 8.
+func (r *receiver) queueOutOfOrder(s *segment, segSeq seqnum.Value, segLen seqnum.Size) {
+	stats := r.ep.stack.Stats().TCP
+	if r.ep.receiveBufferAvailable() <= 0 {
+		stats.OutOfOrderDropped.Increment()
+		r.ep.stats.OutOfOrder.Dropped.Increment()
+		return
+	}
+
+	limit := r.outOfOrderLimit()
+	pruned := false
+	for r.pendingBufUsed >= limit {
+		last := r.lastOutOfOrder()
+		if last < 0 || !segSeq.LessThan(r.pendingRcvdSegments[last].sequenceNumber) {
+			break
+		}
+		p := heap.Remove(&r.pendingRcvdSegments, last).(*segment)
+		r.ep.rcvListMu.Lock()
+		r.pendingBufUsed -= p.segMemSize()
+		r.ep.rcvListMu.Unlock()
+		p.decRef()
+		pruned = true
+		stats.OutOfOrderPruned.Increment()
+		r.ep.stats.OutOfOrder.Pruned.Increment()
+	}
+	if pruned {
+		r.ep.sack.NumBlocks = 0
+	}
+	if r.pendingBufUsed >= limit {
+		stats.OutOfOrderDropped.Increment()
+		r.ep.stats.OutOfOrder.Dropped.Increment()
+		return
+	}
+
+	r.ep.rcvListMu.Lock()
+	r.pendingBufUsed += s.segMemSize()
+	r.ep.rcvListMu.Unlock()
+	s.incRef()
+	heap.Push(&r.pendingRcvdSegments, s)
+	r.oooPackets++
+	stats.OutOfOrderQueued.Increment()
+	r.ep.stats.OutOfOrder.Queued.Increment()
+	if depth, maxDepth := uint64(r.pendingRcvdSegments.Len()), r.ep.stats.OutOfOrder.MaxDepth.Value(); depth > maxDepth {
+		r.ep.stats.OutOfOrder.MaxDepth.IncrementBy(depth - maxDepth)
+	}
+	UpdateSACKBlocks(&r.ep.sack, segSeq, segSeq.Add(segLen), r.rcvNxt)
+}
+
+// lastOutOfOrder returns the index of the queued out-of-order segment with the
+// highest sequence number, or -1 if the queue is empty.
+func (r *receiver) lastOutOfOrder() int {
+	last := -1
+	for i, s := range r.pendingRcvdSegments {
+		if last < 0 || r.pendingRcvdSegments[last].sequenceNumber.LessThan(s.sequenceNumber) {
+			last = i
+		}
+	}
+	return last
+}
+
 // handleTimeWaitSegment handles inbound segments received when the endpoint
 // has entered the TIME_WAIT state.
 func (r *receiver) handleTimeWaitSegment(s *segment) (resetTimeWait bool, newSyn bool) {
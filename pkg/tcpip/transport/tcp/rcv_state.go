@@ -27,3 +27,18 @@ func (r *receiver) saveLastRcvdAckTime() unixTime {
 func (r *receiver) loadLastRcvdAckTime(unix unixTime) {
 	r.lastRcvdAckTime = time.Unix(unix.second, unix.nano)
 }
+
+// saveLastRcvdDataTime is invoked by stateify.
+func (r *receiver) saveLastRcvdDataTime() unixTime {
+	return unixTime{r.lastRcvdDataTime.Unix(), r.lastRcvdDataTime.UnixNano()}
+}
+
+// loadLastRcvdDataTime is invoked by stateify.
+func (r *receiver) loadLastRcvdDataTime(unix unixTime) {
+	r.lastRcvdDataTime = time.Unix(unix.second, unix.nano)
+}
+
+// afterLoad is invoked by stateify.
+func (r *receiver) afterLoad() {
+	r.delAckTimer.init(&r.delAckWaker)
+}
@@ -0,0 +1,305 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/seqnum"
+)
+
+// The repair mode of an endpoint, as per Linux's TCP_REPAIR, lets the state of
+// an established connection be saved and restored by the user:
+//
+//  - The sequence numbers of the connection are set with
+//    tcpip.TCPQueueSeqOption before the endpoint is connected, which moves it
+//    straight to the established state without a handshake.
+//  - The options negotiated by the handshake and the windows are restored
+//    with tcpip.TCPRepairOptionsOption and tcpip.TCPRepairWindowOption.
+//  - The data of the receive and send queues are peeked and written to the
+//    queue selected by tcpip.TCPRepairQueueOption.
+//
+// An endpoint in repair mode doesn't send any segment: data written to the
+// send queue is considered sent as far as the window allows, and is
+// retransmitted once the endpoint leaves repair mode. Closing it discards the
+// connection without notifying the peer.
+
+// setRepairLocked enters or leaves repair mode.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) setRepairLocked(v int) *tcpip.Error {
+	switch v {
+	case tcpip.TCPRepairOn:
+		if e.EndpointState() == StateListen {
+			return tcpip.ErrNotPermitted
+		}
+		e.repair = true
+	case tcpip.TCPRepairOff, tcpip.TCPRepairOffNoWindowProbe:
+		if !e.repair {
+			return nil
+		}
+		e.repair = false
+		e.repairQueue = tcpip.TCPNoQueue
+		// As in Linux, a window probe makes the peer advertise its
+		// current window and acknowledge the data it received.
+		if v == tcpip.TCPRepairOff && e.EndpointState() == StateEstablished {
+			ack, win := e.rcv.getSendParams()
+			e.sendRaw(buffer.VectorisedView{}, header.TCPFlagAck, e.snd.sndUna-1, ack, win)
+		}
+	default:
+		return tcpip.ErrInvalidOptionValue
+	}
+	return nil
+}
+
+// setRepairQueueLocked selects the queue operated on in repair mode.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) setRepairQueueLocked(v int) *tcpip.Error {
+	if !e.repair {
+		return tcpip.ErrNotPermitted
+	}
+	switch v {
+	case tcpip.TCPNoQueue, tcpip.TCPRecvQueue, tcpip.TCPSendQueue:
+		e.repairQueue = v
+		return nil
+	default:
+		return tcpip.ErrInvalidOptionValue
+	}
+}
+
+// setQueueSeqLocked sets the sequence number of the queue selected by
+// tcpip.TCPRepairQueueOption, for an endpoint in repair mode that isn't
+// connected yet.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) setQueueSeqLocked(v int) *tcpip.Error {
+	if !e.repair {
+		return tcpip.ErrNotPermitted
+	}
+	switch e.EndpointState() {
+	case StateInitial, StateBound:
+	default:
+		return tcpip.ErrNotPermitted
+	}
+	switch e.repairQueue {
+	case tcpip.TCPSendQueue:
+		e.repairSndSeq = seqnum.Value(v)
+	case tcpip.TCPRecvQueue:
+		e.repairRcvSeq = seqnum.Value(v)
+	default:
+		return tcpip.ErrInvalidOptionValue
+	}
+	return nil
+}
+
+// queueSeqLocked returns the sequence number of the queue selected by
+// tcpip.TCPRepairQueueOption: the sequence number following the data written
+// to the send queue, or the next sequence number expected by the receive
+// queue.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) queueSeqLocked() (int, *tcpip.Error) {
+	connected := e.snd != nil && e.rcv != nil
+	switch e.repairQueue {
+	case tcpip.TCPSendQueue:
+		if !connected {
+			return int(e.repairSndSeq), nil
+		}
+		e.sndBufMu.Lock()
+		seq := e.snd.sndUna.Add(seqnum.Size(e.sndBufUsed))
+		e.sndBufMu.Unlock()
+		return int(seq), nil
+	case tcpip.TCPRecvQueue:
+		if !connected {
+			return int(e.repairRcvSeq), nil
+		}
+		return int(e.rcv.rcvNxt), nil
+	default:
+		return 0, tcpip.ErrInvalidOptionValue
+	}
+}
+
+// repairHandshake returns the handshake state an endpoint in repair mode is
+// established with, from the sequence numbers restored by the user. The
+// options are those of a peer that doesn't support any, until they are
+// restored with tcpip.TCPRepairOptionsOption, and the send window is closed
+// until it is restored or updated by the peer.
+func (e *endpoint) repairHandshake() *handshake {
+	return &handshake{
+		ep:          e,
+		iss:         e.repairSndSeq - 1,
+		ackNum:      e.repairRcvSeq,
+		rcvWnd:      seqnum.Size(e.initialReceiveWindow()),
+		sndWndScale: -1,
+		mss:         header.TCPDefaultMSS,
+	}
+}
+
+// setRepairOptionsLocked restores some of the options negotiated by the
+// handshake of a connection established in repair mode, before any data is
+// sent.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) setRepairOptionsLocked(opts *tcpip.TCPRepairOptionsOption) *tcpip.Error {
+	if !e.repair {
+		return tcpip.ErrInvalidOptionValue
+	}
+	if e.EndpointState() != StateEstablished || e.snd.writeList.Front() != nil {
+		return tcpip.ErrNotPermitted
+	}
+	if opts.MSS != 0 && (opts.MSS < header.TCPMinimumMSS || opts.MSS > header.TCPMaximumMSS) {
+		return tcpip.ErrInvalidOptionValue
+	}
+	if opts.WindowScale && (opts.SndWndScale > header.MaxWndScale || opts.RcvWndScale > header.MaxWndScale) {
+		return tcpip.ErrInvalidOptionValue
+	}
+
+	mss := int(opts.MSS)
+	if mss == 0 {
+		mss = e.snd.maxPayloadSize + e.maxOptionSize()
+	}
+	if opts.Timestamp {
+		e.sendTSOk = true
+	}
+	if opts.SACKPermitted {
+		e.sackPermitted = true
+	}
+	if opts.WindowScale {
+		e.snd.sndWndScale = opts.SndWndScale
+		e.rcv.rcvWndScale = opts.RcvWndScale
+	}
+
+	// The payload size depends on the options sent with every segment, so
+	// it is computed once they are known.
+	e.snd.maxPayloadSize = mss - e.maxOptionSize()
+	e.snd.updateMaxPayloadSize(int(e.route.MTU()), 0)
+	e.scoreboard.smss = uint16(e.snd.maxPayloadSize)
+	if e.snd.gso {
+		e.gso.MSS = uint16(e.snd.maxPayloadSize)
+	}
+	return nil
+}
+
+// repairWindowLocked returns the window state of a connection in repair mode.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) repairWindowLocked(w *tcpip.TCPRepairWindowOption) *tcpip.Error {
+	if !e.repair {
+		return tcpip.ErrNotPermitted
+	}
+	if !e.EndpointState().connected() {
+		return tcpip.ErrNotConnected
+	}
+	// Window updates from any segment are accepted, as if the last one
+	// came with the next sequence number expected.
+	*w = tcpip.TCPRepairWindowOption{
+		SndWl1:    uint32(e.rcv.rcvNxt),
+		SndWnd:    uint32(e.snd.sndWnd),
+		MaxWindow: uint32(e.snd.maxSndWnd),
+		RcvWnd:    uint32(e.rcv.rcvWnd),
+		RcvWup:    uint32(e.rcv.rcvWUP),
+	}
+	return nil
+}
+
+// setRepairWindowLocked restores the window state of a connection in repair
+// mode.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) setRepairWindowLocked(w *tcpip.TCPRepairWindowOption) *tcpip.Error {
+	if !e.repair {
+		return tcpip.ErrNotPermitted
+	}
+	if !e.EndpointState().connected() {
+		return tcpip.ErrNotConnected
+	}
+	rcvWup := seqnum.Value(w.RcvWup)
+	if e.rcv.rcvNxt.Add(seqnum.Size(w.RcvWnd)).LessThan(seqnum.Value(w.SndWl1)) || e.rcv.rcvNxt.LessThan(rcvWup) || w.MaxWindow < w.SndWnd {
+		return tcpip.ErrInvalidOptionValue
+	}
+
+	e.snd.sndWnd = seqnum.Size(w.SndWnd)
+	e.snd.maxSndWnd = seqnum.Size(w.MaxWindow)
+	e.rcvListMu.Lock()
+	e.rcv.rcvWnd = seqnum.Size(w.RcvWnd)
+	e.rcv.rcvWUP = rcvWup
+	e.rcv.rcvAcc = rcvWup.Add(e.rcv.rcvWnd)
+	if e.rcv.rcvAcc.LessThan(e.rcv.rcvNxt) {
+		e.rcv.rcvAcc = e.rcv.rcvNxt
+	}
+	e.rcvListMu.Unlock()
+	return nil
+}
+
+// writeRecvQueueLocked appends the payload of p to the receive queue of a
+// connection in repair mode, as if it had been received from the peer.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) writeRecvQueueLocked(p tcpip.Payloader) (int64, *tcpip.Error) {
+	e.rcvListMu.Lock()
+	avail := e.receiveBufferAvailableLocked()
+	e.rcvListMu.Unlock()
+	if avail == 0 {
+		return 0, tcpip.ErrNoBufferSpace
+	}
+
+	v, err := fetchPayload(p, avail, false /* borrow */)
+	if err != nil || len(v) == 0 {
+		return 0, err
+	}
+
+	s := newOutgoingSegment(e.ID, v)
+	s.sequenceNumber = e.rcv.rcvNxt
+	e.rcv.consumeSegment(s, s.sequenceNumber, seqnum.Size(len(v)))
+	s.decRef()
+	return int64(len(v)), nil
+}
+
+// peekSendQueueLocked copies the data written to the send queue of a
+// connection in repair mode which hasn't been acknowledged yet to vec.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) peekSendQueueLocked(vec [][]byte) int64 {
+	e.sndBufMu.Lock()
+	defer e.sndBufMu.Unlock()
+
+	// Make a copy of vec so we can modify the slide headers.
+	vec = append([][]byte(nil), vec...)
+
+	var num int64
+	for _, l := range []*segmentList{&e.snd.writeList, &e.sndQueue} {
+		for s := l.Front(); s != nil; s = s.Next() {
+			for _, v := range s.data.Views() {
+				for len(v) > 0 {
+					if len(vec) == 0 {
+						return num
+					}
+					if len(vec[0]) == 0 {
+						vec = vec[1:]
+						continue
+					}
+
+					n := copy(vec[0], v)
+					v = v[n:]
+					vec[0] = vec[0][n:]
+					num += int64(n)
+				}
+			}
+		}
+	}
+	return num
+}
@@ -68,6 +68,14 @@ type segment struct {
 	ackNumber      seqnum.Value
 	flags          uint8
 	window         seqnum.Size
+
+	// ae is the Accurate ECN flag, which isn't part of flags. It is only
+	// populated for received segments.
+	ae bool
+
+	// ecn is the ECN codepoint of the IP header of a received segment.
+	ecn uint8
+
 	// csum is only populated for received segments.
 	csum uint16
 	// csumValid is true if the csum in the received segment is valid.
@@ -82,8 +90,20 @@ type segment struct {
 	xmitTime  time.Time `state:".(unixTime)"`
 	xmitCount uint32
 
+	// txDelivered, txDeliveredTime, txFirstSentTime and txAppLimited are
+	// snapshots of the sender's delivery rate state taken when the segment
+	// was last transmitted. See deliveryRate.
+	txDelivered     int
+	txDeliveredTime time.Time `state:".(unixTime)"`
+	txFirstSentTime time.Time `state:".(unixTime)"`
+	txAppLimited    bool
+
 	// acked indicates if the segment has already been SACKed.
 	acked bool
+
+	// more is set if the data of an outgoing segment was written with
+	// MSG_MORE.
+	more bool
 }
 
 func newIncomingSegment(id stack.TransportEndpointID, pkt *stack.PacketBuffer) *segment {
@@ -97,6 +117,8 @@ func newIncomingSegment(id stack.TransportEndpointID, pkt *stack.PacketBuffer) *
 		nicID:          pkt.NICID,
 		remoteLinkAddr: pkt.SourceLinkAddress(),
 	}
+	tos, _ := netHdr.TOS()
+	s.ecn = tos & header.IPECNMask
 	s.data = pkt.Data.Clone(s.views[:])
 	s.hdr = header.TCP(pkt.TransportHeader().View())
 	s.rcvdTime = time.Now()
@@ -133,6 +155,7 @@ func (s *segment) clone() *segment {
 		xmitCount:      s.xmitCount,
 		ep:             s.ep,
 		qFlags:         s.qFlags,
+		more:           s.more,
 	}
 	t.data = s.data.Clone(t.views[:])
 	return t
@@ -251,6 +274,7 @@ func (s *segment) parse(skipChecksumValidation bool) bool {
 	s.sequenceNumber = seqnum.Value(s.hdr.SequenceNumber())
 	s.ackNumber = seqnum.Value(s.hdr.AckNumber())
 	s.flags = s.hdr.Flags()
+	s.ae = s.hdr.AE()
 	s.window = seqnum.Size(s.hdr.WindowSize())
 	return true
 }
@@ -80,3 +80,23 @@ func (s *segment) saveXmitTime() unixTime {
 func (s *segment) loadXmitTime(unix unixTime) {
 	s.rcvdTime = time.Unix(unix.second, unix.nano)
 }
+
+// saveTxDeliveredTime is invoked by stateify.
+func (s *segment) saveTxDeliveredTime() unixTime {
+	return unixTime{s.txDeliveredTime.Unix(), s.txDeliveredTime.UnixNano()}
+}
+
+// loadTxDeliveredTime is invoked by stateify.
+func (s *segment) loadTxDeliveredTime(unix unixTime) {
+	s.txDeliveredTime = time.Unix(unix.second, unix.nano)
+}
+
+// saveTxFirstSentTime is invoked by stateify.
+func (s *segment) saveTxFirstSentTime() unixTime {
+	return unixTime{s.txFirstSentTime.Unix(), s.txFirstSentTime.UnixNano()}
+}
+
+// loadTxFirstSentTime is invoked by stateify.
+func (s *segment) loadTxFirstSentTime(unix unixTime) {
+	s.txFirstSentTime = time.Unix(unix.second, unix.nano)
+}
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"math"
 	"sort"
+	"sync/atomic"
 	"time"
 
 	"gvisor.dev/gvisor/pkg/sleep"
@@ -42,10 +43,32 @@ const (
 	// before fast-retransmit is entered.
 	nDupAckThreshold = 3
 
+	// maxReordering is the maximum reordering degree, in segments, reported
+	// by TCP_INFO.
+	// Linux default net.ipv4.tcp_max_reordering.
+	maxReordering = 300
+
 	// MaxRetries is the maximum number of probe retries sender does
 	// before timing out the connection.
 	// Linux default TCP_RETR2, net.ipv4.tcp_retries2.
 	MaxRetries = 15
+
+	// corkTimeout is the maximum time for which partial segments are held
+	// back by TCP_CORK or MSG_MORE, as in Linux.
+	corkTimeout = 200 * time.Millisecond
+
+	// mtuProbingRetries is the number of consecutive retransmission
+	// timeouts after which a path MTU black hole is suspected, as Linux's
+	// net.ipv4.tcp_retries1.
+	mtuProbingRetries = 3
+
+	// mtuProbingBaseMSS is the MSS used first when a path MTU black hole
+	// is suspected, as Linux's net.ipv4.tcp_base_mss.
+	mtuProbingBaseMSS = 1024
+
+	// mtuProbingMinMSS is the minimum MSS to which black hole detection
+	// reduces the MSS, as Linux's net.ipv4.tcp_mtu_probe_floor.
+	mtuProbingMinMSS = 48
 )
 
 // ccState indicates the current congestion control state for this sender.
@@ -140,6 +163,9 @@ type sender struct {
 	// sndWnd is the send window size.
 	sndWnd seqnum.Size
 
+	// maxSndWnd is the largest send window advertised by the peer.
+	maxSndWnd seqnum.Size
+
 	// sndUna is the next unacknowledged sequence number.
 	sndUna seqnum.Value
 
@@ -209,6 +235,81 @@ type sender struct {
 	// rc has the fields needed for implementing RACK loss detection
 	// algorithm.
 	rc rackControl
+
+	// dr holds the delivery rate estimation state.
+	dr deliveryRate
+
+	// pacingRate is the rate, in bytes per second, at which the sender
+	// paces out segments. Zero disables pacing. It is set by congestion
+	// control algorithms which rely on pacing, e.g. BBR.
+	pacingRate uint64
+
+	// pacingNextSend is the earliest time at which the next segment may be
+	// sent when pacing is enabled.
+	pacingNextSend time.Time `state:"nosave"`
+
+	// pacingTimer and pacingWaker are used to resume sending once the
+	// pacing delay has elapsed.
+	pacingTimer timer       `state:"nosave"`
+	pacingWaker sleep.Waker `state:"nosave"`
+
+	// corkTimer and corkWaker are used to send the partial segment held
+	// back by TCP_CORK or MSG_MORE once it was held for corkTimeout.
+	corkTimer timer       `state:"nosave"`
+	corkWaker sleep.Waker `state:"nosave"`
+
+	// corkExpired is set while the partial segment held back by TCP_CORK
+	// or MSG_MORE is sent because the cork timer expired.
+	corkExpired bool
+
+	// slowStartAfterIdle and mtuProbing are the stack-wide
+	// tcp_slow_start_after_idle and tcp_mtu_probing tunables.
+	slowStartAfterIdle bool
+	mtuProbing         bool
+
+	// The following fields are only used to report statistics through
+	// TCP_INFO.
+
+	// retransmits is the number of consecutive retransmission timeouts
+	// since data was last acknowledged. It is also used to detect path MTU
+	// black holes.
+	retransmits uint8
+
+	// bytesSent, bytesRetrans and bytesAcked are the number of bytes sent
+	// (including retransmissions), retransmitted and cumulatively
+	// acknowledged.
+	bytesSent    uint64
+	bytesRetrans uint64
+	bytesAcked   uint64
+
+	// dataSegsOut is the number of packets sent with data.
+	dataSegsOut uint32
+
+	// dsackDups is the number of D-SACK blocks received.
+	dsackDups uint32
+
+	// lastDataSendTime is the time at which data was last sent.
+	lastDataSendTime time.Time `state:".(unixTime)"`
+
+	// chrono tracks what limits the sender while it is busy.
+	chrono chrono
+
+	// ecnRecover is sndNxt at the time the congestion window was last
+	// reduced in response to ECN feedback. Further feedback is ignored
+	// until it is acknowledged.
+	ecnRecover seqnum.Value
+
+	// ecnCWRPending is set if the next new data segment must carry the CWR
+	// flag.
+	ecnCWRPending bool
+
+	// ecnCEP is the number of CE marked packets reported by an Accurate
+	// ECN peer, starting at accECNInitialCEP.
+	ecnCEP uint32
+
+	// deliveredCE is the number of packets delivered with ECN feedback
+	// reporting congestion.
+	deliveredCE uint32
 }
 
 // rtt is a synchronization wrapper used to appease stateify. See the comment
@@ -264,6 +365,7 @@ func newSender(ep *endpoint, iss, irs seqnum.Value, sndWnd seqnum.Size, mss uint
 	s := &sender{
 		ep:               ep,
 		sndWnd:           sndWnd,
+		maxSndWnd:        sndWnd,
 		sndUna:           iss + 1,
 		sndNxt:           iss + 1,
 		rto:              1 * time.Second,
@@ -278,9 +380,15 @@ func newSender(ep *endpoint, iss, irs seqnum.Value, sndWnd seqnum.Size, mss uint
 			rescueRxt: iss,
 		},
 		rc: rackControl{
-			fack: iss,
+			fack:       iss,
+			reordering: nDupAckThreshold,
 		},
 		gso: ep.gso != nil,
+		dr: deliveryRate{
+			deliveredTime: time.Now(),
+		},
+		ecnRecover: iss + 1,
+		ecnCEP:     accECNInitialCEP,
 	}
 
 	if s.gso {
@@ -298,6 +406,8 @@ func newSender(ep *endpoint, iss, irs seqnum.Value, sndWnd seqnum.Size, mss uint
 	}
 
 	s.resendTimer.init(&s.resendWaker)
+	s.pacingTimer.init(&s.pacingWaker)
+	s.corkTimer.init(&s.corkWaker)
 
 	s.updateMaxPayloadSize(int(ep.route.MTU()), 0)
 
@@ -325,6 +435,12 @@ func newSender(ep *endpoint, iss, irs seqnum.Value, sndWnd seqnum.Size, mss uint
 	}
 	s.maxRetries = uint32(maxRetries)
 
+	var tunables tcpip.TCPTunablesOption
+	if err := ep.stack.TransportProtocolOption(ProtocolNumber, &tunables); err == nil {
+		s.slowStartAfterIdle = tunables.SlowStartAfterIdle
+		s.mtuProbing = tunables.MTUProbing
+	}
+
 	return s
 }
 
@@ -334,10 +450,13 @@ func newSender(ep *endpoint, iss, irs seqnum.Value, sndWnd seqnum.Size, mss uint
 func (s *sender) initCongestionControl(congestionControlName tcpip.CongestionControlOption) congestionControl {
 	s.sndCwnd = InitialCwnd
 	s.sndSsthresh = math.MaxInt64
+	s.pacingRate = 0
 
 	switch congestionControlName {
 	case ccCubic:
 		return newCubicCC(s)
+	case ccBBR:
+		return newBBRCC(s)
 	case ccReno:
 		fallthrough
 	default:
@@ -414,9 +533,34 @@ func (s *sender) updateMaxPayloadSize(mtu, count int) {
 	s.sendData()
 }
 
+// reduceMaxPayloadSizeForBlackhole reduces the maximum payload size in case
+// full sized segments are dropped by a path MTU black hole, as Linux's
+// tcp_mtu_probing. The payload size is first reduced to mtuProbingBaseMSS,
+// and then halved on each further retransmission timeout.
+func (s *sender) reduceMaxPayloadSizeForBlackhole() {
+	m := s.maxPayloadSize
+	if m > mtuProbingBaseMSS {
+		m = mtuProbingBaseMSS
+	} else {
+		m /= 2
+	}
+	if m < mtuProbingMinMSS {
+		m = mtuProbingMinMSS
+	}
+	if m >= s.maxPayloadSize {
+		return
+	}
+
+	s.maxPayloadSize = m
+	if s.gso {
+		s.ep.gso.MSS = uint16(m)
+	}
+	s.ep.scoreboard.smss = uint16(m)
+}
+
 // sendAck sends an ACK segment.
 func (s *sender) sendAck() {
-	s.sendSegmentFromView(buffer.VectorisedView{}, header.TCPFlagAck, s.sndNxt)
+	s.sendSegmentFromView(buffer.VectorisedView{}, header.TCPFlagAck, s.sndNxt, false /* ect */)
 }
 
 // updateRTO updates the retransmit timeout when a new roud-trip time is
@@ -548,6 +692,13 @@ func (s *sender) retransmitTimerExpired() bool {
 		return false
 	}
 
+	if s.retransmits < math.MaxUint8 {
+		s.retransmits++
+	}
+	if s.mtuProbing && s.retransmits >= mtuProbingRetries {
+		s.reduceMaxPayloadSizeForBlackhole()
+	}
+
 	// Set new timeout. The timer will be restarted by the call to sendData
 	// below.
 	s.rto *= 2
@@ -782,7 +933,9 @@ func (s *sender) maybeSendSegment(seg *segment, limit int, end seqnum.Value) (se
 	// We abuse the flags field to determine if we have already
 	// assigned a sequence number to this segment.
 	if !s.isAssignedSequenceNumber(seg) {
-		// Merge segments if allowed.
+		// Merge segments if allowed. The segments of Multipath TCP
+		// subflows are never merged, so that each of them is covered
+		// by a single data sequence mapping.
 		if seg.data.Size() != 0 {
 			available := int(s.sndNxt.Size(end))
 			if available > limit {
@@ -800,12 +953,13 @@ func (s *sender) maybeSendSegment(seg *segment, limit int, end seqnum.Value) (se
 			// triggering bugs in poorly written DNS
 			// implementations.
 			var nextTooBig bool
-			for seg.Next() != nil && seg.Next().data.Size() != 0 {
+			for s.ep.subflow == nil && seg.Next() != nil && seg.Next().data.Size() != 0 {
 				if seg.data.Size()+seg.Next().data.Size() > available {
 					nextTooBig = true
 					break
 				}
 				seg.data.Append(seg.Next().data)
+				seg.more = seg.Next().more
 
 				// Consume the segment that we just merged in.
 				s.writeList.Remove(seg.Next())
@@ -827,11 +981,22 @@ func (s *sender) maybeSendSegment(seg *segment, limit int, end seqnum.Value) (se
 					//   sent all at once.
 					return false
 				}
-				// With TCP_CORK, hold back until minimum of the available
-				// send space and MSS.
-				// TODO(gvisor.dev/issue/2833): Drain the held segments after a
-				// timeout.
-				if seg.data.Size() < s.maxPayloadSize && s.ep.ops.GetCorkOption() {
+				// With TCP_CORK or MSG_MORE, hold back until minimum
+				// of the available send space and MSS, unless a FIN
+				// follows. As in Linux, the segment is held for at
+				// most corkTimeout.
+				if seg.data.Size() < s.maxPayloadSize && (s.ep.ops.GetCorkOption() || seg.more) && seg.Next() == nil && !s.corkExpired {
+					if !s.corkTimer.enabled() {
+						s.corkTimer.enable(corkTimeout)
+					}
+					return false
+				}
+				// Auto-corking: hold back the segment while earlier
+				// data is still queued below the endpoint, so that
+				// more data can be coalesced into it. It is sent once
+				// the queued data leaves the stack.
+				if s.ep.autocork && s.outstanding > 0 && s.queueThrottled(0) {
+					s.ep.stack.Stats().TCP.AutoCorking.Increment()
 					return false
 				}
 			}
@@ -840,6 +1005,7 @@ func (s *sender) maybeSendSegment(seg *segment, limit int, end seqnum.Value) (se
 		// Assign flags. We don't do it above so that we can merge
 		// additional data if Nagle holds the segment.
 		seg.sequenceNumber = s.sndNxt
+		s.corkTimer.disable()
 		seg.flags = header.TCPFlagAck | header.TCPFlagPsh
 	}
 
@@ -969,6 +1135,10 @@ func (s *sender) postXmit(dataSent bool) {
 		// We sent data, so we should stop the keepalive timer to ensure
 		// that no keepalives are sent while there is pending data.
 		s.ep.disableKeepaliveTimer()
+
+		// Publish the data in flight, which is no longer accounted as
+		// not sent for TCP_NOTSENT_LOWAT.
+		s.ep.updateSndBufferInFlight(int(s.sndUna.Size(s.sndNxt)))
 	}
 
 	// If the sender has advertized zero receive window and we have
@@ -994,6 +1164,9 @@ func (s *sender) sendData() {
 	limit := s.maxPayloadSize
 	if s.gso {
 		limit = int(s.ep.gso.MaxSize - header.TCPHeaderMaximumSize)
+		if segsLimit := int(s.ep.gso.MaxSegs) * s.maxPayloadSize; segsLimit < limit {
+			limit = segsLimit
+		}
 	}
 	end := s.sndUna.Add(s.sndWnd)
 
@@ -1001,7 +1174,7 @@ func (s *sender) sendData() {
 	// "A TCP SHOULD set cwnd to no more than RW before beginning
 	// transmission if the TCP has not sent data in the interval exceeding
 	// the retrasmission timeout."
-	if !s.fr.active && s.state != RTORecovery && time.Now().Sub(s.lastSendTime) > s.rto {
+	if s.slowStartAfterIdle && !s.fr.active && s.state != RTORecovery && time.Now().Sub(s.lastSendTime) > s.rto {
 		if s.sndCwnd > InitialCwnd {
 			s.sndCwnd = InitialCwnd
 		}
@@ -1019,17 +1192,106 @@ func (s *sender) sendData() {
 			s.writeNext = seg.Next()
 			continue
 		}
+		if s.pacingDelayed() {
+			break
+		}
+		if s.outstanding > 0 && s.queueThrottled(s.queueLimit()) {
+			s.ep.stack.Stats().TCP.SmallQueueThrottled.Increment()
+			break
+		}
 		if sent := s.maybeSendSegment(seg, limit, end); !sent {
 			break
 		}
 		dataSent = true
+		s.pace(seg.data.Size())
 		s.outstanding += s.pCount(seg)
 		s.writeNext = seg.Next()
 	}
 
+	s.checkAppLimited()
+	s.updateSendChrono(end)
 	s.postXmit(dataSent)
 }
 
+// sendCorked sends the partial segment held back by TCP_CORK or MSG_MORE once
+// the cork timer expired.
+func (s *sender) sendCorked() {
+	s.corkExpired = true
+	s.sendData()
+	s.corkExpired = false
+}
+
+// pacingDelayed returns true if sending must be deferred to honor the pacing
+// rate, in which case the pacing timer is armed to resume sending.
+func (s *sender) pacingDelayed() bool {
+	if s.pacingRate == 0 {
+		return false
+	}
+	now := time.Now()
+	if !now.Before(s.pacingNextSend) {
+		return false
+	}
+	s.pacingTimer.enable(s.pacingNextSend.Sub(now))
+	return true
+}
+
+// pace advances the pacing schedule after size bytes have been sent.
+func (s *sender) pace(size int) {
+	if s.pacingRate == 0 {
+		return
+	}
+	now := time.Now()
+	if s.pacingNextSend.Before(now) {
+		s.pacingNextSend = now
+	}
+	s.pacingNextSend = s.pacingNextSend.Add(time.Duration(uint64(size) * uint64(time.Second) / s.pacingRate))
+}
+
+// queueLimit returns the number of bytes the endpoint may have queued below it
+// before it stops sending new data: about 1ms worth of data at the sending rate,
+// but at least two segments and at most the stack-wide limit. See
+// tcp_small_queue_check in Linux.
+func (s *sender) queueLimit() int64 {
+	rate := s.pacingRate
+	if rate == 0 {
+		s.rtt.Lock()
+		srtt := s.rtt.srtt
+		s.rtt.Unlock()
+		if srtt > 0 {
+			rate = uint64(s.sndCwnd) * uint64(s.maxPayloadSize) * uint64(time.Second) / uint64(srtt)
+		}
+	}
+	limit := int64(rate >> 10)
+	if min := int64(2 * s.maxPayloadSize); limit < min {
+		limit = min
+	}
+	if max := int64(s.ep.limitOutputBytes); limit > max {
+		limit = max
+	}
+	return limit
+}
+
+// queueThrottled returns true if the endpoint has more than limit bytes queued
+// below it, in which case sending is resumed once some of them leave the
+// stack.
+func (s *sender) queueThrottled(limit int64) bool {
+	if atomic.LoadInt64(&s.ep.queuedBytes) <= limit {
+		return false
+	}
+	atomic.StoreUint32(&s.ep.queueThrottled, 1)
+	// Check again in case the data left the stack before the flag was set.
+	return atomic.LoadInt64(&s.ep.queuedBytes) > limit
+}
+
+// transmitDone is called once size bytes of the endpoint's data segments have
+// left the stack. It may be called from any goroutine.
+func (e *endpoint) transmitDone(size int) {
+	atomic.AddInt64(&e.queuedBytes, -int64(size))
+	if atomic.CompareAndSwapUint32(&e.queueThrottled, 1, 0) {
+		e.notifyProtocolGoroutine(notifyTransmitDone)
+	}
+}
+
 func (s *sender) enterRecovery() {
 	s.fr.active = true
 	// Save state to reflect we're now in fast recovery.
@@ -1184,6 +1446,7 @@ func (s *sender) walkSACK(rcvdSeg *segment) {
 	n := len(rcvdSeg.parsedOptions.SACKBlocks)
 	if s.checkDSACK(rcvdSeg) {
 		s.rc.setDSACKSeen()
+		s.dsackDups++
 		idx = 1
 		n--
 	}
@@ -1205,7 +1468,8 @@ func (s *sender) walkSACK(rcvdSeg *segment) {
 		for seg != nil && seg.sequenceNumber.LessThan(sb.End) && seg.xmitCount != 0 {
 			if sb.Start.LessThanEq(seg.sequenceNumber) && !seg.acked {
 				s.rc.update(seg, rcvdSeg, s.ep.tsOffset)
-				s.rc.detectReorder(seg)
+				s.rc.detectReorder(seg, s.maxPayloadSize)
+				s.onSegmentDelivered(seg)
 				seg.acked = true
 			}
 			seg = seg.Next()
@@ -1260,6 +1524,9 @@ func (s *sender) checkDSACK(rcvdSeg *segment) bool {
 // handleRcvdSegment is called when a segment is received; it is responsible for
 // updating the send-related state.
 func (s *sender) handleRcvdSegment(rcvdSeg *segment) {
+	s.startRateSample()
+	delivered := s.dr.delivered
+
 	// Check if we can extract an RTT measurement from this ack.
 	if !rcvdSeg.parsedOptions.TS && s.rttMeasureSeqNum.LessThan(rcvdSeg.ackNumber) {
 		s.updateRTO(time.Now().Sub(s.rttMeasureTime))
@@ -1325,6 +1592,9 @@ func (s *sender) handleRcvdSegment(rcvdSeg *segment) {
 
 	// Stash away the current window size.
 	s.sndWnd = rcvdSeg.window
+	if s.sndWnd > s.maxSndWnd {
+		s.maxSndWnd = s.sndWnd
+	}
 
 	// Disable zero window probing if remote advertizes a non-zero receive
 	// window. This can be with an ACK to the zero window probe (where the
@@ -1369,6 +1639,8 @@ func (s *sender) handleRcvdSegment(rcvdSeg *segment) {
 		// Remove all acknowledged data from the write list.
 		acked := s.sndUna.Size(ack)
 		s.sndUna = ack
+		s.bytesAcked += uint64(acked)
+		s.retransmits = 0
 
 		ackLeft := acked
 		originalOutstanding := s.outstanding
@@ -1384,6 +1656,9 @@ func (s *sender) handleRcvdSegment(rcvdSeg *segment) {
 				seg.data.TrimFront(int(ackLeft))
 				seg.sequenceNumber.UpdateForward(ackLeft)
 				s.outstanding -= prevCount - s.pCount(seg)
+				if !seg.acked {
+					s.onPacketsDelivered(prevCount - s.pCount(seg))
+				}
 				break
 			}
 
@@ -1394,7 +1669,10 @@ func (s *sender) handleRcvdSegment(rcvdSeg *segment) {
 			// Update the RACK fields if SACK is enabled.
 			if s.ep.sackPermitted && !seg.acked {
 				s.rc.update(seg, rcvdSeg, s.ep.tsOffset)
-				s.rc.detectReorder(seg)
+				s.rc.detectReorder(seg, s.maxPayloadSize)
+			}
+			if !seg.acked {
+				s.onSegmentDelivered(seg)
 			}
 
 			s.writeList.Remove(seg)
@@ -1411,6 +1689,7 @@ func (s *sender) handleRcvdSegment(rcvdSeg *segment) {
 
 		// Update the send buffer usage and notify potential waiters.
 		s.ep.updateSndBufferUsage(int(acked))
+		s.stopChrono(chronoSndBufLimited)
 
 		// Clear SACK information for all acked data.
 		s.ep.scoreboard.Delete(s.sndUna)
@@ -1443,6 +1722,17 @@ func (s *sender) handleRcvdSegment(rcvdSeg *segment) {
 		}
 	}
 
+	// Respond to congestion reported by ECN feedback.
+	s.handleECN(rcvdSeg, s.dr.delivered-delivered)
+
+	// Complete the delivery rate sample, which is also reported by
+	// TCP_INFO, and let congestion control algorithms which rely on
+	// delivery rate estimation act on this ACK.
+	rs := s.generateRateSample()
+	if h, ok := s.cc.(rateSampleHandler); ok {
+		h.HandleRateSample(rs)
+	}
+
 	// Now that we've popped all acknowledged data from the retransmit
 	// queue, retransmit if needed.
 	if s.fr.active {
@@ -1464,6 +1754,15 @@ func (s *sender) handleRcvdSegment(rcvdSeg *segment) {
 
 // sendSegment sends the specified segment.
 func (s *sender) sendSegment(seg *segment) *tcpip.Error {
+	// As per RFC 3168 section 6.1.5, retransmitted segments aren't ECN
+	// capable, and CWR is set on the first new data segment sent after
+	// reducing the congestion window.
+	flags := seg.flags
+	ect := seg.xmitCount == 0 && seg.data.Size() > 0
+	if ect && s.ecnCWRPending {
+		flags |= header.TCPFlagCwr
+		s.ecnCWRPending = false
+	}
 	if seg.xmitCount > 0 {
 		s.ep.stack.Stats().TCP.Retransmits.Increment()
 		s.ep.stats.SendErrors.Retransmits.Increment()
@@ -1472,8 +1771,17 @@ func (s *sender) sendSegment(seg *segment) *tcpip.Error {
 		}
 	}
 	seg.xmitTime = time.Now()
+	s.onSegmentSent(seg, seg.xmitTime)
+	if size := seg.data.Size(); size > 0 {
+		s.bytesSent += uint64(size)
+		if seg.xmitCount > 0 {
+			s.bytesRetrans += uint64(size)
+		}
+		s.dataSegsOut += uint32(s.pCount(seg))
+		s.lastDataSendTime = seg.xmitTime
+	}
 	seg.xmitCount++
-	err := s.sendSegmentFromView(seg.data, seg.flags, seg.sequenceNumber)
+	err := s.sendSegmentFromView(seg.data, flags, seg.sequenceNumber, ect)
 
 	// Every time a packet containing data is sent (including a
 	// retransmission), if SACK is enabled and we are retransmitting data
@@ -1493,8 +1801,9 @@ func (s *sender) sendSegment(seg *segment) *tcpip.Error {
 }
 
 // sendSegmentFromView sends a new segment containing the given payload, flags
-// and sequence number.
-func (s *sender) sendSegmentFromView(data buffer.VectorisedView, flags byte, seq seqnum.Value) *tcpip.Error {
+// and sequence number. The segment is marked ECN capable if ect is true and
+// ECN was negotiated.
+func (s *sender) sendSegmentFromView(data buffer.VectorisedView, flags byte, seq seqnum.Value, ect bool) *tcpip.Error {
 	s.lastSendTime = time.Now()
 	if seq == s.rttMeasureSeqNum {
 		s.rttMeasureTime = s.lastSendTime
@@ -1504,6 +1813,7 @@ func (s *sender) sendSegmentFromView(data buffer.VectorisedView, flags byte, seq
 
 	// Remember the max sent ack.
 	s.maxSentAck = rcvNxt
+	s.ep.rcv.ackSent()
 
-	return s.ep.sendRaw(data, flags, seq, rcvNxt, rcvWnd)
+	return s.ep.sendRawECN(data, flags, seq, rcvNxt, rcvWnd, ect)
 }
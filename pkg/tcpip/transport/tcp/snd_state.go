@@ -47,6 +47,8 @@ func (s *sender) loadRttMeasureTime(unix unixTime) {
 // afterLoad is invoked by stateify.
 func (s *sender) afterLoad() {
 	s.resendTimer.init(&s.resendWaker)
+	s.pacingTimer.init(&s.pacingWaker)
+	s.corkTimer.init(&s.corkWaker)
 }
 
 // saveFirstRetransmittedSegXmitTime is invoked by stateify.
@@ -58,3 +60,23 @@ func (s *sender) saveFirstRetransmittedSegXmitTime() unixTime {
 func (s *sender) loadFirstRetransmittedSegXmitTime(unix unixTime) {
 	s.firstRetransmittedSegXmitTime = time.Unix(unix.second, unix.nano)
 }
+
+// saveLastDataSendTime is invoked by stateify.
+func (s *sender) saveLastDataSendTime() unixTime {
+	return unixTime{s.lastDataSendTime.Unix(), s.lastDataSendTime.UnixNano()}
+}
+
+// loadLastDataSendTime is invoked by stateify.
+func (s *sender) loadLastDataSendTime(unix unixTime) {
+	s.lastDataSendTime = time.Unix(unix.second, unix.nano)
+}
+
+// saveStart is invoked by stateify.
+func (c *chrono) saveStart() unixTime {
+	return unixTime{c.start.Unix(), c.start.UnixNano()}
+}
+
+// loadStart is invoked by stateify.
+func (c *chrono) loadStart(unix unixTime) {
+	c.start = time.Unix(unix.second, unix.nano)
+}
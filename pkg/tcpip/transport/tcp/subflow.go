@@ -0,0 +1,172 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// SubflowSegment describes a segment of a Multipath TCP subflow to its
+// SubflowHandler.
+type SubflowSegment struct {
+	// Flags are the TCP flags of the segment.
+	Flags uint8
+
+	// SSN is the sequence number of the segment, relative to the initial
+	// sequence number of its sender.
+	SSN uint32
+
+	// Len is the length of the payload of the segment.
+	Len int
+
+	// Ack is the acknowledgement number of a received segment, relative
+	// to the initial sequence number of its receiver. It's not set for
+	// outgoing segments.
+	Ack uint32
+
+	// Options are the TCP options of a received segment. They are not set
+	// for outgoing segments.
+	Options []byte
+}
+
+// SubflowHandler handles the options of the segments of a Multipath TCP
+// subflow, as per RFC 8684.
+//
+// The methods of a SubflowHandler are called with the locks of the subflow
+// held; they must not call back into the subflow.
+type SubflowHandler interface {
+	// SynOptions encodes the options to add to the SYN, or SYN-ACK if
+	// synAck is true, of the subflow into b. It returns the number of
+	// bytes written.
+	SynOptions(synAck bool, b []byte) int
+
+	// Options encodes the options to add to the outgoing segment seg into
+	// b. It returns the number of bytes written.
+	Options(seg SubflowSegment, b []byte) int
+
+	// MaxOptionsSize returns the largest number of bytes Options writes.
+	MaxOptionsSize() int
+
+	// HandleSegment is called with each segment received once the subflow
+	// is synchronized, starting with the SYN-ACK of an active opener and
+	// the ACK completing the handshake of a passive opener. It returns
+	// false if the subflow must be reset.
+	HandleSegment(seg SubflowSegment) bool
+}
+
+// SubflowListenHandler creates the SubflowHandlers of the connections
+// accepted by a listening endpoint.
+type SubflowListenHandler interface {
+	// HandleSyn is called with the identifier of the connection and the
+	// options of a SYN received by the listening endpoint. It returns the
+	// handler of the new connection, or nil if it's a regular TCP
+	// connection. If ok is false, the SYN is rejected with a RST.
+	HandleSyn(id stack.TransportEndpointID, synOptions []byte) (h SubflowHandler, ok bool)
+}
+
+// Subflow is implemented by TCP endpoints, which may be used as the subflows
+// of Multipath TCP connections.
+type Subflow interface {
+	tcpip.Endpoint
+
+	// SetSubflowHandler sets the handler of the subflow. It must be called
+	// before the endpoint is connected.
+	SetSubflowHandler(h SubflowHandler)
+
+	// SetSubflowListenHandler sets the handler used to create the
+	// handlers of the subflows accepted by the endpoint. It must be called
+	// before the endpoint starts listening.
+	SetSubflowListenHandler(h SubflowListenHandler)
+
+	// SubflowHandler returns the handler of the subflow.
+	SubflowHandler() SubflowHandler
+
+	// NotifySubflow requests that an ACK carrying the current options of
+	// the handler is sent.
+	NotifySubflow()
+
+	// SendBufferAvailable returns the number of bytes that can be written
+	// to the endpoint without blocking.
+	SendBufferAvailable() int
+}
+
+// SetSubflowHandler implements Subflow.SetSubflowHandler.
+func (e *endpoint) SetSubflowHandler(h SubflowHandler) {
+	e.LockUser()
+	e.subflow = h
+	e.UnlockUser()
+}
+
+// SetSubflowListenHandler implements Subflow.SetSubflowListenHandler.
+func (e *endpoint) SetSubflowListenHandler(h SubflowListenHandler) {
+	e.LockUser()
+	e.subflowListen = h
+	e.UnlockUser()
+}
+
+// SubflowHandler implements Subflow.SubflowHandler.
+func (e *endpoint) SubflowHandler() SubflowHandler {
+	e.LockUser()
+	defer e.UnlockUser()
+	return e.subflow
+}
+
+// NotifySubflow implements Subflow.NotifySubflow.
+func (e *endpoint) NotifySubflow() {
+	e.notifyProtocolGoroutine(notifySubflow)
+}
+
+// SendBufferAvailable implements Subflow.SendBufferAvailable.
+func (e *endpoint) SendBufferAvailable() int {
+	e.sndBufMu.Lock()
+	defer e.sndBufMu.Unlock()
+	if avail := e.sndBufSize - e.sndBufUsed; avail > 0 {
+		return avail
+	}
+	return 0
+}
+
+// handleSubflowSegment passes the received segment s to the subflow handler,
+// if any. It returns false if the subflow must be reset.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) handleSubflowSegment(s *segment) bool {
+	if e.subflow == nil {
+		return true
+	}
+	return e.subflow.HandleSegment(SubflowSegment{
+		Flags:   s.flags,
+		SSN:     uint32(s.sequenceNumber - e.subflowIRS),
+		Len:     s.data.Size(),
+		Ack:     uint32(s.ackNumber - e.subflowISS),
+		Options: s.options,
+	})
+}
+
+// addSubflowSynOptions appends the options of the subflow handler, if any, to
+// the SYN options in options. The returned slice shares the backing array of
+// options.
+func (e *endpoint) addSubflowSynOptions(synAck bool, options []byte) []byte {
+	if e.subflow == nil {
+		return options
+	}
+	offset := len(options)
+	options = options[:maxOptionSize]
+	offset += e.subflow.SynOptions(synAck, options[offset:])
+	offset += header.AddTCPOptionPadding(options, offset)
+	return options[:offset]
+}
@@ -339,6 +339,69 @@ func TestUpdateSACKBlocks(t *testing.T) {
 	}
 }
 
+// TestSackRenegingOutOfOrderPrune verifies that a full out-of-order queue is
+// pruned to make room for a segment closer to the left edge of the window, and
+// that the SACK blocks of the pruned segments are withdrawn.
+func TestSackRenegingOutOfOrderPrune(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	setStackSACKPermitted(t, c, true)
+	// Only allow a single segment in the out-of-order queue.
+	limit := tcpip.TCPOutOfOrderLimitOption(1)
+	if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &limit); err != nil {
+		t.Fatalf("SetTransportProtocolOption(%d, &%T(%d)): %s", tcp.ProtocolNumber, limit, limit, err)
+	}
+	rep := createConnectedWithSACKPermittedOption(c)
+	data := []byte{1, 2, 3}
+	start := rep.NextSeqNum
+	seg := func(i int) seqnum.Value {
+		return start.Add(seqnum.Size(i * len(data)))
+	}
+
+	// Queue the fourth segment.
+	rep.NextSeqNum = seg(3)
+	rep.SendPacket(data, nil)
+	rep.NextSeqNum = start
+	rep.VerifyACKHasSACK([]header.SACKBlock{{seg(3), seg(4)}})
+
+	// The fifth segment is dropped since the queue is full.
+	rep.NextSeqNum = seg(4)
+	rep.SendPacket(data, nil)
+	rep.NextSeqNum = start
+	rep.VerifyACKHasSACK([]header.SACKBlock{{seg(3), seg(4)}})
+
+	// The second segment replaces the fourth one, which is reneged on.
+	rep.NextSeqNum = seg(1)
+	rep.SendPacket(data, nil)
+	rep.NextSeqNum = start
+	rep.VerifyACKHasSACK([]header.SACKBlock{{seg(1), seg(2)}})
+
+	// Send the first segment, which is acknowledged along with the second.
+	rep.SendPacket(data, nil)
+	rep.NextSeqNum = seg(2)
+	rep.VerifyACKNoSACK()
+
+	stats := c.Stack().Stats().TCP
+	epStats := c.EP.Stats().(*tcp.Stats).OutOfOrder
+	for _, s := range []struct {
+		name      string
+		got, want uint64
+	}{
+		{"TCP.OutOfOrderQueued", stats.OutOfOrderQueued.Value(), 2},
+		{"TCP.OutOfOrderDropped", stats.OutOfOrderDropped.Value(), 1},
+		{"TCP.OutOfOrderPruned", stats.OutOfOrderPruned.Value(), 1},
+		{"OutOfOrder.Queued", epStats.Queued.Value(), 2},
+		{"OutOfOrder.Dropped", epStats.Dropped.Value(), 1},
+		{"OutOfOrder.Pruned", epStats.Pruned.Value(), 1},
+		{"OutOfOrder.MaxDepth", epStats.MaxDepth.Value(), 1},
+	} {
+		if s.got != s.want {
+			t.Errorf("got %s = %d, want = %d", s.name, s.got, s.want)
+		}
+	}
+}
+
 func TestTrimSackBlockList(t *testing.T) {
 	testCases := []struct {
 		rcvNxt     seqnum.Value
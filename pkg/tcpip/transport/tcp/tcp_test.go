@@ -1372,7 +1372,6 @@ func TestConnectBindToDevice(t *testing.T) {
 		want   tcp.EndpointState
 	}{
 		{"RightDevice", 1, tcp.StateEstablished},
-		{"WrongDevice", 2, tcp.StateSynSent},
 		{"AnyDevice", 0, tcp.StateEstablished},
 	} {
 		t.Run(test.name, func(t *testing.T) {
@@ -1427,6 +1426,23 @@ func TestConnectBindToDevice(t *testing.T) {
 	}
 }
 
+// TestConnectBindToWrongDevice tests that an endpoint bound to a device
+// doesn't connect through the routes of other devices.
+func TestConnectBindToWrongDevice(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	c.Create(-1)
+	bindToDevice := tcpip.BindToDeviceOption(2)
+	if err := c.EP.SetSockOpt(&bindToDevice); err != nil {
+		t.Fatalf("c.EP.SetSockOpt(&%T(%d)): %s", bindToDevice, bindToDevice, err)
+	}
+
+	if err := c.EP.Connect(tcpip.FullAddress{Addr: context.TestAddr, Port: context.TestPort}); err != tcpip.ErrNoRoute {
+		t.Fatalf("got c.EP.Connect(...) = %s, want = %s", err, tcpip.ErrNoRoute)
+	}
+}
+
 func TestSynSent(t *testing.T) {
 	for _, test := range []struct {
 		name  string
@@ -1635,8 +1651,8 @@ func TestOutOfOrderFlood(t *testing.T) {
 		)
 	}
 
-	// Send packet with seqnum 793. It must be discarded because the
-	// out-of-order buffer was filled by the previous packets.
+	// Send packet with seqnum 793. It must be queued by pruning one of the
+	// previous packets from the full out-of-order buffer.
 	c.SendPacket(data[3:], &context.Headers{
 		SrcPort: context.TestPort,
 		DstPort: c.Port,
@@ -1665,15 +1681,44 @@ func TestOutOfOrderFlood(t *testing.T) {
 		RcvWnd:  30000,
 	})
 
-	// Check that only packet 790 is acknowledged.
+	// Check that all packets are acknowledged.
 	checker.IPv4(t, c.GetPacket(),
 		checker.TCP(
 			checker.DstPort(context.TestPort),
 			checker.TCPSeqNum(uint32(c.IRS)+1),
-			checker.TCPAckNum(793),
+			checker.TCPAckNum(799),
 			checker.TCPFlags(header.TCPFlagAck),
 		),
 	)
+
+	stats := c.EP.Stats().(*tcp.Stats).OutOfOrder
+	if got := stats.Pruned.Value(); got != 1 {
+		t.Errorf("got OutOfOrder.Pruned = %d, want = 1", got)
+	}
+	if got := stats.Dropped.Value(); got == 0 {
+		t.Error("got OutOfOrder.Dropped = 0, want > 0")
+	}
+	if got, want := stats.MaxDepth.Value(), stats.Queued.Value()-stats.Pruned.Value(); got != want {
+		t.Errorf("got OutOfOrder.MaxDepth = %d, want = %d", got, want)
+	}
+}
+
+func TestOutOfOrderLimitOption(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	var v tcpip.TCPOutOfOrderLimitOption
+	if err := c.Stack().TransportProtocolOption(tcp.ProtocolNumber, &v); err != nil {
+		t.Fatalf("TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, v, err)
+	}
+	if want := tcpip.TCPOutOfOrderLimitOption(tcp.DefaultOutOfOrderLimit); v != want {
+		t.Errorf("got TransportProtocolOption(%d, &%T) = %d, want = %d", tcp.ProtocolNumber, v, v, want)
+	}
+	for _, v := range []tcpip.TCPOutOfOrderLimitOption{-1, 0} {
+		if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &v); err != tcpip.ErrInvalidOptionValue {
+			t.Errorf("SetTransportProtocolOption(%d, &%T(%d)) = %s, want = %s", tcp.ProtocolNumber, v, v, err, tcpip.ErrInvalidOptionValue)
+		}
+	}
 }
 
 func TestRstOnCloseWithUnreadData(t *testing.T) {
@@ -2801,6 +2846,123 @@ func TestMSSNotDelayed(t *testing.T) {
 	}
 }
 
+func TestCorkTimeout(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		cork bool
+		more bool
+	}{
+		{name: "cork", cork: true},
+		{name: "more", more: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := context.New(t, defaultMTU)
+			defer c.Cleanup()
+
+			c.CreateConnected(789, 30000, -1 /* epRcvBuf */)
+			c.EP.SocketOptions().SetCorkOption(test.cork)
+
+			data := []byte{1, 2, 3}
+			start := time.Now()
+			view := buffer.NewViewFromBytes(data)
+			if _, _, err := c.EP.Write(tcpip.SlicePayload(view), tcpip.WriteOptions{More: test.more}); err != nil {
+				t.Fatalf("Write failed: %s", err)
+			}
+			c.CheckNoPacketTimeout("partial segment sent without delay", 100*time.Millisecond)
+
+			// The partial segment is sent once the cork timer expires.
+			b := c.GetPacket()
+			if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+				t.Errorf("got partial segment after %s, want at least 200ms", elapsed)
+			}
+			checker.IPv4(t, b,
+				checker.PayloadLen(len(data)+header.TCPMinimumSize),
+				checker.TCP(
+					checker.DstPort(context.TestPort),
+					checker.TCPSeqNum(uint32(c.IRS)+1),
+					checker.TCPAckNum(790),
+				),
+			)
+		})
+	}
+}
+
+func TestMoreWrite(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	c.CreateConnected(789, 30000, -1 /* epRcvBuf */)
+
+	// Data written with MSG_MORE is held back until data is written
+	// without it.
+	allData := [][]byte{{1, 2, 3}, {4, 5}, {6}}
+	for i, data := range allData {
+		more := i != len(allData)-1
+		view := buffer.NewViewFromBytes(data)
+		if _, _, err := c.EP.Write(tcpip.SlicePayload(view), tcpip.WriteOptions{More: more}); err != nil {
+			t.Fatalf("Write #%d failed: %s", i+1, err)
+		}
+		if more {
+			c.CheckNoPacketTimeout("segment written with MSG_MORE sent", 50*time.Millisecond)
+		}
+	}
+
+	b := c.GetPacketWithTimeout(100 * time.Millisecond)
+	if b == nil {
+		t.Fatal("data written without MSG_MORE wasn't sent immediately")
+	}
+	checker.IPv4(t, b,
+		checker.PayloadLen(6+header.TCPMinimumSize),
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPSeqNum(uint32(c.IRS)+1),
+			checker.TCPAckNum(790),
+		),
+	)
+	if got, want := b[header.IPv4MinimumSize+header.TCPMinimumSize:], []byte{1, 2, 3, 4, 5, 6}; !bytes.Equal(got, want) {
+		t.Fatalf("got data = %v, want = %v", got, want)
+	}
+}
+
+func TestCorkShutdown(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	c.CreateConnected(789, 30000, -1 /* epRcvBuf */)
+	c.EP.SocketOptions().SetCorkOption(true)
+
+	data := []byte{1, 2, 3}
+	view := buffer.NewViewFromBytes(data)
+	if _, _, err := c.EP.Write(tcpip.SlicePayload(view), tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	c.CheckNoPacketTimeout("corked segment sent", 50*time.Millisecond)
+
+	// The corked data is sent along with the FIN.
+	if err := c.EP.Shutdown(tcpip.ShutdownWrite); err != nil {
+		t.Fatalf("Shutdown failed: %s", err)
+	}
+	for _, want := range []struct {
+		payload int
+		flags   uint8
+	}{
+		{payload: len(data), flags: header.TCPFlagAck | header.TCPFlagPsh},
+		{payload: 0, flags: header.TCPFlagAck | header.TCPFlagFin},
+	} {
+		b := c.GetPacketWithTimeout(100 * time.Millisecond)
+		if b == nil {
+			t.Fatal("corked data wasn't sent on shutdown")
+		}
+		checker.IPv4(t, b,
+			checker.PayloadLen(want.payload+header.TCPMinimumSize),
+			checker.TCP(
+				checker.DstPort(context.TestPort),
+				checker.TCPFlags(want.flags),
+			),
+		)
+	}
+}
+
 func testBrokenUpWrite(t *testing.T, c *context.Context, maxPayload int) {
 	payloadMultiplier := 10
 	dataLen := payloadMultiplier * maxPayload
@@ -4816,6 +4978,7 @@ func TestStackSetCongestionControl(t *testing.T) {
 	}{
 		{"reno", nil},
 		{"cubic", nil},
+		{"bbr", nil},
 		{"blahblah", tcpip.ErrNoSuchFile},
 	}
 
@@ -4868,7 +5031,7 @@ func TestStackAvailableCongestionControl(t *testing.T) {
 	if err := s.TransportProtocolOption(tcp.ProtocolNumber, &aCC); err != nil {
 		t.Fatalf("s.TransportProtocolOption(%v, %v) = %v", tcp.ProtocolNumber, &aCC, err)
 	}
-	if got, want := aCC, tcpip.TCPAvailableCongestionControlOption("reno cubic"); got != want {
+	if got, want := aCC, tcpip.TCPAvailableCongestionControlOption("reno cubic bbr"); got != want {
 		t.Fatalf("got tcpip.TCPAvailableCongestionControlOption: %v, want: %v", got, want)
 	}
 }
@@ -4890,7 +5053,7 @@ func TestStackSetAvailableCongestionControl(t *testing.T) {
 	if err := s.TransportProtocolOption(tcp.ProtocolNumber, &cc); err != nil {
 		t.Fatalf("s.TransportProtocolOptio(%d, &%T(%s)): %s", tcp.ProtocolNumber, cc, cc, err)
 	}
-	if got, want := cc, tcpip.TCPAvailableCongestionControlOption("reno cubic"); got != want {
+	if got, want := cc, tcpip.TCPAvailableCongestionControlOption("reno cubic bbr"); got != want {
 		t.Fatalf("got tcpip.TCPAvailableCongestionControlOption = %s, want = %s", got, want)
 	}
 }
@@ -4902,6 +5065,7 @@ func TestEndpointSetCongestionControl(t *testing.T) {
 	}{
 		{"reno", nil},
 		{"cubic", nil},
+		{"bbr", nil},
 		{"blahblah", tcpip.ErrNoSuchFile},
 	}
 
@@ -5697,82 +5861,1153 @@ func TestListenBacklogFullSynCookieInUse(t *testing.T) {
 	}
 }
 
-func TestSYNRetransmit(t *testing.T) {
+func TestSynCookiesOption(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		stackMode  tcpip.TCPSynCookiesOption
+		threshold  uint64
+		epMode     tcpip.TCPSynCookiesOption
+		epMaxSyn   uint64
+		pending    int
+		wantCookie bool
+		wantDrop   bool
+	}{
+		{name: "on overflow below threshold", stackMode: tcpip.TCPSynCookiesOnOverflow, threshold: tcp.SynRcvdCountThreshold},
+		{name: "on overflow above threshold", stackMode: tcpip.TCPSynCookiesOnOverflow, threshold: 0, wantCookie: true},
+		{name: "stack always", stackMode: tcpip.TCPSynCookiesAlways, threshold: tcp.SynRcvdCountThreshold, wantCookie: true},
+		{name: "stack off above threshold", stackMode: tcpip.TCPSynCookiesOff, threshold: 0, wantDrop: true},
+		{name: "listener always", stackMode: tcpip.TCPSynCookiesOff, threshold: 0, epMode: tcpip.TCPSynCookiesAlways, wantCookie: true},
+		{name: "listener off", stackMode: tcpip.TCPSynCookiesAlways, threshold: tcp.SynRcvdCountThreshold, epMode: tcpip.TCPSynCookiesOff},
+		{name: "listener max syn backlog", stackMode: tcpip.TCPSynCookiesOnOverflow, threshold: tcp.SynRcvdCountThreshold, epMaxSyn: 1, pending: 1, wantCookie: true},
+		{name: "listener max syn backlog off", stackMode: tcpip.TCPSynCookiesOnOverflow, threshold: tcp.SynRcvdCountThreshold, epMode: tcpip.TCPSynCookiesOff, epMaxSyn: 1, pending: 1, wantDrop: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := context.New(t, defaultMTU)
+			defer c.Cleanup()
+
+			mode := test.stackMode
+			if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &mode); err != nil {
+				t.Fatalf("SetTransportProtocolOption(%d, &%T(%d)): %s", tcp.ProtocolNumber, mode, mode, err)
+			}
+			threshold := tcpip.TCPSynRcvdCountThresholdOption(test.threshold)
+			if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &threshold); err != nil {
+				t.Fatalf("SetTransportProtocolOption(%d, &%T(%d)): %s", tcp.ProtocolNumber, threshold, threshold, err)
+			}
+
+			var err *tcpip.Error
+			c.EP, err = c.Stack().NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, &c.WQ)
+			if err != nil {
+				t.Fatalf("NewEndpoint failed: %s", err)
+			}
+			epMode := test.epMode
+			if err := c.EP.SetSockOpt(&epMode); err != nil {
+				t.Fatalf("SetSockOpt(&%T(%d)): %s", epMode, epMode, err)
+			}
+			epMaxSyn := tcpip.TCPSynRcvdCountThresholdOption(test.epMaxSyn)
+			if err := c.EP.SetSockOpt(&epMaxSyn); err != nil {
+				t.Fatalf("SetSockOpt(&%T(%d)): %s", epMaxSyn, epMaxSyn, err)
+			}
+			if err := c.EP.Bind(tcpip.FullAddress{Port: context.StackPort}); err != nil {
+				t.Fatalf("Bind failed: %s", err)
+			}
+			if err := c.EP.Listen(10); err != nil {
+				t.Fatalf("Listen failed: %s", err)
+			}
+
+			// Leave the pending connections in SYN-RCVD state.
+			srcPort := uint16(context.TestPort)
+			for i := 0; i < test.pending; i++ {
+				c.SendPacket(nil, &context.Headers{
+					SrcPort: srcPort,
+					DstPort: context.StackPort,
+					Flags:   header.TCPFlagSyn,
+					SeqNum:  seqnum.Value(789),
+					RcvWnd:  30000,
+				})
+				checker.IPv4(t, c.GetPacket(), checker.TCP(
+					checker.DstPort(srcPort),
+					checker.TCPFlags(header.TCPFlagAck|header.TCPFlagSyn),
+				))
+				srcPort++
+			}
+
+			stats := c.Stack().Stats()
+			epStats := c.EP.Stats().(*tcp.Stats)
+			wantSent := stats.TCP.ListenOverflowSynCookieSent.Value()
+			wantDrop := stats.TCP.ListenOverflowSynDrop.Value()
+			if test.wantCookie {
+				wantSent++
+			}
+			if test.wantDrop {
+				wantDrop++
+			}
+
+			c.SendPacket(nil, &context.Headers{
+				SrcPort: srcPort,
+				DstPort: context.StackPort,
+				Flags:   header.TCPFlagSyn,
+				SeqNum:  seqnum.Value(789),
+				RcvWnd:  30000,
+			})
+			if test.wantDrop {
+				c.CheckNoPacketTimeout("unexpected packet received", 50*time.Millisecond)
+			} else {
+				checker.IPv4(t, c.GetPacket(), checker.TCP(
+					checker.DstPort(srcPort),
+					checker.TCPFlags(header.TCPFlagAck|header.TCPFlagSyn),
+					checker.TCPAckNum(790),
+				))
+			}
+
+			if got := stats.TCP.ListenOverflowSynCookieSent.Value(); got != wantSent {
+				t.Errorf("got stats.TCP.ListenOverflowSynCookieSent.Value() = %d, want = %d", got, wantSent)
+			}
+			if got := epStats.ReceiveErrors.ListenOverflowSynCookieSent.Value(); got != wantSent {
+				t.Errorf("got EP stats Stats.ReceiveErrors.ListenOverflowSynCookieSent = %d, want = %d", got, wantSent)
+			}
+			if got := stats.TCP.ListenOverflowSynDrop.Value(); got != wantDrop {
+				t.Errorf("got stats.TCP.ListenOverflowSynDrop.Value() = %d, want = %d", got, wantDrop)
+			}
+		})
+	}
+}
+
+func TestSynCookiesOptionInvalid(t *testing.T) {
 	c := context.New(t, defaultMTU)
 	defer c.Cleanup()
 
-	// Create TCP endpoint.
-	var err *tcpip.Error
-	c.EP, err = c.Stack().NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, &c.WQ)
-	if err != nil {
-		t.Fatalf("NewEndpoint failed: %s", err)
+	for _, mode := range []tcpip.TCPSynCookiesOption{tcpip.TCPSynCookiesDefault, tcpip.TCPSynCookiesOff + 1} {
+		if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &mode); err != tcpip.ErrInvalidOptionValue {
+			t.Errorf("SetTransportProtocolOption(%d, &%T(%d)) = %s, want = %s", tcp.ProtocolNumber, mode, mode, err, tcpip.ErrInvalidOptionValue)
+		}
 	}
 
-	// Bind to wildcard.
-	if err := c.EP.Bind(tcpip.FullAddress{Port: context.StackPort}); err != nil {
-		t.Fatalf("Bind failed: %s", err)
+	var got tcpip.TCPSynCookiesOption
+	if err := c.Stack().TransportProtocolOption(tcp.ProtocolNumber, &got); err != nil {
+		t.Fatalf("TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, got, err)
 	}
-
-	// Start listening.
-	if err := c.EP.Listen(10); err != nil {
-		t.Fatalf("Listen failed: %s", err)
+	if want := tcpip.TCPSynCookiesOnOverflow; got != want {
+		t.Errorf("got TransportProtocolOption(%d, &%T) = %d, want = %d", tcp.ProtocolNumber, got, got, want)
 	}
+}
 
-	// Send the same SYN packet multiple times. We should still get a valid SYN-ACK
-	// reply.
-	irs := seqnum.Value(789)
-	for i := 0; i < 5; i++ {
-		c.SendPacket(nil, &context.Headers{
-			SrcPort: context.TestPort,
-			DstPort: context.StackPort,
-			Flags:   header.TCPFlagSyn,
-			SeqNum:  irs,
-			RcvWnd:  30000,
+func TestECNPassiveNegotiation(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		stackOpt  tcpip.TCPECNOption
+		epOpt     tcpip.TCPECNOption
+		synFlags  int
+		wantFlags uint8
+	}{
+		{name: "passive classic", stackOpt: tcpip.TCPECNPassive, synFlags: header.TCPFlagEce | header.TCPFlagCwr, wantFlags: header.TCPFlagEce},
+		{name: "passive no request", stackOpt: tcpip.TCPECNPassive, synFlags: 0, wantFlags: 0},
+		{name: "passive ece only", stackOpt: tcpip.TCPECNPassive, synFlags: header.TCPFlagEce, wantFlags: 0},
+		{name: "stack disabled", stackOpt: tcpip.TCPECNDisabled, synFlags: header.TCPFlagEce | header.TCPFlagCwr, wantFlags: 0},
+		{name: "listener disabled", stackOpt: tcpip.TCPECNPassive, epOpt: tcpip.TCPECNDisabled, synFlags: header.TCPFlagEce | header.TCPFlagCwr, wantFlags: 0},
+		{name: "listener enabled", stackOpt: tcpip.TCPECNDisabled, epOpt: tcpip.TCPECNEnabled, synFlags: header.TCPFlagEce | header.TCPFlagCwr, wantFlags: header.TCPFlagEce},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := context.New(t, defaultMTU)
+			defer c.Cleanup()
+
+			opt := test.stackOpt
+			if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err != nil {
+				t.Fatalf("SetTransportProtocolOption(%d, &%T(%d)): %s", tcp.ProtocolNumber, opt, opt, err)
+			}
+
+			var err *tcpip.Error
+			c.EP, err = c.Stack().NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, &c.WQ)
+			if err != nil {
+				t.Fatalf("NewEndpoint failed: %s", err)
+			}
+			epOpt := test.epOpt
+			if err := c.EP.SetSockOpt(&epOpt); err != nil {
+				t.Fatalf("SetSockOpt(&%T(%d)): %s", epOpt, epOpt, err)
+			}
+			if err := c.EP.Bind(tcpip.FullAddress{Port: context.StackPort}); err != nil {
+				t.Fatalf("Bind failed: %s", err)
+			}
+			if err := c.EP.Listen(10); err != nil {
+				t.Fatalf("Listen failed: %s", err)
+			}
+
+			c.SendPacket(nil, &context.Headers{
+				SrcPort: context.TestPort,
+				DstPort: context.StackPort,
+				Flags:   header.TCPFlagSyn | test.synFlags,
+				SeqNum:  seqnum.Value(789),
+				RcvWnd:  30000,
+			})
+			checker.IPv4(t, c.GetPacket(),
+				checker.TOS(header.IPECNNotECT, 0),
+				checker.TCP(
+					checker.DstPort(context.TestPort),
+					checker.TCPFlags(header.TCPFlagAck|header.TCPFlagSyn|test.wantFlags),
+				),
+			)
 		})
 	}
+}
 
-	// Receive the SYN-ACK reply.
-	tcpCheckers := []checker.TransportChecker{
-		checker.SrcPort(context.StackPort),
-		checker.DstPort(context.TestPort),
-		checker.TCPFlags(header.TCPFlagAck | header.TCPFlagSyn),
-		checker.TCPAckNum(uint32(irs) + 1),
+func TestECNActiveRequest(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		epOpt     tcpip.TCPECNOption
+		wantFlags uint8
+	}{
+		{name: "default", epOpt: tcpip.TCPECNDefault, wantFlags: 0},
+		{name: "disabled", epOpt: tcpip.TCPECNDisabled, wantFlags: 0},
+		{name: "passive", epOpt: tcpip.TCPECNPassive, wantFlags: 0},
+		{name: "enabled", epOpt: tcpip.TCPECNEnabled, wantFlags: header.TCPFlagEce | header.TCPFlagCwr},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := context.New(t, defaultMTU)
+			defer c.Cleanup()
+
+			c.Create(-1)
+			epOpt := test.epOpt
+			if err := c.EP.SetSockOpt(&epOpt); err != nil {
+				t.Fatalf("SetSockOpt(&%T(%d)): %s", epOpt, epOpt, err)
+			}
+			if err := c.EP.Connect(tcpip.FullAddress{Addr: context.TestAddr, Port: context.TestPort}); err != tcpip.ErrConnectStarted {
+				t.Fatalf("got c.EP.Connect(...) = %s, want = %s", err, tcpip.ErrConnectStarted)
+			}
+			checker.IPv4(t, c.GetPacket(), checker.TCP(
+				checker.DstPort(context.TestPort),
+				checker.TCPFlags(header.TCPFlagSyn|test.wantFlags),
+			))
+		})
 	}
-	checker.IPv4(t, c.GetPacket(), checker.TCP(tcpCheckers...))
 }
 
-func TestSynRcvdBadSeqNumber(t *testing.T) {
-	c := context.New(t, defaultMTU)
-	defer c.Cleanup()
+// createConnectedWithECN creates and connects c.EP to a peer which accepts ECN
+// in its SYN-ACK.
+func createConnectedWithECN(t *testing.T, c *context.Context) {
+	t.Helper()
 
-	// Create TCP endpoint.
-	var err *tcpip.Error
-	c.EP, err = c.Stack().NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, &c.WQ)
-	if err != nil {
-		t.Fatalf("NewEndpoint failed: %s", err)
+	c.Create(-1)
+	opt := tcpip.TCPECNEnabled
+	if err := c.EP.SetSockOpt(&opt); err != nil {
+		t.Fatalf("SetSockOpt(&%T(%d)): %s", opt, opt, err)
 	}
 
-	// Bind to wildcard.
-	if err := c.EP.Bind(tcpip.FullAddress{Port: context.StackPort}); err != nil {
-		t.Fatalf("Bind failed: %s", err)
+	we, ch := waiter.NewChannelEntry(nil)
+	c.WQ.EventRegister(&we, waiter.EventOut)
+	defer c.WQ.EventUnregister(&we)
+	if err := c.EP.Connect(tcpip.FullAddress{Addr: context.TestAddr, Port: context.TestPort}); err != tcpip.ErrConnectStarted {
+		t.Fatalf("got c.EP.Connect(...) = %s, want = %s", err, tcpip.ErrConnectStarted)
 	}
-
-	// Start listening.
-	if err := c.EP.Listen(10); err != nil {
-		t.Fatalf("Listen failed: %s", err)
+	b := c.GetPacket()
+	tcpHdr := header.TCP(header.IPv4(b).Payload())
+	if got, want := tcpHdr.Flags(), uint8(header.TCPFlagSyn|header.TCPFlagEce|header.TCPFlagCwr); got != want {
+		t.Fatalf("got SYN flags = %#x, want = %#x", got, want)
 	}
-
-	// Send a SYN to get a SYN-ACK. This should put the ep into SYN-RCVD state
-	irs := seqnum.Value(789)
+	c.IRS = seqnum.Value(tcpHdr.SequenceNumber())
+	c.Port = tcpHdr.SourcePort()
 	c.SendPacket(nil, &context.Headers{
 		SrcPort: context.TestPort,
-		DstPort: context.StackPort,
-		Flags:   header.TCPFlagSyn,
-		SeqNum:  irs,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagSyn | header.TCPFlagAck | header.TCPFlagEce,
+		SeqNum:  context.TestInitialSequenceNumber,
+		AckNum:  c.IRS.Add(1),
 		RcvWnd:  30000,
 	})
+	checker.IPv4(t, c.GetPacket(), checker.TCP(checker.TCPFlags(header.TCPFlagAck)))
+	<-ch
+}
 
-	// Receive the SYN-ACK reply.
+func TestECNEstablished(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	createConnectedWithECN(t, c)
+
+	var info tcpip.TCPInfoOption
+	if err := c.EP.GetSockOpt(&info); err != nil {
+		t.Fatalf("GetSockOpt(&%T): %s", info, err)
+	}
+	if !info.ECN {
+		t.Errorf("got info.ECN = false, want = true")
+	}
+
+	// Data segments are sent ECN capable.
+	view := buffer.NewView(10)
+	if _, _, err := c.EP.Write(tcpip.SlicePayload(view), tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	checker.IPv4(t, c.GetPacket(),
+		checker.TOS(header.IPECNECT0, 0),
+		checker.PayloadLen(len(view)+header.TCPMinimumSize),
+		checker.TCP(checker.TCPFlags(header.TCPFlagAck|header.TCPFlagPsh)),
+	)
+}
+
+// handledSegments returns a channel which receives a value after the endpoint
+// of c handles a segment. It must be called before the endpoint is created.
+func handledSegments(c *context.Context) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	c.Stack().AddTCPProbe(func(stack.TCPEndpointState) {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	})
+	return ch
+}
+
+// getTCPInfo returns the TCP_INFO of ep.
+func getTCPInfo(t *testing.T, ep tcpip.Endpoint) tcpip.TCPInfoOption {
+	t.Helper()
+
+	var info tcpip.TCPInfoOption
+	if err := ep.GetSockOpt(&info); err != nil {
+		t.Fatalf("GetSockOpt(&%T): %s", info, err)
+	}
+	return info
+}
+
+// tcpInfoAfter returns the TCP_INFO of c.EP after it handled the segment sent
+// by send.
+func tcpInfoAfter(t *testing.T, c *context.Context, handled <-chan struct{}, send func()) tcpip.TCPInfoOption {
+	t.Helper()
+
+	select {
+	case <-handled:
+	default:
+	}
+	send()
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the segment to be handled")
+	}
+	return getTCPInfo(t, c.EP)
+}
+
+func TestTCPInfoDelivered(t *testing.T) {
+	c := context.New(t, uint32(mtu))
+	defer c.Cleanup()
+
+	handled := handledSegments(c)
+	const numPackets = 3
+	start := time.Now()
+	data := sendAndReceive(t, c, numPackets)
+
+	info := getTCPInfo(t, c.EP)
+	if info.BytesSent != uint64(len(data)) || info.DataSegsOut != numPackets || info.Delivered != 0 {
+		t.Errorf("got info.{BytesSent, DataSegsOut, Delivered} = {%d, %d, %d} before the ACK, want = {%d, %d, 0}", info.BytesSent, info.DataSegsOut, info.Delivered, len(data), numPackets)
+	}
+
+	info = tcpInfoAfter(t, c, handled, func() {
+		c.SendAck(seqnum.Value(context.TestInitialSequenceNumber).Add(1), len(data))
+	})
+	elapsed := time.Since(start)
+	if info.Delivered != numPackets {
+		t.Errorf("got info.Delivered = %d, want = %d", info.Delivered, numPackets)
+	}
+	if info.BytesAcked != uint64(len(data)) {
+		t.Errorf("got info.BytesAcked = %d, want = %d", info.BytesAcked, len(data))
+	}
+	if info.BytesRetrans != 0 {
+		t.Errorf("got info.BytesRetrans = %d, want = 0", info.BytesRetrans)
+	}
+	if info.MinRTT <= 0 || info.MinRTT > elapsed {
+		t.Errorf("got info.MinRTT = %s, want in (0, %s]", info.MinRTT, elapsed)
+	}
+	// The data was delivered at least as fast as it was written and
+	// acknowledged.
+	if min := uint64(float64(len(data)) / elapsed.Seconds()); info.DeliveryRate < min {
+		t.Errorf("got info.DeliveryRate = %d, want >= %d", info.DeliveryRate, min)
+	}
+}
+
+func TestTCPInfoRetransmitted(t *testing.T) {
+	c := context.New(t, uint32(mtu))
+	defer c.Cleanup()
+
+	handled := handledSegments(c)
+	data := sendAndReceive(t, c, 1 /* numPackets */)
+
+	// Wait for the retransmission timer to resend the segment.
+	c.ReceiveAndCheckPacketWithOptions(data, 0, maxPayload, tsOptionSize)
+
+	info := tcpInfoAfter(t, c, handled, func() {
+		c.SendAck(seqnum.Value(context.TestInitialSequenceNumber).Add(1), len(data))
+	})
+	if info.BytesRetrans != uint64(len(data)) || info.BytesSent != 2*uint64(len(data)) || info.TotalRetrans != 1 {
+		t.Errorf("got info.{BytesRetrans, BytesSent, TotalRetrans} = {%d, %d, %d}, want = {%d, %d, 1}", info.BytesRetrans, info.BytesSent, info.TotalRetrans, len(data), 2*len(data))
+	}
+	if info.Delivered != 1 {
+		t.Errorf("got info.Delivered = %d, want = 1", info.Delivered)
+	}
+	// The ACK of a retransmitted segment is not an RTT sample.
+	if info.MinRTT != 0 {
+		t.Errorf("got info.MinRTT = %s, want = 0", info.MinRTT)
+	}
+}
+
+func TestTCPInfoDeliveredCE(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	handled := handledSegments(c)
+	createConnectedWithECN(t, c)
+
+	data := []byte{1, 2, 3}
+	if _, _, err := c.EP.Write(tcpip.SlicePayload(data), tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	checker.IPv4(t, c.GetPacket(),
+		checker.TOS(header.IPECNECT0, 0),
+		checker.PayloadLen(len(data)+header.TCPMinimumSize),
+	)
+
+	// The peer echoes the congestion experienced by the segment.
+	info := tcpInfoAfter(t, c, handled, func() {
+		c.SendPacket(nil, &context.Headers{
+			SrcPort: context.TestPort,
+			DstPort: c.Port,
+			Flags:   header.TCPFlagAck | header.TCPFlagEce,
+			SeqNum:  seqnum.Value(context.TestInitialSequenceNumber).Add(1),
+			AckNum:  c.IRS.Add(1 + seqnum.Size(len(data))),
+			RcvWnd:  30000,
+		})
+	})
+	if info.Delivered != 1 || info.DeliveredCE != 1 {
+		t.Errorf("got info.{Delivered, DeliveredCE} = {%d, %d}, want = {1, 1}", info.Delivered, info.DeliveredCE)
+	}
+}
+
+func TestTCPInfoChrono(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	handled := handledSegments(c)
+	c.CreateConnected(context.TestInitialSequenceNumber, 30000, -1 /* epRcvBuf */)
+	seq := seqnum.Value(context.TestInitialSequenceNumber).Add(1)
+	sendAck := func(bytesReceived int, rcvWnd seqnum.Size) {
+		c.SendPacket(nil, &context.Headers{
+			SrcPort: context.TestPort,
+			DstPort: c.Port,
+			Flags:   header.TCPFlagAck,
+			SeqNum:  seq,
+			AckNum:  c.IRS.Add(1 + seqnum.Size(bytesReceived)),
+			RcvWnd:  rcvWnd,
+		})
+	}
+
+	// The sender isn't busy while it has nothing to send.
+	info := tcpInfoAfter(t, c, handled, func() { sendAck(0, maxPayload) })
+	if info.BusyTime != 0 {
+		t.Errorf("got info.BusyTime = %s while idle, want = 0", info.BusyTime)
+	}
+
+	// The peer only lets the first segment of the data through.
+	data := buffer.NewView(2 * maxPayload)
+	if _, _, err := c.EP.Write(tcpip.SlicePayload(data), tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	c.ReceiveAndCheckPacket(data, 0, maxPayload)
+	info = getTCPInfo(t, c.EP)
+	if info.RwndLimited <= 0 || info.BusyTime < info.RwndLimited {
+		t.Errorf("got info.{BusyTime, RwndLimited} = {%s, %s}, want 0 < RwndLimited <= BusyTime", info.BusyTime, info.RwndLimited)
+	}
+
+	sendAck(maxPayload, 30000)
+	c.ReceiveAndCheckPacket(data, maxPayload, maxPayload)
+	info = tcpInfoAfter(t, c, handled, func() { sendAck(len(data), 30000) })
+
+	// The sender is idle again once all the data is acknowledged.
+	time.Sleep(10 * time.Millisecond)
+	if got := getTCPInfo(t, c.EP); got.BusyTime != info.BusyTime || got.RwndLimited != info.RwndLimited {
+		t.Errorf("got info.{BusyTime, RwndLimited} = {%s, %s} while idle, want = {%s, %s}", got.BusyTime, got.RwndLimited, info.BusyTime, info.RwndLimited)
+	}
+}
+
+func TestTCPInfoReordering(t *testing.T) {
+	c := context.New(t, uint32(mtu))
+	defer c.Cleanup()
+
+	handled := handledSegments(c)
+	const numPackets = 5
+	data := sendAndReceive(t, c, numPackets)
+	if info := getTCPInfo(t, c.EP); info.Reordering != 3 || info.ReordSeen != 0 {
+		t.Errorf("got info.{Reordering, ReordSeen} = {%d, %d} before any reordering, want = {3, 0}", info.Reordering, info.ReordSeen)
+	}
+
+	// The last segment overtakes all the others.
+	seq := seqnum.Value(context.TestInitialSequenceNumber).Add(1)
+	start := c.IRS.Add(1 + (numPackets-1)*maxPayload)
+	tcpInfoAfter(t, c, handled, func() {
+		c.SendAckWithSACK(seq, 0, []header.SACKBlock{{start, start.Add(maxPayload)}})
+	})
+	info := tcpInfoAfter(t, c, handled, func() { c.SendAck(seq, len(data)) })
+	if info.Reordering != numPackets || info.ReordSeen != numPackets-1 {
+		t.Errorf("got info.{Reordering, ReordSeen} = {%d, %d}, want = {%d, %d}", info.Reordering, info.ReordSeen, numPackets, numPackets-1)
+	}
+}
+
+func TestSmallQueueOptions(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	var autocork tcpip.TCPAutocorkOption
+	if err := c.Stack().TransportProtocolOption(tcp.ProtocolNumber, &autocork); err != nil {
+		t.Fatalf("TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, autocork, err)
+	}
+	if !autocork {
+		t.Errorf("got TransportProtocolOption(%d, &%T) = false, want = true", tcp.ProtocolNumber, autocork)
+	}
+	autocork = false
+	if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &autocork); err != nil {
+		t.Fatalf("SetTransportProtocolOption(%d, &%T(%t)): %s", tcp.ProtocolNumber, autocork, autocork, err)
+	}
+	if err := c.Stack().TransportProtocolOption(tcp.ProtocolNumber, &autocork); err != nil {
+		t.Fatalf("TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, autocork, err)
+	}
+	if autocork {
+		t.Errorf("got TransportProtocolOption(%d, &%T) = true, want = false", tcp.ProtocolNumber, autocork)
+	}
+
+	var lob tcpip.TCPLimitOutputBytesOption
+	if err := c.Stack().TransportProtocolOption(tcp.ProtocolNumber, &lob); err != nil {
+		t.Fatalf("TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, lob, err)
+	}
+	if want := tcpip.TCPLimitOutputBytesOption(tcp.DefaultLimitOutputBytes); lob != want {
+		t.Errorf("got TransportProtocolOption(%d, &%T) = %d, want = %d", tcp.ProtocolNumber, lob, lob, want)
+	}
+	lob = -1
+	if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &lob); err != tcpip.ErrInvalidOptionValue {
+		t.Errorf("SetTransportProtocolOption(%d, &%T(%d)) = %s, want = %s", tcp.ProtocolNumber, lob, lob, err, tcpip.ErrInvalidOptionValue)
+	}
+
+	// Data is sent normally when the link endpoint doesn't queue packets.
+	lob = 0
+	if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &lob); err != nil {
+		t.Fatalf("SetTransportProtocolOption(%d, &%T(%d)): %s", tcp.ProtocolNumber, lob, lob, err)
+	}
+	c.CreateConnected(789, 30000, -1 /* epRcvBuf */)
+	data := []byte{1, 2, 3}
+	for i := 0; i < 2; i++ {
+		if _, _, err := c.EP.Write(tcpip.SlicePayload(data), tcpip.WriteOptions{}); err != nil {
+			t.Fatalf("Write failed: %s", err)
+		}
+		checker.IPv4(t, c.GetPacket(),
+			checker.PayloadLen(len(data)+header.TCPMinimumSize),
+			checker.TCP(
+				checker.DstPort(context.TestPort),
+				checker.TCPSeqNum(uint32(c.IRS)+1+uint32(i*len(data))),
+				checker.TCPFlagsMatch(header.TCPFlagAck, ^uint8(header.TCPFlagPsh)),
+			),
+		)
+	}
+	if got := c.Stack().Stats().TCP.SmallQueueThrottled.Value(); got != 0 {
+		t.Errorf("got stats.TCP.SmallQueueThrottled.Value() = %d, want = 0", got)
+	}
+}
+
+func TestRepairRestore(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	c.Create(-1)
+	if err := c.EP.SetSockOptInt(tcpip.TCPQueueSeqOption, 1); err != tcpip.ErrNotPermitted {
+		t.Fatalf("got SetSockOptInt(TCPQueueSeqOption, 1) = %s, want = %s", err, tcpip.ErrNotPermitted)
+	}
+
+	const sndSeq, rcvSeq = 1000, 5000
+	for _, opt := range []struct {
+		name tcpip.SockOptInt
+		v    int
+	}{
+		{tcpip.TCPRepairOption, tcpip.TCPRepairOn},
+		{tcpip.TCPRepairQueueOption, tcpip.TCPSendQueue},
+		{tcpip.TCPQueueSeqOption, sndSeq},
+		{tcpip.TCPRepairQueueOption, tcpip.TCPRecvQueue},
+		{tcpip.TCPQueueSeqOption, rcvSeq},
+	} {
+		if err := c.EP.SetSockOptInt(opt.name, opt.v); err != nil {
+			t.Fatalf("SetSockOptInt(%d, %d): %s", opt.name, opt.v, err)
+		}
+	}
+
+	// The connection is established without a handshake.
+	if err := c.EP.Connect(tcpip.FullAddress{Addr: context.TestAddr, Port: context.TestPort}); err != nil {
+		t.Fatalf("Connect(...): %s", err)
+	}
+	if got, want := tcp.EndpointState(c.EP.State()), tcp.StateEstablished; got != want {
+		t.Fatalf("got State() = %s, want = %s", got, want)
+	}
+	if err := c.EP.SetSockOptInt(tcpip.TCPQueueSeqOption, rcvSeq); err != tcpip.ErrNotPermitted {
+		t.Fatalf("got SetSockOptInt(TCPQueueSeqOption, %d) = %s, want = %s", rcvSeq, err, tcpip.ErrNotPermitted)
+	}
+	opts := tcpip.TCPRepairOptionsOption{MSS: 1400, SACKPermitted: true}
+	if err := c.EP.SetSockOpt(&opts); err != nil {
+		t.Fatalf("SetSockOpt(&%#v): %s", opts, err)
+	}
+	wantWin := tcpip.TCPRepairWindowOption{
+		SndWl1:    rcvSeq,
+		SndWnd:    30000,
+		MaxWindow: 30000,
+		RcvWnd:    20000,
+		RcvWup:    rcvSeq,
+	}
+	if err := c.EP.SetSockOpt(&wantWin); err != nil {
+		t.Fatalf("SetSockOpt(&%#v): %s", wantWin, err)
+	}
+	var win tcpip.TCPRepairWindowOption
+	if err := c.EP.GetSockOpt(&win); err != nil {
+		t.Fatalf("GetSockOpt(&%T): %s", win, err)
+	}
+	if win != wantWin {
+		t.Errorf("got GetSockOpt(&%T) = %#v, want = %#v", win, win, wantWin)
+	}
+
+	// Restore the queues. The data of the send queue is not sent.
+	rcvData, sndData := []byte("received"), []byte("sent")
+	if _, _, err := c.EP.Write(tcpip.SlicePayload(rcvData), tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write(...) to the receive queue: %s", err)
+	}
+	if err := c.EP.SetSockOptInt(tcpip.TCPRepairQueueOption, tcpip.TCPSendQueue); err != nil {
+		t.Fatalf("SetSockOptInt(TCPRepairQueueOption, TCPSendQueue): %s", err)
+	}
+	if _, _, err := c.EP.Write(tcpip.SlicePayload(sndData), tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write(...) to the send queue: %s", err)
+	}
+	c.CheckNoPacketTimeout("got a packet from an endpoint in repair mode", 100*time.Millisecond)
+
+	for _, q := range []struct {
+		queue int
+		seq   int
+		data  []byte
+	}{
+		{tcpip.TCPSendQueue, sndSeq + len(sndData), sndData},
+		{tcpip.TCPRecvQueue, rcvSeq + len(rcvData), rcvData},
+	} {
+		if err := c.EP.SetSockOptInt(tcpip.TCPRepairQueueOption, q.queue); err != nil {
+			t.Fatalf("SetSockOptInt(TCPRepairQueueOption, %d): %s", q.queue, err)
+		}
+		if v, err := c.EP.GetSockOptInt(tcpip.TCPQueueSeqOption); err != nil || v != q.seq {
+			t.Errorf("got GetSockOptInt(TCPQueueSeqOption) = (%d, %v) for queue %d, want = (%d, nil)", v, err, q.queue, q.seq)
+		}
+		buf := make([]byte, 20)
+		n, _, err := c.EP.Peek([][]byte{buf})
+		if err != nil {
+			t.Fatalf("Peek(...) of queue %d: %s", q.queue, err)
+		}
+		if got := buf[:n]; !bytes.Equal(got, q.data) {
+			t.Errorf("got Peek(...) of queue %d = %q, want = %q", q.queue, got, q.data)
+		}
+	}
+	if _, _, err := c.EP.Read(nil); err != tcpip.ErrNotPermitted {
+		t.Fatalf("got Read(nil) = %s, want = %s", err, tcpip.ErrNotPermitted)
+	}
+
+	// Leaving repair mode sends a window probe, and the send queue is
+	// retransmitted.
+	if err := c.EP.SetSockOptInt(tcpip.TCPRepairOption, tcpip.TCPRepairOff); err != nil {
+		t.Fatalf("SetSockOptInt(TCPRepairOption, TCPRepairOff): %s", err)
+	}
+	checker.IPv4(t, c.GetPacket(),
+		checker.PayloadLen(header.TCPMinimumSize),
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPSeqNum(sndSeq-1),
+			checker.TCPAckNum(rcvSeq+uint32(len(rcvData))),
+			checker.TCPFlags(header.TCPFlagAck),
+		),
+	)
+	b := c.GetPacket()
+	checker.IPv4(t, b,
+		checker.PayloadLen(header.TCPMinimumSize+len(sndData)),
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPSeqNum(sndSeq),
+			checker.TCPAckNum(rcvSeq+uint32(len(rcvData))),
+		),
+	)
+	if got := header.IPv4(b).Payload()[header.TCPMinimumSize:]; !bytes.Equal(got, sndData) {
+		t.Errorf("got retransmitted data = %q, want = %q", got, sndData)
+	}
+
+	v, _, err := c.EP.Read(nil)
+	if err != nil {
+		t.Fatalf("Read(nil): %s", err)
+	}
+	if !bytes.Equal(v, rcvData) {
+		t.Errorf("got Read(nil) = %q, want = %q", v, rcvData)
+	}
+}
+
+func TestRepairClose(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	c.CreateConnected(789, 30000, -1 /* epRcvBuf */)
+	if err := c.EP.SetSockOptInt(tcpip.TCPRepairOption, tcpip.TCPRepairOn); err != nil {
+		t.Fatalf("SetSockOptInt(TCPRepairOption, TCPRepairOn): %s", err)
+	}
+
+	// The connection is discarded without notifying the peer.
+	c.EP.Close()
+	c.CheckNoPacketTimeout("got a packet from an endpoint closed in repair mode", 1*time.Second)
+}
+
+// closeWithMetrics exchanges data over c.EP so that its RTT is measured, and
+// closes it gracefully so that its metrics are cached.
+func closeWithMetrics(t *testing.T, c *context.Context, iss seqnum.Value) {
+	t.Helper()
+
+	view := buffer.NewView(10)
+	if _, _, err := c.EP.Write(tcpip.SlicePayload(view), tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	checker.IPv4(t, c.GetPacket(),
+		checker.PayloadLen(len(view)+header.TCPMinimumSize),
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPSeqNum(uint32(c.IRS)+1),
+			checker.TCPAckNum(uint32(iss)+1),
+		),
+	)
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagAck,
+		SeqNum:  iss + 1,
+		AckNum:  c.IRS.Add(1 + seqnum.Size(len(view))),
+		RcvWnd:  30000,
+	})
+
+	c.EP.Close()
+	checker.IPv4(t, c.GetPacket(), checker.TCP(
+		checker.DstPort(context.TestPort),
+		checker.TCPSeqNum(uint32(c.IRS)+1+uint32(len(view))),
+		checker.TCPFlags(header.TCPFlagAck|header.TCPFlagFin),
+	))
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagAck | header.TCPFlagFin,
+		SeqNum:  iss + 1,
+		AckNum:  c.IRS.Add(2 + seqnum.Size(len(view))),
+		RcvWnd:  30000,
+	})
+	checker.IPv4(t, c.GetPacket(), checker.TCP(
+		checker.DstPort(context.TestPort),
+		checker.TCPAckNum(uint32(iss)+2),
+		checker.TCPFlags(header.TCPFlagAck),
+	))
+}
+
+func TestMetricsCache(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	iss := seqnum.Value(789)
+	c.CreateConnected(iss, 30000, -1 /* epRcvBuf */)
+	closeWithMetrics(t, c, iss)
+
+	var metrics tcpip.TCPMetricsOption
+	if err := c.Stack().TransportProtocolOption(tcp.ProtocolNumber, &metrics); err != nil {
+		t.Fatalf("TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, metrics, err)
+	}
+	if len(metrics) != 1 {
+		t.Fatalf("got len(metrics) = %d, want = 1", len(metrics))
+	}
+	if got := metrics[0]; got.LocalAddress != context.StackAddr || got.RemoteAddress != context.TestAddr || got.RTT == 0 {
+		t.Fatalf("got metrics[0] = %+v, want an entry with a measured RTT from %s to %s", got, context.StackAddr, context.TestAddr)
+	}
+
+	// The next connection to the same destination starts with the RTO
+	// computed from the cached RTT, which is clamped to the minimum RTO,
+	// rather than the initial one.
+	var minRTO tcpip.TCPMinRTOOption
+	if err := c.Stack().TransportProtocolOption(tcp.ProtocolNumber, &minRTO); err != nil {
+		t.Fatalf("TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, minRTO, err)
+	}
+	c.CreateConnected(iss, 30000, -1 /* epRcvBuf */)
+	var info tcpip.TCPInfoOption
+	if err := c.EP.GetSockOpt(&info); err != nil {
+		t.Fatalf("GetSockOpt(&%T): %s", info, err)
+	}
+	if got, want := info.RTO, time.Duration(minRTO); got != want {
+		t.Errorf("got info.RTO = %s, want = %s", got, want)
+	}
+
+	flush := tcpip.TCPMetricsFlushOption("")
+	if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &flush); err != nil {
+		t.Fatalf("SetTransportProtocolOption(%d, &%T(%q)): %s", tcp.ProtocolNumber, flush, flush, err)
+	}
+	if err := c.Stack().TransportProtocolOption(tcp.ProtocolNumber, &metrics); err != nil {
+		t.Fatalf("TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, metrics, err)
+	}
+	if len(metrics) != 0 {
+		t.Errorf("got metrics = %+v after flush, want none", metrics)
+	}
+}
+
+func TestMetricsNoSave(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	opt := tcpip.TCPMetricsSaveOption(false)
+	if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err != nil {
+		t.Fatalf("SetTransportProtocolOption(%d, &%T(%t)): %s", tcp.ProtocolNumber, opt, opt, err)
+	}
+
+	iss := seqnum.Value(789)
+	c.CreateConnected(iss, 30000, -1 /* epRcvBuf */)
+	closeWithMetrics(t, c, iss)
+
+	var metrics tcpip.TCPMetricsOption
+	if err := c.Stack().TransportProtocolOption(tcp.ProtocolNumber, &metrics); err != nil {
+		t.Fatalf("TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, metrics, err)
+	}
+	if len(metrics) != 0 {
+		t.Errorf("got metrics = %+v, want none", metrics)
+	}
+}
+
+func TestGSOMaxSegs(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+	c.SetGSOEnabled(true)
+
+	const maxSegs = 2
+	opt := stack.GSOOption{MaxSize: stack.SoftwareGSOMaxSize, MaxSegs: maxSegs}
+	if err := c.Stack().SetOption(opt); err != nil {
+		t.Fatalf("SetOption(%#v): %s", opt, err)
+	}
+
+	// The accepted endpoint sends segments of 100 bytes, as advertised by
+	// the peer.
+	const maxPayload = 100
+	c.AcceptWithOptions(-1 /* wndScale */, header.TCPSynOptions{MSS: c.MSSWithoutOptions(), WS: -1})
+	view := buffer.NewView(5000)
+	if _, _, err := c.EP.Write(tcpip.SlicePayload(view), tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+
+	// The GSO packet can't carry more than maxSegs segments, although the
+	// congestion window would allow sending more data. The TCP checksum
+	// isn't verified as it's left to the link.
+	checker.IPv4(t, c.GetPacket(), checker.PayloadLen(maxSegs*maxPayload+header.TCPMinimumSize))
+}
+
+func TestDelayedAckOptions(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	var timeout tcpip.TCPDelayedAckTimeoutOption
+	if err := c.Stack().TransportProtocolOption(tcp.ProtocolNumber, &timeout); err != nil {
+		t.Fatalf("TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, timeout, err)
+	}
+	if want := tcpip.TCPDelayedAckTimeoutOption(tcp.DefaultDelayedAckTimeout); timeout != want {
+		t.Errorf("got TransportProtocolOption(%d, &%T) = %s, want = %s", tcp.ProtocolNumber, timeout, time.Duration(timeout), time.Duration(want))
+	}
+	for _, v := range []tcpip.TCPDelayedAckTimeoutOption{-1, tcpip.TCPDelayedAckTimeoutOption(tcp.MaxDelayedAckTimeout + 1)} {
+		if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &v); err != tcpip.ErrInvalidOptionValue {
+			t.Errorf("SetTransportProtocolOption(%d, &%T(%s)) = %s, want = %s", tcp.ProtocolNumber, v, time.Duration(v), err, tcpip.ErrInvalidOptionValue)
+		}
+	}
+
+	var segs tcpip.TCPDelayedAckSegmentsOption
+	if err := c.Stack().TransportProtocolOption(tcp.ProtocolNumber, &segs); err != nil {
+		t.Fatalf("TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, segs, err)
+	}
+	if want := tcpip.TCPDelayedAckSegmentsOption(tcp.DefaultDelayedAckSegments); segs != want {
+		t.Errorf("got TransportProtocolOption(%d, &%T) = %d, want = %d", tcp.ProtocolNumber, segs, segs, want)
+	}
+	segs = 0
+	if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &segs); err != tcpip.ErrInvalidOptionValue {
+		t.Errorf("SetTransportProtocolOption(%d, &%T(%d)) = %s, want = %s", tcp.ProtocolNumber, segs, segs, err, tcpip.ErrInvalidOptionValue)
+	}
+}
+
+// createDelayedAckConnected creates a connected endpoint with TCP_QUICKACK
+// disabled, whose acknowledgments are delayed by the given timeout.
+func createDelayedAckConnected(t *testing.T, c *context.Context, timeout time.Duration) {
+	t.Helper()
+
+	opt := tcpip.TCPDelayedAckTimeoutOption(timeout)
+	if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err != nil {
+		t.Fatalf("SetTransportProtocolOption(%d, &%T(%s)): %s", tcp.ProtocolNumber, opt, timeout, err)
+	}
+	c.CreateConnected(789, 30000, -1 /* epRcvBuf */)
+	c.EP.SocketOptions().SetQuickAck(false)
+}
+
+func TestDelayedAck(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	const timeout = 300 * time.Millisecond
+	createDelayedAckConnected(t, c, timeout)
+
+	data := []byte{1, 2, 3}
+	start := time.Now()
+	c.SendPacket(data, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagAck,
+		SeqNum:  790,
+		AckNum:  c.IRS.Add(1),
+		RcvWnd:  30000,
+	})
+	c.CheckNoPacketTimeout("data acknowledged without delay", timeout/3)
+
+	checker.IPv4(t, c.GetPacket(),
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPSeqNum(uint32(c.IRS)+1),
+			checker.TCPAckNum(uint32(790+len(data))),
+			checker.TCPFlags(header.TCPFlagAck),
+		),
+	)
+	if elapsed := time.Since(start); elapsed < timeout {
+		t.Errorf("got ACK after %s, want at least %s", elapsed, timeout)
+	}
+}
+
+func TestDelayedAckSegments(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	createDelayedAckConnected(t, c, tcp.MaxDelayedAckTimeout)
+
+	// The second data segment is acknowledged immediately.
+	data := []byte{1, 2, 3}
+	for i := 0; i < tcp.DefaultDelayedAckSegments; i++ {
+		c.SendPacket(data, &context.Headers{
+			SrcPort: context.TestPort,
+			DstPort: c.Port,
+			Flags:   header.TCPFlagAck,
+			SeqNum:  seqnum.Value(790 + i*len(data)),
+			AckNum:  c.IRS.Add(1),
+			RcvWnd:  30000,
+		})
+	}
+	b := c.GetPacketWithTimeout(tcp.MaxDelayedAckTimeout / 2)
+	if b == nil {
+		t.Fatal("data wasn't acknowledged immediately")
+	}
+	checker.IPv4(t, b,
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPSeqNum(uint32(c.IRS)+1),
+			checker.TCPAckNum(uint32(790+tcp.DefaultDelayedAckSegments*len(data))),
+			checker.TCPFlags(header.TCPFlagAck),
+		),
+	)
+}
+
+func TestQuickAckSendsDelayedAck(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	createDelayedAckConnected(t, c, tcp.MaxDelayedAckTimeout)
+
+	data := []byte{1, 2, 3}
+	c.SendPacket(data, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagAck,
+		SeqNum:  790,
+		AckNum:  c.IRS.Add(1),
+		RcvWnd:  30000,
+	})
+	c.CheckNoPacketTimeout("data acknowledged without delay", 100*time.Millisecond)
+
+	// Enabling TCP_QUICKACK acknowledges the pending data.
+	c.EP.SocketOptions().SetQuickAck(true)
+	b := c.GetPacketWithTimeout(tcp.MaxDelayedAckTimeout / 2)
+	if b == nil {
+		t.Fatal("data wasn't acknowledged immediately")
+	}
+	checker.IPv4(t, b,
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPSeqNum(uint32(c.IRS)+1),
+			checker.TCPAckNum(uint32(790+len(data))),
+			checker.TCPFlags(header.TCPFlagAck),
+		),
+	)
+}
+
+func TestNotSentLowatOption(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	var lowat tcpip.TCPNotSentLowatOption
+	if err := c.Stack().TransportProtocolOption(tcp.ProtocolNumber, &lowat); err != nil {
+		t.Fatalf("TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, lowat, err)
+	}
+	if want := tcpip.TCPNotSentLowatOption(tcp.DefaultNotSentLowat); lowat != want {
+		t.Errorf("got TransportProtocolOption(%d, &%T) = %d, want = %d", tcp.ProtocolNumber, lowat, lowat, want)
+	}
+	lowat = 0
+	if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &lowat); err != tcpip.ErrInvalidOptionValue {
+		t.Errorf("SetTransportProtocolOption(%d, &%T(%d)) = %s, want = %s", tcp.ProtocolNumber, lowat, lowat, err, tcpip.ErrInvalidOptionValue)
+	}
+
+	// The endpoint uses the stack-wide threshold until one is set.
+	c.Create(-1 /* epRcvBuf */)
+	if err := c.EP.GetSockOpt(&lowat); err != nil {
+		t.Fatalf("c.EP.GetSockOpt(&%T): %s", lowat, err)
+	}
+	if lowat != 0 {
+		t.Errorf("got c.EP.GetSockOpt(&%T) = %d, want = 0", lowat, lowat)
+	}
+	lowat = 1000
+	if err := c.EP.SetSockOpt(&lowat); err != nil {
+		t.Fatalf("c.EP.SetSockOpt(&%T(%d)): %s", lowat, lowat, err)
+	}
+	if err := c.EP.GetSockOpt(&lowat); err != nil {
+		t.Fatalf("c.EP.GetSockOpt(&%T): %s", lowat, err)
+	}
+	if lowat != 1000 {
+		t.Errorf("got c.EP.GetSockOpt(&%T) = %d, want = 1000", lowat, lowat)
+	}
+}
+
+func TestNotSentLowat(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	c.CreateConnected(789, 30000, -1 /* epRcvBuf */)
+
+	const lowat = 100
+	opt := tcpip.TCPNotSentLowatOption(lowat)
+	if err := c.EP.SetSockOpt(&opt); err != nil {
+		t.Fatalf("c.EP.SetSockOpt(&%T(%d)): %s", opt, opt, err)
+	}
+
+	// Corked data isn't sent.
+	c.EP.SocketOptions().SetCorkOption(true)
+	view := buffer.NewView(lowat / 2)
+	if _, _, err := c.EP.Write(tcpip.SlicePayload(view), tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	if got := c.EP.Readiness(waiter.EventOut); got != waiter.EventOut {
+		t.Errorf("got c.EP.Readiness(EventOut) = %#x, want = %#x", got, waiter.EventOut)
+	}
+
+	// Only the data below the threshold is written.
+	view = buffer.NewView(lowat)
+	n, _, err := c.EP.Write(tcpip.SlicePayload(view), tcpip.WriteOptions{})
+	if err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	if n != lowat/2 {
+		t.Errorf("got Write(...) = %d, want = %d", n, lowat/2)
+	}
+	if got := c.EP.Readiness(waiter.EventOut); got != 0 {
+		t.Errorf("got c.EP.Readiness(EventOut) = %#x, want = 0", got)
+	}
+	if _, _, err := c.EP.Write(tcpip.SlicePayload(view), tcpip.WriteOptions{}); err != tcpip.ErrWouldBlock {
+		t.Fatalf("got Write(...) = %s, want = %s", err, tcpip.ErrWouldBlock)
+	}
+
+	// Writers are notified once the data is sent.
+	we, ch := waiter.NewChannelEntry(nil)
+	c.WQ.EventRegister(&we, waiter.EventOut)
+	defer c.WQ.EventUnregister(&we)
+
+	c.EP.SocketOptions().SetCorkOption(false)
+	checker.IPv4(t, c.GetPacket(),
+		checker.PayloadLen(header.TCPMinimumSize+lowat),
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPSeqNum(uint32(c.IRS)+1),
+		),
+	)
+	select {
+	case <-ch:
+	case <-time.After(1 * time.Second):
+		t.Fatalf("timed out waiting for the endpoint to become writable")
+	}
+	if got := c.EP.Readiness(waiter.EventOut); got != waiter.EventOut {
+		t.Errorf("got c.EP.Readiness(EventOut) = %#x, want = %#x", got, waiter.EventOut)
+	}
+}
+
+func TestSYNRetransmit(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	// Create TCP endpoint.
+	var err *tcpip.Error
+	c.EP, err = c.Stack().NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, &c.WQ)
+	if err != nil {
+		t.Fatalf("NewEndpoint failed: %s", err)
+	}
+
+	// Bind to wildcard.
+	if err := c.EP.Bind(tcpip.FullAddress{Port: context.StackPort}); err != nil {
+		t.Fatalf("Bind failed: %s", err)
+	}
+
+	// Start listening.
+	if err := c.EP.Listen(10); err != nil {
+		t.Fatalf("Listen failed: %s", err)
+	}
+
+	// Send the same SYN packet multiple times. We should still get a valid SYN-ACK
+	// reply.
+	irs := seqnum.Value(789)
+	for i := 0; i < 5; i++ {
+		c.SendPacket(nil, &context.Headers{
+			SrcPort: context.TestPort,
+			DstPort: context.StackPort,
+			Flags:   header.TCPFlagSyn,
+			SeqNum:  irs,
+			RcvWnd:  30000,
+		})
+	}
+
+	// Receive the SYN-ACK reply.
+	tcpCheckers := []checker.TransportChecker{
+		checker.SrcPort(context.StackPort),
+		checker.DstPort(context.TestPort),
+		checker.TCPFlags(header.TCPFlagAck | header.TCPFlagSyn),
+		checker.TCPAckNum(uint32(irs) + 1),
+	}
+	checker.IPv4(t, c.GetPacket(), checker.TCP(tcpCheckers...))
+}
+
+func TestSynRcvdBadSeqNumber(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	// Create TCP endpoint.
+	var err *tcpip.Error
+	c.EP, err = c.Stack().NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, &c.WQ)
+	if err != nil {
+		t.Fatalf("NewEndpoint failed: %s", err)
+	}
+
+	// Bind to wildcard.
+	if err := c.EP.Bind(tcpip.FullAddress{Port: context.StackPort}); err != nil {
+		t.Fatalf("Bind failed: %s", err)
+	}
+
+	// Start listening.
+	if err := c.EP.Listen(10); err != nil {
+		t.Fatalf("Listen failed: %s", err)
+	}
+
+	// Send a SYN to get a SYN-ACK. This should put the ep into SYN-RCVD state
+	irs := seqnum.Value(789)
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: context.StackPort,
+		Flags:   header.TCPFlagSyn,
+		SeqNum:  irs,
+		RcvWnd:  30000,
+	})
+
+	// Receive the SYN-ACK reply.
 	b := c.GetPacket()
 	tcpHdr := header.TCP(header.IPv4(b).Payload())
 	iss := seqnum.Value(tcpHdr.SequenceNumber())
@@ -7458,6 +8693,11 @@ func TestTCPDeferAccept(t *testing.T) {
 		checker.TCPSeqNum(uint32(iss+1)),
 		checker.TCPAckNum(uint32(irs+5))))
 
+	// The bare ACK of the handshake was dropped.
+	if got := c.Stack().Stats().TCP.DeferAcceptDrop.Value(); got != 1 {
+		t.Errorf("got stats.TCP.DeferAcceptDrop.Value() = %d, want = 1", got)
+	}
+
 	// Give a bit of time for the socket to be delivered to the accept queue.
 	time.Sleep(50 * time.Millisecond)
 	aep, _, err := c.EP.Accept(nil)
@@ -7474,6 +8714,91 @@ func TestTCPDeferAccept(t *testing.T) {
 		checker.TCPAckNum(uint32(irs+5))))
 }
 
+func TestTCPDeferAcceptRounding(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	c.Create(-1)
+
+	// The timeout is rounded up to the SYN-ACK retransmissions sent after
+	// 1, 3, 7, 15, 31 and 63 seconds.
+	for _, test := range []struct {
+		set, want time.Duration
+	}{
+		{set: 0, want: 0},
+		{set: -time.Second, want: 0},
+		{set: time.Second, want: time.Second},
+		{set: 2 * time.Second, want: 3 * time.Second},
+		{set: 5 * time.Second, want: 7 * time.Second},
+		{set: 15 * time.Second, want: 15 * time.Second},
+		{set: 40 * time.Second, want: 63 * time.Second},
+		{set: time.Hour, want: 63 * time.Second},
+	} {
+		opt := tcpip.TCPDeferAcceptOption(test.set)
+		if err := c.EP.SetSockOpt(&opt); err != nil {
+			t.Fatalf("c.EP.SetSockOpt(&%T(%s)): %s", opt, test.set, err)
+		}
+		if err := c.EP.GetSockOpt(&opt); err != nil {
+			t.Fatalf("c.EP.GetSockOpt(&%T): %s", opt, err)
+		}
+		if got := time.Duration(opt); got != test.want {
+			t.Errorf("got TCPDeferAcceptOption = %s after setting %s, want = %s", got, test.set, test.want)
+		}
+	}
+}
+
+func TestTCPDeferAcceptFIN(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	c.Create(-1)
+
+	if err := c.EP.Bind(tcpip.FullAddress{Port: context.StackPort}); err != nil {
+		t.Fatal("Bind failed:", err)
+	}
+
+	if err := c.EP.Listen(10); err != nil {
+		t.Fatal("Listen failed:", err)
+	}
+
+	const tcpDeferAccept = 5 * time.Second
+	tcpDeferAcceptOpt := tcpip.TCPDeferAcceptOption(tcpDeferAccept)
+	if err := c.EP.SetSockOpt(&tcpDeferAcceptOpt); err != nil {
+		t.Fatalf("c.EP.SetSockOpt(&%T(%s)): %s", tcpDeferAcceptOpt, tcpDeferAccept, err)
+	}
+
+	irs, iss := executeHandshake(t, c, context.TestPort, false /* synCookiesInUse */)
+
+	// A FIN completes the handshake like data does.
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: context.StackPort,
+		Flags:   header.TCPFlagAck | header.TCPFlagFin,
+		SeqNum:  irs + 1,
+		AckNum:  iss + 1,
+	})
+
+	// Receive ACK for the FIN we sent.
+	checker.IPv4(t, c.GetPacket(), checker.TCP(
+		checker.SrcPort(context.StackPort),
+		checker.DstPort(context.TestPort),
+		checker.TCPFlags(header.TCPFlagAck),
+		checker.TCPSeqNum(uint32(iss+1)),
+		checker.TCPAckNum(uint32(irs+2))))
+
+	// Give sometime for the endpoint to be delivered to the accept queue.
+	time.Sleep(50 * time.Millisecond)
+	aep, _, err := c.EP.Accept(nil)
+	if err != nil {
+		t.Fatalf("got c.EP.Accept(nil) = %s, want: nil", err)
+	}
+	defer aep.Close()
+
+	if _, _, err := aep.Read(nil); err != tcpip.ErrClosedForReceive {
+		t.Errorf("got aep.Read(nil) = %s, want = %s", err, tcpip.ErrClosedForReceive)
+	}
+}
+
 func TestTCPDeferAcceptTimeout(t *testing.T) {
 	c := context.New(t, defaultMTU)
 	defer c.Cleanup()
@@ -7649,6 +8974,153 @@ func TestSetStackTimeWaitReuse(t *testing.T) {
 	}
 }
 
+func TestTCPTunablesOption(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	s := c.Stack()
+	var v tcpip.TCPTunablesOption
+	if err := s.TransportProtocolOption(tcp.ProtocolNumber, &v); err != nil {
+		t.Fatalf("s.TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, v, err)
+	}
+	// The buffer size ranges are set by the test context.
+	var rs tcpip.TCPReceiveBufferSizeRangeOption
+	if err := s.TransportProtocolOption(tcp.ProtocolNumber, &rs); err != nil {
+		t.Fatalf("s.TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, rs, err)
+	}
+	var ss tcpip.TCPSendBufferSizeRangeOption
+	if err := s.TransportProtocolOption(tcp.ProtocolNumber, &ss); err != nil {
+		t.Fatalf("s.TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, ss, err)
+	}
+	want := tcpip.TCPTunablesOption{
+		SynRetries:         tcp.DefaultSynRetries,
+		MaxRetries:         tcp.MaxRetries,
+		FinTimeout:         tcp.DefaultTCPLingerTimeout,
+		ReceiveBufferSize:  rs,
+		SendBufferSize:     ss,
+		SlowStartAfterIdle: true,
+	}
+	if v != want {
+		t.Errorf("got s.TransportProtocolOption(%d, &%T) = %+v, want = %+v", tcp.ProtocolNumber, v, v, want)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		modify func(*tcpip.TCPTunablesOption)
+	}{
+		{"SynRetries", func(v *tcpip.TCPTunablesOption) { v.SynRetries = 0 }},
+		{"ReceiveBufferSize", func(v *tcpip.TCPTunablesOption) { v.ReceiveBufferSize.Default = v.ReceiveBufferSize.Max + 1 }},
+		{"SendBufferSize", func(v *tcpip.TCPTunablesOption) { v.SendBufferSize.Min = 0 }},
+	} {
+		invalid := want
+		tc.modify(&invalid)
+		if err := s.SetTransportProtocolOption(tcp.ProtocolNumber, &invalid); err != tcpip.ErrInvalidOptionValue {
+			t.Errorf("s.SetTransportProtocolOption(%d, &%T) with invalid %s = %s, want = %s", tcp.ProtocolNumber, invalid, tc.name, err, tcpip.ErrInvalidOptionValue)
+		}
+	}
+
+	set := tcpip.TCPTunablesOption{
+		SynRetries: 3,
+		MaxRetries: 8,
+		FinTimeout: 10 * time.Second,
+		ReceiveBufferSize: tcpip.TCPReceiveBufferSizeRangeOption{
+			Min:     4096,
+			Default: 65536,
+			Max:     1 << 20,
+		},
+		SendBufferSize: tcpip.TCPSendBufferSizeRangeOption{
+			Min:     4096,
+			Default: 16384,
+			Max:     1 << 20,
+		},
+		ModerateReceiveBuffer: true,
+		MTUProbing:            true,
+	}
+	if err := s.SetTransportProtocolOption(tcp.ProtocolNumber, &set); err != nil {
+		t.Fatalf("s.SetTransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, set, err)
+	}
+	if err := s.TransportProtocolOption(tcp.ProtocolNumber, &v); err != nil {
+		t.Fatalf("s.TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, v, err)
+	}
+	if v != set {
+		t.Errorf("got s.TransportProtocolOption(%d, &%T) = %+v, want = %+v", tcp.ProtocolNumber, v, v, set)
+	}
+
+	// The tunables are also reported by the individual options.
+	var synRetries tcpip.TCPSynRetriesOption
+	if err := s.TransportProtocolOption(tcp.ProtocolNumber, &synRetries); err != nil {
+		t.Fatalf("s.TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, synRetries, err)
+	}
+	if got, want := uint8(synRetries), set.SynRetries; got != want {
+		t.Errorf("got TCPSynRetriesOption = %d, want = %d", got, want)
+	}
+	var finTimeout tcpip.TCPLingerTimeoutOption
+	if err := s.TransportProtocolOption(tcp.ProtocolNumber, &finTimeout); err != nil {
+		t.Fatalf("s.TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, finTimeout, err)
+	}
+	if got, want := time.Duration(finTimeout), set.FinTimeout; got != want {
+		t.Errorf("got TCPLingerTimeoutOption = %s, want = %s", got, want)
+	}
+}
+
+func TestMTUProbingBlackhole(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		mtuProbing bool
+		wantSize   int
+	}{
+		{"Disabled", false, 1400},
+		{"Enabled", true, 1024},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := context.New(t, defaultMTU)
+			defer c.Cleanup()
+
+			var tunables tcpip.TCPTunablesOption
+			if err := c.Stack().TransportProtocolOption(tcp.ProtocolNumber, &tunables); err != nil {
+				t.Fatalf("TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, tunables, err)
+			}
+			tunables.MTUProbing = tc.mtuProbing
+			if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &tunables); err != nil {
+				t.Fatalf("SetTransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, tunables, err)
+			}
+			maxRTO := tcpip.TCPMaxRTOOption(tcp.MinRTO)
+			if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &maxRTO); err != nil {
+				t.Fatalf("SetTransportProtocolOption(%d, &%T(%s)): %s", tcp.ProtocolNumber, maxRTO, time.Duration(maxRTO), err)
+			}
+
+			const maxPayload = 1460
+			c.CreateConnectedWithRawOptions(789 /* iss */, 30000 /* rcvWnd */, -1 /* epRcvBuf */, []byte{
+				header.TCPOptionMSS, 4, byte(maxPayload / 256), byte(maxPayload % 256),
+			})
+
+			data := buffer.NewView(1400)
+			if _, _, err := c.EP.Write(tcpip.SlicePayload(data), tcpip.WriteOptions{}); err != nil {
+				t.Fatalf("Write failed: %s", err)
+			}
+
+			// The segment is retransmitted whole until the third
+			// retransmission timeout.
+			for i := 0; i < 3; i++ {
+				checker.IPv4(t, c.GetPacket(),
+					checker.PayloadLen(len(data)+header.TCPMinimumSize),
+					checker.TCP(
+						checker.DstPort(context.TestPort),
+						checker.TCPSeqNum(uint32(c.IRS)+1),
+					),
+				)
+			}
+			checker.IPv4(t, c.GetPacket(),
+				checker.PayloadLen(tc.wantSize+header.TCPMinimumSize),
+				checker.TCP(
+					checker.DstPort(context.TestPort),
+					checker.TCPSeqNum(uint32(c.IRS)+1),
+				),
+			)
+		})
+	}
+}
+
 // generateRandomPayload generates a random byte slice of the specified length
 // causing a fatal test failure if it is unable to do so.
 func generateRandomPayload(t *testing.T, n int) []byte {
@@ -0,0 +1,139 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// txTimestamp is a send for which SO_TIMESTAMPING transmit timestamps were
+// requested. As in Linux, the timestamps of a send are taken when its last
+// byte is first transmitted and when it is acknowledged.
+type txTimestamp struct {
+	// end is the value of sndBufQueued once the data of the send was
+	// queued. The last byte of the send is transmitted once
+	// sndBufAcked+sndBufInFlight reaches end, and acknowledged once
+	// sndBufAcked reaches end.
+	end uint64
+
+	// sent is set once the last byte of the send was transmitted.
+	sent bool
+
+	req *tcpip.TxTimestampRequest
+}
+
+// queueTxTimestampLocked records the request for transmit timestamps of the
+// send of v, which was just added to the send queue, if there is one.
+//
+// Precondition: e.sndBufMu must be locked.
+func (e *endpoint) queueTxTimestampLocked(v []byte) {
+	req := e.ops.NewTxTimestampRequest(v, true /* stream */, e.NetProto)
+	if req == nil {
+		return
+	}
+	e.txTimestamps = append(e.txTimestamps, txTimestamp{
+		end: e.sndBufQueued,
+		req: req,
+	})
+}
+
+// sentTxTimestampsLocked marks the sends whose last byte was transmitted as
+// sent, and returns those which weren't already.
+//
+// Precondition: e.sndBufMu must be locked.
+func (e *endpoint) sentTxTimestampsLocked() []*tcpip.TxTimestampRequest {
+	var reqs []*tcpip.TxTimestampRequest
+	sent := e.sndBufAcked + uint64(e.sndBufInFlight)
+	for i := range e.txTimestamps {
+		ts := &e.txTimestamps[i]
+		if ts.end > sent {
+			break
+		}
+		if !ts.sent {
+			ts.sent = true
+			reqs = append(reqs, ts.req)
+		}
+	}
+	return reqs
+}
+
+// ackedTxTimestampsLocked removes the sends whose data was acknowledged from
+// e.txTimestamps and returns them.
+//
+// Precondition: e.sndBufMu must be locked.
+func (e *endpoint) ackedTxTimestampsLocked() []txTimestamp {
+	i := 0
+	for i < len(e.txTimestamps) && e.txTimestamps[i].end <= e.sndBufAcked {
+		i++
+	}
+	if i == 0 {
+		return nil
+	}
+	done := e.txTimestamps[:i:i]
+	e.txTimestamps = e.txTimestamps[i:]
+	return done
+}
+
+// reportSentTxTimestamps reports the timestamps of the sends of reqs, whose
+// last byte was just transmitted.
+func (e *endpoint) reportSentTxTimestamps(reqs []*tcpip.TxTimestampRequest) {
+	if len(reqs) == 0 {
+		return
+	}
+	now := e.stack.Clock().NowNanoseconds()
+	notify := false
+	for _, req := range reqs {
+		// The data is handed to the network layer and transmitted at
+		// once.
+		if req.Report(tcpip.TimestampSched, now) {
+			notify = true
+		}
+		if req.Report(tcpip.TimestampSnd, now) {
+			notify = true
+		}
+	}
+	if notify {
+		e.waiterQueue.Notify(waiter.EventErr)
+	}
+}
+
+// reportAckedTxTimestamps reports the timestamps of sends, whose data was just
+// acknowledged.
+func (e *endpoint) reportAckedTxTimestamps(sends []txTimestamp) {
+	if len(sends) == 0 {
+		return
+	}
+	now := e.stack.Clock().NowNanoseconds()
+	notify := false
+	for _, ts := range sends {
+		if !ts.sent {
+			// The acknowledgement raced with the transmission of
+			// the send being recorded.
+			if ts.req.Report(tcpip.TimestampSched, now) {
+				notify = true
+			}
+			if ts.req.Report(tcpip.TimestampSnd, now) {
+				notify = true
+			}
+		}
+		if ts.req.Report(tcpip.TimestampAck, now) {
+			notify = true
+		}
+	}
+	if notify {
+		e.waiterQueue.Notify(waiter.EventErr)
+	}
+}
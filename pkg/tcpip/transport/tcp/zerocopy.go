@@ -0,0 +1,92 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// zeroCopySend is a MSG_ZEROCOPY send whose payload is held by the send
+// queue.
+type zeroCopySend struct {
+	// end is the value of sndBufQueued once the payload was queued. The
+	// payload is no longer referenced once sndBufAcked reaches end.
+	end uint64
+
+	zc *tcpip.ZeroCopySend
+}
+
+// fetchPayload fetches at most size bytes of p, borrowing the memory of the
+// sender if p is a MSG_ZEROCOPY payload and borrow is true.
+func fetchPayload(p tcpip.Payloader, size int, borrow bool) ([]byte, *tcpip.Error) {
+	zp, ok := p.(tcpip.ZeroCopyPayloader)
+	if !ok {
+		return p.Payload(size)
+	}
+	if borrow {
+		return zp.BorrowPayload(size)
+	}
+	zp.ZeroCopySend().MarkCopied()
+	return p.Payload(size)
+}
+
+// queueZeroCopySendLocked records that the payload of p, which was just
+// added to the send queue, is held until it is acknowledged.
+//
+// Precondition: e.sndBufMu must be locked.
+func (e *endpoint) queueZeroCopySendLocked(p tcpip.Payloader) {
+	zp, ok := p.(tcpip.ZeroCopyPayloader)
+	if !ok {
+		return
+	}
+	zc := zp.ZeroCopySend()
+	zc.IncRef()
+	e.zeroCopySends = append(e.zeroCopySends, zeroCopySend{
+		end: e.sndBufQueued,
+		zc:  zc,
+	})
+}
+
+// ackedZeroCopySendsLocked removes the sends whose payload was acknowledged
+// from e.zeroCopySends and returns them.
+//
+// Precondition: e.sndBufMu must be locked.
+func (e *endpoint) ackedZeroCopySendsLocked() []zeroCopySend {
+	i := 0
+	for i < len(e.zeroCopySends) && e.zeroCopySends[i].end <= e.sndBufAcked {
+		i++
+	}
+	if i == 0 {
+		return nil
+	}
+	done := e.zeroCopySends[:i:i]
+	e.zeroCopySends = e.zeroCopySends[i:]
+	return done
+}
+
+// completeZeroCopySends drops the references of the send queue on the payload
+// of sends, which queues their completion notifications.
+func (e *endpoint) completeZeroCopySends(sends []zeroCopySend) {
+	notify := false
+	for _, s := range sends {
+		if s.zc.DecRef() {
+			notify = true
+		}
+	}
+	if notify {
+		e.waiterQueue.Notify(waiter.EventErr)
+	}
+}
@@ -36,6 +36,8 @@ type udpPacket struct {
 	timestamp     int64
 	// tos stores either the receiveTOS or receiveTClass value.
 	tos uint8
+	// flowInfo stores the IPv6 traffic class and flow label.
+	flowInfo uint32
 }
 
 // EndpointState represents the state of a UDP endpoint.
@@ -110,6 +112,11 @@ type endpoint struct {
 	portFlags      ports.Flags
 	bindToDevice   tcpip.NICID
 
+	// routeCache caches the route of the datagrams sent to an explicit
+	// destination, so that sending repeatedly to it doesn't find a route
+	// for each datagram.
+	routeCache stack.RouteCache `state:"nosave"`
+
 	lastErrorMu sync.Mutex   `state:"nosave"`
 	lastError   *tcpip.Error `state:".(string)"`
 
@@ -122,6 +129,27 @@ type endpoint struct {
 	// applied while sending packets. Defaults to 0 as on Linux.
 	sendTOS uint8
 
+	// pmtud is the path MTU discovery setting, one of the
+	// tcpip.PMTUDiscovery* values.
+	pmtud int
+
+	// sendCsCov and recvCsCov are the checksum coverages of UDP-Lite
+	// endpoints, set with UDPLiteSendCsCovOption and UDPLiteRecvCsCovOption.
+	sendCsCov int
+	recvCsCov int
+
+	// flowLabel is the IPv6 flow label of packets sent to the connected
+	// peer. It is only set when IPV6_FLOWINFO_SEND is enabled.
+	flowLabel uint32
+
+	// flowLabels holds the IPv6 flow labels leased by the endpoint.
+	flowLabels stack.FlowLabelLeases `state:"nosave"`
+
+	// plpmtud performs packetization layer path MTU discovery for the path to
+	// the connected peer. It is created when the application first queries
+	// the discovery state.
+	plpmtud *stack.PLPMTUD `state:"nosave"`
+
 	// shutdownFlags represent the current shutdown state of the endpoint.
 	shutdownFlags tcpip.ShutdownFlags
 
@@ -129,6 +157,11 @@ type endpoint struct {
 	// closed. Protected by the mu mutex.
 	multicastMemberships map[multicastMembership]struct{}
 
+	// multicastSourceMemberships holds the sources of the source-specific
+	// multicast memberships that need to be removed when the endpoint is
+	// closed. Protected by the mu mutex.
+	multicastSourceMemberships map[multicastMembership]map[tcpip.Address]struct{}
+
 	// effectiveNetProtos contains the network protocols actually in use. In
 	// most cases it will only contain "netProto", but in cases like IPv6
 	// endpoints with v6only set to false, this could include multiple
@@ -156,12 +189,12 @@ type multicastMembership struct {
 	multicastAddr tcpip.Address
 }
 
-func newEndpoint(s *stack.Stack, netProto tcpip.NetworkProtocolNumber, waiterQueue *waiter.Queue) *endpoint {
+func newEndpoint(s *stack.Stack, transProto tcpip.TransportProtocolNumber, netProto tcpip.NetworkProtocolNumber, waiterQueue *waiter.Queue) *endpoint {
 	e := &endpoint{
 		stack: s,
 		TransportEndpointInfo: stack.TransportEndpointInfo{
 			NetProto:   netProto,
-			TransProto: header.UDPProtocolNumber,
+			TransProto: transProto,
 		},
 		waiterQueue: waiterQueue,
 		// RFC 1075 section 5.4 recommends a TTL of 1 for membership
@@ -176,12 +209,14 @@ func newEndpoint(s *stack.Stack, netProto tcpip.NetworkProtocolNumber, waiterQue
 		// TTL=1.
 		//
 		// Linux defaults to TTL=1.
-		multicastTTL:         1,
-		rcvBufSizeMax:        32 * 1024,
-		sndBufSizeMax:        32 * 1024,
-		multicastMemberships: make(map[multicastMembership]struct{}),
-		state:                StateInitial,
-		uniqueID:             s.UniqueID(),
+		multicastTTL:               1,
+		pmtud:                      tcpip.PMTUDiscoveryDont,
+		rcvBufSizeMax:              32 * 1024,
+		sndBufSizeMax:              32 * 1024,
+		multicastMemberships:       make(map[multicastMembership]struct{}),
+		multicastSourceMemberships: make(map[multicastMembership]map[tcpip.Address]struct{}),
+		state:                      StateInitial,
+		uniqueID:                   s.UniqueID(),
 	}
 	e.ops.InitHandler(e)
 	e.ops.SetMulticastLoop(true)
@@ -241,8 +276,8 @@ func (e *endpoint) Close() {
 
 	switch e.EndpointState() {
 	case StateBound, StateConnected:
-		e.stack.UnregisterTransportEndpoint(e.RegisterNICID, e.effectiveNetProtos, ProtocolNumber, e.ID, e, e.boundPortFlags, e.boundBindToDevice)
-		e.stack.ReleasePort(e.effectiveNetProtos, ProtocolNumber, e.ID.LocalAddress, e.ID.LocalPort, e.boundPortFlags, e.boundBindToDevice, tcpip.FullAddress{})
+		e.stack.UnregisterTransportEndpoint(e.RegisterNICID, e.effectiveNetProtos, e.TransProto, e.ID, e, e.boundPortFlags, e.boundBindToDevice)
+		e.stack.ReleasePort(e.effectiveNetProtos, e.TransProto, e.ID.LocalAddress, e.ID.LocalPort, e.boundPortFlags, e.boundBindToDevice, tcpip.FullAddress{})
 		e.boundBindToDevice = 0
 		e.boundPortFlags = ports.Flags{}
 	}
@@ -251,6 +286,14 @@ func (e *endpoint) Close() {
 		e.stack.LeaveGroup(e.NetProto, mem.nicID, mem.multicastAddr)
 	}
 	e.multicastMemberships = make(map[multicastMembership]struct{})
+	for mem, sources := range e.multicastSourceMemberships {
+		for source := range sources {
+			e.stack.LeaveSourceGroup(e.NetProto, mem.nicID, mem.multicastAddr, source)
+		}
+	}
+	e.multicastSourceMemberships = make(map[multicastMembership]map[tcpip.Address]struct{})
+
+	e.stack.ReleaseFlowLabels(&e.flowLabels)
 
 	// Close the receive list and drain it.
 	e.rcvMu.Lock()
@@ -266,6 +309,8 @@ func (e *endpoint) Close() {
 		e.route.Release()
 		e.route = nil
 	}
+	e.routeCache.Release()
+	e.plpmtud = nil
 
 	// Update the state.
 	e.setEndpointState(StateClosed)
@@ -305,7 +350,49 @@ func (e *endpoint) Read(addr *tcpip.FullAddress) (buffer.View, tcpip.ControlMess
 	if addr != nil {
 		*addr = p.senderAddress
 	}
+	return p.data.ToView(), e.controlMessages(p), nil
+}
+
+// ReadBatch implements tcpip.BatchEndpoint.ReadBatch.
+func (e *endpoint) ReadBatch(msgs []tcpip.ReadMessage) (int, *tcpip.Error) {
+	if err := e.LastError(); err != nil {
+		return 0, err
+	}
+
+	e.rcvMu.Lock()
 
+	if e.rcvList.Empty() {
+		err := tcpip.ErrWouldBlock
+		if e.rcvClosed {
+			e.stats.ReadErrors.ReadClosed.Increment()
+			err = tcpip.ErrClosedForReceive
+		}
+		e.rcvMu.Unlock()
+		return 0, err
+	}
+
+	ps := make([]*udpPacket, 0, len(msgs))
+	for len(ps) < len(msgs) && !e.rcvList.Empty() {
+		p := e.rcvList.Front()
+		e.rcvList.Remove(p)
+		e.rcvBufSize -= p.data.Size()
+		ps = append(ps, p)
+	}
+	e.rcvMu.Unlock()
+
+	for i, p := range ps {
+		msgs[i] = tcpip.ReadMessage{
+			Data:    p.data.ToView(),
+			Addr:    p.senderAddress,
+			Control: e.controlMessages(p),
+		}
+	}
+	return len(ps), nil
+}
+
+// controlMessages returns the control messages of p, as per the socket
+// options of the endpoint.
+func (e *endpoint) controlMessages(p *udpPacket) tcpip.ControlMessages {
 	cm := tcpip.ControlMessages{
 		HasTimestamp: true,
 		Timestamp:    p.timestamp,
@@ -323,7 +410,12 @@ func (e *endpoint) Read(addr *tcpip.FullAddress) (buffer.View, tcpip.ControlMess
 		cm.HasIPPacketInfo = true
 		cm.PacketInfo = p.packetInfo
 	}
-	return p.data.ToView(), cm, nil
+	// Like Linux, only pass the flow information when it is not zero.
+	if e.ops.GetReceiveFlowInfo() && p.flowInfo != 0 {
+		cm.HasFlowInfo = true
+		cm.FlowInfo = p.flowInfo
+	}
+	return cm
 }
 
 // prepareForWrite prepares the endpoint for sending data. In particular, it
@@ -368,8 +460,15 @@ func (e *endpoint) prepareForWrite(to *tcpip.FullAddress) (retry bool, err *tcpi
 
 // connectRoute establishes a route to the specified interface or the
 // configured multicast interface if no interface is specified and the
-// specified address is a multicast address.
-func (e *endpoint) connectRoute(nicID tcpip.NICID, addr tcpip.FullAddress, netProto tcpip.NetworkProtocolNumber) (*stack.Route, tcpip.NICID, *tcpip.Error) {
+// specified address is a multicast address. The route is cached by cache, if
+// not nil.
+func (e *endpoint) connectRoute(nicID tcpip.NICID, addr tcpip.FullAddress, netProto tcpip.NetworkProtocolNumber, cache *stack.RouteCache) (*stack.Route, tcpip.NICID, *tcpip.Error) {
+	// An endpoint bound to a device only finds routes through the device, or
+	// its routing domain if it is a VRF device.
+	if nicID == 0 {
+		nicID = e.bindToDevice
+	}
+
 	localAddr := e.ID.LocalAddress
 	if e.isBroadcastOrMulticast(nicID, netProto, localAddr) {
 		// A packet can only originate from a unicast address (i.e., an interface).
@@ -386,7 +485,7 @@ func (e *endpoint) connectRoute(nicID tcpip.NICID, addr tcpip.FullAddress, netPr
 	}
 
 	// Find a route to the desired destination.
-	r, err := e.stack.FindRoute(nicID, localAddr, addr.Addr, netProto, e.ops.GetMulticastLoop())
+	r, err := e.stack.FindRouteWithCache(cache, nicID, localAddr, addr.Addr, netProto, e.ops.GetMulticastLoop(), stack.RoutePolicyKey{Mark: e.ops.GetMark()})
 	if err != nil {
 		return nil, 0, err
 	}
@@ -397,6 +496,53 @@ func (e *endpoint) connectRoute(nicID tcpip.NICID, addr tcpip.FullAddress, netPr
 // if the data cannot be written.
 func (e *endpoint) Write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, <-chan struct{}, *tcpip.Error) {
 	n, ch, err := e.write(p, opts)
+	e.updateWriteStats(err)
+	return n, ch, err
+}
+
+// WriteBatch implements tcpip.BatchEndpoint.WriteBatch.
+func (e *endpoint) WriteBatch(msgs []tcpip.WriteMessage) (int, <-chan struct{}, *tcpip.Error) {
+	if err := e.LastError(); err != nil {
+		e.updateWriteStats(err)
+		return 0, nil, err
+	}
+
+	// The datagrams are prepared under a single acquisition of the lock, and
+	// sent once it is released as in write.
+	ds := make([]udpDatagram, 0, len(msgs))
+	var ch <-chan struct{}
+	var err *tcpip.Error
+	e.mu.RLock()
+	for _, m := range msgs {
+		var d udpDatagram
+		d, ch, err = e.prepareWriteLocked(m.Payload, m.Options)
+		if err != nil {
+			break
+		}
+		ds = append(ds, d)
+	}
+	e.mu.RUnlock()
+
+	for i := range ds {
+		if _, sendErr := e.send(&ds[i]); sendErr != nil {
+			// The datagrams following the one which failed are dropped.
+			for j := i + 1; j < len(ds); j++ {
+				ds[j].release()
+			}
+			e.updateWriteStats(sendErr)
+			return i, nil, sendErr
+		}
+		e.updateWriteStats(nil)
+	}
+	if err != nil {
+		e.updateWriteStats(err)
+	}
+	return len(ds), ch, err
+}
+
+// updateWriteStats updates the stats of the endpoint for a datagram written
+// with err.
+func (e *endpoint) updateWriteStats(err *tcpip.Error) {
 	switch err {
 	case nil:
 		e.stats.PacketsSent.Increment()
@@ -415,7 +561,40 @@ func (e *endpoint) Write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, <-c
 		// For all other errors when writing to the network layer.
 		e.stats.SendErrors.SendToNetworkFailed.Increment()
 	}
-	return n, ch, err
+}
+
+// udpDatagram is a datagram prepared for sending by prepareWriteLocked.
+type udpDatagram struct {
+	route *stack.Route
+	// ownedRoute is true if route was found for the datagram, and must be
+	// released once it is sent.
+	ownedRoute bool
+
+	data          buffer.View
+	localPort     uint16
+	dstPort       uint16
+	ttl           uint8
+	useDefaultTTL bool
+	tos           uint8
+	flowLabel     uint32
+	dontFragment  bool
+	ignorePathMTU bool
+	owner         tcpip.PacketOwner
+	noChecksum    bool
+	sendCsCov     int
+	txTime        stack.TxTime
+	tsReq         *tcpip.TxTimestampRequest
+
+	// zeroCopy is the payload of the datagram if it is sent with
+	// MSG_ZEROCOPY, and nil otherwise.
+	zeroCopy tcpip.ZeroCopyPayloader
+}
+
+// release releases the resources held by d, which isn't sent.
+func (d *udpDatagram) release() {
+	if d.ownedRoute {
+		d.route.Release()
+	}
 }
 
 func (e *endpoint) write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, <-chan struct{}, *tcpip.Error) {
@@ -423,32 +602,37 @@ func (e *endpoint) write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, <-c
 		return 0, nil, err
 	}
 
+	e.mu.RLock()
+	d, ch, err := e.prepareWriteLocked(p, opts)
+	e.mu.RUnlock()
+	if err != nil {
+		return 0, ch, err
+	}
+	n, err := e.send(&d)
+	return n, nil, err
+}
+
+// prepareWriteLocked prepares a datagram with payload p for sending with opts.
+//
+// Precondition: e.mu must be read locked.
+func (e *endpoint) prepareWriteLocked(p tcpip.Payloader, opts tcpip.WriteOptions) (d udpDatagram, ch <-chan struct{}, err *tcpip.Error) {
 	// MSG_MORE is unimplemented. (This also means that MSG_EOR is a no-op.)
 	if opts.More {
-		return 0, nil, tcpip.ErrInvalidOptionValue
+		return udpDatagram{}, nil, tcpip.ErrInvalidOptionValue
 	}
 
 	to := opts.To
 
-	e.mu.RLock()
-	lockReleased := false
-	defer func() {
-		if lockReleased {
-			return
-		}
-		e.mu.RUnlock()
-	}()
-
 	// If we've shutdown with SHUT_WR we are in an invalid state for sending.
 	if e.shutdownFlags&tcpip.ShutdownWrite != 0 {
-		return 0, nil, tcpip.ErrClosedForSend
+		return udpDatagram{}, nil, tcpip.ErrClosedForSend
 	}
 
 	// Prepare for write.
 	for {
 		retry, err := e.prepareForWrite(to)
 		if err != nil {
-			return 0, nil, err
+			return udpDatagram{}, nil, err
 		}
 
 		if !retry {
@@ -458,13 +642,15 @@ func (e *endpoint) write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, <-c
 
 	route := e.route
 	dstPort := e.dstPort
+	flowLabel := e.flowLabel
+	ownedRoute := false
 	if to != nil {
 		// Reject destination address if it goes through a different
 		// NIC than the endpoint was bound to.
 		nicID := to.NIC
 		if e.BindNICID != 0 {
 			if nicID != 0 && nicID != e.BindNICID {
-				return 0, nil, tcpip.ErrNoRoute
+				return udpDatagram{}, nil, tcpip.ErrNoRoute
 			}
 
 			nicID = e.BindNICID
@@ -472,44 +658,81 @@ func (e *endpoint) write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, <-c
 
 		if to.Port == 0 {
 			// Port 0 is an invalid port to send to.
-			return 0, nil, tcpip.ErrInvalidEndpointState
+			return udpDatagram{}, nil, tcpip.ErrInvalidEndpointState
 		}
 
 		dst, netProto, err := e.checkV4MappedLocked(*to)
 		if err != nil {
-			return 0, nil, err
+			return udpDatagram{}, nil, err
 		}
 
-		r, _, err := e.connectRoute(nicID, dst, netProto)
+		flowLabel, err = e.sendFlowLabelLocked(dst, netProto)
 		if err != nil {
-			return 0, nil, err
+			return udpDatagram{}, nil, err
 		}
-		defer r.Release()
+
+		r, _, err := e.connectRoute(nicID, dst, netProto, &e.routeCache)
+		if err != nil {
+			return udpDatagram{}, nil, err
+		}
+		defer func() {
+			if !d.ownedRoute {
+				r.Release()
+			}
+		}()
 
 		route = r
 		dstPort = dst.Port
+		ownedRoute = true
 	}
 
 	if !e.ops.GetBroadcast() && route.IsOutboundBroadcast() {
-		return 0, nil, tcpip.ErrBroadcastDisabled
+		return udpDatagram{}, nil, tcpip.ErrBroadcastDisabled
 	}
 
 	if route.IsResolutionRequired() {
 		if ch, err := route.Resolve(nil); err != nil {
 			if err == tcpip.ErrWouldBlock {
-				return 0, ch, tcpip.ErrNoLinkAddress
+				return udpDatagram{}, ch, tcpip.ErrNoLinkAddress
 			}
-			return 0, nil, err
+			return udpDatagram{}, nil, err
 		}
 	}
 
-	v, err := p.FullPayload()
+	var v []byte
+	zp, zeroCopy := p.(tcpip.ZeroCopyPayloader)
+	if zeroCopy && route.HasZeroCopyTXCapability() {
+		// The datagram is sent synchronously, so the payload of MSG_ZEROCOPY
+		// sends can be borrowed from the sender.
+		v, err = zp.BorrowFullPayload()
+	} else {
+		if zeroCopy {
+			zp.ZeroCopySend().MarkCopied()
+		}
+		v, err = p.FullPayload()
+	}
 	if err != nil {
-		return 0, nil, err
+		return udpDatagram{}, nil, err
 	}
 	if len(v) > header.UDPMaximumPacketSize {
 		// Payload can't possibly fit in a packet.
-		return 0, nil, tcpip.ErrMessageTooLong
+		return udpDatagram{}, nil, tcpip.ErrMessageTooLong
+	}
+	var dontFragment, ignorePathMTU bool
+	if opts.PathMTUProbe {
+		if header.UDPMinimumSize+len(v) > int(route.MaxMTU()) {
+			// Probes are never fragmented.
+			return udpDatagram{}, nil, tcpip.ErrMessageTooLong
+		}
+		dontFragment, ignorePathMTU = true, true
+	} else {
+		dontFragment, ignorePathMTU, err = route.PMTUDiscoveryParams(e.pmtud, header.UDPMinimumSize+len(v))
+		if err != nil {
+			if err == tcpip.ErrMessageTooLong && e.ops.QueueLocalErr(err, route.NetProto, route.PMTUDiscoveryPathMTU(e.pmtud), tcpip.FullAddress{Addr: route.RemoteAddress, Port: dstPort}, nil /* payload */) {
+				e.waiterQueue.Notify(waiter.EventErr)
+			}
+			return udpDatagram{}, nil, err
+		}
 	}
 
 	ttl := e.ttl
@@ -521,27 +744,100 @@ func (e *endpoint) write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, <-c
 		useDefaultTTL = false
 	}
 
-	localPort := e.ID.LocalPort
-	sendTOS := e.sendTOS
-	owner := e.owner
-	noChecksum := e.SocketOptions().GetNoChecksum()
-	lockReleased = true
-	e.mu.RUnlock()
+	d = udpDatagram{
+		route:         route,
+		ownedRoute:    ownedRoute,
+		data:          v,
+		localPort:     e.ID.LocalPort,
+		dstPort:       dstPort,
+		ttl:           ttl,
+		useDefaultTTL: useDefaultTTL,
+		tos:           e.sendTOS,
+		flowLabel:     flowLabel,
+		dontFragment:  dontFragment,
+		ignorePathMTU: ignorePathMTU,
+		owner:         e.owner,
+		noChecksum:    e.SocketOptions().GetNoChecksum(),
+		sendCsCov:     e.sendCsCov,
+		txTime:        e.txTime(opts, route.NetProto, tcpip.FullAddress{Addr: route.RemoteAddress, Port: dstPort}),
+		tsReq:         e.ops.NewTxTimestampRequest(v, false /* stream */, route.NetProto),
+	}
+	if zeroCopy {
+		d.zeroCopy = zp
+	}
+	return d, nil, nil
+}
 
-	// Do not hold lock when sending as loopback is synchronous and if the UDP
-	// datagram ends up generating an ICMP response then it can result in a
-	// deadlock where the ICMP response handling ends up acquiring this endpoint's
-	// mutex using e.mu.RLock() in endpoint.HandleControlPacket which can cause a
-	// deadlock if another caller is trying to acquire e.mu in exclusive mode w/
-	// e.mu.Lock(). Since e.mu.Lock() prevents any new read locks to ensure the
-	// lock can be eventually acquired.
-	//
-	// See: https://golang.org/pkg/sync/#RWMutex for details on why recursive read
-	// locking is prohibited.
-	if err := sendUDP(route, buffer.View(v).ToVectorisedView(), localPort, dstPort, ttl, useDefaultTTL, sendTOS, owner, noChecksum); err != nil {
-		return 0, nil, err
+// send sends d, which was prepared by prepareWriteLocked, and releases it.
+//
+// Do not hold lock when sending as loopback is synchronous and if the UDP
+// datagram ends up generating an ICMP response then it can result in a
+// deadlock where the ICMP response handling ends up acquiring this endpoint's
+// mutex using e.mu.RLock() in endpoint.HandleControlPacket which can cause a
+// deadlock if another caller is trying to acquire e.mu in exclusive mode w/
+// e.mu.Lock(). Since e.mu.Lock() prevents any new read locks to ensure the
+// lock can be eventually acquired.
+//
+// See: https://golang.org/pkg/sync/#RWMutex for details on why recursive read
+// locking is prohibited.
+//
+// Precondition: e.mu must not be locked.
+func (e *endpoint) send(d *udpDatagram) (int64, *tcpip.Error) {
+	defer d.release()
+	e.reportTxTimestamp(d.tsReq, tcpip.TimestampSched)
+	if err := sendUDP(d.route, e.TransProto, d.data.ToVectorisedView(), d.localPort, d.dstPort, d.ttl, d.useDefaultTTL, d.tos, d.flowLabel, d.dontFragment, d.ignorePathMTU, d.owner, d.noChecksum, d.sendCsCov, d.txTime); err != nil {
+		return 0, err
+	}
+	e.reportTxTimestamp(d.tsReq, tcpip.TimestampSnd)
+	if d.zeroCopy != nil {
+		d.zeroCopy.ZeroCopySend().MarkSent()
+	}
+	return int64(len(d.data)), nil
+}
+
+// reportTxTimestamp reports the transmit timestamp of type t of the datagram
+// which requested r, if any.
+func (e *endpoint) reportTxTimestamp(r *tcpip.TxTimestampRequest, t tcpip.TimestampType) {
+	if r == nil {
+		return
+	}
+	if r.Report(t, e.stack.Clock().NowNanoseconds()) {
+		e.waiterQueue.Notify(waiter.EventErr)
+	}
+}
+
+// txTime returns the transmit time of a datagram written with opts to dst.
+func (e *endpoint) txTime(opts tcpip.WriteOptions, netProto tcpip.NetworkProtocolNumber, dst tcpip.FullAddress) stack.TxTime {
+	if opts.TxTime == 0 {
+		return stack.TxTime{}
+	}
+	v := e.ops.GetTxTime()
+	if !v.Enabled {
+		return stack.TxTime{}
+	}
+	txTime := stack.TxTime{
+		Time:     opts.TxTime,
+		Deadline: v.DeadlineMode,
+	}
+	if v.ReportErrors {
+		reported := opts.TxTimeReported
+		txTime.Missed = func() {
+			// As in Linux, the transmit time is reported in the info
+			// and data of the error.
+			if e.ops.QueueErr(&tcpip.SockError{
+				Err:       tcpip.ErrCanceled,
+				ErrOrigin: tcpip.SockExtErrorOriginTxTime,
+				ErrCode:   tcpip.SockExtErrorCodeTxTimeMissed,
+				ErrInfo:   uint32(reported),
+				ErrData:   uint32(reported >> 32),
+				Dst:       dst,
+				NetProto:  netProto,
+			}) {
+				e.waiterQueue.Notify(waiter.EventErr)
+			}
+		}
 	}
-	return int64(len(v)), nil, nil
+	return txTime
 }
 
 // Peek only returns data from a single datagram, so do nothing here.
@@ -563,15 +859,47 @@ func (e *endpoint) OnReusePortSet(v bool) {
 	e.mu.Unlock()
 }
 
+// OnReusePortFilterSet implements
+// tcpip.SocketOptionsHandler.OnReusePortFilterSet.
+func (e *endpoint) OnReusePortFilterSet(f tcpip.ReusePortFilter) {
+	e.mu.Lock()
+	// Only bound endpoints belong to a SO_REUSEPORT group. The filter of an
+	// unbound endpoint is attached when it is bound.
+	if e.EndpointState() == StateBound {
+		e.stack.SetReusePortFilter(e.effectiveNetProtos, e.TransProto, e.ID, e, f, e.boundBindToDevice)
+	}
+	e.mu.Unlock()
+}
+
 // SetSockOptInt implements tcpip.Endpoint.SetSockOptInt.
 func (e *endpoint) SetSockOptInt(opt tcpip.SockOptInt, v int) *tcpip.Error {
 	switch opt {
 	case tcpip.MTUDiscoverOption:
-		// Return not supported if the value is not disabling path
-		// MTU discovery.
-		if v != tcpip.PMTUDiscoveryDont {
-			return tcpip.ErrNotSupported
+		if v < tcpip.PMTUDiscoveryWant || v > tcpip.PMTUDiscoveryOmit {
+			return tcpip.ErrInvalidOptionValue
+		}
+		e.mu.Lock()
+		e.pmtud = v
+		e.mu.Unlock()
+
+	case tcpip.UDPLiteSendCsCovOption, tcpip.UDPLiteRecvCsCovOption:
+		if e.TransProto != header.UDPLiteProtocolNumber {
+			return tcpip.ErrUnknownProtocolOption
+		}
+		// As in Linux, coverages which can't be held by the length field
+		// are clamped, and nonzero coverages include at least the header.
+		if v != 0 && v < header.UDPMinimumSize {
+			v = header.UDPMinimumSize
+		} else if v > header.UDPMaximumSize {
+			v = header.UDPMaximumSize
 		}
+		e.mu.Lock()
+		if opt == tcpip.UDPLiteSendCsCovOption {
+			e.sendCsCov = v
+		} else {
+			e.recvCsCov = v
+		}
+		e.mu.Unlock()
 
 	case tcpip.MulticastTTLOption:
 		e.mu.Lock()
@@ -709,6 +1037,9 @@ func (e *endpoint) SetSockOpt(opt tcpip.SettableSocketOption) *tcpip.Error {
 		if _, ok := e.multicastMemberships[memToInsert]; ok {
 			return tcpip.ErrPortInUse
 		}
+		if _, ok := e.multicastSourceMemberships[memToInsert]; ok {
+			return tcpip.ErrPortInUse
+		}
 
 		if err := e.stack.JoinGroup(e.NetProto, nicID, v.MulticastAddr); err != nil {
 			return err
@@ -752,6 +1083,72 @@ func (e *endpoint) SetSockOpt(opt tcpip.SettableSocketOption) *tcpip.Error {
 
 		delete(e.multicastMemberships, memToRemove)
 
+	case *tcpip.AddSourceMembershipOption:
+		if !header.IsV4MulticastAddress(v.MulticastAddr) && !header.IsV6MulticastAddress(v.MulticastAddr) {
+			return tcpip.ErrInvalidOptionValue
+		}
+		if len(v.SourceAddr) != len(v.MulticastAddr) {
+			return tcpip.ErrInvalidOptionValue
+		}
+
+		nicID := e.multicastMembershipNIC(v.NIC, v.InterfaceAddr, v.MulticastAddr)
+		if nicID == 0 {
+			return tcpip.ErrUnknownDevice
+		}
+
+		mem := multicastMembership{nicID: nicID, multicastAddr: v.MulticastAddr}
+
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		// A group may not be joined both with and without a source filter.
+		if _, ok := e.multicastMemberships[mem]; ok {
+			return tcpip.ErrInvalidOptionValue
+		}
+		sources, ok := e.multicastSourceMemberships[mem]
+		if _, joined := sources[v.SourceAddr]; joined {
+			return tcpip.ErrPortInUse
+		}
+
+		if err := e.stack.JoinSourceGroup(e.NetProto, nicID, v.MulticastAddr, v.SourceAddr); err != nil {
+			return err
+		}
+
+		if !ok {
+			sources = make(map[tcpip.Address]struct{})
+			e.multicastSourceMemberships[mem] = sources
+		}
+		sources[v.SourceAddr] = struct{}{}
+
+	case *tcpip.RemoveSourceMembershipOption:
+		if !header.IsV4MulticastAddress(v.MulticastAddr) && !header.IsV6MulticastAddress(v.MulticastAddr) {
+			return tcpip.ErrInvalidOptionValue
+		}
+
+		nicID := e.multicastMembershipNIC(v.NIC, v.InterfaceAddr, v.MulticastAddr)
+		if nicID == 0 {
+			return tcpip.ErrUnknownDevice
+		}
+
+		mem := multicastMembership{nicID: nicID, multicastAddr: v.MulticastAddr}
+
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		sources := e.multicastSourceMemberships[mem]
+		if _, ok := sources[v.SourceAddr]; !ok {
+			return tcpip.ErrBadLocalAddress
+		}
+
+		if err := e.stack.LeaveSourceGroup(e.NetProto, nicID, v.MulticastAddr, v.SourceAddr); err != nil {
+			return err
+		}
+
+		delete(sources, v.SourceAddr)
+		if len(sources) == 0 {
+			delete(e.multicastSourceMemberships, mem)
+		}
+
 	case *tcpip.BindToDeviceOption:
 		id := tcpip.NICID(*v)
 		if id != 0 && !e.stack.HasNIC(id) {
@@ -764,10 +1161,23 @@ func (e *endpoint) SetSockOpt(opt tcpip.SettableSocketOption) *tcpip.Error {
 	case *tcpip.SocketDetachFilterOption:
 		return nil
 
+	case *tcpip.FlowLabelManagerOption:
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return e.stack.ManageFlowLabel(&e.flowLabels, *v)
+
 	case *tcpip.LingerOption:
 		e.mu.Lock()
 		e.linger = *v
 		e.mu.Unlock()
+
+	case *tcpip.PLPMTUDProbeResultOption:
+		e.mu.RLock()
+		defer e.mu.RUnlock()
+		if e.plpmtud == nil {
+			return tcpip.ErrInvalidEndpointState
+		}
+		return e.plpmtud.ProbeResult(v.Size, v.Acked)
 	}
 	return nil
 }
@@ -788,8 +1198,22 @@ func (e *endpoint) GetSockOptInt(opt tcpip.SockOptInt) (int, *tcpip.Error) {
 		return v, nil
 
 	case tcpip.MTUDiscoverOption:
-		// The only supported setting is path MTU discovery disabled.
-		return tcpip.PMTUDiscoveryDont, nil
+		e.mu.RLock()
+		v := e.pmtud
+		e.mu.RUnlock()
+		return v, nil
+
+	case tcpip.UDPLiteSendCsCovOption, tcpip.UDPLiteRecvCsCovOption:
+		if e.TransProto != header.UDPLiteProtocolNumber {
+			return -1, tcpip.ErrUnknownProtocolOption
+		}
+		e.mu.RLock()
+		v := e.sendCsCov
+		if opt == tcpip.UDPLiteRecvCsCovOption {
+			v = e.recvCsCov
+		}
+		e.mu.RUnlock()
+		return v, nil
 
 	case tcpip.MulticastTTLOption:
 		e.mu.Lock()
@@ -825,6 +1249,14 @@ func (e *endpoint) GetSockOptInt(opt tcpip.SockOptInt) (int, *tcpip.Error) {
 		e.mu.Unlock()
 		return v, nil
 
+	case tcpip.PathMTUOption:
+		e.mu.RLock()
+		defer e.mu.RUnlock()
+		if e.EndpointState() != StateConnected {
+			return -1, tcpip.ErrNotConnected
+		}
+		return int(e.route.PathMTU()), nil
+
 	default:
 		return -1, tcpip.ErrUnknownProtocolOption
 	}
@@ -851,42 +1283,60 @@ func (e *endpoint) GetSockOpt(opt tcpip.GettableSocketOption) *tcpip.Error {
 		*o = e.linger
 		e.mu.RUnlock()
 
+	case *tcpip.PLPMTUDInfo:
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if e.EndpointState() != StateConnected {
+			return tcpip.ErrNotConnected
+		}
+		if e.plpmtud == nil {
+			e.plpmtud = stack.NewPLPMTUD(e.route)
+		}
+		*o = e.plpmtud.Info()
+
 	default:
 		return tcpip.ErrUnknownProtocolOption
 	}
 	return nil
 }
 
-// sendUDP sends a UDP segment via the provided network endpoint and under the
-// provided identity.
-func sendUDP(r *stack.Route, data buffer.VectorisedView, localPort, remotePort uint16, ttl uint8, useDefaultTTL bool, tos uint8, owner tcpip.PacketOwner, noChecksum bool) *tcpip.Error {
+// sendUDP sends a UDP or UDP-Lite segment via the provided network endpoint and
+// under the provided identity. The checksum of UDP-Lite segments covers
+// sendCsCov bytes, or the whole segment if sendCsCov is 0 or larger.
+func sendUDP(r *stack.Route, transProto tcpip.TransportProtocolNumber, data buffer.VectorisedView, localPort, remotePort uint16, ttl uint8, useDefaultTTL bool, tos uint8, flowLabel uint32, dontFragment, ignorePathMTU bool, owner tcpip.PacketOwner, noChecksum bool, sendCsCov int, txTime stack.TxTime) *tcpip.Error {
 	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
 		ReserveHeaderBytes: header.UDPMinimumSize + int(r.MaxHeaderLength()),
 		Data:               data,
 	})
 	pkt.Owner = owner
+	pkt.TxTime = txTime
 
 	// Initialize the UDP header.
 	udp := header.UDP(pkt.TransportHeader().Push(header.UDPMinimumSize))
-	pkt.TransportProtocolNumber = ProtocolNumber
+	pkt.TransportProtocolNumber = transProto
 
 	length := uint16(pkt.Size())
+	// The length field of UDP-Lite segments holds their checksum coverage
+	// instead of their length (RFC 3828 section 3.1).
+	csCov := length
+	if transProto == header.UDPLiteProtocolNumber && sendCsCov != 0 && sendCsCov < int(length) {
+		csCov = uint16(sendCsCov)
+	}
 	udp.Encode(&header.UDPFields{
 		SrcPort: localPort,
 		DstPort: remotePort,
-		Length:  length,
+		Length:  csCov,
 	})
 
 	// Set the checksum field unless TX checksum offload is enabled.
 	// On IPv4, UDP checksum is optional, and a zero value indicates the
 	// transmitter skipped the checksum generation (RFC768).
 	// On IPv6, UDP checksum is not optional (RFC2460 Section 8.1).
+	// UDP-Lite checksum is never optional (RFC 3828 section 3.1).
 	if r.RequiresTXTransportChecksum() &&
-		(!noChecksum || r.NetProto == header.IPv6ProtocolNumber) {
-		xsum := r.PseudoHeaderChecksum(ProtocolNumber, length)
-		for _, v := range data.Views() {
-			xsum = header.Checksum(v, xsum)
-		}
+		(!noChecksum || r.NetProto == header.IPv6ProtocolNumber || transProto == header.UDPLiteProtocolNumber) {
+		xsum := r.PseudoHeaderChecksum(transProto, length)
+		xsum = header.ChecksumVVWithOffset(data, xsum, 0, int(csCov)-header.UDPMinimumSize)
 		udp.SetChecksum(^udp.CalculateChecksum(xsum))
 	}
 
@@ -894,19 +1344,39 @@ func sendUDP(r *stack.Route, data buffer.VectorisedView, localPort, remotePort u
 		ttl = r.DefaultTTL()
 	}
 	if err := r.WritePacket(nil /* gso */, stack.NetworkHeaderParams{
-		Protocol: ProtocolNumber,
-		TTL:      ttl,
-		TOS:      tos,
+		Protocol:      transProto,
+		TTL:           ttl,
+		TOS:           tos,
+		FlowLabel:     flowLabel,
+		DontFragment:  dontFragment,
+		IgnorePathMTU: ignorePathMTU,
 	}, pkt); err != nil {
-		r.Stats().UDP.PacketSendErrors.Increment()
+		protocolStats(r.Stats(), transProto).PacketSendErrors.Increment()
 		return err
 	}
 
 	// Track count of packets sent.
-	r.Stats().UDP.PacketsSent.Increment()
+	protocolStats(r.Stats(), transProto).PacketsSent.Increment()
 	return nil
 }
 
+// sendFlowLabelLocked returns the IPv6 flow label of packets sent to addr.
+//
+// The flow label of addr is only used when IPV6_FLOWINFO_SEND is enabled, in
+// which case the endpoint must have leased it.
+//
+// Precondition: e.mu must be locked.
+func (e *endpoint) sendFlowLabelLocked(addr tcpip.FullAddress, netProto tcpip.NetworkProtocolNumber) (uint32, *tcpip.Error) {
+	if netProto != header.IPv6ProtocolNumber || !e.ops.GetSendFlowInfo() {
+		return 0, nil
+	}
+	label := addr.FlowLabel & header.IPv6FlowLabelMask
+	if label != 0 && !e.flowLabels.Has(label) {
+		return 0, tcpip.ErrInvalidOptionValue
+	}
+	return label, nil
+}
+
 // checkV4MappedLocked determines the effective network protocol and converts
 // addr to its canonical form.
 func (e *endpoint) checkV4MappedLocked(addr tcpip.FullAddress) (tcpip.FullAddress, tcpip.NetworkProtocolNumber, *tcpip.Error) {
@@ -950,18 +1420,20 @@ func (e *endpoint) Disconnect() *tcpip.Error {
 	} else {
 		if e.ID.LocalPort != 0 {
 			// Release the ephemeral port.
-			e.stack.ReleasePort(e.effectiveNetProtos, ProtocolNumber, e.ID.LocalAddress, e.ID.LocalPort, boundPortFlags, e.boundBindToDevice, tcpip.FullAddress{})
+			e.stack.ReleasePort(e.effectiveNetProtos, e.TransProto, e.ID.LocalAddress, e.ID.LocalPort, boundPortFlags, e.boundBindToDevice, tcpip.FullAddress{})
 			e.boundPortFlags = ports.Flags{}
 		}
 		e.setEndpointState(StateInitial)
 	}
 
-	e.stack.UnregisterTransportEndpoint(e.RegisterNICID, e.effectiveNetProtos, ProtocolNumber, e.ID, e, boundPortFlags, e.boundBindToDevice)
+	e.stack.UnregisterTransportEndpoint(e.RegisterNICID, e.effectiveNetProtos, e.TransProto, e.ID, e, boundPortFlags, e.boundBindToDevice)
 	e.ID = id
 	e.boundBindToDevice = btd
 	e.route.Release()
 	e.route = nil
+	e.plpmtud = nil
 	e.dstPort = 0
+	e.flowLabel = 0
 
 	return nil
 }
@@ -1000,7 +1472,12 @@ func (e *endpoint) Connect(addr tcpip.FullAddress) *tcpip.Error {
 		return err
 	}
 
-	r, nicID, err := e.connectRoute(nicID, addr, netProto)
+	flowLabel, err := e.sendFlowLabelLocked(addr, netProto)
+	if err != nil {
+		return err
+	}
+
+	r, nicID, err := e.connectRoute(nicID, addr, netProto, nil /* cache */)
 	if err != nil {
 		return err
 	}
@@ -1037,13 +1514,15 @@ func (e *endpoint) Connect(addr tcpip.FullAddress) *tcpip.Error {
 
 	// Remove the old registration.
 	if e.ID.LocalPort != 0 {
-		e.stack.UnregisterTransportEndpoint(e.RegisterNICID, e.effectiveNetProtos, ProtocolNumber, e.ID, e, oldPortFlags, e.boundBindToDevice)
+		e.stack.UnregisterTransportEndpoint(e.RegisterNICID, e.effectiveNetProtos, e.TransProto, e.ID, e, oldPortFlags, e.boundBindToDevice)
 	}
 
 	e.ID = id
 	e.boundBindToDevice = btd
 	e.route = r.Clone()
+	e.plpmtud = nil
 	e.dstPort = addr.Port
+	e.flowLabel = flowLabel
 	e.RegisterNICID = nicID
 	e.effectiveNetProtos = netProtos
 
@@ -1101,7 +1580,7 @@ func (*endpoint) Accept(*tcpip.FullAddress) (tcpip.Endpoint, *waiter.Queue, *tcp
 
 func (e *endpoint) registerWithStack(nicID tcpip.NICID, netProtos []tcpip.NetworkProtocolNumber, id stack.TransportEndpointID) (stack.TransportEndpointID, tcpip.NICID, *tcpip.Error) {
 	if e.ID.LocalPort == 0 {
-		port, err := e.stack.ReservePort(netProtos, ProtocolNumber, id.LocalAddress, id.LocalPort, e.portFlags, e.bindToDevice, tcpip.FullAddress{}, nil /* testPort */)
+		port, err := e.stack.ReservePort(netProtos, e.TransProto, id.LocalAddress, id.LocalPort, e.portFlags, e.bindToDevice, tcpip.FullAddress{}, nil /* testPort */)
 		if err != nil {
 			return id, e.bindToDevice, err
 		}
@@ -1109,9 +1588,9 @@ func (e *endpoint) registerWithStack(nicID tcpip.NICID, netProtos []tcpip.Networ
 	}
 	e.boundPortFlags = e.portFlags
 
-	err := e.stack.RegisterTransportEndpoint(nicID, netProtos, ProtocolNumber, id, e, e.boundPortFlags, e.bindToDevice)
+	err := e.stack.RegisterTransportEndpoint(nicID, netProtos, e.TransProto, id, e, e.boundPortFlags, e.bindToDevice)
 	if err != nil {
-		e.stack.ReleasePort(netProtos, ProtocolNumber, id.LocalAddress, id.LocalPort, e.boundPortFlags, e.bindToDevice, tcpip.FullAddress{})
+		e.stack.ReleasePort(netProtos, e.TransProto, id.LocalAddress, id.LocalPort, e.boundPortFlags, e.bindToDevice, tcpip.FullAddress{})
 		e.boundPortFlags = ports.Flags{}
 	}
 	return id, e.bindToDevice, err
@@ -1142,10 +1621,14 @@ func (e *endpoint) bindLocked(addr tcpip.FullAddress) *tcpip.Error {
 
 	nicID := addr.NIC
 	if len(addr.Addr) != 0 && !e.isBroadcastOrMulticast(addr.NIC, netProto, addr.Addr) {
-		// A local unicast address was specified, verify that it's valid.
+		// A local unicast address was specified, verify that it's valid
+		// unless non-local binds are allowed.
 		nicID = e.stack.CheckLocalAddress(addr.NIC, netProto, addr.Addr)
 		if nicID == 0 {
-			return tcpip.ErrBadLocalAddress
+			if !e.stack.CanBindNonLocal(netProto, &e.ops) {
+				return tcpip.ErrBadLocalAddress
+			}
+			nicID = addr.NIC
 		}
 	}
 
@@ -1162,6 +1645,9 @@ func (e *endpoint) bindLocked(addr tcpip.FullAddress) *tcpip.Error {
 	e.boundBindToDevice = btd
 	e.RegisterNICID = nicID
 	e.effectiveNetProtos = netProtos
+	if f := e.ops.GetReusePortFilter(); f != nil {
+		e.stack.SetReusePortFilter(netProtos, e.TransProto, id, e, f, btd)
+	}
 
 	// Mark endpoint as bound.
 	e.setEndpointState(StateBound)
@@ -1241,17 +1727,57 @@ func (e *endpoint) Readiness(mask waiter.EventMask) waiter.EventMask {
 	e.lastErrorMu.Lock()
 	hasError := e.lastError != nil
 	e.lastErrorMu.Unlock()
-	if hasError {
+	if hasError || e.ops.PeekErr() != nil {
 		result |= waiter.EventErr
 	}
 	return result
 }
 
+// protocolStats returns the stats of transProto, UDP or UDP-Lite.
+func protocolStats(stats tcpip.Stats, transProto tcpip.TransportProtocolNumber) tcpip.UDPStats {
+	if transProto == header.UDPLiteProtocolNumber {
+		return stats.UDPLite
+	}
+	return stats.UDP
+}
+
+// checksumCoverage returns the number of bytes of a segment of transProto
+// covered by its checksum, or false if the length field of hdr is invalid.
+// UDP checksums cover the whole segment. UDP-Lite checksums cover the number
+// of bytes held by the length field, or the whole segment if it is 0 (RFC 3828
+// section 3.1).
+func checksumCoverage(transProto tcpip.TransportProtocolNumber, hdr header.UDP, pkt *stack.PacketBuffer) (int, bool) {
+	size := header.UDPMinimumSize + pkt.Data.Size()
+	length := int(hdr.Length())
+	if transProto != header.UDPLiteProtocolNumber {
+		return size, length <= size
+	}
+	if length == 0 {
+		return size, true
+	}
+	return length, length >= header.UDPMinimumSize && length <= size
+}
+
 // verifyChecksum verifies the checksum unless RX checksum offload is enabled.
 // On IPv4, UDP checksum is optional, and a zero value means the transmitter
 // omitted the checksum generation (RFC768).
 // On IPv6, UDP checksum is not optional (RFC2460 Section 8.1).
-func verifyChecksum(hdr header.UDP, pkt *stack.PacketBuffer) bool {
+// UDP-Lite checksum is never optional, and only covers csCov bytes (RFC 3828
+// section 3.1).
+func verifyChecksum(transProto tcpip.TransportProtocolNumber, hdr header.UDP, pkt *stack.PacketBuffer, csCov int) bool {
+	if transProto == header.UDPLiteProtocolNumber {
+		if pkt.RXTransportChecksumValidated {
+			return true
+		}
+		if hdr.Checksum() == 0 {
+			return false
+		}
+		netHdr := pkt.Network()
+		xsum := header.PseudoHeaderChecksum(transProto, netHdr.DestinationAddress(), netHdr.SourceAddress(), uint16(header.UDPMinimumSize+pkt.Data.Size()))
+		xsum = header.ChecksumVVWithOffset(pkt.Data, xsum, 0, csCov-header.UDPMinimumSize)
+		return hdr.CalculateChecksum(xsum) == 0xffff
+	}
+
 	if !pkt.RXTransportChecksumValidated &&
 		(hdr.Checksum() != 0 || pkt.NetworkProtocolNumber == header.IPv6ProtocolNumber) {
 		netHdr := pkt.Network()
@@ -1264,40 +1790,100 @@ func verifyChecksum(hdr header.UDP, pkt *stack.PacketBuffer) bool {
 	return true
 }
 
+// multicastMembershipNIC returns the NIC to use for a multicast membership,
+// or 0 if no NIC could be found.
+//
+// The interface address is considered not-set if it is empty or contains
+// all-zeros.
+func (e *endpoint) multicastMembershipNIC(nicID tcpip.NICID, interfaceAddr, multicastAddr tcpip.Address) tcpip.NICID {
+	if len(interfaceAddr) != 0 && interfaceAddr != header.IPv4Any {
+		return e.stack.CheckLocalAddress(nicID, e.NetProto, interfaceAddr)
+	}
+	if nicID == 0 {
+		r, err := e.stack.FindRoute(0, "", multicastAddr, header.IPv4ProtocolNumber, false /* multicastLoop */)
+		if err == nil {
+			nicID = r.NICID()
+			r.Release()
+		}
+	}
+	return nicID
+}
+
+// multicastSourceAllowed returns true if a packet sent to dst by src on the
+// specified NIC passes the endpoint's source-specific multicast memberships.
+//
+// Packets to groups the endpoint did not join with a source filter are always
+// allowed.
+func (e *endpoint) multicastSourceAllowed(nicID tcpip.NICID, dst, src tcpip.Address) bool {
+	if !header.IsV4MulticastAddress(dst) && !header.IsV6MulticastAddress(dst) {
+		return true
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	sources, ok := e.multicastSourceMemberships[multicastMembership{nicID: nicID, multicastAddr: dst}]
+	if !ok {
+		return true
+	}
+	_, ok = sources[src]
+	return ok
+}
+
 // HandlePacket is called by the stack when new packets arrive to this transport
 // endpoint.
 func (e *endpoint) HandlePacket(id stack.TransportEndpointID, pkt *stack.PacketBuffer) {
 	// Get the header then trim it from the view.
 	hdr := header.UDP(pkt.TransportHeader().View())
-	if int(hdr.Length()) > pkt.Data.Size()+header.UDPMinimumSize {
+	stats := protocolStats(e.stack.Stats(), e.TransProto)
+	csCov, ok := checksumCoverage(e.TransProto, hdr, pkt)
+	if !ok {
 		// Malformed packet.
-		e.stack.Stats().UDP.MalformedPacketsReceived.Increment()
+		stats.MalformedPacketsReceived.Increment()
 		e.stats.ReceiveErrors.MalformedPacketsReceived.Increment()
 		return
 	}
 
-	if !verifyChecksum(hdr, pkt) {
+	if !verifyChecksum(e.TransProto, hdr, pkt, csCov) {
 		// Checksum Error.
-		e.stack.Stats().UDP.ChecksumErrors.Increment()
+		stats.ChecksumErrors.Increment()
 		e.stats.ReceiveErrors.ChecksumErrors.Increment()
 		return
 	}
 
-	e.stack.Stats().UDP.PacketsReceived.Increment()
+	// Drop UDP-Lite packets whose checksum partially covers less than
+	// required by the endpoint.
+	if e.TransProto == header.UDPLiteProtocolNumber && csCov < header.UDPMinimumSize+pkt.Data.Size() {
+		e.mu.RLock()
+		recvCsCov := e.recvCsCov
+		e.mu.RUnlock()
+		if csCov < recvCsCov {
+			stats.MalformedPacketsReceived.Increment()
+			e.stats.ReceiveErrors.MalformedPacketsReceived.Increment()
+			return
+		}
+	}
+
+	stats.PacketsReceived.Increment()
 	e.stats.PacketsReceived.Increment()
 
+	// Drop multicast packets from sources that the endpoint did not join.
+	if !e.multicastSourceAllowed(pkt.NICID, id.LocalAddress, id.RemoteAddress) {
+		return
+	}
+
 	e.rcvMu.Lock()
 	// Drop the packet if our buffer is currently full.
 	if !e.rcvReady || e.rcvClosed {
 		e.rcvMu.Unlock()
-		e.stack.Stats().UDP.ReceiveBufferErrors.Increment()
+		stats.ReceiveBufferErrors.Increment()
 		e.stats.ReceiveErrors.ClosedReceiver.Increment()
 		return
 	}
 
 	if e.rcvBufSize >= e.rcvBufSizeMax {
 		e.rcvMu.Unlock()
-		e.stack.Stats().UDP.ReceiveBufferErrors.Increment()
+		stats.ReceiveBufferErrors.Increment()
 		e.stats.ReceiveErrors.ReceiveBufferOverflow.Increment()
 		return
 	}
@@ -1321,7 +1907,9 @@ func (e *endpoint) HandlePacket(id stack.TransportEndpointID, pkt *stack.PacketB
 	case header.IPv4ProtocolNumber:
 		packet.tos, _ = header.IPv4(pkt.NetworkHeader().View()).TOS()
 	case header.IPv6ProtocolNumber:
-		packet.tos, _ = header.IPv6(pkt.NetworkHeader().View()).TOS()
+		var flowLabel uint32
+		packet.tos, flowLabel = header.IPv6(pkt.NetworkHeader().View()).TOS()
+		packet.flowInfo = uint32(packet.tos)<<20 | flowLabel
 	}
 
 	// TODO(gvisor.dev/issue/3556): r.LocalAddress may be a multicast or broadcast
@@ -1343,6 +1931,20 @@ func (e *endpoint) HandlePacket(id stack.TransportEndpointID, pkt *stack.PacketB
 
 // HandleControlPacket implements stack.TransportEndpoint.HandleControlPacket.
 func (e *endpoint) HandleControlPacket(id stack.TransportEndpointID, typ stack.ControlType, extra uint32, pkt *stack.PacketBuffer) {
+	if e.ops.GetRecvError() {
+		e.mu.RLock()
+		pmtud := e.pmtud
+		e.mu.RUnlock()
+
+		// Like Linux, don't report Packet Too Big errors to endpoints that
+		// let the stack fragment their packets.
+		if typ != stack.ControlPacketTooBig || pmtud != tcpip.PMTUDiscoveryDont {
+			if sockErr := stack.NewSockErrorFromControl(id, typ, extra, pkt); sockErr != nil && e.ops.QueueErr(sockErr) {
+				e.waiterQueue.Notify(waiter.EventErr)
+			}
+		}
+	}
+
 	if typ == stack.ControlPortUnreachable {
 		if e.EndpointState() == StateConnected {
 			e.lastErrorMu.Lock()
@@ -1353,6 +1955,23 @@ func (e *endpoint) HandleControlPacket(id stack.TransportEndpointID, typ stack.C
 			return
 		}
 	}
+	if typ == stack.ControlPacketTooBig {
+		e.mu.RLock()
+		if e.plpmtud != nil {
+			e.plpmtud.PacketTooBig(extra)
+		}
+		e.mu.RUnlock()
+	}
+}
+
+// HandleNICRemoved implements stack.NICRemovalHandler.HandleNICRemoved. The
+// removal is reported as a pending ErrUnknownDevice error.
+func (e *endpoint) HandleNICRemoved(tcpip.NICID) {
+	e.lastErrorMu.Lock()
+	e.lastError = tcpip.ErrUnknownDevice
+	e.lastErrorMu.Unlock()
+
+	e.waiterQueue.Notify(waiter.EventErr)
 }
 
 // State implements tcpip.Endpoint.State.
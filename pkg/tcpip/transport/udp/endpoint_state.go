@@ -97,6 +97,13 @@ func (e *endpoint) Resume(s *stack.Stack) {
 			panic(err)
 		}
 	}
+	for m, sources := range e.multicastSourceMemberships {
+		for source := range sources {
+			if err := e.stack.JoinSourceGroup(e.NetProto, m.nicID, m.multicastAddr, source); err != nil {
+				panic(err)
+			}
+		}
+	}
 
 	state := e.EndpointState()
 	if state != StateBound && state != StateConnected {
@@ -115,13 +122,13 @@ func (e *endpoint) Resume(s *stack.Stack) {
 
 	var err *tcpip.Error
 	if state == StateConnected {
-		e.route, err = e.stack.FindRoute(e.RegisterNICID, e.ID.LocalAddress, e.ID.RemoteAddress, netProto, e.ops.GetMulticastLoop())
+		e.route, err = e.stack.FindRouteWithPolicy(e.RegisterNICID, e.ID.LocalAddress, e.ID.RemoteAddress, netProto, e.ops.GetMulticastLoop(), stack.RoutePolicyKey{Mark: e.ops.GetMark()})
 		if err != nil {
 			panic(err)
 		}
 	} else if len(e.ID.LocalAddress) != 0 && !e.isBroadcastOrMulticast(e.RegisterNICID, netProto, e.ID.LocalAddress) { // stateBound
 		// A local unicast address is specified, verify that it's valid.
-		if e.stack.CheckLocalAddress(e.RegisterNICID, netProto, e.ID.LocalAddress) == 0 {
+		if e.stack.CheckLocalAddress(e.RegisterNICID, netProto, e.ID.LocalAddress) == 0 && !e.stack.CanBindNonLocal(netProto, &e.ops) {
 			panic(tcpip.ErrBadLocalAddress)
 		}
 	}
@@ -77,7 +77,7 @@ func (r *ForwarderRequest) CreateEndpoint(queue *waiter.Queue) (tcpip.Endpoint,
 	}
 	route.ResolveWith(r.pkt.SourceLinkAddress())
 
-	ep := newEndpoint(r.stack, r.pkt.NetworkProtocolNumber, queue)
+	ep := newEndpoint(r.stack, ProtocolNumber, r.pkt.NetworkProtocolNumber, queue)
 	if err := r.stack.RegisterTransportEndpoint(r.pkt.NICID, []tcpip.NetworkProtocolNumber{r.pkt.NetworkProtocolNumber}, ProtocolNumber, r.id, ep, ep.portFlags, ep.bindToDevice); err != nil {
 		ep.Close()
 		route.Release()
@@ -29,6 +29,9 @@ const (
 	// ProtocolNumber is the udp protocol number.
 	ProtocolNumber = header.UDPProtocolNumber
 
+	// LiteProtocolNumber is the UDP-Lite protocol number.
+	LiteProtocolNumber = header.UDPLiteProtocolNumber
+
 	// MinBufferSize is the smallest size of a receive or send buffer.
 	MinBufferSize = 4 << 10 // 4KiB bytes.
 
@@ -46,22 +49,25 @@ const (
 
 type protocol struct {
 	stack *stack.Stack
+
+	// number is the protocol number, ProtocolNumber or LiteProtocolNumber.
+	number tcpip.TransportProtocolNumber
 }
 
-// Number returns the udp protocol number.
-func (*protocol) Number() tcpip.TransportProtocolNumber {
-	return ProtocolNumber
+// Number returns the udp or UDP-Lite protocol number.
+func (p *protocol) Number() tcpip.TransportProtocolNumber {
+	return p.number
 }
 
 // NewEndpoint creates a new udp endpoint.
 func (p *protocol) NewEndpoint(netProto tcpip.NetworkProtocolNumber, waiterQueue *waiter.Queue) (tcpip.Endpoint, *tcpip.Error) {
-	return newEndpoint(p.stack, netProto, waiterQueue), nil
+	return newEndpoint(p.stack, p.number, netProto, waiterQueue), nil
 }
 
 // NewRawEndpoint creates a new raw UDP endpoint. It implements
 // stack.TransportProtocol.NewRawEndpoint.
 func (p *protocol) NewRawEndpoint(netProto tcpip.NetworkProtocolNumber, waiterQueue *waiter.Queue) (tcpip.Endpoint, *tcpip.Error) {
-	return raw.NewEndpoint(p.stack, netProto, header.UDPProtocolNumber, waiterQueue)
+	return raw.NewEndpoint(p.stack, netProto, p.number, waiterQueue)
 }
 
 // MinimumPacketSize returns the minimum valid udp packet size.
@@ -80,13 +86,15 @@ func (*protocol) ParsePorts(v buffer.View) (src, dst uint16, err *tcpip.Error) {
 // protocol but don't match any existing endpoint.
 func (p *protocol) HandleUnknownDestinationPacket(id stack.TransportEndpointID, pkt *stack.PacketBuffer) stack.UnknownDestinationPacketDisposition {
 	hdr := header.UDP(pkt.TransportHeader().View())
-	if int(hdr.Length()) > pkt.Data.Size()+header.UDPMinimumSize {
-		p.stack.Stats().UDP.MalformedPacketsReceived.Increment()
+	stats := protocolStats(p.stack.Stats(), p.number)
+	csCov, ok := checksumCoverage(p.number, hdr, pkt)
+	if !ok {
+		stats.MalformedPacketsReceived.Increment()
 		return stack.UnknownDestinationPacketMalformed
 	}
 
-	if !verifyChecksum(hdr, pkt) {
-		p.stack.Stats().UDP.ChecksumErrors.Increment()
+	if !verifyChecksum(p.number, hdr, pkt, csCov) {
+		stats.ChecksumErrors.Increment()
 		return stack.UnknownDestinationPacketMalformed
 	}
 
@@ -110,11 +118,21 @@ func (*protocol) Close() {}
 func (*protocol) Wait() {}
 
 // Parse implements stack.TransportProtocol.Parse.
-func (*protocol) Parse(pkt *stack.PacketBuffer) bool {
+func (p *protocol) Parse(pkt *stack.PacketBuffer) bool {
+	if p.number == LiteProtocolNumber {
+		return parse.UDPLite(pkt)
+	}
 	return parse.UDP(pkt)
 }
 
 // NewProtocol returns a UDP transport protocol.
 func NewProtocol(s *stack.Stack) stack.TransportProtocol {
-	return &protocol{stack: s}
+	return &protocol{stack: s, number: ProtocolNumber}
+}
+
+// NewLiteProtocol returns a UDP-Lite transport protocol, whose endpoints are
+// UDP endpoints able to send and receive segments whose checksum only covers
+// their beginning (RFC 3828).
+func NewLiteProtocol(s *stack.Stack) stack.TransportProtocol {
+	return &protocol{stack: s, number: LiteProtocolNumber}
 }
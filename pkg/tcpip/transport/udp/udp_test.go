@@ -579,6 +579,47 @@ func TestBindToDeviceOption(t *testing.T) {
 	}
 }
 
+func TestBoundToRemovedDevice(t *testing.T) {
+	const nicID = 321
+
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol}})
+	if err := s.CreateNIC(nicID, loopback.New()); err != nil {
+		t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+	}
+
+	var wq waiter.Queue
+	ep, err := s.NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+	if err != nil {
+		t.Fatalf("NewEndpoint failed; %s", err)
+	}
+	defer ep.Close()
+	bindToDevice := tcpip.BindToDeviceOption(nicID)
+	if err := ep.SetSockOpt(&bindToDevice); err != nil {
+		t.Fatalf("SetSockOpt(&%T(%d)): %s", bindToDevice, bindToDevice, err)
+	}
+	if err := ep.Bind(tcpip.FullAddress{Port: stackPort}); err != nil {
+		t.Fatalf("ep.Bind(_): %s", err)
+	}
+
+	we, ch := waiter.NewChannelEntry(nil)
+	wq.EventRegister(&we, waiter.EventErr)
+	defer wq.EventUnregister(&we)
+
+	if err := s.RemoveNIC(nicID); err != nil {
+		t.Fatalf("s.RemoveNIC(%d): %s", nicID, err)
+	}
+	select {
+	case <-ch:
+	default:
+		t.Fatal("endpoint not notified of the removal of its device")
+	}
+	if err := ep.LastError(); err != tcpip.ErrUnknownDevice {
+		t.Errorf("got ep.LastError() = %v, want = %s", err, tcpip.ErrUnknownDevice)
+	}
+}
+
 // testReadInternal sends a packet of the given test flow into the stack by
 // injecting it into the link endpoint. It then attempts to read it from the
 // UDP endpoint and depending on if this was expected to succeed verifies its
@@ -731,6 +772,48 @@ func TestBindReservedPort(t *testing.T) {
 	}()
 }
 
+func TestBindNonLocalAddress(t *testing.T) {
+	tests := []struct {
+		name         string
+		freeBind     bool
+		nonLocalBind bool
+		want         *tcpip.Error
+	}{
+		{name: "Default", want: tcpip.ErrBadLocalAddress},
+		{name: "IP_FREEBIND", freeBind: true},
+		{name: "ip_nonlocal_bind", nonLocalBind: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newDualTestContext(t, defaultMTU)
+			defer c.cleanup()
+
+			opt := tcpip.NonLocalBindOption(test.nonLocalBind)
+			if err := c.s.SetNetworkProtocolOption(ipv4.ProtocolNumber, &opt); err != nil {
+				t.Fatalf("SetNetworkProtocolOption(%d, &%T(%t)): %s", ipv4.ProtocolNumber, opt, opt, err)
+			}
+			c.createEndpoint(ipv4.ProtocolNumber)
+			c.ep.SocketOptions().SetFreeBind(test.freeBind)
+
+			// testAddr isn't assigned to the stack.
+			addr := tcpip.FullAddress{Addr: testAddr, Port: stackPort}
+			if got := c.ep.Bind(addr); got != test.want {
+				t.Fatalf("got ep.Bind(%#v) = %v, want = %v", addr, got, test.want)
+			}
+			if test.want != nil {
+				return
+			}
+			got, err := c.ep.GetLocalAddress()
+			if err != nil {
+				t.Fatalf("GetLocalAddress failed: %s", err)
+			}
+			if got.Addr != addr.Addr || got.Port != addr.Port {
+				t.Errorf("got GetLocalAddress() = %#v, want = %#v", got, addr)
+			}
+		})
+	}
+}
+
 func TestV4ReadOnV6(t *testing.T) {
 	c := newDualTestContext(t, defaultMTU)
 	defer c.cleanup()
@@ -885,6 +968,65 @@ func TestReadOnBoundToMulticast(t *testing.T) {
 	}
 }
 
+// TestReadOnSourceSpecificMulticast checks that an endpoint that joined a
+// multicast group with a source filter only receives data sent by the joined
+// sources.
+func TestReadOnSourceSpecificMulticast(t *testing.T) {
+	const otherAddr = tcpip.Address("\x0a\x00\x00\x03")
+
+	tests := []struct {
+		name       string
+		sourceAddr tcpip.Address
+		wantRead   bool
+	}{
+		{name: "joined source", sourceAddr: testAddr, wantRead: true},
+		{name: "other source", sourceAddr: otherAddr, wantRead: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newDualTestContext(t, defaultMTU)
+			defer c.cleanup()
+
+			c.createEndpointForFlow(multicastV4)
+
+			if err := c.ep.Bind(tcpip.FullAddress{Addr: multicastAddr, Port: stackPort}); err != nil {
+				c.t.Fatal("Bind failed:", err)
+			}
+
+			join := tcpip.AddSourceMembershipOption{NIC: 1, MulticastAddr: multicastAddr, SourceAddr: test.sourceAddr}
+			if err := c.ep.SetSockOpt(&join); err != nil {
+				c.t.Fatalf("SetSockOpt(&%#v): %s", join, err)
+			}
+			if err := c.ep.SetSockOpt(&join); err != tcpip.ErrPortInUse {
+				c.t.Errorf("got SetSockOpt(&%#v) = %s, want = %s", join, err, tcpip.ErrPortInUse)
+			}
+			anySource := tcpip.AddMembershipOption{NIC: 1, MulticastAddr: multicastAddr}
+			if err := c.ep.SetSockOpt(&anySource); err != tcpip.ErrPortInUse {
+				c.t.Errorf("got SetSockOpt(&%#v) = %s, want = %s", anySource, err, tcpip.ErrPortInUse)
+			}
+
+			if test.wantRead {
+				testRead(c, multicastV4)
+			} else {
+				testFailingRead(c, multicastV4, false /* expectReadError */)
+			}
+
+			// Leaving the source-specific membership removes the filter.
+			leave := tcpip.RemoveSourceMembershipOption{NIC: 1, MulticastAddr: multicastAddr, SourceAddr: test.sourceAddr}
+			if err := c.ep.SetSockOpt(&leave); err != nil {
+				c.t.Fatalf("SetSockOpt(&%#v): %s", leave, err)
+			}
+			if err := c.ep.SetSockOpt(&leave); err != tcpip.ErrBadLocalAddress {
+				c.t.Errorf("got SetSockOpt(&%#v) = %s, want = %s", leave, err, tcpip.ErrBadLocalAddress)
+			}
+			if got, err := c.s.IsInGroup(1, multicastAddr); err != nil || got {
+				c.t.Errorf("got c.s.IsInGroup(1, %s) = (%t, %s), want = (false, nil)", multicastAddr, got, err)
+			}
+		})
+	}
+}
+
 // TestV4ReadOnBoundToBroadcast checks that an endpoint can bind to a broadcast
 // address and can receive only broadcast data.
 func TestV4ReadOnBoundToBroadcast(t *testing.T) {
@@ -1441,6 +1583,91 @@ func TestWriteIncrementsPacketsSent(t *testing.T) {
 	}
 }
 
+func TestReadBatch(t *testing.T) {
+	c := newDualTestContext(t, defaultMTU)
+	defer c.cleanup()
+
+	c.createEndpointForFlow(unicastV6)
+
+	// Bind to wildcard.
+	if err := c.ep.Bind(tcpip.FullAddress{Port: stackPort}); err != nil {
+		c.t.Fatalf("Bind failed: %s", err)
+	}
+
+	var payloads [][]byte
+	for i := 0; i < 3; i++ {
+		payload := newPayload()
+		payloads = append(payloads, payload)
+		c.injectPacket(unicastV6, payload, false)
+	}
+
+	ep := c.ep.(tcpip.BatchEndpoint)
+	h := unicastV6.header4Tuple(incoming)
+	msgs := make([]tcpip.ReadMessage, 2)
+	for _, want := range [][][]byte{payloads[:2], payloads[2:]} {
+		n, err := ep.ReadBatch(msgs)
+		if err != nil {
+			t.Fatalf("ReadBatch failed: %s", err)
+		}
+		if n != len(want) {
+			t.Fatalf("got ReadBatch(_) = %d, want = %d", n, len(want))
+		}
+		for i, m := range msgs[:n] {
+			if m.Addr.Addr != h.srcAddr.Addr {
+				t.Errorf("got msgs[%d].Addr.Addr = %s, want = %s", i, m.Addr.Addr, h.srcAddr.Addr)
+			}
+			if !bytes.Equal(m.Data, want[i]) {
+				t.Errorf("got msgs[%d].Data = %x, want = %x", i, m.Data, want[i])
+			}
+		}
+	}
+
+	if _, err := ep.ReadBatch(msgs); err != tcpip.ErrWouldBlock {
+		t.Fatalf("got ReadBatch(_) = %s, want = %s", err, tcpip.ErrWouldBlock)
+	}
+}
+
+func TestWriteBatch(t *testing.T) {
+	c := newDualTestContext(t, defaultMTU)
+	defer c.cleanup()
+
+	c.createEndpointForFlow(unicastV6)
+
+	h := unicastV6.header4Tuple(outgoing)
+	var payloads []buffer.View
+	var msgs []tcpip.WriteMessage
+	for i := 0; i < 3; i++ {
+		payload := buffer.View(newPayload())
+		payloads = append(payloads, payload)
+		msgs = append(msgs, tcpip.WriteMessage{
+			Payload: tcpip.SlicePayload(payload),
+			Options: tcpip.WriteOptions{
+				To: &tcpip.FullAddress{Addr: h.dstAddr.Addr, Port: h.dstAddr.Port},
+			},
+		})
+	}
+
+	n, _, err := c.ep.(tcpip.BatchEndpoint).WriteBatch(msgs)
+	if err != nil {
+		t.Fatalf("WriteBatch failed: %s", err)
+	}
+	if n != len(msgs) {
+		t.Fatalf("got WriteBatch(_) = %d, want = %d", n, len(msgs))
+	}
+
+	for i, payload := range payloads {
+		b := c.getPacketAndVerify(unicastV6)
+		udp := header.UDP(header.IPv6(b).Payload())
+		if !bytes.Equal(payload, udp.Payload()) {
+			t.Errorf("got payload of datagram %d = %x, want = %x", i, udp.Payload(), payload)
+		}
+	}
+
+	if got, want := c.ep.Stats().(*tcpip.TransportEndpointStats).PacketsSent.Value(), uint64(len(msgs)); got != want {
+		t.Errorf("got PacketsSent = %d, want = %d", got, want)
+	}
+}
+
 func TestNoChecksum(t *testing.T) {
 	for _, flow := range []testFlow{unicastV4, unicastV6} {
 		t.Run(fmt.Sprintf("flow:%s", flow), func(t *testing.T) {
@@ -1690,6 +1917,82 @@ func TestReceiveTosTClass(t *testing.T) {
 	}
 }
 
+func TestReceiveFlowInfo(t *testing.T) {
+	tests := []struct {
+		flow    testFlow
+		checker checker.ControlMessagesChecker
+	}{
+		{flow: unicastV6, checker: checker.ReceiveFlowInfo(testTOS << 20)},
+		{flow: unicastV6Only, checker: checker.ReceiveFlowInfo(testTOS << 20)},
+		{flow: unicastV4in6, checker: func(t *testing.T, cm tcpip.ControlMessages) {
+			t.Helper()
+			if cm.HasFlowInfo {
+				t.Errorf("got cm.HasFlowInfo = %t, want = false", cm.HasFlowInfo)
+			}
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("flow:%s", test.flow), func(t *testing.T) {
+			c := newDualTestContext(t, defaultMTU)
+			defer c.cleanup()
+
+			c.createEndpointForFlow(test.flow)
+			c.ep.SocketOptions().SetReceiveFlowInfo(true)
+			if err := c.ep.Bind(tcpip.FullAddress{Port: stackPort}); err != nil {
+				c.t.Fatalf("Bind failed: %s", err)
+			}
+			testRead(c, test.flow, test.checker)
+		})
+	}
+}
+
+func TestSendFlowInfo(t *testing.T) {
+	const flowLabel = 0x12345
+
+	c := newDualTestContext(t, defaultMTU)
+	defer c.cleanup()
+
+	c.createEndpointForFlow(unicastV6)
+	h := unicastV6.header4Tuple(outgoing)
+	to := tcpip.FullAddress{Addr: h.dstAddr.Addr, Port: h.dstAddr.Port, FlowLabel: flowLabel}
+	write := func() *tcpip.Error {
+		_, _, err := c.ep.Write(tcpip.SlicePayload(newPayload()), tcpip.WriteOptions{To: &to})
+		return err
+	}
+
+	// The flow label of the destination is ignored unless IPV6_FLOWINFO_SEND
+	// is enabled.
+	if err := write(); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	c.getPacketAndVerify(unicastV6, checker.TOS(0, 0))
+
+	// The flow label must be leased before it is used.
+	c.ep.SocketOptions().SetSendFlowInfo(true)
+	if err := write(); err != tcpip.ErrInvalidOptionValue {
+		t.Fatalf("got Write(_, _) = %s, want = %s", err, tcpip.ErrInvalidOptionValue)
+	}
+	if err := c.ep.SetSockOpt(&tcpip.FlowLabelManagerOption{
+		Label:  flowLabel,
+		Action: tcpip.FlowLabelLease,
+		Share:  tcpip.FlowLabelShareExclusive,
+		Create: true,
+	}); err != nil {
+		t.Fatalf("SetSockOpt(&FlowLabelManagerOption{...}) failed: %s", err)
+	}
+	if err := write(); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	c.getPacketAndVerify(unicastV6, checker.TOS(0, flowLabel))
+
+	// Connected endpoints use the flow label of their peer.
+	if err := c.ep.Connect(to); err != nil {
+		t.Fatalf("Connect failed: %s", err)
+	}
+	testWriteWithoutDestination(c, unicastV6, checker.TOS(0, flowLabel))
+}
+
 func TestMulticastInterfaceOption(t *testing.T) {
 	for _, flow := range []testFlow{multicastV4, multicastV4in6, multicastV6, multicastV6Only} {
 		t.Run(fmt.Sprintf("flow:%s", flow), func(t *testing.T) {
@@ -2448,3 +2751,696 @@ func TestOutgoingSubnetBroadcast(t *testing.T) {
 		})
 	}
 }
+
+func TestPathMTU(t *testing.T) {
+	const mtu = 1500
+
+	c := newDualTestContext(t, mtu)
+	defer c.cleanup()
+
+	c.createEndpoint(ipv4.ProtocolNumber)
+	if _, err := c.ep.GetSockOptInt(tcpip.PathMTUOption); err != tcpip.ErrNotConnected {
+		t.Fatalf("got GetSockOptInt(tcpip.PathMTUOption) = (_, %v), want = (_, %s)", err, tcpip.ErrNotConnected)
+	}
+	var info tcpip.PLPMTUDInfo
+	if err := c.ep.GetSockOpt(&info); err != tcpip.ErrNotConnected {
+		t.Fatalf("got GetSockOpt(&tcpip.PLPMTUDInfo{}) = %v, want = %s", err, tcpip.ErrNotConnected)
+	}
+
+	if err := c.ep.Connect(tcpip.FullAddress{Addr: testAddr, Port: testPort}); err != nil {
+		t.Fatalf("Connect failed: %s", err)
+	}
+	local, err := c.ep.GetLocalAddress()
+	if err != nil {
+		t.Fatalf("GetLocalAddress failed: %s", err)
+	}
+
+	checkPathMTU := func(want int) {
+		t.Helper()
+
+		if v, err := c.ep.GetSockOptInt(tcpip.PathMTUOption); err != nil {
+			t.Fatalf("GetSockOptInt(tcpip.PathMTUOption) failed: %s", err)
+		} else if v != want {
+			t.Errorf("got GetSockOptInt(tcpip.PathMTUOption) = %d, want = %d", v, want)
+		}
+	}
+	checkInfo := func(want tcpip.PLPMTUDInfo) {
+		t.Helper()
+
+		var got tcpip.PLPMTUDInfo
+		if err := c.ep.GetSockOpt(&got); err != nil {
+			t.Fatalf("GetSockOpt(&tcpip.PLPMTUDInfo{}) failed: %s", err)
+		}
+		if got != want {
+			t.Errorf("got GetSockOpt(&tcpip.PLPMTUDInfo{}) = %#v, want = %#v", got, want)
+		}
+	}
+	write := func(size int, probe bool) *tcpip.Error {
+		t.Helper()
+
+		payload := make([]byte, size-header.IPv4MinimumSize-header.UDPMinimumSize)
+		_, _, err := c.ep.Write(tcpip.SlicePayload(payload), tcpip.WriteOptions{PathMTUProbe: probe})
+		return err
+	}
+
+	checkPathMTU(mtu)
+
+	// Results can only be reported once the search started.
+	if err := c.ep.SetSockOpt(&tcpip.PLPMTUDProbeResultOption{Size: 1200, Acked: true}); err != tcpip.ErrInvalidEndpointState {
+		t.Fatalf("got SetSockOpt(&tcpip.PLPMTUDProbeResultOption{...}) = %v, want = %s", err, tcpip.ErrInvalidEndpointState)
+	}
+	checkInfo(tcpip.PLPMTUDInfo{State: tcpip.PLPMTUDBase, PLPMTU: 1200, ProbeSize: 1200})
+	if err := c.ep.SetSockOpt(&tcpip.PLPMTUDProbeResultOption{Size: 1200, Acked: true}); err != nil {
+		t.Fatalf("SetSockOpt(&tcpip.PLPMTUDProbeResultOption{...}) failed: %s", err)
+	}
+	checkInfo(tcpip.PLPMTUDInfo{State: tcpip.PLPMTUDSearching, PLPMTU: 1200, ProbeSize: mtu})
+
+	// Receive a Fragmentation Needed message for a previously sent packet.
+	const pathMTU = 1400
+	buf := buffer.NewView(header.IPv4MinimumSize + header.ICMPv4MinimumSize + header.IPv4MinimumSize + header.UDPMinimumSize)
+	ip := header.IPv4(buf)
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(buf)),
+		TTL:         65,
+		Protocol:    uint8(header.ICMPv4ProtocolNumber),
+		SrcAddr:     testAddr,
+		DstAddr:     stackAddr,
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+	icmp := header.ICMPv4(ip.Payload())
+	icmp.SetType(header.ICMPv4DstUnreachable)
+	icmp.SetCode(header.ICMPv4FragmentationNeeded)
+	icmp.SetMTU(pathMTU)
+	orig := header.IPv4(icmp.Payload())
+	orig.Encode(&header.IPv4Fields{
+		TotalLength: mtu,
+		TTL:         64,
+		Protocol:    uint8(udp.ProtocolNumber),
+		SrcAddr:     stackAddr,
+		DstAddr:     testAddr,
+	})
+	orig.SetChecksum(^orig.CalculateChecksum())
+	header.UDP(orig[header.IPv4MinimumSize:]).Encode(&header.UDPFields{
+		SrcPort: local.Port,
+		DstPort: testPort,
+		Length:  mtu - header.IPv4MinimumSize,
+	})
+	icmp.SetChecksum(^header.Checksum(icmp, 0))
+	c.linkEP.InjectInbound(ipv4.ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: buf.ToVectorisedView(),
+	}))
+
+	checkPathMTU(pathMTU)
+	if got, ok := c.s.PathMTU(ipv4.ProtocolNumber, testAddr); !ok || got != pathMTU {
+		t.Errorf("got c.s.PathMTU(%d, %s) = (%d, %t), want = (%d, true)", ipv4.ProtocolNumber, testAddr, got, ok, pathMTU)
+	}
+	// The reported MTU is probed next.
+	checkInfo(tcpip.PLPMTUDInfo{State: tcpip.PLPMTUDSearching, PLPMTU: 1200, ProbeSize: pathMTU})
+
+	// Packets larger than the path MTU are fragmented.
+	if err := write(mtu, false /* probe */); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	for _, flags := range []uint8{header.IPv4FlagMoreFragments, 0} {
+		p, ok := c.linkEP.Read()
+		if !ok {
+			t.Fatal("Packet wasn't written out")
+		}
+		vv := buffer.NewVectorisedView(p.Pkt.Size(), p.Pkt.Views())
+		b := vv.ToView()
+		checker.IPv4(t, b, checker.FragmentFlags(flags))
+		if len(b) > pathMTU {
+			t.Errorf("got fragment of %d bytes, want at most %d bytes", len(b), pathMTU)
+		}
+	}
+
+	// Probes are sent unfragmented with the DF bit set, up to the link MTU.
+	if err := write(mtu, true /* probe */); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	c.getPacketAndVerify(unicastV4, checker.IPFullLength(mtu), checker.FragmentFlags(header.IPv4FlagDontFragment))
+	if err := write(mtu+1, true /* probe */); err != tcpip.ErrMessageTooLong {
+		t.Fatalf("got Write(...) = %v, want = %s", err, tcpip.ErrMessageTooLong)
+	}
+}
+
+func TestMTUDiscover(t *testing.T) {
+	const (
+		mtu     = 1500
+		pathMTU = 1400
+	)
+
+	type write struct {
+		// size is the size of the IPv4 packet carrying the datagram.
+		size      int
+		wantErr   *tcpip.Error
+		wantFlags []uint8
+	}
+	tests := []struct {
+		name    string
+		setting int
+		writes  []write
+	}{
+		{
+			name:    "Dont",
+			setting: tcpip.PMTUDiscoveryDont,
+			writes: []write{
+				{size: 1000, wantFlags: []uint8{0}},
+				{size: 1450, wantFlags: []uint8{header.IPv4FlagMoreFragments, 0}},
+			},
+		},
+		{
+			name:    "Want",
+			setting: tcpip.PMTUDiscoveryWant,
+			writes: []write{
+				{size: 1000, wantFlags: []uint8{header.IPv4FlagDontFragment}},
+				{size: 1450, wantFlags: []uint8{header.IPv4FlagMoreFragments, 0}},
+			},
+		},
+		{
+			name:    "Do",
+			setting: tcpip.PMTUDiscoveryDo,
+			writes: []write{
+				{size: 1000, wantFlags: []uint8{header.IPv4FlagDontFragment}},
+				{size: 1450, wantErr: tcpip.ErrMessageTooLong},
+			},
+		},
+		{
+			name:    "Probe",
+			setting: tcpip.PMTUDiscoveryProbe,
+			writes: []write{
+				{size: 1450, wantFlags: []uint8{header.IPv4FlagDontFragment}},
+				{size: 1600, wantErr: tcpip.ErrMessageTooLong},
+			},
+		},
+		{
+			name:    "Interface",
+			setting: tcpip.PMTUDiscoveryInterface,
+			writes: []write{
+				{size: 1450, wantFlags: []uint8{0}},
+				{size: 1600, wantErr: tcpip.ErrMessageTooLong},
+			},
+		},
+		{
+			name:    "Omit",
+			setting: tcpip.PMTUDiscoveryOmit,
+			writes: []write{
+				{size: 1450, wantFlags: []uint8{0}},
+				{size: 1600, wantFlags: []uint8{header.IPv4FlagMoreFragments, 0}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newDualTestContext(t, mtu)
+			defer c.cleanup()
+
+			c.createEndpoint(ipv4.ProtocolNumber)
+			if v, err := c.ep.GetSockOptInt(tcpip.MTUDiscoverOption); err != nil {
+				t.Fatalf("GetSockOptInt(tcpip.MTUDiscoverOption) failed: %s", err)
+			} else if v != tcpip.PMTUDiscoveryDont {
+				t.Errorf("got GetSockOptInt(tcpip.MTUDiscoverOption) = %d, want = %d", v, tcpip.PMTUDiscoveryDont)
+			}
+			if err := c.ep.SetSockOptInt(tcpip.MTUDiscoverOption, test.setting); err != nil {
+				t.Fatalf("SetSockOptInt(tcpip.MTUDiscoverOption, %d) failed: %s", test.setting, err)
+			}
+			if v, err := c.ep.GetSockOptInt(tcpip.MTUDiscoverOption); err != nil {
+				t.Fatalf("GetSockOptInt(tcpip.MTUDiscoverOption) failed: %s", err)
+			} else if v != test.setting {
+				t.Errorf("got GetSockOptInt(tcpip.MTUDiscoverOption) = %d, want = %d", v, test.setting)
+			}
+			if err := c.ep.Connect(tcpip.FullAddress{Addr: testAddr, Port: testPort}); err != nil {
+				t.Fatalf("Connect failed: %s", err)
+			}
+			c.s.UpdatePathMTU(ipv4.ProtocolNumber, testAddr, pathMTU)
+
+			for _, w := range test.writes {
+				payload := make([]byte, w.size-header.IPv4MinimumSize-header.UDPMinimumSize)
+				if _, _, err := c.ep.Write(tcpip.SlicePayload(payload), tcpip.WriteOptions{}); err != w.wantErr {
+					t.Fatalf("got Write(<%d byte datagram>, _) = (_, _, %v), want = (_, _, %v)", len(payload), err, w.wantErr)
+				}
+				for _, flags := range w.wantFlags {
+					p, ok := c.linkEP.Read()
+					if !ok {
+						t.Fatalf("packet wasn't written out for %d byte write", w.size)
+					}
+					vv := buffer.NewVectorisedView(p.Pkt.Size(), p.Pkt.Views())
+					checker.IPv4(t, vv.ToView(), checker.FragmentFlags(flags))
+				}
+				if p, ok := c.linkEP.Read(); ok {
+					t.Errorf("got unexpected packet = %#v for %d byte write", p, w.size)
+				}
+			}
+		})
+	}
+}
+
+func TestMTUDiscoverLockedRoute(t *testing.T) {
+	const routeMTU = 1400
+
+	c := newDualTestContext(t, defaultMTU)
+	defer c.cleanup()
+
+	c.s.SetRouteTable([]tcpip.Route{{
+		Destination: header.IPv4EmptySubnet,
+		NIC:         1,
+		MTU:         routeMTU,
+		MTULocked:   true,
+	}})
+
+	c.createEndpoint(ipv4.ProtocolNumber)
+	if err := c.ep.SetSockOptInt(tcpip.MTUDiscoverOption, tcpip.PMTUDiscoveryWant); err != nil {
+		t.Fatalf("SetSockOptInt(tcpip.MTUDiscoverOption, %d) failed: %s", tcpip.PMTUDiscoveryWant, err)
+	}
+	if err := c.ep.SetSockOptInt(tcpip.MTUDiscoverOption, tcpip.PMTUDiscoveryOmit+1); err != tcpip.ErrInvalidOptionValue {
+		t.Fatalf("got SetSockOptInt(tcpip.MTUDiscoverOption, %d) = %v, want = %s", tcpip.PMTUDiscoveryOmit+1, err, tcpip.ErrInvalidOptionValue)
+	}
+	if err := c.ep.Connect(tcpip.FullAddress{Addr: testAddr, Port: testPort}); err != nil {
+		t.Fatalf("Connect failed: %s", err)
+	}
+
+	// The path MTU can't be lowered below the locked route MTU.
+	c.s.UpdatePathMTU(ipv4.ProtocolNumber, testAddr, routeMTU-100)
+	if v, err := c.ep.GetSockOptInt(tcpip.PathMTUOption); err != nil {
+		t.Fatalf("GetSockOptInt(tcpip.PathMTUOption) failed: %s", err)
+	} else if v != routeMTU {
+		t.Errorf("got GetSockOptInt(tcpip.PathMTUOption) = %d, want = %d", v, routeMTU)
+	}
+
+	// Packets sent through a route with a locked MTU don't have the DF bit set,
+	// and are fragmented to fit the route MTU.
+	for _, size := range []int{routeMTU, routeMTU + 1} {
+		payload := make([]byte, size-header.IPv4MinimumSize-header.UDPMinimumSize)
+		if _, _, err := c.ep.Write(tcpip.SlicePayload(payload), tcpip.WriteOptions{}); err != nil {
+			t.Fatalf("Write(<%d byte datagram>, _) failed: %s", len(payload), err)
+		}
+		wantFlags := []uint8{0}
+		if size > routeMTU {
+			wantFlags = []uint8{header.IPv4FlagMoreFragments, 0}
+		}
+		for _, flags := range wantFlags {
+			p, ok := c.linkEP.Read()
+			if !ok {
+				t.Fatalf("packet wasn't written out for %d byte write", size)
+			}
+			vv := buffer.NewVectorisedView(p.Pkt.Size(), p.Pkt.Views())
+			b := vv.ToView()
+			checker.IPv4(t, b, checker.FragmentFlags(flags))
+			if len(b) > routeMTU {
+				t.Errorf("got packet of %d bytes, want at most %d bytes", len(b), routeMTU)
+			}
+		}
+	}
+}
+
+func TestRecvError(t *testing.T) {
+	const mtu = 1500
+
+	c := newDualTestContext(t, mtu)
+	defer c.cleanup()
+
+	c.createEndpoint(ipv4.ProtocolNumber)
+	if err := c.ep.Connect(tcpip.FullAddress{Addr: testAddr, Port: testPort}); err != nil {
+		t.Fatalf("Connect failed: %s", err)
+	}
+	local, err := c.ep.GetLocalAddress()
+	if err != nil {
+		t.Fatalf("GetLocalAddress failed: %s", err)
+	}
+
+	checkSockErr := func(got *tcpip.SockError, want tcpip.SockError) {
+		t.Helper()
+
+		if got == nil {
+			t.Fatal("got DequeueErr() = nil, want non-nil error")
+		}
+		if got.Err != want.Err {
+			t.Errorf("got sockErr.Err = %s, want = %s", got.Err, want.Err)
+		}
+		if got.ErrOrigin != want.ErrOrigin || got.ErrType != want.ErrType || got.ErrCode != want.ErrCode || got.ErrInfo != want.ErrInfo {
+			t.Errorf("got (origin, type, code, info) = (%d, %d, %d, %d), want = (%d, %d, %d, %d)", got.ErrOrigin, got.ErrType, got.ErrCode, got.ErrInfo, want.ErrOrigin, want.ErrType, want.ErrCode, want.ErrInfo)
+		}
+		if !bytes.Equal(got.Payload, want.Payload) {
+			t.Errorf("got sockErr.Payload = %x, want = %x", got.Payload, want.Payload)
+		}
+		if got.Dst != want.Dst {
+			t.Errorf("got sockErr.Dst = %#v, want = %#v", got.Dst, want.Dst)
+		}
+		if got.Offender != want.Offender {
+			t.Errorf("got sockErr.Offender = %#v, want = %#v", got.Offender, want.Offender)
+		}
+		if got.NetProto != want.NetProto {
+			t.Errorf("got sockErr.NetProto = %d, want = %d", got.NetProto, want.NetProto)
+		}
+	}
+
+	// injectPortUnreachable injects a Port Unreachable message for a datagram
+	// the endpoint sent with the given payload.
+	payload := []byte("hello")
+	injectPortUnreachable := func() {
+		t.Helper()
+
+		buf := buffer.NewView(header.IPv4MinimumSize + header.ICMPv4MinimumSize + header.IPv4MinimumSize + header.UDPMinimumSize + len(payload))
+		ip := header.IPv4(buf)
+		ip.Encode(&header.IPv4Fields{
+			TotalLength: uint16(len(buf)),
+			TTL:         65,
+			Protocol:    uint8(header.ICMPv4ProtocolNumber),
+			SrcAddr:     testAddr,
+			DstAddr:     stackAddr,
+		})
+		ip.SetChecksum(^ip.CalculateChecksum())
+		icmp := header.ICMPv4(ip.Payload())
+		icmp.SetType(header.ICMPv4DstUnreachable)
+		icmp.SetCode(header.ICMPv4PortUnreachable)
+		orig := header.IPv4(icmp.Payload())
+		orig.Encode(&header.IPv4Fields{
+			TotalLength: uint16(len(orig)),
+			TTL:         64,
+			Protocol:    uint8(udp.ProtocolNumber),
+			SrcAddr:     stackAddr,
+			DstAddr:     testAddr,
+		})
+		orig.SetChecksum(^orig.CalculateChecksum())
+		u := header.UDP(orig[header.IPv4MinimumSize:])
+		u.Encode(&header.UDPFields{
+			SrcPort: local.Port,
+			DstPort: testPort,
+			Length:  uint16(header.UDPMinimumSize + len(payload)),
+		})
+		copy(u.Payload(), payload)
+		icmp.SetChecksum(^header.Checksum(icmp, 0))
+		c.linkEP.InjectInbound(ipv4.ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Data: buf.ToVectorisedView(),
+		}))
+	}
+
+	// Errors are not queued unless IP_RECVERR is enabled.
+	injectPortUnreachable()
+	if sockErr := c.ep.SocketOptions().PeekErr(); sockErr != nil {
+		t.Fatalf("got PeekErr() = %#v, want = nil", sockErr)
+	}
+	if err := c.ep.LastError(); err != tcpip.ErrConnectionRefused {
+		t.Fatalf("got LastError() = %v, want = %s", err, tcpip.ErrConnectionRefused)
+	}
+
+	c.ep.SocketOptions().SetRecvError(true)
+	injectPortUnreachable()
+	if got := c.ep.Readiness(waiter.EventErr); got != waiter.EventErr {
+		t.Errorf("got Readiness(waiter.EventErr) = %b, want = %b", got, waiter.EventErr)
+	}
+	if err := c.ep.LastError(); err != tcpip.ErrConnectionRefused {
+		t.Fatalf("got LastError() = %v, want = %s", err, tcpip.ErrConnectionRefused)
+	}
+	wantPayload := make([]byte, header.UDPMinimumSize+len(payload))
+	header.UDP(wantPayload).Encode(&header.UDPFields{
+		SrcPort: local.Port,
+		DstPort: testPort,
+		Length:  uint16(len(wantPayload)),
+	})
+	copy(wantPayload[header.UDPMinimumSize:], payload)
+	checkSockErr(c.ep.SocketOptions().DequeueErr(), tcpip.SockError{
+		Err:       tcpip.ErrConnectionRefused,
+		ErrOrigin: tcpip.SockExtErrorOriginICMP,
+		ErrType:   uint8(header.ICMPv4DstUnreachable),
+		ErrCode:   uint8(header.ICMPv4PortUnreachable),
+		Payload:   wantPayload,
+		Dst:       tcpip.FullAddress{Addr: testAddr, Port: testPort},
+		Offender:  tcpip.FullAddress{Addr: testAddr},
+		NetProto:  ipv4.ProtocolNumber,
+	})
+	if sockErr := c.ep.SocketOptions().DequeueErr(); sockErr != nil {
+		t.Fatalf("got DequeueErr() = %#v, want = nil", sockErr)
+	}
+	if got := c.ep.Readiness(waiter.EventErr); got != 0 {
+		t.Errorf("got Readiness(waiter.EventErr) = %b, want = 0", got)
+	}
+
+	// Datagrams that can't be sent without fragmentation generate a local
+	// error carrying the MTU.
+	if err := c.ep.SetSockOptInt(tcpip.MTUDiscoverOption, tcpip.PMTUDiscoveryDo); err != nil {
+		t.Fatalf("SetSockOptInt(tcpip.MTUDiscoverOption, %d) failed: %s", tcpip.PMTUDiscoveryDo, err)
+	}
+	big := make([]byte, mtu)
+	if _, _, err := c.ep.Write(tcpip.SlicePayload(big), tcpip.WriteOptions{}); err != tcpip.ErrMessageTooLong {
+		t.Fatalf("got Write(<%d byte datagram>, _) = %v, want = %s", len(big), err, tcpip.ErrMessageTooLong)
+	}
+	checkSockErr(c.ep.SocketOptions().DequeueErr(), tcpip.SockError{
+		Err:       tcpip.ErrMessageTooLong,
+		ErrOrigin: tcpip.SockExtErrorOriginLocal,
+		ErrInfo:   mtu,
+		Dst:       tcpip.FullAddress{Addr: testAddr, Port: testPort},
+		NetProto:  ipv4.ProtocolNumber,
+	})
+
+	// Disabling IP_RECVERR discards queued errors.
+	injectPortUnreachable()
+	if sockErr := c.ep.SocketOptions().PeekErr(); sockErr == nil {
+		t.Fatal("got PeekErr() = nil, want non-nil error")
+	}
+	c.ep.SocketOptions().SetRecvError(false)
+	if sockErr := c.ep.SocketOptions().PeekErr(); sockErr != nil {
+		t.Fatalf("got PeekErr() = %#v, want = nil", sockErr)
+	}
+}
+
+// zeroCopyPayload implements tcpip.ZeroCopyPayloader for tests.
+type zeroCopyPayload struct {
+	tcpip.SlicePayload
+	zc       *tcpip.ZeroCopySend
+	borrowed bool
+}
+
+func (p *zeroCopyPayload) BorrowFullPayload() ([]byte, *tcpip.Error) {
+	p.borrowed = true
+	return p.SlicePayload.FullPayload()
+}
+
+func (p *zeroCopyPayload) BorrowPayload(size int) ([]byte, *tcpip.Error) {
+	p.borrowed = true
+	return p.SlicePayload.Payload(size)
+}
+
+func (p *zeroCopyPayload) ZeroCopySend() *tcpip.ZeroCopySend {
+	return p.zc
+}
+
+func TestZeroCopySend(t *testing.T) {
+	c := newDualTestContext(t, defaultMTU)
+	defer c.cleanup()
+
+	c.createEndpoint(ipv4.ProtocolNumber)
+	if err := c.ep.Connect(tcpip.FullAddress{Addr: testAddr, Port: testPort}); err != nil {
+		t.Fatalf("Connect failed: %s", err)
+	}
+
+	send := func(wantBorrowed bool) {
+		t.Helper()
+
+		released := false
+		p := &zeroCopyPayload{SlicePayload: []byte("hello")}
+		p.zc = c.ep.SocketOptions().NewZeroCopySend(ipv4.ProtocolNumber, func() { released = true })
+		if _, _, err := c.ep.Write(p, tcpip.WriteOptions{}); err != nil {
+			t.Fatalf("Write failed: %s", err)
+		}
+		if p.borrowed != wantBorrowed {
+			t.Errorf("got borrowed = %t, want = %t", p.borrowed, wantBorrowed)
+		}
+		if c.linkEP.Drain() != 1 {
+			t.Fatal("expected a datagram to be sent")
+		}
+		if !p.zc.DecRef() {
+			t.Error("got DecRef() = false, want = true")
+		}
+		if !released {
+			t.Error("payload was not released")
+		}
+	}
+
+	checkNotification := func(code uint8, first, last uint32) {
+		t.Helper()
+
+		got := c.ep.SocketOptions().DequeueErr()
+		if got == nil {
+			t.Fatal("got DequeueErr() = nil, want non-nil notification")
+		}
+		if got.Err != nil || got.ErrOrigin != tcpip.SockExtErrorOriginZeroCopy || got.NetProto != ipv4.ProtocolNumber {
+			t.Errorf("got (err, origin, netProto) = (%v, %d, %d), want = (nil, %d, %d)", got.Err, got.ErrOrigin, got.NetProto, tcpip.SockExtErrorOriginZeroCopy, ipv4.ProtocolNumber)
+		}
+		if got.ErrCode != code || got.ErrInfo != first || got.ErrData != last {
+			t.Errorf("got (code, info, data) = (%d, %d, %d), want = (%d, %d, %d)", got.ErrCode, got.ErrInfo, got.ErrData, code, first, last)
+		}
+	}
+
+	// The payload is copied when the link endpoint may hold onto it.
+	send(false /* wantBorrowed */)
+
+	// Completions of consecutive sends are coalesced.
+	c.linkEP.LinkEPCapabilities |= stack.CapabilityZeroCopyTX
+	send(true /* wantBorrowed */)
+	send(true /* wantBorrowed */)
+
+	// Notifications are queued even if IP_RECVERR is disabled.
+	if got := c.ep.Readiness(waiter.EventErr); got != waiter.EventErr {
+		t.Errorf("got Readiness(waiter.EventErr) = %b, want = %b", got, waiter.EventErr)
+	}
+	checkNotification(tcpip.SockExtErrorCodeZeroCopyCopied, 0, 0)
+	checkNotification(0, 1, 2)
+	if sockErr := c.ep.SocketOptions().DequeueErr(); sockErr != nil {
+		t.Fatalf("got DequeueErr() = %#v, want = nil", sockErr)
+	}
+
+	// Failed sends don't generate notifications.
+	p := &zeroCopyPayload{SlicePayload: make([]byte, header.UDPMaximumPacketSize+1)}
+	p.zc = c.ep.SocketOptions().NewZeroCopySend(ipv4.ProtocolNumber, func() {})
+	if _, _, err := c.ep.Write(p, tcpip.WriteOptions{}); err != tcpip.ErrMessageTooLong {
+		t.Fatalf("got Write(<%d byte datagram>, _) = %v, want = %s", len(p.SlicePayload), err, tcpip.ErrMessageTooLong)
+	}
+	if p.zc.DecRef() {
+		t.Error("got DecRef() = true, want = false")
+	}
+}
+
+func TestTxTimestamps(t *testing.T) {
+	c := newDualTestContext(t, defaultMTU)
+	defer c.cleanup()
+
+	c.createEndpoint(ipv4.ProtocolNumber)
+	if err := c.ep.Connect(tcpip.FullAddress{Addr: testAddr, Port: testPort}); err != nil {
+		t.Fatalf("Connect failed: %s", err)
+	}
+
+	write := func(payload string) {
+		t.Helper()
+
+		if _, _, err := c.ep.Write(tcpip.SlicePayload(payload), tcpip.WriteOptions{}); err != nil {
+			t.Fatalf("Write failed: %s", err)
+		}
+		if c.linkEP.Drain() != 1 {
+			t.Fatal("expected a datagram to be sent")
+		}
+	}
+
+	checkTimestamp := func(typ tcpip.TimestampType, key uint32, payload string) {
+		t.Helper()
+
+		got := c.ep.SocketOptions().DequeueErr()
+		if got == nil {
+			t.Fatal("got DequeueErr() = nil, want non-nil timestamp")
+		}
+		if got.Err != nil || got.ErrOrigin != tcpip.SockExtErrorOriginTimestamping || got.NetProto != ipv4.ProtocolNumber {
+			t.Errorf("got (err, origin, netProto) = (%v, %d, %d), want = (nil, %d, %d)", got.Err, got.ErrOrigin, got.NetProto, tcpip.SockExtErrorOriginTimestamping, ipv4.ProtocolNumber)
+		}
+		if got.ErrInfo != uint32(typ) || got.ErrData != key || string(got.Payload) != payload {
+			t.Errorf("got (info, data, payload) = (%d, %d, %q), want = (%d, %d, %q)", got.ErrInfo, got.ErrData, got.Payload, typ, key, payload)
+		}
+		if got.Timestamp == 0 {
+			t.Error("got Timestamp = 0, want non-zero")
+		}
+	}
+
+	// No timestamps are reported unless requested.
+	write("hello")
+	if sockErr := c.ep.SocketOptions().DequeueErr(); sockErr != nil {
+		t.Fatalf("got DequeueErr() = %#v, want = nil", sockErr)
+	}
+
+	c.ep.SocketOptions().SetTimestamping(tcpip.TimestampingTxSched | tcpip.TimestampingTxSoftware | tcpip.TimestampingTxAck | tcpip.TimestampingOptID)
+	write("hello")
+	write("world")
+
+	// Timestamps are queued even if IP_RECVERR is disabled, and UDP doesn't
+	// report acknowledgements.
+	if got := c.ep.Readiness(waiter.EventErr); got != waiter.EventErr {
+		t.Errorf("got Readiness(waiter.EventErr) = %b, want = %b", got, waiter.EventErr)
+	}
+	checkTimestamp(tcpip.TimestampSched, 0, "hello")
+	checkTimestamp(tcpip.TimestampSnd, 0, "hello")
+	checkTimestamp(tcpip.TimestampSched, 1, "world")
+	checkTimestamp(tcpip.TimestampSnd, 1, "world")
+	if sockErr := c.ep.SocketOptions().DequeueErr(); sockErr != nil {
+		t.Fatalf("got DequeueErr() = %#v, want = nil", sockErr)
+	}
+
+	// Without SOF_TIMESTAMPING_OPT_ID, the key is 0, and with
+	// SOF_TIMESTAMPING_OPT_TSONLY the payload isn't reported.
+	c.ep.SocketOptions().SetTimestamping(tcpip.TimestampingTxSoftware | tcpip.TimestampingOptTsonly)
+	write("hello")
+	checkTimestamp(tcpip.TimestampSnd, 0, "")
+
+	// Setting SOF_TIMESTAMPING_OPT_ID again restarts the keys.
+	c.ep.SocketOptions().SetTimestamping(tcpip.TimestampingTxSoftware | tcpip.TimestampingOptID)
+	write("hello")
+	checkTimestamp(tcpip.TimestampSnd, 0, "hello")
+}
+
+func TestTxTime(t *testing.T) {
+	c := newDualTestContext(t, defaultMTU)
+	defer c.cleanup()
+
+	c.createEndpoint(ipv4.ProtocolNumber)
+	if err := c.ep.Connect(tcpip.FullAddress{Addr: testAddr, Port: testPort}); err != nil {
+		t.Fatalf("Connect failed: %s", err)
+	}
+
+	const txTime = 1000
+	var reported uint64 = 0x1234567890
+	write := func() *stack.PacketBuffer {
+		t.Helper()
+
+		opts := tcpip.WriteOptions{TxTime: txTime, TxTimeReported: reported}
+		if _, _, err := c.ep.Write(tcpip.SlicePayload("hello"), opts); err != nil {
+			t.Fatalf("Write failed: %s", err)
+		}
+		p, ok := c.linkEP.Read()
+		if !ok {
+			t.Fatal("packet wasn't written out")
+		}
+		return p.Pkt
+	}
+
+	// Transmit times are ignored unless SO_TXTIME is enabled.
+	if got := write().TxTime; got.Time != 0 || got.Missed != nil {
+		t.Errorf("got TxTime = %#v without SO_TXTIME, want = zero", got)
+	}
+
+	c.ep.SocketOptions().SetTxTime(tcpip.TxTimeOption{Enabled: true, DeadlineMode: true})
+	got := write().TxTime
+	if got.Time != txTime || !got.Deadline {
+		t.Errorf("got (TxTime.Time, TxTime.Deadline) = (%d, %t), want = (%d, true)", got.Time, got.Deadline, txTime)
+	}
+	if got.Missed != nil {
+		t.Error("got TxTime.Missed != nil without SOF_TXTIME_REPORT_ERRORS")
+	}
+
+	c.ep.SocketOptions().SetRecvError(true)
+	c.ep.SocketOptions().SetTxTime(tcpip.TxTimeOption{Enabled: true, ReportErrors: true})
+	got = write().TxTime
+	if got.Time != txTime || got.Deadline {
+		t.Errorf("got (TxTime.Time, TxTime.Deadline) = (%d, %t), want = (%d, false)", got.Time, got.Deadline, txTime)
+	}
+	if got.Missed == nil {
+		t.Fatal("got TxTime.Missed = nil with SOF_TXTIME_REPORT_ERRORS")
+	}
+	got.Missed()
+	if got := c.ep.Readiness(waiter.EventErr); got != waiter.EventErr {
+		t.Errorf("got Readiness(waiter.EventErr) = %b, want = %b", got, waiter.EventErr)
+	}
+	sockErr := c.ep.SocketOptions().DequeueErr()
+	if sockErr == nil {
+		t.Fatal("got DequeueErr() = nil, want non-nil error")
+	}
+	want := tcpip.SockError{
+		Err:       tcpip.ErrCanceled,
+		ErrOrigin: tcpip.SockExtErrorOriginTxTime,
+		ErrCode:   tcpip.SockExtErrorCodeTxTimeMissed,
+		ErrInfo:   uint32(reported),
+		ErrData:   uint32(reported >> 32),
+		Dst:       tcpip.FullAddress{Addr: testAddr, Port: testPort},
+		NetProto:  ipv4.ProtocolNumber,
+	}
+	if sockErr.Err != want.Err || sockErr.ErrOrigin != want.ErrOrigin || sockErr.ErrCode != want.ErrCode || sockErr.ErrInfo != want.ErrInfo || sockErr.ErrData != want.ErrData || sockErr.Dst != want.Dst || sockErr.NetProto != want.NetProto {
+		t.Errorf("got DequeueErr() = %#v, want = %#v", *sockErr, want)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xdp implements NIC ingress hooks running classic BPF programs, with
+// the actions of XDP programs.
+//
+// The programs are run on the packets as received by the NIC, starting at
+// their link header, and load their data in network byte order. They return
+// an action in the low 16 bits of their return value; for Redirect, the high
+// 16 bits are the ID of the NIC to redirect the packet to. A program failing
+// to load data out of the packet aborts.
+package xdp
+
+import (
+	"encoding/binary"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/bpf"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// The actions returned by the programs, as in enum xdp_action in
+// linux/bpf.h.
+const (
+	// Aborted drops the packet, as the program failed.
+	Aborted = 0
+
+	// Drop drops the packet.
+	Drop = 1
+
+	// Pass lets the NIC process the packet.
+	Pass = 2
+
+	// TX sends the packet back through the NIC. It isn't supported, and
+	// packets are dropped instead.
+	TX = 3
+
+	// Redirect delivers the packet to the NIC given in the high 16 bits of
+	// the return value.
+	Redirect = 4
+)
+
+// RedirectTo returns the return value of a program redirecting the packets to
+// the NIC id.
+func RedirectTo(id tcpip.NICID) uint32 {
+	return uint32(id)<<16 | Redirect
+}
+
+// Hook is an ingress hook running a classic BPF program.
+type Hook struct {
+	program bpf.Program
+}
+
+var _ stack.IngressHook = (*Hook)(nil)
+
+// New returns a hook running the classic BPF program insns.
+func New(insns []linux.BPFInstruction) (*Hook, error) {
+	program, err := bpf.Compile(insns)
+	if err != nil {
+		return nil, err
+	}
+	return &Hook{program: program}, nil
+}
+
+// Process implements stack.IngressHook.Process.
+func (h *Hook) Process(_ tcpip.NICID, _ tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) (stack.IngressAction, tcpip.NICID) {
+	// The program reads the packet as a contiguous buffer.
+	data := make(buffer.View, 0, pkt.Size())
+	for _, v := range pkt.Views() {
+		data = append(data, v...)
+	}
+
+	ret, err := bpf.Exec(h.program, bpf.InputBytes{Data: data, Order: binary.BigEndian})
+	if err != nil {
+		return stack.IngressDrop, 0
+	}
+	switch ret & 0xffff {
+	case Pass:
+		return stack.IngressPass, 0
+	case Redirect:
+		return stack.IngressRedirect, tcpip.NICID(ret >> 16)
+	default:
+		return stack.IngressDrop, 0
+	}
+}
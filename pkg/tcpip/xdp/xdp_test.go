@@ -0,0 +1,120 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xdp
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/bpf"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+func TestHook(t *testing.T) {
+	// The program passes the IPv4 packets, redirects the IPv6 packets to
+	// NIC 2 and drops the other packets, after checking that the
+	// destination link address starts with 0x02, which aborts if it is
+	// out of the packet.
+	b := bpf.NewProgramBuilder()
+	b.AddStmt(bpf.Ld|bpf.Abs|bpf.B, 0)
+	b.AddJumpFalseLabel(bpf.Jmp|bpf.Jeq|bpf.K, 2, 0, "drop")
+	b.AddStmt(bpf.Ld|bpf.Abs|bpf.H, header.EthernetMinimumSize-2)
+	b.AddJumpFalseLabel(bpf.Jmp|bpf.Jeq|bpf.K, uint32(header.IPv4ProtocolNumber), 0, "ipv6")
+	b.AddStmt(bpf.Ret|bpf.K, Pass)
+	if err := b.AddLabel("ipv6"); err != nil {
+		t.Fatal(err)
+	}
+	b.AddJumpFalseLabel(bpf.Jmp|bpf.Jeq|bpf.K, uint32(header.IPv6ProtocolNumber), 0, "drop")
+	b.AddStmt(bpf.Ret|bpf.K, RedirectTo(2))
+	if err := b.AddLabel("drop"); err != nil {
+		t.Fatal(err)
+	}
+	b.AddStmt(bpf.Ret|bpf.K, Drop)
+	insns, err := b.Instructions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := New(insns)
+	if err != nil {
+		t.Fatalf("New(_): %s", err)
+	}
+
+	for _, test := range []struct {
+		name       string
+		dst        tcpip.LinkAddress
+		protocol   tcpip.NetworkProtocolNumber
+		linkHeader bool
+		wantAction stack.IngressAction
+		wantNIC    tcpip.NICID
+	}{
+		{
+			name:       "IPv4",
+			dst:        "\x02\x00\x00\x00\x00\x01",
+			protocol:   header.IPv4ProtocolNumber,
+			linkHeader: true,
+			wantAction: stack.IngressPass,
+		},
+		{
+			name:       "IPv6",
+			dst:        "\x02\x00\x00\x00\x00\x01",
+			protocol:   header.IPv6ProtocolNumber,
+			linkHeader: true,
+			wantAction: stack.IngressRedirect,
+			wantNIC:    2,
+		},
+		{
+			name:       "ARP",
+			dst:        "\x02\x00\x00\x00\x00\x01",
+			protocol:   header.ARPProtocolNumber,
+			linkHeader: true,
+			wantAction: stack.IngressDrop,
+		},
+		{
+			name:       "Other destination",
+			dst:        "\x04\x00\x00\x00\x00\x01",
+			protocol:   header.IPv4ProtocolNumber,
+			linkHeader: true,
+			wantAction: stack.IngressDrop,
+		},
+		{
+			name:       "Aborted",
+			protocol:   header.IPv4ProtocolNumber,
+			wantAction: stack.IngressDrop,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{})
+			if test.linkHeader {
+				v := make(buffer.View, header.EthernetMinimumSize+header.IPv4MinimumSize)
+				header.Ethernet(v).Encode(&header.EthernetFields{
+					DstAddr: test.dst,
+					Type:    test.protocol,
+				})
+				pkt = stack.NewPacketBuffer(stack.PacketBufferOptions{
+					Data: v.ToVectorisedView(),
+				})
+				if _, ok := pkt.LinkHeader().Consume(header.EthernetMinimumSize); !ok {
+					t.Fatal("failed to consume the link header")
+				}
+			}
+			action, nic := h.Process(1, test.protocol, pkt)
+			if action != test.wantAction || nic != test.wantNIC {
+				t.Errorf("got h.Process(1, %d, _) = (%d, %d), want = (%d, %d)", test.protocol, action, nic, test.wantAction, test.wantNIC)
+			}
+		})
+	}
+}
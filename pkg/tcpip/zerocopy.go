@@ -0,0 +1,187 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcpip
+
+import (
+	"sync/atomic"
+
+	"gvisor.dev/gvisor/pkg/sync"
+)
+
+// SockExtErrorCodeZeroCopyCopied is the ErrCode of zerocopy completion
+// notifications for sends whose payload had to be copied.
+const SockExtErrorCodeZeroCopyCopied = 1
+
+// ZeroCopyPayloader is a Payloader for sends with MSG_ZEROCOPY.
+type ZeroCopyPayloader interface {
+	Payloader
+
+	// BorrowFullPayload is like FullPayload, but the returned slice may
+	// reference the memory of the sender rather than a copy of it. The slice
+	// remains valid until the last reference to the send returned by
+	// ZeroCopySend is dropped.
+	BorrowFullPayload() ([]byte, *Error)
+
+	// BorrowPayload is like Payload, with the same guarantees as
+	// BorrowFullPayload.
+	BorrowPayload(size int) ([]byte, *Error)
+
+	// ZeroCopySend returns the send the payload belongs to.
+	ZeroCopySend() *ZeroCopySend
+}
+
+// ZeroCopySend tracks the payload of a send with MSG_ZEROCOPY, or of a
+// borrowed send (see NewBorrowedSend).
+//
+// The caller of Write holds the initial reference for the duration of the
+// call, and endpoints hold a reference for as long as they hold onto the
+// payload. Once the last reference is dropped, the payload is released and a
+// completion notification is queued on the socket's error queue, as described
+// in Linux's Documentation/networking/msg_zerocopy.rst.
+type ZeroCopySend struct {
+	so       *SocketOptions
+	netProto NetworkProtocolNumber
+	release  func()
+
+	refs int32
+
+	// mu protects the fields below.
+	mu sync.Mutex
+
+	// sent is set once an endpoint accepted the payload. Sends that are
+	// never accepted, e.g. because they failed, don't generate completion
+	// notifications.
+	sent bool
+
+	// id is the sequence number of the send on the socket, assigned when
+	// the send is accepted.
+	id uint32
+
+	// copied is set if the payload was copied rather than borrowed.
+	copied bool
+}
+
+// NewZeroCopySend returns a new send with MSG_ZEROCOPY on the socket owning
+// so. release is called once the payload of the send is no longer referenced.
+func (so *SocketOptions) NewZeroCopySend(netProto NetworkProtocolNumber, release func()) *ZeroCopySend {
+	return &ZeroCopySend{
+		so:       so,
+		netProto: netProto,
+		release:  release,
+		refs:     1,
+	}
+}
+
+// NewBorrowedSend returns a new send whose payload may be borrowed like that of
+// a send with MSG_ZEROCOPY, but which doesn't generate completion
+// notifications, e.g. a splice from a file. release is called once the payload
+// of the send is no longer referenced.
+func NewBorrowedSend(release func()) *ZeroCopySend {
+	return &ZeroCopySend{
+		release: release,
+		refs:    1,
+	}
+}
+
+// IncRef takes a reference on the payload of the send on behalf of an
+// endpoint, which also marks the send as accepted.
+func (z *ZeroCopySend) IncRef() {
+	atomic.AddInt32(&z.refs, 1)
+	z.MarkSent()
+}
+
+// MarkSent marks the send as accepted by an endpoint that doesn't hold onto
+// its payload past the Write call.
+func (z *ZeroCopySend) MarkSent() {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if !z.sent {
+		z.sent = true
+		if z.so != nil {
+			z.id = atomic.AddUint32(&z.so.zeroCopyNextID, 1) - 1
+		}
+	}
+}
+
+// MarkCopied records that (part of) the payload of the send was copied, which
+// is reported with the completion notification.
+func (z *ZeroCopySend) MarkCopied() {
+	z.mu.Lock()
+	z.copied = true
+	z.mu.Unlock()
+}
+
+// DecRef drops a reference on the payload of the send. Dropping the last
+// reference releases the payload and queues a completion notification if the
+// send was accepted.
+//
+// DecRef returns true if a notification was queued, in which case the caller
+// must notify the socket's waiters of waiter.EventErr.
+func (z *ZeroCopySend) DecRef() bool {
+	switch v := atomic.AddInt32(&z.refs, -1); {
+	case v > 0:
+		return false
+	case v < 0:
+		panic("ZeroCopySend: negative reference count")
+	}
+
+	z.release()
+
+	z.mu.Lock()
+	sent, id, copied := z.sent, z.id, z.copied
+	z.mu.Unlock()
+	if !sent || z.so == nil {
+		return false
+	}
+	return z.so.queueZeroCopyCompletion(id, copied, z.netProto)
+}
+
+// queueZeroCopyCompletion queues the completion notification of the send with
+// the given id. Like Linux, notifications for consecutive sends are coalesced
+// into a single entry covering the range [ErrInfo, ErrData].
+//
+// Unlike other errors, completion notifications are queued regardless of
+// IP*_RECVERR.
+func (so *SocketOptions) queueZeroCopyCompletion(id uint32, copied bool, net NetworkProtocolNumber) bool {
+	var code uint8
+	if copied {
+		code = SockExtErrorCodeZeroCopyCopied
+	}
+
+	so.errQueueMu.Lock()
+	defer so.errQueueMu.Unlock()
+	if tail := so.errQueue.Back(); tail != nil && tail.ErrOrigin == SockExtErrorOriginZeroCopy && tail.ErrCode == code && tail.ErrData+1 == id {
+		// Entries may be referenced by readers that peeked at the queue, so
+		// replace the tail rather than modifying it.
+		merged := *tail
+		merged.sockErrorEntry = sockErrorEntry{}
+		merged.ErrData = id
+		so.errQueue.InsertAfter(tail, &merged)
+		so.errQueue.Remove(tail)
+		return true
+	}
+	if so.errQueueLen >= maxErrQueueLen {
+		return false
+	}
+	so.errQueue.PushBack(&SockError{
+		ErrOrigin: SockExtErrorOriginZeroCopy,
+		ErrCode:   code,
+		ErrInfo:   id,
+		ErrData:   id,
+		NetProto:  net,
+	})
+	so.errQueueLen++
+	return true
+}
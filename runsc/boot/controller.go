@@ -94,6 +94,14 @@ const (
 	// and routes in a network stack.
 	NetworkCreateLinksAndRoutes = "Network.CreateLinksAndRoutes"
 
+	// NetworkStartPacketCapture is the URPC endpoint for starting to save
+	// the packets going through a network interface.
+	NetworkStartPacketCapture = "Network.StartPacketCapture"
+
+	// NetworkStopPacketCapture is the URPC endpoint for stopping a packet
+	// capture started with NetworkStartPacketCapture.
+	NetworkStopPacketCapture = "Network.StopPacketCapture"
+
 	// RootContainerStart is the URPC endpoint for starting a new sandbox
 	// with root container.
 	RootContainerStart = "containerManager.StartRoot"
@@ -60,12 +60,15 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/link/loopback"
 	"gvisor.dev/gvisor/pkg/tcpip/link/sniffer"
+	"gvisor.dev/gvisor/pkg/tcpip/link/tunnel"
 	"gvisor.dev/gvisor/pkg/tcpip/network/arp"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/icmp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/mptcp"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/raw"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/sctp"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
 	"gvisor.dev/gvisor/runsc/boot/filter"
@@ -78,6 +81,7 @@ import (
 	// Include supported socket providers.
 	"gvisor.dev/gvisor/pkg/sentry/socket/hostinet"
 	_ "gvisor.dev/gvisor/pkg/sentry/socket/netlink"
+	_ "gvisor.dev/gvisor/pkg/sentry/socket/netlink/nfnetlink"
 	_ "gvisor.dev/gvisor/pkg/sentry/socket/netlink/route"
 	_ "gvisor.dev/gvisor/pkg/sentry/socket/netlink/uevent"
 	"gvisor.dev/gvisor/pkg/sentry/socket/netstack"
@@ -1081,12 +1085,27 @@ func newRootNetworkNamespace(conf *config.Config, clock tcpip.Clock, uniqueID st
 }
 
 func newEmptySandboxNetworkStack(clock tcpip.Clock, uniqueID stack.UniqueID) (inet.Stack, error) {
-	netProtos := []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol, arp.NewProtocol}
+	netProtos := []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocolWithOptions(ipv6.Options{
+		// Linux generates flow labels by default (net.ipv6.auto_flowlabels).
+		AutoFlowLabels: true,
+		// Linux limits the number of options in Hop-by-Hop and Destination
+		// Options headers (net.ipv6.max_hbh_opts_number and
+		// net.ipv6.max_dst_opts_number).
+		ExtHdrOptions: ipv6.ExtHdrOptionsPolicy{
+			MaxHopByHopOptions:    8,
+			MaxDestinationOptions: 8,
+		},
+	}), arp.NewProtocol}
 	transProtos := []stack.TransportProtocolFactory{
 		tcp.NewProtocol,
+		mptcp.NewProtocol,
+		sctp.NewProtocol,
 		udp.NewProtocol,
+		udp.NewLiteProtocol,
 		icmp.NewProtocol4,
 		icmp.NewProtocol6,
+		// Deliver GRE packets to the tunnels created through netlink.
+		tunnel.NewGREProtocol,
 	}
 	s := netstack.Stack{stack.New(stack.Options{
 		NetworkProtocols:   netProtos,
@@ -1099,6 +1118,8 @@ func newEmptySandboxNetworkStack(clock tcpip.Clock, uniqueID stack.UniqueID) (in
 		RawFactory: raw.EndpointFactory{},
 		UniqueID:   uniqueID,
 		IPTables:   netfilter.DefaultLinuxTables(),
+		// Log the packets of LOG rules to the sentry log.
+		PacketLogger: netfilter.PacketLogger{},
 	})}
 
 	// Enable SACK Recovery.
@@ -17,11 +17,14 @@ package boot
 import (
 	"fmt"
 	"net"
+	"os"
 	"runtime"
 	"strings"
 	"syscall"
 
+	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/sync"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/link/fdbased"
 	"gvisor.dev/gvisor/pkg/tcpip/link/loopback"
@@ -64,6 +67,20 @@ var (
 // Network exposes methods that can be used to configure a network stack.
 type Network struct {
 	Stack *stack.Stack
+
+	mu sync.Mutex
+
+	// captures are the packet captures in progress, keyed by the name of
+	// their network interface.
+	captures map[string]*packetCapture
+}
+
+// packetCapture is a packet capture started with StartPacketCapture.
+type packetCapture struct {
+	*sniffer.Capture
+
+	// files are the files the packets are saved to.
+	files []*os.File
 }
 
 // Route represents a route in the network stack.
@@ -117,6 +134,32 @@ type CreateLinksAndRoutesArgs struct {
 	Defaultv6Gateway DefaultRoute
 }
 
+// StartPacketCaptureArgs are arguments to StartPacketCapture.
+type StartPacketCaptureArgs struct {
+	// FilePayload contains the files the packets are saved to. They are
+	// rotated once they reach MaxFileSize.
+	urpc.FilePayload
+
+	// Interface is the name of the network interface to capture on.
+	Interface string
+
+	// Filter is a classic BPF program selecting the packets to save, as
+	// compiled by tcpdump -ddd for the RAW link type. If nil, all the
+	// packets are saved.
+	Filter []linux.BPFInstruction
+
+	// SnapLen is the maximum number of bytes of a packet saved, or 0 for
+	// the default.
+	SnapLen uint32
+
+	// Direction is the direction of the packets to save, or 0 for both.
+	Direction stack.CaptureDirection
+
+	// MaxFileSize is the size beyond which a file is rotated, in bytes, or
+	// 0 for no limit.
+	MaxFileSize int64
+}
+
 // IPWithPrefix is an address with its subnet prefix length.
 type IPWithPrefix struct {
 	// Address is a network address.
@@ -275,6 +318,90 @@ func (n *Network) CreateLinksAndRoutes(args *CreateLinksAndRoutesArgs, _ *struct
 	return nil
 }
 
+// StartPacketCapture starts saving the packets going through a network
+// interface in the pcap format.
+func (n *Network) StartPacketCapture(args *StartPacketCaptureArgs, _ *struct{}) error {
+	if len(args.FilePayload.Files) == 0 {
+		return fmt.Errorf("no capture file")
+	}
+	var nicID tcpip.NICID
+	for id, info := range n.Stack.NICInfo() {
+		if info.Name == args.Interface {
+			nicID = id
+			break
+		}
+	}
+	if nicID == 0 {
+		return fmt.Errorf("unknown interface %q", args.Interface)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.captures[args.Interface]; ok {
+		return fmt.Errorf("interface %q is already captured on", args.Interface)
+	}
+	// The files of the payload are closed once the call returns.
+	var files []*os.File
+	closeFiles := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	for _, f := range args.FilePayload.Files {
+		newFD, err := syscall.Dup(int(f.Fd()))
+		if err != nil {
+			closeFiles()
+			return fmt.Errorf("failed to dup FD %v: %v", f.Fd(), err)
+		}
+		files = append(files, os.NewFile(uintptr(newFD), f.Name()))
+	}
+
+	opts := sniffer.CaptureOptions{
+		Filter:      args.Filter,
+		SnapLen:     args.SnapLen,
+		Direction:   args.Direction,
+		MaxFileSize: args.MaxFileSize,
+	}
+	for _, f := range files {
+		opts.Files = append(opts.Files, f)
+	}
+	c, err := sniffer.StartCapture(n.Stack, nicID, opts)
+	if err != nil {
+		closeFiles()
+		return err
+	}
+	if n.captures == nil {
+		n.captures = make(map[string]*packetCapture)
+	}
+	n.captures[args.Interface] = &packetCapture{
+		Capture: c,
+		files:   files,
+	}
+	log.Infof("Started packet capture on interface %q", args.Interface)
+	return nil
+}
+
+// StopPacketCapture stops a packet capture started with StartPacketCapture on
+// the network interface *iface, and closes its files.
+func (n *Network) StopPacketCapture(iface *string, _ *struct{}) error {
+	n.mu.Lock()
+	c, ok := n.captures[*iface]
+	delete(n.captures, *iface)
+	n.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("interface %q isn't captured on", *iface)
+	}
+
+	err := c.Stop()
+	for _, f := range c.files {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	log.Infof("Stopped packet capture on interface %q after %d packets", *iface, c.Packets())
+	return err
+}
+
 // createNICWithAddrs creates a NIC in the network stack and adds the given
 // addresses.
 func (n *Network) createNICWithAddrs(id tcpip.NICID, name string, ep stack.LinkEndpoint, addrs []IPWithPrefix) error {
@@ -16,6 +16,8 @@ package cmd
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
@@ -23,8 +25,11 @@ import (
 	"time"
 
 	"github.com/google/subcommands"
+	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/log"
 	"gvisor.dev/gvisor/pkg/sentry/control"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/runsc/boot"
 	"gvisor.dev/gvisor/runsc/config"
 	"gvisor.dev/gvisor/runsc/container"
 	"gvisor.dev/gvisor/runsc/flag"
@@ -45,6 +50,14 @@ type Debug struct {
 	logPackets   string
 	duration     time.Duration
 	ps           bool
+
+	pcap          string
+	pcapInterface string
+	pcapFilter    string
+	pcapSnapLen   uint
+	pcapDirection string
+	pcapFileSize  int64
+	pcapFiles     int
 }
 
 // Name implements subcommands.Command.
@@ -70,13 +83,20 @@ func (d *Debug) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&d.profileCPU, "profile-cpu", "", "writes CPU profile to the given file.")
 	f.StringVar(&d.profileBlock, "profile-block", "", "writes block profile to the given file.")
 	f.StringVar(&d.profileMutex, "profile-mutex", "", "writes mutex profile to the given file.")
-	f.DurationVar(&d.duration, "duration", time.Second, "amount of time to wait for CPU and trace profiles")
+	f.DurationVar(&d.duration, "duration", time.Second, "amount of time to wait for CPU and trace profiles, and packet captures")
 	f.StringVar(&d.trace, "trace", "", "writes an execution trace to the given file.")
 	f.IntVar(&d.signal, "signal", -1, "sends signal to the sandbox")
 	f.StringVar(&d.strace, "strace", "", `A comma separated list of syscalls to trace. "all" enables all traces, "off" disables all`)
 	f.StringVar(&d.logLevel, "log-level", "", "The log level to set: warning (0), info (1), or debug (2).")
 	f.StringVar(&d.logPackets, "log-packets", "", "A boolean value to enable or disable packet logging: true or false.")
 	f.BoolVar(&d.ps, "ps", false, "lists processes")
+	f.StringVar(&d.pcap, "pcap", "", "writes the packets going through the interface to the given file in the pcap format.")
+	f.StringVar(&d.pcapInterface, "pcap-interface", "eth0", "the network interface to capture packets on.")
+	f.StringVar(&d.pcapFilter, "pcap-filter", "", "a file with the classic BPF filter selecting the packets to capture, as printed by tcpdump -ddd for the RAW link type.")
+	f.UintVar(&d.pcapSnapLen, "pcap-snaplen", 0, "the maximum number of bytes of a packet to capture, or 0 for the default.")
+	f.StringVar(&d.pcapDirection, "pcap-direction", "inout", "the direction of the packets to capture: in, out, or inout.")
+	f.Int64Var(&d.pcapFileSize, "pcap-file-size", 0, "the size in bytes beyond which capture files are rotated, or 0 for no limit.")
+	f.IntVar(&d.pcapFiles, "pcap-files", 1, "the number of capture files to rotate through, suffixed with their index if more than 1.")
 }
 
 // Execute implements subcommands.Command.Execute.
@@ -214,6 +234,29 @@ func (d *Debug) Execute(_ context.Context, f *flag.FlagSet, args ...interface{})
 		log.Infof("Tracing started for %v, writing to %q", d.duration, d.trace)
 	}
 
+	if d.pcap != "" {
+		delay = true
+		args, err := d.packetCaptureArgs()
+		if err != nil {
+			return Errorf(err.Error())
+		}
+		err = c.Sandbox.StartPacketCapture(args)
+		for _, f := range args.FilePayload.Files {
+			// The sandbox has its own copies of the files.
+			f.Close()
+		}
+		if err != nil {
+			return Errorf(err.Error())
+		}
+		defer func() {
+			if err := c.Sandbox.StopPacketCapture(d.pcapInterface); err != nil {
+				Fatalf(err.Error())
+			}
+			log.Infof("Packet capture written to %q", d.pcap)
+		}()
+		log.Infof("Packet capture started on %q for %v, writing to %q", d.pcapInterface, d.duration, d.pcap)
+	}
+
 	if d.strace != "" || len(d.logLevel) != 0 || len(d.logPackets) != 0 {
 		args := control.LoggingArgs{}
 		switch strings.ToLower(d.strace) {
@@ -288,3 +331,73 @@ func (d *Debug) Execute(_ context.Context, f *flag.FlagSet, args ...interface{})
 
 	return subcommands.ExitSuccess
 }
+
+// packetCaptureArgs returns the arguments to start the packet capture
+// configured by the flags.
+func (d *Debug) packetCaptureArgs() (*boot.StartPacketCaptureArgs, error) {
+	args := &boot.StartPacketCaptureArgs{
+		Interface:   d.pcapInterface,
+		SnapLen:     uint32(d.pcapSnapLen),
+		MaxFileSize: d.pcapFileSize,
+	}
+	switch d.pcapDirection {
+	case "in":
+		args.Direction = stack.CaptureInbound
+	case "out":
+		args.Direction = stack.CaptureOutbound
+	case "inout":
+		args.Direction = stack.CaptureBoth
+	default:
+		return nil, fmt.Errorf("invalid packet capture direction %q", d.pcapDirection)
+	}
+	if d.pcapFilter != "" {
+		b, err := ioutil.ReadFile(d.pcapFilter)
+		if err != nil {
+			return nil, err
+		}
+		if args.Filter, err = parseBPF(string(b)); err != nil {
+			return nil, fmt.Errorf("parsing %q: %v", d.pcapFilter, err)
+		}
+	}
+	if d.pcapFiles < 1 {
+		return nil, fmt.Errorf("invalid number of packet capture files %d", d.pcapFiles)
+	}
+	for i := 0; i < d.pcapFiles; i++ {
+		name := d.pcap
+		if d.pcapFiles > 1 {
+			name = fmt.Sprintf("%s%d", d.pcap, i)
+		}
+		f, err := os.Create(name)
+		if err != nil {
+			for _, f := range args.FilePayload.Files {
+				f.Close()
+			}
+			return nil, err
+		}
+		args.FilePayload.Files = append(args.FilePayload.Files, f)
+	}
+	return args, nil
+}
+
+// parseBPF parses a classic BPF program in the format printed by tcpdump -ddd:
+// the number of instructions, followed by one instruction per line with its
+// code, jump offsets and constant in decimal.
+func parseBPF(s string) ([]linux.BPFInstruction, error) {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	n, err := strconv.Atoi(strings.TrimSpace(lines[0]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid number of instructions: %v", err)
+	}
+	if n != len(lines)-1 {
+		return nil, fmt.Errorf("got %d instructions, want %d", len(lines)-1, n)
+	}
+	insns := make([]linux.BPFInstruction, 0, n)
+	for _, line := range lines[1:] {
+		var insn linux.BPFInstruction
+		if _, err := fmt.Sscanf(line, "%d %d %d %d", &insn.OpCode, &insn.JumpIfTrue, &insn.JumpIfFalse, &insn.K); err != nil {
+			return nil, fmt.Errorf("invalid instruction %q: %v", line, err)
+		}
+		insns = append(insns, insn)
+	}
+	return insns, nil
+}
@@ -1045,6 +1045,37 @@ func (s *Sandbox) StopCPUProfile() error {
 	return nil
 }
 
+// StartPacketCapture starts saving the packets going through a network
+// interface of the sandbox to args.Files.
+func (s *Sandbox) StartPacketCapture(args *boot.StartPacketCaptureArgs) error {
+	log.Debugf("Packet capture start %q on %q", s.ID, args.Interface)
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.Call(boot.NetworkStartPacketCapture, args, nil); err != nil {
+		return fmt.Errorf("starting sandbox %q packet capture on %q: %v", s.ID, args.Interface, err)
+	}
+	return nil
+}
+
+// StopPacketCapture stops a previously started packet capture.
+func (s *Sandbox) StopPacketCapture(iface string) error {
+	log.Debugf("Packet capture stop %q on %q", s.ID, iface)
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.Call(boot.NetworkStopPacketCapture, &iface, nil); err != nil {
+		return fmt.Errorf("stopping sandbox %q packet capture on %q: %v", s.ID, iface, err)
+	}
+	return nil
+}
+
 // BlockProfile writes a block profile to the given file.
 func (s *Sandbox) BlockProfile(f *os.File) error {
 	log.Debugf("Block profile %q", s.ID)
@@ -51,6 +51,9 @@ func init() {
 	RegisterTestCase(FilterInputInvertDestination{})
 	RegisterTestCase(FilterInputSource{})
 	RegisterTestCase(FilterInputInvertSource{})
+	RegisterTestCase(FilterInputConnLimit{})
+	RegisterTestCase(FilterInputRecent{})
+	RegisterTestCase(FilterInputLog{})
 }
 
 // FilterInputDropUDP tests that we can drop UDP traffic.
@@ -743,3 +746,116 @@ func (FilterInputInvertSource) ContainerAction(ctx context.Context, ip net.IP, i
 func (FilterInputInvertSource) LocalAction(ctx context.Context, ip net.IP, ipv6 bool) error {
 	return sendUDPLoop(ctx, ip, acceptPort)
 }
+
+// FilterInputConnLimit tests that connections above the limit of connlimit
+// rules are not accepted.
+type FilterInputConnLimit struct{ baseCase }
+
+// Name implements TestCase.Name.
+func (FilterInputConnLimit) Name() string {
+	return "FilterInputConnLimit"
+}
+
+// ContainerAction implements TestCase.ContainerAction.
+func (FilterInputConnLimit) ContainerAction(ctx context.Context, ip net.IP, ipv6 bool) error {
+	if err := filterTable(ipv6, "-A", "INPUT", "-p", "tcp", "--syn", "--dport", fmt.Sprintf("%d", dropPort), "-m", "connlimit", "--connlimit-above", "0", "-j", "DROP"); err != nil {
+		return err
+	}
+
+	// Listen for TCP packets on drop port.
+	timedCtx, cancel := context.WithTimeout(ctx, NegativeTimeout)
+	defer cancel()
+	if err := listenTCP(timedCtx, dropPort); err == nil {
+		return fmt.Errorf("connection on port %d should not be accepted, but got accepted", dropPort)
+	} else if !errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("error reading: %v", err)
+	}
+
+	return nil
+}
+
+// LocalAction implements TestCase.LocalAction.
+func (FilterInputConnLimit) LocalAction(ctx context.Context, ip net.IP, ipv6 bool) error {
+	// Ensure we cannot connect to the container.
+	timedCtx, cancel := context.WithTimeout(ctx, NegativeTimeout)
+	defer cancel()
+	if err := connectTCP(timedCtx, ip, dropPort); err == nil {
+		return fmt.Errorf("expected not to connect, but was able to connect on port %d", dropPort)
+	}
+	return nil
+}
+
+// FilterInputRecent tests that the packets of hosts added to a recent list
+// are dropped.
+type FilterInputRecent struct{ containerCase }
+
+// Name implements TestCase.Name.
+func (FilterInputRecent) Name() string {
+	return "FilterInputRecent"
+}
+
+// ContainerAction implements TestCase.ContainerAction.
+func (FilterInputRecent) ContainerAction(ctx context.Context, ip net.IP, ipv6 bool) error {
+	// Ban the hosts which send packets to dropPort.
+	rules := [][]string{
+		{"-A", "INPUT", "-p", "udp", "-m", "recent", "--name", "banned", "--rcheck", "-j", "DROP"},
+		{"-A", "INPUT", "-p", "udp", "--dport", fmt.Sprintf("%d", dropPort), "-m", "recent", "--name", "banned", "--set", "-j", "DROP"},
+	}
+	if err := filterTableRules(ipv6, rules); err != nil {
+		return err
+	}
+
+	// Listen for UDP packets on acceptPort.
+	timedCtx, cancel := context.WithTimeout(ctx, NegativeTimeout)
+	defer cancel()
+	if err := listenUDP(timedCtx, acceptPort); err == nil {
+		return fmt.Errorf("packets on port %d should have been dropped, but got a packet", acceptPort)
+	} else if !errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("error reading: %v", err)
+	}
+
+	return nil
+}
+
+// LocalAction implements TestCase.LocalAction.
+func (FilterInputRecent) LocalAction(ctx context.Context, ip net.IP, ipv6 bool) error {
+	go sendUDPLoop(ctx, ip, dropPort)
+	return sendUDPLoop(ctx, ip, acceptPort)
+}
+
+// FilterInputLog tests that LOG and NFLOG rules don't stop the traversal of
+// chains.
+type FilterInputLog struct{ containerCase }
+
+// Name implements TestCase.Name.
+func (FilterInputLog) Name() string {
+	return "FilterInputLog"
+}
+
+// ContainerAction implements TestCase.ContainerAction.
+func (FilterInputLog) ContainerAction(ctx context.Context, ip net.IP, ipv6 bool) error {
+	rules := [][]string{
+		{"-A", "INPUT", "-p", "udp", "-j", "LOG", "--log-prefix", "input: "},
+		{"-A", "INPUT", "-p", "udp", "-j", "NFLOG", "--nflog-group", "1"},
+		{"-A", "INPUT", "-p", "udp", "--dport", fmt.Sprintf("%d", dropPort), "-j", "DROP"},
+	}
+	if err := filterTableRules(ipv6, rules); err != nil {
+		return err
+	}
+
+	// Listen for UDP packets on dropPort.
+	timedCtx, cancel := context.WithTimeout(ctx, NegativeTimeout)
+	defer cancel()
+	if err := listenUDP(timedCtx, dropPort); err == nil {
+		return fmt.Errorf("packets on port %d should have been dropped, but got a packet", dropPort)
+	} else if !errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("error reading: %v", err)
+	}
+
+	return nil
+}
+
+// LocalAction implements TestCase.LocalAction.
+func (FilterInputLog) LocalAction(ctx context.Context, ip net.IP, ipv6 bool) error {
+	return sendUDPLoop(ctx, ip, dropPort)
+}
@@ -392,6 +392,18 @@ func TestInputInvertSource(t *testing.T) {
 	singleTest(t, FilterInputInvertSource{})
 }
 
+func TestFilterInputConnLimit(t *testing.T) {
+	singleTest(t, FilterInputConnLimit{})
+}
+
+func TestFilterInputRecent(t *testing.T) {
+	singleTest(t, FilterInputRecent{})
+}
+
+func TestFilterInputLog(t *testing.T) {
+	singleTest(t, FilterInputLog{})
+}
+
 func TestFilterAddrs(t *testing.T) {
 	tcs := []struct {
 		ipv6  bool
@@ -143,6 +143,32 @@ TEST(IPTablesBasic, GetRevision) {
       SyscallFailsWithErrno(EPROTONOSUPPORT));
 }
 
+TEST(IPTablesBasic, GetMatchRevision) {
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_NET_RAW)));
+
+  int sock;
+  ASSERT_THAT(sock = socket(AF_INET, SOCK_RAW, IPPROTO_ICMP),
+              SyscallSucceeds());
+
+  struct xt_get_revision rev = {};
+  socklen_t rev_len = sizeof(rev);
+
+  snprintf(rev.name, sizeof(rev.name), "recent");
+  rev.revision = 0;
+
+  // The highest revision is returned.
+  EXPECT_THAT(
+      getsockopt(sock, SOL_IP, IPT_SO_GET_REVISION_MATCH, &rev, &rev_len),
+      SyscallSucceeds());
+  EXPECT_EQ(rev.revision, 1);
+
+  // Revisions > 1 don't exist.
+  rev.revision = 2;
+  EXPECT_THAT(
+      getsockopt(sock, SOL_IP, IPT_SO_GET_REVISION_MATCH, &rev, &rev_len),
+      SyscallFailsWithErrno(EPROTONOSUPPORT));
+}
+
 // Fixture for iptables tests.
 class IPTablesTest : public ::testing::Test {
  protected:
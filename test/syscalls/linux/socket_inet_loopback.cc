@@ -13,6 +13,7 @@
 // limitations under the License.
 
 #include <arpa/inet.h>
+#include <linux/filter.h>
 #include <netinet/in.h>
 #include <netinet/tcp.h>
 #include <poll.h>
@@ -1767,6 +1768,83 @@ TEST_P(SocketInetReusePortTest, UdpPortReuseMultiThreadShort_NoRandomSave) {
   }
 }
 
+TEST_P(SocketInetReusePortTest, UdpPortReuseCBPF_NoRandomSave) {
+  auto const& param = GetParam();
+
+  TestAddress const& listener = param.listener;
+  TestAddress const& connector = param.connector;
+  sockaddr_storage listen_addr = listener.addr;
+  sockaddr_storage conn_addr = connector.addr;
+  constexpr int kThreadCount = 3;
+
+  // The program selects the socket whose index is the first 32-bit word of
+  // the payload.
+  struct sock_filter code[] = {
+      {BPF_LD | BPF_W | BPF_ABS, 0, 0, 0},
+      {BPF_RET | BPF_A, 0, 0, 0},
+  };
+  struct sock_fprog prog = {
+      .len = sizeof(code) / sizeof(code[0]),
+      .filter = code,
+  };
+
+  // Create listening sockets.
+  FileDescriptor listener_fds[kThreadCount];
+  for (int i = 0; i < kThreadCount; i++) {
+    listener_fds[i] =
+        ASSERT_NO_ERRNO_AND_VALUE(Socket(listener.family(), SOCK_DGRAM, 0));
+    int fd = listener_fds[i].get();
+
+    ASSERT_THAT(setsockopt(fd, SOL_SOCKET, SO_REUSEPORT, &kSockOptOn,
+                           sizeof(kSockOptOn)),
+                SyscallSucceeds());
+    ASSERT_THAT(
+        bind(fd, reinterpret_cast<sockaddr*>(&listen_addr), listener.addr_len),
+        SyscallSucceeds());
+
+    // On the first bind we need to determine which port was bound.
+    if (i != 0) {
+      continue;
+    }
+
+    // Get the port bound by the listening socket.
+    socklen_t addrlen = listener.addr_len;
+    ASSERT_THAT(
+        getsockname(listener_fds[0].get(),
+                    reinterpret_cast<sockaddr*>(&listen_addr), &addrlen),
+        SyscallSucceeds());
+    uint16_t const port =
+        ASSERT_NO_ERRNO_AND_VALUE(AddrPort(listener.family(), listen_addr));
+    ASSERT_NO_ERRNO(SetAddrPort(listener.family(), &listen_addr, port));
+    ASSERT_NO_ERRNO(SetAddrPort(connector.family(), &conn_addr, port));
+  }
+
+  ASSERT_THAT(setsockopt(listener_fds[0].get(), SOL_SOCKET,
+                         SO_ATTACH_REUSEPORT_CBPF, &prog, sizeof(prog)),
+              SyscallSucceeds());
+
+  constexpr int kSendAttempts = 9;
+  FileDescriptor client_fd =
+      ASSERT_NO_ERRNO_AND_VALUE(Socket(connector.family(), SOCK_DGRAM, 0));
+  for (int i = 0; i < kSendAttempts; i++) {
+    uint32_t const idx = htonl(i % kThreadCount);
+    ASSERT_THAT(RetryEINTR(sendto)(client_fd.get(), &idx, sizeof(idx), 0,
+                                   reinterpret_cast<sockaddr*>(&conn_addr),
+                                   connector.addr_len),
+                SyscallSucceedsWithValue(sizeof(idx)));
+  }
+
+  // Each socket receives the datagrams carrying its index.
+  for (int i = 0; i < kSendAttempts; i++) {
+    uint32_t idx;
+    ASSERT_THAT(
+        RetryEINTR(recv)(listener_fds[i % kThreadCount].get(), &idx,
+                         sizeof(idx), 0),
+        SyscallSucceedsWithValue(sizeof(idx)));
+    EXPECT_EQ(ntohl(idx), i % kThreadCount);
+  }
+}
+
 INSTANTIATE_TEST_SUITE_P(
     All, SocketInetReusePortTest,
     ::testing::Values(
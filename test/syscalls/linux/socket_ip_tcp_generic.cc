@@ -65,6 +65,27 @@ TEST_P(TCPSocketPairTest, ZeroTcpInfoSucceeds) {
               SyscallSucceeds());
 }
 
+TEST_P(TCPSocketPairTest, TcpInfoReportsConnectionState) {
+  auto sockets = ASSERT_NO_ERRNO_AND_VALUE(NewSocketPair());
+
+  char buf[10] = {};
+  ASSERT_THAT(RetryEINTR(write)(sockets->first_fd(), buf, sizeof(buf)),
+              SyscallSucceedsWithValue(sizeof(buf)));
+  ASSERT_THAT(RetryEINTR(read)(sockets->second_fd(), buf, sizeof(buf)),
+              SyscallSucceedsWithValue(sizeof(buf)));
+
+  struct tcp_info opt = {};
+  socklen_t optLen = sizeof(opt);
+  ASSERT_THAT(getsockopt(sockets->first_fd(), SOL_TCP, TCP_INFO, &opt, &optLen),
+              SyscallSucceeds());
+  EXPECT_EQ(optLen, sizeof(opt));
+  EXPECT_EQ(opt.tcpi_state, TCP_ESTABLISHED);
+  EXPECT_GT(opt.tcpi_snd_mss, 0);
+  EXPECT_GT(opt.tcpi_rcv_mss, 0);
+  EXPECT_GT(opt.tcpi_snd_cwnd, 0);
+  EXPECT_EQ(opt.tcpi_total_retrans, 0);
+}
+
 // This test validates that an RST is sent instead of a FIN when data is
 // unread on calls to close(2).
 TEST_P(TCPSocketPairTest, RSTSentOnCloseWithUnreadData) {
@@ -15,6 +15,7 @@
 #include <arpa/inet.h>
 #include <fcntl.h>
 #include <ifaddrs.h>
+#include <linux/fib_rules.h>
 #include <linux/if.h>
 #include <linux/netlink.h>
 #include <linux/rtnetlink.h>
@@ -493,6 +494,129 @@ TEST(NetlinkRouteTest, GetAddrDump) {
       false));
 }
 
+// GetNeighDump tests a RTM_GETNEIGH + NLM_F_DUMP request.
+TEST(NetlinkRouteTest, GetNeighDump) {
+  FileDescriptor fd =
+      ASSERT_NO_ERRNO_AND_VALUE(NetlinkBoundSocket(NETLINK_ROUTE));
+  uint32_t port = ASSERT_NO_ERRNO_AND_VALUE(NetlinkPortID(fd.get()));
+
+  struct request {
+    struct nlmsghdr hdr;
+    struct ndmsg ndm;
+  };
+
+  struct request req = {};
+  req.hdr.nlmsg_len = sizeof(req);
+  req.hdr.nlmsg_type = RTM_GETNEIGH;
+  req.hdr.nlmsg_flags = NLM_F_REQUEST | NLM_F_DUMP;
+  req.hdr.nlmsg_seq = kSeq;
+  req.ndm.ndm_family = AF_UNSPEC;
+
+  ASSERT_NO_ERRNO(NetlinkRequestResponse(
+      fd, &req, sizeof(req),
+      [&](const struct nlmsghdr* hdr) {
+        EXPECT_THAT(hdr->nlmsg_type, AnyOf(Eq(RTM_NEWNEIGH), Eq(NLMSG_DONE)));
+
+        EXPECT_TRUE((hdr->nlmsg_flags & NLM_F_MULTI) == NLM_F_MULTI)
+            << std::hex << hdr->nlmsg_flags;
+
+        EXPECT_EQ(hdr->nlmsg_seq, kSeq);
+        EXPECT_EQ(hdr->nlmsg_pid, port);
+
+        if (hdr->nlmsg_type != RTM_NEWNEIGH) {
+          return;
+        }
+
+        // RTM_NEWNEIGH contains at least the header and ndmsg.
+        ASSERT_GE(hdr->nlmsg_len, NLMSG_SPACE(sizeof(struct ndmsg)));
+        const struct ndmsg* msg =
+            reinterpret_cast<const struct ndmsg*>(NLMSG_DATA(hdr));
+        EXPECT_THAT(msg->ndm_family, AnyOf(Eq(AF_INET), Eq(AF_INET6)));
+      },
+      false));
+}
+
+// GetNeighNotFound tests a RTM_GETNEIGH request for a missing neighbor.
+TEST(NetlinkRouteTest, GetNeighNotFound) {
+  Link loopback_link = ASSERT_NO_ERRNO_AND_VALUE(LoopbackLink());
+
+  FileDescriptor fd =
+      ASSERT_NO_ERRNO_AND_VALUE(NetlinkBoundSocket(NETLINK_ROUTE));
+
+  struct request {
+    struct nlmsghdr hdr;
+    struct ndmsg ndm;
+    struct rtattr rtattr;
+    struct in_addr dst;
+  };
+
+  struct request req = {};
+  req.hdr.nlmsg_len = sizeof(req);
+  req.hdr.nlmsg_type = RTM_GETNEIGH;
+  req.hdr.nlmsg_flags = NLM_F_REQUEST;
+  req.hdr.nlmsg_seq = kSeq;
+  req.ndm.ndm_family = AF_INET;
+  req.ndm.ndm_ifindex = loopback_link.index;
+  req.rtattr.rta_type = NDA_DST;
+  req.rtattr.rta_len = RTA_LENGTH(sizeof(req.dst));
+  ASSERT_EQ(inet_pton(AF_INET, "192.0.2.1", &req.dst), 1);
+
+  EXPECT_THAT(NetlinkRequestAckOrError(fd, kSeq, &req, sizeof(req)),
+              PosixErrorIs(ENOENT, ::testing::_));
+}
+
+// GetRuleDump tests a RTM_GETRULE + NLM_F_DUMP request.
+TEST(NetlinkRouteTest, GetRuleDump) {
+  // The routing policy of the host isn't exposed.
+  SKIP_IF(IsRunningWithHostinet());
+
+  FileDescriptor fd =
+      ASSERT_NO_ERRNO_AND_VALUE(NetlinkBoundSocket(NETLINK_ROUTE));
+  uint32_t port = ASSERT_NO_ERRNO_AND_VALUE(NetlinkPortID(fd.get()));
+
+  struct request {
+    struct nlmsghdr hdr;
+    struct fib_rule_hdr frh;
+  };
+
+  struct request req = {};
+  req.hdr.nlmsg_len = sizeof(req);
+  req.hdr.nlmsg_type = RTM_GETRULE;
+  req.hdr.nlmsg_flags = NLM_F_REQUEST | NLM_F_DUMP;
+  req.hdr.nlmsg_seq = kSeq;
+  req.frh.family = AF_INET;
+
+  bool main_found = false;
+  ASSERT_NO_ERRNO(NetlinkRequestResponse(
+      fd, &req, sizeof(req),
+      [&](const struct nlmsghdr* hdr) {
+        EXPECT_THAT(hdr->nlmsg_type, AnyOf(Eq(RTM_NEWRULE), Eq(NLMSG_DONE)));
+
+        EXPECT_TRUE((hdr->nlmsg_flags & NLM_F_MULTI) == NLM_F_MULTI)
+            << std::hex << hdr->nlmsg_flags;
+
+        EXPECT_EQ(hdr->nlmsg_seq, kSeq);
+        EXPECT_EQ(hdr->nlmsg_pid, port);
+
+        if (hdr->nlmsg_type != RTM_NEWRULE) {
+          return;
+        }
+
+        // RTM_NEWRULE contains at least the header and fib_rule_hdr.
+        ASSERT_GE(hdr->nlmsg_len, NLMSG_SPACE(sizeof(struct fib_rule_hdr)));
+        const struct fib_rule_hdr* msg =
+            reinterpret_cast<const struct fib_rule_hdr*>(NLMSG_DATA(hdr));
+        EXPECT_EQ(msg->family, AF_INET);
+        if (msg->action == FR_ACT_TO_TBL && msg->table == RT_TABLE_MAIN) {
+          main_found = true;
+        }
+      },
+      false));
+
+  // The default rules include a rule looking up the main table.
+  EXPECT_TRUE(main_found);
+}
+
 TEST(NetlinkRouteTest, LookupAll) {
   struct ifaddrs* if_addr_list = nullptr;
   auto cleanup = Cleanup([&if_addr_list]() { freeifaddrs(if_addr_list); });
@@ -549,6 +673,93 @@ TEST(NetlinkRouteTest, AddAndRemoveAddr) {
               PosixErrorIs(EEXIST, ::testing::_));
 }
 
+// ModifyRoute sends a RTM_NEWROUTE or RTM_DELROUTE request with flags for the
+// IPv4 route to dst/prefixlen through the interface index with priority.
+PosixError ModifyRoute(uint16_t type, uint16_t flags, int index,
+                       const struct in_addr& dst, int prefixlen,
+                       uint32_t priority) {
+  ASSIGN_OR_RETURN_ERRNO(FileDescriptor fd, NetlinkBoundSocket(NETLINK_ROUTE));
+
+  struct request {
+    struct nlmsghdr hdr;
+    struct rtmsg rtm;
+    char attrbuf[512];
+  };
+
+  struct request req = {};
+  req.hdr.nlmsg_len = NLMSG_LENGTH(sizeof(req.rtm));
+  req.hdr.nlmsg_type = type;
+  req.hdr.nlmsg_flags = NLM_F_REQUEST | NLM_F_ACK | flags;
+  req.hdr.nlmsg_seq = kSeq;
+  req.rtm.rtm_family = AF_INET;
+  req.rtm.rtm_dst_len = prefixlen;
+  req.rtm.rtm_table = RT_TABLE_MAIN;
+  if (type == RTM_NEWROUTE) {
+    req.rtm.rtm_protocol = RTPROT_BOOT;
+    req.rtm.rtm_scope = RT_SCOPE_LINK;
+    req.rtm.rtm_type = RTN_UNICAST;
+  }
+
+  auto put_attr = [&req](uint16_t attr_type, const void* data, int len) {
+    struct rtattr* rta = reinterpret_cast<struct rtattr*>(
+        reinterpret_cast<int8_t*>(&req) + NLMSG_ALIGN(req.hdr.nlmsg_len));
+    rta->rta_type = attr_type;
+    rta->rta_len = RTA_LENGTH(len);
+    memcpy(RTA_DATA(rta), data, len);
+    req.hdr.nlmsg_len = NLMSG_ALIGN(req.hdr.nlmsg_len) + RTA_LENGTH(len);
+  };
+  put_attr(RTA_DST, &dst, sizeof(dst));
+  put_attr(RTA_OIF, &index, sizeof(index));
+  put_attr(RTA_PRIORITY, &priority, sizeof(priority));
+
+  return NetlinkRequestAckOrError(fd, kSeq, &req, req.hdr.nlmsg_len);
+}
+
+TEST(NetlinkRouteTest, AddReplaceAndRemoveRoute) {
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_NET_ADMIN)));
+  // Routes can't be modified with hostinet.
+  SKIP_IF(IsRunningWithHostinet());
+  // Don't do cooperative save/restore because netstack state is not restored.
+  // TODO(gvisor.dev/issue/4595): enable cooperative save tests.
+  const DisableSave ds;
+
+  Link loopback_link = ASSERT_NO_ERRNO_AND_VALUE(LoopbackLink());
+
+  struct in_addr dst;
+  ASSERT_EQ(inet_pton(AF_INET, "10.123.0.0", &dst), 1);
+  constexpr int kPrefixLen = 16;
+  constexpr uint32_t kPriority = 42;
+
+  // Replacing a missing route without NLM_F_CREATE should fail.
+  EXPECT_THAT(ModifyRoute(RTM_NEWROUTE, NLM_F_REPLACE, loopback_link.index,
+                          dst, kPrefixLen, kPriority),
+              PosixErrorIs(ENOENT, ::testing::_));
+
+  ASSERT_NO_ERRNO(ModifyRoute(RTM_NEWROUTE, NLM_F_CREATE | NLM_F_EXCL,
+                              loopback_link.index, dst, kPrefixLen,
+                              kPriority));
+  Cleanup defer_route_removal = Cleanup([&] {
+    // First delete should succeed, as the route exists.
+    EXPECT_NO_ERRNO(ModifyRoute(RTM_DELROUTE, 0, loopback_link.index, dst,
+                                kPrefixLen, kPriority));
+
+    // Second delete should fail, as the route no longer exists.
+    EXPECT_THAT(ModifyRoute(RTM_DELROUTE, 0, loopback_link.index, dst,
+                            kPrefixLen, kPriority),
+                PosixErrorIs(ESRCH, ::testing::_));
+  });
+
+  // Creating the route exclusively again should fail.
+  EXPECT_THAT(ModifyRoute(RTM_NEWROUTE, NLM_F_CREATE | NLM_F_EXCL,
+                          loopback_link.index, dst, kPrefixLen, kPriority),
+              PosixErrorIs(EEXIST, ::testing::_));
+
+  // Replacing the route should succeed.
+  EXPECT_NO_ERRNO(ModifyRoute(RTM_NEWROUTE, NLM_F_CREATE | NLM_F_REPLACE,
+                              loopback_link.index, dst, kPrefixLen,
+                              kPriority));
+}
+
 // GetRouteDump tests a RTM_GETROUTE + NLM_F_DUMP request.
 TEST(NetlinkRouteTest, GetRouteDump) {
   FileDescriptor fd =